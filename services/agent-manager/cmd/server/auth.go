@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// errServiceTokensRequired is returned by serviceAuth in production when no
+// tokens are configured
+var errServiceTokensRequired = errors.New("SERVICE_AUTH_TOKENS is required in production")
+
+// serviceTokens parses the accepted tokens from SERVICE_AUTH_TOKENS. Several
+// comma-separated tokens are accepted at once so a token can be rotated
+// without downtime.
+func serviceTokens(value string) []string {
+	var tokens []string
+	for _, token := range strings.Split(value, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// serviceAuth requires a bearer token from the calling service, as the
+// Node.js server does. With no tokens configured every request is let
+// through, for local development only: in production the service refuses to
+// start instead.
+func serviceAuth(tokens []string, production bool, logger *zap.Logger) (gin.HandlerFunc, error) {
+	if len(tokens) == 0 {
+		if production {
+			return nil, errServiceTokensRequired
+		}
+		logger.Warn("SERVICE_AUTH_TOKENS is not set, service authentication is disabled")
+		return func(c *gin.Context) { c.Next() }, nil
+	}
+
+	accepted := make([][sha256.Size]byte, len(tokens))
+	for i, token := range tokens {
+		accepted[i] = sha256.Sum256([]byte(token))
+	}
+
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		matched := 0
+		if ok && token != "" {
+			// Compare digests so every token takes the same time to check
+			candidate := sha256.Sum256([]byte(token))
+			for _, expected := range accepted {
+				matched |= subtle.ConstantTimeCompare(candidate[:], expected[:])
+			}
+		}
+		if matched == 0 {
+			logger.Warn("Rejected service request",
+				zap.String("path", c.Request.URL.Path),
+				zap.String("service", c.GetHeader("X-Service-Name")))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid service token"})
+			return
+		}
+		c.Next()
+	}, nil
+}
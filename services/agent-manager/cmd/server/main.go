@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,19 +22,16 @@ func main() {
 	port := getEnv("SERVICE_PORT", "8084")
 	metricsPort := getEnv("METRICS_PORT", "8085")
 
+	// Callers must present a service token; only development runs without
+	production := getEnv("NODE_ENV", "development") == "production"
+	auth, err := serviceAuth(serviceTokens(os.Getenv("SERVICE_AUTH_TOKENS")), production, logger)
+	if err != nil {
+		logger.Fatal("Invalid service authentication configuration", zap.Error(err))
+	}
+
 	// Create main router
 	router := gin.Default()
-
-	// API routes
-	v1 := router.Group("/api/v1")
-	{
-		v1.GET("/agents", listAgents)
-		v1.POST("/agents", createAgent)
-		v1.GET("/agents/:id", getAgent)
-		v1.PUT("/agents/:id", updateAgent)
-		v1.DELETE("/agents/:id", deleteAgent)
-		v1.POST("/agents/:id/execute", executeAgentTask)
-	}
+	registerRoutes(router, auth)
 
 	// Create metrics router
 	metricsRouter := gin.New()
@@ -91,6 +86,19 @@ func main() {
 	logger.Info("Server exited")
 }
 
+// registerRoutes registers the API routes, all of them behind auth
+func registerRoutes(router *gin.Engine, auth gin.HandlerFunc) {
+	v1 := router.Group("/api/v1", auth)
+	{
+		v1.GET("/agents", listAgents)
+		v1.POST("/agents", createAgent)
+		v1.GET("/agents/:id", getAgent)
+		v1.PUT("/agents/:id", updateAgent)
+		v1.DELETE("/agents/:id", deleteAgent)
+		v1.POST("/agents/:id/execute", executeAgentTask)
+	}
+}
+
 func listAgents(c *gin.Context) {
 	// Placeholder for agent listing logic
 	c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestServiceAuth_RequiredInProduction(t *testing.T) {
+	_, err := serviceAuth(nil, true, zap.NewNop())
+	assert.ErrorIs(t, err, errServiceTokensRequired)
+
+	_, err = serviceAuth(nil, false, zap.NewNop())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old-token", "new-token"}, serviceTokens(" old-token, new-token,"))
+}
@@ -1,5 +1,6 @@
 import express, { Application } from 'express';
-import { createServer } from 'http';
+import { createServer, IncomingMessage } from 'http';
+import { Duplex } from 'stream';
 import { Server as SocketIOServer } from 'socket.io';
 import cors from 'cors';
 import helmet from 'helmet';
@@ -9,14 +10,18 @@ import { logger } from './utils/logger';
 import { errorHandler } from './middleware/errorHandler';
 import { requestLogger } from './middleware/requestLogger';
 import { validateRequest } from './middleware/validateRequest';
+import { loadServiceTokens, serviceAuth, serviceTokenVerifier } from './middleware/serviceAuth';
 import { agentRoutes } from './routes/agentRoutes';
 import { healthRoutes } from './routes/healthRoutes';
 import { taskRoutes } from './routes/taskRoutes';
+import { versionRoutes } from './routes/versionRoutes';
 import { AgentRegistry } from './services/agentRegistry';
 import { AgentRepository } from './repositories/agentRepository';
 import { DatabaseManager } from './config/database';
 import { AgentOrchestrator } from './services/agentOrchestrator';
 import { AgentCommunicator } from './services/agentCommunicator';
+import { ConnectionHub, defaultConnectionHubConfig } from './services/connectionHub';
+import { ConnectionTaskRouter } from './services/connectionTaskRouter';
 import { TaskQueue } from './queues/taskQueue';
 import { MongoDBService } from './services/mongodbService';
 import { MetricsService } from './services/metricsService';
@@ -45,6 +50,17 @@ for (const envVar of requiredEnvVars) {
 
 const PORT = parseInt(process.env.PORT || '3002', 10);
 const NODE_ENV = process.env.NODE_ENV || 'development';
+const SERVICE_AUTH_TOKENS = loadServiceTokens();
+
+if (NODE_ENV === 'production' && SERVICE_AUTH_TOKENS.length === 0) {
+  logger.error('Missing required environment variable: SERVICE_AUTH_TOKENS');
+  process.exit(1);
+}
+
+const envInt = (name: string, defaultValue: number): number => {
+  const value = parseInt(process.env[name] || '', 10);
+  return Number.isNaN(value) ? defaultValue : value;
+};
 
 class AgentManagerServer {
   private app: Application;
@@ -55,6 +71,7 @@ class AgentManagerServer {
   private dbManager!: DatabaseManager;
   private agentOrchestrator!: AgentOrchestrator;
   private agentCommunicator!: AgentCommunicator;
+  private connectionHub!: ConnectionHub;
   private taskQueue!: TaskQueue;
   private mongoService!: MongoDBService;
   private metricsService: MetricsService;
@@ -68,7 +85,9 @@ class AgentManagerServer {
         methods: ['GET', 'POST'],
         credentials: true
       },
-      transports: ['websocket', 'polling']
+      transports: ['websocket', 'polling'],
+      // Upgrades to other paths are the connection hub's
+      destroyUpgrade: false
     });
 
     // Services will be initialized in start() method
@@ -86,7 +105,7 @@ class AgentManagerServer {
       origin: process.env.ALLOWED_ORIGINS?.split(',') || '*',
       credentials: true,
       methods: ['GET', 'POST', 'PUT', 'DELETE', 'PATCH', 'OPTIONS'],
-      allowedHeaders: ['Content-Type', 'Authorization', 'X-Request-ID']
+      allowedHeaders: ['Content-Type', 'Authorization', 'X-Request-ID', 'X-Service-Name']
     }));
 
     // Compression
@@ -124,14 +143,17 @@ class AgentManagerServer {
       this.agentOrchestrator
     ));
 
+    // API version and features, negotiated by clients
+    apiRouter.use('/version', versionRoutes);
+
     // Metrics endpoint
     apiRouter.get('/metrics', async (req, res) => {
       const metrics = await this.metricsService.getMetrics();
       res.json(metrics);
     });
 
-    // Mount API routes
-    this.app.use('/api/v1', apiRouter);
+    // Mount API routes, callers must present a service token
+    this.app.use('/api/v1', serviceAuth(SERVICE_AUTH_TOKENS), apiRouter);
 
     // 404 handler
     this.app.use((req, res) => {
@@ -202,6 +224,23 @@ class AgentManagerServer {
     });
   }
 
+  private setupConnectionHub(): void {
+    // WebSocket connections of orchestrators to agents, authenticated like
+    // the API they sit under
+    const router = new ConnectionTaskRouter(this.agentOrchestrator, this.agentCommunicator);
+    this.connectionHub = new ConnectionHub({
+      maxConnections: envInt('WS_MAX_CONNECTIONS', defaultConnectionHubConfig.maxConnections),
+      maxConnectionsPerProject: envInt('WS_MAX_CONNECTIONS_PER_PROJECT', defaultConnectionHubConfig.maxConnectionsPerProject),
+      pingInterval: envInt('WS_PING_INTERVAL', 30) * 1000,
+      pongTimeout: envInt('WS_PONG_TIMEOUT', 10) * 1000
+    }, serviceTokenVerifier(SERVICE_AUTH_TOKENS), router);
+    router.attach(this.connectionHub);
+
+    this.httpServer.on('upgrade', (req: IncomingMessage, socket: Duplex, head: Buffer) => {
+      this.connectionHub.handleUpgrade(req, socket, head);
+    });
+  }
+
   public async start(): Promise<void> {
     try {
       // Initialize database connections
@@ -253,6 +292,7 @@ class AgentManagerServer {
       this.setupMiddleware();
       this.setupRoutes();
       this.setupSocketIO();
+      this.setupConnectionHub();
 
       // Start HTTP server
       this.httpServer.listen(PORT, () => {
@@ -267,6 +307,10 @@ class AgentManagerServer {
         
         // Stop accepting new connections
         this.httpServer.close();
+
+        // Close the connections of orchestrators, which the HTTP server
+        // no longer tracks once upgraded
+        await this.connectionHub.shutdown();
         
         // Close Socket.IO connections
         this.io.close();
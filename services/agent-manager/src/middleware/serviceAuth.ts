@@ -0,0 +1,66 @@
+import { createHash, timingSafeEqual } from 'crypto';
+import { Request, Response, NextFunction, RequestHandler } from 'express';
+import { logger } from '../utils/logger';
+import { UnauthorizedError } from './errorHandler';
+
+/**
+ * Parse the accepted service tokens from SERVICE_AUTH_TOKENS. Several
+ * comma-separated tokens are accepted at once so a token can be rotated
+ * without downtime: add the new one, switch the callers, drop the old one.
+ */
+export const loadServiceTokens = (env: NodeJS.ProcessEnv = process.env): string[] => {
+  return (env.SERVICE_AUTH_TOKENS || '')
+    .split(',')
+    .map(token => token.trim())
+    .filter(token => token.length > 0);
+};
+
+const digest = (value: string): Buffer => createHash('sha256').update(value).digest();
+
+// Compare digests so the comparison takes the same time for any token length
+const matches = (token: string, accepted: Buffer[]): boolean => {
+  const candidate = digest(token);
+  return accepted.reduce((found, expected) => timingSafeEqual(candidate, expected) || found, false);
+};
+
+/**
+ * Check the Authorization header of a calling service against the accepted
+ * tokens. With no tokens configured every caller is accepted.
+ */
+export const serviceTokenVerifier = (tokens: string[]): ((authorization?: string) => boolean) => {
+  if (tokens.length === 0) {
+    return () => true;
+  }
+
+  const accepted = tokens.map(digest);
+
+  return (authorization?: string): boolean => {
+    const [scheme, token] = (authorization || '').split(' ');
+    return scheme === 'Bearer' && !!token && matches(token, accepted);
+  };
+};
+
+/**
+ * Require a bearer token from the calling service. With no tokens
+ * configured every request is let through, for local development.
+ */
+export const serviceAuth = (tokens: string[]): RequestHandler => {
+  if (tokens.length === 0) {
+    logger.warn('SERVICE_AUTH_TOKENS is not set, service authentication is disabled');
+    return (req: Request, res: Response, next: NextFunction): void => next();
+  }
+
+  const verify = serviceTokenVerifier(tokens);
+
+  return (req: Request, res: Response, next: NextFunction): void => {
+    if (!verify(req.get('authorization'))) {
+      logger.warn('Rejected service request', {
+        requestId: req.id,
+        path: req.path,
+        service: req.get('x-service-name')
+      });
+      return next(new UnauthorizedError('Missing or invalid service token'));
+    }
+    next();
+  };
+};
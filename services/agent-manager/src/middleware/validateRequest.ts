@@ -57,6 +57,15 @@ const formatJoiErrors = (error: Joi.ValidationError): any => {
   }, {} as Record<string, string>);
 };
 
+// Agent capability, as registered and as refreshed on heartbeats
+const capability = Joi.object({
+  name: Joi.string().required(),
+  description: Joi.string().optional(),
+  version: Joi.string().required(),
+  parameters: Joi.object(),
+  proficiency: Joi.number().min(0).max(1).optional()
+});
+
 // Common validation schemas
 export const schemas = {
   // ID parameter
@@ -85,23 +94,21 @@ export const schemas = {
       'security', 'documentation', 'review', 'optimization',
       'meta-prompt', 'dynamic'
     ).required(),
-    capabilities: Joi.array().items(
-      Joi.object({
-        name: Joi.string().required(),
-        description: Joi.string().optional(),
-        version: Joi.string().required(),
-        parameters: Joi.object()
-      })
-    ).min(1).required(),
+    capabilities: Joi.array().items(capability).min(1).required(),
     endpoint: Joi.string().uri().optional(),
     metadata: Joi.object({
       version: Joi.string().required(),
       platform: Joi.string().required(),
-      region: Joi.string(),
+      region: Joi.string().trim().lowercase(),
       tags: Joi.array().items(Joi.string())
     }).required()
   }),
 
+  // Agent heartbeat; capabilities replace the registered ones when given
+  heartbeat: Joi.object({
+    capabilities: Joi.array().items(capability).min(1).optional()
+  }),
+
   // Task submission
   taskSubmission: Joi.object({
     type: Joi.string().valid(
@@ -43,6 +43,7 @@ export interface AgentCapability {
   description?: string;
   version: string;
   parameters?: Record<string, any>;
+  proficiency?: number; // Self-reported success rate, 0-1, the orchestrator checks for drift
 }
 
 export interface AgentMetrics {
@@ -64,6 +65,7 @@ export interface Agent extends Document {
   capabilities: AgentCapability[];
   endpoint?: string;
   socketId?: string;
+  region?: string; // Data residency region, as registered in metadata; projects pinned to a region only use its agents
   metadata: {
     version: string;
     platform: string;
@@ -1,5 +1,5 @@
 import { Collection, Db } from 'mongodb';
-import { Agent, AgentStatus } from '../models/agent';
+import { Agent, AgentCapability, AgentStatus } from '../models/agent';
 import { logger } from '../utils/logger';
 import Redis from 'ioredis';
 
@@ -105,17 +105,21 @@ export class AgentRepository {
   }
 
   /**
-   * Update agent heartbeat
+   * Update agent heartbeat, replacing its capabilities when given
    */
-  async updateHeartbeat(agentId: string): Promise<boolean> {
+  async updateHeartbeat(agentId: string, capabilities?: AgentCapability[]): Promise<boolean> {
+    const update: Record<string, any> = {
+      lastHeartbeat: new Date(),
+      'metrics.lastActive': new Date()
+    };
+    if (capabilities) {
+      update.capabilities = capabilities;
+      update.updatedAt = new Date();
+    }
+
     const result = await this.collection.updateOne(
       { id: agentId },
-      { 
-        $set: { 
-          lastHeartbeat: new Date(),
-          'metrics.lastActive': new Date()
-        } 
-      }
+      { $set: update }
     );
 
     if (result.modifiedCount > 0) {
@@ -0,0 +1,21 @@
+import { Router, Request, Response } from 'express';
+
+// Version of the agent manager API. Clients refuse an incompatible major
+// version, so bump the major only for breaking changes.
+export const API_VERSION = '1.1.0';
+
+// Optional API features this service provides. Clients only use a feature
+// that is listed here and fall back to the core API otherwise.
+export const API_FEATURES = [
+  'capability-objects'
+];
+
+export const versionRoutes = Router();
+
+// API version and supported features
+versionRoutes.get('/', (req: Request, res: Response) => {
+  res.json({
+    version: API_VERSION,
+    features: API_FEATURES
+  });
+});
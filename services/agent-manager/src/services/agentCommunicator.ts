@@ -3,6 +3,7 @@ import { v4 as uuidv4 } from 'uuid';
 import { EventEmitter } from 'events';
 import { 
   Agent, 
+  AgentCapability,
   AgentMessage, 
   AgentEvent,
   Task,
@@ -10,6 +11,7 @@ import {
   AgentStatus
 } from '../models/agent';
 import { AgentRegistry } from './agentRegistry';
+import { schemas } from '../middleware/validateRequest';
 import { logger } from '../utils/logger';
 
 interface PendingMessage {
@@ -173,9 +175,20 @@ export class AgentCommunicator extends EventEmitter {
       }
     });
 
-    // Heartbeat
-    socket.on('heartbeat', async () => {
-      await this.agentRegistry.updateAgentHeartbeat(agentId);
+    // Heartbeat, optionally refreshing the agent's capabilities
+    socket.on('heartbeat', async (payload?: { capabilities?: AgentCapability[] }) => {
+      let capabilities: AgentCapability[] | undefined;
+      if (payload) {
+        const { error, value } = schemas.heartbeat.validate(payload, { stripUnknown: true });
+        if (error) {
+          logger.warn(`Agent ${agentId} sent invalid heartbeat capabilities: ${error.message}`);
+          socket.emit('error', { message: 'Invalid heartbeat capabilities' });
+        } else {
+          capabilities = value.capabilities;
+        }
+      }
+
+      await this.agentRegistry.updateAgentHeartbeat(agentId, capabilities);
       socket.emit('heartbeat:ack', { timestamp: new Date() });
     });
 
@@ -1,5 +1,5 @@
 import { EventEmitter } from 'events';
-import { Agent, AgentStatus, AgentType, AgentFilter, AgentRegistrationRequest } from '../models/agent';
+import { Agent, AgentCapability, AgentStatus, AgentType, AgentFilter, AgentRegistrationRequest } from '../models/agent';
 import { AgentRepository } from '../repositories/agentRepository';
 import { logger } from '../utils/logger';
 import { v4 as uuidv4 } from 'uuid';
@@ -144,6 +144,7 @@ export class AgentRegistry extends EventEmitter {
       capabilities: request.capabilities,
       endpoint: request.endpoint,
       socketId,
+      region: request.metadata.region,
       metadata: request.metadata,
       metrics: {
         tasksCompleted: 0,
@@ -201,10 +202,12 @@ export class AgentRegistry extends EventEmitter {
   }
 
   /**
-   * Update agent heartbeat
+   * Update agent heartbeat. Capabilities reported with the heartbeat replace
+   * the registered ones, so agents upgraded or degraded since registration
+   * are matched on what they can do now.
    */
-  async updateAgentHeartbeat(agentId: string): Promise<void> {
-    const success = await this.repository.updateHeartbeat(agentId);
+  async updateAgentHeartbeat(agentId: string, capabilities?: AgentCapability[]): Promise<void> {
+    const success = await this.repository.updateHeartbeat(agentId, capabilities);
     
     if (success) {
       // Update local cache timestamp
@@ -212,19 +215,41 @@ export class AgentRegistry extends EventEmitter {
       if (agent) {
         agent.lastHeartbeat = new Date();
         agent.metrics.lastActive = new Date();
+        if (capabilities) {
+          this.updateCapabilities(agent, capabilities);
+        }
       }
     } else {
       // Agent not found in DB, try to reload
       const agent = await this.repository.findById(agentId);
       if (agent) {
         this.localCache.set(agentId, agent);
-        await this.repository.updateHeartbeat(agentId);
+        await this.repository.updateHeartbeat(agentId, capabilities);
+        if (capabilities) {
+          this.updateCapabilities(agent, capabilities);
+        }
       } else {
         throw new Error(`Agent ${agentId} not found`);
       }
     }
   }
 
+  /**
+   * Replace the cached capabilities of an agent, notifying subscribers when
+   * they changed
+   */
+  private updateCapabilities(agent: Agent, capabilities: AgentCapability[]): void {
+    if (JSON.stringify(agent.capabilities) === JSON.stringify(capabilities)) {
+      return;
+    }
+
+    agent.capabilities = capabilities;
+    agent.updatedAt = new Date();
+    logger.info(`Agent ${agent.id} capabilities updated`);
+    this.emit('agent:capabilities:updated', agent);
+    this.notifySubscribers();
+  }
+
   /**
    * Get agent by ID (synchronous - returns from cache only)
    */
@@ -0,0 +1,405 @@
+import { IncomingMessage, STATUS_CODES } from 'http';
+import { Duplex } from 'stream';
+import WebSocket, { RawData, WebSocketServer } from 'ws';
+import { logger } from '../utils/logger';
+
+// Header naming the project a connection is scoped to
+export const PROJECT_HEADER = 'x-project-id';
+
+// Message types the hub answers itself
+export const MessageTypes = {
+  // Answered with the same payload, for orchestrators to check a
+  // connection is alive
+  ECHO: 'echo',
+  // Answered with the status of the connection
+  STATUS: 'status',
+  // Answers messages that could not be handled
+  ERROR: 'error'
+} as const;
+
+// Close code sent to orchestrators on shutdown
+const CLOSE_GOING_AWAY = 1001;
+
+const CONNECT_PATH = /^\/api\/v1\/agents\/([^/]+)\/connect$/;
+
+/**
+ * Envelope of the messages exchanged over a connection, as the
+ * orchestrator's AgentClient sends and decodes them
+ */
+export interface ConnectionMessage {
+  id?: string;
+  type: string;
+  payload?: any;
+  timestamp?: string;
+}
+
+/**
+ * Thrown by routers for message types they do not handle
+ */
+export class UnsupportedMessageError extends Error {
+  constructor(type: string) {
+    super(`unsupported message type "${type}"`);
+    this.name = 'UnsupportedMessageError';
+  }
+}
+
+/**
+ * Passes the messages orchestrators send, other than echo and status, to
+ * the agent runtime. Replies go out with Connection.send or
+ * ConnectionHub.send.
+ */
+export interface MessageRouter {
+  route(connection: Connection, message: ConnectionMessage): Promise<void>;
+}
+
+export interface ConnectionHubConfig {
+  // Bounds the open connections; 0 means no limit
+  maxConnections: number;
+  // Bounds the open connections of each project; 0 means no limit
+  maxConnectionsPerProject: number;
+  // How often connections are pinged, in milliseconds
+  pingInterval: number;
+  // How long past a ping a connection may stay silent before it is
+  // dropped, in milliseconds
+  pongTimeout: number;
+  // Bounds the messages read from a connection
+  maxMessageBytes: number;
+}
+
+// Matches the ping interval and pong timeout orchestrators use by default
+export const defaultConnectionHubConfig: ConnectionHubConfig = {
+  maxConnections: 1000,
+  maxConnectionsPerProject: 100,
+  pingInterval: 30000,
+  pongTimeout: 10000,
+  maxMessageBytes: 1 << 20
+};
+
+/**
+ * A connection of an orchestrator to an agent
+ */
+export class Connection {
+  public readonly id: string;
+  public readonly agentId: string;
+  public readonly projectId: string;
+  public readonly connectedAt: Date;
+  private ws: WebSocket;
+  public lastSeen: number;
+
+  constructor(id: string, agentId: string, projectId: string, ws: WebSocket) {
+    this.id = id;
+    this.agentId = agentId;
+    this.projectId = projectId;
+    this.connectedAt = new Date();
+    this.ws = ws;
+    this.lastSeen = Date.now();
+  }
+
+  /**
+   * Send a message to the orchestrator. Returns false when the connection
+   * is no longer open.
+   */
+  public send(message: ConnectionMessage): boolean {
+    if (this.ws.readyState !== WebSocket.OPEN) {
+      return false;
+    }
+    this.ws.send(JSON.stringify({
+      ...message,
+      payload: message.payload ?? null,
+      timestamp: message.timestamp || new Date().toISOString()
+    }));
+    return true;
+  }
+
+  public close(code: number, reason: string): void {
+    this.ws.close(code, reason);
+  }
+
+  public terminate(): void {
+    this.ws.terminate();
+  }
+
+  public ping(): void {
+    this.ws.ping();
+  }
+}
+
+/**
+ * Serves the WebSocket connections orchestrators open to agents at
+ * /api/v1/agents/:id/connect, within the project of the X-Project-ID
+ * header, and routes their messages to the agent runtime.
+ */
+export class ConnectionHub {
+  private config: ConnectionHubConfig;
+  private router?: MessageRouter;
+  private authorize: (authorization?: string) => boolean;
+  private wss: WebSocketServer;
+  private connections: Map<string, Connection> = new Map();
+  private projects: Map<string, number> = new Map();
+  private reserved = 0;
+  private nextId = 0;
+  private closing = false;
+  private pingTimer: NodeJS.Timeout | null = null;
+
+  constructor(
+    config: Partial<ConnectionHubConfig>,
+    authorize: (authorization?: string) => boolean,
+    router?: MessageRouter
+  ) {
+    this.config = { ...defaultConnectionHubConfig, ...config };
+    this.authorize = authorize;
+    this.router = router;
+    this.wss = new WebSocketServer({ noServer: true, maxPayload: this.config.maxMessageBytes });
+  }
+
+  /**
+   * Handle an HTTP upgrade. Returns false for paths other than the connect
+   * endpoint, which are left to other handlers.
+   */
+  public handleUpgrade(req: IncomingMessage, socket: Duplex, head: Buffer): boolean {
+    const match = CONNECT_PATH.exec((req.url || '').split('?')[0]);
+    if (!match) {
+      return false;
+    }
+
+    if (!this.authorize(req.headers.authorization)) {
+      logger.warn('Rejected agent connection', { path: req.url });
+      reject(socket, 401, 'Missing or invalid service token');
+      return true;
+    }
+
+    const agentId = decodeURIComponent(match[1]);
+    const projectId = headerValue(req.headers[PROJECT_HEADER]);
+    if (!projectId) {
+      reject(socket, 400, 'X-Project-ID header is required');
+      return true;
+    }
+
+    // Checked before a slot is taken, as ws drops bad handshakes silently
+    if (!req.headers['sec-websocket-key'] || req.headers['sec-websocket-version'] !== '13') {
+      reject(socket, 400, 'invalid WebSocket handshake');
+      return true;
+    }
+
+    const refusal = this.reserve(projectId);
+    if (refusal) {
+      reject(socket, refusal.status, refusal.message);
+      return true;
+    }
+
+    this.wss.handleUpgrade(req, socket, head, (ws) => this.serve(ws, agentId, projectId));
+    return true;
+  }
+
+  /**
+   * Deliver a message from the agent runtime to the orchestrators connected
+   * to an agent, within a project unless projectId is empty. Returns the
+   * number of connections it was sent on.
+   */
+  public send(agentId: string, projectId: string, message: ConnectionMessage): number {
+    let sent = 0;
+    for (const connection of this.connections.values()) {
+      if (connection.agentId === agentId && (!projectId || connection.projectId === projectId)) {
+        if (connection.send(message)) {
+          sent++;
+        }
+      }
+    }
+    return sent;
+  }
+
+  public count(): number {
+    return this.connections.size;
+  }
+
+  /**
+   * Refuse new connections, close the open ones with a going-away frame and
+   * wait for them to end, forcing those still open after timeoutMs
+   */
+  public async shutdown(timeoutMs: number = 5000): Promise<void> {
+    this.closing = true;
+    this.stopPinging();
+    for (const connection of this.connections.values()) {
+      connection.close(CLOSE_GOING_AWAY, 'agent manager shutting down');
+    }
+
+    const deadline = Date.now() + timeoutMs;
+    while (this.connections.size > 0 && Date.now() < deadline) {
+      await new Promise(resolve => setTimeout(resolve, 50));
+    }
+    for (const connection of this.connections.values()) {
+      connection.terminate();
+    }
+    this.wss.close();
+  }
+
+  // Take a connection slot of the project, or say why there is none
+  private reserve(projectId: string): { status: number; message: string } | null {
+    const projectConnections = this.projects.get(projectId) || 0;
+    if (this.closing) {
+      return { status: 503, message: 'agent manager is shutting down' };
+    }
+    if (this.config.maxConnections > 0 && this.reserved >= this.config.maxConnections) {
+      return { status: 503, message: 'connection limit reached' };
+    }
+    if (this.config.maxConnectionsPerProject > 0 && projectConnections >= this.config.maxConnectionsPerProject) {
+      return {
+        status: 429,
+        message: `project connection limit reached: project ${projectId} has ${projectConnections} connections`
+      };
+    }
+    this.reserved++;
+    this.projects.set(projectId, projectConnections + 1);
+    return null;
+  }
+
+  private release(projectId: string): void {
+    this.reserved--;
+    const remaining = (this.projects.get(projectId) || 1) - 1;
+    if (remaining > 0) {
+      this.projects.set(projectId, remaining);
+    } else {
+      this.projects.delete(projectId);
+    }
+  }
+
+  private serve(ws: WebSocket, agentId: string, projectId: string): void {
+    this.nextId++;
+    const connection = new Connection(`${agentId}-${this.nextId}`, agentId, projectId, ws);
+    this.connections.set(connection.id, connection);
+    this.startPinging();
+    logger.info('Orchestrator connected', { connectionId: connection.id, agentId, projectId });
+
+    // Connections opened as shutdown started are closed with the others
+    if (this.closing) {
+      connection.close(CLOSE_GOING_AWAY, 'agent manager shutting down');
+    }
+
+    const seen = () => {
+      connection.lastSeen = Date.now();
+    };
+    // ws answers the pings of orchestrators itself
+    ws.on('ping', seen);
+    ws.on('pong', seen);
+    ws.on('message', (data: RawData) => {
+      seen();
+      this.handle(connection, data);
+    });
+    ws.on('error', (error: Error) => {
+      logger.warn('WebSocket connection failed', { connectionId: connection.id, error: error.message });
+    });
+    ws.on('close', (code: number) => {
+      this.connections.delete(connection.id);
+      this.release(projectId);
+      if (this.connections.size === 0) {
+        this.stopPinging();
+      }
+      logger.info('Orchestrator disconnected', { connectionId: connection.id, agentId, projectId, code });
+    });
+  }
+
+  // Ping every connection, dropping those silent for a ping interval plus
+  // the pong timeout
+  private startPinging(): void {
+    if (this.pingTimer) {
+      return;
+    }
+    this.pingTimer = setInterval(() => {
+      const cutoff = Date.now() - this.config.pingInterval - this.config.pongTimeout;
+      for (const connection of this.connections.values()) {
+        if (connection.lastSeen < cutoff) {
+          logger.warn('WebSocket pong timeout', { connectionId: connection.id });
+          connection.terminate();
+          continue;
+        }
+        connection.ping();
+      }
+    }, this.config.pingInterval);
+    this.pingTimer.unref();
+  }
+
+  private stopPinging(): void {
+    if (this.pingTimer) {
+      clearInterval(this.pingTimer);
+      this.pingTimer = null;
+    }
+  }
+
+  // Answer echo and status messages and route the others
+  private handle(connection: Connection, data: RawData): void {
+    let message: ConnectionMessage;
+    try {
+      message = JSON.parse(data.toString());
+    } catch {
+      connection.send(errorMessage({ type: '' }, 'invalid message: a JSON object with a type is required'));
+      return;
+    }
+    if (!message || typeof message !== 'object' || typeof message.type !== 'string' || !message.type) {
+      connection.send(errorMessage({ type: '' }, 'invalid message: a JSON object with a type is required'));
+      return;
+    }
+
+    switch (message.type) {
+      case MessageTypes.ECHO:
+        connection.send({ id: message.id, type: MessageTypes.ECHO, payload: message.payload });
+        return;
+      case MessageTypes.STATUS:
+        connection.send({ id: message.id, type: MessageTypes.STATUS, payload: this.status(connection) });
+        return;
+    }
+
+    if (!this.router) {
+      connection.send(errorMessage(message, new UnsupportedMessageError(message.type).message));
+      return;
+    }
+    this.router.route(connection, message).catch((error: Error) => {
+      if (!(error instanceof UnsupportedMessageError)) {
+        logger.error('Failed to route message', {
+          connectionId: connection.id,
+          type: message.type,
+          error: error.message
+        });
+      }
+      connection.send(errorMessage(message, error.message));
+    });
+  }
+
+  private status(connection: Connection) {
+    let connections = 0;
+    for (const other of this.connections.values()) {
+      if (other.agentId === connection.agentId) {
+        connections++;
+      }
+    }
+    return {
+      connection_id: connection.id,
+      agent_id: connection.agentId,
+      project_id: connection.projectId,
+      connected_at: connection.connectedAt.toISOString(),
+      // Open connections to the agent
+      connections
+    };
+  }
+}
+
+const headerValue = (value: string | string[] | undefined): string => {
+  return (Array.isArray(value) ? value[0] : value || '').trim();
+};
+
+const errorMessage = (message: ConnectionMessage, text: string): ConnectionMessage => ({
+  id: message.id,
+  type: MessageTypes.ERROR,
+  payload: { error: text, type: message.type }
+});
+
+// Answer a refused upgrade with a JSON error, as the API answers requests
+const reject = (socket: Duplex, status: number, message: string): void => {
+  const body = JSON.stringify({ error: message });
+  socket.end(
+    `HTTP/1.1 ${status} ${STATUS_CODES[status]}\r\n` +
+    'Content-Type: application/json\r\n' +
+    `Content-Length: ${Buffer.byteLength(body)}\r\n` +
+    'Connection: close\r\n\r\n' +
+    body
+  );
+};
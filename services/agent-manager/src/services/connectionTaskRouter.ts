@@ -0,0 +1,123 @@
+import { AgentOrchestrator } from './agentOrchestrator';
+import { AgentCommunicator } from './agentCommunicator';
+import {
+  Connection,
+  ConnectionHub,
+  ConnectionMessage,
+  MessageRouter,
+  UnsupportedMessageError
+} from './connectionHub';
+import { schemas } from '../middleware/validateRequest';
+import { Task, TaskRequest, TaskStatus } from '../models/agent';
+import { logger } from '../utils/logger';
+
+// Asks for a task to be executed, with a task submission as payload
+export const EXECUTE_TASK_MESSAGE = 'execute_task';
+// Reports the state of a task submitted over a connection
+export const TASK_UPDATE_MESSAGE = 'task_update';
+
+// Agent events relayed to the orchestrators whose task they are about
+const RELAYED_AGENT_EVENTS = ['task_log', 'resource_usage'];
+
+interface SubmittedTask {
+  agentId: string;
+  projectId: string;
+}
+
+/**
+ * Submits the tasks orchestrators send over their connections to the task
+ * queue, and reports their progress and outcome back on the connections to
+ * the agent they were sent to, within their project.
+ */
+export class ConnectionTaskRouter implements MessageRouter {
+  private orchestrator: AgentOrchestrator;
+  private communicator: AgentCommunicator;
+  private hub?: ConnectionHub;
+  private submitted: Map<string, SubmittedTask> = new Map();
+
+  constructor(orchestrator: AgentOrchestrator, communicator: AgentCommunicator) {
+    this.orchestrator = orchestrator;
+    this.communicator = communicator;
+  }
+
+  /**
+   * Start relaying task updates to the connections of a hub
+   */
+  public attach(hub: ConnectionHub): void {
+    this.hub = hub;
+
+    this.orchestrator.on('task:completed', (task: Task) => this.finish(task));
+    this.orchestrator.on('task:failed', (task: Task) => this.finish(task));
+
+    this.communicator.on('task:progress', (data: { taskId: string; progress: number; message?: string }) => {
+      this.relay(data.taskId, {
+        type: TASK_UPDATE_MESSAGE,
+        payload: {
+          task_id: data.taskId,
+          status: TaskStatus.IN_PROGRESS,
+          progress: data.progress,
+          message: data.message
+        }
+      });
+    });
+
+    for (const event of RELAYED_AGENT_EVENTS) {
+      this.communicator.on(`agent:${event}`, (agentId: string, payload: any) => {
+        const taskId = payload?.task_id || payload?.taskId;
+        if (taskId) {
+          this.relay(taskId, { type: event, payload });
+        }
+      });
+    }
+  }
+
+  public async route(connection: Connection, message: ConnectionMessage): Promise<void> {
+    if (message.type !== EXECUTE_TASK_MESSAGE) {
+      throw new UnsupportedMessageError(message.type);
+    }
+
+    const { error, value } = schemas.taskSubmission.validate(message.payload, { stripUnknown: true });
+    if (error) {
+      throw new Error(`invalid task: ${error.message}`);
+    }
+
+    // Tasks belong to the project the connection was opened for
+    const request: TaskRequest = {
+      ...value,
+      metadata: {
+        ...value.metadata,
+        source: 'orchestrator',
+        projectId: connection.projectId,
+        correlationId: value.metadata?.correlationId || message.id
+      }
+    };
+    const task = await this.orchestrator.submitTask(request);
+    this.submitted.set(task.id, { agentId: connection.agentId, projectId: connection.projectId });
+
+    logger.info(`Task ${task.id} submitted over connection ${connection.id}`);
+    connection.send({ id: message.id, type: TASK_UPDATE_MESSAGE, payload: taskUpdate(task) });
+  }
+
+  private finish(task: Task): void {
+    this.relay(task.id, { type: TASK_UPDATE_MESSAGE, payload: taskUpdate(task) });
+    this.submitted.delete(task.id);
+  }
+
+  private relay(taskId: string, message: ConnectionMessage): void {
+    const submitted = this.submitted.get(taskId);
+    if (!submitted || !this.hub) {
+      return;
+    }
+    this.hub.send(submitted.agentId, submitted.projectId, message);
+  }
+}
+
+const taskUpdate = (task: Task) => ({
+  task_id: task.id,
+  status: task.status,
+  assigned_agent_id: task.assignedAgentId,
+  result: task.result,
+  error: task.error,
+  created_at: task.createdAt,
+  completed_at: task.completedAt
+});
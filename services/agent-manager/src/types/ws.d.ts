@@ -0,0 +1,49 @@
+// Typings for the parts of ws the connection hub uses. ws is the WebSocket
+// implementation socket.io already ships with; its typings are not published
+// with it.
+declare module 'ws' {
+  import { EventEmitter } from 'events';
+  import { ClientRequest, IncomingMessage, OutgoingHttpHeaders } from 'http';
+  import { Duplex } from 'stream';
+
+  type RawData = Buffer | ArrayBuffer | Buffer[];
+
+  class WebSocket extends EventEmitter {
+    static readonly CONNECTING: number;
+    static readonly OPEN: number;
+    static readonly CLOSING: number;
+    static readonly CLOSED: number;
+
+    readonly readyState: number;
+
+    constructor(address: string, options?: { headers?: OutgoingHttpHeaders; autoPong?: boolean });
+
+    send(data: string, cb?: (err?: Error) => void): void;
+    ping(data?: Buffer | string): void;
+    close(code?: number, reason?: string): void;
+    terminate(): void;
+
+    on(event: 'message', listener: (data: RawData, isBinary: boolean) => void): this;
+    on(event: 'close', listener: (code: number, reason: Buffer) => void): this;
+    on(event: 'ping' | 'pong', listener: (data: Buffer) => void): this;
+    on(event: 'open', listener: () => void): this;
+    on(event: 'error', listener: (err: Error) => void): this;
+    on(event: 'unexpected-response', listener: (req: ClientRequest, res: IncomingMessage) => void): this;
+    on(event: string | symbol, listener: (...args: any[]) => void): this;
+  }
+
+  class WebSocketServer extends EventEmitter {
+    constructor(options: { noServer: true; maxPayload?: number });
+
+    handleUpgrade(
+      req: IncomingMessage,
+      socket: Duplex,
+      head: Buffer,
+      callback: (ws: WebSocket, req: IncomingMessage) => void
+    ): void;
+    close(cb?: (err?: Error) => void): void;
+  }
+
+  export { RawData, WebSocket, WebSocketServer };
+  export default WebSocket;
+}
@@ -0,0 +1,67 @@
+import express, { Application } from 'express';
+import request from 'supertest';
+import { loadServiceTokens, serviceAuth } from '../../../src/middleware/serviceAuth';
+import { errorHandler } from '../../../src/middleware/errorHandler';
+
+jest.mock('../../../src/utils/logger');
+
+const createApp = (tokens: string[]): Application => {
+  const app = express();
+  app.get('/health', (req, res) => res.json({ status: 'healthy' }));
+
+  const apiRouter = express.Router();
+  apiRouter.get('/agents', (req, res) => res.json({ agents: [] }));
+  app.use('/api/v1', serviceAuth(tokens), apiRouter);
+
+  app.use(errorHandler);
+  return app;
+};
+
+describe('serviceAuth', () => {
+  const app = createApp(['old-token', 'new-token']);
+
+  it('should reject requests without a token', async () => {
+    const response = await request(app).get('/api/v1/agents');
+
+    expect(response.status).toBe(401);
+    expect(response.body.error.code).toBe('UNAUTHORIZED');
+  });
+
+  it('should reject an unknown token', async () => {
+    const response = await request(app)
+      .get('/api/v1/agents')
+      .set('Authorization', 'Bearer wrong-token');
+
+    expect(response.status).toBe(401);
+  });
+
+  it('should accept both tokens while one is rotated', async () => {
+    for (const token of ['old-token', 'new-token']) {
+      const response = await request(app)
+        .get('/api/v1/agents')
+        .set('Authorization', `Bearer ${token}`)
+        .set('X-Service-Name', 'orchestrator');
+
+      expect(response.status).toBe(200);
+    }
+  });
+
+  it('should leave the health check open', async () => {
+    const response = await request(app).get('/health');
+
+    expect(response.status).toBe(200);
+  });
+
+  it('should allow all requests when no tokens are configured', async () => {
+    const response = await request(createApp([])).get('/api/v1/agents');
+
+    expect(response.status).toBe(200);
+  });
+});
+
+describe('loadServiceTokens', () => {
+  it('should parse a comma-separated token list', () => {
+    expect(loadServiceTokens({ SERVICE_AUTH_TOKENS: ' old-token, new-token,' })).toEqual(['old-token', 'new-token']);
+    expect(loadServiceTokens({})).toEqual([]);
+  });
+});
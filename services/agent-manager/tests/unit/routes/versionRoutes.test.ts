@@ -0,0 +1,17 @@
+import express from 'express';
+import request from 'supertest';
+import { API_FEATURES, API_VERSION, versionRoutes } from '../../../src/routes/versionRoutes';
+
+describe('versionRoutes', () => {
+  const app = express();
+  app.use('/api/v1/version', versionRoutes);
+
+  it('should report the API version and features', async () => {
+    const response = await request(app).get('/api/v1/version');
+
+    expect(response.status).toBe(200);
+    expect(response.body).toEqual({ version: API_VERSION, features: API_FEATURES });
+    expect(response.body.version).toMatch(/^\d+\.\d+\.\d+$/);
+    expect(response.body.features).toContain('capability-objects');
+  });
+});
@@ -53,6 +53,7 @@ describe('AgentRegistry', () => {
       expect(agent.status).toBe(AgentStatus.AVAILABLE);
       expect(agent.socketId).toBe('socket-123');
       expect(agent.capabilities).toEqual(request.capabilities);
+      expect(agent.region).toBe('us-east-1');
     });
 
     it('should emit agent:registered event', async () => {
@@ -229,6 +230,30 @@ describe('AgentRegistry', () => {
       );
     });
 
+    it('should refresh capabilities reported with a heartbeat', async () => {
+      const agent = await agentRegistry.registerAgent({
+        name: 'Test Agent',
+        type: AgentType.CODE_GEN,
+        capabilities: [{ name: 'javascript', version: '1.0' }],
+        metadata: { version: '1.0.0', platform: 'linux' }
+      });
+      const updated = jest.fn();
+      agentRegistry.on('agent:capabilities:updated', updated);
+
+      const capabilities = [
+        { name: 'javascript', version: '2.0', proficiency: 0.9 },
+        { name: 'go', version: '1.0', proficiency: 0.6 }
+      ];
+      await agentRegistry.updateAgentHeartbeat(agent.id, capabilities);
+      // An unchanged report is not an update
+      await agentRegistry.updateAgentHeartbeat(agent.id, capabilities);
+      // Nor is a heartbeat without capabilities
+      await agentRegistry.updateAgentHeartbeat(agent.id);
+
+      expect(agentRegistry.getAgent(agent.id)?.capabilities).toEqual(capabilities);
+      expect(updated).toHaveBeenCalledTimes(1);
+    });
+
     it('should mark offline agent as available on heartbeat', async () => {
       const agent = await agentRegistry.registerAgent({
         name: 'Test Agent',
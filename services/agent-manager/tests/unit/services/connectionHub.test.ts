@@ -0,0 +1,213 @@
+import { createServer, IncomingMessage, Server } from 'http';
+import { AddressInfo } from 'net';
+import { EventEmitter } from 'events';
+import WebSocket from 'ws';
+import { ConnectionHub, ConnectionHubConfig, ConnectionMessage } from '../../../src/services/connectionHub';
+import { ConnectionTaskRouter, EXECUTE_TASK_MESSAGE, TASK_UPDATE_MESSAGE } from '../../../src/services/connectionTaskRouter';
+import { serviceTokenVerifier } from '../../../src/middleware/serviceAuth';
+import { AgentOrchestrator } from '../../../src/services/agentOrchestrator';
+import { AgentCommunicator } from '../../../src/services/agentCommunicator';
+import { AgentType, Task, TaskPriority, TaskStatus } from '../../../src/models/agent';
+
+jest.mock('../../../src/utils/logger');
+
+// Stands in for the task subsystem, recording submitted tasks
+class FakeOrchestrator extends EventEmitter {
+  public submitted: Task[] = [];
+
+  async submitTask(request: any): Promise<Task> {
+    const task = {
+      id: `task-${this.submitted.length + 1}`,
+      type: request.type,
+      priority: TaskPriority.MEDIUM,
+      status: TaskStatus.PENDING,
+      payload: request.payload,
+      metadata: request.metadata,
+      attempts: 0,
+      maxAttempts: 3,
+      timeout: 300000,
+      createdAt: new Date(),
+      updatedAt: new Date()
+    } as Task;
+    this.submitted.push(task);
+    return task;
+  }
+}
+
+interface Harness {
+  hub: ConnectionHub;
+  orchestrator: FakeOrchestrator;
+  communicator: EventEmitter;
+  url: string;
+  server: Server;
+}
+
+const startHub = async (config: Partial<ConnectionHubConfig> = {}): Promise<Harness> => {
+  const orchestrator = new FakeOrchestrator();
+  const communicator = new EventEmitter();
+  const router = new ConnectionTaskRouter(
+    orchestrator as unknown as AgentOrchestrator,
+    communicator as unknown as AgentCommunicator
+  );
+  const hub = new ConnectionHub(config, serviceTokenVerifier(['old-token', 'new-token']), router);
+  router.attach(hub);
+
+  const server = createServer((req, res) => res.writeHead(404).end());
+  server.on('upgrade', (req, socket, head) => {
+    if (!hub.handleUpgrade(req, socket, head)) {
+      socket.destroy();
+    }
+  });
+  await new Promise<void>(resolve => server.listen(0, resolve));
+  const { port } = server.address() as AddressInfo;
+  return { hub, orchestrator, communicator, server, url: `ws://127.0.0.1:${port}` };
+};
+
+// Opens a connection to an agent as the orchestrator's AgentClient does
+const dial = (
+  url: string,
+  headers: Record<string, string>,
+  options: { autoPong?: boolean } = {}
+): Promise<WebSocket> => {
+  return new Promise((resolve, reject) => {
+    const ws = new WebSocket(`${url}/api/v1/agents/agent-1/connect`, { headers, ...options });
+    ws.on('open', () => resolve(ws));
+    ws.on('unexpected-response', (req, res: IncomingMessage) => reject(res.statusCode));
+    ws.on('error', reject);
+  });
+};
+
+const authorized = (projectId: string = 'project-1') => ({
+  Authorization: 'Bearer new-token',
+  'X-Project-ID': projectId
+});
+
+const nextMessage = (ws: WebSocket): Promise<ConnectionMessage> => {
+  return new Promise(resolve => ws.once('message', data => resolve(JSON.parse(data.toString()))));
+};
+
+const closed = (ws: WebSocket): Promise<number> => {
+  return new Promise(resolve => ws.once('close', (code: number) => resolve(code)));
+};
+
+// Resolves once the hub has released its connections
+const drained = async (hub: ConnectionHub): Promise<void> => {
+  while (hub.count() > 0) {
+    await new Promise(resolve => setTimeout(resolve, 10));
+  }
+};
+
+describe('ConnectionHub', () => {
+  let harness: Harness;
+  const sockets: WebSocket[] = [];
+
+  const connect = async (headers = authorized(), options = {}) => {
+    const ws = await dial(harness.url, headers, options);
+    sockets.push(ws);
+    return ws;
+  };
+
+  beforeEach(async () => {
+    harness = await startHub({ maxConnectionsPerProject: 2 });
+  });
+
+  afterEach(async () => {
+    sockets.splice(0).forEach(ws => ws.terminate());
+    await harness.hub.shutdown(100);
+    await new Promise(resolve => harness.server.close(resolve));
+  });
+
+  it('should require a service token and a project', async () => {
+    await expect(dial(harness.url, { 'X-Project-ID': 'project-1' })).rejects.toBe(401);
+    await expect(dial(harness.url, { ...authorized(), Authorization: 'Bearer wrong-token' })).rejects.toBe(401);
+    await expect(dial(harness.url, { Authorization: 'Bearer old-token' })).rejects.toBe(400);
+    expect(harness.hub.count()).toBe(0);
+  });
+
+  it('should answer echo and status messages', async () => {
+    const ws = await connect();
+
+    ws.send(JSON.stringify({ id: 'm1', type: 'echo', payload: { ping: 1 } }));
+    const echo = await nextMessage(ws);
+    expect(echo).toMatchObject({ id: 'm1', type: 'echo', payload: { ping: 1 } });
+    expect(echo.timestamp).toBeDefined();
+
+    ws.send(JSON.stringify({ id: 'm2', type: 'status' }));
+    const status = await nextMessage(ws);
+    expect(status.payload).toMatchObject({ agent_id: 'agent-1', project_id: 'project-1', connections: 1 });
+  });
+
+  it('should answer unsupported and invalid messages with errors', async () => {
+    const ws = await connect();
+
+    ws.send(JSON.stringify({ id: 'm1', type: 'teleport' }));
+    expect(await nextMessage(ws)).toMatchObject({ id: 'm1', type: 'error', payload: { type: 'teleport' } });
+
+    ws.send('not json');
+    expect((await nextMessage(ws)).type).toBe('error');
+  });
+
+  it('should submit tasks to the task subsystem and report their outcome', async () => {
+    const ws = await connect();
+    const other = await connect(authorized('project-2'));
+
+    ws.send(JSON.stringify({
+      id: 'm1',
+      type: EXECUTE_TASK_MESSAGE,
+      payload: { type: AgentType.CODE_GEN, payload: { prompt: 'hello' }, metadata: { projectId: 'project-2' } }
+    }));
+    const queued = await nextMessage(ws);
+    expect(queued).toMatchObject({ id: 'm1', type: TASK_UPDATE_MESSAGE, payload: { task_id: 'task-1', status: 'pending' } });
+
+    const [task] = harness.orchestrator.submitted;
+    expect(task.metadata).toMatchObject({ source: 'orchestrator', projectId: 'project-1', correlationId: 'm1' });
+
+    // Progress and the outcome reach the project's connections only
+    let leaked = false;
+    other.on('message', () => { leaked = true; });
+    harness.communicator.emit('task:progress', { taskId: 'task-1', progress: 50 });
+    expect(await nextMessage(ws)).toMatchObject({ type: TASK_UPDATE_MESSAGE, payload: { task_id: 'task-1', progress: 50 } });
+
+    task.status = TaskStatus.COMPLETED;
+    task.result = { ok: true };
+    harness.orchestrator.emit('task:completed', task);
+    expect(await nextMessage(ws)).toMatchObject({ payload: { task_id: 'task-1', status: 'completed', result: { ok: true } } });
+    expect(leaked).toBe(false);
+
+    ws.send(JSON.stringify({ id: 'm2', type: EXECUTE_TASK_MESSAGE, payload: { type: 'unknown' } }));
+    expect(await nextMessage(ws)).toMatchObject({ id: 'm2', type: 'error' });
+  });
+
+  it('should limit the connections of a project', async () => {
+    await connect();
+    await connect();
+    await expect(dial(harness.url, authorized())).rejects.toBe(429);
+    await connect(authorized('project-2'));
+    expect(harness.hub.count()).toBe(3);
+  });
+
+  it('should drop connections that stop answering pings', async () => {
+    await harness.hub.shutdown(100);
+    harness.server.close();
+    harness = await startHub({ pingInterval: 50, pongTimeout: 50 });
+
+    const ws = await connect(authorized(), { autoPong: false });
+    expect(await closed(ws)).toBe(1006);
+    await drained(harness.hub);
+
+    // The orchestrator reconnects
+    const again = await connect();
+    again.send(JSON.stringify({ id: 'm1', type: 'echo' }));
+    expect((await nextMessage(again)).id).toBe('m1');
+  });
+
+  it('should close connections on shutdown', async () => {
+    const ws = await connect();
+    const code = closed(ws);
+    await harness.hub.shutdown(1000);
+
+    expect(await code).toBe(1001);
+    expect(harness.hub.count()).toBe(0);
+    await expect(dial(harness.url, authorized())).rejects.toBe(503);
+  });
+});
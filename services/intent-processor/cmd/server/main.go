@@ -2,8 +2,6 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,11 +22,18 @@ func main() {
 	port := getEnv("SERVICE_PORT", "8082")
 	metricsPort := getEnv("METRICS_PORT", "8083")
 
+	// Callers must present a service token; only development runs without
+	production := getEnv("ENV", "production") != "development"
+	auth, err := serviceAuth(serviceTokens(os.Getenv("SERVICE_AUTH_TOKENS")), production, logger)
+	if err != nil {
+		logger.Fatal("Invalid service authentication configuration", zap.Error(err))
+	}
+
 	// Create main router
 	router := gin.Default()
 
-	// API routes
-	v1 := router.Group("/api/v1")
+	// API routes, callers must present a service token
+	v1 := router.Group("/api/v1", auth)
 	{
 		v1.POST("/process", processIntent)
 		v1.GET("/status/:id", getProcessingStatus)
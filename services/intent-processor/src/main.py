@@ -22,9 +22,21 @@ from .models import (
     IntentResponse,
     HealthResponse,
     ErrorResponse,
-    TaskBreakdown
+    TaskBreakdown,
+    ProcessIntentRequest,
+    ProcessIntentResponse,
+    IntentStatusResponse,
+    CancelIntentRequest,
+    AnalyzeIntentRequest,
+    AnalyzeIntentResponse
 )
 from .services.robust_intent_analyzer import RobustIntentAnalyzer
+from .services.intent_registry import (
+    IntentRegistry,
+    IntentNotFoundError,
+    IntentStateError,
+    IntentCancelledError
+)
 from .services.prompt_manager import PromptManager
 from .services.thought_stream import thought_stream
 from .utils.resilience import (
@@ -34,6 +46,7 @@ from .utils.resilience import (
     timeout_wrapper,
     HealthChecker
 )
+from .utils.service_auth import ServiceAuth, load_service_tokens
 
 # Configure structured logging
 logHandler = logging.StreamHandler()
@@ -99,6 +112,7 @@ async def lifespan(app: FastAPI):
         app.state.prompt_manager = PromptManager()
         app.state.intent_analyzer = RobustIntentAnalyzer(redis_client=redis_client)
         await app.state.intent_analyzer.initialize()
+        app.state.intent_registry = IntentRegistry(app.state.intent_analyzer)
         
         # Log available providers
         from .services.llm_factory import llm_factory
@@ -150,6 +164,11 @@ app.add_middleware(
 )
 
 
+# Require a service token on /api/v1, /health and /metrics stay open for probes.
+# Only development runs without tokens.
+app.middleware("http")(ServiceAuth(load_service_tokens(), production=os.getenv("ENV", "production") != "development"))
+
+
 @app.middleware("http")
 async def add_metrics(request: Request, call_next):
     """Add prometheus metrics to all requests"""
@@ -378,6 +397,78 @@ async def process_intent(request: IntentRequest, req: Request) -> IntentResponse
         )
 
 
+def intent_error(status_code: int, code: str, message: str) -> JSONResponse:
+    """Error body the orchestrator's intent client decodes"""
+    return JSONResponse(status_code=status_code, content={"code": code, "message": message})
+
+
+# REST mirror of the IntentService gRPC contract, for orchestrators using the
+# http intent transport where gRPC cannot pass through the proxy layer
+
+@app.post("/api/v1/intents/analyze", response_model=AnalyzeIntentResponse)
+async def analyze_intent_rest(request: AnalyzeIntentRequest, req: Request):
+    """Analyze an intent without processing it"""
+    try:
+        return await req.app.state.intent_registry.analyze(request)
+    except ValueError as e:
+        return intent_error(status.HTTP_400_BAD_REQUEST, "INVALID_ARGUMENT", str(e))
+    except Exception as e:
+        logger.error(f"Failed to analyze intent: {str(e)}")
+        return intent_error(status.HTTP_500_INTERNAL_SERVER_ERROR, "INTERNAL", "Failed to analyze intent")
+
+
+@app.post("/api/v1/intents/process", response_model=ProcessIntentResponse)
+async def process_intent_rest(request: ProcessIntentRequest, req: Request):
+    """
+    Process an intent. Async requests return once the intent is accepted, and
+    its outcome is read from the status endpoint.
+    """
+    try:
+        response = await req.app.state.intent_registry.process(request)
+    except IntentCancelledError as e:
+        intent_processing_counter.labels(status="cancelled").inc()
+        return intent_error(status.HTTP_409_CONFLICT, "CANCELLED", str(e))
+    except asyncio.TimeoutError:
+        intent_processing_counter.labels(status="error").inc()
+        return intent_error(status.HTTP_504_GATEWAY_TIMEOUT, "DEADLINE_EXCEEDED", "Processing timeout")
+    except ValueError as e:
+        intent_processing_counter.labels(status="validation_error").inc()
+        return intent_error(status.HTTP_400_BAD_REQUEST, "INVALID_ARGUMENT", str(e))
+    except Exception as e:
+        intent_processing_counter.labels(status="error").inc()
+        logger.error(
+            "Failed to process intent",
+            extra={"request_id": request.request_id, "error": str(e), "type": type(e).__name__}
+        )
+        return intent_error(status.HTTP_500_INTERNAL_SERVER_ERROR, "INTERNAL", "Failed to process intent")
+
+    if not request.async_:
+        intent_processing_counter.labels(status="success").inc()
+    return response
+
+
+@app.get("/api/v1/intents/{intent_id}/status", response_model=IntentStatusResponse)
+async def get_intent_status_rest(intent_id: str, req: Request):
+    """Get the status of an intent processed by this replica"""
+    try:
+        return req.app.state.intent_registry.status(intent_id)
+    except IntentNotFoundError as e:
+        return intent_error(status.HTTP_404_NOT_FOUND, "NOT_FOUND", str(e))
+
+
+@app.post("/api/v1/intents/{intent_id}/cancel")
+async def cancel_intent_rest(intent_id: str, request: CancelIntentRequest, req: Request):
+    """Cancel an intent still processing"""
+    try:
+        req.app.state.intent_registry.cancel(intent_id, request.reason)
+    except IntentNotFoundError as e:
+        return intent_error(status.HTTP_404_NOT_FOUND, "NOT_FOUND", str(e))
+    except IntentStateError as e:
+        return intent_error(status.HTTP_409_CONFLICT, "FAILED_PRECONDITION", str(e))
+
+    return {"success": True, "message": f"Intent {intent_id} cancelled"}
+
+
 @app.post("/api/v1/validate-tasks", response_model=Dict[str, Any])
 async def validate_tasks(tasks: TaskBreakdown, req: Request) -> Dict[str, Any]:
     """
@@ -164,4 +164,99 @@ class ValidationResult(BaseModel):
     
     def add_suggestion(self, suggestion: str):
         """Add an improvement suggestion"""
-        self.suggestions.append(suggestion)
\ No newline at end of file
+        self.suggestions.append(suggestion)
+
+class ProcessIntentRequest(BaseModel):
+    """Request of the REST intent API, mirroring the gRPC ProcessIntentRequest"""
+    type: str = Field("", description="Kind of intent, passed to the analyzer as context")
+    content: str = Field(..., description="Natural language content of the intent")
+    context: Optional[Dict[str, str]] = Field(None, description="Additional context for processing")
+    parameters: Optional[Dict[str, Any]] = Field(None, description="Intent parameters")
+    constraints: Optional[Dict[str, Any]] = Field(None, description="Intent constraints")
+    project_id: str = Field("", description="Project identifier")
+    user_id: str = Field("", description="User identifier")
+    request_id: str = Field("", description="Request identifier")
+    async_: bool = Field(False, alias="async", description="Return once accepted instead of once processed")
+    priority: str = Field("", description="Intent priority")
+    timeout_seconds: int = Field(0, description="Processing timeout, the service default when 0")
+    max_retries: int = Field(0, description="Accepted for compatibility, the analyzer falls back on its own")
+
+    @validator('content')
+    def validate_content(cls, v):
+        if not v.strip():
+            raise ValueError("Content cannot be empty")
+        return v.strip()
+
+
+class IntentAction(BaseModel):
+    """Action derived from a task of a processed intent"""
+    id: str = Field(..., description="Action identifier, the task identifier")
+    type: str = Field(..., description="Action type, the task type")
+    description: str = Field(..., description="Action description")
+    parameters: Dict[str, Any] = Field(default_factory=dict, description="Action parameters")
+    status: str = Field("pending", description="Action status")
+    result: Dict[str, Any] = Field(default_factory=dict, description="Action result")
+
+
+class ProcessIntentResponse(BaseModel):
+    """Response of the REST intent API, mirroring the gRPC ProcessIntentResponse"""
+    intent_id: str = Field(..., description="Intent identifier for status and cancel requests")
+    status: str = Field(..., description="Intent status")
+    message: str = Field("", description="Status message")
+    result: Dict[str, Any] = Field(default_factory=dict, description="Analysis of the intent")
+    confidence: float = Field(0.0, description="Confidence score")
+    actions: List[IntentAction] = Field(default_factory=list, description="Actions to perform")
+    suggestions: List[str] = Field(default_factory=list, description="Suggestions")
+    metadata: Dict[str, str] = Field(default_factory=dict, description="Additional metadata")
+
+
+class IntentStatusResponse(BaseModel):
+    """Status of an intent, mirroring the gRPC GetIntentStatusResponse"""
+    intent_id: str = Field(..., description="Intent identifier")
+    status: str = Field(..., description="processing, completed, failed or cancelled")
+    progress: int = Field(0, description="Progress percentage")
+    message: str = Field("", description="Status message")
+    started_at: datetime = Field(..., description="Processing start time")
+    completed_at: Optional[datetime] = Field(None, description="Processing end time")
+    error: str = Field("", description="Error of a failed intent")
+
+
+class CancelIntentRequest(BaseModel):
+    """Request to cancel an intent still processing"""
+    reason: str = Field("", description="Cancellation reason")
+
+
+class AnalyzeIntentRequest(BaseModel):
+    """Request to analyze an intent without processing it"""
+    content: str = Field(..., description="Natural language content of the intent")
+    context: Optional[Dict[str, str]] = Field(None, description="Additional context for analysis")
+    project_id: str = Field("", description="Project identifier")
+    user_id: str = Field("", description="User identifier")
+
+    @validator('content')
+    def validate_content(cls, v):
+        if not v.strip():
+            raise ValueError("Content cannot be empty")
+        return v.strip()
+
+
+class IntentEntity(BaseModel):
+    """Entity extracted from an intent"""
+    type: str = Field(..., description="Entity type")
+    value: str = Field(..., description="Entity value")
+    confidence: float = Field(0.0, description="Confidence score")
+    start: int = Field(0, description="Start offset in the content, 0 when unknown")
+    end: int = Field(0, description="End offset in the content, 0 when unknown")
+
+
+class AnalyzeIntentResponse(BaseModel):
+    """Analysis of an intent, mirroring the gRPC AnalyzeIntentResponse"""
+    intent_type: str = Field(..., description="Classified intent type")
+    confidence: float = Field(..., description="Confidence score")
+    entities: List[IntentEntity] = Field(default_factory=list, description="Extracted entities")
+    required_params: List[str] = Field(default_factory=list, description="Parameters the intent needs")
+    optional_params: List[str] = Field(default_factory=list, description="Parameters the intent accepts")
+    suggestions: List[str] = Field(default_factory=list, description="Suggestions")
+    risks: List[str] = Field(default_factory=list, description="Risks")
+    estimated_time: int = Field(0, description="Estimated time in seconds")
+    estimated_cost: float = Field(0.0, description="Estimated cost")
@@ -0,0 +1,254 @@
+"""
+Intent Registry for the REST intent API
+Analyzes and processes intents for the orchestrator's HTTP transport, and
+keeps the status of processed intents for status and cancel requests
+"""
+
+import asyncio
+import logging
+import uuid
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Optional
+
+from ..models import (
+    AnalyzeIntentRequest,
+    AnalyzeIntentResponse,
+    IntentAction,
+    IntentAnalysisResult,
+    IntentEntity,
+    IntentStatusResponse,
+    ProcessIntentRequest,
+    ProcessIntentResponse,
+)
+
+logger = logging.getLogger(__name__)
+
+PROCESSING = "processing"
+COMPLETED = "completed"
+FAILED = "failed"
+CANCELLED = "cancelled"
+
+TERMINAL_STATUSES = {COMPLETED, FAILED, CANCELLED}
+
+# Timeout of intents that do not set one, as for /api/v1/process-intent
+DEFAULT_TIMEOUT_SECONDS = 60
+
+
+class IntentNotFoundError(LookupError):
+    """Raised for intents the registry does not know"""
+
+
+class IntentStateError(ValueError):
+    """Raised when an intent cannot change to the requested state"""
+
+
+class IntentCancelledError(Exception):
+    """Raised when an intent is cancelled while a request waits on it"""
+
+
+class IntentRegistry:
+    """Processes intents with the intent analyzer and tracks their status.
+
+    Statuses are kept in memory, bounded to max_intents with the oldest
+    finished intents dropped first: they do not survive a restart, and only
+    the replica that processed an intent knows it.
+    """
+
+    def __init__(self, analyzer, max_intents: int = 10000):
+        self.analyzer = analyzer
+        self.max_intents = max_intents
+        self._statuses: Dict[str, IntentStatusResponse] = {}
+        self._tasks: Dict[str, asyncio.Task] = {}
+
+    async def analyze(self, request: AnalyzeIntentRequest) -> AnalyzeIntentResponse:
+        """Analyze an intent without tracking it"""
+        result = await self.analyzer.analyze_intent(
+            text=request.content,
+            context=request.context or None,
+            project_info=_project_info(request.project_id)
+        )
+
+        hours = sum(task.estimated_hours or 0 for task in result.tasks)
+        return AnalyzeIntentResponse(
+            intent_type=result.intent_type.value,
+            confidence=result.confidence,
+            entities=_entities(result),
+            suggestions=_strings(result.metadata.get("suggestions")),
+            risks=_strings(result.metadata.get("risks")),
+            estimated_time=int(hours * 3600)
+        )
+
+    async def process(self, request: ProcessIntentRequest) -> ProcessIntentResponse:
+        """Process an intent, returning once it is accepted for async requests
+        and once it is processed otherwise"""
+        intent_id = str(uuid.uuid4())
+        status = IntentStatusResponse(
+            intent_id=intent_id,
+            status=PROCESSING,
+            message="Intent accepted for processing",
+            started_at=_now()
+        )
+        self._track(status)
+
+        task = asyncio.create_task(self._run(request, status))
+        self._tasks[intent_id] = task
+        task.add_done_callback(lambda _: self._tasks.pop(intent_id, None))
+
+        if request.async_:
+            # Failures are recorded in the status; retrieve them so they are
+            # not reported as never retrieved
+            task.add_done_callback(lambda t: t.cancelled() or t.exception())
+            return ProcessIntentResponse(
+                intent_id=intent_id,
+                status=PROCESSING,
+                message=status.message,
+                metadata=_metadata(request)
+            )
+
+        try:
+            return await task
+        except asyncio.CancelledError:
+            if status.status == CANCELLED:
+                raise IntentCancelledError(f"intent {intent_id} was cancelled")
+            raise
+
+    def status(self, intent_id: str) -> IntentStatusResponse:
+        """Return the status of an intent"""
+        status = self._statuses.get(intent_id)
+        if status is None:
+            raise IntentNotFoundError(f"intent {intent_id} not found")
+        return status
+
+    def cancel(self, intent_id: str, reason: str = "") -> IntentStatusResponse:
+        """Cancel an intent still processing"""
+        status = self.status(intent_id)
+        if status.status in TERMINAL_STATUSES:
+            raise IntentStateError(f"intent {intent_id} is already {status.status}")
+
+        status.status = CANCELLED
+        status.message = reason or "Intent cancelled"
+        status.completed_at = _now()
+        task = self._tasks.get(intent_id)
+        if task:
+            task.cancel()
+
+        logger.info(f"Intent {intent_id} cancelled: {status.message}")
+        return status
+
+    async def _run(self, request: ProcessIntentRequest, status: IntentStatusResponse) -> ProcessIntentResponse:
+        context: Dict[str, Any] = dict(request.context or {})
+        if request.type:
+            context.setdefault("intent_type", request.type)
+        timeout = request.timeout_seconds or DEFAULT_TIMEOUT_SECONDS
+
+        try:
+            result = await asyncio.wait_for(
+                self.analyzer.analyze_intent(
+                    text=request.content,
+                    context=context or None,
+                    project_info=_project_info(request.project_id),
+                    request_id=request.request_id or None
+                ),
+                timeout=timeout
+            )
+        except asyncio.TimeoutError:
+            self._finish(status, FAILED, "Processing timeout", error=f"timed out after {timeout}s")
+            raise
+        except asyncio.CancelledError:
+            raise
+        except Exception as e:
+            self._finish(status, FAILED, "Failed to process intent", error=str(e))
+            raise
+
+        self._finish(status, COMPLETED, result.summary)
+        return ProcessIntentResponse(
+            intent_id=status.intent_id,
+            status=COMPLETED,
+            message=result.summary,
+            result={
+                "intent_type": result.intent_type.value,
+                "summary": result.summary,
+                "tasks": [task.model_dump(mode="json") for task in result.tasks],
+                "metadata": result.metadata
+            },
+            confidence=result.confidence,
+            actions=[_action(task) for task in result.tasks],
+            suggestions=_strings(result.metadata.get("suggestions")),
+            metadata=_metadata(request, result)
+        )
+
+    def _finish(self, status: IntentStatusResponse, state: str, message: str, error: str = "") -> None:
+        # A cancellation that raced the analysis wins
+        if status.status in TERMINAL_STATUSES:
+            return
+        status.status = state
+        status.message = message
+        status.error = error
+        status.progress = 100 if state == COMPLETED else status.progress
+        status.completed_at = _now()
+
+    def _track(self, status: IntentStatusResponse) -> None:
+        self._statuses[status.intent_id] = status
+        if len(self._statuses) <= self.max_intents:
+            return
+        # Dicts keep insertion order, so the oldest finished intents go first
+        for intent_id in [i for i, s in self._statuses.items() if s.status in TERMINAL_STATUSES]:
+            if len(self._statuses) <= self.max_intents:
+                break
+            del self._statuses[intent_id]
+
+
+def _now() -> datetime:
+    # Timezone-aware, so the orchestrator can parse the timestamps as RFC 3339
+    return datetime.now(timezone.utc)
+
+
+def _project_info(project_id: str) -> Optional[Dict[str, Any]]:
+    return {"project_id": project_id} if project_id else None
+
+
+def _strings(value: Any) -> List[str]:
+    if not isinstance(value, list):
+        return []
+    return [str(item) for item in value]
+
+
+def _entities(result: IntentAnalysisResult) -> List[IntentEntity]:
+    """Entities the analyzer reported, grouped by type or as a plain list"""
+    entities = result.metadata.get("entities")
+    if isinstance(entities, dict):
+        return [
+            IntentEntity(type=str(kind), value=str(value), confidence=result.confidence)
+            for kind, values in entities.items()
+            for value in (values if isinstance(values, list) else [values])
+        ]
+    return [
+        IntentEntity(type="entity", value=value, confidence=result.confidence)
+        for value in _strings(entities)
+    ]
+
+
+def _action(task) -> IntentAction:
+    return IntentAction(
+        id=task.id,
+        type=task.type.value,
+        description=task.title,
+        parameters={
+            "description": task.description,
+            "priority": task.priority.value,
+            "complexity": task.complexity.value,
+            "estimated_hours": task.estimated_hours,
+            "dependencies": task.dependencies
+        }
+    )
+
+
+def _metadata(request: ProcessIntentRequest, result: Optional[IntentAnalysisResult] = None) -> Dict[str, str]:
+    metadata = {
+        "request_id": request.request_id,
+        "project_id": request.project_id,
+        "priority": request.priority
+    }
+    if result is not None:
+        metadata["strategy"] = str(result.metadata.get("strategy", ""))
+    return {key: value for key, value in metadata.items() if value}
@@ -0,0 +1,79 @@
+"""
+Service-to-service authentication
+"""
+
+import hashlib
+import hmac
+import logging
+import os
+from typing import List, Mapping, Optional
+
+from fastapi import Request, status
+from fastapi.responses import JSONResponse
+
+logger = logging.getLogger(__name__)
+
+PROTECTED_PREFIX = "/api/v1"
+
+
+def load_service_tokens(env: Optional[Mapping[str, str]] = None) -> List[str]:
+    """Parse the accepted tokens from SERVICE_AUTH_TOKENS.
+
+    Several comma-separated tokens are accepted at once so a token can be
+    rotated without downtime.
+    """
+    env = os.environ if env is None else env
+    return [token.strip() for token in env.get("SERVICE_AUTH_TOKENS", "").split(",") if token.strip()]
+
+
+class ServiceAuth:
+    """Requires a bearer token on /api/v1 requests from other services.
+
+    Without tokens every request is let through, for local development only:
+    in production construction fails, so the service refuses to start.
+    """
+
+    def __init__(self, tokens: List[str], production: bool = False):
+        if not tokens and production:
+            raise RuntimeError("SERVICE_AUTH_TOKENS is required in production")
+        self._accepted = [self._digest(token) for token in tokens]
+        if not tokens:
+            logger.warning("SERVICE_AUTH_TOKENS is not set, service authentication is disabled")
+
+    @staticmethod
+    def _digest(value: str) -> bytes:
+        return hashlib.sha256(value.encode()).digest()
+
+    def accepts(self, authorization: str) -> bool:
+        """Check an Authorization header in constant time"""
+        if not self._accepted:
+            return True
+        scheme, _, token = authorization.partition(" ")
+        if scheme != "Bearer" or not token:
+            return False
+        candidate = self._digest(token)
+        matched = False
+        for expected in self._accepted:
+            matched = hmac.compare_digest(candidate, expected) or matched
+        return matched
+
+    async def __call__(self, request: Request, call_next):
+        if request.url.path.startswith(PROTECTED_PREFIX) and not self.accepts(
+            request.headers.get("Authorization", "")
+        ):
+            logger.warning(
+                "Rejected service request",
+                extra={
+                    "path": request.url.path,
+                    "service": request.headers.get("X-Service-Name", "unknown")
+                }
+            )
+            return JSONResponse(
+                status_code=status.HTTP_401_UNAUTHORIZED,
+                content={
+                    "error": "Unauthorized",
+                    "message": "Missing or invalid service token",
+                    "request_id": request.headers.get("X-Request-ID", "unknown")
+                }
+            )
+        return await call_next(request)
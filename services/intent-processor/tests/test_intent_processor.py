@@ -15,6 +15,7 @@ from src.models import (
     TaskBreakdown,
     IntentRequest,
     IntentResponse,
+    IntentAnalysisResult,
     ValidationResult
 )
 from src.services.intent_analyzer import IntentAnalyzer
@@ -348,5 +349,187 @@ class TestIntegration:
         assert result.summary == "Add user authentication with JWT"
 
 
+class TestServiceAuth:
+    """Test cases for service-to-service authentication"""
+
+    @pytest.fixture
+    def client(self):
+        from fastapi import FastAPI
+        from fastapi.testclient import TestClient
+        from src.utils.service_auth import ServiceAuth
+
+        app = FastAPI()
+        app.middleware("http")(ServiceAuth(["old-token", "new-token"]))
+
+        @app.get("/health")
+        async def health():
+            return {"status": "healthy"}
+
+        @app.get("/api/v1/prompt-templates")
+        async def templates():
+            return {"templates": []}
+
+        return TestClient(app)
+
+    def test_rejects_missing_and_unknown_tokens(self, client):
+        """Test requests without a valid token are rejected"""
+        assert client.get("/api/v1/prompt-templates").status_code == 401
+        response = client.get("/api/v1/prompt-templates", headers={"Authorization": "Bearer wrong-token"})
+        assert response.status_code == 401
+
+    def test_accepts_both_tokens_during_rotation(self, client):
+        """Test old and new tokens are both accepted"""
+        for token in ["old-token", "new-token"]:
+            response = client.get("/api/v1/prompt-templates", headers={"Authorization": f"Bearer {token}"})
+            assert response.status_code == 200
+
+    def test_health_stays_open(self, client):
+        """Test probes do not need a token"""
+        assert client.get("/health").status_code == 200
+
+    def test_load_service_tokens(self):
+        """Test comma-separated token parsing"""
+        from src.utils.service_auth import load_service_tokens
+
+        assert load_service_tokens({"SERVICE_AUTH_TOKENS": " old-token, new-token,"}) == ["old-token", "new-token"]
+        assert load_service_tokens({}) == []
+
+    def test_requires_tokens_in_production(self):
+        """Test the service refuses to run unauthenticated in production"""
+        from src.utils.service_auth import ServiceAuth
+
+        with pytest.raises(RuntimeError, match="SERVICE_AUTH_TOKENS"):
+            ServiceAuth([], production=True)
+        assert ServiceAuth([]).accepts("")
+
+
+
+class FakeIntentAnalyzer:
+    """Analyzer returning a fixed analysis, blocking until released when asked"""
+
+    def __init__(self, block: bool = False):
+        import asyncio
+        self.release = asyncio.Event() if block else None
+        self.calls = []
+
+    async def analyze_intent(self, text, context=None, project_info=None, request_id=None):
+        self.calls.append({"text": text, "context": context, "project_info": project_info})
+        if self.release is not None:
+            await self.release.wait()
+        return IntentAnalysisResult(
+            intent_type=IntentType.FEATURE_REQUEST,
+            confidence=0.9,
+            summary="Add user authentication",
+            tasks=[
+                Task(
+                    id="task1",
+                    title="Create auth API",
+                    description="Create authentication API",
+                    type=TaskType.BACKEND,
+                    estimated_hours=2
+                )
+            ],
+            metadata={"strategy": "fake", "entities": {"technologies": ["JWT"]}}
+        )
+
+
+class TestIntentAPI:
+    """Test cases for the REST intent API the orchestrator's http transport uses"""
+
+    @pytest.fixture
+    def client(self, monkeypatch):
+        monkeypatch.setenv("ENV", "development")
+        from fastapi.testclient import TestClient
+        from src import main
+        from src.services.intent_registry import IntentRegistry
+
+        analyzer = FakeIntentAnalyzer()
+        monkeypatch.setattr(main.app.state, "intent_registry", IntentRegistry(analyzer), raising=False)
+        client = TestClient(main.app)
+        client.analyzer = analyzer
+        return client
+
+    def test_process_returns_the_analysis(self, client):
+        """Test a synchronous intent is processed and tracked"""
+        response = client.post("/api/v1/intents/process", json={
+            "type": "code_generation",
+            "content": "Create a user login system with JWT tokens",
+            "context": {"language": "go"},
+            "project_id": "project-1",
+            "request_id": "req-1",
+            "async": False
+        })
+        assert response.status_code == 200
+        body = response.json()
+        assert body["status"] == "completed"
+        assert body["confidence"] == 0.9
+        assert body["result"]["intent_type"] == "feature_request"
+        assert body["actions"][0]["id"] == "task1"
+        assert body["metadata"] == {"request_id": "req-1", "project_id": "project-1", "strategy": "fake"}
+        assert client.analyzer.calls[0]["context"] == {"language": "go", "intent_type": "code_generation"}
+        assert client.analyzer.calls[0]["project_info"] == {"project_id": "project-1"}
+
+        status = client.get(f"/api/v1/intents/{body['intent_id']}/status").json()
+        assert status["status"] == "completed"
+        assert status["progress"] == 100
+        # Timestamps carry a timezone so Go parses them as RFC 3339
+        assert status["started_at"].endswith(("Z", "+00:00"))
+
+        response = client.post(f"/api/v1/intents/{body['intent_id']}/cancel", json={"reason": "late"})
+        assert response.status_code == 409
+        assert response.json()["code"] == "FAILED_PRECONDITION"
+
+    def test_analyze_returns_the_gRPC_shape(self, client):
+        """Test analysis maps entities and the estimated time"""
+        response = client.post("/api/v1/intents/analyze", json={"content": "Add a login page", "project_id": "p"})
+        assert response.status_code == 200
+        body = response.json()
+        assert body["intent_type"] == "feature_request"
+        assert body["entities"][0]["type"] == "technologies"
+        assert body["entities"][0]["value"] == "JWT"
+        assert body["estimated_time"] == 7200
+
+    def test_unknown_intents_are_not_found(self, client):
+        """Test errors use the body the orchestrator decodes"""
+        response = client.get("/api/v1/intents/missing/status")
+        assert response.status_code == 404
+        assert response.json()["code"] == "NOT_FOUND"
+        assert client.post("/api/v1/intents/missing/cancel", json={}).status_code == 404
+
+    async def test_async_intents_can_be_cancelled(self):
+        """Test an async intent is processing until cancelled"""
+        import asyncio
+        from src.models import ProcessIntentRequest
+        from src.services.intent_registry import IntentRegistry, IntentStateError
+
+        analyzer = FakeIntentAnalyzer(block=True)
+        registry = IntentRegistry(analyzer)
+        accepted = await registry.process(ProcessIntentRequest(**{"content": "Add a login page", "async": True}))
+        assert accepted.status == "processing"
+        await asyncio.sleep(0)
+        assert registry.status(accepted.intent_id).status == "processing"
+
+        registry.cancel(accepted.intent_id, "no longer needed")
+        await asyncio.sleep(0)
+        status = registry.status(accepted.intent_id)
+        assert status.status == "cancelled"
+        assert status.message == "no longer needed"
+        assert status.completed_at is not None
+        with pytest.raises(IntentStateError):
+            registry.cancel(accepted.intent_id)
+
+    async def test_finished_intents_are_evicted_first(self):
+        """Test the registry stays bounded"""
+        from src.models import ProcessIntentRequest
+        from src.services.intent_registry import IntentRegistry, IntentNotFoundError
+
+        registry = IntentRegistry(FakeIntentAnalyzer(), max_intents=2)
+        first = await registry.process(ProcessIntentRequest(content="Add a login page"))
+        await registry.process(ProcessIntentRequest(content="Add a logout page"))
+        last = await registry.process(ProcessIntentRequest(content="Add a profile page"))
+        with pytest.raises(IntentNotFoundError):
+            registry.status(first.intent_id)
+        assert registry.status(last.intent_id).status == "completed"
+
 if __name__ == "__main__":
-    pytest.main([__file__, "-v"])
\ No newline at end of file
+    pytest.main([__file__, "-v"])
@@ -0,0 +1,102 @@
+// Command replayer replays a workflow history against the workflows of this
+// build to debug nondeterminism errors. The history is fetched from Temporal
+// by workflow ID or read from a JSON file:
+//
+//	replayer -workflow-id 0d6c1f7e-... [-run-id ...] [-save history.json]
+//	replayer -history history.json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	historypb "go.temporal.io/api/history/v1"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/temporal"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run replays the history the arguments name and prints the outcome. A
+// divergence is returned as an error wrapping temporal.ErrNondeterministic.
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replayer", flag.ContinueOnError)
+	flags.SetOutput(out)
+	workflowID := flags.String("workflow-id", "", "ID of the workflow whose history is fetched from Temporal")
+	runID := flags.String("run-id", "", "run of the workflow; the latest run when empty")
+	historyFile := flags.String("history", "", "JSON history file to replay instead of fetching one")
+	saveFile := flags.String("save", "", "file to save the fetched history to, e.g. as a golden history")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of fetching the history")
+	verbose := flags.Bool("v", false, "log the workflows' replay")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if (*workflowID == "") == (*historyFile == "") {
+		return errors.New("exactly one of -workflow-id and -history is required")
+	}
+
+	logger := zap.NewNop()
+	if *verbose {
+		logger, _ = zap.NewDevelopment()
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	var history *historypb.History
+	if *historyFile != "" {
+		history, err = temporal.LoadHistory(*historyFile)
+	} else {
+		history, err = fetchHistory(cfg, logger, *workflowID, *runID, *timeout)
+	}
+	if err != nil {
+		return err
+	}
+	if *saveFile != "" {
+		if err := temporal.SaveHistory(*saveFile, history); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Saved %d events to %s\n", len(history.Events), *saveFile)
+	}
+
+	replayer, err := temporal.NewReplayer(cfg.Temporal.PayloadCodec, logger)
+	if err != nil {
+		return err
+	}
+	divergence, err := replayer.Replay(history)
+	if divergence != nil {
+		fmt.Fprintf(out, "Nondeterminism at event %d (%s)\n", divergence.EventID, divergence.EventType)
+		fmt.Fprintf(out, "  %s\n", divergence.Explanation)
+		fmt.Fprintf(out, "  SDK: %s\n", divergence.Detail)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Replayed %d events without divergence\n", len(history.Events))
+	return nil
+}
+
+func fetchHistory(cfg *config.Config, logger *zap.Logger, workflowID, runID string, timeout time.Duration) (*historypb.History, error) {
+	c, err := temporal.NewClient(&cfg.Temporal, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return temporal.FetchHistory(ctx, c, workflowID, runID)
+}
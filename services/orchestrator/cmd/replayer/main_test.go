@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_ReplaysHistoryFile(t *testing.T) {
+	var out bytes.Buffer
+	err := run([]string{"-history", "../../internal/temporal/testdata/task_execution_history.json"}, &out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "without divergence")
+}
+
+func TestRun_RequiresOneSource(t *testing.T) {
+	var out bytes.Buffer
+	assert.Error(t, run(nil, &out))
+	assert.Error(t, run([]string{"-workflow-id", "wf-1", "-history", "history.json"}, &out))
+}
@@ -0,0 +1,719 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	sdktemporal "go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/api"
+	"orchestrator/internal/config"
+	"orchestrator/internal/database"
+	"orchestrator/internal/encryption"
+	"orchestrator/internal/errorsig"
+	"orchestrator/internal/events"
+	"orchestrator/internal/redact"
+	"orchestrator/internal/services"
+	"orchestrator/internal/temporal"
+)
+
+// App holds the fully initialized orchestrator components
+type App struct {
+	DB              *gorm.DB
+	Redis           *redis.Client
+	RedisGuard      *services.RedisGuard
+	EventQueue      *services.WorkflowEventQueue
+	Events          *events.Registry
+	Redactor        *redact.Redactor
+	IntentClient    *services.IntentClient
+	AgentClient     *services.AgentClient
+	TemporalWorker  *temporal.Worker
+	WorkflowEngine  *services.WorkflowEngine
+	WorkflowMonitor *services.WorkflowMonitor
+	Reconciler      *services.WorkflowReconciler
+	TaskQueues      *services.TaskQueueMonitor
+	ProjectActivity *services.ProjectActivityTracker
+	Storage         *services.StorageAccountant
+	Scheduler       *services.FairShareScheduler
+	CapabilityDrift *services.CapabilityDriftDetector // Nil unless capability drift detection is enabled
+	AgentGC         *services.AgentGarbageCollector
+	AgentPools      *services.PoolManager // Nil unless warm pools are configured
+	LogRelay        *services.LogRelay
+	Faults          *services.FaultInjector // Nil unless fault injection is enabled
+	Maintenance     *services.MaintenanceMode
+	Outputs         *services.OutputPolicy // Nil unless output offloading is enabled
+	Artifacts       *services.ArtifactService
+	Results         *services.ResultCompactor // Nil unless result compaction is enabled and artifact storage configured
+	Usage           *services.UsageMeter      // Nil unless usage metering is enabled
+	Metrics         *services.MetricsRecorder // Nil unless execution metrics are enabled
+	Templates       *services.TemplateCatalog
+	Handlers        *api.Handlers
+	Encryptor       *encryption.Encryptor
+}
+
+// Close releases the clients held by the app
+func (a *App) Close() {
+	if a.IntentClient != nil {
+		a.IntentClient.Close()
+	}
+	if a.AgentClient != nil {
+		a.AgentClient.Close()
+	}
+	if a.Redis != nil {
+		a.Redis.Close()
+	}
+}
+
+// Dependencies are the factories used to connect to external systems.
+// Tests replace them with fakes.
+type Dependencies struct {
+	ConnectDB         func(cfg *config.Config) (*gorm.DB, error)
+	ConnectRedis      func(ctx context.Context, cfg *config.Config) (*redis.Client, error)
+	NewIntentClient   func(cfg *config.Config, logger *zap.Logger) (*services.IntentClient, error)
+	NewAgentClient    func(cfg *config.Config, logger *zap.Logger) (*services.AgentClient, error)
+	NewTemporalWorker func(cfg *config.Config, db *gorm.DB, logger *zap.Logger, intentClient *services.IntentClient, agentClient *services.AgentClient) (*temporal.Worker, error)
+}
+
+// defaultDependencies returns the production dependency factories
+func defaultDependencies() Dependencies {
+	return Dependencies{
+		ConnectDB: func(cfg *config.Config) (*gorm.DB, error) {
+			db, err := database.Connect(cfg.Database.URL)
+			if err != nil {
+				return nil, err
+			}
+			// Without auto migration the schema is migrated with --migrate
+			// before the service is rolled out
+			if cfg.Database.EnableAutoMigration {
+				if err := database.Migrate(db); err != nil {
+					if sqlDB, dbErr := db.DB(); dbErr == nil {
+						sqlDB.Close()
+					}
+					return nil, fmt.Errorf("failed to run migrations: %w", err)
+				}
+			}
+			return db, nil
+		},
+		ConnectRedis: func(ctx context.Context, cfg *config.Config) (*redis.Client, error) {
+			redisClient := redis.NewClient(&redis.Options{
+				Addr:         cfg.Redis.Addr,
+				Password:     cfg.Redis.Password,
+				DB:           cfg.Redis.DB,
+				MaxRetries:   cfg.Redis.MaxRetries,
+				MinIdleConns: cfg.Redis.MinIdleConns,
+				MaxIdleConns: cfg.Redis.MaxActiveConns,
+				PoolTimeout:  time.Duration(cfg.Redis.PoolTimeout) * time.Second,
+				ReadTimeout:  time.Duration(cfg.Redis.ReadTimeout) * time.Second,
+				WriteTimeout: time.Duration(cfg.Redis.WriteTimeout) * time.Second,
+			})
+
+			pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			if err := redisClient.Ping(pingCtx).Err(); err != nil {
+				redisClient.Close()
+				return nil, err
+			}
+			return redisClient, nil
+		},
+		NewIntentClient: func(cfg *config.Config, logger *zap.Logger) (*services.IntentClient, error) {
+			return services.NewIntentClient(&cfg.IntentAPI, logger)
+		},
+		NewAgentClient: func(cfg *config.Config, logger *zap.Logger) (*services.AgentClient, error) {
+			return services.NewAgentClient(&cfg.AgentManager, logger)
+		},
+		NewTemporalWorker: func(cfg *config.Config, db *gorm.DB, logger *zap.Logger, intentClient *services.IntentClient, agentClient *services.AgentClient) (*temporal.Worker, error) {
+			worker, err := temporal.NewWorker(&cfg.Temporal, db, logger, intentClient, agentClient)
+			if err != nil {
+				return nil, err
+			}
+			worker.SetAgentSelectionPolicy(services.NewAgentSelectionPolicy(&cfg.AgentManager))
+			return worker, nil
+		},
+	}
+}
+
+// BackoffConfig controls how long bootstrap waits for dependencies
+type BackoffConfig struct {
+	MaxWait        time.Duration // Total time budget for all dependencies
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// defaultBackoff returns the startup backoff derived from configuration
+func defaultBackoff(cfg *config.Config) BackoffConfig {
+	maxWait := time.Duration(cfg.Server.StartupTimeout) * time.Second
+	if maxWait <= 0 {
+		maxWait = 2 * time.Minute
+	}
+	return BackoffConfig{
+		MaxWait:        maxWait,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     15 * time.Second,
+	}
+}
+
+// bootstrap connects to every dependency, retrying with exponential backoff
+// until backoff.MaxWait elapses, and wires the application components.
+func bootstrap(ctx context.Context, cfg *config.Config, logger *zap.Logger, deps Dependencies, backoff BackoffConfig) (*App, error) {
+	ctx, cancel := context.WithTimeout(ctx, backoff.MaxWait)
+	defer cancel()
+
+	app := &App{}
+
+	if err := waitForDependency(ctx, logger, backoff, "database", func() (err error) {
+		app.DB, err = deps.ConnectDB(cfg)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// Encryption callbacks must be registered before any component writes
+	encryptor, err := setupEncryption(app.DB, cfg)
+	if err != nil {
+		return nil, err
+	}
+	app.Encryptor = encryptor
+
+	// Sensitive values are redacted from data leaving the orchestrator
+	app.Redactor, err = redact.New(cfg.Redaction.Fields, cfg.Redaction.Paths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction rules: %w", err)
+	}
+	redactors := services.NewProjectRedactors(app.DB, app.Redactor, logger)
+	sandbox := services.NewSandboxPolicies(app.DB, &cfg.Sandbox)
+
+	// Written errors are cleaned and classified, and get their signature for
+	// failure analysis
+	errorNormalizer, err := setupErrorSignatures(app.DB, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := waitForDependency(ctx, logger, backoff, "redis", func() (err error) {
+		app.Redis, err = deps.ConnectRedis(ctx, cfg)
+		return err
+	}); err != nil {
+		app.Close()
+		return nil, err
+	}
+	// Every user of the client falls back while Redis is unreachable
+	app.RedisGuard = services.RedisGuardFor(app.Redis, logger)
+	app.RedisGuard.Configure(&cfg.Redis)
+	// Consumers of the events published through the guard are watched
+	// against what is published
+	app.Events = events.NewRegistry(&cfg.Events, logger)
+	app.RedisGuard.SetEventRegistry(app.Events)
+	app.RedisGuard.SetRedactor(app.Redactor)
+	// Workflow events are published in the background, off the paths
+	// emitting them
+	app.EventQueue = services.NewWorkflowEventQueue(app.RedisGuard, &cfg.Redis, logger)
+
+	if err := waitForDependency(ctx, logger, backoff, "intent client", func() (err error) {
+		app.IntentClient, err = deps.NewIntentClient(cfg, logger)
+		return err
+	}); err != nil {
+		app.Close()
+		return nil, err
+	}
+
+	if err := waitForDependency(ctx, logger, backoff, "agent client", func() (err error) {
+		app.AgentClient, err = deps.NewAgentClient(cfg, logger)
+		return err
+	}); err != nil {
+		app.Close()
+		return nil, err
+	}
+
+	// Agent selection lists agents for every task; bursts share cached lists
+	if cfg.AgentManager.ListCacheTTL > 0 {
+		app.AgentClient.SetListCache(services.NewAgentListCache(
+			services.NewRedisAgentListStore(app.Redis),
+			&cfg.AgentManager,
+			logger,
+		))
+	}
+
+	if err := waitForDependency(ctx, logger, backoff, "temporal", func() (err error) {
+		app.TemporalWorker, err = deps.NewTemporalWorker(cfg, app.DB, logger, app.IntentClient, app.AgentClient)
+		return err
+	}); err != nil {
+		app.Close()
+		return nil, err
+	}
+	app.TemporalWorker.SetSpawnCoordinator(services.NewSpawnCoordinator(
+		services.NewRedisSpawnStore(app.Redis, services.NewAdvisoryLocker(app.DB)),
+		&cfg.AgentManager,
+		logger,
+	))
+	// Projects steer agent selection through the agents they prefer and
+	// exclude in their settings
+	agentPrefs := services.NewAgentPreferenceService(app.DB, app.AgentClient, &cfg.AgentManager)
+	app.TemporalWorker.SetAgentPreferences(agentPrefs)
+
+	// Secrets are only stored encrypted
+	var secrets services.SecretProvider
+	if app.Encryptor != nil {
+		secrets = services.NewDBSecretProvider(app.DB, app.Encryptor)
+	}
+	variables := services.NewVariableService(app.DB, secrets)
+	app.TemporalWorker.SetVariableService(variables)
+	app.TemporalWorker.SetEstimateRefiner(services.NewEstimateRefiner(app.DB, &cfg.Estimation, logger))
+	// Execution plans are validated when created and when workflows start
+	// from a template
+	plans := services.NewPlanValidator(&cfg.Plans)
+	app.TemporalWorker.SetPlanValidator(plans)
+	// Task results are scored for agent performance, by a reviewer agent
+	// when one is configured
+	var qualityScorer temporal.QualityScorer
+	if cfg.Quality.ReviewerAgentID != "" {
+		qualityScorer = temporal.NewReviewerQualityScorer(app.AgentClient, cfg.Quality.ReviewerAgentID)
+	}
+	qualityScores := services.NewTaskQualityTracker(app.DB)
+	app.TemporalWorker.SetQualityScoring(qualityScorer, qualityScores)
+	app.Storage = services.NewStorageAccountant(
+		app.DB,
+		services.NewRedisStorageUsageMirror(app.Redis),
+		&cfg.Storage,
+		logger,
+	)
+	app.TemporalWorker.SetStorageAccountant(app.Storage)
+
+	// Artifact contents and outputs too large to store inline share the
+	// artifact storage
+	var store services.OutputStore
+	if cfg.Outputs.StoreDir != "" {
+		store, err = services.NewFileOutputStore(cfg.Outputs.StoreDir)
+		if err != nil {
+			app.Close()
+			return nil, err
+		}
+	}
+	// Workflows pinned to a region keep their contents in its bucket
+	if len(cfg.Outputs.RegionBuckets) > 0 {
+		buckets := make(map[string]services.OutputStore, len(cfg.Outputs.RegionBuckets))
+		for region, dir := range cfg.Outputs.RegionBuckets {
+			if buckets[region], err = services.NewFileOutputStore(dir); err != nil {
+				app.Close()
+				return nil, err
+			}
+		}
+		store = services.NewRegionalOutputStore(buckets, store)
+	}
+	app.Artifacts = services.NewArtifactService(app.DB, store, &cfg.Artifacts, logger)
+	app.TemporalWorker.SetArtifactService(app.Artifacts)
+	app.TemporalWorker.SetRepositoryFetcher(services.NewGitFetcher(app.DB, secrets, &cfg.Repositories, logger))
+	analyzers, err := services.NewStaticAnalyzer(&cfg.StaticAnalysis, logger)
+	if err != nil {
+		app.Close()
+		return nil, err
+	}
+	app.TemporalWorker.SetStaticAnalyzer(analyzers)
+	app.TemporalWorker.SetSecurityAnalyzer(services.NewSecurityAnalyzer(&cfg.SecurityAnalysis, logger))
+	if cfg.AnalysisCache.Enabled {
+		app.TemporalWorker.SetAnalysisCache(services.NewAnalysisCache(app.DB, &cfg.AnalysisCache, logger))
+	}
+	app.TemporalWorker.SetSCMProviders(map[string]services.SCMProvider{
+		services.SCMProviderGitHub: services.NewGitHubProvider(app.DB, &cfg.SCM, logger),
+	})
+	// Pull request diffs are reviewed by an LLM when AI review is enabled
+	var aiReviewer *services.LLMReviewer
+	if cfg.AIReview.Enabled {
+		aiReviewer = services.NewLLMReviewer(&cfg.AIReview, secrets, logger)
+		app.TemporalWorker.SetAIReviewer(aiReviewer)
+	}
+	if cfg.Outputs.MaxInlineBytes > 0 {
+		app.Outputs = services.NewOutputPolicy(app.DB, store, &cfg.Outputs, logger)
+		app.Outputs.SetStorageAccountant(app.Storage)
+		app.TemporalWorker.SetOutputPolicy(app.Outputs)
+	}
+	// The task outputs of finished task execution workflows are moved to an
+	// archive in artifact storage
+	if cfg.Results.Enabled && store != nil {
+		app.Results = services.NewResultCompactor(app.DB, store, &cfg.Results, logger)
+		app.Results.SetStorageAccountant(app.Storage)
+	}
+	app.TemporalWorker.SetRiskGate(services.NewRiskGate(
+		app.DB,
+		&cfg.RiskPolicy,
+		services.NewRedisWorkflowEventPublisher(app.Redis),
+		logger,
+	))
+	app.TemporalWorker.SetBudgetEnforcer(services.NewBudgetEnforcer(
+		app.DB,
+		&cfg.Budget,
+		services.NewRedisWorkflowEventPublisher(app.Redis),
+		logger,
+	))
+	if cfg.AgentManager.CapabilityDrift.Enabled {
+		app.CapabilityDrift = services.NewCapabilityDriftDetector(
+			app.DB,
+			app.AgentClient,
+			services.NewRedisAgentEventPublisher(app.Redis),
+			&cfg.AgentManager.CapabilityDrift,
+			logger,
+		)
+		app.AgentClient.SetCapabilityDrift(app.CapabilityDrift)
+		app.TemporalWorker.SetCapabilityDriftDetector(app.CapabilityDrift)
+	}
+	app.AgentGC = services.NewAgentGarbageCollector(
+		app.DB,
+		app.AgentClient,
+		logger,
+		time.Duration(cfg.AgentManager.ConnectionSweepInterval)*time.Second,
+	)
+	app.TemporalWorker.SetAgentGarbageCollector(app.AgentGC)
+	provisioners := resourceProvisioners(cfg.Resources.Providers)
+	app.TemporalWorker.SetResourceProvisioners(provisioners)
+
+	// Create workflow engine with proper configuration
+	workflowConfig := &services.WorkflowConfig{
+		TaskQueue:               cfg.Temporal.TaskQueue,
+		MaxConcurrentWorkflows:  cfg.Temporal.MaxConcurrentWorkflows,
+		MaxConcurrentActivities: cfg.Temporal.MaxConcurrentActivities,
+		WorkflowTimeout:         30 * time.Minute,
+		ActivityTimeout:         5 * time.Minute,
+		RetryPolicy: &sdktemporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    3,
+		},
+		EnableMetrics:          cfg.Temporal.EnableMetrics,
+		EnableTracing:          cfg.Telemetry.EnableDistributedTracing,
+		EnableSearchAttributes: cfg.Temporal.EnableSearchAttributes || cfg.Temporal.RegisterSearchAttributes,
+	}
+
+	app.WorkflowEngine = services.NewWorkflowEngine(
+		app.DB,
+		app.Redis,
+		app.TemporalWorker.GetClient(),
+		logger,
+		app.IntentClient,
+		app.AgentClient,
+		workflowConfig,
+	)
+	app.WorkflowEngine.SetPlanValidator(plans)
+	app.WorkflowEngine.SetWorkflowTypes(app.TemporalWorker.WorkflowTypes().Specs())
+	app.WorkflowEngine.SetRedactors(redactors)
+	app.WorkflowEngine.SetSandboxPolicies(sandbox)
+	app.WorkflowEngine.SetDataResidency(services.NewDataResidency(app.DB, logger))
+	app.WorkflowEngine.SetEventQueue(app.EventQueue)
+
+	// Initialize workflow monitor
+	app.WorkflowMonitor = services.NewWorkflowMonitor(
+		app.DB,
+		app.TemporalWorker.GetClient(),
+		logger,
+		app.Redis,
+		5*time.Second, // Check every 5 seconds
+	)
+	app.WorkflowMonitor.EnableReaper(app.WorkflowEngine, services.ReaperConfig{
+		PendingThreshold:   time.Duration(cfg.Temporal.StalePendingThreshold) * time.Second,
+		MaxMissedDescribes: cfg.Temporal.MissingExecutionChecks,
+	})
+	app.WorkflowMonitor.EnableSLATracking(app.WorkflowEngine)
+	app.WorkflowMonitor.EnableStuckDetection(app.WorkflowEngine, cfg.Temporal.StuckTaskFailures)
+	app.WorkflowMonitor.EnableDraftRetention(time.Duration(cfg.Temporal.DraftRetention) * time.Second)
+	app.WorkflowMonitor.EnableCheckpointRetention(time.Duration(cfg.Temporal.CheckpointRetention) * time.Second)
+	app.WorkflowMonitor.EnableAgentCleanup(app.AgentGC)
+	app.Reconciler = services.NewWorkflowReconciler(
+		app.WorkflowMonitor,
+		app.WorkflowEngine,
+		services.NewRedisReconcileCheckpointStore(app.Redis),
+		&cfg.Temporal.Reconcile,
+	)
+	app.TaskQueues = services.NewTaskQueueMonitor(
+		app.DB,
+		app.TemporalWorker.GetClient(),
+		app.TemporalWorker.WorkflowTypes().Specs(),
+		&cfg.Temporal,
+		logger,
+	)
+
+	app.ProjectActivity = services.NewProjectActivityTracker(
+		app.DB,
+		logger,
+		time.Duration(cfg.Database.ActivityFlushInterval)*time.Second,
+	)
+	app.WorkflowEngine.SetActivityTracker(app.ProjectActivity)
+
+	app.Scheduler = services.NewFairShareScheduler(app.DB, app.WorkflowEngine, &cfg.Scheduling, logger)
+	app.WorkflowEngine.SetScheduler(app.Scheduler)
+
+	// The maintenance flag is kept in Redis so every replica agrees on it
+	app.Maintenance = services.NewMaintenanceMode(services.NewRedisMaintenanceStore(app.Redis), &cfg.Maintenance, logger)
+	app.WorkflowEngine.SetMaintenanceMode(app.Maintenance)
+
+	projectService := services.NewProjectService(app.DB, logger)
+	projectService.SetActivityTracker(app.ProjectActivity)
+	projectService.SetErrorNormalizer(errorNormalizer)
+	projectService.SetStorageAccountant(app.Storage)
+	projectService.SetStatsCache(services.NewRedisProjectStatsCache(app.Redis), time.Duration(cfg.Projects.StatsCacheTTL)*time.Second)
+	projectService.SetStatsBatchLimit(cfg.Projects.StatsBatchLimit)
+	app.Handlers = api.NewHandlers(app.WorkflowEngine, projectService, app.AgentClient, logger, app.DB)
+	if app.Encryptor != nil {
+		app.Handlers.SetEncryptor(app.Encryptor)
+	}
+	app.Handlers.SetVariableService(variables)
+	app.Handlers.SetWorkerController(app.TemporalWorker)
+
+	// An incompatible agent manager degrades health; in strict mode it also
+	// stops workflows that need agents from starting
+	app.Handlers.SetAgentCompatibility(app.AgentClient)
+	app.Handlers.SetRedisHealth(app.RedisGuard)
+	app.Handlers.SetTaskQualityTracker(qualityScores)
+
+	// Template usage is recomputed periodically for the catalog
+	app.Templates = services.NewTemplateCatalog(app.DB, logger, time.Duration(cfg.Templates.StatsInterval)*time.Second)
+	app.Handlers.SetTemplateCatalog(app.Templates)
+	if cfg.AgentManager.VersionPolicy == "strict" {
+		app.WorkflowEngine.SetAgentCompatibility(app.AgentClient)
+	}
+	app.Handlers.SetResourceService(services.NewResourceService(
+		app.DB,
+		app.TemporalWorker.GetClient(),
+		cfg.Temporal.TaskQueue,
+		provisioners,
+		&cfg.Resources,
+		logger,
+	))
+
+	// Promotions run as workflows, which the worker applies
+	promotions := services.NewEnvironmentPromoter(app.DB, app.WorkflowEngine, logger)
+	app.TemporalWorker.SetEnvironmentPromoter(promotions)
+	app.Handlers.SetEnvironmentPromoter(promotions)
+
+	// Agents stream task logs over their WebSocket connections
+	app.LogRelay = services.NewLogRelay(
+		services.NewRedisLogStream(app.Redis, int64(cfg.AgentManager.LogStreamMaxLen)),
+		services.LogRelayConfig{BufferSize: cfg.AgentManager.LogBufferSize},
+		logger,
+	)
+	app.LogRelay.SetEventRegistry(app.Events)
+	app.AgentClient.RegisterHandler(services.TaskLogMessageType, app.LogRelay.HandleMessage)
+	app.Handlers.SetLogRelay(app.LogRelay)
+	app.Handlers.SetEventRegistry(app.Events)
+	app.Handlers.SetRedactors(redactors)
+	app.Handlers.SetSandboxPolicies(sandbox)
+	app.Handlers.SetAgentPreferences(agentPrefs)
+	app.Handlers.SetShareService(services.NewWorkflowShareService(
+		app.DB,
+		services.NewRedisShareTokenStore(app.Redis),
+		&cfg.Share,
+		logger,
+	))
+	app.Handlers.SetCommentService(services.NewWorkflowCommentService(app.DB, logger))
+	app.Handlers.SetMaintenanceMode(app.Maintenance)
+	app.Handlers.SetWorkflowReconciler(app.Reconciler)
+	app.Handlers.SetTaskQueueMonitor(app.TaskQueues)
+	app.Handlers.SetOutputPolicy(app.Outputs)
+	app.Handlers.SetArtifactService(app.Artifacts)
+	app.Handlers.SetResultCompactor(app.Results)
+
+	// Warm pools keep idle agents ready for latency-sensitive task types
+	if len(cfg.AgentManager.WarmPools) > 0 {
+		app.AgentPools = services.NewPoolManager(app.AgentClient, &cfg.AgentManager, logger)
+		app.TemporalWorker.SetAgentPools(app.AgentPools)
+		app.Handlers.SetAgentPools(app.AgentPools)
+	}
+
+	// Usage counters are kept in Redis so every replica adds to the same
+	// counters, and rolled up into the database
+	if cfg.Usage.Enabled {
+		app.Usage = services.NewUsageMeter(app.DB, services.NewRedisUsageCounterStore(app.Redis), &cfg.Usage, logger)
+		app.WorkflowEngine.SetUsageMeter(app.Usage)
+		app.AgentClient.SetUsageMeter(app.Usage)
+		app.Storage.SetUsageMeter(app.Usage)
+		if aiReviewer != nil {
+			aiReviewer.SetUsageMeter(app.Usage)
+		}
+		app.Handlers.SetUsageMeter(app.Usage)
+	}
+
+	// Execution metrics are buffered and written to the metrics table in
+	// batches; agents report resource usage over their WebSocket connections
+	if cfg.ExecutionMetrics.Enabled {
+		app.Metrics = services.NewMetricsRecorder(app.DB, &cfg.ExecutionMetrics, logger)
+		app.TemporalWorker.SetMetricsRecorder(app.Metrics)
+		app.AgentClient.RegisterHandler(services.ResourceUsageMessageType, app.Metrics.HandleMessage)
+		app.Handlers.SetMetricsRecorder(app.Metrics)
+	}
+
+	// Fault rules are kept in Redis so every replica applies them
+	if cfg.FaultInjection.Enabled {
+		app.Faults = services.NewFaultInjector(services.NewRedisFaultRuleStore(app.Redis), &cfg.FaultInjection, logger)
+		app.TemporalWorker.SetFaultInjector(app.Faults)
+		app.Handlers.SetFaultInjector(app.Faults)
+		logger.Warn("Fault injection is enabled")
+	}
+
+	return app, nil
+}
+
+// resourceProvisioners returns the provisioners of the enabled providers
+func resourceProvisioners(providers []string) map[string]services.ResourceProvisioner {
+	provisioners := make(map[string]services.ResourceProvisioner)
+	for _, provider := range providers {
+		switch provider {
+		case services.ResourceProviderLocal:
+			provisioners[provider] = services.NewLocalProvisioner()
+		case services.ResourceProviderDocker:
+			provisioners[provider] = services.NewDockerProvisioner()
+		}
+	}
+	return provisioners
+}
+
+// setupEncryption registers the field encryption callbacks when encryption at
+// rest is enabled. It returns a nil encryptor when it is disabled.
+func setupEncryption(db *gorm.DB, cfg *config.Config) (*encryption.Encryptor, error) {
+	if !cfg.Encryption.Enabled {
+		return nil, nil
+	}
+
+	keys := map[string]string{cfg.Encryption.MasterKeyID: cfg.Encryption.MasterKey}
+	for id, key := range cfg.Encryption.PreviousKeys {
+		if id != cfg.Encryption.MasterKeyID {
+			keys[id] = key
+		}
+	}
+
+	provider, err := encryption.NewLocalKeyProviderFromBase64(cfg.Encryption.MasterKeyID, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+
+	encryptor := encryption.NewEncryptor(provider)
+	policy := encryption.NewSettingsPolicy(time.Duration(cfg.Encryption.SettingsCacheTTL) * time.Second)
+	if err := encryption.Register(db, encryptor, policy); err != nil {
+		return nil, fmt.Errorf("failed to register encryption callbacks: %w", err)
+	}
+	return encryptor, nil
+}
+
+// setupErrorSignatures registers the callbacks cleaning and classifying
+// written errors, archiving the full text of execution errors, and storing
+// their signature, normalized with the configured rules
+func setupErrorSignatures(db *gorm.DB, cfg *config.Config, logger *zap.Logger) (*errorsig.Normalizer, error) {
+	rules := make([]errorsig.Rule, 0, len(cfg.Failures.Normalizers))
+	for _, normalizer := range cfg.Failures.Normalizers {
+		rules = append(rules, errorsig.Rule{Pattern: normalizer.Pattern, Replacement: normalizer.Replacement})
+	}
+	normalizer, err := errorsig.NewNormalizer(rules...)
+	if err != nil {
+		return nil, err
+	}
+	if err := errorsig.Register(db, normalizer); err != nil {
+		return nil, fmt.Errorf("failed to register error signature callbacks: %w", err)
+	}
+	cleaner := errorsig.NewCleaner(cfg.Failures.MaxErrorLength)
+	if err := errorsig.RegisterCleaner(db, cleaner, services.ExecutionErrorArchiver(logger)); err != nil {
+		return nil, fmt.Errorf("failed to register error cleaning callbacks: %w", err)
+	}
+	return normalizer, nil
+}
+
+// waitForDependency calls connect until it succeeds or ctx expires, doubling
+// the delay between attempts up to backoff.MaxBackoff.
+func waitForDependency(ctx context.Context, logger *zap.Logger, backoff BackoffConfig, name string, connect func() error) error {
+	delay := backoff.InitialBackoff
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		err := connect()
+		if err == nil {
+			if attempt > 1 {
+				logger.Info("Dependency became available",
+					zap.String("dependency", name),
+					zap.Int("attempts", attempt),
+					zap.Duration("waited", time.Since(start)),
+				)
+			}
+			return nil
+		}
+
+		logger.Warn("Dependency not available, retrying",
+			zap.String("dependency", name),
+			zap.Int("attempt", attempt),
+			zap.Duration("retry_in", delay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for %s after %d attempts: %w", name, attempt, err)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > backoff.MaxBackoff {
+			delay = backoff.MaxBackoff
+		}
+	}
+}
+
+// startupState tracks whether the service has finished bootstrapping
+type startupState struct {
+	ready atomic.Bool
+}
+
+// startupRouter serves probes while dependencies are still being connected
+func startupRouter(state *startupState) *gin.Engine {
+	router := gin.New()
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "starting"})
+	})
+	router.GET("/ready", readyHandler(state))
+	return router
+}
+
+// WarmupSteps are the warmup steps of the paths the first requests take:
+// database connections and statements, the Redis pool, the Temporal
+// connection, and workflow config and request validation
+func (a *App) WarmupSteps(cfg *config.WarmupConfig) []services.WarmupStep {
+	return []services.WarmupStep{
+		services.DatabaseWarmupStep(a.DB, cfg.DatabaseConnections, services.WarmupModels...),
+		services.RedisWarmupStep(a.Redis),
+		services.TemporalWarmupStep(a.TemporalWorker.GetClient()),
+		services.WorkflowConfigWarmupStep(),
+		a.Handlers.WarmupStep(),
+	}
+}
+
+// readyHandler reports 503 until bootstrap has completed
+func readyHandler(state *startupState) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !state.ready.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
+
+// swappableHandler lets the HTTP server start before the full router exists
+type swappableHandler struct {
+	mu      sync.RWMutex
+	handler http.Handler
+}
+
+func (s *swappableHandler) Set(handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+func (s *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	handler := s.handler
+	s.mu.RUnlock()
+	handler.ServeHTTP(w, r)
+}
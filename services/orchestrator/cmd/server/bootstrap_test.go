@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/services"
+	"orchestrator/internal/temporal"
+)
+
+// fakeDependencies returns dependency factories that fail the given number
+// of times before succeeding, counting every attempt.
+func fakeDependencies(t *testing.T, failures map[string]int, attempts map[string]int) Dependencies {
+	try := func(name string) error {
+		attempts[name]++
+		if attempts[name] <= failures[name] {
+			return errors.New(name + " unavailable")
+		}
+		return nil
+	}
+
+	return Dependencies{
+		ConnectDB: func(cfg *config.Config) (*gorm.DB, error) {
+			if err := try("database"); err != nil {
+				return nil, err
+			}
+			db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+			require.NoError(t, err)
+			return db, nil
+		},
+		ConnectRedis: func(ctx context.Context, cfg *config.Config) (*redis.Client, error) {
+			if err := try("redis"); err != nil {
+				return nil, err
+			}
+			return redis.NewClient(&redis.Options{Addr: "localhost:0"}), nil
+		},
+		NewIntentClient: func(cfg *config.Config, logger *zap.Logger) (*services.IntentClient, error) {
+			if err := try("intent client"); err != nil {
+				return nil, err
+			}
+			return &services.IntentClient{}, nil
+		},
+		NewAgentClient: func(cfg *config.Config, logger *zap.Logger) (*services.AgentClient, error) {
+			if err := try("agent client"); err != nil {
+				return nil, err
+			}
+			return &services.AgentClient{}, nil
+		},
+		NewTemporalWorker: func(cfg *config.Config, db *gorm.DB, logger *zap.Logger, intentClient *services.IntentClient, agentClient *services.AgentClient) (*temporal.Worker, error) {
+			if err := try("temporal"); err != nil {
+				return nil, err
+			}
+			return &temporal.Worker{}, nil
+		},
+	}
+}
+
+func testBackoff(maxWait time.Duration) BackoffConfig {
+	return BackoffConfig{
+		MaxWait:        maxWait,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+	}
+}
+
+func TestBootstrap_RetriesUntilDependenciesAreAvailable(t *testing.T) {
+	failures := map[string]int{"database": 2, "redis": 1, "temporal": 3}
+	attempts := map[string]int{}
+
+	app, err := bootstrap(context.Background(), &config.Config{}, zap.NewNop(),
+		fakeDependencies(t, failures, attempts), testBackoff(5*time.Second))
+	require.NoError(t, err)
+
+	assert.NotNil(t, app.DB)
+	assert.NotNil(t, app.Redis)
+	assert.NotNil(t, app.WorkflowEngine)
+	assert.NotNil(t, app.WorkflowMonitor)
+	assert.NotNil(t, app.Handlers)
+	assert.Nil(t, app.Faults)
+	assert.Equal(t, map[string]int{
+		"database":      3,
+		"redis":         2,
+		"intent client": 1,
+		"agent client":  1,
+		"temporal":      4,
+	}, attempts)
+}
+
+func TestBootstrap_EnablesFaultInjection(t *testing.T) {
+	cfg := &config.Config{FaultInjection: config.FaultInjectionConfig{Enabled: true, DefaultTTL: 300, MaxTTL: 3600, RefreshInterval: 1}}
+
+	app, err := bootstrap(context.Background(), cfg, zap.NewNop(),
+		fakeDependencies(t, map[string]int{}, map[string]int{}), testBackoff(time.Second))
+	require.NoError(t, err)
+	assert.NotNil(t, app.Faults)
+}
+
+func TestBootstrap_GivesUpAfterMaxWait(t *testing.T) {
+	failures := map[string]int{"temporal": 1 << 30}
+	attempts := map[string]int{}
+
+	start := time.Now()
+	_, err := bootstrap(context.Background(), &config.Config{}, zap.NewNop(),
+		fakeDependencies(t, failures, attempts), testBackoff(50*time.Millisecond))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "temporal")
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Greater(t, attempts["temporal"], 1)
+}
+
+func TestBootstrap_StopsWhenContextCancelled(t *testing.T) {
+	failures := map[string]int{"database": 1 << 30}
+	attempts := map[string]int{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := bootstrap(ctx, &config.Config{}, zap.NewNop(),
+		fakeDependencies(t, failures, attempts), testBackoff(time.Minute))
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "database")
+	assert.Equal(t, 1, attempts["database"])
+}
+
+func TestWaitForDependency_BackoffIsCapped(t *testing.T) {
+	var calls []time.Time
+	backoff := BackoffConfig{InitialBackoff: 2 * time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+
+	err := waitForDependency(context.Background(), zap.NewNop(), backoff, "flaky", func() error {
+		calls = append(calls, time.Now())
+		if len(calls) < 5 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Len(t, calls, 5)
+	// Delays are 2ms, 4ms, 4ms, 4ms; the last gap must not have kept doubling
+	assert.Less(t, calls[4].Sub(calls[3]), 50*time.Millisecond)
+}
+
+func TestStartupProbes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	state := &startupState{}
+	handler := &swappableHandler{handler: startupRouter(state)}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// While starting: alive but not ready
+	assert.Equal(t, http.StatusOK, get("/health").Code)
+	assert.Contains(t, get("/health").Body.String(), "starting")
+	assert.Equal(t, http.StatusServiceUnavailable, get("/ready").Code)
+
+	// Once bootstrap completes the full router takes over
+	router := gin.New()
+	router.GET("/ready", readyHandler(state))
+	handler.Set(router)
+	state.ready.Store(true)
+
+	assert.Equal(t, http.StatusOK, get("/ready").Code)
+}
+
+func TestSetupEncryption(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	// Disabled by default
+	encryptor, err := setupEncryption(db, &config.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, encryptor)
+
+	cfg := &config.Config{Encryption: config.EncryptionConfig{
+		Enabled:      true,
+		MasterKeyID:  "k2",
+		MasterKey:    base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+		PreviousKeys: map[string]string{"k1": base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+	}}
+	encryptor, err = setupEncryption(db, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", encryptor.CurrentKeyID())
+
+	cfg.Encryption.MasterKey = base64.StdEncoding.EncodeToString([]byte("too short"))
+	_, err = setupEncryption(db, cfg)
+	assert.ErrorContains(t, err, "32 bytes")
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,32 +12,49 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/redis/go-redis/v9"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/jaeger"
 	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
-	sdktemporal "go.temporal.io/sdk/temporal"
 	"go.uber.org/zap"
 
 	"orchestrator/internal/api"
 	"orchestrator/internal/config"
-	"orchestrator/internal/database"
 	"orchestrator/internal/middleware"
+	"orchestrator/internal/redact"
 	"orchestrator/internal/services"
-	"orchestrator/internal/temporal"
 )
 
 func main() {
+	migrate := flag.Bool("migrate", false, "apply the pending database migrations and exit")
+	migrateStatus := flag.Bool("migrate-status", false, "list the database migrations with when they were applied and exit")
+	flag.Parse()
+
 	// Initialize logger
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
 
+	if *migrate || *migrateStatus {
+		if err := runMigrations(os.Stdout, *migrate, *migrateStatus); err != nil {
+			logger.Fatal("Database migration failed", zap.Error(err))
+		}
+		return
+	}
+
+	// run returns only after its deferred cleanup has completed
+	if err := run(logger); err != nil {
+		logger.Fatal("Orchestrator stopped", zap.Error(err))
+	}
+}
+
+// run starts the service and blocks until it is asked to shut down or a
+// background component fails
+func run(logger *zap.Logger) error {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		logger.Fatal("Failed to load configuration", zap.Error(err))
+		return fmt.Errorf("failed to load configuration: %w", err)
 	}
 
 	// Initialize telemetry
@@ -49,139 +67,161 @@ func main() {
 		}
 	}
 
-	// Initialize database
-	db, err := database.Connect(cfg.Database.URL)
-	if err != nil {
-		logger.Fatal("Failed to connect to database", zap.Error(err))
-	}
-
-	// Initialize Redis
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:         cfg.Redis.Addr,
-		Password:     cfg.Redis.Password,
-		DB:           cfg.Redis.DB,
-		MaxRetries:   cfg.Redis.MaxRetries,
-		MinIdleConns: cfg.Redis.MinIdleConns,
-		MaxIdleConns: cfg.Redis.MaxActiveConns,
-		PoolTimeout:  time.Duration(cfg.Redis.PoolTimeout) * time.Second,
-		ReadTimeout:  time.Duration(cfg.Redis.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Redis.WriteTimeout) * time.Second,
-	})
+	// Stop waiting for dependencies as soon as we are asked to shut down
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Test Redis connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		logger.Fatal("Failed to connect to Redis", zap.Error(err))
+	// Start the HTTP server first so probes are answered while dependencies come up
+	state := &startupState{}
+	handler := &swappableHandler{handler: startupRouter(state)}
+	srv := &http.Server{
+		Addr:         fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
+		Handler:      handler,
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
+		IdleTimeout:  120 * time.Second,
 	}
 
-	// Initialize clients
-	intentClient, err := services.NewIntentClient(&cfg.IntentAPI, logger)
+	// Background failures end run instead of exiting the process directly,
+	// so deferred cleanup still happens
+	serverErrs := make(chan error, 1)
+	go func() {
+		logger.Info("Starting Orchestrator service",
+			zap.String("host", cfg.Server.Host),
+			zap.String("port", cfg.Server.Port),
+			zap.String("environment", cfg.Telemetry.Environment),
+		)
+		
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serverErrs <- fmt.Errorf("failed to start server: %w", err)
+		}
+	}()
+
+	// Connect to dependencies with bounded retry
+	app, err := bootstrap(ctx, cfg, logger, defaultDependencies(), defaultBackoff(cfg))
 	if err != nil {
-		logger.Fatal("Failed to create intent client", zap.Error(err))
+		return fmt.Errorf("failed to initialize dependencies: %w", err)
 	}
-	defer intentClient.Close()
+	defer app.Close()
 
-	agentClient, err := services.NewAgentClient(&cfg.AgentManager, logger)
-	if err != nil {
-		logger.Fatal("Failed to create agent client", zap.Error(err))
+	// Negotiate the agent manager's API version before accepting workflows,
+	// then follow its upgrades
+	if _, err := app.AgentClient.NegotiateVersion(ctx); err != nil {
+		logger.Warn("Failed to negotiate agent manager version", zap.Error(err))
 	}
-	defer agentClient.Close()
+	app.AgentClient.StartVersionChecks(time.Duration(cfg.AgentManager.VersionCheckInterval) * time.Second)
 
-	// Initialize Temporal worker
-	temporalWorker, err := temporal.NewWorker(&cfg.Temporal, db, logger, intentClient, agentClient)
-	if err != nil {
-		logger.Fatal("Failed to create Temporal worker", zap.Error(err))
+	// Started before the worker so samples of activities still finishing
+	// at shutdown are written
+	if app.Metrics != nil {
+		app.Metrics.Start()
+		defer app.Metrics.Stop()
 	}
 
-	// Start Temporal worker in background
-	go func() {
-		if err := temporalWorker.Start(); err != nil {
-			logger.Fatal("Failed to start Temporal worker", zap.Error(err))
-		}
-	}()
-	defer temporalWorker.Stop()
+	// Start Temporal worker
+	if err := app.TemporalWorker.Start(); err != nil {
+		return fmt.Errorf("failed to start Temporal worker: %w", err)
+	}
+	defer app.TemporalWorker.Stop()
+
+	app.RedisGuard.Start()
+	defer app.RedisGuard.Stop()
+
+	// Stopped before the guard, so the events still queued are published
+	// or buffered by it
+	app.EventQueue.Start()
+	defer app.EventQueue.Stop()
+
+	app.WorkflowMonitor.Start()
+	defer app.WorkflowMonitor.Stop()
+
+	app.TaskQueues.Start()
+	defer app.TaskQueues.Stop()
+
+	// Correct workflows whose executions closed, or started, while no
+	// replica was watching; an interrupted pass resumes
+	if cfg.Temporal.Reconcile.OnStartup {
+		go func() {
+			if _, err := app.Reconciler.Reconcile(ctx, services.ReconcileOptions{}); err != nil {
+				logger.Warn("Startup workflow reconciliation did not complete", zap.Error(err))
+			}
+		}()
+	}
 
-	// Initialize services
-	projectService := services.NewProjectService(db, logger)
-	
-	// Create workflow engine with proper configuration
-	workflowConfig := &services.WorkflowConfig{
-		TaskQueue:               cfg.Temporal.TaskQueue,
-		MaxConcurrentWorkflows:  cfg.Temporal.MaxConcurrentWorkflows,
-		MaxConcurrentActivities: cfg.Temporal.MaxConcurrentActivities,
-		WorkflowTimeout:         30 * time.Minute,
-		ActivityTimeout:         5 * time.Minute,
-		RetryPolicy: &sdktemporal.RetryPolicy{
-			InitialInterval:    time.Second,
-			BackoffCoefficient: 2.0,
-			MaximumInterval:    time.Minute,
-			MaximumAttempts:    3,
-		},
-		EnableMetrics: cfg.Temporal.EnableMetrics,
-		EnableTracing: cfg.Telemetry.EnableDistributedTracing,
+	app.ProjectActivity.Start()
+	defer app.ProjectActivity.Stop()
+
+	app.Storage.Start()
+	defer app.Storage.Stop()
+
+	if app.Results != nil {
+		app.Results.Start()
+		defer app.Results.Stop()
 	}
-	
-	workflowEngine := services.NewWorkflowEngine(
-		db,
-		redisClient,
-		temporalWorker.GetClient(),
-		logger,
-		intentClient,
-		agentClient,
-		workflowConfig,
-	)
 
-	// Initialize workflow monitor
-	workflowMonitor := services.NewWorkflowMonitor(
-		db,
-		temporalWorker.GetClient(),
-		logger,
-		redisClient,
-		5*time.Second, // Check every 5 seconds
-	)
-	workflowMonitor.Start()
-	defer workflowMonitor.Stop()
+	app.Templates.Start()
+	defer app.Templates.Stop()
 
-	// Initialize handlers
-	handlers := api.NewHandlers(workflowEngine, projectService, agentClient, logger, db)
+	app.Scheduler.Start()
+	defer app.Scheduler.Stop()
+	config.Watch(func(reloaded *config.Config) {
+		app.Scheduler.Configure(&reloaded.Scheduling)
+	}, func(err error) {
+		logger.Warn("Ignoring config file change", zap.Error(err))
+	})
+
+	if app.CapabilityDrift != nil {
+		app.CapabilityDrift.Start()
+		defer app.CapabilityDrift.Stop()
+	}
+
+	app.LogRelay.Start()
+	defer app.LogRelay.Stop()
+
+	app.Events.Start()
+	defer app.Events.Stop()
+
+	app.AgentGC.Start()
+	defer app.AgentGC.Stop()
+
+	if app.AgentPools != nil {
+		app.AgentPools.Start()
+		defer app.AgentPools.Stop()
+	}
+
+	if app.Usage != nil {
+		app.Usage.Start()
+		defer app.Usage.Stop()
+	}
+
+	// Warm the paths of the first requests before reporting ready, so they
+	// do not pay for it after a rollout; failed steps only leave theirs cold
+	if cfg.Server.Warmup.Enabled {
+		services.RunWarmup(ctx, app.WarmupSteps(&cfg.Server.Warmup), time.Duration(cfg.Server.Warmup.Timeout)*time.Second, logger)
+	}
+
+	// Background jobs started by requests stop with the server
+	app.Handlers.SetJobContext(ctx)
+
+	// Switch to the full router and report ready
+	handler.Set(setupRouter(app.Handlers, cfg, logger, app.Redactor, state, app.Faults, app.Maintenance, app.Usage))
+	state.ready.Store(true)
+	logger.Info("Orchestrator ready")
 
-	// Setup routers
-	router := setupRouter(handlers, cfg, logger)
-	
 	// Start metrics server if enabled
 	if cfg.Server.EnableMetrics {
 		go startMetricsServer(cfg.Server.MetricsPort, logger)
 	}
 
-	// Start main server
-	srv := &http.Server{
-		Addr:         fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
-		Handler:      router,
-		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
-		IdleTimeout:  120 * time.Second,
+	// Wait for interrupt signal or a background failure
+	var runErr error
+	select {
+	case <-ctx.Done():
+	case runErr = <-serverErrs:
+	case err := <-app.TemporalWorker.Errors():
+		runErr = fmt.Errorf("temporal worker failed: %w", err)
 	}
 
-	// Graceful shutdown
-	go func() {
-		logger.Info("Starting Orchestrator service",
-			zap.String("host", cfg.Server.Host),
-			zap.String("port", cfg.Server.Port),
-			zap.String("environment", cfg.Telemetry.Environment),
-		)
-		
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("Failed to start server", zap.Error(err))
-		}
-	}()
-
-	// Wait for interrupt signal to gracefully shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
 	logger.Info("Shutting down server...")
 
 	// Graceful shutdown with timeout
@@ -196,9 +236,10 @@ func main() {
 	}
 
 	logger.Info("Server exited")
+	return runErr
 }
 
-func setupRouter(h *api.Handlers, cfg *config.Config, logger *zap.Logger) *gin.Engine {
+func setupRouter(h *api.Handlers, cfg *config.Config, logger *zap.Logger, redactor *redact.Redactor, state *startupState, faults *services.FaultInjector, maintenance *services.MaintenanceMode, usage *services.UsageMeter) *gin.Engine {
 	// Set Gin mode
 	if cfg.Telemetry.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -208,11 +249,12 @@ func setupRouter(h *api.Handlers, cfg *config.Config, logger *zap.Logger) *gin.E
 
 	// Global middleware
 	router.Use(middleware.RequestID())
-	router.Use(middleware.Logger(logger))
+	router.Use(middleware.Logger(logger, redactor))
 	router.Use(middleware.Recovery(logger))
 	router.Use(middleware.CORS())
 	router.Use(middleware.RequestSizeLimit(cfg.Server.MaxRequestSize))
 	router.Use(middleware.Timeout(time.Duration(cfg.Server.WriteTimeout) * time.Second))
+	router.Use(middleware.Deadline())
 
 	// Tracing middleware if enabled
 	if cfg.Telemetry.EnableDistributedTracing {
@@ -221,10 +263,15 @@ func setupRouter(h *api.Handlers, cfg *config.Config, logger *zap.Logger) *gin.E
 
 	// Health check (no auth required)
 	router.GET("/health", h.HealthCheck)
-	router.GET("/ready", func(c *gin.Context) {
-		// Ready check - simplified for now
-		c.JSON(http.StatusOK, gin.H{"status": "ready"})
-	})
+	router.GET("/ready", readyHandler(state))
+
+	// Public routes: no auth, their own rate limit and CORS policy
+	public := router.Group("/api/v1/public")
+	public.Use(middleware.PublicCORS(cfg.Share.AllowedOrigins))
+	public.Use(middleware.RateLimit(cfg.Share.RateLimit))
+	{
+		public.GET("/workflows/:token", h.GetPublicWorkflow)
+	}
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
@@ -234,35 +281,149 @@ func setupRouter(h *api.Handlers, cfg *config.Config, logger *zap.Logger) *gin.E
 		v1.Use(middleware.Auth(cfg.Auth.JWTSecret))
 	}
 	
+	// Usage is metered per API key or user, so after authentication
+	if usage != nil {
+		v1.Use(middleware.UsageMetering(usage))
+	}
+
 	// Apply rate limiting
 	v1.Use(middleware.RateLimit(1000)) // 1000 requests per minute
 
+	// Read-only maintenance mode; cancelling workflows keeps working so
+	// operators can stop runaway work during maintenance
+	if maintenance != nil {
+		v1.Use(middleware.Maintenance(maintenance, "/api/v1/admin/maintenance", "/api/v1/workflows/:id/cancel"))
+	}
+
+	// Fault injection, never enabled in production
+	if faults != nil {
+		v1.Use(middleware.FaultInjection(faults, "/api/v1/admin/faults"))
+	}
+
 	// Projects
 	projects := v1.Group("/projects")
 	{
 		projects.POST("", h.CreateProject)
+		projects.POST("/stats:method", h.BatchProjectStats)
 		projects.GET("/:id", h.GetProject)
 		projects.GET("", h.ListProjects)
 		projects.PUT("/:id", h.UpdateProject)
 		projects.DELETE("/:id", h.DeleteProject)
+		projects.GET("/:id/intent-stats", h.GetIntentStats)
+		projects.GET("/:id/estimation-accuracy", h.GetEstimationAccuracy)
+		projects.GET("/:id/failure-analysis", h.GetFailureAnalysis)
+		projects.GET("/:id/storage", h.GetProjectStorage)
+		projects.GET("/:id/policy", h.GetProjectPolicy)
+		projects.GET("/:id/agent-preferences", h.GetAgentPreferences)
+		projects.PUT("/:id/agent-preferences", h.UpdateAgentPreferences)
+		projects.GET("/:id/variables", h.GetProjectVariables)
+		projects.PUT("/:id/variables", h.SetProjectVariables)
+		projects.GET("/:id/secrets", h.ListProjectSecrets)
+		projects.PUT("/:id/secrets/:name", h.SetProjectSecret)
+		projects.DELETE("/:id/secrets/:name", h.DeleteProjectSecret)
+		projects.POST("/:id/resources", h.CreateResource)
+		projects.GET("/:id/resources", h.ListResources)
+		projects.GET("/:id/resources/:rid", h.GetResource)
+		projects.DELETE("/:id/resources/:rid", h.DeleteResource)
+		projects.POST("/:id/environments/:env/promote", h.PromoteEnvironment)
 	}
 
 	// Workflows
 	workflows := v1.Group("/workflows")
 	{
 		workflows.POST("", h.StartWorkflow)
+		workflows.POST("/validate", h.ValidateWorkflow)
 		workflows.GET("/:id", h.GetWorkflow)
 		workflows.GET("", h.ListWorkflows)
 		workflows.POST("/:id/cancel", h.CancelWorkflow)
+		workflows.POST("/:id/risk-confirmation", h.ConfirmIntentRisks)
+		workflows.POST("/:id/steps/:step_id/skip", h.SkipWorkflowStep)
+		workflows.POST("/:id/steps/:step_id/complete", h.CompleteWorkflowStep)
+		workflows.PATCH("/:id/tags", h.UpdateWorkflowTags)
 		workflows.GET("/:id/metrics", h.GetWorkflowMetrics)
+		workflows.GET("/:id/metrics/timeseries", h.GetWorkflowMetricsTimeseries)
+		workflows.GET("/:id/pending-activities", h.GetPendingActivities)
+		workflows.GET("/:id/state", h.GetWorkflowState)
+		workflows.GET("/:id/runs", h.ListWorkflowRuns)
+		workflows.GET("/:id/report", h.GetWorkflowReport)
+		workflows.GET("/:id/graph", h.GetWorkflowGraph)
+		workflows.GET("/:id/compare", h.CompareWorkflows)
+		workflows.GET("/:id/artifacts", h.ListWorkflowArtifacts)
+		workflows.GET("/:id/result", h.GetWorkflowResult)
+		workflows.POST("/:id/share", h.CreateWorkflowShare)
+		workflows.DELETE("/:id/share/:token_id", h.RevokeWorkflowShare)
+		workflows.GET("/:id/comments", h.ListWorkflowComments)
+		workflows.POST("/:id/comments", h.CreateWorkflowComment)
+		workflows.PATCH("/:id/comments/:comment_id", h.UpdateWorkflowComment)
+		workflows.DELETE("/:id/comments/:comment_id", h.DeleteWorkflowComment)
+		workflows.POST("/drafts", h.CreateWorkflowDraft)
+		workflows.PUT("/drafts/:id", h.UpdateWorkflowDraft)
+		workflows.DELETE("/drafts/:id", h.DeleteWorkflowDraft)
+		workflows.POST("/:id/submit", h.SubmitWorkflowDraft)
+		workflows.POST("/:id/rerun", h.RerunWorkflow)
+	}
+
+	// Workflow templates
+	templates := v1.Group("/workflow-templates")
+	{
+		templates.GET("/catalog", h.GetTemplateCatalog)
+		templates.POST("/:id/clone", h.CloneWorkflowTemplate)
+	}
+
+	// Executions
+	executions := v1.Group("/executions")
+	{
+		executions.GET("/:id", h.GetExecution)
+		executions.GET("/:id/output", h.GetExecutionOutput)
+		executions.GET("/:id/logs", h.GetExecutionLogs)
+		executions.GET("/:id/metrics", h.GetExecutionMetrics)
 	}
 
+	// Artifacts
+	artifacts := v1.Group("/artifacts")
+	{
+		artifacts.GET("/:id", h.GetArtifact)
+		artifacts.GET("/:id/versions", h.ListArtifactVersions)
+		artifacts.GET("/:id/diff", h.DiffArtifact)
+		artifacts.GET("/:id/preview", h.PreviewArtifact)
+	}
+
+	// Usage metering
+	v1.GET("/usage", h.GetUsage)
+
+	// Queue wait and execution time of workflows and executions
+	v1.GET("/metrics/overview", h.GetMetricsOverview)
+
 	// Agents
 	agents := v1.Group("/agents")
 	{
 		agents.GET("", h.ListAgents)
+		agents.GET("/stats", h.GetAgentStats)
 		agents.GET("/:id", h.GetAgent)
 		agents.POST("/:id/restart", h.RestartAgent)
+		agents.POST("/execute-batch", h.ExecuteAgentBatch)
+	}
+
+	v1.GET("/agent-pools", h.GetAgentPools)
+
+	// Admin, for the admins listed in the auth config only
+	admin := v1.Group("/admin")
+	admin.Use(middleware.RequireAdmin(cfg.Auth.Admins))
+	{
+		admin.POST("/encryption/rewrap", h.RewrapEncryptionKeys)
+		admin.POST("/worker/restart", h.RestartWorker)
+		admin.POST("/workflows/:id/reset-stuck", h.ResetStuckWorkflow)
+		admin.POST("/reconcile-workflows", h.ReconcileWorkflows)
+		admin.GET("/task-queues", h.ListTaskQueues)
+		admin.POST("/maintenance", h.SetMaintenance)
+		admin.POST("/faults", h.CreateFaultRule)
+		admin.GET("/faults", h.ListFaultRules)
+		admin.DELETE("/faults/:rule_id", h.DeleteFaultRule)
+		admin.GET("/event-consumers", h.ListEventConsumers)
+		admin.GET("/projects", h.AdminListProjects)
+		admin.GET("/workflows", h.AdminListWorkflows)
+		// Visibility queries span every project's workflows
+		admin.GET("/workflows/visibility", h.ListWorkflowsFromTemporal)
 	}
 
 	// Demo endpoints
@@ -299,9 +460,15 @@ func initTelemetry(cfg *config.Config, logger *zap.Logger) (func(context.Context
 		return nil, fmt.Errorf("failed to create Jaeger exporter: %w", err)
 	}
 
+	// Spans are exported with sensitive attribute values redacted
+	redactor, err := redact.New(cfg.Redaction.Fields, cfg.Redaction.Paths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redaction rules: %w", err)
+	}
+
 	// Create trace provider
 	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exp),
+		sdktrace.WithBatcher(redact.SpanExporter(exp, redactor)),
 		sdktrace.WithResource(res),
 		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.Telemetry.SamplingRate)),
 	)
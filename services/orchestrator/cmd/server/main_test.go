@@ -0,0 +1,253 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/api"
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+	"orchestrator/internal/testutil"
+)
+
+// adminAuth authenticates test_user, the user of valid-test-token, as an
+// admin
+var adminAuth = config.AuthConfig{Enabled: true, JWTSecret: "jwt-secret", Admins: []string{"test_user"}}
+
+// asAdmin authenticates req as test_user
+func asAdmin(req *http.Request) *http.Request {
+	req.Header.Set("Authorization", "Bearer valid-test-token")
+	return req
+}
+
+func TestSetupRouter_AdminRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{MaxRequestSize: 1 << 20, WriteTimeout: 5},
+		Auth:   config.AuthConfig{Enabled: true, JWTSecret: "jwt-secret", Admins: []string{"ops-user"}},
+	}
+	h := api.NewHandlers(nil, nil, nil, zap.NewNop(), nil)
+	router := setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, nil, nil, nil)
+
+	// test_user is authenticated but no admin
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/v1/admin/") {
+			continue
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, asAdmin(httptest.NewRequest(route.Method, route.Path, nil)))
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s", route.Method, route.Path)
+		assert.Contains(t, rec.Body.String(), "Admin access required")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, asAdmin(httptest.NewRequest(http.MethodGet, "/api/v1/admin/workflows/visibility", nil)))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// API keys are admins by their ID
+	cfg.Auth.Admins = []string{"ops-user", "a2e4ab0472c8"}
+	router = setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, nil, nil, nil)
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/worker/restart", nil)
+	req.Header.Set("X-API-Key", "test-api-key-123")
+	router.ServeHTTP(rec, req)
+	assert.NotEqual(t, http.StatusForbidden, rec.Code, rec.Body.String())
+}
+
+func TestSetupRouter_AdminListingsOfDeletedRecords(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{MaxRequestSize: 1 << 20, WriteTimeout: 5},
+		Auth:   config.AuthConfig{Enabled: true, JWTSecret: "jwt-secret", Admins: []string{"ops-user"}},
+	}
+	workflows := testutil.NewFakeWorkflowService(&models.Workflow{
+		ID: "wf-1", Name: "Build", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusCompleted, ProjectID: "project-1",
+	})
+	projects := testutil.NewFakeProjectManager(&models.Project{ID: "project-1", Name: "Platform", Status: models.ProjectStatusActive})
+	h := api.NewHandlers(workflows, projects, testutil.NewFakeAgentGateway(), zap.NewNop(), nil)
+
+	get := func(router *gin.Engine, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, asAdmin(httptest.NewRequest(http.MethodGet, path, nil)))
+		return rec
+	}
+
+	// Deleted records stay hidden from users who are not admins
+	router := setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, nil, nil, nil)
+	for _, path := range []string{"/api/v1/admin/projects?include_deleted=true", "/api/v1/admin/workflows?include_deleted=true"} {
+		rec := get(router, path)
+		assert.Equal(t, http.StatusForbidden, rec.Code, path)
+		assert.NotContains(t, rec.Body.String(), "Platform")
+	}
+
+	cfg.Auth.Admins = append(cfg.Auth.Admins, "test_user")
+	router = setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, nil, nil, nil)
+	for _, path := range []string{"/api/v1/admin/projects?include_deleted=true", "/api/v1/admin/workflows?include_deleted=true"} {
+		rec := get(router, path)
+		assert.Equal(t, http.StatusOK, rec.Code, "%s: %s", path, rec.Body.String())
+	}
+}
+
+func TestSetupRouter_PublicWorkflowRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{MaxRequestSize: 1 << 20, WriteTimeout: 5},
+		Auth:   config.AuthConfig{Enabled: true, JWTSecret: "jwt-secret"},
+		Share: config.ShareConfig{
+			Secret:         strings.Repeat("s", 32),
+			DefaultTTL:     60,
+			MaxTTL:         60,
+			RateLimit:      2,
+			AllowedOrigins: []string{"https://stakeholders.example.com"},
+		},
+	}
+
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+	h := api.NewHandlers(nil, nil, nil, zap.NewNop(), nil)
+	h.SetShareService(services.NewWorkflowShareService(nil, services.NewRedisShareTokenStore(redisClient), &cfg.Share, zap.NewNop()))
+	router := setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, nil, nil, nil)
+
+	get := func(path, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Authenticated routes still require credentials
+	assert.Equal(t, http.StatusUnauthorized, get("/api/v1/workflows", "").Code)
+
+	// The public route is reached without credentials; the forged token is
+	// rejected by the handler rather than the auth middleware
+	rec := get("/api/v1/public/workflows/forged.token", "https://stakeholders.example.com")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "https://stakeholders.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+
+	rec = get("/api/v1/public/workflows/forged.token", "https://elsewhere.example.com")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// The public rate limit applies on its own
+	assert.Equal(t, http.StatusTooManyRequests, get("/api/v1/public/workflows/forged.token", "").Code)
+}
+
+func TestSetupRouter_FaultInjection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Server:         config.ServerConfig{MaxRequestSize: 1 << 20, WriteTimeout: 5},
+		FaultInjection: config.FaultInjectionConfig{Enabled: true, DefaultTTL: 300, MaxTTL: 3600, MaxDelay: 60, RefreshInterval: 1},
+		Auth:           adminAuth,
+	}
+	h := api.NewHandlers(nil, nil, nil, zap.NewNop(), nil)
+
+	get := func(router *gin.Engine, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, asAdmin(httptest.NewRequest(http.MethodGet, path, nil)))
+		return rec
+	}
+
+	// Disabled: no rules can be managed and nothing is faulted
+	router := setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, nil, nil, nil)
+	assert.Equal(t, http.StatusNotFound, get(router, "/api/v1/admin/faults").Code)
+
+	faults := services.NewFaultInjector(services.NewMemoryFaultRuleStore(), &cfg.FaultInjection, zap.NewNop())
+	h.SetFaultInjector(faults)
+	router = setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, faults, nil, nil)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, asAdmin(httptest.NewRequest(http.MethodPost, "/api/v1/admin/faults", strings.NewReader(
+		`{"target": "http", "pattern": "/api/v1/workflows/*", "probability": 1, "status_code": 503}`))))
+	require.Equal(t, http.StatusCreated, rec.Code)
+
+	// Matching requests fail before reaching their handler, while the rules
+	// themselves stay manageable
+	rec = get(router, "/api/v1/workflows/wf-1")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("X-Fault-Injected"))
+	assert.Equal(t, http.StatusOK, get(router, "/api/v1/admin/faults").Code)
+}
+
+func TestSetupRouter_MaintenanceMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		Server:      config.ServerConfig{MaxRequestSize: 1 << 20, WriteTimeout: 5},
+		Maintenance: config.MaintenanceConfig{RetryAfter: 300, MaxTTL: 3600, RefreshInterval: 1},
+		Auth:        adminAuth,
+	}
+	workflows := testutil.NewFakeWorkflowService(&models.Workflow{
+		ID: "wf-1", Name: "Build", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusRunning, ProjectID: "project-1",
+	})
+	projects := testutil.NewFakeProjectManager(&models.Project{ID: "project-1", Name: "Platform", Status: models.ProjectStatusActive})
+	h := api.NewHandlers(workflows, projects, testutil.NewFakeAgentGateway(), zap.NewNop(), nil)
+	maintenance := services.NewMaintenanceMode(services.NewMemoryMaintenanceStore(), &cfg.Maintenance, zap.NewNop())
+	h.SetMaintenanceMode(maintenance)
+	router := setupRouter(h, cfg, zap.NewNop(), nil, &startupState{}, nil, maintenance, nil)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(rec, asAdmin(req))
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/api/v1/admin/maintenance",
+		`{"enabled": true, "message": "Migrating the database until 14:00 UTC", "ttl_seconds": 1800}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		// Reads keep working
+		{http.MethodGet, "/api/v1/workflows/wf-1", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/workflows", "", http.StatusOK},
+		{http.MethodGet, "/api/v1/projects/project-1", "", http.StatusOK},
+		// Mutations are refused
+		{http.MethodPost, "/api/v1/workflows", `{"name": "build", "type": "execution"}`, http.StatusServiceUnavailable},
+		{http.MethodPost, "/api/v1/workflows/wf-1/rerun", "", http.StatusServiceUnavailable},
+		{http.MethodPut, "/api/v1/projects/project-1", `{"name": "Renamed"}`, http.StatusServiceUnavailable},
+		{http.MethodPatch, "/api/v1/workflows/wf-1/tags", `{"add": ["urgent"]}`, http.StatusServiceUnavailable},
+		{http.MethodDelete, "/api/v1/projects/project-1", "", http.StatusServiceUnavailable},
+		{http.MethodPost, "/api/v1/admin/worker/restart", "", http.StatusServiceUnavailable},
+		// Running workflows can still be cancelled
+		{http.MethodPost, "/api/v1/workflows/wf-1/cancel", `{"reason": "runaway"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := serve(tt.method, tt.path, tt.body)
+		assert.Equal(t, tt.status, rec.Code, "%s %s: %s", tt.method, tt.path, rec.Body.String())
+		if tt.status == http.StatusServiceUnavailable {
+			assert.Contains(t, rec.Body.String(), `"error":"Service is in maintenance mode: Migrating the database until 14:00 UTC"`)
+			assert.Contains(t, rec.Body.String(), `"request_id"`)
+			assert.Equal(t, "1800", rec.Header().Get("Retry-After"))
+		}
+	}
+
+	rec = serve(http.MethodGet, "/health", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"maintenance":{"enabled":true`)
+
+	// The admin endpoint itself is exempt, so maintenance can be ended
+	rec = serve(http.MethodPost, "/api/v1/admin/maintenance", `{"enabled": false}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = serve(http.MethodPut, "/api/v1/projects/project-1", `{"name": "Renamed"}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
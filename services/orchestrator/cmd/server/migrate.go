@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/database"
+)
+
+// runMigrations serves --migrate and --migrate-status: it applies the
+// pending database migrations and lists every migration with when it was
+// applied, without starting the service
+func runMigrations(out io.Writer, apply, status bool) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	db, err := database.Connect(cfg.Database.URL)
+	if err != nil {
+		return err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+	return migrateDatabase(db, out, apply, status)
+}
+
+func migrateDatabase(db *gorm.DB, out io.Writer, apply, status bool) error {
+	if apply {
+		if err := database.Migrate(db); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Database migrations applied")
+	}
+	if !status {
+		return nil
+	}
+
+	states, err := database.MigrationStatus(db)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED AT")
+	for _, state := range states {
+		appliedAt := "pending"
+		if state.AppliedAt != nil {
+			appliedAt = state.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", state.Version, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/database"
+)
+
+func TestMigrateDatabase_Status(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&database.SchemaMigration{}))
+	appliedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Create(&database.SchemaMigration{Version: "2026101800_baseline", AppliedAt: appliedAt}).Error)
+
+	var out bytes.Buffer
+	require.NoError(t, migrateDatabase(db, &out, false, true))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.GreaterOrEqual(t, len(lines), 3)
+	assert.Regexp(t, `^VERSION\s+APPLIED AT$`, lines[0])
+	assert.Regexp(t, `^2026101800_baseline\s+2026-10-18T12:00:00Z$`, lines[1])
+	assert.Regexp(t, `^2026101801_workflow_duration_ms\s+pending$`, lines[2], "the status alone applies nothing")
+}
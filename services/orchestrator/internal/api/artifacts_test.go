@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/services"
+)
+
+func TestHandlers_ArtifactDiff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	db := setupTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT,
+		path TEXT, url TEXT, size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+		workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`).Error)
+
+	store, err := services.NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	artifacts := services.NewArtifactService(db, store, &config.ArtifactsConfig{MaxDiffBytes: 1024}, zap.NewNop())
+	for _, version := range []struct {
+		id, key, parent, content string
+		version                  int
+	}{
+		{"main-1", "main.go", "", "package main\n", 1},
+		{"main-2", "main.go", "main-1", "package main\n\nfunc main() {}\n", 2},
+		{"logo-1", "logo.png", "", "\x89PNG\x00\x01", 1},
+		{"logo-2", "logo.png", "logo-1", "\x89PNG\x00\x02", 2},
+	} {
+		checksum, err := artifacts.PutContent(ctx, []byte(version.content))
+		require.NoError(t, err)
+		var parent interface{}
+		if version.parent != "" {
+			parent = version.parent
+		}
+		require.NoError(t, db.Exec(`INSERT INTO artifacts (id, execution_id, name, type, path, size, checksum,
+			workflow_id, logical_key, version, parent_artifact_id) VALUES (?, 'exec-1', ?, 'code', ?, ?, ?, 'wf-1', ?, ?, ?)`,
+			version.id, version.key, version.key, len(version.content), checksum, version.key, version.version, parent).Error)
+	}
+
+	handlers := setupTestHandlers(t, db)
+	handlers.SetArtifactService(artifacts)
+	router := gin.New()
+	router.GET("/api/v1/artifacts/:id/versions", handlers.ListArtifactVersions)
+	router.GET("/api/v1/artifacts/:id/diff", handlers.DiffArtifact)
+	router.GET("/api/v1/artifacts/:id/preview", handlers.PreviewArtifact)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/v1/artifacts/main-2/versions")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var versions struct {
+		Versions []struct {
+			ID      string `json:"id"`
+			Version int    `json:"version"`
+		} `json:"versions"`
+	}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &versions))
+	require.Len(t, versions.Versions, 2)
+	assert.Equal(t, "main-1", versions.Versions[0].ID)
+	assert.Equal(t, 2, versions.Versions[1].Version)
+
+	// Text contents are diffed
+	rec = get("/api/v1/artifacts/main-2/diff?against=main-1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var diff services.ArtifactDiff
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &diff))
+	assert.Equal(t, services.ArtifactDiffText, diff.Mode)
+	assert.Contains(t, diff.Diff, "+func main() {}\n")
+
+	// Binary contents are compared by metadata
+	rec = get("/api/v1/artifacts/logo-2/diff?against=logo-1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	diff = services.ArtifactDiff{}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &diff))
+	assert.Equal(t, services.ArtifactDiffMetadata, diff.Mode)
+	assert.Empty(t, diff.Diff)
+	assert.Equal(t, "artifact logo-1 is binary", diff.Reason)
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/artifacts/main-2/diff").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/artifacts/main-2/diff?against=missing").Code)
+
+	// Previews show the first lines of text and the metadata of binaries
+	rec = get("/api/v1/artifacts/main-2/preview?lines=2")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var preview services.ArtifactPreview
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &preview))
+	assert.Equal(t, services.ArtifactPreviewText, preview.Class)
+	assert.Equal(t, "go", preview.Language)
+	assert.Equal(t, "package main\n\n", preview.Content)
+	assert.Equal(t, 3, preview.TotalLines)
+	assert.True(t, preview.Truncated)
+
+	rec = get("/api/v1/artifacts/logo-2/preview")
+	require.Equal(t, http.StatusOK, rec.Code)
+	preview = services.ArtifactPreview{}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &preview))
+	assert.True(t, preview.Binary)
+	assert.Empty(t, preview.Content)
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/artifacts/main-2/preview?lines=many").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/artifacts/main-2/preview?depth=0").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/artifacts/missing/preview").Code)
+}
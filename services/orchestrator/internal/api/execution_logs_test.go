@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/services"
+)
+
+// setupExecutionLogsDB adds the execution columns the logs endpoint reads;
+// the full models use Postgres-only types
+func setupExecutionLogsDB(t *testing.T) *gorm.DB {
+	db := setupTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE executions (id TEXT PRIMARY KEY, status TEXT, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`CREATE TABLE execution_logs (
+		id TEXT PRIMARY KEY, execution_id TEXT, level TEXT, message TEXT, source TEXT,
+		line_number INTEGER, metadata TEXT, timestamp DATETIME, created_at DATETIME)`).Error)
+	return db
+}
+
+// fakeAgentSocket accepts agent connections and writes the frames sent on
+// the returned channel to the connected agent
+func fakeAgentSocket(t *testing.T) (*httptest.Server, chan<- interface{}) {
+	frames := make(chan interface{})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/agents/agent-1/connect", r.URL.Path)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for frame := range frames {
+			if err := conn.WriteJSON(frame); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(frames) })
+	return server, frames
+}
+
+func taskLogFrame(executionID, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      services.TaskLogMessageType,
+		"timestamp": time.Now(),
+		"payload":   map[string]interface{}{"execution_id": executionID, "level": "info", "message": message},
+	}
+}
+
+func TestHandlers_FollowExecutionLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupExecutionLogsDB(t)
+	require.NoError(t, db.Exec(`INSERT INTO executions (id, status) VALUES ('exec-running', 'running'), ('exec-done', 'succeeded')`).Error)
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Exec(`INSERT INTO execution_logs (id, execution_id, level, message, timestamp) VALUES
+		('log-2', 'exec-done', 'info', 'stored 2', ?), ('log-1', 'exec-done', 'info', 'stored 1', ?)`,
+		start.Add(time.Second), start).Error)
+
+	agentSocket, frames := fakeAgentSocket(t)
+	agentClient, err := services.NewAgentClient(&config.AgentManagerConfig{
+		BaseURL:          agentSocket.URL,
+		WebSocketURL:     "ws" + strings.TrimPrefix(agentSocket.URL, "http"),
+		HTTPTimeout:      5,
+		WebSocketTimeout: 5,
+		PingInterval:     30,
+		PongTimeout:      10,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	defer agentClient.Close()
+
+	relay := services.NewLogRelay(services.NewMemoryLogStream(100), services.LogRelayConfig{
+		BufferSize:     10,
+		FollowInterval: 50 * time.Millisecond,
+	}, zap.NewNop())
+	relay.Start()
+	defer relay.Stop()
+	agentClient.RegisterHandler(services.TaskLogMessageType, relay.HandleMessage)
+	_, err = agentClient.ConnectToAgent(context.Background(), "agent-1", "project-1")
+	require.NoError(t, err)
+
+	handlers := setupTestHandlers(t, db)
+	handlers.SetLogRelay(relay)
+	router := gin.New()
+	router.GET("/executions/:id/logs", handlers.GetExecutionLogs)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/executions/exec-running/logs?follow=true")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	lines := make(chan services.TaskLogLine)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var line services.TaskLogLine
+			if json.Unmarshal(scanner.Bytes(), &line) == nil {
+				lines <- line
+			}
+		}
+	}()
+	next := func() services.TaskLogLine {
+		select {
+		case line, ok := <-lines:
+			require.True(t, ok, "log stream ended early")
+			return line
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "timed out waiting for a log line")
+			return services.TaskLogLine{}
+		}
+	}
+
+	frames <- taskLogFrame("exec-running", "line 1")
+	frames <- taskLogFrame("exec-other", "not followed")
+	frames <- map[string]interface{}{"type": "status", "payload": map[string]string{"state": "busy"}}
+	frames <- taskLogFrame("exec-running", "line 2")
+	frames <- taskLogFrame("exec-running", "line 3")
+
+	for _, message := range []string{"line 1", "line 2", "line 3"} {
+		line := next()
+		assert.Equal(t, message, line.Message)
+		assert.Equal(t, "exec-running", line.ExecutionID)
+	}
+
+	// Messages without a handler are still received directly
+	data, err := agentClient.ReceiveMessage("agent-1", 5*time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"status"`)
+
+	// The stream ends once the execution has finished and its last lines
+	// are sent
+	frames <- taskLogFrame("exec-running", "line 4")
+	assert.Equal(t, "line 4", next().Message)
+	require.NoError(t, db.Exec(`UPDATE executions SET status = 'succeeded' WHERE id = 'exec-running'`).Error)
+	select {
+	case line, ok := <-lines:
+		assert.False(t, ok, "unexpected line %q", line.Message)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "log stream did not end")
+	}
+
+	// Finished executions are served from the database
+	resp, err = http.Get(server.URL + "/executions/exec-done/logs?follow=true")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var stored []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line services.TaskLogLine
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		stored = append(stored, line.Message)
+	}
+	assert.Equal(t, []string{"stored 1", "stored 2"}, stored)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/executions/exec-done/logs", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var logs []map[string]interface{}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &logs))
+	assert.Len(t, logs, 2)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/executions/missing/logs?follow=true", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestHandlers_ExecutionMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, created_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE metrics (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), execution_id TEXT NOT NULL,
+			name TEXT NOT NULL, value REAL NOT NULL, unit TEXT, type TEXT, tags BLOB, timestamp DATETIME NOT NULL, created_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	created := time.Now().UTC().Add(-10 * time.Minute).Truncate(time.Minute)
+	workflow := &models.Workflow{Name: "Metrics", Type: models.WorkflowTypeExecution, ProjectID: "project-1", CreatedAt: created}
+	require.NoError(t, db.Create(workflow).Error)
+	for _, id := range []string{"execution-1", "execution-2"} {
+		require.NoError(t, db.Exec(`INSERT INTO executions (id, workflow_id, created_at) VALUES (?, ?, ?)`, id, workflow.ID, created).Error)
+	}
+
+	recorder := services.NewMetricsRecorder(db, &config.ExecutionMetricsConfig{FlushInterval: 5, FlushTimeout: 5, BatchSize: 100, MaxBuffered: 100}, zap.NewNop())
+	recorder.Record(
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 10, Timestamp: created.Add(time.Minute)},
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 20, Timestamp: created.Add(time.Minute + 10*time.Second)},
+		models.Metric{ExecutionID: "execution-2", Name: "cpu_usage", Value: 60, Timestamp: created.Add(time.Minute)},
+		models.Metric{ExecutionID: "execution-1", Name: "memory_usage", Value: 1024, Timestamp: created.Add(time.Minute)},
+	)
+	_, err := recorder.Flush(context.Background())
+	require.NoError(t, err)
+
+	handlers := setupTestHandlers(t, db)
+	router := gin.New()
+	router.GET("/executions/:id/metrics", handlers.GetExecutionMetrics)
+	router.GET("/workflows/:id/metrics/timeseries", handlers.GetWorkflowMetricsTimeseries)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, get("/executions/execution-1/metrics").Code)
+	handlers.SetMetricsRecorder(recorder)
+
+	// The range defaults to the life of the execution
+	rec := get("/executions/execution-1/metrics?name=cpu_usage&step=1m")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var report services.MetricsReport
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &report))
+	assert.True(t, report.From.Equal(created))
+	assert.EqualValues(t, 60000, report.StepMs)
+	require.Len(t, report.Series, 1)
+	assert.Equal(t, []services.MetricBucket{{Start: created.Add(time.Minute), Avg: 15, Min: 10, Max: 20, Count: 2}}, report.Series[0].Buckets)
+
+	rec = get("/workflows/" + workflow.ID + "/metrics/timeseries?name=cpu_usage&step=1m")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	report = services.MetricsReport{}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &report))
+	assert.Equal(t, workflow.ID, report.WorkflowID)
+	require.Len(t, report.Series, 1)
+	assert.Equal(t, []services.MetricBucket{{Start: created.Add(time.Minute), Avg: 30, Min: 10, Max: 60, Count: 3, Executions: 2}}, report.Series[0].Buckets)
+
+	for path, status := range map[string]int{
+		"/executions/missing/metrics":                             http.StatusNotFound,
+		"/workflows/missing/metrics/timeseries":                   http.StatusNotFound,
+		"/executions/execution-1/metrics?step=soon":               http.StatusBadRequest,
+		"/executions/execution-1/metrics?step=1ms":                http.StatusBadRequest,
+		"/executions/execution-1/metrics?from=yesterday":          http.StatusBadRequest,
+		"/executions/execution-1/metrics?to=2020-01-01T00:00:00Z": http.StatusBadRequest,
+	} {
+		assert.Equal(t, status, get(path).Code, path)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/services"
+)
+
+func TestHandlers_ExecutionOutput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, workflow_id TEXT, workflow_step_id TEXT,
+			agent_id TEXT, name TEXT, type TEXT, status TEXT, language TEXT, runtime TEXT, code TEXT, script TEXT,
+			command TEXT, arguments TEXT, environment TEXT, input TEXT, output TEXT, logs TEXT, error TEXT,
+			error_signature TEXT, error_class TEXT, exit_code INTEGER, started_at DATETIME, completed_at DATETIME, duration INTEGER,
+			timeout_seconds INTEGER, retry_count INTEGER, max_retries INTEGER, retry_delay INTEGER,
+			resource_usage TEXT, metadata TEXT, tags TEXT, priority INTEGER, simulated BOOLEAN, queued_at DATETIME,
+			scheduled_at DATETIME, created_by TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT, path TEXT, url TEXT,
+			size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+			workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	store, err := services.NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	outputs := services.NewOutputPolicy(db, store, &config.OutputsConfig{MaxInlineBytes: 64, PreviewBytes: 8}, zap.NewNop())
+	full := `{"log":"` + strings.Repeat(`building\n`, 20) + `"}`
+	stored, _, err := outputs.Limit(ctx, "project-1", "exec-large", []byte(full))
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`INSERT INTO executions (id, status, output) VALUES ('exec-large', 'succeeded', ?),
+		('exec-small', 'succeeded', ?)`, stored, []byte(`{"result":"ok"}`)).Error)
+
+	handlers := setupTestHandlers(t, db)
+	handlers.SetOutputPolicy(outputs)
+	router := gin.New()
+	router.GET("/api/v1/executions/:id", handlers.GetExecution)
+	router.GET("/api/v1/executions/:id/output", handlers.GetExecutionOutput)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Details carry the preview and where the full output is
+	rec := get("/api/v1/executions/exec-large")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var execution struct {
+		Output        map[string]interface{} `json:"output"`
+		FullOutputURL string                 `json:"full_output_url"`
+	}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &execution))
+	assert.Equal(t, "/api/v1/executions/exec-large/output", execution.FullOutputURL)
+	assert.Equal(t, true, execution.Output["truncated"])
+	assert.Contains(t, execution.Output["preview"], "bytes truncated")
+
+	rec = get(execution.FullOutputURL)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, full, rec.Body.String())
+
+	// Inline outputs have no full output URL and are returned as stored
+	rec = get("/api/v1/executions/exec-small")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "full_output_url")
+	rec = get("/api/v1/executions/exec-small/output")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"result":"ok"}`, rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/executions/missing").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/executions/missing/output").Code)
+}
@@ -1,31 +1,137 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/encryption"
+	"orchestrator/internal/errorclass"
+	"orchestrator/internal/errorsig"
+	"orchestrator/internal/events"
+	"orchestrator/internal/models"
+	"orchestrator/internal/requestid"
 	"orchestrator/internal/services"
+	"orchestrator/internal/temporal"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 // Handlers contains all HTTP handlers
 type Handlers struct {
-	workflowEngine *services.WorkflowEngine
-	projectService *services.ProjectService
-	agentClient    *services.AgentClient
+	workflowEngine WorkflowService
+	projectService ProjectManager
+	agentClient    AgentGateway
 	logger         *zap.Logger
 	db             *gorm.DB
+	jobs           context.Context
+	encryptor      *encryption.Encryptor
+	worker         WorkerController
+	shares         *services.WorkflowShareService
+	reports        *services.WorkflowReportBuilder
+	comparer       *services.WorkflowComparer
+	graphs         *services.WorkflowGraphBuilder
+	variables      *services.VariableService
+	logs           *services.LogRelay
+	resources      *services.ResourceService
+	promotions     *services.EnvironmentPromoter
+	faults         *services.FaultInjector
+	maintenance    *services.MaintenanceMode
+	comments       *services.WorkflowCommentService
+	outputs        *services.OutputPolicy
+	artifacts      *services.ArtifactService
+	results        *services.ResultCompactor
+	usage          *services.UsageMeter
+	metrics        *services.MetricsRecorder
+	pools          *services.PoolManager
+	compatibility  services.AgentCompatibilityChecker
+	redis          services.RedisHealthChecker
+	qualityScores  *services.TaskQualityTracker
+	templates      *services.TemplateCatalog
+	eventConsumers *events.Registry
+	sandbox        *services.SandboxPolicies
+	agentPrefs     *services.AgentPreferenceService
+	reconciler     *services.WorkflowReconciler
+	taskQueues     *services.TaskQueueMonitor
 }
 
+// WorkerController reports the health of the Temporal worker and restarts it
+type WorkerController interface {
+	Health() temporal.WorkerHealth
+	Restart() error
+}
+
+// WorkflowService starts, queries and cancels workflows
+type WorkflowService interface {
+	StartWorkflow(ctx context.Context, req *services.StartWorkflowRequest) (*services.StartWorkflowResponse, error)
+	GetWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error)
+	ListWorkflowSummaries(ctx context.Context, filters *services.WorkflowFilters) ([]*models.WorkflowSummary, int64, error)
+	ListWorkflowsFromTemporal(ctx context.Context, query string) ([]services.TemporalWorkflowExecution, error)
+	UpdateWorkflowTags(ctx context.Context, workflowID string, replace *[]string, add, remove []string) (*models.Workflow, error)
+	CancelWorkflow(ctx context.Context, workflowID string, reason string, userID string) error
+	GetWorkflowMetrics(ctx context.Context, workflowID string) (*services.WorkflowMetrics, error)
+	GetTimingOverview(ctx context.Context, projectID string, since, until time.Time) (*services.TimingOverview, error)
+	GetPendingActivities(ctx context.Context, workflowID string) ([]services.PendingActivity, error)
+	GetWorkflowState(ctx context.Context, workflowID string) (*services.WorkflowState, error)
+	ListWorkflowRuns(ctx context.Context, workflowID string) ([]models.WorkflowExecution, error)
+	CountWorkflowRuns(ctx context.Context, workflowID string) (int64, error)
+	GetIntentAnalysis(ctx context.Context, workflowID string) (*models.IntentAnalysis, error)
+	GetIntentRiskReview(ctx context.Context, workflowID string) (*models.IntentRiskReview, error)
+	ConfirmIntentRisks(ctx context.Context, workflowID string, confirmation services.RiskConfirmation) (*models.IntentRiskReview, error)
+	OverrideStep(ctx context.Context, workflowID string, override services.StepOverride) error
+	RerunWorkflow(ctx context.Context, workflowID, from, userID string) (*services.StartWorkflowResponse, error)
+	ResetStuck(ctx context.Context, workflowID, actor string) (*models.Workflow, error)
+
+	CreateWorkflowDraft(ctx context.Context, req *services.StartWorkflowRequest) (*models.Workflow, error)
+	UpdateWorkflowDraft(ctx context.Context, workflowID string, req *services.StartWorkflowRequest) (*models.Workflow, error)
+	SubmitWorkflowDraft(ctx context.Context, workflowID, userID string) (*services.StartWorkflowResponse, error)
+	DeleteWorkflowDraft(ctx context.Context, workflowID string) error
+
+	ValidateWorkflow(ctx context.Context, req *services.StartWorkflowRequest, steps []services.PlanStep) error
+}
+
+// ProjectManager manages projects and reports their statistics
+type ProjectManager interface {
+	CreateProject(ctx context.Context, req *services.CreateProjectRequest) (*models.Project, error)
+	GetProject(ctx context.Context, projectID string) (*models.Project, error)
+	ListProjects(ctx context.Context, filters *services.ProjectFilters) ([]*models.Project, int64, error)
+	UpdateProject(ctx context.Context, projectID string, req *services.UpdateProjectRequest) (*models.Project, error)
+	DeleteProject(ctx context.Context, projectID string) error
+	GetIntentStats(ctx context.Context, projectID string, since, until time.Time) (*services.IntentStats, error)
+	GetEstimationAccuracy(ctx context.Context, projectID string, since, until time.Time) (*services.EstimationAccuracy, error)
+	GetFailureAnalysis(ctx context.Context, projectID string, since, until time.Time) (*services.FailureAnalysis, error)
+	GetProjectStorage(ctx context.Context, projectID string) (*services.StorageUsage, error)
+	GetProjectStatsBatch(ctx context.Context, projectIDs []string, viewer services.ProjectViewer) ([]services.ProjectStatsResult, error)
+}
+
+// AgentGateway lists, updates and runs tasks on agents of the agent manager
+type AgentGateway interface {
+	ListAgents(ctx context.Context, filters *services.AgentFilters) (*services.AgentList, error)
+	GetAgent(ctx context.Context, agentID string) (*services.Agent, error)
+	UpdateAgent(ctx context.Context, agentID string, req *services.UpdateAgentRequest) (*services.Agent, error)
+	ExecuteTaskBatch(ctx context.Context, requests []services.AgentTaskRequest, opts services.BatchOptions) (*services.BatchResult, error)
+}
+
+var (
+	_ WorkflowService = (*services.WorkflowEngine)(nil)
+	_ ProjectManager  = (*services.ProjectService)(nil)
+	_ AgentGateway    = (*services.AgentClient)(nil)
+)
+
 // NewHandlers creates new handlers instance
 func NewHandlers(
-	workflowEngine *services.WorkflowEngine,
-	projectService *services.ProjectService,
-	agentClient *services.AgentClient,
+	workflowEngine WorkflowService,
+	projectService ProjectManager,
+	agentClient AgentGateway,
 	logger *zap.Logger,
 	db *gorm.DB,
 ) *Handlers {
@@ -35,9 +141,157 @@ func NewHandlers(
 		agentClient:    agentClient,
 		logger:         logger,
 		db:             db,
+		jobs:           context.Background(),
+		reports:        services.NewWorkflowReportBuilder(db),
+		comparer:       services.NewWorkflowComparer(db),
+		graphs:         services.NewWorkflowGraphBuilder(db),
 	}
 }
 
+// SetJobContext sets the context of the background jobs handlers start, such
+// as the key rewrap, so they stop when the server shuts down
+func (h *Handlers) SetJobContext(ctx context.Context) {
+	h.jobs = ctx
+}
+
+// SetEncryptor enables the encryption admin endpoints
+func (h *Handlers) SetEncryptor(encryptor *encryption.Encryptor) {
+	h.encryptor = encryptor
+}
+
+// SetAgentCompatibility makes the health check report the agent manager
+// degraded while its API version is incompatible
+func (h *Handlers) SetAgentCompatibility(checker services.AgentCompatibilityChecker) {
+	h.compatibility = checker
+}
+
+// SetRedisHealth makes the health check report Redis degraded while it is
+// unreachable
+func (h *Handlers) SetRedisHealth(checker services.RedisHealthChecker) {
+	h.redis = checker
+}
+
+// SetTaskQualityTracker enables the agent stats endpoint
+func (h *Handlers) SetTaskQualityTracker(tracker *services.TaskQualityTracker) {
+	h.qualityScores = tracker
+}
+
+// SetTemplateCatalog enables the workflow template catalog and cloning
+func (h *Handlers) SetTemplateCatalog(catalog *services.TemplateCatalog) {
+	h.templates = catalog
+}
+
+// SetEventRegistry enables the event consumers endpoint
+func (h *Handlers) SetEventRegistry(registry *events.Registry) {
+	h.eventConsumers = registry
+}
+
+// SetRedactors sets the project redaction rules applied to workflow reports
+func (h *Handlers) SetRedactors(redactors *services.ProjectRedactors) {
+	h.reports.SetRedactors(redactors)
+}
+
+// SetWorkerController enables the worker health check and restart endpoint
+func (h *Handlers) SetWorkerController(worker WorkerController) {
+	h.worker = worker
+}
+
+// SetShareService enables workflow share links and the public workflow view
+func (h *Handlers) SetShareService(shares *services.WorkflowShareService) {
+	h.shares = shares
+}
+
+// SetVariableService enables the project variable and secret endpoints
+func (h *Handlers) SetVariableService(variables *services.VariableService) {
+	h.variables = variables
+}
+
+// SetLogRelay enables following the live logs of running executions
+func (h *Handlers) SetLogRelay(logs *services.LogRelay) {
+	h.logs = logs
+}
+
+// SetResourceService enables the project resource endpoints
+func (h *Handlers) SetResourceService(resources *services.ResourceService) {
+	h.resources = resources
+}
+
+// SetEnvironmentPromoter enables the environment promotion endpoint
+func (h *Handlers) SetEnvironmentPromoter(promotions *services.EnvironmentPromoter) {
+	h.promotions = promotions
+}
+
+// SetFaultInjector enables the fault injection admin endpoints
+func (h *Handlers) SetFaultInjector(faults *services.FaultInjector) {
+	h.faults = faults
+}
+
+// SetMaintenanceMode enables the maintenance admin endpoint and reports
+// maintenance mode in the health check
+func (h *Handlers) SetMaintenanceMode(maintenance *services.MaintenanceMode) {
+	h.maintenance = maintenance
+}
+
+// SetWorkflowReconciler enables the workflow reconciliation admin endpoint
+func (h *Handlers) SetWorkflowReconciler(reconciler *services.WorkflowReconciler) {
+	h.reconciler = reconciler
+}
+
+// SetTaskQueueMonitor enables the task queue stats admin endpoint
+func (h *Handlers) SetTaskQueueMonitor(monitor *services.TaskQueueMonitor) {
+	h.taskQueues = monitor
+}
+
+// SetCommentService enables comments on workflows
+func (h *Handlers) SetCommentService(comments *services.WorkflowCommentService) {
+	h.comments = comments
+}
+
+// SetOutputPolicy makes the execution output endpoint return offloaded
+// outputs in full
+func (h *Handlers) SetOutputPolicy(outputs *services.OutputPolicy) {
+	h.outputs = outputs
+}
+
+// SetArtifactService enables the artifact endpoints
+func (h *Handlers) SetArtifactService(artifacts *services.ArtifactService) {
+	h.artifacts = artifacts
+}
+
+// SetResultCompactor makes the workflow result endpoint rehydrate compacted
+// results on request
+func (h *Handlers) SetResultCompactor(results *services.ResultCompactor) {
+	h.results = results
+}
+
+// SetUsageMeter enables the usage report endpoint
+func (h *Handlers) SetUsageMeter(usage *services.UsageMeter) {
+	h.usage = usage
+}
+
+// SetMetricsRecorder enables the execution and workflow metric series
+// endpoints
+func (h *Handlers) SetMetricsRecorder(metrics *services.MetricsRecorder) {
+	h.metrics = metrics
+}
+
+// SetAgentPools enables reporting the warm agent pools
+func (h *Handlers) SetAgentPools(pools *services.PoolManager) {
+	h.pools = pools
+}
+
+// SetSandboxPolicies enables reporting the effective sandbox policy of
+// projects
+func (h *Handlers) SetSandboxPolicies(policies *services.SandboxPolicies) {
+	h.sandbox = policies
+}
+
+// SetAgentPreferences enables reporting and updating the agent preferences
+// of projects
+func (h *Handlers) SetAgentPreferences(prefs *services.AgentPreferenceService) {
+	h.agentPrefs = prefs
+}
+
 // GetDB returns the database instance
 func (h *Handlers) GetDB() *gorm.DB {
 	return h.db
@@ -68,6 +322,13 @@ func (h *Handlers) CreateProject(c *gin.Context) {
 		Tags:        req.Tags,
 	})
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidRiskPolicy) || errors.Is(err, services.ErrInvalidSchedulingWeight) ||
+			errors.Is(err, services.ErrInvalidBudget) || errors.Is(err, services.ErrInvalidRedactionRules) ||
+			errors.Is(err, services.ErrInvalidSandboxPolicy) || errors.Is(err, services.ErrInvalidAgentPreferences) ||
+			errors.Is(err, services.ErrInvalidRegion) {
+			h.respondError(c, http.StatusBadRequest, "Invalid project settings", err)
+			return
+		}
 		h.respondError(c, http.StatusInternalServerError, "Failed to create project", err)
 		return
 	}
@@ -94,12 +355,24 @@ func (h *Handlers) GetProject(c *gin.Context) {
 
 // ListProjects lists all projects with optional filters
 func (h *Handlers) ListProjects(c *gin.Context) {
+	h.listProjects(c, false)
+}
+
+// AdminListProjects lists projects like ListProjects, including deleted
+// ones with ?include_deleted=true
+func (h *Handlers) AdminListProjects(c *gin.Context) {
+	h.listProjects(c, c.Query("include_deleted") == "true")
+}
+
+func (h *Handlers) listProjects(c *gin.Context, includeDeleted bool) {
 	filters := &services.ProjectFilters{
 		Status:   c.Query("status"),
 		Type:     c.Query("type"),
 		OwnerID:  c.Query("owner_id"),
 		SortBy:   c.Query("sort_by"),
 		SortDesc: c.Query("sort_order") == "desc",
+
+		IncludeDeleted: includeDeleted,
 	}
 
 	// Parse pagination
@@ -153,6 +426,13 @@ func (h *Handlers) UpdateProject(c *gin.Context) {
 		UpdatedBy:   userID,
 	})
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidRiskPolicy) || errors.Is(err, services.ErrInvalidSchedulingWeight) ||
+			errors.Is(err, services.ErrInvalidBudget) || errors.Is(err, services.ErrInvalidRedactionRules) ||
+			errors.Is(err, services.ErrInvalidSandboxPolicy) || errors.Is(err, services.ErrInvalidAgentPreferences) ||
+			errors.Is(err, services.ErrInvalidRegion) {
+			h.respondError(c, http.StatusBadRequest, "Invalid project settings", err)
+			return
+		}
 		h.respondError(c, http.StatusInternalServerError, "Failed to update project", err)
 		return
 	}
@@ -176,225 +456,2564 @@ func (h *Handlers) DeleteProject(c *gin.Context) {
 	h.respondSuccess(c, http.StatusOK, gin.H{"message": "Project deleted successfully"})
 }
 
-// Workflow Handlers
+// defaultIntentStatsWindow is the intent stats window when since is not given
+const defaultIntentStatsWindow = 7 * 24 * time.Hour
 
-// StartWorkflow starts a new workflow
-func (h *Handlers) StartWorkflow(c *gin.Context) {
-	var req StartWorkflowRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
-		return
-	}
+// defaultAgentStatsWindow is the agent stats window when since is not given
+const defaultAgentStatsWindow = 7 * 24 * time.Hour
 
-	userID := c.GetString("user_id")
-	if userID == "" {
-		userID = "system"
-	}
+// defaultEstimationAccuracyWindow is the estimation accuracy window when
+// since is not given
+const defaultEstimationAccuracyWindow = 30 * 24 * time.Hour
 
-	// Convert request to service format
-	startReq := &services.StartWorkflowRequest{
-		Name:           req.Name,
-		Description:    req.Description,
-		Type:           req.Type,
-		Priority:       req.Priority,
-		ProjectID:      req.ProjectID,
-		UserID:         userID,
-		Input:          req.Input,
-		Config:         req.Config,
-		MaxRetries:     req.MaxRetries,
-		TimeoutSeconds: req.TimeoutSeconds,
-	}
+// defaultFailureAnalysisWindow and maxFailureAnalysisWindow bound the window
+// of a failure analysis
+const (
+	defaultFailureAnalysisWindow = 24 * time.Hour
+	maxFailureAnalysisWindow     = 30 * 24 * time.Hour
+)
 
-	// Set defaults
-	if startReq.Priority == "" {
-		startReq.Priority = "medium"
+// defaultTimingWindow and maxTimingWindow bound the window of a timing
+// overview
+const (
+	defaultTimingWindow = 24 * time.Hour
+	maxTimingWindow     = 30 * 24 * time.Hour
+)
+
+// statsWindow parses the since and until query parameters of a stats
+// endpoint. until defaults to now and since to defaultWindow before until.
+// It responds with an error and returns false when they are invalid.
+func (h *Handlers) statsWindow(c *gin.Context, defaultWindow time.Duration) (since, until time.Time, ok bool) {
+	until = time.Now()
+	if value := c.Query("until"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "until must be an RFC3339 timestamp", err)
+			return since, until, false
+		}
+		until = t
 	}
-	if startReq.MaxRetries == 0 {
-		startReq.MaxRetries = 3
+	since = until.Add(-defaultWindow)
+	if value := c.Query("since"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "since must be an RFC3339 timestamp", err)
+			return since, until, false
+		}
+		since = t
 	}
-	if startReq.TimeoutSeconds == 0 {
-		startReq.TimeoutSeconds = 3600
+	if !since.Before(until) {
+		h.respondError(c, http.StatusBadRequest, "since must be before until", nil)
+		return since, until, false
 	}
+	return since, until, true
+}
 
-	response, err := h.workflowEngine.StartWorkflow(c.Request.Context(), startReq)
+// GetIntentStats aggregates a project's intent analyses over a time window.
+// The window defaults to the last 7 days.
+func (h *Handlers) GetIntentStats(c *gin.Context) {
+	projectID := c.Param("id")
+	if projectID == "" {
+		h.respondError(c, http.StatusBadRequest, "Project ID is required", nil)
+		return
+	}
+
+	since, until, ok := h.statsWindow(c, defaultIntentStatsWindow)
+	if !ok {
+		return
+	}
+
+	stats, err := h.projectService.GetIntentStats(c.Request.Context(), projectID, since, until)
 	if err != nil {
-		h.respondError(c, http.StatusInternalServerError, "Failed to start workflow", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to get intent stats", err)
 		return
 	}
 
-	h.respondSuccess(c, http.StatusCreated, response)
+	h.respondSuccess(c, http.StatusOK, stats)
 }
 
-// GetWorkflow retrieves workflow details
-func (h *Handlers) GetWorkflow(c *gin.Context) {
-	workflowID := c.Param("id")
-	if workflowID == "" {
-		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+// GetEstimationAccuracy reports how far a project's task estimates were from
+// the actual durations, per task type. The window defaults to the last 30 days.
+func (h *Handlers) GetEstimationAccuracy(c *gin.Context) {
+	projectID := c.Param("id")
+	if projectID == "" {
+		h.respondError(c, http.StatusBadRequest, "Project ID is required", nil)
 		return
 	}
 
-	workflow, err := h.workflowEngine.GetWorkflow(c.Request.Context(), workflowID)
+	since, until, ok := h.statsWindow(c, defaultEstimationAccuracyWindow)
+	if !ok {
+		return
+	}
+
+	accuracy, err := h.projectService.GetEstimationAccuracy(c.Request.Context(), projectID, since, until)
 	if err != nil {
-		h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+		h.respondError(c, http.StatusInternalServerError, "Failed to get estimation accuracy", err)
 		return
 	}
 
-	h.respondSuccess(c, http.StatusOK, workflow)
+	h.respondSuccess(c, http.StatusOK, accuracy)
 }
 
-// ListWorkflows lists workflows with filters
-func (h *Handlers) ListWorkflows(c *gin.Context) {
-	filters := &services.WorkflowFilters{
-		ProjectID: c.Query("project_id"),
-		Status:    c.Query("status"),
-		Type:      c.Query("type"),
-		CreatedBy: c.Query("created_by"),
-		SortBy:    c.Query("sort_by"),
-		SortDesc:  c.Query("sort_order") == "desc",
+// GetFailureAnalysis groups the workflows and executions of a project that
+// failed within the window, a duration such as 24h ending now, by error
+// signature
+func (h *Handlers) GetFailureAnalysis(c *gin.Context) {
+	projectID := c.Param("id")
+	if projectID == "" {
+		h.respondError(c, http.StatusBadRequest, "Project ID is required", nil)
+		return
 	}
 
-	// Parse dates
-	if startDate := c.Query("start_date"); startDate != "" {
-		if t, err := time.Parse(time.RFC3339, startDate); err == nil {
-			filters.StartDate = t
-		}
-	}
-	if endDate := c.Query("end_date"); endDate != "" {
-		if t, err := time.Parse(time.RFC3339, endDate); err == nil {
-			filters.EndDate = t
+	window := defaultFailureAnalysisWindow
+	if value := c.Query("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxFailureAnalysisWindow {
+			h.respondError(c, http.StatusBadRequest,
+				fmt.Sprintf("window must be a positive duration of at most %s", maxFailureAnalysisWindow), err)
+			return
 		}
+		window = parsed
 	}
 
-	// Parse pagination
-	if limit := c.Query("limit"); limit != "" {
-		if l, err := strconv.Atoi(limit); err == nil {
-			filters.Limit = l
-		}
+	until := time.Now()
+	analysis, err := h.projectService.GetFailureAnalysis(c.Request.Context(), projectID, until.Add(-window), until)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to get failure analysis", err)
+		return
 	}
-	if offset := c.Query("offset"); offset != "" {
-		if o, err := strconv.Atoi(offset); err == nil {
-			filters.Offset = o
-		}
+
+	h.respondSuccess(c, http.StatusOK, analysis)
+}
+
+// GetProjectStorage returns the artifact storage used by a project, by
+// artifact type, and its storage quota
+func (h *Handlers) GetProjectStorage(c *gin.Context) {
+	projectID := c.Param("id")
+	if projectID == "" {
+		h.respondError(c, http.StatusBadRequest, "Project ID is required", nil)
+		return
 	}
 
-	workflows, total, err := h.workflowEngine.ListWorkflows(c.Request.Context(), filters)
+	usage, err := h.projectService.GetProjectStorage(c.Request.Context(), projectID)
 	if err != nil {
-		h.respondError(c, http.StatusInternalServerError, "Failed to list workflows", err)
+		if errors.Is(err, services.ErrProjectNotFound) {
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get project storage", err)
 		return
 	}
 
-	h.respondSuccess(c, http.StatusOK, gin.H{
-		"workflows": workflows,
-		"total":     total,
-		"limit":     filters.Limit,
-		"offset":    filters.Offset,
-	})
+	h.respondSuccess(c, http.StatusOK, usage)
 }
 
-// CancelWorkflow cancels a running workflow
-func (h *Handlers) CancelWorkflow(c *gin.Context) {
-	workflowID := c.Param("id")
-	if workflowID == "" {
-		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+// BatchProjectStats returns the stats of several projects in one response,
+// leaving out those the caller cannot read. It serves
+// POST /projects/stats:batch; gin reads the colon as the start of a
+// parameter, so other methods are answered with 404.
+func (h *Handlers) BatchProjectStats(c *gin.Context) {
+	if c.Param("method") != ":batch" {
+		h.respondError(c, http.StatusNotFound, "Unknown project stats method", nil)
 		return
 	}
 
-	var req CancelWorkflowRequest
+	var req BatchProjectStatsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		req.Reason = "User requested cancellation"
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
 	}
 
-	if err := h.workflowEngine.CancelWorkflow(c.Request.Context(), workflowID, req.Reason); err != nil {
-		h.respondError(c, http.StatusInternalServerError, "Failed to cancel workflow", err)
+	// API keys and requests without authentication read every project
+	viewer := services.ProjectViewer{
+		UserID: c.GetString("user_id"),
+		All:    c.GetString("auth_type") == "api_key" || c.GetString("user_id") == "",
+	}
+	results, err := h.projectService.GetProjectStatsBatch(c.Request.Context(), req.ProjectIDs, viewer)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidProjectStatsBatch) {
+			h.respondError(c, http.StatusBadRequest, "Invalid project stats batch", err)
+			return
+		}
+		if deadline.Exhausted(err) {
+			h.respondError(c, http.StatusGatewayTimeout, "Request deadline exceeded", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get project stats", err)
 		return
 	}
 
-	h.respondSuccess(c, http.StatusOK, gin.H{"message": "Workflow cancelled successfully"})
+	h.respondSuccess(c, http.StatusOK, gin.H{"projects": results})
 }
 
-// GetWorkflowMetrics retrieves workflow metrics
-func (h *Handlers) GetWorkflowMetrics(c *gin.Context) {
-	workflowID := c.Param("id")
-	if workflowID == "" {
-		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+// GetProjectPolicy returns the sandbox policy that applies to a project:
+// its own lists merged over those of its organization and the defaults
+func (h *Handlers) GetProjectPolicy(c *gin.Context) {
+	if h.sandbox == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Sandbox policies are not configured", nil)
 		return
 	}
 
-	metrics, err := h.workflowEngine.GetWorkflowMetrics(c.Request.Context(), workflowID)
+	policy, err := h.sandbox.Policy(c.Request.Context(), c.Param("id"))
 	if err != nil {
-		h.respondError(c, http.StatusInternalServerError, "Failed to get workflow metrics", err)
+		if errors.Is(err, services.ErrProjectNotFound) {
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get project policy", err)
 		return
 	}
 
-	h.respondSuccess(c, http.StatusOK, metrics)
+	h.respondSuccess(c, http.StatusOK, policy)
 }
 
-// Agent Handlers
-
-// ListAgents lists available agents
-func (h *Handlers) ListAgents(c *gin.Context) {
-	filters := &services.AgentFilters{
-		ProjectID: c.Query("project_id"),
-		Type:      c.Query("type"),
-		Status:    c.Query("status"),
+// GetAgentPreferences returns the agents a project prefers and excludes,
+// with the boost its preferred agents get
+func (h *Handlers) GetAgentPreferences(c *gin.Context) {
+	if h.agentPrefs == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Agent preferences are not configured", nil)
+		return
 	}
 
-	// Parse pagination
-	if page := c.Query("page"); page != "" {
-		if p, err := strconv.Atoi(page); err == nil {
-			filters.Page = p
+	prefs, err := h.agentPrefs.Preferences(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrProjectNotFound) {
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+			return
 		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get agent preferences", err)
+		return
 	}
-	if pageSize := c.Query("page_size"); pageSize != "" {
-		if ps, err := strconv.Atoi(pageSize); err == nil {
-			filters.PageSize = ps
-		}
+
+	h.respondSuccess(c, http.StatusOK, prefs)
+}
+
+// UpdateAgentPreferences replaces the agent preferences of a project. Every
+// agent they name must exist.
+func (h *Handlers) UpdateAgentPreferences(c *gin.Context) {
+	if h.agentPrefs == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Agent preferences are not configured", nil)
+		return
 	}
 
-	agentList, err := h.agentClient.ListAgents(c.Request.Context(), filters)
+	var req models.AgentPreferences
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	prefs, err := h.agentPrefs.Update(c.Request.Context(), c.Param("id"), req)
 	if err != nil {
-		h.respondError(c, http.StatusInternalServerError, "Failed to list agents", err)
+		switch {
+		case errors.Is(err, services.ErrInvalidAgentPreferences):
+			h.respondError(c, http.StatusBadRequest, "Invalid agent preferences", err)
+		case errors.Is(err, services.ErrProjectNotFound):
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+		default:
+			h.respondAgentManagerError(c, "Failed to update agent preferences", err)
+		}
 		return
 	}
 
-	h.respondSuccess(c, http.StatusOK, agentList)
+	h.respondSuccess(c, http.StatusOK, prefs)
 }
 
-// GetAgent retrieves agent details
-func (h *Handlers) GetAgent(c *gin.Context) {
-	agentID := c.Param("id")
-	if agentID == "" {
-		h.respondError(c, http.StatusBadRequest, "Agent ID is required", nil)
+// Variable Handlers
+
+// GetProjectVariables returns a project's environment variables
+func (h *Handlers) GetProjectVariables(c *gin.Context) {
+	if h.variables == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Project variables are not configured", nil)
 		return
 	}
 
-	agent, err := h.agentClient.GetAgent(c.Request.Context(), agentID)
+	vars, err := h.variables.GetProjectVariables(c.Request.Context(), c.Param("id"))
 	if err != nil {
-		h.respondError(c, http.StatusNotFound, "Agent not found", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get project variables", err)
 		return
 	}
 
-	h.respondSuccess(c, http.StatusOK, agent)
+	h.respondSuccess(c, http.StatusOK, gin.H{"variables": vars})
 }
 
-// RestartAgent restarts an agent
-func (h *Handlers) RestartAgent(c *gin.Context) {
-	agentID := c.Param("id")
-	if agentID == "" {
-		h.respondError(c, http.StatusBadRequest, "Agent ID is required", nil)
+// SetProjectVariables replaces a project's environment variables
+func (h *Handlers) SetProjectVariables(c *gin.Context) {
+	if h.variables == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Project variables are not configured", nil)
 		return
 	}
 
-	// Update agent status to trigger restart
-	_, err := h.agentClient.UpdateAgent(c.Request.Context(), agentID, &services.UpdateAgentRequest{
-		Status: "restarting",
-	})
-	if err != nil {
-		h.respondError(c, http.StatusInternalServerError, "Failed to restart agent", err)
+	var req SetProjectVariablesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
 		return
 	}
 
-	h.respondSuccess(c, http.StatusOK, gin.H{"message": "Agent restart initiated"})
+	if err := h.variables.SetProjectVariables(c.Request.Context(), c.Param("id"), req.Variables); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidVariableName):
+			h.respondError(c, http.StatusBadRequest, "Invalid variable name", err)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to update project variables", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"variables": req.Variables})
+}
+
+// secretProvider returns the secret provider, responding with an error when
+// secrets are not available
+func (h *Handlers) secretProvider(c *gin.Context) services.SecretProvider {
+	if h.variables == nil || h.variables.Secrets() == nil {
+		h.respondError(c, http.StatusConflict, "Secrets require encryption at rest to be enabled", nil)
+		return nil
+	}
+	return h.variables.Secrets()
+}
+
+// ListProjectSecrets lists the names of a project's secrets. Values are
+// write-only and never returned.
+func (h *Handlers) ListProjectSecrets(c *gin.Context) {
+	secrets := h.secretProvider(c)
+	if secrets == nil {
+		return
+	}
+
+	names, err := secrets.ListSecrets(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to list project secrets", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"secrets": names})
+}
+
+// SetProjectSecret creates or replaces a project secret
+func (h *Handlers) SetProjectSecret(c *gin.Context) {
+	secrets := h.secretProvider(c)
+	if secrets == nil {
+		return
+	}
+
+	var req SetProjectSecretRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	name := c.Param("name")
+	if err := secrets.SetSecret(c.Request.Context(), c.Param("id"), name, req.Value); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidVariableName):
+			h.respondError(c, http.StatusBadRequest, "Invalid secret name", err)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to set project secret", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"name": name})
+}
+
+// DeleteProjectSecret deletes a project secret
+func (h *Handlers) DeleteProjectSecret(c *gin.Context) {
+	secrets := h.secretProvider(c)
+	if secrets == nil {
+		return
+	}
+
+	name := c.Param("name")
+	if err := secrets.DeleteSecret(c.Request.Context(), c.Param("id"), name); err != nil {
+		switch {
+		case errors.Is(err, services.ErrSecretNotFound):
+			h.respondError(c, http.StatusNotFound, "Secret not found", err)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to delete project secret", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"name": name, "deleted": true})
+}
+
+// Resource Handlers
+
+// resourceService returns the resource service, responding with an error
+// when resources are not configured
+func (h *Handlers) resourceService(c *gin.Context) *services.ResourceService {
+	if h.resources == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Project resources are not configured", nil)
+	}
+	return h.resources
+}
+
+// CreateResource records a resource and starts provisioning it
+func (h *Handlers) CreateResource(c *gin.Context) {
+	resources := h.resourceService(c)
+	if resources == nil {
+		return
+	}
+
+	var req services.CreateResourceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	userID := requestUserID(c)
+
+	resource, err := resources.CreateResource(c.Request.Context(), c.Param("id"), userID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownResourceProvider):
+			h.respondError(c, http.StatusBadRequest, "Unknown resource provider", err)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to create resource", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusAccepted, resource)
+}
+
+// ListResources returns a project's resources, filtered by
+// ?status=ready&status=failed or ?status=ready,failed
+func (h *Handlers) ListResources(c *gin.Context) {
+	resources := h.resourceService(c)
+	if resources == nil {
+		return
+	}
+
+	var statuses []models.ResourceStatus
+	for _, value := range c.QueryArray("status") {
+		for _, status := range splitCSV(value) {
+			statuses = append(statuses, models.ResourceStatus(status))
+		}
+	}
+
+	list, err := resources.ListResources(c.Request.Context(), c.Param("id"), statuses)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidResourceStatus) {
+			h.respondError(c, http.StatusBadRequest, "Invalid status filter", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to list resources", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, list)
+}
+
+// GetResource returns a project resource
+func (h *Handlers) GetResource(c *gin.Context) {
+	resources := h.resourceService(c)
+	if resources == nil {
+		return
+	}
+
+	resource, err := resources.GetResource(c.Request.Context(), c.Param("id"), c.Param("rid"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Resource not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get resource", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, resource)
+}
+
+// DeleteResource starts terminating a project resource
+func (h *Handlers) DeleteResource(c *gin.Context) {
+	resources := h.resourceService(c)
+	if resources == nil {
+		return
+	}
+
+	resource, err := resources.TerminateResource(c.Request.Context(), c.Param("id"), c.Param("rid"))
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Resource not found", err)
+		case errors.Is(err, services.ErrResourceProvisioning):
+			h.respondError(c, http.StatusConflict, "Resource is still provisioning", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to terminate resource", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusAccepted, resource)
+}
+
+// PromoteEnvironment copies the config and variables of an environment into
+// a target environment in a promotion workflow, optionally deploying the
+// target after. Dry runs only return the diff.
+func (h *Handlers) PromoteEnvironment(c *gin.Context) {
+	if h.promotions == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Environment promotion is not configured", nil)
+		return
+	}
+
+	var req services.PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	response, err := h.promotions.Promote(c.Request.Context(), c.Param("id"), c.Param("env"), requestUserID(c), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrPromotionConflict):
+			h.respondError(c, http.StatusConflict, "Target environment was modified since its last promotion, promote with force to overwrite it", err)
+		case errors.Is(err, services.ErrInvalidPromotion):
+			h.respondError(c, http.StatusBadRequest, "Invalid environment promotion", err)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Environment not found", err)
+		default:
+			h.respondWorkflowError(c, "Failed to promote environment", err)
+		}
+		return
+	}
+
+	if response.DryRun {
+		h.respondSuccess(c, http.StatusOK, response)
+		return
+	}
+	h.respondSuccess(c, http.StatusAccepted, response)
+}
+
+// Workflow Handlers
+
+// StartWorkflow starts a new workflow. With force=true, code analysis
+// workflows analyze the code again instead of reusing a cached report.
+func (h *Handlers) StartWorkflow(c *gin.Context) {
+	var req StartWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	startReq := newStartWorkflowRequest(&req, requestUserID(c))
+	startReq.BypassCache = c.Query("force") == "true"
+	startReq.Dedupe = c.Query("dedupe")
+	response, err := h.workflowEngine.StartWorkflow(c.Request.Context(), startReq)
+	if err != nil {
+		h.respondWorkflowError(c, "Failed to start workflow", err)
+		return
+	}
+
+	// dedupe=reuse answers with the workflow already in flight
+	if response.Reused {
+		h.respondSuccess(c, http.StatusOK, response)
+		return
+	}
+	h.respondSuccess(c, http.StatusCreated, response)
+}
+
+// ValidateWorkflow checks a workflow request, and the plan steps it sets,
+// without starting the workflow. Invalid requests fail as they would start.
+func (h *Handlers) ValidateWorkflow(c *gin.Context) {
+	var req ValidateWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	err := h.workflowEngine.ValidateWorkflow(c.Request.Context(),
+		newStartWorkflowRequest(&req.StartWorkflowRequest, requestUserID(c)), req.Steps)
+	if err != nil {
+		h.respondWorkflowError(c, "Failed to validate workflow", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"valid": true})
+}
+
+// CreateWorkflowDraft saves a workflow without starting it
+func (h *Handlers) CreateWorkflowDraft(c *gin.Context) {
+	var req WorkflowDraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	draft, err := h.workflowEngine.CreateWorkflowDraft(c.Request.Context(),
+		newStartWorkflowRequest((*StartWorkflowRequest)(&req), requestUserID(c)))
+	if err != nil {
+		h.respondWorkflowError(c, "Failed to create workflow draft", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusCreated, draft)
+}
+
+// UpdateWorkflowDraft replaces the settings of a draft
+func (h *Handlers) UpdateWorkflowDraft(c *gin.Context) {
+	var req WorkflowDraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	draft, err := h.workflowEngine.UpdateWorkflowDraft(c.Request.Context(), c.Param("id"),
+		newStartWorkflowRequest((*StartWorkflowRequest)(&req), requestUserID(c)))
+	if err != nil {
+		h.respondWorkflowError(c, "Failed to update workflow draft", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, draft)
+}
+
+// SubmitWorkflowDraft starts a draft
+func (h *Handlers) SubmitWorkflowDraft(c *gin.Context) {
+	response, err := h.workflowEngine.SubmitWorkflowDraft(c.Request.Context(), c.Param("id"), requestUserID(c))
+	if err != nil {
+		h.respondWorkflowError(c, "Failed to submit workflow draft", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, response)
+}
+
+// RerunWorkflow starts a new workflow from a finished one. The from query
+// parameter is the phase it resumes after: start (the default) repeats every
+// phase, analysis and plan reuse the intent analysis and execution plan
+// checkpoints of intent workflows.
+func (h *Handlers) RerunWorkflow(c *gin.Context) {
+	response, err := h.workflowEngine.RerunWorkflow(c.Request.Context(), c.Param("id"), c.Query("from"), requestUserID(c))
+	if err != nil {
+		h.respondWorkflowError(c, "Failed to re-run workflow", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusCreated, response)
+}
+
+// DeleteWorkflowDraft discards a draft
+func (h *Handlers) DeleteWorkflowDraft(c *gin.Context) {
+	if err := h.workflowEngine.DeleteWorkflowDraft(c.Request.Context(), c.Param("id")); err != nil {
+		h.respondWorkflowError(c, "Failed to delete workflow draft", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"message": "Workflow draft deleted"})
+}
+
+// GetTemplateCatalog lists the public workflow templates of every project
+// with their usage, filtered by type and tags (?tag=a&tag=b or ?tags=a,b) and
+// sorted by popularity unless sort says otherwise
+func (h *Handlers) GetTemplateCatalog(c *gin.Context) {
+	if h.templates == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Workflow templates are not configured", nil)
+		return
+	}
+
+	filter := services.TemplateCatalogFilter{
+		Type: c.Query("type"),
+		Tags: append(c.QueryArray("tag"), splitCSV(c.Query("tags"))...),
+		Sort: c.Query("sort"),
+	}
+	for param, value := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if raw := c.Query(param); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				h.respondError(c, http.StatusBadRequest, "Invalid "+param, err)
+				return
+			}
+			*value = n
+		}
+	}
+
+	page, err := h.templates.Catalog(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCatalogQuery) {
+			h.respondError(c, http.StatusBadRequest, "Invalid catalog query", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to list workflow templates", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, page)
+}
+
+// CloneWorkflowTemplate copies a template into the project of the request
+// as a new template recording where it was cloned from
+func (h *Handlers) CloneWorkflowTemplate(c *gin.Context) {
+	if h.templates == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Workflow templates are not configured", nil)
+		return
+	}
+
+	var req CloneWorkflowTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	clone, err := h.templates.CloneTemplate(c.Request.Context(), c.Param("id"), req.ProjectID, req.Name, requestUserID(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrTemplateNotFound):
+			h.respondError(c, http.StatusNotFound, "Workflow template not found", err)
+		case errors.Is(err, services.ErrProjectNotFound):
+			h.respondError(c, http.StatusNotFound, "Project not found", err)
+		case errors.Is(err, services.ErrTemplateNameTaken):
+			h.respondError(c, http.StatusConflict, "Workflow template name already taken", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to clone workflow template", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusCreated, clone)
+}
+
+// newStartWorkflowRequest converts a workflow request to the service
+// format, applying the defaults
+func newStartWorkflowRequest(req *StartWorkflowRequest, userID string) *services.StartWorkflowRequest {
+	startReq := &services.StartWorkflowRequest{
+		Name:           req.Name,
+		Description:    req.Description,
+		Type:           req.Type,
+		Priority:       req.Priority,
+		ProjectID:      req.ProjectID,
+		UserID:         userID,
+		Input:          req.Input,
+		Config:         req.Config,
+		Tags:           req.Tags,
+		Labels:         req.Labels,
+		MaxRetries:     req.MaxRetries,
+		TimeoutSeconds: req.TimeoutSeconds,
+
+		SLASeconds:          req.SLASeconds,
+		SLAWarningThreshold: req.SLAWarningThreshold,
+		Budget:              req.Budget,
+		TemplateID:          req.TemplateID,
+		Source:              req.Source,
+	}
+
+	// Set defaults
+	if startReq.Priority == "" {
+		startReq.Priority = "medium"
+	}
+	if startReq.MaxRetries == 0 {
+		startReq.MaxRetries = 3
+	}
+	if startReq.TimeoutSeconds == 0 {
+		startReq.TimeoutSeconds = 3600
+	}
+	return startReq
+}
+
+// requestUserID returns the authenticated user, or "system"
+func requestUserID(c *gin.Context) string {
+	if userID := c.GetString("user_id"); userID != "" {
+		return userID
+	}
+	return "system"
+}
+
+// respondWorkflowError maps the errors of starting a workflow or changing a
+// draft to a status, answering 500 with message for the others
+func (h *Handlers) respondWorkflowError(c *gin.Context, message string, err error) {
+	switch {
+	case deadline.Exhausted(err):
+		h.respondError(c, http.StatusGatewayTimeout, "Request deadline exceeded", err)
+	case errors.Is(err, services.ErrInvalidTags):
+		h.respondError(c, http.StatusBadRequest, "Invalid workflow tags", err)
+	case errors.Is(err, services.ErrInvalidSimulationConfig):
+		h.respondError(c, http.StatusBadRequest, "Invalid simulation config", err)
+	case errors.Is(err, services.ErrInvalidWorkflowConfig):
+		h.respondError(c, http.StatusBadRequest, "Invalid workflow config", err)
+	case errors.Is(err, services.ErrUnknownWorkflowType):
+		h.respondError(c, http.StatusBadRequest, "Unknown workflow type", err)
+	case errors.Is(err, services.ErrWorkflowTypeNotAllowed):
+		h.respondError(c, http.StatusUnprocessableEntity, "Workflow type not allowed by sandbox policy", err)
+	case errors.Is(err, services.ErrInvalidWorkflowInput):
+		h.respondError(c, http.StatusBadRequest, "Invalid workflow input", err)
+	case errors.Is(err, services.ErrInvalidSLA):
+		h.respondError(c, http.StatusBadRequest, "Invalid workflow SLA", err)
+	case errors.Is(err, services.ErrInvalidBudget):
+		h.respondError(c, http.StatusBadRequest, "Invalid workflow budget", err)
+	case errors.Is(err, services.ErrInvalidPlan):
+		h.respondError(c, http.StatusBadRequest, "Invalid execution plan", err)
+	case errors.Is(err, services.ErrInvalidTemplate):
+		h.respondError(c, http.StatusBadRequest, "Invalid workflow template", err)
+	case errors.Is(err, services.ErrInvalidWorkflowSource):
+		h.respondError(c, http.StatusBadRequest, "Invalid workflow source", err)
+	case errors.Is(err, services.ErrIncompleteDraft):
+		h.respondError(c, http.StatusBadRequest, "Workflow draft is incomplete", err)
+	case errors.Is(err, services.ErrInvalidRerunPhase):
+		h.respondError(c, http.StatusBadRequest, "Invalid re-run phase", err)
+	case errors.Is(err, services.ErrInvalidDedupeMode):
+		h.respondError(c, http.StatusBadRequest, "Invalid dedupe mode", err)
+	case errors.Is(err, services.ErrDuplicateWorkflow):
+		h.respondError(c, http.StatusConflict, "Duplicate workflow in flight", err)
+	case errors.Is(err, services.ErrAgentManagerIncompatible):
+		h.respondError(c, http.StatusServiceUnavailable, "Agent manager is incompatible", err)
+	case errors.Is(err, services.ErrMaintenanceMode):
+		h.respondError(c, http.StatusServiceUnavailable, "Service is in maintenance mode", err)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+	case errors.Is(err, services.ErrProjectNotFound):
+		h.respondError(c, http.StatusNotFound, "Project not found", err)
+	case errors.Is(err, services.ErrNotDraft):
+		h.respondError(c, http.StatusConflict, "Workflow is not a draft", err)
+	case errors.Is(err, services.ErrWorkflowNotRerunnable):
+		h.respondError(c, http.StatusConflict, "Workflow has not finished", err)
+	case errors.Is(err, services.ErrCheckpointNotFound):
+		h.respondError(c, http.StatusNotFound, "Workflow checkpoint not found", err)
+	case errors.Is(err, services.ErrCheckpointSchemaMismatch):
+		h.respondError(c, http.StatusConflict, "Workflow checkpoint is outdated", err)
+	default:
+		h.respondError(c, http.StatusInternalServerError, message, err)
+	}
+}
+
+// GetWorkflow retrieves workflow details
+func (h *Handlers) GetWorkflow(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	workflow, err := h.workflowEngine.GetWorkflow(c.Request.Context(), workflowID)
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+		return
+	}
+
+	// The analysis and risk review are supplementary; the workflow is
+	// returned without them when they cannot be read
+	analysis, err := h.workflowEngine.GetIntentAnalysis(c.Request.Context(), workflowID)
+	if err != nil {
+		h.logger.Warn("failed to get intent analysis",
+			zap.String("workflow_id", workflowID),
+			zap.Error(err))
+	}
+	review, err := h.workflowEngine.GetIntentRiskReview(c.Request.Context(), workflowID)
+	if err != nil {
+		h.logger.Warn("failed to get intent risk review",
+			zap.String("workflow_id", workflowID),
+			zap.Error(err))
+	}
+
+	for i := range workflow.Executions {
+		setFullOutputURL(&workflow.Executions[i])
+	}
+	detail := WorkflowDetail{Workflow: workflow, Analysis: analysis, RiskReview: review}
+	if review != nil && review.Status == models.RiskReviewPending {
+		detail.PendingRisks = review.TriggeredRisks
+	}
+	runs, err := h.workflowEngine.CountWorkflowRuns(c.Request.Context(), workflowID)
+	if err != nil {
+		h.logger.Warn("failed to count workflow runs",
+			zap.String("workflow_id", workflowID),
+			zap.Error(err))
+	}
+	detail.RunCount = runs
+	if h.comments != nil {
+		count, err := h.comments.CountComments(c.Request.Context(), workflowID)
+		if err != nil {
+			h.logger.Warn("failed to count workflow comments",
+				zap.String("workflow_id", workflowID),
+				zap.Error(err))
+		}
+		detail.CommentCount = count
+	}
+	h.respondSuccess(c, http.StatusOK, detail)
+}
+
+// ListWorkflows lists workflow summaries with filters.
+// Full workflow details, including input and output, are only returned by GetWorkflow.
+func (h *Handlers) ListWorkflows(c *gin.Context) {
+	h.listWorkflows(c, false)
+}
+
+// AdminListWorkflows lists workflow summaries like ListWorkflows, including
+// deleted workflows with ?include_deleted=true
+func (h *Handlers) AdminListWorkflows(c *gin.Context) {
+	h.listWorkflows(c, c.Query("include_deleted") == "true")
+}
+
+func (h *Handlers) listWorkflows(c *gin.Context, includeDeleted bool) {
+	filters := &services.WorkflowFilters{
+		ProjectID: c.Query("project_id"),
+		Status:    c.Query("status"),
+		Type:      c.Query("type"),
+		CreatedBy: c.Query("created_by"),
+		SLAStatus: c.Query("sla_status"),
+		SortBy:    c.Query("sort_by"),
+		SortDesc:  c.Query("sort_order") == "desc",
+
+		Commit:     c.Query("commit"),
+		Repository: c.Query("repository"),
+
+		IncludeDrafts:  c.Query("include_drafts") == "true",
+		IncludeDeleted: includeDeleted,
+	}
+	if filters.SLAStatus != "" && !services.IsValidSLAStatus(filters.SLAStatus) {
+		h.respondError(c, http.StatusBadRequest, "Invalid sla_status, expected on_track, at_risk or breached", nil)
+		return
+	}
+
+	// Parse tag and label filters (?tag=a&tag=b or ?tags=a,b; ?label=key=value)
+	for _, tag := range append(c.QueryArray("tag"), splitCSV(c.Query("tags"))...) {
+		filters.Tags = append(filters.Tags, tag)
+	}
+	for _, label := range c.QueryArray("label") {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			h.respondError(c, http.StatusBadRequest, "Invalid label filter, expected key=value", nil)
+			return
+		}
+		if filters.Labels == nil {
+			filters.Labels = make(map[string]string)
+		}
+		filters.Labels[key] = value
+	}
+
+	// Parse dates
+	if startDate := c.Query("start_date"); startDate != "" {
+		if t, err := time.Parse(time.RFC3339, startDate); err == nil {
+			filters.StartDate = t
+		}
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		if t, err := time.Parse(time.RFC3339, endDate); err == nil {
+			filters.EndDate = t
+		}
+	}
+
+	// Parse pagination
+	if limit := c.Query("limit"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			filters.Limit = l
+		}
+	}
+	if offset := c.Query("offset"); offset != "" {
+		if o, err := strconv.Atoi(offset); err == nil {
+			filters.Offset = o
+		}
+	}
+
+	workflows, total, err := h.workflowEngine.ListWorkflowSummaries(c.Request.Context(), filters)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to list workflows", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"workflows": workflows,
+		"total":     total,
+		"limit":     filters.Limit,
+		"offset":    filters.Offset,
+	})
+}
+
+// ListWorkflowsFromTemporal runs a Temporal visibility query against the
+// orchestrator's workflows, e.g. ?query=ProjectId = 'p1' AND Priority = 'high'.
+// Queries are not scoped to a project, so the route is for admins only.
+func (h *Handlers) ListWorkflowsFromTemporal(c *gin.Context) {
+	workflows, err := h.workflowEngine.ListWorkflowsFromTemporal(c.Request.Context(), c.Query("query"))
+	if errors.Is(err, services.ErrInvalidVisibilityQuery) {
+		h.respondError(c, http.StatusBadRequest, "Invalid visibility query", err)
+		return
+	}
+	if err != nil {
+		h.respondError(c, http.StatusBadGateway, "Failed to query Temporal visibility", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"workflows": workflows,
+		"count":     len(workflows),
+	})
+}
+
+// UpdateWorkflowTags modifies the tags of an existing workflow. Temporal
+// visibility keeps the tags the workflow was started with.
+func (h *Handlers) UpdateWorkflowTags(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	var req UpdateWorkflowTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if req.Tags == nil && len(req.Add) == 0 && len(req.Remove) == 0 {
+		h.respondError(c, http.StatusBadRequest, "One of tags, add or remove is required", nil)
+		return
+	}
+
+	workflow, err := h.workflowEngine.UpdateWorkflowTags(c.Request.Context(), workflowID, req.Tags, req.Add, req.Remove)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		if errors.Is(err, services.ErrInvalidTags) {
+			h.respondError(c, http.StatusBadRequest, "Invalid workflow tags", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to update workflow tags", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"workflow_id": workflow.ID,
+		"tags":        workflow.Tags,
+	})
+}
+
+// CancelWorkflow cancels a running workflow
+func (h *Handlers) CancelWorkflow(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	var req CancelWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		req.Reason = "User requested cancellation"
+	}
+
+	if err := h.workflowEngine.CancelWorkflow(c.Request.Context(), workflowID, req.Reason, requestUserID(c)); err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to cancel workflow", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"message": "Workflow cancelled successfully"})
+}
+
+// ConfirmIntentRisks confirms or denies the risks of a workflow waiting for
+// their confirmation
+func (h *Handlers) ConfirmIntentRisks(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	var req ConfirmIntentRisksRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	review, err := h.workflowEngine.ConfirmIntentRisks(c.Request.Context(), workflowID, services.RiskConfirmation{
+		Confirmed: *req.Confirmed,
+		Actor:     requestUserID(c),
+		Comment:   req.Comment,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+		case errors.Is(err, services.ErrNoPendingRiskReview):
+			h.respondError(c, http.StatusConflict, "Workflow has no risks pending confirmation", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to confirm intent risks", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusAccepted, gin.H{
+		"workflow_id":     workflowID,
+		"confirmed":       *req.Confirmed,
+		"triggered_risks": review.TriggeredRisks,
+	})
+}
+
+// SkipWorkflowStep has a running workflow skip one of its steps, continuing
+// as if the step succeeded
+func (h *Handlers) SkipWorkflowStep(c *gin.Context) {
+	// The body is optional
+	var req SkipWorkflowStepRequest
+	_ = c.ShouldBindJSON(&req)
+	h.overrideWorkflowStep(c, services.StepOverride{
+		Action: services.StepOverrideSkip,
+		Reason: req.Reason,
+	})
+}
+
+// CompleteWorkflowStep completes a step of a running workflow by hand; the
+// steps depending on it receive the supplied output
+func (h *Handlers) CompleteWorkflowStep(c *gin.Context) {
+	var req CompleteWorkflowStepRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	h.overrideWorkflowStep(c, services.StepOverride{
+		Action: services.StepOverrideComplete,
+		Output: req.Output,
+		Reason: req.Reason,
+	})
+}
+
+// overrideWorkflowStep sends the override of the step in the path to its
+// workflow on behalf of the requesting user
+func (h *Handlers) overrideWorkflowStep(c *gin.Context, override services.StepOverride) {
+	workflowID := c.Param("id")
+	override.StepID = c.Param("step_id")
+	if workflowID == "" || override.StepID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID and step ID are required", nil)
+		return
+	}
+	override.Actor = requestUserID(c)
+
+	if err := h.workflowEngine.OverrideStep(c.Request.Context(), workflowID, override); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+		case errors.Is(err, services.ErrOverrideForbidden):
+			h.respondError(c, http.StatusForbidden, "Overriding steps requires the "+services.PermissionOverrideSteps+" permission", err)
+		case errors.Is(err, services.ErrStepFinished):
+			h.respondError(c, http.StatusConflict, "Step already finished", err)
+		case errors.Is(err, services.ErrWorkflowNotOverridable):
+			h.respondError(c, http.StatusConflict, "Workflow is not running", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to override step", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusAccepted, gin.H{
+		"workflow_id": workflowID,
+		"step_id":     override.StepID,
+		"action":      override.Action,
+	})
+}
+
+// GetWorkflowMetrics retrieves workflow metrics
+func (h *Handlers) GetWorkflowMetrics(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	metrics, err := h.workflowEngine.GetWorkflowMetrics(c.Request.Context(), workflowID)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to get workflow metrics", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, metrics)
+}
+
+// GetMetricsOverview splits the time of the workflows and executions of a
+// project, or of every project without project_id, started within the
+// window, a duration such as 24h ending now, into queue wait and execution
+func (h *Handlers) GetMetricsOverview(c *gin.Context) {
+	window := defaultTimingWindow
+	if value := c.Query("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxTimingWindow {
+			h.respondError(c, http.StatusBadRequest,
+				fmt.Sprintf("window must be a positive duration of at most %s", maxTimingWindow), err)
+			return
+		}
+		window = parsed
+	}
+
+	until := time.Now()
+	overview, err := h.workflowEngine.GetTimingOverview(c.Request.Context(), c.Query("project_id"), until.Add(-window), until)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to get metrics overview", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, overview)
+}
+
+// GetPendingActivities reports a workflow's pending activities with their
+// heartbeat state, flagging activities that are likely stuck
+func (h *Handlers) GetPendingActivities(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	activities, err := h.workflowEngine.GetPendingActivities(c.Request.Context(), workflowID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get pending activities", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"workflow_id": workflowID,
+		"activities":  activities,
+	})
+}
+
+// ListWorkflowRuns lists the Temporal runs of a workflow, oldest first: its
+// first run, retries and continuations
+func (h *Handlers) ListWorkflowRuns(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	runs, err := h.workflowEngine.ListWorkflowRuns(c.Request.Context(), workflowID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to list workflow runs", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"workflow_id": workflowID,
+		"runs":        runs,
+		"total":       len(runs),
+	})
+}
+
+// GetWorkflowState reports the current phase, steps, artifacts and last
+// error of a workflow, as the workflow itself answers them while running
+func (h *Handlers) GetWorkflowState(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	state, err := h.workflowEngine.GetWorkflowState(c.Request.Context(), workflowID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get workflow state", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, state)
+}
+
+// GetWorkflowReport renders the execution trace of a workflow as a
+// downloadable report (?format=json|markdown)
+func (h *Handlers) GetWorkflowReport(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	renderer, err := services.ReportRendererFor(format)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Unsupported report format", err)
+		return
+	}
+
+	report, err := h.reports.Build(c.Request.Context(), workflowID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to build workflow report", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := renderer.Render(&buf, report); err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to render workflow report", err)
+		return
+	}
+
+	if format != "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="workflow-%s-report.%s"`, workflowID, renderer.FileExtension()))
+	}
+	c.Data(http.StatusOK, renderer.ContentType(), buf.Bytes())
+}
+
+// CompareWorkflows diffs a workflow run against another run of the same
+// project and type (?with=:other_id)
+func (h *Handlers) CompareWorkflows(c *gin.Context) {
+	workflowID := c.Param("id")
+	otherID := c.Query("with")
+	if workflowID == "" || otherID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID and the workflow to compare with are required", nil)
+		return
+	}
+
+	comparison, err := h.comparer.Compare(c.Request.Context(), workflowID, otherID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		if errors.Is(err, services.ErrWorkflowsNotComparable) {
+			h.respondError(c, http.StatusBadRequest, "Workflows cannot be compared", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to compare workflows", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, comparison)
+}
+
+// GetWorkflowGraph returns a workflow's steps as a DAG with live node status
+// and layout ranks
+func (h *Handlers) GetWorkflowGraph(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+
+	graph, err := h.graphs.Build(c.Request.Context(), workflowID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to build workflow graph", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, graph)
+}
+
+// CreateWorkflowShare creates a signed, expiring link to a read-only view of
+// a workflow
+func (h *Handlers) CreateWorkflowShare(c *gin.Context) {
+	workflowID := c.Param("id")
+	if workflowID == "" {
+		h.respondError(c, http.StatusBadRequest, "Workflow ID is required", nil)
+		return
+	}
+	if h.shares == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Workflow sharing is not configured", nil)
+		return
+	}
+
+	// The body is optional
+	var req CreateWorkflowShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+	}
+
+	share, err := h.shares.CreateShareToken(c.Request.Context(), workflowID, time.Duration(req.ExpiresInSeconds)*time.Second)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+		case errors.Is(err, services.ErrInvalidShareTTL):
+			h.respondError(c, http.StatusBadRequest, "Invalid expires_in_seconds", err)
+		case errors.Is(err, services.ErrSharingDisabled):
+			h.respondError(c, http.StatusServiceUnavailable, "Workflow sharing is not configured", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Failed to create share link", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusCreated, share)
+}
+
+// RevokeWorkflowShare revokes a share link by token ID
+func (h *Handlers) RevokeWorkflowShare(c *gin.Context) {
+	tokenID := c.Param("token_id")
+	if tokenID == "" {
+		h.respondError(c, http.StatusBadRequest, "Token ID is required", nil)
+		return
+	}
+	if h.shares == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Workflow sharing is not configured", nil)
+		return
+	}
+
+	if err := h.shares.RevokeShareToken(c.Request.Context(), c.Param("id"), tokenID); err != nil {
+		if errors.Is(err, services.ErrSharingDisabled) {
+			h.respondError(c, http.StatusServiceUnavailable, "Workflow sharing is not configured", err)
+			return
+		}
+		if errors.Is(err, services.ErrShareTokenNotFound) {
+			h.respondError(c, http.StatusNotFound, "Share link not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to revoke share link", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"token_id": tokenID, "revoked": true})
+}
+
+// commentService returns the comment service, responding with an error
+// when comments are not configured
+func (h *Handlers) commentService(c *gin.Context) *services.WorkflowCommentService {
+	if h.comments == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Workflow comments are not configured", nil)
+	}
+	return h.comments
+}
+
+// respondCommentError maps comment service errors to responses
+func (h *Handlers) respondCommentError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidComment):
+		h.respondError(c, http.StatusBadRequest, "Invalid comment", err)
+	case errors.Is(err, services.ErrCommentForbidden):
+		h.respondError(c, http.StatusForbidden, "Only the author or a project admin may change the comment", err)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		h.respondError(c, http.StatusNotFound, "Comment or workflow not found", err)
+	default:
+		h.respondError(c, http.StatusInternalServerError, message, err)
+	}
+}
+
+// CreateWorkflowComment adds a comment to a workflow as the requesting user
+func (h *Handlers) CreateWorkflowComment(c *gin.Context) {
+	comments := h.commentService(c)
+	if comments == nil {
+		return
+	}
+
+	var req services.CreateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	comment, err := comments.CreateComment(c.Request.Context(), c.Param("id"), requestUserID(c), &req)
+	if err != nil {
+		h.respondCommentError(c, "Failed to create comment", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusCreated, comment)
+}
+
+// ListWorkflowComments returns a page of a workflow's comments, pinned
+// comments first (?limit=50&offset=0)
+func (h *Handlers) ListWorkflowComments(c *gin.Context) {
+	comments := h.commentService(c)
+	if comments == nil {
+		return
+	}
+
+	var limit, offset int
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil {
+		limit = l
+	}
+	if o, err := strconv.Atoi(c.Query("offset")); err == nil {
+		offset = o
+	}
+
+	list, err := comments.ListComments(c.Request.Context(), c.Param("id"), limit, offset)
+	if err != nil {
+		h.respondCommentError(c, "Failed to list comments", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, list)
+}
+
+// UpdateWorkflowComment edits the body or the pinned flag of a comment
+func (h *Handlers) UpdateWorkflowComment(c *gin.Context) {
+	comments := h.commentService(c)
+	if comments == nil {
+		return
+	}
+
+	var req services.UpdateCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	comment, err := comments.UpdateComment(c.Request.Context(), c.Param("id"), c.Param("comment_id"), requestUserID(c), &req)
+	if err != nil {
+		h.respondCommentError(c, "Failed to update comment", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, comment)
+}
+
+// DeleteWorkflowComment deletes a comment
+func (h *Handlers) DeleteWorkflowComment(c *gin.Context) {
+	comments := h.commentService(c)
+	if comments == nil {
+		return
+	}
+
+	commentID := c.Param("comment_id")
+	if err := comments.DeleteComment(c.Request.Context(), c.Param("id"), commentID, requestUserID(c)); err != nil {
+		h.respondCommentError(c, "Failed to delete comment", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"comment_id": commentID, "deleted": true})
+}
+
+// GetPublicWorkflow serves the redacted view of a shared workflow without
+// authentication. Error responses carry no details.
+func (h *Handlers) GetPublicWorkflow(c *gin.Context) {
+	if h.shares == nil {
+		h.respondError(c, http.StatusNotFound, "Shared workflow not found", nil)
+		return
+	}
+
+	view, err := h.shares.GetPublicWorkflowView(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrShareTokenExpired), errors.Is(err, services.ErrShareTokenRevoked):
+			h.respondError(c, http.StatusGone, "Share link is no longer valid", nil)
+		case errors.Is(err, services.ErrShareTokenInvalid), errors.Is(err, services.ErrSharingDisabled),
+			errors.Is(err, gorm.ErrRecordNotFound):
+			h.respondError(c, http.StatusNotFound, "Shared workflow not found", nil)
+		default:
+			h.logger.Error("failed to get shared workflow", zap.Error(err))
+			h.respondError(c, http.StatusServiceUnavailable, "Shared workflow is unavailable", nil)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, view)
+}
+
+// Execution Handlers
+
+// GetExecution returns an execution. An offloaded output is returned as its
+// preview, with full_output_url set to where the full output is.
+func (h *Handlers) GetExecution(c *gin.Context) {
+	var execution models.Execution
+	if err := h.db.WithContext(c.Request.Context()).First(&execution, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Execution not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get execution", err)
+		return
+	}
+	setFullOutputURL(&execution)
+	h.respondSuccess(c, http.StatusOK, execution)
+}
+
+// GetExecutionOutput returns the full output of an execution, read from
+// artifact storage when it was offloaded. JSON outputs are returned as JSON,
+// others as plain text.
+func (h *Handlers) GetExecutionOutput(c *gin.Context) {
+	ctx := c.Request.Context()
+	var execution models.Execution
+	if err := h.db.WithContext(ctx).Select("id", "output").First(&execution, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Execution not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get execution", err)
+		return
+	}
+
+	output, err := h.outputs.FullOutput(ctx, execution.Output)
+	if err != nil {
+		if errors.Is(err, services.ErrOutputNotFound) {
+			h.respondError(c, http.StatusNotFound, "Execution output not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get execution output", err)
+		return
+	}
+	if json.Valid(output) {
+		c.Data(http.StatusOK, "application/json", output)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", output)
+}
+
+// GetWorkflowResult returns the output of a workflow. Compacted task results
+// are returned as stored, without the outputs of their tasks, unless
+// full=true, which rehydrates them from their archive.
+func (h *Handlers) GetWorkflowResult(c *gin.Context) {
+	ctx := c.Request.Context()
+	var workflow models.Workflow
+	if err := h.db.WithContext(ctx).Select("id", "project_id", "output").First(&workflow, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get workflow", err)
+		return
+	}
+	if len(workflow.Output) == 0 {
+		h.respondError(c, http.StatusNotFound, "Workflow has no result", nil)
+		return
+	}
+
+	output := []byte(workflow.Output)
+	if full, _ := strconv.ParseBool(c.Query("full")); full {
+		var err error
+		output, err = h.results.FullResult(ctx, workflow.Output)
+		if err != nil {
+			if errors.Is(err, services.ErrOutputNotFound) {
+				h.respondError(c, http.StatusNotFound, "Workflow result archive not found", err)
+				return
+			}
+			h.respondError(c, http.StatusInternalServerError, "Failed to get workflow result", err)
+			return
+		}
+	}
+	c.Data(http.StatusOK, "application/json", output)
+}
+
+// ListWorkflowArtifacts lists the artifacts of a workflow by logical key,
+// with the latest version of each
+func (h *Handlers) ListWorkflowArtifacts(c *gin.Context) {
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifacts are not configured", nil)
+		return
+	}
+	groups, err := h.artifacts.ListWorkflowArtifacts(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to list artifacts", err)
+		return
+	}
+	h.respondSuccess(c, http.StatusOK, gin.H{"artifacts": groups})
+}
+
+// GetArtifact returns an artifact
+func (h *Handlers) GetArtifact(c *gin.Context) {
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifacts are not configured", nil)
+		return
+	}
+	artifact, err := h.artifacts.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondArtifactError(c, "Failed to get artifact", err)
+		return
+	}
+	h.respondSuccess(c, http.StatusOK, artifact)
+}
+
+// ListArtifactVersions lists the versions of an artifact, oldest first
+func (h *Handlers) ListArtifactVersions(c *gin.Context) {
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifacts are not configured", nil)
+		return
+	}
+	versions, err := h.artifacts.Versions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondArtifactError(c, "Failed to list artifact versions", err)
+		return
+	}
+	h.respondSuccess(c, http.StatusOK, gin.H{"versions": versions})
+}
+
+// DiffArtifact compares an artifact against the artifact given by against,
+// usually an earlier version of it
+func (h *Handlers) DiffArtifact(c *gin.Context) {
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifacts are not configured", nil)
+		return
+	}
+	against := c.Query("against")
+	if against == "" {
+		h.respondError(c, http.StatusBadRequest, "against is required", nil)
+		return
+	}
+	diff, err := h.artifacts.Diff(c.Request.Context(), c.Param("id"), against)
+	if err != nil {
+		h.respondArtifactError(c, "Failed to diff artifacts", err)
+		return
+	}
+	h.respondSuccess(c, http.StatusOK, diff)
+}
+
+// PreviewArtifact previews the content of an artifact: the first lines of
+// text, JSON and YAML down to depth, or the metadata of binary content
+func (h *Handlers) PreviewArtifact(c *gin.Context) {
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifacts are not configured", nil)
+		return
+	}
+	lines, depth := services.DefaultPreviewLines, services.DefaultPreviewDepth
+	for param, value := range map[string]*int{"lines": &lines, "depth": &depth} {
+		if raw := c.Query(param); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				h.respondError(c, http.StatusBadRequest, "Invalid "+param, err)
+				return
+			}
+			*value = n
+		}
+	}
+	preview, err := h.artifacts.Preview(c.Request.Context(), c.Param("id"), lines, depth)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPreview) {
+			h.respondError(c, http.StatusBadRequest, "Invalid preview", err)
+			return
+		}
+		h.respondArtifactError(c, "Failed to preview artifact", err)
+		return
+	}
+	h.respondSuccess(c, http.StatusOK, preview)
+}
+
+// respondArtifactError responds to a failed artifact lookup
+func (h *Handlers) respondArtifactError(c *gin.Context, message string, err error) {
+	if errors.Is(err, services.ErrArtifactNotFound) {
+		h.respondError(c, http.StatusNotFound, "Artifact not found", err)
+		return
+	}
+	h.respondError(c, http.StatusInternalServerError, message, err)
+}
+
+// defaultUsageWindow is the period usage is reported over when the request
+// sets no from
+const defaultUsageWindow = 24 * time.Hour
+
+// GetUsage reports the API usage of a project, or of every project without
+// project_id, between from and to by hour or by day. format=csv returns the
+// series as CSV rows.
+func (h *Handlers) GetUsage(c *gin.Context) {
+	if h.usage == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Usage metering is not enabled", nil)
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		h.respondError(c, http.StatusBadRequest, "format must be json or csv", nil)
+		return
+	}
+
+	query := services.UsageQuery{
+		ProjectID:   c.Query("project_id"),
+		To:          time.Now(),
+		Granularity: c.DefaultQuery("granularity", services.UsageGranularityHour),
+	}
+	if value := c.Query("to"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "to must be an RFC3339 timestamp", err)
+			return
+		}
+		query.To = t
+	}
+	query.From = query.To.Add(-defaultUsageWindow)
+	if value := c.Query("from"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "from must be an RFC3339 timestamp", err)
+			return
+		}
+		query.From = t
+	}
+
+	report, err := h.usage.Usage(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUsageQuery) {
+			h.respondError(c, http.StatusBadRequest, "Invalid usage query", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get usage", err)
+		return
+	}
+	if format == "json" {
+		h.respondSuccess(c, http.StatusOK, report)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(services.UsageCSV(report)); err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to render usage", err)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="usage.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// metricsQuery reads the metric series query of a request: the metric name,
+// the range between from and to (RFC3339, default from the creation of the
+// execution or workflow until now) and the step, a duration such as 30s
+func (h *Handlers) metricsQuery(c *gin.Context, createdAt time.Time) (services.MetricsQuery, bool) {
+	query := services.MetricsQuery{Name: c.Query("name"), From: createdAt, To: time.Now()}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{{"from", &query.From}, {"to", &query.To}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, param.name+" must be an RFC3339 timestamp", err)
+			return query, false
+		}
+		*param.value = t
+	}
+	if value := c.Query("step"); value != "" {
+		step, err := time.ParseDuration(value)
+		if err != nil {
+			h.respondError(c, http.StatusBadRequest, "step must be a duration such as 30s", err)
+			return query, false
+		}
+		query.Step = step
+	}
+	return query, true
+}
+
+// respondMetricsError reports a failed metric series query
+func (h *Handlers) respondMetricsError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidMetricsQuery) {
+		h.respondError(c, http.StatusBadRequest, "Invalid metrics query", err)
+		return
+	}
+	h.respondError(c, http.StatusInternalServerError, "Failed to get metrics", err)
+}
+
+// GetExecutionMetrics returns the metric series of an execution, downsampled
+// to the average, minimum and maximum of each step-wide bucket
+func (h *Handlers) GetExecutionMetrics(c *gin.Context) {
+	if h.metrics == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Execution metrics are not enabled", nil)
+		return
+	}
+	ctx := c.Request.Context()
+	var execution models.Execution
+	if err := h.db.WithContext(ctx).Select("id", "created_at").First(&execution, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Execution not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get execution", err)
+		return
+	}
+	query, ok := h.metricsQuery(c, execution.CreatedAt)
+	if !ok {
+		return
+	}
+
+	report, err := h.metrics.ExecutionSeries(ctx, execution.ID, query)
+	if err != nil {
+		h.respondMetricsError(c, err)
+		return
+	}
+	h.respondSuccess(c, http.StatusOK, report)
+}
+
+// GetWorkflowMetricsTimeseries returns the metric series of every execution
+// of a workflow together, each bucket summarizing the samples of all of them
+func (h *Handlers) GetWorkflowMetricsTimeseries(c *gin.Context) {
+	if h.metrics == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Execution metrics are not enabled", nil)
+		return
+	}
+	ctx := c.Request.Context()
+	var workflow models.Workflow
+	if err := h.db.WithContext(ctx).Select("id", "created_at").First(&workflow, "id = ?", c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get workflow", err)
+		return
+	}
+	query, ok := h.metricsQuery(c, workflow.CreatedAt)
+	if !ok {
+		return
+	}
+
+	report, err := h.metrics.WorkflowSeries(ctx, workflow.ID, query)
+	if err != nil {
+		h.respondMetricsError(c, err)
+		return
+	}
+	h.respondSuccess(c, http.StatusOK, report)
+}
+
+// setFullOutputURL links an execution whose output was offloaded to the
+// endpoint returning it in full
+func setFullOutputURL(execution *models.Execution) {
+	if _, ok := models.ParseOffloadedOutput(execution.Output); ok {
+		execution.FullOutputURL = "/api/v1/executions/" + execution.ID + "/output"
+	}
+}
+
+// GetExecutionLogs returns the stored logs of an execution. With follow=true
+// the lines are streamed as newline-delimited JSON instead: the live log of a
+// running execution is followed until it finishes, while the stored log of a
+// finished one is sent and the stream ends.
+func (h *Handlers) GetExecutionLogs(c *gin.Context) {
+	executionID := c.Param("id")
+	if executionID == "" {
+		h.respondError(c, http.StatusBadRequest, "Execution ID is required", nil)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var execution models.Execution
+	if err := h.db.WithContext(ctx).Select("id", "status").First(&execution, "id = ?", executionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Execution not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to get execution", err)
+		return
+	}
+
+	follow, _ := strconv.ParseBool(c.Query("follow"))
+	if follow && h.logs != nil && !execution.IsTerminal() {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		h.followExecutionLogs(c, executionID)
+		return
+	}
+
+	var logs []models.ExecutionLog
+	if err := h.db.WithContext(ctx).Where("execution_id = ?", executionID).
+		Order("timestamp ASC").Find(&logs).Error; err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to get execution logs", err)
+		return
+	}
+	if !follow {
+		h.respondSuccess(c, http.StatusOK, logs)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for _, entry := range logs {
+		line := services.TaskLogLine{
+			ExecutionID: entry.ExecutionID,
+			Level:       entry.Level,
+			Message:     entry.Message,
+			Timestamp:   entry.Timestamp,
+		}
+		if err := encoder.Encode(line); err != nil {
+			return
+		}
+	}
+}
+
+// followExecutionLogs streams an execution's live log until the execution
+// has finished and its last lines are sent, or the client goes away
+func (h *Handlers) followExecutionLogs(c *gin.Context, executionID string) {
+	ctx := c.Request.Context()
+	encoder := json.NewEncoder(c.Writer)
+
+	after := ""
+	finished := false
+	for ctx.Err() == nil {
+		lines, err := h.logs.Read(ctx, executionID, after)
+		if err != nil {
+			if ctx.Err() == nil {
+				requestid.Logger(ctx, h.logger).Warn("Stopped following execution logs",
+					zap.String("execution_id", executionID),
+					zap.Error(err))
+			}
+			return
+		}
+		for _, line := range lines {
+			if err := encoder.Encode(line); err != nil {
+				return
+			}
+			after = line.ID
+		}
+		if len(lines) > 0 {
+			c.Writer.Flush()
+			continue
+		}
+
+		// Nothing new: once the execution has finished, read once more for
+		// the lines still on their way and end the stream
+		if finished {
+			return
+		}
+		var execution models.Execution
+		if err := h.db.WithContext(ctx).Select("id", "status").First(&execution, "id = ?", executionID).Error; err != nil {
+			return
+		}
+		finished = execution.IsTerminal()
+	}
+}
+
+// Agent Handlers
+
+// ListAgents lists available agents
+func (h *Handlers) ListAgents(c *gin.Context) {
+	filters := &services.AgentFilters{
+		ProjectID: c.Query("project_id"),
+		Type:      c.Query("type"),
+		Status:    c.Query("status"),
+	}
+
+	if err := parseAgentPagination(c, filters); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid pagination", err)
+		return
+	}
+	if err := parseAgentCapacityFilters(c, filters); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid agent filters", err)
+		return
+	}
+
+	agentList, err := h.agentClient.ListAgents(c.Request.Context(), filters)
+	if err != nil {
+		h.respondAgentManagerError(c, "Failed to list agents", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, agentList)
+}
+
+// GetAgentPools reports the warm agent pools as of their last check; none
+// when no pool is configured
+func (h *Handlers) GetAgentPools(c *gin.Context) {
+	h.respondSuccess(c, http.StatusOK, gin.H{"pools": h.pools.Status()})
+}
+
+// parseAgentPagination parses the page and page_size, or offset and limit,
+// query parameters of ListAgents. Sizes default to DefaultAgentPageSize and
+// are clamped to MaxAgentPageSize.
+func parseAgentPagination(c *gin.Context, filters *services.AgentFilters) error {
+	query := func(name string) (int, bool, error) {
+		value := c.Query(name)
+		if value == "" {
+			return 0, false, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false, fmt.Errorf("%s must be an integer", name)
+		}
+		return n, true, nil
+	}
+	page, hasPage, err := query("page")
+	if err != nil {
+		return err
+	}
+	pageSize, hasPageSize, err := query("page_size")
+	if err != nil {
+		return err
+	}
+	offset, hasOffset, err := query("offset")
+	if err != nil {
+		return err
+	}
+	limit, hasLimit, err := query("limit")
+	if err != nil {
+		return err
+	}
+	if (hasPage || hasPageSize) && (hasOffset || hasLimit) {
+		return errors.New("use either page and page_size or offset and limit")
+	}
+
+	size, sizeName := pageSize, "page_size"
+	if hasOffset || hasLimit {
+		size, sizeName = limit, "limit"
+	}
+	switch {
+	case !hasPageSize && !hasLimit:
+		size = services.DefaultAgentPageSize
+	case size < 1:
+		return fmt.Errorf("%s must be at least 1", sizeName)
+	case size > services.MaxAgentPageSize:
+		size = services.MaxAgentPageSize
+	}
+	filters.PageSize = size
+
+	if hasOffset {
+		if offset < 0 {
+			return errors.New("offset must not be negative")
+		}
+		filters.Offset = offset
+		return nil
+	}
+	if hasPage && page < 1 {
+		return errors.New("page must be at least 1")
+	}
+	filters.Page = max(page, 1)
+	return nil
+}
+
+// respondAgentManagerError responds to a failed agent manager request.
+// Server errors of the agent manager become 502 Bad Gateway and its rate
+// limits 429 Too Many Requests with its Retry-After.
+func (h *Handlers) respondAgentManagerError(c *gin.Context, message string, err error) {
+	switch {
+	case deadline.Exhausted(err):
+		h.respondError(c, http.StatusGatewayTimeout, "Request deadline exceeded", err)
+	case errors.Is(err, services.ErrAgentManagerRateLimited):
+		if retryAfter := services.RetryAfter(err); retryAfter != "" {
+			c.Header("Retry-After", retryAfter)
+		}
+		h.respondError(c, http.StatusTooManyRequests, message, err)
+	case errors.Is(err, services.ErrAgentManagerUnavailable):
+		h.respondError(c, http.StatusBadGateway, message, err)
+	default:
+		h.respondError(c, http.StatusInternalServerError, message, err)
+	}
+}
+
+// parseAgentCapacityFilters parses the capability, load and heartbeat query
+// parameters of ListAgents
+func parseAgentCapacityFilters(c *gin.Context, filters *services.AgentFilters) error {
+	for _, value := range c.QueryArray("capability") {
+		filters.Capabilities = append(filters.Capabilities, splitCSV(value)...)
+	}
+	filters.RequiredCapabilities = splitCSV(c.Query("required_capabilities"))
+
+	if value := c.Query("min_capability_match"); value != "" {
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil || score < 0 || score > 1 {
+			return errors.New("min_capability_match must be a number between 0 and 1")
+		}
+		if len(filters.RequiredCapabilities) == 0 {
+			return errors.New("min_capability_match requires required_capabilities")
+		}
+		filters.MinCapabilityMatch = score
+	}
+
+	if value := c.Query("max_load"); value != "" {
+		load, err := strconv.ParseFloat(value, 64)
+		if err != nil || load < 0 {
+			return errors.New("max_load must be a non-negative number")
+		}
+		filters.MaxLoad = &load
+	}
+
+	if value := c.Query("heartbeat_within"); value != "" {
+		within, err := time.ParseDuration(value)
+		if err != nil || within <= 0 {
+			return errors.New("heartbeat_within must be a positive duration such as 30s or 5m")
+		}
+		filters.HeartbeatWithin = within
+	}
+
+	return nil
+}
+
+// GetAgent retrieves agent details
+func (h *Handlers) GetAgent(c *gin.Context) {
+	agentID := c.Param("id")
+	if agentID == "" {
+		h.respondError(c, http.StatusBadRequest, "Agent ID is required", nil)
+		return
+	}
+
+	agent, err := h.agentClient.GetAgent(c.Request.Context(), agentID)
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Agent not found", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, agent)
+}
+
+// GetAgentStats reports the average quality score of the tasks each agent
+// ran, or the agent of the agent_id query parameter, over a window that
+// defaults to the last 7 days
+func (h *Handlers) GetAgentStats(c *gin.Context) {
+	if h.qualityScores == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Agent stats are not configured", nil)
+		return
+	}
+
+	since, until, ok := h.statsWindow(c, defaultAgentStatsWindow)
+	if !ok {
+		return
+	}
+
+	stats, err := h.qualityScores.AgentStats(c.Request.Context(), c.Query("agent_id"), since, until)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to get agent stats", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"since":  since,
+		"until":  until,
+		"agents": stats,
+	})
+}
+
+// RestartAgent restarts an agent
+func (h *Handlers) RestartAgent(c *gin.Context) {
+	agentID := c.Param("id")
+	if agentID == "" {
+		h.respondError(c, http.StatusBadRequest, "Agent ID is required", nil)
+		return
+	}
+
+	// Update agent status to trigger restart
+	_, err := h.agentClient.UpdateAgent(c.Request.Context(), agentID, &services.UpdateAgentRequest{
+		Status: "restarting",
+	})
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to restart agent", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"message": "Agent restart initiated"})
+}
+
+// ExecuteAgentBatch runs tasks on many agents concurrently. Per-task failures
+// and fail-fast aborts are reported in the result rather than as an error.
+func (h *Handlers) ExecuteAgentBatch(c *gin.Context) {
+	var req ExecuteBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	result, err := h.agentClient.ExecuteTaskBatch(c.Request.Context(), req.Tasks, services.BatchOptions{
+		MaxParallel: req.MaxParallel,
+		FailFast:    req.FailFast,
+		Deadline:    time.Duration(req.DeadlineSeconds) * time.Second,
+	})
+	if err != nil && !errors.Is(err, services.ErrBatchAborted) {
+		if errors.Is(err, services.ErrInvalidBatch) {
+			h.respondError(c, http.StatusBadRequest, "Invalid task batch", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to execute task batch", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, result)
+}
+
+// RewrapEncryptionKeys starts a background job that re-wraps every encrypted
+// data key under the current master key
+func (h *Handlers) RewrapEncryptionKeys(c *gin.Context) {
+	if h.encryptor == nil {
+		h.respondError(c, http.StatusConflict, "Encryption at rest is not enabled", nil)
+		return
+	}
+
+	keyID := h.encryptor.CurrentKeyID()
+	go func() {
+		result, err := h.rewrapEncryptedData(h.jobs)
+		if err != nil {
+			h.logger.Error("Encryption key rewrap failed", zap.String("key_id", keyID), zap.Error(err))
+			return
+		}
+		h.logger.Info("Encryption key rewrap completed",
+			zap.String("key_id", keyID),
+			zap.Int("scanned", result.Scanned),
+			zap.Int("rewrapped", result.Rewrapped))
+	}()
+
+	h.respondSuccess(c, http.StatusAccepted, gin.H{
+		"status": "started",
+		"key_id": keyID,
+	})
+}
+
+// rewrapEncryptedData re-wraps the data keys of every model with encrypted
+// columns and of every project secret under the current master key
+func (h *Handlers) rewrapEncryptedData(ctx context.Context) (encryption.RewrapResult, error) {
+	result, err := encryption.Rewrap(ctx, h.db, h.encryptor, 500, &models.Workflow{}, &models.Execution{}, &models.WorkflowExecution{}, &models.Artifact{})
+	if err != nil {
+		return result, err
+	}
+
+	secrets, err := services.NewDBSecretProvider(h.db, h.encryptor).RewrapSecrets(ctx)
+	result.Scanned += secrets.Scanned
+	result.Rewrapped += secrets.Rewrapped
+	return result, err
+}
+
+// RestartWorker stops and recreates the Temporal worker without restarting
+// the process. Restarts are refused while one is running or shortly after the
+// previous one.
+func (h *Handlers) RestartWorker(c *gin.Context) {
+	if h.worker == nil {
+		h.respondError(c, http.StatusConflict, "Temporal worker is not managed by this instance", nil)
+		return
+	}
+
+	before := h.worker.Health()
+	err := h.worker.Restart()
+	switch {
+	case errors.Is(err, temporal.ErrWorkerRestartInProgress):
+		h.respondError(c, http.StatusConflict, "Worker restart already in progress", err)
+		return
+	case errors.Is(err, temporal.ErrWorkerRestartCooldown):
+		h.respondError(c, http.StatusTooManyRequests, "Worker was restarted recently", err)
+		return
+	}
+
+	h.auditWorkerRestart(c, before, err)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to restart worker", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"message": "Worker restarted",
+		"worker":  h.worker.Health(),
+	})
+}
+
+// ResetStuckWorkflow clears the stuck flag of a workflow once a fix for its
+// failing workflow task is deployed
+func (h *Handlers) ResetStuckWorkflow(c *gin.Context) {
+	actor := requestUserID(c)
+	workflow, err := h.workflowEngine.ResetStuck(c.Request.Context(), c.Param("id"), actor)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.respondError(c, http.StatusNotFound, "Workflow not found", err)
+			return
+		}
+		if errors.Is(err, services.ErrWorkflowNotStuck) {
+			h.respondError(c, http.StatusConflict, "Workflow is not stuck", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to reset stuck workflow", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{
+		"workflow_id": workflow.ID,
+		"stuck":       workflow.Stuck,
+	})
+}
+
+// auditWorkerRestart records who restarted the worker and in what state
+func (h *Handlers) auditWorkerRestart(c *gin.Context, before temporal.WorkerHealth, restartErr error) {
+	if h.db == nil {
+		return
+	}
+	details := map[string]interface{}{
+		"previous_state": before.State,
+		"reason":         before.Reason,
+	}
+	if restartErr != nil {
+		details["error"] = restartErr.Error()
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		h.logger.Error("Failed to marshal audit details", zap.Error(err))
+		return
+	}
+	actor := requestUserID(c)
+	entry := &models.AuditLog{
+		EntityType: "temporal_worker",
+		EntityID:   "orchestrator-worker",
+		Action:     "worker.restart",
+		Actor:      actor,
+		Details:    data,
+	}
+	if err := h.db.WithContext(c.Request.Context()).Create(entry).Error; err != nil {
+		h.logger.Error("Failed to write audit log", zap.String("action", entry.Action), zap.Error(err))
+	}
+}
+
+// Fault Injection Handlers
+
+// faultInjector returns the fault injector, responding with 404 when fault
+// injection is disabled
+func (h *Handlers) faultInjector(c *gin.Context) *services.FaultInjector {
+	if h.faults == nil {
+		h.respondError(c, http.StatusNotFound, "Fault injection is disabled", nil)
+	}
+	return h.faults
+}
+
+// CreateFaultRule starts injecting latency or errors into matching API
+// requests or activities until the rule expires
+func (h *Handlers) CreateFaultRule(c *gin.Context) {
+	faults := h.faultInjector(c)
+	if faults == nil {
+		return
+	}
+
+	var rule services.FaultRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	actor := requestUserID(c)
+	created, err := faults.CreateRule(c.Request.Context(), &rule, actor)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidFaultRule) {
+			h.respondError(c, http.StatusBadRequest, "Invalid fault rule", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to create fault rule", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusCreated, created)
+}
+
+// ListFaultRules returns the active fault rules
+func (h *Handlers) ListFaultRules(c *gin.Context) {
+	faults := h.faultInjector(c)
+	if faults == nil {
+		return
+	}
+
+	rules, err := faults.ListRules(c.Request.Context())
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to list fault rules", err)
+		return
+	}
+	if rules == nil {
+		rules = []services.FaultRule{}
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"rules": rules})
+}
+
+// DeleteFaultRule stops a fault rule before it expires
+func (h *Handlers) DeleteFaultRule(c *gin.Context) {
+	faults := h.faultInjector(c)
+	if faults == nil {
+		return
+	}
+
+	if err := faults.DeleteRule(c.Request.Context(), c.Param("rule_id")); err != nil {
+		if errors.Is(err, services.ErrFaultRuleNotFound) {
+			h.respondError(c, http.StatusNotFound, "Fault rule not found", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to delete fault rule", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"message": "Fault rule deleted"})
+}
+
+// ListEventConsumers returns the state of the event consumers: what they
+// processed, their lag and whether the watchdog flagged them as stalled
+func (h *Handlers) ListEventConsumers(c *gin.Context) {
+	if h.eventConsumers == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Event consumers are not configured", nil)
+		return
+	}
+
+	consumers := h.eventConsumers.Consumers()
+	stalled := 0
+	for _, consumer := range consumers {
+		if consumer.Stalled {
+			stalled++
+		}
+	}
+
+	h.respondSuccess(c, http.StatusOK, gin.H{"consumers": consumers, "stalled": stalled})
+}
+
+// SetMaintenance enables or disables the read-only maintenance mode, on
+// every replica
+func (h *Handlers) SetMaintenance(c *gin.Context) {
+	if h.maintenance == nil {
+		h.respondError(c, http.StatusNotFound, "Maintenance mode is not available", nil)
+		return
+	}
+
+	var req services.MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	actor := requestUserID(c)
+	status, err := h.maintenance.Set(c.Request.Context(), &req, actor)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMaintenance) {
+			h.respondError(c, http.StatusBadRequest, "Invalid maintenance request", err)
+			return
+		}
+		h.respondError(c, http.StatusInternalServerError, "Failed to set maintenance mode", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, status)
+}
+
+// ReconcileWorkflows runs a pass reconciling the state of unfinished
+// workflows with Temporal, resuming an interrupted one, and reports its
+// summary. orphan_policy=terminate terminates running executions without a
+// workflow row instead of only logging them.
+func (h *Handlers) ReconcileWorkflows(c *gin.Context) {
+	if h.reconciler == nil {
+		h.respondError(c, http.StatusNotFound, "Workflow reconciliation is not available", nil)
+		return
+	}
+
+	report, err := h.reconciler.Reconcile(c.Request.Context(), services.ReconcileOptions{
+		OrphanPolicy: c.Query("orphan_policy"),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidReconcileRequest):
+			h.respondError(c, http.StatusBadRequest, "Invalid reconcile request", err)
+		case errors.Is(err, services.ErrReconcileInProgress):
+			h.respondError(c, http.StatusConflict, "Workflow reconciliation already in progress", err)
+		default:
+			h.respondError(c, http.StatusInternalServerError, "Workflow reconciliation failed", err)
+		}
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, report)
+}
+
+// ListTaskQueues reports the pollers and backlog of the task queues, as
+// Temporal describes them, and the lag of the workflows waiting to start
+func (h *Handlers) ListTaskQueues(c *gin.Context) {
+	if h.taskQueues == nil {
+		h.respondError(c, http.StatusNotFound, "Task queue stats are not available", nil)
+		return
+	}
+
+	report, err := h.taskQueues.Collect(c.Request.Context())
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to collect task queue stats", err)
+		return
+	}
+
+	h.respondSuccess(c, http.StatusOK, report)
 }
 
 // Health check handler with detailed status
@@ -415,23 +3034,52 @@ func (h *Handlers) HealthCheck(c *gin.Context) {
 	// Check Temporal connectivity
 	temporalHealthy := h.workflowEngine != nil
 	
-	// Check Agent Manager connectivity
-	agentManagerHealthy := true
-	if h.agentClient != nil {
-		// Simple check - could be enhanced with actual ping
-		agentManagerHealthy = true
-	}
-	
-	overallHealthy := dbHealthy && temporalHealthy && agentManagerHealthy
+	overallHealthy := dbHealthy && temporalHealthy
 	
+	checks := gin.H{
+		"database":      dbHealthy,
+		"temporal":      temporalHealthy,
+		"agent_manager": true,
+	}
 	response := gin.H{
 		"status":    "healthy",
 		"timestamp": time.Now().Unix(),
-		"checks": gin.H{
-			"database":      dbHealthy,
-			"temporal":      temporalHealthy,
-			"agent_manager": agentManagerHealthy,
-		},
+		"checks":    checks,
+	}
+
+	// An incompatible agent manager degrades the service without making it
+	// unhealthy: workflows without agents still run
+	if h.compatibility != nil {
+		compatibility := h.compatibility.Compatibility()
+		checks["agent_manager"] = compatibility.Compatible
+		response["agent_manager"] = compatibility
+		if !compatibility.Compatible {
+			response["status"] = "degraded"
+		}
+	}
+
+	// Reads, writes and events fall back while Redis is unreachable, so it
+	// only degrades the service
+	if h.redis != nil {
+		redisHealth := h.redis.Health()
+		checks["redis"] = redisHealth.Healthy
+		response["redis"] = redisHealth
+		if !redisHealth.Healthy {
+			response["status"] = "degraded"
+		}
+	}
+
+	// Maintenance mode keeps the service up for reads
+	if h.maintenance != nil {
+		response["maintenance"] = h.maintenance.Status(c.Request.Context())
+	}
+
+	// A worker that stopped polling leaves accepted workflows unprocessed
+	if h.worker != nil {
+		worker := h.worker.Health()
+		checks["worker"] = worker.Healthy
+		response["worker"] = worker
+		overallHealthy = overallHealthy && worker.Healthy
 	}
 	
 	if !overallHealthy {
@@ -448,6 +3096,9 @@ func (h *Handlers) HealthCheck(c *gin.Context) {
 
 // Helper methods
 
+// errorDetails cleans the errors returned to clients as details
+var errorDetails = errorsig.NewCleaner(errorsig.DefaultMaxErrorLength)
+
 func (h *Handlers) respondSuccess(c *gin.Context, statusCode int, data interface{}) {
 	c.JSON(statusCode, gin.H{
 		"success": true,
@@ -456,22 +3107,38 @@ func (h *Handlers) respondSuccess(c *gin.Context, statusCode int, data interface
 }
 
 func (h *Handlers) respondError(c *gin.Context, statusCode int, message string, err error) {
-	h.logger.Error(message, zap.Error(err))
+	requestID := requestid.FromContext(c.Request.Context())
+	requestid.Logger(c.Request.Context(), h.logger).Error(message, zap.Error(err))
 	
 	response := gin.H{
 		"success": false,
 		"error": gin.H{
-			"message": message,
+			"message":    message,
+			"request_id": requestID,
 		},
 	}
 	
 	if err != nil {
-		response["error"].(gin.H)["details"] = err.Error()
+		// Errors may carry what agents reported: clients get them cleaned,
+		// with the class of failure as the code
+		response["error"].(gin.H)["details"] = errorDetails.Clean(err.Error())
+		response["error"].(gin.H)["code"] = errorclass.Classify(err.Error())
 	}
 	
 	c.JSON(statusCode, response)
 }
 
+// splitCSV splits a comma-separated query value, dropping empty entries
+func splitCSV(value string) []string {
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
 // Request types
 
 type CreateProjectRequest struct {
@@ -496,12 +3163,131 @@ type StartWorkflowRequest struct {
 	Type           string          `json:"type" binding:"required"`
 	Priority       string          `json:"priority"`
 	ProjectID      string          `json:"project_id" binding:"required"`
-	Input          json.RawMessage `json:"input"`
-	Config         json.RawMessage `json:"config"`
-	MaxRetries     int             `json:"max_retries"`
-	TimeoutSeconds int             `json:"timeout_seconds"`
+	Input          json.RawMessage   `json:"input"`
+	Config         json.RawMessage   `json:"config"`
+	Tags           []string          `json:"tags"`
+	Labels         map[string]string `json:"labels"`
+	MaxRetries     int               `json:"max_retries"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+
+	SLASeconds          int     `json:"sla_seconds"`
+	SLAWarningThreshold float64 `json:"sla_warning_threshold"`
+
+	Budget     *models.WorkflowBudget `json:"budget"`      // Limits on the intent's estimated cost and time
+	TemplateID string                 `json:"template_id"` // Workflow template the workflow is started from
+
+	Source *models.WorkflowSource `json:"source"` // Git context of a workflow started by CI
+}
+
+// ValidateWorkflowRequest is a workflow to validate, with the steps of an
+// execution plan to validate along
+type ValidateWorkflowRequest struct {
+	StartWorkflowRequest
+	Steps []services.PlanStep `json:"steps"`
+}
+
+// WorkflowDraftRequest composes a workflow saved as a draft. Unlike a
+// StartWorkflowRequest it may be incomplete until the draft is submitted.
+type WorkflowDraftRequest struct {
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	Type           string            `json:"type"`
+	Priority       string            `json:"priority"`
+	ProjectID      string            `json:"project_id"`
+	Input          json.RawMessage   `json:"input"`
+	Config         json.RawMessage   `json:"config"`
+	Tags           []string          `json:"tags"`
+	Labels         map[string]string `json:"labels"`
+	MaxRetries     int               `json:"max_retries"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+
+	SLASeconds          int     `json:"sla_seconds"`
+	SLAWarningThreshold float64 `json:"sla_warning_threshold"`
+
+	Budget     *models.WorkflowBudget `json:"budget"`      // Limits on the intent's estimated cost and time
+	TemplateID string                 `json:"template_id"` // Workflow template the workflow is started from
+
+	Source *models.WorkflowSource `json:"source"` // Git context of a workflow started by CI
+}
+
+type BatchProjectStatsRequest struct {
+	ProjectIDs []string `json:"project_ids" binding:"required"`
 }
 
 type CancelWorkflowRequest struct {
 	Reason string `json:"reason"`
+}
+
+// ConfirmIntentRisksRequest confirms (true) or denies (false) the risks a
+// workflow is waiting on
+type ConfirmIntentRisksRequest struct {
+	Confirmed *bool  `json:"confirmed" binding:"required"`
+	Comment   string `json:"comment"`
+}
+
+// SkipWorkflowStepRequest skips a step of a running workflow
+type SkipWorkflowStepRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CompleteWorkflowStepRequest completes a step of a running workflow by hand
+// with the output its dependents receive
+type CompleteWorkflowStepRequest struct {
+	Output map[string]interface{} `json:"output" binding:"required"`
+	Reason string                 `json:"reason"`
+}
+
+// CloneWorkflowTemplateRequest clones a template into a project, named
+// after the template and the project unless a name is given
+type CloneWorkflowTemplateRequest struct {
+	ProjectID string `json:"project_id" binding:"required"`
+	Name      string `json:"name"`
+}
+
+// UpdateWorkflowTagsRequest modifies the tags of an existing workflow.
+// Tags, when present, replaces the current set before Add and Remove are applied.
+type UpdateWorkflowTagsRequest struct {
+	Tags   *[]string `json:"tags"`
+	Add    []string  `json:"add"`
+	Remove []string  `json:"remove"`
+}
+
+// ExecuteBatchRequest runs tasks on many agents. DeadlineSeconds bounds the
+// whole batch; by default it is derived from the task timeouts.
+type ExecuteBatchRequest struct {
+	Tasks           []services.AgentTaskRequest `json:"tasks" binding:"required"`
+	MaxParallel     int                         `json:"max_parallel"`
+	FailFast        bool                        `json:"fail_fast"`
+	DeadlineSeconds int                         `json:"deadline_seconds"`
+}
+
+// CreateWorkflowShareRequest sets the validity of a share link. The
+// configured default applies when ExpiresInSeconds is zero.
+type CreateWorkflowShareRequest struct {
+	ExpiresInSeconds int `json:"expires_in_seconds"`
+}
+
+// SetProjectVariablesRequest replaces all environment variables of a project.
+// Values may reference secrets as ${secret.NAME}.
+type SetProjectVariablesRequest struct {
+	Variables map[string]string `json:"variables" binding:"required"`
+}
+
+// SetProjectSecretRequest sets the value of a secret
+type SetProjectSecretRequest struct {
+	Value string `json:"value" binding:"required"`
+}
+
+// Response types
+
+// WorkflowDetail is a workflow with its intent analysis and the risk
+// policy's decision on it, if they were recorded. PendingRisks are the risks
+// the workflow is waiting to have confirmed.
+type WorkflowDetail struct {
+	*models.Workflow
+	Analysis     *models.IntentAnalysis   `json:"analysis,omitempty"`
+	RiskReview   *models.IntentRiskReview `json:"risk_review,omitempty"`
+	PendingRisks []string                 `json:"pending_risks,omitempty"`
+	RunCount     int64                    `json:"run_count"`
+	CommentCount int64                    `json:"comment_count"`
 }
\ No newline at end of file
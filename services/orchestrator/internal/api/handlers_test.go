@@ -0,0 +1,1529 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/encryption"
+	"orchestrator/internal/events"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+	"orchestrator/internal/temporal"
+)
+
+// setupTestDB creates an sqlite database holding only the workflows table.
+// The uuid column default is Postgres-only, so it is stripped from the parsed
+// schema and IDs are assigned by a create callback instead.
+func setupTestDB(t testing.TB) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	assert.NoError(t, err)
+
+	stmt := &gorm.Statement{DB: db}
+	assert.NoError(t, stmt.Parse(&models.Workflow{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+
+	var seq int
+	err = db.Callback().Create().Before("gorm:create").Register("test:assign_id", func(tx *gorm.DB) {
+		if workflow, ok := tx.Statement.Dest.(*models.Workflow); ok && workflow.ID == "" {
+			seq++
+			workflow.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", seq)
+		}
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Migrator().CreateTable(&models.Workflow{}))
+
+	return db
+}
+
+func setupTestHandlers(t *testing.T, db *gorm.DB) *Handlers {
+	logger := zap.NewNop()
+
+	engine := services.NewWorkflowEngine(db, nil, nil, logger, nil, nil, &services.WorkflowConfig{
+		TaskQueue: "test-queue",
+	})
+
+	return NewHandlers(engine, nil, nil, logger, db)
+}
+
+func TestHandlers_ListWorkflowsReturnsSummaries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	db := setupTestDB(t)
+	handlers := setupTestHandlers(t, db)
+
+	workflow := &models.Workflow{
+		Name:      "Listed Workflow",
+		Type:      models.WorkflowTypeExecution,
+		Status:    models.WorkflowStatusCompleted,
+		ProjectID: "test-project-id",
+		Input:     json.RawMessage(`{"code": "print('hello')"}`),
+		Output:    json.RawMessage(`{"stdout": "hello"}`),
+		CreatedBy: "test-user",
+		UpdatedBy: "test-user",
+	}
+	err := db.Create(workflow).Error
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/api/v1/workflows", handlers.ListWorkflows)
+
+	// Execute
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/workflows?project_id=test-project-id", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Workflows []map[string]interface{} `json:"workflows"`
+			Total     int64                    `json:"total"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(rec.Body.Bytes(), &body)
+	assert.NoError(t, err)
+	assert.True(t, body.Success)
+	assert.Equal(t, int64(1), body.Data.Total)
+	assert.Len(t, body.Data.Workflows, 1)
+
+	item := body.Data.Workflows[0]
+	assert.Equal(t, "Listed Workflow", item["name"])
+	assert.NotContains(t, item, "input")
+	assert.NotContains(t, item, "output")
+	assert.NotContains(t, item, "project")
+}
+
+func TestHandlers_AdminListWorkflowsIncludeDeleted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	handlers := setupTestHandlers(t, db)
+	for _, name := range []string{"kept", "deleted"} {
+		workflow := &models.Workflow{Name: name, Type: models.WorkflowTypeCustom, Status: models.WorkflowStatusCompleted, ProjectID: "test-project-id"}
+		assert.NoError(t, db.Create(workflow).Error)
+		if name == "deleted" {
+			assert.NoError(t, db.Delete(workflow).Error)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/workflows", handlers.ListWorkflows)
+	router.GET("/api/v1/admin/workflows", handlers.AdminListWorkflows)
+
+	for _, tt := range []struct {
+		path  string
+		names []string
+	}{
+		{"/api/v1/workflows?sort_by=name&include_deleted=true", []string{"kept"}},
+		{"/api/v1/admin/workflows?sort_by=name", []string{"kept"}},
+		{"/api/v1/admin/workflows?sort_by=name&include_deleted=true", []string{"deleted", "kept"}},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, tt.path)
+
+		var body struct {
+			Data struct {
+				Workflows []models.WorkflowSummary `json:"workflows"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		var names []string
+		for _, workflow := range body.Data.Workflows {
+			names = append(names, workflow.Name)
+			assert.Equal(t, workflow.Name == "deleted", workflow.DeletedAt != nil, tt.path)
+		}
+		assert.Equal(t, tt.names, names, tt.path)
+	}
+}
+
+func TestHandlers_ListWorkflowsFiltersByTagAndLabel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	db := setupTestDB(t)
+	handlers := setupTestHandlers(t, db)
+
+	for _, workflow := range []*models.Workflow{
+		{Name: "api", Tags: models.StringArray{"team:payments", "JIRA-1"}, Labels: models.Labels{"env": "prod"}},
+		{Name: "ui", Tags: models.StringArray{"team:payments"}, Labels: models.Labels{"env": "prod"}},
+		{Name: "docs", Tags: models.StringArray{"JIRA-1"}},
+	} {
+		workflow.Type = models.WorkflowTypeCustom
+		workflow.ProjectID = "test-project-id"
+		assert.NoError(t, db.Create(workflow).Error)
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/workflows", handlers.ListWorkflows)
+
+	tests := []struct {
+		name     string
+		query    string
+		code     int
+		expected int64
+	}{
+		{"repeated tag params", "tag=team:payments&tag=JIRA-1", http.StatusOK, 1},
+		{"comma separated tags", "tags=team:payments,JIRA-1", http.StatusOK, 1},
+		{"label", "label=env=prod", http.StatusOK, 2},
+		{"tag and label", "tag=JIRA-1&label=env=prod", http.StatusOK, 1},
+		{"malformed label", "label=env", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/workflows?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data struct {
+					Total int64 `json:"total"`
+				} `json:"data"`
+			}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, tt.expected, body.Data.Total)
+		})
+	}
+}
+
+func TestHandlers_ListWorkflowsFiltersBySLAStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	db := setupTestDB(t)
+	handlers := setupTestHandlers(t, db)
+
+	for _, status := range []models.SLAStatus{models.SLAStatusOnTrack, models.SLAStatusBreached, ""} {
+		assert.NoError(t, db.Create(&models.Workflow{
+			Name:      "workflow " + string(status),
+			Type:      models.WorkflowTypeCustom,
+			ProjectID: "test-project-id",
+			SLAStatus: status,
+		}).Error)
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/workflows", handlers.ListWorkflows)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/workflows?sla_status=breached", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Data struct {
+			Workflows []map[string]interface{} `json:"workflows"`
+			Total     int64                    `json:"total"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, int64(1), body.Data.Total)
+	if assert.Len(t, body.Data.Workflows, 1) {
+		assert.Equal(t, "breached", body.Data.Workflows[0]["sla_status"])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/workflows?sla_status=late", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestHandlers_UpdateWorkflowTags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Setup
+	db := setupTestDB(t)
+	handlers := setupTestHandlers(t, db)
+
+	workflow := &models.Workflow{
+		Name:      "Tagged Workflow",
+		Type:      models.WorkflowTypeCustom,
+		ProjectID: "test-project-id",
+		Tags:      models.StringArray{"team:payments", "JIRA-1"},
+	}
+	assert.NoError(t, db.Create(workflow).Error)
+
+	router := gin.New()
+	router.PATCH("/api/v1/workflows/:id/tags", handlers.UpdateWorkflowTags)
+
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/v1/workflows/"+id+"/tags", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("add and remove", func(t *testing.T) {
+		rec := patch(workflow.ID, `{"add": ["release:1.2"], "remove": ["JIRA-1"]}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var body struct {
+			Data struct {
+				Tags []string `json:"tags"`
+			} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, []string{"team:payments", "release:1.2"}, body.Data.Tags)
+
+		var stored models.Workflow
+		assert.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+		assert.Equal(t, models.StringArray{"team:payments", "release:1.2"}, stored.Tags)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		rec := patch(workflow.ID, `{"tags": []}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var stored models.Workflow
+		assert.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+		assert.Empty(t, stored.Tags)
+	})
+
+	t.Run("empty request", func(t *testing.T) {
+		rec := patch(workflow.ID, `{}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid tag", func(t *testing.T) {
+		rec := patch(workflow.ID, `{"add": ["has space"]}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("unknown workflow", func(t *testing.T) {
+		rec := patch("00000000-0000-0000-0000-999999999999", `{"add": ["a"]}`)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestHandlers_GetWorkflowIncludesIntentAnalysis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE intent_analyses (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			workflow_id TEXT, project_id TEXT, intent_type TEXT, confidence REAL, entities TEXT,
+			required_params TEXT, optional_params TEXT, risks TEXT, estimated_time INTEGER,
+			estimated_cost REAL, low_confidence BOOLEAN, created_at DATETIME)`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+	assert.NoError(t, db.AutoMigrate(&models.IntentRiskReview{}))
+
+	// Unreachable Redis: cache misses fall through to the database
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+	engine := services.NewWorkflowEngine(db, redisClient, nil, zap.NewNop(), nil, nil, &services.WorkflowConfig{TaskQueue: "test-queue"})
+	handlers := NewHandlers(engine, nil, nil, zap.NewNop(), db)
+
+	analyzed := &models.Workflow{Name: "Analyzed", Type: models.WorkflowTypeIntent, ProjectID: "test-project-id"}
+	plain := &models.Workflow{Name: "Plain", Type: models.WorkflowTypeCustom, ProjectID: "test-project-id"}
+	assert.NoError(t, db.Create(analyzed).Error)
+	assert.NoError(t, db.Create(plain).Error)
+	assert.NoError(t, db.Create(&models.IntentAnalysis{
+		WorkflowID:    analyzed.ID,
+		ProjectID:     "test-project-id",
+		IntentType:    "code_generation",
+		Confidence:    0.4,
+		Entities:      json.RawMessage(`[{"type":"language","value":"go","confidence":0.9,"start":0,"end":2}]`),
+		LowConfidence: true,
+	}).Error)
+	assert.NoError(t, db.Create(&models.IntentRiskReview{
+		WorkflowID:     analyzed.ID,
+		ProjectID:      "test-project-id",
+		Action:         models.RiskActionRequireConfirmation,
+		TriggeredRisks: models.StringArray{"data_loss: drops the users table"},
+		Status:         models.RiskReviewPending,
+	}).Error)
+
+	router := gin.New()
+	router.GET("/api/v1/workflows/:id", handlers.GetWorkflow)
+
+	get := func(id string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/workflows/"+id, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Data
+	}
+
+	data := get(analyzed.ID)
+	assert.Equal(t, analyzed.ID, data["id"])
+	assert.Equal(t, "Analyzed", data["name"])
+	analysis, ok := data["analysis"].(map[string]interface{})
+	if assert.True(t, ok, "analysis missing from %v", data) {
+		assert.Equal(t, "code_generation", analysis["intent_type"])
+		assert.Equal(t, true, analysis["low_confidence"])
+		assert.Len(t, analysis["entities"], 1)
+	}
+	assert.Equal(t, []interface{}{"data_loss: drops the users table"}, data["pending_risks"])
+	review, ok := data["risk_review"].(map[string]interface{})
+	if assert.True(t, ok, "risk review missing from %v", data) {
+		assert.Equal(t, "require_confirmation", review["action"])
+		assert.Equal(t, "pending", review["status"])
+	}
+
+	data = get(plain.ID)
+	assert.Equal(t, "Plain", data["name"])
+	assert.NotContains(t, data, "analysis")
+	assert.NotContains(t, data, "risk_review")
+	assert.NotContains(t, data, "pending_risks")
+}
+
+// memoryShareTokens is an in-memory services.ShareTokenStore, holding the
+// workflow of issued tokens and an empty value for revoked ones
+type memoryShareTokens map[string]string
+
+func (m memoryShareTokens) Issue(ctx context.Context, tokenID, workflowID string, ttl time.Duration) error {
+	m[tokenID] = workflowID
+	return nil
+}
+
+func (m memoryShareTokens) Issued(ctx context.Context, tokenID string) (string, time.Duration, error) {
+	return m[tokenID], time.Hour, nil
+}
+
+func (m memoryShareTokens) Revoke(ctx context.Context, tokenID string, ttl time.Duration) error {
+	m[tokenID] = ""
+	return nil
+}
+
+func (m memoryShareTokens) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	workflowID, ok := m[tokenID]
+	return ok && workflowID == "", nil
+}
+
+func TestHandlers_WorkflowShareLinks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	assert.NoError(t, db.Exec(`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT,
+		"order" INTEGER, status TEXT, started_at DATETIME, completed_at DATETIME)`).Error)
+	handlers := setupTestHandlers(t, db)
+	handlers.SetShareService(services.NewWorkflowShareService(db, memoryShareTokens{}, &config.ShareConfig{
+		Secret:        strings.Repeat("s", 32),
+		DefaultTTL:    3600,
+		MaxTTL:        7200,
+		PublicBaseURL: "https://status.example.com",
+	}, zap.NewNop()))
+
+	workflow := &models.Workflow{
+		Name:      "Shared Workflow",
+		Type:      models.WorkflowTypeCustom,
+		Status:    models.WorkflowStatusRunning,
+		ProjectID: "test-project-id",
+		Input:     json.RawMessage(`{"password": "hunter2"}`),
+	}
+	assert.NoError(t, db.Create(workflow).Error)
+
+	router := gin.New()
+	router.POST("/api/v1/workflows/:id/share", handlers.CreateWorkflowShare)
+	router.DELETE("/api/v1/workflows/:id/share/:token_id", handlers.RevokeWorkflowShare)
+	router.GET("/api/v1/public/workflows/:token", handlers.GetPublicWorkflow)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v1/workflows/"+workflow.ID+"/share", `{"expires_in_seconds": 86400}`).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/v1/workflows/00000000-0000-0000-0000-999999999999/share", "").Code)
+
+	rec := do(http.MethodPost, "/api/v1/workflows/"+workflow.ID+"/share", "")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Data services.ShareToken `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, "https://status.example.com/api/v1/public/workflows/"+created.Data.Token, created.Data.URL)
+
+	rec = do(http.MethodGet, "/api/v1/public/workflows/"+created.Data.Token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Shared Workflow")
+	assert.NotContains(t, rec.Body.String(), "hunter2")
+
+	rec = do(http.MethodGet, "/api/v1/public/workflows/"+created.Data.Token+"x", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "details")
+
+	// Links are only revoked through their workflow
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/v1/workflows/00000000-0000-0000-0000-999999999999/share/"+created.Data.ID, "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v1/public/workflows/"+created.Data.Token, "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/api/v1/workflows/"+workflow.ID+"/share/"+created.Data.ID, "").Code)
+	assert.Equal(t, http.StatusGone, do(http.MethodGet, "/api/v1/public/workflows/"+created.Data.Token, "").Code)
+}
+
+// fakeAgentManager serves a paginated agent list and records the pages requested
+func fakeAgentManager(t *testing.T, agents []services.Agent) (*httptest.Server, *[]int) {
+	var pages []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+		if page <= 0 {
+			page = 1
+		}
+		if pageSize <= 0 {
+			pageSize = 20
+		}
+		pages = append(pages, page)
+
+		start := (page - 1) * pageSize
+		if start > len(agents) {
+			start = len(agents)
+		}
+		end := start + pageSize
+		if end > len(agents) {
+			end = len(agents)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(services.AgentList{
+			Agents:     agents[start:end],
+			TotalCount: int64(len(agents)),
+			Page:       page,
+			PageSize:   pageSize,
+		}))
+	}))
+	t.Cleanup(server.Close)
+	return server, &pages
+}
+
+func TestHandlers_ListAgentsCapabilityAndLoadFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Now()
+	recent := now.Add(-30 * time.Second)
+	stale := now.Add(-time.Hour)
+	capabilities := func(names ...string) []services.Capability {
+		result := make([]services.Capability, len(names))
+		for i, name := range names {
+			result[i] = services.Capability{Name: name}
+		}
+		return result
+	}
+
+	// Filler agents push the interesting ones onto the second page
+	var population []services.Agent
+	for i := 0; i < 120; i++ {
+		population = append(population, services.Agent{
+			ID: fmt.Sprintf("filler-%d", i), Type: "filler", Load: 0.1, LastHeartbeat: &recent,
+		})
+	}
+	population = append(population,
+		services.Agent{ID: "full-match", Capabilities: capabilities("go", "docker", "postgres"), Load: 0.2, LastHeartbeat: &recent},
+		services.Agent{ID: "partial-match", Capabilities: capabilities("Go", "docker"), Load: 0.5, LastHeartbeat: &recent},
+		services.Agent{ID: "busy", Capabilities: capabilities("go", "docker", "postgres"), Load: 0.95, LastHeartbeat: &recent},
+		services.Agent{ID: "stale", Capabilities: capabilities("go", "docker", "postgres"), Load: 0.1, LastHeartbeat: &stale},
+		services.Agent{ID: "python", Capabilities: capabilities("python"), Load: 0.1, LastHeartbeat: &recent},
+	)
+
+	server, pages := fakeAgentManager(t, population)
+	agentClient, err := services.NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+	assert.NoError(t, err)
+
+	handlers := NewHandlers(nil, nil, agentClient, zap.NewNop(), nil)
+	router := gin.New()
+	router.GET("/api/v1/agents", handlers.ListAgents)
+
+	type listedAgent struct {
+		ID         string   `json:"id"`
+		MatchScore *float64 `json:"match_score"`
+	}
+	list := func(query string) (int, []listedAgent, int64) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/agents?"+query, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		var body struct {
+			Data struct {
+				Agents     []listedAgent `json:"agents"`
+				TotalCount int64         `json:"total_count"`
+			} `json:"data"`
+		}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		}
+		return rec.Code, body.Data.Agents, body.Data.TotalCount
+	}
+	ids := func(agents []listedAgent) []string {
+		result := make([]string, len(agents))
+		for i, agent := range agents {
+			result[i] = agent.ID
+		}
+		return result
+	}
+
+	t.Run("required capabilities are scored and ranked", func(t *testing.T) {
+		*pages = nil
+		code, agents, total := list("required_capabilities=go,docker,postgres&min_capability_match=0.6&max_load=0.8&heartbeat_within=5m")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, int64(2), total)
+		assert.Equal(t, []string{"full-match", "partial-match"}, ids(agents))
+		if assert.NotNil(t, agents[0].MatchScore) && assert.NotNil(t, agents[1].MatchScore) {
+			assert.InDelta(t, 1.0, *agents[0].MatchScore, 1e-9)
+			assert.InDelta(t, 2.0/3.0, *agents[1].MatchScore, 1e-9)
+		}
+		// Both pages were fetched from the agent manager
+		assert.Equal(t, []int{1, 2}, *pages)
+	})
+
+	t.Run("repeated capability requires all", func(t *testing.T) {
+		code, agents, _ := list("capability=postgres&capability=go")
+		assert.Equal(t, http.StatusOK, code)
+		assert.ElementsMatch(t, []string{"full-match", "busy", "stale"}, ids(agents))
+		for _, agent := range agents {
+			assert.Nil(t, agent.MatchScore)
+		}
+	})
+
+	t.Run("heartbeat and load", func(t *testing.T) {
+		code, agents, total := list("capability=go&max_load=0.3&heartbeat_within=10m")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, []string{"full-match"}, ids(agents))
+	})
+
+	t.Run("results are paginated after filtering", func(t *testing.T) {
+		code, agents, total := list("heartbeat_within=10m&page=2&page_size=100")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, int64(124), total)
+		assert.Len(t, agents, 24)
+	})
+
+	t.Run("unfiltered requests are passed through", func(t *testing.T) {
+		*pages = nil
+		code, agents, _ := list("page_size=10")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Len(t, agents, 10)
+		assert.Equal(t, []int{1}, *pages)
+	})
+
+	t.Run("invalid filters", func(t *testing.T) {
+		for _, query := range []string{
+			"required_capabilities=go&min_capability_match=1.5",
+			"min_capability_match=0.5",
+			"max_load=-1",
+			"heartbeat_within=soon",
+		} {
+			code, _, _ := list(query)
+			assert.Equal(t, http.StatusBadRequest, code, query)
+		}
+	})
+}
+
+func TestHandlers_ListAgentsPagination(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var population []services.Agent
+	for i := 0; i < 30; i++ {
+		population = append(population, services.Agent{ID: fmt.Sprintf("agent-%02d", i)})
+	}
+	server, _ := fakeAgentManager(t, population)
+	var upstream struct {
+		status     int
+		retryAfter string
+	}
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upstream.retryAfter != "" {
+			w.Header().Set("Retry-After", upstream.retryAfter)
+		}
+		w.WriteHeader(upstream.status)
+		assert.NoError(t, json.NewEncoder(w).Encode(services.ErrorResponse{Code: "UPSTREAM", Message: "queue overloaded"}))
+	}))
+	t.Cleanup(failing.Close)
+
+	list := func(baseURL, query string) *httptest.ResponseRecorder {
+		agentClient, err := services.NewAgentClient(&config.AgentManagerConfig{BaseURL: baseURL, HTTPTimeout: 5}, zap.NewNop())
+		assert.NoError(t, err)
+		router := gin.New()
+		router.GET("/api/v1/agents", NewHandlers(nil, nil, agentClient, zap.NewNop(), nil).ListAgents)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/agents?"+query, nil))
+		return rec
+	}
+	page := func(rec *httptest.ResponseRecorder) services.AgentList {
+		var list services.AgentList
+		assert.NoError(t, json.Unmarshal(extractData(t, rec), &list))
+		return list
+	}
+
+	t.Run("defaults and clamping", func(t *testing.T) {
+		rec := list(server.URL, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		defaults := page(rec)
+		assert.Len(t, defaults.Agents, services.DefaultAgentPageSize)
+		assert.True(t, defaults.HasMore)
+		assert.Equal(t, 1, defaults.Page)
+
+		clamped := page(list(server.URL, "page_size=5000"))
+		assert.Equal(t, services.MaxAgentPageSize, clamped.PageSize)
+		assert.Len(t, clamped.Agents, 30)
+		assert.False(t, clamped.HasMore)
+	})
+
+	t.Run("offset and limit", func(t *testing.T) {
+		rec := list(server.URL, "offset=25&limit=10")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		result := page(rec)
+		assert.Len(t, result.Agents, 5)
+		assert.Equal(t, "agent-25", result.Agents[0].ID)
+		assert.Equal(t, 25, result.Offset)
+		assert.Equal(t, 10, result.Limit)
+		assert.Equal(t, int64(30), result.TotalCount)
+		assert.False(t, result.HasMore)
+	})
+
+	t.Run("invalid pagination", func(t *testing.T) {
+		for _, query := range []string{"page=0", "page=two", "page_size=0", "offset=-1", "limit=0", "page=2&offset=10"} {
+			assert.Equal(t, http.StatusBadRequest, list(server.URL, query).Code, query)
+		}
+	})
+
+	t.Run("agent manager errors", func(t *testing.T) {
+		upstream.status = http.StatusInternalServerError
+		rec := list(failing.URL, "")
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Contains(t, rec.Body.String(), "queue overloaded")
+
+		upstream.status, upstream.retryAfter = http.StatusTooManyRequests, "12"
+		rec = list(failing.URL, "")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "12", rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Body.String(), "queue overloaded")
+	})
+}
+
+// fakeWorkerController reports a fixed health and counts restarts
+type fakeWorkerController struct {
+	health     temporal.WorkerHealth
+	restartErr error
+	restarts   int
+}
+
+func (f *fakeWorkerController) Health() temporal.WorkerHealth { return f.health }
+
+func (f *fakeWorkerController) Restart() error {
+	if f.restartErr != nil {
+		return f.restartErr
+	}
+	f.restarts++
+	f.health = temporal.WorkerHealth{State: temporal.WorkerStateRunning, Healthy: true, Restarts: f.restarts}
+	return nil
+}
+
+func TestHandlers_WorkerHealthAndRestart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	assert.NoError(t, db.Exec(`CREATE TABLE audit_logs (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		entity_type TEXT, entity_id TEXT, action TEXT, actor TEXT, details TEXT, created_at DATETIME)`).Error)
+	handlers := setupTestHandlers(t, db)
+	worker := &fakeWorkerController{health: temporal.WorkerHealth{
+		State:  temporal.WorkerStateRunning,
+		Reason: "no successful poll for 5m0s",
+	}}
+	handlers.SetWorkerController(worker)
+
+	router := gin.New()
+	router.GET("/health", handlers.HealthCheck)
+	router.POST("/api/v1/admin/worker/restart", func(c *gin.Context) {
+		c.Set("user_id", "ops-user")
+		handlers.RestartWorker(c)
+	})
+	call := func(method, path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return rec.Code, body
+	}
+
+	// A worker that stopped polling makes the service unhealthy
+	code, body := call(http.MethodGet, "/health")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	data := body["data"].(map[string]interface{})
+	assert.Equal(t, "unhealthy", data["status"])
+	assert.Equal(t, false, data["checks"].(map[string]interface{})["worker"])
+	assert.Equal(t, "no successful poll for 5m0s", data["worker"].(map[string]interface{})["reason"])
+
+	code, body = call(http.MethodPost, "/api/v1/admin/worker/restart")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["data"].(map[string]interface{})["worker"].(map[string]interface{})["healthy"])
+	assert.Equal(t, 1, worker.restarts)
+
+	code, body = call(http.MethodGet, "/health")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["data"].(map[string]interface{})["checks"].(map[string]interface{})["worker"])
+
+	var audit models.AuditLog
+	assert.NoError(t, db.First(&audit, "action = ?", "worker.restart").Error)
+	assert.Equal(t, "ops-user", audit.Actor)
+	assert.JSONEq(t, `{"previous_state": "running", "reason": "no successful poll for 5m0s"}`, string(audit.Details))
+
+	// Guarded restarts
+	worker.restartErr = fmt.Errorf("%w: retry in 20s", temporal.ErrWorkerRestartCooldown)
+	code, _ = call(http.MethodPost, "/api/v1/admin/worker/restart")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	worker.restartErr = temporal.ErrWorkerRestartInProgress
+	code, _ = call(http.MethodPost, "/api/v1/admin/worker/restart")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, 1, worker.restarts)
+}
+
+func TestHandlers_GetWorkflowReport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT, "order" INTEGER,
+			status TEXT, error TEXT, started_at DATETIME, completed_at DATETIME, duration INTEGER)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, created_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE intent_analyses (id TEXT PRIMARY KEY, workflow_id TEXT, created_at DATETIME)`,
+		`CREATE TABLE workflow_comments (id TEXT PRIMARY KEY, workflow_id TEXT, pinned BOOLEAN, created_at DATETIME)`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+	handlers := setupTestHandlers(t, db)
+
+	workflow := &models.Workflow{Name: "Reported", Type: models.WorkflowTypeCustom, ProjectID: "test-project-id"}
+	assert.NoError(t, db.Create(workflow).Error)
+	assert.NoError(t, db.Exec(`INSERT INTO workflow_steps (id, workflow_id, name, "order", status, error)
+		VALUES ('step-1', ?, 'build', 1, 'failed', 'compile error')`, workflow.ID).Error)
+
+	router := gin.New()
+	router.GET("/api/v1/workflows/:id/report", handlers.GetWorkflowReport)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/v1/workflows/" + workflow.ID + "/report")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Disposition"))
+	var report services.WorkflowReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, "Reported", report.Workflow.Name)
+	assert.Equal(t, 1, report.Totals.StepsFailed)
+
+	rec = get("/api/v1/workflows/" + workflow.ID + "/report?format=markdown")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="workflow-`+workflow.ID+`-report.md"`, rec.Header().Get("Content-Disposition"))
+	assert.Contains(t, rec.Body.String(), "| step | build | compile error |")
+
+	rec = get("/api/v1/workflows/" + workflow.ID + "/report?format=pdf")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = get("/api/v1/workflows/00000000-0000-0000-0000-999999999999/report")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandlers_CompareWorkflows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT, "order" INTEGER,
+			status TEXT, error TEXT, duration INTEGER)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, deleted_at DATETIME)`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+	handlers := setupTestHandlers(t, db)
+
+	var ids []string
+	for _, workflowType := range []models.WorkflowType{models.WorkflowTypeCustom, models.WorkflowTypeCustom, models.WorkflowTypeReview} {
+		workflow := &models.Workflow{Name: "Compared", Type: workflowType, ProjectID: "test-project-id"}
+		assert.NoError(t, db.Create(workflow).Error)
+		ids = append(ids, workflow.ID)
+	}
+	assert.NoError(t, db.Exec(`INSERT INTO workflow_steps (id, workflow_id, name, "order", status, error)
+		VALUES ('step-1', ?, 'build', 1, 'failed', 'compile error')`, ids[1]).Error)
+
+	router := gin.New()
+	router.GET("/api/v1/workflows/:id/compare", handlers.CompareWorkflows)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/api/v1/workflows/" + ids[0] + "/compare?with=" + ids[1])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Data services.WorkflowComparison `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, 1, body.Data.Summary.StepsAdded)
+	assert.Equal(t, 1, body.Data.Summary.NewFailures)
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/workflows/"+ids[0]+"/compare").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/workflows/"+ids[0]+"/compare?with="+ids[2]).Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/workflows/"+ids[0]+"/compare?with=00000000-0000-0000-0000-999999999999").Code)
+}
+
+func TestHandlers_ProjectSecretsAreWriteOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	assert.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, environment_vars BLOB, secrets BLOB, deleted_at DATETIME)`).Error)
+	assert.NoError(t, db.Exec(`INSERT INTO projects (id) VALUES ('project-1')`).Error)
+	handlers := setupTestHandlers(t, db)
+
+	router := gin.New()
+	router.GET("/projects/:id/variables", handlers.GetProjectVariables)
+	router.PUT("/projects/:id/variables", handlers.SetProjectVariables)
+	router.GET("/projects/:id/secrets", handlers.ListProjectSecrets)
+	router.PUT("/projects/:id/secrets/:name", handlers.SetProjectSecret)
+	router.DELETE("/projects/:id/secrets/:name", handlers.DeleteProjectSecret)
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Without encryption at rest there is nowhere safe to keep secrets
+	handlers.SetVariableService(services.NewVariableService(db, nil))
+	assert.Equal(t, http.StatusConflict, send(http.MethodPut, "/projects/project-1/secrets/TOKEN", `{"value": "tok-1"}`).Code)
+
+	keys, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{3}, 32)})
+	assert.NoError(t, err)
+	handlers.SetVariableService(services.NewVariableService(db, services.NewDBSecretProvider(db, encryption.NewEncryptor(keys))))
+
+	rec := send(http.MethodPut, "/projects/project-1/secrets/TOKEN", `{"value": "tok-1"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "tok-1")
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, "/projects/project-1/secrets/not-valid", `{"value": "x"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPut, "/projects/project-2/secrets/TOKEN", `{"value": "x"}`).Code)
+
+	rec = send(http.MethodGet, "/projects/project-1/secrets", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"secrets": ["TOKEN"]}`, string(extractData(t, rec)))
+
+	rec = send(http.MethodPut, "/projects/project-1/variables", `{"variables": {"URL": "https://api", "AUTH": "${secret.TOKEN}"}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = send(http.MethodGet, "/projects/project-1/variables", "")
+	assert.JSONEq(t, `{"variables": {"URL": "https://api", "AUTH": "${secret.TOKEN}"}}`, string(extractData(t, rec)))
+
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/projects/project-1/secrets/TOKEN", "").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/projects/project-1/secrets/TOKEN", "").Code)
+}
+
+// setupRewrapDB creates an sqlite database for the models with encrypted
+// columns, sealing under encryptor
+func setupRewrapDB(t *testing.T, encryptor *encryption.Encryptor) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	assert.NoError(t, err)
+	for _, model := range []interface{}{&models.WorkflowExecution{}, &models.Artifact{}} {
+		stmt := &gorm.Statement{DB: db}
+		assert.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DefaultValue == "gen_random_uuid()" {
+				field.HasDefaultValue = false
+				field.DefaultValue = ""
+			}
+		}
+	}
+	assert.NoError(t, encryption.Register(db, encryptor, encryption.NewSettingsPolicy(0)))
+	return db
+}
+
+func TestHandlers_RewrapEncryptedDataCoversRuns(t *testing.T) {
+	ctx := context.Background()
+	oldKey := map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}
+	oldKeys, err := encryption.NewLocalKeyProvider("k1", oldKey)
+	assert.NoError(t, err)
+	db := setupRewrapDB(t, encryption.NewEncryptor(oldKeys))
+	assert.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, settings TEXT, secrets BLOB, deleted_at DATETIME)`).Error)
+	assert.NoError(t, db.Exec(`INSERT INTO projects (id, settings) VALUES ('project-1', '{"encrypt_at_rest": true}')`).Error)
+	assert.NoError(t, services.NewDBSecretProvider(db, encryption.NewEncryptor(oldKeys)).SetSecret(ctx, "project-1", "API_TOKEN", "tok-123"))
+	assert.NoError(t, db.Exec(`CREATE TABLE workflows (id TEXT PRIMARY KEY, input TEXT, output TEXT)`).Error)
+	assert.NoError(t, db.Exec(`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, code TEXT, output TEXT)`).Error)
+	assert.NoError(t, db.Exec(`INSERT INTO executions (id, project_id) VALUES ('exec-1', 'project-1')`).Error)
+	assert.NoError(t, db.Migrator().CreateTable(&models.WorkflowExecution{}, &models.Artifact{}))
+
+	run := &models.WorkflowExecution{ID: "run-1", WorkflowID: "wf-1", ProjectID: "project-1", ExecutionID: "temporal-run-1",
+		Status: models.WorkflowStatusCompleted, Input: json.RawMessage(`{"code":"secret"}`), StartedAt: time.Now()}
+	assert.NoError(t, db.Create(run).Error)
+	artifact := &models.Artifact{ID: "artifact-1", ExecutionID: "exec-1", Name: "main.go", Type: "code",
+		ContentHead: []byte("package main // secret")}
+	assert.NoError(t, db.Create(artifact).Error)
+
+	// Rotate to k2, keeping k1 only for unwrapping
+	rotated, err := encryption.NewLocalKeyProvider("k2", map[string][]byte{"k1": oldKey["k1"], "k2": bytes.Repeat([]byte{2}, 32)})
+	assert.NoError(t, err)
+	h := NewHandlers(nil, nil, nil, zap.NewNop(), db)
+	h.SetEncryptor(encryption.NewEncryptor(rotated))
+	result, err := h.rewrapEncryptedData(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Rewrapped)
+
+	// With k1 retired the run, the artifact head and the secret still open. sqlite hands the rewrapped jsonb
+	// back as text, which json.RawMessage does not scan, so it is read raw.
+	newKeys, err := encryption.NewLocalKeyProvider("k2", map[string][]byte{"k2": bytes.Repeat([]byte{2}, 32)})
+	assert.NoError(t, err)
+	var input string
+	assert.NoError(t, db.Raw(`SELECT input FROM workflow_executions WHERE id = ?`, "run-1").Scan(&input).Error)
+	opened, err := encryption.NewEncryptor(newKeys).Decrypt(ctx, []byte(input))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"code":"secret"}`, string(opened))
+	var head []byte
+	assert.NoError(t, db.Raw(`SELECT content_head FROM artifacts WHERE id = ?`, "artifact-1").Row().Scan(&head))
+	opened, err = encryption.NewEncryptor(newKeys).Decrypt(ctx, head)
+	assert.NoError(t, err)
+	assert.Equal(t, "package main // secret", string(opened))
+	token, err := services.NewDBSecretProvider(db, encryption.NewEncryptor(newKeys)).GetSecret(ctx, "project-1", "API_TOKEN")
+	assert.NoError(t, err)
+	assert.Equal(t, "tok-123", token)
+}
+
+// extractData returns the data field of a success response
+func extractData(t *testing.T, rec *httptest.ResponseRecorder) json.RawMessage {
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return body.Data
+}
+
+func TestHandlers_FaultRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handlers := setupTestHandlers(t, setupTestDB(t))
+	router := gin.New()
+	router.POST("/api/v1/admin/faults", handlers.CreateFaultRule)
+	router.GET("/api/v1/admin/faults", handlers.ListFaultRules)
+	router.DELETE("/api/v1/admin/faults/:rule_id", handlers.DeleteFaultRule)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	activityRule := `{"target": "activity", "pattern": "*ExecuteTaskWithAgentActivity", "probability": 0.5, "error": "agent unavailable", "ttl_seconds": 60}`
+
+	// Fault injection is off unless an injector is set
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/api/v1/admin/faults", activityRule).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/admin/faults", "").Code)
+
+	cfg := &config.FaultInjectionConfig{Enabled: true, DefaultTTL: 300, MaxTTL: 3600, MaxDelay: 60, RefreshInterval: 1}
+	handlers.SetFaultInjector(services.NewFaultInjector(services.NewMemoryFaultRuleStore(), cfg, zap.NewNop()))
+
+	rec := serve(http.MethodPost, "/api/v1/admin/faults", activityRule)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var created struct {
+		Data services.FaultRule `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Data.ID)
+	assert.Equal(t, 60, created.Data.TTL)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/api/v1/admin/faults",
+		`{"target": "http", "pattern": "/api/v1/projects", "probability": 2, "status_code": 503}`).Code)
+
+	rec = serve(http.MethodGet, "/api/v1/admin/faults", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var listed struct {
+		Data struct {
+			Rules []services.FaultRule `json:"rules"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &listed))
+	assert.Len(t, listed.Data.Rules, 1)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/api/v1/admin/faults/"+created.Data.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodDelete, "/api/v1/admin/faults/"+created.Data.ID, "").Code)
+}
+
+func TestHandlers_ErrorResponsesClassifyDetails(t *testing.T) {
+	handlers := setupTestHandlers(t, setupTestDB(t))
+	respond := func(err error) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rec)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		handlers.respondError(c, http.StatusBadGateway, "Failed to execute task", err)
+		var body struct {
+			Error map[string]interface{} `json:"error"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body.Error
+	}
+
+	payload := respond(fmt.Errorf("agent error: \x1b[31m429 Too Many Requests\x1b[0m: %s", strings.Repeat("retry later; ", 1000)))
+	assert.Equal(t, "quota", payload["code"])
+	details, _ := payload["details"].(string)
+	assert.True(t, strings.HasPrefix(details, "agent error: 429 Too Many Requests"))
+	assert.Contains(t, details, "bytes truncated")
+
+	// Errors without details have no code
+	payload = respond(nil)
+	assert.NotContains(t, payload, "code")
+	assert.NotContains(t, payload, "details")
+}
+
+// killableRedis is a Redis hook caching values in memory until it is killed,
+// after which every command fails as if the server went away
+type killableRedis struct {
+	killed bool
+	values map[string]string
+}
+
+func (r *killableRedis) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (r *killableRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if r.killed {
+			return &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		}
+		args := cmd.Args()
+		switch cmd.Name() {
+		case "get":
+			value, ok := r.values[args[1].(string)]
+			if !ok {
+				return redis.Nil
+			}
+			cmd.(*redis.StringCmd).SetVal(value)
+		case "set":
+			r.values[args[1].(string)] = string(args[2].([]byte))
+		}
+		return nil
+	}
+}
+
+func (r *killableRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error { return nil }
+}
+
+func TestHandlers_GetWorkflowWhileRedisDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, deleted_at DATETIME)`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+	guard := services.RedisGuardFor(redisClient, zap.NewNop())
+	fake := &killableRedis{values: make(map[string]string)}
+	redisClient.AddHook(fake)
+	engine := services.NewWorkflowEngine(db, redisClient, nil, zap.NewNop(), nil, nil, &services.WorkflowConfig{TaskQueue: "test-queue"})
+	handlers := NewHandlers(engine, nil, nil, zap.NewNop(), db)
+	handlers.SetRedisHealth(guard)
+
+	workflow := &models.Workflow{Name: "Cached", Type: models.WorkflowTypeCustom, ProjectID: "test-project-id"}
+	assert.NoError(t, db.Create(workflow).Error)
+
+	router := gin.New()
+	router.GET("/health", handlers.HealthCheck)
+	router.GET("/api/v1/workflows/:id", handlers.GetWorkflow)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, get("/api/v1/workflows/"+workflow.ID).Code)
+	assert.Contains(t, fake.values, "workflow:"+workflow.ID)
+
+	// Redis dies mid-test: reads still succeed from the database
+	fake.killed = true
+	rec := get("/api/v1/workflows/" + workflow.ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"Cached"`)
+
+	rec = get("/health")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"degraded"`)
+}
+
+func TestHandlers_GetAgentStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Migrator().CreateTable(&models.TaskQualityScore{}))
+
+	tracker := services.NewTaskQualityTracker(db)
+	now := time.Now()
+	assert.NoError(t, tracker.RecordScores(context.Background(), []models.TaskQualityScore{
+		{WorkflowID: "wf-1", TaskID: "task-1", AgentID: "agent-1", Score: 0.9, Scorer: "heuristic", CreatedAt: now.Add(-time.Hour)},
+		{WorkflowID: "wf-1", TaskID: "task-2", AgentID: "agent-1", Score: 0.5, Scorer: "heuristic", CreatedAt: now.Add(-time.Hour)},
+		{WorkflowID: "wf-1", TaskID: "task-3", AgentID: "agent-2", Score: 0.8, Scorer: "reviewer", CreatedAt: now.Add(-time.Hour)},
+		// Outside the default window
+		{WorkflowID: "wf-0", TaskID: "task-1", AgentID: "agent-2", Score: 0.1, Scorer: "heuristic", CreatedAt: now.Add(-30 * 24 * time.Hour)},
+	}))
+
+	h := NewHandlers(nil, nil, nil, zap.NewNop(), nil)
+	h.SetTaskQualityTracker(tracker)
+	router := gin.New()
+	router.GET("/agents/stats", h.GetAgentStats)
+
+	var stats struct {
+		Agents []services.AgentQualityStats `json:"agents"`
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agents/stats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(extractData(t, w), &stats))
+	if assert.Len(t, stats.Agents, 2) {
+		assert.Equal(t, "agent-2", stats.Agents[0].AgentID)
+		assert.InDelta(t, 0.8, stats.Agents[0].AverageQuality, 1e-9)
+		assert.Equal(t, "agent-1", stats.Agents[1].AgentID)
+		assert.InDelta(t, 0.7, stats.Agents[1].AverageQuality, 1e-9)
+		assert.Equal(t, int64(2), stats.Agents[1].ScoredTasks)
+		assert.InDelta(t, 0.5, stats.Agents[1].MinQuality, 1e-9)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agents/stats?agent_id=agent-2&since="+now.Add(-60*24*time.Hour).UTC().Format(time.RFC3339), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(extractData(t, w), &stats))
+	if assert.Len(t, stats.Agents, 1) {
+		assert.Equal(t, int64(2), stats.Agents[0].ScoredTasks)
+		assert.InDelta(t, 0.45, stats.Agents[0].AverageQuality, 1e-9)
+	}
+}
+
+func TestHandlers_TemplateCatalogAndClone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	stmt := &gorm.Statement{DB: db}
+	assert.NoError(t, stmt.Parse(&models.WorkflowTemplate{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+	assert.NoError(t, db.Migrator().CreateTable(&models.WorkflowTemplate{}, &models.TemplateStat{}))
+	assert.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, name TEXT, deleted_at DATETIME)`).Error)
+	assert.NoError(t, db.Exec(`INSERT INTO projects (id, name) VALUES ('project-2', 'Payments')`).Error)
+
+	for _, template := range []*models.WorkflowTemplate{
+		{ID: "tpl-build", Name: "Build", Type: models.WorkflowTypeExecution, Version: "1.0.0", IsPublic: true, IsActive: true},
+		{ID: "tpl-deploy", Name: "Deploy", Type: models.WorkflowTypeDeployment, Version: "1.0.0", IsPublic: true, IsActive: true},
+	} {
+		assert.NoError(t, db.Create(template).Error)
+	}
+	templateID := "tpl-deploy"
+	assert.NoError(t, db.Create(&models.Workflow{Name: "Deploy", Type: models.WorkflowTypeDeployment, ProjectID: "project-2",
+		Status: models.WorkflowStatusCompleted, TemplateID: &templateID}).Error)
+
+	catalog := services.NewTemplateCatalog(db, zap.NewNop(), time.Minute)
+	_, err := catalog.RefreshStats(context.Background())
+	assert.NoError(t, err)
+
+	h := NewHandlers(nil, nil, nil, zap.NewNop(), nil)
+	h.SetTemplateCatalog(catalog)
+	router := gin.New()
+	router.GET("/workflow-templates/catalog", h.GetTemplateCatalog)
+	router.POST("/workflow-templates/:id/clone", h.CloneWorkflowTemplate)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/workflow-templates/catalog", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page services.TemplateCatalogPage
+	assert.NoError(t, json.Unmarshal(extractData(t, w), &page))
+	if assert.Len(t, page.Templates, 2) {
+		assert.Equal(t, "Deploy", page.Templates[0].Name)
+		assert.Equal(t, int64(1), page.Templates[0].Usage.WorkflowCount)
+		assert.Equal(t, 1.0, page.Templates[0].Usage.SuccessRate)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/workflow-templates/catalog?sort=random", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	clone := func(templateID, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/workflow-templates/"+templateID+"/clone", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = clone("tpl-build", `{"project_id": "project-2"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var cloned models.WorkflowTemplate
+	assert.NoError(t, json.Unmarshal(extractData(t, w), &cloned))
+	assert.Equal(t, "Build (Payments)", cloned.Name)
+	if assert.NotNil(t, cloned.SourceTemplateID) {
+		assert.Equal(t, "tpl-build", *cloned.SourceTemplateID)
+	}
+
+	assert.Equal(t, http.StatusConflict, clone("tpl-build", `{"project_id": "project-2"}`).Code)
+	assert.Equal(t, http.StatusNotFound, clone("tpl-missing", `{"project_id": "project-2"}`).Code)
+	assert.Equal(t, http.StatusNotFound, clone("tpl-build", `{"project_id": "project-missing", "name": "Build 2"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, clone("tpl-build", `{}`).Code)
+}
+
+func TestHandlers_ListEventConsumers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandlers(nil, nil, nil, zap.NewNop(), nil)
+	router := gin.New()
+	router.GET("/admin/event-consumers", h.ListEventConsumers)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/event-consumers", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	registry := events.NewRegistry(&config.EventsConfig{StallThreshold: 1}, zap.NewNop())
+	registry.Register("api_webhooks", events.ConsumerOptions{
+		Lag: func(ctx context.Context) (int64, error) { return 4, nil },
+	})
+	registry.Register("api_streamer", events.ConsumerOptions{Source: "workflow:events"}).Processed()
+	registry.Check(context.Background())
+	h.SetEventRegistry(registry)
+
+	var body struct {
+		Consumers []events.ConsumerStatus `json:"consumers"`
+		Stalled   int                     `json:"stalled"`
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/event-consumers", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(extractData(t, w), &body))
+	if assert.Len(t, body.Consumers, 2) {
+		assert.Equal(t, "api_streamer", body.Consumers[0].Name)
+		assert.Equal(t, int64(1), body.Consumers[0].Processed)
+		assert.Equal(t, "api_webhooks", body.Consumers[1].Name)
+		assert.Equal(t, int64(4), body.Consumers[1].Lag)
+	}
+	assert.Zero(t, body.Stalled)
+}
+
+func TestHandlers_SandboxPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, organization_id TEXT, settings BLOB, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, organization_id, settings) VALUES
+			('project-1', 'org-1', CAST('{"sandbox_policy": {"allowed_task_types": ["documentation"]}}' AS BLOB))`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+	policies := services.NewSandboxPolicies(db, &config.SandboxConfig{
+		AllowedTaskTypes: []string{"testing", "documentation"},
+		Organizations: map[string]config.SandboxPolicyConfig{
+			"org-1": {AllowedWorkflowTypes: []string{"task_execution", "custom"}},
+		},
+	})
+	engine := services.NewWorkflowEngine(db, nil, nil, zap.NewNop(), nil, nil, &services.WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(temporal.DefaultWorkflowTypes().Specs())
+	engine.SetSandboxPolicies(policies)
+	handlers := NewHandlers(engine, nil, nil, zap.NewNop(), db)
+	handlers.SetSandboxPolicies(policies)
+
+	router := gin.New()
+	router.GET("/api/v1/projects/:id/policy", handlers.GetProjectPolicy)
+	router.POST("/api/v1/workflows", handlers.StartWorkflow)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/projects/project-1/policy", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Data services.EffectiveSandboxPolicy `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, []models.WorkflowType{models.WorkflowTypeTaskExecution, models.WorkflowTypeCustom}, body.Data.AllowedWorkflowTypes)
+	assert.Equal(t, services.SandboxPolicySourceOrganization, body.Data.WorkflowTypesSource)
+	assert.Equal(t, []string{"documentation"}, body.Data.AllowedTaskTypes)
+	assert.Equal(t, services.SandboxPolicySourceProject, body.Data.TaskTypesSource)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/projects/project-2/policy", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Disallowed types are refused, listing the allowed ones
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/workflows",
+		strings.NewReader(`{"name": "deploy", "type": "deployment", "project_id": "project-1"}`)))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "allowed types: task_execution, custom")
+}
+
+func TestHandlers_AgentPreferences(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, updated_at DATETIME, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, settings) VALUES ('project-1', CAST('{"scheduling_weight": 2}' AS BLOB))`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+	agents := services.NewStubAgentClient(nil)
+	handlers := setupTestHandlers(t, db)
+	router := gin.New()
+	router.GET("/api/v1/projects/:id/agent-preferences", handlers.GetAgentPreferences)
+	router.PUT("/api/v1/projects/:id/agent-preferences", handlers.UpdateAgentPreferences)
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := send(http.MethodGet, "/api/v1/projects/project-1/agent-preferences", "")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	handlers.SetAgentPreferences(services.NewAgentPreferenceService(db, agents, &config.AgentManagerConfig{PreferenceBoost: 0.2}))
+	rec = send(http.MethodPut, "/api/v1/projects/project-1/agent-preferences",
+		`{"preferred_agent_ids": ["stub-code-executor"], "preferred_agent_types": ["fine-tuned"], "excluded_agent_ids": ["stub-meta-agent"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	rec = send(http.MethodGet, "/api/v1/projects/project-1/agent-preferences", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var prefs services.EffectiveAgentPreferences
+	assert.NoError(t, json.Unmarshal(extractData(t, rec), &prefs))
+	assert.Equal(t, []string{"stub-code-executor"}, prefs.PreferredAgentIDs)
+	assert.Equal(t, []string{"fine-tuned"}, prefs.PreferredAgentTypes)
+	assert.Equal(t, []string{"stub-meta-agent"}, prefs.ExcludedAgentIDs)
+	assert.Equal(t, 0.2, prefs.EffectiveBoost)
+
+	// Unknown agents and invalid preferences are refused
+	rec = send(http.MethodPut, "/api/v1/projects/project-1/agent-preferences", `{"preferred_agent_ids": ["ghost"]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "unknown agent ghost")
+	rec = send(http.MethodPut, "/api/v1/projects/project-1/agent-preferences", `{"boost": 2}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = send(http.MethodGet, "/api/v1/projects/project-2/agent-preferences", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHandlers_ReconcileWorkflows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	handlers := setupTestHandlers(t, db)
+	router := gin.New()
+	router.POST("/api/v1/admin/reconcile-workflows", handlers.ReconcileWorkflows)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/reconcile-workflows", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	temporalClient := new(mocks.Client)
+	temporalClient.On("ListWorkflow", mock.Anything, mock.Anything).Return(&workflowservice.ListWorkflowExecutionsResponse{}, nil)
+	engine := services.NewWorkflowEngine(db, nil, temporalClient, zap.NewNop(), nil, nil, &services.WorkflowConfig{TaskQueue: "test-queue"})
+	monitor := services.NewWorkflowMonitor(db, temporalClient, zap.NewNop(), nil, time.Minute)
+	handlers.SetWorkflowReconciler(services.NewWorkflowReconciler(monitor, engine, services.NewMemoryReconcileCheckpointStore(),
+		&config.ReconcileConfig{RequestsPerSecond: 10, PageSize: 10, OrphanPolicy: services.OrphanPolicyLog}))
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/reconcile-workflows?orphan_policy=delete", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/admin/reconcile-workflows?orphan_policy=terminate", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body struct {
+		Data services.ReconcileReport `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, services.OrphanPolicyTerminate, body.Data.OrphanPolicy)
+	assert.Zero(t, body.Data.Checked)
+}
+
+func TestHandlers_StartWorkflowDedupe(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-id")
+	temporalClient := new(mocks.Client)
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(run, nil)
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+	engine := services.NewWorkflowEngine(db, redisClient, temporalClient, zap.NewNop(), nil, nil, &services.WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(temporal.DefaultWorkflowTypes().Specs())
+	handlers := NewHandlers(engine, nil, nil, zap.NewNop(), db)
+
+	router := gin.New()
+	router.POST("/workflows", handlers.StartWorkflow)
+	start := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/workflows"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := start("", `{"name": "Build", "type": "code_execution", "project_id": "project-1", "input": {"code": "print(1)", "language": "python"}}`)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var first services.StartWorkflowResponse
+	assert.NoError(t, json.Unmarshal(extractData(t, rec), &first))
+
+	duplicate := `{"name": "Build again", "type": "code_execution", "project_id": "project-1", "input": {"language": "python", "code": "print(1)"}}`
+
+	rec = start("", duplicate)
+	assert.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var warned services.StartWorkflowResponse
+	assert.NoError(t, json.Unmarshal(extractData(t, rec), &warned))
+	assert.Equal(t, first.WorkflowID, warned.DuplicateOf)
+	assert.NotEmpty(t, warned.Warning)
+
+	rec = start("?dedupe=reuse", duplicate)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var reused services.StartWorkflowResponse
+	assert.NoError(t, json.Unmarshal(extractData(t, rec), &reused))
+	assert.Equal(t, first.WorkflowID, reused.WorkflowID)
+	assert.True(t, reused.Reused)
+
+	rec = start("?dedupe=strict", duplicate)
+	assert.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), first.WorkflowID)
+
+	rec = start("?dedupe=sometimes", duplicate)
+	assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+}
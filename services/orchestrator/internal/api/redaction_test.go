@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"orchestrator/internal/middleware"
+	"orchestrator/internal/redact"
+)
+
+func TestLogger_RedactsRequestLogs(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	redactor, err := redact.New([]string{"ssn"}, nil)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(middleware.Logger(zap.New(core), redactor))
+	router.GET("/workflows", func(c *gin.Context) {
+		c.Error(errors.New("upstream rejected api_key=k-123"))
+		c.Status(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/workflows?page=2&access_token=eyJhbGciOi&ssn=123-45-6789", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := logs.FilterMessage("Server error").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "/workflows?page=2&access_token=[REDACTED:string:medium]&ssn=[REDACTED:string:medium]", fields["path"])
+	assert.Equal(t, "Error #01: upstream rejected api_key=[REDACTED:string:short]\n", fields["error"])
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/middleware"
+	"orchestrator/internal/services"
+)
+
+func TestDeadlineMiddleware_ListAgents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The agent manager answers after delay, recording the deadlines it gets
+	var calls atomic.Int32
+	var delay atomic.Int64
+	var received atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		received.Store(r.Header.Get(deadline.Header))
+		select {
+		case <-time.After(time.Duration(delay.Load())):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(services.AgentList{Agents: []services.Agent{{ID: "agent-1"}}, TotalCount: 1}))
+	}))
+	t.Cleanup(server.Close)
+
+	agentClient, err := services.NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+	require.NoError(t, err)
+	router := gin.New()
+	router.Use(middleware.Deadline())
+	router.GET("/api/v1/agents", NewHandlers(nil, nil, agentClient, zap.NewNop(), nil).ListAgents)
+
+	list := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil)
+		if header != "" {
+			req.Header.Set(deadline.Header, header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := list("")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, received.Load(), "requests without a deadline pass none on")
+
+	sent := time.Now()
+	rec = list("2000")
+	require.Equal(t, http.StatusOK, rec.Code)
+	passed, err := deadline.Parse(received.Load().(string), time.Now())
+	require.NoError(t, err)
+	assert.WithinDuration(t, sent.Add(2*time.Second), passed, 100*time.Millisecond,
+		"the agent manager gets the time left of the request")
+
+	// Requests out of time are refused without calling the agent manager
+	before := calls.Load()
+	rec = list(time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Request deadline exceeded")
+	rec = list("0")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Equal(t, before, calls.Load())
+
+	rec = list("soon")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid X-Request-Deadline")
+
+	// A slow agent manager exhausts the request's deadline
+	delay.Store(int64(time.Second))
+	rec = list("100")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Request deadline exceeded")
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/middleware"
+	"orchestrator/internal/models"
+	"orchestrator/internal/requestid"
+	"orchestrator/internal/services"
+	"orchestrator/internal/temporal"
+)
+
+// publishRecorder is a Redis hook that records published messages instead of
+// sending commands to a server
+type publishRecorder struct {
+	messages []string
+}
+
+func (r *publishRecorder) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (r *publishRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "publish" {
+			r.messages = append(r.messages, string(cmd.Args()[2].([]byte)))
+		}
+		return nil
+	}
+}
+
+func (r *publishRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error { return nil }
+}
+
+// headerMap collects Temporal headers written by a context propagator
+type headerMap map[string]*commonpb.Payload
+
+func (h headerMap) Set(key string, value *commonpb.Payload) { h[key] = value }
+
+func TestRequestID_PropagatesFromRequestToAgentCall(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	recorder := &publishRecorder{}
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	redisClient.AddHook(recorder)
+
+	var startCtx context.Context
+	var startOptions client.StartWorkflowOptions
+	mockWorkflowRun := new(mocks.WorkflowRun)
+	mockWorkflowRun.On("GetID").Return("temporal-id")
+	mockWorkflowRun.On("GetRunID").Return("run-id")
+	mockTemporalClient := new(mocks.Client)
+	mockTemporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			startCtx = args.Get(0).(context.Context)
+			startOptions = args.Get(1).(client.StartWorkflowOptions)
+		}).
+		Return(mockWorkflowRun, nil)
+
+	db := setupTestDB(t)
+	engine := services.NewWorkflowEngine(db, redisClient, mockTemporalClient, logger, nil, nil, &services.WorkflowConfig{
+		TaskQueue: "test-queue",
+	})
+	engine.SetWorkflowTypes(temporal.DefaultWorkflowTypes().Specs())
+	handlers := NewHandlers(engine, nil, nil, logger, db)
+
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.Logger(logger, nil))
+	router.POST("/workflows", handlers.StartWorkflow)
+
+	// Request → workflow start
+	body := `{"name": "Traced", "type": "code_execution", "project_id": "project-1"}`
+	req := httptest.NewRequest(http.MethodPost, "/workflows", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestid.Header, "req-chain-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "req-chain-1", w.Header().Get(requestid.Header))
+
+	requestLogs := logs.FilterField(zap.String(requestid.Field, "req-chain-1"))
+	assert.Equal(t, 1, requestLogs.FilterMessage("Request processed").Len())
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, "req-chain-1", stored.RequestID)
+	assert.Equal(t, "req-chain-1", startOptions.Memo[requestid.Field])
+
+	require.NotEmpty(t, recorder.messages)
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(recorder.messages[0]), &event))
+	assert.Equal(t, "req-chain-1", event[requestid.Field])
+
+	// Workflow start → agent call. The propagator writes the request ID into
+	// the Temporal header, which the worker hands on to activities.
+	header := headerMap{}
+	propagator := temporal.NewRequestIDPropagator()
+	require.NoError(t, propagator.Inject(startCtx, header))
+
+	var agentRequestID string
+	agentManager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentRequestID = r.Header.Get(requestid.Header)
+		_ = json.NewEncoder(w).Encode(services.AgentList{Agents: []services.Agent{{
+			ID:           "executor-1",
+			Type:         "code_executor",
+			Status:       "active",
+			Capabilities: []services.Capability{{Name: "go"}},
+		}}})
+	}))
+	defer agentManager.Close()
+
+	agentClient, err := services.NewAgentClient(&config.AgentManagerConfig{BaseURL: agentManager.URL, HTTPTimeout: 5}, zap.NewNop())
+	require.NoError(t, err)
+	activities := temporal.NewActivities(db, zap.NewNop(), nil, agentClient)
+
+	var suite testsuite.WorkflowTestSuite
+	suite.SetContextPropagators([]workflow.ContextPropagator{propagator})
+	suite.SetHeader(&commonpb.Header{Fields: header})
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterActivity(activities.SelectAgentActivity)
+
+	env.ExecuteWorkflow(func(ctx workflow.Context) (*temporal.AgentInfo, error) {
+		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{StartToCloseTimeout: time.Minute})
+		var agent temporal.AgentInfo
+		err := workflow.ExecuteActivity(ctx, activities.SelectAgentActivity,
+			temporal.CodeExecutionRequest{Language: "go"}, (*services.SimulationConfig)(nil)).Get(ctx, &agent)
+		return &agent, err
+	})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	assert.Equal(t, "req-chain-1", agentRequestID)
+}
+
+func TestRequestID_GeneratedAndIncludedInErrorResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+
+	db := setupTestDB(t)
+	engine := services.NewWorkflowEngine(db, nil, nil, logger, nil, nil, &services.WorkflowConfig{TaskQueue: "test-queue"})
+	handlers := NewHandlers(engine, nil, nil, logger, db)
+
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.Logger(logger, nil))
+	router.POST("/workflows", handlers.StartWorkflow)
+
+	req := httptest.NewRequest(http.MethodPost, "/workflows", strings.NewReader(`{`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	generated := w.Header().Get(requestid.Header)
+	parsed, err := uuid.Parse(generated)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Version(7), parsed.Version())
+
+	var response struct {
+		Error struct {
+			Message   string `json:"message"`
+			RequestID string `json:"request_id"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, generated, response.Error.RequestID)
+
+	// Both the handler's error log and the request log carry the ID
+	assert.Equal(t, 2, logs.FilterField(zap.String(requestid.Field, generated)).Len())
+}
@@ -0,0 +1,494 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+	"orchestrator/internal/testutil"
+)
+
+// routeFakes holds the in-memory services behind a routing test
+type routeFakes struct {
+	workflows *testutil.FakeWorkflowService
+	projects  *testutil.FakeProjectManager
+	agents    *testutil.FakeAgentGateway
+}
+
+// errUpstream is the failure of a service the handlers depend on
+var errUpstream = errors.New("upstream unavailable")
+
+// setupRouteTest registers the project, workflow and agent routes on
+// handlers backed by in-memory services holding a project, a running
+// workflow and an agent
+func setupRouteTest(t *testing.T) (*gin.Engine, *routeFakes) {
+	gin.SetMode(gin.TestMode)
+
+	fakes := &routeFakes{
+		workflows: testutil.NewFakeWorkflowService(&models.Workflow{
+			ID:        "wf-1",
+			Name:      "Build",
+			Type:      models.WorkflowTypeExecution,
+			Status:    models.WorkflowStatusRunning,
+			ProjectID: "project-1",
+			Tags:      models.StringArray{"ci"},
+		}),
+		projects: testutil.NewFakeProjectManager(&models.Project{
+			ID:      "project-1",
+			Name:    "Platform",
+			Status:  models.ProjectStatusActive,
+			OwnerID: "owner-1",
+		}),
+		agents: testutil.NewFakeAgentGateway(services.Agent{
+			ID:     "agent-1",
+			Type:   "code_executor",
+			Status: "active",
+		}),
+	}
+	h := NewHandlers(fakes.workflows, fakes.projects, fakes.agents, zap.NewNop(), nil)
+
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	projects := v1.Group("/projects")
+	projects.POST("", h.CreateProject)
+	projects.POST("/stats:method", h.BatchProjectStats)
+	projects.GET("/:id", h.GetProject)
+	projects.GET("", h.ListProjects)
+	projects.PUT("/:id", h.UpdateProject)
+	projects.DELETE("/:id", h.DeleteProject)
+	projects.GET("/:id/intent-stats", h.GetIntentStats)
+	projects.GET("/:id/estimation-accuracy", h.GetEstimationAccuracy)
+	projects.GET("/:id/failure-analysis", h.GetFailureAnalysis)
+	projects.GET("/:id/storage", h.GetProjectStorage)
+
+	workflows := v1.Group("/workflows")
+	workflows.POST("", h.StartWorkflow)
+	workflows.POST("/validate", h.ValidateWorkflow)
+	workflows.GET("/:id", h.GetWorkflow)
+	workflows.GET("", h.ListWorkflows)
+	workflows.POST("/:id/cancel", h.CancelWorkflow)
+	workflows.POST("/:id/risk-confirmation", h.ConfirmIntentRisks)
+	workflows.POST("/:id/steps/:step_id/skip", h.SkipWorkflowStep)
+	workflows.POST("/:id/steps/:step_id/complete", h.CompleteWorkflowStep)
+	workflows.PATCH("/:id/tags", h.UpdateWorkflowTags)
+	workflows.GET("/:id/metrics", h.GetWorkflowMetrics)
+	workflows.GET("/:id/pending-activities", h.GetPendingActivities)
+	workflows.GET("/:id/state", h.GetWorkflowState)
+	workflows.GET("/:id/runs", h.ListWorkflowRuns)
+	workflows.POST("/drafts", h.CreateWorkflowDraft)
+	workflows.PUT("/drafts/:id", h.UpdateWorkflowDraft)
+	workflows.DELETE("/drafts/:id", h.DeleteWorkflowDraft)
+	workflows.POST("/:id/submit", h.SubmitWorkflowDraft)
+	workflows.POST("/:id/rerun", h.RerunWorkflow)
+
+	v1.GET("/metrics/overview", h.GetMetricsOverview)
+	v1.GET("/admin/workflows/visibility", h.ListWorkflowsFromTemporal)
+
+	templates := v1.Group("/workflow-templates")
+	templates.GET("/catalog", h.GetTemplateCatalog)
+	templates.POST("/:id/clone", h.CloneWorkflowTemplate)
+
+	agents := v1.Group("/agents")
+	agents.GET("", h.ListAgents)
+	agents.GET("/stats", h.GetAgentStats)
+	agents.GET("/:id", h.GetAgent)
+	agents.POST("/:id/restart", h.RestartAgent)
+	agents.POST("/execute-batch", h.ExecuteAgentBatch)
+
+	return router, fakes
+}
+
+func serveRoute(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlers_RoutingTable(t *testing.T) {
+	failProjects := func(f *routeFakes) { f.projects.Err = errUpstream }
+	failWorkflows := func(f *routeFakes) { f.workflows.Err = errUpstream }
+	failAgents := func(f *routeFakes) { f.agents.Err = errUpstream }
+	withDraft := func(f *routeFakes) {
+		f.workflows.Workflows["draft-1"] = &models.Workflow{ID: "draft-1", Name: "Deploy", Status: models.WorkflowStatusDraft}
+	}
+	withPendingRisks := func(f *routeFakes) {
+		f.workflows.RiskReviews["wf-1"] = &models.IntentRiskReview{
+			WorkflowID:     "wf-1",
+			Action:         models.RiskActionRequireConfirmation,
+			TriggeredRisks: []string{"data_loss: drops a table"},
+			Status:         models.RiskReviewPending,
+		}
+	}
+
+	withFailedIntent := func(f *routeFakes) {
+		wf := f.workflows.Workflows["wf-1"]
+		wf.Type = models.WorkflowTypeIntent
+		wf.Status = models.WorkflowStatusFailed
+		f.workflows.Checkpoints["wf-1"] = []*models.WorkflowCheckpoint{
+			{WorkflowID: "wf-1", Phase: models.CheckpointPhaseAnalysis, SchemaVersion: services.IntentAnalysisCheckpointVersion},
+			{WorkflowID: "wf-1", Phase: models.CheckpointPhasePlan, SchemaVersion: services.ExecutionPlanCheckpointVersion - 1},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		setup  func(*routeFakes)
+		status int
+	}{
+		// Projects
+		{"create project", http.MethodPost, "/api/v1/projects", `{"name": "Payments"}`, nil, http.StatusCreated},
+		{"create project without name", http.MethodPost, "/api/v1/projects", `{"description": "no name"}`, nil, http.StatusBadRequest},
+		{"create project upstream failure", http.MethodPost, "/api/v1/projects", `{"name": "Payments"}`, failProjects, http.StatusInternalServerError},
+		{"get project", http.MethodGet, "/api/v1/projects/project-1", "", nil, http.StatusOK},
+		{"get unknown project", http.MethodGet, "/api/v1/projects/missing", "", nil, http.StatusNotFound},
+		{"list projects", http.MethodGet, "/api/v1/projects?status=active&limit=10", "", nil, http.StatusOK},
+		{"list projects upstream failure", http.MethodGet, "/api/v1/projects", "", failProjects, http.StatusInternalServerError},
+		{"update project", http.MethodPut, "/api/v1/projects/project-1", `{"description": "updated"}`, nil, http.StatusOK},
+		{"update project invalid body", http.MethodPut, "/api/v1/projects/project-1", `{`, nil, http.StatusBadRequest},
+		{"update project upstream failure", http.MethodPut, "/api/v1/projects/project-1", `{"description": "updated"}`, failProjects, http.StatusInternalServerError},
+		{"delete project", http.MethodDelete, "/api/v1/projects/project-1", "", nil, http.StatusOK},
+		{"delete project upstream failure", http.MethodDelete, "/api/v1/projects/project-1", "", failProjects, http.StatusInternalServerError},
+		{"intent stats", http.MethodGet, "/api/v1/projects/project-1/intent-stats", "", nil, http.StatusOK},
+		{"intent stats invalid window", http.MethodGet, "/api/v1/projects/project-1/intent-stats?since=yesterday", "", nil, http.StatusBadRequest},
+		{"intent stats upstream failure", http.MethodGet, "/api/v1/projects/project-1/intent-stats", "", failProjects, http.StatusInternalServerError},
+		{"estimation accuracy", http.MethodGet, "/api/v1/projects/project-1/estimation-accuracy", "", nil, http.StatusOK},
+		{"estimation accuracy inverted window", http.MethodGet, "/api/v1/projects/project-1/estimation-accuracy?since=2026-02-01T00:00:00Z&until=2026-01-01T00:00:00Z", "", nil, http.StatusBadRequest},
+		{"estimation accuracy upstream failure", http.MethodGet, "/api/v1/projects/project-1/estimation-accuracy", "", failProjects, http.StatusInternalServerError},
+		{"failure analysis", http.MethodGet, "/api/v1/projects/project-1/failure-analysis?window=6h", "", nil, http.StatusOK},
+		{"failure analysis invalid window", http.MethodGet, "/api/v1/projects/project-1/failure-analysis?window=forever", "", nil, http.StatusBadRequest},
+		{"failure analysis window too long", http.MethodGet, "/api/v1/projects/project-1/failure-analysis?window=8760h", "", nil, http.StatusBadRequest},
+		{"failure analysis upstream failure", http.MethodGet, "/api/v1/projects/project-1/failure-analysis", "", failProjects, http.StatusInternalServerError},
+		{"project storage", http.MethodGet, "/api/v1/projects/project-1/storage", "", nil, http.StatusOK},
+		{"unknown project storage", http.MethodGet, "/api/v1/projects/missing/storage", "", nil, http.StatusNotFound},
+		{"project storage upstream failure", http.MethodGet, "/api/v1/projects/project-1/storage", "", failProjects, http.StatusInternalServerError},
+		{"project stats batch", http.MethodPost, "/api/v1/projects/stats:batch", `{"project_ids": ["project-1", "missing"]}`, nil, http.StatusOK},
+		{"project stats batch without projects", http.MethodPost, "/api/v1/projects/stats:batch", `{"project_ids": []}`, nil, http.StatusBadRequest},
+		{"project stats batch invalid body", http.MethodPost, "/api/v1/projects/stats:batch", `{`, nil, http.StatusBadRequest},
+		{"project stats unknown method", http.MethodPost, "/api/v1/projects/stats:sum", `{"project_ids": ["project-1"]}`, nil, http.StatusNotFound},
+		{"project stats batch upstream failure", http.MethodPost, "/api/v1/projects/stats:batch", `{"project_ids": ["project-1"]}`, failProjects, http.StatusInternalServerError},
+
+		// Workflows
+		{"start workflow", http.MethodPost, "/api/v1/workflows", `{"name": "Deploy", "type": "deployment", "project_id": "project-1"}`, nil, http.StatusCreated},
+		{"start workflow without type", http.MethodPost, "/api/v1/workflows", `{"name": "Deploy", "project_id": "project-1"}`, nil, http.StatusBadRequest},
+		{"start workflow invalid tags", http.MethodPost, "/api/v1/workflows", `{"name": "Deploy", "type": "deployment", "project_id": "project-1"}`,
+			func(f *routeFakes) { f.workflows.Err = services.ErrInvalidTags }, http.StatusBadRequest},
+		{"start workflow invalid source", http.MethodPost, "/api/v1/workflows",
+			`{"name": "Analyze", "type": "code_analysis", "project_id": "project-1", "source": {"commit_sha": "HEAD"}}`,
+			func(f *routeFakes) { f.workflows.Err = services.ErrInvalidWorkflowSource }, http.StatusBadRequest},
+		{"start workflow unknown project", http.MethodPost, "/api/v1/workflows", `{"name": "Deploy", "type": "deployment", "project_id": "missing"}`,
+			func(f *routeFakes) { f.workflows.Err = services.ErrProjectNotFound }, http.StatusNotFound},
+		{"start workflow upstream failure", http.MethodPost, "/api/v1/workflows", `{"name": "Deploy", "type": "deployment", "project_id": "project-1"}`, failWorkflows, http.StatusInternalServerError},
+		{"validate workflow", http.MethodPost, "/api/v1/workflows/validate",
+			`{"name": "Deploy", "type": "deployment", "project_id": "project-1", "steps": [{"id": "build"}, {"id": "ship", "depends_on": ["build"]}]}`, nil, http.StatusOK},
+		{"validate workflow cyclic plan", http.MethodPost, "/api/v1/workflows/validate",
+			`{"name": "Deploy", "type": "deployment", "project_id": "project-1", "steps": [{"id": "build", "depends_on": ["ship"]}, {"id": "ship", "depends_on": ["build"]}]}`, nil, http.StatusBadRequest},
+		{"validate workflow upstream failure", http.MethodPost, "/api/v1/workflows/validate", `{"name": "Deploy", "type": "deployment", "project_id": "project-1"}`, failWorkflows, http.StatusInternalServerError},
+		{"get workflow", http.MethodGet, "/api/v1/workflows/wf-1", "", nil, http.StatusOK},
+		{"get unknown workflow", http.MethodGet, "/api/v1/workflows/missing", "", nil, http.StatusNotFound},
+		{"list workflows", http.MethodGet, "/api/v1/workflows?project_id=project-1", "", nil, http.StatusOK},
+		{"list workflows invalid sla status", http.MethodGet, "/api/v1/workflows?sla_status=late", "", nil, http.StatusBadRequest},
+		{"list workflows invalid label", http.MethodGet, "/api/v1/workflows?label=team", "", nil, http.StatusBadRequest},
+		{"list workflows by commit", http.MethodGet, "/api/v1/workflows?repository=github.com%2Facme%2Forders&commit=9fceb02d", "", nil, http.StatusOK},
+		{"list workflows upstream failure", http.MethodGet, "/api/v1/workflows", "", failWorkflows, http.StatusInternalServerError},
+		{"visibility query", http.MethodGet, "/api/v1/admin/workflows/visibility?query=Priority%20%3D%20'high'", "", nil, http.StatusOK},
+		{"visibility invalid query", http.MethodGet, "/api/v1/admin/workflows/visibility?query=bad", "",
+			func(f *routeFakes) { f.workflows.Err = services.ErrInvalidVisibilityQuery }, http.StatusBadRequest},
+		{"visibility upstream failure", http.MethodGet, "/api/v1/admin/workflows/visibility", "", failWorkflows, http.StatusBadGateway},
+		{"cancel workflow", http.MethodPost, "/api/v1/workflows/wf-1/cancel", `{"reason": "superseded"}`, nil, http.StatusOK},
+		{"cancel unknown workflow", http.MethodPost, "/api/v1/workflows/missing/cancel", "", nil, http.StatusInternalServerError},
+		{"update tags", http.MethodPatch, "/api/v1/workflows/wf-1/tags", `{"add": ["release"]}`, nil, http.StatusOK},
+		{"update tags without changes", http.MethodPatch, "/api/v1/workflows/wf-1/tags", `{}`, nil, http.StatusBadRequest},
+		{"update tags of unknown workflow", http.MethodPatch, "/api/v1/workflows/missing/tags", `{"add": ["release"]}`, nil, http.StatusNotFound},
+		{"update tags upstream failure", http.MethodPatch, "/api/v1/workflows/wf-1/tags", `{"add": ["release"]}`, failWorkflows, http.StatusInternalServerError},
+		{"workflow metrics", http.MethodGet, "/api/v1/workflows/wf-1/metrics", "", nil, http.StatusOK},
+		{"workflow metrics upstream failure", http.MethodGet, "/api/v1/workflows/wf-1/metrics", "", failWorkflows, http.StatusInternalServerError},
+		{"pending activities", http.MethodGet, "/api/v1/workflows/wf-1/pending-activities", "", nil, http.StatusOK},
+		{"pending activities of unknown workflow", http.MethodGet, "/api/v1/workflows/missing/pending-activities", "", nil, http.StatusNotFound},
+		{"pending activities upstream failure", http.MethodGet, "/api/v1/workflows/wf-1/pending-activities", "", failWorkflows, http.StatusInternalServerError},
+		{"metrics overview", http.MethodGet, "/api/v1/metrics/overview?project_id=project-1&window=6h", "", nil, http.StatusOK},
+		{"metrics overview invalid window", http.MethodGet, "/api/v1/metrics/overview?window=forever", "", nil, http.StatusBadRequest},
+		{"metrics overview window too long", http.MethodGet, "/api/v1/metrics/overview?window=8760h", "", nil, http.StatusBadRequest},
+		{"metrics overview upstream failure", http.MethodGet, "/api/v1/metrics/overview", "", failWorkflows, http.StatusInternalServerError},
+		{"workflow runs", http.MethodGet, "/api/v1/workflows/wf-1/runs", "", nil, http.StatusOK},
+		{"runs of unknown workflow", http.MethodGet, "/api/v1/workflows/missing/runs", "", nil, http.StatusNotFound},
+		{"workflow runs upstream failure", http.MethodGet, "/api/v1/workflows/wf-1/runs", "", failWorkflows, http.StatusInternalServerError},
+		{"confirm risks", http.MethodPost, "/api/v1/workflows/wf-1/risk-confirmation", `{"confirmed": true}`, withPendingRisks, http.StatusAccepted},
+		{"deny risks", http.MethodPost, "/api/v1/workflows/wf-1/risk-confirmation", `{"confirmed": false, "comment": "not in prod"}`, withPendingRisks, http.StatusAccepted},
+		{"confirm risks without decision", http.MethodPost, "/api/v1/workflows/wf-1/risk-confirmation", `{}`, withPendingRisks, http.StatusBadRequest},
+		{"confirm risks not pending", http.MethodPost, "/api/v1/workflows/wf-1/risk-confirmation", `{"confirmed": true}`, nil, http.StatusConflict},
+		{"confirm risks of unknown workflow", http.MethodPost, "/api/v1/workflows/missing/risk-confirmation", `{"confirmed": true}`, nil, http.StatusNotFound},
+		{"confirm risks upstream failure", http.MethodPost, "/api/v1/workflows/wf-1/risk-confirmation", `{"confirmed": true}`, failWorkflows, http.StatusInternalServerError},
+		{"skip step", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/skip", "", nil, http.StatusAccepted},
+		{"skip step with reason", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/skip", `{"reason": "done by hand"}`, nil, http.StatusAccepted},
+		{"skip step of finished workflow", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/skip", "", withFailedIntent, http.StatusConflict},
+		{"skip step of unknown workflow", http.MethodPost, "/api/v1/workflows/missing/steps/step-2/skip", "", nil, http.StatusNotFound},
+		{"complete step", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/complete", `{"output": {"ticket": "OPS-1"}}`, nil, http.StatusAccepted},
+		{"complete step without output", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/complete", `{}`, nil, http.StatusBadRequest},
+		{"complete step forbidden", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/complete", `{"output": {}}`,
+			func(f *routeFakes) { f.workflows.Err = services.ErrOverrideForbidden }, http.StatusForbidden},
+		{"complete finished step", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/complete", `{"output": {}}`,
+			func(f *routeFakes) { f.workflows.Err = services.ErrStepFinished }, http.StatusConflict},
+		{"complete step upstream failure", http.MethodPost, "/api/v1/workflows/wf-1/steps/step-2/complete", `{"output": {}}`, failWorkflows, http.StatusInternalServerError},
+		{"workflow state", http.MethodGet, "/api/v1/workflows/wf-1/state", "", nil, http.StatusOK},
+		{"state of unknown workflow", http.MethodGet, "/api/v1/workflows/missing/state", "", nil, http.StatusNotFound},
+		{"workflow state upstream failure", http.MethodGet, "/api/v1/workflows/wf-1/state", "", failWorkflows, http.StatusInternalServerError},
+		{"list workflows with drafts", http.MethodGet, "/api/v1/workflows?include_drafts=true", "", withDraft, http.StatusOK},
+		{"create draft", http.MethodPost, "/api/v1/workflows/drafts", `{"name": "Deploy"}`, nil, http.StatusCreated},
+		{"create draft invalid body", http.MethodPost, "/api/v1/workflows/drafts", `{`, nil, http.StatusBadRequest},
+		{"create draft invalid tags", http.MethodPost, "/api/v1/workflows/drafts", `{"name": "Deploy", "tags": ["Bad Tag"]}`,
+			func(f *routeFakes) { f.workflows.Err = services.ErrInvalidTags }, http.StatusBadRequest},
+		{"update draft", http.MethodPut, "/api/v1/workflows/drafts/draft-1", `{"name": "Deploy", "type": "deployment"}`, withDraft, http.StatusOK},
+		{"update unknown draft", http.MethodPut, "/api/v1/workflows/drafts/missing", `{"name": "Deploy"}`, nil, http.StatusNotFound},
+		{"update running workflow as draft", http.MethodPut, "/api/v1/workflows/drafts/wf-1", `{"name": "Deploy"}`, nil, http.StatusConflict},
+		{"submit draft", http.MethodPost, "/api/v1/workflows/draft-1/submit", "", withDraft, http.StatusOK},
+		{"submit incomplete draft", http.MethodPost, "/api/v1/workflows/draft-1/submit", "",
+			func(f *routeFakes) { f.workflows.Err = services.ErrIncompleteDraft }, http.StatusBadRequest},
+		{"submit running workflow", http.MethodPost, "/api/v1/workflows/wf-1/submit", "", nil, http.StatusConflict},
+		{"submit draft upstream failure", http.MethodPost, "/api/v1/workflows/draft-1/submit", "", failWorkflows, http.StatusInternalServerError},
+		{"rerun workflow", http.MethodPost, "/api/v1/workflows/wf-1/rerun", "", withFailedIntent, http.StatusCreated},
+		{"rerun from analysis", http.MethodPost, "/api/v1/workflows/wf-1/rerun?from=analysis", "", withFailedIntent, http.StatusCreated},
+		{"rerun from outdated plan", http.MethodPost, "/api/v1/workflows/wf-1/rerun?from=plan", "", withFailedIntent, http.StatusConflict},
+		{"rerun from unknown phase", http.MethodPost, "/api/v1/workflows/wf-1/rerun?from=execute", "", withFailedIntent, http.StatusBadRequest},
+		{"rerun running workflow", http.MethodPost, "/api/v1/workflows/wf-1/rerun", "", nil, http.StatusConflict},
+		{"rerun unknown workflow", http.MethodPost, "/api/v1/workflows/missing/rerun", "", nil, http.StatusNotFound},
+		{"rerun upstream failure", http.MethodPost, "/api/v1/workflows/wf-1/rerun", "", failWorkflows, http.StatusInternalServerError},
+		{"delete draft", http.MethodDelete, "/api/v1/workflows/drafts/draft-1", "", withDraft, http.StatusOK},
+		{"delete unknown draft", http.MethodDelete, "/api/v1/workflows/drafts/missing", "", nil, http.StatusNotFound},
+
+		// Agents
+		{"list agents", http.MethodGet, "/api/v1/agents?type=code_executor", "", nil, http.StatusOK},
+		{"list agents invalid filter", http.MethodGet, "/api/v1/agents?max_load=-1", "", nil, http.StatusBadRequest},
+		{"list agents upstream failure", http.MethodGet, "/api/v1/agents", "", failAgents, http.StatusInternalServerError},
+		{"template catalog not configured", http.MethodGet, "/api/v1/workflow-templates/catalog", "", nil, http.StatusServiceUnavailable},
+		{"clone template not configured", http.MethodPost, "/api/v1/workflow-templates/tpl-1/clone", `{"project_id": "project-1"}`, nil, http.StatusServiceUnavailable},
+		{"agent stats not configured", http.MethodGet, "/api/v1/agents/stats", "", nil, http.StatusServiceUnavailable},
+		{"get agent", http.MethodGet, "/api/v1/agents/agent-1", "", nil, http.StatusOK},
+		{"get unknown agent", http.MethodGet, "/api/v1/agents/missing", "", nil, http.StatusNotFound},
+		{"restart agent", http.MethodPost, "/api/v1/agents/agent-1/restart", "", nil, http.StatusOK},
+		{"restart agent upstream failure", http.MethodPost, "/api/v1/agents/agent-1/restart", "", failAgents, http.StatusInternalServerError},
+		{"execute batch", http.MethodPost, "/api/v1/agents/execute-batch", `{"tasks": [{"agent_id": "agent-1", "task": {"type": "lint"}}]}`, nil, http.StatusOK},
+		{"execute batch aborted", http.MethodPost, "/api/v1/agents/execute-batch", `{"tasks": [{"agent_id": "missing", "task": {"type": "lint"}}], "fail_fast": true}`, nil, http.StatusOK},
+		{"execute batch without tasks", http.MethodPost, "/api/v1/agents/execute-batch", `{"tasks": []}`, nil, http.StatusBadRequest},
+		{"execute batch upstream failure", http.MethodPost, "/api/v1/agents/execute-batch", `{"tasks": [{"agent_id": "agent-1", "task": {"type": "lint"}}]}`, failAgents, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, fakes := setupRouteTest(t)
+			if tt.setup != nil {
+				tt.setup(fakes)
+			}
+
+			rec := serveRoute(router, tt.method, tt.path, tt.body)
+			require.Equal(t, tt.status, rec.Code, rec.Body.String())
+
+			var body struct {
+				Success bool `json:"success"`
+				Error   *struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			assert.Equal(t, rec.Code < http.StatusBadRequest, body.Success)
+			if !body.Success {
+				require.NotNil(t, body.Error)
+				assert.NotEmpty(t, body.Error.Message)
+			}
+		})
+	}
+}
+
+func TestHandlers_RoutesReachServices(t *testing.T) {
+	router, fakes := setupRouteTest(t)
+
+	// Starting a workflow applies the defaults and the caller
+	rec := serveRoute(router, http.MethodPost, "/api/v1/workflows", `{"name": "Deploy", "type": "deployment", "project_id": "project-1"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Len(t, fakes.workflows.Started, 1)
+	started := fakes.workflows.Started[0]
+	assert.Equal(t, "medium", started.Priority)
+	assert.Equal(t, 3, started.MaxRetries)
+	assert.Equal(t, 3600, started.TimeoutSeconds)
+	assert.Equal(t, "system", started.UserID)
+
+	var response services.StartWorkflowResponse
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &response))
+	assert.Equal(t, "running", response.Status)
+
+	// The workflow started is listed with the existing one
+	rec = serveRoute(router, http.MethodGet, "/api/v1/workflows?project_id=project-1&limit=1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Workflows []models.WorkflowSummary `json:"workflows"`
+		Total     int64                    `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &list))
+	assert.Equal(t, int64(2), list.Total)
+	assert.Len(t, list.Workflows, 1)
+
+	// Cancelling passes the reason and tags are merged
+	require.Equal(t, http.StatusOK, serveRoute(router, http.MethodPost, "/api/v1/workflows/wf-1/cancel", `{"reason": "superseded"}`).Code)
+	assert.Equal(t, "superseded", fakes.workflows.Cancelled["wf-1"])
+	assert.Equal(t, models.WorkflowStatusCancelled, fakes.workflows.Workflows["wf-1"].Status)
+
+	rec = serveRoute(router, http.MethodPatch, "/api/v1/workflows/wf-1/tags", `{"add": ["release"], "remove": ["ci"]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"workflow_id": "wf-1", "tags": ["release"]}`, string(extractData(t, rec)))
+
+	// Projects are created for the caller, updated and deleted
+	rec = serveRoute(router, http.MethodPost, "/api/v1/projects", `{"name": "Payments", "tags": ["billing"]}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var project models.Project
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &project))
+	assert.Equal(t, "system", project.OwnerID)
+	assert.Equal(t, http.StatusInternalServerError, serveRoute(router, http.MethodPost, "/api/v1/projects", `{"name": "Payments"}`).Code)
+
+	require.Equal(t, http.StatusOK, serveRoute(router, http.MethodPut, "/api/v1/projects/"+project.ID, `{"status": "archived"}`).Code)
+	assert.Equal(t, models.ProjectStatus("archived"), fakes.projects.Projects[project.ID].Status)
+	require.Equal(t, http.StatusOK, serveRoute(router, http.MethodDelete, "/api/v1/projects/"+project.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, serveRoute(router, http.MethodGet, "/api/v1/projects/"+project.ID, "").Code)
+
+	// Restarting an agent marks it restarting
+	require.Equal(t, http.StatusOK, serveRoute(router, http.MethodPost, "/api/v1/agents/agent-1/restart", "").Code)
+	assert.Equal(t, "restarting", fakes.agents.Agents["agent-1"].Status)
+
+	// A fail-fast batch reports the abort in its result
+	rec = serveRoute(router, http.MethodPost, "/api/v1/agents/execute-batch", `{
+		"tasks": [{"agent_id": "missing", "task": {"type": "lint"}}, {"agent_id": "agent-1", "task": {"type": "lint"}}],
+		"fail_fast": true
+	}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var batch services.BatchResult
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &batch))
+	assert.True(t, batch.Aborted)
+	assert.Equal(t, 1, batch.Failed)
+	assert.Equal(t, 1, batch.Skipped)
+}
+
+func TestHandlers_BatchProjectStatsVisibility(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	projects := testutil.NewFakeProjectManager(
+		&models.Project{ID: "project-1", OwnerID: "alice"},
+		&models.Project{ID: "project-2", OwnerID: "bob", Members: []models.ProjectMember{{UserID: "alice", Role: "viewer"}}},
+		&models.Project{ID: "project-3", OwnerID: "bob"},
+	)
+	projects.Stats["project-1"] = models.ProjectStats{TotalWorkflows: 4}
+	h := NewHandlers(testutil.NewFakeWorkflowService(), projects, nil, zap.NewNop(), nil)
+
+	batch := func(userID, authType string) []services.ProjectStatsResult {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("user_id", userID)
+			c.Set("auth_type", authType)
+		})
+		router.POST("/api/v1/projects/stats:method", h.BatchProjectStats)
+		rec := serveRoute(router, http.MethodPost, "/api/v1/projects/stats:batch", `{"project_ids": ["project-3", "project-2", "project-1"]}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var data struct {
+			Projects []services.ProjectStatsResult `json:"projects"`
+		}
+		require.NoError(t, json.Unmarshal(extractData(t, rec), &data))
+		return data.Projects
+	}
+
+	results := batch("alice", "jwt")
+	require.Len(t, results, 2, "projects alice cannot read are left out")
+	assert.Equal(t, "project-2", results[0].ProjectID)
+	assert.Equal(t, "project-1", results[1].ProjectID)
+	assert.Equal(t, int64(4), results[1].Stats.TotalWorkflows)
+
+	assert.Len(t, batch("api_user", "api_key"), 3, "API keys read every project")
+}
+
+// fakeCompatibility reports a fixed agent manager compatibility
+type fakeCompatibility services.AgentManagerCompatibility
+
+func (f fakeCompatibility) Compatibility() services.AgentManagerCompatibility {
+	return services.AgentManagerCompatibility(f)
+}
+
+func TestHandlers_IncompatibleAgentManager(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	workflows := testutil.NewFakeWorkflowService()
+	workflows.Err = fmt.Errorf("%w: agent manager API 2.0.0 is incompatible", services.ErrAgentManagerIncompatible)
+	h := NewHandlers(workflows, nil, nil, zap.NewNop(), nil)
+	h.SetAgentCompatibility(fakeCompatibility{
+		Version:    "2.0.0",
+		Features:   []string{},
+		Negotiated: true,
+		Reason:     "agent manager API 2.0.0 is incompatible with supported major version 1",
+	})
+
+	router := gin.New()
+	router.GET("/health", h.HealthCheck)
+	router.POST("/api/v1/workflows", h.StartWorkflow)
+
+	// The service stays up, degraded
+	rec := serveRoute(router, http.MethodGet, "/health", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var health struct {
+		Status       string                             `json:"status"`
+		Checks       map[string]bool                    `json:"checks"`
+		AgentManager services.AgentManagerCompatibility `json:"agent_manager"`
+	}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &health))
+	assert.Equal(t, "degraded", health.Status)
+	assert.False(t, health.Checks["agent_manager"])
+	assert.Equal(t, "2.0.0", health.AgentManager.Version)
+
+	rec = serveRoute(router, http.MethodPost, "/api/v1/workflows", `{"name": "Build", "type": "code_execution", "project_id": "project-1"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+// fakeRedisHealth reports a fixed Redis health
+type fakeRedisHealth services.RedisHealth
+
+func (f fakeRedisHealth) Health() services.RedisHealth {
+	return services.RedisHealth(f)
+}
+
+func TestHandlers_RedisDownDegradesHealth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := NewHandlers(testutil.NewFakeWorkflowService(), nil, nil, zap.NewNop(), nil)
+	downSince := time.Now().Add(-time.Minute)
+	h.SetRedisHealth(fakeRedisHealth{
+		DownSince:      &downSince,
+		LastError:      "dial tcp: connection refused",
+		BufferedEvents: 3,
+	})
+
+	router := gin.New()
+	router.GET("/health", h.HealthCheck)
+
+	// Core paths fall back, so the service stays up, degraded
+	rec := serveRoute(router, http.MethodGet, "/health", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var health struct {
+		Status string               `json:"status"`
+		Checks map[string]bool      `json:"checks"`
+		Redis  services.RedisHealth `json:"redis"`
+	}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &health))
+	assert.Equal(t, "degraded", health.Status)
+	assert.False(t, health.Checks["redis"])
+	assert.Equal(t, 3, health.Redis.BufferedEvents)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	taskqueuepb "go.temporal.io/api/taskqueue/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// backlogService describes every task queue with one poller and a backlog
+type backlogService struct {
+	workflowservice.WorkflowServiceClient
+}
+
+func (backlogService) DescribeTaskQueue(ctx context.Context, req *workflowservice.DescribeTaskQueueRequest, opts ...grpc.CallOption) (*workflowservice.DescribeTaskQueueResponse, error) {
+	return &workflowservice.DescribeTaskQueueResponse{
+		Pollers:         []*taskqueuepb.PollerInfo{{Identity: "worker-1"}},
+		TaskQueueStatus: &taskqueuepb.TaskQueueStatus{BacklogCountHint: 12, RatePerSecond: 4},
+	}, nil
+}
+
+func TestHandlers_ListTaskQueues(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	require.NoError(t, db.Create(&models.Workflow{Name: "held", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusPending}).Error)
+
+	handlers := setupTestHandlers(t, db)
+	router := gin.New()
+	router.GET("/api/v1/admin/task-queues", handlers.ListTaskQueues)
+	list := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/task-queues", nil))
+		return rec
+	}
+	assert.Equal(t, http.StatusNotFound, list().Code)
+
+	temporalClient := new(mocks.Client)
+	temporalClient.On("WorkflowService").Return(backlogService{})
+	registry := services.NewWorkflowTypeRegistry()
+	require.NoError(t, registry.Register(services.WorkflowTypeSpec{Type: models.WorkflowTypeExecution, Workflow: "CodeExecutionWorkflow"}))
+	handlers.SetTaskQueueMonitor(services.NewTaskQueueMonitor(db, temporalClient, registry, &config.TemporalConfig{
+		Namespace: "default", TaskQueue: "orchestrator-task-queue", TaskQueueStatsInterval: 30,
+	}, zap.NewNop()))
+
+	rec := list()
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var report struct {
+		Namespace string `json:"namespace"`
+		Queues    []struct {
+			Name     string `json:"name"`
+			Workflow struct {
+				Pollers        int     `json:"pollers"`
+				Backlog        int64   `json:"backlog"`
+				TasksPerSecond float64 `json:"tasks_per_second"`
+			} `json:"workflow"`
+			Activity struct {
+				Pollers int   `json:"pollers"`
+				Backlog int64 `json:"backlog"`
+			} `json:"activity"`
+			PendingWorkflows int64    `json:"pending_workflows"`
+			LagSeconds       *float64 `json:"lag_seconds"`
+			Limitations      []string `json:"limitations"`
+		} `json:"queues"`
+	}
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &report))
+	assert.Equal(t, "default", report.Namespace)
+	require.Len(t, report.Queues, 1)
+	queue := report.Queues[0]
+	assert.Equal(t, "orchestrator-task-queue", queue.Name)
+	assert.Equal(t, 1, queue.Workflow.Pollers)
+	assert.Equal(t, int64(12), queue.Workflow.Backlog)
+	assert.Equal(t, 4.0, queue.Workflow.TasksPerSecond)
+	assert.Equal(t, 1, queue.Activity.Pollers)
+	assert.Equal(t, int64(12), queue.Activity.Backlog)
+	assert.Equal(t, int64(1), queue.PendingWorkflows)
+	assert.NotNil(t, queue.LagSeconds)
+	assert.Len(t, queue.Limitations, 1, "only the backlog age is left out")
+}
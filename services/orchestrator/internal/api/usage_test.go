@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/middleware"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestHandlers_Usage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.UsageBucket{}, &models.UsageRollup{}))
+	meter := services.NewUsageMeter(db, services.NewMemoryUsageCounterStore(), &config.UsageConfig{RollupInterval: 60}, zap.NewNop())
+
+	handlers := setupTestHandlers(t, db)
+	handlers.SetUsageMeter(meter)
+	router := gin.New()
+	v1 := router.Group("/api/v1")
+	v1.Use(middleware.Auth("secret"), middleware.UsageMetering(meter))
+	v1.GET("/projects/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	v1.GET("/workflows", func(c *gin.Context) { c.Status(http.StatusOK) })
+	v1.GET("/usage", handlers.GetUsage)
+
+	get := func(path, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Concurrent requests of two API keys are each counted once
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%4 == 0 {
+				get("/api/v1/projects/project-1", "prod-api-key-456")
+				return
+			}
+			get("/api/v1/workflows?project_id=project-1", "test-api-key-123")
+		}(i)
+	}
+	wg.Wait()
+	_, err := meter.Rollup(context.Background())
+	require.NoError(t, err)
+
+	rec := get("/api/v1/usage?project_id=project-1&granularity=day", "test-api-key-123")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var report services.UsageReport
+	require.NoError(t, json.Unmarshal(extractData(t, rec), &report))
+	assert.Equal(t, map[string]int64{"requests.workflows": 30, "requests.projects": 10}, report.Totals)
+	// The default window is the last 24 hours, spanning two days
+	require.Len(t, report.Series, 2)
+	today := report.Series[1]
+	assert.Equal(t, time.Now().UTC().Truncate(24*time.Hour), today.Start.UTC())
+	assert.Equal(t, report.Totals, today.Metrics)
+	require.Len(t, report.BySubject, 2)
+	for subject, metrics := range report.BySubject {
+		assert.True(t, strings.HasPrefix(subject, "api_key:"), "API keys are billed by fingerprint, not by key")
+		assert.NotContains(t, subject, "api-key")
+		assert.Len(t, metrics, 1)
+	}
+
+	// Usage requests name no project, so they are not billed to one
+	rec = get("/api/v1/usage?project_id=project-1&format=csv", "test-api-key-123")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"period_start", "metric", "count"}, rows[0])
+	assert.Equal(t, []string{"requests.projects", "10"}, rows[1][1:])
+	assert.Equal(t, []string{"requests.workflows", "30"}, rows[2][1:])
+
+	for _, query := range []string{"granularity=minute", "format=xml", "from=yesterday", "from=2026-10-18T10:00:00Z&to=2026-10-18T09:00:00Z"} {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v1/usage?"+query, "test-api-key-123").Code, query)
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin/binding"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// warmupRequests are the request bodies of the workflow and project
+// endpoints, bound and validated once at warmup
+var warmupRequests = []interface{}{
+	&StartWorkflowRequest{},
+	&ValidateWorkflowRequest{},
+	&WorkflowDraftRequest{},
+	&CreateProjectRequest{},
+}
+
+// warmupResponses are the responses of the workflow endpoints, encoded once
+// at warmup
+var warmupResponses = []interface{}{
+	&services.StartWorkflowResponse{},
+	&models.Workflow{Steps: []models.WorkflowStep{{}}, Executions: []models.Execution{{}}},
+}
+
+// WarmupStep builds the caches the binding validator and the JSON codecs
+// fill on first use of a type, for the bodies and responses of the workflow
+// endpoints, so the first requests after startup do not build them
+func (h *Handlers) WarmupStep() services.WarmupStep {
+	return services.WarmupStep{Name: "api", Run: func(ctx context.Context) error {
+		for _, request := range warmupRequests {
+			if err := json.Unmarshal([]byte(`{}`), request); err != nil {
+				return err
+			}
+			// Empty bodies fail validation; the struct's rules are cached
+			// either way
+			_ = binding.Validator.ValidateStruct(request)
+		}
+		for _, response := range warmupResponses {
+			if _, err := json.Marshal(response); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+	"orchestrator/internal/temporal"
+)
+
+// BenchmarkFirstStartWorkflow times the first workflow start on a fresh
+// database and router, with and without the warmup. Process-wide caches
+// (schemas, codecs, validators) survive across iterations, so only the first
+// cold iteration is fully cold; run with -benchtime 1x -count N to compare.
+func BenchmarkFirstStartWorkflow(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	for _, warm := range []bool{false, true} {
+		name := "cold"
+		if warm {
+			name = "warm"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				router, handlers, db := setupFirstStartRouter(b)
+				if warm {
+					services.RunWarmup(context.Background(), []services.WarmupStep{
+						services.DatabaseWarmupStep(db, 1, &models.Workflow{}),
+						services.WorkflowConfigWarmupStep(),
+						handlers.WarmupStep(),
+					}, 5*time.Second, zap.NewNop())
+				}
+				req := httptest.NewRequest(http.MethodPost, "/workflows",
+					strings.NewReader(`{"name": "First", "type": "code_execution", "project_id": "project-1"}`))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				b.StartTimer()
+
+				router.ServeHTTP(w, req)
+
+				b.StopTimer()
+				if w.Code != http.StatusCreated {
+					b.Fatalf("status %d: %s", w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+// setupFirstStartRouter serves workflow starts from a fresh database, with
+// Temporal mocked and Redis unreachable
+func setupFirstStartRouter(b *testing.B) (*gin.Engine, *Handlers, *gorm.DB) {
+	mockWorkflowRun := new(mocks.WorkflowRun)
+	mockWorkflowRun.On("GetID").Return("temporal-id")
+	mockWorkflowRun.On("GetRunID").Return("run-id")
+	mockTemporalClient := new(mocks.Client)
+	mockTemporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(mockWorkflowRun, nil)
+
+	db := setupTestDB(b)
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	b.Cleanup(func() { redisClient.Close() })
+	engine := services.NewWorkflowEngine(db, redisClient, mockTemporalClient, zap.NewNop(), nil, nil, &services.WorkflowConfig{
+		TaskQueue: "test-queue",
+	})
+	engine.SetWorkflowTypes(temporal.DefaultWorkflowTypes().Specs())
+	handlers := NewHandlers(engine, nil, nil, zap.NewNop(), db)
+
+	router := gin.New()
+	router.POST("/workflows", handlers.StartWorkflow)
+	return router, handlers, db
+}
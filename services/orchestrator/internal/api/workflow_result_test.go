@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestHandlers_WorkflowResult(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	db := setupTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, workflow_id TEXT, workflow_step_id TEXT,
+			agent_id TEXT, name TEXT, type TEXT, status TEXT, language TEXT, runtime TEXT, code TEXT, script TEXT,
+			command TEXT, arguments TEXT, environment TEXT, input TEXT, output TEXT, logs TEXT, error TEXT,
+			error_signature TEXT, error_class TEXT, exit_code INTEGER, started_at DATETIME, completed_at DATETIME, duration INTEGER,
+			timeout_seconds INTEGER, retry_count INTEGER, max_retries INTEGER, retry_delay INTEGER,
+			resource_usage TEXT, metadata TEXT, tags TEXT, priority INTEGER, simulated BOOLEAN, queued_at DATETIME,
+			scheduled_at DATETIME, created_by TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT, path TEXT, url TEXT,
+			size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+			workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	full := `{"status":"success","task_results":[{"task_id":"task-1","status":"succeeded","output":{"log":"` +
+		strings.Repeat(`compiled\n`, 100) + `"},"duration":1000}],"summary":"Completed 1 of 1 tasks"}`
+	completedAt := time.Now()
+	workflow := &models.Workflow{Name: "tasks", Type: models.WorkflowTypeTaskExecution, ProjectID: "project-1",
+		Status: models.WorkflowStatusCompleted, Output: json.RawMessage(full), CompletedAt: &completedAt}
+	require.NoError(t, db.Create(workflow).Error)
+	pending := &models.Workflow{Name: "tasks", Type: models.WorkflowTypeTaskExecution, ProjectID: "project-1"}
+	require.NoError(t, db.Create(pending).Error)
+
+	store, err := services.NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	results := services.NewResultCompactor(db, store, &config.ResultsConfig{
+		Enabled: true, CompactInterval: 60, BatchSize: 10, ErrorSummaryBytes: 100,
+	}, zap.NewNop())
+	_, err = results.Compact(ctx, workflow.ID)
+	require.NoError(t, err)
+
+	handlers := setupTestHandlers(t, db)
+	handlers.SetResultCompactor(results)
+	router := gin.New()
+	router.GET("/api/v1/workflows/:id/result", handlers.GetWorkflowResult)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// The compacted result keeps the task's status and duration only
+	rec := get("/api/v1/workflows/" + workflow.ID + "/result")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var compacted struct {
+		TaskResults []map[string]interface{} `json:"task_results"`
+		Archive     *models.ResultArchive    `json:"archive"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &compacted))
+	require.Len(t, compacted.TaskResults, 1)
+	assert.Equal(t, "succeeded", compacted.TaskResults[0]["status"])
+	assert.NotContains(t, compacted.TaskResults[0], "output")
+	require.NotNil(t, compacted.Archive)
+	assert.Equal(t, len(full), compacted.Archive.Size)
+
+	rec = get("/api/v1/workflows/" + workflow.ID + "/result?full=true")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, full, rec.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/workflows/"+pending.ID+"/result").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/workflows/missing/result?full=true").Code)
+}
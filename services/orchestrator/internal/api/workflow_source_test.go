@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"orchestrator/internal/models"
+)
+
+func TestHandlers_ListWorkflowsBySource(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB(t)
+	handlers := setupTestHandlers(t, db)
+
+	create := func(name string, metadata string) string {
+		workflow := &models.Workflow{Name: name, Type: models.WorkflowTypeAnalysis, Status: models.WorkflowStatusCompleted,
+			ProjectID: "project-1"}
+		if metadata != "" {
+			workflow.Metadata = json.RawMessage(metadata)
+		}
+		require.NoError(t, db.Create(workflow).Error)
+		return workflow.ID
+	}
+	// Metadata as the engine writes it
+	orders := create("orders", `{"source":{"repository":"github.com/acme/orders","branch":"main",`+
+		`"commit_sha":"9fceb02d0ae598e9","ci_run_url":"https://ci.example.com/runs/42","pr_number":17}}`)
+	create("billing", `{"source":{"repository":"github.com/acme/billing","commit_sha":"1a2b3c4d"}}`)
+	create("manual", "")
+
+	router := gin.New()
+	router.GET("/api/v1/workflows", handlers.ListWorkflows)
+	list := func(query string) []map[string]interface{} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/workflows?"+query, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var page struct {
+			Workflows []map[string]interface{} `json:"workflows"`
+		}
+		require.NoError(t, json.Unmarshal(extractData(t, rec), &page))
+		return page.Workflows
+	}
+
+	workflows := list("commit=9FCEB02D0AE598E9")
+	require.Len(t, workflows, 1)
+	assert.Equal(t, orders, workflows[0]["id"])
+	assert.Equal(t, map[string]interface{}{
+		"repository": "github.com/acme/orders", "branch": "main", "commit_sha": "9fceb02d0ae598e9",
+		"ci_run_url": "https://ci.example.com/runs/42", "pr_number": float64(17),
+	}, workflows[0]["source"])
+	assert.NotContains(t, workflows[0], "metadata")
+
+	workflows = list("repository=github.com%2Facme%2Fbilling")
+	require.Len(t, workflows, 1)
+	assert.Equal(t, "billing", workflows[0]["name"])
+	assert.Empty(t, list("repository=github.com%2Facme%2Fbilling&commit=9fceb02d0ae598e9"))
+	assert.Len(t, list(""), 3)
+}
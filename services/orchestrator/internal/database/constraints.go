@@ -0,0 +1,167 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// QuarantinedRow is a row a migration moved out of its table because it
+// violated a constraint the migration adds. The row is kept as JSON so it can
+// be inspected and restored by hand.
+type QuarantinedRow struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	Migration     string          `gorm:"not null;index" json:"migration"`
+	SourceTable   string          `gorm:"not null;index:idx_quarantined_rows_source" json:"source_table"`
+	RowID         string          `gorm:"not null;index:idx_quarantined_rows_source" json:"row_id"`
+	Reason        string          `gorm:"not null" json:"reason"`
+	Data          json.RawMessage `gorm:"type:jsonb;not null" json:"data"`
+	QuarantinedAt time.Time       `gorm:"not null" json:"quarantined_at"`
+}
+
+// TableName specifies the table name for QuarantinedRow
+func (QuarantinedRow) TableName() string {
+	return "quarantined_rows"
+}
+
+// requiredColumns are columns the models require values for that AutoMigrate
+// created nullable
+var requiredColumns = []struct {
+	table  string
+	column string
+}{
+	{"workflows", "project_id"},
+	{"workflow_executions", "project_id"},
+}
+
+// foreignKey references a child row's parent by its id
+type foreignKey struct {
+	table    string
+	column   string
+	parent   string
+	onDelete string
+}
+
+// name is the constraint's name
+func (fk foreignKey) name() string {
+	return fmt.Sprintf("fk_%s_%s", fk.table, fk.column)
+}
+
+// foreignKeys are listed parents first, so the children of quarantined rows
+// are quarantined after them. Projects are only soft deleted; hard deletes
+// of workflows purge drafts, which take their dependents with them.
+var foreignKeys = []foreignKey{
+	// Project references
+	{"workflows", "project_id", "projects", "NO ACTION"},
+	{"project_members", "project_id", "projects", "CASCADE"},
+	{"environments", "project_id", "projects", "CASCADE"},
+	{"resources", "project_id", "projects", "CASCADE"},
+	{"integrations", "project_id", "projects", "CASCADE"},
+
+	// Workflow references
+	{"workflow_steps", "workflow_id", "workflows", "CASCADE"},
+	{"workflow_executions", "workflow_id", "workflows", "CASCADE"},
+	{"workflow_comments", "workflow_id", "workflows", "CASCADE"},
+	{"workflow_checkpoints", "workflow_id", "workflows", "CASCADE"},
+	{"intent_analyses", "workflow_id", "workflows", "CASCADE"},
+
+	// Execution references
+	{"executions", "project_id", "projects", "NO ACTION"},
+	{"executions", "workflow_id", "workflows", "CASCADE"},
+	{"artifacts", "execution_id", "executions", "CASCADE"},
+	{"execution_logs", "execution_id", "executions", "CASCADE"},
+	{"execution_events", "execution_id", "executions", "CASCADE"},
+}
+
+// quarantine moves the rows of table matching condition, with the table
+// aliased as t, to quarantined_rows
+func quarantine(tx *gorm.DB, migration, table, reason, condition string) error {
+	move := fmt.Sprintf(`INSERT INTO quarantined_rows (migration, source_table, row_id, reason, data, quarantined_at)
+		SELECT ?, ?, t.id::text, ?, to_jsonb(t), CURRENT_TIMESTAMP FROM %s t WHERE %s`, table, condition)
+	if err := tx.Exec(move, migration, table, reason).Error; err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", table, err)
+	}
+	remove := fmt.Sprintf("DELETE FROM %s t WHERE %s", table, condition)
+	if err := tx.Exec(remove).Error; err != nil {
+		return fmt.Errorf("failed to remove quarantined %s: %w", table, err)
+	}
+	return nil
+}
+
+// enforceRequiredColumns back-fills the projects of workflow runs from their
+// workflows, quarantines the rows still missing a required value and makes
+// the columns NOT NULL
+func enforceRequiredColumns(migration string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE workflow_executions SET project_id = w.project_id
+			FROM workflows w
+			WHERE w.id = workflow_executions.workflow_id AND workflow_executions.project_id IS NULL`).Error; err != nil {
+			return fmt.Errorf("failed to back-fill workflow run projects: %w", err)
+		}
+
+		for _, required := range requiredColumns {
+			// Text columns of older schemas may hold empty strings
+			condition := fmt.Sprintf("NULLIF(t.%s::text, '') IS NULL", required.column)
+			if err := quarantine(tx, migration, required.table, required.column+" is empty", condition); err != nil {
+				return err
+			}
+			sql := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL", required.table, required.column)
+			if err := tx.Exec(sql).Error; err != nil {
+				return fmt.Errorf("failed to require %s.%s: %w", required.table, required.column, err)
+			}
+		}
+		return nil
+	}
+}
+
+// addForeignKeys quarantines the rows referencing missing parents and adds
+// the foreign keys
+func addForeignKeys(migration string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		for _, fk := range foreignKeys {
+			condition := fmt.Sprintf("t.%s IS NOT NULL AND NOT EXISTS (SELECT 1 FROM %s p WHERE p.id = t.%s)",
+				fk.column, fk.parent, fk.column)
+			reason := fmt.Sprintf("%s references a missing %s row", fk.column, fk.parent)
+			if err := quarantine(tx, migration, fk.table, reason, condition); err != nil {
+				return err
+			}
+
+			if tx.Migrator().HasConstraint(fk.table, fk.name()) {
+				continue
+			}
+			sql := fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (id) ON DELETE %s",
+				fk.table, fk.name(), fk.column, fk.parent, fk.onDelete)
+			if err := tx.Exec(sql).Error; err != nil {
+				return fmt.Errorf("failed to add foreign key %s: %w", fk.name(), err)
+			}
+		}
+		return nil
+	}
+}
+
+// createCompositeIndexes creates the indexes behind the per-workflow and
+// per-execution listings and the pending queue
+func createCompositeIndexes(tx *gorm.DB) error {
+	indexes := []struct {
+		table   string
+		name    string
+		columns string
+	}{
+		{"workflows", "idx_workflows_status_created", "status,created_at"},
+		{"workflow_steps", "idx_workflow_steps_workflow_order", `workflow_id,"order"`},
+		{"workflow_executions", "idx_workflow_executions_workflow_started", "workflow_id,started_at"},
+		{"executions", "idx_executions_workflow_created", "workflow_id,created_at"},
+		{"artifacts", "idx_artifacts_execution_created", "execution_id,created_at"},
+		{"execution_logs", "idx_execution_logs_execution_timestamp", `execution_id,"timestamp"`},
+	}
+
+	for _, idx := range indexes {
+		sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", idx.name, idx.table, idx.columns)
+		if err := tx.Exec(sql).Error; err != nil {
+			return fmt.Errorf("failed to create index %s: %w", idx.name, err)
+		}
+	}
+	return nil
+}
@@ -12,7 +12,8 @@ import (
 	"orchestrator/internal/models"
 )
 
-// Connect establishes a database connection
+// Connect establishes a database connection. Migrations are applied
+// separately by Migrate.
 func Connect(databaseURL string) (*gorm.DB, error) {
 	// Configure GORM
 	config := &gorm.Config{
@@ -30,6 +31,10 @@ func Connect(databaseURL string) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := RegisterUTCTimestamps(db); err != nil {
+		return nil, fmt.Errorf("failed to register timestamp callbacks: %w", err)
+	}
+
 	// Get underlying SQL database
 	sqlDB, err := db.DB()
 	if err != nil {
@@ -47,16 +52,11 @@ func Connect(databaseURL string) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Run migrations
-	if err := AutoMigrate(db); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
-	}
-
 	return db, nil
 }
 
-// AutoMigrate runs database migrations
-func AutoMigrate(db *gorm.DB) error {
+// migrateBaseline creates the tables of the models and their indexes
+func migrateBaseline(db *gorm.DB) error {
 	// Enable PostgreSQL extensions
 	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"").Error; err != nil {
 		return fmt.Errorf("failed to create uuid extension: %w", err)
@@ -75,14 +75,34 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.Workflow{},
 		&models.WorkflowStep{},
 		&models.WorkflowTemplate{},
+		&models.TemplateStat{},
 		&models.WorkflowExecution{},
+		&models.IntentAnalysis{},
+		&models.IntentRiskReview{},
+		&models.WorkflowCheckpoint{},
+		&models.TaskDurationSample{},
+		&models.TaskDurationStat{},
+		&models.AgentTaskOutcome{},
+		&models.AgentCapabilityFlag{},
+		&models.TaskQualityScore{},
+		&models.WorkflowAgent{},
+		&models.WorkflowComment{},
+		&models.AnalysisResult{},
 
 		// Execution models
 		&models.Execution{},
 		&models.Artifact{},
+		&models.ProjectStorageUsage{},
 		&models.Metric{},
 		&models.ExecutionLog{},
 		&models.ExecutionEvent{},
+
+		// Usage models
+		&models.UsageBucket{},
+		&models.UsageRollup{},
+
+		// Audit models
+		&models.AuditLog{},
 	}
 
 	for _, model := range models {
@@ -143,6 +163,43 @@ func createIndexes(db *gorm.DB) error {
 		}
 	}
 
+	// Covering indexes let list endpoints be answered without reading the jsonb columns
+	coveringIndexes := []struct {
+		table   string
+		name    string
+		columns string
+		include string
+	}{
+		{"workflows", "idx_workflows_summary", "project_id,created_at DESC",
+			"id,name,type,status,priority,started_at,completed_at,duration,created_by"},
+	}
+
+	for _, idx := range coveringIndexes {
+		sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s) INCLUDE (%s)", idx.name, idx.table, idx.columns, idx.include)
+		if err := db.Exec(sql).Error; err != nil {
+			return fmt.Errorf("failed to create index %s: %w", idx.name, err)
+		}
+	}
+
+	// GIN indexes back containment filters on array and jsonb columns
+	ginIndexes := []struct {
+		table  string
+		name   string
+		column string
+	}{
+		{"workflows", "idx_workflows_tags", "tags"},
+		{"workflows", "idx_workflows_labels", "labels"},
+		// The source filters match the source block of the metadata
+		{"workflows", "idx_workflows_metadata", "metadata jsonb_path_ops"},
+	}
+
+	for _, idx := range ginIndexes {
+		sql := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (%s)", idx.name, idx.table, idx.column)
+		if err := db.Exec(sql).Error; err != nil {
+			return fmt.Errorf("failed to create index %s: %w", idx.name, err)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SchemaMigration records a migration that has been applied
+type SchemaMigration struct {
+	Version   string    `gorm:"primaryKey" json:"version"`
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`
+}
+
+// TableName specifies the table name for SchemaMigration
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migration is a one-off change to the schema or existing rows
+type migration struct {
+	version string
+	apply   func(tx *gorm.DB) error
+	// postgres migrations use PostgreSQL's DDL; other databases, used in
+	// tests, skip them
+	postgres bool
+}
+
+// migrations run in order, each exactly once. Schema changes after the
+// baseline are migrations too: models are no longer migrated on every
+// start, so a new column needs an ADD COLUMN IF NOT EXISTS migration.
+var migrations = []migration{
+	{
+		// The schema AutoMigrate maintained before versioned migrations.
+		// On databases it built it only adds what is missing.
+		version:  "2026101800_baseline",
+		apply:    migrateBaseline,
+		postgres: true,
+	},
+	{
+		// Workflow durations were stored in seconds; every other duration is in milliseconds
+		version: "2026101801_workflow_duration_ms",
+		apply: func(tx *gorm.DB) error {
+			return tx.Exec("UPDATE workflows SET duration = duration * 1000 WHERE duration <> 0").Error
+		},
+	},
+	{
+		// Workflows started before runs were recorded get one run row
+		// mirroring the workflow; their earlier retries are not known
+		version: "2026101802_workflow_run_history",
+		apply: func(tx *gorm.DB) error {
+			return tx.Exec(`INSERT INTO workflow_executions (workflow_id, project_id, execution_id, reason, status,
+					input, output, error, started_at, completed_at, duration, retry_count, created_at, updated_at)
+				SELECT w.id, w.project_id, COALESCE(NULLIF(w.temporal_run_id, ''), 'backfill-' || w.id), 'backfill', w.status,
+					w.input, w.output, w.error, COALESCE(w.started_at, w.created_at), w.completed_at, w.duration,
+					w.retry_count, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+				FROM workflows w
+				WHERE w.deleted_at IS NULL AND w.status <> 'draft'
+					AND ((w.temporal_run_id IS NOT NULL AND w.temporal_run_id <> '') OR w.started_at IS NOT NULL)
+					AND NOT EXISTS (SELECT 1 FROM workflow_executions e WHERE e.workflow_id = w.id)`).Error
+		},
+	},
+	{
+		// Cancellation reasons were stored as errors of cancelled workflows,
+		// counting cancellations as failures
+		version: "2026101803_workflow_cancelled_reason",
+		apply: func(tx *gorm.DB) error {
+			return tx.Exec(`UPDATE workflows SET cancelled_reason = error, cancelled_at = COALESCE(cancelled_at, completed_at),
+					error = '', error_signature = '', error_class = ''
+				WHERE status = 'cancelled' AND error IS NOT NULL AND error <> ''
+					AND (cancelled_reason IS NULL OR cancelled_reason = '')`).Error
+		},
+	},
+	{
+		// Rows violating the constraints added below are moved here
+		version: "2026101804_quarantined_rows",
+		apply: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&QuarantinedRow{})
+		},
+		postgres: true,
+	},
+	{
+		version:  "2026101805_required_project_ids",
+		apply:    enforceRequiredColumns("2026101805_required_project_ids"),
+		postgres: true,
+	},
+	{
+		version:  "2026101806_foreign_keys",
+		apply:    addForeignKeys("2026101806_foreign_keys"),
+		postgres: true,
+	},
+	{
+		version:  "2026101807_composite_indexes",
+		apply:    createCompositeIndexes,
+		postgres: true,
+	},
+}
+
+// MigrationState is a migration and when it was applied
+type MigrationState struct {
+	Version   string     `json:"version"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"` // Nil while pending
+}
+
+// Migrate applies the migrations that have not run yet. The version row is
+// claimed before a migration runs, inside the same transaction, so
+// concurrent instances apply each migration once and a failed migration
+// leaves no trace.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to migrate schema_migrations: %w", err)
+	}
+
+	for _, migration := range migrations {
+		if migration.postgres && db.Dialector.Name() != "postgres" {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			claim := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&SchemaMigration{Version: migration.version, AppliedAt: time.Now().UTC()})
+			if claim.Error != nil {
+				return claim.Error
+			}
+			if claim.RowsAffected == 0 {
+				return nil // Already applied
+			}
+			return migration.apply(tx)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", migration.version, err)
+		}
+	}
+
+	return nil
+}
+
+// MigrationStatus returns every migration in order with when it was applied
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate schema_migrations: %w", err)
+	}
+	var applied []SchemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	appliedAt := make(map[string]time.Time, len(applied))
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.AppliedAt
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		state := MigrationState{Version: migration.version}
+		if at, ok := appliedAt[migration.version]; ok {
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// connectTestPostgres connects to a new schema of the database named by
+// ORCHESTRATOR_TEST_DATABASE_URL, e.g. the postgres service of
+// docker-compose.yml, and drops the schema after the test
+func connectTestPostgres(t *testing.T) *gorm.DB {
+	databaseURL := os.Getenv("ORCHESTRATOR_TEST_DATABASE_URL")
+	if testing.Short() || databaseURL == "" {
+		t.Skip("set ORCHESTRATOR_TEST_DATABASE_URL to run the migrations against PostgreSQL")
+	}
+
+	admin, err := Connect(databaseURL)
+	require.NoError(t, err)
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	require.NoError(t, admin.Exec("CREATE SCHEMA "+schema).Error)
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	schemaURL, err := url.Parse(databaseURL)
+	require.NoError(t, err)
+	query := schemaURL.Query()
+	query.Set("search_path", schema)
+	schemaURL.RawQuery = query.Encode()
+	db, err := Connect(schemaURL.String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// migrateUntil applies the migrations before version, as a database
+// migrated by an earlier release
+func migrateUntil(t *testing.T, db *gorm.DB, version string) {
+	all := migrations
+	defer func() { migrations = all }()
+	for i, migration := range all {
+		if migration.version == version {
+			migrations = all[:i]
+			break
+		}
+	}
+	require.NoError(t, Migrate(db))
+}
+
+func TestMigrate_PostgresConstraints(t *testing.T) {
+	db := connectTestPostgres(t)
+	migrateUntil(t, db, "2026101804_quarantined_rows")
+
+	// Rows written while AutoMigrate left the constraints out
+	project := &models.Project{Name: "orders", OwnerID: "user-1"}
+	require.NoError(t, db.Create(project).Error)
+	deleted := &models.Project{Name: "billing", OwnerID: "user-1"}
+	require.NoError(t, db.Create(deleted).Error)
+
+	newWorkflow := func(projectID string) *models.Workflow {
+		workflow := &models.Workflow{Name: "analysis", Type: models.WorkflowTypeAnalysis, ProjectID: projectID}
+		require.NoError(t, db.Create(workflow).Error)
+		return workflow
+	}
+	kept := newWorkflow(project.ID)
+	projectless := newWorkflow(project.ID)
+	require.NoError(t, db.Exec("UPDATE workflows SET project_id = NULL WHERE id = ?", projectless.ID).Error)
+	orphanedWorkflow := newWorkflow(deleted.ID)
+
+	step := &models.WorkflowStep{WorkflowID: projectless.ID, Name: "analyze", Type: "analysis", Order: 1}
+	require.NoError(t, db.Create(step).Error)
+	run := &models.WorkflowExecution{WorkflowID: kept.ID, ExecutionID: "run-1", Status: models.WorkflowStatusRunning,
+		StartedAt: time.Now()}
+	require.NoError(t, db.Omit("project_id").Create(run).Error)
+
+	// Executions without a step cannot be created through the model, whose
+	// empty step ID is no uuid
+	newExecution := func(workflowID string) string {
+		id := uuid.NewString()
+		require.NoError(t, db.Exec(`INSERT INTO executions (id, project_id, workflow_id, name, type)
+			VALUES (?, ?, ?, 'build', ?)`, id, project.ID, workflowID, models.ExecutionTypeCode).Error)
+		return id
+	}
+	keptExecution := newExecution(kept.ID)
+	gone := newWorkflow(project.ID)
+	orphanedExecution := newExecution(gone.ID)
+	require.NoError(t, db.Exec("DELETE FROM workflows WHERE id = ?", gone.ID).Error)
+	require.NoError(t, db.Exec("DELETE FROM projects WHERE id = ?", deleted.ID).Error)
+
+	require.NoError(t, Migrate(db))
+	require.NoError(t, Migrate(db), "applied migrations are not applied again")
+
+	// Violations are quarantined rather than deleted
+	var quarantined []QuarantinedRow
+	require.NoError(t, db.Order("id").Find(&quarantined).Error)
+	moved := make(map[string]string, len(quarantined))
+	for _, row := range quarantined {
+		moved[row.SourceTable+"/"+row.RowID] = row.Reason
+		assert.NotEmpty(t, row.Data)
+	}
+	assert.Equal(t, map[string]string{
+		"workflows/" + projectless.ID:      "project_id is empty",
+		"workflows/" + orphanedWorkflow.ID: "project_id references a missing projects row",
+		"workflow_steps/" + step.ID:        "workflow_id references a missing workflows row",
+		"executions/" + orphanedExecution:  "workflow_id references a missing workflows row",
+	}, moved)
+
+	var data map[string]interface{}
+	for _, row := range quarantined {
+		if row.RowID == orphanedExecution {
+			require.NoError(t, json.Unmarshal(row.Data, &data))
+		}
+	}
+	assert.Equal(t, "build", data["name"], "the row is kept whole")
+
+	var workflowIDs []string
+	require.NoError(t, db.Model(&models.Workflow{}).Order("id").Pluck("id", &workflowIDs).Error)
+	assert.Equal(t, []string{kept.ID}, workflowIDs)
+	var executionIDs []string
+	require.NoError(t, db.Model(&models.Execution{}).Pluck("id", &executionIDs).Error)
+	assert.Equal(t, []string{keptExecution}, executionIDs)
+
+	var backfilled models.WorkflowExecution
+	require.NoError(t, db.First(&backfilled, "id = ?", run.ID).Error)
+	assert.Equal(t, project.ID, backfilled.ProjectID, "runs get the project of their workflow")
+
+	// The constraints hold from now on
+	for _, fk := range foreignKeys {
+		assert.True(t, db.Migrator().HasConstraint(fk.table, fk.name()), fk.name())
+	}
+	assert.True(t, db.Migrator().HasIndex("executions", "idx_executions_workflow_created"))
+	assert.Error(t, db.Exec("UPDATE workflows SET project_id = NULL WHERE id = ?", kept.ID).Error)
+	assert.Error(t, db.Exec(`INSERT INTO executions (project_id, workflow_id, name, type) VALUES (?, ?, 'build', ?)`,
+		project.ID, uuid.NewString(), models.ExecutionTypeCode).Error)
+	assert.Error(t, db.Create(&models.Workflow{Name: "analysis", Type: models.WorkflowTypeAnalysis,
+		ProjectID: uuid.NewString()}).Error)
+
+	states, err := MigrationStatus(db)
+	require.NoError(t, err)
+	for _, state := range states {
+		assert.NotNil(t, state.AppliedAt, state.Version)
+	}
+}
+
+func TestMigrate_PostgresFreshDatabase(t *testing.T) {
+	db := connectTestPostgres(t)
+	require.NoError(t, Migrate(db))
+
+	for _, table := range []string{"projects", "workflows", "executions", "quarantined_rows"} {
+		assert.True(t, db.Migrator().HasTable(table), table)
+	}
+	for _, fk := range foreignKeys {
+		assert.True(t, db.Migrator().HasConstraint(fk.table, fk.name()), fk.name())
+	}
+	var quarantined int64
+	require.NoError(t, db.Model(&QuarantinedRow{}).Count(&quarantined).Error)
+	assert.Zero(t, quarantined)
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupMigrationTest creates the tables the data migrations change
+func setupMigrationTest(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, temporal_run_id TEXT, status TEXT DEFAULT 'completed',
+			input BLOB, output BLOB, error TEXT, error_signature TEXT, error_class TEXT, cancelled_reason TEXT,
+			cancelled_at DATETIME, started_at DATETIME, completed_at DATETIME, duration INTEGER,
+			retry_count INTEGER DEFAULT 0, created_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE workflow_executions (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), workflow_id TEXT,
+			project_id TEXT, execution_id TEXT UNIQUE, reason TEXT, previous_run_id TEXT, status TEXT, input BLOB, output BLOB,
+			events BLOB, metrics BLOB, error TEXT, started_at DATETIME, completed_at DATETIME, duration INTEGER,
+			retry_count INTEGER, resource_usage BLOB, created_at DATETIME, updated_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	return db
+}
+
+func TestMigrateData_WorkflowDurationsToMilliseconds(t *testing.T) {
+	db := setupMigrationTest(t)
+	require.NoError(t, db.Exec(`INSERT INTO workflows (id, duration) VALUES
+		('finished', 300), ('quick', 1), ('unfinished', 0), ('unset', NULL)`).Error)
+
+	durations := func() map[string]*int64 {
+		var rows []struct {
+			ID       string
+			Duration *int64
+		}
+		require.NoError(t, db.Raw(`SELECT id, duration FROM workflows`).Scan(&rows).Error)
+		result := make(map[string]*int64, len(rows))
+		for _, row := range rows {
+			result[row.ID] = row.Duration
+		}
+		return result
+	}
+	ms := func(v int64) *int64 { return &v }
+
+	require.NoError(t, Migrate(db))
+	expected := map[string]*int64{"finished": ms(300000), "quick": ms(1000), "unfinished": ms(0), "unset": nil}
+	assert.Equal(t, expected, durations())
+
+	// Applied migrations are recorded and never run twice
+	require.NoError(t, Migrate(db))
+	assert.Equal(t, expected, durations())
+
+	var applied []SchemaMigration
+	require.NoError(t, db.Order("version").Find(&applied).Error)
+	require.Len(t, applied, 3, "PostgreSQL migrations are skipped")
+	assert.Equal(t, "2026101801_workflow_duration_ms", applied[0].Version)
+	assert.False(t, applied[0].AppliedAt.IsZero())
+}
+
+func TestMigrationStatus(t *testing.T) {
+	db := setupMigrationTest(t)
+
+	states, err := MigrationStatus(db)
+	require.NoError(t, err)
+	require.Len(t, states, len(migrations))
+	for i, state := range states {
+		assert.Equal(t, migrations[i].version, state.Version, "migrations are listed in order")
+		assert.Nil(t, state.AppliedAt)
+	}
+
+	require.NoError(t, Migrate(db))
+	states, err = MigrationStatus(db)
+	require.NoError(t, err)
+	pending := map[string]bool{}
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			pending[state.Version] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{
+		"2026101800_baseline":             true,
+		"2026101804_quarantined_rows":     true,
+		"2026101805_required_project_ids": true,
+		"2026101806_foreign_keys":         true,
+		"2026101807_composite_indexes":    true,
+	}, pending, "only the PostgreSQL migrations are pending on SQLite")
+}
+
+func TestMigrateData_BackfillsWorkflowRuns(t *testing.T) {
+	db := setupMigrationTest(t)
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Exec(`INSERT INTO workflows
+		(id, project_id, temporal_run_id, status, input, error, started_at, completed_at, duration, retry_count, created_at)
+		VALUES
+		('failed', 'project-1', 'run-1', 'failed', '{"intent":"add an endpoint"}', 'activity timed out', ?, ?, 90, 2, ?),
+		('never-submitted', 'project-1', '', 'running', NULL, '', ?, NULL, 0, 0, ?),
+		('pending', 'project-1', '', 'pending', NULL, '', NULL, NULL, 0, 0, ?),
+		('draft', 'project-1', '', 'draft', NULL, '', NULL, NULL, 0, 0, ?),
+		('recorded', 'project-1', 'run-2', 'running', NULL, '', ?, NULL, 0, 0, ?)`,
+		started, started.Add(90*time.Second), started, started, started, started, started, started, started).Error)
+	require.NoError(t, db.Exec(`INSERT INTO workflow_executions (workflow_id, execution_id, reason, status)
+		VALUES ('recorded', 'run-2', 'start', 'running')`).Error)
+
+	require.NoError(t, Migrate(db))
+	require.NoError(t, Migrate(db))
+
+	var runs []struct {
+		WorkflowID  string
+		ExecutionID string
+		Reason      string
+		Status      string
+		Input       string
+		Error       string
+		StartedAt   time.Time
+		Duration    int64
+		RetryCount  int
+	}
+	require.NoError(t, db.Raw(`SELECT workflow_id, execution_id, reason, status, input, error, started_at, duration, retry_count
+		FROM workflow_executions ORDER BY workflow_id`).Scan(&runs).Error)
+	require.Len(t, runs, 3, "pending workflows and drafts never ran; recorded runs are kept")
+
+	failed := runs[0]
+	assert.Equal(t, "failed", failed.WorkflowID)
+	assert.Equal(t, "run-1", failed.ExecutionID)
+	assert.Equal(t, "backfill", failed.Reason)
+	assert.Equal(t, "failed", failed.Status)
+	assert.JSONEq(t, `{"intent":"add an endpoint"}`, failed.Input)
+	assert.Equal(t, "activity timed out", failed.Error)
+	assert.True(t, failed.StartedAt.Equal(started))
+	assert.EqualValues(t, 90000, failed.Duration, "durations are backfilled after their conversion to milliseconds")
+	assert.Equal(t, 2, failed.RetryCount)
+
+	assert.Equal(t, "backfill-never-submitted", runs[1].ExecutionID)
+	assert.Equal(t, "start", runs[2].Reason)
+}
+
+func TestMigrateData_MovesCancellationReasons(t *testing.T) {
+	db := setupMigrationTest(t)
+	completed := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cancelledAt := completed.Add(-time.Minute)
+	require.NoError(t, db.Exec(`INSERT INTO workflows
+		(id, status, error, error_signature, error_class, cancelled_reason, cancelled_at, completed_at)
+		VALUES
+		('cancelled', 'cancelled', 'User requested cancellation', 'User requested cancellation', 'unknown', NULL, NULL, ?),
+		('recorded', 'cancelled', 'stale', 'stale', '', 'superseded', ?, ?),
+		('quiet', 'cancelled', '', '', '', NULL, NULL, ?),
+		('failed', 'failed', 'activity timed out', 'activity timed out', 'timeout', NULL, NULL, ?)`,
+		completed, cancelledAt, completed, completed, completed).Error)
+
+	require.NoError(t, Migrate(db))
+
+	type row struct {
+		ID              string
+		Error           string
+		ErrorSignature  string
+		ErrorClass      string
+		CancelledReason *string
+		CancelledAt     *time.Time
+	}
+	var rows []row
+	require.NoError(t, db.Raw(`SELECT id, error, error_signature, error_class, cancelled_reason, cancelled_at
+		FROM workflows ORDER BY id`).Scan(&rows).Error)
+	byID := make(map[string]row, len(rows))
+	for _, r := range rows {
+		byID[r.ID] = r
+	}
+
+	cancelled := byID["cancelled"]
+	require.NotNil(t, cancelled.CancelledReason)
+	assert.Equal(t, "User requested cancellation", *cancelled.CancelledReason)
+	require.NotNil(t, cancelled.CancelledAt)
+	assert.True(t, cancelled.CancelledAt.Equal(completed), "cancelled when it completed")
+	assert.Empty(t, cancelled.Error, "cancellations are no longer errors")
+	assert.Empty(t, cancelled.ErrorSignature)
+	assert.Empty(t, cancelled.ErrorClass)
+
+	recorded := byID["recorded"]
+	assert.Equal(t, "superseded", *recorded.CancelledReason, "recorded reasons are kept")
+	assert.True(t, recorded.CancelledAt.Equal(cancelledAt))
+	assert.Equal(t, "stale", recorded.Error)
+
+	assert.Nil(t, byID["quiet"].CancelledReason, "no reason to move")
+
+	failed := byID["failed"]
+	assert.Equal(t, "activity timed out", failed.Error, "failures are left alone")
+	assert.Nil(t, failed.CancelledReason)
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// RegisterUTCTimestamps registers callbacks that convert the timestamps of
+// written and loaded models to UTC, so they are stored and serialized as
+// UTC RFC3339 regardless of the server's local time zone
+func RegisterUTCTimestamps(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("orchestrator:utc_timestamps", utcTimestamps); err != nil {
+		return fmt.Errorf("failed to register create callback: %w", err)
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("orchestrator:utc_timestamps", utcTimestamps); err != nil {
+		return fmt.Errorf("failed to register update callback: %w", err)
+	}
+	if err := callbacks.Query().After("gorm:query").Register("orchestrator:utc_timestamps", utcTimestamps); err != nil {
+		return fmt.Errorf("failed to register query callback: %w", err)
+	}
+	return nil
+}
+
+func utcTimestamps(db *gorm.DB) {
+	if db.Error != nil || db.Statement == nil {
+		return
+	}
+	if db.Statement.ReflectValue.IsValid() {
+		toUTC(db.Statement.ReflectValue)
+	}
+	// Update("column", value) and Updates(map) carry their values in Dest
+	if values, ok := db.Statement.Dest.(map[string]interface{}); ok {
+		for column, value := range values {
+			switch t := value.(type) {
+			case time.Time:
+				values[column] = t.UTC()
+			case *time.Time:
+				if t != nil {
+					values[column] = t.UTC()
+				}
+			}
+		}
+	}
+}
+
+// toUTC converts the time.Time and *time.Time fields of a struct, or of
+// each struct in a slice, to UTC in place. Embedded structs are included,
+// associations are left to their own callbacks.
+func toUTC(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !value.IsNil() {
+			toUTC(value.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		switch value.Type().Elem().Kind() {
+		case reflect.Struct, reflect.Ptr, reflect.Interface:
+		default:
+			return
+		}
+		for i := 0; i < value.Len(); i++ {
+			toUTC(value.Index(i))
+		}
+	case reflect.Struct:
+		if value.Type() == timeType {
+			if value.CanSet() {
+				value.Set(reflect.ValueOf(value.Interface().(time.Time).UTC()))
+			}
+			return
+		}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if field.Type == timeType || field.Type == reflect.PointerTo(timeType) || field.Anonymous {
+				toUTC(value.Field(i))
+			}
+		}
+	}
+}
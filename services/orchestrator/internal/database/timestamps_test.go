@@ -0,0 +1,65 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type AuditedTimes struct {
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type timestamped struct {
+	ID          string     `gorm:"primaryKey" json:"id"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	AuditedTimes
+}
+
+func TestRegisterUTCTimestamps(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, RegisterUTCTimestamps(db))
+	require.NoError(t, db.AutoMigrate(&timestamped{}))
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	started := time.Date(2024, 3, 1, 21, 0, 0, 0, tokyo)
+	completed := started.Add(90 * time.Second)
+	row := &timestamped{ID: "row-1", StartedAt: started, CompletedAt: &completed}
+	row.CreatedAt = started
+	require.NoError(t, db.Create(row).Error)
+
+	assert.Equal(t, time.UTC, row.StartedAt.Location(), "written models are converted in place")
+	assert.Equal(t, time.UTC, row.CompletedAt.Location())
+	assert.Equal(t, time.UTC, row.CreatedAt.Location(), "embedded structs are converted")
+	assert.True(t, row.StartedAt.Equal(started))
+
+	later := time.Date(2024, 3, 2, 9, 30, 0, 0, tokyo)
+	require.NoError(t, db.Model(row).Update("completed_at", later).Error)
+
+	var stored timestamped
+	require.NoError(t, db.First(&stored, "id = ?", "row-1").Error)
+	assert.Equal(t, time.UTC, stored.StartedAt.Location())
+	assert.True(t, stored.CompletedAt.Equal(later))
+
+	var raw string
+	require.NoError(t, db.Raw(`SELECT completed_at FROM timestampeds`).Row().Scan(&raw))
+	assert.Contains(t, raw, "2024-03-02", "values are stored in UTC")
+	assert.NotContains(t, raw, "+09:00")
+
+	data, err := json.Marshal(stored)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id": "row-1",
+		"started_at": "2024-03-01T12:00:00Z",
+		"completed_at": "2024-03-02T00:30:00Z",
+		"created_at": "2024-03-01T12:00:00Z"
+	}`, string(data))
+}
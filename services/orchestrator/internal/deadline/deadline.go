@@ -0,0 +1,75 @@
+// Package deadline carries the deadline of an API request into the work done
+// for it, so calls to other services get only the time the client is still
+// waiting for, and calls nobody would wait for are not made.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Header is the HTTP header carrying a request's deadline: an RFC 3339 time,
+// or the milliseconds left as an integer. Calls to other services pass it on
+// as an RFC 3339 time.
+const Header = "X-Request-Deadline"
+
+// ErrExhausted is returned when a request's deadline has passed before work
+// for it started
+var ErrExhausted = errors.New("request deadline exhausted")
+
+// Parse parses a deadline header value, relative to now when it is a number
+// of milliseconds
+func Parse(value string, now time.Time) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if ms < 0 {
+			return time.Time{}, fmt.Errorf("invalid %s %q: milliseconds must not be negative", Header, value)
+		}
+		return now.Add(time.Duration(ms) * time.Millisecond), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: not an RFC 3339 time or a number of milliseconds", Header, value)
+	}
+	return t, nil
+}
+
+// Remaining returns the time left before ctx's deadline, and false when ctx
+// has none
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(d), true
+}
+
+// Check returns an error wrapping ErrExhausted when ctx's deadline has passed
+// or ctx is done, before an expensive call is made for it. Contexts without a
+// deadline always pass.
+func Check(ctx context.Context, call string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w before %s: %v", ErrExhausted, call, err)
+	}
+	if remaining, ok := Remaining(ctx); ok && remaining <= 0 {
+		return fmt.Errorf("%w before %s", ErrExhausted, call)
+	}
+	return nil
+}
+
+// Exhausted reports whether err was caused by the deadline of the request:
+// one refused by Check, or a call that ran out of time
+func Exhausted(err error) bool {
+	return errors.Is(err, ErrExhausted) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// SetHeader passes ctx's deadline on in header, leaving header as is when ctx
+// has none
+func SetHeader(ctx context.Context, header http.Header) {
+	if d, ok := ctx.Deadline(); ok {
+		header.Set(Header, d.UTC().Format(time.RFC3339Nano))
+	}
+}
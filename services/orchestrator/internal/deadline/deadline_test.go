@@ -0,0 +1,65 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	d, err := Parse("10000", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(10*time.Second), d, "numbers are the milliseconds left")
+
+	d, err = Parse("2026-10-18T12:00:02.5Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(2500*time.Millisecond), d)
+
+	for _, value := range []string{"-1", "10s", "tomorrow"} {
+		_, err := Parse(value, now)
+		assert.ErrorContains(t, err, "invalid X-Request-Deadline", value)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	assert.NoError(t, Check(context.Background(), "listing agents"), "contexts without a deadline always pass")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.NoError(t, Check(ctx, "listing agents"))
+	remaining, ok := Remaining(ctx)
+	require.True(t, ok)
+	assert.InDelta(t, time.Minute, remaining, float64(time.Second))
+	_, ok = Remaining(context.Background())
+	assert.False(t, ok)
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	err := Check(expired, "listing agents")
+	assert.ErrorIs(t, err, ErrExhausted)
+	assert.ErrorContains(t, err, "request deadline exhausted before listing agents")
+	assert.True(t, Exhausted(err))
+	assert.True(t, Exhausted(expired.Err()), "calls running out of time exhausted the deadline too")
+	assert.False(t, Exhausted(context.Canceled))
+}
+
+func TestSetHeader(t *testing.T) {
+	header := http.Header{}
+	SetHeader(context.Background(), header)
+	assert.Empty(t, header.Get(Header))
+
+	d := time.Date(2026, 10, 18, 12, 0, 2, 500000000, time.FixedZone("CEST", 2*60*60))
+	ctx, cancel := context.WithDeadline(context.Background(), d)
+	defer cancel()
+	SetHeader(ctx, header)
+	assert.Equal(t, "2026-10-18T10:00:02.5Z", header.Get(Header))
+	parsed, err := Parse(header.Get(Header), time.Now())
+	require.NoError(t, err)
+	assert.True(t, parsed.Equal(d))
+}
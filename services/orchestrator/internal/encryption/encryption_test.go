@@ -0,0 +1,281 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+)
+
+// sealedRecord mirrors the shape of the workflow and execution models: a
+// project ID, a jsonb payload and a text column marked for encryption.
+type sealedRecord struct {
+	ID        uint `gorm:"primaryKey"`
+	ProjectID string
+	Payload   json.RawMessage `gorm:"encrypted"`
+	Code      string          `gorm:"type:text;encrypted"`
+	Note      string
+}
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func testEncryptor(t *testing.T, current string, keys map[string][]byte) *Encryptor {
+	provider, err := NewLocalKeyProvider(current, keys)
+	require.NoError(t, err)
+	return NewEncryptor(provider)
+}
+
+// setupEncryptedDB creates an sqlite database with a minimal projects table
+// and the sealed record table, with the encryption callbacks registered.
+func setupEncryptedDB(t *testing.T, encryptor *Encryptor) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, settings TEXT, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.AutoMigrate(&sealedRecord{}))
+	require.NoError(t, Register(db, encryptor, NewSettingsPolicy(0)))
+	return db
+}
+
+func setProjectEncryption(t *testing.T, db *gorm.DB, projectID string, enabled bool) {
+	settings, err := json.Marshal(models.ProjectSettings{EncryptAtRest: enabled})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`INSERT INTO projects (id, settings) VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET settings = excluded.settings`, projectID, string(settings)).Error)
+}
+
+// rawRecord reads the stored column values, bypassing decryption
+func rawRecord(t *testing.T, db *gorm.DB, id uint) (payload, code string) {
+	row := db.Raw(`SELECT payload, code FROM sealed_records WHERE id = ?`, id).Row()
+	require.NoError(t, row.Scan(&payload, &code))
+	return payload, code
+}
+
+func TestEncryptor_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	encryptor := testEncryptor(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	plaintext := []byte(`{"code": "print('secret')"}`)
+	sealed, err := encryptor.Encrypt(ctx, plaintext)
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+	assert.NotContains(t, string(sealed), "secret")
+	assert.True(t, json.Valid(sealed), "envelopes must fit jsonb columns")
+
+	opened, err := encryptor.Decrypt(ctx, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Plaintext passes through unchanged
+	opened, err = encryptor.Decrypt(ctx, plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Tampering is detected
+	var envelope Envelope
+	require.NoError(t, json.Unmarshal(sealed, &envelope))
+	envelope.Ciphertext[0] ^= 0xff
+	tampered, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	_, err = encryptor.Decrypt(ctx, tampered)
+	assert.Error(t, err)
+
+	// A different master key cannot unwrap the data key
+	other := testEncryptor(t, "k1", map[string][]byte{"k1": testKey(2)})
+	_, err = other.Decrypt(ctx, sealed)
+	assert.Error(t, err)
+}
+
+func TestRegister_StoresCiphertextForEncryptedProjects(t *testing.T) {
+	db := setupEncryptedDB(t, testEncryptor(t, "k1", map[string][]byte{"k1": testKey(1)}))
+	setProjectEncryption(t, db, "sensitive", true)
+	setProjectEncryption(t, db, "public", false)
+
+	sensitive := &sealedRecord{ProjectID: "sensitive", Payload: json.RawMessage(`{"secret":true}`), Code: "print('secret')", Note: "visible"}
+	public := &sealedRecord{ProjectID: "public", Payload: json.RawMessage(`{"secret":false}`), Code: "print('hello')"}
+	require.NoError(t, db.Create(sensitive).Error)
+	require.NoError(t, db.Create(public).Error)
+
+	// Callers keep their plaintext after the write
+	assert.JSONEq(t, `{"secret":true}`, string(sensitive.Payload))
+	assert.Equal(t, "print('secret')", sensitive.Code)
+
+	// The database only holds ciphertext for the encrypted project
+	payload, code := rawRecord(t, db, sensitive.ID)
+	assert.True(t, IsEncrypted([]byte(payload)))
+	assert.True(t, IsEncrypted([]byte(code)))
+	assert.NotContains(t, code, "secret")
+
+	payload, code = rawRecord(t, db, public.ID)
+	assert.JSONEq(t, `{"secret":false}`, payload)
+	assert.Equal(t, "print('hello')", code)
+
+	// Reads are transparently decrypted
+	var records []sealedRecord
+	require.NoError(t, db.Order("id").Find(&records).Error)
+	require.Len(t, records, 2)
+	assert.JSONEq(t, `{"secret":true}`, string(records[0].Payload))
+	assert.Equal(t, "print('secret')", records[0].Code)
+	assert.Equal(t, "visible", records[0].Note)
+	assert.Equal(t, "print('hello')", records[1].Code)
+}
+
+func TestRegister_FlagTogglesMidLifecycle(t *testing.T) {
+	db := setupEncryptedDB(t, testEncryptor(t, "k1", map[string][]byte{"k1": testKey(1)}))
+	setProjectEncryption(t, db, "project", false)
+
+	record := &sealedRecord{ProjectID: "project", Payload: json.RawMessage(`{"step":1}`), Code: "v1"}
+	require.NoError(t, db.Create(record).Error)
+	payload, _ := rawRecord(t, db, record.ID)
+	assert.False(t, IsEncrypted([]byte(payload)))
+
+	// Enabling the flag encrypts the row on its next write
+	setProjectEncryption(t, db, "project", true)
+	var loaded sealedRecord
+	require.NoError(t, db.First(&loaded, record.ID).Error)
+	loaded.Payload = json.RawMessage(`{"step":2}`)
+	require.NoError(t, db.Save(&loaded).Error)
+
+	payload, code := rawRecord(t, db, record.ID)
+	assert.True(t, IsEncrypted([]byte(payload)))
+	assert.True(t, IsEncrypted([]byte(code)))
+
+	// Disabling it leaves existing ciphertext readable...
+	setProjectEncryption(t, db, "project", false)
+	loaded = sealedRecord{}
+	require.NoError(t, db.First(&loaded, record.ID).Error)
+	assert.JSONEq(t, `{"step":2}`, string(loaded.Payload))
+	assert.Equal(t, "v1", loaded.Code)
+
+	// ...and the next write stores plaintext again
+	require.NoError(t, db.Save(&loaded).Error)
+	payload, code = rawRecord(t, db, record.ID)
+	assert.JSONEq(t, `{"step":2}`, payload)
+	assert.Equal(t, "v1", code)
+
+	// Column updates that don't touch encrypted fields leave them alone
+	require.NoError(t, db.Model(&loaded).Update("note", "updated").Error)
+	payload, _ = rawRecord(t, db, record.ID)
+	assert.JSONEq(t, `{"step":2}`, payload)
+}
+
+func TestRegister_RefusesPlaintextMapUpdates(t *testing.T) {
+	encryptor := testEncryptor(t, "k1", map[string][]byte{"k1": testKey(1)})
+	db := setupEncryptedDB(t, encryptor)
+	setProjectEncryption(t, db, "sensitive", true)
+
+	record := &sealedRecord{ProjectID: "sensitive", Payload: json.RawMessage(`{"step":1}`), Code: "v1"}
+	require.NoError(t, db.Create(record).Error)
+
+	// Map updates bypass sealing, so plaintext for an encrypted column is an error...
+	err := db.Model(record).Update("payload", json.RawMessage(`{"step":2}`)).Error
+	assert.ErrorIs(t, err, ErrPlaintextUpdate)
+	err = db.Model(record).Updates(map[string]interface{}{"note": "updated", "Code": "v2"}).Error
+	assert.ErrorIs(t, err, ErrPlaintextUpdate)
+	assert.Contains(t, err.Error(), "sealed_records.code")
+	payload, code := rawRecord(t, db, record.ID)
+	assert.True(t, IsEncrypted([]byte(payload)))
+	assert.True(t, IsEncrypted([]byte(code)))
+
+	// ...while ciphertext and other columns pass
+	sealed, err := encryptor.Encrypt(context.Background(), []byte("v3"))
+	require.NoError(t, err)
+	require.NoError(t, db.Model(record).Updates(map[string]interface{}{"note": "updated", "code": string(sealed)}).Error)
+	var loaded sealedRecord
+	require.NoError(t, db.First(&loaded, record.ID).Error)
+	assert.Equal(t, "v3", loaded.Code)
+	assert.Equal(t, "updated", loaded.Note)
+}
+
+func TestRewrap_RotatesMasterKey(t *testing.T) {
+	ctx := context.Background()
+	oldEncryptor := testEncryptor(t, "k1", map[string][]byte{"k1": testKey(1)})
+	db := setupEncryptedDB(t, oldEncryptor)
+	setProjectEncryption(t, db, "project", true)
+
+	for i := 0; i < 5; i++ {
+		record := &sealedRecord{ProjectID: "project", Payload: json.RawMessage(`{"n":1}`), Code: "secret"}
+		require.NoError(t, db.Create(record).Error)
+	}
+	plain := &sealedRecord{ProjectID: "other", Payload: json.RawMessage(`{"n":2}`)}
+	require.NoError(t, db.Create(plain).Error)
+
+	// Rotate: k2 becomes current, k1 is kept only for unwrapping
+	rotated := testEncryptor(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	result, err := Rewrap(ctx, db, rotated, 2, &sealedRecord{})
+	require.NoError(t, err)
+	assert.Equal(t, RewrapResult{Scanned: 6, Rewrapped: 5}, result)
+
+	// Every envelope now references k2 and opens without k1
+	newOnly := testEncryptor(t, "k2", map[string][]byte{"k2": testKey(2)})
+	var payloads []string
+	require.NoError(t, db.Raw(`SELECT payload FROM sealed_records WHERE project_id = ?`, "project").Scan(&payloads).Error)
+	require.Len(t, payloads, 5)
+	for _, payload := range payloads {
+		envelope, ok := parseEnvelope([]byte(payload))
+		require.True(t, ok)
+		assert.Equal(t, "k2", envelope.KeyID)
+
+		opened, err := newOnly.Decrypt(ctx, []byte(payload))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"n":1}`, string(opened))
+	}
+
+	// A second run has nothing left to do
+	result, err = Rewrap(ctx, db, rotated, 2, &sealedRecord{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Rewrapped)
+}
+
+func TestSettingsPolicy_CachesFlag(t *testing.T) {
+	ctx := context.Background()
+	db := setupEncryptedDB(t, testEncryptor(t, "k1", map[string][]byte{"k1": testKey(1)}))
+	setProjectEncryption(t, db, "project", true)
+
+	policy := NewSettingsPolicy(time.Minute)
+	enabled, err := policy.EncryptionEnabled(ctx, db, "project")
+	require.NoError(t, err)
+	assert.True(t, enabled)
+
+	setProjectEncryption(t, db, "project", false)
+	enabled, _ = policy.EncryptionEnabled(ctx, db, "project")
+	assert.True(t, enabled, "cached until the TTL expires")
+
+	policy.Invalidate("project")
+	enabled, _ = policy.EncryptionEnabled(ctx, db, "project")
+	assert.False(t, enabled)
+
+	enabled, err = policy.EncryptionEnabled(ctx, db, "missing")
+	require.NoError(t, err)
+	assert.False(t, enabled)
+}
+
+func TestEncryptedFields_SensitiveColumns(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+
+	names := func(model interface{}) []string {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(model))
+		var result []string
+		for _, field := range EncryptedFields(stmt.Schema) {
+			result = append(result, field.DBName)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"input", "output"}, names(&models.Workflow{}))
+	assert.Equal(t, []string{"code", "output"}, names(&models.Execution{}))
+	assert.Equal(t, []string{"content_head"}, names(&models.Artifact{}))
+}
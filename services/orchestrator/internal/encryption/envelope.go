@@ -0,0 +1,162 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// envelopeVersion identifies the envelope format and cipher
+const envelopeVersion = "aes-256-gcm/v1"
+
+// dataKeySize is the size of the per-value AES-256 data key
+const dataKeySize = 32
+
+// ErrUnknownKey is returned when an envelope references a master key the
+// provider does not hold
+var ErrUnknownKey = errors.New("unknown master key")
+
+// Envelope is the stored form of an encrypted value. It is valid JSON so it
+// fits the existing jsonb columns; []byte fields are base64 encoded.
+type Envelope struct {
+	Version    string `json:"__enc"`
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"dk"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ct"`
+}
+
+// Encryptor performs envelope encryption: every value is sealed with a fresh
+// AES-GCM data key, and the data key is wrapped by the provider's master key.
+type Encryptor struct {
+	keys KeyProvider
+}
+
+// NewEncryptor creates an encryptor backed by the given key provider
+func NewEncryptor(keys KeyProvider) *Encryptor {
+	return &Encryptor{keys: keys}
+}
+
+// CurrentKeyID returns the ID of the master key used for new values
+func (e *Encryptor) CurrentKeyID() string {
+	return e.keys.CurrentKeyID()
+}
+
+// Encrypt seals plaintext and returns the JSON-encoded envelope
+func (e *Encryptor) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	keyID, wrapped, err := e.keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return json.Marshal(Envelope{
+		Version:    envelopeVersion,
+		KeyID:      keyID,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, []byte(envelopeVersion)),
+	})
+}
+
+// Decrypt opens an envelope produced by Encrypt. Values that are not
+// envelopes are returned unchanged, so rows written before encryption was
+// enabled stay readable.
+func (e *Encryptor) Decrypt(ctx context.Context, data []byte) ([]byte, error) {
+	envelope, ok := parseEnvelope(data)
+	if !ok {
+		return data, nil
+	}
+
+	dataKey, err := e.keys.UnwrapKey(ctx, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, []byte(envelopeVersion))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Rewrap re-wraps the data key of an envelope under the current master key.
+// The ciphertext is unchanged. It reports false when data is not an envelope
+// or is already wrapped by the current key.
+func (e *Encryptor) Rewrap(ctx context.Context, data []byte) ([]byte, bool, error) {
+	envelope, ok := parseEnvelope(data)
+	if !ok || envelope.KeyID == e.keys.CurrentKeyID() {
+		return data, false, nil
+	}
+
+	dataKey, err := e.keys.UnwrapKey(ctx, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	envelope.KeyID, envelope.WrappedKey, err = e.keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	rewrapped, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, false, err
+	}
+	return rewrapped, true, nil
+}
+
+// IsEncrypted reports whether data is an encryption envelope
+func IsEncrypted(data []byte) bool {
+	_, ok := parseEnvelope(data)
+	return ok
+}
+
+// parseEnvelope decodes data as an envelope. The cheap marker check keeps
+// ordinary JSON payloads from being fully parsed on every read.
+func parseEnvelope(data []byte) (*Envelope, bool) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' || !bytes.Contains(trimmed, []byte(`"__enc"`)) {
+		return nil, false
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(trimmed, &envelope); err != nil || envelope.Version != envelopeVersion {
+		return nil, false
+	}
+	return &envelope, true
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,337 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"orchestrator/internal/models"
+)
+
+// encryptedTag marks a model field for encryption at rest, e.g.
+// `gorm:"type:jsonb;encrypted"`. Supported field types are []byte-based
+// (json.RawMessage) and string.
+const encryptedTag = "ENCRYPTED"
+
+const restoreKey = "encryption:restore"
+
+// ErrPlaintextUpdate is returned when a map update, such as
+// Update("output", value), writes plaintext to an encrypted column. Only
+// models are sealed, so such writes set the field and select the column:
+// Model(x).Select("output").Updates(x).
+var ErrPlaintextUpdate = errors.New("plaintext update of an encrypted column")
+
+// ProjectPolicy reports whether a project requires encryption at rest
+type ProjectPolicy interface {
+	EncryptionEnabled(ctx context.Context, db *gorm.DB, projectID string) (bool, error)
+}
+
+// SettingsPolicy reads the encrypt_at_rest flag from project settings and
+// caches the result for ttl. A zero ttl disables caching.
+type SettingsPolicy struct {
+	ttl   time.Duration
+	mu    sync.Mutex
+	cache map[string]cachedFlag
+}
+
+type cachedFlag struct {
+	enabled bool
+	expires time.Time
+}
+
+// NewSettingsPolicy creates a policy backed by the projects table
+func NewSettingsPolicy(ttl time.Duration) *SettingsPolicy {
+	return &SettingsPolicy{ttl: ttl, cache: make(map[string]cachedFlag)}
+}
+
+// EncryptionEnabled implements ProjectPolicy. Unknown projects are not encrypted.
+func (p *SettingsPolicy) EncryptionEnabled(ctx context.Context, db *gorm.DB, projectID string) (bool, error) {
+	if projectID == "" {
+		return false, nil
+	}
+
+	p.mu.Lock()
+	cached, ok := p.cache[projectID]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.enabled, nil
+	}
+
+	var settings []string
+	err := db.Session(&gorm.Session{NewDB: true, Context: ctx}).
+		Model(&models.Project{}).Where("id = ?", projectID).Limit(1).Pluck("settings", &settings).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load project settings: %w", err)
+	}
+
+	enabled := false
+	if len(settings) > 0 {
+		parsed, err := models.ParseProjectSettings([]byte(settings[0]))
+		if err != nil {
+			return false, err
+		}
+		enabled = parsed.EncryptAtRest
+	}
+
+	if p.ttl > 0 {
+		p.mu.Lock()
+		p.cache[projectID] = cachedFlag{enabled: enabled, expires: time.Now().Add(p.ttl)}
+		p.mu.Unlock()
+	}
+	return enabled, nil
+}
+
+// Invalidate drops the cached flag for a project, e.g. after its settings change
+func (p *SettingsPolicy) Invalidate(projectID string) {
+	p.mu.Lock()
+	delete(p.cache, projectID)
+	p.mu.Unlock()
+}
+
+// Register installs GORM callbacks that encrypt fields tagged `encrypted`
+// before they are written for projects with encryption at rest enabled, and
+// transparently decrypt them after queries. Written models get their
+// plaintext back once the statement completes, so callers never see
+// ciphertext.
+func Register(db *gorm.DB, encryptor *Encryptor, policy ProjectPolicy) error {
+	h := &callbacks{encryptor: encryptor, policy: policy}
+
+	if err := db.Callback().Create().Before("gorm:create").Register("encryption:seal_create", h.seal); err != nil {
+		return fmt.Errorf("failed to register create callback: %w", err)
+	}
+	if err := db.Callback().Create().After("gorm:create").Register("encryption:restore_create", h.restore); err != nil {
+		return fmt.Errorf("failed to register create callback: %w", err)
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("encryption:seal_update", h.seal); err != nil {
+		return fmt.Errorf("failed to register update callback: %w", err)
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("encryption:restore_update", h.restore); err != nil {
+		return fmt.Errorf("failed to register update callback: %w", err)
+	}
+	if err := db.Callback().Query().After("gorm:query").Register("encryption:open", h.open); err != nil {
+		return fmt.Errorf("failed to register query callback: %w", err)
+	}
+	return nil
+}
+
+type callbacks struct {
+	encryptor *Encryptor
+	policy    ProjectPolicy
+}
+
+// seal encrypts tagged fields of the models being written
+func (h *callbacks) seal(tx *gorm.DB) {
+	stmt := tx.Statement
+	fields := EncryptedFields(stmt.Schema)
+	if tx.Error != nil || len(fields) == 0 {
+		return
+	}
+	if isMapDest(stmt.Dest) {
+		if column := plaintextColumn(stmt.Dest, fields); column != "" {
+			tx.AddError(fmt.Errorf("%w: %s.%s", ErrPlaintextUpdate, stmt.Schema.Table, column))
+		}
+		return
+	}
+	projectField := stmt.Schema.LookUpField("ProjectID")
+	executionField := stmt.Schema.LookUpField("ExecutionID")
+	if projectField == nil && executionField == nil {
+		return
+	}
+
+	ctx := stmt.Context
+	var restores []func()
+	executionProjects := make(map[string]string)
+	eachModel(stmt, func(model reflect.Value) {
+		var id string
+		if projectField != nil {
+			projectID, _ := projectField.ValueOf(ctx, model)
+			id, _ = projectID.(string)
+		} else {
+			// Models without a project, such as artifacts, belong to the
+			// project of their execution
+			executionID, _ := executionField.ValueOf(ctx, model)
+			var err error
+			if id, err = executionProject(tx, executionProjects, executionID); err != nil {
+				tx.AddError(err)
+				return
+			}
+		}
+		enabled, err := h.policy.EncryptionEnabled(ctx, tx, id)
+		if err != nil {
+			tx.AddError(err)
+			return
+		}
+		if !enabled {
+			return
+		}
+
+		for _, field := range fields {
+			value := field.ReflectValueOf(ctx, model)
+			plaintext := fieldBytes(value)
+			if len(plaintext) == 0 || IsEncrypted(plaintext) {
+				continue
+			}
+
+			sealed, err := h.encryptor.Encrypt(ctx, plaintext)
+			if err != nil {
+				tx.AddError(fmt.Errorf("failed to encrypt %s.%s: %w", stmt.Schema.Table, field.DBName, err))
+				return
+			}
+
+			original := reflect.ValueOf(value.Interface())
+			setFieldBytes(value, sealed)
+			restores = append(restores, func() { value.Set(original) })
+		}
+	})
+
+	if len(restores) > 0 {
+		tx.InstanceSet(restoreKey, restores)
+	}
+}
+
+// executionProject returns the project of an execution, cached in projects
+// for the statement. Unknown executions belong to no project.
+func executionProject(tx *gorm.DB, projects map[string]string, executionID interface{}) (string, error) {
+	id, _ := executionID.(string)
+	if id == "" {
+		return "", nil
+	}
+	if projectID, ok := projects[id]; ok {
+		return projectID, nil
+	}
+
+	var projectIDs []string
+	err := tx.Session(&gorm.Session{NewDB: true, Context: tx.Statement.Context}).
+		Table("executions").Where("id = ?", id).Limit(1).Pluck("project_id", &projectIDs).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to load execution project: %w", err)
+	}
+	projects[id] = ""
+	if len(projectIDs) > 0 {
+		projects[id] = projectIDs[0]
+	}
+	return projects[id], nil
+}
+
+// restore puts the plaintext back on written models
+func (h *callbacks) restore(tx *gorm.DB) {
+	if restores, ok := tx.InstanceGet(restoreKey); ok {
+		for _, restore := range restores.([]func()) {
+			restore()
+		}
+	}
+}
+
+// open decrypts tagged fields of queried models
+func (h *callbacks) open(tx *gorm.DB) {
+	stmt := tx.Statement
+	fields := EncryptedFields(stmt.Schema)
+	if tx.Error != nil || len(fields) == 0 {
+		return
+	}
+
+	ctx := stmt.Context
+	eachModel(stmt, func(model reflect.Value) {
+		for _, field := range fields {
+			value := field.ReflectValueOf(ctx, model)
+			data := fieldBytes(value)
+			if !IsEncrypted(data) {
+				continue
+			}
+
+			plaintext, err := h.encryptor.Decrypt(ctx, data)
+			if err != nil {
+				tx.AddError(fmt.Errorf("failed to decrypt %s.%s: %w", stmt.Schema.Table, field.DBName, err))
+				return
+			}
+			setFieldBytes(value, plaintext)
+		}
+	})
+}
+
+// EncryptedFields returns the fields of a schema tagged for encryption
+func EncryptedFields(s *schema.Schema) []*schema.Field {
+	if s == nil {
+		return nil
+	}
+	var fields []*schema.Field
+	for _, field := range s.Fields {
+		if _, ok := field.TagSettings[encryptedTag]; ok {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// eachModel calls fn for every addressable model of the statement's schema
+// type. Destinations of another type, such as summary structs, are skipped.
+func eachModel(stmt *gorm.Statement, fn func(model reflect.Value)) {
+	rv := stmt.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			model := reflect.Indirect(rv.Index(i))
+			if model.Type() == stmt.Schema.ModelType && model.CanAddr() {
+				fn(model)
+			}
+		}
+	case reflect.Struct:
+		if rv.Type() == stmt.Schema.ModelType && rv.CanAddr() {
+			fn(rv)
+		}
+	}
+}
+
+func isMapDest(dest interface{}) bool {
+	return reflect.Indirect(reflect.ValueOf(dest)).Kind() == reflect.Map
+}
+
+// plaintextColumn returns the first encrypted column a map update sets to
+// plaintext. Ciphertext, as written by Rewrap, passes.
+func plaintextColumn(dest interface{}, fields []*schema.Field) string {
+	updates := reflect.Indirect(reflect.ValueOf(dest))
+	keyType := updates.Type().Key()
+	if keyType.Kind() != reflect.String {
+		return ""
+	}
+	for _, field := range fields {
+		for _, key := range []string{field.DBName, field.Name} {
+			value := updates.MapIndex(reflect.ValueOf(key).Convert(keyType))
+			if !value.IsValid() {
+				continue
+			}
+			data := fieldBytes(reflect.Indirect(reflect.ValueOf(value.Interface())))
+			if len(data) > 0 && !IsEncrypted(data) {
+				return field.DBName
+			}
+		}
+	}
+	return ""
+}
+
+func fieldBytes(value reflect.Value) []byte {
+	switch value.Kind() {
+	case reflect.String:
+		return []byte(value.String())
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return value.Bytes()
+		}
+	}
+	return nil
+}
+
+func setFieldBytes(value reflect.Value, data []byte) {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(string(data))
+	case reflect.Slice:
+		value.SetBytes(data)
+	}
+}
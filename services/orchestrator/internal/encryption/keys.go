@@ -0,0 +1,100 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// KeyProvider wraps and unwraps data keys with a master key. LocalKeyProvider
+// keeps master keys in memory; a KMS-backed provider can replace it without
+// changing callers.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the master key used to wrap new data keys
+	CurrentKeyID() string
+	// WrapKey wraps a data key with the current master key
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey unwraps a data key with the named master key
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider wraps data keys with AES-GCM master keys held in memory.
+// Retired keys are kept for unwrapping until every value has been rewrapped.
+type LocalKeyProvider struct {
+	currentKeyID string
+	keys         map[string]cipher.AEAD
+}
+
+// NewLocalKeyProvider creates a provider from raw 32-byte master keys keyed by
+// ID. currentKeyID selects the key used for new values.
+func NewLocalKeyProvider(currentKeyID string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("current master key %q is not configured", currentKeyID)
+	}
+
+	provider := &LocalKeyProvider{
+		currentKeyID: currentKeyID,
+		keys:         make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %q must be 32 bytes, got %d", id, len(key))
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		provider.keys[id] = gcm
+	}
+	return provider, nil
+}
+
+// NewLocalKeyProviderFromBase64 is NewLocalKeyProvider for base64-encoded keys,
+// as they appear in configuration
+func NewLocalKeyProviderFromBase64(currentKeyID string, encodedKeys map[string]string) (*LocalKeyProvider, error) {
+	keys := make(map[string][]byte, len(encodedKeys))
+	for id, encoded := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q is not valid base64: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewLocalKeyProvider(currentKeyID, keys)
+}
+
+// CurrentKeyID implements KeyProvider
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	return p.currentKeyID
+}
+
+// WrapKey implements KeyProvider. The key ID is bound as additional data so a
+// wrapped key cannot be replayed under a different ID.
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	gcm := p.keys[p.currentKeyID]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return p.currentKeyID, gcm.Seal(nonce, nonce, dataKey, []byte(p.currentKeyID)), nil
+}
+
+// UnwrapKey implements KeyProvider
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	gcm, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+
+	nonce, sealed := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	dataKey, err := gcm.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key with %s: %w", keyID, err)
+	}
+	return dataKey, nil
+}
@@ -0,0 +1,70 @@
+// Package errorclass classifies error messages by the kind of failure they
+// describe, so failures reported by different agents, in different words and
+// languages, can be handled and grouped alike.
+package errorclass
+
+import "regexp"
+
+// Class is the kind of failure an error message describes
+type Class string
+
+const (
+	// Timeout is an operation that did not finish in time
+	Timeout Class = "timeout"
+	// Auth is a caller that could not be authenticated or lacks permission
+	Auth Class = "auth"
+	// Quota is a rate limit, quota or budget that was exhausted
+	Quota Class = "quota"
+	// Validation is input that was rejected as invalid
+	Validation Class = "validation"
+	// Crash is a process that panicked, was killed or raised an unhandled
+	// exception
+	Crash Class = "crash"
+	// Unknown is a failure matching none of the other classes
+	Unknown Class = "unknown"
+)
+
+// rule assigns its class to messages matching its pattern
+type rule struct {
+	class   Class
+	pattern *regexp.Regexp
+}
+
+// rules are tried in order and the first match wins. Auth and quota come
+// first, as their messages often also read as invalid input ("invalid API
+// key") or mention the timeout of a retry; crashes come before validation
+// for the same reason. Patterns include the wording of common locales, and
+// (?i) folds case beyond ASCII.
+var rules = []rule{
+	{Auth, regexp.MustCompile(`(?i)unauthori[sz]ed|unauthenticated|forbidden|permission denied|access denied|` +
+		`authentication (?:failed|required)|invalid (?:api[ _-]?key|credentials|token)|(?:token|credentials) (?:has )?expired|` +
+		`(?:status|http|code)[ :=]*40[13]\b|nicht autorisiert|zugriff verweigert|non autorisé|accès refusé|` +
+		`no autorizado|acceso denegado|não autorizado|acesso negado|未授权|未认证|権限がありません|認証に失敗|доступ запрещ|не авторизован`)},
+	{Quota, regexp.MustCompile(`(?i)quota|rate[ _-]?limit|too many requests|resource[ _]exhausted|` +
+		`insufficient (?:credits|funds|balance)|budget exceeded|limit exceeded|(?:status|http|code)[ :=]*429\b|` +
+		`kontingent|cuota|cota excedida|配额|限流|クォータ|レート制限|лимит|квот`)},
+	{Timeout, regexp.MustCompile(`(?i)time[ _-]?out|timed out|deadline exceeded|deadline_exceeded|` +
+		`(?:status|http|code)[ :=]*504\b|zeitüberschreitung|délai d'attente|délai dépassé|tiempo de espera|` +
+		`tempo limite|超时|タイムアウト|тайм-?аут|время ожидания`)},
+	{Crash, regexp.MustCompile(`(?i)panic:|goroutine \d+ \[|segmentation fault|sigsegv|sigkill|sigabrt|core dumped|` +
+		`oomkilled|out of memory|exit (?:status|code) 13[4-9]\b|traceback \(most recent call last\)|` +
+		`unhandled exception|uncaught exception|fatal error|exception in thread|stack overflow|` +
+		`speicherzugriffsfehler|erreur de segmentation|violación de segmento|段错误|崩溃|クラッシュ|ошибка сегментирования`)},
+	{Validation, regexp.MustCompile(`(?i)invalid|validation|malformed|must (?:be|not|have)|is required|` +
+		`missing required|unprocessable|bad request|schema|(?:status|http|code)[ :=]*(?:400|422)\b|` +
+		`ungültig|non valide|invalide|inválid|无效|不正な|недопустим|некорректн`)},
+}
+
+// Classify returns the class of the failure an error message describes;
+// messages matching no class are Unknown and empty ones have no class
+func Classify(message string) Class {
+	if message == "" {
+		return ""
+	}
+	for _, r := range rules {
+		if r.pattern.MatchString(message) {
+			return r.class
+		}
+	}
+	return Unknown
+}
@@ -0,0 +1,47 @@
+package errorclass
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		message string
+		want    Class
+	}{
+		{"", ""},
+		{"context deadline exceeded", Timeout},
+		{"activity StartToClose timeout", Timeout},
+		{"Post \"https://agent-7:8080/tasks\": net/http: request canceled (Client.Timeout exceeded while awaiting headers)", Timeout},
+		{"upstream returned HTTP 504 Gateway Timeout", Timeout},
+		{"Zeitüberschreitung beim Warten auf die Antwort des Agenten", Timeout},
+		{"délai d'attente dépassé lors de la connexion", Timeout},
+		{"请求超时", Timeout},
+		{"401 Unauthorized: invalid API key sk-proj-abc", Auth},
+		{"openai: Incorrect API key provided (status 401)", Auth},
+		{"git clone failed: Permission denied (publickey)", Auth},
+		{"ZUGRIFF VERWEIGERT für Benutzer agent", Auth},
+		{"acceso denegado al repositorio", Auth},
+		{"429 Too Many Requests: Rate limit reached for gpt-4 in organization org-1 on tokens per min", Quota},
+		{"You exceeded your current quota, please check your plan and billing details", Quota},
+		{"rpc error: code = ResourceExhausted desc = resource_exhausted", Quota},
+		{"Se ha superado la cuota diaria", Quota},
+		{"panic: runtime error: invalid memory address or nil pointer dereference\n[signal SIGSEGV: segmentation violation]\n\ngoroutine 1 [running]:", Crash},
+		{"Traceback (most recent call last):\n  File \"agent.py\", line 42, in run\nKeyError: 'task'", Crash},
+		{"container exited: OOMKilled", Crash},
+		{"process exited with exit status 137", Crash},
+		{"Exception in thread \"main\" java.lang.NullPointerException", Crash},
+		{"validation failed: field 'title' is required", Validation},
+		{"422 Unprocessable Entity: schema mismatch", Validation},
+		{"task input must be a JSON object", Validation},
+		{"Paramètre non valide: langue", Validation},
+		{"something went wrong", Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			assert.Equal(t, tt.want, Classify(tt.message))
+		})
+	}
+}
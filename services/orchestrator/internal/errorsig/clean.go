@@ -0,0 +1,136 @@
+package errorsig
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxErrorLength is the longest stored error, in bytes, of cleaners
+// created without a length
+const DefaultMaxErrorLength = 4096
+
+// MinErrorLength is the shortest maximum length of a cleaner; shorter ones
+// would leave little besides the truncation marker
+const MinErrorLength = 128
+
+// minBlobLength is the shortest run of base64 replaced as an encoded blob
+const minBlobLength = 64
+
+var (
+	// ansiEscape matches terminal escape sequences: CSI sequences such as
+	// colors, OSC sequences such as hyperlinks, and two-byte escapes
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+	// dataURI matches inline base64 content such as data:image/png;base64,...
+	dataURI = regexp.MustCompile(`data:[\w.+-]+/[\w.+-]+;base64,[A-Za-z0-9+/=]+`)
+	// base64Blob matches long runs of base64 or base64url characters
+	base64Blob = regexp.MustCompile(fmt.Sprintf(`[A-Za-z0-9+/_-]{%d,}={0,2}`, minBlobLength))
+)
+
+// Cleaner makes error messages fit to store and show: terminal escapes
+// removed, binary and encoded content replaced by placeholders, and long
+// messages truncated keeping their head and tail, where stack traces carry
+// the error and its root cause. It is safe for concurrent use.
+type Cleaner struct {
+	maxLength int
+}
+
+// NewCleaner creates a cleaner truncating messages to maxLength bytes, or
+// DefaultMaxErrorLength when it is not positive
+func NewCleaner(maxLength int) *Cleaner {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxErrorLength
+	}
+	return &Cleaner{maxLength: max(maxLength, MinErrorLength)}
+}
+
+// MaxLength returns the longest message the cleaner returns, in bytes
+func (c *Cleaner) MaxLength() int {
+	return c.maxLength
+}
+
+// Clean returns the message as it should be stored
+func (c *Cleaner) Clean(message string) string {
+	cleaned := ansiEscape.ReplaceAllString(message, "")
+	cleaned = replaceBinary(cleaned)
+	cleaned = dataURI.ReplaceAllStringFunc(cleaned, func(match string) string {
+		return fmt.Sprintf("<base64 %d bytes>", len(match))
+	})
+	cleaned = base64Blob.ReplaceAllStringFunc(cleaned, func(match string) string {
+		if !isBlob(match) {
+			return match
+		}
+		return fmt.Sprintf("<base64 %d bytes>", len(match))
+	})
+	return truncateMiddle(strings.TrimSpace(cleaned), c.maxLength)
+}
+
+// isBlob reports whether a run of base64 characters is encoded content
+// rather than a hex digest, a path or an identifier: it mixes upper and
+// lower case letters and digits
+func isBlob(run string) bool {
+	var upper, lower, digit bool
+	for _, r := range run {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= '0' && r <= '9':
+			digit = true
+		}
+	}
+	return upper && lower && digit
+}
+
+// replaceBinary replaces runs of invalid UTF-8 and control characters other
+// than whitespace with a placeholder giving their length
+func replaceBinary(s string) string {
+	var b strings.Builder
+	binary := 0
+	flush := func() {
+		if binary > 0 {
+			fmt.Fprintf(&b, "<binary %d bytes>", binary)
+			binary = 0
+		}
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size <= 1) || (unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t') {
+			binary += size
+		} else {
+			flush()
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	flush()
+	return b.String()
+}
+
+// truncateMiddle shortens s to at most max bytes by replacing its middle
+// with a marker giving the bytes left out, without splitting a rune
+func truncateMiddle(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	// The marker is sized for the whole message, so the one finally used,
+	// giving fewer bytes, is no longer
+	markerLength := len(truncationMarker(len(s)))
+	if max <= markerLength {
+		return truncate(s, max)
+	}
+	keep := max - markerLength
+	head := truncate(s, keep-keep/2)
+	tail := s[len(s)-keep/2:]
+	for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+		tail = tail[1:]
+	}
+	return head + truncationMarker(len(s)-len(head)-len(tail)) + tail
+}
+
+func truncationMarker(omitted int) string {
+	return fmt.Sprintf("\n… %d bytes truncated …\n", omitted)
+}
@@ -0,0 +1,164 @@
+package errorsig
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/errorclass"
+)
+
+// pythonTrace is an agent error carrying a whole stack trace, the error at
+// its start and its root cause at its end
+func pythonTrace(frames int) string {
+	var b strings.Builder
+	b.WriteString("Task execution failed: agent error: Traceback (most recent call last):\n")
+	for i := 0; i < frames; i++ {
+		fmt.Fprintf(&b, "  File \"/app/agent/steps.py\", line %d, in run_step_%d\n    result = handler(payload)\n", 100+i, i)
+	}
+	b.WriteString("TimeoutError: model call did not return in 120s")
+	return b.String()
+}
+
+func TestCleaner_Clean(t *testing.T) {
+	cleaner := NewCleaner(512)
+	blob := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("\x89PNG\r\n\x1a\n screenshot bytes ", 8)))
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"empty", "", ""},
+		{"plain", "exit status 1", "exit status 1"},
+		{"ansi colors", "\x1b[31;1mERROR\x1b[0m build failed: \x1b[33mmissing module\x1b[0m",
+			"ERROR build failed: missing module"},
+		{"ansi cursor and hyperlink", "\x1b[2K\x1b[1Gnpm ERR! see \x1b]8;;https://docs.npmjs.com\x07docs\x1b]8;;\x07",
+			"npm ERR! see docs"},
+		{"binary", "decode failed near \x00\x01\x02\xff\xfe payload", "decode failed near <binary 5 bytes> payload"},
+		{"base64 blob", "screenshot: " + blob + " attached", fmt.Sprintf("screenshot: <base64 %d bytes> attached", len(blob))},
+		{"data uri", "render failed for data:image/png;base64,iVBORw0KGgo=", "render failed for <base64 34 bytes>"},
+		{"hex digest kept", "checksum mismatch: " + strings.Repeat("9fceb02d", 8), "checksum mismatch: " + strings.Repeat("9fceb02d", 8)},
+		{"multilingual kept", "Zeitüberschreitung: 请求超时 — tâche échouée", "Zeitüberschreitung: 请求超时 — tâche échouée"},
+		{"whitespace trimmed", "\n\tsegfault\n", "segfault"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cleaner.Clean(tt.message))
+		})
+	}
+}
+
+func TestCleaner_TruncatesKeepingHeadAndTail(t *testing.T) {
+	cleaner := NewCleaner(512)
+	trace := pythonTrace(200)
+
+	cleaned := cleaner.Clean(trace)
+	assert.LessOrEqual(t, len(cleaned), 512)
+	assert.True(t, strings.HasPrefix(cleaned, "Task execution failed: agent error: Traceback"))
+	assert.True(t, strings.HasSuffix(cleaned, "TimeoutError: model call did not return in 120s"))
+	assert.Contains(t, cleaned, "bytes truncated")
+
+	// Runes are never split, wherever the cut falls
+	for _, length := range []int{MinErrorLength, 129, 130, 131, 257} {
+		cleaned := NewCleaner(length).Clean(strings.Repeat("é漢", 400))
+		assert.LessOrEqual(t, len(cleaned), length)
+		assert.True(t, utf8.ValidString(cleaned), length)
+	}
+
+	assert.Equal(t, DefaultMaxErrorLength, NewCleaner(0).MaxLength())
+	assert.Equal(t, MinErrorLength, NewCleaner(10).MaxLength())
+}
+
+// agentExecution is a model with an error, its class and signature
+type agentExecution struct {
+	ID             uint
+	Status         string
+	Error          string
+	ErrorClass     string
+	ErrorSignature string
+}
+
+// archived is an error kept by the test archiver
+type archived struct {
+	table, id, message string
+}
+
+func setupCleanedDB(t *testing.T) (*gorm.DB, *[]archived) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	var originals []archived
+	require.NoError(t, Register(db, Default()))
+	require.NoError(t, RegisterCleaner(db, NewCleaner(MinErrorLength), func(tx *gorm.DB, table, id, message string) {
+		originals = append(originals, archived{table, id, message})
+	}))
+	require.NoError(t, db.AutoMigrate(&agentExecution{}, &plainJob{}))
+	return db, &originals
+}
+
+func TestRegisterCleaner_CleansAndClassifiesWrittenErrors(t *testing.T) {
+	db, originals := setupCleanedDB(t)
+	stored := func(id uint) agentExecution {
+		var execution agentExecution
+		require.NoError(t, db.First(&execution, id).Error)
+		return execution
+	}
+
+	// Create: the error is cleaned keeping the root cause at its end,
+	// classified and signed once cleaned; the original of the truncated trace
+	// is archived
+	trace := pythonTrace(20)
+	execution := &agentExecution{Status: "failed", Error: trace}
+	require.NoError(t, db.Create(execution).Error)
+	saved := stored(execution.ID)
+	assert.LessOrEqual(t, len(saved.Error), MinErrorLength)
+	assert.True(t, strings.HasSuffix(saved.Error, "TimeoutError: model call did not return in 120s"))
+	assert.Equal(t, string(errorclass.Timeout), saved.ErrorClass)
+	assert.Equal(t, Default().Signature(saved.Error), saved.ErrorSignature)
+	assert.Equal(t, []archived{{"agent_executions", fmt.Sprint(execution.ID), trace}}, *originals)
+
+	// Errors cleaning leaves alone are not archived
+	colored := &agentExecution{Error: "\x1b[31mrate limit exceeded\x1b[0m"}
+	short := &agentExecution{Error: "exit status 1"}
+	require.NoError(t, db.Create([]*agentExecution{colored, short}).Error)
+	assert.Equal(t, "rate limit exceeded", stored(colored.ID).Error)
+	assert.Equal(t, string(errorclass.Quota), stored(colored.ID).ErrorClass)
+	assert.Equal(t, string(errorclass.Unknown), stored(short.ID).ErrorClass)
+	require.Len(t, *originals, 2)
+	assert.Equal(t, archived{"agent_executions", fmt.Sprint(colored.ID), "\x1b[31mrate limit exceeded\x1b[0m"}, (*originals)[1])
+
+	// Updates with a map on a model holding its key, and with a struct
+	require.NoError(t, db.Model(&agentExecution{ID: short.ID}).
+		Updates(map[string]interface{}{"status": "failed", "error": "401 Unauthorized\x00"}).Error)
+	assert.Equal(t, "401 Unauthorized<binary 1 bytes>", stored(short.ID).Error)
+	assert.Equal(t, string(errorclass.Auth), stored(short.ID).ErrorClass)
+	require.NoError(t, db.Model(&agentExecution{ID: short.ID}).Updates(&agentExecution{Error: "\x1b[1mcontext deadline exceeded"}).Error)
+	assert.Equal(t, "context deadline exceeded", stored(short.ID).Error)
+	assert.Equal(t, string(errorclass.Timeout), stored(short.ID).ErrorClass)
+	require.Len(t, *originals, 4)
+	assert.Equal(t, fmt.Sprint(short.ID), (*originals)[3].id)
+
+	// Updates without the row's key are cleaned, not archived
+	require.NoError(t, db.Model(&agentExecution{}).Where("id = ?", short.ID).Update("error", "\x1b[0mboom").Error)
+	assert.Equal(t, "boom", stored(short.ID).Error)
+	assert.Len(t, *originals, 4)
+
+	// Clearing the error clears its class
+	require.NoError(t, db.Model(&agentExecution{ID: short.ID}).Update("error", "").Error)
+	assert.Empty(t, stored(short.ID).ErrorClass)
+
+	// Models without a class are cleaned all the same
+	job := &plainJob{Error: "\x1b[31mboom\x1b[0m"}
+	require.NoError(t, db.Create(job).Error)
+	var plain plainJob
+	require.NoError(t, db.First(&plain, job.ID).Error)
+	assert.Equal(t, "boom", plain.Error)
+}
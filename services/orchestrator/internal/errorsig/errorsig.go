@@ -0,0 +1,110 @@
+// Package errorsig reduces error messages to signatures: the message with the
+// parts that differ between occurrences of the same failure, such as IDs,
+// timestamps and hex strings, replaced by placeholders. Failures with the same
+// signature likely share a root cause.
+package errorsig
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the longest signature, in bytes; longer ones are truncated
+const MaxLength = 512
+
+// DefaultReplacement replaces the matches of rules that set no replacement
+const DefaultReplacement = "<id>"
+
+// Rule replaces the matches of Pattern, a regular expression, with Replacement
+type Rule struct {
+	Pattern     string
+	Replacement string
+}
+
+type rule struct {
+	pattern     *regexp.Regexp
+	replacement string
+	// keep reports whether a match is left unchanged
+	keep func(match string) bool
+}
+
+// defaultRules run in order after the custom rules: timestamps before
+// UUIDs and hex strings, which may match their digits, and long numbers last.
+// Numbers of up to three digits, such as status and exit codes, are kept.
+var defaultRules = []rule{
+	{pattern: regexp.MustCompile(`\d{4}-\d{2}-\d{2}(?:[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)?`), replacement: "<time>"},
+	{pattern: regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(?:\.\d+)?\b`), replacement: "<time>"},
+	{pattern: regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), replacement: "<uuid>"},
+	{pattern: regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b`), replacement: "<hex>"},
+	// A hex string mixes digits and letters: words made of the letters a-f
+	// are kept and plain numbers are left to the number rule
+	{pattern: regexp.MustCompile(`(?i)\b[0-9a-f]{8,}\b`), replacement: "<hex>", keep: func(match string) bool {
+		return !strings.ContainsAny(match, "0123456789") || !strings.ContainsAny(match, "abcdefABCDEF")
+	}},
+	{pattern: regexp.MustCompile(`\b\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h)\b`), replacement: "<duration>"},
+	{pattern: regexp.MustCompile(`\d{4,}`), replacement: "<n>"},
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Normalizer computes error signatures. It is safe for concurrent use.
+type Normalizer struct {
+	rules []rule
+}
+
+// NewNormalizer creates a normalizer applying the custom rules, in order,
+// before the default ones
+func NewNormalizer(custom ...Rule) (*Normalizer, error) {
+	rules := make([]rule, 0, len(custom)+len(defaultRules))
+	for _, r := range custom {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error signature pattern %q: %w", r.Pattern, err)
+		}
+		replacement := r.Replacement
+		if replacement == "" {
+			replacement = DefaultReplacement
+		}
+		rules = append(rules, rule{pattern: pattern, replacement: replacement})
+	}
+	return &Normalizer{rules: append(rules, defaultRules...)}, nil
+}
+
+// Default returns a normalizer applying the default rules only
+func Default() *Normalizer {
+	return &Normalizer{rules: defaultRules}
+}
+
+// Signature returns the signature of an error message; that of an empty
+// message is empty
+func (n *Normalizer) Signature(message string) string {
+	signature := message
+	for _, r := range n.rules {
+		if r.keep == nil {
+			signature = r.pattern.ReplaceAllLiteralString(signature, r.replacement)
+			continue
+		}
+		signature = r.pattern.ReplaceAllStringFunc(signature, func(match string) string {
+			if r.keep(match) {
+				return match
+			}
+			return r.replacement
+		})
+	}
+	signature = strings.TrimSpace(whitespace.ReplaceAllString(signature, " "))
+	return truncate(signature, MaxLength)
+}
+
+// truncate shortens s to at most max bytes without splitting a rune
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[:max]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+package errorsig
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestNormalizer_Signature(t *testing.T) {
+	normalizer := Default()
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"empty", "", ""},
+		{"uuid", "workflow 3f2b8c1e-9a4d-4c6b-8e2f-1a2b3c4d5e6f not found", "workflow <uuid> not found"},
+		{"rfc3339 timestamp", "lease expired at 2026-10-18T03:14:07.123Z", "lease expired at <time>"},
+		{"log timestamp", "2026-10-18 03:14:07 connection reset", "<time> connection reset"},
+		{"clock time", "deadline 03:14:07 exceeded", "deadline <time> exceeded"},
+		{"hex address", "panic: nil pointer dereference at 0xc000123abc", "panic: nil pointer dereference at <hex>"},
+		{"commit hash", "checkout of 9fceb02d0ae598e95dc970b74767f19372d61af8 failed", "checkout of <hex> failed"},
+		{"duration", "activity timed out after 30.5s", "activity timed out after <duration>"},
+		{"long number", "dial tcp 10.0.0.12:5432: connection refused", "dial tcp 10.0.0.12:<n>: connection refused"},
+		{"long decimal number", "memory limit 2147483648 exceeded", "memory limit <n> exceeded"},
+		{"status codes kept", "agent returned HTTP 503 for task 88123", "agent returned HTTP 503 for task <n>"},
+		{"hex letters kept", "accessed facade of deadbeef", "accessed facade of deadbeef"},
+		{"whitespace", "  step failed:\n\texit status 137  ", "step failed: exit status 137"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizer.Signature(tt.message))
+		})
+	}
+}
+
+func TestNormalizer_GroupsOccurrencesOfOneFailure(t *testing.T) {
+	normalizer := Default()
+
+	occurrences := []string{
+		"task 4f1c2a9e-0b7d-4e3a-9c55-0d1e2f3a4b5c on agent agent-7 failed at 2026-10-18T01:02:03Z: OOMKilled (memory 2147483648 bytes)",
+		"task 8a7b6c5d-4e3f-4a1b-8c2d-3e4f5a6b7c8d on agent agent-7 failed at 2026-10-18T04:05:06Z: OOMKilled (memory 4294967296 bytes)",
+	}
+	assert.Equal(t, normalizer.Signature(occurrences[0]), normalizer.Signature(occurrences[1]))
+	assert.NotEqual(t, normalizer.Signature(occurrences[0]),
+		normalizer.Signature("task 8a7b6c5d-4e3f-4a1b-8c2d-3e4f5a6b7c8d on agent agent-7 failed at 2026-10-18T04:05:06Z: exit status 1"))
+}
+
+func TestNewNormalizer_CustomRules(t *testing.T) {
+	normalizer, err := NewNormalizer(
+		Rule{Pattern: `job-[a-z]+`, Replacement: "<job>"},
+		Rule{Pattern: `tenant=\w+`},
+	)
+	require.NoError(t, err)
+
+	// Custom rules run first, so they see the message as written
+	assert.Equal(t, "<job> failed for <id> at <time>",
+		normalizer.Signature("job-nightly failed for tenant=acme at 2026-10-18T01:02:03Z"))
+
+	_, err = NewNormalizer(Rule{Pattern: `(`})
+	assert.Error(t, err)
+}
+
+func TestNormalizer_TruncatesLongSignatures(t *testing.T) {
+	signature := Default().Signature(strings.Repeat("é", MaxLength))
+	assert.LessOrEqual(t, len(signature), MaxLength)
+	assert.True(t, strings.HasPrefix(strings.Repeat("é", MaxLength), signature))
+}
+
+// failedJob is a model with an error and its signature
+type failedJob struct {
+	ID             uint
+	Status         string
+	Error          string
+	ErrorSignature string
+}
+
+// plainJob is a model without a signature
+type plainJob struct {
+	ID    uint
+	Error string
+}
+
+func setupSignedDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, Register(db, Default()))
+	require.NoError(t, db.AutoMigrate(&failedJob{}, &plainJob{}))
+	return db
+}
+
+func TestRegister_SignsWrittenErrors(t *testing.T) {
+	db := setupSignedDB(t)
+	stored := func(id uint) failedJob {
+		var job failedJob
+		require.NoError(t, db.First(&job, id).Error)
+		return job
+	}
+	const want = "task <uuid> failed"
+	message := func(id string) string { return "task " + id + " failed" }
+
+	// Create, of one model or a batch
+	job := &failedJob{Status: "failed", Error: message("3f2b8c1e-9a4d-4c6b-8e2f-1a2b3c4d5e6f")}
+	require.NoError(t, db.Create(job).Error)
+	assert.Equal(t, want, stored(job.ID).ErrorSignature)
+
+	batch := []failedJob{{Error: "a at 0xdeadbeef01"}, {}}
+	require.NoError(t, db.Create(&batch).Error)
+	assert.Equal(t, "a at <hex>", stored(batch[0].ID).ErrorSignature)
+	assert.Empty(t, stored(batch[1].ID).ErrorSignature)
+
+	// Update and Updates with a map
+	require.NoError(t, db.Model(&failedJob{}).Where("id = ?", batch[1].ID).
+		Update("error", message("8a7b6c5d-4e3f-4a1b-8c2d-3e4f5a6b7c8d")).Error)
+	assert.Equal(t, want, stored(batch[1].ID).ErrorSignature)
+
+	require.NoError(t, db.Model(&failedJob{}).Where("id = ?", batch[1].ID).
+		Updates(map[string]interface{}{"status": "retrying", "error": ""}).Error)
+	assert.Empty(t, stored(batch[1].ID).ErrorSignature)
+
+	// Updates with a struct, only when it sets the error
+	require.NoError(t, db.Model(&failedJob{ID: job.ID}).Updates(failedJob{Status: "retrying"}).Error)
+	assert.Equal(t, want, stored(job.ID).ErrorSignature)
+	require.NoError(t, db.Model(&failedJob{ID: job.ID}).Updates(&failedJob{Error: "exit status 137"}).Error)
+	assert.Equal(t, "exit status 137", stored(job.ID).ErrorSignature)
+
+	// Selected columns include the signature along with the error
+	require.NoError(t, db.Model(&failedJob{ID: job.ID}).Select("error").
+		Updates(map[string]interface{}{"error": message("4f1c2a9e-0b7d-4e3a-9c55-0d1e2f3a4b5c")}).Error)
+	assert.Equal(t, want, stored(job.ID).ErrorSignature)
+
+	// Save writes the whole model
+	saved := stored(job.ID)
+	saved.Error = ""
+	require.NoError(t, db.Save(&saved).Error)
+	assert.Empty(t, stored(job.ID).ErrorSignature)
+
+	// Models without a signature are written as they are
+	require.NoError(t, db.Create(&plainJob{Error: "boom"}).Error)
+}
@@ -0,0 +1,291 @@
+package errorsig
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+
+	"orchestrator/internal/errorclass"
+)
+
+// Register installs GORM callbacks that set the ErrorSignature field of
+// written models to the signature of their Error field, so failures can be
+// grouped by signature without normalizing their messages when read. Models
+// without both fields are left alone.
+func Register(db *gorm.DB, normalizer *Normalizer) error {
+	s := &signer{normalizer: normalizer}
+
+	if err := db.Callback().Create().Before("gorm:create").Register("errorsig:sign_create", s.sign); err != nil {
+		return fmt.Errorf("failed to register create callback: %w", err)
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("errorsig:sign_update", s.sign); err != nil {
+		return fmt.Errorf("failed to register update callback: %w", err)
+	}
+	return nil
+}
+
+type signer struct {
+	normalizer *Normalizer
+}
+
+// sign sets the signature of the error being written
+func (s *signer) sign(tx *gorm.DB) {
+	stmt := tx.Statement
+	if tx.Error != nil || stmt.Schema == nil {
+		return
+	}
+	errorField := stmt.Schema.LookUpField("Error")
+	signatureField := stmt.Schema.LookUpField("ErrorSignature")
+	if errorField == nil || signatureField == nil {
+		return
+	}
+
+	// Update("error", message) and Updates(map) carry their values in Dest
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		for _, key := range []string{errorField.DBName, errorField.Name} {
+			if message, ok := values[key].(string); ok {
+				values[signatureField.DBName] = s.normalizer.Signature(message)
+				selectColumn(stmt, errorField, signatureField)
+			}
+		}
+		return
+	}
+
+	ctx := stmt.Context
+	if !writesModel(stmt) {
+		// Updates(struct) writes the non-zero fields of its argument
+		dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+		if dest.Kind() != reflect.Struct || dest.Type() != stmt.Schema.ModelType {
+			return
+		}
+		message, zero := errorField.ValueOf(ctx, dest)
+		if text, ok := message.(string); ok && !zero {
+			stmt.SetColumn(signatureField.DBName, s.normalizer.Signature(text), true)
+			selectColumn(stmt, errorField, signatureField)
+		}
+		return
+	}
+
+	// Create and Save write the models themselves
+	forEachModel(stmt, func(model reflect.Value) {
+		message, _ := errorField.ValueOf(ctx, model)
+		text, _ := message.(string)
+		tx.AddError(signatureField.Set(ctx, model, s.normalizer.Signature(text)))
+	})
+	selectColumn(stmt, errorField, signatureField)
+}
+
+// Archiver keeps the full text of an error the cleaner changed before it was
+// written to the row of table with primary key id. It runs in the
+// transaction of the write and reports its own failures.
+type Archiver func(tx *gorm.DB, table, id, message string)
+
+// originalsKey holds the originals of the errors cleaned by a statement
+const originalsKey = "errorsig:originals"
+
+// RegisterCleaner installs GORM callbacks that clean the Error field of
+// written models and set their ErrorClass field, when they have one, to the
+// class of the error as it was reported. Errors cleaning changed are passed
+// to archive, when set, once written; that needs the primary key of the row,
+// so errors of updates without a model holding it are not archived.
+// Errors are cleaned before any other callback runs, so signatures are
+// computed from cleaned errors.
+func RegisterCleaner(db *gorm.DB, cleaner *Cleaner, archive Archiver) error {
+	c := &cleaning{cleaner: cleaner, archive: archive}
+
+	if err := db.Callback().Create().Before("*").Register("errorsig:clean_create", c.clean); err != nil {
+		return fmt.Errorf("failed to register create callback: %w", err)
+	}
+	if err := db.Callback().Update().Before("*").Register("errorsig:clean_update", c.clean); err != nil {
+		return fmt.Errorf("failed to register update callback: %w", err)
+	}
+	if archive == nil {
+		return nil
+	}
+	if err := db.Callback().Create().After("gorm:create").Register("errorsig:archive_create", c.archiveOriginals); err != nil {
+		return fmt.Errorf("failed to register create callback: %w", err)
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("errorsig:archive_update", c.archiveOriginals); err != nil {
+		return fmt.Errorf("failed to register update callback: %w", err)
+	}
+	return nil
+}
+
+type cleaning struct {
+	cleaner *Cleaner
+	archive Archiver
+}
+
+// original is an error as reported, with the model of the row it was
+// written to; the model is invalid when the statement has none
+type original struct {
+	model   reflect.Value
+	message string
+}
+
+// clean cleans and classifies the error being written
+func (c *cleaning) clean(tx *gorm.DB) {
+	stmt := tx.Statement
+	if tx.Error != nil || stmt.Schema == nil {
+		return
+	}
+	errorField := stmt.Schema.LookUpField("Error")
+	if errorField == nil || errorField.FieldType.Kind() != reflect.String {
+		return
+	}
+	classField := stmt.Schema.LookUpField("ErrorClass")
+	var originals []original
+
+	// Update("error", message) and Updates(map) carry their values in Dest
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		for _, key := range []string{errorField.DBName, errorField.Name} {
+			message, ok := values[key].(string)
+			if !ok {
+				continue
+			}
+			cleaned := c.cleaner.Clean(message)
+			values[key] = cleaned
+			if classField != nil {
+				values[classField.DBName] = string(errorclass.Classify(message))
+				selectColumn(stmt, errorField, classField)
+			}
+			if cleaned != message {
+				originals = append(originals, original{model: statementModel(stmt), message: message})
+			}
+		}
+		c.keep(tx, originals)
+		return
+	}
+
+	ctx := stmt.Context
+	if !writesModel(stmt) {
+		// Updates(struct) writes the non-zero fields of its argument
+		dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+		if dest.Kind() != reflect.Struct || dest.Type() != stmt.Schema.ModelType {
+			return
+		}
+		message, zero := errorField.ValueOf(ctx, dest)
+		text, ok := message.(string)
+		if !ok || zero {
+			return
+		}
+		cleaned := c.cleaner.Clean(text)
+		stmt.SetColumn(errorField.DBName, cleaned, true)
+		if classField != nil {
+			stmt.SetColumn(classField.DBName, string(errorclass.Classify(text)), true)
+			selectColumn(stmt, errorField, classField)
+		}
+		if cleaned != text {
+			originals = append(originals, original{model: statementModel(stmt), message: text})
+		}
+		c.keep(tx, originals)
+		return
+	}
+
+	// Create and Save write the models themselves
+	forEachModel(stmt, func(model reflect.Value) {
+		message, _ := errorField.ValueOf(ctx, model)
+		text, _ := message.(string)
+		cleaned := c.cleaner.Clean(text)
+		tx.AddError(errorField.Set(ctx, model, cleaned))
+		if classField != nil {
+			tx.AddError(classField.Set(ctx, model, string(errorclass.Classify(text))))
+		}
+		if cleaned != text {
+			originals = append(originals, original{model: model, message: text})
+		}
+	})
+	if classField != nil {
+		selectColumn(stmt, errorField, classField)
+	}
+	c.keep(tx, originals)
+}
+
+// keep holds the originals of the cleaned errors until they are written
+func (c *cleaning) keep(tx *gorm.DB, originals []original) {
+	if c.archive != nil && len(originals) > 0 {
+		tx.InstanceSet(originalsKey, originals)
+	}
+}
+
+// archiveOriginals archives the originals of the errors the statement wrote
+func (c *cleaning) archiveOriginals(tx *gorm.DB) {
+	stmt := tx.Statement
+	if tx.Error != nil || stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return
+	}
+	held, ok := tx.InstanceGet(originalsKey)
+	if !ok {
+		return
+	}
+	primaryKey := stmt.Schema.PrioritizedPrimaryField
+	for _, o := range held.([]original) {
+		if !o.model.IsValid() {
+			continue
+		}
+		id, zero := primaryKey.ValueOf(stmt.Context, o.model)
+		if zero {
+			continue
+		}
+		c.archive(tx, stmt.Table, fmt.Sprint(id), o.message)
+	}
+}
+
+// statementModel returns the model of a statement writing separate values,
+// invalid when it is not one of the statement's schema type
+func statementModel(stmt *gorm.Statement) reflect.Value {
+	model := reflect.Indirect(reflect.ValueOf(stmt.Model))
+	if model.Kind() != reflect.Struct || model.Type() != stmt.Schema.ModelType {
+		return reflect.Value{}
+	}
+	return model
+}
+
+// writesModel reports whether the statement writes its model, as Create and
+// Save do, rather than a separate set of values
+func writesModel(stmt *gorm.Statement) bool {
+	dest, model := reflect.ValueOf(stmt.Dest), reflect.ValueOf(stmt.Model)
+	switch dest.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		return model.Kind() == dest.Kind() && dest.Pointer() == model.Pointer()
+	}
+	return false
+}
+
+// selectColumn adds a column derived from the error to the selected columns
+// of a statement that selects the error
+func selectColumn(stmt *gorm.Statement, errorField, field *schema.Field) {
+	selectsError := false
+	for _, column := range stmt.Selects {
+		switch column {
+		case "*", field.DBName, field.Name:
+			return
+		case errorField.DBName, errorField.Name:
+			selectsError = true
+		}
+	}
+	if selectsError {
+		stmt.Selects = append(stmt.Selects, field.DBName)
+	}
+}
+
+// forEachModel calls fn for every addressable model of the statement's
+// schema type
+func forEachModel(stmt *gorm.Statement, fn func(model reflect.Value)) {
+	rv := stmt.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			model := reflect.Indirect(rv.Index(i))
+			if model.Type() == stmt.Schema.ModelType && model.CanAddr() {
+				fn(model)
+			}
+		}
+	case reflect.Struct:
+		if rv.Type() == stmt.Schema.ModelType && rv.CanAddr() {
+			fn(rv)
+		}
+	}
+}
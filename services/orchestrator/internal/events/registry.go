@@ -0,0 +1,405 @@
+// Package events keeps track of the consumers of the events the orchestrator
+// publishes, so a consumer that falls behind or silently dies is noticed.
+package events
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// Defaults of the watchdog when the configuration leaves them unset
+const (
+	defaultWatchdogInterval = 30 * time.Second
+	defaultStallThreshold   = 5 * time.Minute
+)
+
+var (
+	eventsProducedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_events_produced_total",
+		Help: "Events published, by source",
+	}, []string{"source"})
+	consumerProcessedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_event_consumer_processed_total",
+		Help: "Events an event consumer processed, by consumer",
+	}, []string{"consumer"})
+	consumerErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_event_consumer_errors_total",
+		Help: "Events an event consumer failed to process, by consumer",
+	}, []string{"consumer"})
+	consumerLastProcessed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_event_consumer_last_processed_timestamp_seconds",
+		Help: "When an event consumer last processed an event, by consumer",
+	}, []string{"consumer"})
+	consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_event_consumer_lag",
+		Help: "Events delivered to an event consumer and not yet processed, by consumer",
+	}, []string{"consumer"})
+	consumerStalled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_event_consumer_stalled",
+		Help: "Whether an event consumer stopped processing while events are produced, by consumer",
+	}, []string{"consumer"})
+)
+
+// SourceOf returns the source of the events published on a channel: the
+// channel without its last segment, so workflow:events:<project ID> is the
+// source workflow:events
+func SourceOf(channel string) string {
+	if i := strings.LastIndex(channel, ":"); i > 0 {
+		return channel[:i]
+	}
+	return channel
+}
+
+// LagFunc returns how many events delivered to a consumer are waiting to be
+// processed, such as the pending entries of a stream consumer group
+type LagFunc func(ctx context.Context) (int64, error)
+
+// RedisStreamLag returns the pending entries of a Redis stream consumer group
+func RedisStreamLag(client *redis.Client, stream, group string) LagFunc {
+	return func(ctx context.Context) (int64, error) {
+		pending, err := client.XPending(ctx, stream, group).Result()
+		if err != nil {
+			return 0, err
+		}
+		return pending.Count, nil
+	}
+}
+
+// ConsumerOptions describes what a consumer consumes
+type ConsumerOptions struct {
+	// Source is the source of the events consumed, as returned by SourceOf;
+	// the consumer is behind when events are produced on it after the
+	// consumer last processed one
+	Source string
+	// Lag reports the events waiting for the consumer, for consumers that
+	// queue them
+	Lag LagFunc
+}
+
+// ConsumerStatus is the state of an event consumer
+type ConsumerStatus struct {
+	Name            string     `json:"name"`
+	Source          string     `json:"source,omitempty"`
+	Processed       int64      `json:"processed"`
+	Errors          int64      `json:"errors"`
+	LastProcessedAt *time.Time `json:"last_processed_at,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastProducedAt  *time.Time `json:"last_produced_at,omitempty"` // When an event was last produced on the source
+	Lag             int64      `json:"lag"`
+	Stalled         bool       `json:"stalled"`
+	StalledSince    *time.Time `json:"stalled_since,omitempty"`
+	RegisteredAt    time.Time  `json:"registered_at"`
+}
+
+// Consumer is the bookkeeping of one registered event consumer. A nil
+// consumer records nothing, for components running without a registry.
+type Consumer struct {
+	registry *Registry
+	name     string
+	source   string
+	lagFunc  LagFunc
+
+	// Guarded by the registry's mutex
+	processed       int64
+	errors          int64
+	lastReceivedAt  time.Time
+	lastProcessedAt time.Time
+	lastErrorAt     time.Time
+	lastError       string
+	lag             int64
+	waitingSince    time.Time // When the watchdog saw events waiting for the consumer; zero while none are
+	stalledSince    *time.Time
+	registeredAt    time.Time
+}
+
+// Received records that an event was delivered to the consumer
+func (c *Consumer) Received() {
+	if c == nil {
+		return
+	}
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	c.lastReceivedAt = c.registry.now()
+}
+
+// Processed records that the consumer processed an event
+func (c *Consumer) Processed() {
+	if c == nil {
+		return
+	}
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	c.processed++
+	c.lastProcessedAt = c.registry.now()
+	consumerProcessedTotal.WithLabelValues(c.name).Inc()
+	consumerLastProcessed.WithLabelValues(c.name).Set(float64(c.lastProcessedAt.Unix()))
+}
+
+// Failed records that the consumer failed to process an event
+func (c *Consumer) Failed(err error) {
+	if c == nil {
+		return
+	}
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	c.errors++
+	c.lastErrorAt = c.registry.now()
+	if err != nil {
+		c.lastError = err.Error()
+	}
+	consumerErrorsTotal.WithLabelValues(c.name).Inc()
+}
+
+// Wrap returns handler with the consumer's bookkeeping: each event is
+// recorded as received, then as processed or failed by the handler's error
+func Wrap[T any](c *Consumer, handler func(ctx context.Context, event T) error) func(ctx context.Context, event T) error {
+	return func(ctx context.Context, event T) error {
+		c.Received()
+		if err := handler(ctx, event); err != nil {
+			c.Failed(err)
+			return err
+		}
+		c.Processed()
+		return nil
+	}
+}
+
+// Registry holds the event consumers of the orchestrator and the sources
+// they consume. Its watchdog flags the consumers that have not processed an
+// event for longer than the stall threshold while events are waiting for
+// them. A nil registry records nothing.
+type Registry struct {
+	logger    *zap.Logger
+	interval  time.Duration
+	threshold time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	consumers map[string]*Consumer
+	produced  map[string]time.Time // When an event was last produced, by source
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewRegistry creates an event consumer registry
+func NewRegistry(cfg *config.EventsConfig, logger *zap.Logger) *Registry {
+	r := &Registry{
+		logger:    logger,
+		interval:  defaultWatchdogInterval,
+		threshold: defaultStallThreshold,
+		now:       time.Now,
+		consumers: make(map[string]*Consumer),
+		produced:  make(map[string]time.Time),
+		stopChan:  make(chan struct{}),
+	}
+	if cfg != nil && cfg.WatchdogInterval > 0 {
+		r.interval = time.Duration(cfg.WatchdogInterval) * time.Second
+	}
+	if cfg != nil && cfg.StallThreshold > 0 {
+		r.threshold = time.Duration(cfg.StallThreshold) * time.Second
+	}
+	return r
+}
+
+// Register adds a consumer to the registry. Registering a name again
+// returns the consumer already registered under it.
+func (r *Registry) Register(name string, opts ConsumerOptions) *Consumer {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if consumer, ok := r.consumers[name]; ok {
+		return consumer
+	}
+	consumer := &Consumer{
+		registry:     r,
+		name:         name,
+		source:       opts.Source,
+		lagFunc:      opts.Lag,
+		registeredAt: r.now(),
+	}
+	r.consumers[name] = consumer
+	consumerStalled.WithLabelValues(name).Set(0)
+	return consumer
+}
+
+// RecordProduced records that an event was produced on source
+func (r *Registry) RecordProduced(source string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.produced[source] = r.now()
+	eventsProducedTotal.WithLabelValues(source).Inc()
+}
+
+// Consumers returns the state of the registered consumers by name
+func (r *Registry) Consumers() []ConsumerStatus {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]ConsumerStatus, 0, len(r.consumers))
+	for _, consumer := range r.consumers {
+		statuses = append(statuses, r.status(consumer))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// status returns the state of a consumer; the mutex must be held
+func (r *Registry) status(c *Consumer) ConsumerStatus {
+	status := ConsumerStatus{
+		Name:         c.name,
+		Source:       c.source,
+		Processed:    c.processed,
+		Errors:       c.errors,
+		LastError:    c.lastError,
+		Lag:          c.lag,
+		Stalled:      c.stalledSince != nil,
+		StalledSince: c.stalledSince,
+		RegisteredAt: c.registeredAt,
+	}
+	if !c.lastProcessedAt.IsZero() {
+		processed := c.lastProcessedAt
+		status.LastProcessedAt = &processed
+	}
+	if !c.lastErrorAt.IsZero() {
+		failed := c.lastErrorAt
+		status.LastErrorAt = &failed
+	}
+	if produced, ok := r.produced[c.source]; ok && c.source != "" {
+		status.LastProducedAt = &produced
+	}
+	return status
+}
+
+// Check refreshes the lag of the consumers and flags those that have not
+// processed an event for longer than the stall threshold while events are
+// waiting for them: events queued, delivered or produced on their source
+// since they last processed one. Time the consumer was idle before the
+// watchdog saw events waiting does not count. It returns the consumers
+// flagged.
+func (r *Registry) Check(ctx context.Context) []ConsumerStatus {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	consumers := make([]*Consumer, 0, len(r.consumers))
+	for _, consumer := range r.consumers {
+		consumers = append(consumers, consumer)
+	}
+	r.mu.Unlock()
+
+	// Lags are read without the mutex, as they may query Redis
+	lags := make(map[*Consumer]int64, len(consumers))
+	for _, consumer := range consumers {
+		if consumer.lagFunc == nil {
+			continue
+		}
+		lag, err := consumer.lagFunc(ctx)
+		if err != nil {
+			r.logger.Warn("Failed to read event consumer lag", zap.String("consumer", consumer.name), zap.Error(err))
+			continue
+		}
+		lags[consumer] = lag
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	var stalled []ConsumerStatus
+	for _, consumer := range consumers {
+		if lag, ok := lags[consumer]; ok {
+			consumer.lag = lag
+			consumerLag.WithLabelValues(consumer.name).Set(float64(lag))
+		}
+
+		idleSince := consumer.lastProcessedAt
+		if idleSince.IsZero() {
+			idleSince = consumer.registeredAt
+		}
+		waiting := consumer.lag > 0 || consumer.lastReceivedAt.After(idleSince)
+		if produced, ok := r.produced[consumer.source]; ok && consumer.source != "" && produced.After(idleSince) {
+			waiting = true
+		}
+
+		// Events that only started waiting do not count against the time a
+		// consumer was idle for lack of them
+		if !waiting {
+			consumer.waitingSince = time.Time{}
+		} else if consumer.waitingSince.IsZero() {
+			consumer.waitingSince = now
+		}
+		if consumer.waitingSince.After(idleSince) {
+			idleSince = consumer.waitingSince
+		}
+
+		switch isStalled := waiting && now.Sub(idleSince) > r.threshold; {
+		case isStalled && consumer.stalledSince == nil:
+			consumer.stalledSince = &now
+			consumerStalled.WithLabelValues(consumer.name).Set(1)
+			r.logger.Warn("Event consumer stalled",
+				zap.String("consumer", consumer.name),
+				zap.Time("lastProcessedAt", consumer.lastProcessedAt),
+				zap.Int64("lag", consumer.lag))
+		case !isStalled && consumer.stalledSince != nil:
+			r.logger.Info("Event consumer recovered",
+				zap.String("consumer", consumer.name),
+				zap.Duration("stalledFor", now.Sub(*consumer.stalledSince)))
+			consumer.stalledSince = nil
+			consumerStalled.WithLabelValues(consumer.name).Set(0)
+		}
+		if consumer.stalledSince != nil {
+			stalled = append(stalled, r.status(consumer))
+		}
+	}
+	sort.Slice(stalled, func(i, j int) bool { return stalled[i].Name < stalled[j].Name })
+	return stalled
+}
+
+// Start starts the watchdog
+func (r *Registry) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop stops the watchdog
+func (r *Registry) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+		r.wg.Wait()
+	})
+}
+
+func (r *Registry) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Check(context.Background())
+		case <-r.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// testClock is a clock the tests move forward
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func (c *testClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestRegistry(t *testing.T) (*Registry, *testClock) {
+	clock := &testClock{now: time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)}
+	registry := NewRegistry(&config.EventsConfig{WatchdogInterval: 1, StallThreshold: 60}, zap.NewNop())
+	registry.now = clock.Now
+	return registry, clock
+}
+
+func TestSourceOf(t *testing.T) {
+	assert.Equal(t, "workflow:events", SourceOf("workflow:events:project-1"))
+	assert.Equal(t, "agent:events", SourceOf("agent:events:agent-1"))
+	assert.Equal(t, "events", SourceOf("events"))
+}
+
+func TestRegistry_WrapRecordsProcessing(t *testing.T) {
+	registry, clock := newTestRegistry(t)
+	consumer := registry.Register("test_wrap", ConsumerOptions{Source: "workflow:events"})
+	assert.Same(t, consumer, registry.Register("test_wrap", ConsumerOptions{}))
+
+	handle := Wrap(consumer, func(ctx context.Context, event string) error {
+		if event == "bad" {
+			return errors.New("malformed event")
+		}
+		return nil
+	})
+	require.NoError(t, handle(context.Background(), "good"))
+	clock.advance(time.Second)
+	require.Error(t, handle(context.Background(), "bad"))
+
+	statuses := registry.Consumers()
+	require.Len(t, statuses, 1)
+	status := statuses[0]
+	assert.Equal(t, "test_wrap", status.Name)
+	assert.Equal(t, int64(1), status.Processed)
+	assert.Equal(t, int64(1), status.Errors)
+	assert.Equal(t, "malformed event", status.LastError)
+	require.NotNil(t, status.LastProcessedAt)
+	assert.Equal(t, clock.now.Add(-time.Second), *status.LastProcessedAt)
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerProcessedTotal.WithLabelValues("test_wrap")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerErrorsTotal.WithLabelValues("test_wrap")))
+
+	// A nil consumer, of a component running without a registry, records nothing
+	var unregistered *Registry
+	require.NoError(t, Wrap(unregistered.Register("none", ConsumerOptions{}), func(ctx context.Context, event string) error {
+		return nil
+	})(context.Background(), "good"))
+}
+
+func TestRegistry_FlagsStalledStreamConsumer(t *testing.T) {
+	registry, clock := newTestRegistry(t)
+	var lag int64
+	consumer := registry.Register("test_stream", ConsumerOptions{
+		Lag: func(ctx context.Context) (int64, error) { return lag, nil },
+	})
+	handle := Wrap(consumer, func(ctx context.Context, event int) error { return nil })
+	require.NoError(t, handle(context.Background(), 1))
+
+	// Idle with nothing waiting is not stalled
+	clock.advance(5 * time.Minute)
+	assert.Empty(t, registry.Check(context.Background()))
+
+	// Entries pile up while the consumer processes nothing
+	lag = 12
+	assert.Empty(t, registry.Check(context.Background()), "idle time before entries waited does not count")
+	clock.advance(61 * time.Second)
+	stalled := registry.Check(context.Background())
+	require.Len(t, stalled, 1)
+	assert.Equal(t, "test_stream", stalled[0].Name)
+	assert.Equal(t, int64(12), stalled[0].Lag)
+	require.NotNil(t, stalled[0].StalledSince)
+	assert.Equal(t, 12.0, testutil.ToFloat64(consumerLag.WithLabelValues("test_stream")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(consumerStalled.WithLabelValues("test_stream")))
+
+	// Still stalled on the next check, since the same time
+	clock.advance(time.Minute)
+	stalled = registry.Check(context.Background())
+	require.Len(t, stalled, 1)
+	assert.Equal(t, clock.now.Add(-time.Minute), *stalled[0].StalledSince)
+
+	// Recovery clears the flag
+	require.NoError(t, handle(context.Background(), 2))
+	lag = 0
+	assert.Empty(t, registry.Check(context.Background()))
+	assert.False(t, registry.Consumers()[0].Stalled)
+	assert.Zero(t, testutil.ToFloat64(consumerLag.WithLabelValues("test_stream")))
+	assert.Zero(t, testutil.ToFloat64(consumerStalled.WithLabelValues("test_stream")))
+}
+
+func TestRegistry_FlagsConsumerBehindItsSource(t *testing.T) {
+	registry, clock := newTestRegistry(t)
+	consumer := registry.Register("test_pubsub", ConsumerOptions{Source: "workflow:events"})
+	registry.Register("test_other", ConsumerOptions{Source: "agent:events"})
+
+	// A consumer that never processed anything is idle since it registered
+	clock.advance(30 * time.Second)
+	registry.RecordProduced(SourceOf("workflow:events:project-1"))
+	assert.Empty(t, registry.Check(context.Background()))
+	clock.advance(61 * time.Second)
+	stalled := registry.Check(context.Background())
+	require.Len(t, stalled, 1)
+	assert.Equal(t, "test_pubsub", stalled[0].Name)
+	require.NotNil(t, stalled[0].LastProducedAt)
+	assert.Equal(t, 1.0, testutil.ToFloat64(eventsProducedTotal.WithLabelValues("workflow:events")))
+
+	consumer.Processed()
+	assert.Empty(t, registry.Check(context.Background()))
+
+	// An event delivered to a consumer blocked on it
+	clock.advance(time.Second)
+	consumer.Received()
+	assert.Empty(t, registry.Check(context.Background()))
+	clock.advance(2 * time.Minute)
+	stalled = registry.Check(context.Background())
+	require.Len(t, stalled, 1)
+	assert.Equal(t, "test_pubsub", stalled[0].Name)
+}
+
+func TestRegistry_WatchdogChecksPeriodically(t *testing.T) {
+	registry := NewRegistry(&config.EventsConfig{WatchdogInterval: 1, StallThreshold: 1}, zap.NewNop())
+	registry.interval = 10 * time.Millisecond
+	registry.Register("test_watchdog", ConsumerOptions{
+		Lag: func(ctx context.Context) (int64, error) { return 3, nil },
+	})
+	registry.threshold = 0
+
+	registry.Start()
+	defer registry.Stop()
+	assert.Eventually(t, func() bool {
+		statuses := registry.Consumers()
+		return len(statuses) == 1 && statuses[0].Stalled
+	}, time.Second, 5*time.Millisecond)
+	registry.Stop()
+}
@@ -3,19 +3,29 @@ package middleware
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.uber.org/zap"
+
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/redact"
+	"orchestrator/internal/requestid"
 )
 
-// Logger middleware for request logging
-func Logger(logger *zap.Logger) gin.HandlerFunc {
+// Logger middleware for request logging. Query parameters and error values
+// the redactor selects are redacted; a nil redactor applies the default
+// field names.
+func Logger(logger *zap.Logger, redactor *redact.Redactor) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
@@ -29,10 +39,10 @@ func Logger(logger *zap.Logger) gin.HandlerFunc {
 		clientIP := c.ClientIP()
 		method := c.Request.Method
 		statusCode := c.Writer.Status()
-		errorMessage := c.Errors.ByType(gin.ErrorTypePrivate).String()
+		errorMessage := redactor.Text(c.Errors.ByType(gin.ErrorTypePrivate).String())
 
 		if raw != "" {
-			path = path + "?" + raw
+			path = path + "?" + redactor.Query(raw)
 		}
 
 		fields := []zap.Field{
@@ -42,6 +52,7 @@ func Logger(logger *zap.Logger) gin.HandlerFunc {
 			zap.Int("status_code", statusCode),
 			zap.Duration("latency", latency),
 			zap.String("user_agent", c.Request.UserAgent()),
+			zap.String(requestid.Field, requestid.FromContext(c.Request.Context())),
 		}
 
 		if errorMessage != "" {
@@ -68,16 +79,14 @@ func Recovery(logger *zap.Logger) gin.HandlerFunc {
 		defer func() {
 			if err := recover(); err != nil {
 				// Log the panic
-				logger.Error("Panic recovered",
+				requestid.Logger(c.Request.Context(), logger).Error("Panic recovered",
 					zap.Any("error", err),
 					zap.String("path", c.Request.URL.Path),
 					zap.String("method", c.Request.Method),
 				)
 
 				// Return 500 error
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-					"error": "Internal server error",
-				})
+				abortWithError(c, http.StatusInternalServerError, "Internal server error")
 			}
 		}()
 		c.Next()
@@ -105,6 +114,33 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+// PublicCORS sets CORS headers for unauthenticated endpoints, replacing those
+// of CORS. Credentials are never allowed and the origin is only echoed when
+// it is in allowedOrigins; "*" allows any origin.
+func PublicCORS(allowedOrigins []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Del("Access-Control-Allow-Credentials")
+		header.Del("Access-Control-Allow-Origin")
+		header.Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		header.Add("Vary", "Origin")
+
+		origin := c.Request.Header.Get("Origin")
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" {
+				header.Set("Access-Control-Allow-Origin", "*")
+				break
+			}
+			if origin != "" && allowed == origin {
+				header.Set("Access-Control-Allow-Origin", origin)
+				break
+			}
+		}
+
+		c.Next()
+	}
+}
+
 // Auth middleware for authentication
 func Auth(jwtSecret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -114,24 +150,21 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 			// Check for API key
 			apiKey := c.GetHeader("X-API-Key")
 			if apiKey == "" {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"error": "Missing authorization",
-				})
+				abortWithError(c, http.StatusUnauthorized, "Missing authorization")
 				return
 			}
 			
 			// Validate API key (simplified for now)
 			// In production, this would check against a database
 			if !isValidAPIKey(apiKey) {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"error": "Invalid API key",
-				})
+				abortWithError(c, http.StatusUnauthorized, "Invalid API key")
 				return
 			}
 			
 			// Set user context from API key
 			c.Set("user_id", "api_user")
 			c.Set("auth_type", "api_key")
+			c.Set("api_key_id", apiKeyID(apiKey))
 			c.Next()
 			return
 		}
@@ -141,9 +174,7 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 		if strings.HasPrefix(authHeader, "Bearer ") {
 			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
 		} else {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization format",
-			})
+			abortWithError(c, http.StatusUnauthorized, "Invalid authorization format")
 			return
 		}
 
@@ -151,9 +182,7 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 		// In production, this would properly validate JWT
 		userID, err := validateJWT(tokenString, jwtSecret)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token",
-			})
+			abortWithError(c, http.StatusUnauthorized, "Invalid token")
 			return
 		}
 
@@ -164,6 +193,30 @@ func Auth(jwtSecret string) gin.HandlerFunc {
 	}
 }
 
+// RequireAdmin rejects requests with 403 unless Auth, which must run first,
+// authenticated one of admins: a user ID, or the ID of an API key. Without
+// authentication no one is an admin.
+func RequireAdmin(admins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(admins))
+	for _, admin := range admins {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			allowed[admin] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		subject := c.GetString("user_id")
+		if c.GetString("auth_type") == "api_key" {
+			subject = c.GetString("api_key_id")
+		}
+		if subject == "" || !allowed[subject] {
+			abortWithError(c, http.StatusForbidden, "Admin access required")
+			return
+		}
+		c.Next()
+	}
+}
+
 // RateLimit middleware for rate limiting
 func RateLimit(requestsPerMinute int) gin.HandlerFunc {
 	// Simple in-memory rate limiter
@@ -187,9 +240,7 @@ func RateLimit(requestsPerMinute int) gin.HandlerFunc {
 		
 		// Check rate limit
 		if len(clients[clientIP]) >= requestsPerMinute {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded",
-			})
+			abortWithError(c, http.StatusTooManyRequests, "Rate limit exceeded")
 			return
 		}
 		
@@ -199,16 +250,19 @@ func RateLimit(requestsPerMinute int) gin.HandlerFunc {
 	}
 }
 
-// RequestID middleware for request tracking
+// RequestID middleware for request tracking. The ID is taken from the
+// X-Request-ID header or generated, and is carried in the request context so
+// logs, workflows and calls to other services can be correlated.
 func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requestID := c.GetHeader("X-Request-ID")
+		requestID := c.GetHeader(requestid.Header)
 		if requestID == "" {
-			requestID = generateRequestID()
+			requestID = requestid.New()
 		}
 		
-		c.Set("request_id", requestID)
-		c.Writer.Header().Set("X-Request-ID", requestID)
+		c.Set(requestid.Field, requestID)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), requestID))
+		c.Writer.Header().Set(requestid.Header, requestID)
 		c.Next()
 	}
 }
@@ -232,13 +286,41 @@ func Timeout(timeout time.Duration) gin.HandlerFunc {
 			// Request completed within timeout
 		case <-ctx.Done():
 			// Timeout occurred
-			c.AbortWithStatusJSON(http.StatusRequestTimeout, gin.H{
-				"error": "Request timeout",
-			})
+			abortWithError(c, http.StatusRequestTimeout, "Request timeout")
 		}
 	}
 }
 
+// Deadline applies the deadline a client sets in the X-Request-Deadline
+// header to the request context when it comes before the timeout's, so the
+// work done for the request stops when the client stops waiting. Requests
+// whose deadline has passed are refused with 504 and invalid deadlines with
+// 400.
+func Deadline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(deadline.Header)
+		if value == "" {
+			c.Next()
+			return
+		}
+		now := time.Now()
+		d, err := deadline.Parse(value, now)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !d.After(now) {
+			abortWithError(c, http.StatusGatewayTimeout, "Request deadline exceeded")
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(c.Request.Context(), d)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
 // Tracing middleware for distributed tracing
 func Tracing(serviceName string) gin.HandlerFunc {
 	return otelgin.Middleware(serviceName)
@@ -257,9 +339,7 @@ func RequestSizeLimit(maxSize int64) gin.HandlerFunc {
 		}
 		
 		if int64(len(body)) > maxSize {
-			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
-				"error": "Request body too large",
-			})
+			abortWithError(c, http.StatusRequestEntityTooLarge, "Request body too large")
 			return
 		}
 		
@@ -267,8 +347,149 @@ func RequestSizeLimit(maxSize int64) gin.HandlerFunc {
 	}
 }
 
+// HTTPFaultInjector decides the faults injected into API requests
+type HTTPFaultInjector interface {
+	// InjectHTTPFault returns the delay and the error status, 0 for none,
+	// for a request
+	InjectHTTPFault(ctx context.Context, method, route, path string) (time.Duration, int)
+}
+
+// FaultInjection delays or fails requests as the injector's rules say.
+// Requests under exemptPrefix, such as those managing the rules, are never
+// faulted.
+func FaultInjection(injector HTTPFaultInjector, exemptPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, exemptPrefix) {
+			c.Next()
+			return
+		}
+
+		delay, statusCode := injector.InjectHTTPFault(c.Request.Context(), c.Request.Method, c.FullPath(), c.Request.URL.Path)
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-c.Request.Context().Done():
+			}
+		}
+		if statusCode != 0 {
+			c.Header("X-Fault-Injected", "true")
+			abortWithError(c, statusCode, "Injected fault")
+			return
+		}
+		c.Next()
+	}
+}
+
+// MaintenanceChecker reports whether the API is in read-only maintenance
+// mode
+type MaintenanceChecker interface {
+	// Maintenance returns whether maintenance mode is on, the operator's
+	// message and how long clients should wait before retrying
+	Maintenance(ctx context.Context) (bool, string, time.Duration)
+}
+
+// Maintenance rejects POST, PUT, PATCH and DELETE requests with 503 while
+// maintenance mode is on; reads keep working. Requests to exemptRoutes,
+// route templates such as /api/v1/workflows/:id/cancel, are never rejected.
+func Maintenance(checker MaintenanceChecker, exemptRoutes ...string) gin.HandlerFunc {
+	exempt := make(map[string]bool, len(exemptRoutes))
+	for _, route := range exemptRoutes {
+		exempt[route] = true
+	}
+
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			c.Next()
+			return
+		}
+		if exempt[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		active, message, retryAfter := checker.Maintenance(c.Request.Context())
+		if !active {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+		if message != "" {
+			abortWithError(c, http.StatusServiceUnavailable, "Service is in maintenance mode: "+message)
+			return
+		}
+		abortWithError(c, http.StatusServiceUnavailable, "Service is in maintenance mode")
+	}
+}
+
+// UsageRecorder meters API usage for billing
+type UsageRecorder interface {
+	// WithSubject returns ctx carrying who the usage of a request is billed to
+	WithSubject(ctx context.Context, subject string) context.Context
+	// RecordRequest counts a request of a route class against a project, ""
+	// when the request names none
+	RecordRequest(ctx context.Context, projectID, routeClass string)
+}
+
+// UsageMetering counts requests per project, route class and subject: the
+// API key or user authenticated by Auth, which must run first. Work the
+// request starts, such as workflows, is billed to the same subject.
+func UsageMetering(recorder UsageRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(recorder.WithSubject(c.Request.Context(), usageSubject(c)))
+		c.Next()
+		recorder.RecordRequest(c.Request.Context(), requestProjectID(c), routeClass(c.FullPath()))
+	}
+}
+
+// usageSubject returns who a request is billed to
+func usageSubject(c *gin.Context) string {
+	if keyID := c.GetString("api_key_id"); keyID != "" {
+		return "api_key:" + keyID
+	}
+	if userID := c.GetString("user_id"); userID != "" {
+		return "user:" + userID
+	}
+	return "anonymous"
+}
+
+// requestProjectID returns the project a request names in its project_id
+// query or route parameter, or as the project it addresses
+func requestProjectID(c *gin.Context) string {
+	if projectID := c.Query("project_id"); projectID != "" {
+		return projectID
+	}
+	if projectID := c.Param("project_id"); projectID != "" {
+		return projectID
+	}
+	if strings.HasPrefix(c.FullPath(), "/api/v1/projects/:id") {
+		return c.Param("id")
+	}
+	return ""
+}
+
+// routeClass returns the resource a route template is under, e.g. workflows
+// for /api/v1/workflows/:id/cancel, or unmatched for unknown routes
+func routeClass(route string) string {
+	if route == "" {
+		return "unmatched"
+	}
+	parts := strings.Split(strings.TrimPrefix(route, "/"), "/")
+	if len(parts) >= 3 && parts[0] == "api" && strings.HasPrefix(parts[1], "v") {
+		return parts[2]
+	}
+	return parts[0]
+}
+
 // Helper functions
 
+// apiKeyID returns a fingerprint identifying an API key without revealing it
+func apiKeyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:6])
+}
+
 func isValidAPIKey(apiKey string) bool {
 	// Simplified validation
 	// In production, check against database
@@ -294,8 +515,11 @@ func validateJWT(token, secret string) (string, error) {
 	return "", fmt.Errorf("invalid token")
 }
 
-func generateRequestID() string {
-	// Simple request ID generation
-	// In production, use UUID
-	return fmt.Sprintf("req-%d", time.Now().UnixNano())
+// abortWithError aborts the request with an error body that includes the
+// request ID
+func abortWithError(c *gin.Context, statusCode int, message string) {
+	c.AbortWithStatusJSON(statusCode, gin.H{
+		"error":         message,
+		requestid.Field: requestid.FromContext(c.Request.Context()),
+	})
 }
\ No newline at end of file
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AgentTaskOutcome is the outcome of a task an agent ran, for one of the
+// capabilities the task required. A task is recorded once per workflow, so
+// replays of the recording activity add nothing.
+type AgentTaskOutcome struct {
+	WorkflowID string    `gorm:"type:uuid;primaryKey" json:"workflow_id"`
+	TaskID     string    `gorm:"primaryKey" json:"task_id"`
+	Capability string    `gorm:"primaryKey;index:idx_agent_task_outcome_agent" json:"capability"` // Lowercased
+	AgentID    string    `gorm:"not null;index:idx_agent_task_outcome_agent" json:"agent_id"`
+	Succeeded  bool      `gorm:"not null" json:"succeeded"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName specifies the table name for AgentTaskOutcome
+func (AgentTaskOutcome) TableName() string {
+	return "agent_task_outcomes"
+}
+
+// TaskQualityScore is the quality score of a task an agent ran, between 0
+// and 1, with the signals it was computed from. A task is scored once per
+// workflow, so replays of the scoring activity add nothing.
+type TaskQualityScore struct {
+	WorkflowID string          `gorm:"type:uuid;primaryKey" json:"workflow_id"`
+	TaskID     string          `gorm:"primaryKey" json:"task_id"`
+	ProjectID  string          `gorm:"type:uuid;index" json:"project_id"`
+	AgentID    string          `gorm:"not null;index:idx_task_quality_score_agent" json:"agent_id"`
+	TaskType   string          `json:"task_type"`
+	Score      float64         `gorm:"not null" json:"score"`
+	Scorer     string          `gorm:"not null" json:"scorer"` // "heuristic" or "reviewer"
+	Signals    json.RawMessage `gorm:"type:jsonb" json:"signals,omitempty"`
+	CreatedAt  time.Time       `gorm:"index:idx_task_quality_score_agent" json:"created_at"`
+}
+
+// TableName specifies the table name for TaskQualityScore
+func (TaskQualityScore) TableName() string {
+	return "task_quality_scores"
+}
+
+// AgentCapabilityFlag marks a capability whose observed success rate fell
+// below what the agent advertises. Weight scales the capability in agent
+// matching: the observed share of the advertised proficiency when drifting
+// capabilities are down-weighted, 1 otherwise.
+type AgentCapabilityFlag struct {
+	AgentID               string    `gorm:"primaryKey" json:"agent_id"`
+	Capability            string    `gorm:"primaryKey" json:"capability"` // Lowercased
+	AdvertisedProficiency float64   `json:"advertised_proficiency"`
+	ObservedSuccessRate   float64   `json:"observed_success_rate"`
+	Samples               int64     `json:"samples"`
+	Weight                float64   `gorm:"not null;default:1" json:"weight"`
+	FlaggedAt             time.Time `json:"flagged_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for AgentCapabilityFlag
+func (AgentCapabilityFlag) TableName() string {
+	return "agent_capability_flags"
+}
+
+// WorkflowAgent is a dynamic agent a workflow spawned or reused. The
+// orchestrator deletes the agents it spawned once no unfinished workflow
+// uses them.
+type WorkflowAgent struct {
+	WorkflowID string     `gorm:"type:uuid;primaryKey" json:"workflow_id"`
+	AgentID    string     `gorm:"primaryKey;index" json:"agent_id"`
+	Spawned    bool       `gorm:"not null" json:"spawned"` // False when the workflow reused an agent spawned earlier
+	TTLSeconds int        `json:"ttl_seconds"`             // Lifetime the agent was spawned with; 0 when not TTL-scoped
+	ReleasedAt *time.Time `gorm:"index" json:"released_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// TableName specifies the table name for WorkflowAgent
+func (WorkflowAgent) TableName() string {
+	return "workflow_agents"
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AnalysisResult is the report of a code analysis of a repository at a
+// commit, reused by later analyses of the same commit while the analyzers
+// are at the same version
+type AnalysisResult struct {
+	// Key is a hash of the repository, commit, path, file globs and analysis
+	// types analyzed
+	Key             string          `gorm:"primaryKey" json:"key"`
+	Repository      string          `gorm:"not null" json:"repository"`
+	Commit          string          `gorm:"not null;index" json:"commit"`
+	Types           string          `json:"types"` // Sorted and comma separated; empty for every type
+	AnalyzerVersion string          `gorm:"not null" json:"analyzer_version"`
+	Report          json.RawMessage `gorm:"type:jsonb" json:"report"`
+	ProjectID       string          `gorm:"type:uuid;index" json:"project_id"` // Project of the analysis that produced the report
+	WorkflowID      string          `gorm:"type:uuid" json:"workflow_id"`      // Workflow that produced the report
+	CreatedAt       time.Time       `json:"created_at"`
+	ExpiresAt       time.Time       `gorm:"index" json:"expires_at"`
+}
+
+// TableName specifies the table name for AnalysisResult
+func (AnalysisResult) TableName() string {
+	return "analysis_results"
+}
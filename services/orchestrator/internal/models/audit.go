@@ -0,0 +1,22 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditLog records an action taken on an entity, by a user or by the system
+type AuditLog struct {
+	ID         string          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	EntityType string          `gorm:"not null;index:idx_audit_entity" json:"entity_type"`
+	EntityID   string          `gorm:"not null;index:idx_audit_entity" json:"entity_id"`
+	Action     string          `gorm:"not null;index" json:"action"`
+	Actor      string          `gorm:"not null" json:"actor"`
+	Details    json.RawMessage `gorm:"type:jsonb" json:"details,omitempty"`
+	CreatedAt  time.Time       `gorm:"index" json:"created_at"`
+}
+
+// TableName specifies the table name for AuditLog
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
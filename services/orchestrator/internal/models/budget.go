@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// BudgetPolicy is what happens to an intent whose estimates exceed its
+// budget
+type BudgetPolicy string
+
+const (
+	// BudgetPolicyWarn publishes a warning and runs the intent anyway
+	BudgetPolicyWarn BudgetPolicy = "warn"
+	// BudgetPolicyFail fails the workflow before the intent is planned
+	BudgetPolicyFail BudgetPolicy = "fail"
+)
+
+// IsValid reports whether the policy is a known budget policy
+func (p BudgetPolicy) IsValid() bool {
+	return p == BudgetPolicyWarn || p == BudgetPolicyFail
+}
+
+// WorkflowBudget limits the estimated cost and duration of an intent. Zero
+// limits are unlimited.
+type WorkflowBudget struct {
+	MaxCost            float64      `json:"max_cost,omitempty"`
+	MaxDurationSeconds int          `json:"max_duration_seconds,omitempty"`
+	Policy             BudgetPolicy `json:"policy,omitempty"` // Empty uses the configured policy
+}
+
+// IsZero reports whether the budget sets no limit
+func (b *WorkflowBudget) IsZero() bool {
+	return b == nil || (b.MaxCost == 0 && b.MaxDurationSeconds == 0)
+}
+
+// Value implements driver.Valuer
+func (b *WorkflowBudget) Value() (driver.Value, error) {
+	if b == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (b *WorkflowBudget) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*b = WorkflowBudget{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), b)
+	case []byte:
+		return json.Unmarshal(v, b)
+	default:
+		return fmt.Errorf("cannot scan %T into WorkflowBudget", src)
+	}
+}
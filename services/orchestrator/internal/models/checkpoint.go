@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CheckpointPhase names the workflow phase whose output a checkpoint keeps
+type CheckpointPhase string
+
+const (
+	CheckpointPhaseAnalysis CheckpointPhase = "analysis" // Intent analysis result
+	CheckpointPhasePlan     CheckpointPhase = "plan"     // Execution plan
+)
+
+// WorkflowCheckpoint is the output of a phase of an intent workflow, kept so
+// a re-run can resume after the phase instead of repeating it
+type WorkflowCheckpoint struct {
+	ID            string          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	WorkflowID    string          `gorm:"type:uuid;not null;uniqueIndex:idx_workflow_checkpoint_phase" json:"workflow_id"`
+	Phase         CheckpointPhase `gorm:"not null;uniqueIndex:idx_workflow_checkpoint_phase" json:"phase"`
+	SchemaVersion int             `gorm:"not null" json:"schema_version"` // Version of the phase output's format
+	Data          json.RawMessage `gorm:"type:jsonb" json:"data"`
+	CreatedAt     time.Time       `gorm:"index" json:"created_at"`
+}
+
+// TableName specifies the table name for WorkflowCheckpoint
+func (WorkflowCheckpoint) TableName() string {
+	return "workflow_checkpoints"
+}
+
+// CheckpointRef points a re-run at the checkpoints of an earlier workflow.
+// The re-run resumes after From, reusing the outputs of the phases up to it.
+type CheckpointRef struct {
+	WorkflowID string          `json:"workflow_id"`
+	From       CheckpointPhase `json:"from"`
+}
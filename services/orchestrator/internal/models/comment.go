@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// WorkflowComment is an operator note on a workflow. The body is Markdown.
+type WorkflowComment struct {
+	ID         string    `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	WorkflowID string    `gorm:"type:uuid;not null;index:idx_workflow_comment_order" json:"workflow_id"`
+	Author     string    `gorm:"not null" json:"author"`
+	Body       string    `gorm:"type:text;not null" json:"body"`
+	Pinned     bool      `gorm:"default:false;index:idx_workflow_comment_order" json:"pinned"`
+	CreatedAt  time.Time `gorm:"index:idx_workflow_comment_order" json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// Mentions are the workflows the body references as wf:<id>
+	Mentions []WorkflowMention `gorm:"-" json:"mentions,omitempty"`
+}
+
+// TableName specifies the table name for WorkflowComment
+func (WorkflowComment) TableName() string {
+	return "workflow_comments"
+}
+
+// WorkflowMention is a link to a workflow mentioned in a comment
+type WorkflowMention struct {
+	WorkflowID string `json:"workflow_id"`
+	Link       string `json:"link"`
+}
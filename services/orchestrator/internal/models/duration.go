@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// HumanDuration returns the human-readable form of a duration in
+// milliseconds, e.g. "1m30.5s", or an empty string for no duration
+func HumanDuration(ms int64) string {
+	if ms <= 0 {
+		return ""
+	}
+	return (time.Duration(ms) * time.Millisecond).String()
+}
+
+// elapsedMillis returns the milliseconds from start to end
+func elapsedMillis(start, end time.Time) int64 {
+	return end.Sub(start).Milliseconds()
+}
+
+// legacyDuration reads the "duration" field that preceded "duration_ms".
+// Deprecated: accepted on input for one release only.
+func legacyDuration(data []byte) (int64, bool) {
+	var fields struct {
+		Duration json.RawMessage `json:"duration"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil || fields.Duration == nil {
+		return 0, false
+	}
+	var value int64
+	if err := json.Unmarshal(fields.Duration, &value); err != nil {
+		return 0, false
+	}
+	return value, true
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkflowDurationSerialization(t *testing.T) {
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	completed := started.Add(90*time.Second + 250*time.Millisecond)
+	workflow := &Workflow{ID: "workflow-1", StartedAt: &started, CompletedAt: &completed}
+	require.NoError(t, workflow.BeforeUpdate(nil))
+
+	data, err := json.Marshal(workflow)
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.EqualValues(t, 90250, fields["duration_ms"])
+	assert.Equal(t, "1m30.25s", fields["duration_text"])
+	assert.NotContains(t, fields, "duration")
+	assert.Equal(t, "2024-03-01T12:00:00Z", fields["started_at"])
+
+	var decoded Workflow
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.EqualValues(t, 90250, decoded.Duration)
+}
+
+func TestLegacyDurationInput(t *testing.T) {
+	var workflow Workflow
+	require.NoError(t, json.Unmarshal([]byte(`{"id": "workflow-1", "duration": 300}`), &workflow))
+	assert.EqualValues(t, 300000, workflow.Duration, "workflow durations were seconds")
+	assert.Equal(t, "5m0s", workflow.DurationText)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"id": "workflow-2", "duration": 300, "duration_ms": 1500}`), &workflow))
+	assert.EqualValues(t, 1500, workflow.Duration, "duration_ms wins over the old field")
+
+	var execution Execution
+	require.NoError(t, json.Unmarshal([]byte(`{"id": "execution-1", "duration": 1500}`), &execution))
+	assert.EqualValues(t, 1500, execution.Duration)
+
+	var step WorkflowStep
+	require.NoError(t, json.Unmarshal([]byte(`{"id": "step-1", "duration": "45s"}`), &step))
+	assert.Zero(t, step.Duration, "values that are not numbers are ignored")
+
+	var nested Workflow
+	require.NoError(t, json.Unmarshal([]byte(`{"id": "workflow-3", "steps": [{"duration": 45000}], "executions": [{"duration": 44000}]}`), &nested))
+	assert.EqualValues(t, 45000, nested.Steps[0].Duration)
+	assert.EqualValues(t, 44000, nested.Executions[0].Duration)
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -20,8 +21,59 @@ const (
 	ExecutionStatusTimedOut   ExecutionStatus = "timed_out"
 	ExecutionStatusSkipped    ExecutionStatus = "skipped"
 	ExecutionStatusRetrying   ExecutionStatus = "retrying"
+
+	// ExecutionStatusBelowThreshold ends a refined task whose best round
+	// scored below the acceptance threshold
+	ExecutionStatusBelowThreshold ExecutionStatus = "below_threshold"
 )
 
+// executionStatusAliases maps statuses reported by agents or stored by older
+// versions to the canonical status
+var executionStatusAliases = map[string]ExecutionStatus{
+	"completed":  ExecutionStatusSucceeded,
+	"complete":   ExecutionStatusSucceeded,
+	"success":    ExecutionStatusSucceeded,
+	"successful": ExecutionStatusSucceeded,
+	"done":       ExecutionStatusSucceeded,
+	"error":      ExecutionStatusFailed,
+	"errored":    ExecutionStatusFailed,
+	"failure":    ExecutionStatusFailed,
+	"canceled":   ExecutionStatusCancelled,
+	"timeout":    ExecutionStatusTimedOut,
+}
+
+// ParseExecutionStatus normalizes a status string into an ExecutionStatus.
+// ok is false for unknown statuses, which are returned unchanged.
+func ParseExecutionStatus(status string) (ExecutionStatus, bool) {
+	normalized := strings.ToLower(strings.TrimSpace(status))
+	switch s := ExecutionStatus(normalized); s {
+	case ExecutionStatusPending, ExecutionStatusQueued, ExecutionStatusRunning, ExecutionStatusSucceeded,
+		ExecutionStatusFailed, ExecutionStatusCancelled, ExecutionStatusTimedOut, ExecutionStatusSkipped,
+		ExecutionStatusRetrying, ExecutionStatusBelowThreshold:
+		return s, true
+	}
+	if s, ok := executionStatusAliases[normalized]; ok {
+		return s, true
+	}
+	return ExecutionStatus(status), false
+}
+
+// IsSuccess returns true if the status is a successful outcome
+func (s ExecutionStatus) IsSuccess() bool {
+	return s == ExecutionStatusSucceeded
+}
+
+// UnmarshalJSON normalizes legacy status strings such as "completed" so
+// payloads stored before the statuses were unified decode consistently
+func (s *ExecutionStatus) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s, _ = ParseExecutionStatus(raw)
+	return nil
+}
+
 // ExecutionType represents different types of executions
 type ExecutionType string
 
@@ -48,19 +100,23 @@ type Execution struct {
 	Status           ExecutionStatus `gorm:"not null;default:'pending';index" json:"status"`
 	Language         string          `json:"language,omitempty"`
 	Runtime          string          `json:"runtime,omitempty"`
-	Code             string          `gorm:"type:text" json:"code,omitempty"`
+	Code             string          `gorm:"type:text;encrypted" json:"code,omitempty"`
 	Script           string          `gorm:"type:text" json:"script,omitempty"`
 	Command          string          `json:"command,omitempty"`
 	Arguments        []string        `gorm:"type:text[]" json:"arguments,omitempty"`
 	Environment      json.RawMessage `gorm:"type:jsonb" json:"environment,omitempty"`
 	Input            json.RawMessage `gorm:"type:jsonb" json:"input,omitempty"`
-	Output           json.RawMessage `gorm:"type:jsonb" json:"output,omitempty"`
+	Output           json.RawMessage `gorm:"type:jsonb;encrypted" json:"output,omitempty"` // Inline, or an OffloadedOutput when too large
+	FullOutputURL    string          `gorm:"-" json:"full_output_url,omitempty"`               // Set by the API when the output was offloaded
 	Logs             string          `gorm:"type:text" json:"logs,omitempty"`
 	Error            string          `gorm:"type:text" json:"error,omitempty"`
+	ErrorSignature   string          `gorm:"type:varchar(512);index" json:"error_signature,omitempty"` // Error with IDs and timestamps normalized away
+	ErrorClass       string          `gorm:"type:varchar(32);index" json:"error_class,omitempty"`      // Kind of failure: timeout, auth, quota, validation, crash or unknown
 	ExitCode         *int            `json:"exit_code,omitempty"`
 	StartedAt        *time.Time      `json:"started_at,omitempty"`
 	CompletedAt      *time.Time      `json:"completed_at,omitempty"`
-	Duration         int64           `json:"duration,omitempty"` // Duration in milliseconds
+	Duration         int64           `json:"duration_ms,omitempty"` // Duration in milliseconds
+	DurationText     string          `gorm:"-" json:"duration_text,omitempty"`
 	TimeoutSeconds   int             `gorm:"default:300" json:"timeout_seconds"`
 	RetryCount       int             `gorm:"default:0" json:"retry_count"`
 	MaxRetries       int             `gorm:"default:3" json:"max_retries"`
@@ -69,6 +125,7 @@ type Execution struct {
 	Metadata         json.RawMessage `gorm:"type:jsonb" json:"metadata,omitempty"`
 	Tags             []string        `gorm:"type:text[]" json:"tags,omitempty"`
 	Priority         int             `gorm:"default:0" json:"priority"`
+	Simulated        bool            `gorm:"default:false;index" json:"simulated"` // Produced by a simulated workflow; excluded from stats
 	QueuedAt         *time.Time      `json:"queued_at,omitempty"`
 	ScheduledAt      *time.Time      `json:"scheduled_at,omitempty"`
 	CreatedBy        string          `json:"created_by"`
@@ -101,6 +158,19 @@ type Artifact struct {
 	UpdatedAt    time.Time       `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt  `gorm:"index" json:"deleted_at,omitempty"`
 
+	// Artifacts of a workflow with the same logical key, their path within
+	// the task or workflow, are versions of one file. Each version links to
+	// the version it replaced.
+	WorkflowID       string  `gorm:"index:idx_artifacts_workflow_key" json:"workflow_id,omitempty"`
+	LogicalKey       string  `gorm:"index:idx_artifacts_workflow_key" json:"logical_key,omitempty"`
+	Version          int     `gorm:"not null;default:1" json:"version"`
+	ParentArtifactID *string `gorm:"type:uuid;index" json:"parent_artifact_id,omitempty"`
+
+	// ContentHead is the start of the content, kept when the artifact is
+	// recorded so previews need not read artifact storage. It is only read
+	// by previews, and encrypted like the code of its execution.
+	ContentHead []byte `gorm:"type:bytea;<-:create;->:false;encrypted" json:"-"`
+
 	// Relationships
 	Execution *Execution `gorm:"foreignKey:ExecutionID" json:"execution,omitempty"`
 }
@@ -108,13 +178,13 @@ type Artifact struct {
 // Metric represents a metric collected during execution
 type Metric struct {
 	ID          string          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	ExecutionID string          `gorm:"type:uuid;not null;index" json:"execution_id"`
-	Name        string          `gorm:"not null;index" json:"name"`
+	ExecutionID string          `gorm:"type:uuid;not null;index;index:idx_metrics_series,priority:1" json:"execution_id"`
+	Name        string          `gorm:"not null;index;index:idx_metrics_series,priority:2" json:"name"`
 	Value       float64         `gorm:"not null" json:"value"`
 	Unit        string          `json:"unit,omitempty"`
 	Type        string          `gorm:"default:'gauge'" json:"type"`
 	Tags        json.RawMessage `gorm:"type:jsonb" json:"tags,omitempty"`
-	Timestamp   time.Time       `gorm:"not null;index" json:"timestamp"`
+	Timestamp   time.Time       `gorm:"not null;index;index:idx_metrics_series,priority:3" json:"timestamp"`
 	CreatedAt   time.Time       `json:"created_at"`
 
 	// Relationships
@@ -209,8 +279,28 @@ func (e *Execution) BeforeCreate(tx *gorm.DB) error {
 // BeforeUpdate hook to calculate duration
 func (e *Execution) BeforeUpdate(tx *gorm.DB) error {
 	if e.StartedAt != nil && e.CompletedAt != nil {
-		e.Duration = int64(e.CompletedAt.Sub(*e.StartedAt).Milliseconds())
+		e.Duration = elapsedMillis(*e.StartedAt, *e.CompletedAt)
+	}
+	e.DurationText = HumanDuration(e.Duration)
+	return nil
+}
+
+// AfterFind hook to derive the duration text
+func (e *Execution) AfterFind(tx *gorm.DB) error {
+	e.DurationText = HumanDuration(e.Duration)
+	return nil
+}
+
+// UnmarshalJSON also accepts the deprecated "duration" field
+func (e *Execution) UnmarshalJSON(data []byte) error {
+	type plain Execution
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	if ms, ok := legacyDuration(data); ok && e.Duration == 0 {
+		e.Duration = ms
 	}
+	e.DurationText = HumanDuration(e.Duration)
 	return nil
 }
 
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// IntentAnalysis is the Intent Processor's analysis of a workflow's intent,
+// kept for analytics after the execution plan has been built
+type IntentAnalysis struct {
+	ID             string          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	WorkflowID     string          `gorm:"type:uuid;not null;index" json:"workflow_id"`
+	ProjectID      string          `gorm:"type:uuid;index:idx_intent_analysis_project" json:"project_id"`
+	IntentType     string          `gorm:"not null;index" json:"intent_type"`
+	Confidence     float64         `json:"confidence"`
+	Entities       json.RawMessage `gorm:"type:jsonb" json:"entities,omitempty"`
+	RequiredParams StringArray     `gorm:"type:text[]" json:"required_params,omitempty"`
+	OptionalParams StringArray     `gorm:"type:text[]" json:"optional_params,omitempty"`
+	Risks          StringArray     `gorm:"type:text[]" json:"risks,omitempty"`
+	EstimatedTime  int             `json:"estimated_time"` // Estimated duration in seconds
+	EstimatedCost  float64         `json:"estimated_cost"`
+	LowConfidence  bool            `gorm:"default:false" json:"low_confidence"`
+	CreatedAt      time.Time       `gorm:"index:idx_intent_analysis_project" json:"created_at"`
+}
+
+// TableName specifies the table name for IntentAnalysis
+func (IntentAnalysis) TableName() string {
+	return "intent_analyses"
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// OutputArtifactType is the type of the artifacts holding outputs too large
+// to store inline
+const OutputArtifactType = "output"
+
+// OffloadedOutput stands in for an output too large to store inline. The full
+// output is in artifact storage under OutputRef; a preview of its first and
+// last bytes, with a truncation marker in between, stays inline.
+type OffloadedOutput struct {
+	Truncated  bool   `json:"truncated"`
+	OutputRef  string `json:"output_ref"` // Key of the full output in the output store
+	ArtifactID string `json:"artifact_id,omitempty"`
+	Size       int    `json:"size"`     // Bytes of the full output
+	Checksum   string `json:"checksum"` // SHA-256 of the full output
+	Preview    string `json:"preview"`
+}
+
+// ParseOffloadedOutput returns the offloaded form of an output, or false for
+// outputs stored inline
+func ParseOffloadedOutput(output []byte) (*OffloadedOutput, bool) {
+	if !bytes.Contains(output, []byte(`"output_ref"`)) {
+		return nil, false
+	}
+	var offloaded OffloadedOutput
+	if err := json.Unmarshal(output, &offloaded); err != nil || !offloaded.Truncated || offloaded.OutputRef == "" {
+		return nil, false
+	}
+	return &offloaded, true
+}
+
+// ResultArchiveArtifactType is the type of the artifacts holding the full
+// results of compacted workflows, gzip-compressed
+const ResultArchiveArtifactType = "result_archive"
+
+// ResultArchive is kept, under the "archive" key, in the output of a
+// workflow whose task results were compacted. The output as it was before
+// compaction is in artifact storage under ArchiveRef, gzip-compressed.
+type ResultArchive struct {
+	ArchiveRef     string    `json:"archive_ref"` // Key of the compressed result in the output store
+	ArtifactID     string    `json:"artifact_id,omitempty"`
+	Size           int       `json:"size"`            // Bytes of the full result
+	CompressedSize int       `json:"compressed_size"` // Bytes of the archive
+	Checksum       string    `json:"checksum"`        // SHA-256 of the full result
+	CompactedAt    time.Time `json:"compacted_at"`
+}
+
+// ParseResultArchive returns the archive of a compacted workflow output, or
+// false for outputs that were not compacted
+func ParseResultArchive(output []byte) (*ResultArchive, bool) {
+	if !bytes.Contains(output, []byte(`"archive_ref"`)) {
+		return nil, false
+	}
+	var compacted struct {
+		Archive *ResultArchive `json:"archive"`
+	}
+	if err := json.Unmarshal(output, &compacted); err != nil || compacted.Archive == nil || compacted.Archive.ArchiveRef == "" {
+		return nil, false
+	}
+	return compacted.Archive, true
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -18,6 +19,17 @@ const (
 	ProjectStatusInitializing ProjectStatus = "initializing"
 )
 
+// ResourceStatus represents the lifecycle state of a project resource
+type ResourceStatus string
+
+const (
+	ResourceStatusProvisioning ResourceStatus = "provisioning"
+	ResourceStatusReady        ResourceStatus = "ready"
+	ResourceStatusFailed       ResourceStatus = "failed"
+	ResourceStatusTerminating  ResourceStatus = "terminating"
+	ResourceStatusTerminated   ResourceStatus = "terminated"
+)
+
 // ProjectType represents different types of projects
 type ProjectType string
 
@@ -51,7 +63,7 @@ type Project struct {
 	BuildConfig      json.RawMessage `gorm:"type:jsonb" json:"build_config,omitempty"`
 	DeploymentConfig json.RawMessage `gorm:"type:jsonb" json:"deployment_config,omitempty"`
 	EnvironmentVars  json.RawMessage `gorm:"type:jsonb" json:"environment_vars,omitempty"`
-	Secrets          json.RawMessage `gorm:"type:jsonb" json:"secrets,omitempty"`
+	Secrets          json.RawMessage `gorm:"type:jsonb" json:"-"` // Encrypted values, never returned by the API
 	CreatedBy        string          `json:"created_by"`
 	UpdatedBy        string          `json:"updated_by"`
 	LastActivityAt   *time.Time      `json:"last_activity_at,omitempty"`
@@ -68,6 +80,109 @@ type Project struct {
 	Integrations   []Integration   `gorm:"foreignKey:ProjectID" json:"integrations,omitempty"`
 }
 
+// ProjectSettings is the typed subset of Project.Settings the orchestrator acts on
+type ProjectSettings struct {
+	// EncryptAtRest encrypts workflow and execution payloads in the database
+	EncryptAtRest bool `json:"encrypt_at_rest"`
+	// RiskPolicy extends the global risk policy: its rules are matched
+	// first, and its default action replaces the global one
+	RiskPolicy *RiskPolicy `json:"risk_policy,omitempty"`
+	// SchedulingWeight is the project's weight in fair-share scheduling;
+	// 0 means the configured default weight
+	SchedulingWeight float64 `json:"scheduling_weight,omitempty"`
+	// DefaultBudget applies to intent workflows started without a budget
+	DefaultBudget *WorkflowBudget `json:"default_budget,omitempty"`
+	// Redaction adds to the global redaction rules for the project's
+	// workflow events and reports
+	Redaction *RedactionRules `json:"redaction,omitempty"`
+	// SandboxPolicy restricts the workflow and task types the project may
+	// run; its lists replace those of the organization and global defaults
+	SandboxPolicy *SandboxPolicy `json:"sandbox_policy,omitempty"`
+	// AgentPreferences steer the selection of agents for the project's tasks
+	AgentPreferences *AgentPreferences `json:"agent_preferences,omitempty"`
+	// Region pins the project's workflows to a data residency region: only
+	// agents reporting the region take their tasks, and their artifacts are
+	// kept in the region's bucket
+	Region string `json:"region,omitempty"`
+}
+
+// AgentPreferences are the agents a project prefers and those it never
+// uses. Excluded agents are left out before agents are scored; preferred
+// agents, by ID or type, have their capability match score raised by the
+// boost and win ties.
+type AgentPreferences struct {
+	PreferredAgentIDs   []string `json:"preferred_agent_ids,omitempty"`
+	PreferredAgentTypes []string `json:"preferred_agent_types,omitempty"`
+	ExcludedAgentIDs    []string `json:"excluded_agent_ids,omitempty"`
+	// Boost is added to the capability match score, 0-1, of preferred
+	// agents; 0 means the configured default
+	Boost float64 `json:"boost,omitempty"`
+}
+
+// SandboxPolicy lists the workflow types a project may start and the task
+// types its task execution workflows may run. An empty list allows every
+// type.
+type SandboxPolicy struct {
+	AllowedWorkflowTypes []WorkflowType `json:"allowed_workflow_types,omitempty"`
+	AllowedTaskTypes     []string       `json:"allowed_task_types,omitempty"`
+}
+
+// AllowsWorkflowType reports whether the policy allows a workflow type
+func (p *SandboxPolicy) AllowsWorkflowType(workflowType WorkflowType) bool {
+	if p == nil || len(p.AllowedWorkflowTypes) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedWorkflowTypes {
+		if allowed == workflowType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsTaskType reports whether the policy allows a task type
+func (p *SandboxPolicy) AllowsTaskType(taskType string) bool {
+	if p == nil || len(p.AllowedTaskTypes) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedTaskTypes {
+		if allowed == taskType {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactionRules select the values redacted from data leaving the
+// orchestrator: field names match any key ending with them, paths are JSON
+// paths such as "$.deploy.targets[*].host"
+type RedactionRules struct {
+	Fields []string `json:"fields,omitempty"`
+	Paths  []string `json:"paths,omitempty"`
+}
+
+// ParseProjectSettings decodes project settings. Empty settings yield zero values.
+func ParseProjectSettings(raw []byte) (ProjectSettings, error) {
+	var settings ProjectSettings
+	if len(raw) == 0 || string(raw) == "null" {
+		return settings, nil
+	}
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return settings, fmt.Errorf("failed to parse project settings: %w", err)
+	}
+	return settings, nil
+}
+
+// AgentPreferences returns the agent preferences the project's settings
+// set, nil when they set none
+func (p *Project) AgentPreferences() (*AgentPreferences, error) {
+	settings, err := ParseProjectSettings(p.Settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings.AgentPreferences, nil
+}
+
 // ProjectMember represents a member of a project
 type ProjectMember struct {
 	ID        string         `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -100,6 +215,11 @@ type Environment struct {
 	HealthCheckURL  string          `json:"health_check_url,omitempty"`
 	LastDeployedAt  *time.Time      `json:"last_deployed_at,omitempty"`
 	LastDeployedBy  string          `json:"last_deployed_by,omitempty"`
+	// Promotion marker: the environment last promoted into this one, when,
+	// and the checksum of the config and variables the promotion left
+	PromotedFrom      string     `json:"promoted_from,omitempty"`
+	PromotedAt        *time.Time `json:"promoted_at,omitempty"`
+	PromotionChecksum string     `json:"promotion_checksum,omitempty"`
 	CreatedBy       string          `json:"created_by"`
 	CreatedAt       time.Time       `json:"created_at"`
 	UpdatedAt       time.Time       `json:"updated_at"`
@@ -117,9 +237,10 @@ type Resource struct {
 	Type          string          `gorm:"not null" json:"type"`
 	Provider      string          `json:"provider"`
 	Region        string          `json:"region,omitempty"`
-	Status        string          `gorm:"default:'provisioning'" json:"status"`
+	Status        ResourceStatus  `gorm:"default:'provisioning';index" json:"status"`
+	Error         string          `gorm:"type:text" json:"error,omitempty"` // Why provisioning or termination failed
 	Config        json.RawMessage `gorm:"type:jsonb" json:"config,omitempty"`
-	Metadata      json.RawMessage `gorm:"type:jsonb" json:"metadata,omitempty"`
+	Metadata      json.RawMessage `gorm:"type:jsonb" json:"metadata,omitempty"` // Connection details once ready
 	Cost          json.RawMessage `gorm:"type:jsonb" json:"cost,omitempty"`
 	Usage         json.RawMessage `gorm:"type:jsonb" json:"usage,omitempty"`
 	Limits        json.RawMessage `gorm:"type:jsonb" json:"limits,omitempty"`
@@ -169,6 +290,7 @@ type ProjectStats struct {
 	TotalResources    int64     `json:"total_resources"`
 	TotalIntegrations int64     `json:"total_integrations"`
 	StorageUsed       int64     `json:"storage_used"`
+	StorageQuota      int64     `json:"storage_quota,omitempty"` // Zero is unlimited
 	ComputeHours      float64   `json:"compute_hours"`
 	EstimatedCost     float64   `json:"estimated_cost"`
 	LastActivityAt    time.Time `json:"last_activity_at"`
@@ -238,36 +360,42 @@ func (p *Project) HasFeature(feature string) bool {
 	return false
 }
 
+// member returns the active member of the project with the user ID.
+// Queries and preloads leave deleted members out of Members; members
+// deleted after loading are skipped as well.
+func (p *Project) member(userID string) *ProjectMember {
+	for i := range p.Members {
+		if p.Members[i].UserID == userID && !p.Members[i].DeletedAt.Valid {
+			return &p.Members[i]
+		}
+	}
+	return nil
+}
+
 // GetMemberRole returns the role of a member in the project
 func (p *Project) GetMemberRole(userID string) string {
-	for _, member := range p.Members {
-		if member.UserID == userID && member.DeletedAt.Time.IsZero() {
-			return member.Role
-		}
+	if member := p.member(userID); member != nil {
+		return member.Role
 	}
 	return ""
 }
 
 // HasPermission checks if a user has a specific permission in the project
 func (p *Project) HasPermission(userID string, permission string) bool {
-	role := p.GetMemberRole(userID)
-	if role == "" {
+	member := p.member(userID)
+	if member == nil || member.Role == "" {
 		return false
 	}
 	
 	// Owner has all permissions
-	if role == "owner" {
+	if member.Role == "owner" {
 		return true
 	}
 	
 	// Check specific permissions for the role
-	for _, member := range p.Members {
-		if member.UserID == userID && member.DeletedAt.Time.IsZero() {
-			for _, perm := range member.Permissions {
-				if perm == permission || perm == "*" {
-					return true
-				}
-			}
+	for _, perm := range member.Permissions {
+		if perm == permission || perm == "*" {
+			return true
 		}
 	}
 	
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// RiskAction is what a risk policy does about the risks of an intent
+type RiskAction string
+
+// Risk actions, from least to most strict
+const (
+	RiskActionAllow               RiskAction = "allow"
+	RiskActionWarn                RiskAction = "warn"
+	RiskActionRequireConfirmation RiskAction = "require_confirmation"
+	RiskActionBlock               RiskAction = "block"
+)
+
+// IsValid reports whether the action is a known risk action
+func (a RiskAction) IsValid() bool {
+	return a.Severity() >= 0
+}
+
+// Severity orders risk actions from allow (0) to block; unknown actions are -1
+func (a RiskAction) Severity() int {
+	switch a {
+	case RiskActionAllow:
+		return 0
+	case RiskActionWarn:
+		return 1
+	case RiskActionRequireConfirmation:
+		return 2
+	case RiskActionBlock:
+		return 3
+	}
+	return -1
+}
+
+// RiskRule maps risks to an action. A risk matches the rule when it is of
+// the rule's category, written "category: description", or contains one of
+// its keywords; both compare case-insensitively.
+type RiskRule struct {
+	Category string     `json:"category,omitempty"`
+	Keywords []string   `json:"keywords,omitempty"`
+	Action   RiskAction `json:"action"`
+}
+
+// Matches reports whether a risk matches the rule
+func (r RiskRule) Matches(risk string) bool {
+	risk = strings.ToLower(risk)
+	if category := strings.ToLower(strings.TrimSpace(r.Category)); category != "" {
+		if risk == category || strings.HasPrefix(risk, category+":") {
+			return true
+		}
+	}
+	for _, keyword := range r.Keywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" && strings.Contains(risk, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// RiskPolicy maps the risks intent analysis reports to actions. The first
+// rule a risk matches decides its action.
+type RiskPolicy struct {
+	Rules         []RiskRule `json:"rules,omitempty"`
+	DefaultAction RiskAction `json:"default_action,omitempty"` // Action for risks no rule matches
+}
+
+// RiskReviewStatus is where the confirmation of an intent's risks stands
+type RiskReviewStatus string
+
+const (
+	RiskReviewPending   RiskReviewStatus = "pending"
+	RiskReviewConfirmed RiskReviewStatus = "confirmed"
+	RiskReviewDenied    RiskReviewStatus = "denied"
+	RiskReviewTimedOut  RiskReviewStatus = "timed_out"
+)
+
+// IntentRiskReview is the risk policy's decision on the intent of a
+// workflow. Decisions requiring confirmation also track the confirmation.
+type IntentRiskReview struct {
+	WorkflowID     string           `gorm:"type:uuid;primaryKey" json:"workflow_id"`
+	ProjectID      string           `gorm:"type:uuid;index" json:"project_id"`
+	Action         RiskAction       `gorm:"not null" json:"action"`
+	TriggeredRisks StringArray      `gorm:"type:text[]" json:"triggered_risks,omitempty"` // Risks that led to the action
+	Status         RiskReviewStatus `gorm:"index" json:"status,omitempty"`                // Empty unless confirmation is required
+	ReviewedBy     string           `json:"reviewed_by,omitempty"`
+	Comment        string           `json:"comment,omitempty"`
+	ReviewedAt     *time.Time       `json:"reviewed_at,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+}
+
+// TableName specifies the table name for IntentRiskReview
+func (IntentRiskReview) TableName() string {
+	return "intent_risk_reviews"
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ProjectStorageUsage counts the bytes and artifacts a project stores of one
+// artifact type. Rows are kept up to date as artifacts are stored and
+// removed, and recomputed from the artifacts table on reconciliation.
+type ProjectStorageUsage struct {
+	ProjectID    string     `gorm:"type:uuid;primaryKey" json:"project_id"`
+	ArtifactType string     `gorm:"primaryKey" json:"artifact_type"`
+	Bytes        int64      `gorm:"not null;default:0" json:"bytes"`
+	Artifacts    int64      `gorm:"not null;default:0" json:"artifacts"`
+	ReconciledAt *time.Time `json:"reconciled_at,omitempty"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// TableName specifies the table name for ProjectStorageUsage
+func (ProjectStorageUsage) TableName() string {
+	return "project_storage_usage"
+}
+
+// ProjectResourceLimits are the limits set in a project's resource_limits.
+// Zero values are unlimited.
+type ProjectResourceLimits struct {
+	// MaxStorageBytes caps the bytes of artifacts the project stores
+	MaxStorageBytes int64 `json:"max_storage_bytes"`
+}
+
+// ParseProjectResourceLimits decodes project resource limits. Empty limits
+// yield zero values.
+func ParseProjectResourceLimits(raw []byte) (ProjectResourceLimits, error) {
+	var limits ProjectResourceLimits
+	if len(raw) == 0 || string(raw) == "null" {
+		return limits, nil
+	}
+	if err := json.Unmarshal(raw, &limits); err != nil {
+		return limits, fmt.Errorf("failed to parse project resource limits: %w", err)
+	}
+	return limits, nil
+}
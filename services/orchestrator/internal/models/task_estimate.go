@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// TaskDurationSample is the actual duration of one successfully executed
+// task, next to the estimate it was given. A task is sampled once per
+// workflow, so replays of the recording activity add nothing.
+type TaskDurationSample struct {
+	WorkflowID     string    `gorm:"type:uuid;primaryKey" json:"workflow_id"`
+	TaskID         string    `gorm:"primaryKey" json:"task_id"`
+	ProjectID      string    `gorm:"type:uuid;index:idx_task_duration_sample_project" json:"project_id"`
+	TaskType       string    `gorm:"not null;index:idx_task_duration_sample_key" json:"task_type"`
+	Complexity     string    `gorm:"index:idx_task_duration_sample_key" json:"complexity"`
+	EstimatedHours float64   `json:"estimated_hours"`
+	Duration       int64     `gorm:"not null" json:"duration_ms"` // Actual duration in milliseconds
+	CreatedAt      time.Time `gorm:"index:idx_task_duration_sample_project" json:"created_at"`
+}
+
+// TableName specifies the table name for TaskDurationSample
+func (TaskDurationSample) TableName() string {
+	return "task_duration_samples"
+}
+
+// ActualHours returns the actual duration in hours
+func (s *TaskDurationSample) ActualHours() float64 {
+	return float64(s.Duration) / float64(time.Hour/time.Millisecond)
+}
+
+// TaskDurationStat summarizes the recent actual durations of tasks of one
+// type and complexity. Durations are in milliseconds.
+type TaskDurationStat struct {
+	TaskType    string    `gorm:"primaryKey" json:"task_type"`
+	Complexity  string    `gorm:"primaryKey" json:"complexity"`
+	SampleCount int64     `json:"sample_count"`
+	Mean        int64     `json:"mean_ms"`
+	P50         int64     `json:"p50_ms"`
+	P95         int64     `json:"p95_ms"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for TaskDurationStat
+func (TaskDurationStat) TableName() string {
+	return "task_duration_stats"
+}
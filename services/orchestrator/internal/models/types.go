@@ -0,0 +1,142 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StringArray is a []string stored as a Postgres text[] column.
+// Elements are always written quoted, e.g. {"a","b"}.
+type StringArray []string
+
+// Value implements driver.Valuer
+func (a StringArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, s := range a {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('"')
+		for _, r := range s {
+			if r == '"' || r == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+// Scan implements sql.Scanner
+func (a *StringArray) Scan(src interface{}) error {
+	var literal string
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		literal = v
+	case []byte:
+		literal = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into StringArray", src)
+	}
+
+	parsed, err := parseArrayLiteral(literal)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// parseArrayLiteral parses a one-dimensional Postgres array literal
+func parseArrayLiteral(literal string) ([]string, error) {
+	if len(literal) < 2 || literal[0] != '{' || literal[len(literal)-1] != '}' {
+		return nil, fmt.Errorf("invalid array literal: %q", literal)
+	}
+
+	body := literal[1 : len(literal)-1]
+	result := make([]string, 0)
+	if body == "" {
+		return result, nil
+	}
+
+	var (
+		elem    strings.Builder
+		quoted  bool
+		inQuote bool
+		escaped bool
+	)
+	flush := func() {
+		value := elem.String()
+		if !quoted {
+			value = strings.TrimSpace(value)
+		}
+		result = append(result, value)
+		elem.Reset()
+		quoted = false
+	}
+
+	for _, r := range body {
+		switch {
+		case escaped:
+			elem.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+			quoted = true
+		case r == ',' && !inQuote:
+			flush()
+		default:
+			elem.WriteRune(r)
+		}
+	}
+	if inQuote || escaped {
+		return nil, fmt.Errorf("invalid array literal: %q", literal)
+	}
+	flush()
+
+	return result, nil
+}
+
+// Labels is a free-form string map stored as a jsonb column
+type Labels map[string]string
+
+// Value implements driver.Valuer
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner
+func (l *Labels) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("cannot scan %T into Labels", src)
+	}
+}
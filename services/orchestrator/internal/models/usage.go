@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// UsageBucket is the usage of one metric by one subject, an API key or a
+// user, within a project over one hour. Requests that name no project are
+// counted under an empty project ID.
+type UsageBucket struct {
+	ProjectID string    `gorm:"primaryKey" json:"project_id"`
+	Subject   string    `gorm:"primaryKey" json:"subject"`
+	Metric    string    `gorm:"primaryKey" json:"metric"`
+	Hour      time.Time `gorm:"primaryKey" json:"hour"` // Start of the hour, UTC
+	Count     int64     `gorm:"not null;default:0" json:"count"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for UsageBucket
+func (UsageBucket) TableName() string {
+	return "usage_buckets"
+}
+
+// UsageRollup records a batch of usage counters added to the usage buckets,
+// so a batch rolled up again is not counted twice
+type UsageRollup struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`
+}
+
+// TableName specifies the table name for UsageRollup
+func (UsageRollup) TableName() string {
+	return "usage_rollups"
+}
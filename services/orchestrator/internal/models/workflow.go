@@ -19,6 +19,15 @@ const (
 	WorkflowStatusTerminated WorkflowStatus = "terminated"
 	WorkflowStatusTimedOut   WorkflowStatus = "timed_out"
 	WorkflowStatusPaused     WorkflowStatus = "paused"
+
+	// WorkflowStatusDraft is a workflow saved without starting it; it is
+	// submitted later, which moves it to pending
+	WorkflowStatusDraft WorkflowStatus = "draft"
+
+	// Statuses of workflow steps an operator overrode: skipped, or done by
+	// hand with the output they supplied
+	WorkflowStatusSkipped           WorkflowStatus = "skipped"
+	WorkflowStatusCompletedManually WorkflowStatus = "completed_manually"
 )
 
 // WorkflowType represents different types of workflows
@@ -32,6 +41,7 @@ const (
 	WorkflowTypeDeployment   WorkflowType = "deployment"
 	WorkflowTypeTaskExecution WorkflowType = "task_execution"
 	WorkflowTypeCustom       WorkflowType = "custom"
+	WorkflowTypePromotion    WorkflowType = "environment_promotion"
 )
 
 // WorkflowPriority represents the priority of a workflow
@@ -44,6 +54,39 @@ const (
 	WorkflowPriorityCritical WorkflowPriority = "critical"
 )
 
+// workflowPriorities orders the priorities from lowest to highest
+var workflowPriorities = []WorkflowPriority{
+	WorkflowPriorityLow,
+	WorkflowPriorityMedium,
+	WorkflowPriorityHigh,
+	WorkflowPriorityCritical,
+}
+
+// Rank orders priorities, from 0 for low to 3 for critical; unknown
+// priorities rank as medium
+func (p WorkflowPriority) Rank() int {
+	for i, priority := range workflowPriorities {
+		if p == priority {
+			return i
+		}
+	}
+	return 1
+}
+
+// Next returns the priority one level up, critical for critical
+func (p WorkflowPriority) Next() WorkflowPriority {
+	return workflowPriorities[min(p.Rank()+1, len(workflowPriorities)-1)]
+}
+
+// SLAStatus tracks a workflow's progress against its SLA
+type SLAStatus string
+
+const (
+	SLAStatusOnTrack  SLAStatus = "on_track"
+	SLAStatusAtRisk   SLAStatus = "at_risk"
+	SLAStatusBreached SLAStatus = "breached"
+)
+
 // Workflow represents a workflow definition
 type Workflow struct {
 	ID               string           `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -51,22 +94,51 @@ type Workflow struct {
 	Description      string           `json:"description"`
 	Type             WorkflowType     `gorm:"not null" json:"type"`
 	Priority         WorkflowPriority `gorm:"default:'medium'" json:"priority"`
+	// EffectivePriority is the priority the scheduler honors: the requested
+	// priority, escalated while the workflow waits in the pending queue
+	EffectivePriority WorkflowPriority `gorm:"index" json:"effective_priority"`
 	ProjectID        string           `gorm:"type:uuid;index" json:"project_id"`
 	TemporalID       string           `gorm:"index" json:"temporal_id,omitempty"`
 	TemporalRunID    string           `json:"temporal_run_id,omitempty"`
 	Status           WorkflowStatus   `gorm:"default:'pending';index" json:"status"`
-	Input            json.RawMessage  `gorm:"type:jsonb" json:"input,omitempty"`
-	Output           json.RawMessage  `gorm:"type:jsonb" json:"output,omitempty"`
+	Input            json.RawMessage  `gorm:"type:jsonb;encrypted" json:"input,omitempty"`
+	Output           json.RawMessage  `gorm:"type:jsonb;encrypted" json:"output,omitempty"`
 	Metadata         json.RawMessage  `gorm:"type:jsonb" json:"metadata,omitempty"`
+	Source           *WorkflowSource  `gorm:"-" json:"source,omitempty"` // Git context the workflow was started for, kept in Metadata
 	Config           json.RawMessage  `gorm:"type:jsonb" json:"config,omitempty"`
+	Tags             StringArray      `gorm:"type:text[]" json:"tags,omitempty"`
+	Labels           Labels           `gorm:"type:jsonb" json:"labels,omitempty"`
+	Simulated        bool             `gorm:"default:false;index" json:"simulated"` // Run against stub agents; excluded from stats
+	RequestID        string           `gorm:"index" json:"request_id,omitempty"`    // API request that started the workflow
+	Fingerprint      string           `gorm:"type:varchar(64);index" json:"fingerprint,omitempty"` // Hash of project, type and canonical input, see WorkflowFingerprint
 	Error            string           `json:"error,omitempty"`
+	ErrorSignature   string           `gorm:"type:varchar(512);index" json:"error_signature,omitempty"` // Error with IDs and timestamps normalized away
+	ErrorClass       string           `gorm:"type:varchar(32);index" json:"error_class,omitempty"`      // Kind of failure: timeout, auth, quota, validation, crash or unknown
+	CancelledReason  string           `json:"cancelled_reason,omitempty"` // Why the workflow was cancelled; cancellations are not failures and leave Error alone
+	CancelledBy      string           `json:"cancelled_by,omitempty"`     // User who cancelled the workflow; empty when it was cancelled outside the orchestrator
+	CancelledAt      *time.Time       `json:"cancelled_at,omitempty"`
+	SubmittedAt      *time.Time       `json:"submitted_at,omitempty"` // When a draft was submitted
+	QueuedAt         *time.Time       `json:"queued_at,omitempty"`    // When the workflow started waiting to run
 	StartedAt        *time.Time       `json:"started_at,omitempty"`
 	CompletedAt      *time.Time       `json:"completed_at,omitempty"`
-	Duration         int64            `json:"duration,omitempty"` // Duration in seconds
+	Duration         int64            `json:"duration_ms,omitempty"` // Duration in milliseconds
+	ResultCompactedAt *time.Time      `gorm:"index" json:"result_compacted_at,omitempty"` // When the task results in Output were compacted, see ResultArchive
+	DurationText     string           `gorm:"-" json:"duration_text,omitempty"`
 	RetryCount       int              `gorm:"default:0" json:"retry_count"`
 	MaxRetries       int              `gorm:"default:3" json:"max_retries"`
 	TimeoutSeconds   int              `gorm:"default:3600" json:"timeout_seconds"`
+	SLASeconds       int              `json:"sla_seconds,omitempty"`           // Expected completion time; 0 means no SLA
+	SLAThreshold     float64          `json:"sla_warning_threshold,omitempty"`           // Fraction of the SLA after which the workflow is at risk
+	SLAStatus        SLAStatus        `gorm:"index" json:"sla_status,omitempty"`
+	SchedulingReason string           `json:"scheduling_reason,omitempty"` // Why the fair-share scheduler holds the pending workflow
+	Stuck            bool             `gorm:"default:false;index" json:"stuck"`  // Its workflow task keeps failing, see StuckError
+	StuckError       string           `json:"stuck_error,omitempty"`            // Why the workflow task fails
+	StuckAt          *time.Time       `json:"stuck_at,omitempty"`
+	Budget           *WorkflowBudget  `gorm:"type:jsonb" json:"budget,omitempty"`
+	EstimatedTime    *int             `json:"estimated_time,omitempty"` // Seconds, once intent analysis estimated it
+	EstimatedCost    *float64         `json:"estimated_cost,omitempty"` // Once intent analysis estimated it
 	ParentWorkflowID *string          `gorm:"type:uuid" json:"parent_workflow_id,omitempty"`
+	TemplateID       *string          `gorm:"type:uuid;index" json:"template_id,omitempty"` // Template the workflow was started from
 	CreatedBy        string           `json:"created_by"`
 	UpdatedBy        string           `json:"updated_by"`
 	CreatedAt        time.Time        `json:"created_at"`
@@ -79,6 +151,36 @@ type Workflow struct {
 	Steps      []WorkflowStep `gorm:"foreignKey:WorkflowID" json:"steps,omitempty"`
 }
 
+// WorkflowSummary is a lightweight read model of a workflow used by list endpoints.
+// It deliberately omits the jsonb payload columns (input, output, metadata, config).
+type WorkflowSummary struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	Type         WorkflowType     `json:"type"`
+	Status       WorkflowStatus   `json:"status"`
+	Priority     WorkflowPriority `json:"priority"`
+	EffectivePriority WorkflowPriority `json:"effective_priority"`
+	ProjectID    string           `json:"project_id"`
+	Tags         StringArray      `json:"tags,omitempty"`
+	Labels       Labels           `json:"labels,omitempty"`
+	Source       *WorkflowSource  `gorm:"-" json:"source,omitempty"`
+	SourceMetadata []byte          `json:"-"` // Metadata holding only the source, as listings select it
+	Simulated    bool             `json:"simulated,omitempty"`
+	SLAStatus    SLAStatus        `json:"sla_status,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	StartedAt    *time.Time       `json:"started_at,omitempty"`
+	CompletedAt  *time.Time       `json:"completed_at,omitempty"`
+	Duration     int64            `json:"duration_ms,omitempty"` // Duration in milliseconds
+	DurationText string           `gorm:"-" json:"duration_text,omitempty"`
+	Error        string           `json:"error,omitempty"` // Truncated to 200 characters
+	ErrorClass   string           `json:"error_class,omitempty"`
+	CancelledReason string        `json:"cancelled_reason,omitempty"`
+	CancelledBy  string           `json:"cancelled_by,omitempty"`
+	CancelledAt  *time.Time       `json:"cancelled_at,omitempty"`
+	CreatedBy    string           `json:"created_by"`
+	DeletedAt    *time.Time       `json:"deleted_at,omitempty"` // Only set in admin listings of deleted workflows
+}
+
 // WorkflowStep represents a step in a workflow
 type WorkflowStep struct {
 	ID              string          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -93,11 +195,14 @@ type WorkflowStep struct {
 	Error           string          `json:"error,omitempty"`
 	StartedAt       *time.Time      `json:"started_at,omitempty"`
 	CompletedAt     *time.Time      `json:"completed_at,omitempty"`
-	Duration        int64           `json:"duration,omitempty"` // Duration in milliseconds
+	Duration        int64           `json:"duration_ms,omitempty"` // Duration in milliseconds
+	DurationText    string          `gorm:"-" json:"duration_text,omitempty"`
 	RetryCount      int             `gorm:"default:0" json:"retry_count"`
 	MaxRetries      int             `gorm:"default:3" json:"max_retries"`
 	TimeoutSeconds  int             `gorm:"default:300" json:"timeout_seconds"`
 	DependsOn       []string        `gorm:"type:text[]" json:"depends_on,omitempty"`
+	StepID          string          `gorm:"index" json:"step_id,omitempty"`       // ID of the step in the execution plan
+	OverriddenBy    string          `json:"overridden_by,omitempty"`             // Operator who skipped or completed the step
 	CreatedAt       time.Time       `json:"created_at"`
 	UpdatedAt       time.Time       `json:"updated_at"`
 
@@ -116,9 +221,14 @@ type WorkflowTemplate struct {
 	Config      json.RawMessage `gorm:"type:jsonb" json:"config"`
 	Steps       json.RawMessage `gorm:"type:jsonb" json:"steps"`
 	Variables   json.RawMessage `gorm:"type:jsonb" json:"variables,omitempty"`
-	Tags        []string        `gorm:"type:text[]" json:"tags,omitempty"`
+	Tags        StringArray     `gorm:"type:text[]" json:"tags,omitempty"`
 	IsActive    bool            `gorm:"default:true" json:"is_active"`
-	IsPublic    bool            `gorm:"default:false" json:"is_public"`
+	IsPublic    bool            `gorm:"default:false" json:"is_public"` // Listed in the catalog, and clonable, across projects
+	ProjectID   *string         `gorm:"type:uuid;index" json:"project_id,omitempty"` // Owning project; nil for global templates
+	// Provenance of a template cloned from another: the source and the
+	// version it had when cloned
+	SourceTemplateID *string    `gorm:"type:uuid;index" json:"source_template_id,omitempty"`
+	SourceVersion    string     `json:"source_version,omitempty"`
 	CreatedBy   string          `json:"created_by"`
 	UpdatedBy   string          `json:"updated_by"`
 	CreatedAt   time.Time       `json:"created_at"`
@@ -126,20 +236,55 @@ type WorkflowTemplate struct {
 	DeletedAt   gorm.DeletedAt  `gorm:"index" json:"deleted_at,omitempty"`
 }
 
-// WorkflowExecution represents a workflow execution history
+// TemplateStat is the usage of a workflow template, recomputed periodically
+// from the workflows started from it. Drafts and simulated workflows are not
+// counted.
+type TemplateStat struct {
+	TemplateID     string    `gorm:"type:uuid;primaryKey" json:"template_id"`
+	WorkflowCount  int64     `gorm:"index" json:"workflow_count"` // Workflows started from the template
+	FinishedCount  int64     `json:"finished_count"`              // Of which completed, failed, cancelled, terminated or timed out
+	CompletedCount int64     `json:"completed_count"`
+	CancelledCount int64     `json:"cancelled_count"`
+	SuccessRate    float64   `json:"success_rate"`    // Completed of the finished workflows not cancelled: cancellations are not failures
+	AvgDurationMs  int64     `json:"avg_duration_ms"` // Of the completed workflows
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// TableName specifies the table name for TemplateStat
+func (TemplateStat) TableName() string {
+	return "template_stats"
+}
+
+// RunReason is why a Temporal run of a workflow started
+type RunReason string
+
+const (
+	RunReasonStart         RunReason = "start"           // First run of the workflow
+	RunReasonRetry         RunReason = "retry"           // Temporal retried a failed run
+	RunReasonContinueAsNew RunReason = "continue_as_new" // The previous run continued as new
+	RunReasonRerun         RunReason = "rerun"           // First run of a re-run of another workflow
+	RunReasonBackfill      RunReason = "backfill"        // Synthesized for workflows started before runs were recorded
+)
+
+// WorkflowExecution is one Temporal run of a workflow. ExecutionID is the
+// Temporal run ID.
 type WorkflowExecution struct {
 	ID             string          `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
 	WorkflowID     string          `gorm:"type:uuid;not null;index" json:"workflow_id"`
+	ProjectID      string          `gorm:"type:uuid;index" json:"project_id"`
 	ExecutionID    string          `gorm:"not null;unique;index" json:"execution_id"`
+	Reason         RunReason       `gorm:"not null;default:'start'" json:"reason"`
+	PreviousRunID  string          `json:"previous_run_id,omitempty"` // Run this one follows, of a re-run's source workflow for re-runs
 	Status         WorkflowStatus  `gorm:"not null" json:"status"`
-	Input          json.RawMessage `gorm:"type:jsonb" json:"input,omitempty"`
-	Output         json.RawMessage `gorm:"type:jsonb" json:"output,omitempty"`
+	Input          json.RawMessage `gorm:"type:jsonb;encrypted" json:"input,omitempty"`
+	Output         json.RawMessage `gorm:"type:jsonb;encrypted" json:"output,omitempty"`
 	Events         json.RawMessage `gorm:"type:jsonb" json:"events,omitempty"`
 	Metrics        json.RawMessage `gorm:"type:jsonb" json:"metrics,omitempty"`
 	Error          string          `json:"error,omitempty"`
 	StartedAt      time.Time       `json:"started_at"`
 	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
-	Duration       int64           `json:"duration,omitempty"` // Duration in milliseconds
+	Duration       int64           `json:"duration_ms,omitempty"` // Duration in milliseconds
+	DurationText   string          `gorm:"-" json:"duration_text,omitempty"`
 	RetryCount     int             `gorm:"default:0" json:"retry_count"`
 	ResourceUsage  json.RawMessage `gorm:"type:jsonb" json:"resource_usage,omitempty"`
 	CreatedAt      time.Time       `json:"created_at"`
@@ -177,14 +322,105 @@ func (w *Workflow) BeforeCreate(tx *gorm.DB) error {
 	if w.Priority == "" {
 		w.Priority = WorkflowPriorityMedium
 	}
+	if w.EffectivePriority == "" {
+		w.EffectivePriority = w.Priority
+	}
+	w.syncDuration()
 	return nil
 }
 
 // BeforeUpdate hook to calculate duration
 func (w *Workflow) BeforeUpdate(tx *gorm.DB) error {
+	w.syncDuration()
+	return nil
+}
+
+// AfterFind hook to derive the duration text. Workflows stored before
+// escalation have the requested priority as effective priority.
+func (w *Workflow) AfterFind(tx *gorm.DB) error {
+	w.DurationText = HumanDuration(w.Duration)
+	w.Source = ParseWorkflowSource(w.Metadata)
+	if w.EffectivePriority == "" {
+		w.EffectivePriority = w.Priority
+	}
+	return nil
+}
+
+// syncDuration recomputes the duration once both timestamps are known
+func (w *Workflow) syncDuration() {
 	if w.StartedAt != nil && w.CompletedAt != nil {
-		w.Duration = int64(w.CompletedAt.Sub(*w.StartedAt).Seconds())
+		w.Duration = elapsedMillis(*w.StartedAt, *w.CompletedAt)
+	}
+	w.DurationText = HumanDuration(w.Duration)
+}
+
+// UnmarshalJSON also accepts the deprecated "duration" field, which held seconds
+func (w *Workflow) UnmarshalJSON(data []byte) error {
+	type plain Workflow
+	if err := json.Unmarshal(data, (*plain)(w)); err != nil {
+		return err
+	}
+	if seconds, ok := legacyDuration(data); ok && w.Duration == 0 {
+		w.Duration = seconds * 1000
+	}
+	w.DurationText = HumanDuration(w.Duration)
+	return nil
+}
+
+// AfterFind hook to derive the duration text and effective priority
+func (s *WorkflowSummary) AfterFind(tx *gorm.DB) error {
+	s.DurationText = HumanDuration(s.Duration)
+	s.Source = ParseWorkflowSource(s.SourceMetadata)
+	if s.EffectivePriority == "" {
+		s.EffectivePriority = s.Priority
+	}
+	return nil
+}
+
+// BeforeUpdate hook to calculate duration
+func (s *WorkflowStep) BeforeUpdate(tx *gorm.DB) error {
+	if s.StartedAt != nil && s.CompletedAt != nil {
+		s.Duration = elapsedMillis(*s.StartedAt, *s.CompletedAt)
+	}
+	s.DurationText = HumanDuration(s.Duration)
+	return nil
+}
+
+// AfterFind hook to derive the duration text
+func (s *WorkflowStep) AfterFind(tx *gorm.DB) error {
+	s.DurationText = HumanDuration(s.Duration)
+	return nil
+}
+
+// UnmarshalJSON also accepts the deprecated "duration" field
+func (s *WorkflowStep) UnmarshalJSON(data []byte) error {
+	type plain WorkflowStep
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	if ms, ok := legacyDuration(data); ok && s.Duration == 0 {
+		s.Duration = ms
+	}
+	s.DurationText = HumanDuration(s.Duration)
+	return nil
+}
+
+// AfterFind hook to derive the duration text
+func (e *WorkflowExecution) AfterFind(tx *gorm.DB) error {
+	e.DurationText = HumanDuration(e.Duration)
+	return nil
+}
+
+// UnmarshalJSON also accepts the deprecated "duration" field
+func (e *WorkflowExecution) UnmarshalJSON(data []byte) error {
+	type plain WorkflowExecution
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	if ms, ok := legacyDuration(data); ok && e.Duration == 0 {
+		e.Duration = ms
 	}
+	e.DurationText = HumanDuration(e.Duration)
 	return nil
 }
 
@@ -208,5 +444,5 @@ func (w *Workflow) GetDurationString() string {
 	if w.Duration == 0 {
 		return "0s"
 	}
-	return time.Duration(w.Duration * int64(time.Second)).String()
+	return (time.Duration(w.Duration) * time.Millisecond).String()
 }
\ No newline at end of file
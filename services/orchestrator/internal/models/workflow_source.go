@@ -0,0 +1,32 @@
+package models
+
+import "encoding/json"
+
+// WorkflowSourceMetadataKey is the workflow metadata key of the git context a
+// workflow was started for, see WorkflowSource
+const WorkflowSourceMetadataKey = "source"
+
+// WorkflowSource is the git context of a workflow started by CI: the commit
+// it runs for and the CI run and pull request that started it
+type WorkflowSource struct {
+	Repository string `json:"repository"`
+	Branch     string `json:"branch,omitempty"`
+	CommitSHA  string `json:"commit_sha,omitempty"`
+	CIRunURL   string `json:"ci_run_url,omitempty"`
+	PRNumber   int    `json:"pr_number,omitempty"`
+}
+
+// ParseWorkflowSource returns the git context in a workflow's metadata, nil
+// when it has none
+func ParseWorkflowSource(metadata []byte) *WorkflowSource {
+	if len(metadata) == 0 {
+		return nil
+	}
+	var fields struct {
+		Source *WorkflowSource `json:"source"`
+	}
+	if err := json.Unmarshal(metadata, &fields); err != nil {
+		return nil
+	}
+	return fields.Source
+}
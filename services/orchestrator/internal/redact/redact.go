@@ -0,0 +1,408 @@
+// Package redact removes sensitive values from workflow inputs and outputs
+// on their way out of the orchestrator: request logs, emitted events, trace
+// attributes and exported reports. Stored data is never redacted.
+//
+// Values are selected by field-name rules, matching any object key that
+// ends with the name regardless of case and separators ("token" matches
+// "access_token" and "X-Auth-Token"), and by JSON-path rules such as
+// "$.deploy.targets[*].host". A redacted value is replaced with a typed
+// marker that keeps its type and length class, e.g. "[REDACTED:string:short]".
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultFields are the field names redacted everywhere, whatever the
+// configuration
+var DefaultFields = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"authorization",
+	"api_key",
+	"private_key",
+	"credentials",
+	"cookie",
+}
+
+// Length classes of redacted strings, arrays and objects
+const (
+	LengthEmpty  = "empty"
+	LengthShort  = "short"  // Up to 8 characters or elements
+	LengthMedium = "medium" // Up to 64
+	LengthLong   = "long"
+)
+
+const markerPrefix = "[REDACTED:"
+
+var (
+	markerPattern = regexp.MustCompile(`^\[REDACTED:(string|number|bool|array|object)(:(empty|short|medium|long))?\]$`)
+	// textKeyPattern and textValuePattern match the key=value and key: value
+	// pairs of free text, such as error messages, query strings and headers
+	textKeyPattern   = regexp.MustCompile(`(?i)([a-z0-9_.\-]+)(["']?\s*[=:]\s*)`)
+	textValuePattern = regexp.MustCompile(`(?i)^((?:bearer|basic)\s+[^\s,;&"']+|"[^"]*"|'[^']*'|[^\s,;&"']+)`)
+)
+
+// Redactor redacts the values its rules select. A nil redactor applies the
+// default field names.
+type Redactor struct {
+	fields []string   // Normalized field names
+	paths  [][]string // Path segments; "*" matches any key or element, "[*]" any element
+}
+
+var defaultRedactor = mustNew(nil, nil)
+
+func mustNew(fields, paths []string) *Redactor {
+	r, err := New(fields, paths)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// New creates a redactor of the default field names plus the given field
+// names and JSON paths
+func New(fields, paths []string) (*Redactor, error) {
+	return extend(&Redactor{}, append(append([]string{}, DefaultFields...), fields...), paths)
+}
+
+// With returns a redactor of r's rules plus the given ones, leaving r as it
+// is. It fails for empty field names and malformed paths.
+func (r *Redactor) With(fields, paths []string) (*Redactor, error) {
+	if r == nil {
+		r = defaultRedactor
+	}
+	return extend(r, fields, paths)
+}
+
+func extend(r *Redactor, fields, paths []string) (*Redactor, error) {
+	combined := &Redactor{
+		fields: append([]string{}, r.fields...),
+		paths:  append([][]string{}, r.paths...),
+	}
+	for _, field := range fields {
+		name := normalize(field)
+		if name == "" {
+			return nil, fmt.Errorf("redaction field %q has no letters or digits", field)
+		}
+		combined.fields = append(combined.fields, name)
+	}
+	for _, path := range paths {
+		segments, err := parsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		combined.paths = append(combined.paths, segments)
+	}
+	return combined, nil
+}
+
+// ValidatePath checks the syntax of a JSON-path rule
+func ValidatePath(path string) error {
+	_, err := parsePath(path)
+	return err
+}
+
+// parsePath splits a path such as "$.input.users[*].ssn" into its segments:
+// object keys, "*" for any key or element and "[*]" for any array element
+func parsePath(path string) ([]string, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(path), "$"), ".")
+	if trimmed == "" {
+		return nil, fmt.Errorf("redaction path %q selects no field", path)
+	}
+	var segments []string
+	for _, part := range strings.Split(trimmed, ".") {
+		key, elements, found := strings.Cut(part, "[")
+		if found {
+			elements = "[" + elements
+		}
+		if key == "" && elements == "" {
+			return nil, fmt.Errorf("redaction path %q has an empty segment", path)
+		}
+		if key != "" {
+			segments = append(segments, key)
+		}
+		// Each "[*]" is one more level of array nesting
+		for elements != "" {
+			if !strings.HasPrefix(elements, "[*]") {
+				return nil, fmt.Errorf("redaction path %q: only [*] selects array elements", path)
+			}
+			segments = append(segments, "[*]")
+			elements = elements[len("[*]"):]
+		}
+	}
+	return segments, nil
+}
+
+// normalize lowercases a field name and drops everything but letters and
+// digits, so "API-Key", "api_key" and "apiKey" are the same name
+func normalize(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+// MatchesField reports whether the field-name rules select a key
+func (r *Redactor) MatchesField(key string) bool {
+	if r == nil {
+		r = defaultRedactor
+	}
+	name := normalize(key)
+	if name == "" {
+		return false
+	}
+	for _, field := range r.fields {
+		if strings.HasSuffix(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPath reports whether a path rule selects the value at path
+func (r *Redactor) matchesPath(path []string) bool {
+	for _, rule := range r.paths {
+		if len(rule) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range rule {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns a redacted copy of a decoded JSON value: maps of string
+// keys, slices, strings, numbers, bools and nil. Values of other types are
+// converted through their JSON encoding first. v itself is not modified.
+func (r *Redactor) Value(v interface{}) interface{} {
+	if r == nil {
+		r = defaultRedactor
+	}
+	redacted, _ := r.value(normalizeValue(v), nil)
+	return redacted
+}
+
+// normalizeValue converts values of Go types to their decoded JSON form
+func normalizeValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, map[string]interface{}, []interface{}, string, bool, float64, json.Number:
+		return v
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return v
+	}
+	return decoded
+}
+
+// value redacts v found at path, reporting whether anything changed
+func (r *Redactor) value(v interface{}, path []string) (interface{}, bool) {
+	if len(path) > 0 && r.matchesPath(path) {
+		return redactValue(v)
+	}
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		var result map[string]interface{}
+		for key, item := range typed {
+			var redacted interface{}
+			var changed bool
+			if r.MatchesField(key) {
+				redacted, changed = redactValue(item)
+			} else {
+				redacted, changed = r.value(item, append(path[:len(path):len(path)], key))
+			}
+			if !changed {
+				continue
+			}
+			if result == nil {
+				result = make(map[string]interface{}, len(typed))
+				for k, original := range typed {
+					result[k] = original
+				}
+			}
+			result[key] = redacted
+		}
+		if result == nil {
+			return v, false
+		}
+		return result, true
+	case []interface{}:
+		var result []interface{}
+		for i, item := range typed {
+			redacted, changed := r.value(item, append(path[:len(path):len(path)], "[*]"))
+			if !changed {
+				continue
+			}
+			if result == nil {
+				result = append([]interface{}{}, typed...)
+			}
+			result[i] = redacted
+		}
+		if result == nil {
+			return v, false
+		}
+		return result, true
+	}
+	return v, false
+}
+
+// redactValue replaces a selected value with its marker. Null carries
+// nothing to hide and markers are already redacted, so both are kept.
+func redactValue(v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	if s, ok := v.(string); ok && IsMarker(s) {
+		return v, false
+	}
+	return Marker(v), true
+}
+
+// Marker returns the marker replacing a value: its JSON type and, for
+// strings, arrays and objects, its length class
+func Marker(v interface{}) string {
+	switch typed := v.(type) {
+	case string:
+		return markerPrefix + "string:" + lengthClass(utf8.RuneCountInString(typed)) + "]"
+	case bool:
+		return markerPrefix + "bool]"
+	case float64, float32, int, int64, int32, uint, uint64, uint32, json.Number:
+		return markerPrefix + "number]"
+	case []interface{}:
+		return markerPrefix + "array:" + lengthClass(len(typed)) + "]"
+	case map[string]interface{}:
+		return markerPrefix + "object:" + lengthClass(len(typed)) + "]"
+	}
+	return Marker(normalizeValue(v))
+}
+
+// IsMarker reports whether s is the marker of a redacted value
+func IsMarker(s string) bool {
+	return strings.HasPrefix(s, markerPrefix) && markerPattern.MatchString(s)
+}
+
+func lengthClass(n int) string {
+	switch {
+	case n == 0:
+		return LengthEmpty
+	case n <= 8:
+		return LengthShort
+	case n <= 64:
+		return LengthMedium
+	}
+	return LengthLong
+}
+
+// JSON redacts an encoded JSON document. Documents with nothing to redact,
+// and text that is not JSON, are returned as they are.
+func (r *Redactor) JSON(data []byte) []byte {
+	if r == nil {
+		r = defaultRedactor
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return data
+	}
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return data
+	}
+	redacted, changed := r.value(decoded, nil)
+	if !changed {
+		return data
+	}
+	encoded, err := json.Marshal(redacted)
+	if err != nil {
+		return data
+	}
+	return encoded
+}
+
+// Query redacts the values of the selected parameters of a raw URL query,
+// keeping the order and encoding of the others
+func (r *Redactor) Query(rawQuery string) string {
+	if r == nil {
+		r = defaultRedactor
+	}
+	if rawQuery == "" {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || !r.MatchesField(key) || IsMarker(value) {
+			continue
+		}
+		params[i] = key + "=" + Marker(value)
+	}
+	return strings.Join(params, "&")
+}
+
+// Text redacts the values of the selected keys in free text, such as the
+// "password=hunter2" of an error message or the "Authorization: Bearer ..."
+// of a header dump
+func (r *Redactor) Text(s string) string {
+	if r == nil {
+		r = defaultRedactor
+	}
+	if !strings.ContainsAny(s, "=:") {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range textKeyPattern.FindAllStringSubmatchIndex(s, -1) {
+		// Keys are looked for after every separator, not only after values,
+		// so "failed: password=x" still finds the password
+		if match[0] < last || !r.MatchesField(s[match[2]:match[3]]) {
+			continue
+		}
+		start := match[1]
+		value := textValuePattern.FindString(s[start:])
+		if value == "" {
+			continue
+		}
+		end := start + len(value)
+		quote := ""
+		if value[0] == '"' || value[0] == '\'' {
+			quote, value = value[:1], value[1:len(value)-1]
+		}
+		if IsMarker(value) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(quote + Marker(value) + quote)
+		last = end
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package redact
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestRedactor_JSONCorpus(t *testing.T) {
+	redactor, err := New([]string{"ssn"}, []string{"$.deploy.targets[*].host", "$.matrix[*][*]", "$.env.*.value"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "nothing to redact", in: `{"name":"orders","replicas":3}`, want: `{"name":"orders","replicas":3}`},
+		{name: "default field", in: `{"password":"hunter2"}`, want: `{"password":"[REDACTED:string:short]"}`},
+		{name: "field suffix", in: `{"access_token":"abc","X-Auth-Token":"def"}`, want: `{"X-Auth-Token":"[REDACTED:string:short]","access_token":"[REDACTED:string:short]"}`},
+		{name: "field spelling", in: `{"apiKey":"k","API-KEY":"k","api_key":"k"}`, want: `{"API-KEY":"[REDACTED:string:short]","apiKey":"[REDACTED:string:short]","api_key":"[REDACTED:string:short]"}`},
+		{name: "configured field", in: `{"user":{"ssn":"123-45-6789"}}`, want: `{"user":{"ssn":"[REDACTED:string:medium]"}}`},
+		{name: "field is not a suffix", in: `{"max_tokens":4096,"token_count":12}`, want: `{"max_tokens":4096,"token_count":12}`},
+		{name: "empty string", in: `{"secret":""}`, want: `{"secret":"[REDACTED:string:empty]"}`},
+		{name: "long string", in: `{"private_key":"` + strings.Repeat("k", 65) + `"}`, want: `{"private_key":"[REDACTED:string:long]"}`},
+		{name: "multibyte string counts runes", in: `{"passwd":"pässwörd"}`, want: `{"passwd":"[REDACTED:string:short]"}`},
+		{name: "number", in: `{"pin_token":1234}`, want: `{"pin_token":"[REDACTED:number]"}`},
+		{name: "bool", in: `{"secret":true}`, want: `{"secret":"[REDACTED:bool]"}`},
+		{name: "null is kept", in: `{"secret":null}`, want: `{"secret":null}`},
+		{name: "object", in: `{"credentials":{"user":"a","pass":"b"}}`, want: `{"credentials":"[REDACTED:object:short]"}`},
+		{name: "array", in: `{"tokens_secret":["a","b","c"]}`, want: `{"tokens_secret":"[REDACTED:array:short]"}`},
+		{name: "nested in arrays", in: `{"users":[{"name":"a","password":"x"},{"name":"b","password":"yy"}]}`,
+			want: `{"users":[{"name":"a","password":"[REDACTED:string:short]"},{"name":"b","password":"[REDACTED:string:short]"}]}`},
+		{name: "top-level array", in: `[{"token":"t"},"plain"]`, want: `[{"token":"[REDACTED:string:short]"},"plain"]`},
+		{name: "path through array", in: `{"deploy":{"targets":[{"host":"db.internal","port":5432},{"host":"cache.internal"}]}}`,
+			want: `{"deploy":{"targets":[{"host":"[REDACTED:string:medium]","port":5432},{"host":"[REDACTED:string:medium]"}]}}`},
+		{name: "path through nested arrays", in: `{"matrix":[[1,2],[3]]}`, want: `{"matrix":[["[REDACTED:number]","[REDACTED:number]"],["[REDACTED:number]"]]}`},
+		{name: "path wildcard", in: `{"env":{"DB_URL":{"value":"postgres://db"},"MODE":{"value":"prod"}}}`,
+			want: `{"env":{"DB_URL":{"value":"[REDACTED:string:medium]"},"MODE":{"value":"[REDACTED:string:short]"}}}`},
+		{name: "path must match fully", in: `{"host":"db.internal","deploy":{"host":"x"}}`, want: `{"host":"db.internal","deploy":{"host":"x"}}`},
+		{name: "markers are kept", in: `{"password":"[REDACTED:string:long]"}`, want: `{"password":"[REDACTED:string:long]"}`},
+		{name: "not JSON", in: `password=hunter2`, want: `password=hunter2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactor.JSON([]byte(tt.in))
+			if tt.in == tt.want {
+				// Untouched documents are returned as they are, byte for byte
+				assert.Equal(t, tt.in, string(got))
+				return
+			}
+			assert.JSONEq(t, tt.want, string(got))
+			// Redacting twice changes nothing more
+			assert.Equal(t, string(got), string(redactor.JSON(got)))
+		})
+	}
+}
+
+func TestRedactor_ValueLeavesInputUntouched(t *testing.T) {
+	input := map[string]interface{}{
+		"name":  "orders",
+		"steps": []interface{}{map[string]interface{}{"token": "abc"}},
+	}
+	redacted := (*Redactor)(nil).Value(input).(map[string]interface{})
+
+	assert.Equal(t, "[REDACTED:string:short]", redacted["steps"].([]interface{})[0].(map[string]interface{})["token"])
+	assert.Equal(t, "abc", input["steps"].([]interface{})[0].(map[string]interface{})["token"])
+
+	// Go values are redacted through their JSON form
+	type credentials struct {
+		User     string `json:"user"`
+		Password string `json:"password"`
+	}
+	assert.Equal(t, map[string]interface{}{"user": "ci", "password": "[REDACTED:string:short]"},
+		(*Redactor)(nil).Value(credentials{User: "ci", Password: "hunter2"}))
+}
+
+func TestRedactor_QueryAndText(t *testing.T) {
+	var redactor *Redactor
+
+	assert.Equal(t, "page=2&access_token=[REDACTED:string:medium]&sort=name",
+		redactor.Query("page=2&access_token=eyJhbGciOiJIUzI1NiJ9&sort=name"))
+	assert.Equal(t, "page=2", redactor.Query("page=2"))
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"connect failed: password=hunter2 host=db", "connect failed: password=[REDACTED:string:short] host=db"},
+		{`Authorization: Bearer abc.def`, `Authorization: [REDACTED:string:medium]`},
+		{`{"client_secret": "s3cr3t", "id": "x"}`, `{"client_secret": "[REDACTED:string:short]", "id": "x"}`},
+		{"fetch https://api.example.com/v1?api_key=k123&q=go", "fetch https://api.example.com/v1?api_key=[REDACTED:string:short]&q=go"},
+		{"at 12:30:00 nothing leaked", "at 12:30:00 nothing leaked"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, redactor.Text(tt.in))
+		assert.Equal(t, tt.want, redactor.Text(tt.want), "redacting twice changes nothing more")
+	}
+}
+
+func TestRedactor_Rules(t *testing.T) {
+	base, err := New(nil, nil)
+	require.NoError(t, err)
+	extended, err := base.With([]string{"ssn"}, []string{"$.host"})
+	require.NoError(t, err)
+
+	assert.True(t, extended.MatchesField("user_ssn"))
+	assert.False(t, base.MatchesField("user_ssn"), "With leaves the redactor extended as it is")
+	assert.Equal(t, `{"host":"[REDACTED:string:short]"}`, string(extended.JSON([]byte(`{"host":"db"}`))))
+
+	for _, path := range []string{"", "$", "a..b", "items[0]", "items[*", "$.a[*]b"} {
+		assert.Error(t, ValidatePath(path), path)
+	}
+	for _, path := range []string{"a", "$.a.b", "$.items[*].name", "$[*].a", "$.a[*][*]", "$.*.value"} {
+		assert.NoError(t, ValidatePath(path), path)
+	}
+	_, err = New([]string{"--"}, nil)
+	assert.Error(t, err)
+}
+
+func TestSpanExporter_RedactsAttributes(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(SpanExporter(exporter, nil)))
+	defer provider.Shutdown(context.Background())
+
+	_, span := provider.Tracer("test").Start(context.Background(), "request")
+	span.SetAttributes(
+		attribute.String("http.url", "https://orchestrator/api/v1/workflows?token=abc123&page=2"),
+		attribute.String("http.request.header.authorization", "Bearer abc"),
+		attribute.Int("db.password", 1234),
+		attribute.String("workflow.id", "wf-1"),
+	)
+	span.AddEvent("retry", oteltrace.WithAttributes(attribute.String("error", "login failed: secret=xyz")))
+	span.SetStatus(codes.Error, "dial failed: password=hunter2")
+	span.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	attributes := make(map[string]string)
+	for _, kv := range spans[0].Attributes {
+		attributes[string(kv.Key)] = kv.Value.Emit()
+	}
+	assert.Equal(t, map[string]string{
+		"http.url":                          "https://orchestrator/api/v1/workflows?token=[REDACTED:string:short]&page=2",
+		"http.request.header.authorization": "[REDACTED:string:medium]",
+		"db.password":                       "[REDACTED:number]",
+		"workflow.id":                       "wf-1",
+	}, attributes)
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "login failed: secret=[REDACTED:string:short]", spans[0].Events[0].Attributes[0].Value.AsString())
+	assert.Equal(t, "dial failed: password=[REDACTED:string:short]", spans[0].Status.Description)
+}
@@ -0,0 +1,86 @@
+package redact
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// SpanExporter wraps a span exporter so the attributes, event attributes and
+// status descriptions of the spans it exports are redacted. Instrumentation
+// may set attributes at any point of a span's life, so redaction happens
+// when spans leave the process rather than when they start.
+func SpanExporter(exporter sdktrace.SpanExporter, r *Redactor) sdktrace.SpanExporter {
+	return &spanExporter{SpanExporter: exporter, redactor: r}
+}
+
+type spanExporter struct {
+	sdktrace.SpanExporter
+	redactor *Redactor
+}
+
+func (e *spanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	redacted := make([]sdktrace.ReadOnlySpan, len(spans))
+	for i, span := range spans {
+		redacted[i] = e.redactor.Span(span)
+	}
+	return e.SpanExporter.ExportSpans(ctx, redacted)
+}
+
+// redactedSpan is a span with its attributes, events and status replaced
+type redactedSpan struct {
+	sdktrace.ReadOnlySpan
+	attributes []attribute.KeyValue
+	events     []sdktrace.Event
+	status     sdktrace.Status
+}
+
+func (s *redactedSpan) Attributes() []attribute.KeyValue { return s.attributes }
+func (s *redactedSpan) Events() []sdktrace.Event         { return s.events }
+func (s *redactedSpan) Status() sdktrace.Status          { return s.status }
+
+// Span returns a redacted view of a span
+func (r *Redactor) Span(span sdktrace.ReadOnlySpan) sdktrace.ReadOnlySpan {
+	events := span.Events()
+	redacted := &redactedSpan{
+		ReadOnlySpan: span,
+		attributes:   r.Attributes(span.Attributes()),
+		events:       make([]sdktrace.Event, len(events)),
+		status:       span.Status(),
+	}
+	for i, event := range events {
+		event.Attributes = r.Attributes(event.Attributes)
+		redacted.events[i] = event
+	}
+	redacted.status.Description = r.Text(redacted.status.Description)
+	return redacted
+}
+
+// Attributes returns a redacted copy of trace attributes: the values of the
+// selected keys are replaced with their marker, and selected pairs in the
+// string values, such as the query of a URL, are redacted as text
+func (r *Redactor) Attributes(attributes []attribute.KeyValue) []attribute.KeyValue {
+	redacted := make([]attribute.KeyValue, len(attributes))
+	for i, kv := range attributes {
+		redacted[i] = kv
+		if r.MatchesField(string(kv.Key)) {
+			if kv.Value.Type() == attribute.STRING && IsMarker(kv.Value.AsString()) {
+				continue
+			}
+			redacted[i] = kv.Key.String(Marker(kv.Value.AsInterface()))
+			continue
+		}
+		switch kv.Value.Type() {
+		case attribute.STRING:
+			redacted[i] = kv.Key.String(r.Text(kv.Value.AsString()))
+		case attribute.STRINGSLICE:
+			values := kv.Value.AsStringSlice()
+			for j, value := range values {
+				values[j] = r.Text(value)
+			}
+			redacted[i] = kv.Key.StringSlice(values)
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,53 @@
+// Package requestid carries the ID of the API request that caused some work
+// through contexts, logs and calls to other services, so a failure reported
+// by a user can be correlated across the platform.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Header is the HTTP header used to pass request IDs between services
+const Header = "X-Request-ID"
+
+// Field is the key used for request IDs in logs, response bodies and events
+const Field = "request_id"
+
+type contextKey struct{}
+
+// New generates a request ID. UUIDv7 IDs sort by creation time.
+func New() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Logger returns logger annotated with the request ID carried by ctx
+func Logger(ctx context.Context, logger *zap.Logger) *zap.Logger {
+	if id := FromContext(ctx); id != "" {
+		return logger.With(zap.String(Field, id))
+	}
+	return logger
+}
@@ -0,0 +1,147 @@
+// Package retry coordinates retries across the layers that may retry the
+// same call: Temporal's activity retries, the retries workflows make
+// themselves and the retries of service clients. Its backoff is
+// deterministic, so workflow code can use it, and its budget is plain data,
+// so workflows can carry it in their state.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// ErrBudgetExhausted is returned by Budget.Spend once a workflow has used
+// its retries or its retry window has ended
+var ErrBudgetExhausted = errors.New("retry budget exhausted")
+
+// Backoff computes exponential delays between attempts, with jitter so that
+// the retries of calls failing together do not hit a dependency at once
+type Backoff struct {
+	Initial    time.Duration // Delay before the first retry
+	Max        time.Duration // Delays are capped at this
+	Multiplier float64
+	Jitter     float64 // Fraction of each delay taken off at random, from 0 to 1
+}
+
+// DefaultBackoff is the backoff shared by the orchestrator's retries
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay returns the delay before retrying after the given attempt, counted
+// from 1. The jitter is derived from the key and attempt rather than drawn,
+// so a workflow replaying its history computes the same delays; keys such as
+// a task ID spread the retries of different calls apart.
+func (b Backoff) Delay(attempt int, key string) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := float64(b.Initial)
+	for i := 1; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if b.Max > 0 && delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		hash := fnv.New64a()
+		fmt.Fprintf(hash, "%s:%d", key, attempt)
+		fraction := float64(hash.Sum64()%1000) / 1000
+		delay -= delay * b.Jitter * fraction
+	}
+	return time.Duration(delay)
+}
+
+// Budget bounds the retries a workflow makes across all of its calls, by
+// count and by wall-clock time. A zero Limit allows no retries; a zero
+// Deadline leaves the retries unbounded in time.
+type Budget struct {
+	Limit    int       `json:"limit"`
+	Used     int       `json:"used"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// NewBudget creates a budget of retries that must all start within window
+// of now. A zero window leaves the retries unbounded in time.
+func NewBudget(retries int, window time.Duration, now time.Time) *Budget {
+	budget := &Budget{Limit: retries}
+	if window > 0 {
+		budget.Deadline = now.Add(window)
+	}
+	return budget
+}
+
+// Remaining returns the retries left
+func (b *Budget) Remaining() int {
+	if b == nil {
+		return 0
+	}
+	if b.Used >= b.Limit {
+		return 0
+	}
+	return b.Limit - b.Used
+}
+
+// Spend takes a retry from the budget. It returns ErrBudgetExhausted, saying
+// which bound was hit, when no retry is left or the window has ended.
+func (b *Budget) Spend(now time.Time) error {
+	if b == nil {
+		return nil
+	}
+	if b.Used >= b.Limit {
+		return fmt.Errorf("%w: %d of %d retries used", ErrBudgetExhausted, b.Used, b.Limit)
+	}
+	if !b.Deadline.IsZero() && !now.Before(b.Deadline) {
+		return fmt.Errorf("%w: the retry window ended at %s", ErrBudgetExhausted, b.Deadline.UTC().Format(time.RFC3339))
+	}
+	b.Used++
+	return nil
+}
+
+// Charge takes retries another layer already made, such as the retries
+// Temporal made of a failed activity, from the budget
+func (b *Budget) Charge(retries int) {
+	if b == nil || retries <= 0 {
+		return
+	}
+	b.Used += retries
+	if b.Used > b.Limit {
+		b.Used = b.Limit
+	}
+}
+
+// Attempts caps the attempts of a call retried by another layer, such as
+// Temporal's retry policy, at the retries left plus the first attempt. A
+// max of 0, which leaves the other layer unbounded, is capped as well.
+func (b *Budget) Attempts(max int) int {
+	if b == nil {
+		return max
+	}
+	attempts := b.Remaining() + 1
+	if max > 0 && max < attempts {
+		return max
+	}
+	return attempts
+}
+
+type clientRetriesKey struct{}
+
+// WithClientRetries returns a context in which service clients retry failed
+// calls themselves. Clients make a single attempt by default, as their
+// callers, Temporal activities, are already retried; only callers that
+// nothing retries should opt in.
+func WithClientRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, clientRetriesKey{}, true)
+}
+
+// ClientRetries reports whether service clients may retry calls made with ctx
+func ClientRetries(ctx context.Context) bool {
+	enabled, _ := ctx.Value(clientRetriesKey{}).(bool)
+	return enabled
+}
@@ -0,0 +1,77 @@
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	backoff := Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+
+	assert.Equal(t, time.Second, backoff.Delay(0, "task-1"))
+	assert.Equal(t, time.Second, backoff.Delay(1, "task-1"))
+	assert.Equal(t, 2*time.Second, backoff.Delay(2, "task-1"))
+	assert.Equal(t, 8*time.Second, backoff.Delay(4, "task-1"))
+	assert.Equal(t, 10*time.Second, backoff.Delay(5, "task-1"))
+	assert.Equal(t, 10*time.Second, backoff.Delay(1000, "task-1"))
+}
+
+func TestBackoff_Jitter(t *testing.T) {
+	backoff := Backoff{Initial: 10 * time.Second, Max: time.Minute, Multiplier: 2, Jitter: 0.5}
+
+	delays := map[time.Duration]bool{}
+	for _, key := range []string{"task-1", "task-2", "task-3", "task-4"} {
+		delay := backoff.Delay(1, key)
+		assert.LessOrEqual(t, delay, 10*time.Second, key)
+		assert.GreaterOrEqual(t, delay, 5*time.Second, key)
+		assert.Equal(t, delay, backoff.Delay(1, key), "delays are deterministic")
+		delays[delay] = true
+	}
+	assert.Greater(t, len(delays), 1, "keys spread the delays apart")
+}
+
+func TestBudget_Spend(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	budget := NewBudget(2, time.Minute, start)
+
+	assert.Equal(t, 3, budget.Attempts(5))
+	assert.Equal(t, 3, budget.Attempts(0), "unbounded attempts are capped")
+	assert.Equal(t, 2, budget.Attempts(2))
+	require.NoError(t, budget.Spend(start))
+	require.NoError(t, budget.Spend(start.Add(time.Second)))
+	assert.Equal(t, 0, budget.Remaining())
+	assert.Equal(t, 1, budget.Attempts(5))
+
+	err := budget.Spend(start.Add(2 * time.Second))
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.EqualError(t, err, "retry budget exhausted: 2 of 2 retries used")
+	assert.Equal(t, 2, budget.Used, "a refused retry is not counted")
+
+	window := NewBudget(5, time.Minute, start)
+	err = window.Spend(start.Add(time.Minute))
+	assert.ErrorIs(t, err, ErrBudgetExhausted)
+	assert.EqualError(t, err, "retry budget exhausted: the retry window ended at 2026-01-01T12:01:00Z")
+
+	unbounded := NewBudget(1, 0, start)
+	assert.NoError(t, unbounded.Spend(start.Add(24*time.Hour)))
+
+	charged := NewBudget(3, 0, start)
+	charged.Charge(2)
+	assert.Equal(t, 1, charged.Remaining())
+	charged.Charge(5)
+	assert.Equal(t, 3, charged.Used, "charges are capped at the limit")
+
+	var none *Budget
+	assert.NoError(t, none.Spend(start))
+	assert.Equal(t, 4, none.Attempts(4))
+}
+
+func TestClientRetries(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, ClientRetries(ctx))
+	assert.True(t, ClientRetries(WithClientRetries(ctx)))
+}
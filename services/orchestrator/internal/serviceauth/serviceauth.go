@@ -0,0 +1,111 @@
+// Package serviceauth authenticates the orchestrator to the platform
+// services it calls. Every request to the Agent Manager and the Intent
+// Processor carries a bearer token, sent as the Authorization header over
+// HTTP and as authorization metadata over gRPC.
+package serviceauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/credentials"
+
+	"orchestrator/internal/config"
+)
+
+// Modes of ServiceAuthConfig
+const (
+	ModeNone   = "none"
+	ModeStatic = "static"
+	ModeJWT    = "jwt" // Reserved for service account tokens
+)
+
+// ServiceName identifies the orchestrator to the services it calls
+const ServiceName = "orchestrator"
+
+// ServiceNameHeader carries the name of the calling service, for logs
+const ServiceNameHeader = "X-Service-Name"
+
+// ErrUnauthorized is returned when a service rejects the orchestrator's token
+var ErrUnauthorized = errors.New("service token rejected")
+
+// TokenSource provides the token presented on each call. Static tokens
+// never change; service account tokens will be refreshed by their source.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a pre-shared token
+type StaticToken string
+
+// Token returns the token
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// NewTokenSource returns the token source configured for one service, or
+// nil when calls to it are not authenticated
+func NewTokenSource(cfg config.ServiceAuthConfig) (TokenSource, error) {
+	switch cfg.Mode {
+	case "", ModeNone:
+		return nil, nil
+	case ModeStatic:
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("service auth mode static requires a token")
+		}
+		return StaticToken(cfg.Token), nil
+	case ModeJWT:
+		return nil, fmt.Errorf("service auth mode jwt is not supported yet")
+	default:
+		return nil, fmt.Errorf("unsupported service auth mode: %s", cfg.Mode)
+	}
+}
+
+// SetHeaders adds the token and service name to an outgoing request. A nil
+// source leaves the header unchanged.
+func SetHeaders(ctx context.Context, header http.Header, source TokenSource) error {
+	if source == nil {
+		return nil
+	}
+	token, err := source.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get service token: %w", err)
+	}
+	header.Set("Authorization", "Bearer "+token)
+	header.Set(ServiceNameHeader, ServiceName)
+	return nil
+}
+
+// RPCCredentials adapts a token source to gRPC per-call credentials
+type RPCCredentials struct {
+	Source TokenSource
+	// Secure requires a TLS connection before the token is sent
+	Secure bool
+}
+
+var _ credentials.PerRPCCredentials = RPCCredentials{}
+
+// GetRequestMetadata returns the metadata attached to each call
+func (c RPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.Source.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service token: %w", err)
+	}
+	return map[string]string{
+		"authorization":  "Bearer " + token,
+		"x-service-name": ServiceName,
+	}, nil
+}
+
+// RequireTransportSecurity reports whether the token needs TLS
+func (c RPCCredentials) RequireTransportSecurity() bool {
+	return c.Secure
+}
+
+// Rejected wraps ErrUnauthorized with the service that rejected the token
+// and the config key holding it
+func Rejected(service, configKey string) error {
+	return fmt.Errorf("%w by the %s, check %s", ErrUnauthorized, service, configKey)
+}
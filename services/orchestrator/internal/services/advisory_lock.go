@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Locker takes named locks shared by every worker
+type Locker interface {
+	// TryLock takes key unless it is held, returning a token that unlocks it.
+	// The lock is released after ttl if it is not unlocked before.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (token string, ok bool, err error)
+	Unlock(ctx context.Context, token string) error
+}
+
+// AdvisoryLocker takes Postgres session advisory locks. Each lock holds a
+// connection of the pool until it is released, so it is meant for the few
+// short locks taken while Redis is unreachable.
+type AdvisoryLocker struct {
+	db *gorm.DB
+
+	mu    sync.Mutex
+	held  map[string]*advisoryLock
+	after func(time.Duration, func()) *time.Timer
+}
+
+type advisoryLock struct {
+	id     int64
+	conn   *sql.Conn
+	expiry *time.Timer
+}
+
+// NewAdvisoryLocker takes locks as Postgres advisory locks of db
+func NewAdvisoryLocker(db *gorm.DB) *AdvisoryLocker {
+	return &AdvisoryLocker{db: db, held: make(map[string]*advisoryLock), after: time.AfterFunc}
+}
+
+// advisoryLockID maps a lock name onto the bigint key space of advisory locks
+func advisoryLockID(key string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return int64(hash.Sum64())
+}
+
+func (l *AdvisoryLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return "", false, err
+	}
+	// Session locks belong to a connection, so the lock keeps its own
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get connection for advisory lock: %w", err)
+	}
+	id := advisoryLockID(key)
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", id).Scan(&ok); err != nil {
+		conn.Close()
+		return "", false, fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+	if !ok {
+		conn.Close()
+		return "", false, nil
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		l.release(&advisoryLock{id: id, conn: conn})
+		return "", false, err
+	}
+	token := hex.EncodeToString(raw)
+	lock := &advisoryLock{id: id, conn: conn}
+	l.mu.Lock()
+	l.held[token] = lock
+	lock.expiry = l.after(ttl, func() { l.Unlock(context.Background(), token) })
+	l.mu.Unlock()
+	return token, true, nil
+}
+
+// Unlock releases the lock of token. Unknown tokens, e.g. of locks that
+// expired, are ignored.
+func (l *AdvisoryLocker) Unlock(ctx context.Context, token string) error {
+	l.mu.Lock()
+	lock, ok := l.held[token]
+	delete(l.held, token)
+	l.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if lock.expiry != nil {
+		lock.expiry.Stop()
+	}
+	return l.release(lock)
+}
+
+func (l *AdvisoryLocker) release(lock *advisoryLock) error {
+	_, err := lock.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lock.id)
+	if err != nil {
+		// Discard the connection rather than return it to the pool still
+		// holding the lock; ending the session releases it
+		lock.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		return err
+	}
+	return lock.conn.Close()
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Outcomes of a task's preference for the agent of its task group
+const (
+	// AffinityHonored means the preferred agent ran the task
+	AffinityHonored = "honored"
+	// AffinityBroken means the preferred agent could not take the task and
+	// the agent was selected as usual
+	AffinityBroken = "broken"
+)
+
+// AffinityMetadataKey is the workflow metadata key of its affinity decisions
+const AffinityMetadataKey = "agent_affinity"
+
+// AffinityDecision records whether a task ran on the agent preferred for its
+// task group
+type AffinityDecision struct {
+	TaskID         string `json:"task_id"`
+	GroupKey       string `json:"group_key"`
+	PreferredAgent string `json:"preferred_agent"`
+	AgentID        string `json:"agent_id,omitempty"`
+	Outcome        string `json:"outcome"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// AffinityMetrics summarizes the affinity decisions of a workflow
+type AffinityMetrics struct {
+	Honored   int                `json:"honored"`
+	Broken    int                `json:"broken"`
+	Decisions []AffinityDecision `json:"decisions"`
+}
+
+// NewAffinityMetrics counts the outcomes of the given decisions
+func NewAffinityMetrics(decisions []AffinityDecision) *AffinityMetrics {
+	metrics := &AffinityMetrics{Decisions: decisions}
+	for _, decision := range decisions {
+		switch decision.Outcome {
+		case AffinityHonored:
+			metrics.Honored++
+		case AffinityBroken:
+			metrics.Broken++
+		}
+	}
+	return metrics
+}
+
+// Reusable reports whether a preferred agent may take another task of its
+// group: it must be available and below its type's utilization threshold.
+// The reason explains a refusal.
+func (p AgentSelectionPolicy) Reusable(agent Agent) (bool, string) {
+	if agent.Status != "available" && agent.Status != "active" {
+		return false, fmt.Sprintf("agent %s is %s", agent.ID, agent.Status)
+	}
+	if p.Saturated(agent) {
+		return false, fmt.Sprintf("agent %s load %.2f at or above threshold %.2f", agent.ID, agent.Load, p.Threshold(agent.Type))
+	}
+	return true, ""
+}
+
+// affinityFromMetadata reads the affinity decisions recorded in a workflow's
+// metadata, nil when there are none
+func affinityFromMetadata(metadata json.RawMessage) *AffinityMetrics {
+	if len(metadata) == 0 {
+		return nil
+	}
+	var fields struct {
+		Affinity *AffinityMetrics `json:"agent_affinity"`
+	}
+	if err := json.Unmarshal(metadata, &fields); err != nil {
+		return nil
+	}
+	return fields.Affinity
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentSelectionPolicy_Reusable(t *testing.T) {
+	policy := AgentSelectionPolicy{UtilizationThreshold: 0.8}
+
+	ok, _ := policy.Reusable(Agent{ID: "a", Type: "backend", Status: "available", Load: 0.5})
+	assert.True(t, ok)
+
+	ok, reason := policy.Reusable(Agent{ID: "a", Type: "backend", Status: "offline"})
+	assert.False(t, ok)
+	assert.Equal(t, "agent a is offline", reason)
+
+	ok, reason = policy.Reusable(Agent{ID: "a", Type: "backend", Status: "active", Load: 0.9})
+	assert.False(t, ok)
+	assert.Contains(t, reason, "at or above threshold 0.80")
+}
+
+func TestAffinityFromMetadata(t *testing.T) {
+	assert.Nil(t, affinityFromMetadata(nil))
+	assert.Nil(t, affinityFromMetadata(json.RawMessage(`{"owner":"team-a"}`)))
+
+	metadata, err := json.Marshal(map[string]interface{}{
+		"owner": "team-a",
+		AffinityMetadataKey: NewAffinityMetrics([]AffinityDecision{
+			{TaskID: "task-2", GroupKey: "component:orders", PreferredAgent: "a", AgentID: "a", Outcome: AffinityHonored},
+			{TaskID: "task-3", GroupKey: "component:orders", PreferredAgent: "a", AgentID: "b", Outcome: AffinityBroken, Reason: "agent a is offline"},
+		}),
+	})
+	require.NoError(t, err)
+
+	affinity := affinityFromMetadata(metadata)
+	require.NotNil(t, affinity)
+	assert.Equal(t, 1, affinity.Honored)
+	assert.Equal(t, 1, affinity.Broken)
+	require.Len(t, affinity.Decisions, 2)
+	assert.Equal(t, "b", affinity.Decisions[1].AgentID)
+}
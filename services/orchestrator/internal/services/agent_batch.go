@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultBatchParallelism is the number of tasks of a batch dispatched at
+	// once when BatchOptions.MaxParallel is not set
+	DefaultBatchParallelism = 10
+	// MaxBatchSize is the largest number of tasks accepted in one batch
+	MaxBatchSize = 500
+	// defaultBatchTaskTimeout is assumed for tasks without a timeout when
+	// deriving the batch deadline
+	defaultBatchTaskTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidBatch is returned for batches that cannot be dispatched
+	ErrInvalidBatch = errors.New("invalid task batch")
+	// ErrBatchAborted is returned when a fail-fast batch stops at the first
+	// failed task
+	ErrBatchAborted = errors.New("task batch aborted")
+)
+
+// AgentTaskRequest is one task of a batch and the agent it runs on
+type AgentTaskRequest struct {
+	AgentID string             `json:"agent_id"`
+	Task    ExecuteTaskRequest `json:"task"`
+}
+
+// BatchOptions controls how a task batch is dispatched
+type BatchOptions struct {
+	// MaxParallel bounds the number of tasks in flight
+	MaxParallel int `json:"max_parallel,omitempty"`
+	// FailFast cancels the remaining tasks after the first failure instead
+	// of collecting every error
+	FailFast bool `json:"fail_fast,omitempty"`
+	// Deadline bounds the whole batch. When unset it is derived from the
+	// longest task timeout and the number of waves at MaxParallel.
+	Deadline time.Duration `json:"deadline,omitempty"`
+	// OnProgress is called after each task finishes
+	OnProgress func(completed, total int) `json:"-"`
+}
+
+// AgentTaskResult is the outcome of one task of a batch
+type AgentTaskResult struct {
+	AgentID   string         `json:"agent_id"`
+	Execution *TaskExecution `json:"execution,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Skipped   bool           `json:"skipped,omitempty"`
+	Duration  time.Duration  `json:"duration"`
+}
+
+// BatchResult holds the results of a batch in request order
+type BatchResult struct {
+	Results   []AgentTaskResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Skipped   int               `json:"skipped"`
+	Aborted   bool              `json:"aborted"`
+	Duration  time.Duration     `json:"duration"`
+}
+
+// ExecuteTaskBatch runs tasks on agents concurrently with bounded parallelism.
+// Agent managers with batch execution run the batch in one request;
+// otherwise the client dispatches the tasks itself.
+func (c *AgentClient) ExecuteTaskBatch(ctx context.Context, requests []AgentTaskRequest, opts BatchOptions) (*BatchResult, error) {
+	ctx, span := c.tracer.Start(ctx, "ExecuteTaskBatch",
+		trace.WithAttributes(
+			attribute.Int("batch.size", len(requests)),
+			attribute.Bool("batch.fail_fast", opts.FailFast),
+		),
+	)
+	defer span.End()
+
+	if !c.Supports(FeatureBatchExecute) {
+		return ExecuteTaskBatch(ctx, c, requests, opts)
+	}
+	return c.executeRemoteBatch(ctx, requests, opts)
+}
+
+// executeRemoteBatch runs a batch through the agent manager's batch
+// endpoint. Progress is only reported once the batch finished.
+func (c *AgentClient) executeRemoteBatch(ctx context.Context, requests []AgentTaskRequest, opts BatchOptions) (*BatchResult, error) {
+	if err := validateBatch(requests); err != nil {
+		return nil, err
+	}
+	body := struct {
+		Tasks []AgentTaskRequest `json:"tasks"`
+		BatchOptions
+	}{requests, opts}
+
+	url := fmt.Sprintf("%s/api/v1/tasks/batch-execute", c.config.BaseURL)
+	var result BatchResult
+	if _, err := c.doRequest(ctx, http.MethodPost, url, body, &result); err != nil {
+		return nil, err
+	}
+	if opts.OnProgress != nil {
+		completed := len(requests) - result.Skipped
+		opts.OnProgress(completed, len(requests))
+	}
+	if result.Aborted {
+		return &result, fmt.Errorf("%w: aborted by the agent manager", ErrBatchAborted)
+	}
+	return &result, nil
+}
+
+// ExecuteTaskBatch runs tasks through an executor concurrently. Results keep
+// the order of the requests. Task failures are reported per result; the
+// returned error is only set for invalid batches and aborted fail-fast
+// batches, in which case the partial result is still returned. Tasks not
+// started before an abort or the batch deadline are marked skipped.
+func ExecuteTaskBatch(ctx context.Context, executor AgentExecutor, requests []AgentTaskRequest, opts BatchOptions) (*BatchResult, error) {
+	if err := validateBatch(requests); err != nil {
+		return nil, err
+	}
+
+	parallel := opts.MaxParallel
+	if parallel <= 0 {
+		parallel = DefaultBatchParallelism
+	}
+	if parallel > len(requests) {
+		parallel = len(requests)
+	}
+
+	deadline := opts.Deadline
+	if deadline <= 0 {
+		deadline = batchDeadline(requests, parallel)
+	}
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+
+	start := time.Now()
+	result := &BatchResult{Results: make([]AgentTaskResult, len(requests))}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		completed int
+		firstErr  error
+	)
+	slots := make(chan struct{}, parallel)
+
+	for i := range requests {
+		request := requests[i]
+		result.Results[i].AgentID = request.AgentID
+
+		// Wait for a free slot; stop dispatching once the batch is cancelled
+		acquired := false
+		select {
+		case slots <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			if acquired {
+				<-slots
+			}
+			for j := i; j < len(requests); j++ {
+				result.Results[j].AgentID = requests[j].AgentID
+				result.Results[j].Skipped = true
+				result.Results[j].Error = fmt.Sprintf("not started: %v", ctx.Err())
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			taskStart := time.Now()
+			task := request.Task
+			execution, err := executor.ExecuteTask(ctx, request.AgentID, &task)
+
+			mu.Lock()
+			defer mu.Unlock()
+			entry := &result.Results[i]
+			entry.Duration = time.Since(taskStart)
+			if err != nil {
+				entry.Error = err.Error()
+				if opts.FailFast && firstErr == nil {
+					firstErr = fmt.Errorf("%w: agent %s: %v", ErrBatchAborted, request.AgentID, err)
+					cancel()
+				}
+			} else {
+				entry.Execution = execution
+			}
+			completed++
+			if opts.OnProgress != nil {
+				opts.OnProgress(completed, len(requests))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, entry := range result.Results {
+		switch {
+		case entry.Skipped:
+			result.Skipped++
+		case entry.Error != "":
+			result.Failed++
+		default:
+			result.Succeeded++
+		}
+	}
+	result.Duration = time.Since(start)
+	result.Aborted = firstErr != nil
+	return result, firstErr
+}
+
+// validateBatch rejects batches that are empty, too large or incomplete
+func validateBatch(requests []AgentTaskRequest) error {
+	if len(requests) == 0 {
+		return fmt.Errorf("%w: no tasks", ErrInvalidBatch)
+	}
+	if len(requests) > MaxBatchSize {
+		return fmt.Errorf("%w: %d tasks exceeds the limit of %d", ErrInvalidBatch, len(requests), MaxBatchSize)
+	}
+	for i, request := range requests {
+		if request.AgentID == "" {
+			return fmt.Errorf("%w: task %d has no agent_id", ErrInvalidBatch, i)
+		}
+		if request.Task.Type == "" {
+			return fmt.Errorf("%w: task %d has no type", ErrInvalidBatch, i)
+		}
+	}
+	return nil
+}
+
+// batchDeadline allows each wave of parallel tasks the longest task timeout,
+// which is shorter than the sum of the timeouts whenever tasks run in
+// parallel
+func batchDeadline(requests []AgentTaskRequest, parallel int) time.Duration {
+	longest := time.Duration(0)
+	for _, request := range requests {
+		timeout := time.Duration(request.Task.Timeout) * time.Second
+		if timeout <= 0 {
+			timeout = defaultBatchTaskTimeout
+		}
+		if timeout > longest {
+			longest = timeout
+		}
+	}
+	waves := (len(requests) + parallel - 1) / parallel
+	return time.Duration(waves) * longest
+}
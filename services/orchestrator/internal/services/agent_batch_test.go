@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// batchAgentManager is a fake Agent Manager whose execute endpoint sleeps for
+// the given delay, fails for agents named "failing-*" and records the peak
+// number of concurrent requests
+type batchAgentManager struct {
+	delay    time.Duration
+	inFlight int32
+	peak     int32
+	mu       sync.Mutex
+	agents   []string
+}
+
+func (m *batchAgentManager) serve(t *testing.T) *AgentClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&m.inFlight, 1)
+		defer atomic.AddInt32(&m.inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&m.peak)
+			if current <= peak || atomic.CompareAndSwapInt32(&m.peak, peak, current) {
+				break
+			}
+		}
+
+		agentID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/agents/"), "/execute")
+		m.mu.Lock()
+		m.agents = append(m.agents, agentID)
+		m.mu.Unlock()
+
+		select {
+		case <-time.After(m.delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(agentID, "failing-") {
+			w.WriteHeader(http.StatusInternalServerError)
+			assert.NoError(t, json.NewEncoder(w).Encode(ErrorResponse{Code: "EXEC_FAILED", Message: "smoke test failed"}))
+			return
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(TaskExecution{ID: "task-" + agentID, AgentID: agentID, Status: "completed"}))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+	require.NoError(t, err)
+	return client
+}
+
+func batchRequests(agentIDs ...string) []AgentTaskRequest {
+	requests := make([]AgentTaskRequest, len(agentIDs))
+	for i, agentID := range agentIDs {
+		requests[i] = AgentTaskRequest{AgentID: agentID, Task: ExecuteTaskRequest{Type: "smoke_test", Timeout: 30}}
+	}
+	return requests
+}
+
+func TestExecuteTaskBatch_BoundsParallelismAndKeepsOrder(t *testing.T) {
+	manager := &batchAgentManager{delay: 30 * time.Millisecond}
+	client := manager.serve(t)
+
+	var agentIDs []string
+	for i := 0; i < 12; i++ {
+		agentIDs = append(agentIDs, fmt.Sprintf("env-%02d", i))
+	}
+
+	var progress []int
+	result, err := client.ExecuteTaskBatch(context.Background(), batchRequests(agentIDs...), BatchOptions{
+		MaxParallel: 3,
+		OnProgress: func(completed, total int) {
+			assert.Equal(t, 12, total)
+			progress = append(progress, completed)
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&manager.peak))
+	assert.Equal(t, 12, result.Succeeded)
+	assert.False(t, result.Aborted)
+	for i, entry := range result.Results {
+		assert.Equal(t, agentIDs[i], entry.AgentID)
+		require.NotNil(t, entry.Execution)
+		assert.Equal(t, "task-"+agentIDs[i], entry.Execution.ID)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, progress)
+}
+
+func TestExecuteTaskBatch_CollectsAllErrors(t *testing.T) {
+	manager := &batchAgentManager{delay: 5 * time.Millisecond}
+	client := manager.serve(t)
+
+	result, err := client.ExecuteTaskBatch(context.Background(),
+		batchRequests("env-a", "failing-b", "env-c", "failing-d", "env-e"), BatchOptions{MaxParallel: 2})
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, result.Succeeded)
+	assert.Equal(t, 2, result.Failed)
+	assert.Zero(t, result.Skipped)
+	assert.Len(t, manager.agents, 5)
+	assert.Contains(t, result.Results[1].Error, "smoke test failed")
+	assert.Contains(t, result.Results[3].Error, "smoke test failed")
+	assert.Empty(t, result.Results[4].Error)
+}
+
+func TestExecuteTaskBatch_FailFast(t *testing.T) {
+	manager := &batchAgentManager{delay: 5 * time.Millisecond}
+	client := manager.serve(t)
+
+	result, err := client.ExecuteTaskBatch(context.Background(),
+		batchRequests("env-a", "failing-b", "env-c", "env-d"), BatchOptions{MaxParallel: 1, FailFast: true})
+	require.ErrorIs(t, err, ErrBatchAborted)
+	require.NotNil(t, result)
+
+	assert.True(t, result.Aborted)
+	assert.Equal(t, 1, result.Succeeded)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 2, result.Skipped)
+	assert.True(t, result.Results[2].Skipped)
+	assert.True(t, result.Results[3].Skipped)
+	assert.Equal(t, []string{"env-a", "failing-b"}, manager.agents)
+}
+
+func TestExecuteTaskBatch_Deadline(t *testing.T) {
+	manager := &batchAgentManager{delay: time.Second}
+	client := manager.serve(t)
+
+	start := time.Now()
+	result, err := client.ExecuteTaskBatch(context.Background(),
+		batchRequests("env-a", "env-b", "env-c"), BatchOptions{MaxParallel: 1, Deadline: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 2, result.Skipped)
+	assert.Contains(t, result.Results[1].Error, "deadline exceeded")
+}
+
+func TestBatchDeadline(t *testing.T) {
+	requests := batchRequests("a", "b", "c", "d", "e", "f", "g", "h", "i", "j")
+	requests[4].Task.Timeout = 0 // falls back to the default task timeout
+
+	// Two waves of five, each allowed the longest task timeout
+	assert.Equal(t, 2*defaultBatchTaskTimeout, batchDeadline(requests, 5))
+	assert.Less(t, batchDeadline(batchRequests("a", "b", "c", "d"), 4), 4*30*time.Second)
+}
+
+func TestExecuteTaskBatch_RejectsInvalidBatches(t *testing.T) {
+	client := (&batchAgentManager{}).serve(t)
+
+	_, err := client.ExecuteTaskBatch(context.Background(), nil, BatchOptions{})
+	assert.ErrorIs(t, err, ErrInvalidBatch)
+
+	_, err = client.ExecuteTaskBatch(context.Background(), batchRequests("env-a", ""), BatchOptions{})
+	assert.ErrorIs(t, err, ErrInvalidBatch)
+
+	_, err = client.ExecuteTaskBatch(context.Background(), make([]AgentTaskRequest, MaxBatchSize+1), BatchOptions{})
+	assert.ErrorIs(t, err, ErrInvalidBatch)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
@@ -17,6 +18,11 @@ import (
 	"go.uber.org/zap"
 
 	"orchestrator/internal/config"
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/models"
+	"orchestrator/internal/requestid"
+	"orchestrator/internal/serviceauth"
+	"orchestrator/pkg/agentconn"
 )
 
 // AgentClient handles communication with the Agent Manager service
@@ -26,10 +32,52 @@ type AgentClient struct {
 	config           *config.AgentManagerConfig
 	logger           *zap.Logger
 	tracer           trace.Tracer
+	tokens           serviceauth.TokenSource
 	wsConnections    map[string]*AgentConnection
 	wsConnectionsMux sync.RWMutex
+	handlers         map[string]AgentMessageHandler
+	handlersMux      sync.RWMutex
+	listCache        *AgentListCache
+	drift            *CapabilityDriftDetector
+	version          agentVersionState
+	conversations    *localConversations
+	usage            *UsageMeter
 }
 
+// AgentExecutor is the part of the Agent Manager API used by workflow
+// activities. AgentClient talks to the real service; StubAgentClient serves
+// canned responses for simulated workflows.
+type AgentExecutor interface {
+	CreateAgent(ctx context.Context, req *CreateAgentRequest) (*Agent, error)
+	GetAgent(ctx context.Context, agentID string) (*Agent, error)
+	ListAgents(ctx context.Context, filters *AgentFilters) (*AgentList, error)
+	ExecuteTask(ctx context.Context, agentID string, req *ExecuteTaskRequest) (*TaskExecution, error)
+}
+
+var _ AgentExecutor = (*AgentClient)(nil)
+
+// TaskMonitor polls and cancels tasks while they run on an agent. Activities
+// use it to detect agents that stopped responding mid-task; executors
+// without it are not watched.
+type TaskMonitor interface {
+	GetTaskStatus(ctx context.Context, agentID, taskID string) (*TaskExecution, error)
+	CancelTask(ctx context.Context, agentID, taskID string) error
+}
+
+var _ TaskMonitor = (*AgentClient)(nil)
+
+// AgentMessage is the envelope of the messages agents send over their
+// WebSocket connection. Payload is decoded according to Type.
+type AgentMessage struct {
+	ID        string          `json:"id,omitempty"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// AgentMessageHandler handles the messages of one type received from an agent
+type AgentMessageHandler func(agentID string, msg AgentMessage)
+
 // AgentConnection represents a WebSocket connection to an agent
 type AgentConnection struct {
 	conn         *websocket.Conn
@@ -46,6 +94,11 @@ type AgentConnection struct {
 
 // NewAgentClient creates a new Agent Manager client
 func NewAgentClient(cfg *config.AgentManagerConfig, logger *zap.Logger) (*AgentClient, error) {
+	tokens, err := serviceauth.NewTokenSource(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure agent manager auth: %w", err)
+	}
+
 	httpClient := &http.Client{
 		Timeout: time.Duration(cfg.HTTPTimeout) * time.Second,
 		Transport: &http.Transport{
@@ -62,18 +115,53 @@ func NewAgentClient(cfg *config.AgentManagerConfig, logger *zap.Logger) (*AgentC
 		EnableCompression: cfg.EnableCompression,
 	}
 
-	return &AgentClient{
+	client := &AgentClient{
 		httpClient:    httpClient,
 		wsDialer:      wsDialer,
 		config:        cfg,
 		logger:        logger,
 		tracer:        otel.Tracer("agent-client"),
+		tokens:        tokens,
 		wsConnections: make(map[string]*AgentConnection),
-	}, nil
+	}
+	client.conversations = newLocalConversations("local-conversation", client.ExecuteTask)
+	return client, nil
+}
+
+// SetListCache caches the agent lists of ListAgents. The cache is dropped
+// whenever the client creates, updates, deletes or spawns an agent.
+func (c *AgentClient) SetListCache(cache *AgentListCache) {
+	c.listCache = cache
+}
+
+// SetCapabilityDrift annotates the agents the client gets and lists with
+// the capability flags of the detector
+func (c *AgentClient) SetCapabilityDrift(drift *CapabilityDriftDetector) {
+	c.drift = drift
+}
+
+// annotateCapabilityFlags sets the capability flags of agents. Agents are
+// still returned, unflagged, when the flags cannot be read.
+func (c *AgentClient) annotateCapabilityFlags(ctx context.Context, agents []Agent) {
+	if c.drift == nil {
+		return
+	}
+	if err := c.drift.annotate(ctx, agents); err != nil {
+		c.logger.Warn("Failed to read agent capability flags", zap.Error(err))
+	}
+}
+
+// invalidateAgentLists drops the cached agent lists after an agent changed
+func (c *AgentClient) invalidateAgentLists(ctx context.Context) {
+	if c.listCache != nil {
+		c.listCache.Invalidate(ctx)
+	}
 }
 
 // Close closes all connections
 func (c *AgentClient) Close() error {
+	c.stopVersionChecks()
+
 	c.wsConnectionsMux.Lock()
 	defer c.wsConnectionsMux.Unlock()
 
@@ -96,12 +184,21 @@ func (c *AgentClient) CreateAgent(ctx context.Context, req *CreateAgentRequest)
 	)
 	defer span.End()
 
+	var body interface{} = req
+	if capabilities := c.capabilityObjects(req.Capabilities); capabilities != nil {
+		body = struct {
+			*CreateAgentRequest
+			Capabilities []Capability `json:"capabilities"`
+		}{req, capabilities}
+	}
+
 	url := fmt.Sprintf("%s/api/v1/agents", c.config.BaseURL)
 	var agent Agent
-	_, err := c.doRequest(ctx, http.MethodPost, url, req, &agent)
+	_, err := c.doRequest(ctx, http.MethodPost, url, body, &agent)
 	if err != nil {
 		return nil, err
 	}
+	c.invalidateAgentLists(ctx)
 	return &agent, nil
 }
 
@@ -120,30 +217,47 @@ func (c *AgentClient) GetAgent(ctx context.Context, agentID string) (*Agent, err
 	if err != nil {
 		return nil, err
 	}
-	return &agent, nil
+	agents := []Agent{agent}
+	c.annotateCapabilityFlags(ctx, agents)
+	return &agents[0], nil
 }
 
-// ListAgents lists agents with filters
+// ListAgents lists agents with filters. Capability, load and heartbeat
+// filters are applied client-side over every page the agent manager returns.
+// With a list cache set, lists are served from it unless filters ask for
+// fresh agents.
 func (c *AgentClient) ListAgents(ctx context.Context, filters *AgentFilters) (*AgentList, error) {
 	ctx, span := c.tracer.Start(ctx, "ListAgents")
 	defer span.End()
 
-	url := fmt.Sprintf("%s/api/v1/agents", c.config.BaseURL)
-	// Add query parameters based on filters
-	if filters != nil {
-		// Build query string
-		params := buildQueryParams(filters)
-		if params != "" {
-			url += "?" + params
+	if c.listCache != nil {
+		return c.listCache.List(ctx, filters, func(ctx context.Context) (*AgentList, error) {
+			return c.listAgents(ctx, filters)
+		})
+	}
+	return c.listAgents(ctx, filters)
+}
+
+// listAgents lists agents from the agent manager
+func (c *AgentClient) listAgents(ctx context.Context, filters *AgentFilters) (*AgentList, error) {
+	if filters == nil || !filters.needsClientSideFiltering() {
+		list, err := c.listAgentsPage(ctx, filters)
+		if err != nil {
+			return nil, err
 		}
+		c.annotateCapabilityFlags(ctx, list.Agents)
+		return list, nil
 	}
 
-	var agentList AgentList
-	_, err := c.doRequest(ctx, http.MethodGet, url, nil, &agentList)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("agents.client_side_filtering", true))
+	agents, err := c.listAllAgents(ctx, *filters)
 	if err != nil {
 		return nil, err
 	}
-	return &agentList, nil
+	// Flags weigh in on capability match scores
+	c.annotateCapabilityFlags(ctx, agents)
+
+	return NewAgentListPage(filterAgents(agents, filters, time.Now()), filters), nil
 }
 
 // UpdateAgent updates agent configuration
@@ -155,15 +269,38 @@ func (c *AgentClient) UpdateAgent(ctx context.Context, agentID string, req *Upda
 	)
 	defer span.End()
 
+	var body interface{} = req
+	if capabilities := c.capabilityObjects(req.Capabilities); capabilities != nil {
+		body = struct {
+			*UpdateAgentRequest
+			Capabilities []Capability `json:"capabilities,omitempty"`
+		}{req, capabilities}
+	}
+
 	url := fmt.Sprintf("%s/api/v1/agents/%s", c.config.BaseURL, agentID)
 	var agent Agent
-	_, err := c.doRequest(ctx, http.MethodPut, url, req, &agent)
+	_, err := c.doRequest(ctx, http.MethodPut, url, body, &agent)
 	if err != nil {
 		return nil, err
 	}
+	c.invalidateAgentLists(ctx)
 	return &agent, nil
 }
 
+// capabilityObjects converts capability names to the objects agent managers
+// with capability objects expect. It returns nil for agent managers taking
+// names.
+func (c *AgentClient) capabilityObjects(names []string) []Capability {
+	if len(names) == 0 || !c.Supports(FeatureCapabilityObjects) {
+		return nil
+	}
+	capabilities := make([]Capability, len(names))
+	for i, name := range names {
+		capabilities[i] = Capability{Name: name, Version: defaultCapabilityVersion}
+	}
+	return capabilities
+}
+
 // DeleteAgent deletes an agent
 func (c *AgentClient) DeleteAgent(ctx context.Context, agentID string) error {
 	ctx, span := c.tracer.Start(ctx, "DeleteAgent",
@@ -174,8 +311,11 @@ func (c *AgentClient) DeleteAgent(ctx context.Context, agentID string) error {
 	defer span.End()
 
 	url := fmt.Sprintf("%s/api/v1/agents/%s", c.config.BaseURL, agentID)
-	_, err := c.doRequest(ctx, http.MethodDelete, url, nil, nil)
-	return err
+	if _, err := c.doRequest(ctx, http.MethodDelete, url, nil, nil); err != nil {
+		return err
+	}
+	c.invalidateAgentLists(ctx)
+	return nil
 }
 
 // ExecuteTask executes a task on an agent
@@ -194,6 +334,11 @@ func (c *AgentClient) ExecuteTask(ctx context.Context, agentID string, req *Exec
 	if err != nil {
 		return nil, err
 	}
+	// Agents the meta-agent spawns join the fleet
+	if req.Type == "spawn-agent" {
+		c.invalidateAgentLists(ctx)
+	}
+	c.usage.Record(ctx, "", UsageMetricAgentTasks, 1)
 	return &taskExecution, nil
 }
 
@@ -252,12 +397,12 @@ func (c *AgentClient) ConnectToAgent(ctx context.Context, agentID, projectID str
 	c.wsConnectionsMux.RUnlock()
 
 	// Create new connection
-	url := fmt.Sprintf("%s/api/v1/agents/%s/connect", c.config.WebSocketURL, agentID)
-	
 	header := http.Header{}
-	header.Add("X-Project-ID", projectID)
+	if err := serviceauth.SetHeaders(ctx, header, c.tokens); err != nil {
+		return nil, err
+	}
 
-	wsConn, _, err := c.wsDialer.DialContext(ctx, url, header)
+	wsConn, _, err := agentconn.Dial(ctx, c.wsDialer, c.config.WebSocketURL, agentID, projectID, header)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to agent: %w", err)
 	}
@@ -282,7 +427,7 @@ func (c *AgentClient) ConnectToAgent(ctx context.Context, agentID, projectID str
 	})
 
 	// Start goroutines for reading and writing
-	go conn.readPump(c.logger)
+	go conn.readPump(c.logger, c.dispatch)
 	go conn.writePump(c.logger, c.config)
 
 	// Store connection
@@ -306,6 +451,19 @@ func (c *AgentClient) DisconnectFromAgent(agentID string) error {
 	return nil
 }
 
+// ConnectedAgents returns the IDs of the agents with an open WebSocket
+// connection
+func (c *AgentClient) ConnectedAgents() []string {
+	c.wsConnectionsMux.RLock()
+	defer c.wsConnectionsMux.RUnlock()
+
+	agentIDs := make([]string, 0, len(c.wsConnections))
+	for agentID := range c.wsConnections {
+		agentIDs = append(agentIDs, agentID)
+	}
+	return agentIDs
+}
+
 // SendMessage sends a message to an agent via WebSocket
 func (c *AgentClient) SendMessage(agentID string, message interface{}) error {
 	c.wsConnectionsMux.RLock()
@@ -329,6 +487,37 @@ func (c *AgentClient) SendMessage(agentID string, message interface{}) error {
 	}
 }
 
+// RegisterHandler routes the messages of a type received from any agent to
+// handler instead of ReceiveMessage. Handlers run on the connection's read
+// loop and must not block.
+func (c *AgentClient) RegisterHandler(messageType string, handler AgentMessageHandler) {
+	c.handlersMux.Lock()
+	defer c.handlersMux.Unlock()
+	if c.handlers == nil {
+		c.handlers = make(map[string]AgentMessageHandler)
+	}
+	c.handlers[messageType] = handler
+}
+
+// dispatch passes a message to the handler registered for its type, and
+// reports whether there was one
+func (c *AgentClient) dispatch(agentID string, data []byte) bool {
+	var msg AgentMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type == "" {
+		return false
+	}
+
+	c.handlersMux.RLock()
+	handler, ok := c.handlers[msg.Type]
+	c.handlersMux.RUnlock()
+	if !ok {
+		return false
+	}
+
+	handler(agentID, msg)
+	return true
+}
+
 // ReceiveMessage receives a message from an agent via WebSocket
 func (c *AgentClient) ReceiveMessage(agentID string, timeout time.Duration) ([]byte, error) {
 	c.wsConnectionsMux.RLock()
@@ -350,18 +539,34 @@ func (c *AgentClient) ReceiveMessage(agentID string, timeout time.Duration) ([]b
 // Helper methods
 
 func (c *AgentClient) doRequest(ctx context.Context, method, url string, body interface{}, result interface{}) (interface{}, error) {
+	if _, err := c.send(ctx, method, url, body, result); err != nil {
+		return nil, err
+	}
+	if result != nil {
+		return result, nil
+	}
+	return nil, nil
+}
+
+// send makes a request to the Agent Manager and decodes the response into
+// result. It returns the response status, 0 when no response was received.
+func (c *AgentClient) send(ctx context.Context, method, url string, body interface{}, result interface{}) (int, error) {
+	// Nobody waits for the response of a request whose deadline has passed
+	if err := deadline.Check(ctx, "calling the agent manager"); err != nil {
+		return 0, err
+	}
 	var reqBody io.Reader
 	if body != nil {
 		data, err := json.Marshal(body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+			return 0, fmt.Errorf("failed to marshal request body: %w", err)
 		}
 		reqBody = bytes.NewReader(data)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -372,34 +577,42 @@ func (c *AgentClient) doRequest(ctx context.Context, method, url string, body in
 		req.Header.Set("X-Trace-ID", span.SpanContext().TraceID().String())
 		req.Header.Set("X-Span-ID", span.SpanContext().SpanID().String())
 	}
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		req.Header.Set(requestid.Header, requestID)
+	}
+	deadline.SetHeader(ctx, req.Header)
+	if err := serviceauth.SetHeaders(ctx, req.Header, c.tokens); err != nil {
+		return 0, err
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return 0, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		return resp.StatusCode, serviceauth.Rejected("agent manager", "agent_manager.auth.token")
+	}
 	if resp.StatusCode >= 400 {
 		var errorResp ErrorResponse
 		if err := json.Unmarshal(respBody, &errorResp); err == nil {
-			return nil, fmt.Errorf("API error: %s (code: %s)", errorResp.Message, errorResp.Code)
+			return resp.StatusCode, agentManagerError(resp, fmt.Errorf("API error: %s (code: %s)", errorResp.Message, errorResp.Code))
 		}
-		return nil, fmt.Errorf("API error: %s", string(respBody))
+		return resp.StatusCode, agentManagerError(resp, fmt.Errorf("API error: %s", string(respBody)))
 	}
 
 	if result != nil {
 		if err := json.Unmarshal(respBody, result); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+			return resp.StatusCode, fmt.Errorf("failed to unmarshal response: %w", err)
 		}
-		return result, nil
 	}
-
-	return nil, nil
+	return resp.StatusCode, nil
 }
 
 // AgentConnection methods
@@ -412,7 +625,7 @@ func (conn *AgentConnection) Close() {
 	})
 }
 
-func (conn *AgentConnection) readPump(logger *zap.Logger) {
+func (conn *AgentConnection) readPump(logger *zap.Logger, dispatch func(agentID string, data []byte) bool) {
 	defer conn.Close()
 
 	for {
@@ -423,6 +636,9 @@ func (conn *AgentConnection) readPump(logger *zap.Logger) {
 			}
 			return
 		}
+		if dispatch(conn.agentID, message) {
+			continue
+		}
 
 		select {
 		case conn.receiveChan <- message:
@@ -491,6 +707,7 @@ type UpdateAgentRequest struct {
 }
 
 type ExecuteTaskRequest struct {
+	TaskID     string                 `json:"task_id,omitempty"` // Assigned by the caller so the task can be polled while it runs
 	Type       string                 `json:"type"`
 	Input      map[string]interface{} `json:"input"`
 	Config     map[string]interface{} `json:"config"`
@@ -500,29 +717,59 @@ type ExecuteTaskRequest struct {
 }
 
 type Capability struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Version     string `json:"version"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Version     string  `json:"version"`
+	Proficiency float64 `json:"proficiency,omitempty"` // Success rate the agent advertises, 0-1
+}
+
+// defaultCapabilityVersion is sent for capabilities only known by name
+const defaultCapabilityVersion = "1.0.0"
+
+// UnmarshalJSON accepts capabilities as objects or, from agent managers
+// without capability objects, as names
+func (c *Capability) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*c = Capability{Name: name}
+		return nil
+	}
+	type capability Capability
+	return json.Unmarshal(data, (*capability)(c))
 }
 
 type Agent struct {
-	ID           string                 `json:"id"`
-	Name         string                 `json:"name"`
-	Type         string                 `json:"type"`
-	Status       string                 `json:"status"`
-	ProjectID    string                 `json:"project_id"`
-	Config       map[string]interface{} `json:"config"`
-	Capabilities []Capability           `json:"capabilities"`
-	Tags         []string               `json:"tags"`
-	CreatedAt    time.Time              `json:"created_at"`
-	UpdatedAt    time.Time              `json:"updated_at"`
+	ID            string                 `json:"id"`
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	Status        string                 `json:"status"`
+	ProjectID     string                 `json:"project_id"`
+	Config        map[string]interface{} `json:"config"`
+	Capabilities  []Capability           `json:"capabilities"`
+	Tags          []string               `json:"tags"`
+	Load          float64                `json:"load"` // Fraction of capacity in use, 0-1
+	Region        string                 `json:"region,omitempty"` // Data residency region the agent runs in, as it reports it
+	LastHeartbeat *time.Time             `json:"last_heartbeat,omitempty"`
+	MatchScore    *float64               `json:"match_score,omitempty"` // Set when filtering by required capabilities
+	// CapabilityFlags are the capabilities whose observed success rate fell
+	// below their advertised proficiency; set with a drift detector
+	CapabilityFlags []models.AgentCapabilityFlag `json:"capability_flags,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
 }
 
+// AgentList is a page of agents, described both by page/page_size and by
+// offset/limit. TotalCount is only set when TotalKnown; HasMore tells whether
+// agents follow the page either way.
 type AgentList struct {
 	Agents     []Agent `json:"agents"`
 	TotalCount int64   `json:"total_count"`
+	TotalKnown bool    `json:"total_known"`
+	HasMore    bool    `json:"has_more"`
 	Page       int     `json:"page"`
 	PageSize   int     `json:"page_size"`
+	Offset     int     `json:"offset"`
+	Limit      int     `json:"limit"`
 }
 
 type TaskExecution struct {
@@ -545,6 +792,17 @@ type AgentFilters struct {
 	Tags      []string
 	Page      int
 	PageSize  int
+	Offset    int // First agent to list; takes precedence over Page
+
+	Capabilities         []string      // Agent must provide all of these
+	RequiredCapabilities []string      // Scored with CapabilityMatchScore
+	MinCapabilityMatch   float64       // Minimum score against RequiredCapabilities, 0-1
+	MaxLoad              *float64      // Maximum load, 0-1
+	HeartbeatWithin      time.Duration // Maximum age of the last heartbeat
+
+	// Fresh bypasses the agent list cache, for callers that must not act on
+	// stale agents. GetAgent is never cached.
+	Fresh bool `json:"-"`
 }
 
 type ErrorResponse struct {
@@ -566,6 +824,9 @@ func buildQueryParams(filters *AgentFilters) string {
 	if filters.Status != "" {
 		params += fmt.Sprintf("status=%s&", filters.Status)
 	}
+	for _, capability := range filters.Capabilities {
+		params += fmt.Sprintf("capabilities=%s&", url.QueryEscape(capability))
+	}
 	if filters.Page > 0 {
 		params += fmt.Sprintf("page=%d&", filters.Page)
 	}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/serviceauth"
+)
+
+// newAuthenticatingAgentManager starts a fake Agent Manager that accepts
+// any of the given tokens, as it does while a token is being rotated
+func newAuthenticatingAgentManager(t *testing.T, tokens ...string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for _, token := range tokens {
+			if r.Header.Get("Authorization") == "Bearer "+token {
+				assert.Equal(t, serviceauth.ServiceName, r.Header.Get(serviceauth.ServiceNameHeader))
+				assert.NoError(t, json.NewEncoder(w).Encode(Agent{ID: "agent-1", Status: "active"}))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		assert.NoError(t, json.NewEncoder(w).Encode(ErrorResponse{Code: "UNAUTHORIZED", Message: "Missing or invalid service token"}))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newAuthenticatedAgentClient(t *testing.T, baseURL string, auth config.ServiceAuthConfig) *AgentClient {
+	client, err := NewAgentClient(&config.AgentManagerConfig{BaseURL: baseURL, HTTPTimeout: 5, Auth: auth}, zap.NewNop())
+	require.NoError(t, err)
+	return client
+}
+
+func TestAgentClient_AcceptsOldAndNewTokenDuringRotation(t *testing.T) {
+	server := newAuthenticatingAgentManager(t, "old-token", "new-token")
+
+	for _, token := range []string{"old-token", "new-token"} {
+		client := newAuthenticatedAgentClient(t, server.URL, config.ServiceAuthConfig{Mode: serviceauth.ModeStatic, Token: token})
+
+		agent, err := client.GetAgent(context.Background(), "agent-1")
+		require.NoError(t, err, token)
+		assert.Equal(t, "agent-1", agent.ID)
+	}
+}
+
+func TestAgentClient_RejectedTokenNamesConfigKey(t *testing.T) {
+	server := newAuthenticatingAgentManager(t, "new-token")
+
+	client := newAuthenticatedAgentClient(t, server.URL, config.ServiceAuthConfig{Mode: serviceauth.ModeStatic, Token: "old-token"})
+	_, err := client.GetAgent(context.Background(), "agent-1")
+	require.ErrorIs(t, err, serviceauth.ErrUnauthorized)
+	assert.Contains(t, err.Error(), "agent_manager.auth.token")
+
+	client = newAuthenticatedAgentClient(t, server.URL, config.ServiceAuthConfig{Mode: serviceauth.ModeNone})
+	_, err = client.GetAgent(context.Background(), "agent-1")
+	assert.ErrorIs(t, err, serviceauth.ErrUnauthorized)
+}
+
+func TestNewAgentClient_RequiresStaticToken(t *testing.T) {
+	_, err := NewAgentClient(&config.AgentManagerConfig{
+		BaseURL:     "http://agent-manager",
+		HTTPTimeout: 5,
+		Auth:        config.ServiceAuthConfig{Mode: serviceauth.ModeStatic},
+	}, zap.NewNop())
+	assert.Error(t, err)
+}
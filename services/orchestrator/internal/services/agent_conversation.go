@@ -0,0 +1,285 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ConversationalAgent is implemented by agent clients that keep multi-turn
+// conversations with an agent. The agent manager holds the conversation
+// history, so each turn only carries what is new.
+type ConversationalAgent interface {
+	CreateConversation(ctx context.Context, agentID string, req *CreateConversationRequest) (*Conversation, error)
+	SendTurn(ctx context.Context, agentID, conversationID string, req *SendTurnRequest) (*ConversationTurn, error)
+	GetTurns(ctx context.Context, agentID, conversationID string) ([]ConversationTurn, error)
+	CloseConversation(ctx context.Context, agentID, conversationID string) error
+}
+
+var (
+	_ ConversationalAgent = (*AgentClient)(nil)
+	_ ConversationalAgent = (*StubAgentClient)(nil)
+)
+
+// CreateConversationRequest opens a conversation for a task type. Context is
+// shared by every turn of the conversation.
+type CreateConversationRequest struct {
+	Type    string                 `json:"type"`
+	Context map[string]interface{} `json:"context,omitempty"`
+	Config  map[string]interface{} `json:"config,omitempty"`
+}
+
+// SendTurnRequest is one message to an agent within a conversation
+type SendTurnRequest struct {
+	Input map[string]interface{} `json:"input"`
+}
+
+// Conversation is a multi-turn exchange with an agent
+type Conversation struct {
+	ID        string                 `json:"id"`
+	AgentID   string                 `json:"agent_id"`
+	Type      string                 `json:"type"`
+	Status    string                 `json:"status"`
+	Context   map[string]interface{} `json:"context,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// ConversationTurn is an agent's answer to one turn of a conversation
+type ConversationTurn struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"`
+	Index          int                    `json:"index"`
+	Status         string                 `json:"status"`
+	Input          map[string]interface{} `json:"input"`
+	Output         map[string]interface{} `json:"output"`
+	Error          string                 `json:"error,omitempty"`
+	Duration       int64                  `json:"duration"` // Milliseconds
+	Cost           float64                `json:"cost"`
+	CreatedAt      time.Time              `json:"created_at"`
+}
+
+// CreateConversation opens a conversation with an agent. Agent managers
+// without conversations get one emulated by the client.
+func (c *AgentClient) CreateConversation(ctx context.Context, agentID string, req *CreateConversationRequest) (*Conversation, error) {
+	if !c.Supports(FeatureConversations) {
+		return c.conversations.Create(agentID, req), nil
+	}
+	ctx, span := c.tracer.Start(ctx, "CreateConversation",
+		trace.WithAttributes(
+			attribute.String("agent.id", agentID),
+			attribute.String("conversation.type", req.Type),
+		),
+	)
+	defer span.End()
+
+	url := fmt.Sprintf("%s/api/v1/agents/%s/conversations", c.config.BaseURL, agentID)
+	var conversation Conversation
+	_, err := c.doRequest(ctx, http.MethodPost, url, req, &conversation)
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// SendTurn sends the next turn of a conversation and returns the agent's answer
+func (c *AgentClient) SendTurn(ctx context.Context, agentID, conversationID string, req *SendTurnRequest) (*ConversationTurn, error) {
+	if c.conversations.Owns(conversationID) {
+		return c.conversations.SendTurn(ctx, agentID, conversationID, req)
+	}
+	ctx, span := c.tracer.Start(ctx, "SendTurn",
+		trace.WithAttributes(
+			attribute.String("agent.id", agentID),
+			attribute.String("conversation.id", conversationID),
+		),
+	)
+	defer span.End()
+
+	url := fmt.Sprintf("%s/api/v1/agents/%s/conversations/%s/turns", c.config.BaseURL, agentID, conversationID)
+	var turn ConversationTurn
+	_, err := c.doRequest(ctx, http.MethodPost, url, req, &turn)
+	if err != nil {
+		return nil, err
+	}
+	return &turn, nil
+}
+
+// GetTurns lists the turns of a conversation in order
+func (c *AgentClient) GetTurns(ctx context.Context, agentID, conversationID string) ([]ConversationTurn, error) {
+	if c.conversations.Owns(conversationID) {
+		return c.conversations.GetTurns(conversationID)
+	}
+	ctx, span := c.tracer.Start(ctx, "GetTurns",
+		trace.WithAttributes(
+			attribute.String("agent.id", agentID),
+			attribute.String("conversation.id", conversationID),
+		),
+	)
+	defer span.End()
+
+	url := fmt.Sprintf("%s/api/v1/agents/%s/conversations/%s/turns", c.config.BaseURL, agentID, conversationID)
+	var result struct {
+		Turns []ConversationTurn `json:"turns"`
+	}
+	_, err := c.doRequest(ctx, http.MethodGet, url, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Turns, nil
+}
+
+// CloseConversation ends a conversation, releasing its history on the agent
+func (c *AgentClient) CloseConversation(ctx context.Context, agentID, conversationID string) error {
+	if c.conversations.Owns(conversationID) {
+		return c.conversations.Close(conversationID)
+	}
+	ctx, span := c.tracer.Start(ctx, "CloseConversation",
+		trace.WithAttributes(
+			attribute.String("agent.id", agentID),
+			attribute.String("conversation.id", conversationID),
+		),
+	)
+	defer span.End()
+
+	url := fmt.Sprintf("%s/api/v1/agents/%s/conversations/%s/close", c.config.BaseURL, agentID, conversationID)
+	_, err := c.doRequest(ctx, http.MethodPost, url, nil, nil)
+	return err
+}
+
+// localConversations emulates conversations for agents that cannot hold
+// them. Each turn is run as a task of the conversation's type whose input is
+// the conversation context overlaid with the turn input. The history is kept
+// in memory, so a conversation must not outlive the process that opened it.
+type localConversations struct {
+	prefix  string
+	execute func(ctx context.Context, agentID string, req *ExecuteTaskRequest) (*TaskExecution, error)
+
+	mu            sync.Mutex
+	conversations map[string]*localConversation
+}
+
+// localConversation is a conversation held by localConversations
+type localConversation struct {
+	Conversation
+	config map[string]interface{}
+	turns  []ConversationTurn
+}
+
+// newLocalConversations emulates conversations over execute, giving them IDs
+// starting with prefix
+func newLocalConversations(prefix string, execute func(ctx context.Context, agentID string, req *ExecuteTaskRequest) (*TaskExecution, error)) *localConversations {
+	return &localConversations{
+		prefix:        prefix,
+		execute:       execute,
+		conversations: make(map[string]*localConversation),
+	}
+}
+
+// Owns reports whether a conversation ID was given out by these conversations
+func (l *localConversations) Owns(conversationID string) bool {
+	return strings.HasPrefix(conversationID, l.prefix+"-")
+}
+
+// Create opens a conversation
+func (l *localConversations) Create(agentID string, req *CreateConversationRequest) *Conversation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conversation := &localConversation{
+		Conversation: Conversation{
+			ID:        fmt.Sprintf("%s-%d", l.prefix, len(l.conversations)+1),
+			AgentID:   agentID,
+			Type:      req.Type,
+			Status:    "open",
+			Context:   req.Context,
+			CreatedAt: time.Now(),
+		},
+		config: req.Config,
+	}
+	l.conversations[conversation.ID] = conversation
+	result := conversation.Conversation
+	return &result
+}
+
+// SendTurn runs a turn as a task and records the agent's answer
+func (l *localConversations) SendTurn(ctx context.Context, agentID, conversationID string, req *SendTurnRequest) (*ConversationTurn, error) {
+	conversation, err := l.open(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	input := make(map[string]interface{}, len(conversation.Context)+len(req.Input))
+	for key, value := range conversation.Context {
+		input[key] = value
+	}
+	for key, value := range req.Input {
+		input[key] = value
+	}
+	execution, err := l.execute(ctx, agentID, &ExecuteTaskRequest{
+		Type:   conversation.Type,
+		Input:  input,
+		Config: conversation.config,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	turn := ConversationTurn{
+		ID:             fmt.Sprintf("%s-turn-%d", conversationID, len(conversation.turns)+1),
+		ConversationID: conversationID,
+		Index:          len(conversation.turns),
+		Status:         execution.Status,
+		Input:          req.Input,
+		Output:         execution.Output,
+		Error:          execution.Error,
+		Duration:       execution.Duration,
+		CreatedAt:      execution.StartedAt,
+	}
+	conversation.turns = append(conversation.turns, turn)
+	return &turn, nil
+}
+
+// GetTurns lists the turns of a conversation in order
+func (l *localConversations) GetTurns(conversationID string) ([]ConversationTurn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conversation, ok := l.conversations[conversationID]
+	if !ok {
+		return nil, fmt.Errorf("conversation %s not found", conversationID)
+	}
+	return append([]ConversationTurn(nil), conversation.turns...), nil
+}
+
+// Close ends a conversation
+func (l *localConversations) Close(conversationID string) error {
+	conversation, err := l.open(conversationID)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	conversation.Status = "closed"
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *localConversations) open(conversationID string) (*localConversation, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	conversation, ok := l.conversations[conversationID]
+	if !ok {
+		return nil, fmt.Errorf("conversation %s not found", conversationID)
+	}
+	if conversation.Status != "open" {
+		return nil, fmt.Errorf("conversation %s is %s", conversationID, conversation.Status)
+	}
+	return conversation, nil
+}
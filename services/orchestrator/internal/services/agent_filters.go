@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// agentListFetchPageSize is the page size used when fetching every page
+	// from the agent manager for client-side filtering
+	agentListFetchPageSize = 100
+	// maxClientFilteredAgents bounds how many agents are fetched for
+	// client-side filtering
+	maxClientFilteredAgents = 1000
+)
+
+// CapabilityMatchScore returns the fraction of required capabilities the agent
+// provides, compared case-insensitively. Capabilities flagged for drift count
+// by their flag's weight. An empty requirement list matches every agent with
+// a score of 1.
+func CapabilityMatchScore(agent Agent, requiredCapabilities []string) float64 {
+	if len(requiredCapabilities) == 0 {
+		return 1.0
+	}
+
+	weights := CapabilityWeights(agent)
+	score := 0.0
+	for _, required := range requiredCapabilities {
+		score += weights[strings.ToLower(required)]
+	}
+
+	return score / float64(len(requiredCapabilities))
+}
+
+// CapabilityWeights returns the weight of each capability the agent provides
+// by lowercased name: 1, or the weight of the capability's drift flag
+func CapabilityWeights(agent Agent) map[string]float64 {
+	weights := make(map[string]float64, len(agent.Capabilities))
+	for _, capability := range agent.Capabilities {
+		weights[strings.ToLower(capability.Name)] = 1
+	}
+	for _, flag := range agent.CapabilityFlags {
+		if _, ok := weights[flag.Capability]; ok {
+			weights[flag.Capability] = flag.Weight
+		}
+	}
+	return weights
+}
+
+// providesCapabilities reports whether the agent provides all capabilities,
+// compared case-insensitively
+func providesCapabilities(agent Agent, capabilities []string) bool {
+	weights := CapabilityWeights(agent)
+	for _, capability := range capabilities {
+		if _, ok := weights[strings.ToLower(capability)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// needsClientSideFiltering reports whether the filters include criteria the
+// agent manager does not apply itself
+func (f *AgentFilters) needsClientSideFiltering() bool {
+	return len(f.Capabilities) > 0 ||
+		len(f.RequiredCapabilities) > 0 ||
+		f.MaxLoad != nil ||
+		f.HeartbeatWithin > 0
+}
+
+// listAllAgents fetches every page from the agent manager, up to
+// maxClientFilteredAgents agents. A page shorter than asked for is not taken
+// as the last one, since the agent manager may serve smaller pages; paging
+// stops at the reported total or, without one, at the first empty page.
+func (c *AgentClient) listAllAgents(ctx context.Context, filters AgentFilters) ([]Agent, error) {
+	var agents []Agent
+	for page := 1; len(agents) < maxClientFilteredAgents; page++ {
+		result, err := c.fetchAgentPage(ctx, &filters, page, agentListFetchPageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Agents) == 0 {
+			break
+		}
+
+		offset := len(agents)
+		agents = append(agents, result.Agents...)
+		if total, ok := result.total(offset); ok && int64(len(agents)) >= total {
+			break
+		}
+	}
+
+	if len(agents) > maxClientFilteredAgents {
+		agents = agents[:maxClientFilteredAgents]
+	}
+	return agents, nil
+}
+
+// filterAgents applies capability, load and heartbeat filters and annotates
+// each agent with its capability match score. When requirements are given the
+// result is ordered by descending score.
+func filterAgents(agents []Agent, filters *AgentFilters, now time.Time) []Agent {
+	result := make([]Agent, 0, len(agents))
+	for _, agent := range agents {
+		if !providesCapabilities(agent, filters.Capabilities) {
+			continue
+		}
+		if filters.MaxLoad != nil && agent.Load > *filters.MaxLoad {
+			continue
+		}
+		if filters.HeartbeatWithin > 0 &&
+			(agent.LastHeartbeat == nil || now.Sub(*agent.LastHeartbeat) > filters.HeartbeatWithin) {
+			continue
+		}
+
+		if len(filters.RequiredCapabilities) > 0 {
+			// Keep a score computed by the agent manager if it sent one
+			if agent.MatchScore == nil {
+				score := CapabilityMatchScore(agent, filters.RequiredCapabilities)
+				agent.MatchScore = &score
+			}
+			if *agent.MatchScore < filters.MinCapabilityMatch {
+				continue
+			}
+		}
+
+		result = append(result, agent)
+	}
+
+	if len(filters.RequiredCapabilities) > 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			return *result[i].MatchScore > *result[j].MatchScore
+		})
+	}
+	return result
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/models"
+)
+
+// Agent GC actions, used as audit actions and metric labels
+const (
+	AgentGCActionDeleted      = "deleted"
+	AgentGCActionDisconnected = "disconnected"
+)
+
+// agentGCActor is the audit actor for agents deleted and disconnected by the
+// orchestrator
+const agentGCActor = "system:agent-gc"
+
+// terminalWorkflowStatuses are the statuses of finished workflows
+var terminalWorkflowStatuses = []models.WorkflowStatus{
+	models.WorkflowStatusCompleted,
+	models.WorkflowStatusFailed,
+	models.WorkflowStatusCancelled,
+	models.WorkflowStatusTerminated,
+	models.WorkflowStatusTimedOut,
+}
+
+var agentGCActionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_agent_gc_actions_total",
+	Help: "Dynamic agents deleted and agent connections closed by the orchestrator, by action",
+}, []string{"action"})
+
+// AgentLifecycle looks up and deletes agents and closes the connections to
+// them
+type AgentLifecycle interface {
+	GetAgent(ctx context.Context, agentID string) (*Agent, error)
+	DeleteAgent(ctx context.Context, agentID string) error
+	DisconnectFromAgent(agentID string) error
+	ConnectedAgents() []string
+}
+
+var _ AgentLifecycle = (*AgentClient)(nil)
+
+// AgentGarbageCollector cleans up after agents on the orchestrator side. It
+// tracks the dynamic agents each workflow spawned or reused; once a
+// workflow finishes, the TTL-scoped agents the orchestrator spawned are
+// deleted and disconnected when no unfinished workflow uses them any more.
+// A periodic sweep closes the connections to agents the Agent Manager no
+// longer knows.
+type AgentGarbageCollector struct {
+	db       *gorm.DB
+	agents   AgentLifecycle
+	logger   *zap.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewAgentGarbageCollector creates a collector that sweeps connections
+// every interval
+func NewAgentGarbageCollector(db *gorm.DB, agents AgentLifecycle, logger *zap.Logger, interval time.Duration) *AgentGarbageCollector {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &AgentGarbageCollector{
+		db:       db,
+		agents:   agents,
+		logger:   logger,
+		interval: interval,
+		now:      time.Now,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start starts the periodic connection sweep
+func (g *AgentGarbageCollector) Start() {
+	g.wg.Add(1)
+	go g.run()
+	g.logger.Info("Agent garbage collector started", zap.Duration("interval", g.interval))
+}
+
+// Stop stops the sweep
+func (g *AgentGarbageCollector) Stop() {
+	g.stopOnce.Do(func() {
+		close(g.stopChan)
+		g.wg.Wait()
+		g.logger.Info("Agent garbage collector stopped")
+	})
+}
+
+func (g *AgentGarbageCollector) run() {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.SweepConnections(context.Background())
+		case <-g.stopChan:
+			return
+		}
+	}
+}
+
+// TrackAgent records that a workflow spawned, or reused, a dynamic agent.
+// Recording the same agent for a workflow again is a no-op.
+func (g *AgentGarbageCollector) TrackAgent(ctx context.Context, workflowID, agentID string, spawned bool, ttl time.Duration) error {
+	err := g.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WorkflowAgent{
+		WorkflowID: workflowID,
+		AgentID:    agentID,
+		Spawned:    spawned,
+		TTLSeconds: int(ttl / time.Second),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to track workflow agent: %w", err)
+	}
+	return nil
+}
+
+// ReleaseFinishedWorkflows releases the agents of the finished workflows
+// whose agents were not released yet
+func (g *AgentGarbageCollector) ReleaseFinishedWorkflows(ctx context.Context) {
+	var workflowIDs []string
+	err := g.db.WithContext(ctx).Model(&models.WorkflowAgent{}).
+		Joins("JOIN workflows ON workflows.id = workflow_agents.workflow_id").
+		Where("workflow_agents.released_at IS NULL AND workflows.status IN ?", terminalWorkflowStatuses).
+		Distinct().
+		Pluck("workflow_agents.workflow_id", &workflowIDs).Error
+	if err != nil {
+		g.logger.Error("Failed to find finished workflows with agents", zap.Error(err))
+		return
+	}
+	for _, workflowID := range workflowIDs {
+		if err := g.ReleaseWorkflowAgents(ctx, workflowID); err != nil {
+			g.logger.Warn("Failed to release workflow agents",
+				zap.String("workflow_id", workflowID),
+				zap.Error(err))
+		}
+	}
+}
+
+// ReleaseWorkflowAgents releases the agents of a finished workflow. Agents
+// still used by an unfinished workflow are left alone; the others are
+// disconnected, and deleted if the orchestrator spawned them with a TTL.
+// Agents that fail to delete stay tracked and are retried.
+func (g *AgentGarbageCollector) ReleaseWorkflowAgents(ctx context.Context, workflowID string) error {
+	var tracked []models.WorkflowAgent
+	if err := g.db.WithContext(ctx).
+		Where("workflow_id = ? AND released_at IS NULL", workflowID).
+		Order("agent_id").
+		Find(&tracked).Error; err != nil {
+		return fmt.Errorf("failed to get workflow agents: %w", err)
+	}
+
+	var errs []error
+	for _, agent := range tracked {
+		if err := g.releaseAgent(ctx, &agent); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		now := g.now()
+		if err := g.db.WithContext(ctx).Model(&models.WorkflowAgent{}).
+			Where("workflow_id = ? AND agent_id = ?", agent.WorkflowID, agent.AgentID).
+			Update("released_at", now).Error; err != nil {
+			errs = append(errs, fmt.Errorf("failed to mark agent %s released: %w", agent.AgentID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// releaseAgent deletes and disconnects an agent of a finished workflow
+// unless another workflow still uses it
+func (g *AgentGarbageCollector) releaseAgent(ctx context.Context, agent *models.WorkflowAgent) error {
+	var users int64
+	if err := g.db.WithContext(ctx).Model(&models.WorkflowAgent{}).
+		Joins("JOIN workflows ON workflows.id = workflow_agents.workflow_id").
+		Where("workflow_agents.agent_id = ? AND workflow_agents.workflow_id <> ? AND workflow_agents.released_at IS NULL",
+			agent.AgentID, agent.WorkflowID).
+		Where("workflows.status NOT IN ? AND workflows.deleted_at IS NULL", terminalWorkflowStatuses).
+		Count(&users).Error; err != nil {
+		return fmt.Errorf("failed to check users of agent %s: %w", agent.AgentID, err)
+	}
+	if users > 0 {
+		return nil
+	}
+
+	// The agent may have been spawned by an earlier workflow this one reused
+	// it from
+	var spawned int64
+	if err := g.db.WithContext(ctx).Model(&models.WorkflowAgent{}).
+		Where("agent_id = ? AND spawned AND ttl_seconds > 0", agent.AgentID).
+		Count(&spawned).Error; err != nil {
+		return fmt.Errorf("failed to check spawn of agent %s: %w", agent.AgentID, err)
+	}
+	if spawned > 0 {
+		err := g.agents.DeleteAgent(ctx, agent.AgentID)
+		switch {
+		case errors.Is(err, ErrAgentNotFound):
+			// Reaped by the Agent Manager already
+		case err != nil:
+			return fmt.Errorf("failed to delete agent %s: %w", agent.AgentID, err)
+		default:
+			g.record(ctx, AgentGCActionDeleted, agent.AgentID, map[string]interface{}{
+				"workflow_id": agent.WorkflowID,
+				"reason":      "workflow_finished",
+			})
+		}
+	}
+
+	g.disconnect(ctx, agent.AgentID, map[string]interface{}{
+		"workflow_id": agent.WorkflowID,
+		"reason":      "workflow_finished",
+	})
+	return nil
+}
+
+// SweepConnections closes the connections to agents the Agent Manager
+// answers 404 for
+func (g *AgentGarbageCollector) SweepConnections(ctx context.Context) {
+	for _, agentID := range g.agents.ConnectedAgents() {
+		_, err := g.agents.GetAgent(ctx, agentID)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrAgentNotFound) {
+			g.logger.Debug("Failed to check connected agent",
+				zap.String("agent_id", agentID),
+				zap.Error(err))
+			continue
+		}
+		g.disconnect(ctx, agentID, map[string]interface{}{"reason": "agent_not_found"})
+	}
+}
+
+// disconnect closes the connection to an agent, if one is open
+func (g *AgentGarbageCollector) disconnect(ctx context.Context, agentID string, details map[string]interface{}) {
+	connected := false
+	for _, id := range g.agents.ConnectedAgents() {
+		if id == agentID {
+			connected = true
+			break
+		}
+	}
+	if !connected {
+		return
+	}
+	if err := g.agents.DisconnectFromAgent(agentID); err != nil {
+		g.logger.Warn("Failed to disconnect from agent",
+			zap.String("agent_id", agentID),
+			zap.Error(err))
+		return
+	}
+	g.record(ctx, AgentGCActionDisconnected, agentID, details)
+}
+
+// record logs, audits and counts an agent GC action
+func (g *AgentGarbageCollector) record(ctx context.Context, action, agentID string, details map[string]interface{}) {
+	agentGCActionsTotal.WithLabelValues(action).Inc()
+	g.logger.Info("Agent garbage collector action",
+		zap.String("action", action),
+		zap.String("agent_id", agentID),
+		zap.Any("details", details))
+
+	data, err := json.Marshal(details)
+	if err != nil {
+		g.logger.Error("Failed to marshal audit details", zap.Error(err))
+		return
+	}
+	entry := &models.AuditLog{
+		EntityType: "agent",
+		EntityID:   agentID,
+		Action:     "agent_gc." + action,
+		Actor:      agentGCActor,
+		Details:    data,
+	}
+	if err := g.db.WithContext(ctx).Create(entry).Error; err != nil {
+		g.logger.Error("Failed to write audit log",
+			zap.String("agent_id", agentID),
+			zap.String("action", action),
+			zap.Error(err))
+	}
+}
+
+// EnableAgentCleanup makes the monitor release the agents of finished
+// workflows through the collector
+func (m *WorkflowMonitor) EnableAgentCleanup(agents *AgentGarbageCollector) {
+	m.agentGC = agents
+}
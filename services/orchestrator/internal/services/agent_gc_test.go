@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// fakeAgentManager serves the agents it knows, deletes them and accepts
+// WebSocket connections to any agent
+type fakeAgentManager struct {
+	mu      sync.Mutex
+	agents  map[string]bool
+	deleted []string
+}
+
+func newFakeAgentManager(t *testing.T, agentIDs ...string) (*fakeAgentManager, *AgentClient) {
+	manager := &fakeAgentManager{agents: make(map[string]bool)}
+	for _, agentID := range agentIDs {
+		manager.agents[agentID] = true
+	}
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentID := strings.TrimPrefix(r.URL.Path, "/api/v1/agents/")
+		if strings.HasSuffix(agentID, "/connect") {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+
+		manager.mu.Lock()
+		defer manager.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if !manager.agents[agentID] {
+			w.WriteHeader(http.StatusNotFound)
+			assert.NoError(t, json.NewEncoder(w).Encode(ErrorResponse{Code: "NOT_FOUND", Message: "agent not found"}))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			assert.NoError(t, json.NewEncoder(w).Encode(Agent{ID: agentID, Status: "available"}))
+		case http.MethodDelete:
+			delete(manager.agents, agentID)
+			manager.deleted = append(manager.deleted, agentID)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewAgentClient(&config.AgentManagerConfig{
+		BaseURL:          server.URL,
+		WebSocketURL:     "ws" + strings.TrimPrefix(server.URL, "http"),
+		HTTPTimeout:      5,
+		WebSocketTimeout: 5,
+		PingInterval:     30,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return manager, client
+}
+
+func (m *fakeAgentManager) deletedAgents() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.deleted...)
+}
+
+func setupAgentGCTest(t *testing.T) *gorm.DB {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE workflow_agents (workflow_id TEXT, agent_id TEXT, spawned BOOLEAN,
+		ttl_seconds INTEGER, released_at DATETIME, created_at DATETIME, PRIMARY KEY (workflow_id, agent_id))`).Error)
+	require.NoError(t, db.Exec(`CREATE TABLE audit_logs (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		entity_type TEXT, entity_id TEXT, action TEXT, actor TEXT, details TEXT, created_at DATETIME)`).Error)
+	return db
+}
+
+func agentGCActions(t *testing.T, db *gorm.DB) []string {
+	var entries []models.AuditLog
+	require.NoError(t, db.Where("actor = ?", agentGCActor).Order("rowid").Find(&entries).Error)
+	actions := make([]string, len(entries))
+	for i, entry := range entries {
+		actions[i] = entry.Action + " " + entry.EntityID
+	}
+	return actions
+}
+
+func connectedAgents(client *AgentClient) []string {
+	agentIDs := client.ConnectedAgents()
+	sort.Strings(agentIDs)
+	return agentIDs
+}
+
+func TestAgentGarbageCollector_ReleasesAgentsOfFinishedWorkflows(t *testing.T) {
+	for _, status := range []models.WorkflowStatus{models.WorkflowStatusCompleted, models.WorkflowStatusCancelled} {
+		t.Run(string(status), func(t *testing.T) {
+			ctx := context.Background()
+			db := setupAgentGCTest(t)
+			manager, client := newFakeAgentManager(t, "dynamic-1", "dynamic-2", "backend-1")
+			collector := NewAgentGarbageCollector(db, client, zap.NewNop(), time.Minute)
+			monitor := NewWorkflowMonitor(db, new(mocks.Client), zap.NewNop(), nil, time.Minute)
+			monitor.EnableAgentCleanup(collector)
+
+			finishing := &models.Workflow{Name: "finishing", Type: models.WorkflowTypeTaskExecution, Status: models.WorkflowStatusRunning}
+			running := &models.Workflow{Name: "running", Type: models.WorkflowTypeTaskExecution, Status: models.WorkflowStatusRunning}
+			require.NoError(t, db.Create(finishing).Error)
+			require.NoError(t, db.Create(running).Error)
+
+			// The finishing workflow spawned dynamic-1 and reused dynamic-2,
+			// which the running workflow spawned; backend-1 is a static agent
+			// without a TTL
+			require.NoError(t, collector.TrackAgent(ctx, finishing.ID, "dynamic-1", true, time.Hour))
+			require.NoError(t, collector.TrackAgent(ctx, finishing.ID, "dynamic-2", false, time.Hour))
+			require.NoError(t, collector.TrackAgent(ctx, finishing.ID, "backend-1", false, 0))
+			require.NoError(t, collector.TrackAgent(ctx, running.ID, "dynamic-2", true, time.Hour))
+			require.NoError(t, collector.TrackAgent(ctx, finishing.ID, "dynamic-1", true, time.Hour))
+			for _, agentID := range []string{"dynamic-1", "dynamic-2", "backend-1"} {
+				_, err := client.ConnectToAgent(ctx, agentID, "project-1")
+				require.NoError(t, err)
+			}
+
+			// Nothing is released while the workflows run
+			monitor.checkWorkflows()
+			assert.Empty(t, manager.deletedAgents())
+
+			require.NoError(t, db.Model(finishing).Update("status", status).Error)
+			monitor.checkWorkflows()
+			assert.Equal(t, []string{"dynamic-1"}, manager.deletedAgents())
+			assert.Equal(t, []string{"dynamic-2"}, connectedAgents(client))
+			assert.Equal(t, []string{
+				"agent_gc.disconnected backend-1",
+				"agent_gc.deleted dynamic-1",
+				"agent_gc.disconnected dynamic-1",
+			}, agentGCActions(t, db))
+
+			var unreleased int64
+			require.NoError(t, db.Model(&models.WorkflowAgent{}).
+				Where("workflow_id = ? AND released_at IS NULL", finishing.ID).Count(&unreleased).Error)
+			assert.Zero(t, unreleased)
+
+			// The agent shared with the running workflow goes once it finishes
+			require.NoError(t, db.Model(running).Update("status", models.WorkflowStatusCompleted).Error)
+			monitor.checkWorkflows()
+			monitor.checkWorkflows()
+			assert.Equal(t, []string{"dynamic-1", "dynamic-2"}, manager.deletedAgents())
+			assert.Empty(t, connectedAgents(client))
+			assert.Len(t, agentGCActions(t, db), 5)
+		})
+	}
+}
+
+func TestAgentGarbageCollector_RetriesFailedDeletes(t *testing.T) {
+	ctx := context.Background()
+	db := setupAgentGCTest(t)
+	_, client := newFakeAgentManager(t)
+	collector := NewAgentGarbageCollector(db, client, zap.NewNop(), time.Minute)
+
+	workflow := &models.Workflow{Name: "done", Type: models.WorkflowTypeTaskExecution, Status: models.WorkflowStatusFailed}
+	require.NoError(t, db.Create(workflow).Error)
+	require.NoError(t, collector.TrackAgent(ctx, workflow.ID, "gone", true, time.Hour))
+	require.NoError(t, collector.TrackAgent(ctx, workflow.ID, "unreachable", true, time.Hour))
+
+	// An agent the Agent Manager reaped already counts as deleted; one that
+	// fails to delete is kept for the next round
+	collector.agents = &failingDeletes{AgentLifecycle: client, failing: "unreachable"}
+	assert.ErrorIs(t, collector.ReleaseWorkflowAgents(ctx, workflow.ID), ErrAgentManagerUnavailable)
+
+	var unreleased []string
+	require.NoError(t, db.Model(&models.WorkflowAgent{}).Where("released_at IS NULL").Pluck("agent_id", &unreleased).Error)
+	assert.Equal(t, []string{"unreachable"}, unreleased)
+	assert.Empty(t, agentGCActions(t, db))
+}
+
+// failingDeletes fails to delete one agent
+type failingDeletes struct {
+	AgentLifecycle
+	failing string
+}
+
+func (f *failingDeletes) DeleteAgent(ctx context.Context, agentID string) error {
+	if agentID == f.failing {
+		return ErrAgentManagerUnavailable
+	}
+	return f.AgentLifecycle.DeleteAgent(ctx, agentID)
+}
+
+func TestAgentGarbageCollector_SweepsConnectionsToUnknownAgents(t *testing.T) {
+	ctx := context.Background()
+	db := setupAgentGCTest(t)
+	manager, client := newFakeAgentManager(t, "agent-1", "agent-2")
+	collector := NewAgentGarbageCollector(db, client, zap.NewNop(), time.Minute)
+
+	for _, agentID := range []string{"agent-1", "agent-2"} {
+		_, err := client.ConnectToAgent(ctx, agentID, "project-1")
+		require.NoError(t, err)
+	}
+	collector.SweepConnections(ctx)
+	assert.Equal(t, []string{"agent-1", "agent-2"}, connectedAgents(client))
+
+	// agent-2 is reaped by the Agent Manager
+	manager.mu.Lock()
+	delete(manager.agents, "agent-2")
+	manager.mu.Unlock()
+	collector.SweepConnections(ctx)
+	assert.Equal(t, []string{"agent-1"}, connectedAgents(client))
+	assert.Equal(t, []string{"agent_gc.disconnected agent-2"}, agentGCActions(t, db))
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// Agent list cache lookup results, recorded as the
+// agent_list_cache_requests_total result label
+const (
+	// AgentListCacheHit is a list served from the cache
+	AgentListCacheHit = "hit"
+	// AgentListCacheMiss is a list fetched from the agent manager and cached
+	AgentListCacheMiss = "miss"
+	// AgentListCacheShared is a miss that waited for the identical request
+	// already fetching the list
+	AgentListCacheShared = "shared"
+	// AgentListCacheBypass is a list fetched for a caller asking for fresh
+	// agents
+	AgentListCacheBypass = "bypass"
+)
+
+var agentListCacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_agent_list_cache_requests_total",
+	Help: "Agent list requests by cache result; the hit rate is hit over all results",
+}, []string{"result"})
+
+// AgentListStore holds cached agent lists. Invalidate drops every list at
+// once by moving to a new generation, which cache keys include.
+type AgentListStore interface {
+	// Get returns the list cached at key, nil when there is none
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Generation(ctx context.Context) (int64, error)
+	Invalidate(ctx context.Context) error
+}
+
+const (
+	agentListKeyPrefix     = "agents:list:"
+	agentListGenerationKey = "agents:list:generation"
+)
+
+// redisAgentListStore keeps agent lists in Redis so that every replica
+// shares them and sees the invalidations of the others
+type redisAgentListStore struct {
+	client *redis.Client
+}
+
+// NewRedisAgentListStore caches agent lists in Redis
+func NewRedisAgentListStore(client *redis.Client) AgentListStore {
+	return &redisAgentListStore{client: client}
+}
+
+func (s *redisAgentListStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.Get(ctx, agentListKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (s *redisAgentListStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, agentListKeyPrefix+key, value, ttl).Err()
+}
+
+func (s *redisAgentListStore) Generation(ctx context.Context) (int64, error) {
+	generation, err := s.client.Get(ctx, agentListGenerationKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return generation, err
+}
+
+func (s *redisAgentListStore) Invalidate(ctx context.Context) error {
+	return s.client.Incr(ctx, agentListGenerationKey).Err()
+}
+
+// memoryAgentListStore caches agent lists in memory, for a single replica
+type memoryAgentListStore struct {
+	mu         sync.Mutex
+	entries    map[string]memoryAgentListEntry
+	generation int64
+	now        func() time.Time
+}
+
+type memoryAgentListEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryAgentListStore caches agent lists in memory
+func NewMemoryAgentListStore() AgentListStore {
+	return &memoryAgentListStore{entries: make(map[string]memoryAgentListEntry), now: time.Now}
+}
+
+func (s *memoryAgentListStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if !s.now().Before(entry.expires) {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+func (s *memoryAgentListStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryAgentListEntry{value: value, expires: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryAgentListStore) Generation(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation, nil
+}
+
+func (s *memoryAgentListStore) Invalidate(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	// Lists of earlier generations can no longer be read
+	s.entries = make(map[string]memoryAgentListEntry)
+	return nil
+}
+
+// AgentListCache caches agent lists for a short TTL. Agent selection lists
+// the same agents for every task it schedules, so bursts of tasks would
+// otherwise make as many identical requests to the agent manager. Identical
+// misses share one request, lists are dropped whenever the orchestrator
+// changes an agent, and callers needing current agents set AgentFilters.Fresh.
+// The cache is best effort: when the store fails, lists are fetched upstream.
+type AgentListCache struct {
+	store  AgentListStore
+	ttl    time.Duration
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	inflight map[string]*agentListCall
+}
+
+// agentListCall is a request for an agent list that identical misses wait for
+type agentListCall struct {
+	done chan struct{}
+	list *AgentList
+	err  error
+}
+
+// NewAgentListCache creates an agent list cache from configuration
+func NewAgentListCache(store AgentListStore, cfg *config.AgentManagerConfig, logger *zap.Logger) *AgentListCache {
+	return &AgentListCache{
+		store:    store,
+		ttl:      time.Duration(cfg.ListCacheTTL) * time.Second,
+		logger:   logger,
+		inflight: make(map[string]*agentListCall),
+	}
+}
+
+// List returns the agents matching the filters, from the cache or else from
+// fetch
+func (c *AgentListCache) List(ctx context.Context, filters *AgentFilters, fetch func(ctx context.Context) (*AgentList, error)) (*AgentList, error) {
+	if filters != nil && filters.Fresh {
+		agentListCacheRequestsTotal.WithLabelValues(AgentListCacheBypass).Inc()
+		return fetch(ctx)
+	}
+
+	key, err := c.key(ctx, filters)
+	if err != nil {
+		c.logWarn("Agent list cache unavailable", err)
+		agentListCacheRequestsTotal.WithLabelValues(AgentListCacheBypass).Inc()
+		return fetch(ctx)
+	}
+	if list := c.get(ctx, key); list != nil {
+		agentListCacheRequestsTotal.WithLabelValues(AgentListCacheHit).Inc()
+		return list, nil
+	}
+
+	// Identical misses wait for the request in flight
+	c.mu.Lock()
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		agentListCacheRequestsTotal.WithLabelValues(AgentListCacheShared).Inc()
+		select {
+		case <-call.done:
+			return call.list.clone(), call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &agentListCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	agentListCacheRequestsTotal.WithLabelValues(AgentListCacheMiss).Inc()
+	call.list, call.err = fetch(ctx)
+	if call.err == nil {
+		c.set(ctx, key, call.list)
+	}
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(call.done)
+	return call.list.clone(), call.err
+}
+
+// clone copies a list shared by identical misses, so callers filtering its
+// agents do not affect each other
+func (l *AgentList) clone() *AgentList {
+	if l == nil {
+		return nil
+	}
+	copied := *l
+	copied.Agents = slices.Clone(l.Agents)
+	return &copied
+}
+
+// Invalidate drops every cached list, after the orchestrator changed an agent
+func (c *AgentListCache) Invalidate(ctx context.Context) {
+	if err := c.store.Invalidate(ctx); err != nil {
+		c.logWarn("Failed to invalidate agent list cache", err)
+	}
+}
+
+// logWarn logs a failure of the store, leaving Redis outages to the guard
+// that logs them once per interval
+func (c *AgentListCache) logWarn(msg string, err error) {
+	if !IsRedisOutage(err) {
+		c.logger.Warn(msg, zap.Error(err))
+	}
+}
+
+// key identifies the lists of the filters in the current generation
+func (c *AgentListCache) key(ctx context.Context, filters *AgentFilters) (string, error) {
+	generation, err := c.store.Generation(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read agent list generation: %w", err)
+	}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal agent filters: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return strconv.FormatInt(generation, 10) + ":" + hex.EncodeToString(sum[:16]), nil
+}
+
+// get returns the list cached at key, nil when there is none or it cannot
+// be read
+func (c *AgentListCache) get(ctx context.Context, key string) *AgentList {
+	data, err := c.store.Get(ctx, key)
+	if err != nil {
+		c.logWarn("Failed to read cached agent list", err)
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	var list AgentList
+	if err := json.Unmarshal(data, &list); err != nil {
+		c.logger.Warn("Failed to decode cached agent list", zap.Error(err))
+		return nil
+	}
+	return &list
+}
+
+func (c *AgentListCache) set(ctx context.Context, key string, list *AgentList) {
+	data, err := json.Marshal(list)
+	if err != nil {
+		c.logger.Warn("Failed to encode agent list", zap.Error(err))
+		return
+	}
+	if err := c.store.Set(ctx, key, data, c.ttl); err != nil {
+		c.logWarn("Failed to cache agent list", err)
+	}
+}
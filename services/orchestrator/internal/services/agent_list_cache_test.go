@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// countingAgentManager is a fake Agent Manager counting the agent lists it
+// serves. While gate is set, lists wait for it to close.
+type countingAgentManager struct {
+	lists atomic.Int32
+	gate  chan struct{}
+}
+
+func newCountingAgentManager(t *testing.T) (*countingAgentManager, *httptest.Server) {
+	manager := &countingAgentManager{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/agents":
+			n := manager.lists.Add(1)
+			if manager.gate != nil {
+				<-manager.gate
+			}
+			// Each list names the request that fetched it
+			assert.NoError(t, json.NewEncoder(w).Encode(AgentList{
+				Agents:     []Agent{{ID: "agent-1", Status: "available", Name: fmt.Sprintf("fetch-%d", n)}},
+				TotalCount: 1,
+			}))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			assert.NoError(t, json.NewEncoder(w).Encode(Agent{ID: "agent-1", Status: "restarting"}))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return manager, server
+}
+
+// newCachingAgentClient creates an agent client caching lists in the store
+func newCachingAgentClient(t *testing.T, baseURL string, store AgentListStore) *AgentClient {
+	cfg := &config.AgentManagerConfig{BaseURL: baseURL, HTTPTimeout: 5, ListCacheTTL: 10}
+	client, err := NewAgentClient(cfg, zap.NewNop())
+	require.NoError(t, err)
+	client.SetListCache(NewAgentListCache(store, cfg, zap.NewNop()))
+	return client
+}
+
+func TestAgentListCache_ServesListsUntilTTLExpires(t *testing.T) {
+	ctx := context.Background()
+	manager, server := newCountingAgentManager(t)
+	store := NewMemoryAgentListStore().(*memoryAgentListStore)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	client := newCachingAgentClient(t, server.URL, store)
+	filters := &AgentFilters{ProjectID: "project-1", Status: "available"}
+
+	first, err := client.ListAgents(ctx, filters)
+	require.NoError(t, err)
+	now = now.Add(9 * time.Second)
+	second, err := client.ListAgents(ctx, filters)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.EqualValues(t, 1, manager.lists.Load())
+
+	// Other filters are cached separately
+	_, err = client.ListAgents(ctx, &AgentFilters{ProjectID: "project-2", Status: "available"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, manager.lists.Load())
+
+	now = now.Add(time.Second)
+	third, err := client.ListAgents(ctx, filters)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, manager.lists.Load())
+	assert.Equal(t, "fetch-3", third.Agents[0].Name)
+}
+
+func TestAgentListCache_AgentChangesInvalidateLists(t *testing.T) {
+	ctx := context.Background()
+	filters := &AgentFilters{ProjectID: "project-1"}
+
+	mutations := map[string]func(client *AgentClient) error{
+		"create": func(client *AgentClient) error {
+			_, err := client.CreateAgent(ctx, &CreateAgentRequest{Name: "coder", Type: "backend", ProjectID: "project-1"})
+			return err
+		},
+		"update": func(client *AgentClient) error {
+			_, err := client.UpdateAgent(ctx, "agent-1", &UpdateAgentRequest{Name: "renamed"})
+			return err
+		},
+		"restart": func(client *AgentClient) error {
+			_, err := client.UpdateAgent(ctx, "agent-1", &UpdateAgentRequest{Status: "restarting"})
+			return err
+		},
+		"delete": func(client *AgentClient) error {
+			return client.DeleteAgent(ctx, "agent-1")
+		},
+		"spawn": func(client *AgentClient) error {
+			_, err := client.ExecuteTask(ctx, "meta", &ExecuteTaskRequest{Type: "spawn-agent"})
+			return err
+		},
+	}
+	for name, mutate := range mutations {
+		t.Run(name, func(t *testing.T) {
+			manager, server := newCountingAgentManager(t)
+			client := newCachingAgentClient(t, server.URL, NewMemoryAgentListStore())
+
+			_, err := client.ListAgents(ctx, filters)
+			require.NoError(t, err)
+			require.NoError(t, mutate(client))
+			_, err = client.ListAgents(ctx, filters)
+			require.NoError(t, err)
+			assert.EqualValues(t, 2, manager.lists.Load())
+		})
+	}
+
+	t.Run("other tasks keep lists", func(t *testing.T) {
+		manager, server := newCountingAgentManager(t)
+		client := newCachingAgentClient(t, server.URL, NewMemoryAgentListStore())
+
+		_, err := client.ListAgents(ctx, filters)
+		require.NoError(t, err)
+		_, err = client.ExecuteTask(ctx, "agent-1", &ExecuteTaskRequest{Type: "generate_backend_code"})
+		require.NoError(t, err)
+		_, err = client.ListAgents(ctx, filters)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, manager.lists.Load())
+	})
+}
+
+func TestAgentListCache_FreshBypassesCache(t *testing.T) {
+	ctx := context.Background()
+	manager, server := newCountingAgentManager(t)
+	client := newCachingAgentClient(t, server.URL, NewMemoryAgentListStore())
+	bypassed := testutil.ToFloat64(agentListCacheRequestsTotal.WithLabelValues(AgentListCacheBypass))
+
+	_, err := client.ListAgents(ctx, &AgentFilters{ProjectID: "project-1"})
+	require.NoError(t, err)
+	fresh, err := client.ListAgents(ctx, &AgentFilters{ProjectID: "project-1", Fresh: true})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, manager.lists.Load())
+	assert.Equal(t, "fetch-2", fresh.Agents[0].Name)
+	assert.Equal(t, bypassed+1, testutil.ToFloat64(agentListCacheRequestsTotal.WithLabelValues(AgentListCacheBypass)))
+}
+
+func TestAgentListCache_ConcurrentMissesShareOneRequest(t *testing.T) {
+	ctx := context.Background()
+	manager, server := newCountingAgentManager(t)
+	manager.gate = make(chan struct{})
+	client := newCachingAgentClient(t, server.URL, NewMemoryAgentListStore())
+	shared := testutil.ToFloat64(agentListCacheRequestsTotal.WithLabelValues(AgentListCacheShared))
+
+	const callers = 10
+	var wg sync.WaitGroup
+	lists := make([]*AgentList, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			list, err := client.ListAgents(ctx, &AgentFilters{ProjectID: "project-1", Status: "available"})
+			assert.NoError(t, err)
+			lists[i] = list
+		}(i)
+	}
+
+	// Release the request once every other caller waits for it
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(agentListCacheRequestsTotal.WithLabelValues(AgentListCacheShared)) == shared+callers-1
+	}, 5*time.Second, 5*time.Millisecond)
+	close(manager.gate)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, manager.lists.Load())
+	for _, list := range lists {
+		require.NotNil(t, list)
+		assert.Equal(t, "fetch-1", list.Agents[0].Name)
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// DefaultAgentPageSize is the page size of agent lists that ask for none
+	DefaultAgentPageSize = 20
+	// MaxAgentPageSize is the largest page of agents listed at once
+	MaxAgentPageSize = 100
+)
+
+var (
+	// ErrAgentManagerUnavailable is returned when the agent manager answers
+	// with a server error
+	ErrAgentManagerUnavailable = errors.New("agent manager unavailable")
+	// ErrAgentManagerRateLimited is returned when the agent manager rejects a
+	// request with 429 Too Many Requests
+	ErrAgentManagerRateLimited = errors.New("agent manager rate limited the request")
+	// ErrAgentNotFound is returned when the agent manager answers a request
+	// for an agent with 404 Not Found
+	ErrAgentNotFound = errors.New("agent not found")
+)
+
+// rateLimitedError keeps the Retry-After header of a rate limited request
+type rateLimitedError struct {
+	err        error
+	retryAfter string
+}
+
+func (e *rateLimitedError) Error() string { return e.err.Error() }
+func (e *rateLimitedError) Unwrap() error { return e.err }
+
+// RetryAfter returns the Retry-After header the agent manager sent with a
+// rate limited request, empty when there was none
+func RetryAfter(err error) string {
+	var limited *rateLimitedError
+	if errors.As(err, &limited) {
+		return limited.retryAfter
+	}
+	return ""
+}
+
+// agentManagerError wraps the error of a failed agent manager response with
+// the sentinel of its status
+func agentManagerError(resp *http.Response, err error) error {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &rateLimitedError{
+			err:        fmt.Errorf("%w: %w", ErrAgentManagerRateLimited, err),
+			retryAfter: resp.Header.Get("Retry-After"),
+		}
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("%w: %w", ErrAgentManagerUnavailable, err)
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrAgentNotFound, err)
+	}
+	return err
+}
+
+// agentManagerPage is a page of agents as the agent manager returns it.
+// TotalCount is nil when the agent manager leaves it out.
+type agentManagerPage struct {
+	Agents     []Agent `json:"agents"`
+	TotalCount *int64  `json:"total_count"`
+}
+
+// window returns the offset and limit of the agents the filters ask for. An
+// Offset takes precedence over Page.
+func (f *AgentFilters) window() (offset, limit int) {
+	limit = f.PageSize
+	if limit <= 0 {
+		return f.Offset, 0
+	}
+	if f.Offset > 0 || f.Page <= 1 {
+		return f.Offset, limit
+	}
+	return (f.Page - 1) * limit, limit
+}
+
+// NewAgentListPage returns the page of agents the filters ask for out of
+// every matching agent, whose total is therefore known
+func NewAgentListPage(agents []Agent, filters *AgentFilters) *AgentList {
+	offset, limit := 0, 0
+	if filters != nil {
+		offset, limit = filters.window()
+	}
+	page := agents
+	if page == nil {
+		page = []Agent{}
+	}
+	if offset > len(page) {
+		offset = len(page)
+	}
+	page = page[offset:]
+	if limit > 0 && len(page) > limit {
+		page = page[:limit]
+	}
+	list := &AgentList{Agents: page, TotalCount: int64(len(agents)), TotalKnown: true}
+	list.setWindow(offset, limit)
+	list.HasMore = int64(offset+len(page)) < list.TotalCount
+	return list
+}
+
+// setWindow describes the page in both the orchestrator's offset/limit and
+// the agent manager's page/page_size conventions. Offsets between page
+// boundaries report the page they start in.
+func (l *AgentList) setWindow(offset, limit int) {
+	l.Offset, l.Limit = offset, limit
+	l.Page, l.PageSize = 1, limit
+	if limit > 0 {
+		l.Page = offset/limit + 1
+	}
+}
+
+// MinTotal returns the number of agents, or a lower bound of it when the
+// agent manager did not report a usable total
+func (l *AgentList) MinTotal() int64 {
+	if l.TotalKnown {
+		return l.TotalCount
+	}
+	total := int64(l.Offset + len(l.Agents))
+	if l.HasMore {
+		total++
+	}
+	return total
+}
+
+// listAgentsPage lists the page of agents the filters ask for. The agent
+// manager only serves pages aligned to their size, so an offset between page
+// boundaries takes two requests. When the agent manager leaves out the total
+// or reports one its agents contradict, the total is left unknown and one
+// more agent is fetched to tell whether others follow the page.
+func (c *AgentClient) listAgentsPage(ctx context.Context, filters *AgentFilters) (*AgentList, error) {
+	var offset, limit int
+	if filters != nil {
+		offset, limit = filters.window()
+	}
+	if limit <= 0 {
+		// Without a page size the agent manager picks one
+		result, err := c.fetchAgentPage(ctx, filters, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		list := &AgentList{Agents: result.Agents}
+		list.setWindow(0, len(result.Agents))
+		if total, ok := result.total(0); ok {
+			list.TotalCount, list.TotalKnown = total, true
+			list.HasMore = int64(len(result.Agents)) < total
+		}
+		return list, nil
+	}
+
+	first := offset/limit + 1
+	skip := offset % limit
+	result, err := c.fetchAgentPage(ctx, filters, first, limit)
+	if err != nil {
+		return nil, err
+	}
+	agents := result.Agents
+	if skip > 0 && len(result.Agents) == limit {
+		next, err := c.fetchAgentPage(ctx, filters, first+1, limit)
+		if err != nil {
+			return nil, err
+		}
+		agents = append(agents[:len(agents):len(agents)], next.Agents...)
+	}
+	agents = agents[min(skip, len(agents)):]
+	if len(agents) > limit {
+		agents = agents[:limit]
+	}
+
+	list := &AgentList{Agents: agents}
+	list.setWindow(offset, limit)
+	switch total, ok := result.total((first - 1) * limit); {
+	case ok && total >= int64(offset+len(agents)):
+		list.TotalCount, list.TotalKnown = total, true
+		list.HasMore = int64(offset+len(agents)) < total
+	case len(agents) < limit:
+		// A short page is the last one; past the end the total stays unknown
+		list.HasMore = false
+		if len(agents) > 0 || offset == 0 {
+			list.TotalCount, list.TotalKnown = int64(offset+len(agents)), true
+		}
+	default:
+		probe, err := c.fetchAgentPage(ctx, filters, offset+limit+1, 1)
+		if err != nil {
+			return nil, err
+		}
+		list.HasMore = len(probe.Agents) > 0
+	}
+	return list, nil
+}
+
+// total returns the total the agent manager reported for a page starting at
+// offset, unless it left it out or its agents contradict it
+func (p *agentManagerPage) total(offset int) (int64, bool) {
+	if p.TotalCount == nil || *p.TotalCount < int64(offset+len(p.Agents)) {
+		return 0, false
+	}
+	return *p.TotalCount, true
+}
+
+// fetchAgentPage fetches one page from the agent manager
+func (c *AgentClient) fetchAgentPage(ctx context.Context, filters *AgentFilters, page, pageSize int) (*agentManagerPage, error) {
+	url := fmt.Sprintf("%s/api/v1/agents", c.config.BaseURL)
+	if filters != nil {
+		query := *filters
+		query.Page, query.PageSize = page, pageSize
+		if params := buildQueryParams(&query); params != "" {
+			url += "?" + params
+		}
+	}
+
+	var result agentManagerPage
+	if _, err := c.doRequest(ctx, http.MethodGet, url, nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Agents == nil {
+		result.Agents = []Agent{}
+	}
+	return &result, nil
+}
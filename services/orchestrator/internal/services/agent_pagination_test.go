@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// pagingAgentManager serves pages of agents, reporting the total as a
+// pathological agent manager would
+type pagingAgentManager struct {
+	agents []Agent
+	total  func(agents int) interface{} // total_count to report, omitted when nil
+	limit  int                          // largest page served, unlimited when zero
+
+	mu    sync.Mutex
+	pages []string // page/page_size of each request
+}
+
+func newPagingAgentManager(t *testing.T, count int, total func(agents int) interface{}) (*pagingAgentManager, *AgentClient) {
+	manager := &pagingAgentManager{total: total}
+	for i := 0; i < count; i++ {
+		manager.agents = append(manager.agents, Agent{ID: fmt.Sprintf("agent-%02d", i)})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+		manager.mu.Lock()
+		manager.pages = append(manager.pages, fmt.Sprintf("%d/%d", page, pageSize))
+		if manager.limit > 0 {
+			pageSize = min(pageSize, manager.limit)
+		}
+		manager.mu.Unlock()
+
+		start := min((page-1)*pageSize, len(manager.agents))
+		end := min(start+pageSize, len(manager.agents))
+		body := map[string]interface{}{"agents": manager.agents[start:end]}
+		if start == end {
+			body["agents"] = nil
+		}
+		if total := manager.total(len(manager.agents)); total != nil {
+			body["total_count"] = total
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(body))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+	require.NoError(t, err)
+	return manager, client
+}
+
+func (m *pagingAgentManager) requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.pages...)
+}
+
+func agentIDs(list *AgentList) []string {
+	ids := make([]string, len(list.Agents))
+	for i, agent := range list.Agents {
+		ids[i] = agent.ID
+	}
+	return ids
+}
+
+func TestAgentClient_ListAgentsTotals(t *testing.T) {
+	tests := map[string]struct {
+		total      func(agents int) interface{}
+		totalKnown bool
+	}{
+		"reported total":          {total: func(n int) interface{} { return n }, totalKnown: true},
+		"missing total":           {total: func(int) interface{} { return nil }},
+		"zero total with results": {total: func(int) interface{} { return 0 }},
+		"total below the agents":  {total: func(int) interface{} { return 3 }},
+		"null total":              {total: func(int) interface{} { return json.RawMessage("null") }},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, client := newPagingAgentManager(t, 12, tt.total)
+
+			// First page
+			list, err := client.ListAgents(ctx, &AgentFilters{Page: 1, PageSize: 5})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"agent-00", "agent-01", "agent-02", "agent-03", "agent-04"}, agentIDs(list))
+			assert.True(t, list.HasMore)
+			assert.Equal(t, tt.totalKnown, list.TotalKnown)
+			if tt.totalKnown {
+				assert.Equal(t, int64(12), list.TotalCount)
+				assert.Equal(t, []string{"1/5"}, manager.requests())
+			} else {
+				assert.Zero(t, list.TotalCount, "unknown totals are not made up")
+				assert.Equal(t, []string{"1/5", "6/1"}, manager.requests(), "one more agent tells whether others follow")
+			}
+			assert.Equal(t, 1, list.Page)
+			assert.Equal(t, 0, list.Offset)
+			assert.Equal(t, 5, list.Limit)
+
+			// A page ending exactly at the last agent
+			list, err = client.ListAgents(ctx, &AgentFilters{Offset: 7, PageSize: 5})
+			require.NoError(t, err)
+			assert.Len(t, list.Agents, 5)
+			assert.False(t, list.HasMore)
+
+			// The short last page
+			list, err = client.ListAgents(ctx, &AgentFilters{Page: 3, PageSize: 5})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"agent-10", "agent-11"}, agentIDs(list))
+			assert.False(t, list.HasMore)
+			assert.True(t, list.TotalKnown, "the last page tells the total")
+			assert.Equal(t, int64(12), list.TotalCount)
+			assert.Equal(t, int64(12), list.MinTotal())
+
+			// Past the end
+			list, err = client.ListAgents(ctx, &AgentFilters{Page: 9, PageSize: 5})
+			require.NoError(t, err)
+			assert.NotNil(t, list.Agents)
+			assert.Empty(t, list.Agents)
+			assert.False(t, list.HasMore)
+		})
+	}
+}
+
+func TestAgentClient_ListAgentsUnalignedOffset(t *testing.T) {
+	manager, client := newPagingAgentManager(t, 12, func(n int) interface{} { return n })
+
+	list, err := client.ListAgents(context.Background(), &AgentFilters{Offset: 3, PageSize: 4})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent-03", "agent-04", "agent-05", "agent-06"}, agentIDs(list))
+	assert.Equal(t, []string{"1/4", "2/4"}, manager.requests(), "the offset spans two agent manager pages")
+	assert.Equal(t, 3, list.Offset)
+	assert.Equal(t, 4, list.Limit)
+	assert.Equal(t, 1, list.Page)
+	assert.True(t, list.HasMore)
+	assert.Equal(t, int64(12), list.TotalCount)
+}
+
+func TestAgentClient_ListAllAgentsPastShortPages(t *testing.T) {
+	tests := map[string]struct {
+		total    func(agents int) interface{}
+		requests []string
+	}{
+		"reported total": {total: func(n int) interface{} { return n }, requests: []string{"1/100", "2/100", "3/100"}},
+		"missing total":  {total: func(int) interface{} { return nil }, requests: []string{"1/100", "2/100", "3/100", "4/100"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			manager, client := newPagingAgentManager(t, 25, tt.total)
+			manager.limit = 10
+
+			maxLoad := 1.0
+			list, err := client.ListAgents(context.Background(), &AgentFilters{MaxLoad: &maxLoad})
+			require.NoError(t, err)
+			assert.Len(t, list.Agents, 25, "pages smaller than asked for are not the last")
+			assert.Equal(t, "agent-24", list.Agents[24].ID)
+			assert.Equal(t, tt.requests, manager.requests())
+		})
+	}
+}
+
+func TestAgentClient_UpstreamErrors(t *testing.T) {
+	tests := map[string]struct {
+		status     int
+		header     http.Header
+		want       error
+		retryAfter string
+	}{
+		"server error": {status: http.StatusServiceUnavailable, want: ErrAgentManagerUnavailable},
+		"rate limited": {status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"30"}}, want: ErrAgentManagerRateLimited, retryAfter: "30"},
+		"client error": {status: http.StatusBadRequest},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, values := range tt.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(tt.status)
+				assert.NoError(t, json.NewEncoder(w).Encode(ErrorResponse{Code: "UPSTREAM", Message: "database is down"}))
+			}))
+			t.Cleanup(server.Close)
+			client, err := NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+			require.NoError(t, err)
+
+			_, err = client.ListAgents(context.Background(), &AgentFilters{Page: 1, PageSize: 5})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "database is down", "the upstream message is kept")
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+			} else {
+				assert.NotErrorIs(t, err, ErrAgentManagerUnavailable)
+				assert.NotErrorIs(t, err, ErrAgentManagerRateLimited)
+			}
+			assert.Equal(t, tt.retryAfter, RetryAfter(err))
+		})
+	}
+}
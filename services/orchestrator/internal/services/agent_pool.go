@@ -0,0 +1,526 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// WarmPoolTag marks the agents created for warm pools. Only tagged agents
+// are pool members, so agents of the same type created otherwise are never
+// scaled down.
+const WarmPoolTag = "warm-pool"
+
+// warmPoolClaimTTL is how long a member handed to a task counts as busy
+// before the task starts running on it
+const warmPoolClaimTTL = time.Minute
+
+// warmPoolPendingTTL is how long a spawned member counts toward its pool
+// before the Agent Manager lists it
+const warmPoolPendingTTL = 2 * time.Minute
+
+// Warm pool member states, used as metric labels
+const (
+	PoolMemberIdle    = "idle"
+	PoolMemberBusy    = "busy"
+	PoolMemberPending = "pending"
+)
+
+var (
+	agentPoolMembers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_agent_pool_members",
+		Help: "Warm pool members as of the last check, by pool and state",
+	}, []string{"pool", "state"})
+
+	agentPoolSpawnsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_agent_pool_spawns_total",
+		Help: "Warm pool members spawned to replenish pools, by pool and result",
+	}, []string{"pool", "result"})
+
+	agentPoolScaleDownsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_agent_pool_scale_downs_total",
+		Help: "Idle warm pool members removed after their idle TTL, by pool and result",
+	}, []string{"pool", "result"})
+
+	agentPoolSelectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_agent_pool_selections_total",
+		Help: "Tasks of warm pool task types given an idle member (hit) or not (miss), by pool",
+	}, []string{"pool", "result"})
+)
+
+// PoolAgents lists, creates and deletes the agents of warm pools
+type PoolAgents interface {
+	ListAgents(ctx context.Context, filters *AgentFilters) (*AgentList, error)
+	CreateAgent(ctx context.Context, req *CreateAgentRequest) (*Agent, error)
+	DeleteAgent(ctx context.Context, agentID string) error
+}
+
+var _ PoolAgents = (*AgentClient)(nil)
+
+// PoolStatus describes a warm pool as of its last check
+type PoolStatus struct {
+	AgentType      string     `json:"agent_type"`
+	TaskTypes      []string   `json:"task_types"`
+	MinIdle        int        `json:"min_idle"`
+	MaxSize        int        `json:"max_size"`
+	IdleTTLSeconds int        `json:"idle_ttl_seconds"`
+	Members        int        `json:"members"`
+	Idle           int        `json:"idle"`
+	Busy           int        `json:"busy"`
+	Pending        int        `json:"pending"`   // Members spawned, not listed by the Agent Manager yet
+	InFlight       int        `json:"in_flight"` // Tasks running on members
+	CheckedAt      *time.Time `json:"checked_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// warmPool is the configuration of a pool and its members as of the last
+// check
+type warmPool struct {
+	cfg       config.WarmPoolConfig
+	members   map[string]Agent     // Members listed by the last check, and pending ones
+	pending   map[string]time.Time // Spawned members not listed yet, by spawn time
+	checkedAt time.Time
+	lastError string
+
+	// reconcile serializes the checks of the pool, so concurrent checks
+	// never spawn past its max size
+	reconcile sync.Mutex
+}
+
+// PoolManager keeps the warm pools of agent types between their minimum of
+// idle members and their maximum size. Members are the agents it created for
+// a pool, as listed by the Agent Manager; the tasks running on them are
+// counted in-process too, so members are not taken for idle before the Agent
+// Manager reports their load. Pools are checked every interval and whenever
+// a member is taken or freed.
+type PoolManager struct {
+	agents   PoolAgents
+	logger   *zap.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	pools      []*warmPool
+	byTaskType map[string]*warmPool
+
+	mu        sync.Mutex           // Guards the pools' members, pending and status, and the maps below
+	inFlight  map[string]int       // Tasks running on members
+	claims    map[string]time.Time // Members handed to tasks not running yet
+	idleSince map[string]time.Time
+
+	wake     chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewPoolManager creates the manager of the configured warm pools
+func NewPoolManager(agents PoolAgents, cfg *config.AgentManagerConfig, logger *zap.Logger) *PoolManager {
+	interval := time.Duration(cfg.PoolCheckInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	m := &PoolManager{
+		agents:     agents,
+		logger:     logger,
+		interval:   interval,
+		now:        time.Now,
+		byTaskType: make(map[string]*warmPool),
+		inFlight:   make(map[string]int),
+		claims:     make(map[string]time.Time),
+		idleSince:  make(map[string]time.Time),
+		wake:       make(chan struct{}, 1),
+		stopChan:   make(chan struct{}),
+	}
+	for _, poolCfg := range cfg.WarmPools {
+		pool := &warmPool{
+			cfg:     poolCfg,
+			members: make(map[string]Agent),
+			pending: make(map[string]time.Time),
+		}
+		m.pools = append(m.pools, pool)
+		for _, taskType := range poolCfg.TaskTypes {
+			if _, ok := m.byTaskType[taskType]; !ok {
+				m.byTaskType[taskType] = pool
+			}
+		}
+	}
+	return m
+}
+
+// Start starts checking the pools
+func (m *PoolManager) Start() {
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("Agent pool manager started",
+		zap.Int("pools", len(m.pools)),
+		zap.Duration("interval", m.interval))
+}
+
+// Stop stops checking the pools. Members are left running.
+func (m *PoolManager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+		m.wg.Wait()
+		m.logger.Info("Agent pool manager stopped")
+	})
+}
+
+func (m *PoolManager) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.Reconcile(context.Background())
+	for {
+		select {
+		case <-ticker.C:
+			m.Reconcile(context.Background())
+		case <-m.wake:
+			m.Reconcile(context.Background())
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// notify asks for a check of the pools without waiting for it
+func (m *PoolManager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Reconcile checks every pool: members are listed, idle members are spawned
+// up to the pool's minimum within its max size, and idle members beyond the
+// minimum are removed once idle for the pool's TTL
+func (m *PoolManager) Reconcile(ctx context.Context) {
+	for _, pool := range m.pools {
+		if err := m.reconcilePool(ctx, pool); err != nil {
+			m.logger.Warn("Failed to check warm pool",
+				zap.String("pool", pool.cfg.AgentType),
+				zap.Error(err))
+		}
+	}
+}
+
+func (m *PoolManager) reconcilePool(ctx context.Context, pool *warmPool) error {
+	pool.reconcile.Lock()
+	defer pool.reconcile.Unlock()
+
+	listed, err := m.listMembers(ctx, pool.cfg.AgentType)
+	if err != nil {
+		m.mu.Lock()
+		pool.lastError = err.Error()
+		m.mu.Unlock()
+		return err
+	}
+
+	m.mu.Lock()
+	m.refresh(pool, listed)
+	idle, _ := m.partition(pool)
+	spawns := min(pool.cfg.MinIdle-len(idle), pool.cfg.MaxSize-len(pool.members))
+	expired := m.takeExpired(pool, idle)
+	m.mu.Unlock()
+
+	var errs []error
+	for i := 0; i < spawns; i++ {
+		if err := m.spawn(ctx, pool); err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+	for _, agentID := range expired {
+		if err := m.remove(ctx, pool, agentID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err = errors.Join(errs...)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pool.lastError = ""
+	if err != nil {
+		pool.lastError = err.Error()
+	}
+	idle, busy := m.partition(pool)
+	agentPoolMembers.WithLabelValues(pool.cfg.AgentType, PoolMemberIdle).Set(float64(len(idle)))
+	agentPoolMembers.WithLabelValues(pool.cfg.AgentType, PoolMemberBusy).Set(float64(busy))
+	agentPoolMembers.WithLabelValues(pool.cfg.AgentType, PoolMemberPending).Set(float64(len(pool.pending)))
+	return err
+}
+
+// listMembers lists the members of a pool, skipping the agent list cache
+func (m *PoolManager) listMembers(ctx context.Context, agentType string) (map[string]Agent, error) {
+	members := make(map[string]Agent)
+	for page := 1; len(members) < maxClientFilteredAgents; page++ {
+		list, err := m.agents.ListAgents(ctx, &AgentFilters{
+			Type:     agentType,
+			Page:     page,
+			PageSize: agentListFetchPageSize,
+			Fresh:    true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list agents: %w", err)
+		}
+		for _, agent := range list.Agents {
+			if slices.Contains(agent.Tags, WarmPoolTag) {
+				members[agent.ID] = agent
+			}
+		}
+		if !list.HasMore || len(list.Agents) == 0 {
+			break
+		}
+	}
+	return members, nil
+}
+
+// refresh replaces the members of a pool with a new listing. Spawned
+// members stay members until listed, or for warmPoolPendingTTL. Callers hold
+// m.mu.
+func (m *PoolManager) refresh(pool *warmPool, listed map[string]Agent) {
+	now := m.now()
+	for agentID, spawnedAt := range pool.pending {
+		if _, ok := listed[agentID]; ok || now.Sub(spawnedAt) > warmPoolPendingTTL {
+			delete(pool.pending, agentID)
+			continue
+		}
+		listed[agentID] = pool.members[agentID]
+	}
+	for agentID := range pool.members {
+		if _, ok := listed[agentID]; !ok && m.inFlight[agentID] == 0 {
+			delete(m.idleSince, agentID)
+			delete(m.claims, agentID)
+		}
+	}
+	pool.members = listed
+	pool.checkedAt = now
+	for agentID, claimedAt := range m.claims {
+		if now.Sub(claimedAt) > warmPoolClaimTTL {
+			delete(m.claims, agentID)
+		}
+	}
+	// Members are idle since they were last seen busy
+	for agentID, agent := range listed {
+		if _, ok := m.idleSince[agentID]; !ok || m.busy(agent) {
+			m.idleSince[agentID] = now
+		}
+	}
+}
+
+// busy reports whether a member is running or about to run a task. Callers
+// hold m.mu.
+func (m *PoolManager) busy(agent Agent) bool {
+	if m.inFlight[agent.ID] > 0 || agent.Status != "available" || agent.Load > 0 {
+		return true
+	}
+	claimedAt, ok := m.claims[agent.ID]
+	return ok && m.now().Sub(claimedAt) <= warmPoolClaimTTL
+}
+
+// partition returns the idle members of a pool, longest idle first, and the
+// number of busy ones. Callers hold m.mu.
+func (m *PoolManager) partition(pool *warmPool) ([]Agent, int) {
+	var idle []Agent
+	busy := 0
+	for _, agent := range pool.members {
+		if m.busy(agent) {
+			busy++
+			continue
+		}
+		idle = append(idle, agent)
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		si, sj := m.idleSince[idle[i].ID], m.idleSince[idle[j].ID]
+		if !si.Equal(sj) {
+			return si.Before(sj)
+		}
+		return idle[i].ID < idle[j].ID
+	})
+	return idle, busy
+}
+
+// takeExpired takes the idle members beyond the pool's minimum that have
+// been idle for its TTL out of the pool, so they are not handed to tasks
+// while they are removed. Callers hold m.mu.
+func (m *PoolManager) takeExpired(pool *warmPool, idle []Agent) []string {
+	ttl := time.Duration(pool.cfg.IdleTTL) * time.Second
+	excess := len(idle) - pool.cfg.MinIdle
+	if ttl <= 0 || excess <= 0 {
+		return nil
+	}
+	now := m.now()
+	var expired []string
+	for _, agent := range idle[:excess] {
+		if now.Sub(m.idleSince[agent.ID]) < ttl {
+			break
+		}
+		delete(pool.members, agent.ID)
+		expired = append(expired, agent.ID)
+	}
+	return expired
+}
+
+// spawn creates a member of a pool
+func (m *PoolManager) spawn(ctx context.Context, pool *warmPool) error {
+	agent, err := m.agents.CreateAgent(ctx, &CreateAgentRequest{
+		Name:         fmt.Sprintf("%s-pool-%s", pool.cfg.AgentType, uuid.NewString()[:8]),
+		Type:         pool.cfg.AgentType,
+		Capabilities: pool.cfg.Capabilities,
+		Tags:         []string{WarmPoolTag},
+	})
+	if err != nil {
+		agentPoolSpawnsTotal.WithLabelValues(pool.cfg.AgentType, "failure").Inc()
+		return fmt.Errorf("failed to spawn %s pool member: %w", pool.cfg.AgentType, err)
+	}
+	agentPoolSpawnsTotal.WithLabelValues(pool.cfg.AgentType, "success").Inc()
+	m.logger.Info("Spawned warm pool member",
+		zap.String("pool", pool.cfg.AgentType),
+		zap.String("agent_id", agent.ID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := pool.members[agent.ID]; !ok {
+		pool.members[agent.ID] = *agent
+		pool.pending[agent.ID] = m.now()
+		m.idleSince[agent.ID] = m.now()
+	}
+	return nil
+}
+
+// remove deletes an idle member taken out of its pool. A member that fails
+// to delete rejoins the pool with the next listing.
+func (m *PoolManager) remove(ctx context.Context, pool *warmPool, agentID string) error {
+	err := m.agents.DeleteAgent(ctx, agentID)
+	if err != nil && !errors.Is(err, ErrAgentNotFound) {
+		agentPoolScaleDownsTotal.WithLabelValues(pool.cfg.AgentType, "failure").Inc()
+		return fmt.Errorf("failed to remove %s pool member %s: %w", pool.cfg.AgentType, agentID, err)
+	}
+	agentPoolScaleDownsTotal.WithLabelValues(pool.cfg.AgentType, "success").Inc()
+	m.logger.Info("Removed idle warm pool member",
+		zap.String("pool", pool.cfg.AgentType),
+		zap.String("agent_id", agentID))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.idleSince, agentID)
+	delete(m.claims, agentID)
+	return nil
+}
+
+// Claim hands an idle member of the pool serving a task type to a task,
+// nil when the type has no pool or the pool has no idle member outside
+// exclude. The member counts as busy until the task runs on it, or for a
+// minute.
+func (m *PoolManager) Claim(taskType string, exclude []string) *Agent {
+	if m == nil {
+		return nil
+	}
+	pool, ok := m.byTaskType[taskType]
+	if !ok {
+		return nil
+	}
+
+	m.mu.Lock()
+	var claimed *Agent
+	idle, _ := m.partition(pool)
+	for _, agent := range idle {
+		if !slices.Contains(exclude, agent.ID) {
+			m.claims[agent.ID] = m.now()
+			claimed = &agent
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if claimed == nil {
+		agentPoolSelectionsTotal.WithLabelValues(pool.cfg.AgentType, "miss").Inc()
+		return nil
+	}
+	agentPoolSelectionsTotal.WithLabelValues(pool.cfg.AgentType, "hit").Inc()
+	// Taking a member may leave the pool short of idle members
+	m.notify()
+	return claimed
+}
+
+// Acquire counts a task starting on an agent. Agents outside the pools are
+// ignored.
+func (m *PoolManager) Acquire(agentID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pool := range m.pools {
+		if _, ok := pool.members[agentID]; ok {
+			m.inFlight[agentID]++
+			delete(m.claims, agentID)
+			return
+		}
+	}
+}
+
+// Release counts a task acquired on an agent finishing
+func (m *PoolManager) Release(agentID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if m.inFlight[agentID] == 0 {
+		m.mu.Unlock()
+		return
+	}
+	m.inFlight[agentID]--
+	if m.inFlight[agentID] == 0 {
+		delete(m.inFlight, agentID)
+		m.idleSince[agentID] = m.now()
+	}
+	m.mu.Unlock()
+	m.notify()
+}
+
+// Status describes every pool as of its last check
+func (m *PoolManager) Status() []PoolStatus {
+	statuses := []PoolStatus{}
+	if m == nil {
+		return statuses
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, pool := range m.pools {
+		idle, busy := m.partition(pool)
+		status := PoolStatus{
+			AgentType:      pool.cfg.AgentType,
+			TaskTypes:      pool.cfg.TaskTypes,
+			MinIdle:        pool.cfg.MinIdle,
+			MaxSize:        pool.cfg.MaxSize,
+			IdleTTLSeconds: pool.cfg.IdleTTL,
+			Members:        len(pool.members),
+			Idle:           len(idle),
+			Busy:           busy,
+			Pending:        len(pool.pending),
+			LastError:      pool.lastError,
+		}
+		for agentID := range pool.members {
+			status.InFlight += m.inFlight[agentID]
+		}
+		if !pool.checkedAt.IsZero() {
+			checkedAt := pool.checkedAt
+			status.CheckedAt = &checkedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
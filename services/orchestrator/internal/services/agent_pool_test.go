@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// fakePoolAgentManager creates, lists and deletes agents in memory. Created
+// agents are listed right away and start idle.
+type fakePoolAgentManager struct {
+	mu         sync.Mutex
+	agents     map[string]Agent
+	created    int
+	deleted    []string
+	maxMembers int           // Most warm pool members ever listed at once
+	createWait time.Duration // Delay of every create, to widen races
+}
+
+func newFakePoolAgentManager(agents ...Agent) *fakePoolAgentManager {
+	manager := &fakePoolAgentManager{agents: make(map[string]Agent)}
+	for _, agent := range agents {
+		manager.agents[agent.ID] = agent
+	}
+	return manager
+}
+
+func (f *fakePoolAgentManager) ListAgents(ctx context.Context, filters *AgentFilters) (*AgentList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := &AgentList{}
+	for _, agent := range f.agents {
+		if filters.Type == "" || agent.Type == filters.Type {
+			list.Agents = append(list.Agents, agent)
+		}
+	}
+	list.TotalCount, list.TotalKnown = int64(len(list.Agents)), true
+	return list, nil
+}
+
+func (f *fakePoolAgentManager) CreateAgent(ctx context.Context, req *CreateAgentRequest) (*Agent, error) {
+	time.Sleep(f.createWait)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created++
+	agent := Agent{
+		ID:     fmt.Sprintf("%s-%d", req.Type, f.created),
+		Type:   req.Type,
+		Status: "available",
+		Tags:   req.Tags,
+	}
+	f.agents[agent.ID] = agent
+	members := 0
+	for _, agent := range f.agents {
+		if slices.Contains(agent.Tags, WarmPoolTag) {
+			members++
+		}
+	}
+	f.maxMembers = max(f.maxMembers, members)
+	return &agent, nil
+}
+
+func (f *fakePoolAgentManager) DeleteAgent(ctx context.Context, agentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.agents[agentID]; !ok {
+		return ErrAgentNotFound
+	}
+	delete(f.agents, agentID)
+	f.deleted = append(f.deleted, agentID)
+	return nil
+}
+
+func (f *fakePoolAgentManager) members() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, agent := range f.agents {
+		if slices.Contains(agent.Tags, WarmPoolTag) {
+			ids = append(ids, agent.ID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func poolMember(id string) Agent {
+	return Agent{ID: id, Type: "code_executor", Status: "available", Tags: []string{WarmPoolTag}}
+}
+
+func newTestPoolManager(manager *fakePoolAgentManager, pool config.WarmPoolConfig) (*PoolManager, *time.Time) {
+	pool.AgentType = "code_executor"
+	pool.TaskTypes = []string{"code_execution"}
+	pools := NewPoolManager(manager, &config.AgentManagerConfig{
+		WarmPools:         []config.WarmPoolConfig{pool},
+		PoolCheckInterval: 30,
+	}, zap.NewNop())
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pools.now = func() time.Time { return now }
+	return pools, &now
+}
+
+func TestPoolManager_ReplenishesOnConsumption(t *testing.T) {
+	manager := newFakePoolAgentManager()
+	pools, _ := newTestPoolManager(manager, config.WarmPoolConfig{MinIdle: 2, MaxSize: 4})
+	ctx := context.Background()
+
+	pools.Reconcile(ctx)
+	assert.Len(t, manager.members(), 2)
+
+	// Tasks of other types are not served by the pool
+	assert.Nil(t, pools.Claim("deployment", nil))
+
+	// A claimed member is busy, so the pool spawns a replacement
+	agent := pools.Claim("code_execution", nil)
+	require.NotNil(t, agent)
+	pools.Acquire(agent.ID)
+	pools.Reconcile(ctx)
+	assert.Len(t, manager.members(), 3)
+
+	status := pools.Status()
+	require.Len(t, status, 1)
+	assert.Equal(t, 3, status[0].Members)
+	assert.Equal(t, 2, status[0].Idle)
+	assert.Equal(t, 1, status[0].Busy)
+	assert.Equal(t, 1, status[0].InFlight)
+
+	// Claimed members are not handed out twice
+	second := pools.Claim("code_execution", nil)
+	third := pools.Claim("code_execution", nil)
+	require.NotNil(t, second)
+	require.NotNil(t, third)
+	assert.NotEqual(t, second.ID, third.ID)
+	assert.NotContains(t, []string{second.ID, third.ID}, agent.ID)
+	assert.Nil(t, pools.Claim("code_execution", nil))
+
+	// Only one more member fits under the max size
+	pools.Reconcile(ctx)
+	assert.Len(t, manager.members(), 4)
+	pools.Reconcile(ctx)
+	assert.Len(t, manager.members(), 4)
+	assert.Equal(t, 4, manager.created)
+
+	// Freed members are idle again
+	pools.Release(agent.ID)
+	assert.Equal(t, 2, pools.Status()[0].Idle)
+}
+
+func TestPoolManager_ScalesDownIdleMembers(t *testing.T) {
+	other := Agent{ID: "unpooled", Type: "code_executor", Status: "available"}
+	manager := newFakePoolAgentManager(poolMember("pool-1"), poolMember("pool-2"), poolMember("pool-3"), poolMember("pool-4"), other)
+	pools, now := newTestPoolManager(manager, config.WarmPoolConfig{MinIdle: 1, MaxSize: 5, IdleTTL: 600})
+	ctx := context.Background()
+
+	pools.Reconcile(ctx)
+	pools.Acquire("pool-1")
+	pools.Acquire("pool-2")
+
+	// Idle members are kept until their TTL
+	*now = now.Add(5 * time.Minute)
+	pools.Reconcile(ctx)
+	assert.Empty(t, manager.deleted)
+
+	// pool-1 finishes, so it has been idle for less than the TTL
+	pools.Release("pool-1")
+	*now = now.Add(6 * time.Minute)
+	pools.Reconcile(ctx)
+
+	// The expired members are removed down to the minimum of idle members;
+	// busy members and agents outside the pool are kept
+	assert.ElementsMatch(t, []string{"pool-3", "pool-4"}, manager.deleted)
+	assert.Equal(t, []string{"pool-1", "pool-2"}, manager.members())
+
+	*now = now.Add(time.Hour)
+	pools.Reconcile(ctx)
+	assert.Len(t, manager.deleted, 2, "min idle members are kept")
+	assert.Contains(t, manager.members(), "pool-2", "members with tasks in flight are kept")
+	assert.Equal(t, 0, manager.created)
+
+	_, ok := manager.agents["unpooled"]
+	assert.True(t, ok)
+}
+
+func TestPoolManager_MaxSizeUnderSpawnStorm(t *testing.T) {
+	manager := newFakePoolAgentManager()
+	manager.createWait = time.Millisecond
+	pools, _ := newTestPoolManager(manager, config.WarmPoolConfig{MinIdle: 3, MaxSize: 5})
+	ctx := context.Background()
+	pools.Reconcile(ctx)
+
+	// Tasks take every member they can while checks run concurrently
+	consume := func() {
+		for agent := pools.Claim("code_execution", nil); agent != nil; agent = pools.Claim("code_execution", nil) {
+			pools.Acquire(agent.ID)
+		}
+		pools.Reconcile(ctx)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consume()
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, manager.maxMembers, 5)
+
+	// Once every member is busy the pool stays at its max size
+	for i := 0; i < 3; i++ {
+		consume()
+	}
+	assert.Equal(t, 5, manager.maxMembers)
+	assert.Equal(t, 5, manager.created)
+	status := pools.Status()[0]
+	assert.Equal(t, 5, status.Members)
+	assert.Equal(t, 5, status.Busy)
+}
+
+func TestPoolManager_NilIsSafe(t *testing.T) {
+	var pools *PoolManager
+	assert.Nil(t, pools.Claim("code_execution", nil))
+	pools.Acquire("agent-1")
+	pools.Release("agent-1")
+	assert.Empty(t, pools.Status())
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidAgentPreferences is returned for agent preferences listing
+// empty or unknown agent IDs, agents both preferred and excluded, or a boost
+// outside 0-1
+var ErrInvalidAgentPreferences = errors.New("invalid agent preferences")
+
+// How a project's agent preferences influenced the selection of an agent,
+// recorded on the selected agent
+const (
+	// PreferenceBoosted is a preferred agent ranked ahead of agents
+	// matching the task at least as well
+	PreferenceBoosted = "preferred"
+	// PreferenceExcluded is an agent selected after excluded agents that
+	// could have taken the task were left out
+	PreferenceExcluded = "excluded"
+)
+
+// agentPreferencesSettingsKey is the key of agent preferences in project
+// settings
+const agentPreferencesSettingsKey = "agent_preferences"
+
+var agentPreferenceDecisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_agent_preference_decisions_total",
+	Help: "Agent selections influenced by the agent preferences of their project, by outcome",
+}, []string{"outcome"})
+
+// ValidateAgentPreferences checks that agent preferences list no empty IDs
+// or types, no agent both preferred and excluded, and a boost within 0-1.
+// Whether the agents exist is checked by AgentPreferenceService.Update.
+func ValidateAgentPreferences(prefs *models.AgentPreferences) error {
+	if prefs == nil {
+		return nil
+	}
+	for name, values := range map[string][]string{
+		"preferred_agent_ids":   prefs.PreferredAgentIDs,
+		"preferred_agent_types": prefs.PreferredAgentTypes,
+		"excluded_agent_ids":    prefs.ExcludedAgentIDs,
+	} {
+		for _, value := range values {
+			if strings.TrimSpace(value) == "" {
+				return fmt.Errorf("%w: %s lists an empty value", ErrInvalidAgentPreferences, name)
+			}
+		}
+	}
+	for _, agentID := range prefs.ExcludedAgentIDs {
+		if slices.Contains(prefs.PreferredAgentIDs, agentID) {
+			return fmt.Errorf("%w: agent %s is both preferred and excluded", ErrInvalidAgentPreferences, agentID)
+		}
+	}
+	if prefs.Boost < 0 || prefs.Boost > 1 {
+		return fmt.Errorf("%w: boost %v is not between 0 and 1", ErrInvalidAgentPreferences, prefs.Boost)
+	}
+	return nil
+}
+
+// EffectiveAgentPreferences are the agent preferences of a project with the
+// boost that applies to its preferred agents. A nil value prefers and
+// excludes nothing.
+type EffectiveAgentPreferences struct {
+	ProjectID string `json:"project_id"`
+	models.AgentPreferences
+	EffectiveBoost float64 `json:"effective_boost"`
+}
+
+// Filter leaves out the excluded agents, returning the IDs of those it left
+// out
+func (p *EffectiveAgentPreferences) Filter(agents []Agent) ([]Agent, []string) {
+	if p == nil || len(p.ExcludedAgentIDs) == 0 {
+		return agents, nil
+	}
+	var kept []Agent
+	var excluded []string
+	for _, agent := range agents {
+		if slices.Contains(p.ExcludedAgentIDs, agent.ID) {
+			excluded = append(excluded, agent.ID)
+			continue
+		}
+		kept = append(kept, agent)
+	}
+	return kept, excluded
+}
+
+// Preferred reports whether an agent is preferred by ID or type
+func (p *EffectiveAgentPreferences) Preferred(agent Agent) bool {
+	if p == nil {
+		return false
+	}
+	return slices.Contains(p.PreferredAgentIDs, agent.ID) || slices.Contains(p.PreferredAgentTypes, agent.Type)
+}
+
+// Rank orders agents qualified for a task by their score raised by the boost
+// when preferred, scaled to the highest score possible (1 for match
+// fractions); preferred agents win ties, then the least loaded. Without
+// preferred agents among them, agents are returned in their order.
+func (p *EffectiveAgentPreferences) Rank(agents []Agent, scores map[string]float64, scale float64) []Agent {
+	if !slices.ContainsFunc(agents, p.Preferred) {
+		return agents
+	}
+	boosted := make(map[string]float64, len(agents))
+	for _, agent := range agents {
+		boosted[agent.ID] = scores[agent.ID]
+		if p.Preferred(agent) {
+			boosted[agent.ID] += p.EffectiveBoost * scale
+		}
+	}
+	ranked := slices.Clone(agents)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if boosted[ranked[i].ID] != boosted[ranked[j].ID] {
+			return boosted[ranked[i].ID] > boosted[ranked[j].ID]
+		}
+		if preferred := p.Preferred(ranked[i]); preferred != p.Preferred(ranked[j]) {
+			return preferred
+		}
+		return ranked[i].Load < ranked[j].Load
+	})
+	return ranked
+}
+
+// Influence reports how the preferences influenced the selection of an
+// agent among the ranked agents, and why: PreferenceBoosted when it is
+// preferred and ranked ahead of an agent scoring at least as well,
+// PreferenceExcluded when excluded agents were left out, else "". Outcomes
+// are counted.
+func (p *EffectiveAgentPreferences) Influence(selected *Agent, ranked []Agent, scores map[string]float64, excluded []string) (string, string) {
+	if p == nil || selected == nil {
+		return "", ""
+	}
+	if p.Preferred(*selected) {
+		for _, agent := range ranked {
+			if agent.ID != selected.ID && !p.Preferred(agent) && scores[agent.ID] >= scores[selected.ID] {
+				agentPreferenceDecisionsTotal.WithLabelValues(PreferenceBoosted).Inc()
+				return PreferenceBoosted, fmt.Sprintf("project %s prefers agent %s over %s (score %.2f, %.2f without preference)",
+					p.ProjectID, selected.ID, agent.ID, scores[agent.ID], scores[selected.ID])
+			}
+		}
+	}
+	if len(excluded) > 0 {
+		agentPreferenceDecisionsTotal.WithLabelValues(PreferenceExcluded).Inc()
+		return PreferenceExcluded, fmt.Sprintf("project %s excludes agents %s", p.ProjectID, strings.Join(excluded, ", "))
+	}
+	return "", ""
+}
+
+// AgentPreferenceService resolves and updates the agent preferences projects
+// set in their settings. Preferences are read whenever an agent is selected,
+// so changes apply to the next selection.
+type AgentPreferenceService struct {
+	db     *gorm.DB
+	agents AgentExecutor
+	boost  float64
+}
+
+// NewAgentPreferenceService creates the agent preference service; agents
+// checks that the agents preferences name exist
+func NewAgentPreferenceService(db *gorm.DB, agents AgentExecutor, cfg *config.AgentManagerConfig) *AgentPreferenceService {
+	return &AgentPreferenceService{db: db, agents: agents, boost: cfg.PreferenceBoost}
+}
+
+// Preferences returns the effective agent preferences of a project, or
+// ErrProjectNotFound
+func (s *AgentPreferenceService) Preferences(ctx context.Context, projectID string) (*EffectiveAgentPreferences, error) {
+	var project models.Project
+	err := s.db.WithContext(ctx).Select("id", "settings").First(&project, "id = ?", projectID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project settings: %w", err)
+	}
+	prefs, err := project.AgentPreferences()
+	if err != nil {
+		return nil, err
+	}
+	return s.effective(projectID, prefs), nil
+}
+
+func (s *AgentPreferenceService) effective(projectID string, prefs *models.AgentPreferences) *EffectiveAgentPreferences {
+	effective := &EffectiveAgentPreferences{ProjectID: projectID, EffectiveBoost: s.boost}
+	if prefs != nil {
+		effective.AgentPreferences = *prefs
+		if prefs.Boost > 0 {
+			effective.EffectiveBoost = prefs.Boost
+		}
+	}
+	return effective
+}
+
+// Update replaces the agent preferences of a project, keeping the rest of
+// its settings. Every agent the preferences name must exist;
+// ErrInvalidAgentPreferences names the first one that does not.
+func (s *AgentPreferenceService) Update(ctx context.Context, projectID string, prefs models.AgentPreferences) (*EffectiveAgentPreferences, error) {
+	if err := ValidateAgentPreferences(&prefs); err != nil {
+		return nil, err
+	}
+	for _, agentID := range append(slices.Clone(prefs.PreferredAgentIDs), prefs.ExcludedAgentIDs...) {
+		if _, err := s.agents.GetAgent(ctx, agentID); err != nil {
+			if errors.Is(err, ErrAgentNotFound) {
+				return nil, fmt.Errorf("%w: unknown agent %s", ErrInvalidAgentPreferences, agentID)
+			}
+			return nil, fmt.Errorf("failed to get agent %s: %w", agentID, err)
+		}
+	}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var project models.Project
+		err := tx.Select("id", "settings").First(&project, "id = ?", projectID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProjectNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get project settings: %w", err)
+		}
+
+		// Settings the orchestrator does not act on are kept as they are
+		settings := map[string]json.RawMessage{}
+		if len(project.Settings) > 0 && string(project.Settings) != "null" {
+			if err := json.Unmarshal(project.Settings, &settings); err != nil {
+				return fmt.Errorf("failed to parse project settings: %w", err)
+			}
+		}
+		encoded, err := json.Marshal(prefs)
+		if err != nil {
+			return fmt.Errorf("failed to marshal agent preferences: %w", err)
+		}
+		settings[agentPreferencesSettingsKey] = encoded
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return fmt.Errorf("failed to marshal project settings: %w", err)
+		}
+		if err := tx.Model(&project).Update("settings", json.RawMessage(data)).Error; err != nil {
+			return fmt.Errorf("failed to update project settings: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.effective(projectID, &prefs), nil
+}
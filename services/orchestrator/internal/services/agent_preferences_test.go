@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+func TestValidateAgentPreferences(t *testing.T) {
+	assert.NoError(t, ValidateAgentPreferences(nil))
+	assert.NoError(t, ValidateAgentPreferences(&models.AgentPreferences{
+		PreferredAgentIDs: []string{"a"}, PreferredAgentTypes: []string{"backend"}, ExcludedAgentIDs: []string{"b"}, Boost: 0.5,
+	}))
+
+	for name, prefs := range map[string]models.AgentPreferences{
+		"empty ID":               {PreferredAgentIDs: []string{" "}},
+		"empty type":             {PreferredAgentTypes: []string{""}},
+		"preferred and excluded": {PreferredAgentIDs: []string{"a"}, ExcludedAgentIDs: []string{"a"}},
+		"negative boost":         {Boost: -0.1},
+		"boost above one":        {Boost: 1.5},
+	} {
+		assert.ErrorIs(t, ValidateAgentPreferences(&prefs), ErrInvalidAgentPreferences, name)
+	}
+}
+
+func TestEffectiveAgentPreferences_Selection(t *testing.T) {
+	agents := []Agent{
+		{ID: "best", Type: "backend", Load: 0.1},
+		{ID: "team", Type: "fine-tuned", Load: 0.2},
+		{ID: "broken", Type: "backend", Load: 0},
+	}
+	scores := map[string]float64{"best": 1, "team": 0.85, "broken": 1}
+	prefs := &EffectiveAgentPreferences{
+		ProjectID:        "project-1",
+		AgentPreferences: models.AgentPreferences{PreferredAgentTypes: []string{"fine-tuned"}, ExcludedAgentIDs: []string{"broken"}},
+		EffectiveBoost:   0.2,
+	}
+
+	t.Run("excluded agents are left out", func(t *testing.T) {
+		kept, excluded := prefs.Filter(agents)
+		assert.Equal(t, []string{"broken"}, excluded)
+		assert.Equal(t, []Agent{agents[0], agents[1]}, kept)
+	})
+
+	t.Run("preferred agents are boosted", func(t *testing.T) {
+		kept, excluded := prefs.Filter(agents)
+		ranked := prefs.Rank(kept, scores, 1)
+		assert.Equal(t, "team", ranked[0].ID, "0.85 + 0.2 beats 1")
+
+		outcome, reason := prefs.Influence(&ranked[0], ranked, scores, excluded)
+		assert.Equal(t, PreferenceBoosted, outcome)
+		assert.Contains(t, reason, "over best")
+	})
+
+	t.Run("a boost too small leaves the order", func(t *testing.T) {
+		small := *prefs
+		small.EffectiveBoost = 0.1
+		kept, excluded := small.Filter(agents)
+		ranked := small.Rank(kept, scores, 1)
+		assert.Equal(t, "best", ranked[0].ID)
+
+		outcome, reason := small.Influence(&ranked[0], ranked, scores, excluded)
+		assert.Equal(t, PreferenceExcluded, outcome, "the excluded agent could have taken the task")
+		assert.Contains(t, reason, "broken")
+	})
+
+	t.Run("preferred agents win ties", func(t *testing.T) {
+		tied := map[string]float64{"best": 1, "team": 0.8}
+		ranked := prefs.Rank([]Agent{agents[0], agents[1]}, tied, 1)
+		assert.Equal(t, "team", ranked[0].ID)
+
+		// Scores counting capabilities scale the boost
+		counted := map[string]float64{"best": 5, "team": 4}
+		ranked = prefs.Rank([]Agent{agents[0], agents[1]}, counted, 5)
+		assert.Equal(t, "team", ranked[0].ID)
+	})
+
+	t.Run("without preferences nothing changes", func(t *testing.T) {
+		var none *EffectiveAgentPreferences
+		kept, excluded := none.Filter(agents)
+		assert.Equal(t, agents, kept)
+		assert.Empty(t, excluded)
+		assert.Equal(t, agents, none.Rank(agents, scores, 1))
+		outcome, _ := none.Influence(&agents[0], agents, scores, nil)
+		assert.Empty(t, outcome)
+	})
+}
+
+func TestAgentPreferenceService_Update(t *testing.T) {
+	ctx := context.Background()
+	db := setupWorkflowTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, updated_at DATETIME, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, settings) VALUES
+			('project-1', CAST('{"encrypt_at_rest": true, "custom": {"team": "platform"}}' AS BLOB))`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	service := NewAgentPreferenceService(db, NewStubAgentClient(nil), &config.AgentManagerConfig{PreferenceBoost: 0.2})
+
+	prefs, err := service.Preferences(ctx, "project-1")
+	require.NoError(t, err)
+	assert.Empty(t, prefs.PreferredAgentIDs)
+	assert.Equal(t, 0.2, prefs.EffectiveBoost)
+
+	prefs, err = service.Update(ctx, "project-1", models.AgentPreferences{
+		PreferredAgentIDs: []string{stubCodeExecutorID},
+		ExcludedAgentIDs:  []string{stubMetaAgentID},
+		Boost:             0.3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0.3, prefs.EffectiveBoost)
+
+	prefs, err = service.Preferences(ctx, "project-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{stubCodeExecutorID}, prefs.PreferredAgentIDs)
+	assert.Equal(t, []string{stubMetaAgentID}, prefs.ExcludedAgentIDs)
+	assert.Equal(t, 0.3, prefs.EffectiveBoost)
+
+	// The rest of the settings is kept
+	var project models.Project
+	require.NoError(t, db.Select("id", "settings").First(&project, "id = ?", "project-1").Error)
+	var settings map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(project.Settings, &settings))
+	assert.JSONEq(t, `true`, string(settings["encrypt_at_rest"]))
+	assert.JSONEq(t, `{"team": "platform"}`, string(settings["custom"]))
+
+	t.Run("unknown agents are rejected", func(t *testing.T) {
+		_, err := service.Update(ctx, "project-1", models.AgentPreferences{ExcludedAgentIDs: []string{"missing-agent"}})
+		assert.ErrorIs(t, err, ErrInvalidAgentPreferences)
+		assert.Contains(t, err.Error(), "missing-agent")
+
+		prefs, err := service.Preferences(ctx, "project-1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{stubMetaAgentID}, prefs.ExcludedAgentIDs, "rejected preferences are not stored")
+	})
+
+	t.Run("unknown projects", func(t *testing.T) {
+		_, err := service.Preferences(ctx, "project-2")
+		assert.ErrorIs(t, err, ErrProjectNotFound)
+		_, err = service.Update(ctx, "project-2", models.AgentPreferences{})
+		assert.ErrorIs(t, err, ErrProjectNotFound)
+	})
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"orchestrator/internal/config"
+)
+
+// DynamicAgentType is the type of agents created on demand for tasks
+const DynamicAgentType = "dynamic"
+
+// Agent selection decisions, recorded on the selected agent for observability
+const (
+	// SelectionMatchedExisting is an existing agent with spare capacity
+	SelectionMatchedExisting = "matched_existing"
+	// SelectionSpawnedNoMatch is a new agent because none had the capabilities
+	SelectionSpawnedNoMatch = "spawned_no_match"
+	// SelectionSpawnedSaturated is a new agent because every qualified agent
+	// was at or above its utilization threshold
+	SelectionSpawnedSaturated = "spawned_due_to_saturation"
+	// SelectionQueuedSaturated is the least loaded of the saturated qualified
+	// agents, used when no new agent may or could be created
+	SelectionQueuedSaturated = "queued_on_saturated"
+	// SelectionFellBackToMeta is the meta-prompt agent itself, used when a new
+	// agent could not be created
+	SelectionFellBackToMeta = "fell_back_to_meta"
+	// SelectionAffinity is the agent that ran an earlier task of the same
+	// task group, reused while it is available and below its threshold
+	SelectionAffinity = "reused_by_affinity"
+	// SelectionWarmPool is an idle member of the warm pool serving the
+	// task's type
+	SelectionWarmPool = "warm_pool"
+)
+
+// AgentSelectionPolicy sets soft concurrency caps per agent type. Agents at
+// or above their type's utilization threshold are saturated; when every agent
+// qualified for a task is saturated, the task spills over to a new dynamic
+// agent, up to MaxDynamicAgentsPerProject. The zero value never saturates.
+type AgentSelectionPolicy struct {
+	UtilizationThreshold       float64            // Load, 0-1, at which agents are saturated; 0 disables the caps
+	TypeUtilizationThresholds  map[string]float64 // Per agent type overrides of UtilizationThreshold
+	MaxDynamicAgentsPerProject int                // Dynamic agents a project may have before spillover stops
+}
+
+// NewAgentSelectionPolicy creates the selection policy from configuration
+func NewAgentSelectionPolicy(cfg *config.AgentManagerConfig) AgentSelectionPolicy {
+	return AgentSelectionPolicy{
+		UtilizationThreshold:       cfg.UtilizationThreshold,
+		TypeUtilizationThresholds:  cfg.TypeUtilizationThresholds,
+		MaxDynamicAgentsPerProject: cfg.MaxDynamicAgentsPerProject,
+	}
+}
+
+// Threshold returns the utilization threshold of an agent type, 0 when
+// agents of the type never saturate
+func (p AgentSelectionPolicy) Threshold(agentType string) float64 {
+	if threshold, ok := p.TypeUtilizationThresholds[agentType]; ok {
+		return threshold
+	}
+	return p.UtilizationThreshold
+}
+
+// Saturated reports whether an agent's load is at or above its type's
+// utilization threshold
+func (p AgentSelectionPolicy) Saturated(agent Agent) bool {
+	threshold := p.Threshold(agent.Type)
+	return threshold > 0 && agent.Load >= threshold
+}
+
+// AgentSelection is the outcome of matching a task against the fleet
+type AgentSelection struct {
+	Agent    *Agent // Agent to use; nil when a new agent should be spawned
+	Fallback *Agent // Agent to use should spawning fail
+	Decision string
+	Reason   string
+}
+
+// Spawn reports whether a new agent should be created
+func (s AgentSelection) Spawn() bool {
+	return s.Agent == nil
+}
+
+// SelectAgent applies the policy to the agents qualified for a task, ordered
+// best match first. The best agent with spare capacity is used. When all are
+// saturated a new agent is requested while the project is below its dynamic
+// agent cap; otherwise the task queues on the least loaded agent. Without
+// qualified agents a new agent is requested regardless of the cap.
+func (p AgentSelectionPolicy) SelectAgent(ctx context.Context, client AgentExecutor, projectID string, qualified []Agent) AgentSelection {
+	if len(qualified) == 0 {
+		return AgentSelection{Decision: SelectionSpawnedNoMatch, Reason: "no agent provides the required capabilities"}
+	}
+
+	leastLoaded := &qualified[0]
+	for i := range qualified {
+		agent := &qualified[i]
+		if !p.Saturated(*agent) {
+			return AgentSelection{
+				Agent:    agent,
+				Decision: SelectionMatchedExisting,
+				Reason:   fmt.Sprintf("load %.2f below threshold %.2f", agent.Load, p.Threshold(agent.Type)),
+			}
+		}
+		if agent.Load < leastLoaded.Load {
+			leastLoaded = agent
+		}
+	}
+
+	saturated := fmt.Sprintf("all %d qualified agents at or above their utilization threshold", len(qualified))
+	if p.MaxDynamicAgentsPerProject <= 0 {
+		return AgentSelection{Agent: leastLoaded, Decision: SelectionQueuedSaturated, Reason: saturated + "; spillover disabled"}
+	}
+	// Agents spawned moments ago count toward the cap, so the count skips
+	// the agent list cache
+	list, err := client.ListAgents(ctx, &AgentFilters{ProjectID: projectID, Type: DynamicAgentType, Fresh: true})
+	if err != nil {
+		return AgentSelection{
+			Agent:    leastLoaded,
+			Decision: SelectionQueuedSaturated,
+			Reason:   fmt.Sprintf("%s; failed to count dynamic agents: %v", saturated, err),
+		}
+	}
+	dynamic := list.MinTotal()
+	if dynamic >= int64(p.MaxDynamicAgentsPerProject) {
+		return AgentSelection{
+			Agent:    leastLoaded,
+			Decision: SelectionQueuedSaturated,
+			Reason:   fmt.Sprintf("%s; project has %d of %d dynamic agents", saturated, dynamic, p.MaxDynamicAgentsPerProject),
+		}
+	}
+	return AgentSelection{
+		Fallback: leastLoaded,
+		Decision: SelectionSpawnedSaturated,
+		Reason:   fmt.Sprintf("%s; project has %d of %d dynamic agents", saturated, dynamic, p.MaxDynamicAgentsPerProject),
+	}
+}
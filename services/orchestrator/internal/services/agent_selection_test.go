@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentSelectionPolicy_SelectAgent(t *testing.T) {
+	ctx := context.Background()
+	policy := AgentSelectionPolicy{
+		UtilizationThreshold:       0.8,
+		TypeUtilizationThresholds:  map[string]float64{"gpu": 0.5},
+		MaxDynamicAgentsPerProject: 2,
+	}
+	fleet := func(loads ...float64) []Agent {
+		agents := make([]Agent, len(loads))
+		for i, load := range loads {
+			agents[i] = Agent{ID: string(rune('a' + i)), Type: "backend", Load: load}
+		}
+		return agents
+	}
+	client := NewStubAgentClient(nil)
+
+	t.Run("idle fleet uses the best match", func(t *testing.T) {
+		selection := policy.SelectAgent(ctx, client, "project-1", fleet(0.1, 0))
+		assert.False(t, selection.Spawn())
+		assert.Equal(t, "a", selection.Agent.ID)
+		assert.Equal(t, SelectionMatchedExisting, selection.Decision)
+	})
+
+	t.Run("saturated agents are skipped", func(t *testing.T) {
+		selection := policy.SelectAgent(ctx, client, "project-1", fleet(0.8, 0.95, 0.3))
+		assert.Equal(t, "c", selection.Agent.ID)
+		assert.Equal(t, SelectionMatchedExisting, selection.Decision)
+	})
+
+	t.Run("thresholds apply per agent type", func(t *testing.T) {
+		agents := []Agent{{ID: "gpu-1", Type: "gpu", Load: 0.6}, {ID: "cpu-1", Type: "backend", Load: 0.7}}
+		selection := policy.SelectAgent(ctx, client, "project-1", agents)
+		assert.Equal(t, "cpu-1", selection.Agent.ID)
+	})
+
+	t.Run("saturated fleet spills over to a new agent", func(t *testing.T) {
+		selection := policy.SelectAgent(ctx, client, "project-1", fleet(0.9, 0.85))
+		assert.True(t, selection.Spawn())
+		assert.Equal(t, SelectionSpawnedSaturated, selection.Decision)
+		require.NotNil(t, selection.Fallback)
+		assert.Equal(t, "b", selection.Fallback.ID, "the least loaded agent is the fallback")
+	})
+
+	t.Run("spillover stops at the dynamic agent cap", func(t *testing.T) {
+		capped := NewStubAgentClient(nil)
+		for _, name := range []string{"one", "two"} {
+			_, err := capped.CreateAgent(ctx, &CreateAgentRequest{Name: name, Type: DynamicAgentType, ProjectID: "project-1"})
+			require.NoError(t, err)
+		}
+
+		selection := policy.SelectAgent(ctx, capped, "project-1", fleet(0.9, 0.85))
+		assert.False(t, selection.Spawn())
+		assert.Equal(t, "b", selection.Agent.ID)
+		assert.Equal(t, SelectionQueuedSaturated, selection.Decision)
+		assert.Contains(t, selection.Reason, "2 of 2 dynamic agents")
+	})
+
+	t.Run("no match spawns regardless of the cap", func(t *testing.T) {
+		selection := policy.SelectAgent(ctx, client, "project-1", nil)
+		assert.True(t, selection.Spawn())
+		assert.Nil(t, selection.Fallback)
+		assert.Equal(t, SelectionSpawnedNoMatch, selection.Decision)
+	})
+
+	t.Run("zero policy never saturates", func(t *testing.T) {
+		selection := AgentSelectionPolicy{}.SelectAgent(ctx, client, "project-1", fleet(1, 1))
+		assert.Equal(t, "a", selection.Agent.ID)
+		assert.Equal(t, SelectionMatchedExisting, selection.Decision)
+	})
+}
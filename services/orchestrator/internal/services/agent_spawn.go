@@ -0,0 +1,450 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// ErrSpawnWaitTimeout is returned when neither a spawn slot nor the agent of
+// a concurrent spawn became available within the wait timeout
+var ErrSpawnWaitTimeout = errors.New("timed out waiting to spawn an agent")
+
+// Spawn outcomes, recorded as the agent_spawn_requests_total result label
+const (
+	// SpawnCreated is a new agent designed and spawned by the caller
+	SpawnCreated = "created"
+	// SpawnReused is an agent spawned earlier for the same capabilities
+	SpawnReused = "reused"
+	// SpawnJoined is an agent spawned by a concurrent request for the same
+	// capabilities that the caller waited for
+	SpawnJoined = "joined"
+)
+
+var agentSpawnRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_agent_spawn_requests_total",
+	Help: "Requests for dynamic agents by outcome; reused and joined requests are deduplicated spawns",
+}, []string{"result"})
+
+var agentSpawnRateLimitedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "orchestrator_agent_spawn_rate_limited_total",
+	Help: "Spawns that waited for a free slot of the per-project spawn rate limit",
+})
+
+// CapabilityFingerprint identifies the kind of agent a set of required
+// capabilities calls for, independent of their order and case
+func CapabilityFingerprint(capabilities []string) string {
+	normalized := make([]string, 0, len(capabilities))
+	seen := make(map[string]bool, len(capabilities))
+	for _, capability := range capabilities {
+		capability = strings.ToLower(strings.TrimSpace(capability))
+		if capability != "" && !seen[capability] {
+			seen[capability] = true
+			normalized = append(normalized, capability)
+		}
+	}
+	sort.Strings(normalized)
+	sum := sha256.Sum256([]byte(strings.Join(normalized, ",")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SpawnStore holds the shared state of spawn coordination
+type SpawnStore interface {
+	// AcquireLock takes key for ttl unless it is held, returning a token that
+	// releases it
+	AcquireLock(ctx context.Context, key string, ttl time.Duration) (token string, ok bool, err error)
+	ReleaseLock(ctx context.Context, key, token string) error
+	// Get returns the value of key, "" when it is not set
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Increment adds one to the counter at key, which expires ttl after its
+	// first increment
+	Increment(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// redisSpawnStore keeps spawn coordination state in Redis so it is shared
+// by every worker. While Redis is unreachable spawns carry on: locks are
+// taken from the fallback locker, cached agents read as missing, and the
+// rate limit lets spawns through.
+type redisSpawnStore struct {
+	client   *redis.Client
+	guard    *RedisGuard
+	fallback Locker
+}
+
+// fallbackLockPrefix marks the tokens of locks taken from the fallback locker
+const fallbackLockPrefix = "fallback:"
+
+// NewRedisSpawnStore stores spawn coordination state in Redis, taking locks
+// from fallback while Redis is unreachable. Without a fallback, locks fail
+// while Redis is unreachable.
+func NewRedisSpawnStore(client *redis.Client, fallback Locker) SpawnStore {
+	return &redisSpawnStore{client: client, guard: RedisGuardFor(client, nil), fallback: fallback}
+}
+
+// releaseLockScript deletes a lock only while it is held by the token
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (r *redisSpawnStore) AcquireLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", false, err
+	}
+	ok, err := r.client.SetNX(ctx, key, hex.EncodeToString(token), ttl).Result()
+	if IsRedisOutage(err) && r.fallback != nil {
+		fallbackToken, ok, err := r.fallback.TryLock(ctx, key, ttl)
+		return fallbackLockPrefix + fallbackToken, ok, err
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(token), ok, nil
+}
+
+func (r *redisSpawnStore) ReleaseLock(ctx context.Context, key, token string) error {
+	if fallbackToken, ok := strings.CutPrefix(token, fallbackLockPrefix); ok && r.fallback != nil {
+		return r.fallback.Unlock(ctx, fallbackToken)
+	}
+	return releaseLockScript.Run(ctx, r.client, []string{key}, token).Err()
+}
+
+func (r *redisSpawnStore) Get(ctx context.Context, key string) (string, error) {
+	value, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) || IsRedisOutage(err) {
+		return "", nil
+	}
+	return value, err
+}
+
+func (r *redisSpawnStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := r.client.Set(ctx, key, value, ttl).Err(); !IsRedisOutage(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *redisSpawnStore) Delete(ctx context.Context, key string) error {
+	// Deletes missed while Redis is unreachable are replayed once it is back
+	r.guard.Invalidate(ctx, key)
+	return nil
+}
+
+func (r *redisSpawnStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := r.client.Incr(ctx, key).Result()
+	if IsRedisOutage(err) {
+		// Rate limits fail open rather than stop every spawn
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		if err := r.client.Expire(ctx, key, ttl).Err(); err != nil && !IsRedisOutage(err) {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// memorySpawnStore keeps spawn coordination state in process memory
+type memorySpawnStore struct {
+	mu      sync.Mutex
+	entries map[string]memorySpawnEntry
+	now     func() time.Time
+}
+
+type memorySpawnEntry struct {
+	value   string
+	expires time.Time
+}
+
+// NewMemorySpawnStore stores spawn coordination state in memory. It only
+// coordinates the activities of one worker, e.g. in tests and local runs.
+func NewMemorySpawnStore() SpawnStore {
+	return &memorySpawnStore{entries: make(map[string]memorySpawnEntry), now: time.Now}
+}
+
+// get returns the live entry at key; the caller holds mu
+func (m *memorySpawnStore) get(key string) (memorySpawnEntry, bool) {
+	entry, ok := m.entries[key]
+	if ok && !entry.expires.IsZero() && !m.now().Before(entry.expires) {
+		delete(m.entries, key)
+		return memorySpawnEntry{}, false
+	}
+	return entry, ok
+}
+
+func (m *memorySpawnStore) set(key, value string, ttl time.Duration) {
+	entry := memorySpawnEntry{value: value}
+	if ttl > 0 {
+		entry.expires = m.now().Add(ttl)
+	}
+	m.entries[key] = entry
+}
+
+func (m *memorySpawnStore) AcquireLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, held := m.get(key); held {
+		return "", false, nil
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", false, err
+	}
+	m.set(key, hex.EncodeToString(token), ttl)
+	return hex.EncodeToString(token), true, nil
+}
+
+func (m *memorySpawnStore) ReleaseLock(ctx context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.get(key); ok && entry.value == token {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *memorySpawnStore) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, _ := m.get(key)
+	return entry.value, nil
+}
+
+func (m *memorySpawnStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, value, ttl)
+	return nil
+}
+
+func (m *memorySpawnStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memorySpawnStore) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.get(key)
+	n, _ := strconv.ParseInt(entry.value, 10, 64)
+	n++
+	if ok {
+		entry.value = strconv.FormatInt(n, 10)
+		m.entries[key] = entry
+	} else {
+		m.set(key, strconv.FormatInt(n, 10), ttl)
+	}
+	return n, nil
+}
+
+// SpawnCoordinator deduplicates and rate limits the creation of dynamic
+// agents. Requests for the same project and capability fingerprint share one
+// design and spawn: a spawned agent is reused while it is still usable,
+// concurrent requests wait for the one in flight, and spawns are limited per
+// project per rate window, with requests over the limit waiting for a slot.
+type SpawnCoordinator struct {
+	store        SpawnStore
+	logger       *zap.Logger
+	rateLimit    int // Spawns per project per rateWindow; 0 disables the limit
+	rateWindow   time.Duration
+	waitTimeout  time.Duration // Longest wait for a concurrent spawn or a spawn slot
+	reuseTTL     time.Duration // How long a spawned agent is reused
+	lockTTL      time.Duration // Expiry of a spawn in flight, should its owner die
+	pollInterval time.Duration
+	now          func() time.Time
+}
+
+// NewSpawnCoordinator creates a spawn coordinator from configuration
+func NewSpawnCoordinator(store SpawnStore, cfg *config.AgentManagerConfig, logger *zap.Logger) *SpawnCoordinator {
+	return &SpawnCoordinator{
+		store:        store,
+		logger:       logger,
+		rateLimit:    cfg.SpawnRateLimit,
+		rateWindow:   time.Minute,
+		waitTimeout:  time.Duration(cfg.SpawnWaitTimeout) * time.Second,
+		reuseTTL:     time.Duration(cfg.SpawnReuseTTL) * time.Second,
+		lockTTL:      15 * time.Minute,
+		pollInterval: 500 * time.Millisecond,
+		now:          time.Now,
+	}
+}
+
+// SpawnRequest asks for an agent of the kind identified by Fingerprint
+type SpawnRequest struct {
+	ProjectID   string
+	Fingerprint string
+	// Spawn designs and spawns an agent, returning its ID once it is ready
+	Spawn func(ctx context.Context) (string, error)
+	// Usable reports whether a previously spawned agent can take new tasks
+	Usable func(ctx context.Context, agentID string) bool
+	// OnWait, when set, is called on each poll while waiting, e.g. to
+	// heartbeat
+	OnWait func()
+}
+
+// Spawn returns an agent for the request, spawning one only when no usable
+// agent was spawned for its fingerprint and no spawn is in flight. It returns
+// the agent ID and the outcome (SpawnCreated, SpawnReused or SpawnJoined). A
+// nil coordinator spawns directly.
+func (c *SpawnCoordinator) Spawn(ctx context.Context, req SpawnRequest) (string, string, error) {
+	if c == nil {
+		agentID, err := req.Spawn(ctx)
+		return agentID, SpawnCreated, err
+	}
+
+	agentKey := fmt.Sprintf("agent-spawn:%s:%s:agent", req.ProjectID, req.Fingerprint)
+	lockKey := fmt.Sprintf("agent-spawn:%s:%s:lock", req.ProjectID, req.Fingerprint)
+	deadline := c.now().Add(c.waitTimeout)
+	waited := false
+
+	for {
+		// TTL-aware reuse of an agent spawned for the same capabilities
+		agentID, err := c.store.Get(ctx, agentKey)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to look up spawned agent: %w", err)
+		}
+		if agentID != "" {
+			if req.Usable(ctx, agentID) {
+				outcome := SpawnReused
+				if waited {
+					outcome = SpawnJoined
+				}
+				agentSpawnRequestsTotal.WithLabelValues(outcome).Inc()
+				return agentID, outcome, nil
+			}
+			if err := c.store.Delete(ctx, agentKey); err != nil {
+				return "", "", fmt.Errorf("failed to forget unusable agent: %w", err)
+			}
+		}
+
+		token, ok, err := c.store.AcquireLock(ctx, lockKey, c.lockTTL)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to acquire spawn lock: %w", err)
+		}
+		if ok {
+			return c.spawnLocked(ctx, req, agentKey, lockKey, token, deadline)
+		}
+
+		// Another request is spawning this kind of agent
+		waited = true
+		if !c.now().Before(deadline) {
+			return "", "", fmt.Errorf("%w: a concurrent spawn is still in flight", ErrSpawnWaitTimeout)
+		}
+		if err := c.wait(ctx, deadline, req.OnWait); err != nil {
+			return "", "", err
+		}
+	}
+}
+
+// spawnLocked spawns an agent while holding the fingerprint's lock and
+// publishes it for reuse
+func (c *SpawnCoordinator) spawnLocked(ctx context.Context, req SpawnRequest, agentKey, lockKey, token string, deadline time.Time) (string, string, error) {
+	defer func() {
+		// The lock expires on its own should the release fail
+		if err := c.store.ReleaseLock(context.WithoutCancel(ctx), lockKey, token); err != nil {
+			c.logger.Warn("failed to release spawn lock", zap.String("key", lockKey), zap.Error(err))
+		}
+	}()
+
+	// A request that held the lock before may have spawned the agent since
+	// this one looked
+	agentID, err := c.store.Get(ctx, agentKey)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to look up spawned agent: %w", err)
+	}
+	if agentID != "" {
+		agentSpawnRequestsTotal.WithLabelValues(SpawnJoined).Inc()
+		return agentID, SpawnJoined, nil
+	}
+
+	if err := c.acquireSpawnSlot(ctx, req.ProjectID, deadline, req.OnWait); err != nil {
+		return "", "", err
+	}
+
+	agentID, err = req.Spawn(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	agentSpawnRequestsTotal.WithLabelValues(SpawnCreated).Inc()
+	if c.reuseTTL > 0 {
+		if err := c.store.Set(ctx, agentKey, agentID, c.reuseTTL); err != nil {
+			c.logger.Warn("failed to record spawned agent for reuse", zap.String("agent_id", agentID), zap.Error(err))
+		}
+	}
+	return agentID, SpawnCreated, nil
+}
+
+// acquireSpawnSlot counts a spawn against the project's rate limit, waiting
+// for the next rate window while the current one is used up
+func (c *SpawnCoordinator) acquireSpawnSlot(ctx context.Context, projectID string, deadline time.Time, onWait func()) error {
+	if c.rateLimit <= 0 {
+		return nil
+	}
+	limited := false
+	for {
+		window := c.now().Truncate(c.rateWindow)
+		key := fmt.Sprintf("agent-spawn:%s:rate:%d", projectID, window.Unix())
+		n, err := c.store.Increment(ctx, key, c.rateWindow)
+		if err != nil {
+			return fmt.Errorf("failed to count agent spawns: %w", err)
+		}
+		if n <= int64(c.rateLimit) {
+			return nil
+		}
+		if !limited {
+			limited = true
+			agentSpawnRateLimitedTotal.Inc()
+			c.logger.Info("agent spawn rate limit reached, waiting for the next window",
+				zap.String("project_id", projectID),
+				zap.Int("limit", c.rateLimit))
+		}
+		next := window.Add(c.rateWindow)
+		if next.After(deadline) {
+			return fmt.Errorf("%w: rate limit of %d spawns per %s reached in project %s", ErrSpawnWaitTimeout, c.rateLimit, c.rateWindow, projectID)
+		}
+		for c.now().Before(next) {
+			if err := c.wait(ctx, next, onWait); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// wait sleeps one poll interval, or until until when that is sooner
+func (c *SpawnCoordinator) wait(ctx context.Context, until time.Time, onWait func()) error {
+	if onWait != nil {
+		onWait()
+	}
+	timer := time.NewTimer(max(min(until.Sub(c.now()), c.pollInterval), 0))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+func newTestSpawnCoordinator(rateLimit int, waitTimeout time.Duration) *SpawnCoordinator {
+	coordinator := NewSpawnCoordinator(NewMemorySpawnStore(), &config.AgentManagerConfig{
+		SpawnRateLimit: rateLimit,
+		SpawnReuseTTL:  3600,
+	}, zap.NewNop())
+	coordinator.waitTimeout = waitTimeout
+	coordinator.pollInterval = 5 * time.Millisecond
+	return coordinator
+}
+
+// countingSpawn spawns agents named agent-1, agent-2, ... after a delay
+func countingSpawn(spawns *atomic.Int32, delay time.Duration) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		n := spawns.Add(1)
+		time.Sleep(delay)
+		return fmt.Sprintf("agent-%d", n), nil
+	}
+}
+
+func alwaysUsable(ctx context.Context, agentID string) bool { return true }
+
+func TestCapabilityFingerprint(t *testing.T) {
+	assert.Equal(t, CapabilityFingerprint([]string{"api", "Backend"}), CapabilityFingerprint([]string{"backend", " api", "api"}))
+	assert.NotEqual(t, CapabilityFingerprint([]string{"api"}), CapabilityFingerprint([]string{"api", "database"}))
+}
+
+func TestSpawnCoordinator_ConcurrentRequestsShareOneSpawn(t *testing.T) {
+	coordinator := newTestSpawnCoordinator(0, 5*time.Second)
+	var spawns atomic.Int32
+	req := SpawnRequest{
+		ProjectID:   "project-1",
+		Fingerprint: CapabilityFingerprint([]string{"backend"}),
+		Spawn:       countingSpawn(&spawns, 50*time.Millisecond),
+		Usable:      alwaysUsable,
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	ids := make([]string, callers)
+	outcomes := make([]string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			ids[i], outcomes[i], err = coordinator.Spawn(context.Background(), req)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, spawns.Load())
+	created := 0
+	for i := range ids {
+		assert.Equal(t, "agent-1", ids[i])
+		if outcomes[i] == SpawnCreated {
+			created++
+		} else {
+			assert.Equal(t, SpawnJoined, outcomes[i])
+		}
+	}
+	assert.Equal(t, 1, created)
+
+	// Later requests reuse the agent; other capabilities get their own
+	id, outcome, err := coordinator.Spawn(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", id)
+	assert.Equal(t, SpawnReused, outcome)
+
+	other := req
+	other.Fingerprint = CapabilityFingerprint([]string{"frontend"})
+	id, outcome, err = coordinator.Spawn(context.Background(), other)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-2", id)
+	assert.Equal(t, SpawnCreated, outcome)
+}
+
+func TestSpawnCoordinator_RespawnsUnusableAgents(t *testing.T) {
+	coordinator := newTestSpawnCoordinator(0, time.Second)
+	var spawns atomic.Int32
+	req := SpawnRequest{ProjectID: "project-1", Fingerprint: "fp", Spawn: countingSpawn(&spawns, 0), Usable: alwaysUsable}
+
+	_, _, err := coordinator.Spawn(context.Background(), req)
+	require.NoError(t, err)
+
+	req.Usable = func(ctx context.Context, agentID string) bool { return agentID != "agent-1" }
+	id, outcome, err := coordinator.Spawn(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-2", id)
+	assert.Equal(t, SpawnCreated, outcome)
+}
+
+func TestSpawnCoordinator_FailedSpawnReleasesTheLock(t *testing.T) {
+	coordinator := newTestSpawnCoordinator(0, time.Second)
+	var spawns atomic.Int32
+	req := SpawnRequest{
+		ProjectID:   "project-1",
+		Fingerprint: "fp",
+		Spawn:       func(ctx context.Context) (string, error) { return "", errors.New("meta-agent unavailable") },
+		Usable:      alwaysUsable,
+	}
+
+	_, _, err := coordinator.Spawn(context.Background(), req)
+	assert.EqualError(t, err, "meta-agent unavailable")
+
+	req.Spawn = countingSpawn(&spawns, 0)
+	id, _, err := coordinator.Spawn(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", id)
+}
+
+func TestSpawnCoordinator_RateLimitsSpawnsPerProject(t *testing.T) {
+	var spawns atomic.Int32
+	spawn := func(coordinator *SpawnCoordinator, projectID, fingerprint string) (string, error) {
+		id, _, err := coordinator.Spawn(context.Background(), SpawnRequest{
+			ProjectID:   projectID,
+			Fingerprint: fingerprint,
+			Spawn:       countingSpawn(&spawns, 0),
+			Usable:      alwaysUsable,
+		})
+		return id, err
+	}
+
+	t.Run("requests over the limit fail once the wait would time out", func(t *testing.T) {
+		coordinator := newTestSpawnCoordinator(1, 0)
+		coordinator.rateWindow = time.Hour
+
+		_, err := spawn(coordinator, "project-1", "backend")
+		require.NoError(t, err)
+		_, err = spawn(coordinator, "project-1", "frontend")
+		assert.ErrorIs(t, err, ErrSpawnWaitTimeout)
+
+		// The limit applies per project
+		_, err = spawn(coordinator, "project-2", "frontend")
+		assert.NoError(t, err)
+	})
+
+	t.Run("requests over the limit wait for the next window", func(t *testing.T) {
+		coordinator := newTestSpawnCoordinator(1, 5*time.Second)
+		coordinator.rateWindow = 100 * time.Millisecond
+		waits := 0
+		started := time.Now()
+
+		_, err := spawn(coordinator, "project-1", "backend")
+		require.NoError(t, err)
+		_, _, err = coordinator.Spawn(context.Background(), SpawnRequest{
+			ProjectID:   "project-1",
+			Fingerprint: "frontend",
+			Spawn:       countingSpawn(&spawns, 0),
+			Usable:      alwaysUsable,
+			OnWait:      func() { waits++ },
+		})
+		require.NoError(t, err)
+		assert.Positive(t, waits)
+		assert.Less(t, time.Since(started), 5*time.Second)
+	})
+}
+
+func TestSpawnCoordinator_NilSpawnsDirectly(t *testing.T) {
+	var coordinator *SpawnCoordinator
+	var spawns atomic.Int32
+	req := SpawnRequest{ProjectID: "project-1", Fingerprint: "fp", Spawn: countingSpawn(&spawns, 0), Usable: alwaysUsable}
+
+	for i := 0; i < 2; i++ {
+		_, outcome, err := coordinator.Spawn(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, SpawnCreated, outcome)
+	}
+	assert.EqualValues(t, 2, spawns.Load())
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+// Optional Agent Manager API features, advertised by GET /api/v1/version.
+// The client only uses a feature the agent manager advertises and falls
+// back to the core API otherwise.
+const (
+	// FeatureBatchExecute runs a task batch in one request to
+	// /api/v1/tasks/batch-execute instead of one request per task
+	FeatureBatchExecute = "batch-execute"
+	// FeatureConversations keeps multi-turn conversations on the agent
+	// manager instead of emulating them with one task per turn
+	FeatureConversations = "conversations"
+	// FeatureCapabilityObjects sends agent capabilities as objects instead
+	// of names
+	FeatureCapabilityObjects = "capability-objects"
+)
+
+// SupportedAgentManagerMajor is the major version of the Agent Manager API
+// this orchestrator speaks
+const SupportedAgentManagerMajor = 1
+
+// ErrAgentManagerIncompatible is returned for workflows that need agents
+// while the agent manager's API version is incompatible
+var ErrAgentManagerIncompatible = errors.New("agent manager API version is incompatible")
+
+var agentManagerCompatible = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "orchestrator_agent_manager_compatible",
+	Help: "Whether the Agent Manager's API version is compatible (1) or not (0)",
+})
+
+// AgentManagerVersion is the answer of GET /api/v1/version
+type AgentManagerVersion struct {
+	Version  string   `json:"version"`
+	Features []string `json:"features"`
+}
+
+// AgentManagerCompatibility is the outcome of the last version negotiation.
+// Until the agent manager answers, it is assumed compatible without
+// optional features.
+type AgentManagerCompatibility struct {
+	Version    string     `json:"version,omitempty"`
+	Features   []string   `json:"features"`
+	Compatible bool       `json:"compatible"`
+	Negotiated bool       `json:"negotiated"`
+	Reason     string     `json:"reason,omitempty"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+}
+
+// AgentCompatibilityChecker reports whether the agent manager's API is
+// compatible
+type AgentCompatibilityChecker interface {
+	Compatibility() AgentManagerCompatibility
+}
+
+var _ AgentCompatibilityChecker = (*AgentClient)(nil)
+
+// agentVersionState holds the negotiated compatibility of an AgentClient
+type agentVersionState struct {
+	mu     sync.RWMutex
+	result AgentManagerCompatibility
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// NegotiateVersion fetches the agent manager's API version and features and
+// stores the outcome. Agent managers predating the version endpoint are
+// treated as compatible without optional features. An unreachable agent
+// manager keeps the previous outcome.
+func (c *AgentClient) NegotiateVersion(ctx context.Context) (AgentManagerCompatibility, error) {
+	url := fmt.Sprintf("%s/api/v1/version", c.config.BaseURL)
+	var version AgentManagerVersion
+	status, err := c.send(ctx, http.MethodGet, url, nil, &version)
+	if err != nil && status != http.StatusNotFound {
+		return c.Compatibility(), fmt.Errorf("failed to fetch agent manager version: %w", err)
+	}
+
+	now := time.Now()
+	result := AgentManagerCompatibility{Features: []string{}, Compatible: true, Negotiated: true, CheckedAt: &now}
+	if status == http.StatusNotFound {
+		result.Reason = "agent manager does not report its API version; optional features are disabled"
+	} else {
+		result.Version = version.Version
+		if version.Features != nil {
+			result.Features = version.Features
+		}
+		major, err := majorVersion(version.Version)
+		switch {
+		case err != nil:
+			result.Compatible = false
+			result.Reason = err.Error()
+		case major != SupportedAgentManagerMajor:
+			result.Compatible = false
+			result.Reason = fmt.Sprintf("agent manager API %s is incompatible with supported major version %d",
+				version.Version, SupportedAgentManagerMajor)
+		}
+	}
+
+	c.version.mu.Lock()
+	previous := c.version.result
+	c.version.result = result
+	c.version.mu.Unlock()
+
+	if result.Compatible {
+		agentManagerCompatible.Set(1)
+	} else {
+		agentManagerCompatible.Set(0)
+		c.logger.Error("Agent manager API version is incompatible",
+			zap.String("version", result.Version),
+			zap.String("reason", result.Reason))
+	}
+	if result.Compatible && (!previous.Negotiated || previous.Version != result.Version || !slices.Equal(previous.Features, result.Features)) {
+		c.logger.Info("Negotiated agent manager API version",
+			zap.String("version", result.Version),
+			zap.Strings("features", result.Features))
+	}
+	return result, nil
+}
+
+// Compatibility returns the outcome of the last version negotiation
+func (c *AgentClient) Compatibility() AgentManagerCompatibility {
+	c.version.mu.RLock()
+	defer c.version.mu.RUnlock()
+	result := c.version.result
+	if !result.Negotiated {
+		result.Compatible = true
+		result.Features = []string{}
+	}
+	return result
+}
+
+// Supports reports whether the agent manager advertised a feature
+func (c *AgentClient) Supports(feature string) bool {
+	c.version.mu.RLock()
+	defer c.version.mu.RUnlock()
+	return slices.Contains(c.version.result.Features, feature)
+}
+
+// StartVersionChecks negotiates the API version again every interval until
+// the client is closed, so that upgrades of the agent manager are picked up
+func (c *AgentClient) StartVersionChecks(interval time.Duration) {
+	c.version.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.version.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), c.httpClient.Timeout)
+				if _, err := c.NegotiateVersion(ctx); err != nil {
+					c.logger.Warn("Failed to check agent manager version", zap.Error(err))
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// stopVersionChecks stops the checks started by StartVersionChecks
+func (c *AgentClient) stopVersionChecks() {
+	c.version.once.Do(func() {
+		if c.version.stop != nil {
+			close(c.version.stop)
+		}
+	})
+}
+
+// majorVersion parses the major version of a semantic version such as
+// "1.4.0" or "v2.0.0-rc.1"
+func majorVersion(version string) (int, error) {
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	n, err := strconv.Atoi(major)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("agent manager reported an invalid API version %q", version)
+	}
+	return n, nil
+}
+
+// SetAgentCompatibility makes the engine refuse new workflows that run on
+// agents while the agent manager's API version is incompatible. Simulated
+// workflows use stub agents and are still started.
+func (e *WorkflowEngine) SetAgentCompatibility(checker AgentCompatibilityChecker) {
+	e.compatibility = checker
+}
+
+// checkAgentCompatibility returns ErrAgentManagerIncompatible for workflows
+// that cannot run against the agent manager
+func (e *WorkflowEngine) checkAgentCompatibility(workflowType string, simulated bool) error {
+	if e.compatibility == nil || simulated {
+		return nil
+	}
+	if spec, _ := e.workflowTypes.Lookup(models.WorkflowType(workflowType)); !spec.AgentDependent {
+		return nil
+	}
+	if compatibility := e.compatibility.Compatibility(); !compatibility.Compatible {
+		return fmt.Errorf("%w: %s", ErrAgentManagerIncompatible, compatibility.Reason)
+	}
+	return nil
+}
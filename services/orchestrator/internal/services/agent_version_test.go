@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// versionedAgentManager is a fake Agent Manager reporting an API version.
+// Without a version it predates the version endpoint and answers 404.
+type versionedAgentManager struct {
+	version  string
+	features []string
+
+	mu       sync.Mutex
+	requests []string                   // Method and path of each request
+	bodies   map[string]json.RawMessage // Last request body by path
+}
+
+func newVersionedAgentManager(t *testing.T, version string, features ...string) (*versionedAgentManager, *AgentClient) {
+	manager := &versionedAgentManager{version: version, features: features, bodies: map[string]json.RawMessage{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		manager.mu.Lock()
+		manager.requests = append(manager.requests, r.Method+" "+r.URL.Path)
+		manager.bodies[r.URL.Path] = body
+		manager.mu.Unlock()
+
+		objects := manager.supports(FeatureCapabilityObjects)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/api/v1/version":
+			if manager.version == "" {
+				w.WriteHeader(http.StatusNotFound)
+				assert.NoError(t, json.NewEncoder(w).Encode(ErrorResponse{Code: "NOT_FOUND", Message: "Route not found"}))
+				return
+			}
+			assert.NoError(t, json.NewEncoder(w).Encode(AgentManagerVersion{Version: manager.version, Features: manager.features}))
+		case r.URL.Path == "/api/v1/agents":
+			// Agent managers without capability objects list capability names
+			capabilities := interface{}([]string{"golang"})
+			if objects {
+				capabilities = []Capability{{Name: "golang", Version: "1.0.0"}}
+			}
+			assert.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"id": "agent-1", "capabilities": capabilities}))
+		case r.URL.Path == "/api/v1/tasks/batch-execute":
+			assert.NoError(t, json.NewEncoder(w).Encode(BatchResult{
+				Results:   []AgentTaskResult{{AgentID: "agent-1"}, {AgentID: "agent-2"}},
+				Succeeded: 2,
+			}))
+		case strings.HasSuffix(r.URL.Path, "/conversations"):
+			assert.NoError(t, json.NewEncoder(w).Encode(Conversation{ID: "remote-1", AgentID: "agent-1", Status: "open"}))
+		case strings.HasSuffix(r.URL.Path, "/execute"):
+			var req ExecuteTaskRequest
+			assert.NoError(t, json.Unmarshal(body, &req))
+			assert.NoError(t, json.NewEncoder(w).Encode(TaskExecution{ID: "task-1", Status: "completed", Output: req.Input}))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+	require.NoError(t, err)
+	return manager, client
+}
+
+func (m *versionedAgentManager) supports(feature string) bool {
+	for _, f := range m.features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// count returns the number of requests made with a method and path suffix
+func (m *versionedAgentManager) count(method, suffix string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, request := range m.requests {
+		if strings.HasPrefix(request, method+" ") && strings.HasSuffix(request, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *versionedAgentManager) body(path string) json.RawMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bodies[path]
+}
+
+var testBatch = []AgentTaskRequest{
+	{AgentID: "agent-1", Task: ExecuteTaskRequest{Type: "generate_code"}},
+	{AgentID: "agent-2", Task: ExecuteTaskRequest{Type: "generate_code"}},
+}
+
+func TestAgentClient_CompatibleVersionEnablesFeatures(t *testing.T) {
+	ctx := context.Background()
+	manager, client := newVersionedAgentManager(t, "1.3.0",
+		FeatureBatchExecute, FeatureConversations, FeatureCapabilityObjects)
+
+	compatibility, err := client.NegotiateVersion(ctx)
+	require.NoError(t, err)
+	assert.True(t, compatibility.Compatible)
+	assert.True(t, compatibility.Negotiated)
+	assert.Equal(t, "1.3.0", compatibility.Version)
+	assert.Equal(t, compatibility, client.Compatibility())
+
+	// Batches run in one request
+	result, err := client.ExecuteTaskBatch(ctx, testBatch, BatchOptions{MaxParallel: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Succeeded)
+	assert.Equal(t, 1, manager.count(http.MethodPost, "/tasks/batch-execute"))
+	assert.Zero(t, manager.count(http.MethodPost, "/execute"))
+	assert.JSONEq(t, `2`, string(mustField(t, manager.body("/api/v1/tasks/batch-execute"), "max_parallel")))
+
+	// Capabilities are sent as objects
+	agent, err := client.CreateAgent(ctx, &CreateAgentRequest{Name: "coder", Type: "code-gen", Capabilities: []string{"golang"}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"name": "golang", "description": "", "version": "1.0.0"}]`,
+		string(mustField(t, manager.body("/api/v1/agents"), "capabilities")))
+	assert.Equal(t, []Capability{{Name: "golang", Version: "1.0.0"}}, agent.Capabilities)
+
+	// Conversations are held by the agent manager
+	conversation, err := client.CreateConversation(ctx, "agent-1", &CreateConversationRequest{Type: "generate_code"})
+	require.NoError(t, err)
+	assert.Equal(t, "remote-1", conversation.ID)
+	assert.Equal(t, 1, manager.count(http.MethodPost, "/agents/agent-1/conversations"))
+}
+
+func TestAgentClient_MissingFeaturesFallBack(t *testing.T) {
+	tests := map[string]struct {
+		version  string
+		features []string
+	}{
+		"no features":      {version: "1.0.0"},
+		"other features":   {version: "1.1.0", features: []string{"streaming"}},
+		"no version route": {},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager, client := newVersionedAgentManager(t, tt.version, tt.features...)
+
+			compatibility, err := client.NegotiateVersion(ctx)
+			require.NoError(t, err)
+			assert.True(t, compatibility.Compatible)
+			assert.False(t, client.Supports(FeatureBatchExecute))
+
+			// Batches are dispatched by the client
+			result, err := client.ExecuteTaskBatch(ctx, testBatch, BatchOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, 2, result.Succeeded)
+			assert.Zero(t, manager.count(http.MethodPost, "/tasks/batch-execute"))
+			assert.Equal(t, 2, manager.count(http.MethodPost, "/execute"))
+
+			// Capabilities are sent and read as names
+			agent, err := client.CreateAgent(ctx, &CreateAgentRequest{Name: "coder", Type: "code-gen", Capabilities: []string{"golang"}})
+			require.NoError(t, err)
+			assert.JSONEq(t, `["golang"]`, string(mustField(t, manager.body("/api/v1/agents"), "capabilities")))
+			assert.Equal(t, []Capability{{Name: "golang"}}, agent.Capabilities)
+
+			// Conversations are emulated with one task per turn
+			conversation, err := client.CreateConversation(ctx, "agent-1", &CreateConversationRequest{
+				Type:    "generate_code",
+				Context: map[string]interface{}{"title": "Orders API"},
+			})
+			require.NoError(t, err)
+			turn, err := client.SendTurn(ctx, "agent-1", conversation.ID, &SendTurnRequest{Input: map[string]interface{}{"feedback": "add tests"}})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"title": "Orders API", "feedback": "add tests"}, turn.Output)
+			turns, err := client.GetTurns(ctx, "agent-1", conversation.ID)
+			require.NoError(t, err)
+			assert.Len(t, turns, 1)
+			require.NoError(t, client.CloseConversation(ctx, "agent-1", conversation.ID))
+			assert.Zero(t, manager.count(http.MethodPost, "/conversations"))
+			assert.Equal(t, 3, manager.count(http.MethodPost, "/execute"))
+		})
+	}
+}
+
+func TestAgentClient_IncompatibleMajorVersion(t *testing.T) {
+	ctx := context.Background()
+	_, client := newVersionedAgentManager(t, "2.0.0", FeatureBatchExecute)
+
+	// Until the agent manager answers, it is assumed compatible
+	assert.True(t, client.Compatibility().Compatible)
+	assert.False(t, client.Compatibility().Negotiated)
+
+	compatibility, err := client.NegotiateVersion(ctx)
+	require.NoError(t, err)
+	assert.False(t, compatibility.Compatible)
+	assert.Equal(t, "agent manager API 2.0.0 is incompatible with supported major version 1", compatibility.Reason)
+
+	// Strict engines refuse workflows that need agents
+	engine := &WorkflowEngine{}
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+	engine.SetAgentCompatibility(client)
+	_, err = engine.StartWorkflow(ctx, &StartWorkflowRequest{Name: "intent", Type: "intent_processing", ProjectID: "project-1"})
+	assert.ErrorIs(t, err, ErrAgentManagerIncompatible)
+	assert.ErrorIs(t, engine.checkAgentCompatibility("task_execution", false), ErrAgentManagerIncompatible)
+	assert.NoError(t, engine.checkAgentCompatibility("task_execution", true), "simulated workflows use stub agents")
+	assert.NoError(t, engine.checkAgentCompatibility("code_analysis", false))
+
+	// Without the checker, as with the warn policy, workflows are started
+	assert.NoError(t, (&WorkflowEngine{}).checkAgentCompatibility("intent_processing", false))
+}
+
+func TestMajorVersion(t *testing.T) {
+	for version, want := range map[string]int{"1.0.0": 1, "v2.1.0": 2, "3": 3, "1.0.0-rc.1": 1} {
+		major, err := majorVersion(version)
+		require.NoError(t, err, version)
+		assert.Equal(t, want, major, version)
+	}
+	for _, version := range []string{"", "latest", "-1.0.0"} {
+		_, err := majorVersion(version)
+		assert.Error(t, err, version)
+	}
+}
+
+// mustField returns a field of a JSON object
+func mustField(t *testing.T, data json.RawMessage, field string) json.RawMessage {
+	var object map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(data, &object))
+	return object[field]
+}
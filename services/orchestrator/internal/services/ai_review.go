@@ -0,0 +1,653 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+var (
+	// ErrAIReviewAuth is returned when no API key is configured for a
+	// project, or the API rejects it
+	ErrAIReviewAuth = errors.New("AI review authentication failed")
+	// ErrAIReviewFailed is returned when no chunk of a diff could be
+	// reviewed
+	ErrAIReviewFailed = errors.New("AI review failed")
+	// errMalformedReview is the error of model replies that hold no valid
+	// findings
+	errMalformedReview = errors.New("malformed review")
+)
+
+// Severities of AI review findings, most severe first
+const (
+	AIReviewSeverityCritical = "critical"
+	AIReviewSeverityHigh     = "high"
+	AIReviewSeverityMedium   = "medium"
+	AIReviewSeverityLow      = "low"
+	AIReviewSeverityInfo     = "info"
+)
+
+// aiReviewSeverityWeights is the weight of each severity in the finding
+// density code quality is computed from
+var aiReviewSeverityWeights = map[string]float64{
+	AIReviewSeverityCritical: 10,
+	AIReviewSeverityHigh:     5,
+	AIReviewSeverityMedium:   2,
+	AIReviewSeverityLow:      1,
+	AIReviewSeverityInfo:     0,
+}
+
+// aiReviewQualityScale is the weighted findings per 100 changed lines at
+// which code quality is 0.5
+const aiReviewQualityScale = 20
+
+// aiReviewBaseBackoff is the first wait for a rate limit without
+// Retry-After; it doubles with each retry
+const aiReviewBaseBackoff = time.Second
+
+// aiReviewSystemPrompt constrains the model to the JSON findings parsed from
+// its reply
+const aiReviewSystemPrompt = `You are a senior engineer reviewing a pull request diff.
+Report only real problems: bugs, security issues, performance problems, error handling and maintainability issues worth fixing.
+Reply with a single JSON object and nothing else, of the form:
+{"findings": [{"file": "path/of/file", "start_line": 10, "end_line": 12, "severity": "critical|high|medium|low|info", "category": "bug|security|performance|error_handling|maintainability|style|testing", "message": "what is wrong", "suggestion": "how to fix it"}]}
+Lines are line numbers of the new version of the file. Reply {"findings": []} when there is nothing to report.`
+
+var (
+	aiReviewTokensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_ai_review_tokens_total",
+		Help: "Tokens used by AI reviews, by type (prompt, completion)",
+	}, []string{"type"})
+	aiReviewChunksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_ai_review_chunks_total",
+		Help: "Diff chunks sent for AI review, by result (reviewed, failed)",
+	}, []string{"result"})
+)
+
+// AIReviewRequest is a diff to review
+type AIReviewRequest struct {
+	ProjectID string
+	Title     string
+	Diff      string            // Unified diff, split into files when Files is empty
+	Files     []PullRequestFile // Diffs of the files, reviewed instead of Diff
+	Checks    string            // Summary of the automated checks, given as context
+	// Progress, when set, is called as chunks complete
+	Progress func(done, total int)
+}
+
+// AIReviewFinding is a problem found in a range of lines of the new
+// version of a file
+type AIReviewFinding struct {
+	File       string `json:"file"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	Severity   string `json:"severity"`
+	Category   string `json:"category"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// AIReview is the findings of a review and what it cost. A partial review
+// left out the chunks in FailedChunks; its quality covers the rest.
+type AIReview struct {
+	Findings         []AIReviewFinding `json:"findings"`
+	CodeQuality      float64           `json:"code_quality"`
+	Model            string            `json:"model"`
+	Chunks           int               `json:"chunks"`
+	FailedChunks     int               `json:"failed_chunks"`
+	Partial          bool              `json:"partial"`
+	Errors           []string          `json:"errors,omitempty"`
+	PromptTokens     int               `json:"prompt_tokens"`
+	CompletionTokens int               `json:"completion_tokens"`
+}
+
+// AIReviewer reviews the diffs of pull requests
+type AIReviewer interface {
+	Review(ctx context.Context, req *AIReviewRequest) (*AIReview, error)
+}
+
+// LLMReviewer reviews diffs with a model behind an OpenAI-compatible chat
+// completions API. Diffs are split into chunks of whole files, or of hunks
+// of files too large for one, that are reviewed concurrently; the findings
+// of the chunks reviewed are returned when others fail.
+type LLMReviewer struct {
+	cfg     *config.AIReviewConfig
+	secrets SecretProvider
+	usage   *UsageMeter
+	baseURL string
+	client  *http.Client
+	logger  *zap.Logger
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+var _ AIReviewer = (*LLMReviewer)(nil)
+
+// NewLLMReviewer creates a reviewer using the API key in the project secret
+// cfg.APIKeySecret, or cfg.APIKey for projects without one. secrets may be
+// nil.
+func NewLLMReviewer(cfg *config.AIReviewConfig, secrets SecretProvider, logger *zap.Logger) *LLMReviewer {
+	return &LLMReviewer{
+		cfg:     cfg,
+		secrets: secrets,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		logger:  logger,
+		sleep:   sleepContext,
+	}
+}
+
+// SetUsageMeter makes the reviewer count the tokens it uses
+func (r *LLMReviewer) SetUsageMeter(usage *UsageMeter) {
+	r.usage = usage
+}
+
+// reviewChunk is a part of a diff reviewed in one request
+type reviewChunk struct {
+	files []string
+	text  string
+	lines int // Added and removed lines
+}
+
+// chunkResult is the outcome of the review of a chunk
+type chunkResult struct {
+	findings         []AIReviewFinding
+	promptTokens     int
+	completionTokens int
+	err              error
+}
+
+// Review reviews the diff of a request. It fails when no chunk could be
+// reviewed; when only some could, the review is partial.
+func (r *LLMReviewer) Review(ctx context.Context, req *AIReviewRequest) (*AIReview, error) {
+	apiKey, err := r.apiKey(ctx, req.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	files := req.Files
+	if len(files) == 0 && req.Diff != "" {
+		if files, err = ParseUnifiedDiff(req.Diff); err != nil {
+			return nil, err
+		}
+	}
+	chunks := chunkDiff(files, r.cfg.MaxChunkBytes)
+	review := &AIReview{Findings: []AIReviewFinding{}, Model: r.cfg.Model, Chunks: len(chunks), CodeQuality: 1}
+	if len(chunks) == 0 {
+		return review, nil
+	}
+
+	results := make([]chunkResult, len(chunks))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	slots := make(chan struct{}, r.cfg.Concurrency)
+	for i := range chunks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				results[i] = r.reviewChunk(ctx, apiKey, req, chunks[i])
+			case <-ctx.Done():
+				results[i] = chunkResult{err: ctx.Err()}
+			}
+			if req.Progress != nil {
+				mu.Lock()
+				done++
+				req.Progress(done, len(chunks))
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	reviewedLines := 0
+	seen := make(map[string]bool)
+	var authErr error
+	for i, result := range results {
+		review.PromptTokens += result.promptTokens
+		review.CompletionTokens += result.completionTokens
+		if result.err != nil {
+			aiReviewChunksTotal.WithLabelValues("failed").Inc()
+			review.FailedChunks++
+			review.Errors = append(review.Errors, fmt.Sprintf("%s: %v", strings.Join(chunks[i].files, ", "), result.err))
+			if errors.Is(result.err, ErrAIReviewAuth) {
+				authErr = result.err
+			}
+			r.logger.Warn("Failed to review diff chunk",
+				zap.Strings("files", chunks[i].files),
+				zap.Error(result.err))
+			continue
+		}
+		aiReviewChunksTotal.WithLabelValues("reviewed").Inc()
+		reviewedLines += chunks[i].lines
+		for _, finding := range result.findings {
+			key := fmt.Sprintf("%s:%d:%s:%s", finding.File, finding.StartLine, finding.Category, finding.Message)
+			if !seen[key] {
+				seen[key] = true
+				review.Findings = append(review.Findings, finding)
+			}
+		}
+	}
+
+	aiReviewTokensTotal.WithLabelValues("prompt").Add(float64(review.PromptTokens))
+	aiReviewTokensTotal.WithLabelValues("completion").Add(float64(review.CompletionTokens))
+	r.usage.Record(ctx, req.ProjectID, UsageMetricLLMPromptTokens, int64(review.PromptTokens))
+	r.usage.Record(ctx, req.ProjectID, UsageMetricLLMCompletionTokens, int64(review.CompletionTokens))
+
+	if review.FailedChunks == len(chunks) {
+		if authErr != nil {
+			return nil, authErr
+		}
+		return nil, fmt.Errorf("%w: %s", ErrAIReviewFailed, review.Errors[0])
+	}
+	review.Partial = review.FailedChunks > 0
+	sort.SliceStable(review.Findings, func(i, j int) bool {
+		a, b := review.Findings[i], review.Findings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.StartLine < b.StartLine
+	})
+	review.CodeQuality = aiReviewQuality(review.Findings, reviewedLines)
+	return review, nil
+}
+
+// apiKey returns the key of a project, from its secret when it has one
+func (r *LLMReviewer) apiKey(ctx context.Context, projectID string) (string, error) {
+	if r.secrets != nil && projectID != "" && r.cfg.APIKeySecret != "" {
+		key, err := r.secrets.GetSecret(ctx, projectID, r.cfg.APIKeySecret)
+		switch {
+		case err == nil && key != "":
+			return key, nil
+		case err != nil && !errors.Is(err, ErrSecretNotFound):
+			return "", fmt.Errorf("failed to read AI review API key: %w", err)
+		}
+	}
+	if r.cfg.APIKey == "" {
+		return "", fmt.Errorf("%w: no API key configured", ErrAIReviewAuth)
+	}
+	return r.cfg.APIKey, nil
+}
+
+// reviewChunk reviews a chunk, repeating the request when the reply holds
+// no valid findings
+func (r *LLMReviewer) reviewChunk(ctx context.Context, apiKey string, req *AIReviewRequest, chunk reviewChunk) chunkResult {
+	var result chunkResult
+	for attempt := 0; ; attempt++ {
+		content, prompt, completion, err := r.complete(ctx, apiKey, reviewPrompt(req, chunk))
+		result.promptTokens += prompt
+		result.completionTokens += completion
+		if err != nil {
+			result.err = err
+			return result
+		}
+		findings, err := parseReviewFindings(content, chunk.files)
+		if err == nil {
+			result.findings = findings
+			return result
+		}
+		if attempt >= r.cfg.MaxParseFailures {
+			result.err = err
+			return result
+		}
+		r.logger.Debug("Model reply holds no valid findings, asking again",
+			zap.Strings("files", chunk.files),
+			zap.Error(err))
+	}
+}
+
+// reviewPrompt is the user message reviewing a chunk
+func reviewPrompt(req *AIReviewRequest, chunk reviewChunk) string {
+	var prompt strings.Builder
+	if req.Title != "" {
+		fmt.Fprintf(&prompt, "Pull request: %s\n", req.Title)
+	}
+	if req.Checks != "" {
+		fmt.Fprintf(&prompt, "Automated checks: %s\n", req.Checks)
+	}
+	prompt.WriteString("\nDiff:\n")
+	prompt.WriteString(chunk.text)
+	return prompt.String()
+}
+
+// chatCompletionRequest is the part of a chat completions request sent
+type chatCompletionRequest struct {
+	Model          string        `json:"model"`
+	Messages       []chatMessage `json:"messages"`
+	Temperature    float64       `json:"temperature"`
+	MaxTokens      int           `json:"max_tokens"`
+	ResponseFormat struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatCompletionResponse is the part of a chat completions response read
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// complete sends a chat completion request and returns the reply and the
+// tokens used. Rate limited requests and server errors are retried after
+// Retry-After, or with exponential backoff.
+func (r *LLMReviewer) complete(ctx context.Context, apiKey, prompt string) (string, int, int, error) {
+	body := chatCompletionRequest{
+		Model: r.cfg.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: aiReviewSystemPrompt},
+			{Role: "user", Content: prompt},
+		},
+		Temperature: r.cfg.Temperature,
+		MaxTokens:   r.cfg.MaxTokens,
+	}
+	body.ResponseFormat.Type = "json_object"
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.baseURL+"/chat/completions", bytes.NewReader(data))
+		if err != nil {
+			return "", 0, 0, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("AI review request failed: %w", err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("failed to read AI review response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			wait := aiReviewBaseBackoff << attempt
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			if attempt >= r.cfg.MaxRetries || wait > time.Duration(r.cfg.MaxBackoff)*time.Second {
+				return "", 0, 0, fmt.Errorf("AI review API returned %d after %d attempts", resp.StatusCode, attempt+1)
+			}
+			r.logger.Warn("AI review request failed, backing off",
+				zap.Int("status", resp.StatusCode),
+				zap.Duration("wait", wait),
+				zap.Int("attempt", attempt+1))
+			if err := r.sleep(ctx, wait); err != nil {
+				return "", 0, 0, err
+			}
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return "", 0, 0, fmt.Errorf("%w: API returned %d", ErrAIReviewAuth, resp.StatusCode)
+		case resp.StatusCode >= 300:
+			return "", 0, 0, fmt.Errorf("AI review API returned %d: %s", resp.StatusCode, truncateRunes(strings.TrimSpace(string(respBody)), 200))
+		}
+
+		var completion chatCompletionResponse
+		if err := json.Unmarshal(respBody, &completion); err != nil {
+			return "", 0, 0, fmt.Errorf("invalid AI review response: %w", err)
+		}
+		prompt, completed := completion.Usage.PromptTokens, completion.Usage.CompletionTokens
+		if len(completion.Choices) == 0 {
+			return "", prompt, completed, fmt.Errorf("%w: no choices", errMalformedReview)
+		}
+		return completion.Choices[0].Message.Content, prompt, completed, nil
+	}
+}
+
+// rawFinding is a finding as models write it; lines may be numbers or
+// strings
+type rawFinding struct {
+	File       string          `json:"file"`
+	Path       string          `json:"path"`
+	Line       json.RawMessage `json:"line"`
+	StartLine  json.RawMessage `json:"start_line"`
+	EndLine    json.RawMessage `json:"end_line"`
+	Severity   string          `json:"severity"`
+	Category   string          `json:"category"`
+	Message    string          `json:"message"`
+	Suggestion string          `json:"suggestion"`
+}
+
+// parseReviewFindings reads the findings of a model reply. The JSON may be
+// wrapped in a code fence or prose, or be a bare array. Findings without a
+// message, or on files outside the chunk, are dropped; unknown severities
+// become info.
+func parseReviewFindings(content string, files []string) ([]AIReviewFinding, error) {
+	data := extractJSON(content)
+	if data == "" {
+		return nil, fmt.Errorf("%w: no JSON in reply", errMalformedReview)
+	}
+
+	var raw []rawFinding
+	if strings.HasPrefix(data, "[") {
+		if err := json.Unmarshal([]byte(data), &raw); err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedReview, err)
+		}
+	} else {
+		var reply struct {
+			Findings *[]rawFinding `json:"findings"`
+		}
+		if err := json.Unmarshal([]byte(data), &reply); err != nil {
+			return nil, fmt.Errorf("%w: %v", errMalformedReview, err)
+		}
+		if reply.Findings == nil {
+			return nil, fmt.Errorf("%w: no findings field", errMalformedReview)
+		}
+		raw = *reply.Findings
+	}
+
+	inChunk := make(map[string]bool, len(files))
+	for _, file := range files {
+		inChunk[file] = true
+	}
+	findings := make([]AIReviewFinding, 0, len(raw))
+	for _, f := range raw {
+		file := strings.TrimPrefix(strings.TrimPrefix(firstNonEmpty(f.File, f.Path), "b/"), "./")
+		if file == "" && len(files) == 1 {
+			file = files[0]
+		}
+		message := strings.TrimSpace(f.Message)
+		if !inChunk[file] || message == "" {
+			continue
+		}
+		start := lineNumber(f.StartLine)
+		if start == 0 {
+			start = lineNumber(f.Line)
+		}
+		end := lineNumber(f.EndLine)
+		if end < start {
+			end = start
+		}
+		severity := strings.ToLower(strings.TrimSpace(f.Severity))
+		if _, ok := aiReviewSeverityWeights[severity]; !ok {
+			severity = AIReviewSeverityInfo
+		}
+		category := strings.ToLower(strings.TrimSpace(f.Category))
+		if category == "" {
+			category = "general"
+		}
+		findings = append(findings, AIReviewFinding{
+			File:       file,
+			StartLine:  start,
+			EndLine:    end,
+			Severity:   severity,
+			Category:   category,
+			Message:    message,
+			Suggestion: strings.TrimSpace(f.Suggestion),
+		})
+	}
+	return findings, nil
+}
+
+// extractJSON returns the outermost JSON object or array of a reply
+func extractJSON(content string) string {
+	start := strings.IndexAny(content, "{[")
+	if start < 0 {
+		return ""
+	}
+	closing := "}"
+	if content[start] == '[' {
+		closing = "]"
+	}
+	end := strings.LastIndex(content, closing)
+	if end < start {
+		return ""
+	}
+	return content[start : end+1]
+}
+
+// lineNumber reads a line number written as a number or a string; 0 when
+// it is neither
+func lineNumber(raw json.RawMessage) int {
+	var n float64
+	if json.Unmarshal(raw, &n) == nil && n > 0 {
+		return int(n)
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		s, _, _ = strings.Cut(strings.TrimSpace(s), "-")
+		if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// chunkDiff packs the diffs of files into chunks of at most maxBytes of
+// patch. Files larger than a chunk are split at hunks, and hunks larger
+// than a chunk at lines. Binary files and files without a patch are left
+// out.
+func chunkDiff(files []PullRequestFile, maxBytes int) []reviewChunk {
+	var chunks []reviewChunk
+	var current reviewChunk
+	flush := func() {
+		if current.text != "" {
+			chunks = append(chunks, current)
+		}
+		current = reviewChunk{}
+	}
+	add := func(path, text string) {
+		if len(current.text)+len(text) > maxBytes {
+			flush()
+		}
+		if len(current.files) == 0 || current.files[len(current.files)-1] != path {
+			current.files = append(current.files, path)
+		}
+		current.text += text
+		current.lines += changedLines(text)
+	}
+
+	for _, file := range files {
+		if file.Binary || file.Patch == "" {
+			continue
+		}
+		header := fmt.Sprintf("--- %s (%s)\n", file.Path, file.Status)
+		if len(header)+len(file.Patch)+1 <= maxBytes {
+			add(file.Path, header+file.Patch+"\n")
+			continue
+		}
+		// Each part of a split file starts with its header
+		flush()
+		for _, part := range splitPatch(file.Patch, maxBytes-len(header)) {
+			add(file.Path, header+part)
+			flush()
+		}
+	}
+	flush()
+	return chunks
+}
+
+// splitPatch splits a patch into parts of at most maxBytes at hunk
+// headers, or at lines within hunks larger than that
+func splitPatch(patch string, maxBytes int) []string {
+	if maxBytes < 1 {
+		maxBytes = 1
+	}
+	var parts []string
+	var part strings.Builder
+	for _, line := range strings.SplitAfter(patch+"\n", "\n") {
+		if line == "" {
+			continue
+		}
+		full := part.Len()+len(line) > maxBytes
+		if part.Len() > 0 && (full || (strings.HasPrefix(line, "@@") && part.Len() > maxBytes/2)) {
+			parts = append(parts, part.String())
+			part.Reset()
+		}
+		part.WriteString(line)
+	}
+	if part.Len() > 0 {
+		parts = append(parts, part.String())
+	}
+	return parts
+}
+
+// changedLines counts the added and removed lines of a patch
+func changedLines(patch string) int {
+	n := 0
+	for _, line := range strings.Split(patch, "\n") {
+		if (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-")) &&
+			!strings.HasPrefix(line, "+++") && !strings.HasPrefix(line, "---") {
+			n++
+		}
+	}
+	return n
+}
+
+// aiReviewQuality computes code quality from the density of findings,
+// weighted by severity, per 100 changed lines: 1 without findings, 0.5 at
+// aiReviewQualityScale. Diffs under 20 lines count as 20, so a single
+// finding in a small change is not scored as dense.
+func aiReviewQuality(findings []AIReviewFinding, lines int) float64 {
+	weight := 0.0
+	for _, finding := range findings {
+		weight += aiReviewSeverityWeights[finding.Severity]
+	}
+	if lines < 20 {
+		lines = 20
+	}
+	density := weight * 100 / float64(lines)
+	return 1 / (1 + density/aiReviewQualityScale)
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// llmReply is a scripted reply of the fake LLM server: a status, a
+// Retry-After header and the content of the message
+type llmReply struct {
+	status     int
+	retryAfter string
+	content    string
+	block      bool // Hold the request until the client gives up
+}
+
+// fakeLLM is an OpenAI-compatible chat completions server replying to the
+// requests reviewing each file with the replies scripted for it, the last
+// one repeating. Every reply uses 100 prompt and 20 completion tokens.
+type fakeLLM struct {
+	mu      sync.Mutex
+	replies map[string][]llmReply
+	calls   map[string]int
+	keys    []string
+}
+
+func newFakeLLM(t *testing.T, replies map[string][]llmReply) (*fakeLLM, *httptest.Server) {
+	llm := &fakeLLM{replies: replies, calls: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(llm.serve))
+	t.Cleanup(server.Close)
+	return llm, server
+}
+
+func (f *fakeLLM) serve(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	if r.URL.Path != "/chat/completions" || json.NewDecoder(r.Body).Decode(&req) != nil || len(req.Messages) != 2 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.keys = append(f.keys, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	var reply llmReply
+	for file, replies := range f.replies {
+		if strings.Contains(req.Messages[1].Content, "--- "+file+" ") {
+			reply = replies[min(f.calls[file], len(replies)-1)]
+			f.calls[file]++
+		}
+	}
+	f.mu.Unlock()
+
+	if reply.block {
+		<-r.Context().Done()
+		return
+	}
+	if reply.retryAfter != "" {
+		w.Header().Set("Retry-After", reply.retryAfter)
+	}
+	if reply.status != 0 {
+		w.WriteHeader(reply.status)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"message": map[string]string{"role": "assistant", "content": reply.content}}},
+		"usage":   map[string]int{"prompt_tokens": 100, "completion_tokens": 20},
+	})
+}
+
+func (f *fakeLLM) callsOf(file string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[file]
+}
+
+// mapSecrets is a secret provider holding secrets in memory
+type mapSecrets struct {
+	SecretProvider
+	secrets map[string]string // By project ID and name, as project/name
+}
+
+func (s mapSecrets) GetSecret(ctx context.Context, projectID, name string) (string, error) {
+	value, ok := s.secrets[projectID+"/"+name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// setupLLMReviewer returns a reviewer of the fake server whose backoff
+// waits are recorded instead of slept
+func setupLLMReviewer(t *testing.T, server *httptest.Server, cfg config.AIReviewConfig) (*LLMReviewer, *[]time.Duration) {
+	cfg.BaseURL, cfg.Model = server.URL+"/", "review-model"
+	if cfg.MaxChunkBytes == 0 {
+		cfg.MaxChunkBytes = 200
+	}
+	cfg.MaxTokens, cfg.Timeout, cfg.MaxBackoff = 500, 5, 60
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = 2
+	}
+	if cfg.APIKey == "" {
+		cfg.APIKey = "global-key"
+	}
+	cfg.APIKeySecret = "AI_REVIEW_API_KEY"
+	secrets := mapSecrets{secrets: map[string]string{"project-1/AI_REVIEW_API_KEY": "project-key"}}
+	reviewer := NewLLMReviewer(&cfg, secrets, zap.NewNop())
+
+	var mu sync.Mutex
+	var waits []time.Duration
+	reviewer.sleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		waits = append(waits, d)
+		return nil
+	}
+	return reviewer, &waits
+}
+
+// reviewFiles are two files of a diff, each of them a chunk of 200 bytes
+var reviewFiles = []PullRequestFile{
+	{Path: "api/handler.go", Status: PullRequestFileModified, Patch: "@@ -10,3 +10,4 @@ func handle() {\n \tdb := open()\n-\tdefer db.Close()\n+\tquery(db, input)\n+\treturn nil\n"},
+	{Path: "api/util.go", Status: PullRequestFileAdded, Patch: "@@ -0,0 +1,3 @@\n+package api\n+\n+func helper() {}\n"},
+	{Path: "logo.png", Status: PullRequestFileAdded, Binary: true},
+}
+
+func TestLLMReviewer_ReviewsChunks(t *testing.T) {
+	llm, server := newFakeLLM(t, map[string][]llmReply{
+		// Fenced JSON with lines written as strings
+		"api/handler.go": {{content: "Here is my review:\n```json\n" +
+			`{"findings": [{"file": "api/handler.go", "start_line": "12", "end_line": "11", "severity": "HIGH", "category": "Security", "message": "Input reaches the query unescaped", "suggestion": "Use a parameterized query"},` +
+			` {"file": "other.go", "line": 3, "severity": "low", "message": "Not in this chunk"},` +
+			` {"file": "api/handler.go", "line": 11, "severity": "medium", "message": " "}]}` + "\n```"}},
+		// A bare array without file names
+		"api/util.go": {{content: `[{"line": 3, "severity": "nitpick", "category": "style", "message": "helper is unused"}]`}},
+	})
+	reviewer, _ := setupLLMReviewer(t, server, config.AIReviewConfig{})
+	store := NewMemoryUsageCounterStore()
+	meter, db, _ := setupUsageMeter(t, store)
+	reviewer.SetUsageMeter(meter)
+
+	var progress []int
+	review, err := reviewer.Review(context.Background(), &AIReviewRequest{
+		ProjectID: "project-1",
+		Title:     "Add query endpoint",
+		Files:     reviewFiles,
+		Checks:    "passed",
+		Progress:  func(done, total int) { progress = append(progress, done*10+total) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []AIReviewFinding{
+		{File: "api/handler.go", StartLine: 12, EndLine: 12, Severity: AIReviewSeverityHigh, Category: "security", Message: "Input reaches the query unescaped", Suggestion: "Use a parameterized query"},
+		{File: "api/util.go", StartLine: 3, EndLine: 3, Severity: AIReviewSeverityInfo, Category: "style", Message: "helper is unused"},
+	}, review.Findings)
+	assert.Equal(t, 2, review.Chunks)
+	assert.False(t, review.Partial)
+	assert.Equal(t, "review-model", review.Model)
+	assert.Equal(t, 200, review.PromptTokens)
+	assert.Equal(t, 40, review.CompletionTokens)
+	assert.Equal(t, []int{12, 22}, progress)
+	// One high finding in 6 changed lines, counted as 20
+	assert.InDelta(t, 1/(1+25.0/aiReviewQualityScale), review.CodeQuality, 1e-9)
+	// The project's own key is used
+	assert.Equal(t, []string{"project-key", "project-key"}, llm.keys)
+
+	_, err = meter.Rollup(context.Background())
+	require.NoError(t, err)
+	counts := usageCounts(t, db)
+	assert.EqualValues(t, 200, counts["project-1//llm_prompt_tokens@09"])
+	assert.EqualValues(t, 40, counts["project-1//llm_completion_tokens@09"])
+
+	// Projects without a key use the configured one; diffs are split into
+	// files when none are given
+	_, err = reviewer.Review(context.Background(), &AIReviewRequest{
+		ProjectID: "project-2",
+		Diff:      "diff --git a/api/util.go b/api/util.go\n--- a/api/util.go\n+++ b/api/util.go\n" + reviewFiles[1].Patch,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "global-key", llm.keys[2])
+}
+
+func TestLLMReviewer_RetriesRateLimitsAndMalformedReplies(t *testing.T) {
+	llm, server := newFakeLLM(t, map[string][]llmReply{
+		"api/handler.go": {
+			{status: http.StatusTooManyRequests, retryAfter: "7"},
+			{status: http.StatusTooManyRequests},
+			{content: `{"findings": [{"file": "api/handler.go", "line": 12,`},
+			{content: `{"findings": [{"file": "api/handler.go", "line": 12, "severity": "critical", "message": "Connection leaks"}]}`},
+		},
+		// Never valid findings
+		"api/util.go": {{content: "I could not find any issues."}, {content: `{"summary": "fine"}`}},
+	})
+	reviewer, waits := setupLLMReviewer(t, server, config.AIReviewConfig{MaxRetries: 3, MaxParseFailures: 1})
+
+	review, err := reviewer.Review(context.Background(), &AIReviewRequest{ProjectID: "project-1", Files: reviewFiles})
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{7 * time.Second, 2 * time.Second}, *waits, "Retry-After, then exponential backoff")
+	require.Len(t, review.Findings, 1)
+	assert.Equal(t, "Connection leaks", review.Findings[0].Message)
+	assert.Equal(t, 4, llm.callsOf("api/handler.go"))
+
+	// The chunk whose replies never parse is left out
+	assert.Equal(t, 2, llm.callsOf("api/util.go"))
+	assert.True(t, review.Partial)
+	assert.Equal(t, 1, review.FailedChunks)
+	require.Len(t, review.Errors, 1)
+	assert.Contains(t, review.Errors[0], "api/util.go: malformed review")
+	// Tokens of the malformed replies are counted too
+	assert.Equal(t, 400, review.PromptTokens)
+	// Quality covers the reviewed chunk only: one critical in 3 lines
+	assert.InDelta(t, 1/(1+50.0/aiReviewQualityScale), review.CodeQuality, 1e-9)
+}
+
+func TestLLMReviewer_Failures(t *testing.T) {
+	ctx := context.Background()
+	files := reviewFiles[:1]
+
+	_, server := newFakeLLM(t, map[string][]llmReply{"api/handler.go": {{status: http.StatusTooManyRequests}}})
+	reviewer, waits := setupLLMReviewer(t, server, config.AIReviewConfig{MaxRetries: 2})
+	_, err := reviewer.Review(ctx, &AIReviewRequest{ProjectID: "project-1", Files: files})
+	assert.ErrorIs(t, err, ErrAIReviewFailed)
+	assert.Contains(t, err.Error(), "returned 429 after 3 attempts")
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *waits)
+
+	_, server = newFakeLLM(t, map[string][]llmReply{"api/handler.go": {{status: http.StatusUnauthorized}}})
+	reviewer, _ = setupLLMReviewer(t, server, config.AIReviewConfig{})
+	_, err = reviewer.Review(ctx, &AIReviewRequest{ProjectID: "project-1", Files: files})
+	assert.ErrorIs(t, err, ErrAIReviewAuth)
+
+	reviewer = NewLLMReviewer(&config.AIReviewConfig{BaseURL: server.URL, MaxChunkBytes: 200, Concurrency: 1}, nil, zap.NewNop())
+	_, err = reviewer.Review(ctx, &AIReviewRequest{ProjectID: "project-1", Files: files})
+	assert.ErrorIs(t, err, ErrAIReviewAuth)
+
+	// Nothing to review
+	reviewer, _ = setupLLMReviewer(t, server, config.AIReviewConfig{})
+	review, err := reviewer.Review(ctx, &AIReviewRequest{ProjectID: "project-1", Files: reviewFiles[2:]})
+	require.NoError(t, err)
+	assert.Zero(t, review.Chunks)
+	assert.Equal(t, 1.0, review.CodeQuality)
+}
+
+func TestLLMReviewer_PartialOnDeadline(t *testing.T) {
+	_, server := newFakeLLM(t, map[string][]llmReply{
+		"api/handler.go": {{content: `{"findings": []}`}},
+		"api/util.go":    {{block: true}},
+	})
+	reviewer, _ := setupLLMReviewer(t, server, config.AIReviewConfig{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	review, err := reviewer.Review(ctx, &AIReviewRequest{ProjectID: "project-1", Files: reviewFiles})
+	require.NoError(t, err)
+	assert.True(t, review.Partial)
+	assert.Equal(t, 1, review.FailedChunks)
+	assert.Contains(t, review.Errors[0], "context deadline exceeded")
+	assert.Equal(t, 1.0, review.CodeQuality)
+}
+
+func TestChunkDiff(t *testing.T) {
+	var large strings.Builder
+	for hunk := 0; hunk < 3; hunk++ {
+		fmt.Fprintf(&large, "@@ -%d,4 +%d,4 @@\n", hunk*100+1, hunk*100+1)
+		for line := 0; line < 4; line++ {
+			fmt.Fprintf(&large, "+line %d of hunk %d\n", line, hunk)
+		}
+	}
+	files := []PullRequestFile{
+		{Path: "a.go", Status: PullRequestFileModified, Patch: "@@ -1 +1 @@\n-a\n+b"},
+		{Path: "b.go", Status: PullRequestFileModified, Patch: "@@ -1 +1 @@\n-c\n+d"},
+		{Path: "large.go", Status: PullRequestFileModified, Patch: strings.TrimSuffix(large.String(), "\n")},
+		{Path: "empty.go", Status: PullRequestFileRemoved},
+	}
+
+	chunks := chunkDiff(files, 120)
+	require.Len(t, chunks, 4)
+	// Small files share a chunk
+	assert.Equal(t, []string{"a.go", "b.go"}, chunks[0].files)
+	assert.Equal(t, 4, chunks[0].lines)
+	// The large file is split at its hunks, each part with the file header
+	for i, chunk := range chunks[1:] {
+		assert.Equal(t, []string{"large.go"}, chunk.files)
+		assert.True(t, strings.HasPrefix(chunk.text, "--- large.go (modified)\n@@ -"), chunk.text)
+		assert.LessOrEqual(t, len(chunk.text), 120)
+		assert.Contains(t, chunk.text, fmt.Sprintf("of hunk %d", i))
+		assert.Equal(t, 4, chunk.lines)
+	}
+
+	// Hunks larger than a chunk are split at lines
+	for _, chunk := range chunkDiff(files[2:3], 60) {
+		assert.LessOrEqual(t, len(chunk.text), 60)
+	}
+}
+
+func TestAIReviewQuality(t *testing.T) {
+	assert.Equal(t, 1.0, aiReviewQuality(nil, 100))
+	assert.Equal(t, 1.0, aiReviewQuality([]AIReviewFinding{{Severity: AIReviewSeverityInfo}}, 100))
+	// 20 weighted findings per 100 lines halve the quality
+	findings := []AIReviewFinding{{Severity: AIReviewSeverityCritical}, {Severity: AIReviewSeverityHigh}, {Severity: AIReviewSeverityHigh}}
+	assert.InDelta(t, 0.5, aiReviewQuality(findings, 100), 1e-9)
+	// The same findings are denser in a smaller diff
+	assert.Less(t, aiReviewQuality(findings, 50), aiReviewQuality(findings, 100))
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// BypassCacheMetadataKey is the key of the workflow metadata flag that makes
+// code analysis ignore cached reports
+const BypassCacheMetadataKey = "bypass_cache"
+
+// Results of analysis cache lookups
+const (
+	AnalysisCacheHit         = "hit"
+	AnalysisCacheMiss        = "miss"
+	AnalysisCacheInvalidated = "invalidated" // Cached by other analyzer versions
+	AnalysisCacheExpired     = "expired"
+)
+
+var analysisCacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_analysis_cache_lookups_total",
+	Help: "Analysis cache lookups, by result (hit, miss, invalidated, expired)",
+}, []string{"result"})
+
+// AnalysisCacheKey identifies what an analysis analyzed
+type AnalysisCacheKey struct {
+	Repository string
+	Commit     string
+	Path       string
+	Include    []string
+	Types      []string // Every type when empty
+}
+
+// types returns the sorted, comma separated analysis types
+func (k *AnalysisCacheKey) types() string {
+	types := append([]string(nil), k.Types...)
+	sort.Strings(types)
+	return strings.Join(types, ",")
+}
+
+// hash returns the primary key of the analysis
+func (k *AnalysisCacheKey) hash() string {
+	include := append([]string(nil), k.Include...)
+	sort.Strings(include)
+	sum := sha256.New()
+	for _, part := range []string{k.Repository, k.Commit, k.Path, strings.Join(include, ","), k.types()} {
+		// Length-prefixed so that parts cannot run into each other
+		fmt.Fprintf(sum, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// AnalysisCache keeps the reports of code analyses by commit, so analyzing a
+// commit again reuses them. Reports are cached under the version of the
+// analyzers that produced them and expire after the retention.
+type AnalysisCache struct {
+	db     *gorm.DB
+	cfg    *config.AnalysisCacheConfig
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewAnalysisCache creates an analysis cache
+func NewAnalysisCache(db *gorm.DB, cfg *config.AnalysisCacheConfig, logger *zap.Logger) *AnalysisCache {
+	return &AnalysisCache{db: db, cfg: cfg, logger: logger, now: time.Now}
+}
+
+// version is the version reports are cached under: the configured analyzer
+// version and that of the analyzers themselves
+func (c *AnalysisCache) version(analyzers string) string {
+	if c.cfg.AnalyzerVersion == "" {
+		return analyzers
+	}
+	return c.cfg.AnalyzerVersion + "; " + analyzers
+}
+
+// Lookup returns the cached report of an analysis by analyzers at version,
+// and the result of the lookup. Reports cached by other versions or past the
+// retention are removed.
+func (c *AnalysisCache) Lookup(ctx context.Context, key *AnalysisCacheKey, version string) (json.RawMessage, string, error) {
+	var cached models.AnalysisResult
+	err := c.db.WithContext(ctx).Where("key = ?", key.hash()).First(&cached).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		analysisCacheLookupsTotal.WithLabelValues(AnalysisCacheMiss).Inc()
+		return nil, AnalysisCacheMiss, nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to look up analysis result: %w", err)
+	}
+
+	result := AnalysisCacheHit
+	switch {
+	case cached.AnalyzerVersion != c.version(version):
+		result = AnalysisCacheInvalidated
+	case !c.now().Before(cached.ExpiresAt):
+		result = AnalysisCacheExpired
+	}
+	analysisCacheLookupsTotal.WithLabelValues(result).Inc()
+	if result != AnalysisCacheHit {
+		c.logger.Debug("Removing cached analysis result",
+			zap.String("repository", cached.Repository),
+			zap.String("commit", cached.Commit),
+			zap.String("reason", result))
+		if err := c.db.WithContext(ctx).Delete(&cached).Error; err != nil {
+			return nil, "", fmt.Errorf("failed to remove analysis result: %w", err)
+		}
+		return nil, result, nil
+	}
+	return cached.Report, result, nil
+}
+
+// Store caches the report of an analysis by analyzers at version, replacing
+// any earlier one, and removes the expired reports
+func (c *AnalysisCache) Store(ctx context.Context, key *AnalysisCacheKey, version string, report json.RawMessage, projectID, workflowID string) error {
+	now := c.now()
+	result := &models.AnalysisResult{
+		Key:             key.hash(),
+		Repository:      key.Repository,
+		Commit:          key.Commit,
+		Types:           key.types(),
+		AnalyzerVersion: c.version(version),
+		Report:          report,
+		ProjectID:       projectID,
+		WorkflowID:      workflowID,
+		CreatedAt:       now,
+		ExpiresAt:       now.Add(time.Duration(c.cfg.Retention) * time.Second),
+	}
+	err := c.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(result).Error
+	if err != nil {
+		return fmt.Errorf("failed to store analysis result: %w", err)
+	}
+
+	if err := c.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.AnalysisResult{}).Error; err != nil {
+		c.logger.Warn("Failed to remove expired analysis results", zap.Error(err))
+	}
+	return nil
+}
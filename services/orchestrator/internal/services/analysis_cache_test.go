@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+func setupAnalysisCache(t *testing.T, analyzerVersion string) (*AnalysisCache, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.AnalysisResult{}))
+	return NewAnalysisCache(db, &config.AnalysisCacheConfig{
+		Enabled:         true,
+		Retention:       3600,
+		AnalyzerVersion: analyzerVersion,
+	}, zap.NewNop()), db
+}
+
+func TestAnalysisCache_MissThenHit(t *testing.T) {
+	cache, db := setupAnalysisCache(t, "")
+	ctx := context.Background()
+	key := &AnalysisCacheKey{Repository: "https://github.com/acme/api", Commit: "abc123", Types: []string{"static", "security"}}
+
+	report, result, err := cache.Lookup(ctx, key, "static/1")
+	require.NoError(t, err)
+	assert.Equal(t, AnalysisCacheMiss, result)
+	assert.Nil(t, report)
+
+	require.NoError(t, cache.Store(ctx, key, "static/1", json.RawMessage(`{"score": 0.9}`), "project-1", "workflow-1"))
+
+	// The order of the types does not matter
+	report, result, err = cache.Lookup(ctx, &AnalysisCacheKey{
+		Repository: key.Repository, Commit: "abc123", Types: []string{"security", "static"},
+	}, "static/1")
+	require.NoError(t, err)
+	assert.Equal(t, AnalysisCacheHit, result)
+	assert.JSONEq(t, `{"score": 0.9}`, string(report))
+
+	var stored models.AnalysisResult
+	require.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, "security,static", stored.Types)
+	assert.Equal(t, "workflow-1", stored.WorkflowID)
+
+	// Other commits, paths and types are other analyses
+	for _, other := range []*AnalysisCacheKey{
+		{Repository: key.Repository, Commit: "def456", Types: key.Types},
+		{Repository: key.Repository, Commit: "abc123", Path: "pkg", Types: key.Types},
+		{Repository: key.Repository, Commit: "abc123", Types: []string{"static"}},
+	} {
+		_, result, err := cache.Lookup(ctx, other, "static/1")
+		require.NoError(t, err)
+		assert.Equal(t, AnalysisCacheMiss, result)
+	}
+
+	// Storing again replaces the report
+	require.NoError(t, cache.Store(ctx, key, "static/1", json.RawMessage(`{"score": 0.7}`), "project-1", "workflow-2"))
+	report, _, err = cache.Lookup(ctx, key, "static/1")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"score": 0.7}`, string(report))
+	var count int64
+	db.Model(&models.AnalysisResult{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAnalysisCache_VersionInvalidates(t *testing.T) {
+	cache, db := setupAnalysisCache(t, "tools-2026.1")
+	ctx := context.Background()
+	key := &AnalysisCacheKey{Repository: "https://github.com/acme/api", Commit: "abc123"}
+
+	require.NoError(t, cache.Store(ctx, key, "static/1", json.RawMessage(`{}`), "project-1", "workflow-1"))
+
+	// Analyzers at another version invalidate the report
+	report, result, err := cache.Lookup(ctx, key, "static/2")
+	require.NoError(t, err)
+	assert.Equal(t, AnalysisCacheInvalidated, result)
+	assert.Nil(t, report)
+	var count int64
+	db.Model(&models.AnalysisResult{}).Count(&count)
+	assert.Zero(t, count)
+
+	// As does changing the configured analyzer version
+	require.NoError(t, cache.Store(ctx, key, "static/1", json.RawMessage(`{}`), "project-1", "workflow-1"))
+	cache.cfg.AnalyzerVersion = "tools-2026.2"
+	_, result, err = cache.Lookup(ctx, key, "static/1")
+	require.NoError(t, err)
+	assert.Equal(t, AnalysisCacheInvalidated, result)
+}
+
+func TestAnalysisCache_Expires(t *testing.T) {
+	cache, db := setupAnalysisCache(t, "")
+	ctx := context.Background()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	key := &AnalysisCacheKey{Repository: "https://github.com/acme/api", Commit: "abc123"}
+	other := &AnalysisCacheKey{Repository: "https://github.com/acme/web", Commit: "abc123"}
+
+	require.NoError(t, cache.Store(ctx, key, "static/1", json.RawMessage(`{}`), "project-1", "workflow-1"))
+	require.NoError(t, cache.Store(ctx, other, "static/1", json.RawMessage(`{}`), "project-1", "workflow-2"))
+
+	now = now.Add(59 * time.Minute)
+	_, result, err := cache.Lookup(ctx, key, "static/1")
+	require.NoError(t, err)
+	assert.Equal(t, AnalysisCacheHit, result)
+
+	// Past the retention the report is not reused
+	now = now.Add(time.Minute)
+	_, result, err = cache.Lookup(ctx, key, "static/1")
+	require.NoError(t, err)
+	assert.Equal(t, AnalysisCacheExpired, result)
+
+	// Storing a report removes the other expired ones
+	require.NoError(t, cache.Store(ctx, key, "static/1", json.RawMessage(`{}`), "project-1", "workflow-3"))
+	var repositories []string
+	require.NoError(t, db.Model(&models.AnalysisResult{}).Pluck("repository", &repositories).Error)
+	assert.Equal(t, []string{"https://github.com/acme/api"}, repositories)
+}
+
+func TestNewWorkflowRecord_BypassCache(t *testing.T) {
+	req := &StartWorkflowRequest{
+		Name:      "analysis",
+		Type:      string(models.WorkflowTypeAnalysis),
+		ProjectID: "project-1",
+		Input:     json.RawMessage(`{"repository": "https://github.com/acme/api"}`),
+	}
+	workflow, err := newWorkflowRecord(context.Background(), req, models.WorkflowStatusPending)
+	require.NoError(t, err)
+	assert.Empty(t, workflow.Metadata)
+
+	req.BypassCache = true
+	workflow, err = newWorkflowRecord(context.Background(), req, models.WorkflowStatusPending)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"bypass_cache": true}`, string(workflow.Metadata))
+}
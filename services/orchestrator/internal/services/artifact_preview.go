@@ -0,0 +1,453 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"orchestrator/internal/models"
+)
+
+// ArtifactHeadBytes is how much of the start of an artifact's content is
+// kept with its record for previews
+const ArtifactHeadBytes = 64 << 10
+
+// ArtifactLineCountMetadataKey is the artifact metadata key of the number of
+// lines of text contents, counted when the artifact is recorded
+const ArtifactLineCountMetadataKey = "line_count"
+
+// Limits of artifact previews
+const (
+	DefaultPreviewLines = 200
+	MaxPreviewLines     = 5000
+	DefaultPreviewDepth = 3
+	MaxPreviewDepth     = 10
+)
+
+// Content classes of artifact previews
+const (
+	ArtifactPreviewText        = "text"
+	ArtifactPreviewJSON        = "json"
+	ArtifactPreviewYAML        = "yaml"
+	ArtifactPreviewBinary      = "binary"
+	ArtifactPreviewUnavailable = "unavailable"
+)
+
+// Where the content of an artifact preview was read from
+const (
+	ArtifactPreviewSourceHead  = "head"
+	ArtifactPreviewSourceStore = "store"
+)
+
+// ErrInvalidPreview is returned for previews of out of range lines or depth
+var ErrInvalidPreview = errors.New("invalid artifact preview")
+
+// binaryContentTypes are content types previewed by metadata only, without
+// reading the content
+var binaryContentTypes = []string{
+	"image/", "audio/", "video/", "font/",
+	"application/octet-stream", "application/zip", "application/gzip", "application/pdf",
+	"application/x-tar",
+}
+
+// languagesByExtension detects the language of text artifacts by the
+// extension of their path or name
+var languagesByExtension = map[string]string{
+	".go": "go", ".py": "python", ".js": "javascript", ".jsx": "javascript", ".mjs": "javascript",
+	".ts": "typescript", ".tsx": "typescript", ".java": "java", ".kt": "kotlin", ".rb": "ruby",
+	".rs": "rust", ".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp", ".cs": "csharp",
+	".php": "php", ".swift": "swift", ".scala": "scala", ".sh": "shell", ".bash": "shell",
+	".sql": "sql", ".html": "html", ".htm": "html", ".css": "css", ".scss": "scss",
+	".md": "markdown", ".json": "json", ".yaml": "yaml", ".yml": "yaml", ".toml": "toml",
+	".xml": "xml", ".proto": "protobuf", ".tf": "hcl", ".txt": "text",
+}
+
+// languagesByName detects the language of files known by their name
+var languagesByName = map[string]string{
+	"Dockerfile": "dockerfile", "Makefile": "makefile", "go.mod": "go-module",
+}
+
+// shebangLanguages detects the language of scripts by the interpreter of
+// their shebang line, in order
+var shebangLanguages = []struct{ name, language string }{
+	{"python", "python"}, {"node", "javascript"}, {"ruby", "ruby"}, {"bash", "shell"}, {"sh", "shell"},
+}
+
+// ArtifactPreview is the start of an artifact's content: the first lines of
+// text, a depth-limited rendering of JSON and YAML, or for binary and
+// unavailable contents the artifact's metadata only
+type ArtifactPreview struct {
+	ArtifactID  string `json:"artifact_id"`
+	Name        string `json:"name"`
+	Path        string `json:"path,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	Checksum    string `json:"checksum,omitempty"`
+	Class       string `json:"class"`
+	Binary      bool   `json:"binary"`
+	Language    string `json:"language,omitempty"`
+	Content     string `json:"content,omitempty"`
+	// Lines is the number of lines of Content, TotalLines that of the whole
+	// content, or of the whole rendering for JSON and YAML
+	Lines      int    `json:"lines"`
+	TotalLines int    `json:"total_lines"`
+	Truncated  bool   `json:"truncated"`
+	Depth      int    `json:"depth,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// WithContentHead keeps the start of an artifact's content in its record,
+// and the line count of text contents in its metadata, so the artifact can
+// be previewed without reading artifact storage
+func WithContentHead(artifact *models.Artifact, content []byte) error {
+	head := content
+	if len(head) > ArtifactHeadBytes {
+		head = head[:ArtifactHeadBytes]
+	}
+	artifact.ContentHead = append([]byte(nil), head...)
+	if len(content) == 0 || isBinaryContent(content, true) {
+		return nil
+	}
+
+	metadata := map[string]interface{}{}
+	if len(artifact.Metadata) > 0 {
+		if err := json.Unmarshal(artifact.Metadata, &metadata); err != nil {
+			return fmt.Errorf("failed to parse artifact metadata: %w", err)
+		}
+	}
+	metadata[ArtifactLineCountMetadataKey] = countLines(content)
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact metadata: %w", err)
+	}
+	artifact.Metadata = data
+	return nil
+}
+
+// Preview returns the preview of an artifact, of at most lines lines and
+// for JSON and YAML nested depth levels. It is read from the head kept with
+// the artifact, or from artifact storage for artifacts recorded without one.
+func (s *ArtifactService) Preview(ctx context.Context, artifactID string, lines, depth int) (*ArtifactPreview, error) {
+	if lines < 1 || lines > MaxPreviewLines {
+		return nil, fmt.Errorf("%w: lines must be between 1 and %d", ErrInvalidPreview, MaxPreviewLines)
+	}
+	if depth < 1 || depth > MaxPreviewDepth {
+		return nil, fmt.Errorf("%w: depth must be between 1 and %d", ErrInvalidPreview, MaxPreviewDepth)
+	}
+	artifact, err := s.Get(ctx, artifactID)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &ArtifactPreview{
+		ArtifactID:  artifact.ID,
+		Name:        artifact.Name,
+		Path:        artifact.Path,
+		ContentType: artifact.ContentType,
+		Size:        artifact.Size,
+		Checksum:    artifact.Checksum,
+	}
+	if hasBinaryContentType(artifact.ContentType) {
+		preview.Class, preview.Binary = ArtifactPreviewBinary, true
+		return preview, nil
+	}
+
+	data, complete, err := s.previewContent(ctx, artifact, preview)
+	if err != nil {
+		return nil, err
+	}
+	if preview.Class == ArtifactPreviewUnavailable {
+		return preview, nil
+	}
+	if isBinaryContent(data, complete) {
+		preview.Class, preview.Binary = ArtifactPreviewBinary, true
+		return preview, nil
+	}
+
+	preview.Language = detectLanguage(artifact, data)
+	if complete {
+		if format := structuredFormat(artifact); format != "" {
+			if rendered, limited, ok := renderStructured(format, data, depth); ok {
+				preview.Class, preview.Depth = format, depth
+				previewText(preview, rendered, true, lines, countLines(rendered))
+				preview.Truncated = preview.Truncated || limited
+				return preview, nil
+			}
+		}
+	}
+
+	// The line count of incomplete heads was kept with the artifact
+	total := countLines(data)
+	if !complete {
+		total = artifactLineCount(artifact)
+	}
+	preview.Class = ArtifactPreviewText
+	previewText(preview, data, complete, lines, total)
+	return preview, nil
+}
+
+// artifactHead reads the head kept with an artifact, which the artifact
+// model never reads, decrypted for projects with encryption at rest
+type artifactHead struct {
+	ContentHead []byte `gorm:"encrypted"`
+}
+
+// previewContent reads the content to preview, its head when kept with the
+// artifact, else the whole content from artifact storage, and reports
+// whether it is the whole content. Artifacts whose content cannot be read
+// get the unavailable class with the reason.
+func (s *ArtifactService) previewContent(ctx context.Context, artifact *models.Artifact, preview *ArtifactPreview) ([]byte, bool, error) {
+	var heads []artifactHead
+	if err := s.db.WithContext(ctx).Table("artifacts").Select("content_head").
+		Where("id = ?", artifact.ID).Limit(1).
+		Find(&heads).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to get artifact head: %w", err)
+	}
+	if len(heads) > 0 && len(heads[0].ContentHead) > 0 {
+		head := heads[0].ContentHead
+		complete := len(head) < ArtifactHeadBytes || int64(len(head)) == artifact.Size
+		// Without a line count, the whole content is read to count lines
+		if complete || artifactLineCount(artifact) >= 0 {
+			preview.Source = ArtifactPreviewSourceHead
+			return head, complete, nil
+		}
+	}
+
+	// Only empty contents have no checksum
+	if artifact.Checksum == "" {
+		if artifact.Size > 0 {
+			preview.Class, preview.Reason = ArtifactPreviewUnavailable, "artifact content is not available"
+		}
+		return nil, true, nil
+	}
+	if s.store == nil {
+		preview.Class, preview.Reason = ArtifactPreviewUnavailable, "artifact contents are not stored"
+		return nil, false, nil
+	}
+	data, err := s.store.Get(ctx, artifact.Checksum)
+	if err != nil {
+		s.logger.Debug("Failed to read artifact content for preview",
+			zap.String("artifact_id", artifact.ID),
+			zap.Error(err))
+		preview.Class, preview.Reason = ArtifactPreviewUnavailable, "artifact content is not available"
+		return nil, false, nil
+	}
+	preview.Source = ArtifactPreviewSourceStore
+	return data, true, nil
+}
+
+// artifactLineCount returns the line count kept in an artifact's metadata,
+// -1 when it has none
+func artifactLineCount(artifact *models.Artifact) int {
+	var metadata map[string]interface{}
+	if len(artifact.Metadata) == 0 || json.Unmarshal(artifact.Metadata, &metadata) != nil {
+		return -1
+	}
+	count, ok := metadata[ArtifactLineCountMetadataKey].(float64)
+	if !ok {
+		return -1
+	}
+	return int(count)
+}
+
+// previewText sets the content of a preview to the first lines of data, of
+// total lines. The partial last line of an incomplete content is left out,
+// unless it is the only line.
+func previewText(preview *ArtifactPreview, data []byte, complete bool, lines, total int) {
+	end, shown, partial := 0, 0, false
+	for shown < lines && end < len(data) {
+		i := bytes.IndexByte(data[end:], '\n')
+		if i >= 0 {
+			end += i + 1
+			shown++
+			continue
+		}
+		if complete || shown == 0 {
+			end, partial = len(data), !complete
+			shown++
+		}
+		break
+	}
+	text := data[:end]
+	if partial {
+		// Incomplete heads may end within a character
+		for len(text) > 0 && !utf8.Valid(text) {
+			text = text[:len(text)-1]
+		}
+	}
+	preview.Content = string(text)
+	preview.Lines = shown
+	preview.TotalLines = total
+	preview.Truncated = partial || shown < total
+}
+
+// countLines counts the lines of data, the last one with or without a
+// newline
+func countLines(data []byte) int {
+	count := bytes.Count(data, []byte("\n"))
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		count++
+	}
+	return count
+}
+
+func hasBinaryContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range binaryContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBinaryContent reports whether data is not text: it holds NUL bytes or
+// is not valid UTF-8. Incomplete contents may end within a character.
+func isBinaryContent(data []byte, complete bool) bool {
+	if bytes.IndexByte(data, 0) >= 0 {
+		return true
+	}
+	if !complete {
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	return !utf8.Valid(data)
+}
+
+// structuredFormat returns the format, json or yaml, of an artifact's
+// content from its content type or extension, empty for other contents
+func structuredFormat(artifact *models.Artifact) string {
+	contentType := strings.ToLower(artifact.ContentType)
+	switch ext := strings.ToLower(path.Ext(artifactFileName(artifact))); {
+	case strings.Contains(contentType, "json") || ext == ".json":
+		return ArtifactPreviewJSON
+	case strings.Contains(contentType, "yaml") || ext == ".yaml" || ext == ".yml":
+		return ArtifactPreviewYAML
+	}
+	return ""
+}
+
+// artifactFileName is the file name of an artifact: the base of its path,
+// else its name
+func artifactFileName(artifact *models.Artifact) string {
+	if artifact.Path != "" {
+		return path.Base(artifact.Path)
+	}
+	return artifact.Name
+}
+
+// detectLanguage detects the language of a text artifact from the
+// extension of its file name, else from its content
+func detectLanguage(artifact *models.Artifact, data []byte) string {
+	name := artifactFileName(artifact)
+	if language, ok := languagesByName[name]; ok {
+		return language
+	}
+	if language, ok := languagesByExtension[strings.ToLower(path.Ext(name))]; ok {
+		return language
+	}
+
+	content := strings.TrimSpace(string(data))
+	firstLine, _, _ := strings.Cut(content, "\n")
+	switch {
+	case strings.HasPrefix(firstLine, "#!"):
+		for _, interpreter := range shebangLanguages {
+			if strings.Contains(firstLine, interpreter.name) {
+				return interpreter.language
+			}
+		}
+	case strings.HasPrefix(content, "<?php"):
+		return "php"
+	case strings.HasPrefix(strings.ToLower(content), "<!doctype html") || strings.HasPrefix(content, "<html"):
+		return "html"
+	case strings.HasPrefix(content, "<?xml"):
+		return "xml"
+	case strings.HasPrefix(content, "package ") && strings.Contains(content, "func "):
+		return "go"
+	case (strings.HasPrefix(content, "{") || strings.HasPrefix(content, "[")) && json.Valid(data):
+		return "json"
+	}
+	return ""
+}
+
+// renderStructured parses JSON or YAML data and renders it indented, with
+// the values nested deeper than depth replaced by a summary. It reports
+// whether values were replaced, and fails for data that does not parse.
+func renderStructured(format string, data []byte, depth int) ([]byte, bool, bool) {
+	var value interface{}
+	var err error
+	if format == ArtifactPreviewJSON {
+		err = json.Unmarshal(data, &value)
+	} else {
+		err = yaml.Unmarshal(data, &value)
+	}
+	if err != nil {
+		return nil, false, false
+	}
+
+	limited, cut := limitDepth(value, depth)
+	var rendered []byte
+	if format == ArtifactPreviewJSON {
+		rendered, err = json.MarshalIndent(limited, "", "  ")
+		rendered = append(rendered, '\n')
+	} else {
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err = encoder.Encode(limited); err == nil {
+			err = encoder.Close()
+		}
+		rendered = buf.Bytes()
+	}
+	if err != nil {
+		return nil, false, false
+	}
+	return rendered, cut, true
+}
+
+// limitDepth replaces the objects and arrays nested deeper than depth by a
+// summary of their size, and reports whether it replaced any
+func limitDepth(value interface{}, depth int) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if depth == 0 {
+			return fmt.Sprintf("{… %d keys}", len(v)), true
+		}
+		limited, cut := make(map[string]interface{}, len(v)), false
+		for key, item := range v {
+			var itemCut bool
+			limited[key], itemCut = limitDepth(item, depth-1)
+			cut = cut || itemCut
+		}
+		return limited, cut
+	case map[interface{}]interface{}:
+		// YAML mappings with keys other than strings
+		converted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = item
+		}
+		return limitDepth(converted, depth)
+	case []interface{}:
+		if depth == 0 {
+			return fmt.Sprintf("[… %d items]", len(v)), true
+		}
+		limited, cut := make([]interface{}, len(v)), false
+		for i, item := range v {
+			var itemCut bool
+			limited[i], itemCut = limitDepth(item, depth-1)
+			cut = cut || itemCut
+		}
+		return limited, cut
+	}
+	return value, false
+}
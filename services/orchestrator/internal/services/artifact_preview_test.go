@@ -0,0 +1,246 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"orchestrator/internal/encryption"
+	"orchestrator/internal/models"
+)
+
+// countingStore counts the contents read from artifact storage
+type countingStore struct {
+	OutputStore
+	gets atomic.Int32
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.gets.Add(1)
+	return s.OutputStore.Get(ctx, key)
+}
+
+// setupPreviewTest returns an artifact service whose reads of artifact
+// storage are counted, and a function storing artifacts of wf-1, with the
+// head of their content unless withoutHead
+func setupPreviewTest(t *testing.T) (*ArtifactService, *countingStore, func(id, artifactPath, contentType, content string, withoutHead bool)) {
+	accountant, artifacts := setupArtifactServiceTest(t, 1024)
+	store := &countingStore{OutputStore: artifacts.store}
+	artifacts.store = store
+	put := func(id, artifactPath, contentType, content string, withoutHead bool) {
+		checksum, err := artifacts.PutContent(context.Background(), []byte(content))
+		require.NoError(t, err)
+		record := artifact("project-1", id, "code", int64(len(content)))
+		record.WorkflowID, record.Path, record.Checksum, record.ContentType = "wf-1", artifactPath, checksum, contentType
+		if !withoutHead {
+			require.NoError(t, WithContentHead(&record, []byte(content)))
+		}
+		require.NoError(t, accountant.StoreArtifacts(context.Background(), "project-1", []models.Artifact{record}))
+	}
+	return artifacts, store, put
+}
+
+func TestArtifactService_PreviewText(t *testing.T) {
+	ctx := context.Background()
+	artifacts, store, put := setupPreviewTest(t)
+	put("main", "cmd/main.go", "", "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(1)\n}\n", false)
+
+	preview, err := artifacts.Preview(ctx, "main", 3, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewText, preview.Class)
+	assert.Equal(t, "go", preview.Language)
+	assert.Equal(t, "package main\n\nimport \"fmt\"\n", preview.Content)
+	assert.Equal(t, 3, preview.Lines)
+	assert.Equal(t, 7, preview.TotalLines)
+	assert.True(t, preview.Truncated)
+	assert.Equal(t, ArtifactPreviewSourceHead, preview.Source)
+
+	preview, err = artifacts.Preview(ctx, "main", 200, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Equal(t, 7, preview.Lines)
+	assert.False(t, preview.Truncated)
+	assert.Zero(t, store.gets.Load(), "previews are served from the head")
+
+	_, err = artifacts.Preview(ctx, "main", 0, DefaultPreviewDepth)
+	assert.ErrorIs(t, err, ErrInvalidPreview)
+	_, err = artifacts.Preview(ctx, "missing", 10, DefaultPreviewDepth)
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+}
+
+func TestArtifactService_PreviewLargeTextFromHead(t *testing.T) {
+	ctx := context.Background()
+	artifacts, store, put := setupPreviewTest(t)
+	var content strings.Builder
+	for i := 0; content.Len() <= ArtifactHeadBytes; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	total := strings.Count(content.String(), "\n")
+	put("log", "build.log", "text/plain", content.String(), false)
+
+	// The total comes from the line count kept with the head
+	preview, err := artifacts.Preview(ctx, "log", 2, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Equal(t, "line 0\nline 1\n", preview.Content)
+	assert.Equal(t, total, preview.TotalLines)
+	assert.True(t, preview.Truncated)
+
+	// Lines past the head stop at its last whole line
+	preview, err = artifacts.Preview(ctx, "log", MaxPreviewLines, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Less(t, preview.Lines, total)
+	assert.True(t, strings.HasSuffix(preview.Content, "\n"))
+	assert.LessOrEqual(t, len(preview.Content), ArtifactHeadBytes)
+	assert.Zero(t, store.gets.Load())
+}
+
+func TestPreviewText_Truncation(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		complete  bool
+		lines     int
+		content   string
+		shown     int
+		total     int
+		truncated bool
+	}{
+		{"empty", "", true, 10, "", 0, 0, false},
+		{"no trailing newline", "a\nb", true, 10, "a\nb", 2, 2, false},
+		{"trailing newline", "a\nb\n", true, 10, "a\nb\n", 2, 2, false},
+		{"cut", "a\nb\nc\n", true, 2, "a\nb\n", 2, 3, true},
+		{"exact", "a\nb\n", true, 2, "a\nb\n", 2, 2, false},
+		{"partial last line left out", "a\nb\nc", false, 10, "a\nb\n", 2, 9, true},
+		{"partial only line kept", "abc", false, 10, "abc", 1, 1, true},
+		{"partial character dropped", "ab\xc3", false, 10, "ab", 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total := countLines([]byte(tt.data))
+			if !tt.complete {
+				total = tt.total
+			}
+			assert.Equal(t, tt.total, total)
+
+			var preview ArtifactPreview
+			previewText(&preview, []byte(tt.data), tt.complete, tt.lines, total)
+			assert.Equal(t, tt.content, preview.Content)
+			assert.Equal(t, tt.shown, preview.Lines)
+			assert.Equal(t, tt.truncated, preview.Truncated)
+		})
+	}
+}
+
+func TestArtifactService_PreviewStructured(t *testing.T) {
+	ctx := context.Background()
+	artifacts, _, put := setupPreviewTest(t)
+	put("config", "deploy/config.json", "", `{"service": {"name": "api", "ports": [80, 443], "limits": {"cpu": "1"}}, "replicas": 2}`, false)
+	put("values", "chart/values.yaml", "", "image:\n  repository: api\n  tag: \"1.0\"\nreplicas: 2\n", false)
+	put("broken", "broken.json", "", `{"service": `, false)
+
+	preview, err := artifacts.Preview(ctx, "config", 200, 2)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewJSON, preview.Class)
+	assert.Equal(t, 2, preview.Depth)
+	assert.Equal(t, `{
+  "replicas": 2,
+  "service": {
+    "limits": "{… 1 keys}",
+    "name": "api",
+    "ports": "[… 2 items]"
+  }
+}
+`, preview.Content)
+	assert.True(t, preview.Truncated, "values below the depth are summarized")
+
+	preview, err = artifacts.Preview(ctx, "config", 200, 3)
+	require.NoError(t, err)
+	assert.False(t, preview.Truncated)
+	assert.Contains(t, preview.Content, `"cpu": "1"`)
+
+	preview, err = artifacts.Preview(ctx, "values", 200, 1)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewYAML, preview.Class)
+	assert.Equal(t, "image: '{… 2 keys}'\nreplicas: 2\n", preview.Content)
+	assert.True(t, preview.Truncated)
+
+	// Contents that do not parse are previewed as text
+	preview, err = artifacts.Preview(ctx, "broken", 200, 2)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewText, preview.Class)
+	assert.Equal(t, "json", preview.Language)
+	assert.Equal(t, `{"service": `, preview.Content)
+}
+
+func TestArtifactService_PreviewBinary(t *testing.T) {
+	ctx := context.Background()
+	artifacts, store, put := setupPreviewTest(t)
+	put("logo", "assets/logo", "", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", false)
+	put("photo", "assets/photo.jpg", "image/jpeg", "\xff\xd8\xff\xe0", true)
+
+	preview, err := artifacts.Preview(ctx, "logo", 200, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewBinary, preview.Class)
+	assert.True(t, preview.Binary)
+	assert.Empty(t, preview.Content)
+	assert.Equal(t, int64(16), preview.Size)
+	assert.NotEmpty(t, preview.Checksum)
+
+	// Binary content types are not read at all
+	preview, err = artifacts.Preview(ctx, "photo", 200, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.True(t, preview.Binary)
+	assert.Zero(t, store.gets.Load())
+}
+
+func TestArtifactService_PreviewFallsBackToStorage(t *testing.T) {
+	ctx := context.Background()
+	artifacts, store, put := setupPreviewTest(t)
+	put("script", "deploy", "", "#!/usr/bin/env bash\nset -e\nmake deploy\n", true)
+
+	preview, err := artifacts.Preview(ctx, "script", 2, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewSourceStore, preview.Source)
+	assert.Equal(t, "shell", preview.Language)
+	assert.Equal(t, "#!/usr/bin/env bash\nset -e\n", preview.Content)
+	assert.Equal(t, 3, preview.TotalLines)
+	assert.Equal(t, int32(1), store.gets.Load())
+
+	// Contents missing from storage leave the metadata
+	require.NoError(t, artifacts.db.Exec(`UPDATE artifacts SET checksum = 'gone' WHERE id = 'script'`).Error)
+	preview, err = artifacts.Preview(ctx, "script", 2, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewUnavailable, preview.Class)
+	assert.Equal(t, "artifact content is not available", preview.Reason)
+	assert.Empty(t, preview.Content)
+}
+
+func TestArtifactService_PreviewEncryptedHead(t *testing.T) {
+	ctx := context.Background()
+	artifacts, store, put := setupPreviewTest(t)
+	db := artifacts.db
+	require.NoError(t, db.Exec(`ALTER TABLE projects ADD COLUMN settings TEXT`).Error)
+	require.NoError(t, db.Exec(`UPDATE projects SET settings = '{"encrypt_at_rest": true}' WHERE id = 'project-1'`).Error)
+	keys, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	require.NoError(t, encryption.Register(db, encryption.NewEncryptor(keys), encryption.NewSettingsPolicy(0)))
+
+	put("main", "cmd/main.go", "", "package main\n\nconst password = \"hunter2\"\n", false)
+
+	// The head is stored like the code of the artifact's execution
+	var head []byte
+	require.NoError(t, db.Raw(`SELECT content_head FROM artifacts WHERE id = 'main'`).Row().Scan(&head))
+	assert.True(t, encryption.IsEncrypted(head))
+	assert.NotContains(t, string(head), "hunter2")
+
+	preview, err := artifacts.Preview(ctx, "main", 10, DefaultPreviewDepth)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactPreviewSourceHead, preview.Source)
+	assert.Equal(t, "package main\n\nconst password = \"hunter2\"\n", preview.Content)
+	assert.Zero(t, store.gets.Load())
+}
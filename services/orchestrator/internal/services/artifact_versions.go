@@ -0,0 +1,342 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrArtifactNotFound is returned for unknown artifacts
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// Artifact diff modes
+const (
+	ArtifactDiffText     = "text"     // Unified diff of the contents
+	ArtifactDiffMetadata = "metadata" // Metadata only, for binary, large or unavailable contents
+)
+
+// artifactDiffContext is the lines of context around each change of a diff
+const artifactDiffContext = 3
+
+// supersededArtifact matches artifacts a newer version replaced
+const supersededArtifact = "EXISTS (SELECT 1 FROM artifacts AS newer WHERE newer.parent_artifact_id = artifacts.id AND newer.deleted_at IS NULL)"
+
+// ArtifactKey returns the logical key of an artifact: its path within the
+// task or workflow, cleaned, else its name
+func ArtifactKey(artifactPath, name string) string {
+	if artifactPath == "" {
+		return name
+	}
+	return strings.TrimPrefix(path.Clean("/"+artifactPath), "/")
+}
+
+// versionArtifacts links the artifacts of workflows to the latest version of
+// their logical key, setting their version and parent. Artifacts whose
+// content is that of the latest version are dropped. Artifacts without a
+// workflow are not versioned. Callers serialize the writes of a workflow.
+func versionArtifacts(tx *gorm.DB, artifacts []*models.Artifact) ([]*models.Artifact, error) {
+	latest := make(map[string]*models.Artifact)
+	kept := artifacts[:0:0]
+	for _, artifact := range artifacts {
+		if artifact.WorkflowID == "" {
+			kept = append(kept, artifact)
+			continue
+		}
+		if artifact.LogicalKey == "" {
+			artifact.LogicalKey = ArtifactKey(artifact.Path, artifact.Name)
+		}
+
+		chain := artifact.WorkflowID + "\x00" + artifact.LogicalKey
+		previous, ok := latest[chain]
+		if !ok {
+			// Deleted versions keep their numbers, so versions never repeat
+			var found []models.Artifact
+			if err := tx.Unscoped().
+				Where("workflow_id = ? AND logical_key = ?", artifact.WorkflowID, artifact.LogicalKey).
+				Order("version DESC").Limit(1).
+				Find(&found).Error; err != nil {
+				return nil, fmt.Errorf("failed to find latest artifact version: %w", err)
+			}
+			if len(found) > 0 {
+				previous = &found[0]
+			}
+		}
+
+		if previous != nil && !previous.DeletedAt.Valid && previous.Checksum != "" && previous.Checksum == artifact.Checksum {
+			latest[chain] = previous
+			continue
+		}
+		artifact.Version = 1
+		artifact.ParentArtifactID = nil
+		if previous != nil {
+			artifact.Version = previous.Version + 1
+			artifact.ParentArtifactID = &previous.ID
+		}
+		latest[chain] = artifact
+		kept = append(kept, artifact)
+	}
+	return kept, nil
+}
+
+// ArtifactGroup is the latest version of an artifact and how many versions
+// it has
+type ArtifactGroup struct {
+	Key          string          `json:"key"`
+	Latest       models.Artifact `json:"latest"`
+	VersionCount int             `json:"version_count"`
+}
+
+// ArtifactMetadataChange is a metadata field that differs between two
+// artifacts
+type ArtifactMetadataChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// ArtifactDiff compares an artifact against another, usually an earlier
+// version of it. Text contents are compared as a unified diff from the other
+// artifact; other contents by metadata only, with the reason.
+type ArtifactDiff struct {
+	ArtifactID string                   `json:"artifact_id"`
+	AgainstID  string                   `json:"against_id"`
+	Mode       string                   `json:"mode"`
+	Diff       string                   `json:"diff,omitempty"`
+	Truncated  bool                     `json:"truncated,omitempty"`
+	Reason     string                   `json:"reason,omitempty"`
+	Changes    []ArtifactMetadataChange `json:"changes"`
+}
+
+// ArtifactService keeps artifact contents in artifact storage and lists,
+// versions and compares artifacts. Without a store, contents are only
+// checksummed and artifacts are compared by metadata.
+type ArtifactService struct {
+	db           *gorm.DB
+	store        OutputStore
+	maxDiffBytes int
+	logger       *zap.Logger
+}
+
+// NewArtifactService creates an artifact service keeping contents in store
+func NewArtifactService(db *gorm.DB, store OutputStore, cfg *config.ArtifactsConfig, logger *zap.Logger) *ArtifactService {
+	return &ArtifactService{
+		db:           db,
+		store:        store,
+		maxDiffBytes: cfg.MaxDiffBytes,
+		logger:       logger,
+	}
+}
+
+// PutContent keeps the content of an artifact under its checksum, which is
+// returned. Empty contents have no checksum. A nil service only checksums.
+func (s *ArtifactService) PutContent(ctx context.Context, content []byte) (string, error) {
+	if len(content) == 0 {
+		return "", nil
+	}
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	if s == nil || s.store == nil {
+		return checksum, nil
+	}
+	if err := s.store.Put(ctx, checksum, content); err != nil {
+		return "", fmt.Errorf("failed to store artifact content: %w", err)
+	}
+	return checksum, nil
+}
+
+// Get returns an artifact
+func (s *ArtifactService) Get(ctx context.Context, artifactID string) (*models.Artifact, error) {
+	var artifact models.Artifact
+	err := s.db.WithContext(ctx).First(&artifact, "id = ?", artifactID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrArtifactNotFound, artifactID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artifact: %w", err)
+	}
+	return &artifact, nil
+}
+
+// ListWorkflowArtifacts lists the artifacts of a workflow by logical key:
+// the latest version of each and its number of versions
+func (s *ArtifactService) ListWorkflowArtifacts(ctx context.Context, workflowID string) ([]ArtifactGroup, error) {
+	var latest []models.Artifact
+	if err := s.db.WithContext(ctx).
+		Joins("JOIN executions ON executions.id = artifacts.execution_id").
+		Where("executions.workflow_id = ? AND executions.deleted_at IS NULL", workflowID).
+		Where("NOT " + supersededArtifact).
+		Order("artifacts.created_at, artifacts.id").
+		Find(&latest).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workflow artifacts: %w", err)
+	}
+
+	var counts []struct {
+		LogicalKey string
+		Count      int
+	}
+	if err := s.db.WithContext(ctx).Model(&models.Artifact{}).
+		Select("logical_key, COUNT(*) AS count").
+		Where("workflow_id = ? AND logical_key <> ''", workflowID).
+		Group("logical_key").
+		Scan(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to count artifact versions: %w", err)
+	}
+	versions := make(map[string]int, len(counts))
+	for _, count := range counts {
+		versions[count.LogicalKey] = count.Count
+	}
+
+	groups := make([]ArtifactGroup, len(latest))
+	for i, artifact := range latest {
+		groups[i] = ArtifactGroup{Key: artifact.LogicalKey, Latest: artifact, VersionCount: 1}
+		if artifact.LogicalKey == "" {
+			groups[i].Key = ArtifactKey(artifact.Path, artifact.Name)
+		} else if count := versions[artifact.LogicalKey]; count > 0 {
+			groups[i].VersionCount = count
+		}
+	}
+	return groups, nil
+}
+
+// Versions lists the versions of an artifact's logical key in its workflow,
+// oldest first
+func (s *ArtifactService) Versions(ctx context.Context, artifactID string) ([]models.Artifact, error) {
+	artifact, err := s.Get(ctx, artifactID)
+	if err != nil {
+		return nil, err
+	}
+	if artifact.WorkflowID == "" || artifact.LogicalKey == "" {
+		return []models.Artifact{*artifact}, nil
+	}
+	var versions []models.Artifact
+	if err := s.db.WithContext(ctx).
+		Where("workflow_id = ? AND logical_key = ?", artifact.WorkflowID, artifact.LogicalKey).
+		Order("version").
+		Find(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list artifact versions: %w", err)
+	}
+	return versions, nil
+}
+
+// Diff compares an artifact against another. Contents are diffed as text
+// when both are in artifact storage, within the max diff bytes, and valid
+// UTF-8 without NUL bytes; the diff is truncated to the max diff bytes.
+func (s *ArtifactService) Diff(ctx context.Context, artifactID, againstID string) (*ArtifactDiff, error) {
+	artifact, err := s.Get(ctx, artifactID)
+	if err != nil {
+		return nil, err
+	}
+	against, err := s.Get(ctx, againstID)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ArtifactDiff{
+		ArtifactID: artifact.ID,
+		AgainstID:  against.ID,
+		Mode:       ArtifactDiffMetadata,
+		Changes:    artifactMetadataChanges(against, artifact),
+	}
+	from, to, reason := s.textContents(ctx, against, artifact)
+	if reason != "" {
+		diff.Reason = reason
+		return diff, nil
+	}
+
+	text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: artifactDiffLabel(against),
+		ToFile:   artifactDiffLabel(artifact),
+		Context:  artifactDiffContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff artifacts: %w", err)
+	}
+	if len(text) > s.maxDiffBytes {
+		cut := s.maxDiffBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		text, diff.Truncated = text[:cut], true
+	}
+	diff.Mode, diff.Diff = ArtifactDiffText, text
+	return diff, nil
+}
+
+// textContents reads the contents of two artifacts to diff, or says why
+// they are compared by metadata only
+func (s *ArtifactService) textContents(ctx context.Context, from, to *models.Artifact) (string, string, string) {
+	contents := make([]string, 2)
+	for i, artifact := range []*models.Artifact{from, to} {
+		if artifact.Size > int64(s.maxDiffBytes) {
+			return "", "", fmt.Sprintf("artifact %s is larger than %d bytes", artifact.ID, s.maxDiffBytes)
+		}
+		if artifact.Checksum == "" {
+			// Only empty contents have no checksum
+			if artifact.Size > 0 {
+				return "", "", fmt.Sprintf("content of artifact %s is not available", artifact.ID)
+			}
+			continue
+		}
+		if s.store == nil {
+			return "", "", "artifact contents are not stored"
+		}
+		data, err := s.store.Get(ctx, artifact.Checksum)
+		if err != nil {
+			s.logger.Debug("Failed to read artifact content",
+				zap.String("artifact_id", artifact.ID),
+				zap.Error(err))
+			return "", "", fmt.Sprintf("content of artifact %s is not available", artifact.ID)
+		}
+		if len(data) > s.maxDiffBytes {
+			return "", "", fmt.Sprintf("artifact %s is larger than %d bytes", artifact.ID, s.maxDiffBytes)
+		}
+		if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+			return "", "", fmt.Sprintf("artifact %s is binary", artifact.ID)
+		}
+		contents[i] = string(data)
+	}
+	return contents[0], contents[1], ""
+}
+
+// artifactDiffLabel names an artifact in diff headers
+func artifactDiffLabel(artifact *models.Artifact) string {
+	key := artifact.LogicalKey
+	if key == "" {
+		key = ArtifactKey(artifact.Path, artifact.Name)
+	}
+	return fmt.Sprintf("%s (v%d)", key, artifact.Version)
+}
+
+// artifactMetadataChanges lists the metadata fields that differ from one
+// artifact to another
+func artifactMetadataChanges(from, to *models.Artifact) []ArtifactMetadataChange {
+	changes := []ArtifactMetadataChange{}
+	compare := func(field string, a, b interface{}) {
+		if a != b {
+			changes = append(changes, ArtifactMetadataChange{Field: field, From: a, To: b})
+		}
+	}
+	compare("name", from.Name, to.Name)
+	compare("type", from.Type, to.Type)
+	compare("path", from.Path, to.Path)
+	compare("content_type", from.ContentType, to.ContentType)
+	compare("size", from.Size, to.Size)
+	compare("checksum", from.Checksum, to.Checksum)
+	compare("version", from.Version, to.Version)
+	return changes
+}
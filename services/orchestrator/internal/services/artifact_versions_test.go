@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+func setupArtifactServiceTest(t *testing.T, maxDiffBytes int) (*StorageAccountant, *ArtifactService) {
+	db, accountant, _ := setupStorageTest(t)
+	require.NoError(t, db.Exec(`UPDATE executions SET workflow_id = ? WHERE id = ?`, "wf-1", "exec-project-1").Error)
+	store, err := NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	return accountant, NewArtifactService(db, store, &config.ArtifactsConfig{MaxDiffBytes: maxDiffBytes}, zap.NewNop())
+}
+
+// storeVersion stores content as an artifact of wf-1 at artifactPath
+func storeVersion(t *testing.T, accountant *StorageAccountant, artifacts *ArtifactService, id, artifactPath, content string) {
+	checksum, err := artifacts.PutContent(context.Background(), []byte(content))
+	require.NoError(t, err)
+	record := artifact("project-1", id, "code", int64(len(content)))
+	record.WorkflowID, record.Path, record.Checksum = "wf-1", artifactPath, checksum
+	require.NoError(t, accountant.StoreArtifacts(context.Background(), "project-1", []models.Artifact{record}))
+}
+
+func TestArtifactService_VersionsByLogicalKey(t *testing.T) {
+	accountant, artifacts := setupArtifactServiceTest(t, 1024)
+	ctx := context.Background()
+
+	storeVersion(t, accountant, artifacts, "main-1", "src/main.go", "package main\n")
+	storeVersion(t, accountant, artifacts, "main-2", "./src/main.go", "package main\n\nfunc main() {}\n")
+	// Unchanged contents add no version
+	storeVersion(t, accountant, artifacts, "main-3", "src/main.go", "package main\n\nfunc main() {}\n")
+	storeVersion(t, accountant, artifacts, "readme-1", "README.md", "# App\n")
+
+	versions, err := artifacts.Versions(ctx, "main-1")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "src/main.go", versions[0].LogicalKey)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Nil(t, versions[0].ParentArtifactID)
+	assert.Equal(t, "main-2", versions[1].ID)
+	assert.Equal(t, 2, versions[1].Version)
+	require.NotNil(t, versions[1].ParentArtifactID)
+	assert.Equal(t, "main-1", *versions[1].ParentArtifactID)
+
+	groups, err := artifacts.ListWorkflowArtifacts(ctx, "wf-1")
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "src/main.go", groups[0].Key)
+	assert.Equal(t, "main-2", groups[0].Latest.ID)
+	assert.Equal(t, 2, groups[0].VersionCount)
+	assert.Equal(t, "README.md", groups[1].Key)
+	assert.Equal(t, 1, groups[1].VersionCount)
+
+	_, err = artifacts.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+}
+
+func TestArtifactService_Diff(t *testing.T) {
+	accountant, artifacts := setupArtifactServiceTest(t, 256)
+	ctx := context.Background()
+
+	storeVersion(t, accountant, artifacts, "main-1", "main.go", "package main\n\nfunc main() {}\n")
+	storeVersion(t, accountant, artifacts, "main-2", "main.go", "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n")
+
+	diff, err := artifacts.Diff(ctx, "main-2", "main-1")
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactDiffText, diff.Mode)
+	assert.Empty(t, diff.Reason)
+	assert.False(t, diff.Truncated)
+	assert.Contains(t, diff.Diff, "--- main.go (v1)\n+++ main.go (v2)\n")
+	assert.Contains(t, diff.Diff, "-func main() {}\n+func main() {\n+\tprintln(\"hi\")\n+}\n")
+	assert.Contains(t, diff.Changes, ArtifactMetadataChange{Field: "version", From: 1, To: 2})
+
+	// Binary contents are compared by metadata
+	storeVersion(t, accountant, artifacts, "logo-1", "logo.png", "\x89PNG\x00\x01")
+	storeVersion(t, accountant, artifacts, "logo-2", "logo.png", "\x89PNG\x00\x02")
+	diff, err = artifacts.Diff(ctx, "logo-2", "logo-1")
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactDiffMetadata, diff.Mode)
+	assert.Empty(t, diff.Diff)
+	assert.Equal(t, "artifact logo-1 is binary", diff.Reason)
+	assert.NotEmpty(t, diff.Changes)
+
+	// So are contents over the max diff bytes
+	storeVersion(t, accountant, artifacts, "big-1", "big.txt", strings.Repeat("a\n", 200))
+	diff, err = artifacts.Diff(ctx, "big-1", "main-1")
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactDiffMetadata, diff.Mode)
+	assert.Equal(t, "artifact big-1 is larger than 256 bytes", diff.Reason)
+
+	// Diffs over the max diff bytes are truncated
+	storeVersion(t, accountant, artifacts, "list-1", "list.txt", strings.Repeat("a\n", 60))
+	storeVersion(t, accountant, artifacts, "list-2", "list.txt", strings.Repeat("b\n", 60))
+	diff, err = artifacts.Diff(ctx, "list-2", "list-1")
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactDiffText, diff.Mode)
+	assert.True(t, diff.Truncated)
+	assert.Len(t, diff.Diff, 256)
+
+	_, err = artifacts.Diff(ctx, "main-2", "missing")
+	assert.ErrorIs(t, err, ErrArtifactNotFound)
+}
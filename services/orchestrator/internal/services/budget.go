@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidBudget is returned for workflow budgets, or project default
+// budgets, with negative limits or an unknown policy
+var ErrInvalidBudget = errors.New("invalid budget")
+
+// Events published on the workflow event channel of a project when the
+// estimates of an intent exceed its budget
+const (
+	IntentBudgetWarnedEvent   = "intent_budget_warned"
+	IntentBudgetExceededEvent = "intent_budget_exceeded"
+)
+
+var budgetChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_intent_budget_checks_total",
+	Help: "Intent estimates checked against a budget, by result (within, warned, exceeded)",
+}, []string{"result"})
+
+// BudgetDecision is the outcome of checking the estimates of an intent
+// against the budget of its workflow
+type BudgetDecision struct {
+	Budget        models.WorkflowBudget `json:"budget"`
+	EstimatedTime int                   `json:"estimated_time"` // Seconds
+	EstimatedCost float64               `json:"estimated_cost"`
+	// Exceeded describes the limits the estimates exceed; empty when they
+	// are within the budget
+	Exceeded []string            `json:"exceeded,omitempty"`
+	Policy   models.BudgetPolicy `json:"policy"`
+}
+
+// CheckBudget compares estimates with the limits of a budget. The decision
+// takes the budget's policy, or policy when it sets none.
+func CheckBudget(budget models.WorkflowBudget, estimatedTime int, estimatedCost float64, policy models.BudgetPolicy) BudgetDecision {
+	decision := BudgetDecision{
+		Budget:        budget,
+		EstimatedTime: estimatedTime,
+		EstimatedCost: estimatedCost,
+		Policy:        policy,
+	}
+	if budget.Policy != "" {
+		decision.Policy = budget.Policy
+	}
+	if budget.MaxCost > 0 && estimatedCost > budget.MaxCost {
+		decision.Exceeded = append(decision.Exceeded,
+			fmt.Sprintf("estimated cost %.2f exceeds max_cost %.2f", estimatedCost, budget.MaxCost))
+	}
+	if budget.MaxDurationSeconds > 0 && estimatedTime > budget.MaxDurationSeconds {
+		decision.Exceeded = append(decision.Exceeded,
+			fmt.Sprintf("estimated time %ds exceeds max_duration_seconds %d", estimatedTime, budget.MaxDurationSeconds))
+	}
+	return decision
+}
+
+// ValidateBudget checks that a budget has no negative limit and a known
+// policy
+func ValidateBudget(budget *models.WorkflowBudget) error {
+	if budget == nil {
+		return nil
+	}
+	if budget.MaxCost < 0 || budget.MaxDurationSeconds < 0 {
+		return fmt.Errorf("%w: limits must not be negative", ErrInvalidBudget)
+	}
+	if budget.Policy != "" && !budget.Policy.IsValid() {
+		return fmt.Errorf("%w: unknown policy %q, expected warn or fail", ErrInvalidBudget, budget.Policy)
+	}
+	return nil
+}
+
+// BudgetEnforcer records the estimates of analyzed intents on their workflow
+// and checks them against the workflow's budget, or its project's default
+// budget
+type BudgetEnforcer struct {
+	db     *gorm.DB
+	policy models.BudgetPolicy
+	events WorkflowEventPublisher
+	logger *zap.Logger
+}
+
+// NewBudgetEnforcer creates a budget enforcer applying the policy of cfg to
+// budgets that set none
+func NewBudgetEnforcer(db *gorm.DB, cfg *config.BudgetConfig, events WorkflowEventPublisher, logger *zap.Logger) *BudgetEnforcer {
+	return &BudgetEnforcer{
+		db:     db,
+		policy: models.BudgetPolicy(cfg.Policy),
+		events: events,
+		logger: logger,
+	}
+}
+
+// Check records the estimates of a workflow's intent and checks them
+// against its budget. The workflow keeps the budget that applied, which is
+// its project's default budget when it was started without one. Workflows
+// without a budget get no decision.
+func (b *BudgetEnforcer) Check(ctx context.Context, workflowID string, estimatedTime int, estimatedCost float64) (*BudgetDecision, error) {
+	var workflow models.Workflow
+	if err := b.db.WithContext(ctx).Select("id", "project_id", "budget").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+
+	budget := workflow.Budget
+	if budget.IsZero() {
+		projectBudget, err := b.projectBudget(ctx, workflow.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		budget = projectBudget
+	}
+
+	updates := map[string]interface{}{
+		"estimated_time": estimatedTime,
+		"estimated_cost": estimatedCost,
+	}
+	if !budget.IsZero() {
+		updates["budget"] = budget
+	}
+	if err := b.db.WithContext(ctx).Model(&models.Workflow{}).Where("id = ?", workflowID).
+		Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to record intent estimates: %w", err)
+	}
+	if budget.IsZero() {
+		return nil, nil
+	}
+
+	decision := CheckBudget(*budget, estimatedTime, estimatedCost, b.policy)
+	switch {
+	case len(decision.Exceeded) == 0:
+		budgetChecksTotal.WithLabelValues("within").Inc()
+	case decision.Policy == models.BudgetPolicyWarn:
+		budgetChecksTotal.WithLabelValues("warned").Inc()
+		b.publish(ctx, &workflow, IntentBudgetWarnedEvent, &decision)
+	default:
+		budgetChecksTotal.WithLabelValues("exceeded").Inc()
+		b.publish(ctx, &workflow, IntentBudgetExceededEvent, &decision)
+	}
+	if len(decision.Exceeded) > 0 {
+		b.logger.Warn("Intent estimates exceed budget",
+			zap.String("workflow_id", workflow.ID),
+			zap.String("policy", string(decision.Policy)),
+			zap.Strings("exceeded", decision.Exceeded))
+	}
+	return &decision, nil
+}
+
+// projectBudget returns the default budget of a project, or nil when it
+// sets none
+func (b *BudgetEnforcer) projectBudget(ctx context.Context, projectID string) (*models.WorkflowBudget, error) {
+	if projectID == "" {
+		return nil, nil
+	}
+	var project models.Project
+	err := b.db.WithContext(ctx).Select("id", "settings").First(&project, "id = ?", projectID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project settings: %w", err)
+	}
+	settings, err := models.ParseProjectSettings(project.Settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings.DefaultBudget, nil
+}
+
+// publish publishes a budget event on the workflow event channel of the
+// workflow's project. Events are best effort; failures are logged.
+func (b *BudgetEnforcer) publish(ctx context.Context, workflow *models.Workflow, eventType string, decision *BudgetDecision) {
+	if b.events == nil {
+		return
+	}
+	event, err := json.Marshal(map[string]interface{}{
+		"workflow_id": workflow.ID,
+		"project_id":  workflow.ProjectID,
+		"type":        models.WorkflowTypeIntent,
+		"event_type":  eventType,
+		"timestamp":   time.Now(),
+		"data":        decision,
+	})
+	if err != nil {
+		b.logger.Error("Failed to marshal budget event", zap.Error(err))
+		return
+	}
+	if err := b.events.Publish(ctx, workflow.ProjectID, event); err != nil {
+		b.logger.Warn("Failed to publish budget event",
+			zap.String("workflow_id", workflow.ID),
+			zap.String("event_type", eventType),
+			zap.Error(err))
+	}
+}
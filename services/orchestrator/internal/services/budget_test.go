@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"orchestrator/internal/models"
+)
+
+func TestCheckBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		budget   models.WorkflowBudget
+		policy   models.BudgetPolicy
+		exceeded []string
+	}{
+		{"within", models.WorkflowBudget{MaxCost: 5, MaxDurationSeconds: 900}, models.BudgetPolicyFail, nil},
+		{"unlimited duration", models.WorkflowBudget{MaxCost: 5}, models.BudgetPolicyFail, nil},
+		{
+			"cost exceeded", models.WorkflowBudget{MaxCost: 2}, models.BudgetPolicyFail,
+			[]string{"estimated cost 2.50 exceeds max_cost 2.00"},
+		},
+		{
+			"both exceeded, budget policy", models.WorkflowBudget{MaxCost: 2, MaxDurationSeconds: 300, Policy: models.BudgetPolicyWarn},
+			models.BudgetPolicyWarn,
+			[]string{"estimated cost 2.50 exceeds max_cost 2.00", "estimated time 600s exceeds max_duration_seconds 300"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := CheckBudget(tt.budget, 600, 2.5, models.BudgetPolicyFail)
+			assert.Equal(t, tt.policy, decision.Policy)
+			assert.Equal(t, tt.exceeded, decision.Exceeded)
+		})
+	}
+}
+
+func TestValidateBudget(t *testing.T) {
+	assert.NoError(t, ValidateBudget(nil))
+	assert.NoError(t, ValidateBudget(&models.WorkflowBudget{MaxCost: 5, Policy: models.BudgetPolicyWarn}))
+	assert.ErrorIs(t, ValidateBudget(&models.WorkflowBudget{MaxCost: -1}), ErrInvalidBudget)
+	assert.ErrorIs(t, ValidateBudget(&models.WorkflowBudget{Policy: "ignore"}), ErrInvalidBudget)
+
+	assert.NoError(t, validateProjectSettings(json.RawMessage(`{"default_budget": {"max_cost": 5}}`)))
+	assert.ErrorIs(t, validateProjectSettings(json.RawMessage(`{"default_budget": {"max_duration_seconds": -60}}`)), ErrInvalidBudget)
+
+	_, err := newWorkflowRecord(context.Background(), &StartWorkflowRequest{
+		Name:   "generate",
+		Type:   string(models.WorkflowTypeIntent),
+		Budget: &models.WorkflowBudget{MaxCost: 5, Policy: "stop"},
+	}, models.WorkflowStatusPending)
+	assert.ErrorIs(t, err, ErrInvalidBudget)
+
+	workflow, err := newWorkflowRecord(context.Background(), &StartWorkflowRequest{
+		Name:   "generate",
+		Type:   string(models.WorkflowTypeIntent),
+		Budget: &models.WorkflowBudget{},
+	}, models.WorkflowStatusPending)
+	require.NoError(t, err)
+	assert.Nil(t, workflow.Budget, "an empty budget leaves the project default to apply")
+}
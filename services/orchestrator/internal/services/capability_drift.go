@@ -0,0 +1,392 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// Events published on an agent's event channel when one of its capabilities
+// is flagged for drift or recovers
+const (
+	CapabilityDriftFlaggedEvent = "capability_drift_flagged"
+	CapabilityDriftClearedEvent = "capability_drift_cleared"
+)
+
+var (
+	capabilityDriftFlaggedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_capability_drift_flagged_total",
+		Help: "Agent capabilities flagged because their observed success rate fell below their advertised proficiency",
+	})
+	capabilityDriftClearedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_capability_drift_cleared_total",
+		Help: "Agent capability drift flags cleared after recovery",
+	})
+)
+
+// AgentEventPublisher publishes events on an agent's event channel
+type AgentEventPublisher interface {
+	Publish(ctx context.Context, agentID string, event []byte) error
+}
+
+// redisAgentEventPublisher publishes agent events on a Redis channel per
+// agent
+type redisAgentEventPublisher struct {
+	client *redis.Client
+}
+
+// NewRedisAgentEventPublisher creates an AgentEventPublisher publishing on
+// the Redis channel agent:events:<agent ID>
+func NewRedisAgentEventPublisher(client *redis.Client) AgentEventPublisher {
+	return &redisAgentEventPublisher{client: client}
+}
+
+func (r *redisAgentEventPublisher) Publish(ctx context.Context, agentID string, event []byte) error {
+	return RedisGuardFor(r.client, nil).Publish(ctx, fmt.Sprintf("agent:events:%s", agentID), event)
+}
+
+// TaskOutcome is whether an agent ran a task requiring some capabilities
+// successfully
+type TaskOutcome struct {
+	TaskID       string
+	AgentID      string
+	Capabilities []string
+	Succeeded    bool
+}
+
+// CapabilityDriftDetector compares the recent task success rate of each
+// capability an agent advertises with the proficiency it advertises, and
+// flags the capabilities falling short. Flags are kept until enough new
+// outcomes show the capability recovered, and scale the capability down in
+// agent matching when down-weighting is enabled.
+type CapabilityDriftDetector struct {
+	db       *gorm.DB
+	agents   AgentExecutor
+	events   AgentEventPublisher
+	logger   *zap.Logger
+	config   config.CapabilityDriftConfig
+	interval time.Duration
+	window   time.Duration
+	now      func() time.Time
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewCapabilityDriftDetector creates a capability drift detector checking
+// the agents listed by agents. Events may be nil, in which case flags are
+// only logged.
+func NewCapabilityDriftDetector(db *gorm.DB, agents AgentExecutor, events AgentEventPublisher, cfg *config.CapabilityDriftConfig, logger *zap.Logger) *CapabilityDriftDetector {
+	return &CapabilityDriftDetector{
+		db:       db,
+		agents:   agents,
+		events:   events,
+		logger:   logger,
+		config:   *cfg,
+		interval: time.Duration(cfg.CheckInterval) * time.Second,
+		window:   time.Duration(cfg.Window) * time.Second,
+		now:      time.Now,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start starts checking agents periodically
+func (d *CapabilityDriftDetector) Start() {
+	d.wg.Add(1)
+	go d.run()
+	d.logger.Info("Capability drift detector started", zap.Duration("check_interval", d.interval))
+}
+
+// Stop stops the periodic checks
+func (d *CapabilityDriftDetector) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopChan)
+		d.wg.Wait()
+		d.logger.Info("Capability drift detector stopped")
+	})
+}
+
+func (d *CapabilityDriftDetector) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.stopChan:
+			return
+		}
+
+		flagged, cleared, err := d.Check(context.Background())
+		if err != nil {
+			d.logger.Error("Failed to check agent capability drift", zap.Error(err))
+			continue
+		}
+		if flagged > 0 || cleared > 0 {
+			d.logger.Info("Agent capability drift checked",
+				zap.Int("flagged", flagged),
+				zap.Int("cleared", cleared))
+		}
+	}
+}
+
+// RecordOutcomes records the outcomes of a workflow's tasks for each
+// capability they required. Tasks already recorded for the workflow are
+// skipped, so a retried activity counts each task once.
+func (d *CapabilityDriftDetector) RecordOutcomes(ctx context.Context, workflowID string, outcomes []TaskOutcome) error {
+	var rows []models.AgentTaskOutcome
+	for _, outcome := range outcomes {
+		if outcome.AgentID == "" {
+			continue
+		}
+		seen := make(map[string]bool, len(outcome.Capabilities))
+		for _, capability := range outcome.Capabilities {
+			capability = strings.ToLower(capability)
+			if capability == "" || seen[capability] {
+				continue
+			}
+			seen[capability] = true
+			rows = append(rows, models.AgentTaskOutcome{
+				WorkflowID: workflowID,
+				TaskID:     outcome.TaskID,
+				Capability: capability,
+				AgentID:    outcome.AgentID,
+				Succeeded:  outcome.Succeeded,
+				CreatedAt:  d.now(),
+			})
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if err := d.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, 100).Error; err != nil {
+		return fmt.Errorf("failed to record task outcomes: %w", err)
+	}
+	return nil
+}
+
+// capabilityStat counts an agent's recent outcomes for one capability
+type capabilityStat struct {
+	AgentID    string
+	Capability string
+	Samples    int64
+	Successes  int64
+}
+
+// Check compares the capabilities of every agent with their recent
+// outcomes, flagging the capabilities that drifted and clearing the flags
+// of those that recovered or are no longer advertised. It returns the
+// number of flags raised and cleared.
+func (d *CapabilityDriftDetector) Check(ctx context.Context) (int, int, error) {
+	agents, err := d.listAgents(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(agents) == 0 {
+		return 0, 0, nil
+	}
+	agentIDs := make([]string, len(agents))
+	for i, agent := range agents {
+		agentIDs[i] = agent.ID
+	}
+
+	var stats []capabilityStat
+	if err := d.db.WithContext(ctx).Model(&models.AgentTaskOutcome{}).
+		Select("agent_id, capability, COUNT(*) AS samples, SUM(CASE WHEN succeeded THEN 1 ELSE 0 END) AS successes").
+		Where("agent_id IN ? AND created_at >= ?", agentIDs, d.now().Add(-d.window)).
+		Group("agent_id, capability").
+		Scan(&stats).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to count task outcomes: %w", err)
+	}
+	statsByKey := make(map[string]capabilityStat, len(stats))
+	for _, stat := range stats {
+		statsByKey[stat.AgentID+"/"+stat.Capability] = stat
+	}
+
+	var existing []models.AgentCapabilityFlag
+	if err := d.db.WithContext(ctx).Where("agent_id IN ?", agentIDs).Find(&existing).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to list capability flags: %w", err)
+	}
+	flags := make(map[string]models.AgentCapabilityFlag, len(existing))
+	for _, flag := range existing {
+		flags[flag.AgentID+"/"+flag.Capability] = flag
+	}
+
+	flagged, cleared := 0, 0
+	for _, agent := range agents {
+		for _, capability := range agent.Capabilities {
+			name := strings.ToLower(capability.Name)
+			key := agent.ID + "/" + name
+			flag, wasFlagged := flags[key]
+			delete(flags, key)
+
+			// Without enough recent outcomes a capability keeps its state
+			stat, ok := statsByKey[key]
+			if !ok || stat.Samples < int64(d.config.MinSamples) {
+				continue
+			}
+
+			proficiency := advertisedProficiency(capability)
+			rate := float64(stat.Successes) / float64(stat.Samples)
+			if rate >= proficiency*d.config.Threshold {
+				if wasFlagged {
+					flag.ObservedSuccessRate, flag.Samples = rate, stat.Samples
+					if err := d.clear(ctx, flag); err != nil {
+						return flagged, cleared, err
+					}
+					cleared++
+				}
+				continue
+			}
+
+			weight := 1.0
+			if d.config.DownWeight {
+				weight = rate / proficiency
+			}
+			if !wasFlagged {
+				flag.FlaggedAt = d.now()
+			}
+			flag.AgentID, flag.Capability = agent.ID, name
+			flag.AdvertisedProficiency, flag.ObservedSuccessRate = proficiency, rate
+			flag.Samples, flag.Weight, flag.UpdatedAt = stat.Samples, weight, d.now()
+			if err := d.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&flag).Error; err != nil {
+				return flagged, cleared, fmt.Errorf("failed to flag capability: %w", err)
+			}
+			if !wasFlagged {
+				capabilityDriftFlaggedTotal.Inc()
+				d.logger.Warn("Agent capability drifted",
+					zap.String("agent_id", agent.ID),
+					zap.String("capability", name),
+					zap.Float64("advertised_proficiency", proficiency),
+					zap.Float64("observed_success_rate", rate),
+					zap.Int64("samples", stat.Samples))
+				d.publish(ctx, CapabilityDriftFlaggedEvent, flag)
+				flagged++
+			}
+		}
+	}
+
+	// The agents no longer advertise what is left
+	for _, flag := range flags {
+		if err := d.clear(ctx, flag); err != nil {
+			return flagged, cleared, err
+		}
+		cleared++
+	}
+	return flagged, cleared, nil
+}
+
+// Flags returns the capability flags of agents by agent ID
+func (d *CapabilityDriftDetector) Flags(ctx context.Context, agentIDs ...string) (map[string][]models.AgentCapabilityFlag, error) {
+	var flags []models.AgentCapabilityFlag
+	if err := d.db.WithContext(ctx).Where("agent_id IN ?", agentIDs).
+		Order("agent_id, capability").Find(&flags).Error; err != nil {
+		return nil, fmt.Errorf("failed to list capability flags: %w", err)
+	}
+	byAgent := make(map[string][]models.AgentCapabilityFlag)
+	for _, flag := range flags {
+		byAgent[flag.AgentID] = append(byAgent[flag.AgentID], flag)
+	}
+	return byAgent, nil
+}
+
+// annotate sets the capability flags of agents
+func (d *CapabilityDriftDetector) annotate(ctx context.Context, agents []Agent) error {
+	if len(agents) == 0 {
+		return nil
+	}
+	agentIDs := make([]string, len(agents))
+	for i, agent := range agents {
+		agentIDs[i] = agent.ID
+	}
+	flags, err := d.Flags(ctx, agentIDs...)
+	if err != nil {
+		return err
+	}
+	for i := range agents {
+		agents[i].CapabilityFlags = flags[agents[i].ID]
+	}
+	return nil
+}
+
+// listAgents lists every agent, up to maxClientFilteredAgents
+func (d *CapabilityDriftDetector) listAgents(ctx context.Context) ([]Agent, error) {
+	var agents []Agent
+	for page := 1; len(agents) < maxClientFilteredAgents; page++ {
+		list, err := d.agents.ListAgents(ctx, &AgentFilters{Page: page, PageSize: agentListFetchPageSize, Fresh: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list agents: %w", err)
+		}
+		agents = append(agents, list.Agents...)
+		if !list.HasMore || len(list.Agents) == 0 {
+			break
+		}
+	}
+	return agents, nil
+}
+
+// clear removes a capability flag and publishes its clearing
+func (d *CapabilityDriftDetector) clear(ctx context.Context, flag models.AgentCapabilityFlag) error {
+	if err := d.db.WithContext(ctx).Delete(&models.AgentCapabilityFlag{}, "agent_id = ? AND capability = ?", flag.AgentID, flag.Capability).Error; err != nil {
+		return fmt.Errorf("failed to clear capability flag: %w", err)
+	}
+	capabilityDriftClearedTotal.Inc()
+	d.logger.Info("Agent capability drift cleared",
+		zap.String("agent_id", flag.AgentID),
+		zap.String("capability", flag.Capability))
+	d.publish(ctx, CapabilityDriftClearedEvent, flag)
+	return nil
+}
+
+// publish publishes a capability drift event on the agent's channel
+func (d *CapabilityDriftDetector) publish(ctx context.Context, eventType string, flag models.AgentCapabilityFlag) {
+	if d.events == nil {
+		return
+	}
+
+	event, err := json.Marshal(map[string]interface{}{
+		"agent_id":   flag.AgentID,
+		"event_type": eventType,
+		"timestamp":  d.now(),
+		"data": map[string]interface{}{
+			"capability":             flag.Capability,
+			"advertised_proficiency": flag.AdvertisedProficiency,
+			"observed_success_rate":  flag.ObservedSuccessRate,
+			"samples":                flag.Samples,
+			"weight":                 flag.Weight,
+		},
+	})
+	if err != nil {
+		d.logger.Error("failed to marshal capability drift event", zap.Error(err))
+		return
+	}
+	if err := d.events.Publish(ctx, flag.AgentID, event); err != nil {
+		d.logger.Error("failed to publish capability drift event", zap.Error(err))
+	}
+}
+
+// advertisedProficiency is the success rate an agent claims for a
+// capability; capabilities without a proficiency claim to always succeed
+func advertisedProficiency(capability Capability) float64 {
+	if capability.Proficiency <= 0 || capability.Proficiency > 1 {
+		return 1
+	}
+	return capability.Proficiency
+}
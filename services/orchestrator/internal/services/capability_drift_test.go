@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// memoryAgentEvents is an in-memory AgentEventPublisher
+type memoryAgentEvents struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (m *memoryAgentEvents) Publish(ctx context.Context, agentID string, event []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(event, &decoded); err != nil {
+		return err
+	}
+	m.events = append(m.events, decoded)
+	return nil
+}
+
+// driftAgentManager serves a fixed set of agents whose capabilities can be
+// changed, as a heartbeat reporting new capabilities would
+type driftAgentManager struct {
+	mu     sync.Mutex
+	agents []Agent
+}
+
+func (m *driftAgentManager) setCapabilities(agentID string, capabilities ...Capability) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.agents {
+		if m.agents[i].ID == agentID {
+			m.agents[i].Capabilities = capabilities
+		}
+	}
+}
+
+func (m *driftAgentManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/api/v1/agents" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"agents": m.agents, "total_count": len(m.agents)})
+		return
+	}
+	for _, agent := range m.agents {
+		if r.URL.Path == "/api/v1/agents/"+agent.ID {
+			json.NewEncoder(w).Encode(agent)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func setupDriftTest(t *testing.T, downWeight bool) (*CapabilityDriftDetector, *AgentClient, *driftAgentManager, *memoryAgentEvents) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.AgentTaskOutcome{}, &models.AgentCapabilityFlag{}))
+
+	manager := &driftAgentManager{agents: []Agent{
+		{ID: "agent-1", Capabilities: []Capability{{Name: "Go", Proficiency: 0.9}, {Name: "python"}}},
+		{ID: "agent-2", Capabilities: []Capability{{Name: "go"}}},
+	}}
+	server := httptest.NewServer(manager)
+	t.Cleanup(server.Close)
+	client, err := NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+	require.NoError(t, err)
+
+	events := &memoryAgentEvents{}
+	detector := NewCapabilityDriftDetector(db, client, events, &config.CapabilityDriftConfig{
+		CheckInterval: 60,
+		Window:        7 * 24 * 60 * 60,
+		MinSamples:    10,
+		Threshold:     0.8,
+		DownWeight:    downWeight,
+	}, zap.NewNop())
+	client.SetCapabilityDrift(detector)
+	return detector, client, manager, events
+}
+
+// recordOutcomes records tasks of one workflow requiring a capability,
+// the first succeeded of them successful
+func recordOutcomes(t *testing.T, detector *CapabilityDriftDetector, workflowID, agentID, capability string, tasks, succeeded int) {
+	outcomes := make([]TaskOutcome, tasks)
+	for i := range outcomes {
+		outcomes[i] = TaskOutcome{
+			TaskID:       fmt.Sprintf("task-%d", i),
+			AgentID:      agentID,
+			Capabilities: []string{capability, strings.ToUpper(capability)},
+			Succeeded:    i < succeeded,
+		}
+	}
+	require.NoError(t, detector.RecordOutcomes(context.Background(), workflowID, outcomes))
+}
+
+func TestCapabilityDriftDetector_FlagsAndClearsDrift(t *testing.T) {
+	detector, client, _, events := setupDriftTest(t, true)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return now }
+
+	// agent-1 succeeds at half of its Go tasks against an advertised 0.9;
+	// retried recording counts each task once
+	recordOutcomes(t, detector, "wf-1", "agent-1", "go", 10, 5)
+	recordOutcomes(t, detector, "wf-1", "agent-1", "go", 10, 5)
+	recordOutcomes(t, detector, "wf-1", "agent-1", "python", 10, 9)
+	// Too few outcomes to judge agent-2
+	recordOutcomes(t, detector, "wf-1", "agent-2", "go", 3, 0)
+
+	flagged, cleared, err := detector.Check(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, flagged)
+	assert.Zero(t, cleared)
+
+	require.Len(t, events.events, 1)
+	assert.Equal(t, CapabilityDriftFlaggedEvent, events.events[0]["event_type"])
+	assert.Equal(t, "agent-1", events.events[0]["agent_id"])
+	data := events.events[0]["data"].(map[string]interface{})
+	assert.Equal(t, "go", data["capability"])
+	assert.Equal(t, 0.5, data["observed_success_rate"])
+	assert.Equal(t, 10.0, data["samples"])
+
+	// The flag surfaces on the agent
+	agent, err := client.GetAgent(ctx, "agent-1")
+	require.NoError(t, err)
+	require.Len(t, agent.CapabilityFlags, 1)
+	flag := agent.CapabilityFlags[0]
+	assert.Equal(t, "go", flag.Capability)
+	assert.Equal(t, 0.9, flag.AdvertisedProficiency)
+	assert.InDelta(t, 0.5/0.9, flag.Weight, 1e-9)
+	assert.True(t, now.Equal(flag.FlaggedAt))
+
+	// and down-weights the capability in matching
+	list, err := client.ListAgents(ctx, &AgentFilters{RequiredCapabilities: []string{"go"}})
+	require.NoError(t, err)
+	require.Len(t, list.Agents, 2)
+	assert.Equal(t, "agent-2", list.Agents[0].ID)
+	assert.Equal(t, 1.0, *list.Agents[0].MatchScore)
+	assert.InDelta(t, 0.5/0.9, *list.Agents[1].MatchScore, 1e-9)
+	assert.InDelta(t, (1+0.5/0.9)/2, CapabilityMatchScore(*agent, []string{"python", "go"}), 1e-9)
+
+	// Checking again changes nothing
+	flagged, cleared, err = detector.Check(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, flagged+cleared)
+
+	// Once the failures age out of the window, recent successes clear the flag
+	now = now.Add(8 * 24 * time.Hour)
+	recordOutcomes(t, detector, "wf-2", "agent-1", "go", 10, 10)
+	flagged, cleared, err = detector.Check(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, flagged)
+	assert.Equal(t, 1, cleared)
+
+	require.Len(t, events.events, 2)
+	assert.Equal(t, CapabilityDriftClearedEvent, events.events[1]["event_type"])
+	agent, err = client.GetAgent(ctx, "agent-1")
+	require.NoError(t, err)
+	assert.Empty(t, agent.CapabilityFlags)
+	assert.Equal(t, 1.0, CapabilityMatchScore(*agent, []string{"go"}))
+}
+
+func TestCapabilityDriftDetector_FlagsWithoutDownWeighting(t *testing.T) {
+	detector, client, manager, events := setupDriftTest(t, false)
+	ctx := context.Background()
+
+	// agent-2 advertises no proficiency, so it claims to always succeed
+	recordOutcomes(t, detector, "wf-1", "agent-2", "go", 10, 7)
+	flagged, _, err := detector.Check(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, flagged)
+
+	agent, err := client.GetAgent(ctx, "agent-2")
+	require.NoError(t, err)
+	require.Len(t, agent.CapabilityFlags, 1)
+	assert.Equal(t, 1.0, agent.CapabilityFlags[0].Weight)
+	assert.Equal(t, 1.0, CapabilityMatchScore(*agent, []string{"go"}))
+
+	// A refreshed capability set without the capability clears its flag
+	manager.setCapabilities("agent-2", Capability{Name: "rust"})
+	_, cleared, err := detector.Check(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cleared)
+	require.Len(t, events.events, 2)
+	assert.Equal(t, CapabilityDriftClearedEvent, events.events[1]["event_type"])
+
+	flags, err := detector.Flags(ctx, "agent-1", "agent-2")
+	require.NoError(t, err)
+	assert.Empty(t, flags)
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrNoAgentInRegion is returned when no available agent runs in the
+	// region a workflow is pinned to
+	ErrNoAgentInRegion = errors.New("no agent in region")
+	// ErrNoRegionBucket is returned when storing the artifacts of a workflow
+	// pinned to a region no bucket is configured for
+	ErrNoRegionBucket = errors.New("no artifact bucket for region")
+	// ErrInvalidRegion is returned for project settings pinning a region
+	// that is not a region name
+	ErrInvalidRegion = errors.New("invalid region")
+)
+
+// RegionMetadataKey is the workflow metadata key of the data residency
+// region a workflow runs in, the region its project pinned when it started.
+// Running workflows keep that region whatever later changes to the pin.
+const RegionMetadataKey = "region"
+
+// regionPattern matches region names such as eu-west-1
+var regionPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// NormalizeRegion returns a region name as regions are compared
+func NormalizeRegion(region string) string {
+	return strings.ToLower(strings.TrimSpace(region))
+}
+
+// ValidateRegion checks the region a project pins, if any
+func ValidateRegion(region string) error {
+	if region == "" {
+		return nil
+	}
+	if len(region) > 64 || !regionPattern.MatchString(region) {
+		return fmt.Errorf("%w: %q is not a lower-case region name such as eu-west-1", ErrInvalidRegion, region)
+	}
+	return nil
+}
+
+// FilterAgentsByRegion keeps the agents running in a region; an empty region
+// keeps every agent. Agents reporting no region never run in one. Without
+// any agent in the region, the error wraps ErrNoAgentInRegion and lists the
+// regions the agents run in.
+func FilterAgentsByRegion(agents []Agent, region string) ([]Agent, error) {
+	region = NormalizeRegion(region)
+	if region == "" {
+		return agents, nil
+	}
+	var kept []Agent
+	available := make(map[string]bool)
+	for _, agent := range agents {
+		agentRegion := NormalizeRegion(agent.Region)
+		if agentRegion == region {
+			kept = append(kept, agent)
+		} else if agentRegion != "" {
+			available[agentRegion] = true
+		}
+	}
+	if len(kept) > 0 {
+		return kept, nil
+	}
+
+	regions := make([]string, 0, len(available))
+	for agentRegion := range available {
+		regions = append(regions, agentRegion)
+	}
+	sort.Strings(regions)
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("%w %s: no available agent reports a region", ErrNoAgentInRegion, region)
+	}
+	return nil, fmt.Errorf("%w %s: agents are available in %s", ErrNoAgentInRegion, region, strings.Join(regions, ", "))
+}
+
+// WorkflowRegion returns the region a workflow runs in, from its metadata;
+// "" when it is not pinned to one
+func WorkflowRegion(metadata json.RawMessage) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(metadata, &fields); err != nil {
+		return ""
+	}
+	var region string
+	if err := json.Unmarshal(fields[RegionMetadataKey], &region); err != nil {
+		return ""
+	}
+	return region
+}
+
+type regionContextKey struct{}
+
+// WithRegion returns a context storing artifact contents in the bucket of a
+// region; an empty region leaves the context as is
+func WithRegion(ctx context.Context, region string) context.Context {
+	if region = NormalizeRegion(region); region == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, regionContextKey{}, region)
+}
+
+// RegionFromContext returns the region WithRegion set, "" when none is
+func RegionFromContext(ctx context.Context) string {
+	region, _ := ctx.Value(regionContextKey{}).(string)
+	return region
+}
+
+// DataResidency resolves the regions projects pin their workflows to
+type DataResidency struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// NewDataResidency creates the data residency service
+func NewDataResidency(db *gorm.DB, logger *zap.Logger) *DataResidency {
+	return &DataResidency{db: db, logger: logger}
+}
+
+// ProjectRegion returns the region a project pins, "" when it pins none, or
+// ErrProjectNotFound
+func (r *DataResidency) ProjectRegion(ctx context.Context, projectID string) (string, error) {
+	var project models.Project
+	err := r.db.WithContext(ctx).Select("id", "settings").First(&project, "id = ?", projectID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrProjectNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get project settings: %w", err)
+	}
+	settings, err := models.ParseProjectSettings(project.Settings)
+	if err != nil {
+		return "", err
+	}
+	return NormalizeRegion(settings.Region), nil
+}
+
+// SetDataResidency makes the engine record in the metadata of workflows the
+// region their project pins
+func (e *WorkflowEngine) SetDataResidency(residency *DataResidency) {
+	e.residency = residency
+}
+
+// applyRegion records in the metadata of a workflow the region its project
+// pins. Workflows of projects not stored yet, or pinning none, run anywhere.
+func (e *WorkflowEngine) applyRegion(ctx context.Context, workflow *models.Workflow) error {
+	if e.residency == nil {
+		return nil
+	}
+	region, err := e.residency.ProjectRegion(ctx, workflow.ProjectID)
+	if errors.Is(err, ErrProjectNotFound) || (err == nil && region == "") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]json.RawMessage{}
+	if len(workflow.Metadata) > 0 {
+		if err := json.Unmarshal(workflow.Metadata, &metadata); err != nil {
+			return fmt.Errorf("failed to parse workflow metadata: %w", err)
+		}
+	}
+	metadata[RegionMetadataKey], _ = json.Marshal(region)
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow metadata: %w", err)
+	}
+	workflow.Metadata = data
+	e.logger.Debug("Workflow pinned to region",
+		zap.String("project_id", workflow.ProjectID),
+		zap.String("region", region))
+	return nil
+}
+
+// regionalOutputStore keeps the contents stored with a region in their
+// context in the bucket of the region, and the others in the default store
+type regionalOutputStore struct {
+	buckets  map[string]OutputStore
+	fallback OutputStore
+}
+
+// NewRegionalOutputStore routes contents to the bucket of the region in
+// their context, by normalized region name, and to fallback outside
+// regions. Storing in a region without a bucket fails with
+// ErrNoRegionBucket rather than leaving the region.
+func NewRegionalOutputStore(buckets map[string]OutputStore, fallback OutputStore) OutputStore {
+	normalized := make(map[string]OutputStore, len(buckets))
+	for region, bucket := range buckets {
+		normalized[NormalizeRegion(region)] = bucket
+	}
+	return &regionalOutputStore{buckets: normalized, fallback: fallback}
+}
+
+func (s *regionalOutputStore) Put(ctx context.Context, key string, data []byte) error {
+	region := RegionFromContext(ctx)
+	if region == "" {
+		return s.fallback.Put(ctx, key, data)
+	}
+	bucket, ok := s.buckets[region]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrNoRegionBucket, region)
+	}
+	return bucket.Put(ctx, key, data)
+}
+
+// Get reads from the bucket of the context's region first. Readers such as
+// the API do not know the region contents were stored in; keys being content
+// hashes, any store holding a key holds its content, so the others are tried
+// in turn.
+func (s *regionalOutputStore) Get(ctx context.Context, key string) ([]byte, error) {
+	stores := make([]OutputStore, 0, len(s.buckets)+1)
+	if bucket, ok := s.buckets[RegionFromContext(ctx)]; ok {
+		stores = append(stores, bucket)
+	}
+	stores = append(stores, s.fallback)
+	regions := make([]string, 0, len(s.buckets))
+	for region := range s.buckets {
+		if region != RegionFromContext(ctx) {
+			regions = append(regions, region)
+		}
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		stores = append(stores, s.buckets[region])
+	}
+
+	for _, store := range stores {
+		data, err := store.Get(ctx, key)
+		if !errors.Is(err, ErrOutputNotFound) {
+			return data, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrOutputNotFound, key)
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func TestFilterAgentsByRegion(t *testing.T) {
+	agents := []Agent{
+		{ID: "eu-1", Region: "eu-west-1"},
+		{ID: "eu-2", Region: "EU-West-1"},
+		{ID: "us-1", Region: "us-east-1"},
+		{ID: "ap-1", Region: "ap-south-1"},
+		{ID: "unknown"},
+	}
+	ids := func(agents []Agent) []string {
+		var ids []string
+		for _, agent := range agents {
+			ids = append(ids, agent.ID)
+		}
+		return ids
+	}
+
+	kept, err := FilterAgentsByRegion(agents, "")
+	require.NoError(t, err)
+	assert.Len(t, kept, 5, "without a region every agent is kept")
+
+	kept, err = FilterAgentsByRegion(agents, " eu-west-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eu-1", "eu-2"}, ids(kept), "regions compare case-insensitively")
+
+	_, err = FilterAgentsByRegion(agents, "eu-central-1")
+	assert.ErrorIs(t, err, ErrNoAgentInRegion)
+	assert.EqualError(t, err, "no agent in region eu-central-1: agents are available in ap-south-1, eu-west-1, us-east-1")
+
+	_, err = FilterAgentsByRegion([]Agent{{ID: "unknown"}}, "eu-west-1")
+	assert.ErrorIs(t, err, ErrNoAgentInRegion)
+	assert.EqualError(t, err, "no agent in region eu-west-1: no available agent reports a region",
+		"agents reporting no region never run in one")
+}
+
+func TestValidateProjectSettings_Region(t *testing.T) {
+	assert.NoError(t, validateProjectSettings(json.RawMessage(`{"region": "eu-west-1"}`)))
+	assert.NoError(t, validateProjectSettings(json.RawMessage(`{}`)))
+	for _, region := range []string{"EU-West-1", "eu west", "-eu", "eu_west_1"} {
+		settings, err := json.Marshal(map[string]string{"region": region})
+		require.NoError(t, err)
+		assert.ErrorIs(t, validateProjectSettings(settings), ErrInvalidRegion, region)
+	}
+}
+
+func TestRegionalOutputStore_RoutesByRegion(t *testing.T) {
+	ctx := context.Background()
+	newStore := func() OutputStore {
+		store, err := NewFileOutputStore(t.TempDir())
+		require.NoError(t, err)
+		return store
+	}
+	eu, us, fallback := newStore(), newStore(), newStore()
+	store := NewRegionalOutputStore(map[string]OutputStore{"eu-west-1": eu, "US-EAST-1": us}, fallback)
+
+	require.NoError(t, store.Put(WithRegion(ctx, "eu-west-1"), "eu-content", []byte("eu")))
+	require.NoError(t, store.Put(WithRegion(ctx, "us-east-1"), "us-content", []byte("us")))
+	require.NoError(t, store.Put(ctx, "any-content", []byte("any")))
+
+	stored := func(bucket OutputStore, key string) bool {
+		_, err := bucket.Get(ctx, key)
+		return err == nil
+	}
+	assert.True(t, stored(eu, "eu-content"))
+	assert.False(t, stored(fallback, "eu-content"), "pinned contents stay in their region")
+	assert.False(t, stored(us, "eu-content"))
+	assert.True(t, stored(us, "us-content"), "bucket regions are normalized")
+	assert.True(t, stored(fallback, "any-content"))
+	assert.False(t, stored(eu, "any-content"))
+
+	err := store.Put(WithRegion(ctx, "ap-south-1"), "ap-content", []byte("ap"))
+	assert.ErrorIs(t, err, ErrNoRegionBucket, "contents never leave a region without a bucket")
+	assert.False(t, stored(fallback, "ap-content"))
+
+	// Readers not knowing the region find contents in any bucket
+	data, err := store.Get(ctx, "eu-content")
+	require.NoError(t, err)
+	assert.Equal(t, "eu", string(data))
+	data, err = store.Get(WithRegion(ctx, "us-east-1"), "any-content")
+	require.NoError(t, err)
+	assert.Equal(t, "any", string(data))
+	_, err = store.Get(ctx, "missing-content")
+	assert.ErrorIs(t, err, ErrOutputNotFound)
+}
+
+func TestWorkflowEngine_StartWorkflowRecordsRegion(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, settings) VALUES
+			('project-eu', CAST('{"region": "eu-west-1"}' AS BLOB)), ('project-any', NULL)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	engine.SetDataResidency(NewDataResidency(db, zap.NewNop()))
+
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(run, nil)
+
+	start := func(projectID string) *models.Workflow {
+		response, err := engine.StartWorkflow(ctx, &StartWorkflowRequest{
+			Name: "tasks", Type: string(models.WorkflowTypeTaskExecution), ProjectID: projectID, UserID: "alice",
+		})
+		require.NoError(t, err)
+		var workflow models.Workflow
+		require.NoError(t, db.First(&workflow, "id = ?", response.WorkflowID).Error)
+		return &workflow
+	}
+
+	pinned := start("project-eu")
+	assert.JSONEq(t, `{"region": "eu-west-1"}`, string(pinned.Metadata))
+	assert.Equal(t, "eu-west-1", WorkflowRegion(pinned.Metadata))
+	assert.Empty(t, WorkflowRegion(start("project-any").Metadata))
+	assert.Empty(t, WorkflowRegion(start("project-unknown").Metadata))
+
+	// Workflows keep the region they started in
+	require.NoError(t, db.Exec(`UPDATE projects SET settings = ? WHERE id = 'project-eu'`,
+		[]byte(`{"region": "eu-central-1"}`)).Error)
+	assert.Equal(t, "eu-central-1", WorkflowRegion(start("project-eu").Metadata))
+	require.NoError(t, db.First(pinned, "id = ?", pinned.ID).Error)
+	assert.Equal(t, "eu-west-1", WorkflowRegion(pinned.Metadata))
+}
@@ -0,0 +1,627 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// DependencyScannerName names the dependency scanner in findings
+const DependencyScannerName = "osv"
+
+// Ecosystems of the dependencies scanned, as named by OSV
+const (
+	EcosystemGo  = "Go"
+	EcosystemNPM = "npm"
+)
+
+// Dependency is a package version a manifest depends on
+type Dependency struct {
+	Ecosystem string
+	Name      string
+	Version   string // Without a "v" prefix
+	File      string // Manifest declaring it
+}
+
+func (d Dependency) key() string {
+	return d.Ecosystem + "/" + d.Name + "@" + d.Version
+}
+
+// dependencyScanner checks the dependencies declared in go.mod and
+// package-lock.json files against the OSV vulnerability database. Results are
+// cached on disk for the cache TTL; when OSV cannot be reached, expired
+// results are used and dependencies without any are reported as unchecked.
+type dependencyScanner struct {
+	client    *http.Client
+	baseURL   string
+	cacheDir  string
+	cacheTTL  time.Duration
+	batchSize int
+	logger    *zap.Logger
+	now       func() time.Time
+}
+
+// NewDependencyScanner creates a scanner querying the OSV API at
+// cfg.OSVURL
+func NewDependencyScanner(cfg *config.SecurityAnalysisConfig, logger *zap.Logger) SecurityScanner {
+	return &dependencyScanner{
+		client:    &http.Client{Timeout: time.Duration(cfg.OSVTimeout) * time.Second},
+		baseURL:   strings.TrimSuffix(cfg.OSVURL, "/"),
+		cacheDir:  cfg.CacheDir,
+		cacheTTL:  time.Duration(cfg.CacheTTL) * time.Second,
+		batchSize: cfg.BatchSize,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+func (s *dependencyScanner) Name() string {
+	return DependencyScannerName
+}
+
+// Scan checks the dependencies of every manifest in content
+func (s *dependencyScanner) Scan(ctx context.Context, content map[string]string) (*SecurityScan, error) {
+	dependencies, err := ParseDependencies(content)
+	if err != nil {
+		return nil, err
+	}
+	scan := &SecurityScan{Findings: []SecurityFinding{}}
+	if len(dependencies) == 0 {
+		return scan, nil
+	}
+
+	ids, unchecked := s.vulnerabilityIDs(ctx, dependencies)
+	details := make(map[string]*osvVulnerability)
+	for _, dependency := range dependencies {
+		for _, id := range ids[dependency.key()] {
+			if _, ok := details[id]; ok {
+				continue
+			}
+			vuln, err := s.vulnerability(ctx, id)
+			if err != nil {
+				s.logger.Warn("Failed to get vulnerability details", zap.String("id", id), zap.Error(err))
+				unchecked++
+			}
+			details[id] = vuln
+		}
+	}
+
+	for _, dependency := range dependencies {
+		for _, id := range ids[dependency.key()] {
+			scan.Findings = append(scan.Findings, vulnerabilityFinding(dependency, id, details[id]))
+		}
+	}
+	if unchecked > 0 {
+		scan.Degraded = true
+		scan.DegradedReason = fmt.Sprintf("%d dependencies or vulnerabilities could not be checked against OSV", unchecked)
+	}
+	return scan, nil
+}
+
+// ParseDependencies returns the dependencies declared in the go.mod and
+// package-lock.json files of content, sorted and without duplicates
+func ParseDependencies(content map[string]string) ([]Dependency, error) {
+	seen := make(map[string]bool)
+	var dependencies []Dependency
+	for name, data := range content {
+		var parsed []Dependency
+		var err error
+		switch path.Base(name) {
+		case "go.mod":
+			parsed = parseGoMod(name, data)
+		case "package-lock.json":
+			parsed, err = parsePackageLock(name, data)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, dependency := range parsed {
+			if !seen[dependency.key()] {
+				seen[dependency.key()] = true
+				dependencies = append(dependencies, dependency)
+			}
+		}
+	}
+	sort.Slice(dependencies, func(i, j int) bool {
+		return dependencies[i].key() < dependencies[j].key()
+	})
+	return dependencies, nil
+}
+
+// parseGoMod returns the requirements of a go.mod, in single lines and
+// blocks. Replaced modules are checked at their required version.
+func parseGoMod(file, data string) []Dependency {
+	var dependencies []Dependency
+	inBlock := false
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "require" && len(fields) == 3:
+			fields = fields[1:]
+		case !inBlock || len(fields) != 2:
+			continue
+		}
+		dependencies = append(dependencies, Dependency{
+			Ecosystem: EcosystemGo,
+			Name:      fields[0],
+			Version:   strings.TrimPrefix(fields[1], "v"),
+			File:      file,
+		})
+	}
+	return dependencies
+}
+
+// parsePackageLock returns the packages of a package-lock.json, from its
+// packages (lockfile v2 and v3) or dependencies (v1)
+func parsePackageLock(file, data string) ([]Dependency, error) {
+	var lock struct {
+		Packages map[string]struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+		Dependencies map[string]json.RawMessage `json:"dependencies"`
+	}
+	if err := json.Unmarshal([]byte(data), &lock); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", file, err)
+	}
+
+	var dependencies []Dependency
+	if len(lock.Packages) > 0 {
+		for location, pkg := range lock.Packages {
+			// The root package is keyed "", linked workspaces have no version
+			i := strings.LastIndex(location, "node_modules/")
+			if i < 0 || pkg.Link || pkg.Version == "" {
+				continue
+			}
+			name := pkg.Name
+			if name == "" {
+				name = location[i+len("node_modules/"):]
+			}
+			dependencies = append(dependencies, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: pkg.Version, File: file})
+		}
+		return dependencies, nil
+	}
+
+	// Lockfile v1 nests the dependencies of packages under them
+	var walk func(deps map[string]json.RawMessage) error
+	walk = func(deps map[string]json.RawMessage) error {
+		for name, raw := range deps {
+			var pkg struct {
+				Version      string                     `json:"version"`
+				Dependencies map[string]json.RawMessage `json:"dependencies"`
+			}
+			if err := json.Unmarshal(raw, &pkg); err != nil {
+				return fmt.Errorf("invalid %s: %w", file, err)
+			}
+			if pkg.Version != "" && !strings.Contains(pkg.Version, ":") {
+				dependencies = append(dependencies, Dependency{Ecosystem: EcosystemNPM, Name: name, Version: pkg.Version, File: file})
+			}
+			if err := walk(pkg.Dependencies); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(lock.Dependencies); err != nil {
+		return nil, err
+	}
+	return dependencies, nil
+}
+
+// osvVulnerability is the part of an OSV vulnerability read here
+type osvVulnerability struct {
+	ID       string   `json:"id"`
+	Summary  string   `json:"summary"`
+	Details  string   `json:"details"`
+	Aliases  []string `json:"aliases"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// vulnerabilityFinding describes a vulnerability of a dependency; vuln is nil
+// when its details could not be fetched
+func vulnerabilityFinding(dependency Dependency, id string, vuln *osvVulnerability) SecurityFinding {
+	finding := SecurityFinding{
+		Scanner:   DependencyScannerName,
+		Type:      SecurityFindingVulnerability,
+		Severity:  SecuritySeverityUnknown,
+		Summary:   id,
+		ID:        id,
+		Ecosystem: dependency.Ecosystem,
+		Package:   dependency.Name,
+		Version:   dependency.Version,
+		File:      dependency.File,
+	}
+	if vuln == nil {
+		return finding
+	}
+
+	finding.Summary = vuln.Summary
+	if finding.Summary == "" {
+		finding.Summary = strings.SplitN(strings.TrimSpace(vuln.Details), "\n", 2)[0]
+	}
+	for _, alias := range vuln.Aliases {
+		if strings.HasPrefix(alias, "CVE-") {
+			finding.Aliases = append(finding.Aliases, alias)
+		}
+	}
+	finding.Severity = osvSeverity(vuln)
+	for _, affected := range vuln.Affected {
+		if affected.Package.Name != dependency.Name || !strings.EqualFold(affected.Package.Ecosystem, dependency.Ecosystem) {
+			continue
+		}
+		for _, r := range affected.Ranges {
+			for _, event := range r.Events {
+				if fixed := event["fixed"]; fixed != "" && compareVersions(fixed, dependency.Version) > 0 &&
+					(finding.FixedVersion == "" || compareVersions(fixed, finding.FixedVersion) < 0) {
+					finding.FixedVersion = fixed
+				}
+			}
+		}
+	}
+	return finding
+}
+
+// osvSeverity returns the severity the database gives a vulnerability, else
+// the one of its CVSS v3 base score
+func osvSeverity(vuln *osvVulnerability) string {
+	if severity, ok := vuln.DatabaseSpecific["severity"].(string); ok {
+		switch strings.ToLower(severity) {
+		case "critical":
+			return SecuritySeverityCritical
+		case "high":
+			return SecuritySeverityHigh
+		case "moderate", "medium":
+			return SecuritySeverityMedium
+		case "low":
+			return SecuritySeverityLow
+		}
+	}
+	for _, severity := range vuln.Severity {
+		if severity.Type != "CVSS_V3" {
+			continue
+		}
+		score, err := cvss3BaseScore(severity.Score)
+		if err != nil {
+			continue
+		}
+		switch {
+		case score >= 9:
+			return SecuritySeverityCritical
+		case score >= 7:
+			return SecuritySeverityHigh
+		case score >= 4:
+			return SecuritySeverityMedium
+		case score > 0:
+			return SecuritySeverityLow
+		}
+	}
+	return SecuritySeverityUnknown
+}
+
+// osvQueryResult caches the vulnerability IDs of a dependency
+type osvQueryResult struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	IDs       []string  `json:"ids"`
+}
+
+// osvVulnerabilityResult caches the details of a vulnerability
+type osvVulnerabilityResult struct {
+	FetchedAt     time.Time        `json:"fetched_at"`
+	Vulnerability osvVulnerability `json:"vulnerability"`
+}
+
+// vulnerabilityIDs returns the IDs of the vulnerabilities of each dependency,
+// by key, from the cache or OSV in batches. It also returns how many
+// dependencies could not be checked at all.
+func (s *dependencyScanner) vulnerabilityIDs(ctx context.Context, dependencies []Dependency) (map[string][]string, int) {
+	ids := make(map[string][]string, len(dependencies))
+	stale := make(map[string][]string)
+	var queries []Dependency
+	for _, dependency := range dependencies {
+		var cached osvQueryResult
+		if s.readCache("queries", dependency.key(), &cached) {
+			if s.now().Sub(cached.FetchedAt) < s.cacheTTL {
+				ids[dependency.key()] = cached.IDs
+				continue
+			}
+			stale[dependency.key()] = cached.IDs
+		}
+		queries = append(queries, dependency)
+	}
+
+	unchecked := 0
+	for start := 0; start < len(queries); start += s.batchSize {
+		batch := queries[start:min(start+s.batchSize, len(queries))]
+		results, err := s.queryBatch(ctx, batch)
+		if err != nil {
+			s.logger.Warn("Failed to query OSV", zap.Int("dependencies", len(batch)), zap.Error(err))
+		}
+		for i, dependency := range batch {
+			if err == nil {
+				ids[dependency.key()] = results[i]
+				s.writeCache("queries", dependency.key(), osvQueryResult{FetchedAt: s.now(), IDs: results[i]})
+			} else if cached, ok := stale[dependency.key()]; ok {
+				ids[dependency.key()] = cached
+			} else {
+				unchecked++
+			}
+		}
+	}
+	return ids, unchecked
+}
+
+// queryBatch returns the vulnerability IDs of each dependency of a batch,
+// following OSV's pagination of long results
+func (s *dependencyScanner) queryBatch(ctx context.Context, batch []Dependency) ([][]string, error) {
+	type query struct {
+		Package struct {
+			Name      string `json:"name"`
+			Ecosystem string `json:"ecosystem"`
+		} `json:"package"`
+		Version   string `json:"version"`
+		PageToken string `json:"page_token,omitempty"`
+	}
+	queries := make([]query, len(batch))
+	for i, dependency := range batch {
+		queries[i].Package.Name = dependency.Name
+		queries[i].Package.Ecosystem = dependency.Ecosystem
+		queries[i].Version = dependency.Version
+	}
+
+	ids := make([][]string, len(batch))
+	pending := make([]int, len(batch)) // Indexes of the queries with more pages
+	for i := range pending {
+		pending[i] = i
+	}
+	for len(pending) > 0 {
+		body := struct {
+			Queries []query `json:"queries"`
+		}{}
+		for _, i := range pending {
+			body.Queries = append(body.Queries, queries[i])
+		}
+		var response struct {
+			Results []struct {
+				Vulns []struct {
+					ID string `json:"id"`
+				} `json:"vulns"`
+				NextPageToken string `json:"next_page_token"`
+			} `json:"results"`
+		}
+		if err := s.post(ctx, "/v1/querybatch", body, &response); err != nil {
+			return nil, err
+		}
+		if len(response.Results) != len(pending) {
+			return nil, fmt.Errorf("OSV returned %d results for %d queries", len(response.Results), len(pending))
+		}
+
+		var next []int
+		for j, result := range response.Results {
+			i := pending[j]
+			for _, vuln := range result.Vulns {
+				ids[i] = append(ids[i], vuln.ID)
+			}
+			if result.NextPageToken != "" {
+				queries[i].PageToken = result.NextPageToken
+				next = append(next, i)
+			}
+		}
+		pending = next
+	}
+	return ids, nil
+}
+
+// vulnerability returns the details of a vulnerability from the cache or
+// OSV. Expired details are used when OSV cannot be reached.
+func (s *dependencyScanner) vulnerability(ctx context.Context, id string) (*osvVulnerability, error) {
+	var cached osvVulnerabilityResult
+	hasCached := s.readCache("vulns", id, &cached)
+	if hasCached && s.now().Sub(cached.FetchedAt) < s.cacheTTL {
+		return &cached.Vulnerability, nil
+	}
+
+	var vuln osvVulnerability
+	if err := s.get(ctx, "/v1/vulns/"+url.PathEscape(id), &vuln); err != nil {
+		if hasCached {
+			return &cached.Vulnerability, nil
+		}
+		return nil, err
+	}
+	s.writeCache("vulns", id, osvVulnerabilityResult{FetchedAt: s.now(), Vulnerability: vuln})
+	return &vuln, nil
+}
+
+func (s *dependencyScanner) post(ctx context.Context, endpoint string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return s.do(req, out)
+}
+
+func (s *dependencyScanner) get(ctx context.Context, endpoint string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, out)
+}
+
+func (s *dependencyScanner) do(req *http.Request, out interface{}) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("OSV request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("OSV returned %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid OSV response: %w", err)
+	}
+	return nil
+}
+
+// readCache reads a cache entry into out, reporting whether there was one
+func (s *dependencyScanner) readCache(kind, key string, out interface{}) bool {
+	if s.cacheDir == "" {
+		return false
+	}
+	data, err := os.ReadFile(s.cachePath(kind, key))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, out) == nil
+}
+
+// writeCache writes a cache entry; failures only cost a later lookup
+func (s *dependencyScanner) writeCache(kind, key string, value interface{}) {
+	if s.cacheDir == "" {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	file := s.cachePath(kind, key)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		s.logger.Warn("Failed to write OSV cache", zap.Error(err))
+		return
+	}
+	// Written aside and renamed, so concurrent scans never read partial entries
+	tmp := fmt.Sprintf("%s.%d.tmp", file, s.now().UnixNano())
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		s.logger.Warn("Failed to write OSV cache", zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		s.logger.Warn("Failed to write OSV cache", zap.Error(err))
+	}
+}
+
+func (s *dependencyScanner) cachePath(kind, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.cacheDir, kind, hex.EncodeToString(sum[:16])+".json")
+}
+
+// cvss3Weights are the CVSS v3 base metric weights; privileges required
+// weigh more when the scope changes
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3BaseScore computes the base score of a CVSS v3 vector, e.g.
+// CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H
+func cvss3BaseScore(vector string) (float64, error) {
+	parts := strings.Split(vector, "/")
+	if len(parts) == 0 || !strings.HasPrefix(parts[0], "CVSS:3") {
+		return 0, fmt.Errorf("not a CVSS v3 vector: %s", vector)
+	}
+	metrics := make(map[string]string)
+	for _, part := range parts[1:] {
+		if name, value, ok := strings.Cut(part, ":"); ok {
+			metrics[name] = value
+		}
+	}
+	changed := metrics["S"] == "C"
+	values := make(map[string]float64)
+	for name, weights := range cvss3Weights {
+		weight, ok := weights[metrics[name]]
+		if !ok {
+			return 0, fmt.Errorf("CVSS vector %s has no valid %s", vector, name)
+		}
+		values[name] = weight
+	}
+	if changed {
+		switch metrics["PR"] {
+		case "L":
+			values["PR"] = 0.68
+		case "H":
+			values["PR"] = 0.5
+		}
+	}
+
+	iss := 1 - (1-values["C"])*(1-values["I"])*(1-values["A"])
+	impact := 6.42 * iss
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	}
+	if impact <= 0 {
+		return 0, nil
+	}
+	exploitability := 8.22 * values["AV"] * values["AC"] * values["PR"] * values["UI"]
+	if changed {
+		return cvssRoundUp(math.Min(1.08*(impact+exploitability), 10)), nil
+	}
+	return cvssRoundUp(math.Min(impact+exploitability, 10)), nil
+}
+
+// cvssRoundUp rounds up to one decimal as CVSS v3.1 specifies, avoiding
+// floating point artifacts
+func cvssRoundUp(value float64) float64 {
+	scaled := int(math.Round(value * 100000))
+	if scaled%10000 == 0 {
+		return float64(scaled) / 100000
+	}
+	return float64(scaled/10000+1) / 10
+}
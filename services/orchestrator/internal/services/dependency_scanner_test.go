@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// loadSecurityFixture returns the go.mod and package-lock.json fixtures as
+// code fetched from a repository
+func loadSecurityFixture(t *testing.T) map[string]string {
+	content := map[string]string{"main.go": "package main\n"}
+	for name, file := range map[string]string{
+		"go.mod":                "testdata/security/go.mod",
+		"web/package-lock.json": "testdata/security/package-lock.json",
+	} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		content[name] = string(data)
+	}
+	return content
+}
+
+// fakeOSV serves the vulnerabilities of testdata/security/osv to the
+// packages they affect, returning the results of gin one per page
+type fakeOSV struct {
+	*httptest.Server
+	mu      sync.Mutex
+	batches []int // Queries per batch request
+	vulns   int   // Vulnerability requests
+}
+
+func newFakeOSV(t *testing.T) *fakeOSV {
+	affected := map[string][]string{
+		"Go/github.com/gin-gonic/gin@1.9.0": {"GHSA-2c4m-59x9-fr2g", "GO-2023-2102"},
+		"Go/golang.org/x/net@0.17.0":        {"GO-2023-2102"},
+		"npm/lodash@4.17.20":                {"GHSA-35jh-r3h4-6jhm"},
+		"npm/minimist@1.2.5":                {"GHSA-xvch-5gv4-984h"},
+	}
+	osv := &fakeOSV{}
+	osv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		osv.mu.Lock()
+		defer osv.mu.Unlock()
+
+		if r.URL.Path == "/v1/querybatch" {
+			var body struct {
+				Queries []struct {
+					Package struct {
+						Name      string `json:"name"`
+						Ecosystem string `json:"ecosystem"`
+					} `json:"package"`
+					Version   string `json:"version"`
+					PageToken string `json:"page_token"`
+				} `json:"queries"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			osv.batches = append(osv.batches, len(body.Queries))
+
+			type vuln struct {
+				ID string `json:"id"`
+			}
+			type result struct {
+				Vulns         []vuln `json:"vulns,omitempty"`
+				NextPageToken string `json:"next_page_token,omitempty"`
+			}
+			response := struct {
+				Results []result `json:"results"`
+			}{Results: []result{}}
+			for _, query := range body.Queries {
+				ids := affected[query.Package.Ecosystem+"/"+query.Package.Name+"@"+query.Version]
+				var res result
+				switch {
+				case len(ids) > 1 && query.PageToken == "":
+					res = result{Vulns: []vuln{{ID: ids[0]}}, NextPageToken: "page-2"}
+				case len(ids) > 1:
+					for _, id := range ids[1:] {
+						res.Vulns = append(res.Vulns, vuln{ID: id})
+					}
+				default:
+					for _, id := range ids {
+						res.Vulns = append(res.Vulns, vuln{ID: id})
+					}
+				}
+				response.Results = append(response.Results, res)
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(response))
+			return
+		}
+
+		osv.vulns++
+		id := strings.TrimPrefix(r.URL.Path, "/v1/vulns/")
+		data, err := os.ReadFile(filepath.Join("testdata/security/osv", id+".json"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(osv.Close)
+	return osv
+}
+
+func newTestDependencyScanner(url, cacheDir string) *dependencyScanner {
+	return NewDependencyScanner(&config.SecurityAnalysisConfig{
+		OSVURL:     url,
+		OSVTimeout: 5,
+		BatchSize:  2,
+		CacheDir:   cacheDir,
+		CacheTTL:   3600,
+	}, zap.NewNop()).(*dependencyScanner)
+}
+
+func TestParseDependencies(t *testing.T) {
+	dependencies, err := ParseDependencies(loadSecurityFixture(t))
+	require.NoError(t, err)
+	assert.Equal(t, []Dependency{
+		{Ecosystem: EcosystemGo, Name: "github.com/gin-gonic/gin", Version: "1.9.0", File: "go.mod"},
+		{Ecosystem: EcosystemGo, Name: "golang.org/x/net", Version: "0.17.0", File: "go.mod"},
+		{Ecosystem: EcosystemGo, Name: "gopkg.in/yaml.v3", Version: "3.0.1", File: "go.mod"},
+		{Ecosystem: EcosystemNPM, Name: "lodash", Version: "4.17.20", File: "web/package-lock.json"},
+		{Ecosystem: EcosystemNPM, Name: "minimist", Version: "1.2.5", File: "web/package-lock.json"},
+	}, dependencies)
+
+	// Lockfile v1 nests dependencies
+	dependencies, err = ParseDependencies(map[string]string{"package-lock.json": `{
+		"lockfileVersion": 1,
+		"dependencies": {
+			"mkdirp": {"version": "0.5.5", "dependencies": {"minimist": {"version": "1.2.5"}}},
+			"local": {"version": "file:../local"}
+		}
+	}`})
+	require.NoError(t, err)
+	assert.Equal(t, []Dependency{
+		{Ecosystem: EcosystemNPM, Name: "minimist", Version: "1.2.5", File: "package-lock.json"},
+		{Ecosystem: EcosystemNPM, Name: "mkdirp", Version: "0.5.5", File: "package-lock.json"},
+	}, dependencies)
+
+	_, err = ParseDependencies(map[string]string{"package-lock.json": "{"})
+	assert.Error(t, err)
+}
+
+func TestDependencyScanner_Scan(t *testing.T) {
+	osv := newFakeOSV(t)
+	scanner := newTestDependencyScanner(osv.URL, t.TempDir())
+
+	scan, err := scanner.Scan(context.Background(), loadSecurityFixture(t))
+	require.NoError(t, err)
+	assert.False(t, scan.Degraded)
+
+	// 5 dependencies in batches of 2; the second page of gin follows its batch
+	assert.Equal(t, []int{2, 1, 2, 1}, osv.batches)
+	// Each vulnerability is fetched once
+	assert.Equal(t, 4, osv.vulns)
+
+	require.Len(t, scan.Findings, 5)
+	assert.Equal(t, SecurityFinding{
+		Scanner:      DependencyScannerName,
+		Type:         SecurityFindingVulnerability,
+		Severity:     SecuritySeverityMedium,
+		Summary:      "Gin mishandles a wildcard in the origin string",
+		ID:           "GHSA-2c4m-59x9-fr2g",
+		Aliases:      []string{"CVE-2023-29401"},
+		Ecosystem:    EcosystemGo,
+		Package:      "github.com/gin-gonic/gin",
+		Version:      "1.9.0",
+		FixedVersion: "1.9.1",
+		File:         "go.mod",
+	}, scan.Findings[0])
+
+	// Without a fix for the gin package, a severity or a summary
+	assert.Equal(t, "GO-2023-2102", scan.Findings[1].ID)
+	assert.Equal(t, "github.com/gin-gonic/gin", scan.Findings[1].Package)
+	assert.Equal(t, SecuritySeverityUnknown, scan.Findings[1].Severity)
+	assert.Empty(t, scan.Findings[1].FixedVersion)
+	assert.Equal(t, "A malicious HTTP/2 client which rapidly creates requests and immediately resets them can cause excessive server resource consumption.",
+		scan.Findings[1].Summary)
+	assert.Equal(t, []string{"CVE-2023-39325"}, scan.Findings[1].Aliases)
+
+	// The lowest fix above the version
+	assert.Equal(t, "golang.org/x/net", scan.Findings[2].Package)
+	assert.Equal(t, "0.17.1", scan.Findings[2].FixedVersion)
+
+	// Severities from CVSS vectors
+	assert.Equal(t, "lodash", scan.Findings[3].Package)
+	assert.Equal(t, SecuritySeverityHigh, scan.Findings[3].Severity)
+	assert.Equal(t, "4.17.21", scan.Findings[3].FixedVersion)
+	assert.Equal(t, "minimist", scan.Findings[4].Package)
+	assert.Equal(t, SecuritySeverityCritical, scan.Findings[4].Severity)
+	assert.Equal(t, "1.2.6", scan.Findings[4].FixedVersion)
+}
+
+func TestDependencyScanner_Offline(t *testing.T) {
+	osv := newFakeOSV(t)
+	cacheDir := t.TempDir()
+	scanner := newTestDependencyScanner(osv.URL, cacheDir)
+	ctx := context.Background()
+
+	online, err := scanner.Scan(ctx, loadSecurityFixture(t))
+	require.NoError(t, err)
+
+	// Fresh results come from the cache
+	_, err = scanner.Scan(ctx, loadSecurityFixture(t))
+	require.NoError(t, err)
+	assert.Len(t, osv.batches, 4)
+	assert.Equal(t, 4, osv.vulns)
+
+	// Expired results are used while OSV is unreachable
+	osv.Close()
+	scanner.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+	offline, err := scanner.Scan(ctx, loadSecurityFixture(t))
+	require.NoError(t, err)
+	assert.False(t, offline.Degraded)
+	assert.Equal(t, online.Findings, offline.Findings)
+
+	// Without a cache, the scan is degraded rather than failed
+	uncached := newTestDependencyScanner(osv.URL, t.TempDir())
+	degraded, err := uncached.Scan(ctx, loadSecurityFixture(t))
+	require.NoError(t, err)
+	assert.True(t, degraded.Degraded)
+	assert.Equal(t, "5 dependencies or vulnerabilities could not be checked against OSV", degraded.DegradedReason)
+	assert.Empty(t, degraded.Findings)
+}
+
+func TestCVSS3BaseScore(t *testing.T) {
+	for vector, expected := range map[string]float64{
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H": 9.8,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:C/C:H/I:H/A:H": 10,
+		"CVSS:3.1/AV:N/AC:L/PR:H/UI:N/S:U/C:H/I:H/A:H": 7.2,
+		"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N": 6.1,
+		"CVSS:3.0/AV:N/AC:L/PR:L/UI:N/S:U/C:L/I:N/A:N": 4.3,
+		"CVSS:3.1/AV:L/AC:L/PR:L/UI:N/S:U/C:N/I:N/A:N": 0,
+	} {
+		score, err := cvss3BaseScore(vector)
+		require.NoError(t, err, vector)
+		assert.Equal(t, expected, score, vector)
+	}
+
+	_, err := cvss3BaseScore("CVSS:2.0/AV:N/AC:L/Au:N/C:P/I:P/A:P")
+	assert.Error(t, err)
+	_, err = cvss3BaseScore("CVSS:3.1/AV:N/AC:L")
+	assert.Error(t, err)
+}
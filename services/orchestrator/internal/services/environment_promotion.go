@@ -0,0 +1,449 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrPromotionConflict is returned when promoting into an environment
+	// modified since its last promotion, without force
+	ErrPromotionConflict = errors.New("target environment was modified since its last promotion")
+	// ErrInvalidPromotion is returned for promotions of an environment into
+	// itself or of environments whose config is not a JSON object
+	ErrInvalidPromotion = errors.New("invalid environment promotion")
+)
+
+// Sections of an environment a promotion copies. Secrets are never copied,
+// nor read.
+const (
+	PromotionSectionConfig    = "config"
+	PromotionSectionVariables = "variables"
+)
+
+// Kinds of change a promotion makes to a key of the target
+const (
+	PromotionChangeAdded   = "added"
+	PromotionChangeChanged = "changed"
+	PromotionChangeRemoved = "removed"
+)
+
+// EnvironmentChange is a top-level key of a section of the target that a
+// promotion adds, changes or removes
+type EnvironmentChange struct {
+	Section string          `json:"section"`
+	Key     string          `json:"key"`
+	Change  string          `json:"change"`
+	Before  json.RawMessage `json:"before,omitempty"`
+	After   json.RawMessage `json:"after,omitempty"`
+}
+
+// EnvironmentDiff is what promoting one environment into another changes
+type EnvironmentDiff struct {
+	Source  string              `json:"source"`
+	Target  string              `json:"target"`
+	Changes []EnvironmentChange `json:"changes"`
+	// Conflict is set when the target was modified since its last promotion
+	Conflict bool `json:"conflict"`
+}
+
+// EnvironmentSnapshot is the config and variables of an environment
+type EnvironmentSnapshot struct {
+	Config    json.RawMessage `json:"config,omitempty"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+}
+
+// PromotionRequest promotes an environment into a target environment of
+// the same project
+type PromotionRequest struct {
+	Target string `json:"target" binding:"required"`
+	DryRun bool   `json:"dry_run"` // Only return the diff
+	Force  bool   `json:"force"`   // Overwrite a target modified since its last promotion
+	// Deploy is the input of a deployment chained against the target once
+	// it is promoted; its environment is always the target
+	Deploy json.RawMessage `json:"deploy,omitempty"`
+}
+
+// PromotionInput is the input of an environment promotion workflow
+type PromotionInput struct {
+	Source string          `json:"source"`
+	Target string          `json:"target"`
+	Force  bool            `json:"force"`
+	Deploy json.RawMessage `json:"deploy,omitempty"`
+}
+
+// PromotionResult is what a promotion applied, with the target before and
+// after it
+type PromotionResult struct {
+	Source     string              `json:"source"`
+	Target     string              `json:"target"`
+	Changes    []EnvironmentChange `json:"changes"`
+	Before     EnvironmentSnapshot `json:"before"`
+	After      EnvironmentSnapshot `json:"after"`
+	PromotedAt time.Time           `json:"promoted_at"`
+}
+
+// PromotionResponse answers a promotion request: the diff, and unless it
+// is a dry run the workflow applying it
+type PromotionResponse struct {
+	EnvironmentDiff
+	DryRun           bool   `json:"dry_run"`
+	WorkflowID       string `json:"workflow_id,omitempty"`
+	Status           string `json:"status,omitempty"`
+	SchedulingReason string `json:"scheduling_reason,omitempty"`
+}
+
+// WorkflowStarter starts workflows, as the workflow engine does
+type WorkflowStarter interface {
+	StartWorkflow(ctx context.Context, req *StartWorkflowRequest) (*StartWorkflowResponse, error)
+}
+
+// EnvironmentPromoter copies the config and variables of an environment
+// into another of the same project. Promotions are applied by environment
+// promotion workflows, which can chain a deployment of the target.
+type EnvironmentPromoter struct {
+	db      *gorm.DB
+	starter WorkflowStarter
+	logger  *zap.Logger
+	now     func() time.Time
+}
+
+// NewEnvironmentPromoter creates an environment promoter starting promotion
+// workflows with the given starter
+func NewEnvironmentPromoter(db *gorm.DB, starter WorkflowStarter, logger *zap.Logger) *EnvironmentPromoter {
+	return &EnvironmentPromoter{db: db, starter: starter, logger: logger, now: time.Now}
+}
+
+// Diff returns what promoting the source environment into the target
+// changes
+func (p *EnvironmentPromoter) Diff(ctx context.Context, projectID, source, target string) (*EnvironmentDiff, error) {
+	sourceEnv, targetEnv, err := p.load(p.db.WithContext(ctx), projectID, source, target)
+	if err != nil {
+		return nil, err
+	}
+	return diffEnvironments(sourceEnv, targetEnv)
+}
+
+// Promote returns the diff of a promotion and, unless it is a dry run,
+// starts the workflow applying it. A target modified since its last
+// promotion is only overwritten with force.
+func (p *EnvironmentPromoter) Promote(ctx context.Context, projectID, source, userID string, req *PromotionRequest) (*PromotionResponse, error) {
+	diff, err := p.Diff(ctx, projectID, source, req.Target)
+	if err != nil {
+		return nil, err
+	}
+	resp := &PromotionResponse{EnvironmentDiff: *diff, DryRun: req.DryRun}
+	if req.DryRun {
+		return resp, nil
+	}
+	if diff.Conflict && !req.Force {
+		return resp, fmt.Errorf("%w: %s", ErrPromotionConflict, req.Target)
+	}
+
+	input, err := json.Marshal(PromotionInput{Source: source, Target: req.Target, Force: req.Force, Deploy: req.Deploy})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal promotion input: %w", err)
+	}
+	started, err := p.starter.StartWorkflow(ctx, &StartWorkflowRequest{
+		Name:      fmt.Sprintf("Promote %s to %s", source, req.Target),
+		Type:      string(models.WorkflowTypePromotion),
+		Priority:  string(models.WorkflowPriorityMedium),
+		ProjectID: projectID,
+		UserID:    userID,
+		Input:     input,
+		Labels:    map[string]string{"source": source, "target": req.Target},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.WorkflowID = started.WorkflowID
+	resp.Status = started.Status
+	resp.SchedulingReason = started.SchedulingReason
+
+	p.logger.Info("Promoting environment",
+		zap.String("project_id", projectID),
+		zap.String("source", source),
+		zap.String("target", req.Target),
+		zap.String("workflow_id", started.WorkflowID),
+		zap.Int("changes", len(diff.Changes)))
+	return resp, nil
+}
+
+// Apply copies the config and variables of the source environment into the
+// target and marks the target promoted. The conflict guard is checked again,
+// as the target may have changed since the promotion was requested.
+func (p *EnvironmentPromoter) Apply(ctx context.Context, projectID string, input *PromotionInput) (*PromotionResult, error) {
+	var result *PromotionResult
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sourceEnv, targetEnv, err := p.load(tx, projectID, input.Source, input.Target)
+		if err != nil {
+			return err
+		}
+		diff, err := diffEnvironments(sourceEnv, targetEnv)
+		if err != nil {
+			return err
+		}
+		if diff.Conflict && !input.Force {
+			return fmt.Errorf("%w: %s", ErrPromotionConflict, input.Target)
+		}
+
+		checksum, err := environmentChecksum(sourceEnv.Config, sourceEnv.Variables)
+		if err != nil {
+			return err
+		}
+		promotedAt := p.now().UTC()
+		err = tx.Model(&models.Environment{}).Where("id = ?", targetEnv.ID).Updates(map[string]interface{}{
+			"config":             sourceEnv.Config,
+			"variables":          sourceEnv.Variables,
+			"promoted_from":      sourceEnv.Name,
+			"promoted_at":        promotedAt,
+			"promotion_checksum": checksum,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update environment %s: %w", targetEnv.Name, err)
+		}
+
+		result = &PromotionResult{
+			Source:     sourceEnv.Name,
+			Target:     targetEnv.Name,
+			Changes:    diff.Changes,
+			Before:     EnvironmentSnapshot{Config: targetEnv.Config, Variables: targetEnv.Variables},
+			After:      EnvironmentSnapshot{Config: sourceEnv.Config, Variables: sourceEnv.Variables},
+			PromotedAt: promotedAt,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.logger.Info("Promoted environment",
+		zap.String("project_id", projectID),
+		zap.String("source", result.Source),
+		zap.String("target", result.Target),
+		zap.Int("changes", len(result.Changes)))
+	return result, nil
+}
+
+// CreateDeployment records the deployment a promotion workflow chains
+// against its target, as a running child of the promotion workflow. A
+// retried activity gets the deployment recorded the first time.
+func (p *EnvironmentPromoter) CreateDeployment(ctx context.Context, promotionID string, input *PromotionInput) (*models.Workflow, error) {
+	var promotion models.Workflow
+	if err := p.db.WithContext(ctx).First(&promotion, "id = ?", promotionID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+
+	var existing models.Workflow
+	err := p.db.WithContext(ctx).
+		Where("parent_workflow_id = ? AND type = ?", promotionID, models.WorkflowTypeDeployment).
+		Take(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get promotion deployment: %w", err)
+	}
+
+	deploy, err := promotionDeploymentInput(input)
+	if err != nil {
+		return nil, err
+	}
+	deployment, err := newWorkflowRecord(ctx, &StartWorkflowRequest{
+		Name:      fmt.Sprintf("Deploy %s", input.Target),
+		Type:      string(models.WorkflowTypeDeployment),
+		Priority:  string(promotion.Priority),
+		ProjectID: promotion.ProjectID,
+		UserID:    promotion.CreatedBy,
+		Input:     deploy,
+		Labels:    map[string]string{"environment": input.Target},
+	}, models.WorkflowStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	deployment.ID = uuid.NewString()
+	// The deployment runs as a child workflow under its own ID
+	deployment.TemporalID = deployment.ID
+	deployment.ParentWorkflowID = &promotion.ID
+	now := p.now()
+	// Child workflows start without waiting in a queue
+	deployment.QueuedAt = &now
+	deployment.StartedAt = &now
+	if err := p.db.WithContext(ctx).Create(deployment).Error; err != nil {
+		return nil, fmt.Errorf("failed to create deployment workflow record: %w", err)
+	}
+	return deployment, nil
+}
+
+// MarkDeploymentStarted records the run of a chained deployment, so the
+// workflow monitor follows it like any other workflow
+func (p *EnvironmentPromoter) MarkDeploymentStarted(ctx context.Context, deploymentID, runID string) error {
+	err := p.db.WithContext(ctx).Model(&models.Workflow{}).Where("id = ?", deploymentID).
+		Update("temporal_run_id", runID).Error
+	if err != nil {
+		return fmt.Errorf("failed to update deployment workflow: %w", err)
+	}
+	return nil
+}
+
+// promotionDeploymentInput is the input of the deployment chained by a
+// promotion, deploying to the target
+func promotionDeploymentInput(input *PromotionInput) (json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if len(bytes.TrimSpace(input.Deploy)) > 0 {
+		if err := json.Unmarshal(input.Deploy, &fields); err != nil || fields == nil {
+			return nil, fmt.Errorf("%w: deploy must be a JSON object", ErrInvalidPromotion)
+		}
+	}
+	environment, err := json.Marshal(input.Target)
+	if err != nil {
+		return nil, err
+	}
+	fields["environment"] = environment
+	return json.Marshal(fields)
+}
+
+// load returns the source and target environments of a promotion, without
+// their secrets
+func (p *EnvironmentPromoter) load(db *gorm.DB, projectID, source, target string) (*models.Environment, *models.Environment, error) {
+	if source == target {
+		return nil, nil, fmt.Errorf("%w: cannot promote %s into itself", ErrInvalidPromotion, source)
+	}
+	columns := []string{"id", "project_id", "name", "config", "variables", "promoted_from", "promoted_at", "promotion_checksum"}
+	environments := make([]*models.Environment, 2)
+	for i, name := range []string{source, target} {
+		var environment models.Environment
+		if err := db.Select(columns).First(&environment, "project_id = ? AND name = ?", projectID, name).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to get environment %s: %w", name, err)
+		}
+		environments[i] = &environment
+	}
+	return environments[0], environments[1], nil
+}
+
+// diffEnvironments compares the config and variables of two environments
+// key by key
+func diffEnvironments(source, target *models.Environment) (*EnvironmentDiff, error) {
+	diff := &EnvironmentDiff{Source: source.Name, Target: target.Name, Changes: []EnvironmentChange{}}
+	sections := []struct {
+		name           string
+		source, target json.RawMessage
+	}{
+		{PromotionSectionConfig, source.Config, target.Config},
+		{PromotionSectionVariables, source.Variables, target.Variables},
+	}
+	for _, section := range sections {
+		changes, err := diffSection(section.name, section.source, section.target)
+		if err != nil {
+			return nil, err
+		}
+		diff.Changes = append(diff.Changes, changes...)
+	}
+
+	conflict, err := promotionConflict(target)
+	if err != nil {
+		return nil, err
+	}
+	diff.Conflict = conflict
+	return diff, nil
+}
+
+// diffSection compares the top-level keys of a section of two environments
+func diffSection(section string, source, target json.RawMessage) ([]EnvironmentChange, error) {
+	sourceKeys, err := decodeSection(section, source)
+	if err != nil {
+		return nil, err
+	}
+	targetKeys, err := decodeSection(section, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []EnvironmentChange
+	for key, after := range sourceKeys {
+		before, ok := targetKeys[key]
+		switch {
+		case !ok:
+			changes = append(changes, EnvironmentChange{Section: section, Key: key, Change: PromotionChangeAdded, After: after})
+		case !bytes.Equal(before, after):
+			changes = append(changes, EnvironmentChange{Section: section, Key: key, Change: PromotionChangeChanged, Before: before, After: after})
+		}
+	}
+	for key, before := range targetKeys {
+		if _, ok := sourceKeys[key]; !ok {
+			changes = append(changes, EnvironmentChange{Section: section, Key: key, Change: PromotionChangeRemoved, Before: before})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes, nil
+}
+
+// decodeSection reads a section of an environment as its top-level keys,
+// each value in canonical form so equal values compare equal
+func decodeSection(section string, data json.RawMessage) (map[string]json.RawMessage, error) {
+	keys := make(map[string]json.RawMessage)
+	if len(bytes.TrimSpace(data)) == 0 || bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return keys, nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("%w: %s is not a JSON object", ErrInvalidPromotion, section)
+	}
+	for key, value := range values {
+		canonical, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s %s: %w", section, key, err)
+		}
+		keys[key] = canonical
+	}
+	return keys, nil
+}
+
+// promotionConflict reports whether an environment was modified since its
+// last promotion. Environments never promoted into have no conflict.
+func promotionConflict(environment *models.Environment) (bool, error) {
+	if environment.PromotionChecksum == "" {
+		return false, nil
+	}
+	checksum, err := environmentChecksum(environment.Config, environment.Variables)
+	if err != nil {
+		return false, err
+	}
+	return checksum != environment.PromotionChecksum, nil
+}
+
+// environmentChecksum hashes the canonical form of the config and variables
+// of an environment
+func environmentChecksum(config, variables json.RawMessage) (string, error) {
+	hash := sha256.New()
+	for _, section := range []struct {
+		name string
+		data json.RawMessage
+	}{{PromotionSectionConfig, config}, {PromotionSectionVariables, variables}} {
+		// Maps marshal with sorted keys, so the encoding is canonical
+		keys, err := decodeSection(section.name, section.data)
+		if err != nil {
+			return "", err
+		}
+		encoded, err := json.Marshal(keys)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode %s: %w", section.name, err)
+		}
+		hash.Write(encoded)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
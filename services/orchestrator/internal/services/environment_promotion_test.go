@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// setupPromotionTest creates a project with a development environment to
+// promote into a staging environment, both with secrets
+func setupPromotionTest(t *testing.T) (*EnvironmentPromoter, *mocks.Client, *gorm.DB) {
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+
+	stmt := &gorm.Statement{DB: db}
+	require.NoError(t, stmt.Parse(&models.Environment{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+	require.NoError(t, db.Migrator().CreateTable(&models.Environment{}))
+	for _, environment := range []*models.Environment{
+		{
+			ID:        "env-dev",
+			ProjectID: "project-1",
+			Name:      "development",
+			Config:    json.RawMessage(`{"image": "app:2.0", "replicas": 1, "features": {"search": true}}`),
+			Variables: json.RawMessage(`{"LOG_LEVEL": "debug", "CACHE_TTL": "60"}`),
+			Secrets:   json.RawMessage(`{"DB_PASSWORD": "dev-password"}`),
+		},
+		{
+			ID:        "env-staging",
+			ProjectID: "project-1",
+			Name:      "staging",
+			Config:    json.RawMessage(`{"image": "app:1.9", "replicas": 1, "region": "eu-west-1"}`),
+			Variables: json.RawMessage(`{"LOG_LEVEL": "info"}`),
+			Secrets:   json.RawMessage(`{"DB_PASSWORD": "staging-password"}`),
+		},
+	} {
+		require.NoError(t, db.Create(environment).Error)
+	}
+	return NewEnvironmentPromoter(db, engine, zap.NewNop()), temporalClient, db
+}
+
+func getEnvironment(t *testing.T, db *gorm.DB, name string) *models.Environment {
+	var environment models.Environment
+	require.NoError(t, db.First(&environment, "project_id = ? AND name = ?", "project-1", name).Error)
+	return &environment
+}
+
+func TestEnvironmentPromoter_Diff(t *testing.T) {
+	promoter, _, _ := setupPromotionTest(t)
+
+	diff, err := promoter.Diff(context.Background(), "project-1", "development", "staging")
+	require.NoError(t, err)
+	assert.Equal(t, "development", diff.Source)
+	assert.Equal(t, "staging", diff.Target)
+	assert.False(t, diff.Conflict)
+	assert.Equal(t, []EnvironmentChange{
+		{Section: PromotionSectionConfig, Key: "features", Change: PromotionChangeAdded, After: json.RawMessage(`{"search":true}`)},
+		{Section: PromotionSectionConfig, Key: "image", Change: PromotionChangeChanged, Before: json.RawMessage(`"app:1.9"`), After: json.RawMessage(`"app:2.0"`)},
+		{Section: PromotionSectionConfig, Key: "region", Change: PromotionChangeRemoved, Before: json.RawMessage(`"eu-west-1"`)},
+		{Section: PromotionSectionVariables, Key: "CACHE_TTL", Change: PromotionChangeAdded, After: json.RawMessage(`"60"`)},
+		{Section: PromotionSectionVariables, Key: "LOG_LEVEL", Change: PromotionChangeChanged, Before: json.RawMessage(`"info"`), After: json.RawMessage(`"debug"`)},
+	}, diff.Changes, "unchanged keys are left out")
+
+	data, err := json.Marshal(diff)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "password", "secrets are never diffed")
+
+	_, err = promoter.Diff(context.Background(), "project-1", "development", "production")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = promoter.Diff(context.Background(), "project-1", "staging", "staging")
+	assert.ErrorIs(t, err, ErrInvalidPromotion)
+}
+
+func TestEnvironmentPromoter_Apply(t *testing.T) {
+	ctx := context.Background()
+	promoter, _, db := setupPromotionTest(t)
+
+	result, err := promoter.Apply(ctx, "project-1", &PromotionInput{Source: "development", Target: "staging"})
+	require.NoError(t, err)
+	assert.Len(t, result.Changes, 5)
+	assert.JSONEq(t, `{"image": "app:1.9", "replicas": 1, "region": "eu-west-1"}`, string(result.Before.Config))
+	assert.JSONEq(t, `{"LOG_LEVEL": "info"}`, string(result.Before.Variables))
+	assert.JSONEq(t, `{"image": "app:2.0", "replicas": 1, "features": {"search": true}}`, string(result.After.Config))
+	assert.JSONEq(t, `{"LOG_LEVEL": "debug", "CACHE_TTL": "60"}`, string(result.After.Variables))
+
+	// The target has the source's config and variables, its own secrets and
+	// the promotion marker
+	staging := getEnvironment(t, db, "staging")
+	assert.JSONEq(t, string(result.After.Config), string(staging.Config))
+	assert.JSONEq(t, string(result.After.Variables), string(staging.Variables))
+	assert.JSONEq(t, `{"DB_PASSWORD": "staging-password"}`, string(staging.Secrets))
+	assert.Equal(t, "development", staging.PromotedFrom)
+	require.NotNil(t, staging.PromotedAt)
+	assert.NotEmpty(t, staging.PromotionChecksum)
+
+	// Promoting again changes nothing
+	diff, err := promoter.Diff(ctx, "project-1", "development", "staging")
+	require.NoError(t, err)
+	assert.Empty(t, diff.Changes)
+	assert.False(t, diff.Conflict)
+}
+
+func TestEnvironmentPromoter_DryRun(t *testing.T) {
+	promoter, temporalClient, db := setupPromotionTest(t)
+
+	resp, err := promoter.Promote(context.Background(), "project-1", "development", "alice", &PromotionRequest{
+		Target: "staging",
+		DryRun: true,
+	})
+	require.NoError(t, err)
+	assert.True(t, resp.DryRun)
+	assert.Len(t, resp.Changes, 5)
+	assert.Empty(t, resp.WorkflowID)
+
+	// Nothing was started or applied
+	temporalClient.AssertNotCalled(t, "ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	var count int64
+	require.NoError(t, db.Model(&models.Workflow{}).Count(&count).Error)
+	assert.Zero(t, count)
+	staging := getEnvironment(t, db, "staging")
+	assert.JSONEq(t, `{"LOG_LEVEL": "info"}`, string(staging.Variables))
+	assert.Empty(t, staging.PromotionChecksum)
+}
+
+func TestEnvironmentPromoter_ConflictGuard(t *testing.T) {
+	ctx := context.Background()
+	promoter, temporalClient, db := setupPromotionTest(t)
+	_, err := promoter.Apply(ctx, "project-1", &PromotionInput{Source: "development", Target: "staging"})
+	require.NoError(t, err)
+
+	// Reformatting the target is not a modification
+	require.NoError(t, db.Model(&models.Environment{}).Where("id = ?", "env-staging").
+		Update("variables", json.RawMessage(`{ "CACHE_TTL" : "60", "LOG_LEVEL" : "debug" }`)).Error)
+	diff, err := promoter.Diff(ctx, "project-1", "development", "staging")
+	require.NoError(t, err)
+	assert.False(t, diff.Conflict)
+
+	// Changing it by hand is
+	require.NoError(t, db.Model(&models.Environment{}).Where("id = ?", "env-staging").
+		Update("variables", json.RawMessage(`{"CACHE_TTL": "60", "LOG_LEVEL": "warn"}`)).Error)
+	resp, err := promoter.Promote(ctx, "project-1", "development", "alice", &PromotionRequest{Target: "staging"})
+	require.ErrorIs(t, err, ErrPromotionConflict)
+	assert.True(t, resp.Conflict)
+	assert.Len(t, resp.Changes, 1)
+	_, err = promoter.Apply(ctx, "project-1", &PromotionInput{Source: "development", Target: "staging"})
+	require.ErrorIs(t, err, ErrPromotionConflict, "the guard holds when the workflow applies the promotion")
+	assert.JSONEq(t, `{"CACHE_TTL": "60", "LOG_LEVEL": "warn"}`, string(getEnvironment(t, db, "staging").Variables))
+
+	// Dry runs report the conflict without failing
+	resp, err = promoter.Promote(ctx, "project-1", "development", "alice", &PromotionRequest{Target: "staging", DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, resp.Conflict)
+
+	// Force starts the promotion workflow
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, "PromoteEnvironmentWorkflow",
+		mock.MatchedBy(func(workflow *models.Workflow) bool {
+			return workflow.Type == models.WorkflowTypePromotion && workflow.ProjectID == "project-1"
+		})).Return(run, nil).Once()
+	resp, err = promoter.Promote(ctx, "project-1", "development", "alice", &PromotionRequest{
+		Target: "staging",
+		Force:  true,
+		Deploy: json.RawMessage(`{"version": "2.0.0"}`),
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.WorkflowID)
+	assert.Equal(t, string(models.WorkflowStatusRunning), resp.Status)
+	temporalClient.AssertExpectations(t)
+
+	workflow := getWorkflow(t, db, resp.WorkflowID)
+	var input PromotionInput
+	require.NoError(t, json.Unmarshal(workflow.Input, &input))
+	assert.Equal(t, "development", input.Source)
+	assert.Equal(t, "staging", input.Target)
+	assert.True(t, input.Force)
+	assert.JSONEq(t, `{"version": "2.0.0"}`, string(input.Deploy))
+
+	_, err = promoter.Apply(ctx, "project-1", &input)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"LOG_LEVEL": "debug", "CACHE_TTL": "60"}`, string(getEnvironment(t, db, "staging").Variables))
+}
+
+func TestEnvironmentPromoter_CreateDeployment(t *testing.T) {
+	ctx := context.Background()
+	promoter, _, db := setupPromotionTest(t)
+	promotion := &models.Workflow{
+		ID:        "promotion-1",
+		Name:      "Promote development to staging",
+		Type:      models.WorkflowTypePromotion,
+		Priority:  models.WorkflowPriorityHigh,
+		ProjectID: "project-1",
+		Status:    models.WorkflowStatusRunning,
+		CreatedBy: "alice",
+	}
+	require.NoError(t, db.Create(promotion).Error)
+	input := &PromotionInput{Source: "development", Target: "staging", Deploy: json.RawMessage(`{"version": "2.0.0", "environment": "production"}`)}
+
+	deployment, err := promoter.CreateDeployment(ctx, promotion.ID, input)
+	require.NoError(t, err)
+	assert.Equal(t, models.WorkflowTypeDeployment, deployment.Type)
+	assert.Equal(t, models.WorkflowStatusRunning, deployment.Status)
+	assert.Equal(t, models.WorkflowPriorityHigh, deployment.Priority)
+	assert.Equal(t, deployment.ID, deployment.TemporalID)
+	require.NotNil(t, deployment.ParentWorkflowID)
+	assert.Equal(t, promotion.ID, *deployment.ParentWorkflowID)
+	assert.JSONEq(t, `{"version": "2.0.0", "environment": "staging"}`, string(deployment.Input), "deployments target the promoted environment")
+
+	// A retried activity gets the same deployment
+	again, err := promoter.CreateDeployment(ctx, promotion.ID, input)
+	require.NoError(t, err)
+	assert.Equal(t, deployment.ID, again.ID)
+
+	require.NoError(t, promoter.MarkDeploymentStarted(ctx, deployment.ID, "run-1"))
+	assert.Equal(t, "run-1", getWorkflow(t, db, deployment.ID).TemporalRunID)
+}
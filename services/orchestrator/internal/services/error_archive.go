@@ -0,0 +1,44 @@
+package services
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/errorsig"
+	"orchestrator/internal/models"
+)
+
+// ErrorLogSource is the source of the execution logs holding the full text
+// of errors stored cleaned and truncated
+const ErrorLogSource = "error"
+
+// ExecutionErrorArchiver keeps the full text of execution errors the cleaner
+// changed as error logs of the execution, so the indexed error column stays
+// small. Errors of other tables are only stored cleaned.
+func ExecutionErrorArchiver(logger *zap.Logger) errorsig.Archiver {
+	executions := models.Execution{}.TableName()
+	return func(tx *gorm.DB, table, id, message string) {
+		if table != executions {
+			return
+		}
+		entry := &models.ExecutionLog{
+			ID:          uuid.NewString(),
+			ExecutionID: id,
+			Level:       "error",
+			Message:     message,
+			Source:      ErrorLogSource,
+			Timestamp:   time.Now().UTC(),
+		}
+		// A savepoint keeps a failed insert from aborting the transaction of
+		// the execution's write
+		err := tx.Session(&gorm.Session{NewDB: true}).Transaction(func(tx *gorm.DB) error {
+			return tx.Create(entry).Error
+		})
+		if err != nil {
+			logger.Warn("Failed to archive execution error", zap.String("execution_id", id), zap.Error(err))
+		}
+	}
+}
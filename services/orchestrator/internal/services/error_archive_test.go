@@ -0,0 +1,74 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/errorsig"
+	"orchestrator/internal/models"
+)
+
+func TestExecutionErrorArchiver(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	cleaner := errorsig.NewCleaner(errorsig.MinErrorLength)
+	require.NoError(t, errorsig.Register(db, errorsig.Default()))
+	require.NoError(t, errorsig.RegisterCleaner(db, cleaner, ExecutionErrorArchiver(zap.NewNop())))
+	for _, stmt := range []string{
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, name TEXT, type TEXT, status TEXT,
+			error TEXT, error_signature TEXT, error_class TEXT, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE execution_logs (id TEXT PRIMARY KEY, execution_id TEXT NOT NULL, level TEXT, message TEXT,
+			source TEXT, line_number INTEGER, metadata BLOB, timestamp DATETIME, created_at DATETIME)`,
+		`INSERT INTO executions (id, project_id, name, type, status) VALUES ('exec-1', 'project-1', 'deploy', 'action', 'running')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	// A Java agent fails with a stack trace wrapping a base64 screenshot
+	var trace strings.Builder
+	trace.WriteString("\x1b[31mException in thread \"main\" java.lang.IllegalStateException: browser session lost\x1b[0m\n")
+	for i := 0; i < 40; i++ {
+		trace.WriteString("\tat com.example.agent.Browser.step(Browser.java:118)\n")
+	}
+	trace.WriteString("screenshot=iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg==")
+	original := trace.String()
+
+	execution := &models.Execution{ID: "exec-1"}
+	require.NoError(t, db.Model(execution).
+		Updates(map[string]interface{}{"status": models.ExecutionStatusFailed, "error": original}).Error)
+
+	var stored struct {
+		Error      string
+		ErrorClass string
+	}
+	require.NoError(t, db.Table("executions").Select("error", "error_class").Where("id = ?", "exec-1").Scan(&stored).Error)
+	assert.LessOrEqual(t, len(stored.Error), errorsig.MinErrorLength)
+	assert.True(t, strings.HasPrefix(stored.Error, "Exception in thread \"main\" java.lang."))
+	assert.True(t, strings.HasSuffix(stored.Error, "screenshot=<base64 96 bytes>"))
+	assert.Equal(t, "crash", stored.ErrorClass)
+
+	// The full text is kept as an error log of the execution
+	var logs []models.ExecutionLog
+	require.NoError(t, db.Find(&logs).Error)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "exec-1", logs[0].ExecutionID)
+	assert.Equal(t, "error", logs[0].Level)
+	assert.Equal(t, ErrorLogSource, logs[0].Source)
+	assert.Equal(t, original, logs[0].Message)
+
+	// Workflow errors are cleaned and classified, not archived
+	workflow := &models.Workflow{Name: "wf", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusFailed,
+		Error: "\x1b[1mPermission denied (publickey)\x1b[0m"}
+	require.NoError(t, db.Create(workflow).Error)
+	var saved models.Workflow
+	require.NoError(t, db.First(&saved, "id = ?", workflow.ID).Error)
+	assert.Equal(t, "Permission denied (publickey)", saved.Error)
+	assert.Equal(t, "auth", saved.ErrorClass)
+	assert.Equal(t, "Permission denied (publickey)", saved.ErrorSignature)
+	var count int64
+	require.NoError(t, db.Model(&models.ExecutionLog{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
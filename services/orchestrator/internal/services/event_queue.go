@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+const (
+	defaultEventQueueSize      = 1000
+	defaultEventPublishTimeout = 500 * time.Millisecond
+	defaultEventBlockTimeout   = 50 * time.Millisecond
+)
+
+// Progress-style workflow events, the first dropped when events back up
+const (
+	WorkflowEventProgress  = "progress"
+	WorkflowEventHeartbeat = "heartbeat"
+)
+
+var (
+	eventQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orchestrator_event_queue_depth",
+		Help: "Workflow events waiting to be published",
+	})
+	eventPublishDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "orchestrator_event_publish_duration_seconds",
+		Help:    "Time taken to publish a workflow event to Redis",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+	eventsDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_event_queue_dropped_total",
+		Help: "Workflow events dropped because the publish queue was full, by priority",
+	}, []string{"priority"})
+)
+
+// EventPriority is the tier of a workflow event. Under pressure low
+// priority events are dropped first and high priority ones last.
+type EventPriority int
+
+const (
+	EventPriorityLow EventPriority = iota
+	EventPriorityNormal
+	EventPriorityHigh
+)
+
+func (p EventPriority) String() string {
+	switch p {
+	case EventPriorityLow:
+		return "low"
+	case EventPriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// WorkflowEventPriority classifies a workflow event: progress-style events
+// and SLA warnings are low priority; events of terminal states, failures,
+// breached SLAs and stuck workflows are high priority; the others are normal
+func WorkflowEventPriority(eventType string, workflow *models.Workflow) EventPriority {
+	switch eventType {
+	case WorkflowEventProgress, WorkflowEventHeartbeat, SLAEventWarning:
+		return EventPriorityLow
+	case string(models.WorkflowStatusCompleted), string(models.WorkflowStatusFailed),
+		string(models.WorkflowStatusCancelled), string(models.WorkflowStatusTerminated),
+		string(models.WorkflowStatusTimedOut), SLAEventBreached, WorkflowEventStuck:
+		return EventPriorityHigh
+	}
+	if workflow.IsTerminal() {
+		return EventPriorityHigh
+	}
+	return EventPriorityNormal
+}
+
+// EventSink publishes an event on a channel; RedisGuard is the sink in
+// production
+type EventSink interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+}
+
+// queuedEvent is an event waiting in the publish queue
+type queuedEvent struct {
+	channel  string
+	message  []byte
+	priority EventPriority
+}
+
+// WorkflowEventQueue takes workflow event publishing off the paths
+// emitting them. Events go into a bounded queue drained in order by one
+// background publisher, each publish bounded by its own timeout, so every
+// workflow's events are published in the order they were emitted. When the
+// queue backs up low priority events are refused once it is three quarters
+// full, normal ones replace queued low priority events, and high priority
+// ones replace low priority events, wait briefly for room and then replace
+// normal ones. Every event dropped is counted.
+type WorkflowEventQueue struct {
+	sink         EventSink
+	logger       *zap.Logger
+	capacity     int
+	timeout      time.Duration
+	blockTimeout time.Duration
+
+	mu      sync.Mutex
+	queue   []queuedEvent
+	freed   chan struct{} // Closed, and replaced, when queued events are taken
+	stopped bool
+
+	ready    chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewWorkflowEventQueue creates a queue with the event queue size,
+// publish timeout and block timeout of cfg
+func NewWorkflowEventQueue(sink EventSink, cfg *config.RedisConfig, logger *zap.Logger) *WorkflowEventQueue {
+	p := &WorkflowEventQueue{
+		sink:         sink,
+		logger:       logger,
+		capacity:     defaultEventQueueSize,
+		timeout:      defaultEventPublishTimeout,
+		blockTimeout: defaultEventBlockTimeout,
+		freed:        make(chan struct{}),
+		ready:        make(chan struct{}, 1),
+		stopChan:     make(chan struct{}),
+	}
+	if cfg.EventQueueSize > 0 {
+		p.capacity = cfg.EventQueueSize
+	}
+	if cfg.EventPublishTimeout > 0 {
+		p.timeout = time.Duration(cfg.EventPublishTimeout) * time.Millisecond
+	}
+	if cfg.EventBlockTimeout > 0 {
+		p.blockTimeout = time.Duration(cfg.EventBlockTimeout) * time.Millisecond
+	}
+	return p
+}
+
+// Start starts the background publisher
+func (p *WorkflowEventQueue) Start() {
+	p.wg.Add(1)
+	go p.run()
+}
+
+// Stop publishes the events still queued and stops the background
+// publisher. Events emitted afterwards are published synchronously.
+func (p *WorkflowEventQueue) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+	p.wg.Wait()
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.drain()
+}
+
+// Depth returns the number of queued events
+func (p *WorkflowEventQueue) Depth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// Enqueue queues an event for publishing and reports whether it was
+// queued. Only high priority events wait, at most the block timeout, for
+// room in a full queue.
+func (p *WorkflowEventQueue) Enqueue(channel string, message []byte, priority EventPriority) bool {
+	event := queuedEvent{channel: channel, message: message, priority: priority}
+	deadline := time.Now().Add(p.blockTimeout)
+	for {
+		p.mu.Lock()
+		if p.stopped {
+			p.mu.Unlock()
+			p.publish(event)
+			return true
+		}
+		if p.admit(event, false) {
+			p.mu.Unlock()
+			p.signal()
+			return true
+		}
+		if priority < EventPriorityHigh {
+			p.mu.Unlock()
+			p.drop(priority)
+			return false
+		}
+		freed := p.freed
+		p.mu.Unlock()
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-freed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+
+	p.mu.Lock()
+	admitted := p.admit(event, true)
+	p.mu.Unlock()
+	if !admitted {
+		p.drop(priority)
+		return false
+	}
+	p.signal()
+	return true
+}
+
+// admit queues an event if there is room for it, making room by dropping
+// the oldest low priority event for normal and high priority events, and
+// the oldest normal one for high priority events once they waited. The
+// caller holds mu.
+func (p *WorkflowEventQueue) admit(event queuedEvent, waited bool) bool {
+	depth := len(p.queue)
+	if event.priority == EventPriorityLow && depth >= p.capacity*3/4 {
+		return false
+	}
+	if depth >= p.capacity {
+		switch {
+		case p.evict(EventPriorityLow):
+		case waited && event.priority == EventPriorityHigh && p.evict(EventPriorityNormal):
+		default:
+			return false
+		}
+	}
+	p.queue = append(p.queue, event)
+	eventQueueDepth.Set(float64(len(p.queue)))
+	return true
+}
+
+// evict drops the oldest queued event of a priority. The caller holds mu.
+func (p *WorkflowEventQueue) evict(priority EventPriority) bool {
+	for i, queued := range p.queue {
+		if queued.priority == priority {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			p.drop(priority)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *WorkflowEventQueue) drop(priority EventPriority) {
+	eventsDroppedTotal.WithLabelValues(priority.String()).Inc()
+	p.logger.Debug("Dropped workflow event", zap.String("priority", priority.String()))
+}
+
+func (p *WorkflowEventQueue) signal() {
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (p *WorkflowEventQueue) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-p.ready:
+			p.drain()
+		}
+	}
+}
+
+// drain publishes the queued events, oldest first
+func (p *WorkflowEventQueue) drain() {
+	for {
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		event := p.queue[0]
+		p.queue[0] = queuedEvent{}
+		p.queue = p.queue[1:]
+		eventQueueDepth.Set(float64(len(p.queue)))
+		close(p.freed)
+		p.freed = make(chan struct{})
+		p.mu.Unlock()
+
+		p.publish(event)
+	}
+}
+
+// publish publishes an event within the publish timeout
+func (p *WorkflowEventQueue) publish(event queuedEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	start := time.Now()
+	err := p.sink.Publish(ctx, event.channel, event.message)
+	eventPublishDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		p.logger.Error("failed to publish workflow event",
+			zap.String("channel", event.channel),
+			zap.String("priority", event.priority.String()),
+			zap.Error(err))
+	}
+}
+
+// SetEventQueue makes the engine hand its workflow events to the queue
+// instead of publishing them synchronously
+func (e *WorkflowEngine) SetEventQueue(queue *WorkflowEventQueue) {
+	e.events = queue
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// slowPublishHook answers Redis commands without a server, publishing
+// after a delay and recording the published messages in order
+type slowPublishHook struct {
+	delay time.Duration
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (h *slowPublishHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h *slowPublishHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() != "publish" {
+			if cmd.Name() == "get" {
+				cmd.SetErr(redis.Nil)
+				return redis.Nil
+			}
+			return nil
+		}
+		select {
+		case <-time.After(h.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		h.mu.Lock()
+		h.published = append(h.published, fmt.Sprintf("%s", cmd.Args()[2]))
+		h.mu.Unlock()
+		return nil
+	}
+}
+
+func (h *slowPublishHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func (h *slowPublishHook) messages() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.published...)
+}
+
+// gatedSink records published events once its gate is open
+type gatedSink struct {
+	gate chan struct{}
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (s *gatedSink) Publish(ctx context.Context, channel string, message []byte) error {
+	<-s.gate
+	s.mu.Lock()
+	s.published = append(s.published, string(message))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *gatedSink) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.published...)
+}
+
+func TestWorkflowEventPriority(t *testing.T) {
+	running := &models.Workflow{Status: models.WorkflowStatusRunning}
+	failed := &models.Workflow{Status: models.WorkflowStatusFailed}
+
+	tests := []struct {
+		eventType string
+		workflow  *models.Workflow
+		expected  EventPriority
+	}{
+		{WorkflowEventProgress, running, EventPriorityLow},
+		{WorkflowEventHeartbeat, running, EventPriorityLow},
+		{SLAEventWarning, running, EventPriorityLow},
+		{"started", running, EventPriorityNormal},
+		{"created", &models.Workflow{Status: models.WorkflowStatusPending}, EventPriorityNormal},
+		{"completed", running, EventPriorityHigh},
+		{"failed", running, EventPriorityHigh},
+		{"cancelled", running, EventPriorityHigh},
+		{SLAEventBreached, running, EventPriorityHigh},
+		{WorkflowEventStuck, running, EventPriorityHigh},
+		{"updated", failed, EventPriorityHigh},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, WorkflowEventPriority(tt.eventType, tt.workflow), tt.eventType)
+	}
+}
+
+func TestWorkflowEngine_EventsPublishedInBackground(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	hook := &slowPublishHook{delay: 100 * time.Millisecond}
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	redisClient.AddHook(hook)
+	t.Cleanup(func() { redisClient.Close() })
+
+	engine := NewWorkflowEngine(db, redisClient, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	queue := NewWorkflowEventQueue(RedisGuardFor(redisClient, nil), &config.RedisConfig{EventPublishTimeout: 1000}, zap.NewNop())
+	engine.SetEventQueue(queue)
+	queue.Start()
+
+	first := &models.Workflow{ID: "workflow-1", ProjectID: "project-1", Status: models.WorkflowStatusRunning}
+	second := &models.Workflow{ID: "workflow-2", ProjectID: "project-1", Status: models.WorkflowStatusRunning}
+	emitted := []struct {
+		workflow  *models.Workflow
+		eventType string
+	}{
+		{first, "started"}, {second, "started"}, {first, WorkflowEventProgress},
+		{second, WorkflowEventProgress}, {first, "completed"}, {second, "failed"},
+	}
+
+	start := time.Now()
+	for _, event := range emitted {
+		engine.emitWorkflowEvent(context.Background(), event.workflow, event.eventType, nil)
+	}
+	assert.Less(t, time.Since(start), 50*time.Millisecond, "slow publishes do not hold up the emitting path")
+
+	queue.Stop()
+	assert.Zero(t, queue.Depth())
+
+	messages := hook.messages()
+	require.Len(t, messages, len(emitted), "every queued event is published by Stop")
+	for i, message := range messages {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(message), &event))
+		assert.Equal(t, emitted[i].workflow.ID, event["workflow_id"], "events are published in the order they were emitted")
+		assert.Equal(t, emitted[i].eventType, event["event_type"])
+	}
+
+	// Once stopped, events are published synchronously
+	engine.emitWorkflowEvent(context.Background(), first, "updated", nil)
+	assert.Len(t, hook.messages(), len(emitted)+1)
+}
+
+func TestWorkflowEventQueue_DropsLowPriorityEventsFirst(t *testing.T) {
+	sink := &gatedSink{gate: make(chan struct{})}
+	queue := NewWorkflowEventQueue(sink, &config.RedisConfig{EventQueueSize: 4, EventBlockTimeout: 20}, zap.NewNop())
+	droppedLow := testutil.ToFloat64(eventsDroppedTotal.WithLabelValues("low"))
+	droppedNormal := testutil.ToFloat64(eventsDroppedTotal.WithLabelValues("normal"))
+
+	// Without a running publisher nothing leaves the queue
+	assert.True(t, queue.Enqueue("events", []byte("low-1"), EventPriorityLow))
+	assert.True(t, queue.Enqueue("events", []byte("low-2"), EventPriorityLow))
+	assert.True(t, queue.Enqueue("events", []byte("normal-1"), EventPriorityNormal))
+	assert.False(t, queue.Enqueue("events", []byte("low-3"), EventPriorityLow), "low priority events are refused at three quarters")
+	assert.Equal(t, droppedLow+1, testutil.ToFloat64(eventsDroppedTotal.WithLabelValues("low")))
+
+	assert.True(t, queue.Enqueue("events", []byte("normal-2"), EventPriorityNormal))
+	assert.True(t, queue.Enqueue("events", []byte("high-1"), EventPriorityHigh), "high priority events replace low priority ones")
+	assert.True(t, queue.Enqueue("events", []byte("normal-3"), EventPriorityNormal), "so do normal ones")
+	assert.False(t, queue.Enqueue("events", []byte("normal-4"), EventPriorityNormal), "normal events never replace each other")
+	assert.Equal(t, droppedLow+3, testutil.ToFloat64(eventsDroppedTotal.WithLabelValues("low")))
+	assert.Equal(t, droppedNormal+1, testutil.ToFloat64(eventsDroppedTotal.WithLabelValues("normal")))
+
+	start := time.Now()
+	assert.True(t, queue.Enqueue("events", []byte("high-2"), EventPriorityHigh))
+	waited := time.Since(start)
+	assert.GreaterOrEqual(t, waited, 20*time.Millisecond, "high priority events wait for room first")
+	assert.Less(t, waited, time.Second)
+	assert.Equal(t, droppedNormal+2, testutil.ToFloat64(eventsDroppedTotal.WithLabelValues("normal")))
+	assert.Equal(t, 4, queue.Depth())
+
+	queue.Start()
+	close(sink.gate)
+	queue.Stop()
+	assert.Equal(t, []string{"normal-2", "high-1", "normal-3", "high-2"}, sink.messages())
+}
+
+func TestWorkflowEventQueue_HighPriorityEventTakesFreedRoom(t *testing.T) {
+	sink := &gatedSink{gate: make(chan struct{})}
+	queue := NewWorkflowEventQueue(sink, &config.RedisConfig{EventQueueSize: 2, EventBlockTimeout: 5000}, zap.NewNop())
+	queue.Start()
+
+	assert.True(t, queue.Enqueue("events", []byte("normal-1"), EventPriorityNormal))
+	require.Eventually(t, func() bool { return queue.Depth() == 0 }, time.Second, time.Millisecond,
+		"the publisher takes the first event and waits on the sink")
+	assert.True(t, queue.Enqueue("events", []byte("normal-2"), EventPriorityNormal))
+	assert.True(t, queue.Enqueue("events", []byte("normal-3"), EventPriorityNormal))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(sink.gate)
+	}()
+	start := time.Now()
+	assert.True(t, queue.Enqueue("events", []byte("high-1"), EventPriorityHigh))
+	assert.Less(t, time.Since(start), time.Second, "room freed by the publisher ends the wait")
+
+	queue.Stop()
+	assert.Equal(t, []string{"normal-1", "normal-2", "normal-3", "high-1"}, sink.messages(), "nothing was dropped")
+}
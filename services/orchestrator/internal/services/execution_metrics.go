@@ -0,0 +1,513 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ResourceUsageMessageType is the type of the messages agents report the
+// resource usage of running executions in
+const ResourceUsageMessageType = "resource_usage"
+
+// Names of the execution metrics the orchestrator records. Agents add their
+// own through the metrics of their outputs.
+const (
+	ExecutionMetricDuration      = "duration_ms"
+	ExecutionMetricRoundDuration = "round_duration_ms"
+	ExecutionMetricRoundScore    = "round_score"
+	ExecutionMetricRoundCost     = "round_cost"
+)
+
+// ErrInvalidMetricsQuery is returned for metric series queries that cannot
+// be answered
+var ErrInvalidMetricsQuery = errors.New("invalid metrics query")
+
+// Reasons a sample is dropped, recorded as the
+// execution_metrics_dropped_total reason label
+const (
+	metricDropBufferFull  = "buffer_full"
+	metricDropWriteFailed = "write_failed"
+)
+
+const (
+	// maxMetricWriteAttempts bounds the writes of a sample, so samples the
+	// database rejects, e.g. of a deleted execution, do not hold the buffer
+	maxMetricWriteAttempts = 3
+	// metricPurgeInterval is how often samples past their retention are
+	// deleted
+	metricPurgeInterval = time.Hour
+	// defaultMetricStep is the bucket width of series queried without one
+	defaultMetricStep = 30 * time.Second
+	// maxMetricBuckets bounds the buckets of a series
+	maxMetricBuckets = 2000
+)
+
+var (
+	executionMetricsWrittenTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_execution_metrics_written_total",
+		Help: "Execution metric samples written to the metrics table",
+	})
+	executionMetricsDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_execution_metrics_dropped_total",
+		Help: "Execution metric samples dropped, by reason",
+	}, []string{"reason"})
+	executionMetricsBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orchestrator_execution_metrics_buffered",
+		Help: "Execution metric samples waiting to be written",
+	})
+)
+
+// pendingMetric is a sample waiting to be written, with the writes that
+// failed for it
+type pendingMetric struct {
+	metric   models.Metric
+	attempts int
+}
+
+// MetricsRecorder writes time-stamped samples of execution metrics to the
+// metrics table. Samples are buffered in memory and written in batches by
+// one background flusher, so recording never waits on the database. The
+// buffer is bounded: while the database is slow or unreachable, samples
+// beyond it are dropped and counted. A nil recorder records nothing.
+type MetricsRecorder struct {
+	db            *gorm.DB
+	logger        *zap.Logger
+	flushInterval time.Duration
+	flushTimeout  time.Duration
+	batchSize     int
+	maxBuffered   int
+	retention     time.Duration
+	now           func() time.Time
+
+	mu     sync.Mutex
+	buffer []pendingMetric
+
+	flushNow chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewMetricsRecorder creates a recorder writing to the metrics table
+func NewMetricsRecorder(db *gorm.DB, cfg *config.ExecutionMetricsConfig, logger *zap.Logger) *MetricsRecorder {
+	return &MetricsRecorder{
+		db:            db,
+		logger:        logger,
+		flushInterval: time.Duration(cfg.FlushInterval) * time.Second,
+		flushTimeout:  time.Duration(cfg.FlushTimeout) * time.Second,
+		batchSize:     cfg.BatchSize,
+		maxBuffered:   cfg.MaxBuffered,
+		retention:     time.Duration(cfg.Retention) * time.Second,
+		now:           time.Now,
+		flushNow:      make(chan struct{}, 1),
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// Start starts the background flusher
+func (r *MetricsRecorder) Start() {
+	r.wg.Add(1)
+	go r.run()
+	r.logger.Info("Execution metrics recorder started",
+		zap.Duration("flush_interval", r.flushInterval),
+		zap.Duration("retention", r.retention))
+}
+
+// Stop stops the flusher and writes the samples still buffered
+func (r *MetricsRecorder) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+		r.wg.Wait()
+		if _, err := r.Flush(context.Background()); err != nil {
+			r.logger.Error("Failed to flush execution metrics on shutdown", zap.Error(err))
+		}
+		r.logger.Info("Execution metrics recorder stopped")
+	})
+}
+
+// run flushes the buffer every flush interval, or early when a batch is
+// full, and deletes samples past their retention every purge interval
+func (r *MetricsRecorder) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+	purge := time.NewTicker(metricPurgeInterval)
+	defer purge.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.flushNow:
+		case <-purge.C:
+			if _, err := r.Purge(context.Background()); err != nil {
+				r.logger.Error("Failed to purge execution metrics", zap.Error(err))
+			}
+			continue
+		case <-r.stopChan:
+			return
+		}
+		if _, err := r.Flush(context.Background()); err != nil {
+			r.logger.Warn("Failed to flush execution metrics", zap.Error(err))
+		}
+	}
+}
+
+// Record buffers samples for the next flush. Samples without a timestamp
+// are taken now, and samples naming no execution or metric are ignored. It
+// never blocks on the database: samples arriving while the buffer is full
+// are dropped.
+func (r *MetricsRecorder) Record(samples ...models.Metric) {
+	if r == nil {
+		return
+	}
+
+	now := r.now().UTC()
+	dropped := 0
+	r.mu.Lock()
+	for _, sample := range samples {
+		if sample.ExecutionID == "" || sample.Name == "" {
+			continue
+		}
+		if len(r.buffer) >= r.maxBuffered {
+			dropped++
+			continue
+		}
+		if sample.Timestamp.IsZero() {
+			sample.Timestamp = now
+		}
+		sample.Timestamp = sample.Timestamp.UTC()
+		if sample.Type == "" {
+			sample.Type = "gauge"
+		}
+		r.buffer = append(r.buffer, pendingMetric{metric: sample})
+	}
+	buffered := len(r.buffer)
+	r.mu.Unlock()
+
+	executionMetricsBuffered.Set(float64(buffered))
+	if dropped > 0 {
+		executionMetricsDroppedTotal.WithLabelValues(metricDropBufferFull).Add(float64(dropped))
+	}
+	if buffered >= r.batchSize {
+		select {
+		case r.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// RecordValues records a sample of each value for an execution at the same
+// time, with the same tags
+func (r *MetricsRecorder) RecordValues(executionID string, at time.Time, values map[string]float64, tags map[string]interface{}) {
+	if r == nil || len(values) == 0 {
+		return
+	}
+	var encodedTags json.RawMessage
+	if len(tags) > 0 {
+		data, err := json.Marshal(tags)
+		if err != nil {
+			r.logger.Warn("Ignoring unencodable metric tags", zap.String("execution_id", executionID), zap.Error(err))
+		} else {
+			encodedTags = data
+		}
+	}
+	samples := make([]models.Metric, 0, len(values))
+	for name, value := range values {
+		samples = append(samples, models.Metric{ExecutionID: executionID, Name: name, Value: value, Tags: encodedTags, Timestamp: at})
+	}
+	r.Record(samples...)
+}
+
+// Flush writes the buffered samples, batch by batch in one transaction, and
+// returns how many it wrote. Samples of a failed write go back to the buffer
+// as room allows, newest first, until they have failed
+// maxMetricWriteAttempts times.
+func (r *MetricsRecorder) Flush(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	pending := r.buffer
+	r.buffer = nil
+	r.mu.Unlock()
+	executionMetricsBuffered.Set(0)
+
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	batch := make([]models.Metric, len(pending))
+	for i, p := range pending {
+		batch[i] = p.metric
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.flushTimeout)
+	defer cancel()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(batch, r.batchSize).Error
+	})
+	if err == nil {
+		executionMetricsWrittenTotal.Add(float64(len(batch)))
+		return len(batch), nil
+	}
+
+	r.requeue(pending)
+	return 0, fmt.Errorf("failed to write %d metric samples: %w", len(batch), err)
+}
+
+// requeue puts the samples of a failed write back in front of the samples
+// recorded since, dropping those out of attempts or room
+func (r *MetricsRecorder) requeue(pending []pendingMetric) {
+	retry := make([]pendingMetric, 0, len(pending))
+	for _, p := range pending {
+		p.attempts++
+		// IDs the failed insert assigned were rolled back with it
+		p.metric.ID = ""
+		if p.attempts < maxMetricWriteAttempts {
+			retry = append(retry, p)
+		}
+	}
+	dropped := len(pending) - len(retry)
+
+	r.mu.Lock()
+	room := max(r.maxBuffered-len(r.buffer), 0)
+	if len(retry) > room {
+		dropped += len(retry) - room
+		retry = retry[len(retry)-room:]
+	}
+	r.buffer = append(retry, r.buffer...)
+	buffered := len(r.buffer)
+	r.mu.Unlock()
+
+	executionMetricsBuffered.Set(float64(buffered))
+	if dropped > 0 {
+		executionMetricsDroppedTotal.WithLabelValues(metricDropWriteFailed).Add(float64(dropped))
+	}
+}
+
+// Purge deletes the samples older than the retention and returns how many
+// it deleted. Samples are kept forever without a retention.
+func (r *MetricsRecorder) Purge(ctx context.Context) (int64, error) {
+	if r.retention <= 0 {
+		return 0, nil
+	}
+	result := r.db.WithContext(ctx).Where(`"timestamp" < ?`, r.now().UTC().Add(-r.retention)).Delete(&models.Metric{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge metric samples: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		r.logger.Info("Purged execution metrics", zap.Int64("samples", result.RowsAffected))
+	}
+	return result.RowsAffected, nil
+}
+
+// ResourceUsageReport is the payload of a resource_usage message: the
+// resource usage of an execution at a point in time
+type ResourceUsageReport struct {
+	ExecutionID string    `json:"execution_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	models.ResourceUsage
+}
+
+// ResourceUsageSamples returns a sample of each resource a usage report
+// covers. GPU usage is left out for executions without a GPU.
+func ResourceUsageSamples(executionID string, usage models.ResourceUsage, at time.Time) []models.Metric {
+	sample := func(name string, value float64, unit string) models.Metric {
+		return models.Metric{ExecutionID: executionID, Name: name, Value: value, Unit: unit, Timestamp: at}
+	}
+	samples := []models.Metric{
+		sample("cpu_usage", usage.CPUUsage, "percent"),
+		sample("memory_usage", float64(usage.MemoryUsage), "bytes"),
+		sample("memory_limit", float64(usage.MemoryLimit), "bytes"),
+		sample("network_rx_bytes", float64(usage.NetworkRxBytes), "bytes"),
+		sample("network_tx_bytes", float64(usage.NetworkTxBytes), "bytes"),
+		sample("disk_read_bytes", float64(usage.DiskReadBytes), "bytes"),
+		sample("disk_write_bytes", float64(usage.DiskWriteBytes), "bytes"),
+	}
+	if usage.GPUUsage != 0 || usage.GPUMemoryUsage != 0 {
+		samples = append(samples,
+			sample("gpu_usage", usage.GPUUsage, "percent"),
+			sample("gpu_memory_usage", float64(usage.GPUMemoryUsage), "bytes"))
+	}
+	return samples
+}
+
+// HandleMessage records the samples of a resource_usage message. Reports
+// without a timestamp are taken at the message's, or on arrival.
+func (r *MetricsRecorder) HandleMessage(agentID string, msg AgentMessage) {
+	var report ResourceUsageReport
+	if err := json.Unmarshal(msg.Payload, &report); err != nil || report.ExecutionID == "" {
+		r.logger.Warn("Ignoring malformed resource usage message",
+			zap.String("agentID", agentID),
+			zap.String("messageID", msg.ID),
+			zap.Error(err))
+		return
+	}
+	at := report.Timestamp
+	if at.IsZero() {
+		at = msg.Timestamp
+	}
+	r.Record(ResourceUsageSamples(report.ExecutionID, report.ResourceUsage, at)...)
+}
+
+// MetricsQuery selects the samples of a series report
+type MetricsQuery struct {
+	Name string // Every metric when empty
+	From time.Time
+	To   time.Time
+	// Step is the width of the buckets samples are downsampled to, in
+	// whole seconds. Without one, buckets are defaultMetricStep wide, or
+	// wider for ranges that would take more than maxMetricBuckets.
+	Step time.Duration
+}
+
+// MetricBucket summarizes the samples of a metric within a bucket
+type MetricBucket struct {
+	Start      time.Time `json:"start"`
+	Avg        float64   `json:"avg"`
+	Min        float64   `json:"min"`
+	Max        float64   `json:"max"`
+	Count      int64     `json:"count"`                // Samples in the bucket
+	Executions int64     `json:"executions,omitempty"` // Executions the samples are from, for workflow series
+}
+
+// MetricSeries is the buckets of one metric that hold samples, in time order
+type MetricSeries struct {
+	Name    string         `json:"name"`
+	Unit    string         `json:"unit,omitempty"`
+	Buckets []MetricBucket `json:"buckets"`
+}
+
+// MetricsReport is the downsampled series of an execution, or of every
+// execution of a workflow
+type MetricsReport struct {
+	ExecutionID string         `json:"execution_id,omitempty"`
+	WorkflowID  string         `json:"workflow_id,omitempty"`
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	StepMs      int64          `json:"step_ms"`
+	Series      []MetricSeries `json:"series"`
+}
+
+// ExecutionSeries downsamples the metrics of an execution
+func (r *MetricsRecorder) ExecutionSeries(ctx context.Context, executionID string, query MetricsQuery) (*MetricsReport, error) {
+	report, err := r.series(ctx, query, false, func(db *gorm.DB) *gorm.DB {
+		return db.Where("execution_id = ?", executionID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.ExecutionID = executionID
+	return report, nil
+}
+
+// WorkflowSeries downsamples the metrics of every execution of a workflow
+// together: each bucket summarizes the samples of all of them
+func (r *MetricsRecorder) WorkflowSeries(ctx context.Context, workflowID string, query MetricsQuery) (*MetricsReport, error) {
+	report, err := r.series(ctx, query, true, func(db *gorm.DB) *gorm.DB {
+		executions := r.db.Model(&models.Execution{}).Select("id").Where("workflow_id = ?", workflowID)
+		return db.Where("execution_id IN (?)", executions)
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.WorkflowID = workflowID
+	return report, nil
+}
+
+// metricBucketRow is a bucket of a metric as aggregated by the database
+type metricBucketRow struct {
+	Name       string
+	Unit       string
+	Bucket     int64
+	AvgValue   float64
+	MinValue   float64
+	MaxValue   float64
+	Samples    int64
+	Executions int64
+}
+
+// series aggregates the samples a scope selects into buckets, in the
+// database
+func (r *MetricsRecorder) series(ctx context.Context, query MetricsQuery, perExecution bool, scope func(*gorm.DB) *gorm.DB) (*MetricsReport, error) {
+	from, to := query.From.UTC(), query.To.UTC()
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidMetricsQuery)
+	}
+	step, err := metricStep(query.Step, to.Sub(from))
+	if err != nil {
+		return nil, err
+	}
+	seconds := int64(step / time.Second)
+
+	db := r.db.WithContext(ctx).Model(&models.Metric{}).Scopes(scope).
+		Where(`"timestamp" >= ? AND "timestamp" < ?`, from, to)
+	if query.Name != "" {
+		db = db.Where("name = ?", query.Name)
+	}
+	var rows []metricBucketRow
+	err = db.Select(fmt.Sprintf(`name, COALESCE(MAX(unit), '') AS unit, %s AS bucket, AVG(value) AS avg_value,
+		MIN(value) AS min_value, MAX(value) AS max_value, COUNT(*) AS samples,
+		COUNT(DISTINCT execution_id) AS executions`, metricBucketExpr(r.db, seconds))).
+		Group("name, bucket").Order("name, bucket").Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate metric samples: %w", err)
+	}
+
+	report := &MetricsReport{From: from, To: to, StepMs: step.Milliseconds(), Series: []MetricSeries{}}
+	for _, row := range rows {
+		if n := len(report.Series); n == 0 || report.Series[n-1].Name != row.Name {
+			report.Series = append(report.Series, MetricSeries{Name: row.Name, Unit: row.Unit})
+		}
+		bucket := MetricBucket{
+			Start: time.Unix(row.Bucket*seconds, 0).UTC(),
+			Avg:   row.AvgValue,
+			Min:   row.MinValue,
+			Max:   row.MaxValue,
+			Count: row.Samples,
+		}
+		if perExecution {
+			bucket.Executions = row.Executions
+		}
+		series := &report.Series[len(report.Series)-1]
+		series.Buckets = append(series.Buckets, bucket)
+	}
+	return report, nil
+}
+
+// metricStep checks the bucket width of a query over span, or picks one
+func metricStep(step, span time.Duration) (time.Duration, error) {
+	if step == 0 {
+		step = defaultMetricStep
+		if minimum := (span + maxMetricBuckets - 1) / maxMetricBuckets; step < minimum {
+			step = (minimum + time.Second - 1).Truncate(time.Second)
+		}
+		return step, nil
+	}
+	if step < time.Second || step%time.Second != 0 {
+		return 0, fmt.Errorf("%w: step must be a whole number of seconds", ErrInvalidMetricsQuery)
+	}
+	if buckets := (span + step - 1) / step; buckets > maxMetricBuckets {
+		return 0, fmt.Errorf("%w: the range spans %d buckets of %s, at most %d are allowed", ErrInvalidMetricsQuery, buckets, step, maxMetricBuckets)
+	}
+	return step, nil
+}
+
+// metricBucketExpr is the SQL numbering the step-wide bucket of a sample's
+// timestamp, counted from the Unix epoch. Postgres extracts the epoch; other
+// dialects (SQLite in tests) read it with strftime.
+func metricBucketExpr(db *gorm.DB, seconds int64) string {
+	if db.Dialector.Name() == "postgres" {
+		return fmt.Sprintf(`floor(extract(epoch from "timestamp") / %d)::bigint`, seconds)
+	}
+	return fmt.Sprintf(`CAST(strftime('%%s', "timestamp") AS INTEGER) / %d`, seconds)
+}
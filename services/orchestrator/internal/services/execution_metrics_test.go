@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// setupMetricsRecorder creates a recorder over an sqlite database holding the
+// metrics and executions tables, with its clock at 2026-10-18 09:30 UTC
+func setupMetricsRecorder(t *testing.T, cfg config.ExecutionMetricsConfig) (*MetricsRecorder, *gorm.DB, *time.Time) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE metrics (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), execution_id TEXT NOT NULL,
+			name TEXT NOT NULL, value REAL NOT NULL, unit TEXT, type TEXT, tags BLOB, timestamp DATETIME NOT NULL, created_at DATETIME)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, deleted_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	if cfg.BatchSize == 0 {
+		cfg = config.ExecutionMetricsConfig{FlushInterval: 5, FlushTimeout: 5, BatchSize: 100, MaxBuffered: 1000, Retention: 86400}
+	}
+	recorder := NewMetricsRecorder(db, &cfg, zap.NewNop())
+	now := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+	return recorder, db, &now
+}
+
+func metricCount(t *testing.T, db *gorm.DB) int64 {
+	var count int64
+	require.NoError(t, db.Model(&models.Metric{}).Count(&count).Error)
+	return count
+}
+
+func TestMetricsRecorder_BuffersAndFlushes(t *testing.T) {
+	recorder, db, now := setupMetricsRecorder(t, config.ExecutionMetricsConfig{
+		FlushInterval: 5, FlushTimeout: 5, BatchSize: 2, MaxBuffered: 4,
+	})
+
+	// Samples are buffered, not written, and the buffer is bounded
+	droppedBefore := testutil.ToFloat64(executionMetricsDroppedTotal.WithLabelValues(metricDropBufferFull))
+	for i := 0; i < 5; i++ {
+		recorder.Record(models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: float64(i)})
+	}
+	recorder.Record(models.Metric{Name: "no_execution", Value: 1})
+	assert.Zero(t, metricCount(t, db))
+	assert.Equal(t, 1.0, testutil.ToFloat64(executionMetricsDroppedTotal.WithLabelValues(metricDropBufferFull))-droppedBefore)
+	select {
+	case <-recorder.flushNow:
+	default:
+		t.Fatal("a full batch asks for an early flush")
+	}
+
+	written, err := recorder.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 4, written)
+	var stored []models.Metric
+	require.NoError(t, db.Order("value").Find(&stored).Error)
+	require.Len(t, stored, 4)
+	assert.True(t, stored[0].Timestamp.Equal(*now), "samples without a timestamp are taken now")
+	assert.Equal(t, "gauge", stored[0].Type)
+
+	written, err = recorder.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, written)
+}
+
+func TestMetricsRecorder_RequeuesFailedWrites(t *testing.T) {
+	recorder, db, _ := setupMetricsRecorder(t, config.ExecutionMetricsConfig{
+		FlushInterval: 5, FlushTimeout: 5, BatchSize: 2, MaxBuffered: 3,
+	})
+	require.NoError(t, db.Exec(`ALTER TABLE metrics RENAME TO metrics_unavailable`).Error)
+	buffered := func() []float64 {
+		values := []float64{}
+		for _, p := range recorder.buffer {
+			values = append(values, p.metric.Value)
+		}
+		return values
+	}
+	droppedBefore := testutil.ToFloat64(executionMetricsDroppedTotal.WithLabelValues(metricDropWriteFailed))
+
+	recorder.Record(
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 1},
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 2},
+	)
+	_, err := recorder.Flush(context.Background())
+	require.Error(t, err)
+	recorder.Record(models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 3})
+	assert.Equal(t, []float64{1, 2, 3}, buffered(), "failed samples are kept in order")
+	_, err = recorder.Flush(context.Background())
+	require.Error(t, err)
+
+	// Samples that keep failing are dropped after maxMetricWriteAttempts
+	_, err = recorder.Flush(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, []float64{3}, buffered())
+	assert.Equal(t, 2.0, testutil.ToFloat64(executionMetricsDroppedTotal.WithLabelValues(metricDropWriteFailed))-droppedBefore)
+
+	// Failed samples go back in front of the ones recorded during the
+	// write as room allows, the oldest dropped first
+	pending := recorder.buffer
+	recorder.buffer = nil
+	recorder.Record(
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 4},
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 5},
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Value: 6},
+	)
+	recorder.requeue(pending)
+	assert.Equal(t, []float64{4, 5, 6}, buffered())
+	assert.Equal(t, 3.0, testutil.ToFloat64(executionMetricsDroppedTotal.WithLabelValues(metricDropWriteFailed))-droppedBefore)
+
+	require.NoError(t, db.Exec(`ALTER TABLE metrics_unavailable RENAME TO metrics`).Error)
+	written, err := recorder.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, written)
+}
+
+func TestMetricsRecorder_Purge(t *testing.T) {
+	recorder, db, now := setupMetricsRecorder(t, config.ExecutionMetricsConfig{})
+	recorder.Record(
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Timestamp: now.Add(-25 * time.Hour)},
+		models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Timestamp: now.Add(-time.Hour)},
+	)
+	_, err := recorder.Flush(context.Background())
+	require.NoError(t, err)
+
+	purged, err := recorder.Purge(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, purged)
+	assert.EqualValues(t, 1, metricCount(t, db))
+
+	// Without a retention samples are kept
+	recorder.retention = 0
+	purged, err = recorder.Purge(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+}
+
+func TestMetricsRecorder_HandleResourceUsage(t *testing.T) {
+	recorder, db, now := setupMetricsRecorder(t, config.ExecutionMetricsConfig{})
+	payload, err := json.Marshal(ResourceUsageReport{
+		ExecutionID:   "execution-1",
+		ResourceUsage: models.ResourceUsage{CPUUsage: 42.5, MemoryUsage: 1 << 20},
+	})
+	require.NoError(t, err)
+
+	recorder.HandleMessage("agent-1", AgentMessage{Type: ResourceUsageMessageType, Payload: payload, Timestamp: now.Add(-time.Minute)})
+	recorder.HandleMessage("agent-1", AgentMessage{Type: ResourceUsageMessageType, Payload: []byte(`{"cpu_usage": 1}`)})
+	_, err = recorder.Flush(context.Background())
+	require.NoError(t, err)
+
+	var cpu models.Metric
+	require.NoError(t, db.First(&cpu, "name = ?", "cpu_usage").Error)
+	assert.Equal(t, 42.5, cpu.Value)
+	assert.Equal(t, "percent", cpu.Unit)
+	assert.True(t, cpu.Timestamp.Equal(now.Add(-time.Minute)), "reports are taken at their message's timestamp")
+	assert.EqualValues(t, 7, metricCount(t, db), "GPU usage is left out without a GPU")
+}
+
+func TestMetricsRecorder_ExecutionSeries(t *testing.T) {
+	recorder, _, now := setupMetricsRecorder(t, config.ExecutionMetricsConfig{})
+	start := now.Add(-time.Hour).Truncate(time.Minute)
+	for _, sample := range []struct {
+		offset time.Duration
+		value  float64
+	}{
+		{0, 10}, {10 * time.Second, 20}, {29 * time.Second, 60}, // First bucket
+		{30 * time.Second, 5}, // Second bucket
+		{95 * time.Second, 7}, // Fourth; the third has no samples
+	} {
+		recorder.Record(models.Metric{ExecutionID: "execution-1", Name: "cpu_usage", Unit: "percent", Value: sample.value, Timestamp: start.Add(sample.offset)})
+	}
+	recorder.Record(
+		models.Metric{ExecutionID: "execution-1", Name: "memory_usage", Value: 100, Timestamp: start},
+		models.Metric{ExecutionID: "execution-2", Name: "cpu_usage", Value: 99, Timestamp: start},
+	)
+	_, err := recorder.Flush(context.Background())
+	require.NoError(t, err)
+
+	report, err := recorder.ExecutionSeries(context.Background(), "execution-1", MetricsQuery{
+		Name: "cpu_usage", From: start, To: *now, Step: 30 * time.Second,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "execution-1", report.ExecutionID)
+	assert.EqualValues(t, 30000, report.StepMs)
+	require.Len(t, report.Series, 1)
+	assert.Equal(t, "percent", report.Series[0].Unit)
+	assert.Equal(t, []MetricBucket{
+		{Start: start, Avg: 30, Min: 10, Max: 60, Count: 3},
+		{Start: start.Add(30 * time.Second), Avg: 5, Min: 5, Max: 5, Count: 1},
+		{Start: start.Add(90 * time.Second), Avg: 7, Min: 7, Max: 7, Count: 1},
+	}, report.Series[0].Buckets)
+
+	// Every metric, downsampled to a wider step
+	report, err = recorder.ExecutionSeries(context.Background(), "execution-1", MetricsQuery{From: start, To: *now, Step: time.Minute})
+	require.NoError(t, err)
+	require.Len(t, report.Series, 2)
+	assert.Equal(t, "cpu_usage", report.Series[0].Name)
+	assert.Equal(t, MetricBucket{Start: start, Avg: 23.75, Min: 5, Max: 60, Count: 4}, report.Series[0].Buckets[0])
+	assert.Equal(t, "memory_usage", report.Series[1].Name)
+
+	// Samples outside the range are left out
+	report, err = recorder.ExecutionSeries(context.Background(), "execution-1", MetricsQuery{From: start.Add(time.Minute), To: *now, Step: time.Minute})
+	require.NoError(t, err)
+	require.Len(t, report.Series, 1)
+	assert.Equal(t, int64(1), report.Series[0].Buckets[0].Count)
+}
+
+func TestMetricsRecorder_WorkflowSeries(t *testing.T) {
+	recorder, db, now := setupMetricsRecorder(t, config.ExecutionMetricsConfig{})
+	require.NoError(t, db.Exec(`INSERT INTO executions (id, workflow_id) VALUES ('execution-1', 'workflow-1'), ('execution-2', 'workflow-1'),
+		('execution-3', 'workflow-2')`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO executions (id, workflow_id, deleted_at) VALUES ('execution-4', 'workflow-1', ?)`, *now).Error)
+
+	start := now.Add(-10 * time.Minute)
+	for executionID, value := range map[string]float64{"execution-1": 10, "execution-2": 30, "execution-3": 1000, "execution-4": 1000} {
+		recorder.Record(
+			models.Metric{ExecutionID: executionID, Name: "cpu_usage", Value: value, Timestamp: start},
+			models.Metric{ExecutionID: executionID, Name: "cpu_usage", Value: value + 10, Timestamp: start.Add(5 * time.Second)},
+		)
+	}
+	_, err := recorder.Flush(context.Background())
+	require.NoError(t, err)
+
+	report, err := recorder.WorkflowSeries(context.Background(), "workflow-1", MetricsQuery{From: start, To: *now})
+	require.NoError(t, err)
+	assert.Equal(t, "workflow-1", report.WorkflowID)
+	assert.EqualValues(t, defaultMetricStep.Milliseconds(), report.StepMs)
+	require.Len(t, report.Series, 1)
+	assert.Equal(t, []MetricBucket{{Start: start, Avg: 25, Min: 10, Max: 40, Count: 4, Executions: 2}}, report.Series[0].Buckets)
+}
+
+func TestMetricStep(t *testing.T) {
+	step, err := metricStep(0, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, defaultMetricStep, step)
+
+	// Long ranges get wider buckets without a step
+	step, err = metricStep(0, 30*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1296*time.Second, step)
+
+	_, err = metricStep(1500*time.Millisecond, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidMetricsQuery)
+	_, err = metricStep(time.Second, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidMetricsQuery, "3600 buckets")
+}
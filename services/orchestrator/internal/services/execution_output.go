@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrOutputNotFound is returned by output stores for unknown keys
+var ErrOutputNotFound = errors.New("output not found")
+
+// outputTruncationMarker separates the start and the end of an offloaded
+// output's preview
+const outputTruncationMarker = "\n[... %d bytes truncated; full output in artifact storage ...]\n"
+
+var outputsOffloaded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_outputs_offloaded_total",
+	Help: "Execution outputs too large to store inline, offloaded to artifact storage, by content type",
+}, []string{"content_type"})
+
+// OutputStore keeps offloaded outputs. Every replica and worker must reach
+// the same store.
+type OutputStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error) // Error wrapping ErrOutputNotFound for unknown keys
+}
+
+// fileOutputStore keeps offloaded outputs as files in a directory, e.g. a
+// volume shared by all replicas
+type fileOutputStore struct {
+	dir string
+}
+
+// NewFileOutputStore stores offloaded outputs in dir, creating it if needed
+func NewFileOutputStore(dir string) (OutputStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create output store directory: %w", err)
+	}
+	return &fileOutputStore{dir: dir}, nil
+}
+
+// path spreads outputs over subdirectories by key prefix
+func (s *fileOutputStore) path(key string) (string, error) {
+	if len(key) < 3 || filepath.Base(key) != key {
+		return "", fmt.Errorf("invalid output key %q", key)
+	}
+	return filepath.Join(s.dir, key[:2], key), nil
+}
+
+func (s *fileOutputStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	// Keys are content hashes: an existing file already holds the output
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	// Write then rename, so readers never see a partial output
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fileOutputStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrOutputNotFound, key)
+	}
+	return data, err
+}
+
+// OutputPolicy keeps execution outputs up to max_inline_bytes inline, in the
+// database and in workflow payloads. Larger outputs are written to the output
+// store, recorded as output artifacts of their execution and replaced by an
+// OffloadedOutput previewing their first and last preview_bytes. A nil policy
+// keeps every output inline.
+type OutputPolicy struct {
+	db           *gorm.DB
+	store        OutputStore
+	storage      *StorageAccountant
+	maxInline    int
+	previewBytes int
+	logger       *zap.Logger
+}
+
+// NewOutputPolicy creates an output policy offloading to store
+func NewOutputPolicy(db *gorm.DB, store OutputStore, cfg *config.OutputsConfig, logger *zap.Logger) *OutputPolicy {
+	return &OutputPolicy{
+		db:           db,
+		store:        store,
+		maxInline:    cfg.MaxInlineBytes,
+		previewBytes: cfg.PreviewBytes,
+		logger:       logger,
+	}
+}
+
+// SetStorageAccountant makes output artifacts count against their project's
+// storage
+func (p *OutputPolicy) SetStorageAccountant(storage *StorageAccountant) {
+	p.storage = storage
+}
+
+// Limit returns an execution's JSON output as it is to be stored: as is when
+// it fits inline, else the OffloadedOutput of the output, which is then in
+// the store and recorded as an output artifact of the execution
+func (p *OutputPolicy) Limit(ctx context.Context, projectID, executionID string, output []byte) ([]byte, *models.OffloadedOutput, error) {
+	if !p.exceeds(output) {
+		return output, nil, nil
+	}
+	offloaded, err := p.offload(ctx, output, "application/json")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.RecordArtifact(ctx, projectID, executionID, offloaded, "application/json"); err != nil {
+		return nil, nil, err
+	}
+	stored, err := json.Marshal(offloaded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal offloaded output: %w", err)
+	}
+	return stored, offloaded, nil
+}
+
+// LimitText returns the text output of a run that has no execution yet,
+// such as the stdout passed between activities: as is when it fits inline,
+// else its preview, with the full text in the store. The artifact is
+// recorded once the run's execution is, with RecordArtifact.
+func (p *OutputPolicy) LimitText(ctx context.Context, text string) (string, *models.OffloadedOutput, error) {
+	if !p.exceeds([]byte(text)) {
+		return text, nil, nil
+	}
+	offloaded, err := p.offload(ctx, []byte(text), "text/plain")
+	if err != nil {
+		return "", nil, err
+	}
+	return offloaded.Preview, offloaded, nil
+}
+
+// RecordArtifact records an offloaded output as the output artifact of an
+// execution and sets its ArtifactID. Retries record the artifact once.
+func (p *OutputPolicy) RecordArtifact(ctx context.Context, projectID, executionID string, offloaded *models.OffloadedOutput, contentType string) error {
+	artifact := models.Artifact{
+		ID:          uuid.NewSHA1(uuid.NameSpaceURL, []byte("output:"+executionID)).String(),
+		ExecutionID: executionID,
+		Name:        "output",
+		Type:        models.OutputArtifactType,
+		Path:        offloaded.OutputRef,
+		Size:        int64(offloaded.Size),
+		Checksum:    offloaded.Checksum,
+		ContentType: contentType,
+	}
+	var err error
+	if p.storage != nil {
+		err = p.storage.StoreArtifacts(ctx, projectID, []models.Artifact{artifact})
+	} else {
+		err = p.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&artifact).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record output artifact: %w", err)
+	}
+	offloaded.ArtifactID = artifact.ID
+	return nil
+}
+
+// FullOutput returns a stored output in full, read through its reference
+// when it was offloaded
+func (p *OutputPolicy) FullOutput(ctx context.Context, output []byte) ([]byte, error) {
+	offloaded, ok := models.ParseOffloadedOutput(output)
+	if !ok {
+		return output, nil
+	}
+	if p == nil || p.store == nil {
+		return nil, fmt.Errorf("output %s was offloaded but no output store is configured", offloaded.OutputRef)
+	}
+	data, err := p.store.Get(ctx, offloaded.OutputRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offloaded output: %w", err)
+	}
+	return data, nil
+}
+
+func (p *OutputPolicy) exceeds(output []byte) bool {
+	return p != nil && p.maxInline > 0 && len(output) > p.maxInline
+}
+
+// offload writes an output to the store under its content hash
+func (p *OutputPolicy) offload(ctx context.Context, output []byte, contentType string) (*models.OffloadedOutput, error) {
+	sum := sha256.Sum256(output)
+	key := hex.EncodeToString(sum[:])
+	if err := p.store.Put(ctx, key, output); err != nil {
+		return nil, fmt.Errorf("failed to offload output: %w", err)
+	}
+	outputsOffloaded.WithLabelValues(contentType).Inc()
+	p.logger.Info("Offloaded output too large to store inline",
+		zap.String("output_ref", key),
+		zap.Int("size", len(output)),
+		zap.Int("max_inline_bytes", p.maxInline))
+
+	return &models.OffloadedOutput{
+		Truncated: true,
+		OutputRef: key,
+		Size:      len(output),
+		Checksum:  key,
+		Preview:   TruncatedPreview(output, p.previewBytes),
+	}, nil
+}
+
+// TruncatedPreview returns the first and last keep bytes of an output with a
+// truncation marker saying how many bytes were left out in between. Cuts
+// fall on UTF-8 character boundaries.
+func TruncatedPreview(output []byte, keep int) string {
+	if len(output) <= 2*keep {
+		return string(output)
+	}
+	head := keep
+	for head > 0 && !utf8.RuneStart(output[head]) {
+		head--
+	}
+	tail := len(output) - keep
+	for tail < len(output) && !utf8.RuneStart(output[tail]) {
+		tail++
+	}
+	return string(output[:head]) + fmt.Sprintf(outputTruncationMarker, tail-head) + string(output[tail:])
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// setupOutputPolicy returns an output policy keeping up to maxInline bytes
+// inline, offloading to a temporary directory
+func setupOutputPolicy(t *testing.T, maxInline, previewBytes int) (*OutputPolicy, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT,
+		path TEXT, url TEXT, size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+		workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`).Error)
+
+	store, err := NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	return NewOutputPolicy(db, store, &config.OutputsConfig{MaxInlineBytes: maxInline, PreviewBytes: previewBytes}, zap.NewNop()), db
+}
+
+// jsonOutputOfSize returns a JSON output of exactly size bytes
+func jsonOutputOfSize(size int) []byte {
+	return []byte(`{"log":"` + strings.Repeat("x", size-10) + `"}`)
+}
+
+func TestOutputPolicy_OffloadsOutputsAboveThreshold(t *testing.T) {
+	ctx := context.Background()
+	policy, db := setupOutputPolicy(t, 100, 10)
+
+	atLimit := jsonOutputOfSize(100)
+	stored, offloaded, err := policy.Limit(ctx, "project-1", "execution-1", atLimit)
+	require.NoError(t, err)
+	assert.Nil(t, offloaded, "outputs of max_inline_bytes stay inline")
+	assert.Equal(t, atLimit, stored)
+
+	aboveLimit := jsonOutputOfSize(101)
+	stored, offloaded, err = policy.Limit(ctx, "project-1", "execution-2", aboveLimit)
+	require.NoError(t, err)
+	require.NotNil(t, offloaded)
+	assert.Equal(t, 101, offloaded.Size)
+	assert.Equal(t, offloaded.OutputRef, offloaded.Checksum)
+
+	parsed, ok := models.ParseOffloadedOutput(stored)
+	require.True(t, ok)
+	assert.Equal(t, *offloaded, *parsed)
+	_, ok = models.ParseOffloadedOutput(atLimit)
+	assert.False(t, ok)
+
+	// Retried activities record the artifact once
+	_, _, err = policy.Limit(ctx, "project-1", "execution-2", aboveLimit)
+	require.NoError(t, err)
+	var artifacts []models.Artifact
+	require.NoError(t, db.Find(&artifacts).Error)
+	require.Len(t, artifacts, 1)
+	assert.Equal(t, offloaded.ArtifactID, artifacts[0].ID)
+	assert.Equal(t, "execution-2", artifacts[0].ExecutionID)
+	assert.Equal(t, models.OutputArtifactType, artifacts[0].Type)
+	assert.EqualValues(t, 101, artifacts[0].Size)
+
+	// A nil policy keeps every output inline
+	stored, offloaded, err = (*OutputPolicy)(nil).Limit(ctx, "project-1", "execution-3", aboveLimit)
+	require.NoError(t, err)
+	assert.Nil(t, offloaded)
+	assert.Equal(t, aboveLimit, stored)
+}
+
+func TestOutputPolicy_RehydratesOffloadedOutputs(t *testing.T) {
+	ctx := context.Background()
+	policy, _ := setupOutputPolicy(t, 100, 10)
+
+	output := jsonOutputOfSize(500)
+	stored, _, err := policy.Limit(ctx, "project-1", "execution-1", output)
+	require.NoError(t, err)
+	assert.Less(t, len(stored), len(output))
+
+	full, err := policy.FullOutput(ctx, stored)
+	require.NoError(t, err)
+	assert.Equal(t, output, full)
+
+	inline := []byte(`{"result":"ok"}`)
+	full, err = policy.FullOutput(ctx, inline)
+	require.NoError(t, err)
+	assert.Equal(t, inline, full)
+
+	text, offloaded, err := policy.LimitText(ctx, strings.Repeat("line\n", 40))
+	require.NoError(t, err)
+	require.NotNil(t, offloaded)
+	assert.Equal(t, offloaded.Preview, text)
+
+	// Outputs missing from the store cannot be rehydrated
+	missing, err := json.Marshal(models.OffloadedOutput{Truncated: true, OutputRef: strings.Repeat("0", 64)})
+	require.NoError(t, err)
+	_, err = policy.FullOutput(ctx, missing)
+	assert.ErrorIs(t, err, ErrOutputNotFound)
+	_, err = (*OutputPolicy)(nil).FullOutput(ctx, stored)
+	assert.Error(t, err)
+}
+
+func TestTruncatedPreview_KeepsHeadAndTailWithMarker(t *testing.T) {
+	output := []byte(strings.Repeat("a", 10) + strings.Repeat("-", 80) + strings.Repeat("z", 10))
+	preview := TruncatedPreview(output, 10)
+	assert.Equal(t, strings.Repeat("a", 10)+fmt.Sprintf(outputTruncationMarker, 80)+strings.Repeat("z", 10), preview)
+	assert.Contains(t, preview, "[... 80 bytes truncated; full output in artifact storage ...]")
+
+	short := []byte("fits in the preview")
+	assert.Equal(t, string(short), TruncatedPreview(short, 10))
+
+	// Cuts never split a character
+	multibyte := []byte(strings.Repeat("é", 20))
+	preview = TruncatedPreview(multibyte, 5)
+	assert.True(t, strings.HasPrefix(preview, "éé\n"))
+	assert.True(t, strings.HasSuffix(preview, "\néé"))
+	assert.Contains(t, preview, "[... 32 bytes truncated")
+}
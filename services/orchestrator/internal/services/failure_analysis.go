@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"orchestrator/internal/errorclass"
+	"orchestrator/internal/errorsig"
+	"orchestrator/internal/models"
+)
+
+const (
+	// failureSampleSize is the number of workflow IDs sampled per cluster
+	failureSampleSize = 5
+	// maxFailureClusters is the number of clusters reported, largest first
+	maxFailureClusters = 50
+	// agentCorrelationMinFailures is the size a cluster needs before a
+	// dominant agent is flagged
+	agentCorrelationMinFailures = 3
+)
+
+var (
+	failedWorkflowStatuses  = []models.WorkflowStatus{models.WorkflowStatusFailed, models.WorkflowStatusTimedOut}
+	failedExecutionStatuses = []models.ExecutionStatus{models.ExecutionStatusFailed, models.ExecutionStatusTimedOut}
+)
+
+// FailureCluster groups the failures sharing an error class and signature
+type FailureCluster struct {
+	Class             string    `json:"class"` // timeout, auth, quota, validation, crash or unknown
+	Signature         string    `json:"signature"`
+	Count             int       `json:"count"`
+	WorkflowFailures  int       `json:"workflow_failures"`
+	ExecutionFailures int       `json:"execution_failures"`
+	FirstSeen         time.Time `json:"first_seen"`
+	LastSeen          time.Time `json:"last_seen"`
+	WorkflowTypes     []string  `json:"workflow_types"`
+	SampleWorkflowIDs []string  `json:"sample_workflow_ids"`
+
+	// AgentCorrelated is set when a single agent accounts for most of the
+	// failures of the cluster, pointing at the agent rather than the work
+	AgentCorrelated    bool    `json:"agent_correlated"`
+	DominantAgentID    string  `json:"dominant_agent_id,omitempty"`
+	DominantAgentShare float64 `json:"dominant_agent_share,omitempty"`
+}
+
+// FailureAnalysis groups a project's failed workflows and executions over a
+// time window by error class and signature
+type FailureAnalysis struct {
+	ProjectID       string           `json:"project_id"`
+	Since           time.Time        `json:"since"`
+	Until           time.Time        `json:"until"`
+	TotalFailures   int              `json:"total_failures"`
+	Clusters        []FailureCluster `json:"clusters"`
+	OmittedClusters int              `json:"omitted_clusters,omitempty"`
+}
+
+// failedRun is a failed workflow or execution
+type failedRun struct {
+	id, workflowID, workflowType, agentID, class, signature string
+	at                                                      time.Time
+	execution                                               bool
+}
+
+// SetErrorNormalizer sets the normalizer computing the signature of failures
+// stored without one
+func (s *ProjectService) SetErrorNormalizer(normalizer *errorsig.Normalizer) {
+	s.errorNormalizer = normalizer
+}
+
+// GetFailureAnalysis groups the workflows and executions of a project that
+// failed in [since, until) by error class and signature. Failed workflows are
+// attributed to the agent of their last failed execution.
+func (s *ProjectService) GetFailureAnalysis(ctx context.Context, projectID string, since, until time.Time) (*FailureAnalysis, error) {
+	var workflows []models.Workflow
+	if err := s.db.WithContext(ctx).
+		Select("id", "type", "error_class", "error_signature", "completed_at", "updated_at").
+		Where("project_id = ? AND status IN ? AND simulated = ? AND updated_at >= ? AND updated_at < ?",
+			projectID, failedWorkflowStatuses, false, since, until).
+		Find(&workflows).Error; err != nil {
+		return nil, fmt.Errorf("failed to list failed workflows: %w", err)
+	}
+
+	var executions []models.Execution
+	if err := s.db.WithContext(ctx).
+		Select("id", "workflow_id", "agent_id", "error_class", "error_signature", "completed_at", "updated_at").
+		Where("project_id = ? AND status IN ? AND simulated = ? AND updated_at >= ? AND updated_at < ?",
+			projectID, failedExecutionStatuses, false, since, until).
+		Order("updated_at").
+		Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list failed executions: %w", err)
+	}
+
+	failures := make([]*failedRun, 0, len(workflows)+len(executions))
+	workflowTypes := make(map[string]string, len(workflows))
+	for _, workflow := range workflows {
+		workflowTypes[workflow.ID] = string(workflow.Type)
+		failures = append(failures, &failedRun{
+			id:           workflow.ID,
+			workflowID:   workflow.ID,
+			workflowType: string(workflow.Type),
+			class:        workflow.ErrorClass,
+			signature:    workflow.ErrorSignature,
+			at:           failedAt(workflow.CompletedAt, workflow.UpdatedAt),
+		})
+	}
+	// Ordered by time, so the last failed execution of a workflow wins
+	lastAgents := make(map[string]string)
+	for _, execution := range executions {
+		if execution.AgentID != "" && execution.WorkflowID != "" {
+			lastAgents[execution.WorkflowID] = execution.AgentID
+		}
+		failures = append(failures, &failedRun{
+			id:         execution.ID,
+			workflowID: execution.WorkflowID,
+			agentID:    execution.AgentID,
+			class:      execution.ErrorClass,
+			signature:  execution.ErrorSignature,
+			at:         failedAt(execution.CompletedAt, execution.UpdatedAt),
+			execution:  true,
+		})
+	}
+	for _, f := range failures {
+		if !f.execution {
+			f.agentID = lastAgents[f.workflowID]
+		}
+	}
+
+	if err := s.resolveExecutionWorkflowTypes(ctx, failures, workflowTypes); err != nil {
+		return nil, err
+	}
+	if err := s.describeLegacyFailures(ctx, failures); err != nil {
+		return nil, err
+	}
+
+	analysis := &FailureAnalysis{
+		ProjectID:     projectID,
+		Since:         since,
+		Until:         until,
+		TotalFailures: len(failures),
+		Clusters:      clusterFailures(failures),
+	}
+	if len(analysis.Clusters) > maxFailureClusters {
+		analysis.OmittedClusters = len(analysis.Clusters) - maxFailureClusters
+		analysis.Clusters = analysis.Clusters[:maxFailureClusters]
+	}
+	return analysis, nil
+}
+
+// resolveExecutionWorkflowTypes sets the workflow type of failed executions
+// whose workflow did not fail in the window
+func (s *ProjectService) resolveExecutionWorkflowTypes(ctx context.Context, failures []*failedRun, workflowTypes map[string]string) error {
+	var missing []string
+	for _, f := range failures {
+		if _, ok := workflowTypes[f.workflowID]; !ok && f.workflowID != "" {
+			workflowTypes[f.workflowID] = ""
+			missing = append(missing, f.workflowID)
+		}
+	}
+	if len(missing) > 0 {
+		var workflows []models.Workflow
+		if err := s.db.WithContext(ctx).Select("id", "type").Where("id IN ?", missing).Find(&workflows).Error; err != nil {
+			return fmt.Errorf("failed to load workflow types: %w", err)
+		}
+		for _, workflow := range workflows {
+			workflowTypes[workflow.ID] = string(workflow.Type)
+		}
+	}
+	for _, f := range failures {
+		f.workflowType = workflowTypes[f.workflowID]
+	}
+	return nil
+}
+
+// describeLegacyFailures computes the signature and class of failures
+// stored before they were recorded, reading only their errors
+func (s *ProjectService) describeLegacyFailures(ctx context.Context, failures []*failedRun) error {
+	var workflowIDs, executionIDs []string
+	for _, f := range failures {
+		switch {
+		case f.signature != "" && f.class != "":
+		case f.execution:
+			executionIDs = append(executionIDs, f.id)
+		default:
+			workflowIDs = append(workflowIDs, f.id)
+		}
+	}
+	if len(workflowIDs) == 0 && len(executionIDs) == 0 {
+		return nil
+	}
+
+	errorsByID := make(map[string]string)
+	if len(workflowIDs) > 0 {
+		var workflows []models.Workflow
+		if err := s.db.WithContext(ctx).Select("id", "error").Where("id IN ?", workflowIDs).Find(&workflows).Error; err != nil {
+			return fmt.Errorf("failed to load workflow errors: %w", err)
+		}
+		for _, workflow := range workflows {
+			errorsByID[workflow.ID] = workflow.Error
+		}
+	}
+	if len(executionIDs) > 0 {
+		var executions []models.Execution
+		if err := s.db.WithContext(ctx).Select("id", "error").Where("id IN ?", executionIDs).Find(&executions).Error; err != nil {
+			return fmt.Errorf("failed to load execution errors: %w", err)
+		}
+		for _, execution := range executions {
+			errorsByID[execution.ID] = execution.Error
+		}
+	}
+
+	normalizer := s.errorNormalizer
+	if normalizer == nil {
+		normalizer = errorsig.Default()
+	}
+	for _, f := range failures {
+		if f.signature == "" {
+			f.signature = normalizer.Signature(errorsByID[f.id])
+		}
+		if f.class == "" {
+			f.class = string(errorclass.Classify(errorsByID[f.id]))
+		}
+	}
+	return nil
+}
+
+// clusterFailures groups failures by class and signature, largest cluster
+// first. Failures without an error are of no class.
+func clusterFailures(failures []*failedRun) []FailureCluster {
+	type clusterKey struct{ class, signature string }
+	type clusterState struct {
+		cluster   *FailureCluster
+		types     map[string]bool
+		workflows map[string]bool
+		agents    map[string]int
+	}
+	states := make(map[clusterKey]*clusterState)
+	for _, f := range failures {
+		key := clusterKey{f.class, f.signature}
+		state, ok := states[key]
+		if !ok {
+			state = &clusterState{
+				cluster: &FailureCluster{
+					Class:             f.class,
+					Signature:         f.signature,
+					FirstSeen:         f.at,
+					LastSeen:          f.at,
+					WorkflowTypes:     []string{},
+					SampleWorkflowIDs: []string{},
+				},
+				types:     make(map[string]bool),
+				workflows: make(map[string]bool),
+				agents:    make(map[string]int),
+			}
+			states[key] = state
+		}
+
+		cluster := state.cluster
+		cluster.Count++
+		if f.execution {
+			cluster.ExecutionFailures++
+		} else {
+			cluster.WorkflowFailures++
+		}
+		if f.at.Before(cluster.FirstSeen) {
+			cluster.FirstSeen = f.at
+		}
+		if f.at.After(cluster.LastSeen) {
+			cluster.LastSeen = f.at
+		}
+		if f.workflowType != "" {
+			state.types[f.workflowType] = true
+		}
+		if f.workflowID != "" {
+			state.workflows[f.workflowID] = true
+		}
+		if f.agentID != "" {
+			state.agents[f.agentID]++
+		}
+	}
+
+	clusters := make([]FailureCluster, 0, len(states))
+	for _, state := range states {
+		cluster := state.cluster
+		for workflowType := range state.types {
+			cluster.WorkflowTypes = append(cluster.WorkflowTypes, workflowType)
+		}
+		sort.Strings(cluster.WorkflowTypes)
+
+		workflowIDs := make([]string, 0, len(state.workflows))
+		for workflowID := range state.workflows {
+			workflowIDs = append(workflowIDs, workflowID)
+		}
+		sort.Strings(workflowIDs)
+		if len(workflowIDs) > failureSampleSize {
+			workflowIDs = workflowIDs[:failureSampleSize]
+		}
+		cluster.SampleWorkflowIDs = append(cluster.SampleWorkflowIDs, workflowIDs...)
+
+		// "Most" is a strict majority of the cluster's failures
+		for agentID, count := range state.agents {
+			if cluster.Count >= agentCorrelationMinFailures && count*2 > cluster.Count {
+				cluster.AgentCorrelated = true
+				cluster.DominantAgentID = agentID
+				cluster.DominantAgentShare = float64(count) / float64(cluster.Count)
+			}
+		}
+		clusters = append(clusters, *cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
+		}
+		if !clusters[i].LastSeen.Equal(clusters[j].LastSeen) {
+			return clusters[i].LastSeen.After(clusters[j].LastSeen)
+		}
+		if clusters[i].Signature != clusters[j].Signature {
+			return clusters[i].Signature < clusters[j].Signature
+		}
+		return clusters[i].Class < clusters[j].Class
+	})
+	return clusters
+}
+
+// failedAt is when a workflow or execution failed: its completion, or its
+// last update when it has none
+func failedAt(completedAt *time.Time, updatedAt time.Time) time.Time {
+	if completedAt != nil {
+		return *completedAt
+	}
+	return updatedAt
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/errorsig"
+	"orchestrator/internal/models"
+)
+
+func TestProjectService_GetFailureAnalysis(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, errorsig.Register(db, errorsig.Default()))
+	require.NoError(t, db.Exec(`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, workflow_id TEXT,
+		agent_id TEXT, status TEXT, error TEXT, error_signature TEXT, error_class TEXT, simulated BOOLEAN, completed_at DATETIME,
+		updated_at DATETIME, deleted_at DATETIME)`).Error)
+
+	now := time.Now().UTC()
+	newWorkflow := func(projectID string, workflowType models.WorkflowType, status models.WorkflowStatus, message string) *models.Workflow {
+		workflow := &models.Workflow{Name: "wf", Type: workflowType, Status: status, ProjectID: projectID, Error: message}
+		require.NoError(t, db.Create(workflow).Error)
+		return workflow
+	}
+	// Executions are stored without a class, as before classes were recorded
+	var executions int
+	newExecution := func(workflowID, agentID, status, message string) {
+		executions++
+		signature := errorsig.Default().Signature(message)
+		require.NoError(t, db.Exec(`INSERT INTO executions (id, project_id, workflow_id, agent_id, status, error,
+			error_signature, simulated, updated_at) VALUES (?, 'project-1', ?, ?, ?, ?, ?, false, ?)`,
+			fmt.Sprintf("exec-%d", executions), workflowID, agentID, status, message, signature,
+			now.Add(-time.Duration(10-executions)*time.Minute)).Error)
+	}
+
+	// Three workflows fail at a step their executions lost to OOM kills,
+	// two of them on agent-1
+	var oomWorkflows []*models.Workflow
+	for i, agentID := range []string{"agent-1", "agent-2", "agent-1"} {
+		workflow := newWorkflow("project-1", models.WorkflowTypeExecution, models.WorkflowStatusFailed,
+			fmt.Sprintf("step 4f1c2a9e-0b7d-4e3a-9c55-0d1e2f3a4b5%d failed after %d.5s", i, 10+i))
+		oomWorkflows = append(oomWorkflows, workflow)
+		newExecution(workflow.ID, agentID, "failed", fmt.Sprintf("container OOMKilled: memory limit %d exceeded", 2147483648+i))
+	}
+	// The same kill on agent-1 hits a deployment that goes on running
+	running := newWorkflow("project-1", models.WorkflowTypeDeployment, models.WorkflowStatusRunning, "")
+	newExecution(running.ID, "agent-1", "timed_out", "container OOMKilled: memory limit 4294967296 exceeded")
+	newExecution(running.ID, "agent-1", "succeeded", "")
+
+	// The intent service fails two workflows of different types; one was
+	// stored before signatures were recorded
+	newWorkflow("project-1", models.WorkflowTypeIntent, models.WorkflowStatusFailed,
+		"intent service returned 503 at 2026-10-18T01:02:03Z")
+	legacy := newWorkflow("project-1", models.WorkflowTypeAnalysis, models.WorkflowStatusTimedOut,
+		"intent service returned 503 at 2026-10-18T04:05:06Z")
+	require.NoError(t, db.Model(legacy).UpdateColumn("error_signature", "").Error)
+
+	// Outside the analysis: other projects, simulations, older failures and
+	// other outcomes
+	newWorkflow("project-2", models.WorkflowTypeExecution, models.WorkflowStatusFailed, "intent service returned 503")
+	simulated := newWorkflow("project-1", models.WorkflowTypeExecution, models.WorkflowStatusFailed, "intent service returned 503")
+	require.NoError(t, db.Model(simulated).UpdateColumn("simulated", true).Error)
+	old := newWorkflow("project-1", models.WorkflowTypeExecution, models.WorkflowStatusFailed, "intent service returned 503")
+	require.NoError(t, db.Model(old).UpdateColumn("updated_at", now.Add(-48*time.Hour)).Error)
+	newWorkflow("project-1", models.WorkflowTypeExecution, models.WorkflowStatusCompleted, "")
+
+	service := NewProjectService(db, zap.NewNop())
+	analysis, err := service.GetFailureAnalysis(context.Background(), "project-1", now.Add(-24*time.Hour), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 9, analysis.TotalFailures)
+	require.Len(t, analysis.Clusters, 3)
+
+	oom := analysis.Clusters[0]
+	assert.Equal(t, "crash", oom.Class)
+	assert.Equal(t, "container OOMKilled: memory limit <n> exceeded", oom.Signature)
+	assert.Equal(t, 4, oom.Count)
+	assert.Equal(t, 4, oom.ExecutionFailures)
+	assert.Equal(t, []string{string(models.WorkflowTypeExecution), string(models.WorkflowTypeDeployment)}, oom.WorkflowTypes)
+	assert.Len(t, oom.SampleWorkflowIDs, 4)
+	assert.True(t, oom.AgentCorrelated)
+	assert.Equal(t, "agent-1", oom.DominantAgentID)
+	assert.InDelta(t, 0.75, oom.DominantAgentShare, 1e-9)
+	assert.True(t, oom.FirstSeen.Before(oom.LastSeen))
+
+	// Failed workflows are attributed to the agent of their failed execution
+	steps := analysis.Clusters[1]
+	assert.Equal(t, "unknown", steps.Class)
+	assert.Equal(t, "step <uuid> failed after <duration>", steps.Signature)
+	assert.Equal(t, 3, steps.WorkflowFailures)
+	assert.ElementsMatch(t, []string{oomWorkflows[0].ID, oomWorkflows[1].ID, oomWorkflows[2].ID}, steps.SampleWorkflowIDs)
+	assert.True(t, steps.AgentCorrelated)
+	assert.Equal(t, "agent-1", steps.DominantAgentID)
+
+	intent := analysis.Clusters[2]
+	assert.Equal(t, "unknown", intent.Class)
+	assert.Equal(t, "intent service returned 503 at <time>", intent.Signature)
+	assert.Equal(t, 2, intent.Count)
+	assert.Equal(t, []string{string(models.WorkflowTypeAnalysis), string(models.WorkflowTypeIntent)}, intent.WorkflowTypes)
+	assert.False(t, intent.AgentCorrelated)
+	assert.Empty(t, intent.DominantAgentID)
+}
+
+func TestClusterFailures_AgentCorrelation(t *testing.T) {
+	at := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	cluster := func(agents ...string) FailureCluster {
+		var failures []*failedRun
+		for i, agentID := range agents {
+			failures = append(failures, &failedRun{
+				id:         fmt.Sprintf("exec-%d", i),
+				workflowID: fmt.Sprintf("wf-%d", i),
+				agentID:    agentID,
+				signature:  "exit status 1",
+				at:         at,
+				execution:  true,
+			})
+		}
+		clusters := clusterFailures(failures)
+		require.Len(t, clusters, 1)
+		return clusters[0]
+	}
+
+	assert.True(t, cluster("agent-1", "agent-1", "agent-2").AgentCorrelated)
+	// Half is not most
+	assert.False(t, cluster("agent-1", "agent-1", "agent-2", "agent-3").AgentCorrelated)
+	// Failures without an agent count against correlation
+	assert.False(t, cluster("agent-1", "agent-1", "", "").AgentCorrelated)
+	// Two failures are too few to blame an agent
+	assert.False(t, cluster("agent-1", "agent-1").AgentCorrelated)
+
+	// Samples are bounded
+	assert.Len(t, cluster("a", "b", "c", "d", "e", "f", "g").SampleWorkflowIDs, failureSampleSize)
+}
+
+func TestClusterFailures_SplitsClasses(t *testing.T) {
+	at := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	// The same message from an agent timing out and from one crashing
+	failures := []*failedRun{
+		{id: "exec-1", workflowID: "wf-1", class: "timeout", signature: "agent error", at: at, execution: true},
+		{id: "exec-2", workflowID: "wf-2", class: "crash", signature: "agent error", at: at, execution: true},
+		{id: "exec-3", workflowID: "wf-3", class: "timeout", signature: "agent error", at: at, execution: true},
+	}
+
+	clusters := clusterFailures(failures)
+	require.Len(t, clusters, 2)
+	assert.Equal(t, "timeout", clusters[0].Class)
+	assert.Equal(t, 2, clusters[0].Count)
+	assert.Equal(t, "crash", clusters[1].Class)
+	assert.Equal(t, 1, clusters[1].Count)
+}
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidSchedulingWeight is returned for project settings with a
+// negative scheduling weight
+var ErrInvalidSchedulingWeight = errors.New("invalid scheduling weight")
+
+// fairShareWorkflowTypes are the activity-heavy workflow types the
+// fair-share scheduler admits
+var fairShareWorkflowTypes = []models.WorkflowType{
+	models.WorkflowTypeExecution,
+	models.WorkflowTypeTaskExecution,
+}
+
+var (
+	fairShareUtilization = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_fair_share_utilization",
+		Help: "Running activity-heavy workflows of a project over its fair share",
+	}, []string{"project_id"})
+	fairShareHeldWorkflows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_fair_share_held_workflows",
+		Help: "Pending activity-heavy workflows held by the fair-share scheduler",
+	}, []string{"project_id"})
+)
+
+// projectLoad is the activity-heavy workflows of a project
+type projectLoad struct {
+	running int
+	held    int
+	weight  float64
+
+	// heldByPriority counts the held workflows by the rank of their
+	// effective priority
+	heldByPriority [4]int
+}
+
+// topPriority is the rank of the highest effective priority of the held
+// workflows, -1 without any
+func (l *projectLoad) topPriority() int {
+	for rank := len(l.heldByPriority) - 1; rank >= 0; rank-- {
+		if l.heldByPriority[rank] > 0 {
+			return rank
+		}
+	}
+	return -1
+}
+
+// FairShareScheduler keeps one project from taking all the capacity for
+// activity-heavy workflows. Each project with such workflows running or held
+// gets a share of the capacity in proportion to its weight. New workflows of
+// a project at or over its share wait in the pending queue while other
+// projects have held workflows, and all new workflows wait once the capacity
+// is in use; a background dispatcher starts held workflows as capacity frees
+// up, those of the highest effective priority first and among them from the
+// project furthest below its share. Held workflows are escalated to a higher
+// effective priority as they age, so none waits forever.
+//
+// Admission counts the workflows in the database, so concurrent starts can
+// briefly exceed the capacity.
+type FairShareScheduler struct {
+	db     *gorm.DB
+	engine *WorkflowEngine
+	logger *zap.Logger
+
+	mu  sync.RWMutex
+	cfg config.SchedulingConfig
+
+	// Escalation of held workflows, see escalate
+	now    func() time.Time
+	events func(ctx context.Context, workflow *models.Workflow, eventType string, data map[string]interface{})
+
+	wake     chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewFairShareScheduler creates a scheduler that starts held workflows
+// through the engine
+func NewFairShareScheduler(db *gorm.DB, engine *WorkflowEngine, cfg *config.SchedulingConfig, logger *zap.Logger) *FairShareScheduler {
+	return &FairShareScheduler{
+		db:       db,
+		engine:   engine,
+		logger:   logger,
+		cfg:      *cfg,
+		now:      time.Now,
+		events:   engine.emitWorkflowEvent,
+		wake:     make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// SetScheduler makes the engine admit activity-heavy workflows through the
+// fair-share scheduler
+func (e *WorkflowEngine) SetScheduler(scheduler *FairShareScheduler) {
+	e.scheduler = scheduler
+}
+
+// Configure replaces the scheduling settings, e.g. when the config file is
+// reloaded. Switching fair sharing off starts the held workflows.
+func (s *FairShareScheduler) Configure(cfg *config.SchedulingConfig) {
+	s.mu.Lock()
+	s.cfg = *cfg
+	s.mu.Unlock()
+
+	s.logger.Info("Fair-share scheduling configured",
+		zap.Bool("enabled", cfg.FairShare),
+		zap.Int("capacity", cfg.Capacity),
+		zap.Float64("default_weight", cfg.DefaultWeight),
+		zap.Int("escalation_threshold", cfg.EscalationThreshold))
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *FairShareScheduler) config() config.SchedulingConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+// Start starts the dispatcher of held workflows
+func (s *FairShareScheduler) Start() {
+	s.wg.Add(1)
+	go s.run()
+	s.logger.Info("Fair-share scheduler started", zap.Bool("enabled", s.config().FairShare))
+}
+
+// Stop stops the dispatcher
+func (s *FairShareScheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+		s.wg.Wait()
+		s.logger.Info("Fair-share scheduler stopped")
+	})
+}
+
+func (s *FairShareScheduler) run() {
+	defer s.wg.Done()
+
+	for {
+		// The interval is read each round so reloads apply
+		interval := time.Duration(s.config().DispatchInterval) * time.Second
+		select {
+		case <-time.After(interval):
+		case <-s.wake:
+		case <-s.stopChan:
+			return
+		}
+		s.escalate(context.Background())
+		s.dispatch(context.Background())
+	}
+}
+
+// admit decides whether a new workflow starts now. A held workflow keeps the
+// reason in its scheduling_reason and stays pending; the reason is returned,
+// or "" to start the workflow. Errors let the workflow start.
+func (s *FairShareScheduler) admit(ctx context.Context, workflow *models.Workflow) string {
+	if s == nil || !isFairShareWorkflow(workflow.Type) {
+		return ""
+	}
+	cfg := s.config()
+	if !cfg.FairShare {
+		return ""
+	}
+
+	loads, err := s.load(ctx, cfg, workflow.ProjectID)
+	if err != nil {
+		s.logger.Error("Failed to check fair share; starting workflow",
+			zap.String("workflow_id", workflow.ID),
+			zap.Error(err))
+		return ""
+	}
+	reason := holdReason(loads, workflow.ProjectID, cfg.Capacity)
+	if reason == "" {
+		return ""
+	}
+
+	if err := s.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ?", workflow.ID).
+		Update("scheduling_reason", reason).Error; err != nil {
+		s.logger.Error("Failed to hold workflow; starting it",
+			zap.String("workflow_id", workflow.ID),
+			zap.Error(err))
+		return ""
+	}
+	workflow.SchedulingReason = reason
+	s.logger.Info("Holding workflow for fair-share scheduling",
+		zap.String("workflow_id", workflow.ID),
+		zap.String("project_id", workflow.ProjectID),
+		zap.String("reason", reason))
+	return reason
+}
+
+// dispatch starts held workflows while there is capacity, each time the one
+// of the highest effective priority from the project with the lowest running
+// workflows per weight. With fair sharing off it starts all of them. Held workflows stay queued while maintenance
+// mode is on.
+func (s *FairShareScheduler) dispatch(ctx context.Context) {
+	if s.engine.maintenance.Active(ctx) {
+		return
+	}
+	cfg := s.config()
+	loads, err := s.load(ctx, cfg)
+	if err != nil {
+		s.logger.Error("Failed to load fair-share state", zap.Error(err))
+		return
+	}
+
+	running := 0
+	for _, load := range loads {
+		running += load.running
+	}
+	for !cfg.FairShare || running < cfg.Capacity {
+		projectID, ok := nextProject(loads)
+		if !ok {
+			break
+		}
+		load := loads[projectID]
+		workflow, err := s.claimHeld(ctx, projectID)
+		if err != nil {
+			s.logger.Error("Failed to claim held workflow",
+				zap.String("project_id", projectID),
+				zap.Error(err))
+			break
+		}
+		if workflow == nil {
+			// Another replica took the rest
+			load.held = 0
+			load.heldByPriority = [4]int{}
+			continue
+		}
+		load.held--
+		if rank := workflow.EffectivePriority.Rank(); load.heldByPriority[rank] > 0 {
+			load.heldByPriority[rank]--
+		}
+
+		if _, err := s.engine.dispatchWorkflow(ctx, workflow); err != nil {
+			s.logger.Error("Failed to start held workflow",
+				zap.String("workflow_id", workflow.ID),
+				zap.Error(err))
+			continue
+		}
+		s.logger.Info("Started held workflow",
+			zap.String("workflow_id", workflow.ID),
+			zap.String("project_id", projectID))
+		load.running++
+		running++
+	}
+
+	fairShareUtilization.Reset()
+	fairShareHeldWorkflows.Reset()
+	for projectID, share := range fairShares(loads, cfg.Capacity) {
+		fairShareUtilization.WithLabelValues(projectID).Set(float64(loads[projectID].running) / share)
+		fairShareHeldWorkflows.WithLabelValues(projectID).Set(float64(loads[projectID].held))
+	}
+}
+
+// claimHeld takes the oldest held workflow of the highest effective priority
+// of a project off the queue, nil when the project has none left
+func (s *FairShareScheduler) claimHeld(ctx context.Context, projectID string) (*models.Workflow, error) {
+	for {
+		var workflow models.Workflow
+		err := s.db.WithContext(ctx).
+			Where("project_id = ? AND status = ? AND scheduling_reason <> ''", projectID, models.WorkflowStatusPending).
+			Order(effectivePriorityRank + " DESC, created_at, id").
+			Take(&workflow).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get held workflow: %w", err)
+		}
+
+		// Claim the workflow so no other replica starts it as well
+		result := s.db.WithContext(ctx).Model(&models.Workflow{}).
+			Where("id = ? AND status = ? AND scheduling_reason <> ''", workflow.ID, models.WorkflowStatusPending).
+			Update("scheduling_reason", "")
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to claim held workflow: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			workflow.SchedulingReason = ""
+			return &workflow, nil
+		}
+	}
+}
+
+// load counts the running and held activity-heavy workflows by project and
+// looks up the projects' weights. The include projects are active even
+// without workflows.
+func (s *FairShareScheduler) load(ctx context.Context, cfg config.SchedulingConfig, include ...string) (map[string]*projectLoad, error) {
+	var rows []struct {
+		ProjectID    string
+		Status       models.WorkflowStatus
+		PriorityRank int
+		Count        int
+	}
+	err := s.db.WithContext(ctx).Model(&models.Workflow{}).
+		Select("project_id, status, "+effectivePriorityRank+" AS priority_rank, count(*) AS count").
+		Where("type IN ? AND (status = ? OR (status = ? AND scheduling_reason <> ''))",
+			fairShareWorkflowTypes, models.WorkflowStatusRunning, models.WorkflowStatusPending).
+		Group("project_id, status, priority_rank").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count activity-heavy workflows: %w", err)
+	}
+
+	loads := make(map[string]*projectLoad)
+	for _, projectID := range include {
+		loads[projectID] = &projectLoad{}
+	}
+	for _, row := range rows {
+		load, ok := loads[row.ProjectID]
+		if !ok {
+			load = &projectLoad{}
+			loads[row.ProjectID] = load
+		}
+		if row.Status == models.WorkflowStatusRunning {
+			load.running += row.Count
+		} else {
+			load.held += row.Count
+			load.heldByPriority[row.PriorityRank] += row.Count
+		}
+	}
+
+	ids := make([]string, 0, len(loads))
+	for projectID, load := range loads {
+		load.weight = cfg.DefaultWeight
+		if projectID != "" {
+			ids = append(ids, projectID)
+		}
+	}
+	if len(ids) == 0 {
+		return loads, nil
+	}
+	var projects []models.Project
+	if err := s.db.WithContext(ctx).Select("id", "settings").Where("id IN ?", ids).Find(&projects).Error; err != nil {
+		return nil, fmt.Errorf("failed to get project settings: %w", err)
+	}
+	for _, project := range projects {
+		settings, err := models.ParseProjectSettings(project.Settings)
+		if err != nil {
+			s.logger.Warn("Ignoring unreadable project settings",
+				zap.String("project_id", project.ID),
+				zap.Error(err))
+			continue
+		}
+		if settings.SchedulingWeight > 0 {
+			loads[project.ID].weight = settings.SchedulingWeight
+		}
+	}
+	return loads, nil
+}
+
+// fairShares splits the capacity across the projects in proportion to their
+// weights
+func fairShares(loads map[string]*projectLoad, capacity int) map[string]float64 {
+	total := 0.0
+	for _, load := range loads {
+		total += load.weight
+	}
+	shares := make(map[string]float64, len(loads))
+	for projectID, load := range loads {
+		shares[projectID] = float64(capacity) * load.weight / total
+	}
+	return shares
+}
+
+// holdReason is why a new workflow of a project has to wait, "" if it can
+// start
+func holdReason(loads map[string]*projectLoad, projectID string, capacity int) string {
+	running, othersHeld := 0, false
+	for id, load := range loads {
+		running += load.running
+		if id != projectID && load.held > 0 {
+			othersHeld = true
+		}
+	}
+	if running >= capacity {
+		return fmt.Sprintf("capacity: %d of %d activity-heavy workflows running", running, capacity)
+	}
+
+	load := loads[projectID]
+	if load.held > 0 {
+		return fmt.Sprintf("queued behind %d held workflows of the project", load.held)
+	}
+	if share := fairShares(loads, capacity)[projectID]; othersHeld && float64(load.running) >= share {
+		return fmt.Sprintf("fair share: project runs %d activity-heavy workflows, its share is %.1f", load.running, share)
+	}
+	return ""
+}
+
+// nextProject is the project holding workflows of the highest effective
+// priority that runs the fewest workflows for its weight; ties go to the
+// heavier project
+func nextProject(loads map[string]*projectLoad) (string, bool) {
+	candidates := make([]string, 0, len(loads))
+	for projectID, load := range loads {
+		if load.held > 0 {
+			candidates = append(candidates, projectID)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := loads[candidates[i]], loads[candidates[j]]
+		if pa, pb := a.topPriority(), b.topPriority(); pa != pb {
+			return pa > pb
+		}
+		ua, ub := float64(a.running)/a.weight, float64(b.running)/b.weight
+		if ua != ub {
+			return ua < ub
+		}
+		if a.weight != b.weight {
+			return a.weight > b.weight
+		}
+		return candidates[i] < candidates[j]
+	})
+	return candidates[0], true
+}
+
+func isFairShareWorkflow(workflowType models.WorkflowType) bool {
+	for _, heavy := range fairShareWorkflowTypes {
+		if workflowType == heavy {
+			return true
+		}
+	}
+	return false
+}
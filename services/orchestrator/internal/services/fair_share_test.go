@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// setupFairShareTest creates an engine admitting through a fair-share
+// scheduler, and projects with the given scheduling weights. It returns the
+// number of workflows started per project.
+func setupFairShareTest(t *testing.T, capacity int, weights map[string]float64) (*WorkflowEngine, *FairShareScheduler, *gorm.DB, map[string]int) {
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`).Error)
+	for projectID, weight := range weights {
+		settings, err := json.Marshal(models.ProjectSettings{SchedulingWeight: weight})
+		require.NoError(t, err)
+		require.NoError(t, db.Exec(`INSERT INTO projects (id, settings) VALUES (?, ?)`, projectID, settings).Error)
+	}
+
+	started := make(map[string]int)
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { started[args.Get(3).(*models.Workflow).ProjectID]++ }).
+		Return(run, nil)
+
+	scheduler := NewFairShareScheduler(db, engine, &config.SchedulingConfig{
+		FairShare:        true,
+		Capacity:         capacity,
+		DefaultWeight:    1,
+		DispatchInterval: 1,
+	}, zap.NewNop())
+	engine.SetScheduler(scheduler)
+	return engine, scheduler, db, started
+}
+
+func startTaskWorkflow(t *testing.T, engine *WorkflowEngine, projectID string) *StartWorkflowResponse {
+	resp, err := engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+		Name:      "build",
+		Type:      string(models.WorkflowTypeTaskExecution),
+		Priority:  string(models.WorkflowPriorityMedium),
+		ProjectID: projectID,
+		UserID:    "alice",
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+func completeWorkflows(t *testing.T, db *gorm.DB, ids []string) {
+	require.NoError(t, db.Model(&models.Workflow{}).Where("id IN ?", ids).
+		Update("status", models.WorkflowStatusCompleted).Error)
+}
+
+func TestFairShareScheduler_HoldsAndDispatches(t *testing.T) {
+	ctx := context.Background()
+	engine, scheduler, db, started := setupFairShareTest(t, 2, nil)
+
+	// A project alone may use the whole capacity
+	first := startTaskWorkflow(t, engine, "project-a")
+	startTaskWorkflow(t, engine, "project-a")
+	assert.Equal(t, string(models.WorkflowStatusRunning), first.Status)
+
+	// Once it is in use, new workflows wait with the reason recorded
+	held := startTaskWorkflow(t, engine, "project-b")
+	assert.Equal(t, string(models.WorkflowStatusPending), held.Status)
+	assert.Contains(t, held.SchedulingReason, "capacity")
+	var workflow models.Workflow
+	require.NoError(t, db.First(&workflow, "id = ?", held.WorkflowID).Error)
+	assert.Equal(t, models.WorkflowStatusPending, workflow.Status)
+	assert.Equal(t, held.SchedulingReason, workflow.SchedulingReason)
+	require.NotNil(t, workflow.QueuedAt)
+	assert.Nil(t, workflow.StartedAt)
+	startTaskWorkflow(t, engine, "project-a")
+
+	// Workflows of other types are not held
+	intent, err := engine.StartWorkflow(ctx, &StartWorkflowRequest{
+		Name: "intent", Type: string(models.WorkflowTypeIntent), ProjectID: "project-a", UserID: "alice",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, string(models.WorkflowStatusRunning), intent.Status)
+
+	// A freed slot goes to the project below its share, although project-a
+	// submitted first
+	completeWorkflows(t, db, []string{first.WorkflowID})
+	scheduler.dispatch(ctx)
+	require.NoError(t, db.First(&workflow, "id = ?", held.WorkflowID).Error)
+	assert.Equal(t, models.WorkflowStatusRunning, workflow.Status)
+	assert.Empty(t, workflow.SchedulingReason)
+	require.NotNil(t, workflow.StartedAt)
+	assert.False(t, workflow.StartedAt.Before(*workflow.QueuedAt))
+	assert.Equal(t, map[string]int{"project-a": 3, "project-b": 1}, started)
+
+	// A larger capacity applies on the next round
+	completeWorkflows(t, db, []string{held.WorkflowID})
+	scheduler.Configure(&config.SchedulingConfig{FairShare: true, Capacity: 3, DefaultWeight: 1, DispatchInterval: 1})
+	scheduler.dispatch(ctx)
+	assert.Equal(t, map[string]int{"project-a": 4, "project-b": 1}, started)
+
+	// While project-b waits, project-a is held at its share even with a
+	// free slot
+	second := startTaskWorkflow(t, engine, "project-b")
+	third := startTaskWorkflow(t, engine, "project-b")
+	assert.Contains(t, third.SchedulingReason, "capacity")
+	completeWorkflows(t, db, []string{second.WorkflowID})
+	resp := startTaskWorkflow(t, engine, "project-a")
+	assert.Contains(t, resp.SchedulingReason, "fair share")
+	scheduler.dispatch(ctx)
+	assert.Equal(t, map[string]int{"project-a": 4, "project-b": 3}, started)
+
+	// Switching fair sharing off starts everything held
+	scheduler.Configure(&config.SchedulingConfig{FairShare: false, Capacity: 3, DefaultWeight: 1, DispatchInterval: 1})
+	scheduler.dispatch(ctx)
+	var pending int64
+	require.NoError(t, db.Model(&models.Workflow{}).Where("status = ?", models.WorkflowStatusPending).Count(&pending).Error)
+	assert.Zero(t, pending)
+}
+
+func TestFairShareScheduler_StartsInProportionToWeights(t *testing.T) {
+	ctx := context.Background()
+	weights := map[string]float64{"project-a": 1, "project-b": 2, "project-c": 1}
+	engine, scheduler, db, started := setupFairShareTest(t, 4, weights)
+
+	// project-a floods the queue; every project submits more than its share
+	// of the capacity
+	submissions := map[string]int{"project-a": 6, "project-b": 3, "project-c": 1}
+	random := rand.New(rand.NewSource(1))
+	for round := 0; round < 150; round++ {
+		for _, projectID := range []string{"project-a", "project-b", "project-c"} {
+			for i := 0; i < submissions[projectID]; i++ {
+				startTaskWorkflow(t, engine, projectID)
+			}
+		}
+
+		// About half of the running workflows finish each round
+		var running, finished []string
+		require.NoError(t, db.Model(&models.Workflow{}).Where("status = ?", models.WorkflowStatusRunning).
+			Order("id").Pluck("id", &running).Error)
+		for _, id := range running {
+			if random.Intn(2) == 0 {
+				finished = append(finished, id)
+			}
+		}
+		completeWorkflows(t, db, finished)
+		scheduler.dispatch(ctx)
+	}
+
+	total := 0
+	for _, count := range started {
+		total += count
+	}
+	require.Greater(t, total, 200)
+	for projectID, weight := range weights {
+		assert.InDelta(t, weight/4, float64(started[projectID])/float64(total), 0.05, projectID)
+	}
+}
+
+func TestValidateProjectSettings_SchedulingWeight(t *testing.T) {
+	assert.NoError(t, validateProjectSettings(json.RawMessage(`{"scheduling_weight": 2.5}`)))
+	assert.ErrorIs(t, validateProjectSettings(json.RawMessage(`{"scheduling_weight": -1}`)), ErrInvalidSchedulingWeight)
+}
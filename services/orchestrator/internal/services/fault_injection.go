@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// Targets of fault rules
+const (
+	FaultTargetHTTP     = "http"
+	FaultTargetActivity = "activity"
+)
+
+var (
+	// ErrInvalidFaultRule is returned for fault rules that cannot be applied
+	ErrInvalidFaultRule = errors.New("invalid fault rule")
+	// ErrFaultRuleNotFound is returned when deleting an unknown or expired rule
+	ErrFaultRuleNotFound = errors.New("fault rule not found")
+)
+
+var faultsInjected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_faults_injected_total",
+	Help: "Faults injected into API requests and activities, by target and fault",
+}, []string{"target", "fault"})
+
+// FaultRule injects latency or errors into the API requests or activities
+// it matches, until it expires
+type FaultRule struct {
+	ID          string    `json:"id"`
+	Target      string    `json:"target" binding:"required"`  // http or activity
+	Pattern     string    `json:"pattern" binding:"required"` // Glob on the request path or route, or on the activity name
+	Method      string    `json:"method,omitempty"`           // HTTP method; any when empty
+	Probability float64   `json:"probability"`                // Of injecting the fault into a matching call, 0-1
+	Delay       int64     `json:"delay_ms,omitempty"`         // Added before the call or the injected error
+	StatusCode  int       `json:"status_code,omitempty"`      // Error response of HTTP rules; none when 0
+	Error       string    `json:"error,omitempty"`            // Failure of activity rules; none when empty
+	TTL         int       `json:"ttl_seconds,omitempty"`      // Defaults to fault_injection.default_ttl
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// matches reports whether the rule applies to a call of the target. Patterns
+// match the request path or the route template, e.g. /api/v1/workflows/:id.
+func (r *FaultRule) matches(target, method string, names ...string) bool {
+	if r.Target != target || (r.Method != "" && !strings.EqualFold(r.Method, method)) {
+		return false
+	}
+	for _, name := range names {
+		if ok, _ := path.Match(r.Pattern, name); ok && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// FaultRuleStore keeps fault rules until they expire
+type FaultRuleStore interface {
+	Put(ctx context.Context, rule *FaultRule) error
+	List(ctx context.Context) ([]FaultRule, error) // Unexpired rules
+	Delete(ctx context.Context, id string) (bool, error)
+}
+
+const (
+	faultRuleKeyPrefix = "faults:rule:"
+	faultRuleIndexKey  = "faults:rules"
+)
+
+// redisFaultRuleStore keeps each rule in a key expiring with the rule, so
+// every replica sees the same rules, and indexes the rule IDs in a set
+type redisFaultRuleStore struct {
+	client *redis.Client
+}
+
+// NewRedisFaultRuleStore stores fault rules in Redis
+func NewRedisFaultRuleStore(client *redis.Client) FaultRuleStore {
+	return &redisFaultRuleStore{client: client}
+}
+
+func (s *redisFaultRuleStore) Put(ctx context.Context, rule *FaultRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, faultRuleKeyPrefix+rule.ID, data, time.Until(rule.ExpiresAt))
+		pipe.SAdd(ctx, faultRuleIndexKey, rule.ID)
+		return nil
+	})
+	return err
+}
+
+func (s *redisFaultRuleStore) List(ctx context.Context) ([]FaultRule, error) {
+	ids, err := s.client.SMembers(ctx, faultRuleIndexKey).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = faultRuleKeyPrefix + id
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []FaultRule
+	var expired []interface{}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		var rule FaultRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			return nil, fmt.Errorf("failed to decode fault rule %s: %w", ids[i], err)
+		}
+		rules = append(rules, rule)
+	}
+	// Expired rules are gone; drop them from the index too
+	if len(expired) > 0 {
+		s.client.SRem(ctx, faultRuleIndexKey, expired...)
+	}
+	return rules, nil
+}
+
+func (s *redisFaultRuleStore) Delete(ctx context.Context, id string) (bool, error) {
+	var deleted *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, faultRuleKeyPrefix+id)
+		pipe.SRem(ctx, faultRuleIndexKey, id)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return deleted.Val() > 0, nil
+}
+
+// memoryFaultRuleStore keeps fault rules in memory, for a single replica
+type memoryFaultRuleStore struct {
+	mu    sync.Mutex
+	rules map[string]FaultRule
+	now   func() time.Time
+}
+
+// NewMemoryFaultRuleStore stores fault rules in memory
+func NewMemoryFaultRuleStore() FaultRuleStore {
+	return &memoryFaultRuleStore{rules: make(map[string]FaultRule), now: time.Now}
+}
+
+func (s *memoryFaultRuleStore) Put(ctx context.Context, rule *FaultRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules[rule.ID] = *rule
+	return nil
+}
+
+func (s *memoryFaultRuleStore) List(ctx context.Context) ([]FaultRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rules []FaultRule
+	for id, rule := range s.rules {
+		if !s.now().Before(rule.ExpiresAt) {
+			delete(s.rules, id)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s *memoryFaultRuleStore) Delete(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule, ok := s.rules[id]
+	delete(s.rules, id)
+	return ok && s.now().Before(rule.ExpiresAt), nil
+}
+
+// FaultInjector injects the faults of the stored rules into API requests and
+// activities, to exercise retries and rollbacks in staging. It only exists
+// when fault_injection.enabled is set.
+type FaultInjector struct {
+	store   FaultRuleStore
+	config  *config.FaultInjectionConfig
+	logger  *zap.Logger
+	now     func() time.Time
+	refresh time.Duration
+
+	mu       sync.Mutex // guards random, rules and loadedAt
+	random   *rand.Rand
+	rules    []FaultRule
+	loadedAt time.Time
+}
+
+// NewFaultInjector creates a fault injector reading its rules from store
+func NewFaultInjector(store FaultRuleStore, cfg *config.FaultInjectionConfig, logger *zap.Logger) *FaultInjector {
+	return &FaultInjector{
+		store:   store,
+		config:  cfg,
+		logger:  logger,
+		now:     time.Now,
+		refresh: time.Duration(cfg.RefreshInterval) * time.Second,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// CreateRule validates and stores a rule, active until its TTL elapses
+func (f *FaultInjector) CreateRule(ctx context.Context, rule *FaultRule, createdBy string) (*FaultRule, error) {
+	if rule.TTL == 0 {
+		rule.TTL = f.config.DefaultTTL
+	}
+	if err := f.validate(rule); err != nil {
+		return nil, err
+	}
+
+	rule.ID = uuid.NewString()
+	rule.CreatedBy = createdBy
+	rule.CreatedAt = f.now().UTC()
+	rule.ExpiresAt = rule.CreatedAt.Add(time.Duration(rule.TTL) * time.Second)
+	if err := f.store.Put(ctx, rule); err != nil {
+		return nil, fmt.Errorf("failed to store fault rule: %w", err)
+	}
+	f.invalidate()
+
+	f.logger.Warn("Fault rule created",
+		zap.String("rule_id", rule.ID),
+		zap.String("target", rule.Target),
+		zap.String("pattern", rule.Pattern),
+		zap.Float64("probability", rule.Probability),
+		zap.Time("expires_at", rule.ExpiresAt))
+	return rule, nil
+}
+
+func (f *FaultInjector) validate(rule *FaultRule) error {
+	switch rule.Target {
+	case FaultTargetHTTP:
+		if rule.Error != "" {
+			return fmt.Errorf("%w: http rules fail with a status code, not an error", ErrInvalidFaultRule)
+		}
+		if rule.StatusCode != 0 && (rule.StatusCode < 400 || rule.StatusCode > 599) {
+			return fmt.Errorf("%w: status code must be 4xx or 5xx", ErrInvalidFaultRule)
+		}
+	case FaultTargetActivity:
+		if rule.StatusCode != 0 || rule.Method != "" {
+			return fmt.Errorf("%w: activity rules fail with an error, not a status code", ErrInvalidFaultRule)
+		}
+	default:
+		return fmt.Errorf("%w: target must be %s or %s", ErrInvalidFaultRule, FaultTargetHTTP, FaultTargetActivity)
+	}
+	if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+		return fmt.Errorf("%w: malformed pattern %q", ErrInvalidFaultRule, rule.Pattern)
+	}
+	if rule.Probability <= 0 || rule.Probability > 1 {
+		return fmt.Errorf("%w: probability must be above 0 and at most 1", ErrInvalidFaultRule)
+	}
+	if rule.Delay < 0 || rule.Delay > int64(f.config.MaxDelay)*1000 {
+		return fmt.Errorf("%w: delay must be between 0 and %d seconds", ErrInvalidFaultRule, f.config.MaxDelay)
+	}
+	if rule.Delay == 0 && rule.StatusCode == 0 && rule.Error == "" {
+		return fmt.Errorf("%w: a rule must inject a delay or a failure", ErrInvalidFaultRule)
+	}
+	if rule.TTL <= 0 || rule.TTL > f.config.MaxTTL {
+		return fmt.Errorf("%w: ttl must be between 1 and %d seconds", ErrInvalidFaultRule, f.config.MaxTTL)
+	}
+	return nil
+}
+
+// ListRules returns the active rules, oldest first
+func (f *FaultInjector) ListRules(ctx context.Context) ([]FaultRule, error) {
+	rules, err := f.store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fault rules: %w", err)
+	}
+	active := rules[:0]
+	for _, rule := range rules {
+		if f.now().Before(rule.ExpiresAt) {
+			active = append(active, rule)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].CreatedAt.Before(active[j].CreatedAt) })
+	return active, nil
+}
+
+// DeleteRule removes a rule before it expires
+func (f *FaultInjector) DeleteRule(ctx context.Context, id string) error {
+	deleted, err := f.store.Delete(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete fault rule: %w", err)
+	}
+	f.invalidate()
+	if !deleted {
+		return ErrFaultRuleNotFound
+	}
+	f.logger.Info("Fault rule deleted", zap.String("rule_id", id))
+	return nil
+}
+
+// InjectHTTPFault returns the delay and the error status, 0 for none, to
+// inject into a request
+func (f *FaultInjector) InjectHTTPFault(ctx context.Context, method, route, requestPath string) (time.Duration, int) {
+	rule := f.pick(ctx, FaultTargetHTTP, method, requestPath, route)
+	if rule == nil {
+		return 0, 0
+	}
+	f.record(FaultTargetHTTP, rule.StatusCode != 0)
+	return time.Duration(rule.Delay) * time.Millisecond, rule.StatusCode
+}
+
+// InjectActivityFault returns the delay and the error, nil for none, to
+// inject into an activity. Injected errors are retryable, like the failures
+// of the dependencies they stand in for.
+func (f *FaultInjector) InjectActivityFault(ctx context.Context, activityType string) (time.Duration, error) {
+	rule := f.pick(ctx, FaultTargetActivity, "", activityType)
+	if rule == nil {
+		return 0, nil
+	}
+	f.record(FaultTargetActivity, rule.Error != "")
+
+	var err error
+	if rule.Error != "" {
+		err = temporal.NewApplicationError(rule.Error, "InjectedFault", rule.ID)
+	}
+	return time.Duration(rule.Delay) * time.Millisecond, err
+}
+
+// pick returns the first matching rule whose probability roll hits
+func (f *FaultInjector) pick(ctx context.Context, target, method string, names ...string) *FaultRule {
+	rules := f.activeRules(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range rules {
+		if rules[i].matches(target, method, names...) && f.random.Float64() < rules[i].Probability {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// activeRules returns the rules, reloaded from the store at most once per
+// refresh interval. Rules that cannot be loaded inject nothing.
+func (f *FaultInjector) activeRules(ctx context.Context) []FaultRule {
+	f.mu.Lock()
+	if !f.loadedAt.IsZero() && f.now().Sub(f.loadedAt) < f.refresh {
+		rules := f.rules
+		f.mu.Unlock()
+		return rules
+	}
+	f.mu.Unlock()
+
+	rules, err := f.ListRules(ctx)
+	if err != nil {
+		f.logger.Warn("Failed to load fault rules", zap.Error(err))
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+	f.loadedAt = f.now()
+	return rules
+}
+
+func (f *FaultInjector) invalidate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loadedAt = time.Time{}
+}
+
+func (f *FaultInjector) record(target string, failed bool) {
+	fault := "delay"
+	if failed {
+		fault = "error"
+	}
+	faultsInjected.WithLabelValues(target, fault).Inc()
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// newTestFaultInjector returns an injector over a memory store, both on the
+// returned clock
+func newTestFaultInjector(now *time.Time) *FaultInjector {
+	store := NewMemoryFaultRuleStore().(*memoryFaultRuleStore)
+	store.now = func() time.Time { return *now }
+	injector := NewFaultInjector(store, &config.FaultInjectionConfig{
+		Enabled:         true,
+		DefaultTTL:      300,
+		MaxTTL:          3600,
+		MaxDelay:        60,
+		RefreshInterval: 1,
+	}, zap.NewNop())
+	injector.now = store.now
+	injector.random = rand.New(rand.NewSource(1))
+	return injector
+}
+
+func TestFaultInjector_ValidatesRules(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	injector := newTestFaultInjector(&now)
+
+	for name, rule := range map[string]FaultRule{
+		"unknown target":        {Target: "database", Pattern: "*", Probability: 1, Delay: 100},
+		"http error message":    {Target: FaultTargetHTTP, Pattern: "*", Probability: 1, Error: "boom"},
+		"http success status":   {Target: FaultTargetHTTP, Pattern: "*", Probability: 1, StatusCode: 200},
+		"activity status":       {Target: FaultTargetActivity, Pattern: "*", Probability: 1, StatusCode: 503},
+		"malformed pattern":     {Target: FaultTargetHTTP, Pattern: "/api/[", Probability: 1, StatusCode: 503},
+		"zero probability":      {Target: FaultTargetHTTP, Pattern: "*", StatusCode: 503},
+		"probability above one": {Target: FaultTargetHTTP, Pattern: "*", Probability: 1.5, StatusCode: 503},
+		"delay too long":        {Target: FaultTargetHTTP, Pattern: "*", Probability: 1, Delay: 61000},
+		"no fault":              {Target: FaultTargetActivity, Pattern: "*", Probability: 1},
+		"ttl too long":          {Target: FaultTargetHTTP, Pattern: "*", Probability: 1, StatusCode: 503, TTL: 7200},
+	} {
+		rule := rule
+		_, err := injector.CreateRule(context.Background(), &rule, "tester")
+		assert.ErrorIs(t, err, ErrInvalidFaultRule, name)
+	}
+
+	rule, err := injector.CreateRule(context.Background(), &FaultRule{
+		Target: FaultTargetHTTP, Pattern: "/api/v1/workflows/*", Probability: 0.5, StatusCode: 503,
+	}, "tester")
+	require.NoError(t, err)
+	assert.NotEmpty(t, rule.ID)
+	assert.Equal(t, "tester", rule.CreatedBy)
+	assert.Equal(t, 300, rule.TTL)
+	assert.Equal(t, now.Add(5*time.Minute), rule.ExpiresAt)
+}
+
+func TestFaultInjector_RulesExpire(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	injector := newTestFaultInjector(&now)
+	ctx := context.Background()
+
+	short, err := injector.CreateRule(ctx, &FaultRule{
+		Target: FaultTargetHTTP, Pattern: "/api/v1/projects", Probability: 1, StatusCode: 500, TTL: 60,
+	}, "tester")
+	require.NoError(t, err)
+	now = now.Add(time.Second)
+	long, err := injector.CreateRule(ctx, &FaultRule{
+		Target: FaultTargetHTTP, Pattern: "/api/v1/workflows/:id", Probability: 1, Delay: 250, TTL: 600,
+	}, "tester")
+	require.NoError(t, err)
+
+	rules, err := injector.ListRules(ctx)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{short.ID, long.ID}, []string{rules[0].ID, rules[1].ID})
+
+	delay, status := injector.InjectHTTPFault(ctx, "GET", "/api/v1/projects", "/api/v1/projects")
+	assert.Equal(t, time.Duration(0), delay)
+	assert.Equal(t, 500, status)
+	// Routes match by template as well as by path
+	delay, status = injector.InjectHTTPFault(ctx, "GET", "/api/v1/workflows/:id", "/api/v1/workflows/wf-1")
+	assert.Equal(t, 250*time.Millisecond, delay)
+	assert.Zero(t, status)
+
+	// Expired rules are neither listed nor applied, once the cache refreshes
+	now = now.Add(time.Minute)
+	rules, err = injector.ListRules(ctx)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, long.ID, rules[0].ID)
+	_, status = injector.InjectHTTPFault(ctx, "GET", "/api/v1/projects", "/api/v1/projects")
+	assert.Zero(t, status)
+
+	assert.ErrorIs(t, injector.DeleteRule(ctx, short.ID), ErrFaultRuleNotFound)
+	require.NoError(t, injector.DeleteRule(ctx, long.ID))
+	rules, err = injector.ListRules(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+	delay, _ = injector.InjectHTTPFault(ctx, "GET", "/api/v1/workflows/:id", "/api/v1/workflows/wf-1")
+	assert.Zero(t, delay)
+}
+
+func TestFaultInjector_ActivityFaultsFollowProbability(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	injector := newTestFaultInjector(&now)
+	ctx := context.Background()
+
+	_, err := injector.CreateRule(ctx, &FaultRule{
+		Target: FaultTargetActivity, Pattern: "*ExecuteTaskWithAgentActivity", Probability: 0.5, Error: "agent unavailable",
+	}, "tester")
+	require.NoError(t, err)
+
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		_, err := injector.InjectActivityFault(ctx, "MetaAgentExecuteTaskWithAgentActivity")
+		if err != nil {
+			failed++
+			var appErr *temporal.ApplicationError
+			require.True(t, errors.As(err, &appErr))
+			assert.Equal(t, "InjectedFault", appErr.Type())
+			assert.False(t, appErr.NonRetryable())
+		}
+	}
+	assert.InDelta(t, 500, failed, 60)
+
+	// Other activities are untouched
+	_, err = injector.InjectActivityFault(ctx, "AggregateTaskResultsActivity")
+	assert.NoError(t, err)
+}
+
+type failingFaultRuleStore struct {
+	FaultRuleStore
+}
+
+func (failingFaultRuleStore) List(ctx context.Context) ([]FaultRule, error) {
+	return nil, errors.New("redis unavailable")
+}
+
+func TestFaultInjector_InjectsNothingWhenRulesCannotBeLoaded(t *testing.T) {
+	injector := NewFaultInjector(failingFaultRuleStore{}, &config.FaultInjectionConfig{RefreshInterval: 1}, zap.NewNop())
+
+	delay, status := injector.InjectHTTPFault(context.Background(), "GET", "/api/v1/projects", "/api/v1/projects")
+	assert.Zero(t, delay)
+	assert.Zero(t, status)
+	_, err := injector.InjectActivityFault(context.Background(), "MetaAgentExecuteTaskWithAgentActivity")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,424 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// githubPageSize is the page size of GitHub list requests, their maximum
+const githubPageSize = 100
+
+// githubBaseBackoff is the first wait for a rate limit that does not say
+// when it resets; it doubles with each retry
+const githubBaseBackoff = time.Second
+
+// GitHubProvider reads pull requests and posts reviews through the GitHub
+// REST API, with the token of the project's GitHub integration
+type GitHubProvider struct {
+	db      *gorm.DB
+	cfg     *config.SCMConfig
+	baseURL string
+	client  *http.Client
+	logger  *zap.Logger
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+var _ SCMProvider = (*GitHubProvider)(nil)
+
+// NewGitHubProvider creates a GitHub provider taking tokens from the git
+// integrations of projects whose provider is github
+func NewGitHubProvider(db *gorm.DB, cfg *config.SCMConfig, logger *zap.Logger) *GitHubProvider {
+	return &GitHubProvider{
+		db:      db,
+		cfg:     cfg,
+		baseURL: strings.TrimSuffix(cfg.GitHubURL, "/"),
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		logger:  logger,
+		now:     time.Now,
+		sleep:   sleepContext,
+	}
+}
+
+// githubPullRequest is the part of a GitHub pull request read
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+	User    struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Base struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"base"`
+	Head struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+	Additions int `json:"additions"`
+	Deletions int `json:"deletions"`
+}
+
+// githubComment is a review or issue comment, of which only the body is read
+type githubComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// FetchPullRequest fetches the metadata and the diff of a pull request
+func (p *GitHubProvider) FetchPullRequest(ctx context.Context, ref *PullRequestRef) (*PullRequest, error) {
+	repo, err := githubRepository(ref.Repository)
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.token(ctx, ref, false)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("/repos/%s/pulls/%d", repo, ref.Number)
+	var pr githubPullRequest
+	if err := p.request(ctx, token, http.MethodGet, endpoint, "application/vnd.github+json", nil, &pr); err != nil {
+		return nil, err
+	}
+	var diff bytes.Buffer
+	if err := p.request(ctx, token, http.MethodGet, endpoint, "application/vnd.github.diff", nil, &diff); err != nil {
+		return nil, err
+	}
+	files, err := ParseUnifiedDiff(diff.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &PullRequest{
+		Number:     pr.Number,
+		Title:      pr.Title,
+		Author:     pr.User.Login,
+		URL:        pr.HTMLURL,
+		BaseBranch: pr.Base.Ref,
+		HeadBranch: pr.Head.Ref,
+		BaseCommit: pr.Base.SHA,
+		HeadCommit: pr.Head.SHA,
+		Files:      files,
+		Additions:  pr.Additions,
+		Deletions:  pr.Deletions,
+		Diff:       diff.String(),
+	}, nil
+}
+
+// PostReview creates a review holding the comments on lines of the diff,
+// listing the others in its body, and posts the summary as a comment of its
+// own. Both carry a marker of the review key and are not posted again when
+// the pull request already has them.
+func (p *GitHubProvider) PostReview(ctx context.Context, ref *PullRequestRef, review *PullRequestReview) (*PostedReview, error) {
+	repo, err := githubRepository(ref.Repository)
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.token(ctx, ref, true)
+	if err != nil {
+		return nil, err
+	}
+
+	posted := &PostedReview{}
+	patches := make(map[string]string, len(review.Files))
+	for _, file := range review.Files {
+		patches[file.Path] = file.Patch
+	}
+	type inlineComment struct {
+		Path     string `json:"path"`
+		Position int    `json:"position"`
+		Body     string `json:"body"`
+	}
+	var inline []inlineComment
+	var unplaced []ReviewComment
+	for _, comment := range review.Comments {
+		if position, ok := diffPosition(patches[comment.Path], comment.Line); ok {
+			inline = append(inline, inlineComment{Path: comment.Path, Position: position, Body: comment.Body})
+		} else {
+			unplaced = append(unplaced, comment)
+		}
+	}
+	posted.Inline = len(inline)
+	posted.Unplaced = len(unplaced)
+
+	reviewsEndpoint := fmt.Sprintf("/repos/%s/pulls/%d/reviews", repo, ref.Number)
+	marker := reviewMarker("review", review.Key)
+	reviewed, err := p.hasComment(ctx, token, reviewsEndpoint, marker)
+	if err != nil {
+		return nil, err
+	}
+	if !reviewed {
+		body := map[string]interface{}{
+			"commit_id": review.Commit,
+			"event":     "COMMENT",
+			"body":      reviewBody(len(inline), unplaced, marker),
+		}
+		if len(inline) > 0 {
+			body["comments"] = inline
+		}
+		if err := p.request(ctx, token, http.MethodPost, reviewsEndpoint, "application/vnd.github+json", body, nil); err != nil {
+			return nil, fmt.Errorf("failed to create review: %w", err)
+		}
+	}
+
+	commentsEndpoint := fmt.Sprintf("/repos/%s/issues/%d/comments", repo, ref.Number)
+	marker = reviewMarker("review-summary", review.Key)
+	summarized, err := p.hasComment(ctx, token, commentsEndpoint, marker)
+	if err != nil {
+		return nil, err
+	}
+	if !summarized {
+		body := map[string]string{"body": review.Summary + "\n\n" + marker}
+		if err := p.request(ctx, token, http.MethodPost, commentsEndpoint, "application/vnd.github+json", body, nil); err != nil {
+			return nil, fmt.Errorf("failed to post summary: %w", err)
+		}
+	}
+
+	posted.AlreadyPosted = reviewed && summarized
+	return posted, nil
+}
+
+// reviewBody is the body of a review: the comments that could not be placed
+// on the diff, and the marker
+func reviewBody(inline int, unplaced []ReviewComment, marker string) string {
+	var body strings.Builder
+	fmt.Fprintf(&body, "Automated review: %d inline comments", inline)
+	if len(unplaced) > 0 {
+		fmt.Fprintf(&body, ", %d on lines outside the diff:\n", len(unplaced))
+		for _, comment := range unplaced {
+			location := comment.Path
+			if comment.Line > 0 {
+				location = fmt.Sprintf("%s:%d", comment.Path, comment.Line)
+			}
+			fmt.Fprintf(&body, "\n- `%s`: %s", location, comment.Body)
+		}
+	}
+	body.WriteString("\n\n")
+	body.WriteString(marker)
+	return body.String()
+}
+
+// hasComment reports whether a list of reviews or comments has one
+// containing marker
+func (p *GitHubProvider) hasComment(ctx context.Context, token, endpoint, marker string) (bool, error) {
+	for page := 1; ; page++ {
+		var comments []githubComment
+		pageEndpoint := fmt.Sprintf("%s?per_page=%d&page=%d", endpoint, githubPageSize, page)
+		if err := p.request(ctx, token, http.MethodGet, pageEndpoint, "application/vnd.github+json", nil, &comments); err != nil {
+			return false, fmt.Errorf("failed to list comments: %w", err)
+		}
+		for _, comment := range comments {
+			if strings.Contains(comment.Body, marker) {
+				return true, nil
+			}
+		}
+		if len(comments) < githubPageSize {
+			return false, nil
+		}
+	}
+}
+
+// token returns the token of the project's GitHub integration, or the named
+// integration. Pull requests are read anonymously when there is none;
+// writing needs one.
+func (p *GitHubProvider) token(ctx context.Context, ref *PullRequestRef, write bool) (string, error) {
+	query := p.db.WithContext(ctx).
+		Where("project_id = ? AND type = ? AND status = ?", ref.ProjectID, IntegrationTypeGit, "active")
+	if ref.Integration != "" {
+		query = query.Where("name = ?", ref.Integration)
+	} else {
+		query = query.Where("provider = ?", SCMProviderGitHub)
+	}
+	var integration models.Integration
+	err := query.Order("created_at").First(&integration).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if ref.Integration != "" {
+			return "", fmt.Errorf("%w: no active git integration %s", ErrSCMAuth, ref.Integration)
+		}
+		if write {
+			return "", fmt.Errorf("%w: project has no active GitHub integration", ErrSCMAuth)
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find GitHub integration: %w", err)
+	}
+
+	var credentials struct {
+		Token string `json:"token"`
+	}
+	if len(integration.Credentials) > 0 {
+		if err := json.Unmarshal(integration.Credentials, &credentials); err != nil {
+			return "", fmt.Errorf("%w: invalid credentials of integration %s", ErrSCMAuth, integration.Name)
+		}
+	}
+	if credentials.Token == "" {
+		return "", fmt.Errorf("%w: integration %s has no token", ErrSCMAuth, integration.Name)
+	}
+	return credentials.Token, nil
+}
+
+// request sends a request to the GitHub API, decoding the JSON response into
+// out, or copying it when out is a buffer. Rate limited requests are retried
+// once the limit resets, or with exponential backoff when GitHub does not
+// say when.
+func (p *GitHubProvider) request(ctx context.Context, token, method, endpoint, accept string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, p.baseURL+endpoint, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", accept)
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("GitHub request failed: %w", err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read GitHub response: %w", err)
+		}
+
+		if wait, limited := p.rateLimit(resp, respBody); limited {
+			if wait == 0 {
+				wait = githubBaseBackoff << attempt
+			}
+			maxBackoff := time.Duration(p.cfg.MaxBackoff) * time.Second
+			if attempt >= p.cfg.MaxRetries || wait > maxBackoff {
+				return fmt.Errorf("%w: %s %s", ErrSCMRateLimited, method, endpoint)
+			}
+			p.logger.Warn("GitHub rate limit hit, backing off",
+				zap.String("endpoint", endpoint),
+				zap.Duration("wait", wait),
+				zap.Int("attempt", attempt+1))
+			if err := p.sleep(ctx, wait); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("%w: GitHub returned %d: %s", ErrSCMAuth, resp.StatusCode, githubMessage(respBody))
+		case resp.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrSCMNotFound, endpoint)
+		case resp.StatusCode >= 300:
+			return fmt.Errorf("GitHub returned %d: %s", resp.StatusCode, githubMessage(respBody))
+		}
+
+		switch out := out.(type) {
+		case nil:
+		case *bytes.Buffer:
+			out.Write(respBody)
+		default:
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return fmt.Errorf("invalid GitHub response: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// rateLimit reports whether a response is a primary or secondary (abuse)
+// rate limit, and how long to wait when GitHub says
+func (p *GitHubProvider) rateLimit(resp *http.Response, body []byte) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	retryAfter := resp.Header.Get("Retry-After")
+	exhausted := resp.Header.Get("X-RateLimit-Remaining") == "0"
+	message := strings.ToLower(githubMessage(body))
+	if resp.StatusCode == http.StatusForbidden && retryAfter == "" && !exhausted &&
+		!strings.Contains(message, "rate limit") && !strings.Contains(message, "abuse") {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if exhausted {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if wait := time.Unix(reset, 0).Sub(p.now()); wait > 0 {
+				return wait, true
+			}
+		}
+	}
+	return 0, true
+}
+
+// githubMessage returns the message of a GitHub error response
+func githubMessage(body []byte) string {
+	var response struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.Message != "" {
+		return response.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// githubRepository returns the owner/name of a repository given as such or
+// as an HTTPS or SSH URL
+func githubRepository(repository string) (string, error) {
+	path := repository
+	if strings.Contains(repository, "://") {
+		parsed, err := url.Parse(repository)
+		if err != nil {
+			return "", fmt.Errorf("invalid repository URL: %w", err)
+		}
+		path = parsed.Path
+	} else if _, rest, ok := strings.Cut(repository, ":"); ok {
+		// git@github.com:owner/name.git
+		path = rest
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if parts := strings.Split(path, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("%w: invalid GitHub repository %q", ErrSCMNotFound, repository)
+	}
+	return path, nil
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+)
+
+// fakeGitHub serves pull request 7 of acme/api with the testdata/github diff,
+// keeping the reviews and comments posted. The first limited requests are
+// answered with a secondary rate limit.
+type fakeGitHub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	limited  int
+	reviews  []map[string]interface{}
+	comments []map[string]interface{}
+	posts    int
+	tokens   []string
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	diff, err := os.ReadFile("testdata/github/pull.diff")
+	require.NoError(t, err)
+
+	gh := &fakeGitHub{}
+	gh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gh.mu.Lock()
+		defer gh.mu.Unlock()
+		gh.tokens = append(gh.tokens, r.Header.Get("Authorization"))
+
+		if gh.limited > 0 {
+			gh.limited--
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "You have exceeded a secondary rate limit"}`))
+			return
+		}
+
+		listed := func(items []map[string]interface{}) {
+			page := 1
+			fmt.Sscan(r.URL.Query().Get("page"), &page)
+			start := min((page-1)*githubPageSize, len(items))
+			end := min(start+githubPageSize, len(items))
+			require.NoError(t, json.NewEncoder(w).Encode(items[start:end]))
+		}
+		posted := func(items *[]map[string]interface{}) {
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			gh.posts++
+			*items = append(*items, body)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": 1}`))
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /repos/acme/api/pulls/7":
+			if r.Header.Get("Accept") == "application/vnd.github.diff" {
+				w.Write(diff)
+				return
+			}
+			w.Write([]byte(`{
+				"number": 7, "title": "Return errors from the handler",
+				"html_url": "https://github.com/acme/api/pull/7", "user": {"login": "ada"},
+				"base": {"ref": "main", "sha": "base123"}, "head": {"ref": "fix-handler", "sha": "head456"},
+				"additions": 10, "deletions": 5
+			}`))
+		case "GET /repos/acme/api/pulls/7/reviews":
+			listed(gh.reviews)
+		case "POST /repos/acme/api/pulls/7/reviews":
+			posted(&gh.reviews)
+		case "GET /repos/acme/api/issues/7/comments":
+			listed(gh.comments)
+		case "POST /repos/acme/api/issues/7/comments":
+			posted(&gh.comments)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	t.Cleanup(gh.Close)
+	return gh
+}
+
+// setupGitHubProvider creates a provider for the fake API, whose project-1
+// has a GitHub integration with a token and project-2 one without
+func setupGitHubProvider(t *testing.T, url string) *GitHubProvider {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE integrations (id TEXT PRIMARY KEY, project_id TEXT, name TEXT, type TEXT, provider TEXT,
+			status TEXT, config BLOB, credentials BLOB, created_at DATETIME, deleted_at DATETIME)`,
+		`INSERT INTO integrations (id, project_id, name, type, provider, status, credentials, created_at) VALUES
+			('integration-1', 'project-1', 'gitlab', 'git', 'gitlab', 'active', CAST('{"token": "glpat"}' AS BLOB), '2026-01-01'),
+			('integration-2', 'project-1', 'github', 'git', 'github', 'active', CAST('{"token": "ghp_secret"}' AS BLOB), '2026-01-02'),
+			('integration-3', 'project-2', 'github', 'git', 'github', 'active', CAST('{}' AS BLOB), '2026-01-01')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	provider := NewGitHubProvider(db, &config.SCMConfig{
+		GitHubURL:  url,
+		Timeout:    5,
+		MaxRetries: 2,
+		MaxBackoff: 60,
+	}, zap.NewNop())
+	provider.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	return provider
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	diff, err := os.ReadFile("testdata/github/pull.diff")
+	require.NoError(t, err)
+
+	files, err := ParseUnifiedDiff(string(diff))
+	require.NoError(t, err)
+	require.Len(t, files, 5)
+
+	type summary struct {
+		path, previous, status string
+		additions, deletions   int
+		binary                 bool
+	}
+	var summaries []summary
+	for _, file := range files {
+		summaries = append(summaries, summary{file.Path, file.PreviousPath, file.Status, file.Additions, file.Deletions, file.Binary})
+	}
+	assert.Equal(t, []summary{
+		{"api/handler.go", "", PullRequestFileModified, 4, 1, false},
+		{"docs/guide.md", "", PullRequestFileAdded, 2, 0, false},
+		{"legacy.go", "", PullRequestFileRemoved, 0, 3, false},
+		{"util/new name.go", "util/old name.go", PullRequestFileRenamed, 1, 1, false},
+		{"assets/logo.png", "", PullRequestFileModified, 0, 0, true},
+	}, summaries)
+
+	// Patches start at the first hunk
+	assert.True(t, strings.HasPrefix(files[0].Patch, "@@ -1,6 +1,7 @@\n package api"))
+	assert.True(t, strings.HasSuffix(files[0].Patch, "\tw.WriteHeader(http.StatusOK)\n }"))
+	assert.Equal(t, "@@ -0,0 +1,2 @@\n+# Guide\n+Run the server.\n\\ No newline at end of file", files[1].Patch)
+	assert.Empty(t, files[4].Patch)
+
+	// Quoted paths
+	files, err = ParseUnifiedDiff("diff --git \"a/caf\\303\\251.go\" \"b/caf\\303\\251.go\"\n--- \"a/caf\\303\\251.go\"\n+++ \"b/caf\\303\\251.go\"\n@@ -1 +1 @@\n-a\n+b\n")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "café.go", files[0].Path)
+
+	_, err = ParseUnifiedDiff("diff --git nonsense\n")
+	assert.Error(t, err)
+}
+
+func TestDiffPosition(t *testing.T) {
+	diff, err := os.ReadFile("testdata/github/pull.diff")
+	require.NoError(t, err)
+	files, err := ParseUnifiedDiff(string(diff))
+	require.NoError(t, err)
+	patch := files[0].Patch
+
+	for line, expected := range map[int]int{
+		1:  1,  // First line below the header
+		4:  4,  // Added line
+		21: 9,  // First line of the second hunk, below its header
+		22: 11, // Past a removed line
+		25: 14,
+		27: 16,
+	} {
+		position, ok := diffPosition(patch, line)
+		assert.True(t, ok, "line %d", line)
+		assert.Equal(t, expected, position, "line %d", line)
+	}
+
+	// Lines between and after the hunks are not in the diff
+	for _, line := range []int{0, 10, 20, 28} {
+		_, ok := diffPosition(patch, line)
+		assert.False(t, ok, "line %d", line)
+	}
+	// Nor are the lines of removed files
+	_, ok := diffPosition(files[2].Patch, 1)
+	assert.False(t, ok)
+	_, ok = diffPosition("", 1)
+	assert.False(t, ok)
+}
+
+func TestGitHubProvider_FetchPullRequest(t *testing.T) {
+	gh := newFakeGitHub(t)
+	provider := setupGitHubProvider(t, gh.URL)
+	ctx := context.Background()
+
+	for _, repository := range []string{"acme/api", "https://github.com/acme/api.git", "git@github.com:acme/api.git"} {
+		pr, err := provider.FetchPullRequest(ctx, &PullRequestRef{ProjectID: "project-1", Repository: repository, Number: 7})
+		require.NoError(t, err, repository)
+		assert.Equal(t, "Return errors from the handler", pr.Title)
+		assert.Equal(t, "ada", pr.Author)
+		assert.Equal(t, "head456", pr.HeadCommit)
+		assert.Equal(t, "main", pr.BaseBranch)
+		assert.Equal(t, 10, pr.Additions)
+		assert.Len(t, pr.Files, 5)
+		assert.Contains(t, pr.Diff, "diff --git a/api/handler.go b/api/handler.go")
+	}
+	// The token of the GitHub integration, not the first one
+	assert.Equal(t, "Bearer ghp_secret", gh.tokens[0])
+
+	// Public pull requests are read without an integration
+	_, err := provider.FetchPullRequest(ctx, &PullRequestRef{ProjectID: "project-3", Repository: "acme/api", Number: 7})
+	require.NoError(t, err)
+	assert.Empty(t, gh.tokens[len(gh.tokens)-1])
+
+	_, err = provider.FetchPullRequest(ctx, &PullRequestRef{ProjectID: "project-1", Repository: "acme/api", Number: 8})
+	assert.ErrorIs(t, err, ErrSCMNotFound)
+	_, err = provider.FetchPullRequest(ctx, &PullRequestRef{ProjectID: "project-2", Repository: "acme/api", Number: 7})
+	assert.ErrorIs(t, err, ErrSCMAuth)
+	_, err = provider.FetchPullRequest(ctx, &PullRequestRef{ProjectID: "project-1", Repository: "acme", Number: 7})
+	assert.ErrorIs(t, err, ErrSCMNotFound)
+}
+
+func TestGitHubProvider_PostReview(t *testing.T) {
+	gh := newFakeGitHub(t)
+	provider := setupGitHubProvider(t, gh.URL)
+	ctx := context.Background()
+	ref := &PullRequestRef{ProjectID: "project-1", Repository: "acme/api", Number: 7}
+
+	pr, err := provider.FetchPullRequest(ctx, ref)
+	require.NoError(t, err)
+	review := &PullRequestReview{
+		Key:    "workflow-1:head456",
+		Commit: pr.HeadCommit,
+		Files:  pr.Files,
+		Comments: []ReviewComment{
+			{Path: "api/handler.go", Line: 4, Body: "Import only what is used"},
+			{Path: "api/handler.go", Line: 25, Body: "Remove this"},
+			{Path: "api/handler.go", Line: 40, Body: "Handle HEAD too"},
+			{Path: "docs/guide.md", Line: 2, Body: "End with a newline"},
+			{Path: "legacy.go", Line: 1, Body: "Was this used?"},
+		},
+		Summary: "Score 0.8: changes suggested",
+	}
+
+	posted, err := provider.PostReview(ctx, ref, review)
+	require.NoError(t, err)
+	assert.Equal(t, &PostedReview{Inline: 3, Unplaced: 2}, posted)
+
+	require.Len(t, gh.reviews, 1)
+	created := gh.reviews[0]
+	assert.Equal(t, "head456", created["commit_id"])
+	assert.Equal(t, "COMMENT", created["event"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"path": "api/handler.go", "position": float64(4), "body": "Import only what is used"},
+		map[string]interface{}{"path": "api/handler.go", "position": float64(14), "body": "Remove this"},
+		map[string]interface{}{"path": "docs/guide.md", "position": float64(2), "body": "End with a newline"},
+	}, created["comments"])
+	assert.Equal(t, "Automated review: 3 inline comments, 2 on lines outside the diff:\n"+
+		"\n- `api/handler.go:40`: Handle HEAD too"+
+		"\n- `legacy.go:1`: Was this used?"+
+		"\n\n<!-- orchestrator-review:workflow-1:head456 -->", created["body"])
+
+	require.Len(t, gh.comments, 1)
+	assert.Equal(t, "Score 0.8: changes suggested\n\n<!-- orchestrator-review-summary:workflow-1:head456 -->", gh.comments[0]["body"])
+
+	// Posting again, as a retried activity does, posts nothing
+	posted, err = provider.PostReview(ctx, ref, review)
+	require.NoError(t, err)
+	assert.True(t, posted.AlreadyPosted)
+	assert.Equal(t, 2, gh.posts)
+
+	// A retry after only the review was posted posts the summary
+	gh.comments = nil
+	posted, err = provider.PostReview(ctx, ref, review)
+	require.NoError(t, err)
+	assert.False(t, posted.AlreadyPosted)
+	assert.Len(t, gh.reviews, 1)
+	assert.Len(t, gh.comments, 1)
+
+	// Another key is another review
+	review.Key = "workflow-2:head456"
+	_, err = provider.PostReview(ctx, ref, review)
+	require.NoError(t, err)
+	assert.Len(t, gh.reviews, 2)
+
+	// Writing needs a token
+	_, err = provider.PostReview(ctx, &PullRequestRef{ProjectID: "project-3", Repository: "acme/api", Number: 7}, review)
+	assert.ErrorIs(t, err, ErrSCMAuth)
+}
+
+func TestGitHubProvider_PostReviewPaginates(t *testing.T) {
+	gh := newFakeGitHub(t)
+	provider := setupGitHubProvider(t, gh.URL)
+	ref := &PullRequestRef{ProjectID: "project-1", Repository: "acme/api", Number: 7}
+
+	// The marker is on the second page
+	for i := 0; i < githubPageSize; i++ {
+		gh.reviews = append(gh.reviews, map[string]interface{}{"body": "LGTM"})
+	}
+	gh.reviews = append(gh.reviews, map[string]interface{}{"body": reviewMarker("review", "key")})
+	gh.comments = append(gh.comments, map[string]interface{}{"body": reviewMarker("review-summary", "key")})
+
+	posted, err := provider.PostReview(context.Background(), ref, &PullRequestReview{Key: "key", Commit: "head456"})
+	require.NoError(t, err)
+	assert.True(t, posted.AlreadyPosted)
+	assert.Zero(t, gh.posts)
+}
+
+func TestGitHubProvider_RateLimit(t *testing.T) {
+	gh := newFakeGitHub(t)
+	provider := setupGitHubProvider(t, gh.URL)
+	var waits []time.Duration
+	provider.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	ref := &PullRequestRef{ProjectID: "project-1", Repository: "acme/api", Number: 7}
+
+	// Secondary rate limits are retried after Retry-After
+	gh.limited = 2
+	_, err := provider.FetchPullRequest(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{2 * time.Second, 2 * time.Second}, waits)
+
+	// Past the retries the request fails
+	gh.limited = 3
+	_, err = provider.FetchPullRequest(context.Background(), ref)
+	assert.ErrorIs(t, err, ErrSCMRateLimited)
+	gh.limited = 0
+
+	// Exhausted primary limits wait for the reset, unless it is too far
+	now := time.Unix(1700000000, 0)
+	provider.now = func() time.Time { return now }
+	header := http.Header{}
+	header.Set("X-RateLimit-Remaining", "0")
+	header.Set("X-RateLimit-Reset", "1700000030")
+	wait, limited := provider.rateLimit(&http.Response{StatusCode: http.StatusForbidden, Header: header}, []byte(`{"message": "API rate limit exceeded"}`))
+	assert.True(t, limited)
+	assert.Equal(t, 30*time.Second, wait)
+
+	// Abuse responses without a hint back off exponentially
+	wait, limited = provider.rateLimit(&http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}},
+		[]byte(`{"message": "You have triggered an abuse detection mechanism"}`))
+	assert.True(t, limited)
+	assert.Zero(t, wait)
+
+	// Other 403s are permission errors
+	_, limited = provider.rateLimit(&http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}},
+		[]byte(`{"message": "Resource not accessible by integration"}`))
+	assert.False(t, limited)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// maxTopEntities is the number of entities reported by intent stats
+const maxTopEntities = 10
+
+// IntentTypeStats aggregates the analyses of one intent type
+type IntentTypeStats struct {
+	IntentType        string  `json:"intent_type"`
+	Count             int64   `json:"count"`
+	AverageConfidence float64 `json:"average_confidence"`
+}
+
+// EntityStats counts the occurrences of an extracted entity
+type EntityStats struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// IntentStats aggregates a project's intent analyses over a time window
+type IntentStats struct {
+	ProjectID         string            `json:"project_id"`
+	Since             time.Time         `json:"since"`
+	Until             time.Time         `json:"until"`
+	TotalAnalyses     int64             `json:"total_analyses"`
+	LowConfidence     int64             `json:"low_confidence"`
+	AverageConfidence float64           `json:"average_confidence"`
+	IntentTypes       []IntentTypeStats `json:"intent_types"`
+	TopEntities       []EntityStats     `json:"top_entities"`
+}
+
+// GetIntentAnalysis returns the intent analysis recorded for a workflow, or
+// nil when the workflow has none
+func (e *WorkflowEngine) GetIntentAnalysis(ctx context.Context, workflowID string) (*models.IntentAnalysis, error) {
+	var analysis models.IntentAnalysis
+	err := e.db.WithContext(ctx).Where("workflow_id = ?", workflowID).
+		Order("created_at DESC").First(&analysis).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get intent analysis: %w", err)
+	}
+	return &analysis, nil
+}
+
+// GetIntentStats aggregates the intent analyses of a project created in
+// [since, until): counts and average confidence per intent type and the most
+// common entities
+func (s *ProjectService) GetIntentStats(ctx context.Context, projectID string, since, until time.Time) (*IntentStats, error) {
+	var analyses []models.IntentAnalysis
+	if err := s.db.WithContext(ctx).Select("intent_type", "confidence", "entities", "low_confidence").
+		Where("project_id = ? AND created_at >= ? AND created_at < ?", projectID, since, until).
+		Find(&analyses).Error; err != nil {
+		return nil, fmt.Errorf("failed to list intent analyses: %w", err)
+	}
+
+	stats := &IntentStats{
+		ProjectID:   projectID,
+		Since:       since,
+		Until:       until,
+		IntentTypes: []IntentTypeStats{},
+		TopEntities: []EntityStats{},
+	}
+
+	type entityKey struct{ entityType, value string }
+	byType := make(map[string]*IntentTypeStats)
+	entityCounts := make(map[entityKey]int64)
+	var confidenceSum float64
+
+	for _, analysis := range analyses {
+		stats.TotalAnalyses++
+		confidenceSum += analysis.Confidence
+		if analysis.LowConfidence {
+			stats.LowConfidence++
+		}
+
+		typeStats, ok := byType[analysis.IntentType]
+		if !ok {
+			typeStats = &IntentTypeStats{IntentType: analysis.IntentType}
+			byType[analysis.IntentType] = typeStats
+		}
+		typeStats.Count++
+		// Summed here, divided below
+		typeStats.AverageConfidence += analysis.Confidence
+
+		if len(analysis.Entities) == 0 {
+			continue
+		}
+		var entities []Entity
+		if err := json.Unmarshal(analysis.Entities, &entities); err != nil {
+			s.logger.Warn("skipping undecodable intent entities",
+				zap.String("project_id", projectID),
+				zap.Error(err))
+			continue
+		}
+		for _, entity := range entities {
+			entityCounts[entityKey{entity.Type, entity.Value}]++
+		}
+	}
+
+	if stats.TotalAnalyses > 0 {
+		stats.AverageConfidence = confidenceSum / float64(stats.TotalAnalyses)
+	}
+	for _, typeStats := range byType {
+		typeStats.AverageConfidence /= float64(typeStats.Count)
+		stats.IntentTypes = append(stats.IntentTypes, *typeStats)
+	}
+	sort.Slice(stats.IntentTypes, func(i, j int) bool {
+		if stats.IntentTypes[i].Count != stats.IntentTypes[j].Count {
+			return stats.IntentTypes[i].Count > stats.IntentTypes[j].Count
+		}
+		return stats.IntentTypes[i].IntentType < stats.IntentTypes[j].IntentType
+	})
+
+	for key, count := range entityCounts {
+		stats.TopEntities = append(stats.TopEntities, EntityStats{Type: key.entityType, Value: key.value, Count: count})
+	}
+	sort.Slice(stats.TopEntities, func(i, j int) bool {
+		a, b := stats.TopEntities[i], stats.TopEntities[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Value < b.Value
+	})
+	if len(stats.TopEntities) > maxTopEntities {
+		stats.TopEntities = stats.TopEntities[:maxTopEntities]
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+func createIntentAnalysesTable(t *testing.T, db *gorm.DB) {
+	require.NoError(t, db.Exec(`CREATE TABLE intent_analyses (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		workflow_id TEXT, project_id TEXT, intent_type TEXT, confidence REAL, entities TEXT,
+		required_params TEXT, optional_params TEXT, risks TEXT, estimated_time INTEGER,
+		estimated_cost REAL, low_confidence BOOLEAN, created_at DATETIME)`).Error)
+}
+
+func TestProjectService_GetIntentStats(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	createIntentAnalysesTable(t, db)
+
+	now := time.Now()
+	analyze := func(projectID, intentType string, confidence float64, createdAt time.Time, entities ...Entity) {
+		data, err := json.Marshal(entities)
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&models.IntentAnalysis{
+			WorkflowID:    "workflow-" + intentType,
+			ProjectID:     projectID,
+			IntentType:    intentType,
+			Confidence:    confidence,
+			Entities:      data,
+			LowConfidence: confidence < 0.6,
+			CreatedAt:     createdAt,
+		}).Error)
+	}
+	goLang := Entity{Type: "language", Value: "go"}
+	python := Entity{Type: "language", Value: "python"}
+	gin := Entity{Type: "framework", Value: "gin"}
+
+	analyze("project-1", "code_generation", 0.9, now.Add(-time.Hour), goLang, gin)
+	analyze("project-1", "code_generation", 0.7, now.Add(-2*time.Hour), goLang)
+	analyze("project-1", "deployment", 0.5, now.Add(-3*time.Hour), python, goLang)
+	// Outside the window or the project
+	analyze("project-1", "deployment", 0.1, now.Add(-48*time.Hour), python)
+	analyze("project-2", "code_generation", 0.2, now.Add(-time.Hour), python)
+
+	service := NewProjectService(db, zap.NewNop())
+	stats, err := service.GetIntentStats(context.Background(), "project-1", now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(3), stats.TotalAnalyses)
+	assert.Equal(t, int64(1), stats.LowConfidence)
+	assert.InDelta(t, 0.7, stats.AverageConfidence, 0.0001)
+
+	require.Len(t, stats.IntentTypes, 2)
+	assert.Equal(t, "code_generation", stats.IntentTypes[0].IntentType)
+	assert.Equal(t, int64(2), stats.IntentTypes[0].Count)
+	assert.InDelta(t, 0.8, stats.IntentTypes[0].AverageConfidence, 0.0001)
+	assert.Equal(t, "deployment", stats.IntentTypes[1].IntentType)
+	assert.Equal(t, int64(1), stats.IntentTypes[1].Count)
+	assert.InDelta(t, 0.5, stats.IntentTypes[1].AverageConfidence, 0.0001)
+
+	assert.Equal(t, []EntityStats{
+		{Type: "language", Value: "go", Count: 3},
+		{Type: "framework", Value: "gin", Count: 1},
+		{Type: "language", Value: "python", Count: 1},
+	}, stats.TopEntities)
+
+	// An empty window reports zeroes rather than dividing by zero
+	empty, err := service.GetIntentStats(context.Background(), "project-3", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	assert.Zero(t, empty.TotalAnalyses)
+	assert.Zero(t, empty.AverageConfidence)
+	assert.Empty(t, empty.IntentTypes)
+	assert.Empty(t, empty.TopEntities)
+}
@@ -2,7 +2,7 @@ package services
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,175 +11,153 @@ import (
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/backoff"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/keepalive"
-	"google.golang.org/grpc/metadata"
 
 	"orchestrator/internal/config"
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/requestid"
+	"orchestrator/internal/retry"
+	"orchestrator/internal/serviceauth"
 	pb "orchestrator/internal/proto/intent"
 )
 
-// IntentClient handles communication with the Intent Processor service
+// IntentClient handles communication with the Intent Processor service.
+// Tracing, timeouts and retries are applied here so that every transport
+// behaves the same way.
 type IntentClient struct {
-	conn     *grpc.ClientConn
-	client   pb.IntentServiceClient
-	logger   *zap.Logger
-	config   *config.IntentAPIConfig
-	tracer   trace.Tracer
+	transport intentTransport
+	logger    *zap.Logger
+	config    *config.IntentAPIConfig
+	tracer    trace.Tracer
 }
 
-// NewIntentClient creates a new Intent API client
+// intentTransport is the wire protocol used to reach the Intent Processor
+type intentTransport interface {
+	ProcessIntent(ctx context.Context, req *ProcessIntentRequest) (*ProcessIntentResponse, error)
+	GetIntentStatus(ctx context.Context, intentID string) (*IntentStatus, error)
+	CancelIntent(ctx context.Context, intentID string, reason string) error
+	AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error)
+	Close() error
+}
+
+// NewIntentClient creates a new Intent API client using the configured transport
 func NewIntentClient(cfg *config.IntentAPIConfig, logger *zap.Logger) (*IntentClient, error) {
-	// Set up connection options
-	opts := []grpc.DialOption{
-		grpc.WithDefaultCallOptions(
-			grpc.MaxCallRecvMsgSize(10 * 1024 * 1024), // 10MB
-			grpc.MaxCallSendMsgSize(10 * 1024 * 1024), // 10MB
-		),
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:                time.Duration(cfg.KeepAliveInterval) * time.Second,
-			Timeout:             time.Duration(cfg.KeepAliveTimeout) * time.Second,
-			PermitWithoutStream: true,
-		}),
-		grpc.WithConnectParams(grpc.ConnectParams{
-			Backoff: backoff.Config{
-				BaseDelay:  1.0 * time.Second,
-				Multiplier: 1.5,
-				Jitter:     0.2,
-				MaxDelay:   30 * time.Second,
-			},
-			MinConnectTimeout: time.Duration(cfg.Timeout) * time.Second,
-		}),
-	}
+	var (
+		transport intentTransport
+		err       error
+	)
 
-	// Configure TLS if enabled
-	if cfg.EnableTLS {
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		}
-		
-		if cfg.TLSCACertFile != "" {
-			creds, err := credentials.NewClientTLSFromFile(cfg.TLSCACertFile, "")
-			if err != nil {
-				return nil, fmt.Errorf("failed to load TLS credentials: %w", err)
-			}
-			opts = append(opts, grpc.WithTransportCredentials(creds))
-		} else {
-			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
-		}
-	} else {
-		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	switch cfg.Transport {
+	case "", "grpc":
+		transport, err = newGRPCIntentTransport(cfg)
+	case "http":
+		transport, err = newHTTPIntentTransport(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported intent API transport: %s", cfg.Transport)
 	}
-
-	// Establish connection
-	conn, err := grpc.Dial(cfg.Address, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to intent service: %w", err)
+		return nil, err
 	}
 
+	return newIntentClientWithTransport(cfg, transport, logger), nil
+}
+
+// newIntentClientWithTransport wraps an already constructed transport
+func newIntentClientWithTransport(cfg *config.IntentAPIConfig, transport intentTransport, logger *zap.Logger) *IntentClient {
 	return &IntentClient{
-		conn:   conn,
-		client: pb.NewIntentServiceClient(conn),
-		logger: logger,
-		config: cfg,
-		tracer: otel.Tracer("intent-client"),
-	}, nil
+		transport: transport,
+		logger:    logger,
+		config:    cfg,
+		tracer:    otel.Tracer("intent-client"),
+	}
 }
 
-// Close closes the gRPC connection
+// Close closes the underlying transport
 func (c *IntentClient) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	if c.transport != nil {
+		return c.transport.Close()
 	}
 	return nil
 }
 
+// LowConfidenceThreshold is the confidence below which an intent analysis
+// flags its workflow with a warning
+func (c *IntentClient) LowConfidenceThreshold() float64 {
+	return c.config.LowConfidenceThreshold
+}
+
 // ProcessIntent sends an intent to the Intent Processor service
 func (c *IntentClient) ProcessIntent(ctx context.Context, req *ProcessIntentRequest) (*ProcessIntentResponse, error) {
 	ctx, span := c.tracer.Start(ctx, "ProcessIntent",
 		trace.WithAttributes(
 			attribute.String("intent.type", req.Type),
 			attribute.String("project.id", req.ProjectID),
+			attribute.String("intent.transport", c.transportName()),
 		),
 	)
 	defer span.End()
 
+	if req.RequestID == "" {
+		req.RequestID = requestid.FromContext(ctx)
+	}
+
 	// Set timeout
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.Timeout)*time.Second)
 	defer cancel()
 
-	// Add metadata
-	ctx = metadata.AppendToOutgoingContext(ctx,
-		"x-project-id", req.ProjectID,
-		"x-user-id", req.UserID,
-		"x-request-id", req.RequestID,
-	)
-
-	// Create gRPC request
-	grpcReq := &pb.ProcessIntentRequest{
-		Intent: &pb.Intent{
-			Type:        req.Type,
-			Content:     req.Content,
-			Context:     req.Context,
-			Parameters:  convertMapToString(req.Parameters),
-			Constraints: convertMapToString(req.Constraints),
-		},
-		ProjectId: req.ProjectID,
-		UserId:    req.UserID,
-		RequestId: req.RequestID,
-		Options: &pb.ProcessingOptions{
-			Async:          req.Async,
-			Priority:       req.Priority,
-			TimeoutSeconds: int32(req.TimeoutSeconds),
-			MaxRetries:     int32(req.MaxRetries),
-		},
+	// Activities calling the client are retried by Temporal, so the client
+	// only retries callers that opted in; retrying in both layers would
+	// multiply the attempts a failing Intent Processor receives
+	maxRetries := 0
+	if retry.ClientRetries(ctx) {
+		maxRetries = c.config.MaxRetries
 	}
+	backoff := retry.DefaultBackoff
+	backoff.Initial = time.Duration(c.config.RetryInterval) * time.Second
 
-	// Make the call with retry
-	var resp *pb.ProcessIntentResponse
+	var resp *ProcessIntentResponse
 	var err error
-	
-	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
-		resp, err = c.client.ProcessIntent(ctx, grpcReq)
+	attempts := 0
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		attempts++
+		resp, err = c.transport.ProcessIntent(ctx, req)
 		if err == nil {
 			break
 		}
+		if errors.Is(err, serviceauth.ErrUnauthorized) {
+			// Retrying with the same token cannot succeed
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return nil, fmt.Errorf("failed to process intent: %w", err)
+		}
 
-		if attempt < c.config.MaxRetries {
+		if attempt < maxRetries {
+			delay := backoff.Delay(attempt+1, req.RequestID)
 			c.logger.Warn("intent processing failed, retrying",
 				zap.Error(err),
 				zap.Int("attempt", attempt+1),
-				zap.Int("max_retries", c.config.MaxRetries),
+				zap.Int("max_retries", maxRetries),
+				zap.Duration("backoff", delay),
 			)
-			time.Sleep(time.Duration(c.config.RetryInterval) * time.Second)
-			continue
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
 		}
 	}
 
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
-		return nil, fmt.Errorf("failed to process intent after %d attempts: %w", c.config.MaxRetries, err)
+		return nil, fmt.Errorf("failed to process intent after %d attempts: %w", attempts, err)
 	}
 
 	span.SetStatus(codes.Ok, "Intent processed successfully")
 
-	// Convert response
-	result := &ProcessIntentResponse{
-		IntentID:    resp.IntentId,
-		Status:      resp.Status,
-		Message:     resp.Message,
-		Result:      convertMapToInterface(resp.Result),
-		Confidence:  resp.Confidence,
-		Actions:     convertActions(resp.Actions),
-		Suggestions: resp.Suggestions,
-		Metadata:    resp.Metadata,
-	}
-
-	return result, nil
+	return resp, nil
 }
 
 // GetIntentStatus retrieves the status of an intent
@@ -187,6 +165,7 @@ func (c *IntentClient) GetIntentStatus(ctx context.Context, intentID string) (*I
 	ctx, span := c.tracer.Start(ctx, "GetIntentStatus",
 		trace.WithAttributes(
 			attribute.String("intent.id", intentID),
+			attribute.String("intent.transport", c.transportName()),
 		),
 	)
 	defer span.End()
@@ -194,26 +173,14 @@ func (c *IntentClient) GetIntentStatus(ctx context.Context, intentID string) (*I
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.Timeout)*time.Second)
 	defer cancel()
 
-	req := &pb.GetIntentStatusRequest{
-		IntentId: intentID,
-	}
-
-	resp, err := c.client.GetIntentStatus(ctx, req)
+	status, err := c.transport.GetIntentStatus(ctx, intentID)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("failed to get intent status: %w", err)
 	}
 
-	return &IntentStatus{
-		IntentID:    resp.IntentId,
-		Status:      resp.Status,
-		Progress:    int(resp.Progress),
-		Message:     resp.Message,
-		StartedAt:   resp.StartedAt.AsTime(),
-		CompletedAt: resp.CompletedAt.AsTime(),
-		Error:       resp.Error,
-	}, nil
+	return status, nil
 }
 
 // CancelIntent cancels a running intent
@@ -222,6 +189,7 @@ func (c *IntentClient) CancelIntent(ctx context.Context, intentID string, reason
 		trace.WithAttributes(
 			attribute.String("intent.id", intentID),
 			attribute.String("reason", reason),
+			attribute.String("intent.transport", c.transportName()),
 		),
 	)
 	defer span.End()
@@ -229,13 +197,7 @@ func (c *IntentClient) CancelIntent(ctx context.Context, intentID string, reason
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.Timeout)*time.Second)
 	defer cancel()
 
-	req := &pb.CancelIntentRequest{
-		IntentId: intentID,
-		Reason:   reason,
-	}
-
-	_, err := c.client.CancelIntent(ctx, req)
-	if err != nil {
+	if err := c.transport.CancelIntent(ctx, intentID, reason); err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		return fmt.Errorf("failed to cancel intent: %w", err)
@@ -246,37 +208,37 @@ func (c *IntentClient) CancelIntent(ctx context.Context, intentID string, reason
 
 // AnalyzeIntent analyzes an intent without processing it
 func (c *IntentClient) AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error) {
-	ctx, span := c.tracer.Start(ctx, "AnalyzeIntent")
+	ctx, span := c.tracer.Start(ctx, "AnalyzeIntent",
+		trace.WithAttributes(
+			attribute.String("intent.transport", c.transportName()),
+		),
+	)
 	defer span.End()
 
+	// The timeout only shortens the deadline of the caller's request
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.Timeout)*time.Second)
 	defer cancel()
-
-	grpcReq := &pb.AnalyzeIntentRequest{
-		Content:    req.Content,
-		Context:    req.Context,
-		ProjectId:  req.ProjectID,
-		UserId:     req.UserID,
+	if err := deadline.Check(ctx, "analyzing the intent"); err != nil {
+		span.RecordError(err)
+		return nil, err
 	}
 
-	resp, err := c.client.AnalyzeIntent(ctx, grpcReq)
+	resp, err := c.transport.AnalyzeIntent(ctx, req)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		return nil, fmt.Errorf("failed to analyze intent: %w", err)
 	}
 
-	return &AnalyzeIntentResponse{
-		IntentType:     resp.IntentType,
-		Confidence:     resp.Confidence,
-		Entities:       convertEntities(resp.Entities),
-		RequiredParams: resp.RequiredParams,
-		OptionalParams: resp.OptionalParams,
-		Suggestions:    resp.Suggestions,
-		Risks:          resp.Risks,
-		EstimatedTime:  int(resp.EstimatedTimeSeconds),
-		EstimatedCost:  resp.EstimatedCost,
-	}, nil
+	return resp, nil
+}
+
+// transportName returns the configured transport for span attributes
+func (c *IntentClient) transportName() string {
+	if c.config.Transport == "" {
+		return "grpc"
+	}
+	return c.config.Transport
 }
 
 // convertActions converts gRPC actions to internal format
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"orchestrator/internal/config"
+	pb "orchestrator/internal/proto/intent"
+	"orchestrator/internal/retry"
+	"orchestrator/internal/serviceauth"
+)
+
+// fakeIntentService is an in-memory Intent Processor shared by both transports
+type fakeIntentService struct {
+	mu              sync.Mutex
+	intents         map[string]*pb.GetIntentStatusResponse
+	failNextProcess int
+	rejectToken     bool
+	processCalls    int
+}
+
+func newFakeIntentService() *fakeIntentService {
+	return &fakeIntentService{intents: make(map[string]*pb.GetIntentStatusResponse)}
+}
+
+func (f *fakeIntentService) ProcessIntent(ctx context.Context, in *pb.ProcessIntentRequest, opts ...grpc.CallOption) (*pb.ProcessIntentResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.processCalls++
+	if f.rejectToken {
+		return nil, status.Error(codes.Unauthenticated, "invalid service token")
+	}
+	if f.failNextProcess > 0 {
+		f.failNextProcess--
+		return nil, fmt.Errorf("intent processor unavailable")
+	}
+
+	id := fmt.Sprintf("intent-%d", len(f.intents)+1)
+	f.intents[id] = &pb.GetIntentStatusResponse{
+		IntentId:  id,
+		Status:    "processing",
+		Progress:  10,
+		StartedAt: timestamppb.Now(),
+	}
+
+	return &pb.ProcessIntentResponse{
+		IntentId:    id,
+		Status:      "processing",
+		Message:     "Intent received",
+		Result:      map[string]string{"type": in.Intent.Type},
+		Confidence:  0.9,
+		Actions:     []*pb.Action{{Id: "action-1", Type: "generate_code", Status: "pending"}},
+		Suggestions: []string{"add tests"},
+		Metadata:    map[string]string{"project_id": in.ProjectId},
+	}, nil
+}
+
+func (f *fakeIntentService) GetIntentStatus(ctx context.Context, in *pb.GetIntentStatusRequest, opts ...grpc.CallOption) (*pb.GetIntentStatusResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status, ok := f.intents[in.IntentId]
+	if !ok {
+		return nil, fmt.Errorf("intent not found: %s", in.IntentId)
+	}
+	return status, nil
+}
+
+func (f *fakeIntentService) CancelIntent(ctx context.Context, in *pb.CancelIntentRequest, opts ...grpc.CallOption) (*pb.CancelIntentResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status, ok := f.intents[in.IntentId]
+	if !ok {
+		return nil, fmt.Errorf("intent not found: %s", in.IntentId)
+	}
+	status.Status = "cancelled"
+	status.Message = in.Reason
+	return &pb.CancelIntentResponse{Success: true}, nil
+}
+
+func (f *fakeIntentService) AnalyzeIntent(ctx context.Context, in *pb.AnalyzeIntentRequest, opts ...grpc.CallOption) (*pb.AnalyzeIntentResponse, error) {
+	return &pb.AnalyzeIntentResponse{
+		IntentType:           "code_generation",
+		Confidence:           0.8,
+		Entities:             []*pb.Entity{{Type: "language", Value: "go", Confidence: 0.95, Start: 0, End: 2}},
+		RequiredParams:       []string{"language"},
+		Risks:                []string{},
+		EstimatedTimeSeconds: 120,
+		EstimatedCost:        0.5,
+	}, nil
+}
+
+// newFakeIntentHTTPServer exposes the fake service through the REST contract
+func newFakeIntentHTTPServer(t *testing.T, svc *fakeIntentService) *httptest.Server {
+	backend := &grpcIntentTransport{client: svc}
+
+	writeJSON := func(w http.ResponseWriter, code int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(v)
+	}
+	writeErr := func(w http.ResponseWriter, err error) {
+		if errors.Is(err, serviceauth.ErrUnauthorized) {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Code: "UNAUTHORIZED", Message: "invalid service token"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Code: "INTERNAL", Message: err.Error()})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/intents/process", func(w http.ResponseWriter, r *http.Request) {
+		var req ProcessIntentRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		resp, err := backend.ProcessIntent(r.Context(), &req)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+	mux.HandleFunc("/api/v1/intents/analyze", func(w http.ResponseWriter, r *http.Request) {
+		var req AnalyzeIntentRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		resp, err := backend.AnalyzeIntent(r.Context(), &req)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+	mux.HandleFunc("/api/v1/intents/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/intents/"), "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case parts[1] == "status" && r.Method == http.MethodGet:
+			status, err := backend.GetIntentStatus(r.Context(), parts[0])
+			if err != nil {
+				writeErr(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, status)
+		case parts[1] == "cancel" && r.Method == http.MethodPost:
+			var body cancelIntentBody
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			if err := backend.CancelIntent(r.Context(), parts[0], body.Reason); err != nil {
+				writeErr(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newContractClients builds one IntentClient per transport against the same fake service
+func newContractClients(t *testing.T, svc *fakeIntentService) map[string]*IntentClient {
+	server := newFakeIntentHTTPServer(t, svc)
+
+	grpcCfg := &config.IntentAPIConfig{Transport: "grpc", Timeout: 5, MaxRetries: 1}
+	httpCfg := &config.IntentAPIConfig{Transport: "http", HTTPBaseURL: server.URL, Timeout: 5, MaxRetries: 1}
+
+	httpTransport, err := newHTTPIntentTransport(httpCfg)
+	require.NoError(t, err)
+
+	return map[string]*IntentClient{
+		"grpc": newIntentClientWithTransport(grpcCfg, &grpcIntentTransport{client: svc}, zap.NewNop()),
+		"http": newIntentClientWithTransport(httpCfg, httpTransport, zap.NewNop()),
+	}
+}
+
+func TestIntentClient_TransportContract(t *testing.T) {
+	for _, transport := range []string{"grpc", "http"} {
+		t.Run(transport, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("ProcessIntent", func(t *testing.T) {
+				client := newContractClients(t, newFakeIntentService())[transport]
+
+				resp, err := client.ProcessIntent(ctx, &ProcessIntentRequest{
+					Type:      "code_generation",
+					Content:   "build a REST API",
+					ProjectID: "project-1",
+					UserID:    "user-1",
+				})
+				require.NoError(t, err)
+				assert.Equal(t, "intent-1", resp.IntentID)
+				assert.Equal(t, "processing", resp.Status)
+				assert.Equal(t, float32(0.9), resp.Confidence)
+				assert.Equal(t, "code_generation", resp.Result["type"])
+				require.Len(t, resp.Actions, 1)
+				assert.Equal(t, "generate_code", resp.Actions[0].Type)
+				assert.Equal(t, []string{"add tests"}, resp.Suggestions)
+				assert.Equal(t, "project-1", resp.Metadata["project_id"])
+			})
+
+			t.Run("ProcessIntentRetries", func(t *testing.T) {
+				svc := newFakeIntentService()
+				svc.failNextProcess = 1
+				client := newContractClients(t, svc)[transport]
+
+				resp, err := client.ProcessIntent(retry.WithClientRetries(ctx), &ProcessIntentRequest{Type: "code_generation"})
+				require.NoError(t, err)
+				assert.Equal(t, "intent-1", resp.IntentID)
+			})
+
+			t.Run("ProcessIntentSingleAttemptByDefault", func(t *testing.T) {
+				svc := newFakeIntentService()
+				svc.failNextProcess = 2
+				client := newContractClients(t, svc)[transport]
+
+				_, err := client.ProcessIntent(ctx, &ProcessIntentRequest{Type: "code_generation"})
+				require.Error(t, err)
+				assert.Equal(t, 1, svc.failNextProcess, "the call is not retried")
+			})
+
+			t.Run("ProcessIntentExhaustsRetries", func(t *testing.T) {
+				svc := newFakeIntentService()
+				svc.failNextProcess = 2
+				client := newContractClients(t, svc)[transport]
+
+				_, err := client.ProcessIntent(retry.WithClientRetries(ctx), &ProcessIntentRequest{Type: "code_generation"})
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "intent processor unavailable")
+			})
+
+			t.Run("ProcessIntentRejectedToken", func(t *testing.T) {
+				svc := newFakeIntentService()
+				svc.rejectToken = true
+				client := newContractClients(t, svc)[transport]
+
+				_, err := client.ProcessIntent(ctx, &ProcessIntentRequest{Type: "code_generation"})
+				require.ErrorIs(t, err, serviceauth.ErrUnauthorized)
+				assert.Contains(t, err.Error(), "intent_api.auth.token")
+				assert.Equal(t, 1, svc.processCalls, "rejected tokens are not retried")
+			})
+
+			t.Run("StatusAndCancel", func(t *testing.T) {
+				client := newContractClients(t, newFakeIntentService())[transport]
+
+				resp, err := client.ProcessIntent(ctx, &ProcessIntentRequest{Type: "code_generation"})
+				require.NoError(t, err)
+
+				status, err := client.GetIntentStatus(ctx, resp.IntentID)
+				require.NoError(t, err)
+				assert.Equal(t, "processing", status.Status)
+				assert.Equal(t, 10, status.Progress)
+				assert.False(t, status.StartedAt.IsZero())
+
+				require.NoError(t, client.CancelIntent(ctx, resp.IntentID, "user requested"))
+
+				status, err = client.GetIntentStatus(ctx, resp.IntentID)
+				require.NoError(t, err)
+				assert.Equal(t, "cancelled", status.Status)
+				assert.Equal(t, "user requested", status.Message)
+			})
+
+			t.Run("UnknownIntent", func(t *testing.T) {
+				client := newContractClients(t, newFakeIntentService())[transport]
+
+				_, err := client.GetIntentStatus(ctx, "missing")
+				assert.Error(t, err)
+				assert.Error(t, client.CancelIntent(ctx, "missing", "cleanup"))
+			})
+
+			t.Run("AnalyzeIntent", func(t *testing.T) {
+				client := newContractClients(t, newFakeIntentService())[transport]
+
+				resp, err := client.AnalyzeIntent(ctx, &AnalyzeIntentRequest{Content: "write go code"})
+				require.NoError(t, err)
+				assert.Equal(t, "code_generation", resp.IntentType)
+				assert.Equal(t, 120, resp.EstimatedTime)
+				require.Len(t, resp.Entities, 1)
+				assert.Equal(t, "go", resp.Entities[0].Value)
+				assert.Equal(t, 2, resp.Entities[0].End)
+			})
+		})
+	}
+}
+
+func TestNewIntentClient_UnsupportedTransport(t *testing.T) {
+	_, err := NewIntentClient(&config.IntentAPIConfig{Transport: "carrier-pigeon"}, zap.NewNop())
+	assert.Error(t, err)
+}
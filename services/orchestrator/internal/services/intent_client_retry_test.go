@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/retry"
+)
+
+// failingIntentTransport is an Intent Processor that is down, counting the
+// calls it receives
+type failingIntentTransport struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *failingIntentTransport) ProcessIntent(ctx context.Context, req *ProcessIntentRequest) (*ProcessIntentResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return nil, errors.New("intent processor unavailable")
+}
+
+func (f *failingIntentTransport) GetIntentStatus(ctx context.Context, intentID string) (*IntentStatus, error) {
+	return nil, errors.New("intent processor unavailable")
+}
+
+func (f *failingIntentTransport) CancelIntent(ctx context.Context, intentID string, reason string) error {
+	return errors.New("intent processor unavailable")
+}
+
+func (f *failingIntentTransport) AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error) {
+	return nil, errors.New("intent processor unavailable")
+}
+
+func (f *failingIntentTransport) Close() error { return nil }
+
+func (f *failingIntentTransport) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestIntentClient_RetriesOnlyWhenOptedIn(t *testing.T) {
+	cfg := &config.IntentAPIConfig{Timeout: 5, MaxRetries: 3}
+	ctx := context.Background()
+
+	// Activities are retried by Temporal, so the client makes one attempt
+	transport := &failingIntentTransport{}
+	client := newIntentClientWithTransport(cfg, transport, zap.NewNop())
+	_, err := client.ProcessIntent(ctx, &ProcessIntentRequest{Type: "code_generation"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "after 1 attempts")
+	assert.Equal(t, 1, transport.callCount())
+
+	transport = &failingIntentTransport{}
+	client = newIntentClientWithTransport(cfg, transport, zap.NewNop())
+	_, err = client.ProcessIntent(retry.WithClientRetries(ctx), &ProcessIntentRequest{Type: "code_generation"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "after 4 attempts")
+	assert.Equal(t, 4, transport.callCount())
+}
+
+func TestIntentClient_RetryBackoffStopsWithContext(t *testing.T) {
+	transport := &failingIntentTransport{}
+	client := newIntentClientWithTransport(&config.IntentAPIConfig{Timeout: 5, MaxRetries: 3, RetryInterval: 60}, transport, zap.NewNop())
+
+	ctx, cancel := context.WithTimeout(retry.WithClientRetries(context.Background()), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.ProcessIntent(ctx, &ProcessIntentRequest{Type: "code_generation"})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, 1, transport.callCount())
+}
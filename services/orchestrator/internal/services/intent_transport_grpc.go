@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/requestid"
+	"orchestrator/internal/serviceauth"
+	pb "orchestrator/internal/proto/intent"
+)
+
+// grpcIntentTransport talks to the Intent Processor over gRPC
+type grpcIntentTransport struct {
+	conn   *grpc.ClientConn
+	client pb.IntentServiceClient
+}
+
+// newGRPCIntentTransport dials the Intent Processor gRPC endpoint
+func newGRPCIntentTransport(cfg *config.IntentAPIConfig) (*grpcIntentTransport, error) {
+	// Set up connection options
+	opts := []grpc.DialOption{
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(10 * 1024 * 1024), // 10MB
+			grpc.MaxCallSendMsgSize(10 * 1024 * 1024), // 10MB
+		),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(cfg.KeepAliveInterval) * time.Second,
+			Timeout:             time.Duration(cfg.KeepAliveTimeout) * time.Second,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  1.0 * time.Second,
+				Multiplier: 1.5,
+				Jitter:     0.2,
+				MaxDelay:   30 * time.Second,
+			},
+			MinConnectTimeout: time.Duration(cfg.Timeout) * time.Second,
+		}),
+	}
+
+	// Configure TLS if enabled
+	if cfg.EnableTLS {
+		tlsConfig := &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+
+		if cfg.TLSCACertFile != "" {
+			creds, err := credentials.NewClientTLSFromFile(cfg.TLSCACertFile, "")
+			if err != nil {
+				return nil, fmt.Errorf("failed to load TLS credentials: %w", err)
+			}
+			opts = append(opts, grpc.WithTransportCredentials(creds))
+		} else {
+			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+		}
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	tokens, err := serviceauth.NewTokenSource(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure intent API auth: %w", err)
+	}
+	if tokens != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(serviceauth.RPCCredentials{Source: tokens, Secure: cfg.EnableTLS}))
+	}
+
+	// Establish connection
+	conn, err := grpc.Dial(cfg.Address, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to intent service: %w", err)
+	}
+
+	return &grpcIntentTransport{
+		conn:   conn,
+		client: pb.NewIntentServiceClient(conn),
+	}, nil
+}
+
+// Close closes the gRPC connection
+func (t *grpcIntentTransport) Close() error {
+	if t.conn != nil {
+		return t.conn.Close()
+	}
+	return nil
+}
+
+// ProcessIntent sends an intent over gRPC
+func (t *grpcIntentTransport) ProcessIntent(ctx context.Context, req *ProcessIntentRequest) (*ProcessIntentResponse, error) {
+	// Add metadata
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		"x-project-id", req.ProjectID,
+		"x-user-id", req.UserID,
+		"x-request-id", req.RequestID,
+	)
+	ctx = injectTraceMetadata(ctx)
+
+	grpcReq := &pb.ProcessIntentRequest{
+		Intent: &pb.Intent{
+			Type:        req.Type,
+			Content:     req.Content,
+			Context:     req.Context,
+			Parameters:  convertMapToString(req.Parameters),
+			Constraints: convertMapToString(req.Constraints),
+		},
+		ProjectId: req.ProjectID,
+		UserId:    req.UserID,
+		RequestId: req.RequestID,
+		Options: &pb.ProcessingOptions{
+			Async:          req.Async,
+			Priority:       req.Priority,
+			TimeoutSeconds: int32(req.TimeoutSeconds),
+			MaxRetries:     int32(req.MaxRetries),
+		},
+	}
+
+	resp, err := t.client.ProcessIntent(ctx, grpcReq)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &ProcessIntentResponse{
+		IntentID:    resp.IntentId,
+		Status:      resp.Status,
+		Message:     resp.Message,
+		Result:      convertMapToInterface(resp.Result),
+		Confidence:  resp.Confidence,
+		Actions:     convertActions(resp.Actions),
+		Suggestions: resp.Suggestions,
+		Metadata:    resp.Metadata,
+	}, nil
+}
+
+// GetIntentStatus retrieves the status of an intent over gRPC
+func (t *grpcIntentTransport) GetIntentStatus(ctx context.Context, intentID string) (*IntentStatus, error) {
+	resp, err := t.client.GetIntentStatus(injectTraceMetadata(ctx), &pb.GetIntentStatusRequest{
+		IntentId: intentID,
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &IntentStatus{
+		IntentID:    resp.IntentId,
+		Status:      resp.Status,
+		Progress:    int(resp.Progress),
+		Message:     resp.Message,
+		StartedAt:   resp.StartedAt.AsTime(),
+		CompletedAt: resp.CompletedAt.AsTime(),
+		Error:       resp.Error,
+	}, nil
+}
+
+// CancelIntent cancels a running intent over gRPC
+func (t *grpcIntentTransport) CancelIntent(ctx context.Context, intentID string, reason string) error {
+	_, err := t.client.CancelIntent(injectTraceMetadata(ctx), &pb.CancelIntentRequest{
+		IntentId: intentID,
+		Reason:   reason,
+	})
+	return grpcError(err)
+}
+
+// AnalyzeIntent analyzes an intent over gRPC
+func (t *grpcIntentTransport) AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error) {
+	resp, err := t.client.AnalyzeIntent(injectTraceMetadata(ctx), &pb.AnalyzeIntentRequest{
+		Content:   req.Content,
+		Context:   req.Context,
+		ProjectId: req.ProjectID,
+		UserId:    req.UserID,
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &AnalyzeIntentResponse{
+		IntentType:     resp.IntentType,
+		Confidence:     resp.Confidence,
+		Entities:       convertEntities(resp.Entities),
+		RequiredParams: resp.RequiredParams,
+		OptionalParams: resp.OptionalParams,
+		Suggestions:    resp.Suggestions,
+		Risks:          resp.Risks,
+		EstimatedTime:  int(resp.EstimatedTimeSeconds),
+		EstimatedCost:  resp.EstimatedCost,
+	}, nil
+}
+
+// grpcError surfaces a rejected service token as serviceauth.ErrUnauthorized
+func grpcError(err error) error {
+	if status.Code(err) == codes.Unauthenticated {
+		return fmt.Errorf("%w: %s", serviceauth.Rejected("intent processor", "intent_api.auth.token"), status.Convert(err).Message())
+	}
+	return err
+}
+
+// injectTraceMetadata propagates the current trace context and request ID as
+// gRPC metadata
+func injectTraceMetadata(ctx context.Context) context.Context {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	for k, v := range carrier {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if requestID := requestid.FromContext(ctx); requestID != "" && len(md.Get("x-request-id")) == 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", requestID)
+	}
+	return ctx
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/requestid"
+	"orchestrator/internal/serviceauth"
+)
+
+// httpIntentTransport talks to the Intent Processor REST API. It mirrors the
+// gRPC contract for deployments where gRPC cannot pass through the proxy layer.
+type httpIntentTransport struct {
+	httpClient *http.Client
+	baseURL    string
+	tokens     serviceauth.TokenSource
+}
+
+// cancelIntentBody is the request body for the cancel endpoint
+type cancelIntentBody struct {
+	Reason string `json:"reason"`
+}
+
+// newHTTPIntentTransport creates a REST transport for the Intent Processor
+func newHTTPIntentTransport(cfg *config.IntentAPIConfig) (*httpIntentTransport, error) {
+	if cfg.HTTPBaseURL == "" {
+		return nil, fmt.Errorf("intent API http_base_url is required for the http transport")
+	}
+
+	tokens, err := serviceauth.NewTokenSource(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure intent API auth: %w", err)
+	}
+
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     time.Duration(cfg.MaxConnectionIdle) * time.Second,
+	}
+
+	// Configure TLS if enabled
+	if cfg.EnableTLS {
+		tlsConfig := &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+
+		if cfg.TLSCACertFile != "" {
+			caCert, err := os.ReadFile(cfg.TLSCACertFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load TLS credentials: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("failed to parse TLS CA certificate")
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &httpIntentTransport{
+		httpClient: &http.Client{
+			Timeout:   time.Duration(cfg.Timeout) * time.Second,
+			Transport: transport,
+		},
+		baseURL: strings.TrimSuffix(cfg.HTTPBaseURL, "/"),
+		tokens:  tokens,
+	}, nil
+}
+
+// Close releases idle HTTP connections
+func (t *httpIntentTransport) Close() error {
+	t.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// ProcessIntent sends an intent over HTTP
+func (t *httpIntentTransport) ProcessIntent(ctx context.Context, req *ProcessIntentRequest) (*ProcessIntentResponse, error) {
+	header := http.Header{}
+	header.Set("X-Project-ID", req.ProjectID)
+	header.Set("X-User-ID", req.UserID)
+	header.Set("X-Request-ID", req.RequestID)
+
+	var resp ProcessIntentResponse
+	if err := t.doRequest(ctx, http.MethodPost, "/api/v1/intents/process", header, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetIntentStatus retrieves the status of an intent over HTTP
+func (t *httpIntentTransport) GetIntentStatus(ctx context.Context, intentID string) (*IntentStatus, error) {
+	var status IntentStatus
+	path := fmt.Sprintf("/api/v1/intents/%s/status", url.PathEscape(intentID))
+	if err := t.doRequest(ctx, http.MethodGet, path, nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// CancelIntent cancels a running intent over HTTP
+func (t *httpIntentTransport) CancelIntent(ctx context.Context, intentID string, reason string) error {
+	path := fmt.Sprintf("/api/v1/intents/%s/cancel", url.PathEscape(intentID))
+	return t.doRequest(ctx, http.MethodPost, path, nil, &cancelIntentBody{Reason: reason}, nil)
+}
+
+// AnalyzeIntent analyzes an intent over HTTP
+func (t *httpIntentTransport) AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error) {
+	var resp AnalyzeIntentResponse
+	if err := t.doRequest(ctx, http.MethodPost, "/api/v1/intents/analyze", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (t *httpIntentTransport) doRequest(ctx context.Context, method, path string, header http.Header, body interface{}, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if req.Header.Get(requestid.Header) == "" {
+		if requestID := requestid.FromContext(ctx); requestID != "" {
+			req.Header.Set(requestid.Header, requestID)
+		}
+	}
+	deadline.SetHeader(ctx, req.Header)
+
+	if err := serviceauth.SetHeaders(ctx, req.Header, t.tokens); err != nil {
+		return err
+	}
+
+	// Propagate trace context
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return serviceauth.Rejected("intent processor", "intent_api.auth.token")
+	}
+	if resp.StatusCode >= 400 {
+		var errorResp ErrorResponse
+		if err := json.Unmarshal(respBody, &errorResp); err == nil && errorResp.Message != "" {
+			return fmt.Errorf("API error: %s (code: %s)", errorResp.Message, errorResp.Code)
+		}
+		return fmt.Errorf("API error: %s", string(respBody))
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+var (
+	// ErrMaintenanceMode is returned for workflow starts while the
+	// orchestrator is in maintenance mode
+	ErrMaintenanceMode = errors.New("orchestrator is in maintenance mode")
+	// ErrInvalidMaintenance is returned for maintenance requests that cannot
+	// be applied
+	ErrInvalidMaintenance = errors.New("invalid maintenance request")
+)
+
+// defaultMaintenanceMessage is shown to clients when the operator gives no
+// message
+const defaultMaintenanceMessage = "The orchestrator is in maintenance mode"
+
+var maintenanceEnabled = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "orchestrator_maintenance_mode",
+	Help: "Whether the orchestrator is in read-only maintenance mode, as last seen by this replica",
+})
+
+// MaintenanceStatus is the read-only maintenance mode as set by an operator
+type MaintenanceStatus struct {
+	Enabled   bool       `json:"enabled"`
+	Message   string     `json:"message,omitempty"`
+	EnabledBy string     `json:"enabled_by,omitempty"`
+	EnabledAt *time.Time `json:"enabled_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Maintenance ends by itself at this time; never when nil
+}
+
+// MaintenanceRequest enables or disables maintenance mode
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+	TTL     int    `json:"ttl_seconds,omitempty"` // Seconds until maintenance ends by itself; 0 keeps it on until disabled
+}
+
+// MaintenanceStore keeps the maintenance status until it is cleared or
+// expires
+type MaintenanceStore interface {
+	Get(ctx context.Context) (*MaintenanceStatus, error) // Nil when maintenance is off
+	Put(ctx context.Context, status *MaintenanceStatus) error
+	Delete(ctx context.Context) error
+}
+
+const maintenanceKey = "maintenance:status"
+
+// redisMaintenanceStore keeps the status in a key expiring with it, so every
+// replica agrees on it
+type redisMaintenanceStore struct {
+	client *redis.Client
+}
+
+// NewRedisMaintenanceStore stores the maintenance status in Redis
+func NewRedisMaintenanceStore(client *redis.Client) MaintenanceStore {
+	return &redisMaintenanceStore{client: client}
+}
+
+func (s *redisMaintenanceStore) Get(ctx context.Context) (*MaintenanceStatus, error) {
+	data, err := s.client.Get(ctx, maintenanceKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status MaintenanceStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to decode maintenance status: %w", err)
+	}
+	return &status, nil
+}
+
+func (s *redisMaintenanceStore) Put(ctx context.Context, status *MaintenanceStatus) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if status.ExpiresAt != nil {
+		ttl = time.Until(*status.ExpiresAt)
+	}
+	return s.client.Set(ctx, maintenanceKey, data, ttl).Err()
+}
+
+func (s *redisMaintenanceStore) Delete(ctx context.Context) error {
+	return s.client.Del(ctx, maintenanceKey).Err()
+}
+
+// memoryMaintenanceStore keeps the maintenance status in memory, for a
+// single replica
+type memoryMaintenanceStore struct {
+	mu     sync.Mutex
+	status *MaintenanceStatus
+}
+
+// NewMemoryMaintenanceStore stores the maintenance status in memory
+func NewMemoryMaintenanceStore() MaintenanceStore {
+	return &memoryMaintenanceStore{}
+}
+
+func (s *memoryMaintenanceStore) Get(ctx context.Context) (*MaintenanceStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == nil {
+		return nil, nil
+	}
+	status := *s.status
+	return &status, nil
+}
+
+func (s *memoryMaintenanceStore) Put(ctx context.Context, status *MaintenanceStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *status
+	s.status = &stored
+	return nil
+}
+
+func (s *memoryMaintenanceStore) Delete(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = nil
+	return nil
+}
+
+// MaintenanceMode keeps the API up but read-only, e.g. during database
+// migrations. While it is on, mutating requests are rejected, workflow
+// starts fail with ErrMaintenanceMode and held workflows stay queued;
+// workflows already running are still monitored and may be cancelled.
+type MaintenanceMode struct {
+	store   MaintenanceStore
+	config  *config.MaintenanceConfig
+	logger  *zap.Logger
+	now     func() time.Time
+	refresh time.Duration
+
+	mu       sync.Mutex // guards status and loadedAt
+	status   *MaintenanceStatus
+	loadedAt time.Time
+}
+
+// NewMaintenanceMode creates a maintenance mode reading its status from store
+func NewMaintenanceMode(store MaintenanceStore, cfg *config.MaintenanceConfig, logger *zap.Logger) *MaintenanceMode {
+	return &MaintenanceMode{
+		store:   store,
+		config:  cfg,
+		logger:  logger,
+		now:     time.Now,
+		refresh: time.Duration(cfg.RefreshInterval) * time.Second,
+	}
+}
+
+// SetMaintenanceMode makes the engine refuse to start workflows, and its
+// scheduler hold queued ones, while maintenance mode is on
+func (e *WorkflowEngine) SetMaintenanceMode(maintenance *MaintenanceMode) {
+	e.maintenance = maintenance
+}
+
+// Set enables or disables maintenance mode on behalf of actor
+func (m *MaintenanceMode) Set(ctx context.Context, req *MaintenanceRequest, actor string) (*MaintenanceStatus, error) {
+	if !req.Enabled {
+		if err := m.store.Delete(ctx); err != nil {
+			return nil, fmt.Errorf("failed to disable maintenance mode: %w", err)
+		}
+		m.invalidate()
+		m.logger.Warn("Maintenance mode disabled", zap.String("actor", actor))
+		return &MaintenanceStatus{}, nil
+	}
+
+	if req.TTL < 0 || req.TTL > m.config.MaxTTL {
+		return nil, fmt.Errorf("%w: ttl must be between 0 and %d seconds", ErrInvalidMaintenance, m.config.MaxTTL)
+	}
+	now := m.now().UTC()
+	status := &MaintenanceStatus{
+		Enabled:   true,
+		Message:   req.Message,
+		EnabledBy: actor,
+		EnabledAt: &now,
+	}
+	if status.Message == "" {
+		status.Message = defaultMaintenanceMessage
+	}
+	if req.TTL > 0 {
+		expiresAt := now.Add(time.Duration(req.TTL) * time.Second)
+		status.ExpiresAt = &expiresAt
+	}
+	if err := m.store.Put(ctx, status); err != nil {
+		return nil, fmt.Errorf("failed to enable maintenance mode: %w", err)
+	}
+	m.invalidate()
+
+	fields := []zap.Field{zap.String("actor", actor), zap.String("message", status.Message)}
+	if status.ExpiresAt != nil {
+		fields = append(fields, zap.Time("expires_at", *status.ExpiresAt))
+	}
+	m.logger.Warn("Maintenance mode enabled", fields...)
+	return status, nil
+}
+
+// Status returns the maintenance status, reloaded from the store at most
+// once per refresh interval. Maintenance that cannot be loaded is off, so an
+// unreachable store does not take the API down with it.
+func (m *MaintenanceMode) Status(ctx context.Context) MaintenanceStatus {
+	m.mu.Lock()
+	cached := !m.loadedAt.IsZero() && m.now().Sub(m.loadedAt) < m.refresh
+	status := m.status
+	m.mu.Unlock()
+
+	if !cached {
+		loaded, err := m.store.Get(ctx)
+		if err != nil {
+			m.logger.Warn("Failed to load maintenance status", zap.Error(err))
+			loaded = nil
+		}
+		m.mu.Lock()
+		m.status = loaded
+		m.loadedAt = m.now()
+		m.mu.Unlock()
+		status = loaded
+	}
+
+	// Expiry is checked on every call so maintenance ends on time
+	if status == nil || !status.Enabled || (status.ExpiresAt != nil && !m.now().Before(*status.ExpiresAt)) {
+		maintenanceEnabled.Set(0)
+		return MaintenanceStatus{}
+	}
+	maintenanceEnabled.Set(1)
+	return *status
+}
+
+// Active reports whether maintenance mode is on. A nil maintenance mode is
+// never on.
+func (m *MaintenanceMode) Active(ctx context.Context) bool {
+	return m != nil && m.Status(ctx).Enabled
+}
+
+// Maintenance returns whether maintenance mode is on, the operator's message
+// and how long clients should wait before retrying: until maintenance
+// expires, or the configured retry_after when it has no expiry
+func (m *MaintenanceMode) Maintenance(ctx context.Context) (bool, string, time.Duration) {
+	status := m.Status(ctx)
+	if !status.Enabled {
+		return false, "", 0
+	}
+	retryAfter := time.Duration(m.config.RetryAfter) * time.Second
+	if status.ExpiresAt != nil {
+		retryAfter = status.ExpiresAt.Sub(m.now())
+	}
+	return true, status.Message, retryAfter
+}
+
+func (m *MaintenanceMode) invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loadedAt = time.Time{}
+}
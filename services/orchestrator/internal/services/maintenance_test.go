@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// newTestMaintenanceMode returns a maintenance mode over a memory store, on
+// the returned clock
+func newTestMaintenanceMode(now *time.Time) *MaintenanceMode {
+	maintenance := NewMaintenanceMode(NewMemoryMaintenanceStore(), &config.MaintenanceConfig{
+		RetryAfter:      300,
+		MaxTTL:          3600,
+		RefreshInterval: 2,
+	}, zap.NewNop())
+	maintenance.now = func() time.Time { return *now }
+	return maintenance
+}
+
+func TestMaintenanceMode_ExpiresByItself(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	maintenance := newTestMaintenanceMode(&now)
+	ctx := context.Background()
+
+	assert.False(t, maintenance.Active(ctx))
+	_, err := maintenance.Set(ctx, &MaintenanceRequest{Enabled: true, TTL: 7200}, "ops")
+	assert.ErrorIs(t, err, ErrInvalidMaintenance)
+
+	status, err := maintenance.Set(ctx, &MaintenanceRequest{Enabled: true, Message: "Migrating the database", TTL: 600}, "ops")
+	require.NoError(t, err)
+	assert.Equal(t, "ops", status.EnabledBy)
+	assert.Equal(t, now.Add(10*time.Minute), *status.ExpiresAt)
+
+	active, message, retryAfter := maintenance.Maintenance(ctx)
+	assert.True(t, active)
+	assert.Equal(t, "Migrating the database", message)
+	assert.Equal(t, 10*time.Minute, retryAfter)
+
+	// Expiry applies even while the status is cached
+	now = now.Add(10 * time.Minute)
+	active, _, _ = maintenance.Maintenance(ctx)
+	assert.False(t, active)
+
+	// Without a TTL maintenance lasts until it is disabled, and clients
+	// retry after the configured delay
+	_, err = maintenance.Set(ctx, &MaintenanceRequest{Enabled: true}, "ops")
+	require.NoError(t, err)
+	now = now.Add(48 * time.Hour)
+	active, message, retryAfter = maintenance.Maintenance(ctx)
+	assert.True(t, active)
+	assert.Equal(t, defaultMaintenanceMessage, message)
+	assert.Equal(t, 5*time.Minute, retryAfter)
+
+	_, err = maintenance.Set(ctx, &MaintenanceRequest{Enabled: false}, "ops")
+	require.NoError(t, err)
+	assert.False(t, maintenance.Active(ctx))
+	assert.False(t, (*MaintenanceMode)(nil).Active(ctx))
+}
+
+func TestMaintenanceMode_QueuedWorkflowsStartAfterMaintenance(t *testing.T) {
+	ctx := context.Background()
+	engine, scheduler, db, started := setupFairShareTest(t, 1, nil)
+	now := time.Now()
+	maintenance := newTestMaintenanceMode(&now)
+	engine.SetMaintenanceMode(maintenance)
+
+	running := startTaskWorkflow(t, engine, "project-a")
+	held := startTaskWorkflow(t, engine, "project-b")
+	require.NotEmpty(t, held.SchedulingReason)
+
+	_, err := maintenance.Set(ctx, &MaintenanceRequest{Enabled: true, Message: "Migrating"}, "ops")
+	require.NoError(t, err)
+
+	// New workflows are refused and queued ones stay queued, even with
+	// capacity free
+	_, err = engine.StartWorkflow(ctx, &StartWorkflowRequest{
+		Name: "build", Type: string(models.WorkflowTypeTaskExecution), ProjectID: "project-a", UserID: "alice",
+	})
+	assert.ErrorIs(t, err, ErrMaintenanceMode)
+	completeWorkflows(t, db, []string{running.WorkflowID})
+	scheduler.dispatch(ctx)
+	assert.Equal(t, map[string]int{"project-a": 1}, started)
+
+	var workflows int64
+	require.NoError(t, db.Model(&models.Workflow{}).Count(&workflows).Error)
+	assert.EqualValues(t, 2, workflows, "refused starts store nothing")
+
+	_, err = maintenance.Set(ctx, &MaintenanceRequest{Enabled: false}, "ops")
+	require.NoError(t, err)
+	scheduler.dispatch(ctx)
+	assert.Equal(t, map[string]int{"project-a": 1, "project-b": 1}, started)
+	var workflow models.Workflow
+	require.NoError(t, db.First(&workflow, "id = ?", held.WorkflowID).Error)
+	assert.Equal(t, models.WorkflowStatusRunning, workflow.Status)
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidPlan is returned for execution plans whose steps cannot run:
+// see PlanValidationError for why
+var ErrInvalidPlan = errors.New("invalid execution plan")
+
+// Kinds of PlanValidationError
+const (
+	PlanErrorMissingStepID      = "missing_step_id"
+	PlanErrorDuplicateStep      = "duplicate_step"
+	PlanErrorDanglingDependency = "dangling_dependency"
+	PlanErrorCycle              = "cycle"
+	PlanErrorTooManySteps       = "too_many_steps"
+	PlanErrorTooDeep            = "too_deep"
+	PlanErrorStepSettings       = "invalid_step_settings"
+)
+
+// Plan size limits applied when none are configured
+const (
+	defaultPlanMaxSteps = 200
+	defaultPlanMaxDepth = 50
+)
+
+// PlanStep is the part of an execution plan step its validation looks at.
+// Plans of templates, of the meta agent and of custom definitions all
+// describe steps this way.
+type PlanStep struct {
+	ID        string   `json:"id"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	StepActivitySettings
+}
+
+// PlanValidationError tells why an execution plan is invalid and which of
+// its steps are at fault
+type PlanValidationError struct {
+	Kind       string   `json:"kind"`
+	StepID     string   `json:"step_id,omitempty"`
+	Dependency string   `json:"dependency,omitempty"` // The unknown step a dangling dependency names
+	Cycle      []string `json:"cycle,omitempty"`      // Steps of a cycle, starting and ending with the same step
+	Message    string   `json:"message"`
+}
+
+func (e *PlanValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrInvalidPlan, e.Message)
+}
+
+func (e *PlanValidationError) Unwrap() error {
+	return ErrInvalidPlan
+}
+
+// PlanValidator checks that the steps of an execution plan can run: step
+// IDs are set and unique, dependencies name steps of the plan, the
+// dependency graph is acyclic, the plan is within the size limits, and the
+// activity settings of its steps are consistent and within the step limits.
+// A nil validator applies the default limits.
+type PlanValidator struct {
+	maxSteps int
+	maxDepth int
+	steps    StepActivityLimits
+}
+
+// defaultStepActivityLimits are the step limits of a nil validator
+var defaultStepActivityLimits = StepActivityLimits{
+	MaxTimeoutSeconds:   defaultMaxStepTimeout,
+	MaxHeartbeatSeconds: defaultMaxStepHeartbeat,
+	MaxAttempts:         defaultMaxStepAttempts,
+}
+
+// NewPlanValidator creates a plan validator with the configured limits, or
+// the default ones when cfg is nil
+func NewPlanValidator(cfg *config.PlansConfig) *PlanValidator {
+	v := &PlanValidator{maxSteps: defaultPlanMaxSteps, maxDepth: defaultPlanMaxDepth, steps: defaultStepActivityLimits}
+	if cfg != nil {
+		v.maxSteps = cfg.MaxSteps
+		v.maxDepth = cfg.MaxDepth
+		v.steps = StepActivityLimits{
+			MaxTimeoutSeconds:   cfg.MaxStepTimeout,
+			MaxHeartbeatSeconds: cfg.MaxStepHeartbeat,
+			MaxAttempts:         cfg.MaxStepAttempts,
+		}
+	}
+	return v
+}
+
+// Validate returns a *PlanValidationError for the first problem found in
+// steps, or nil when the plan can run
+func (v *PlanValidator) Validate(steps []PlanStep) error {
+	maxSteps, maxDepth := defaultPlanMaxSteps, defaultPlanMaxDepth
+	if v != nil {
+		maxSteps, maxDepth = v.maxSteps, v.maxDepth
+	}
+
+	if len(steps) > maxSteps {
+		return &PlanValidationError{
+			Kind:    PlanErrorTooManySteps,
+			Message: fmt.Sprintf("plan has %d steps, more than the limit of %d", len(steps), maxSteps),
+		}
+	}
+
+	byID := make(map[string]PlanStep, len(steps))
+	for i, step := range steps {
+		if step.ID == "" {
+			return &PlanValidationError{
+				Kind:    PlanErrorMissingStepID,
+				Message: fmt.Sprintf("step %d has no ID", i+1),
+			}
+		}
+		if _, ok := byID[step.ID]; ok {
+			return &PlanValidationError{
+				Kind:    PlanErrorDuplicateStep,
+				StepID:  step.ID,
+				Message: fmt.Sprintf("two steps have the ID %s", step.ID),
+			}
+		}
+		byID[step.ID] = step
+		if err := v.ValidateStepSettings(step.ID, step.StepActivitySettings); err != nil {
+			return err
+		}
+	}
+
+	for _, step := range steps {
+		for _, dependency := range step.DependsOn {
+			if _, ok := byID[dependency]; !ok {
+				return &PlanValidationError{
+					Kind:       PlanErrorDanglingDependency,
+					StepID:     step.ID,
+					Dependency: dependency,
+					Message:    fmt.Sprintf("step %s depends on unknown step %s", step.ID, dependency),
+				}
+			}
+		}
+	}
+
+	if cycle := findPlanCycle(steps, byID); cycle != nil {
+		return &PlanValidationError{
+			Kind:    PlanErrorCycle,
+			StepID:  cycle[0],
+			Cycle:   cycle,
+			Message: fmt.Sprintf("steps depend on each other: %s", strings.Join(cycle, " -> ")),
+		}
+	}
+
+	depths := make(map[string]int, len(steps))
+	for _, step := range steps {
+		if depth := planStepDepth(step, byID, depths); depth > maxDepth {
+			return &PlanValidationError{
+				Kind:    PlanErrorTooDeep,
+				StepID:  step.ID,
+				Message: fmt.Sprintf("step %s is at the end of a chain of %d dependent steps, more than the limit of %d", step.ID, depth, maxDepth),
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateStepSettings returns a *PlanValidationError when the activity
+// settings of a step are inconsistent or beyond the step limits
+func (v *PlanValidator) ValidateStepSettings(stepID string, settings StepActivitySettings) error {
+	limits := defaultStepActivityLimits
+	if v != nil {
+		limits = v.steps
+	}
+	if err := settings.Validate(limits); err != nil {
+		return &PlanValidationError{
+			Kind:    PlanErrorStepSettings,
+			StepID:  stepID,
+			Message: fmt.Sprintf("step %s: %v", stepID, err),
+		}
+	}
+	return nil
+}
+
+// findPlanCycle returns the steps of the first dependency cycle found,
+// walking the plan in order, with its first step repeated at the end
+func findPlanCycle(steps []PlanStep, byID map[string]PlanStep) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(steps))
+	var path []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		state[id] = visiting
+		path = append(path, id)
+		for _, dependency := range byID[id].DependsOn {
+			switch state[dependency] {
+			case visiting:
+				for i, step := range path {
+					if step == dependency {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dependency)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dependency); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		return nil
+	}
+
+	for _, step := range steps {
+		if state[step.ID] == unvisited {
+			if cycle := visit(step.ID); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// planStepDepth returns the length of the longest chain of dependencies
+// ending with step, counting it. The plan must be acyclic.
+func planStepDepth(step PlanStep, byID map[string]PlanStep, depths map[string]int) int {
+	if depth, ok := depths[step.ID]; ok {
+		return depth
+	}
+	depth := 1
+	for _, dependency := range step.DependsOn {
+		depth = max(depth, planStepDepth(byID[dependency], byID, depths)+1)
+	}
+	depths[step.ID] = depth
+	return depth
+}
+
+// SetPlanValidator sets the limits the plans of workflow templates are
+// validated against when workflows start from them
+func (e *WorkflowEngine) SetPlanValidator(plans *PlanValidator) {
+	e.plans = plans
+}
+
+// ValidateWorkflow checks a workflow request without starting it: the
+// checks StartWorkflow makes, the plan of its template and, when set, the
+// given plan steps
+func (e *WorkflowEngine) ValidateWorkflow(ctx context.Context, req *StartWorkflowRequest, steps []PlanStep) error {
+	if _, err := e.resolveRequest(req); err != nil {
+		return err
+	}
+	workflow, err := newWorkflowRecord(ctx, req, models.WorkflowStatusPending)
+	if err != nil {
+		return err
+	}
+	if err := e.applySandboxPolicy(ctx, workflow); err != nil {
+		return err
+	}
+	if err := e.validateTemplatePlan(ctx, workflow.TemplateID); err != nil {
+		return err
+	}
+	if err := e.validateCustomSteps(workflow); err != nil {
+		return err
+	}
+	if steps != nil {
+		return e.plans.Validate(steps)
+	}
+	return nil
+}
+
+// validateTemplatePlan checks the plan of the template a workflow starts
+// from, so an invalid plan fails the start rather than the running workflow
+func (e *WorkflowEngine) validateTemplatePlan(ctx context.Context, templateID *string) error {
+	if templateID == nil {
+		return nil
+	}
+	var template models.WorkflowTemplate
+	err := e.db.WithContext(ctx).Select("id", "steps").First(&template, "id = ?", *templateID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: template %s does not exist", ErrInvalidTemplate, *templateID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get workflow template: %w", err)
+	}
+	if len(template.Steps) == 0 {
+		return nil
+	}
+
+	var steps []PlanStep
+	if err := json.Unmarshal(template.Steps, &steps); err != nil {
+		return fmt.Errorf("%w: template %s steps are not a list of steps", ErrInvalidTemplate, *templateID)
+	}
+	if err := e.plans.Validate(steps); err != nil {
+		return fmt.Errorf("template %s: %w", *templateID, err)
+	}
+	return nil
+}
+
+// customStepSettings is what validation looks at of the steps of a custom
+// workflow's definition
+type customStepSettings struct {
+	Name       string `json:"name"`
+	MaxRetries int    `json:"max_retries"` // Max attempts of definitions from before max_attempts
+	StepActivitySettings
+}
+
+// validateCustomSteps checks the activity settings of the steps a custom
+// workflow's config defines, so invalid settings fail the start rather than
+// the running workflow
+func (e *WorkflowEngine) validateCustomSteps(workflow *models.Workflow) error {
+	if workflow.Type != models.WorkflowTypeCustom || len(workflow.Config) == 0 {
+		return nil
+	}
+	var definition struct {
+		Steps []customStepSettings `json:"steps"`
+	}
+	if err := json.Unmarshal(workflow.Config, &definition); err != nil {
+		return fmt.Errorf("%w: steps must be a list of steps", ErrInvalidWorkflowConfig)
+	}
+	for _, step := range definition.Steps {
+		settings := step.StepActivitySettings
+		if settings.MaxAttempts == 0 {
+			settings.MaxAttempts = step.MaxRetries
+		}
+		if err := e.plans.ValidateStepSettings(step.Name, settings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// chainPlan returns n steps, each depending on the one before
+func chainPlan(n int) []PlanStep {
+	steps := make([]PlanStep, n)
+	for i := range steps {
+		steps[i].ID = fmt.Sprintf("step-%d", i+1)
+		if i > 0 {
+			steps[i].DependsOn = []string{steps[i-1].ID}
+		}
+	}
+	return steps
+}
+
+// widePlan returns n steps depending on nothing
+func widePlan(n int) []PlanStep {
+	steps := make([]PlanStep, n)
+	for i := range steps {
+		steps[i].ID = fmt.Sprintf("step-%d", i+1)
+	}
+	return steps
+}
+
+func TestPlanValidator_Validate(t *testing.T) {
+	validator := NewPlanValidator(&config.PlansConfig{MaxSteps: 5, MaxDepth: 3})
+
+	tests := []struct {
+		name    string
+		steps   []PlanStep
+		want    *PlanValidationError // nil for valid plans
+		message string
+	}{
+		{name: "empty", steps: nil},
+		{name: "single step", steps: []PlanStep{{ID: "build"}}},
+		{name: "chain at the depth limit", steps: chainPlan(3)},
+		{name: "wide plan at the size limit", steps: widePlan(5)},
+		{
+			name: "diamond",
+			steps: []PlanStep{
+				{ID: "build"},
+				{ID: "test", DependsOn: []string{"build"}},
+				{ID: "lint", DependsOn: []string{"build"}},
+				{ID: "ship", DependsOn: []string{"test", "lint"}},
+			},
+		},
+		{
+			name:  "dependency listed after its dependent",
+			steps: []PlanStep{{ID: "ship", DependsOn: []string{"build"}}, {ID: "build"}},
+		},
+		{
+			name:    "missing step ID",
+			steps:   []PlanStep{{ID: "build"}, {}},
+			want:    &PlanValidationError{Kind: PlanErrorMissingStepID},
+			message: "step 2 has no ID",
+		},
+		{
+			name:    "duplicate step",
+			steps:   []PlanStep{{ID: "build"}, {ID: "test"}, {ID: "build"}},
+			want:    &PlanValidationError{Kind: PlanErrorDuplicateStep, StepID: "build"},
+			message: "two steps have the ID build",
+		},
+		{
+			name:    "dangling dependency",
+			steps:   []PlanStep{{ID: "build"}, {ID: "ship", DependsOn: []string{"build", "test"}}},
+			want:    &PlanValidationError{Kind: PlanErrorDanglingDependency, StepID: "ship", Dependency: "test"},
+			message: "step ship depends on unknown step test",
+		},
+		{
+			name:    "self dependency",
+			steps:   []PlanStep{{ID: "build", DependsOn: []string{"build"}}},
+			want:    &PlanValidationError{Kind: PlanErrorCycle, StepID: "build", Cycle: []string{"build", "build"}},
+			message: "steps depend on each other: build -> build",
+		},
+		{
+			name: "cycle",
+			steps: []PlanStep{
+				{ID: "build"},
+				{ID: "test", DependsOn: []string{"build", "ship"}},
+				{ID: "review", DependsOn: []string{"test"}},
+				{ID: "ship", DependsOn: []string{"review"}},
+			},
+			want:    &PlanValidationError{Kind: PlanErrorCycle, StepID: "test", Cycle: []string{"test", "ship", "review", "test"}},
+			message: "steps depend on each other: test -> ship -> review -> test",
+		},
+		{
+			name:    "too many steps",
+			steps:   widePlan(6),
+			want:    &PlanValidationError{Kind: PlanErrorTooManySteps},
+			message: "plan has 6 steps, more than the limit of 5",
+		},
+		{
+			name:    "too deep",
+			steps:   chainPlan(4),
+			want:    &PlanValidationError{Kind: PlanErrorTooDeep, StepID: "step-4"},
+			message: "step step-4 is at the end of a chain of 4 dependent steps, more than the limit of 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate(tt.steps)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidPlan)
+			var planErr *PlanValidationError
+			require.True(t, errors.As(err, &planErr))
+			tt.want.Message = tt.message
+			assert.Equal(t, tt.want, planErr)
+		})
+	}
+}
+
+func TestPlanValidator_DefaultLimits(t *testing.T) {
+	for _, validator := range []*PlanValidator{nil, NewPlanValidator(nil)} {
+		assert.NoError(t, validator.Validate(widePlan(defaultPlanMaxSteps)))
+		assert.ErrorIs(t, validator.Validate(widePlan(defaultPlanMaxSteps+1)), ErrInvalidPlan)
+		assert.NoError(t, validator.Validate(chainPlan(defaultPlanMaxDepth)))
+		assert.ErrorIs(t, validator.Validate(chainPlan(defaultPlanMaxDepth+1)), ErrInvalidPlan)
+	}
+}
+
+func TestWorkflowEngine_ValidateWorkflow(t *testing.T) {
+	_, db := setupTemplateCatalogTest(t)
+	engine := NewWorkflowEngine(db, nil, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+	engine.SetPlanValidator(NewPlanValidator(&config.PlansConfig{MaxSteps: 3, MaxDepth: 3}))
+
+	validID := "00000000-0000-0000-0000-0000000000a1"
+	cyclicID := "00000000-0000-0000-0000-0000000000a2"
+	createTemplate(t, db, &models.WorkflowTemplate{ID: validID, Name: "Build", Type: models.WorkflowTypeExecution, IsActive: true,
+		Steps: []byte(`[{"id": "compile"}, {"id": "test", "depends_on": ["compile"]}]`)})
+	createTemplate(t, db, &models.WorkflowTemplate{ID: cyclicID, Name: "Deploy", Type: models.WorkflowTypeDeployment, IsActive: true,
+		Steps: []byte(`[{"id": "ship", "depends_on": ["verify"]}, {"id": "verify", "depends_on": ["ship"]}]`)})
+
+	ctx := context.Background()
+	request := func(templateID string) *StartWorkflowRequest {
+		return &StartWorkflowRequest{Name: "Build", Type: string(models.WorkflowTypeExecution), ProjectID: "project-1", TemplateID: templateID}
+	}
+
+	assert.NoError(t, engine.ValidateWorkflow(ctx, request(""), nil))
+	assert.NoError(t, engine.ValidateWorkflow(ctx, request(validID), chainPlan(3)))
+	assert.ErrorIs(t, engine.ValidateWorkflow(ctx, request(""), widePlan(4)), ErrInvalidPlan)
+	assert.ErrorIs(t, engine.ValidateWorkflow(ctx, request("00000000-0000-0000-0000-0000000000ff"), nil), ErrInvalidTemplate)
+
+	err := engine.ValidateWorkflow(ctx, request(cyclicID), nil)
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+	assert.Contains(t, err.Error(), "ship -> verify -> ship")
+
+	// The template's plan is checked before the workflow is recorded
+	_, err = engine.StartWorkflow(ctx, request(cyclicID))
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+	var count int64
+	require.NoError(t, db.Model(&models.Workflow{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestPlanValidator_ValidatesStepSettings(t *testing.T) {
+	validator := NewPlanValidator(&config.PlansConfig{MaxSteps: 5, MaxDepth: 3,
+		MaxStepTimeout: 7200, MaxStepHeartbeat: 60, MaxStepAttempts: 5})
+
+	tests := []struct {
+		name     string
+		settings StepActivitySettings
+		message  string // Empty for valid settings
+	}{
+		{name: "none"},
+		{name: "long running migration", settings: StepActivitySettings{TimeoutSeconds: 7200, HeartbeatSeconds: 10,
+			ScheduleToCloseSeconds: 7200, MaxAttempts: 2, NonRetryableErrorTypes: []string{"MigrationConflict"}}},
+		{name: "heartbeat only", settings: StepActivitySettings{HeartbeatSeconds: 10}},
+		{name: "heartbeat as long as the timeout", settings: StepActivitySettings{TimeoutSeconds: 30, HeartbeatSeconds: 30},
+			message: "heartbeat_seconds must be less than timeout_seconds"},
+		{name: "heartbeat as long as all attempts", settings: StepActivitySettings{ScheduleToCloseSeconds: 20, HeartbeatSeconds: 20},
+			message: "heartbeat_seconds must be less than schedule_to_close_seconds"},
+		{name: "attempts bound shorter than one", settings: StepActivitySettings{TimeoutSeconds: 300, ScheduleToCloseSeconds: 60},
+			message: "schedule_to_close_seconds cannot be less than timeout_seconds"},
+		{name: "negative", settings: StepActivitySettings{MaxAttempts: -1}, message: "cannot be negative"},
+		{name: "timeout over the limit", settings: StepActivitySettings{ScheduleToCloseSeconds: 7201},
+			message: "timeouts cannot exceed 7200 seconds"},
+		{name: "heartbeat over the limit", settings: StepActivitySettings{HeartbeatSeconds: 61},
+			message: "heartbeat_seconds cannot exceed 60"},
+		{name: "attempts over the limit", settings: StepActivitySettings{MaxAttempts: 6}, message: "max_attempts cannot exceed 5"},
+		{name: "empty error type", settings: StepActivitySettings{NonRetryableErrorTypes: []string{" "}},
+			message: "non_retryable_error_types cannot have empty types"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.Validate([]PlanStep{{ID: "build"}, {ID: "migrate", DependsOn: []string{"build"}, StepActivitySettings: tt.settings}})
+			if tt.message == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var planErr *PlanValidationError
+			require.True(t, errors.As(err, &planErr), "got %v", err)
+			assert.Equal(t, PlanErrorStepSettings, planErr.Kind)
+			assert.Equal(t, "migrate", planErr.StepID)
+			assert.Contains(t, planErr.Message, tt.message)
+			assert.ErrorIs(t, err, ErrInvalidPlan)
+		})
+	}
+
+	// Without configured limits the defaults apply
+	assert.NoError(t, NewPlanValidator(nil).Validate([]PlanStep{{ID: "a", StepActivitySettings: StepActivitySettings{TimeoutSeconds: defaultMaxStepTimeout}}}))
+	assert.ErrorIs(t, NewPlanValidator(nil).Validate([]PlanStep{{ID: "a", StepActivitySettings: StepActivitySettings{MaxAttempts: defaultMaxStepAttempts + 1}}}), ErrInvalidPlan)
+}
+
+func TestWorkflowEngine_ValidatesStepSettingsAtStart(t *testing.T) {
+	_, db := setupTemplateCatalogTest(t)
+	engine := NewWorkflowEngine(db, nil, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+	engine.SetPlanValidator(NewPlanValidator(&config.PlansConfig{MaxSteps: 10, MaxDepth: 10,
+		MaxStepTimeout: 7200, MaxStepHeartbeat: 600, MaxStepAttempts: 5}))
+	ctx := context.Background()
+
+	templateID := "00000000-0000-0000-0000-0000000000b1"
+	createTemplate(t, db, &models.WorkflowTemplate{ID: templateID, Name: "Migrate", Type: models.WorkflowTypeExecution, IsActive: true,
+		Steps: []byte(`[{"id": "lint", "timeout_seconds": 300}, {"id": "migrate", "timeout_seconds": 7200, "heartbeat_seconds": 7200}]`)})
+	err := engine.ValidateWorkflow(ctx, &StartWorkflowRequest{Name: "Migrate", Type: string(models.WorkflowTypeExecution),
+		ProjectID: "project-1", TemplateID: templateID}, nil)
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+	assert.Contains(t, err.Error(), "step migrate: heartbeat_seconds must be less than timeout_seconds")
+
+	custom := func(steps string) *StartWorkflowRequest {
+		return &StartWorkflowRequest{Name: "Custom", Type: string(models.WorkflowTypeCustom), ProjectID: "project-1",
+			Config: []byte(`{"steps": ` + steps + `}`)}
+	}
+	assert.NoError(t, engine.ValidateWorkflow(ctx, custom(`[{"name": "lint", "timeout_seconds": 300, "max_retries": 3}]`), nil))
+	err = engine.ValidateWorkflow(ctx, custom(`[{"name": "lint", "timeout_seconds": 300, "max_retries": 9}]`), nil)
+	assert.ErrorIs(t, err, ErrInvalidPlan, "max_retries counts against the attempts limit")
+	assert.Contains(t, err.Error(), "step lint: max_attempts cannot exceed 5")
+
+	// Invalid step settings fail the start before the workflow is recorded
+	_, err = engine.StartWorkflow(ctx, custom(`[{"name": "migrate", "timeout_seconds": 60, "schedule_to_close_seconds": 30}]`))
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+	var count int64
+	require.NoError(t, db.Model(&models.Workflow{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+// PriorityEscalationsMetadataKey is the key of the workflow metadata list of
+// the escalations of its effective priority, see PriorityEscalation
+const PriorityEscalationsMetadataKey = "priority_escalations"
+
+// PriorityEventEscalated is published on the workflow event channel when a
+// held workflow's effective priority is escalated
+const PriorityEventEscalated = "priority_escalated"
+
+// effectivePriorityColumn is the effective priority of a workflow row; rows
+// stored before escalation have none and use the requested priority
+const effectivePriorityColumn = "COALESCE(NULLIF(effective_priority, ''), priority)"
+
+// effectivePriorityRank orders workflow rows by effective priority as
+// models.WorkflowPriority.Rank does
+const effectivePriorityRank = "CASE " + effectivePriorityColumn +
+	" WHEN 'low' THEN 0 WHEN 'high' THEN 2 WHEN 'critical' THEN 3 ELSE 1 END"
+
+var priorityEscalationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_workflow_priority_escalations_total",
+	Help: "Escalations of the effective priority of held workflows, by escalated priority",
+}, []string{"project_id", "priority"})
+
+// PriorityEscalation is a raise of the effective priority of a workflow that
+// waited in the pending queue
+type PriorityEscalation struct {
+	From        models.WorkflowPriority `json:"from"`
+	To          models.WorkflowPriority `json:"to"`
+	Reason      string                  `json:"reason"`
+	EscalatedAt time.Time               `json:"escalated_at"`
+}
+
+// priorityEscalations returns the metadata of a workflow and the escalations
+// recorded in it
+func priorityEscalations(workflow *models.Workflow) (map[string]json.RawMessage, []PriorityEscalation, error) {
+	metadata := make(map[string]json.RawMessage)
+	if len(workflow.Metadata) > 0 {
+		if err := json.Unmarshal(workflow.Metadata, &metadata); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse workflow metadata: %w", err)
+		}
+	}
+	var escalations []PriorityEscalation
+	if raw, ok := metadata[PriorityEscalationsMetadataKey]; ok {
+		if err := json.Unmarshal(raw, &escalations); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse priority escalations: %w", err)
+		}
+	}
+	return metadata, escalations, nil
+}
+
+// waitingSince is when a held workflow started waiting at its effective
+// priority: its last escalation, else its submission or creation
+func waitingSince(workflow *models.Workflow, escalations []PriorityEscalation) time.Time {
+	if len(escalations) > 0 {
+		return escalations[len(escalations)-1].EscalatedAt
+	}
+	if workflow.SubmittedAt != nil {
+		return *workflow.SubmittedAt
+	}
+	return workflow.CreatedAt
+}
+
+// escalate raises the effective priority of the held workflows that waited
+// the escalation threshold at their effective priority by one level
+func (s *FairShareScheduler) escalate(ctx context.Context) {
+	cfg := s.config()
+	if cfg.EscalationThreshold <= 0 {
+		return
+	}
+	threshold := time.Duration(cfg.EscalationThreshold) * time.Second
+
+	var workflows []models.Workflow
+	err := s.db.WithContext(ctx).
+		Where("status = ? AND scheduling_reason <> '' AND "+effectivePriorityColumn+" <> ?",
+			models.WorkflowStatusPending, models.WorkflowPriorityCritical).
+		Find(&workflows).Error
+	if err != nil {
+		s.logger.Error("Failed to get held workflows to escalate", zap.Error(err))
+		return
+	}
+	now := s.now()
+	for i := range workflows {
+		s.escalateWorkflow(ctx, &workflows[i], threshold, now)
+	}
+}
+
+// escalateWorkflow raises the effective priority of a held workflow that
+// waited the threshold at it
+func (s *FairShareScheduler) escalateWorkflow(ctx context.Context, workflow *models.Workflow, threshold time.Duration, now time.Time) {
+	metadata, escalations, err := priorityEscalations(workflow)
+	if err != nil {
+		s.logger.Warn("Not escalating workflow with unreadable metadata",
+			zap.String("workflow_id", workflow.ID),
+			zap.Error(err))
+		return
+	}
+	waited := now.Sub(waitingSince(workflow, escalations))
+	if waited < threshold {
+		return
+	}
+
+	from := workflow.EffectivePriority
+	escalation := PriorityEscalation{
+		From:        from,
+		To:          from.Next(),
+		Reason:      fmt.Sprintf("held for %s: %s", waited.Round(time.Second), workflow.SchedulingReason),
+		EscalatedAt: now,
+	}
+	trail, err := json.Marshal(append(escalations, escalation))
+	if err != nil {
+		s.logger.Error("Failed to marshal priority escalations", zap.Error(err))
+		return
+	}
+	metadata[PriorityEscalationsMetadataKey] = trail
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		s.logger.Error("Failed to marshal workflow metadata", zap.Error(err))
+		return
+	}
+
+	// Conditional on the loaded priority so each escalation happens once,
+	// even with several replicas
+	result := s.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND status = ? AND scheduling_reason <> '' AND "+effectivePriorityColumn+" = ?",
+			workflow.ID, models.WorkflowStatusPending, from).
+		Updates(map[string]interface{}{"effective_priority": escalation.To, "metadata": data})
+	if result.Error != nil {
+		s.logger.Error("Failed to escalate workflow priority",
+			zap.String("workflow_id", workflow.ID),
+			zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	workflow.EffectivePriority = escalation.To
+	workflow.Metadata = data
+	s.engine.dropCachedWorkflow(ctx, workflow.ID)
+
+	priorityEscalationsTotal.WithLabelValues(workflow.ProjectID, string(escalation.To)).Inc()
+	s.events(ctx, workflow, PriorityEventEscalated, map[string]interface{}{
+		"requested_priority": workflow.Priority,
+		"from":               escalation.From,
+		"to":                 escalation.To,
+		"reason":             escalation.Reason,
+		"waited_seconds":     int64(waited / time.Second),
+	})
+	s.logger.Info("Escalated held workflow priority",
+		zap.String("workflow_id", workflow.ID),
+		zap.String("project_id", workflow.ProjectID),
+		zap.String("from", string(escalation.From)),
+		zap.String("to", string(escalation.To)),
+		zap.Duration("waited", waited))
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+func startPriorityWorkflow(t *testing.T, engine *WorkflowEngine, projectID string, priority models.WorkflowPriority) *StartWorkflowResponse {
+	resp, err := engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+		Name:      "build",
+		Type:      string(models.WorkflowTypeTaskExecution),
+		Priority:  string(priority),
+		ProjectID: projectID,
+		UserID:    "alice",
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+// enableEscalation escalates after threshold on a fake clock, returning the
+// clock and the escalation events by workflow
+func enableEscalation(scheduler *FairShareScheduler, threshold int) (*time.Time, map[string][]map[string]interface{}) {
+	cfg := scheduler.config()
+	cfg.EscalationThreshold = threshold
+	scheduler.Configure(&cfg)
+
+	now := time.Now()
+	scheduler.now = func() time.Time { return now }
+	events := make(map[string][]map[string]interface{})
+	scheduler.events = func(ctx context.Context, workflow *models.Workflow, eventType string, data map[string]interface{}) {
+		if eventType == PriorityEventEscalated {
+			events[workflow.ID] = append(events[workflow.ID], data)
+		}
+	}
+	return &now, events
+}
+
+func getWorkflow(t *testing.T, db *gorm.DB, id string) *models.Workflow {
+	var workflow models.Workflow
+	require.NoError(t, db.First(&workflow, "id = ?", id).Error)
+	return &workflow
+}
+
+func TestFairShareScheduler_EscalatesAgingWorkflows(t *testing.T) {
+	ctx := context.Background()
+	engine, scheduler, db, _ := setupFairShareTest(t, 1, nil)
+	startPriorityWorkflow(t, engine, "project-a", models.WorkflowPriorityMedium)
+	held := startPriorityWorkflow(t, engine, "project-b", models.WorkflowPriorityLow)
+	require.NotEmpty(t, held.SchedulingReason)
+	now, events := enableEscalation(scheduler, 600)
+	start := *now
+
+	// Not before the threshold
+	*now = start.Add(599 * time.Second)
+	scheduler.escalate(ctx)
+	assert.Equal(t, models.WorkflowPriorityLow, getWorkflow(t, db, held.WorkflowID).EffectivePriority)
+	assert.Empty(t, events)
+
+	// One level per threshold waited, each counted from the last escalation
+	steps := []struct {
+		after    time.Duration
+		expected models.WorkflowPriority
+	}{
+		{600 * time.Second, models.WorkflowPriorityMedium},
+		{900 * time.Second, models.WorkflowPriorityMedium},
+		{1200 * time.Second, models.WorkflowPriorityHigh},
+		{1800 * time.Second, models.WorkflowPriorityCritical},
+		{3600 * time.Second, models.WorkflowPriorityCritical},
+	}
+	for _, step := range steps {
+		*now = start.Add(step.after)
+		scheduler.escalate(ctx)
+		workflow := getWorkflow(t, db, held.WorkflowID)
+		assert.Equal(t, step.expected, workflow.EffectivePriority, "after %s", step.after)
+		assert.Equal(t, models.WorkflowPriorityLow, workflow.Priority, "after %s", step.after)
+	}
+
+	// The trail of escalations is in the metadata
+	workflow := getWorkflow(t, db, held.WorkflowID)
+	_, escalations, err := priorityEscalations(workflow)
+	require.NoError(t, err)
+	require.Len(t, escalations, 3)
+	expected := []struct {
+		from, to models.WorkflowPriority
+		at       time.Duration
+	}{
+		{models.WorkflowPriorityLow, models.WorkflowPriorityMedium, 600 * time.Second},
+		{models.WorkflowPriorityMedium, models.WorkflowPriorityHigh, 1200 * time.Second},
+		{models.WorkflowPriorityHigh, models.WorkflowPriorityCritical, 1800 * time.Second},
+	}
+	for i, escalation := range escalations {
+		assert.Equal(t, expected[i].from, escalation.From)
+		assert.Equal(t, expected[i].to, escalation.To)
+		assert.True(t, start.Add(expected[i].at).Equal(escalation.EscalatedAt), "escalation %d at %s", i, escalation.EscalatedAt)
+		assert.Contains(t, escalation.Reason, "capacity")
+	}
+	assert.Contains(t, escalations[0].Reason, "held for 10m0s")
+
+	// Each escalation published one event
+	require.Len(t, events[held.WorkflowID], 3)
+	assert.Equal(t, models.WorkflowPriorityCritical, events[held.WorkflowID][2]["to"])
+	assert.Equal(t, models.WorkflowPriorityLow, events[held.WorkflowID][2]["requested_priority"])
+
+	// Lists show both priorities
+	summaries, _, err := engine.ListWorkflowSummaries(ctx, &WorkflowFilters{ProjectID: "project-b"})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, models.WorkflowPriorityLow, summaries[0].Priority)
+	assert.Equal(t, models.WorkflowPriorityCritical, summaries[0].EffectivePriority)
+	data, err := json.Marshal(summaries[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"effective_priority":"critical"`)
+}
+
+func TestFairShareScheduler_EscalationDisabled(t *testing.T) {
+	ctx := context.Background()
+	engine, scheduler, db, _ := setupFairShareTest(t, 1, nil)
+	startPriorityWorkflow(t, engine, "project-a", models.WorkflowPriorityMedium)
+	held := startPriorityWorkflow(t, engine, "project-b", models.WorkflowPriorityLow)
+	now, events := enableEscalation(scheduler, 0)
+
+	*now = now.Add(24 * time.Hour)
+	scheduler.escalate(ctx)
+	assert.Equal(t, models.WorkflowPriorityLow, getWorkflow(t, db, held.WorkflowID).EffectivePriority)
+	assert.Empty(t, events)
+}
+
+func TestFairShareScheduler_DispatchesByEffectivePriority(t *testing.T) {
+	ctx := context.Background()
+	engine, scheduler, db, started := setupFairShareTest(t, 2, nil)
+	first := startPriorityWorkflow(t, engine, "project-a", models.WorkflowPriorityMedium)
+	second := startPriorityWorkflow(t, engine, "project-a", models.WorkflowPriorityMedium)
+	older := startPriorityWorkflow(t, engine, "project-a", models.WorkflowPriorityMedium)
+	aged := startPriorityWorkflow(t, engine, "project-a", models.WorkflowPriorityMedium)
+	other := startPriorityWorkflow(t, engine, "project-b", models.WorkflowPriorityMedium)
+	now, _ := enableEscalation(scheduler, 600)
+
+	// Only the aged workflow has waited long enough to escalate
+	require.NoError(t, db.Model(&models.Workflow{}).Where("id = ?", aged.WorkflowID).
+		Update("created_at", now.Add(-15*time.Minute)).Error)
+	scheduler.escalate(ctx)
+	assert.Equal(t, models.WorkflowPriorityHigh, getWorkflow(t, db, aged.WorkflowID).EffectivePriority)
+	assert.Equal(t, models.WorkflowPriorityMedium, getWorkflow(t, db, older.WorkflowID).EffectivePriority)
+
+	// The escalated workflow starts first, ahead of the older workflow of
+	// its project and of the project below its share
+	completeWorkflows(t, db, []string{first.WorkflowID})
+	scheduler.dispatch(ctx)
+	assert.Equal(t, models.WorkflowStatusRunning, getWorkflow(t, db, aged.WorkflowID).Status)
+	assert.Equal(t, map[string]int{"project-a": 3}, started)
+
+	// At equal priorities fair share applies again
+	completeWorkflows(t, db, []string{second.WorkflowID})
+	scheduler.dispatch(ctx)
+	assert.Equal(t, models.WorkflowStatusRunning, getWorkflow(t, db, other.WorkflowID).Status)
+	assert.Equal(t, models.WorkflowStatusPending, getWorkflow(t, db, older.WorkflowID).Status)
+	assert.Equal(t, map[string]int{"project-a": 3, "project-b": 1}, started)
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// maxPendingActivity is the number of distinct projects buffered before the
+// tracker flushes early
+const maxPendingActivity = 10000
+
+// ProjectActivityTracker debounces project last_activity_at updates. Touches
+// are buffered in memory and written by one background flusher, at most once
+// per project per interval, so read traffic does not turn into writes on the
+// projects table.
+type ProjectActivityTracker struct {
+	db       *gorm.DB
+	logger   *zap.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+
+	flushNow chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewProjectActivityTracker creates a tracker that flushes every interval
+func NewProjectActivityTracker(db *gorm.DB, logger *zap.Logger, interval time.Duration) *ProjectActivityTracker {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &ProjectActivityTracker{
+		db:       db,
+		logger:   logger,
+		interval: interval,
+		now:      time.Now,
+		pending:  make(map[string]time.Time),
+		flushNow: make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start starts the background flusher
+func (t *ProjectActivityTracker) Start() {
+	t.wg.Add(1)
+	go t.run()
+	t.logger.Info("Project activity tracker started", zap.Duration("interval", t.interval))
+}
+
+// Stop stops the flusher and writes the touches still pending
+func (t *ProjectActivityTracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stopChan)
+		t.wg.Wait()
+		if err := t.flush(context.Background()); err != nil {
+			t.logger.Error("Failed to flush project activity on shutdown", zap.Error(err))
+		}
+		t.logger.Info("Project activity tracker stopped")
+	})
+}
+
+// Touch records activity on a project. It never blocks on the database and
+// is a no-op on a nil tracker.
+func (t *ProjectActivityTracker) Touch(projectID string) {
+	if t == nil || projectID == "" {
+		return
+	}
+
+	t.mu.Lock()
+	t.pending[projectID] = t.now()
+	full := len(t.pending) >= maxPendingActivity
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run flushes pending touches every interval, or early when the buffer fills
+func (t *ProjectActivityTracker) run() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.flushNow:
+		case <-t.stopChan:
+			return
+		}
+		if err := t.flush(context.Background()); err != nil {
+			t.logger.Error("Failed to flush project activity", zap.Error(err))
+		}
+	}
+}
+
+// flush writes the pending touches in one transaction. Touches that fail to
+// write are kept for the next flush unless a newer one replaced them.
+func (t *ProjectActivityTracker) flush(ctx context.Context) error {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make(map[string]time.Time, len(batch))
+	t.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := t.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for projectID, at := range batch {
+			// UpdateColumn leaves updated_at alone; the guard keeps the
+			// timestamp from moving backwards across replicas
+			if err := tx.Model(&models.Project{}).
+				Where("id = ? AND (last_activity_at IS NULL OR last_activity_at < ?)", projectID, at).
+				UpdateColumn("last_activity_at", at).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.mu.Lock()
+		for projectID, at := range batch {
+			if _, ok := t.pending[projectID]; !ok {
+				t.pending[projectID] = at
+			}
+		}
+		t.mu.Unlock()
+		return fmt.Errorf("failed to update project activity: %w", err)
+	}
+	return nil
+}
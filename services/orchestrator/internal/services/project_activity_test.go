@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+)
+
+// setupProjectActivityTest creates the project tables and counts the
+// UPDATE statements run against projects
+func setupProjectActivityTest(t *testing.T) (*gorm.DB, *atomic.Int32) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	// One connection so every statement sees the same in-memory database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, name TEXT, status TEXT, owner_id TEXT, last_activity_at DATETIME,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE project_members (id TEXT PRIMARY KEY, project_id TEXT, user_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE environments (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE resources (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE integrations (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, name, status, owner_id) VALUES ('project-1', 'one', 'active', 'alice'),
+			('project-2', 'two', 'active', 'bob')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	var updates atomic.Int32
+	require.NoError(t, db.Callback().Update().After("gorm:update").Register("test:count_updates", func(tx *gorm.DB) {
+		if tx.Statement.Table == "projects" {
+			updates.Add(1)
+		}
+	}))
+	return db, &updates
+}
+
+func lastActivity(t *testing.T, db *gorm.DB, projectID string) *time.Time {
+	var project models.Project
+	require.NoError(t, db.Select("id", "last_activity_at").First(&project, "id = ?", projectID).Error)
+	return project.LastActivityAt
+}
+
+func TestProjectActivityTracker_DebouncesReads(t *testing.T) {
+	db, updates := setupProjectActivityTest(t)
+	tracker := NewProjectActivityTracker(db, zap.NewNop(), time.Hour)
+	service := NewProjectService(db, zap.NewNop())
+	service.SetActivityTracker(tracker)
+
+	for i := 0; i < 50; i++ {
+		_, err := service.GetProject(context.Background(), "project-1")
+		require.NoError(t, err)
+	}
+	assert.Zero(t, updates.Load(), "reads do not write")
+	assert.Nil(t, lastActivity(t, db, "project-1"))
+
+	require.NoError(t, tracker.flush(context.Background()))
+	assert.EqualValues(t, 1, updates.Load())
+	assert.NotNil(t, lastActivity(t, db, "project-1"))
+	assert.Nil(t, lastActivity(t, db, "project-2"))
+
+	// Nothing pending, nothing written
+	require.NoError(t, tracker.flush(context.Background()))
+	assert.EqualValues(t, 1, updates.Load())
+}
+
+func TestProjectActivityTracker_FlushesOnStop(t *testing.T) {
+	db, updates := setupProjectActivityTest(t)
+	tracker := NewProjectActivityTracker(db, zap.NewNop(), time.Hour)
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return at }
+	tracker.Start()
+
+	tracker.Touch("project-1")
+	tracker.Touch("project-2")
+	tracker.Stop()
+	tracker.Stop()
+
+	assert.EqualValues(t, 2, updates.Load())
+	for _, projectID := range []string{"project-1", "project-2"} {
+		stored := lastActivity(t, db, projectID)
+		require.NotNil(t, stored)
+		assert.True(t, at.Equal(*stored))
+	}
+
+	// Older touches from another replica do not move the timestamp back
+	tracker.pending["project-1"] = at.Add(-time.Hour)
+	require.NoError(t, tracker.flush(context.Background()))
+	assert.True(t, at.Equal(*lastActivity(t, db, "project-1")))
+}
+
+func TestProjectActivityTracker_NoGoroutinePerTouch(t *testing.T) {
+	db, updates := setupProjectActivityTest(t)
+	tracker := NewProjectActivityTracker(db, zap.NewNop(), 20*time.Millisecond)
+	tracker.Start()
+	defer tracker.Stop()
+
+	before := runtime.NumGoroutine()
+	peak := atomic.Int32{}
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				tracker.Touch(fmt.Sprintf("project-%d", i%2+1))
+				if n := int32(runtime.NumGoroutine()); n > peak.Load() {
+					peak.Store(n)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The workers themselves plus a little scheduler noise
+	assert.LessOrEqual(t, int(peak.Load()), before+8+2)
+	assert.Eventually(t, func() bool {
+		var count int64
+		db.Model(&models.Project{}).Where("last_activity_at IS NOT NULL").Count(&count)
+		return count == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Less(t, int(updates.Load()), 16000/10, "touches are batched")
+}
+
+func TestProjectActivityTracker_NilIsNoop(t *testing.T) {
+	var tracker *ProjectActivityTracker
+	assert.NotPanics(t, func() { tracker.Touch("project-1") })
+}
@@ -3,18 +3,30 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"orchestrator/internal/config"
+	"orchestrator/internal/errorsig"
 	"orchestrator/internal/models"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
+// ErrProjectNotFound is returned for operations on unknown projects
+var ErrProjectNotFound = errors.New("project not found")
+
 // ProjectService handles project management
 type ProjectService struct {
-	db     *gorm.DB
-	logger *zap.Logger
+	db              *gorm.DB
+	logger          *zap.Logger
+	activity        *ProjectActivityTracker
+	errorNormalizer *errorsig.Normalizer
+	storage         *StorageAccountant
+	statsCache      ProjectStatsCache
+	statsCacheTTL   time.Duration
+	statsBatchLimit int
 }
 
 // NewProjectService creates a new project service
@@ -25,8 +37,36 @@ func NewProjectService(db *gorm.DB, logger *zap.Logger) *ProjectService {
 	}
 }
 
+// SetActivityTracker makes project reads and changes record activity through
+// the tracker
+func (s *ProjectService) SetActivityTracker(tracker *ProjectActivityTracker) {
+	s.activity = tracker
+}
+
+// SetStorageAccountant makes project stats and storage reads use the
+// accountant and its cache
+func (s *ProjectService) SetStorageAccountant(storage *StorageAccountant) {
+	s.storage = storage
+}
+
+// SetStatsCache makes batch stats reads serve and store stats in the cache
+// for ttl
+func (s *ProjectService) SetStatsCache(cache ProjectStatsCache, ttl time.Duration) {
+	s.statsCache = cache
+	s.statsCacheTTL = ttl
+}
+
+// SetStatsBatchLimit sets the number of projects whose stats are read at once
+func (s *ProjectService) SetStatsBatchLimit(limit int) {
+	s.statsBatchLimit = limit
+}
+
 // CreateProject creates a new project
 func (s *ProjectService) CreateProject(ctx context.Context, req *CreateProjectRequest) (*models.Project, error) {
+	if err := validateProjectSettings(req.Settings); err != nil {
+		return nil, err
+	}
+
 	project := &models.Project{
 		Name:           req.Name,
 		Description:    req.Description,
@@ -54,6 +94,7 @@ func (s *ProjectService) CreateProject(ctx context.Context, req *CreateProjectRe
 		s.logger.Error("failed to create project", zap.Error(err))
 		return nil, fmt.Errorf("failed to create project: %w", err)
 	}
+	s.auditSandboxPolicyChange(ctx, project.ID, req.OwnerID, nil, req.Settings)
 
 	// Create default environment
 	defaultEnv := &models.Environment{
@@ -110,8 +151,7 @@ func (s *ProjectService) GetProject(ctx context.Context, projectID string) (*mod
 		return nil, fmt.Errorf("failed to get project: %w", err)
 	}
 
-	// Update last activity
-	go s.updateLastActivity(projectID)
+	s.activity.Touch(projectID)
 
 	return &project, nil
 }
@@ -119,6 +159,9 @@ func (s *ProjectService) GetProject(ctx context.Context, projectID string) (*mod
 // ListProjects lists projects with filters
 func (s *ProjectService) ListProjects(ctx context.Context, filters *ProjectFilters) ([]*models.Project, int64, error) {
 	query := s.db.WithContext(ctx).Model(&models.Project{})
+	if filters.IncludeDeleted {
+		query = query.Unscoped()
+	}
 
 	// Apply filters
 	if filters.Status != "" {
@@ -199,6 +242,9 @@ func (s *ProjectService) UpdateProject(ctx context.Context, projectID string, re
 		updates["status"] = req.Status
 	}
 	if req.Settings != nil {
+		if err := validateProjectSettings(req.Settings); err != nil {
+			return nil, err
+		}
 		updates["settings"] = req.Settings
 	}
 	if req.Tags != nil {
@@ -206,9 +252,13 @@ func (s *ProjectService) UpdateProject(ctx context.Context, projectID string, re
 	}
 
 	// Update project
+	before := project.Settings
 	if err := s.db.WithContext(ctx).Model(&project).Updates(updates).Error; err != nil {
 		return nil, fmt.Errorf("failed to update project: %w", err)
 	}
+	if req.Settings != nil {
+		s.auditSandboxPolicyChange(ctx, projectID, req.UpdatedBy, before, req.Settings)
+	}
 
 	// Reload project with associations
 	if err := s.db.WithContext(ctx).
@@ -218,6 +268,7 @@ func (s *ProjectService) UpdateProject(ctx context.Context, projectID string, re
 		return nil, fmt.Errorf("failed to reload project: %w", err)
 	}
 
+	s.activity.Touch(projectID)
 	s.logger.Info("project updated",
 		zap.String("project_id", projectID),
 		zap.String("updated_by", req.UpdatedBy),
@@ -253,16 +304,16 @@ func (s *ProjectService) GetProjectStats(ctx context.Context, projectID string)
 		CalculatedAt: time.Now(),
 	}
 
-	// Count workflows
-	s.db.WithContext(ctx).Model(&models.Workflow{}).Where("project_id = ?", projectID).Count(&stats.TotalWorkflows)
-	s.db.WithContext(ctx).Model(&models.Workflow{}).Where("project_id = ? AND status IN ?", projectID, 
+	// Count workflows; simulated runs are excluded
+	s.db.WithContext(ctx).Model(&models.Workflow{}).Where("project_id = ? AND NOT simulated", projectID).Count(&stats.TotalWorkflows)
+	s.db.WithContext(ctx).Model(&models.Workflow{}).Where("project_id = ? AND NOT simulated AND status IN ?", projectID, 
 		[]string{"running", "pending"}).Count(&stats.ActiveWorkflows)
 
-	// Count executions
-	s.db.WithContext(ctx).Model(&models.Execution{}).Where("project_id = ?", projectID).Count(&stats.TotalExecutions)
-	s.db.WithContext(ctx).Model(&models.Execution{}).Where("project_id = ? AND status = ?", projectID, 
+	// Count executions; simulated runs are excluded
+	s.db.WithContext(ctx).Model(&models.Execution{}).Where("project_id = ? AND NOT simulated", projectID).Count(&stats.TotalExecutions)
+	s.db.WithContext(ctx).Model(&models.Execution{}).Where("project_id = ? AND NOT simulated AND status = ?", projectID, 
 		"succeeded").Count(&stats.SuccessfulExecutions)
-	s.db.WithContext(ctx).Model(&models.Execution{}).Where("project_id = ? AND status = ?", projectID, 
+	s.db.WithContext(ctx).Model(&models.Execution{}).Where("project_id = ? AND NOT simulated AND status = ?", projectID, 
 		"failed").Count(&stats.FailedExecutions)
 
 	// Count members
@@ -280,9 +331,27 @@ func (s *ProjectService) GetProjectStats(ctx context.Context, projectID string)
 		Pluck("last_activity_at", &lastActivity)
 	stats.LastActivityAt = lastActivity
 
+	// Storage used by artifacts, against the quota
+	if usage, err := s.GetProjectStorage(ctx, projectID); err != nil {
+		s.logger.Warn("failed to get project storage usage", zap.String("project_id", projectID), zap.Error(err))
+	} else {
+		stats.StorageUsed = usage.UsedBytes
+		stats.StorageQuota = usage.QuotaBytes
+	}
+
 	return stats, nil
 }
 
+// GetProjectStorage returns the artifact storage used by a project, by
+// artifact type, and its storage quota
+func (s *ProjectService) GetProjectStorage(ctx context.Context, projectID string) (*StorageUsage, error) {
+	storage := s.storage
+	if storage == nil {
+		storage = NewStorageAccountant(s.db, nil, &config.StorageConfig{}, s.logger)
+	}
+	return storage.GetUsage(ctx, projectID)
+}
+
 // AddProjectMember adds a member to a project
 func (s *ProjectService) AddProjectMember(ctx context.Context, projectID string, req *AddProjectMemberRequest) (*models.ProjectMember, error) {
 	// Check if project exists
@@ -321,6 +390,7 @@ func (s *ProjectService) AddProjectMember(ctx context.Context, projectID string,
 		return nil, fmt.Errorf("failed to add project member: %w", err)
 	}
 
+	s.activity.Touch(projectID)
 	s.logger.Info("project member added",
 		zap.String("project_id", projectID),
 		zap.String("user_id", req.UserID),
@@ -343,6 +413,7 @@ func (s *ProjectService) RemoveProjectMember(ctx context.Context, projectID, use
 		return fmt.Errorf("member not found")
 	}
 
+	s.activity.Touch(projectID)
 	s.logger.Info("project member removed",
 		zap.String("project_id", projectID),
 		zap.String("user_id", userID),
@@ -351,13 +422,6 @@ func (s *ProjectService) RemoveProjectMember(ctx context.Context, projectID, use
 	return nil
 }
 
-// updateLastActivity updates the project's last activity timestamp
-func (s *ProjectService) updateLastActivity(projectID string) {
-	now := time.Now()
-	s.db.Model(&models.Project{}).Where("id = ?", projectID).
-		Update("last_activity_at", now)
-}
-
 // Request types
 
 type CreateProjectRequest struct {
@@ -389,6 +453,10 @@ type ProjectFilters struct {
 	SortDesc       bool
 	Limit          int
 	Offset         int
+
+	// IncludeDeleted lists soft-deleted projects too; only admin endpoints
+	// set it
+	IncludeDeleted bool
 }
 
 type AddProjectMemberRequest struct {
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/models"
+)
+
+// DefaultProjectStatsBatchLimit is the number of projects whose stats are
+// read at once when no limit is configured
+const DefaultProjectStatsBatchLimit = 100
+
+// ErrInvalidProjectStatsBatch is returned for batches without project IDs or
+// with more than the limit
+var ErrInvalidProjectStatsBatch = errors.New("invalid project stats batch")
+
+// ProjectViewer is the caller whose projects a batch may read: projects they
+// own or are a member of, or every project with All
+type ProjectViewer struct {
+	UserID string
+	All    bool
+}
+
+// ProjectStatsResult is the stats of one project of a batch, or why they
+// could not be computed
+type ProjectStatsResult struct {
+	ProjectID string               `json:"project_id"`
+	Stats     *models.ProjectStats `json:"stats,omitempty"`
+	Cached    bool                 `json:"cached,omitempty"` // Served from the stats cache
+	Error     string               `json:"error,omitempty"`
+}
+
+// ProjectStatsCache caches computed project stats, in Redis in production
+type ProjectStatsCache interface {
+	// GetMany returns the stats cached for the projects, by project ID
+	GetMany(ctx context.Context, projectIDs []string) (map[string]*models.ProjectStats, error)
+	SetMany(ctx context.Context, stats []*models.ProjectStats, ttl time.Duration) error
+}
+
+const projectStatsKeyPrefix = "project_stats:"
+
+// redisProjectStatsCache caches stats in Redis. While Redis is unreachable
+// every read is a miss and writes are skipped.
+type redisProjectStatsCache struct {
+	client *redis.Client
+}
+
+// NewRedisProjectStatsCache caches project stats in Redis
+func NewRedisProjectStatsCache(client *redis.Client) ProjectStatsCache {
+	return &redisProjectStatsCache{client: client}
+}
+
+func (r *redisProjectStatsCache) GetMany(ctx context.Context, projectIDs []string) (map[string]*models.ProjectStats, error) {
+	cached := make(map[string]*models.ProjectStats)
+	if r.client == nil || len(projectIDs) == 0 {
+		return cached, nil
+	}
+	keys := make([]string, len(projectIDs))
+	for i, projectID := range projectIDs {
+		keys[i] = projectStatsKeyPrefix + projectID
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		if IsRedisOutage(err) {
+			return cached, nil
+		}
+		return nil, err
+	}
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var stats models.ProjectStats
+		if err := json.Unmarshal([]byte(data), &stats); err != nil {
+			return nil, err
+		}
+		cached[projectIDs[i]] = &stats
+	}
+	return cached, nil
+}
+
+func (r *redisProjectStatsCache) SetMany(ctx context.Context, stats []*models.ProjectStats, ttl time.Duration) error {
+	if r.client == nil || len(stats) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	for _, projectStats := range stats {
+		data, err := json.Marshal(projectStats)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, projectStatsKeyPrefix+projectStats.ProjectID, data, ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !IsRedisOutage(err) {
+		return err
+	}
+	return nil
+}
+
+// NormalizeProjectStatsBatch returns the distinct project IDs of a batch in
+// request order, or ErrInvalidProjectStatsBatch when there are none or more
+// than limit
+func NormalizeProjectStatsBatch(projectIDs []string, limit int) ([]string, error) {
+	if limit <= 0 {
+		limit = DefaultProjectStatsBatchLimit
+	}
+	seen := make(map[string]bool, len(projectIDs))
+	ids := make([]string, 0, len(projectIDs))
+	for _, projectID := range projectIDs {
+		projectID = strings.TrimSpace(projectID)
+		if projectID == "" || seen[projectID] {
+			continue
+		}
+		seen[projectID] = true
+		ids = append(ids, projectID)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: project_ids must not be empty", ErrInvalidProjectStatsBatch)
+	}
+	if len(ids) > limit {
+		return nil, fmt.Errorf("%w: at most %d project IDs, got %d", ErrInvalidProjectStatsBatch, limit, len(ids))
+	}
+	return ids, nil
+}
+
+// projectStatsRow is one project's row of a grouped stats query
+type projectStatsRow struct {
+	ProjectID string
+	Total     int64
+	Active    int64
+	Succeeded int64
+	Failed    int64
+}
+
+// projectStatsFamily is a group of stats read for every project of a batch
+// with one query grouped by project
+type projectStatsFamily struct {
+	name  string
+	query func(tx *gorm.DB) *gorm.DB
+	apply func(stats *models.ProjectStats, row *projectStatsRow)
+}
+
+// projectStatsFamilies mirror the counts of GetProjectStats
+var projectStatsFamilies = []projectStatsFamily{
+	{
+		name: "workflows",
+		query: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&models.Workflow{}).
+				Select("project_id, COUNT(*) AS total, SUM(CASE WHEN status IN ('running', 'pending') THEN 1 ELSE 0 END) AS active").
+				Where("NOT simulated")
+		},
+		apply: func(stats *models.ProjectStats, row *projectStatsRow) {
+			stats.TotalWorkflows, stats.ActiveWorkflows = row.Total, row.Active
+		},
+	},
+	{
+		name: "executions",
+		query: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&models.Execution{}).
+				Select(`project_id, COUNT(*) AS total, SUM(CASE WHEN status = 'succeeded' THEN 1 ELSE 0 END) AS succeeded,
+					SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) AS failed`).
+				Where("NOT simulated")
+		},
+		apply: func(stats *models.ProjectStats, row *projectStatsRow) {
+			stats.TotalExecutions, stats.SuccessfulExecutions, stats.FailedExecutions = row.Total, row.Succeeded, row.Failed
+		},
+	},
+	{
+		name: "members",
+		query: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&models.ProjectMember{}).Select("project_id, COUNT(*) AS total")
+		},
+		apply: func(stats *models.ProjectStats, row *projectStatsRow) { stats.TotalMembers = row.Total },
+	},
+	{
+		name: "resources",
+		query: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&models.Resource{}).Select("project_id, COUNT(*) AS total")
+		},
+		apply: func(stats *models.ProjectStats, row *projectStatsRow) { stats.TotalResources = row.Total },
+	},
+	{
+		name: "integrations",
+		query: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&models.Integration{}).Select("project_id, COUNT(*) AS total")
+		},
+		apply: func(stats *models.ProjectStats, row *projectStatsRow) { stats.TotalIntegrations = row.Total },
+	},
+	{
+		name: "storage",
+		query: func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&models.ProjectStorageUsage{}).Select("project_id, SUM(bytes) AS total")
+		},
+		apply: func(stats *models.ProjectStats, row *projectStatsRow) { stats.StorageUsed = row.Total },
+	},
+}
+
+// GetProjectStatsBatch returns the stats of several projects at once, in
+// request order. Projects the viewer cannot read, and unknown ones, are left
+// out. Fresh stats come from the stats cache; the others are computed with
+// one grouped query per family of stats across all of them. A family that
+// fails leaves an error entry for each project it was needed for instead of
+// failing the batch.
+func (s *ProjectService) GetProjectStatsBatch(ctx context.Context, projectIDs []string, viewer ProjectViewer) ([]ProjectStatsResult, error) {
+	ids, err := NormalizeProjectStatsBatch(projectIDs, s.statsBatchLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := s.readableProjects(ctx, ids, viewer)
+	if err != nil {
+		return nil, err
+	}
+	readable := make([]string, 0, len(projects))
+	for _, projectID := range ids {
+		if _, ok := projects[projectID]; ok {
+			readable = append(readable, projectID)
+		}
+	}
+
+	cached := make(map[string]*models.ProjectStats)
+	if s.statsCache != nil && s.statsCacheTTL > 0 {
+		if cached, err = s.statsCache.GetMany(ctx, readable); err != nil {
+			s.logger.Warn("Failed to read cached project stats", zap.Error(err))
+			cached = make(map[string]*models.ProjectStats)
+		}
+	}
+
+	missing := make([]string, 0, len(readable))
+	for _, projectID := range readable {
+		if cached[projectID] == nil {
+			missing = append(missing, projectID)
+		}
+	}
+	// The grouped queries are not run for a caller no longer waiting
+	if len(missing) > 0 {
+		if err := deadline.Check(ctx, "computing project stats"); err != nil {
+			return nil, err
+		}
+	}
+	computed, failures := s.computeProjectStats(ctx, missing, projects)
+
+	results := make([]ProjectStatsResult, 0, len(readable))
+	fresh := make([]*models.ProjectStats, 0, len(computed))
+	for _, projectID := range readable {
+		result := ProjectStatsResult{ProjectID: projectID}
+		switch {
+		case cached[projectID] != nil:
+			result.Stats, result.Cached = cached[projectID], true
+		case len(failures[projectID]) > 0:
+			result.Error = strings.Join(failures[projectID], "; ")
+		default:
+			result.Stats = computed[projectID]
+			fresh = append(fresh, result.Stats)
+		}
+		results = append(results, result)
+	}
+
+	if s.statsCache != nil && s.statsCacheTTL > 0 && len(fresh) > 0 {
+		if err := s.statsCache.SetMany(ctx, fresh, s.statsCacheTTL); err != nil {
+			s.logger.Warn("Failed to cache project stats", zap.Error(err))
+		}
+	}
+	return results, nil
+}
+
+// readableProjects returns, by ID, the projects of ids the viewer can read
+func (s *ProjectService) readableProjects(ctx context.Context, ids []string, viewer ProjectViewer) (map[string]*models.Project, error) {
+	query := s.db.WithContext(ctx).Model(&models.Project{}).
+		Select("id", "last_activity_at", "resource_limits").Where("id IN ?", ids)
+	if !viewer.All {
+		query = query.Where(`owner_id = ? OR id IN (SELECT project_id FROM project_members
+			WHERE user_id = ? AND deleted_at IS NULL)`, viewer.UserID, viewer.UserID)
+	}
+	var rows []*models.Project
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get projects: %w", err)
+	}
+	projects := make(map[string]*models.Project, len(rows))
+	for _, project := range rows {
+		projects[project.ID] = project
+	}
+	return projects, nil
+}
+
+// computeProjectStats computes the stats of the projects with one query per
+// family. failures holds, by project, the families that could not be read.
+func (s *ProjectService) computeProjectStats(ctx context.Context, ids []string, projects map[string]*models.Project) (map[string]*models.ProjectStats, map[string][]string) {
+	stats := make(map[string]*models.ProjectStats, len(ids))
+	failures := make(map[string][]string)
+	if len(ids) == 0 {
+		return stats, failures
+	}
+
+	now := time.Now()
+	for _, projectID := range ids {
+		projectStats := &models.ProjectStats{ProjectID: projectID, CalculatedAt: now}
+		project := projects[projectID]
+		if project.LastActivityAt != nil {
+			projectStats.LastActivityAt = *project.LastActivityAt
+		}
+		if limits, err := models.ParseProjectResourceLimits(project.ResourceLimits); err != nil {
+			s.logger.Warn("Ignoring invalid project resource limits", zap.String("project_id", projectID), zap.Error(err))
+		} else {
+			projectStats.StorageQuota = limits.MaxStorageBytes
+		}
+		stats[projectID] = projectStats
+	}
+
+	for _, family := range projectStatsFamilies {
+		var rows []projectStatsRow
+		err := family.query(s.db.WithContext(ctx)).Where("project_id IN ?", ids).Group("project_id").Scan(&rows).Error
+		if err != nil {
+			s.logger.Warn("Failed to compute project stats", zap.String("family", family.name), zap.Error(err))
+			for _, projectID := range ids {
+				failures[projectID] = append(failures[projectID], fmt.Sprintf("failed to count %s: %v", family.name, err))
+			}
+			continue
+		}
+		for i := range rows {
+			if projectStats, ok := stats[rows[i].ProjectID]; ok {
+				family.apply(projectStats, &rows[i])
+			}
+		}
+	}
+	return stats, failures
+}
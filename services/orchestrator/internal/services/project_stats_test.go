@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// memoryProjectStatsCache is an in-memory ProjectStatsCache
+type memoryProjectStatsCache struct {
+	mu    sync.Mutex
+	stats map[string]models.ProjectStats
+	sets  int
+}
+
+func (m *memoryProjectStatsCache) GetMany(ctx context.Context, projectIDs []string) (map[string]*models.ProjectStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cached := make(map[string]*models.ProjectStats)
+	for _, projectID := range projectIDs {
+		if stats, ok := m.stats[projectID]; ok {
+			cached[projectID] = &stats
+		}
+	}
+	return cached, nil
+}
+
+func (m *memoryProjectStatsCache) SetMany(ctx context.Context, stats []*models.ProjectStats, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, projectStats := range stats {
+		m.stats[projectStats.ProjectID] = *projectStats
+	}
+	m.sets++
+	return nil
+}
+
+// setupProjectStatsTest creates three projects owned by alice, bob and bob,
+// alice being a member of project-2 and a former member of project-3, with
+// workflows, executions, members, resources, integrations and storage
+func setupProjectStatsTest(t *testing.T) (*gorm.DB, *ProjectService) {
+	db := setupWorkflowTestDB(t)
+	for _, table := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, owner_id TEXT, last_activity_at DATETIME, resource_limits BLOB, deleted_at DATETIME)`,
+		`CREATE TABLE project_members (id TEXT PRIMARY KEY, project_id TEXT, user_id TEXT, role TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, status TEXT, simulated BOOLEAN DEFAULT false, deleted_at DATETIME)`,
+		`CREATE TABLE resources (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE integrations (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(table).Error)
+	}
+	require.NoError(t, db.AutoMigrate(&models.ProjectStorageUsage{}))
+
+	active := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Exec(`INSERT INTO projects (id, owner_id, last_activity_at, resource_limits) VALUES
+		('project-1', 'alice', ?, ?), ('project-2', 'bob', NULL, NULL), ('project-3', 'bob', NULL, NULL)`,
+		active, []byte(`{"max_storage_bytes": 1000}`)).Error)
+	require.NoError(t, db.Exec(`INSERT INTO project_members (id, project_id, user_id, role, deleted_at) VALUES
+		('m-1', 'project-1', 'carol', 'viewer', NULL), ('m-2', 'project-1', 'dave', 'viewer', NULL),
+		('m-3', 'project-2', 'alice', 'viewer', NULL), ('m-4', 'project-3', 'alice', 'viewer', ?)`, active).Error)
+
+	for _, workflow := range []*models.Workflow{
+		{Name: "a", ProjectID: "project-1", Status: models.WorkflowStatusRunning},
+		{Name: "b", ProjectID: "project-1", Status: models.WorkflowStatusCompleted},
+		{Name: "c", ProjectID: "project-1", Status: models.WorkflowStatusPending, Simulated: true},
+		{Name: "d", ProjectID: "project-2", Status: models.WorkflowStatusPending},
+	} {
+		workflow.Type = models.WorkflowTypeExecution
+		require.NoError(t, db.Create(workflow).Error)
+	}
+	require.NoError(t, db.Exec(`INSERT INTO executions (id, project_id, status, simulated) VALUES
+		('e-1', 'project-1', 'succeeded', false), ('e-2', 'project-1', 'failed', false), ('e-3', 'project-1', 'failed', true),
+		('e-4', 'project-2', 'succeeded', false), ('e-5', 'project-3', 'running', false)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO resources (id, project_id, deleted_at) VALUES
+		('r-1', 'project-1', NULL), ('r-2', 'project-2', NULL), ('r-3', 'project-2', ?)`, active).Error)
+	require.NoError(t, db.Exec(`INSERT INTO integrations (id, project_id) VALUES ('i-1', 'project-3')`).Error)
+	require.NoError(t, db.Create(&[]models.ProjectStorageUsage{
+		{ProjectID: "project-1", ArtifactType: "log", Bytes: 300, Artifacts: 2},
+		{ProjectID: "project-1", ArtifactType: "output", Bytes: 200, Artifacts: 1},
+		{ProjectID: "project-2", ArtifactType: "log", Bytes: 50, Artifacts: 1},
+	}).Error)
+
+	return db, NewProjectService(db, zap.NewNop())
+}
+
+func TestProjectService_GetProjectStatsBatchMatchesPerProjectStats(t *testing.T) {
+	ctx := context.Background()
+	_, service := setupProjectStatsTest(t)
+
+	results, err := service.GetProjectStatsBatch(ctx, []string{"project-3", "project-1", "project-2"}, ProjectViewer{All: true})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for i, projectID := range []string{"project-3", "project-1", "project-2"} {
+		result := results[i]
+		assert.Equal(t, projectID, result.ProjectID, "results keep the request order")
+		assert.Empty(t, result.Error)
+		assert.False(t, result.Cached)
+		require.NotNil(t, result.Stats)
+
+		expected, err := service.GetProjectStats(ctx, projectID)
+		require.NoError(t, err)
+		assert.True(t, expected.LastActivityAt.Equal(result.Stats.LastActivityAt), projectID)
+		got := *result.Stats
+		got.CalculatedAt, got.LastActivityAt = expected.CalculatedAt, expected.LastActivityAt
+		assert.Equal(t, *expected, got, projectID)
+	}
+
+	project1 := results[1].Stats
+	assert.Equal(t, int64(2), project1.TotalWorkflows, "simulated workflows are left out")
+	assert.Equal(t, int64(1), project1.ActiveWorkflows)
+	assert.Equal(t, int64(2), project1.TotalExecutions)
+	assert.Equal(t, int64(1), project1.FailedExecutions)
+	assert.Equal(t, int64(2), project1.TotalMembers)
+	assert.Equal(t, int64(500), project1.StorageUsed)
+	assert.Equal(t, int64(1000), project1.StorageQuota)
+	assert.Equal(t, int64(1), results[2].Stats.TotalResources, "deleted resources are left out")
+	assert.Equal(t, int64(1), results[0].Stats.TotalIntegrations)
+}
+
+func TestProjectService_GetProjectStatsBatchVisibility(t *testing.T) {
+	_, service := setupProjectStatsTest(t)
+
+	ids := func(results []ProjectStatsResult) []string {
+		var ids []string
+		for _, result := range results {
+			ids = append(ids, result.ProjectID)
+		}
+		return ids
+	}
+	requested := []string{"project-3", "project-2", "missing", "project-1"}
+
+	results, err := service.GetProjectStatsBatch(context.Background(), requested, ProjectViewer{UserID: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"project-2", "project-1"}, ids(results), "owned and member projects; former memberships do not count")
+
+	results, err = service.GetProjectStatsBatch(context.Background(), requested, ProjectViewer{UserID: "mallory"})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = service.GetProjectStatsBatch(context.Background(), requested, ProjectViewer{All: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"project-3", "project-2", "project-1"}, ids(results), "unknown projects are left out")
+}
+
+func TestProjectService_GetProjectStatsBatchCache(t *testing.T) {
+	ctx := context.Background()
+	db, service := setupProjectStatsTest(t)
+	cache := &memoryProjectStatsCache{stats: make(map[string]models.ProjectStats)}
+	service.SetStatsCache(cache, time.Minute)
+
+	results, err := service.GetProjectStatsBatch(ctx, []string{"project-1"}, ProjectViewer{All: true})
+	require.NoError(t, err)
+	assert.False(t, results[0].Cached)
+	assert.Equal(t, int64(2), results[0].Stats.TotalWorkflows)
+	assert.Equal(t, 1, cache.sets)
+
+	// Fresh stats are served from the cache
+	require.NoError(t, db.Create(&models.Workflow{Name: "e", Type: models.WorkflowTypeExecution, ProjectID: "project-1"}).Error)
+	results, err = service.GetProjectStatsBatch(ctx, []string{"project-1", "project-2"}, ProjectViewer{All: true})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Cached)
+	assert.Equal(t, int64(2), results[0].Stats.TotalWorkflows)
+	assert.False(t, results[1].Cached)
+	assert.Equal(t, 2, cache.sets)
+
+	// Without a TTL the cache is left alone
+	service.SetStatsCache(cache, 0)
+	results, err = service.GetProjectStatsBatch(ctx, []string{"project-1"}, ProjectViewer{All: true})
+	require.NoError(t, err)
+	assert.False(t, results[0].Cached)
+	assert.Equal(t, int64(3), results[0].Stats.TotalWorkflows)
+	service.SetStatsCache(cache, time.Minute)
+
+	// A family that fails only affects the projects it was needed for
+	require.NoError(t, db.Exec(`DROP TABLE integrations`).Error)
+	results, err = service.GetProjectStatsBatch(ctx, []string{"project-1", "project-2", "project-3"}, ProjectViewer{All: true})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.NotNil(t, results[0].Stats)
+	assert.NotNil(t, results[1].Stats)
+	assert.Nil(t, results[2].Stats)
+	assert.Contains(t, results[2].Error, "failed to count integrations")
+	assert.Equal(t, 2, cache.sets, "stats with errors are not cached")
+	assert.NotContains(t, cache.stats, "project-3")
+}
+
+func TestProjectService_GetProjectStatsBatchLimit(t *testing.T) {
+	_, service := setupProjectStatsTest(t)
+	service.SetStatsBatchLimit(2)
+
+	_, err := service.GetProjectStatsBatch(context.Background(), []string{"project-1", "project-2", "project-3"}, ProjectViewer{All: true})
+	assert.ErrorIs(t, err, ErrInvalidProjectStatsBatch)
+	_, err = service.GetProjectStatsBatch(context.Background(), []string{" ", ""}, ProjectViewer{All: true})
+	assert.ErrorIs(t, err, ErrInvalidProjectStatsBatch)
+
+	results, err := service.GetProjectStatsBatch(context.Background(), []string{"project-1", "project-2", "project-1"}, ProjectViewer{All: true})
+	require.NoError(t, err, "repeated IDs count once")
+	assert.Len(t, results, 2)
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/redact"
+)
+
+// ErrInvalidRedactionRules is returned for project settings whose redaction
+// rules have empty field names or malformed paths
+var ErrInvalidRedactionRules = errors.New("invalid redaction rules")
+
+// ValidateRedactionRules checks the redaction rules of project settings
+func ValidateRedactionRules(rules *models.RedactionRules) error {
+	if rules == nil {
+		return nil
+	}
+	if _, err := redact.New(rules.Fields, rules.Paths); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRedactionRules, err)
+	}
+	return nil
+}
+
+// ProjectRedactors resolves the redactor of each project: the global rules
+// plus the additions in the project's settings. A nil ProjectRedactors
+// resolves the default rules for every project.
+type ProjectRedactors struct {
+	db     *gorm.DB
+	global *redact.Redactor
+	logger *zap.Logger
+}
+
+// NewProjectRedactors creates a resolver extending the global redactor
+func NewProjectRedactors(db *gorm.DB, global *redact.Redactor, logger *zap.Logger) *ProjectRedactors {
+	return &ProjectRedactors{db: db, global: global, logger: logger}
+}
+
+// For returns the redactor of a project. Projects whose settings cannot be
+// read fall back to the global rules, so redaction never blocks an event or
+// report.
+func (p *ProjectRedactors) For(ctx context.Context, projectID string) *redact.Redactor {
+	if p == nil {
+		return nil
+	}
+	if projectID == "" {
+		return p.global
+	}
+
+	var project models.Project
+	err := p.db.WithContext(ctx).Select("id", "settings").First(&project, "id = ?", projectID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return p.global
+	}
+	if err == nil {
+		var settings models.ProjectSettings
+		settings, err = models.ParseProjectSettings(project.Settings)
+		if err == nil {
+			if settings.Redaction == nil {
+				return p.global
+			}
+			var redactor *redact.Redactor
+			redactor, err = p.global.With(settings.Redaction.Fields, settings.Redaction.Paths)
+			if err == nil {
+				return redactor
+			}
+		}
+	}
+	p.logger.Warn("Using the global redaction rules for project",
+		zap.String("project_id", projectID),
+		zap.Error(err),
+	)
+	return p.global
+}
+
+// SetRedactors sets the redaction rules applied to the workflow events the
+// engine emits
+func (e *WorkflowEngine) SetRedactors(redactors *ProjectRedactors) {
+	e.redactors = redactors
+}
+
+// SetRedactors sets the redaction rules applied to the reports the builder
+// assembles
+func (b *WorkflowReportBuilder) SetRedactors(redactors *ProjectRedactors) {
+	b.redactors = redactors
+}
+
+// redactReport redacts the parts of a report that carry workflow inputs and
+// outputs: the entities of its intent, the queries of artifact links and
+// the values named in error messages and comments
+func redactReport(report *WorkflowReport, redactor *redact.Redactor) {
+	if report.Intent != nil {
+		intent := *report.Intent
+		intent.Entities = redactor.JSON(intent.Entities)
+		report.Intent = &intent
+	}
+	for i := range report.Artifacts.Items {
+		report.Artifacts.Items[i].Link = redactor.Text(report.Artifacts.Items[i].Link)
+	}
+	for i := range report.Errors.Items {
+		report.Errors.Items[i].Message = redactor.Text(report.Errors.Items[i].Message)
+	}
+	for i := range report.Comments.Items {
+		report.Comments.Items[i].Body = redactor.Text(report.Comments.Items[i].Body)
+	}
+}
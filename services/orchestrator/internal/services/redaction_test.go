@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/redact"
+)
+
+// setupRedactionTest stores project-1 with its own redaction rules and
+// project-2 without any
+func setupRedactionTest(t *testing.T) (*gorm.DB, *ProjectRedactors) {
+	db := setupReportTest(t)
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`).Error)
+	settings := `{"redaction": {"fields": ["ssn"], "paths": ["$.data.target.host"]}}`
+	require.NoError(t, db.Exec(`INSERT INTO projects (id, settings) VALUES ('project-1', ?), ('project-2', NULL)`, []byte(settings)).Error)
+
+	global, err := redact.New([]string{"license"}, nil)
+	require.NoError(t, err)
+	return db, NewProjectRedactors(db, global, zap.NewNop())
+}
+
+func TestValidateProjectSettings_Redaction(t *testing.T) {
+	assert.NoError(t, validateProjectSettings(json.RawMessage(`{"redaction": {"fields": ["ssn"], "paths": ["$.deploy.targets[*].host"]}}`)))
+	assert.ErrorIs(t, validateProjectSettings(json.RawMessage(`{"redaction": {"paths": ["$.items[0]"]}}`)), ErrInvalidRedactionRules)
+	assert.ErrorIs(t, validateProjectSettings(json.RawMessage(`{"redaction": {"fields": [""]}}`)), ErrInvalidRedactionRules)
+}
+
+func TestProjectRedactors_For(t *testing.T) {
+	_, redactors := setupRedactionTest(t)
+	ctx := context.Background()
+
+	assert.True(t, redactors.For(ctx, "project-1").MatchesField("user_ssn"))
+	assert.True(t, redactors.For(ctx, "project-1").MatchesField("license"), "project rules add to the global ones")
+	assert.False(t, redactors.For(ctx, "project-2").MatchesField("user_ssn"))
+	assert.True(t, redactors.For(ctx, "project-2").MatchesField("license"))
+	assert.True(t, redactors.For(ctx, "unknown").MatchesField("password"))
+	assert.Nil(t, (*ProjectRedactors)(nil).For(ctx, "project-1"))
+}
+
+func TestWorkflowEngine_RedactsEmittedEvents(t *testing.T) {
+	db, redactors := setupRedactionTest(t)
+	client, fake, _ := newFakeRedis(t)
+	engine := NewWorkflowEngine(db, client, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetRedactors(redactors)
+
+	input := json.RawMessage(`{"repository":"orders","api_token":"ghp_secret"}`)
+	workflow := &models.Workflow{Name: "Deploy", Type: models.WorkflowTypeDeployment, Status: models.WorkflowStatusRunning,
+		ProjectID: "project-1", Input: input}
+	require.NoError(t, db.Create(workflow).Error)
+
+	engine.emitWorkflowEvent(context.Background(), workflow, "workflow.retried", map[string]interface{}{
+		"reason": "connect failed: password=hunter2",
+		"user":   map[string]interface{}{"name": "alice", "ssn": "123-45-6789"},
+		"target": map[string]interface{}{"host": "db.internal", "port": 5432},
+		"input":  workflow.Input,
+	})
+
+	messages := fake.messages()
+	require.Len(t, messages, 1)
+	var event struct {
+		WorkflowID string `json:"workflow_id"`
+		Data       struct {
+			Reason string                 `json:"reason"`
+			User   map[string]interface{} `json:"user"`
+			Target map[string]interface{} `json:"target"`
+			Input  map[string]interface{} `json:"input"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(messages[0]), &event))
+	assert.Equal(t, workflow.ID, event.WorkflowID)
+	// Free text is left to the emitters; only structured values are redacted
+	assert.Equal(t, "connect failed: password=hunter2", event.Data.Reason)
+	assert.Equal(t, map[string]interface{}{"name": "alice", "ssn": "[REDACTED:string:medium]"}, event.Data.User)
+	assert.Equal(t, map[string]interface{}{"host": "[REDACTED:string:medium]", "port": float64(5432)}, event.Data.Target)
+	assert.Equal(t, map[string]interface{}{"repository": "orders", "api_token": "[REDACTED:string:medium]"}, event.Data.Input)
+
+	// The stored workflow keeps its input as it was submitted
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.JSONEq(t, string(input), string(stored.Input))
+}
+
+func TestRedisGuard_RedactsPublishedEvents(t *testing.T) {
+	ctx := context.Background()
+	_, fake, guard := newFakeRedis(t)
+
+	// Without rules of its own the guard redacts the default field names
+	require.NoError(t, guard.Publish(ctx, "agent:events:agent-1", []byte(`{"agent_id":"agent-1","token":"abc"}`)))
+	redactor, err := redact.New([]string{"license"}, nil)
+	require.NoError(t, err)
+	guard.SetRedactor(redactor)
+	require.NoError(t, guard.Publish(ctx, "workflow:events:project-1", []byte(`{"license":"ABCD-1234"}`)))
+	// Events held while Redis is down are buffered redacted
+	fake.setDown(true)
+	require.NoError(t, guard.Publish(ctx, "workflow:events:project-1", []byte(`{"secret":"held"}`)))
+	fake.setDown(false)
+	require.NoError(t, guard.Flush(ctx))
+
+	assert.Equal(t, []string{
+		`{"agent_id":"agent-1","token":"[REDACTED:string:short]"}`,
+		`{"license":"[REDACTED:string:medium]"}`,
+		`{"secret":"[REDACTED:string:short]"}`,
+	}, fake.messages())
+}
+
+func TestWorkflowReportBuilder_RedactsReport(t *testing.T) {
+	db, redactors := setupRedactionTest(t)
+	workflow := seedReportWorkflow(t, db)
+	entities := json.RawMessage(`[{"type":"service","value":"orders"},{"type":"credentials","value":"x","password":"hunter2","ssn":"123-45-6789"}]`)
+	require.NoError(t, db.Exec(`UPDATE intent_analyses SET entities = ? WHERE workflow_id = ?`, []byte(entities), workflow.ID).Error)
+	require.NoError(t, db.Model(&models.Workflow{}).Where("id = ?", workflow.ID).
+		Update("error", "deploy failed: db_password=hunter2").Error)
+	require.NoError(t, db.Exec(`UPDATE artifacts SET url = 'https://artifacts.example.com/orders.go?token=abcdef' WHERE id = 'artifact-2'`).Error)
+
+	builder := NewWorkflowReportBuilder(db)
+	builder.now = func() time.Time { return reportEpoch.Add(time.Hour) }
+	builder.SetRedactors(redactors)
+	report, err := builder.Build(context.Background(), workflow.ID)
+	require.NoError(t, err)
+
+	require.NotNil(t, report.Intent)
+	assert.JSONEq(t, `[{"type":"service","value":"orders"},{"type":"credentials","value":"x","password":"[REDACTED:string:short]","ssn":"[REDACTED:string:medium]"}]`,
+		string(report.Intent.Entities))
+	assert.Equal(t, "deploy failed: db_password=[REDACTED:string:short]", report.Errors.Items[0].Message)
+	require.Len(t, report.Artifacts.Items, 2)
+	assert.Equal(t, "https://artifacts.example.com/orders.go?token=[REDACTED:string:short]", report.Artifacts.Items[1].Link)
+
+	// Stored values are left as they are
+	var analysis models.IntentAnalysis
+	require.NoError(t, db.Where("workflow_id = ?", workflow.ID).First(&analysis).Error)
+	assert.JSONEq(t, string(entities), string(analysis.Entities))
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.Equal(t, "deploy failed: db_password=hunter2", stored.Error)
+}
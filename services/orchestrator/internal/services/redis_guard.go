@@ -0,0 +1,506 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/events"
+	"orchestrator/internal/redact"
+)
+
+// Defaults of the Redis guard when the configuration leaves them unset
+const (
+	defaultRedisErrorLogInterval = time.Minute
+	defaultRedisProbeInterval    = 5 * time.Second
+	defaultRedisEventBufferSize  = 1000
+)
+
+// Commands that failed because Redis was unreachable, and the events held
+// until it is back
+var (
+	redisErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_redis_errors_total",
+		Help: "Redis commands that failed because Redis was unreachable, by command",
+	}, []string{"command"})
+	redisBufferedEvents = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orchestrator_redis_buffered_events",
+		Help: "Events held in memory until Redis is reachable again",
+	})
+	redisDroppedEventsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_redis_dropped_events_total",
+		Help: "Events dropped because the buffer was full while Redis was unreachable",
+	})
+)
+
+// IsRedisOutage reports whether err means Redis could not be reached, as
+// opposed to a missing key, an error reply or a cancelled request
+func IsRedisOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, redis.ErrClosed) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		strings.Contains(err.Error(), "connection pool timeout")
+}
+
+// RedisHealth is the state of Redis as seen by the orchestrator. Core paths
+// keep working while it is unhealthy, so it only degrades the service.
+type RedisHealth struct {
+	Healthy        bool       `json:"healthy"`
+	DownSince      *time.Time `json:"down_since,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	BufferedEvents int        `json:"buffered_events"`
+	DroppedEvents  int64      `json:"dropped_events"`
+	StaleKeys      int        `json:"stale_keys"` // Cache entries to invalidate once Redis is back
+}
+
+// RedisHealthChecker reports the state of Redis
+type RedisHealthChecker interface {
+	Health() RedisHealth
+}
+
+var _ RedisHealthChecker = (*RedisGuard)(nil)
+
+// bufferedEvent is a message published while Redis was unreachable
+type bufferedEvent struct {
+	seq     uint64
+	channel string
+	message []byte
+}
+
+// RedisGuard keeps a Redis outage from taking the orchestrator down. As a
+// hook of the client it sees every command: failures are counted, logged at
+// most once per interval per command, and mark Redis down until a command
+// succeeds again. Its cache helpers fall back to misses, and events published
+// while Redis is down are held in a bounded buffer, oldest dropped first,
+// and delivered in order once it is back.
+type RedisGuard struct {
+	client *redis.Client
+	logger *zap.Logger
+	now    func() time.Time
+
+	mu            sync.Mutex
+	logInterval   time.Duration
+	probeInterval time.Duration
+	lastLogged    map[string]time.Time
+	downSince     *time.Time
+	lastError     string
+	events        []bufferedEvent // Ring holding size events from head
+	head, size    int
+	seq           uint64
+	dropped       int64
+	staleKeys     map[string]struct{}
+	consumers     *events.Registry // Told of the events published, see SetEventRegistry
+	redactor      *redact.Redactor // Redacts the events published, see SetRedactor
+
+	flushMu  sync.Mutex
+	flushNow chan struct{}
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// redisGuards holds the guard of each client, so every user of a client
+// shares its outage state and event buffer
+var redisGuards sync.Map
+
+// noRedisGuard is the guard of components running without Redis
+var noRedisGuard = newRedisGuard(nil, nil)
+
+// RedisGuardFor returns the guard of client, creating it and adding it as a
+// hook of the client on first use. The guard of a nil client treats every
+// read as a miss and drops every write.
+func RedisGuardFor(client *redis.Client, logger *zap.Logger) *RedisGuard {
+	if client == nil {
+		return noRedisGuard
+	}
+	if guard, ok := redisGuards.Load(client); ok {
+		return guard.(*RedisGuard)
+	}
+	guard, loaded := redisGuards.LoadOrStore(client, newRedisGuard(client, logger))
+	if !loaded {
+		client.AddHook(guard.(*RedisGuard))
+	}
+	return guard.(*RedisGuard)
+}
+
+func newRedisGuard(client *redis.Client, logger *zap.Logger) *RedisGuard {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &RedisGuard{
+		client:        client,
+		logger:        logger,
+		now:           time.Now,
+		logInterval:   defaultRedisErrorLogInterval,
+		probeInterval: defaultRedisProbeInterval,
+		lastLogged:    make(map[string]time.Time),
+		events:        make([]bufferedEvent, defaultRedisEventBufferSize),
+		staleKeys:     make(map[string]struct{}),
+		flushNow:      make(chan struct{}, 1),
+		stopChan:      make(chan struct{}),
+	}
+}
+
+// Configure applies the error log interval, probe interval and event buffer
+// size of cfg, keeping the newest buffered events that still fit
+func (g *RedisGuard) Configure(cfg *config.RedisConfig) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.logInterval = defaultRedisErrorLogInterval
+	if cfg.ErrorLogInterval > 0 {
+		g.logInterval = time.Duration(cfg.ErrorLogInterval) * time.Second
+	}
+	g.probeInterval = defaultRedisProbeInterval
+	if cfg.ProbeInterval > 0 {
+		g.probeInterval = time.Duration(cfg.ProbeInterval) * time.Second
+	}
+	size := defaultRedisEventBufferSize
+	if cfg.EventBufferSize > 0 {
+		size = cfg.EventBufferSize
+	}
+	if size == len(g.events) {
+		return
+	}
+	events := make([]bufferedEvent, size)
+	kept := min(g.size, size)
+	for i := 0; i < kept; i++ {
+		events[i] = g.events[(g.head+g.size-kept+i)%len(g.events)]
+	}
+	g.dropEvents(int64(g.size - kept))
+	g.events, g.head, g.size = events, 0, kept
+}
+
+// Start starts probing Redis while it is down and flushing what was held
+// back once it is up
+func (g *RedisGuard) Start() {
+	if g.client == nil {
+		return
+	}
+	g.wg.Add(1)
+	go g.run()
+}
+
+// Stop stops the prober
+func (g *RedisGuard) Stop() {
+	g.stopOnce.Do(func() {
+		close(g.stopChan)
+		g.wg.Wait()
+	})
+}
+
+func (g *RedisGuard) run() {
+	defer g.wg.Done()
+
+	g.mu.Lock()
+	interval := g.probeInterval
+	g.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-g.flushNow:
+		case <-g.stopChan:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		// A successful ping marks Redis up through the hook
+		if g.Health().Healthy || g.client.Ping(ctx).Err() == nil {
+			if err := g.Flush(ctx); err != nil && !IsRedisOutage(err) {
+				g.logger.Warn("Failed to flush events held while Redis was down", zap.Error(err))
+			}
+		}
+		cancel()
+	}
+}
+
+// Health reports whether Redis is reachable and what is held back for it
+func (g *RedisGuard) Health() RedisHealth {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	health := RedisHealth{
+		Healthy:        g.downSince == nil,
+		LastError:      g.lastError,
+		BufferedEvents: g.size,
+		DroppedEvents:  g.dropped,
+		StaleKeys:      len(g.staleKeys),
+	}
+	if g.downSince != nil {
+		since := *g.downSince
+		health.DownSince = &since
+	}
+	return health
+}
+
+func (g *RedisGuard) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (g *RedisGuard) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		g.observe(cmd.Name(), err)
+		return err
+	}
+}
+
+func (g *RedisGuard) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		g.observe("pipeline", err)
+		return err
+	}
+}
+
+// observe records the outcome of a command: any answer from Redis means it
+// is up, a failure to reach it marks it down
+func (g *RedisGuard) observe(command string, err error) {
+	var reply redis.Error
+	if err == nil || errors.Is(err, redis.Nil) || errors.As(err, &reply) {
+		g.markUp()
+		return
+	}
+	if !IsRedisOutage(err) {
+		return
+	}
+	redisErrorsTotal.WithLabelValues(command).Inc()
+
+	now := g.now()
+	g.mu.Lock()
+	if g.downSince == nil {
+		g.downSince = &now
+	}
+	g.lastError = err.Error()
+	log := now.Sub(g.lastLogged[command]) >= g.logInterval
+	if log {
+		g.lastLogged[command] = now
+	}
+	g.mu.Unlock()
+
+	if log {
+		g.logger.Warn("Redis unreachable, falling back",
+			zap.String("command", command),
+			zap.Error(err))
+	}
+}
+
+func (g *RedisGuard) markUp() {
+	g.mu.Lock()
+	downSince := g.downSince
+	g.downSince = nil
+	g.lastError = ""
+	pending := g.size > 0 || len(g.staleKeys) > 0
+	g.mu.Unlock()
+
+	if downSince != nil {
+		g.logger.Info("Redis reachable again", zap.Duration("down_for", g.now().Sub(*downSince)))
+	}
+	if pending {
+		select {
+		case g.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Get returns the value cached at key. ok is false on a miss, which is what
+// every read is while Redis is unreachable.
+func (g *RedisGuard) Get(ctx context.Context, key string) (string, bool) {
+	if g.client == nil {
+		return "", false
+	}
+	value, err := g.client.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) && !IsRedisOutage(err) {
+		g.logger.Warn("Failed to read cache", zap.String("key", key), zap.Error(err))
+	}
+	return value, err == nil
+}
+
+// Set caches value at key for ttl. It is skipped while Redis is unreachable.
+func (g *RedisGuard) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) {
+	if g.client == nil {
+		return
+	}
+	if err := g.client.Set(ctx, key, value, ttl).Err(); err != nil && !IsRedisOutage(err) {
+		g.logger.Warn("Failed to write cache", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// Invalidate deletes cached keys. Keys that cannot be deleted while Redis is
+// unreachable are deleted once it is back, so entries cached before the
+// outage are not served stale; entries past the buffer size are left to
+// expire.
+func (g *RedisGuard) Invalidate(ctx context.Context, keys ...string) {
+	if g.client == nil || len(keys) == 0 {
+		return
+	}
+	err := g.client.Del(ctx, keys...).Err()
+	if err == nil {
+		return
+	}
+	if !IsRedisOutage(err) {
+		g.logger.Warn("Failed to invalidate cache", zap.Strings("keys", keys), zap.Error(err))
+		return
+	}
+	g.mu.Lock()
+	for _, key := range keys {
+		if len(g.staleKeys) < len(g.events) {
+			g.staleKeys[key] = struct{}{}
+		}
+	}
+	g.mu.Unlock()
+}
+
+// Publish publishes message on channel, redacted. While Redis is
+// unreachable, and until the events held back are delivered, the message is
+// buffered instead so subscribers still get events in order.
+func (g *RedisGuard) Publish(ctx context.Context, channel string, message []byte) error {
+	if g.client == nil {
+		return nil
+	}
+	g.mu.Lock()
+	redactor := g.redactor
+	g.mu.Unlock()
+	message = redactor.JSON(message)
+
+	g.mu.Lock()
+	behind := g.size > 0
+	if behind {
+		g.buffer(channel, message)
+	}
+	up := g.downSince == nil
+	registry := g.consumers
+	g.mu.Unlock()
+	registry.RecordProduced(events.SourceOf(channel))
+	if behind {
+		if up {
+			select {
+			case g.flushNow <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}
+
+	err := g.client.Publish(ctx, channel, message).Err()
+	if !IsRedisOutage(err) {
+		return err
+	}
+	g.mu.Lock()
+	g.buffer(channel, message)
+	g.mu.Unlock()
+	return nil
+}
+
+// SetEventRegistry makes the guard record the events published on each
+// source, against which the consumers of the source are watched
+func (g *RedisGuard) SetEventRegistry(registry *events.Registry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.consumers = registry
+}
+
+// SetRedactor sets the rules redacting the events published; until it is
+// set, the default field names are redacted
+func (g *RedisGuard) SetRedactor(redactor *redact.Redactor) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.redactor = redactor
+}
+
+// Flush deletes the cache keys whose invalidation failed and delivers the
+// buffered events, oldest first. It stops at the first outage, keeping the
+// rest for the next flush; events Redis rejects are dropped.
+func (g *RedisGuard) Flush(ctx context.Context) error {
+	if g.client == nil {
+		return nil
+	}
+	g.flushMu.Lock()
+	defer g.flushMu.Unlock()
+
+	g.mu.Lock()
+	keys := make([]string, 0, len(g.staleKeys))
+	for key := range g.staleKeys {
+		keys = append(keys, key)
+	}
+	g.mu.Unlock()
+	if len(keys) > 0 {
+		if err := g.client.Del(ctx, keys...).Err(); IsRedisOutage(err) {
+			return err
+		}
+		g.mu.Lock()
+		for _, key := range keys {
+			delete(g.staleKeys, key)
+		}
+		g.mu.Unlock()
+	}
+
+	for {
+		g.mu.Lock()
+		if g.size == 0 {
+			g.mu.Unlock()
+			return nil
+		}
+		event := g.events[g.head]
+		g.mu.Unlock()
+
+		err := g.client.Publish(ctx, event.channel, event.message).Err()
+		if IsRedisOutage(err) {
+			return err
+		}
+		if err != nil {
+			g.logger.Warn("Dropping event rejected by Redis", zap.String("channel", event.channel), zap.Error(err))
+		}
+		g.mu.Lock()
+		// The event may have been dropped for a newer one meanwhile
+		if g.size > 0 && g.events[g.head].seq == event.seq {
+			g.events[g.head] = bufferedEvent{}
+			g.head = (g.head + 1) % len(g.events)
+			g.size--
+			redisBufferedEvents.Dec()
+		}
+		g.mu.Unlock()
+	}
+}
+
+// buffer holds an event, dropping the oldest when the buffer is full. The
+// caller holds mu.
+func (g *RedisGuard) buffer(channel string, message []byte) {
+	if g.size == len(g.events) {
+		g.events[g.head] = bufferedEvent{}
+		g.head = (g.head + 1) % len(g.events)
+		g.size--
+		g.dropEvents(1)
+	}
+	g.seq++
+	g.events[(g.head+g.size)%len(g.events)] = bufferedEvent{seq: g.seq, channel: channel, message: message}
+	g.size++
+	redisBufferedEvents.Inc()
+}
+
+// dropEvents counts buffered events that are dropped. The caller holds mu.
+func (g *RedisGuard) dropEvents(n int64) {
+	if n <= 0 {
+		return
+	}
+	g.dropped += n
+	redisDroppedEventsTotal.Add(float64(n))
+	redisBufferedEvents.Sub(float64(n))
+}
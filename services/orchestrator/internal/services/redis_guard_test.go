@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/events"
+	"orchestrator/internal/models"
+)
+
+// fakeRedis is a Redis hook serving the commands the orchestrator sends from
+// memory, which can be taken down and brought back mid-test
+type fakeRedis struct {
+	mu        sync.Mutex
+	down      bool
+	values    map[string]string
+	published []string
+}
+
+func newFakeRedis(t *testing.T) (*redis.Client, *fakeRedis, *RedisGuard) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	// The guard is added first so it sees what the fake answers
+	guard := RedisGuardFor(client, zap.NewNop())
+	fake := &fakeRedis{values: make(map[string]string)}
+	client.AddHook(fake)
+	return client, fake, guard
+}
+
+func (f *fakeRedis) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeRedis) messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.published...)
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (f *fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.down {
+			return &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		}
+		args := cmd.Args()
+		switch cmd.Name() {
+		case "get":
+			value, ok := f.values[args[1].(string)]
+			if !ok {
+				return redis.Nil
+			}
+			cmd.(*redis.StringCmd).SetVal(value)
+		case "set":
+			f.values[args[1].(string)] = fakeRedisString(args[2])
+		case "del":
+			for _, key := range args[1:] {
+				delete(f.values, key.(string))
+			}
+		case "publish":
+			f.published = append(f.published, fakeRedisString(args[2]))
+		}
+		return nil
+	}
+}
+
+func fakeRedisString(arg interface{}) string {
+	if data, ok := arg.([]byte); ok {
+		return string(data)
+	}
+	return arg.(string)
+}
+
+func (f *fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error { return nil }
+}
+
+func TestIsRedisOutage(t *testing.T) {
+	assert.False(t, IsRedisOutage(nil))
+	assert.False(t, IsRedisOutage(redis.Nil))
+	assert.False(t, IsRedisOutage(context.Canceled))
+	assert.False(t, IsRedisOutage(errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")))
+	assert.True(t, IsRedisOutage(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}))
+	assert.True(t, IsRedisOutage(redis.ErrClosed))
+}
+
+func TestRedisGuard_ReadsFallBackToDatabase(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTimingTestEngine(t, new(mocks.Client))
+	client, fake, guard := newFakeRedis(t)
+	engine.redis = client
+
+	wf := &models.Workflow{ID: "wf-1", Name: "Build", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusRunning, ProjectID: "project-1"}
+	require.NoError(t, db.Create(wf).Error)
+	_, err := engine.GetWorkflow(ctx, wf.ID)
+	require.NoError(t, err)
+	_, cached := fake.values["workflow:wf-1"]
+	require.True(t, cached)
+
+	// Redis goes away mid-test: reads come from the database
+	fake.setDown(true)
+	require.NoError(t, db.Model(wf).Update("status", models.WorkflowStatusCompleted).Error)
+	got, err := engine.GetWorkflow(ctx, wf.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WorkflowStatusCompleted, got.Status)
+
+	health := guard.Health()
+	assert.False(t, health.Healthy)
+	assert.NotNil(t, health.DownSince)
+	assert.Contains(t, health.LastError, "connection refused")
+
+	// The copy cached before the outage is dropped once Redis is back
+	engine.dropCachedWorkflow(ctx, wf.ID)
+	assert.Equal(t, 1, guard.Health().StaleKeys)
+	fake.setDown(false)
+	require.NoError(t, guard.Flush(ctx))
+	_, cached = fake.values["workflow:wf-1"]
+	assert.False(t, cached)
+	assert.True(t, guard.Health().Healthy)
+	assert.Zero(t, guard.Health().StaleKeys)
+}
+
+func TestRedisGuard_BufferedEventsFlushAfterRecovery(t *testing.T) {
+	ctx := context.Background()
+	client, fake, guard := newFakeRedis(t)
+	publisher := NewRedisWorkflowEventPublisher(client)
+
+	require.NoError(t, publisher.Publish(ctx, "project-1", []byte("first")))
+	fake.setDown(true)
+	require.NoError(t, publisher.Publish(ctx, "project-1", []byte("second")))
+	require.NoError(t, publisher.Publish(ctx, "project-1", []byte("third")))
+	assert.Equal(t, []string{"first"}, fake.messages())
+	assert.Equal(t, 2, guard.Health().BufferedEvents)
+
+	// Flushing while Redis is still down keeps the events
+	assert.Error(t, guard.Flush(ctx))
+	assert.Equal(t, 2, guard.Health().BufferedEvents)
+
+	// Events published before the buffer drains queue behind it
+	fake.setDown(false)
+	require.NoError(t, publisher.Publish(ctx, "project-1", []byte("fourth")))
+	assert.Equal(t, []string{"first"}, fake.messages())
+	require.NoError(t, guard.Flush(ctx))
+	assert.Equal(t, []string{"first", "second", "third", "fourth"}, fake.messages())
+	assert.Zero(t, guard.Health().BufferedEvents)
+}
+
+func TestRedisGuard_ProberFlushesOnRecovery(t *testing.T) {
+	ctx := context.Background()
+	client, fake, guard := newFakeRedis(t)
+	guard.Configure(&config.RedisConfig{ProbeInterval: 1})
+	guard.Start()
+	defer guard.Stop()
+
+	fake.setDown(true)
+	require.NoError(t, guard.Publish(ctx, "workflow:events:project-1", []byte("held")))
+	fake.setDown(false)
+	// Any command reaching Redis marks it back up and wakes the prober
+	require.NoError(t, client.Ping(ctx).Err())
+	assert.Eventually(t, func() bool {
+		return len(fake.messages()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"held"}, fake.messages())
+}
+
+func TestRedisGuard_FullBufferDropsOldest(t *testing.T) {
+	ctx := context.Background()
+	_, fake, guard := newFakeRedis(t)
+	guard.Configure(&config.RedisConfig{EventBufferSize: 2})
+
+	fake.setDown(true)
+	for _, message := range []string{"a", "b", "c"} {
+		require.NoError(t, guard.Publish(ctx, "channel", []byte(message)))
+	}
+	health := guard.Health()
+	assert.Equal(t, 2, health.BufferedEvents)
+	assert.Equal(t, int64(1), health.DroppedEvents)
+
+	fake.setDown(false)
+	require.NoError(t, guard.Flush(ctx))
+	assert.Equal(t, []string{"b", "c"}, fake.messages())
+}
+
+func TestRedisGuard_RecordsProducedEvents(t *testing.T) {
+	ctx := context.Background()
+	_, fake, guard := newFakeRedis(t)
+	registry := events.NewRegistry(&config.EventsConfig{}, zap.NewNop())
+	registry.Register("workflow_events_test", events.ConsumerOptions{Source: "workflow:events"})
+	guard.SetEventRegistry(registry)
+
+	require.NoError(t, guard.Publish(ctx, "workflow:events:project-1", []byte("published")))
+	// Events held while Redis is down are produced as well
+	fake.setDown(true)
+	require.NoError(t, guard.Publish(ctx, "workflow:events:project-2", []byte("held")))
+
+	consumers := registry.Consumers()
+	require.Len(t, consumers, 1)
+	assert.NotNil(t, consumers[0].LastProducedAt)
+}
+
+func TestRedisGuard_NoClient(t *testing.T) {
+	ctx := context.Background()
+	guard := RedisGuardFor(nil, nil)
+	_, ok := guard.Get(ctx, "key")
+	assert.False(t, ok)
+	guard.Set(ctx, "key", "value", time.Minute)
+	guard.Invalidate(ctx, "key")
+	assert.NoError(t, guard.Publish(ctx, "channel", []byte("event")))
+	assert.True(t, guard.Health().Healthy)
+}
+
+// fakeLocker hands out locks from memory
+type fakeLocker struct {
+	held map[string]bool
+}
+
+func (l *fakeLocker) TryLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	if l.held[key] {
+		return "", false, nil
+	}
+	l.held[key] = true
+	return key, true, nil
+}
+
+func (l *fakeLocker) Unlock(ctx context.Context, token string) error {
+	delete(l.held, token)
+	return nil
+}
+
+func TestRedisSpawnStore_FallsBackWhileRedisDown(t *testing.T) {
+	ctx := context.Background()
+	client, fake, _ := newFakeRedis(t)
+	locker := &fakeLocker{held: make(map[string]bool)}
+	store := NewRedisSpawnStore(client, locker)
+	fake.setDown(true)
+
+	token, ok, err := store.AcquireLock(ctx, "spawn:lock:abc", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, locker.held["spawn:lock:abc"])
+	_, ok, err = store.AcquireLock(ctx, "spawn:lock:abc", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, store.ReleaseLock(ctx, "spawn:lock:abc", token))
+	assert.Empty(t, locker.held)
+
+	value, err := store.Get(ctx, "spawn:agent:abc")
+	require.NoError(t, err)
+	assert.Empty(t, value)
+	assert.NoError(t, store.Set(ctx, "spawn:agent:abc", "agent-1", time.Minute))
+	assert.NoError(t, store.Delete(ctx, "spawn:agent:abc"))
+
+	// The spawn rate limit fails open
+	n, err := store.Increment(ctx, "spawn:rate:project-1", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
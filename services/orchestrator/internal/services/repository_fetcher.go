@@ -0,0 +1,526 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrRepositoryAuth is returned when a repository needs credentials the
+	// project does not have, or rejects them
+	ErrRepositoryAuth = errors.New("repository authentication failed")
+	// ErrRepositoryNotFound is returned for unknown repositories, branches
+	// and paths
+	ErrRepositoryNotFound = errors.New("repository not found")
+	// ErrRepositoryURLNotAllowed is returned for repository URLs other than
+	// HTTPS and SSH, for HTTPS hosts neither allowed nor covered by a git
+	// integration, and for local repositories unless they are allowed
+	ErrRepositoryURLNotAllowed = errors.New("repository URL not allowed")
+)
+
+// IntegrationTypeGit is the type of project integrations holding the HTTPS
+// credentials of git hosts
+const IntegrationTypeGit = "git"
+
+// defaultGitTokenUser is the HTTPS user sent with integration tokens that do
+// not set one
+const defaultGitTokenUser = "x-access-token"
+
+// Reasons files are left out of a fetch
+const (
+	RepositorySkipBinary   = "binary"
+	RepositorySkipTooLarge = "too_large"
+	RepositorySkipSizeCap  = "size_cap"
+)
+
+var repositoryFetchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_repository_fetches_total",
+	Help: "Repository fetches for code analysis, by result (cache_hit, cloned, failed)",
+}, []string{"result"})
+
+// RepositoryFetchRequest selects the files to fetch from a repository
+type RepositoryFetchRequest struct {
+	ProjectID   string
+	URL         string   // HTTPS or SSH repository URL; local ones only when allowed
+	Branch      string   // The default branch when empty
+	Path        string   // Directory to fetch; the whole tree when empty
+	Include     []string // Globs matched against file paths and names; every file when empty
+	Integration string   // Git integration holding HTTPS credentials; matched by host when empty
+}
+
+// FetchedRepository is the content of a repository at a commit
+type FetchedRepository struct {
+	Files       []string          // Repository paths, in tree order
+	Content     map[string]string // By repository path
+	Branch      string
+	Commit      string
+	Author      string
+	Message     string
+	CommittedAt time.Time
+	Bytes       int64          // Content fetched
+	Skipped     map[string]int // Files left out, by reason
+	Truncated   bool           // Files were left out for the size cap
+	Cached      bool           // The commit was already cloned
+}
+
+// RepositoryFetcher fetches the files of a repository for analysis
+type RepositoryFetcher interface {
+	Fetch(ctx context.Context, req *RepositoryFetchRequest) (*FetchedRepository, error)
+}
+
+// GitFetcher fetches repositories over HTTPS or SSH, and from the local
+// filesystem when the configuration allows it.
+// Branches are cloned shallowly into a cache directory by repository and
+// commit, so analyses of the same commit share one clone; clones unused for
+// the cache TTL are removed.
+type GitFetcher struct {
+	db      *gorm.DB
+	secrets SecretProvider
+	cfg     *config.RepositoriesConfig
+	logger  *zap.Logger
+	now     func() time.Time
+
+	mu        sync.Mutex
+	clones    map[string]*sync.Mutex // Serializes the cloning of each commit
+	lastEvict time.Time
+}
+
+var _ RepositoryFetcher = (*GitFetcher)(nil)
+
+// NewGitFetcher creates a fetcher taking HTTPS credentials from the git
+// integrations of projects and SSH deploy keys from their secrets
+func NewGitFetcher(db *gorm.DB, secrets SecretProvider, cfg *config.RepositoriesConfig, logger *zap.Logger) *GitFetcher {
+	return &GitFetcher{
+		db:      db,
+		secrets: secrets,
+		cfg:     cfg,
+		logger:  logger,
+		now:     time.Now,
+		clones:  make(map[string]*sync.Mutex),
+	}
+}
+
+// Fetch fetches the files of a branch of a repository, reusing the clone of
+// its head commit when there is one
+func (f *GitFetcher) Fetch(ctx context.Context, req *RepositoryFetchRequest) (*FetchedRepository, error) {
+	fetched, err := f.fetch(ctx, req)
+	if err != nil {
+		repositoryFetchesTotal.WithLabelValues("failed").Inc()
+		return nil, err
+	}
+	if fetched.Cached {
+		repositoryFetchesTotal.WithLabelValues("cache_hit").Inc()
+	} else {
+		repositoryFetchesTotal.WithLabelValues("cloned").Inc()
+	}
+	f.evictExpired()
+	return fetched, nil
+}
+
+func (f *GitFetcher) fetch(ctx context.Context, req *RepositoryFetchRequest) (*FetchedRepository, error) {
+	auth, err := f.auth(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	branch, commit, err := f.resolveBranch(ctx, req.URL, req.Branch, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, cached, err := f.clone(ctx, req.URL, branch, commit, auth)
+	if err != nil {
+		return nil, err
+	}
+	fetched, err := f.readTree(repo, req)
+	if err != nil {
+		return nil, err
+	}
+	fetched.Branch = branch.Short()
+	fetched.Cached = cached
+	return fetched, nil
+}
+
+// auth returns the credentials for a repository: the token of the project's
+// git integration for HTTPS, its deploy key for SSH. Plain HTTP and git://
+// are refused, so credentials never travel in the clear, and local
+// repositories are refused unless allowed, so workflow input cannot read the
+// worker's disk.
+func (f *GitFetcher) auth(ctx context.Context, req *RepositoryFetchRequest) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository URL: %w", err)
+	}
+
+	switch endpoint.Protocol {
+	case "https":
+		return f.integrationAuth(ctx, req, endpoint.Host)
+	case "file":
+		if !f.cfg.AllowLocal {
+			return nil, fmt.Errorf("%w: local repositories are disabled", ErrRepositoryURLNotAllowed)
+		}
+		return nil, nil
+	case "ssh":
+		if f.secrets == nil {
+			return nil, fmt.Errorf("%w: deploy keys need secrets, which are not configured", ErrRepositoryAuth)
+		}
+		key, err := f.secrets.GetSecret(ctx, req.ProjectID, f.cfg.DeployKeySecret)
+		if errors.Is(err, ErrSecretNotFound) {
+			return nil, fmt.Errorf("%w: project has no %s deploy key secret", ErrRepositoryAuth, f.cfg.DeployKeySecret)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get deploy key: %w", err)
+		}
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+		keys, err := gitssh.NewPublicKeys(user, []byte(key), "")
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid deploy key: %v", ErrRepositoryAuth, err)
+		}
+		if f.cfg.KnownHostsFile != "" {
+			keys.HostKeyCallback, err = gitssh.NewKnownHostsCallback(f.cfg.KnownHostsFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load known hosts: %w", err)
+			}
+		}
+		return keys, nil
+	}
+	return nil, fmt.Errorf("%w: %s repositories are not supported, use https or ssh", ErrRepositoryURLNotAllowed,
+		endpoint.Protocol)
+}
+
+// integrationAuth returns the token of the project's git integration for
+// host, or of the named integration, which must be for host too.
+// Repositories without one are fetched anonymously from the allowed hosts
+// only, so workflow input cannot point the worker at internal services.
+func (f *GitFetcher) integrationAuth(ctx context.Context, req *RepositoryFetchRequest, host string) (transport.AuthMethod, error) {
+	query := f.db.WithContext(ctx).
+		Where("project_id = ? AND type = ? AND status = ?", req.ProjectID, IntegrationTypeGit, "active")
+	if req.Integration != "" {
+		query = query.Where("name = ?", req.Integration)
+	}
+	var integrations []models.Integration
+	if err := query.Order("created_at").Find(&integrations).Error; err != nil {
+		return nil, fmt.Errorf("failed to find git integrations: %w", err)
+	}
+
+	for _, integration := range integrations {
+		var settings struct {
+			Host string `json:"host"`
+		}
+		if len(integration.Config) > 0 {
+			if err := json.Unmarshal(integration.Config, &settings); err != nil {
+				return nil, fmt.Errorf("invalid config of integration %s: %w", integration.Name, err)
+			}
+		}
+		if !strings.EqualFold(settings.Host, host) {
+			if req.Integration != "" {
+				return nil, fmt.Errorf("%w: integration %s is for %q, not %q", ErrRepositoryAuth, integration.Name,
+					settings.Host, host)
+			}
+			continue
+		}
+
+		var credentials struct {
+			Username string `json:"username"`
+			Token    string `json:"token"`
+		}
+		if len(integration.Credentials) > 0 {
+			if err := json.Unmarshal(integration.Credentials, &credentials); err != nil {
+				return nil, fmt.Errorf("%w: invalid credentials of integration %s", ErrRepositoryAuth, integration.Name)
+			}
+		}
+		if credentials.Token == "" {
+			return nil, fmt.Errorf("%w: integration %s has no token", ErrRepositoryAuth, integration.Name)
+		}
+		if credentials.Username == "" {
+			credentials.Username = defaultGitTokenUser
+		}
+		return &githttp.BasicAuth{Username: credentials.Username, Password: credentials.Token}, nil
+	}
+
+	if req.Integration != "" {
+		return nil, fmt.Errorf("%w: no active git integration %s", ErrRepositoryAuth, req.Integration)
+	}
+	for _, allowed := range f.cfg.AllowedHosts {
+		if strings.EqualFold(allowed, host) {
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s has no git integration and is not an allowed host", ErrRepositoryURLNotAllowed, host)
+}
+
+// resolveBranch returns the branch, the default one when name is empty, and
+// the commit it points at
+func (f *GitFetcher) resolveBranch(ctx context.Context, url, name string, auth transport.AuthMethod) (plumbing.ReferenceName, plumbing.Hash, error) {
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{Name: "origin", URLs: []string{url}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", plumbing.ZeroHash, repositoryError(err)
+	}
+
+	branch := plumbing.NewBranchReferenceName(name)
+	if name == "" {
+		branch = ""
+		for _, ref := range refs {
+			if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+				branch = ref.Target()
+			}
+		}
+	}
+	for _, ref := range refs {
+		if ref.Name() == branch && ref.Type() == plumbing.HashReference {
+			return branch, ref.Hash(), nil
+		}
+	}
+	if name == "" {
+		return "", plumbing.ZeroHash, fmt.Errorf("%w: no default branch", ErrRepositoryNotFound)
+	}
+	return "", plumbing.ZeroHash, fmt.Errorf("%w: branch %s", ErrRepositoryNotFound, name)
+}
+
+// clone opens the cached clone of commit, or clones the branch into the
+// cache. The branch may have moved since it was resolved; the clone then
+// holds, and is cached under, its new head.
+func (f *GitFetcher) clone(ctx context.Context, url string, branch plumbing.ReferenceName, commit plumbing.Hash, auth transport.AuthMethod) (*git.Repository, bool, error) {
+	repoDir := filepath.Join(f.cfg.CacheDir, repositoryCacheKey(url))
+	dir := filepath.Join(repoDir, commit.String())
+	lock := f.cloneLock(dir)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if repo, err := git.PlainOpen(dir); err == nil {
+		f.touch(dir)
+		return repo, true, nil
+	}
+
+	if err := os.MkdirAll(repoDir, 0o755); err != nil {
+		return nil, false, fmt.Errorf("failed to create repository cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(repoDir, ".clone-")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create repository cache: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	repo, err := git.PlainCloneContext(ctx, tmp, true, &git.CloneOptions{
+		URL:           url,
+		Auth:          auth,
+		ReferenceName: branch,
+		SingleBranch:  true,
+		Depth:         1,
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return nil, false, repositoryError(err)
+	}
+	head, err := repo.Reference(branch, true)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to resolve cloned branch: %w", err)
+	}
+	if head.Hash() != commit {
+		// Cached under the commit cloned, so analyses of the resolved commit do
+		// not read the wrong files
+		dir = filepath.Join(repoDir, head.Hash().String())
+	}
+
+	// Another replica sharing the cache may have cloned the commit meanwhile
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return nil, false, fmt.Errorf("failed to cache repository: %w", err)
+		}
+	}
+	repo, err = git.PlainOpen(dir)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open cached repository: %w", err)
+	}
+	return repo, false, nil
+}
+
+// readTree reads the files of the commit under the requested path that
+// match the include globs, skipping binary files, files over the max file
+// size and files past the size cap
+func (f *GitFetcher) readTree(repo *git.Repository, req *RepositoryFetchRequest) (*FetchedRepository, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve repository head: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit: %w", err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit tree: %w", err)
+	}
+
+	dir := strings.Trim(path.Clean("/"+req.Path), "/")
+	if dir != "" {
+		tree, err = tree.Tree(dir)
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, fmt.Errorf("%w: path %s", ErrRepositoryNotFound, req.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", req.Path, err)
+		}
+	}
+
+	fetched := &FetchedRepository{
+		Files:       []string{},
+		Content:     make(map[string]string),
+		Commit:      commit.Hash.String(),
+		Author:      commit.Author.Name,
+		Message:     strings.TrimSpace(commit.Message),
+		CommittedAt: commit.Committer.When.UTC(),
+		Skipped:     make(map[string]int),
+	}
+	err = tree.Files().ForEach(func(file *object.File) error {
+		name := path.Join(dir, file.Name)
+		if !matchesAny(req.Include, name) {
+			return nil
+		}
+		if file.Size > f.cfg.MaxFileBytes {
+			fetched.Skipped[RepositorySkipTooLarge]++
+			return nil
+		}
+		if binary, err := file.IsBinary(); err != nil {
+			return err
+		} else if binary {
+			fetched.Skipped[RepositorySkipBinary]++
+			return nil
+		}
+		if fetched.Bytes+file.Size > f.cfg.MaxTotalBytes {
+			fetched.Skipped[RepositorySkipSizeCap]++
+			fetched.Truncated = true
+			return nil
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		fetched.Files = append(fetched.Files, name)
+		fetched.Content[name] = content
+		fetched.Bytes += file.Size
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repository files: %w", err)
+	}
+	return fetched, nil
+}
+
+// matchesAny reports whether a file path, or its name, matches one of the
+// globs; every file matches no globs
+func matchesAny(globs []string, name string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneLock returns the lock serializing the cloning of a cache entry
+func (f *GitFetcher) cloneLock(dir string) *sync.Mutex {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lock, ok := f.clones[dir]
+	if !ok {
+		lock = &sync.Mutex{}
+		f.clones[dir] = lock
+	}
+	return lock
+}
+
+// touch marks a cache entry used, restarting its TTL
+func (f *GitFetcher) touch(dir string) {
+	now := f.now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		f.logger.Warn("Failed to mark cached repository used", zap.String("dir", dir), zap.Error(err))
+	}
+}
+
+// evictExpired removes the clones unused for the cache TTL. Clones being
+// fetched are kept. It runs at most once a minute.
+func (f *GitFetcher) evictExpired() {
+	now := f.now()
+	f.mu.Lock()
+	if now.Sub(f.lastEvict) < time.Minute {
+		f.mu.Unlock()
+		return
+	}
+	f.lastEvict = now
+	f.mu.Unlock()
+
+	ttl := time.Duration(f.cfg.CacheTTL) * time.Second
+	entries, _ := filepath.Glob(filepath.Join(f.cfg.CacheDir, "*", "*"))
+	for _, dir := range entries {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() || strings.HasPrefix(info.Name(), ".") || now.Sub(info.ModTime()) < ttl {
+			continue
+		}
+		lock := f.cloneLock(dir)
+		if !lock.TryLock() {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			f.logger.Warn("Failed to evict cached repository", zap.String("dir", dir), zap.Error(err))
+		}
+		f.mu.Lock()
+		delete(f.clones, dir)
+		f.mu.Unlock()
+		lock.Unlock()
+	}
+}
+
+// repositoryCacheKey names the cache directory of a repository URL
+func repositoryCacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// repositoryError maps transport errors to ErrRepositoryAuth and
+// ErrRepositoryNotFound
+func repositoryError(err error) error {
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		return fmt.Errorf("%w: %v", ErrRepositoryAuth, err)
+	case errors.Is(err, transport.ErrRepositoryNotFound), errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return fmt.Errorf("%w: %v", ErrRepositoryNotFound, err)
+	}
+	return fmt.Errorf("failed to fetch repository: %w", err)
+}
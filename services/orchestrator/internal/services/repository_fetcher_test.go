@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/encryption"
+)
+
+// setupRepositoryFixture creates a repository whose main branch holds Go
+// sources, a binary file and a large file, and whose feature branch adds a
+// file to them. It returns the repository URL.
+func setupRepositoryFixture(t *testing.T) string {
+	dir := t.TempDir()
+	repo, err := git.PlainInitWithOptions(dir, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+
+	commit := func(message string, files map[string][]byte) {
+		for name, content := range files {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0o644))
+			_, err := worktree.Add(name)
+			require.NoError(t, err)
+		}
+		_, err := worktree.Commit(message, &git.CommitOptions{Author: &object.Signature{
+			Name:  "Ada",
+			Email: "ada@example.com",
+			When:  time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		}})
+		require.NoError(t, err)
+	}
+
+	commit("Initial commit", map[string][]byte{
+		"main.go":            []byte("package main\n\nfunc main() {}\n"),
+		"README.md":          []byte("# Fixture\n"),
+		"pkg/api/handler.go": []byte("package api\n\nfunc Handle() {}\n"),
+		"pkg/api/logo.png":   {0x89, 'P', 'N', 'G', 0x00, 0x01, 0x02},
+		"pkg/data/large.go":  bytes.Repeat([]byte("// padding\n"), 200),
+	})
+	require.NoError(t, worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName("feature"),
+		Create: true,
+	}))
+	commit("Add service", map[string][]byte{
+		"pkg/api/service.go": []byte("package api\n\nfunc Serve() {}\n"),
+	})
+	require.NoError(t, worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("main")}))
+	return "file://" + dir
+}
+
+// setupRepositoryFetcher creates a fetcher with a 1KB file limit, whose
+// project has a git integration without a token and no deploy key
+func setupRepositoryFetcher(t *testing.T) *GitFetcher {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, environment_vars BLOB, secrets BLOB, deleted_at DATETIME)`,
+		`CREATE TABLE integrations (id TEXT PRIMARY KEY, project_id TEXT, name TEXT, type TEXT, provider TEXT,
+			status TEXT, config BLOB, credentials BLOB, created_at DATETIME, deleted_at DATETIME)`,
+		`INSERT INTO projects (id) VALUES ('project-1')`,
+		`INSERT INTO integrations (id, project_id, name, type, provider, status, config, credentials, created_at) VALUES
+			('integration-1', 'project-1', 'github', 'git', 'github', 'active',
+				CAST('{"host": "github.com"}' AS BLOB), CAST('{"username": "bot"}' AS BLOB), '2026-01-01')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	keys, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{1}, 32)})
+	require.NoError(t, err)
+	return NewGitFetcher(db, NewDBSecretProvider(db, encryption.NewEncryptor(keys)), &config.RepositoriesConfig{
+		CacheDir:        t.TempDir(),
+		CacheTTL:        3600,
+		MaxFileBytes:    1024,
+		MaxTotalBytes:   1024 * 1024,
+		DeployKeySecret: "GIT_DEPLOY_KEY",
+		AllowLocal:      true, // The fixtures are local
+	}, zap.NewNop())
+}
+
+func TestGitFetcher_Branches(t *testing.T) {
+	url := setupRepositoryFixture(t)
+	fetcher := setupRepositoryFetcher(t)
+	ctx := context.Background()
+
+	// The default branch; binary and large files are skipped
+	fetched, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url})
+	require.NoError(t, err)
+	assert.Equal(t, "main", fetched.Branch)
+	assert.Equal(t, []string{"README.md", "main.go", "pkg/api/handler.go"}, fetched.Files)
+	assert.Equal(t, "package main\n\nfunc main() {}\n", fetched.Content["main.go"])
+	assert.Equal(t, map[string]int{RepositorySkipBinary: 1, RepositorySkipTooLarge: 1}, fetched.Skipped)
+	assert.Equal(t, "Ada", fetched.Author)
+	assert.Equal(t, "Initial commit", fetched.Message)
+	assert.Equal(t, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), fetched.CommittedAt)
+	assert.Len(t, fetched.Commit, 40)
+	assert.False(t, fetched.Cached)
+
+	// A named branch
+	feature, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url, Branch: "feature"})
+	require.NoError(t, err)
+	assert.Equal(t, "feature", feature.Branch)
+	assert.Equal(t, "Add service", feature.Message)
+	assert.NotEqual(t, fetched.Commit, feature.Commit)
+	assert.Contains(t, feature.Files, "pkg/api/service.go")
+
+	_, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url, Branch: "missing"})
+	assert.ErrorIs(t, err, ErrRepositoryNotFound)
+}
+
+func TestGitFetcher_Filtering(t *testing.T) {
+	url := setupRepositoryFixture(t)
+	fetcher := setupRepositoryFetcher(t)
+	ctx := context.Background()
+
+	// Path and globs
+	fetched, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{
+		ProjectID: "project-1",
+		URL:       url,
+		Branch:    "feature",
+		Path:      "pkg/api",
+		Include:   []string{"*.go"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pkg/api/handler.go", "pkg/api/service.go"}, fetched.Files)
+	assert.Empty(t, fetched.Skipped)
+
+	// Globs match full paths too
+	fetched, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url, Include: []string{"pkg/*/*.go"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pkg/api/handler.go"}, fetched.Files)
+
+	_, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url, Path: "missing"})
+	assert.ErrorIs(t, err, ErrRepositoryNotFound)
+
+	// Files past the size cap are left out
+	fetcher.cfg.MaxTotalBytes = 40
+	fetched, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"README.md", "main.go"}, fetched.Files)
+	assert.Equal(t, int64(39), fetched.Bytes)
+	assert.Equal(t, 1, fetched.Skipped[RepositorySkipSizeCap])
+	assert.True(t, fetched.Truncated)
+}
+
+func TestGitFetcher_Auth(t *testing.T) {
+	fetcher := setupRepositoryFetcher(t)
+	ctx := context.Background()
+
+	// No deploy key
+	_, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: "git@github.com:acme/app.git"})
+	assert.ErrorIs(t, err, ErrRepositoryAuth)
+
+	// An invalid deploy key
+	require.NoError(t, fetcher.secrets.SetSecret(ctx, "project-1", "GIT_DEPLOY_KEY", "not a key"))
+	_, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: "ssh://git@github.com/acme/app.git"})
+	assert.ErrorIs(t, err, ErrRepositoryAuth)
+
+	// The integration for the host has no token
+	_, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: "https://github.com/acme/app.git"})
+	assert.ErrorIs(t, err, ErrRepositoryAuth)
+
+	// An unknown integration
+	_, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{
+		ProjectID:   "project-1",
+		URL:         "https://gitlab.com/acme/app.git",
+		Integration: "gitlab",
+	})
+	assert.ErrorIs(t, err, ErrRepositoryAuth)
+
+	// A named integration is not sent to another host
+	_, err = fetcher.Fetch(ctx, &RepositoryFetchRequest{
+		ProjectID:   "project-1",
+		URL:         "https://attacker.example.com/acme/app.git",
+		Integration: "github",
+	})
+	assert.ErrorIs(t, err, ErrRepositoryAuth)
+	assert.ErrorContains(t, err, `not "attacker.example.com"`)
+}
+
+func TestGitFetcher_URLs(t *testing.T) {
+	url := setupRepositoryFixture(t)
+	fetcher := setupRepositoryFetcher(t)
+	ctx := context.Background()
+
+	// Credentials never travel in the clear
+	for _, plain := range []string{"http://github.com/acme/app.git", "git://github.com/acme/app.git"} {
+		_, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: plain, Integration: "github"})
+		assert.ErrorIs(t, err, ErrRepositoryURLNotAllowed, plain)
+	}
+
+	// Hosts without an integration are only fetched when allowed
+	fetcher.cfg.AllowedHosts = []string{"GitLab.com"}
+	auth, err := fetcher.auth(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: "https://gitlab.com/acme/app.git"})
+	require.NoError(t, err)
+	assert.Nil(t, auth)
+	for _, internal := range []string{"https://10.0.0.5/acme/app.git", "https://metadata.internal/computeMetadata/v1"} {
+		_, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: internal})
+		assert.ErrorIs(t, err, ErrRepositoryURLNotAllowed, internal)
+	}
+
+	// The worker's disk is off limits unless allowed
+	fetcher.cfg.AllowLocal = false
+	for _, local := range []string{url, strings.TrimPrefix(url, "file://")} {
+		_, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: local})
+		assert.ErrorIs(t, err, ErrRepositoryURLNotAllowed, local)
+	}
+}
+
+func TestGitFetcher_Cache(t *testing.T) {
+	url := setupRepositoryFixture(t)
+	fetcher := setupRepositoryFetcher(t)
+	ctx := context.Background()
+	now := time.Now()
+	fetcher.now = func() time.Time { return now }
+
+	first, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url})
+	require.NoError(t, err)
+	assert.False(t, first.Cached)
+
+	// The same commit is read from its clone
+	second, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url, Path: "pkg"})
+	require.NoError(t, err)
+	assert.True(t, second.Cached)
+	assert.Equal(t, first.Commit, second.Commit)
+	assert.Equal(t, []string{"pkg/api/handler.go"}, second.Files)
+
+	clone := filepath.Join(fetcher.cfg.CacheDir, repositoryCacheKey(url), first.Commit)
+	assert.DirExists(t, clone)
+
+	// Clones unused for the TTL are evicted
+	now = now.Add(2 * time.Hour)
+	fetcher.evictExpired()
+	assert.NoDirExists(t, clone)
+
+	third, err := fetcher.Fetch(ctx, &RepositoryFetchRequest{ProjectID: "project-1", URL: url})
+	require.NoError(t, err)
+	assert.False(t, third.Cached)
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/deadline"
+	"orchestrator/internal/models"
+)
+
+// deadlineRecordingServer serves empty responses, recording the deadline
+// header of each request it receives
+type deadlineRecordingServer struct {
+	calls   atomic.Int32
+	mu      sync.Mutex
+	headers []string
+}
+
+func newDeadlineRecordingServer(t *testing.T, response interface{}) (*deadlineRecordingServer, *httptest.Server) {
+	recorder := &deadlineRecordingServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.calls.Add(1)
+		recorder.mu.Lock()
+		recorder.headers = append(recorder.headers, r.Header.Get(deadline.Header))
+		recorder.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	t.Cleanup(server.Close)
+	return recorder, server
+}
+
+func (s *deadlineRecordingServer) lastDeadline(t *testing.T) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	require.NotEmpty(t, s.headers)
+	header := s.headers[len(s.headers)-1]
+	require.NotEmpty(t, header, "the deadline is passed on")
+	d, err := deadline.Parse(header, time.Now())
+	require.NoError(t, err)
+	return d
+}
+
+// expiredContext returns a context whose deadline has passed
+func expiredContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Millisecond))
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestAgentClient_PropagatesRequestDeadline(t *testing.T) {
+	manager, server := newDeadlineRecordingServer(t, AgentList{Agents: []Agent{{ID: "agent-1"}}, TotalCount: 1})
+	agentClient, err := NewAgentClient(&config.AgentManagerConfig{BaseURL: server.URL, HTTPTimeout: 5}, zap.NewNop())
+	require.NoError(t, err)
+
+	requestDeadline := time.Now().Add(2 * time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), requestDeadline)
+	defer cancel()
+	_, err = agentClient.ListAgents(ctx, nil)
+	require.NoError(t, err)
+	assert.True(t, manager.lastDeadline(t).Equal(requestDeadline), "the agent manager gets the time the caller still waits")
+
+	// Calls nobody waits for anymore are not made
+	_, err = agentClient.ListAgents(expiredContext(t), nil)
+	assert.ErrorIs(t, err, deadline.ErrExhausted)
+	assert.Equal(t, int32(1), manager.calls.Load())
+}
+
+// deadlineRecordingIntentTransport records the deadline of the contexts it
+// is called with
+type deadlineRecordingIntentTransport struct {
+	failingIntentTransport
+	deadlines []time.Time
+}
+
+func (d *deadlineRecordingIntentTransport) AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error) {
+	callDeadline, _ := ctx.Deadline()
+	d.deadlines = append(d.deadlines, callDeadline)
+	return &AnalyzeIntentResponse{IntentType: "code_generation"}, nil
+}
+
+func TestIntentClient_AnalyzeIntentKeepsRequestDeadline(t *testing.T) {
+	transport := &deadlineRecordingIntentTransport{}
+	intentClient := newIntentClientWithTransport(&config.IntentAPIConfig{Timeout: 30}, transport, zap.NewNop())
+
+	requestDeadline := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), requestDeadline)
+	defer cancel()
+	_, err := intentClient.AnalyzeIntent(ctx, &AnalyzeIntentRequest{Content: "build an API"})
+	require.NoError(t, err)
+	require.Len(t, transport.deadlines, 1)
+	assert.True(t, transport.deadlines[0].Equal(requestDeadline), "the configured timeout does not extend the request's")
+
+	// Without a request deadline the configured timeout applies
+	_, err = intentClient.AnalyzeIntent(context.Background(), &AnalyzeIntentRequest{Content: "build an API"})
+	require.NoError(t, err)
+	require.Len(t, transport.deadlines, 2)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), transport.deadlines[1], time.Second)
+
+	_, err = intentClient.AnalyzeIntent(expiredContext(t), &AnalyzeIntentRequest{Content: "build an API"})
+	assert.ErrorIs(t, err, deadline.ErrExhausted)
+	assert.Len(t, transport.deadlines, 2, "exhausted requests do not reach the intent processor")
+}
+
+func TestHTTPIntentTransport_PropagatesRequestDeadline(t *testing.T) {
+	processor, server := newDeadlineRecordingServer(t, AnalyzeIntentResponse{IntentType: "code_generation"})
+	transport, err := newHTTPIntentTransport(&config.IntentAPIConfig{Transport: "http", HTTPBaseURL: server.URL, Timeout: 30})
+	require.NoError(t, err)
+	intentClient := newIntentClientWithTransport(&config.IntentAPIConfig{Transport: "http", Timeout: 30}, transport, zap.NewNop())
+
+	requestDeadline := time.Now().Add(2 * time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), requestDeadline)
+	defer cancel()
+	_, err = intentClient.AnalyzeIntent(ctx, &AnalyzeIntentRequest{Content: "build an API"})
+	require.NoError(t, err)
+	assert.True(t, processor.lastDeadline(t).Equal(requestDeadline))
+}
+
+func TestProjectService_StatsBatchStopsAtRequestDeadline(t *testing.T) {
+	db, service := setupProjectStatsTest(t)
+
+	// Reading the projects uses up the request's time; the tables of the
+	// stats families are recorded if they are still queried
+	var mu sync.Mutex
+	var queried []string
+	require.NoError(t, db.Callback().Query().After("gorm:query").Register("test:deadline", func(tx *gorm.DB) {
+		if tx.Statement.Table == "projects" {
+			<-tx.Statement.Context.Done()
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		queried = append(queried, tx.Statement.Table)
+	}))
+	require.NoError(t, db.Callback().Row().After("gorm:row").Register("test:deadline", func(tx *gorm.DB) {
+		mu.Lock()
+		defer mu.Unlock()
+		queried = append(queried, tx.Statement.Table)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := service.GetProjectStatsBatch(ctx, []string{"project-1", "project-2"}, ProjectViewer{All: true})
+	assert.ErrorIs(t, err, deadline.ErrExhausted)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, queried, "the grouped stats queries are not run")
+}
+
+func TestWorkflowEngine_StartWorkflowDeadlineBoundsEnqueue(t *testing.T) {
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	request := func() *StartWorkflowRequest {
+		return &StartWorkflowRequest{Name: "tasks", Type: string(models.WorkflowTypeExecution), ProjectID: "project-1", UserID: "alice"}
+	}
+	countWorkflows := func() int64 {
+		var count int64
+		require.NoError(t, db.Model(&models.Workflow{}).Count(&count).Error)
+		return count
+	}
+
+	// Exhausted requests neither store nor enqueue a workflow
+	_, err := engine.StartWorkflow(expiredContext(t), request())
+	assert.True(t, deadline.Exhausted(err), "%v", err)
+	assert.Zero(t, countWorkflows())
+	temporalClient.AssertNotCalled(t, "ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// The enqueue gets the request's deadline; the workflow its own timeout
+	requestDeadline := time.Now().Add(5 * time.Second)
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.MatchedBy(func(ctx context.Context) bool {
+		d, ok := ctx.Deadline()
+		return ok && d.Equal(requestDeadline)
+	}), mock.MatchedBy(func(options client.StartWorkflowOptions) bool {
+		return options.WorkflowExecutionTimeout > 5*time.Second
+	}), mock.Anything, mock.Anything).Return(run, nil).Once()
+	ctx, cancel := context.WithDeadline(context.Background(), requestDeadline)
+	defer cancel()
+	response, err := engine.StartWorkflow(ctx, request())
+	require.NoError(t, err)
+	assert.Equal(t, string(models.WorkflowStatusRunning), response.Status)
+
+	// An enqueue outliving the request leaves the workflow for the reaper
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { <-args.Get(0).(context.Context).Done() }).
+		Return(nil, errors.New("context deadline exceeded")).Once()
+	short, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = engine.StartWorkflow(short, request())
+	assert.ErrorIs(t, err, deadline.ErrExhausted)
+	var pending models.Workflow
+	require.NoError(t, db.Where("id <> ?", response.WorkflowID).First(&pending).Error)
+	assert.Equal(t, models.WorkflowStatusPending, pending.Status, "the workflow may have started; it is not marked failed")
+	temporalClient.AssertExpectations(t)
+}
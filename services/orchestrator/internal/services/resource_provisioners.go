@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"orchestrator/internal/models"
+)
+
+// Resource providers available out of the box
+const (
+	ResourceProviderLocal  = "local"
+	ResourceProviderDocker = "docker"
+)
+
+// ResourceState is how far a provider has got with a resource
+type ResourceState struct {
+	Ready      bool                   `json:"ready"`
+	Failed     bool                   `json:"failed"`
+	Error      string                 `json:"error,omitempty"`
+	Connection map[string]interface{} `json:"connection,omitempty"` // How to reach the resource once ready
+}
+
+// ResourceProvisioner creates and removes resources at one provider.
+// Operations are keyed by the resource ID, so repeating one, e.g. when an
+// activity is retried, does not create or remove anything twice.
+type ResourceProvisioner interface {
+	// Provision starts creating a resource; it is a no-op when the resource
+	// already exists
+	Provision(ctx context.Context, resource *models.Resource) error
+	// State reports whether a resource is ready or has failed
+	State(ctx context.Context, resource *models.Resource) (*ResourceState, error)
+	// Terminate removes a resource; it succeeds when the resource is gone
+	Terminate(ctx context.Context, resource *models.Resource) error
+}
+
+// localProvisioner provides resources that need nothing created, e.g. for
+// local runs and tests
+type localProvisioner struct{}
+
+// NewLocalProvisioner creates a provisioner whose resources are ready at once
+func NewLocalProvisioner() ResourceProvisioner {
+	return localProvisioner{}
+}
+
+func (localProvisioner) Provision(ctx context.Context, resource *models.Resource) error {
+	return nil
+}
+
+func (localProvisioner) State(ctx context.Context, resource *models.Resource) (*ResourceState, error) {
+	return &ResourceState{
+		Ready:      true,
+		Connection: map[string]interface{}{"provider": ResourceProviderLocal, "name": resource.Name},
+	}, nil
+}
+
+func (localProvisioner) Terminate(ctx context.Context, resource *models.Resource) error {
+	return nil
+}
+
+// DockerResourceConfig is the config of a resource provided by Docker
+type DockerResourceConfig struct {
+	Image string            `json:"image"`
+	Env   map[string]string `json:"env,omitempty"`
+	Ports []string          `json:"ports,omitempty"` // Container ports to publish on random host ports
+	Args  []string          `json:"args,omitempty"`  // Arguments passed to the image's entrypoint
+}
+
+// commandRunner runs a command and returns its combined output
+type commandRunner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// dockerProvisioner runs each resource as a container on the local Docker
+// daemon, for development
+type dockerProvisioner struct {
+	run commandRunner
+}
+
+// NewDockerProvisioner creates a provisioner running resources as containers
+// through the docker CLI
+func NewDockerProvisioner() ResourceProvisioner {
+	return &dockerProvisioner{run: runCommand}
+}
+
+// dockerContainerName names the container of a resource
+func dockerContainerName(resource *models.Resource) string {
+	return "uos-resource-" + resource.ID
+}
+
+// dockerContainer is the part of docker container inspect read here
+type dockerContainer struct {
+	State struct {
+		Status   string `json:"Status"`
+		Running  bool   `json:"Running"`
+		ExitCode int    `json:"ExitCode"`
+		Error    string `json:"Error"`
+		Health   *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// inspect returns the container of a resource, or nil when there is none
+func (p *dockerProvisioner) inspect(ctx context.Context, resource *models.Resource) (*dockerContainer, error) {
+	output, err := p.run(ctx, "docker", "container", "inspect", "--format", "{{json .}}", dockerContainerName(resource))
+	if err != nil {
+		if strings.Contains(strings.ToLower(string(output)), "no such container") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to inspect container: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	var container dockerContainer
+	if err := json.Unmarshal(output, &container); err != nil {
+		return nil, fmt.Errorf("failed to decode container: %w", err)
+	}
+	return &container, nil
+}
+
+func (p *dockerProvisioner) Provision(ctx context.Context, resource *models.Resource) error {
+	var cfg DockerResourceConfig
+	if len(resource.Config) > 0 {
+		if err := json.Unmarshal(resource.Config, &cfg); err != nil {
+			return fmt.Errorf("invalid docker resource config: %w", err)
+		}
+	}
+	if cfg.Image == "" {
+		return fmt.Errorf("docker resources need an image")
+	}
+
+	container, err := p.inspect(ctx, resource)
+	if err != nil {
+		return err
+	}
+	if container != nil {
+		return nil
+	}
+
+	args := []string{"run", "--detach",
+		"--name", dockerContainerName(resource),
+		"--label", "uos.resource_id=" + resource.ID,
+		"--label", "uos.project_id=" + resource.ProjectID,
+	}
+	envNames := make([]string, 0, len(cfg.Env))
+	for name := range cfg.Env {
+		envNames = append(envNames, name)
+	}
+	sort.Strings(envNames)
+	for _, name := range envNames {
+		args = append(args, "--env", name+"="+cfg.Env[name])
+	}
+	for _, port := range cfg.Ports {
+		args = append(args, "--publish", port)
+	}
+	args = append(args, cfg.Image)
+	args = append(args, cfg.Args...)
+
+	if output, err := p.run(ctx, "docker", args...); err != nil {
+		return fmt.Errorf("failed to start container: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (p *dockerProvisioner) State(ctx context.Context, resource *models.Resource) (*ResourceState, error) {
+	container, err := p.inspect(ctx, resource)
+	if err != nil {
+		return nil, err
+	}
+	if container == nil {
+		return &ResourceState{Failed: true, Error: "container not found"}, nil
+	}
+
+	state := container.State
+	switch {
+	case state.Status == "exited" || state.Status == "dead":
+		message := fmt.Sprintf("container %s with exit code %d", state.Status, state.ExitCode)
+		if state.Error != "" {
+			message += ": " + state.Error
+		}
+		return &ResourceState{Failed: true, Error: message}, nil
+	case state.Health != nil && state.Health.Status == "unhealthy":
+		return &ResourceState{Failed: true, Error: "container is unhealthy"}, nil
+	case !state.Running || (state.Health != nil && state.Health.Status != "healthy"):
+		return &ResourceState{}, nil
+	}
+
+	ports := make(map[string]interface{})
+	for port, bindings := range container.NetworkSettings.Ports {
+		if len(bindings) > 0 {
+			ports[port] = bindings[0].HostIP + ":" + bindings[0].HostPort
+		}
+	}
+	return &ResourceState{
+		Ready: true,
+		Connection: map[string]interface{}{
+			"provider":  ResourceProviderDocker,
+			"container": dockerContainerName(resource),
+			"ports":     ports,
+		},
+	}, nil
+}
+
+func (p *dockerProvisioner) Terminate(ctx context.Context, resource *models.Resource) error {
+	output, err := p.run(ctx, "docker", "rm", "--force", dockerContainerName(resource))
+	if err != nil && !strings.Contains(strings.ToLower(string(output)), "no such container") {
+		return fmt.Errorf("failed to remove container: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrUnknownResourceProvider is returned for resources of a provider
+	// that is not enabled
+	ErrUnknownResourceProvider = errors.New("unknown resource provider")
+	// ErrInvalidResourceStatus is returned when filtering by an unknown status
+	ErrInvalidResourceStatus = errors.New("invalid resource status")
+	// ErrResourceProvisioning is returned when terminating a resource whose
+	// provisioning has not finished
+	ErrResourceProvisioning = errors.New("resource is still provisioning")
+)
+
+// Names of the workflows that provision and terminate resources
+const (
+	ResourceProvisioningWorkflow = "ResourceProvisioningWorkflow"
+	ResourceTerminationWorkflow  = "ResourceTerminationWorkflow"
+)
+
+// ResourceWorkflowInput is the input of the resource workflows
+type ResourceWorkflowInput struct {
+	ResourceID   string        `json:"resource_id"`
+	PollInterval time.Duration `json:"poll_interval"` // Between checks of a provisioning resource
+	Timeout      time.Duration `json:"timeout"`       // For the resource to become ready
+}
+
+// CreateResourceRequest describes a resource to provision for a project
+type CreateResourceRequest struct {
+	Name     string          `json:"name" binding:"required"`
+	Type     string          `json:"type" binding:"required"`
+	Provider string          `json:"provider"` // Defaults to resources.default_provider
+	Region   string          `json:"region"`
+	Config   json.RawMessage `json:"config"` // Provider specific, e.g. the image of a docker resource
+	Limits   json.RawMessage `json:"limits"`
+}
+
+var resourceStatuses = map[models.ResourceStatus]bool{
+	models.ResourceStatusProvisioning: true,
+	models.ResourceStatusReady:        true,
+	models.ResourceStatusFailed:       true,
+	models.ResourceStatusTerminating:  true,
+	models.ResourceStatusTerminated:   true,
+}
+
+// ResourceService manages project resources. Provisioning and termination
+// run as Temporal workflows, one per resource and operation.
+type ResourceService struct {
+	db             *gorm.DB
+	temporalClient client.Client
+	taskQueue      string
+	providers      map[string]ResourceProvisioner
+	config         *config.ResourcesConfig
+	logger         *zap.Logger
+}
+
+// NewResourceService creates a resource service accepting resources of the
+// given providers
+func NewResourceService(
+	db *gorm.DB,
+	temporalClient client.Client,
+	taskQueue string,
+	providers map[string]ResourceProvisioner,
+	cfg *config.ResourcesConfig,
+	logger *zap.Logger,
+) *ResourceService {
+	return &ResourceService{
+		db:             db,
+		temporalClient: temporalClient,
+		taskQueue:      taskQueue,
+		providers:      providers,
+		config:         cfg,
+		logger:         logger,
+	}
+}
+
+// CreateResource records a resource in the provisioning state and starts
+// the workflow provisioning it
+func (s *ResourceService) CreateResource(ctx context.Context, projectID, createdBy string, req *CreateResourceRequest) (*models.Resource, error) {
+	provider := req.Provider
+	if provider == "" {
+		provider = s.config.DefaultProvider
+	}
+	if _, ok := s.providers[provider]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownResourceProvider, provider)
+	}
+
+	var project models.Project
+	if err := s.db.WithContext(ctx).Select("id").First(&project, "id = ?", projectID).Error; err != nil {
+		return nil, fmt.Errorf("project not found: %w", err)
+	}
+
+	resource := &models.Resource{
+		ID:        uuid.NewString(),
+		ProjectID: projectID,
+		Name:      req.Name,
+		Type:      req.Type,
+		Provider:  provider,
+		Region:    req.Region,
+		Status:    models.ResourceStatusProvisioning,
+		Config:    req.Config,
+		Limits:    req.Limits,
+		CreatedBy: createdBy,
+	}
+	if err := s.db.WithContext(ctx).Create(resource).Error; err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+
+	if err := s.startWorkflow(ctx, ResourceProvisioningWorkflow, "resource-provision-", resource); err != nil {
+		s.fail(ctx, resource, err)
+		return nil, err
+	}
+
+	s.logger.Info("Provisioning resource",
+		zap.String("resource_id", resource.ID),
+		zap.String("project_id", projectID),
+		zap.String("provider", provider))
+	return resource, nil
+}
+
+// ListResources returns a project's resources, newest first, optionally
+// only those in the given statuses
+func (s *ResourceService) ListResources(ctx context.Context, projectID string, statuses []models.ResourceStatus) ([]models.Resource, error) {
+	query := s.db.WithContext(ctx).Where("project_id = ?", projectID)
+	if len(statuses) > 0 {
+		for _, status := range statuses {
+			if !resourceStatuses[status] {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidResourceStatus, status)
+			}
+		}
+		query = query.Where("status IN ?", statuses)
+	}
+
+	var resources []models.Resource
+	if err := query.Order("created_at DESC").Find(&resources).Error; err != nil {
+		return nil, fmt.Errorf("failed to list resources: %w", err)
+	}
+	return resources, nil
+}
+
+// GetResource returns a resource of a project
+func (s *ResourceService) GetResource(ctx context.Context, projectID, resourceID string) (*models.Resource, error) {
+	var resource models.Resource
+	if err := s.db.WithContext(ctx).
+		First(&resource, "id = ? AND project_id = ?", resourceID, projectID).Error; err != nil {
+		return nil, fmt.Errorf("resource not found: %w", err)
+	}
+	return &resource, nil
+}
+
+// TerminateResource starts the workflow removing a resource. Resources
+// already terminating or terminated are returned unchanged.
+func (s *ResourceService) TerminateResource(ctx context.Context, projectID, resourceID string) (*models.Resource, error) {
+	resource, err := s.GetResource(ctx, projectID, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resource.Status {
+	case models.ResourceStatusTerminating, models.ResourceStatusTerminated:
+		return resource, nil
+	case models.ResourceStatusProvisioning:
+		return nil, ErrResourceProvisioning
+	}
+
+	// Only one request moves the resource on from the status it was read in
+	result := s.db.WithContext(ctx).Model(&models.Resource{}).
+		Where("id = ? AND status = ?", resource.ID, resource.Status).
+		Updates(map[string]interface{}{"status": models.ResourceStatusTerminating, "error": ""})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update resource: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return s.GetResource(ctx, projectID, resourceID)
+	}
+	resource.Status = models.ResourceStatusTerminating
+	resource.Error = ""
+
+	if err := s.startWorkflow(ctx, ResourceTerminationWorkflow, "resource-terminate-", resource); err != nil {
+		s.fail(ctx, resource, err)
+		return nil, err
+	}
+
+	s.logger.Info("Terminating resource",
+		zap.String("resource_id", resource.ID),
+		zap.String("project_id", projectID))
+	return resource, nil
+}
+
+// startWorkflow starts a resource workflow. Its ID is derived from the
+// resource, so the operation runs at most once at a time per resource.
+func (s *ResourceService) startWorkflow(ctx context.Context, workflowName, idPrefix string, resource *models.Resource) error {
+	input := ResourceWorkflowInput{
+		ResourceID:   resource.ID,
+		PollInterval: time.Duration(s.config.PollInterval) * time.Second,
+		Timeout:      time.Duration(s.config.ProvisionTimeout) * time.Second,
+	}
+	_, err := s.temporalClient.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:                    idPrefix + resource.ID,
+		TaskQueue:             s.taskQueue,
+		WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}, workflowName, input)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", workflowName, err)
+	}
+	return nil
+}
+
+// fail marks a resource failed when its workflow could not be started
+func (s *ResourceService) fail(ctx context.Context, resource *models.Resource, cause error) {
+	if err := s.db.WithContext(ctx).Model(&models.Resource{}).Where("id = ?", resource.ID).
+		Updates(map[string]interface{}{"status": models.ResourceStatusFailed, "error": cause.Error()}).Error; err != nil {
+		s.logger.Error("Failed to mark resource failed",
+			zap.String("resource_id", resource.ID),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+func setupResourceTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+
+	stmt := &gorm.Statement{DB: db}
+	require.NoError(t, stmt.Parse(&models.Resource{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+	require.NoError(t, db.Migrator().CreateTable(&models.Resource{}))
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO projects (id) VALUES ('project-1')`).Error)
+	return db
+}
+
+func newTestResourceService(db *gorm.DB, temporalClient client.Client) *ResourceService {
+	return NewResourceService(db, temporalClient, "test-queue",
+		map[string]ResourceProvisioner{ResourceProviderLocal: NewLocalProvisioner()},
+		&config.ResourcesConfig{DefaultProvider: ResourceProviderLocal, PollInterval: 5, ProvisionTimeout: 600},
+		zap.NewNop())
+}
+
+// expectResourceWorkflow expects one start of a resource workflow
+func expectResourceWorkflow(temporalClient *mocks.Client, workflowName, workflowID string, err error) {
+	temporalClient.On("ExecuteWorkflow", mock.Anything,
+		mock.MatchedBy(func(options client.StartWorkflowOptions) bool {
+			return options.ID == workflowID && options.TaskQueue == "test-queue"
+		}),
+		workflowName,
+		mock.MatchedBy(func(input ResourceWorkflowInput) bool {
+			return strings.HasSuffix(workflowID, input.ResourceID) && input.PollInterval > 0
+		}),
+	).Return(new(mocks.WorkflowRun), err).Once()
+}
+
+func TestResourceService_CreateStartsProvisioning(t *testing.T) {
+	db := setupResourceTestDB(t)
+	temporalClient := new(mocks.Client)
+	service := newTestResourceService(db, temporalClient)
+	ctx := context.Background()
+
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, ResourceProvisioningWorkflow, mock.Anything).
+		Return(new(mocks.WorkflowRun), nil).Once()
+	resource, err := service.CreateResource(ctx, "project-1", "user-1", &CreateResourceRequest{Name: "cache", Type: "redis"})
+	require.NoError(t, err)
+	assert.Equal(t, models.ResourceStatusProvisioning, resource.Status)
+	assert.Equal(t, ResourceProviderLocal, resource.Provider)
+	temporalClient.AssertExpectations(t)
+
+	startOptions := temporalClient.Calls[0].Arguments.Get(1).(client.StartWorkflowOptions)
+	assert.Equal(t, "resource-provision-"+resource.ID, startOptions.ID)
+
+	_, err = service.CreateResource(ctx, "project-1", "user-1", &CreateResourceRequest{Name: "vm", Type: "vm", Provider: "aws"})
+	assert.ErrorIs(t, err, ErrUnknownResourceProvider)
+	_, err = service.CreateResource(ctx, "project-2", "user-1", &CreateResourceRequest{Name: "cache", Type: "redis"})
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// A workflow that cannot be started leaves the resource failed
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, ResourceProvisioningWorkflow, mock.Anything).
+		Return(nil, errors.New("temporal unavailable")).Once()
+	_, err = service.CreateResource(ctx, "project-1", "user-1", &CreateResourceRequest{Name: "queue", Type: "rabbitmq"})
+	require.Error(t, err)
+
+	failed, err := service.ListResources(ctx, "project-1", []models.ResourceStatus{models.ResourceStatusFailed})
+	require.NoError(t, err)
+	require.Len(t, failed, 1)
+	assert.Equal(t, "queue", failed[0].Name)
+	assert.Contains(t, failed[0].Error, "temporal unavailable")
+}
+
+func TestResourceService_ListAndTerminate(t *testing.T) {
+	db := setupResourceTestDB(t)
+	temporalClient := new(mocks.Client)
+	service := newTestResourceService(db, temporalClient)
+	ctx := context.Background()
+
+	for id, status := range map[string]models.ResourceStatus{
+		"resource-ready":        models.ResourceStatusReady,
+		"resource-failed":       models.ResourceStatusFailed,
+		"resource-provisioning": models.ResourceStatusProvisioning,
+	} {
+		require.NoError(t, db.Create(&models.Resource{
+			ID: id, ProjectID: "project-1", Name: id, Type: "database", Provider: ResourceProviderLocal, Status: status,
+		}).Error)
+	}
+
+	all, err := service.ListResources(ctx, "project-1", nil)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+	active, err := service.ListResources(ctx, "project-1",
+		[]models.ResourceStatus{models.ResourceStatusReady, models.ResourceStatusProvisioning})
+	require.NoError(t, err)
+	assert.Len(t, active, 2)
+	_, err = service.ListResources(ctx, "project-1", []models.ResourceStatus{"running"})
+	assert.ErrorIs(t, err, ErrInvalidResourceStatus)
+
+	// Terminating is started once; repeated requests return the resource
+	expectResourceWorkflow(temporalClient, ResourceTerminationWorkflow, "resource-terminate-resource-ready", nil)
+	for i := 0; i < 2; i++ {
+		resource, err := service.TerminateResource(ctx, "project-1", "resource-ready")
+		require.NoError(t, err)
+		assert.Equal(t, models.ResourceStatusTerminating, resource.Status)
+	}
+	temporalClient.AssertExpectations(t)
+
+	// Failed resources can be cleaned up, provisioning ones not yet
+	expectResourceWorkflow(temporalClient, ResourceTerminationWorkflow, "resource-terminate-resource-failed", nil)
+	_, err = service.TerminateResource(ctx, "project-1", "resource-failed")
+	require.NoError(t, err)
+	_, err = service.TerminateResource(ctx, "project-1", "resource-provisioning")
+	assert.ErrorIs(t, err, ErrResourceProvisioning)
+	_, err = service.TerminateResource(ctx, "project-2", "resource-ready")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	temporalClient.AssertExpectations(t)
+}
+
+// fakeDocker answers docker CLI calls from the containers it has started
+type fakeDocker struct {
+	containers map[string]string // Inspect output by container name
+	calls      [][]string
+}
+
+func (d *fakeDocker) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	d.calls = append(d.calls, args)
+	switch args[0] {
+	case "container":
+		if output, ok := d.containers[args[len(args)-1]]; ok {
+			return []byte(output), nil
+		}
+		return []byte("Error: No such container: " + args[len(args)-1]), errors.New("exit status 1")
+	case "run":
+		d.containers[args[3]] = `{"State": {"Status": "running", "Running": true},
+			"NetworkSettings": {"Ports": {"5432/tcp": [{"HostIp": "127.0.0.1", "HostPort": "49153"}]}}}`
+		return []byte("container-id"), nil
+	case "rm":
+		if _, ok := d.containers[args[len(args)-1]]; !ok {
+			return []byte("Error: No such container: " + args[len(args)-1]), errors.New("exit status 1")
+		}
+		delete(d.containers, args[len(args)-1])
+		return nil, nil
+	}
+	return nil, errors.New("unexpected command")
+}
+
+func TestDockerProvisioner_IsKeyedByResource(t *testing.T) {
+	docker := &fakeDocker{containers: make(map[string]string)}
+	provisioner := &dockerProvisioner{run: docker.run}
+	ctx := context.Background()
+
+	cfg, err := json.Marshal(DockerResourceConfig{
+		Image: "postgres:16",
+		Env:   map[string]string{"POSTGRES_PASSWORD": "dev"},
+		Ports: []string{"5432"},
+	})
+	require.NoError(t, err)
+	resource := &models.Resource{ID: "resource-1", ProjectID: "project-1", Config: cfg}
+
+	// Provisioning twice starts one container
+	require.NoError(t, provisioner.Provision(ctx, resource))
+	require.NoError(t, provisioner.Provision(ctx, resource))
+	var runs [][]string
+	for _, call := range docker.calls {
+		if call[0] == "run" {
+			runs = append(runs, call)
+		}
+	}
+	require.Len(t, runs, 1)
+	assert.Equal(t, []string{"run", "--detach", "--name", "uos-resource-resource-1",
+		"--label", "uos.resource_id=resource-1", "--label", "uos.project_id=project-1",
+		"--env", "POSTGRES_PASSWORD=dev", "--publish", "5432", "postgres:16"}, runs[0])
+
+	state, err := provisioner.State(ctx, resource)
+	require.NoError(t, err)
+	assert.True(t, state.Ready)
+	assert.Equal(t, map[string]interface{}{"5432/tcp": "127.0.0.1:49153"}, state.Connection["ports"])
+
+	// Terminating a removed container succeeds
+	require.NoError(t, provisioner.Terminate(ctx, resource))
+	require.NoError(t, provisioner.Terminate(ctx, resource))
+	state, err = provisioner.State(ctx, resource)
+	require.NoError(t, err)
+	assert.True(t, state.Failed)
+
+	assert.Error(t, provisioner.Provision(ctx, &models.Resource{ID: "resource-2"}))
+}
@@ -0,0 +1,416 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+var (
+	resultsCompactedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_results_compacted_total",
+		Help: "Task execution workflow results compacted, by workflow status",
+	}, []string{"status"})
+	resultCompactionSavedBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_result_compaction_saved_bytes_total",
+		Help: "Bytes removed from workflow outputs by result compaction",
+	})
+)
+
+// compactedStatuses are the terminal statuses whose results are compacted as
+// soon as the compactor sees them
+var compactedStatuses = []models.WorkflowStatus{
+	models.WorkflowStatusCompleted,
+	models.WorkflowStatusCancelled,
+}
+
+// failedStatuses are the terminal statuses whose results keep their full
+// detail, for debugging, until failed_after has passed
+var failedStatuses = []models.WorkflowStatus{
+	models.WorkflowStatusFailed,
+	models.WorkflowStatusTimedOut,
+	models.WorkflowStatusTerminated,
+}
+
+// ResultCompactor compacts the results of finished task execution workflows.
+// Their aggregate result keeps the full output and artifact contents of
+// every task; once the workflow is finished only the status, timing, error
+// summary and artifact references of each task stay in the workflow's
+// output. The output as it was is archived, gzip-compressed, in artifact
+// storage and recorded as a result_archive artifact of the workflow, from
+// which FullResult rehydrates it. Failed workflows are compacted only once
+// they are older than failed_after.
+type ResultCompactor struct {
+	db                *gorm.DB
+	store             OutputStore
+	storage           *StorageAccountant
+	logger            *zap.Logger
+	interval          time.Duration
+	failedAfter       time.Duration
+	batchSize         int
+	errorSummaryBytes int
+	now               func() time.Time
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewResultCompactor creates a result compactor archiving to store
+func NewResultCompactor(db *gorm.DB, store OutputStore, cfg *config.ResultsConfig, logger *zap.Logger) *ResultCompactor {
+	return &ResultCompactor{
+		db:                db,
+		store:             store,
+		logger:            logger,
+		interval:          time.Duration(cfg.CompactInterval) * time.Second,
+		failedAfter:       time.Duration(cfg.FailedAfter) * time.Second,
+		batchSize:         cfg.BatchSize,
+		errorSummaryBytes: cfg.ErrorSummaryBytes,
+		now:               time.Now,
+		stopChan:          make(chan struct{}),
+	}
+}
+
+// SetStorageAccountant makes result archives count against their project's
+// storage
+func (c *ResultCompactor) SetStorageAccountant(storage *StorageAccountant) {
+	c.storage = storage
+}
+
+// Start starts the background compaction job
+func (c *ResultCompactor) Start() {
+	c.wg.Add(1)
+	go c.run()
+	c.logger.Info("Result compactor started",
+		zap.Duration("compact_interval", c.interval),
+		zap.Duration("failed_after", c.failedAfter))
+}
+
+// Stop stops the background job
+func (c *ResultCompactor) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+		c.wg.Wait()
+		c.logger.Info("Result compactor stopped")
+	})
+}
+
+func (c *ResultCompactor) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.stopChan:
+			return
+		}
+
+		compacted, err := c.CompactDue(context.Background())
+		if err != nil {
+			c.logger.Error("Failed to compact workflow results", zap.Error(err))
+		}
+		if compacted > 0 {
+			c.logger.Info("Workflow results compacted", zap.Int("workflows", compacted))
+		}
+	}
+}
+
+// CompactDue compacts the results of the finished task execution workflows
+// not compacted yet: completed and cancelled ones, and failed ones finished
+// more than failed_after ago. Workflows failing to compact are logged and
+// tried again on the next run. It returns the number of workflows compacted.
+func (c *ResultCompactor) CompactDue(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		var ids []string
+		if err := c.db.WithContext(ctx).Model(&models.Workflow{}).
+			Where("type = ? AND result_compacted_at IS NULL AND output IS NOT NULL", models.WorkflowTypeTaskExecution).
+			Where("status IN ? OR (status IN ? AND completed_at <= ?)",
+				compactedStatuses, failedStatuses, c.now().Add(-c.failedAfter)).
+			Order("completed_at, id").
+			Limit(c.batchSize).
+			Pluck("id", &ids).Error; err != nil {
+			return total, fmt.Errorf("failed to list workflows to compact: %w", err)
+		}
+
+		compacted := 0
+		for _, id := range ids {
+			if _, err := c.Compact(ctx, id); err != nil {
+				c.logger.Warn("Failed to compact workflow result", zap.String("workflow_id", id), zap.Error(err))
+				continue
+			}
+			compacted++
+		}
+		total += compacted
+		// Workflows failing to compact are listed again; stop once a batch
+		// makes no progress
+		if len(ids) < c.batchSize || compacted == 0 {
+			return total, nil
+		}
+	}
+}
+
+// Compact compacts the result of a finished task execution workflow and
+// returns its archive, nil when the output is not an aggregate of task
+// results. Failed workflows are compacted whatever their age. Compacting a
+// compacted result returns its archive.
+func (c *ResultCompactor) Compact(ctx context.Context, workflowID string) (*models.ResultArchive, error) {
+	var workflow models.Workflow
+	if err := c.db.WithContext(ctx).
+		Select("id", "project_id", "type", "status", "output", "metadata", "simulated", "result_compacted_at").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	if !workflow.IsTerminal() {
+		return nil, fmt.Errorf("workflow %s is %s; only finished workflows are compacted", workflowID, workflow.Status)
+	}
+	if archive, ok := models.ParseResultArchive(workflow.Output); ok {
+		return archive, nil
+	}
+
+	now := c.now()
+	workflow.ResultCompactedAt = &now
+	compacted, err := c.compactResult(workflow.Output)
+	if err != nil {
+		// Outputs other than task aggregates are left as they are, and not
+		// listed again
+		c.logger.Debug("Workflow output is not a task result aggregate",
+			zap.String("workflow_id", workflowID), zap.Error(err))
+		return nil, c.markCompacted(ctx, &workflow)
+	}
+
+	archive, err := c.archive(ctx, &workflow)
+	if err != nil {
+		return nil, err
+	}
+	archive.CompactedAt = now
+	compacted["archive"], err = json.Marshal(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result archive: %w", err)
+	}
+	output, err := json.Marshal(compacted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal compacted result: %w", err)
+	}
+
+	saved := len(workflow.Output) - len(output)
+	workflow.Output = output
+	if err := c.markCompacted(ctx, &workflow); err != nil {
+		return nil, err
+	}
+	resultsCompactedTotal.WithLabelValues(string(workflow.Status)).Inc()
+	if saved > 0 {
+		resultCompactionSavedBytesTotal.Add(float64(saved))
+	}
+	c.logger.Debug("Workflow result compacted",
+		zap.String("workflow_id", workflowID),
+		zap.Int("size", archive.Size),
+		zap.Int("compacted_size", len(output)))
+	return archive, nil
+}
+
+// FullResult returns a stored workflow output in full, rehydrated from its
+// archive when it was compacted
+func (c *ResultCompactor) FullResult(ctx context.Context, output []byte) ([]byte, error) {
+	archive, ok := models.ParseResultArchive(output)
+	if !ok {
+		return output, nil
+	}
+	if c == nil || c.store == nil {
+		return nil, fmt.Errorf("result %s was archived but no output store is configured", archive.ArchiveRef)
+	}
+	data, err := c.store.Get(ctx, archive.ArchiveRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read result archive: %w", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result archive: %w", err)
+	}
+	full, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress result archive: %w", err)
+	}
+	sum := sha256.Sum256(full)
+	if hex.EncodeToString(sum[:]) != archive.Checksum {
+		return nil, fmt.Errorf("result archive %s does not match its checksum", archive.ArchiveRef)
+	}
+	return full, nil
+}
+
+// compactResult returns the compacted fields of an aggregate of task
+// results: the task results keep everything but their output, artifacts
+// keep their references but not their content, and errors are summarized
+func (c *ResultCompactor) compactResult(output []byte) (map[string]json.RawMessage, error) {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, err
+	}
+	if _, ok := result["task_results"]; !ok {
+		return nil, fmt.Errorf("no task results")
+	}
+
+	var tasks []map[string]json.RawMessage
+	if err := json.Unmarshal(result["task_results"], &tasks); err != nil {
+		return nil, fmt.Errorf("invalid task results: %w", err)
+	}
+	for _, task := range tasks {
+		delete(task, "output")
+		if err := c.summarizeError(task); err != nil {
+			return nil, err
+		}
+		if err := compactArtifacts(task); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if result["task_results"], err = json.Marshal(tasks); err != nil {
+		return nil, err
+	}
+	if err := compactArtifacts(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// summarizeError keeps the first line of a task's error, up to
+// error_summary_bytes
+func (c *ResultCompactor) summarizeError(task map[string]json.RawMessage) error {
+	if _, ok := task["error"]; !ok {
+		return nil
+	}
+	var message string
+	if err := json.Unmarshal(task["error"], &message); err != nil {
+		return fmt.Errorf("invalid task error: %w", err)
+	}
+	summary, _, _ := strings.Cut(message, "\n")
+	if len(summary) > c.errorSummaryBytes {
+		cut := c.errorSummaryBytes
+		for cut > 0 && !utf8.RuneStart(summary[cut]) {
+			cut--
+		}
+		summary = summary[:cut]
+	}
+	if summary != message {
+		summary += " [...]"
+	}
+	var err error
+	task["error"], err = json.Marshal(summary)
+	return err
+}
+
+// compactArtifacts drops the contents of the artifacts listed in fields,
+// keeping their references
+func compactArtifacts(fields map[string]json.RawMessage) error {
+	if _, ok := fields["artifacts"]; !ok {
+		return nil
+	}
+	var artifacts []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["artifacts"], &artifacts); err != nil {
+		return fmt.Errorf("invalid artifacts: %w", err)
+	}
+	for _, artifact := range artifacts {
+		delete(artifact, "content")
+	}
+	var err error
+	fields["artifacts"], err = json.Marshal(artifacts)
+	return err
+}
+
+// archive stores a workflow's output, gzip-compressed, in the bucket of its
+// region and records it as the result_archive artifact of the workflow.
+// Retries store and record the archive once.
+func (c *ResultCompactor) archive(ctx context.Context, workflow *models.Workflow) (*models.ResultArchive, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(workflow.Output); err != nil {
+		return nil, fmt.Errorf("failed to compress result: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress result: %w", err)
+	}
+	sum := sha256.Sum256(workflow.Output)
+	archive := &models.ResultArchive{
+		Size:           len(workflow.Output),
+		CompressedSize: compressed.Len(),
+		Checksum:       hex.EncodeToString(sum[:]),
+	}
+	key := sha256.Sum256(compressed.Bytes())
+	archive.ArchiveRef = hex.EncodeToString(key[:])
+	regionCtx := WithRegion(ctx, WorkflowRegion(workflow.Metadata))
+	if err := c.store.Put(regionCtx, archive.ArchiveRef, compressed.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to store result archive: %w", err)
+	}
+
+	now := c.now()
+	execution := &models.Execution{
+		ID:          uuid.NewSHA1(uuid.NameSpaceURL, []byte("result-archive:"+workflow.ID)).String(),
+		ProjectID:   workflow.ProjectID,
+		WorkflowID:  workflow.ID,
+		Name:        "Archive result",
+		Type:        models.ExecutionTypeCustom,
+		Status:      models.ExecutionStatusSucceeded,
+		Simulated:   workflow.Simulated,
+		StartedAt:   &now,
+		CompletedAt: &now,
+	}
+	if err := c.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(execution).Error; err != nil {
+		return nil, fmt.Errorf("failed to create execution: %w", err)
+	}
+	artifact := models.Artifact{
+		ID:          uuid.NewSHA1(uuid.NameSpaceURL, []byte("result-archive:"+workflow.ID+"/"+archive.ArchiveRef)).String(),
+		ExecutionID: execution.ID,
+		WorkflowID:  workflow.ID,
+		Name:        "result.json.gz",
+		Type:        models.ResultArchiveArtifactType,
+		Path:        archive.ArchiveRef,
+		Size:        int64(archive.CompressedSize),
+		Checksum:    archive.ArchiveRef,
+		ContentType: "application/gzip",
+	}
+	var err error
+	if c.storage != nil {
+		err = c.storage.StoreArtifacts(ctx, workflow.ProjectID, []models.Artifact{artifact})
+	} else {
+		err = c.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&artifact).Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record result archive: %w", err)
+	}
+	archive.ArtifactID = artifact.ID
+	return archive, nil
+}
+
+// markCompacted stores a workflow's compacted output. The output is written
+// from the model, not a map, for it to be encrypted at rest like any other.
+func (c *ResultCompactor) markCompacted(ctx context.Context, workflow *models.Workflow) error {
+	if err := c.db.WithContext(ctx).Model(workflow).
+		Select("output", "result_compacted_at").
+		Where("result_compacted_at IS NULL").
+		Updates(workflow).Error; err != nil {
+		return fmt.Errorf("failed to store compacted result: %w", err)
+	}
+	return nil
+}
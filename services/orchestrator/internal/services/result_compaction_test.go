@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+func setupResultCompactionTest(t *testing.T) (*gorm.DB, *ResultCompactor) {
+	db := setupWorkflowTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, workflow_id TEXT, workflow_step_id TEXT,
+			agent_id TEXT, name TEXT, type TEXT, status TEXT, language TEXT, runtime TEXT, code TEXT, script TEXT,
+			command TEXT, arguments TEXT, environment TEXT, input TEXT, output TEXT, logs TEXT, error TEXT,
+			error_signature TEXT, error_class TEXT, exit_code INTEGER, started_at DATETIME, completed_at DATETIME, duration INTEGER,
+			timeout_seconds INTEGER, retry_count INTEGER, max_retries INTEGER, retry_delay INTEGER,
+			resource_usage TEXT, metadata TEXT, tags TEXT, priority INTEGER, simulated BOOLEAN, queued_at DATETIME,
+			scheduled_at DATETIME, created_by TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT, path TEXT, url TEXT,
+			size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+			workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	store, err := NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	compactor := NewResultCompactor(db, store, &config.ResultsConfig{
+		Enabled: true, CompactInterval: 60, FailedAfter: 24 * 60 * 60, BatchSize: 2, ErrorSummaryBytes: 40,
+	}, zap.NewNop())
+	return db, compactor
+}
+
+// largeTaskResult is an aggregate of tasks, the last of them failed, each
+// with a large output and a generated file
+func largeTaskResult(t *testing.T, tasks int) json.RawMessage {
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	var results []map[string]interface{}
+	for i := 0; i < tasks; i++ {
+		result := map[string]interface{}{
+			"task_id":    fmt.Sprintf("task-%d", i),
+			"agent_id":   "backend-1",
+			"status":     "succeeded",
+			"output":     map[string]interface{}{"log": strings.Repeat(fmt.Sprintf("step %d of task %d done\n", i, i), 400)},
+			"start_time": start,
+			"end_time":   start.Add(90 * time.Second),
+			"duration":   90 * time.Second,
+			"task_type":  "backend",
+			"artifacts": []map[string]interface{}{{
+				"id": fmt.Sprintf("file-%d", i), "name": fmt.Sprintf("handler_%d.go", i), "type": "code",
+				"content": strings.Repeat("func handle() {}\n", 200), "path": "internal/api", "size": 3400,
+				"content_type": "text/x-go",
+			}},
+		}
+		if i == tasks-1 {
+			result["status"] = "failed"
+			result["error"] = "agent backend-1 returned: compilation failed in handler.go\n" + strings.Repeat("  at line 12\n", 50)
+		}
+		results = append(results, result)
+	}
+	output, err := json.Marshal(map[string]interface{}{
+		"status":           "partial_success",
+		"total_tasks":      tasks,
+		"successful_tasks": tasks - 1,
+		"failed_tasks":     1,
+		"task_results":     results,
+		"artifacts":        results[0]["artifacts"],
+		"summary":          "Completed 2 of 3 tasks",
+		"metadata":         map[string]interface{}{"project_id": "project-1"},
+	})
+	require.NoError(t, err)
+	return output
+}
+
+func createFinishedWorkflow(t *testing.T, db *gorm.DB, workflowType models.WorkflowType, status models.WorkflowStatus, completedAt time.Time, output json.RawMessage) string {
+	workflow := &models.Workflow{
+		Name: "tasks", Type: workflowType, ProjectID: "project-1", Status: status,
+		Output: output, CompletedAt: &completedAt,
+	}
+	require.NoError(t, db.Create(workflow).Error)
+	return workflow.ID
+}
+
+func storedOutput(t *testing.T, db *gorm.DB, workflowID string) (*models.Workflow, map[string]json.RawMessage) {
+	var workflow models.Workflow
+	require.NoError(t, db.First(&workflow, "id = ?", workflowID).Error)
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(workflow.Output, &fields))
+	return &workflow, fields
+}
+
+func TestResultCompactor_CompactsTaskResults(t *testing.T) {
+	db, compactor := setupResultCompactionTest(t)
+	ctx := context.Background()
+	full := largeTaskResult(t, 3)
+	id := createFinishedWorkflow(t, db, models.WorkflowTypeTaskExecution, models.WorkflowStatusCompleted, time.Now(), full)
+
+	compacted, err := compactor.CompactDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, compacted)
+
+	workflow, fields := storedOutput(t, db, id)
+	require.NotNil(t, workflow.ResultCompactedAt)
+	assert.Less(t, len(workflow.Output)*10, len(full), "task outputs and artifact contents make up most of the result")
+	assert.JSONEq(t, `"partial_success"`, string(fields["status"]))
+	assert.JSONEq(t, `"Completed 2 of 3 tasks"`, string(fields["summary"]))
+	assert.JSONEq(t, `[{"id": "file-0", "name": "handler_0.go", "type": "code", "path": "internal/api", "size": 3400,
+		"content_type": "text/x-go"}]`, string(fields["artifacts"]), "artifacts keep their references")
+
+	var tasks []map[string]interface{}
+	require.NoError(t, json.Unmarshal(fields["task_results"], &tasks))
+	require.Len(t, tasks, 3)
+	for _, task := range tasks {
+		assert.NotContains(t, task, "output")
+		assert.Equal(t, float64(90*time.Second), task["duration"])
+		assert.Equal(t, "2026-10-01T09:00:00Z", task["start_time"])
+		require.Len(t, task["artifacts"], 1)
+		assert.NotContains(t, task["artifacts"].([]interface{})[0], "content")
+	}
+	assert.Equal(t, "succeeded", tasks[0]["status"])
+	assert.NotContains(t, tasks[0], "error")
+	assert.Equal(t, "failed", tasks[2]["status"])
+	assert.Equal(t, "agent backend-1 returned: compilation fa [...]", tasks[2]["error"],
+		"errors keep the start of their first line")
+
+	archive, ok := models.ParseResultArchive(workflow.Output)
+	require.True(t, ok)
+	assert.Equal(t, len(full), archive.Size)
+	assert.Less(t, archive.CompressedSize, archive.Size)
+	var recorded models.Artifact
+	require.NoError(t, db.First(&recorded, "id = ?", archive.ArtifactID).Error)
+	assert.Equal(t, models.ResultArchiveArtifactType, recorded.Type)
+	assert.Equal(t, id, recorded.WorkflowID)
+	assert.Equal(t, int64(archive.CompressedSize), recorded.Size)
+
+	// Compacted results are not compacted again
+	compacted, err = compactor.CompactDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, compacted)
+	again, err := compactor.Compact(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, archive.ArchiveRef, again.ArchiveRef)
+}
+
+func TestResultCompactor_FullResultRehydratesArchive(t *testing.T) {
+	db, compactor := setupResultCompactionTest(t)
+	ctx := context.Background()
+	full := largeTaskResult(t, 5)
+	id := createFinishedWorkflow(t, db, models.WorkflowTypeTaskExecution, models.WorkflowStatusCancelled, time.Now(), full)
+
+	_, err := compactor.Compact(ctx, id)
+	require.NoError(t, err)
+	workflow, _ := storedOutput(t, db, id)
+	rehydrated, err := compactor.FullResult(ctx, workflow.Output)
+	require.NoError(t, err)
+	assert.Equal(t, string(full), string(rehydrated), "the result is rehydrated byte for byte")
+
+	// Results that were not compacted are returned as they are
+	inline := []byte(`{"status": "completed", "task_results": []}`)
+	rehydrated, err = compactor.FullResult(ctx, inline)
+	require.NoError(t, err)
+	assert.Equal(t, inline, rehydrated)
+
+	_, err = (*ResultCompactor)(nil).FullResult(ctx, workflow.Output)
+	assert.ErrorContains(t, err, "no output store is configured")
+}
+
+func TestResultCompactor_KeepsRecentFailedResults(t *testing.T) {
+	db, compactor := setupResultCompactionTest(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	compactor.now = func() time.Time { return now }
+
+	failed := createFinishedWorkflow(t, db, models.WorkflowTypeTaskExecution, models.WorkflowStatusFailed,
+		now.Add(-time.Hour), largeTaskResult(t, 2))
+	timedOut := createFinishedWorkflow(t, db, models.WorkflowTypeTaskExecution, models.WorkflowStatusTimedOut,
+		now.Add(-48*time.Hour), largeTaskResult(t, 2))
+	running := createFinishedWorkflow(t, db, models.WorkflowTypeTaskExecution, models.WorkflowStatusRunning,
+		now.Add(-48*time.Hour), largeTaskResult(t, 2))
+	intent := createFinishedWorkflow(t, db, models.WorkflowTypeIntent, models.WorkflowStatusCompleted,
+		now.Add(-48*time.Hour), json.RawMessage(`{"status": "completed"}`))
+	unknown := createFinishedWorkflow(t, db, models.WorkflowTypeTaskExecution, models.WorkflowStatusCompleted,
+		now.Add(-48*time.Hour), json.RawMessage(`{"status": "completed"}`))
+
+	compacted, err := compactor.CompactDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, compacted)
+
+	compactedAt := func(id string) *time.Time {
+		workflow, _ := storedOutput(t, db, id)
+		return workflow.ResultCompactedAt
+	}
+	assert.Nil(t, compactedAt(failed), "recent failures keep their full result for debugging")
+	assert.NotNil(t, compactedAt(timedOut), "failures older than failed_after are compacted")
+	assert.Nil(t, compactedAt(running))
+	assert.Nil(t, compactedAt(intent), "only task execution results are compacted")
+	assert.NotNil(t, compactedAt(unknown), "outputs without task results are not listed again")
+	workflow, _ := storedOutput(t, db, unknown)
+	assert.JSONEq(t, `{"status": "completed"}`, string(workflow.Output))
+
+	now = now.Add(24 * time.Hour)
+	compacted, err = compactor.CompactDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, compacted)
+	_, fields := storedOutput(t, db, failed)
+	assert.Contains(t, fields, "archive")
+
+	_, err = compactor.Compact(ctx, running)
+	assert.ErrorContains(t, err, "only finished workflows are compacted")
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrInvalidRiskPolicy is returned for project settings with a risk
+	// policy the gate cannot apply
+	ErrInvalidRiskPolicy = errors.New("invalid risk policy")
+	// ErrNoPendingRiskReview is returned when confirming the risks of a
+	// workflow that is not waiting for a confirmation
+	ErrNoPendingRiskReview = errors.New("workflow has no risks pending confirmation")
+)
+
+// IntentRiskConfirmationSignal is the signal that confirms or denies the
+// risks of an intent processing workflow waiting for confirmation
+const IntentRiskConfirmationSignal = "intent_risk_confirmation"
+
+// Events published on the workflow event channel of a project for the
+// decisions of the risk gate
+const (
+	IntentRiskAllowedEvent              = "intent_risk_allowed"
+	IntentRiskWarnedEvent               = "intent_risk_warned"
+	IntentRiskConfirmationRequiredEvent = "intent_risk_confirmation_required"
+	IntentRiskBlockedEvent              = "intent_risk_blocked"
+	IntentRiskConfirmedEvent            = "intent_risk_confirmed"
+	IntentRiskDeniedEvent               = "intent_risk_denied"
+	IntentRiskConfirmationTimedOutEvent = "intent_risk_confirmation_timed_out"
+)
+
+// riskDecisionEvents are the events of the risk policy actions
+var riskDecisionEvents = map[models.RiskAction]string{
+	models.RiskActionAllow:               IntentRiskAllowedEvent,
+	models.RiskActionWarn:                IntentRiskWarnedEvent,
+	models.RiskActionRequireConfirmation: IntentRiskConfirmationRequiredEvent,
+	models.RiskActionBlock:               IntentRiskBlockedEvent,
+}
+
+var riskDecisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_intent_risk_decisions_total",
+	Help: "Risk policy decisions on analyzed intents, by action",
+}, []string{"action"})
+
+// WorkflowEventPublisher publishes events on a project's workflow event
+// channel
+type WorkflowEventPublisher interface {
+	Publish(ctx context.Context, projectID string, event []byte) error
+}
+
+// redisWorkflowEventPublisher publishes workflow events on the Redis channel
+// of their project, like the workflow engine does
+type redisWorkflowEventPublisher struct {
+	client *redis.Client
+}
+
+// NewRedisWorkflowEventPublisher creates a WorkflowEventPublisher
+// publishing on the Redis channel workflow:events:<project ID>
+func NewRedisWorkflowEventPublisher(client *redis.Client) WorkflowEventPublisher {
+	return &redisWorkflowEventPublisher{client: client}
+}
+
+func (r *redisWorkflowEventPublisher) Publish(ctx context.Context, projectID string, event []byte) error {
+	return RedisGuardFor(r.client, nil).Publish(ctx, fmt.Sprintf("workflow:events:%s", projectID), event)
+}
+
+// RiskDecision is the risk policy's action on the risks of an intent, with
+// the risks that led to it
+type RiskDecision struct {
+	Action         models.RiskAction `json:"action"`
+	TriggeredRisks []string          `json:"triggered_risks,omitempty"`
+	// ConfirmationTimeout is how long the workflow waits for the risks to
+	// be confirmed when the action requires confirmation
+	ConfirmationTimeout time.Duration `json:"confirmation_timeout,omitempty"`
+}
+
+// RiskConfirmation confirms or denies the risks of an intent
+type RiskConfirmation struct {
+	Confirmed bool   `json:"confirmed"`
+	Actor     string `json:"actor"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+// EvaluateRiskPolicy decides the action of a policy on a set of risks: the
+// strictest action of any risk. The triggered risks are those with that
+// action, none when the risks are allowed.
+func EvaluateRiskPolicy(policy models.RiskPolicy, risks []string) RiskDecision {
+	decision := RiskDecision{Action: models.RiskActionAllow}
+	for _, risk := range risks {
+		action := policy.DefaultAction
+		for _, rule := range policy.Rules {
+			if rule.Matches(risk) {
+				action = rule.Action
+				break
+			}
+		}
+		if !action.IsValid() {
+			action = models.RiskActionAllow
+		}
+
+		switch {
+		case action.Severity() > decision.Action.Severity():
+			decision.Action = action
+			decision.TriggeredRisks = []string{risk}
+		case action == decision.Action && action != models.RiskActionAllow:
+			decision.TriggeredRisks = append(decision.TriggeredRisks, risk)
+		}
+	}
+	return decision
+}
+
+// ValidateRiskPolicy checks that the rules and default action of a policy
+// use known actions and that every rule can match a risk
+func ValidateRiskPolicy(policy *models.RiskPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	if policy.DefaultAction != "" && !policy.DefaultAction.IsValid() {
+		return fmt.Errorf("%w: unknown default action %q", ErrInvalidRiskPolicy, policy.DefaultAction)
+	}
+	for i, rule := range policy.Rules {
+		if !rule.Action.IsValid() {
+			return fmt.Errorf("%w: unknown action %q of rule %d", ErrInvalidRiskPolicy, rule.Action, i)
+		}
+		if strings.TrimSpace(rule.Category) == "" && len(rule.Keywords) == 0 {
+			return fmt.Errorf("%w: rule %d needs a category or keywords", ErrInvalidRiskPolicy, i)
+		}
+	}
+	return nil
+}
+
+// validateProjectSettings checks the settings of a project before they are
+// stored
+func validateProjectSettings(raw json.RawMessage) error {
+	settings, err := models.ParseProjectSettings(raw)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRiskPolicy, err)
+	}
+	if settings.SchedulingWeight < 0 {
+		return fmt.Errorf("%w: %v is negative", ErrInvalidSchedulingWeight, settings.SchedulingWeight)
+	}
+	if err := ValidateBudget(settings.DefaultBudget); err != nil {
+		return err
+	}
+	if err := ValidateRedactionRules(settings.Redaction); err != nil {
+		return err
+	}
+	if err := ValidateSandboxPolicy(settings.SandboxPolicy); err != nil {
+		return err
+	}
+	if err := ValidateAgentPreferences(settings.AgentPreferences); err != nil {
+		return err
+	}
+	if err := ValidateRegion(settings.Region); err != nil {
+		return err
+	}
+	return ValidateRiskPolicy(settings.RiskPolicy)
+}
+
+// RiskGate applies the risk policy to the risks intent analysis reports. The
+// global policy comes from the configuration; projects extend it through
+// their settings.
+type RiskGate struct {
+	db       *gorm.DB
+	defaults models.RiskPolicy
+	timeout  time.Duration
+	events   WorkflowEventPublisher
+	logger   *zap.Logger
+}
+
+// NewRiskGate creates a risk gate with the global policy of cfg
+func NewRiskGate(db *gorm.DB, cfg *config.RiskPolicyConfig, events WorkflowEventPublisher, logger *zap.Logger) *RiskGate {
+	defaults := models.RiskPolicy{DefaultAction: models.RiskAction(cfg.DefaultAction)}
+	for _, rule := range cfg.Rules {
+		defaults.Rules = append(defaults.Rules, models.RiskRule{
+			Category: rule.Category,
+			Keywords: rule.Keywords,
+			Action:   models.RiskAction(rule.Action),
+		})
+	}
+	return &RiskGate{
+		db:       db,
+		defaults: defaults,
+		timeout:  time.Duration(cfg.ConfirmationTimeout) * time.Second,
+		events:   events,
+		logger:   logger,
+	}
+}
+
+// Policy returns the risk policy of a project: its own rules ahead of the
+// global ones, and its default action if it sets one
+func (g *RiskGate) Policy(ctx context.Context, projectID string) (models.RiskPolicy, error) {
+	policy := g.defaults
+	if projectID == "" {
+		return policy, nil
+	}
+
+	var project models.Project
+	err := g.db.WithContext(ctx).Select("id", "settings").First(&project, "id = ?", projectID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, fmt.Errorf("failed to get project settings: %w", err)
+	}
+	settings, err := models.ParseProjectSettings(project.Settings)
+	if err != nil {
+		return policy, err
+	}
+	if settings.RiskPolicy == nil {
+		return policy, nil
+	}
+
+	policy.Rules = append(append([]models.RiskRule{}, settings.RiskPolicy.Rules...), g.defaults.Rules...)
+	if settings.RiskPolicy.DefaultAction != "" {
+		policy.DefaultAction = settings.RiskPolicy.DefaultAction
+	}
+	return policy, nil
+}
+
+// Evaluate decides the action of a project's risk policy on the risks of an
+// intent
+func (g *RiskGate) Evaluate(ctx context.Context, projectID string, risks []string) (*RiskDecision, error) {
+	policy, err := g.Policy(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	decision := EvaluateRiskPolicy(policy, risks)
+	if decision.Action == models.RiskActionRequireConfirmation {
+		decision.ConfirmationTimeout = g.timeout
+	}
+	return &decision, nil
+}
+
+// RecordDecision stores the decision on the intent of a workflow and
+// publishes its event. Recording the decision again, as a retried activity
+// does, replaces it.
+func (g *RiskGate) RecordDecision(ctx context.Context, workflow *models.Workflow, decision *RiskDecision) error {
+	review := &models.IntentRiskReview{
+		WorkflowID:     workflow.ID,
+		ProjectID:      workflow.ProjectID,
+		Action:         decision.Action,
+		TriggeredRisks: decision.TriggeredRisks,
+	}
+	if decision.Action == models.RiskActionRequireConfirmation {
+		review.Status = models.RiskReviewPending
+	}
+	if err := g.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(review).Error; err != nil {
+		return fmt.Errorf("failed to record risk decision: %w", err)
+	}
+
+	riskDecisionsTotal.WithLabelValues(string(decision.Action)).Inc()
+	if decision.Action != models.RiskActionAllow {
+		g.logger.Warn("Risk policy triggered by intent",
+			zap.String("workflow_id", workflow.ID),
+			zap.String("action", string(decision.Action)),
+			zap.Strings("risks", decision.TriggeredRisks))
+	}
+	g.publish(ctx, review, riskDecisionEvents[decision.Action], nil)
+	return nil
+}
+
+// RecordConfirmation stores the outcome of a workflow's pending risk
+// confirmation and publishes its event. A nil confirmation records that the
+// wait timed out.
+func (g *RiskGate) RecordConfirmation(ctx context.Context, workflowID string, confirmation *RiskConfirmation) error {
+	var review models.IntentRiskReview
+	if err := g.db.WithContext(ctx).First(&review, "workflow_id = ?", workflowID).Error; err != nil {
+		return fmt.Errorf("failed to get risk review: %w", err)
+	}
+
+	now := time.Now()
+	eventType := IntentRiskConfirmationTimedOutEvent
+	review.Status = models.RiskReviewTimedOut
+	if confirmation != nil {
+		eventType = IntentRiskDeniedEvent
+		review.Status = models.RiskReviewDenied
+		if confirmation.Confirmed {
+			eventType = IntentRiskConfirmedEvent
+			review.Status = models.RiskReviewConfirmed
+		}
+		review.ReviewedBy = confirmation.Actor
+		review.Comment = confirmation.Comment
+	}
+	review.ReviewedAt = &now
+	if err := g.db.WithContext(ctx).Model(&review).Select("status", "reviewed_by", "comment", "reviewed_at").
+		Updates(&review).Error; err != nil {
+		return fmt.Errorf("failed to record risk confirmation: %w", err)
+	}
+
+	g.publish(ctx, &review, eventType, map[string]interface{}{
+		"reviewed_by": review.ReviewedBy,
+		"comment":     review.Comment,
+	})
+	return nil
+}
+
+// publish publishes a risk gate event on the workflow event channel of the
+// review's project. Events are best effort; failures are logged.
+func (g *RiskGate) publish(ctx context.Context, review *models.IntentRiskReview, eventType string, data map[string]interface{}) {
+	if g.events == nil {
+		return
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["action"] = review.Action
+	data["triggered_risks"] = review.TriggeredRisks
+
+	event, err := json.Marshal(map[string]interface{}{
+		"workflow_id": review.WorkflowID,
+		"project_id":  review.ProjectID,
+		"type":        models.WorkflowTypeIntent,
+		"event_type":  eventType,
+		"timestamp":   time.Now(),
+		"data":        data,
+	})
+	if err != nil {
+		g.logger.Error("Failed to marshal risk gate event", zap.Error(err))
+		return
+	}
+	if err := g.events.Publish(ctx, review.ProjectID, event); err != nil {
+		g.logger.Warn("Failed to publish risk gate event",
+			zap.String("workflow_id", review.WorkflowID),
+			zap.String("event_type", eventType),
+			zap.Error(err))
+	}
+}
+
+// GetIntentRiskReview returns the risk policy's decision on the intent of a
+// workflow, or nil when the policy made none
+func (e *WorkflowEngine) GetIntentRiskReview(ctx context.Context, workflowID string) (*models.IntentRiskReview, error) {
+	var review models.IntentRiskReview
+	err := e.db.WithContext(ctx).First(&review, "workflow_id = ?", workflowID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get risk review: %w", err)
+	}
+	return &review, nil
+}
+
+// ConfirmIntentRisks confirms or denies the risks of a workflow waiting for
+// confirmation by signaling it; the workflow records the outcome
+func (e *WorkflowEngine) ConfirmIntentRisks(ctx context.Context, workflowID string, confirmation RiskConfirmation) (*models.IntentRiskReview, error) {
+	var workflow models.Workflow
+	if err := e.db.WithContext(ctx).Select("id", "status", "temporal_id").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+
+	review, err := e.GetIntentRiskReview(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if review == nil || review.Status != models.RiskReviewPending || workflow.IsTerminal() || workflow.TemporalID == "" {
+		return nil, ErrNoPendingRiskReview
+	}
+
+	if err := e.temporalClient.SignalWorkflow(ctx, workflow.TemporalID, "", IntentRiskConfirmationSignal, confirmation); err != nil {
+		return nil, fmt.Errorf("failed to signal risk confirmation: %w", err)
+	}
+	e.logger.Info("Intent risk confirmation sent",
+		zap.String("workflow_id", workflowID),
+		zap.Bool("confirmed", confirmation.Confirmed),
+		zap.String("actor", confirmation.Actor))
+	return review, nil
+}
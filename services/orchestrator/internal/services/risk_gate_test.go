@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// memoryWorkflowEvents is an in-memory WorkflowEventPublisher
+type memoryWorkflowEvents struct {
+	mu     sync.Mutex
+	events []map[string]interface{}
+}
+
+func (m *memoryWorkflowEvents) Publish(ctx context.Context, projectID string, event []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(event, &decoded); err != nil {
+		return err
+	}
+	m.events = append(m.events, decoded)
+	return nil
+}
+
+func (m *memoryWorkflowEvents) types() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	types := make([]string, len(m.events))
+	for i, event := range m.events {
+		types[i], _ = event["event_type"].(string)
+	}
+	return types
+}
+
+func TestEvaluateRiskPolicy(t *testing.T) {
+	policy := models.RiskPolicy{
+		Rules: []models.RiskRule{
+			{Category: "Data_Loss", Action: models.RiskActionBlock},
+			{Keywords: []string{"production"}, Action: models.RiskActionRequireConfirmation},
+			{Keywords: []string{"cost", "billing"}, Action: models.RiskActionWarn},
+			{Category: "style", Action: models.RiskActionAllow},
+		},
+		DefaultAction: models.RiskActionWarn,
+	}
+
+	tests := []struct {
+		name      string
+		risks     []string
+		action    models.RiskAction
+		triggered []string
+	}{
+		{"no risks", nil, models.RiskActionAllow, nil},
+		{"allowed", []string{"style: inconsistent naming"}, models.RiskActionAllow, nil},
+		{"warned", []string{"style: tabs", "Raises COST of the cluster"}, models.RiskActionWarn, []string{"Raises COST of the cluster"}},
+		{"default action", []string{"unusual request"}, models.RiskActionWarn, []string{"unusual request"}},
+		{"confirmation", []string{"may increase billing", "touches production database", "restarts production pods"},
+			models.RiskActionRequireConfirmation, []string{"touches production database", "restarts production pods"}},
+		{"blocked", []string{"touches production database", "data_loss: drops the users table"},
+			models.RiskActionBlock, []string{"data_loss: drops the users table"}},
+		{"category needs the separator", []string{"data_lossy compression"}, models.RiskActionWarn, []string{"data_lossy compression"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := EvaluateRiskPolicy(policy, tt.risks)
+			assert.Equal(t, tt.action, decision.Action)
+			assert.Equal(t, tt.triggered, decision.TriggeredRisks)
+		})
+	}
+
+	// Without a default action unmatched risks are allowed
+	assert.Equal(t, models.RiskActionAllow, EvaluateRiskPolicy(models.RiskPolicy{}, []string{"anything"}).Action)
+}
+
+func setupRiskGateTest(t *testing.T) (*gorm.DB, *RiskGate, *memoryWorkflowEvents) {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.AutoMigrate(&models.IntentRiskReview{}))
+
+	events := &memoryWorkflowEvents{}
+	gate := NewRiskGate(db, &config.RiskPolicyConfig{
+		DefaultAction:       "allow",
+		ConfirmationTimeout: 3600,
+		Rules: []config.RiskRuleConfig{
+			{Category: "data_loss", Action: "block"},
+			{Keywords: []string{"production"}, Action: "warn"},
+		},
+	}, events, zap.NewNop())
+	return db, gate, events
+}
+
+func TestRiskGate_ProjectPolicyExtendsDefaults(t *testing.T) {
+	db, gate, _ := setupRiskGateTest(t)
+	ctx := context.Background()
+	settings := `{"risk_policy": {"rules": [{"keywords": ["production"], "action": "require_confirmation"}], "default_action": "warn"}}`
+	require.NoError(t, db.Exec(`INSERT INTO projects (id, settings) VALUES ('project-1', ?), ('project-2', NULL)`, []byte(settings)).Error)
+
+	// The project's rule is matched ahead of the global one
+	decision, err := gate.Evaluate(ctx, "project-1", []string{"restarts production pods"})
+	require.NoError(t, err)
+	assert.Equal(t, models.RiskActionRequireConfirmation, decision.Action)
+	assert.Equal(t, time.Hour, decision.ConfirmationTimeout)
+
+	// Global rules still apply, and the project's default replaces the global one
+	decision, err = gate.Evaluate(ctx, "project-1", []string{"data_loss: truncates logs", "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, models.RiskActionBlock, decision.Action)
+	decision, err = gate.Evaluate(ctx, "project-1", []string{"unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, models.RiskActionWarn, decision.Action)
+	assert.Zero(t, decision.ConfirmationTimeout)
+
+	// Projects without a policy, or unknown ones, get the global policy
+	for _, projectID := range []string{"project-2", "missing", ""} {
+		decision, err = gate.Evaluate(ctx, projectID, []string{"restarts production pods", "unknown"})
+		require.NoError(t, err)
+		assert.Equal(t, models.RiskActionWarn, decision.Action, projectID)
+		assert.Equal(t, []string{"restarts production pods"}, decision.TriggeredRisks, projectID)
+	}
+}
+
+func TestRiskGate_RecordsDecisionsAndConfirmations(t *testing.T) {
+	db, gate, events := setupRiskGateTest(t)
+	ctx := context.Background()
+	workflow := &models.Workflow{Name: "Intent", Type: models.WorkflowTypeIntent, Status: models.WorkflowStatusRunning,
+		ProjectID: "project-1", TemporalID: "temporal-1"}
+	require.NoError(t, db.Create(workflow).Error)
+
+	decision := &RiskDecision{
+		Action:         models.RiskActionRequireConfirmation,
+		TriggeredRisks: []string{"restarts production pods"},
+	}
+	// A retried activity records the decision again
+	require.NoError(t, gate.RecordDecision(ctx, workflow, decision))
+	require.NoError(t, gate.RecordDecision(ctx, workflow, decision))
+
+	temporalClient := new(mocks.Client)
+	temporalClient.On("SignalWorkflow", mock.Anything, "temporal-1", "", IntentRiskConfirmationSignal,
+		RiskConfirmation{Confirmed: false, Actor: "alice", Comment: "not during the freeze"}).Return(nil).Once()
+	engine := NewWorkflowEngine(db, nil, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	review, err := engine.GetIntentRiskReview(ctx, workflow.ID)
+	require.NoError(t, err)
+	require.NotNil(t, review)
+	assert.Equal(t, models.RiskReviewPending, review.Status)
+	assert.Equal(t, models.StringArray{"restarts production pods"}, review.TriggeredRisks)
+
+	// Denying signals the waiting workflow, which records the outcome
+	review, err = engine.ConfirmIntentRisks(ctx, workflow.ID, RiskConfirmation{Actor: "alice", Comment: "not during the freeze"})
+	require.NoError(t, err)
+	assert.Equal(t, models.RiskActionRequireConfirmation, review.Action)
+	require.NoError(t, gate.RecordConfirmation(ctx, workflow.ID,
+		&RiskConfirmation{Actor: "alice", Comment: "not during the freeze"}))
+
+	review, err = engine.GetIntentRiskReview(ctx, workflow.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.RiskReviewDenied, review.Status)
+	assert.Equal(t, "alice", review.ReviewedBy)
+	assert.NotNil(t, review.ReviewedAt)
+
+	// Nothing is pending any more
+	_, err = engine.ConfirmIntentRisks(ctx, workflow.ID, RiskConfirmation{Confirmed: true, Actor: "bob"})
+	assert.ErrorIs(t, err, ErrNoPendingRiskReview)
+	_, err = engine.ConfirmIntentRisks(ctx, "00000000-0000-0000-0000-000000000099", RiskConfirmation{Confirmed: true})
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	assert.Equal(t, []string{
+		IntentRiskConfirmationRequiredEvent, IntentRiskConfirmationRequiredEvent, IntentRiskDeniedEvent,
+	}, events.types())
+	data := events.events[2]["data"].(map[string]interface{})
+	assert.Equal(t, "alice", data["reviewed_by"])
+	assert.Equal(t, []interface{}{"restarts production pods"}, data["triggered_risks"])
+	temporalClient.AssertExpectations(t)
+
+	// Workflows without a decision have no review
+	review, err = engine.GetIntentRiskReview(ctx, "00000000-0000-0000-0000-000000000099")
+	require.NoError(t, err)
+	assert.Nil(t, review)
+}
+
+func TestValidateRiskPolicy(t *testing.T) {
+	assert.NoError(t, validateProjectSettings(nil))
+	assert.NoError(t, validateProjectSettings(json.RawMessage(`{"encrypt_at_rest": true}`)))
+	assert.NoError(t, validateProjectSettings(json.RawMessage(
+		`{"risk_policy": {"rules": [{"category": "data_loss", "action": "block"}], "default_action": "warn"}}`)))
+
+	for _, settings := range []string{
+		`{"risk_policy": {"default_action": "ignore"}}`,
+		`{"risk_policy": {"rules": [{"category": "data_loss", "action": "deny"}]}}`,
+		`{"risk_policy": {"rules": [{"action": "block"}]}}`,
+		`{"risk_policy": []}`,
+	} {
+		assert.ErrorIs(t, validateProjectSettings(json.RawMessage(settings)), ErrInvalidRiskPolicy, settings)
+	}
+}
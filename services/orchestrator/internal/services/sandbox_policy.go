@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrWorkflowTypeNotAllowed is returned when starting a workflow of a
+	// type the sandbox policy of its project does not allow
+	ErrWorkflowTypeNotAllowed = errors.New("workflow type not allowed by sandbox policy")
+	// ErrInvalidSandboxPolicy is returned for project settings with a
+	// sandbox policy listing empty types
+	ErrInvalidSandboxPolicy = errors.New("invalid sandbox policy")
+)
+
+// SandboxPolicyMetadataKey is the workflow metadata key of the task types a
+// task execution workflow may run, as allowed when it started. Running
+// workflows keep that policy whatever later changes to it.
+const SandboxPolicyMetadataKey = "sandbox_policy"
+
+// SandboxPolicyChangedAction is the audit log action of changes to the
+// sandbox policy of a project
+const SandboxPolicyChangedAction = "project.sandbox_policy.changed"
+
+// Sources of the lists of an effective sandbox policy
+const (
+	SandboxPolicySourceDefault      = "default"
+	SandboxPolicySourceOrganization = "organization"
+	SandboxPolicySourceProject      = "project"
+)
+
+var sandboxPolicyRejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_sandbox_policy_rejections_total",
+	Help: "Workflow starts rejected by the sandbox policy of their project, by workflow type",
+}, []string{"workflow_type"})
+
+// EffectiveSandboxPolicy is the sandbox policy that applies to a project,
+// with where each of its lists comes from
+type EffectiveSandboxPolicy struct {
+	ProjectID      string `json:"project_id"`
+	OrganizationID string `json:"organization_id,omitempty"`
+	models.SandboxPolicy
+	WorkflowTypesSource string `json:"workflow_types_source"`
+	TaskTypesSource     string `json:"task_types_source"`
+	// A level listing none of the types allowed above it allows none at all
+	NoWorkflowTypes bool `json:"no_workflow_types,omitempty"`
+	NoTaskTypes     bool `json:"no_task_types,omitempty"`
+}
+
+// restrict narrows the lists of the policy to the types policy also lists.
+// A level can only take types away from the levels above it, never add any.
+func (p *EffectiveSandboxPolicy) restrict(policy models.SandboxPolicy, source string) {
+	if len(policy.AllowedWorkflowTypes) > 0 {
+		p.AllowedWorkflowTypes = intersectTypes(p.AllowedWorkflowTypes, policy.AllowedWorkflowTypes)
+		p.NoWorkflowTypes = p.NoWorkflowTypes || len(p.AllowedWorkflowTypes) == 0
+		p.WorkflowTypesSource = source
+	}
+	if len(policy.AllowedTaskTypes) > 0 {
+		p.AllowedTaskTypes = intersectTypes(p.AllowedTaskTypes, policy.AllowedTaskTypes)
+		p.NoTaskTypes = p.NoTaskTypes || len(p.AllowedTaskTypes) == 0
+		p.TaskTypesSource = source
+	}
+}
+
+// intersectTypes returns the types of requested that allowed lists, or all
+// of them when allowed is empty and so allows every type
+func intersectTypes[T comparable](allowed, requested []T) []T {
+	if len(allowed) == 0 {
+		return requested
+	}
+	kept := make([]T, 0, len(requested))
+	for _, t := range requested {
+		if slices.Contains(allowed, t) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// checkWorkflowType rejects workflow types the policy does not allow,
+// listing those it does, and task execution workflows when no task types
+// are allowed
+func (p *EffectiveSandboxPolicy) checkWorkflowType(workflowType models.WorkflowType) error {
+	if p.NoWorkflowTypes {
+		return fmt.Errorf("%w: no workflow types are allowed in project %s", ErrWorkflowTypeNotAllowed, p.ProjectID)
+	}
+	if workflowType == models.WorkflowTypeTaskExecution && p.NoTaskTypes {
+		return fmt.Errorf("%w: %q is not allowed in project %s, which allows no task types",
+			ErrWorkflowTypeNotAllowed, workflowType, p.ProjectID)
+	}
+	if p.AllowsWorkflowType(workflowType) {
+		return nil
+	}
+	allowed := make([]string, len(p.AllowedWorkflowTypes))
+	for i, t := range p.AllowedWorkflowTypes {
+		allowed[i] = string(t)
+	}
+	return fmt.Errorf("%w: %q is not allowed in project %s, allowed types: %s",
+		ErrWorkflowTypeNotAllowed, workflowType, p.ProjectID, strings.Join(allowed, ", "))
+}
+
+// ValidateSandboxPolicy checks that a sandbox policy lists no empty types
+func ValidateSandboxPolicy(policy *models.SandboxPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	for _, t := range policy.AllowedWorkflowTypes {
+		if strings.TrimSpace(string(t)) == "" {
+			return fmt.Errorf("%w: allowed_workflow_types lists an empty type", ErrInvalidSandboxPolicy)
+		}
+	}
+	for _, t := range policy.AllowedTaskTypes {
+		if strings.TrimSpace(t) == "" {
+			return fmt.Errorf("%w: allowed_task_types lists an empty type", ErrInvalidSandboxPolicy)
+		}
+	}
+	return nil
+}
+
+// SandboxPolicies resolves the sandbox policy of projects. The default
+// policy comes from the configuration, as do those of organizations; the
+// lists a project sets in its settings narrow them, and the lists of an
+// organization narrow the defaults, so projects cannot allow types their
+// organization does not. Settings are read when workflows start, so policy
+// changes apply to the workflows started after them.
+type SandboxPolicies struct {
+	db            *gorm.DB
+	defaults      models.SandboxPolicy
+	organizations map[string]models.SandboxPolicy
+}
+
+// NewSandboxPolicies creates the sandbox policies of cfg
+func NewSandboxPolicies(db *gorm.DB, cfg *config.SandboxConfig) *SandboxPolicies {
+	policies := &SandboxPolicies{
+		db:            db,
+		defaults:      sandboxPolicyOf(cfg.AllowedWorkflowTypes, cfg.AllowedTaskTypes),
+		organizations: make(map[string]models.SandboxPolicy, len(cfg.Organizations)),
+	}
+	for organizationID, policy := range cfg.Organizations {
+		policies.organizations[organizationID] = sandboxPolicyOf(policy.AllowedWorkflowTypes, policy.AllowedTaskTypes)
+	}
+	return policies
+}
+
+func sandboxPolicyOf(workflowTypes, taskTypes []string) models.SandboxPolicy {
+	policy := models.SandboxPolicy{AllowedTaskTypes: taskTypes}
+	for _, t := range workflowTypes {
+		policy.AllowedWorkflowTypes = append(policy.AllowedWorkflowTypes, models.WorkflowType(t))
+	}
+	return policy
+}
+
+// Policy returns the effective sandbox policy of a project, or
+// ErrProjectNotFound
+func (s *SandboxPolicies) Policy(ctx context.Context, projectID string) (*EffectiveSandboxPolicy, error) {
+	var project models.Project
+	err := s.db.WithContext(ctx).Select("id", "organization_id", "settings").First(&project, "id = ?", projectID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project settings: %w", err)
+	}
+	return s.policyOf(&project)
+}
+
+// policyOf narrows the defaults to the policy of the project's organization
+// and then to the project's settings
+func (s *SandboxPolicies) policyOf(project *models.Project) (*EffectiveSandboxPolicy, error) {
+	policy := s.defaultPolicy(project.ID)
+	if project.OrganizationID != nil {
+		policy.OrganizationID = *project.OrganizationID
+		if organization, ok := s.organizations[*project.OrganizationID]; ok {
+			policy.restrict(organization, SandboxPolicySourceOrganization)
+		}
+	}
+	settings, err := models.ParseProjectSettings(project.Settings)
+	if err != nil {
+		return nil, err
+	}
+	if settings.SandboxPolicy != nil {
+		policy.restrict(*settings.SandboxPolicy, SandboxPolicySourceProject)
+	}
+	return policy, nil
+}
+
+func (s *SandboxPolicies) defaultPolicy(projectID string) *EffectiveSandboxPolicy {
+	return &EffectiveSandboxPolicy{
+		ProjectID:           projectID,
+		SandboxPolicy:       s.defaults,
+		WorkflowTypesSource: SandboxPolicySourceDefault,
+		TaskTypesSource:     SandboxPolicySourceDefault,
+	}
+}
+
+// SetSandboxPolicies makes the engine enforce the sandbox policies of
+// projects on the workflows they start
+func (e *WorkflowEngine) SetSandboxPolicies(policies *SandboxPolicies) {
+	e.sandboxPolicies = policies
+}
+
+// applySandboxPolicy rejects workflows of types the sandbox policy of their
+// project does not allow, and records in the metadata of task execution
+// workflows the task types they may run
+func (e *WorkflowEngine) applySandboxPolicy(ctx context.Context, workflow *models.Workflow) error {
+	if e.sandboxPolicies == nil {
+		return nil
+	}
+	// Workflows of projects not stored yet get the defaults
+	policy, err := e.sandboxPolicies.Policy(ctx, workflow.ProjectID)
+	if errors.Is(err, ErrProjectNotFound) {
+		policy = e.sandboxPolicies.defaultPolicy(workflow.ProjectID)
+	} else if err != nil {
+		return err
+	}
+	if err := policy.checkWorkflowType(workflow.Type); err != nil {
+		sandboxPolicyRejectionsTotal.WithLabelValues(string(workflow.Type)).Inc()
+		e.logger.Warn("Workflow rejected by sandbox policy",
+			zap.String("project_id", workflow.ProjectID),
+			zap.String("workflow_type", string(workflow.Type)))
+		return err
+	}
+	if workflow.Type != models.WorkflowTypeTaskExecution || len(policy.AllowedTaskTypes) == 0 {
+		return nil
+	}
+
+	metadata := map[string]json.RawMessage{}
+	if len(workflow.Metadata) > 0 {
+		if err := json.Unmarshal(workflow.Metadata, &metadata); err != nil {
+			return fmt.Errorf("failed to parse workflow metadata: %w", err)
+		}
+	}
+	snapshot, err := json.Marshal(models.SandboxPolicy{AllowedTaskTypes: policy.AllowedTaskTypes})
+	if err != nil {
+		return fmt.Errorf("failed to marshal sandbox policy: %w", err)
+	}
+	metadata[SandboxPolicyMetadataKey] = snapshot
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow metadata: %w", err)
+	}
+	workflow.Metadata = data
+	return nil
+}
+
+// auditSandboxPolicyChange records a change to the sandbox policy a
+// project sets in its settings, if there is one
+func (s *ProjectService) auditSandboxPolicyChange(ctx context.Context, projectID, actor string, before, after json.RawMessage) {
+	// Settings stored before validation existed may not parse
+	from, _ := models.ParseProjectSettings(before)
+	to, err := models.ParseProjectSettings(after)
+	if err != nil {
+		return
+	}
+	if reflect.DeepEqual(from.SandboxPolicy, to.SandboxPolicy) {
+		return
+	}
+
+	details, err := json.Marshal(map[string]interface{}{
+		"before": from.SandboxPolicy,
+		"after":  to.SandboxPolicy,
+	})
+	if err != nil {
+		s.logger.Error("failed to marshal audit details", zap.Error(err))
+		return
+	}
+	entry := &models.AuditLog{
+		EntityType: "project",
+		EntityID:   projectID,
+		Action:     SandboxPolicyChangedAction,
+		Actor:      actor,
+		Details:    details,
+	}
+	if err := s.db.WithContext(ctx).Create(entry).Error; err != nil {
+		s.logger.Error("failed to write audit log", zap.String("project_id", projectID), zap.Error(err))
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// testSandboxConfig allows intent processing, task execution and custom
+// workflows by default; org-1 only task execution and org-2 only testing
+// tasks
+var testSandboxConfig = config.SandboxConfig{
+	AllowedWorkflowTypes: []string{"intent_processing", "task_execution", "custom"},
+	AllowedTaskTypes:     []string{"code_generation", "documentation", "testing"},
+	Organizations: map[string]config.SandboxPolicyConfig{
+		"org-1": {AllowedWorkflowTypes: []string{"task_execution"}},
+		"org-2": {AllowedTaskTypes: []string{"testing"}},
+	},
+}
+
+// createSandboxProjects stores project-a of org-1, allowing code generation
+// tasks only, project-b without organization or settings and project-c of
+// org-2, allowing custom workflows only
+func createSandboxProjects(t *testing.T, db *gorm.DB) {
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, organization_id TEXT, settings BLOB,
+			updated_by TEXT, updated_at DATETIME, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, organization_id, settings) VALUES
+			('project-a', 'org-1', CAST('{"sandbox_policy": {"allowed_task_types": ["code_generation"]}}' AS BLOB)),
+			('project-b', NULL, NULL),
+			('project-c', 'org-2', CAST('{"sandbox_policy": {"allowed_workflow_types": ["custom"]}}' AS BLOB))`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+}
+
+func TestSandboxPolicies_MergePrecedence(t *testing.T) {
+	ctx := context.Background()
+	db := setupWorkflowTestDB(t)
+	createSandboxProjects(t, db)
+	policies := NewSandboxPolicies(db, &testSandboxConfig)
+
+	// Project lists narrow those of the organization, which narrow the
+	// defaults, list by list
+	policy, err := policies.Policy(ctx, "project-a")
+	require.NoError(t, err)
+	assert.Equal(t, "org-1", policy.OrganizationID)
+	assert.Equal(t, []models.WorkflowType{models.WorkflowTypeTaskExecution}, policy.AllowedWorkflowTypes)
+	assert.Equal(t, SandboxPolicySourceOrganization, policy.WorkflowTypesSource)
+	assert.Equal(t, []string{"code_generation"}, policy.AllowedTaskTypes)
+	assert.Equal(t, SandboxPolicySourceProject, policy.TaskTypesSource)
+
+	policy, err = policies.Policy(ctx, "project-b")
+	require.NoError(t, err)
+	assert.Len(t, policy.AllowedWorkflowTypes, 3)
+	assert.Equal(t, []string{"code_generation", "documentation", "testing"}, policy.AllowedTaskTypes)
+	assert.Equal(t, SandboxPolicySourceDefault, policy.WorkflowTypesSource)
+	assert.Equal(t, SandboxPolicySourceDefault, policy.TaskTypesSource)
+
+	policy, err = policies.Policy(ctx, "project-c")
+	require.NoError(t, err)
+	assert.Equal(t, []models.WorkflowType{models.WorkflowTypeCustom}, policy.AllowedWorkflowTypes)
+	assert.Equal(t, SandboxPolicySourceProject, policy.WorkflowTypesSource)
+	assert.Equal(t, []string{"testing"}, policy.AllowedTaskTypes)
+	assert.Equal(t, SandboxPolicySourceOrganization, policy.TaskTypesSource)
+
+	_, err = policies.Policy(ctx, "project-z")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+
+	// Without configured lists every type is allowed
+	policy, err = NewSandboxPolicies(db, &config.SandboxConfig{}).Policy(ctx, "project-b")
+	require.NoError(t, err)
+	assert.True(t, policy.AllowsWorkflowType(models.WorkflowTypeDeployment))
+	assert.True(t, policy.AllowsTaskType("anything"))
+}
+
+func TestSandboxPolicies_ProjectsCannotWiden(t *testing.T) {
+	ctx := context.Background()
+	db := setupWorkflowTestDB(t)
+	createSandboxProjects(t, db)
+	policies := NewSandboxPolicies(db, &testSandboxConfig)
+
+	// project-a tries to allow workflow types org-1 does not, and task types
+	// the defaults do not
+	require.NoError(t, db.Exec(`UPDATE projects SET settings = ? WHERE id = 'project-a'`, []byte(`{"sandbox_policy": {
+		"allowed_workflow_types": ["task_execution", "deployment", "code_analysis"],
+		"allowed_task_types": ["documentation", "shell"]}}`)).Error)
+	policy, err := policies.Policy(ctx, "project-a")
+	require.NoError(t, err)
+	assert.Equal(t, []models.WorkflowType{models.WorkflowTypeTaskExecution}, policy.AllowedWorkflowTypes)
+	assert.Equal(t, SandboxPolicySourceProject, policy.WorkflowTypesSource)
+	assert.Equal(t, []string{"documentation"}, policy.AllowedTaskTypes)
+	assert.False(t, policy.AllowsWorkflowType(models.WorkflowTypeDeployment))
+	assert.False(t, policy.AllowsTaskType("shell"))
+
+	// A project listing only types its organization does not allow allows
+	// none, rather than every type
+	require.NoError(t, db.Exec(`UPDATE projects SET settings = ? WHERE id = 'project-a'`,
+		[]byte(`{"sandbox_policy": {"allowed_workflow_types": ["deployment"], "allowed_task_types": ["shell"]}}`)).Error)
+	policy, err = policies.Policy(ctx, "project-a")
+	require.NoError(t, err)
+	assert.True(t, policy.NoWorkflowTypes)
+	assert.True(t, policy.NoTaskTypes)
+	for _, workflowType := range []models.WorkflowType{models.WorkflowTypeDeployment, models.WorkflowTypeTaskExecution} {
+		assert.ErrorIs(t, policy.checkWorkflowType(workflowType), ErrWorkflowTypeNotAllowed, workflowType)
+	}
+
+	// Organizations cannot widen the defaults either
+	policies = NewSandboxPolicies(db, &config.SandboxConfig{
+		AllowedTaskTypes: []string{"documentation"},
+		Organizations:    map[string]config.SandboxPolicyConfig{"org-2": {AllowedTaskTypes: []string{"documentation", "shell"}}},
+	})
+	policy, err = policies.Policy(ctx, "project-c")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"documentation"}, policy.AllowedTaskTypes)
+	assert.Equal(t, SandboxPolicySourceOrganization, policy.TaskTypesSource)
+}
+
+func TestWorkflowEngine_StartWorkflowEnforcesSandboxPolicy(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	createSandboxProjects(t, db)
+	engine.SetSandboxPolicies(NewSandboxPolicies(db, &testSandboxConfig))
+
+	_, err := engine.StartWorkflow(ctx, &StartWorkflowRequest{
+		Name: "analysis", Type: string(models.WorkflowTypeAnalysis), ProjectID: "project-a", UserID: "alice",
+	})
+	assert.ErrorIs(t, err, ErrWorkflowTypeNotAllowed)
+	assert.EqualError(t, err, `workflow type not allowed by sandbox policy: "code_analysis" is not allowed in project project-a, allowed types: task_execution`)
+	temporalClient.AssertNotCalled(t, "ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Drafts are checked when submitted
+	draft, err := engine.CreateWorkflowDraft(ctx, &StartWorkflowRequest{
+		Name: "intent", Type: string(models.WorkflowTypeIntent), ProjectID: "project-c", UserID: "alice",
+		Input: json.RawMessage(`{"text": "build it"}`),
+	})
+	require.NoError(t, err)
+	_, err = engine.SubmitWorkflowDraft(ctx, draft.ID, "alice")
+	assert.ErrorIs(t, err, ErrWorkflowTypeNotAllowed)
+
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, "TaskExecutionWorkflow", mock.Anything).Return(run, nil)
+
+	// Task execution workflows carry the task types allowed when they start
+	start := func() *models.Workflow {
+		response, err := engine.StartWorkflow(ctx, &StartWorkflowRequest{
+			Name: "tasks", Type: string(models.WorkflowTypeTaskExecution), ProjectID: "project-a", UserID: "alice",
+		})
+		require.NoError(t, err)
+		var workflow models.Workflow
+		require.NoError(t, db.First(&workflow, "id = ?", response.WorkflowID).Error)
+		return &workflow
+	}
+	first := start()
+	assert.JSONEq(t, `{"sandbox_policy": {"allowed_task_types": ["code_generation"]}}`, string(first.Metadata))
+
+	// Policy changes apply to the workflows started after them only
+	require.NoError(t, db.Exec(`UPDATE projects SET settings = ? WHERE id = 'project-a'`,
+		[]byte(`{"sandbox_policy": {"allowed_task_types": ["documentation"]}}`)).Error)
+	second := start()
+	assert.JSONEq(t, `{"sandbox_policy": {"allowed_task_types": ["documentation"]}}`, string(second.Metadata))
+	require.NoError(t, db.First(first, "id = ?", first.ID).Error)
+	assert.JSONEq(t, `{"sandbox_policy": {"allowed_task_types": ["code_generation"]}}`, string(first.Metadata))
+}
+
+func TestProjectService_AuditsSandboxPolicyChanges(t *testing.T) {
+	ctx := context.Background()
+	db := setupWorkflowTestDB(t)
+	createSandboxProjects(t, db)
+	for _, stmt := range []string{
+		`CREATE TABLE project_members (id TEXT PRIMARY KEY, project_id TEXT, user_id TEXT, role TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE environments (id TEXT PRIMARY KEY, project_id TEXT, name TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE audit_logs (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), entity_type TEXT,
+			entity_id TEXT, action TEXT, actor TEXT, details BLOB, created_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	service := NewProjectService(db, zap.NewNop())
+
+	// Settings changes leaving the policy alone are not audited
+	_, err := service.UpdateProject(ctx, "project-a", &UpdateProjectRequest{
+		Settings:  json.RawMessage(`{"scheduling_weight": 2, "sandbox_policy": {"allowed_task_types": ["code_generation"]}}`),
+		UpdatedBy: "alice",
+	})
+	require.NoError(t, err)
+	var entries []models.AuditLog
+	require.NoError(t, db.Find(&entries).Error)
+	assert.Empty(t, entries)
+
+	_, err = service.UpdateProject(ctx, "project-a", &UpdateProjectRequest{
+		Settings:  json.RawMessage(`{"sandbox_policy": {"allowed_workflow_types": ["custom"], "allowed_task_types": ["testing"]}}`),
+		UpdatedBy: "bob",
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Find(&entries).Error)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "project", entries[0].EntityType)
+	assert.Equal(t, "project-a", entries[0].EntityID)
+	assert.Equal(t, SandboxPolicyChangedAction, entries[0].Action)
+	assert.Equal(t, "bob", entries[0].Actor)
+	assert.JSONEq(t, `{
+		"before": {"allowed_task_types": ["code_generation"]},
+		"after": {"allowed_workflow_types": ["custom"], "allowed_task_types": ["testing"]}
+	}`, string(entries[0].Details))
+
+	_, err = service.UpdateProject(ctx, "project-a", &UpdateProjectRequest{
+		Settings:  json.RawMessage(`{"sandbox_policy": {"allowed_task_types": [" "]}}`),
+		UpdatedBy: "bob",
+	})
+	assert.ErrorIs(t, err, ErrInvalidSandboxPolicy)
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrSCMAuth is returned when a source control provider needs a token
+	// the project does not have, or rejects it
+	ErrSCMAuth = errors.New("source control authentication failed")
+	// ErrSCMNotFound is returned for unknown repositories and pull requests
+	ErrSCMNotFound = errors.New("pull request not found")
+	// ErrSCMRateLimited is returned when a rate limit does not reset within
+	// the configured backoff
+	ErrSCMRateLimited = errors.New("source control rate limit exceeded")
+)
+
+// SCMProviderGitHub is the name of the GitHub provider
+const SCMProviderGitHub = "github"
+
+// Statuses of the files of a pull request
+const (
+	PullRequestFileAdded    = "added"
+	PullRequestFileModified = "modified"
+	PullRequestFileRemoved  = "removed"
+	PullRequestFileRenamed  = "renamed"
+)
+
+// PullRequestRef identifies a pull request and the project whose
+// integration accesses it
+type PullRequestRef struct {
+	ProjectID   string
+	Repository  string // owner/name, or the URL of the repository
+	Number      int
+	Integration string // Integration holding the token; matched by provider when empty
+}
+
+// PullRequest is the metadata and diff of a pull request
+type PullRequest struct {
+	Number     int
+	Title      string
+	Author     string
+	URL        string
+	BaseBranch string
+	HeadBranch string
+	BaseCommit string
+	HeadCommit string
+	Files      []PullRequestFile
+	Additions  int
+	Deletions  int
+	Diff       string // Unified diff of the whole pull request
+}
+
+// PullRequestFile is the diff of one file of a pull request
+type PullRequestFile struct {
+	Path         string `json:"path"`
+	PreviousPath string `json:"previous_path,omitempty"` // Set for renamed files
+	Status       string `json:"status"`
+	Additions    int    `json:"additions"`
+	Deletions    int    `json:"deletions"`
+	Patch        string `json:"patch,omitempty"` // Hunks, from the first @@ header
+	Binary       bool   `json:"binary,omitempty"`
+}
+
+// ReviewComment is a review comment on a line of the new version of a file
+type ReviewComment struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Body     string `json:"body"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// PullRequestReview is a review to post on a pull request
+type PullRequestReview struct {
+	// Key identifies the review, so that posting it again is a no-op
+	Key string
+	// Commit is the head commit the comments were made on, and Files its
+	// diff, which places the comments
+	Commit   string
+	Files    []PullRequestFile
+	Comments []ReviewComment
+	// Summary is posted as a comment of its own
+	Summary string
+}
+
+// PostedReview reports what posting a review did
+type PostedReview struct {
+	Inline        int  // Comments placed on lines of the diff
+	Unplaced      int  // Comments on lines outside the diff, added to the review body
+	AlreadyPosted bool // The review and summary were posted by an earlier attempt
+}
+
+// SCMProvider reads pull requests from a source control host and posts
+// reviews to them
+type SCMProvider interface {
+	FetchPullRequest(ctx context.Context, ref *PullRequestRef) (*PullRequest, error)
+	PostReview(ctx context.Context, ref *PullRequestRef, review *PullRequestReview) (*PostedReview, error)
+}
+
+// reviewMarker is the hidden marker identifying posted reviews and summaries
+// by key
+func reviewMarker(kind, key string) string {
+	return fmt.Sprintf("<!-- orchestrator-%s:%s -->", kind, key)
+}
+
+// ParseUnifiedDiff splits a git unified diff into the diffs of its files,
+// with their statuses and line counts
+func ParseUnifiedDiff(diff string) ([]PullRequestFile, error) {
+	var files []PullRequestFile
+	var file *PullRequestFile
+	var patch strings.Builder
+	inHunks := false
+
+	flush := func() {
+		if file == nil {
+			return
+		}
+		file.Patch = strings.TrimSuffix(patch.String(), "\n")
+		files = append(files, *file)
+		patch.Reset()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "diff --git ") {
+			flush()
+			oldPath, newPath, err := parseDiffGitHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			file = &PullRequestFile{Path: newPath, Status: PullRequestFileModified}
+			if oldPath != newPath {
+				file.PreviousPath = oldPath
+				file.Status = PullRequestFileRenamed
+			}
+			inHunks = false
+			continue
+		}
+		if file == nil {
+			continue
+		}
+
+		if strings.HasPrefix(line, "@@") {
+			inHunks = true
+		}
+		if inHunks {
+			patch.WriteString(line)
+			patch.WriteByte('\n')
+			switch {
+			case strings.HasPrefix(line, "+"):
+				file.Additions++
+			case strings.HasPrefix(line, "-"):
+				file.Deletions++
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "new file mode"):
+			file.Status = PullRequestFileAdded
+		case strings.HasPrefix(line, "deleted file mode"):
+			file.Status = PullRequestFileRemoved
+		case strings.HasPrefix(line, "rename from "):
+			file.PreviousPath = unquoteDiffPath(strings.TrimPrefix(line, "rename from "))
+			file.Status = PullRequestFileRenamed
+		case strings.HasPrefix(line, "rename to "):
+			file.Path = unquoteDiffPath(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "Binary files "), strings.HasPrefix(line, "GIT binary patch"):
+			file.Binary = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read diff: %w", err)
+	}
+	flush()
+	return files, nil
+}
+
+// parseDiffGitHeader returns the paths of a "diff --git a/old b/new" line
+func parseDiffGitHeader(line string) (string, string, error) {
+	rest := strings.TrimPrefix(line, "diff --git ")
+	var paths []string
+	for rest != "" && len(paths) < 2 {
+		if rest[0] == '"' {
+			// Quoted paths end at the first unescaped quote
+			end := 1
+			for end < len(rest) && (rest[end] != '"' || rest[end-1] == '\\') {
+				end++
+			}
+			if end == len(rest) {
+				return "", "", fmt.Errorf("invalid diff header: %s", line)
+			}
+			paths = append(paths, unquoteDiffPath(rest[:end+1]))
+			rest = strings.TrimPrefix(rest[end+1:], " ")
+			continue
+		}
+		// Unquoted paths are split where the new path begins; renames are
+		// corrected by the rename lines that follow
+		if len(paths) == 0 {
+			if i := strings.Index(rest, " b/"); i >= 0 {
+				paths = append(paths, rest[:i])
+				rest = rest[i+1:]
+				continue
+			}
+		}
+		paths = append(paths, rest)
+		rest = ""
+	}
+	if len(paths) != 2 || !strings.HasPrefix(paths[0], "a/") || !strings.HasPrefix(paths[1], "b/") {
+		return "", "", fmt.Errorf("invalid diff header: %s", line)
+	}
+	return strings.TrimPrefix(paths[0], "a/"), strings.TrimPrefix(paths[1], "b/"), nil
+}
+
+// unquoteDiffPath unquotes the C-style quoted paths git writes for names
+// with special characters
+func unquoteDiffPath(path string) string {
+	if strings.HasPrefix(path, `"`) {
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			return unquoted
+		}
+	}
+	return path
+}
+
+// diffPosition returns the position of a line of the new version of a file
+// in its patch: the number of lines below the first @@ header, counting the
+// headers of later hunks. Lines outside the hunks have no position.
+func diffPosition(patch string, line int) (int, bool) {
+	position := -1
+	newLine := 0
+	for _, text := range strings.Split(patch, "\n") {
+		position++
+		if strings.HasPrefix(text, "@@") {
+			start, ok := hunkNewStart(text)
+			if !ok {
+				return 0, false
+			}
+			newLine = start
+			continue
+		}
+		if position == 0 {
+			// Not a patch
+			return 0, false
+		}
+		switch {
+		case strings.HasPrefix(text, "-"), strings.HasPrefix(text, `\`):
+			continue
+		case newLine == line:
+			return position, true
+		}
+		newLine++
+	}
+	return 0, false
+}
+
+// hunkNewStart returns the first new line of a "@@ -a,b +c,d @@" hunk header
+func hunkNewStart(header string) (int, bool) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return 0, false
+	}
+	start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+	n, err := strconv.Atoi(start)
+	return n, err == nil
+}
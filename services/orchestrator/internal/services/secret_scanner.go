@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"orchestrator/internal/config"
+)
+
+// SecretScannerName names the secret scanner in findings
+const SecretScannerName = "secrets"
+
+// secretRule finds one kind of secret, whose value is the pattern's capture
+// group. Rules with a minimum entropy only report values random enough to be
+// real credentials rather than placeholders.
+type secretRule struct {
+	name       string
+	pattern    *regexp.Regexp
+	group      int
+	severity   string
+	minEntropy bool
+}
+
+var secretRules = []secretRule{
+	{
+		name:     "private_key",
+		pattern:  regexp.MustCompile(`-----BEGIN ((RSA|EC|DSA|OPENSSH|PGP|ENCRYPTED) )?PRIVATE KEY( BLOCK)?-----`),
+		severity: SecuritySeverityCritical,
+	},
+	{
+		name:     "aws_access_key",
+		pattern:  regexp.MustCompile(`\b((AKIA|ASIA)[0-9A-Z]{16})\b`),
+		group:    1,
+		severity: SecuritySeverityHigh,
+	},
+	{
+		name:       "aws_secret_key",
+		pattern:    regexp.MustCompile(`(?i)aws_?secret_?(access_?)?key\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`),
+		group:      2,
+		severity:   SecuritySeverityCritical,
+		minEntropy: true,
+	},
+	{
+		name:     "github_token",
+		pattern:  regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{50,})\b`),
+		group:    1,
+		severity: SecuritySeverityHigh,
+	},
+	{
+		name:     "slack_token",
+		pattern:  regexp.MustCompile(`\b(xox[abposr]-[A-Za-z0-9-]{10,})\b`),
+		group:    1,
+		severity: SecuritySeverityHigh,
+	},
+	{
+		name:       "generic_token",
+		pattern:    regexp.MustCompile(`(?i)(api_?key|secret|token|passw(or)?d|credential)[a-z_]*["']?\s*[:=]\s*["']([^"'\s]{16,})["']`),
+		group:      3,
+		severity:   SecuritySeverityMedium,
+		minEntropy: true,
+	},
+}
+
+// secretScanner runs the secret rules over every line of the code. The code
+// may hold an allowlist file whose lines each allowlist findings:
+// "path:<glob>" skips files, "rule:<name>" disables a rule, and any other
+// line is a regular expression matched against the values found.
+type secretScanner struct {
+	allowlistFile string
+	minEntropy    float64
+}
+
+// NewSecretScanner creates a scanner reading the allowlist from
+// cfg.SecretAllowlistFile in the code scanned
+func NewSecretScanner(cfg *config.SecurityAnalysisConfig) SecurityScanner {
+	return &secretScanner{allowlistFile: cfg.SecretAllowlistFile, minEntropy: cfg.MinSecretEntropy}
+}
+
+func (s *secretScanner) Name() string {
+	return SecretScannerName
+}
+
+// secretAllowlist is a parsed allowlist file
+type secretAllowlist struct {
+	paths  []string
+	rules  map[string]bool
+	values []*regexp.Regexp
+}
+
+func parseSecretAllowlist(data string) *secretAllowlist {
+	allowlist := &secretAllowlist{rules: make(map[string]bool)}
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "path:"):
+			allowlist.paths = append(allowlist.paths, strings.TrimSpace(strings.TrimPrefix(line, "path:")))
+		case strings.HasPrefix(line, "rule:"):
+			allowlist.rules[strings.TrimSpace(strings.TrimPrefix(line, "rule:"))] = true
+		default:
+			// Lines that are not valid expressions match literally
+			pattern, err := regexp.Compile(line)
+			if err != nil {
+				pattern = regexp.MustCompile(regexp.QuoteMeta(line))
+			}
+			allowlist.values = append(allowlist.values, pattern)
+		}
+	}
+	return allowlist
+}
+
+func (a *secretAllowlist) allowsFile(file string) bool {
+	for _, glob := range a.paths {
+		if ok, _ := path.Match(glob, file); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(file)); ok {
+			return true
+		}
+		// Globs ending in a directory allow the files under it
+		if strings.HasPrefix(file, strings.TrimSuffix(glob, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *secretAllowlist) allowsValue(value string) bool {
+	for _, pattern := range a.values {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan reports the secrets found in content, in file and line order. Values
+// are redacted in the findings.
+func (s *secretScanner) Scan(ctx context.Context, content map[string]string) (*SecurityScan, error) {
+	allowlist := parseSecretAllowlist(content[s.allowlistFile])
+	files := make([]string, 0, len(content))
+	for file := range content {
+		if file != s.allowlistFile && !allowlist.allowsFile(file) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	scan := &SecurityScan{Findings: []SecurityFinding{}}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for i, line := range strings.Split(content[file], "\n") {
+			// A value matched by several rules is reported by the first
+			reported := make(map[string]bool)
+			for _, rule := range secretRules {
+				if allowlist.rules[rule.name] {
+					continue
+				}
+				for _, match := range rule.pattern.FindAllStringSubmatch(line, -1) {
+					value := match[rule.group]
+					if rule.minEntropy && shannonEntropy(value) < s.minEntropy {
+						continue
+					}
+					if reported[value] || allowlist.allowsValue(value) {
+						continue
+					}
+					reported[value] = true
+					summary := "Possible " + strings.ReplaceAll(rule.name, "_", " ")
+					if rule.group > 0 {
+						summary += " " + redactSecret(value)
+					}
+					scan.Findings = append(scan.Findings, SecurityFinding{
+						Scanner:  SecretScannerName,
+						Type:     SecurityFindingSecret,
+						Severity: rule.severity,
+						Summary:  summary,
+						File:     file,
+						Line:     i + 1,
+						Rule:     rule.name,
+					})
+				}
+			}
+		}
+	}
+	return scan, nil
+}
+
+// shannonEntropy returns the bits of entropy per character of a value
+func shannonEntropy(value string) float64 {
+	if value == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range value {
+		counts[r]++
+	}
+	length := float64(len([]rune(value)))
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// redactSecret keeps the first 4 characters of a value, enough to locate it
+func redactSecret(value string) string {
+	if len(value) <= 8 {
+		return "****"
+	}
+	return value[:4] + "****"
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// Security finding types
+const (
+	SecurityFindingVulnerability = "vulnerability"
+	SecurityFindingSecret        = "secret"
+)
+
+// Severities of security findings, from the most severe
+const (
+	SecuritySeverityCritical = "critical"
+	SecuritySeverityHigh     = "high"
+	SecuritySeverityMedium   = "medium"
+	SecuritySeverityLow      = "low"
+	SecuritySeverityUnknown  = "unknown"
+)
+
+// securityAnalysisRevision is bumped when a change to the scanners changes
+// the reports they produce, invalidating cached reports
+const securityAnalysisRevision = 1
+
+// securitySeverityWeights are the chances a finding of each severity is
+// taken to make the code exploitable, from which the risk score is computed
+var securitySeverityWeights = map[string]float64{
+	SecuritySeverityCritical: 0.5,
+	SecuritySeverityHigh:     0.25,
+	SecuritySeverityMedium:   0.1,
+	SecuritySeverityLow:      0.03,
+	SecuritySeverityUnknown:  0.1,
+}
+
+// SecurityFinding is a vulnerable dependency or a secret found in the code
+type SecurityFinding struct {
+	Scanner  string `json:"scanner"`
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+
+	// Vulnerable dependencies
+	ID           string   `json:"id,omitempty"`      // OSV ID
+	Aliases      []string `json:"aliases,omitempty"` // CVE and other IDs of the vulnerability
+	Ecosystem    string   `json:"ecosystem,omitempty"`
+	Package      string   `json:"package,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	FixedVersion string   `json:"fixed_version,omitempty"` // Empty when no version fixes it
+
+	// Secrets and the manifests dependencies are declared in
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	Rule string `json:"rule,omitempty"`
+}
+
+// SecurityScan is the outcome of one scanner. A degraded scan could not
+// check everything, e.g. because a vulnerability database was unreachable.
+type SecurityScan struct {
+	Findings       []SecurityFinding
+	Degraded       bool
+	DegradedReason string
+}
+
+// SecurityScanner checks code, keyed by path, for one kind of security issue
+type SecurityScanner interface {
+	Name() string
+	Scan(ctx context.Context, content map[string]string) (*SecurityScan, error)
+}
+
+// SecurityReport is the outcome of the security analysis of some code
+type SecurityReport struct {
+	Findings        []SecurityFinding `json:"findings"`
+	RiskScore       float64           `json:"risk_score"`
+	Recommendations []string          `json:"recommendations"`
+	Degraded        bool              `json:"degraded"`
+	DegradedReasons []string          `json:"degraded_reasons,omitempty"`
+}
+
+// SecurityAnalyzer runs the security scanners over code and scores the
+// findings
+type SecurityAnalyzer struct {
+	scanners []SecurityScanner
+	version  string
+	logger   *zap.Logger
+}
+
+// NewSecurityAnalyzer creates an analyzer checking dependencies against OSV
+// and content for secrets
+func NewSecurityAnalyzer(cfg *config.SecurityAnalysisConfig, logger *zap.Logger) *SecurityAnalyzer {
+	return &SecurityAnalyzer{
+		scanners: []SecurityScanner{
+			NewDependencyScanner(cfg, logger),
+			NewSecretScanner(cfg),
+		},
+		version: fmt.Sprintf("security/%d, min_secret_entropy %g", securityAnalysisRevision, cfg.MinSecretEntropy),
+		logger:  logger,
+	}
+}
+
+// Version identifies the scanners and their settings, so that reports
+// cached before they changed are not reused
+func (s *SecurityAnalyzer) Version() string {
+	return s.version
+}
+
+// Analyze runs every scanner over content. Scanners that fail degrade the
+// report rather than failing the analysis.
+func (s *SecurityAnalyzer) Analyze(ctx context.Context, content map[string]string) (*SecurityReport, error) {
+	report := &SecurityReport{Findings: []SecurityFinding{}}
+	for _, scanner := range s.scanners {
+		scan, err := scanner.Scan(ctx, content)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			s.logger.Warn("Security scanner failed", zap.String("scanner", scanner.Name()), zap.Error(err))
+			scan = &SecurityScan{Degraded: true, DegradedReason: err.Error()}
+		}
+		report.Findings = append(report.Findings, scan.Findings...)
+		if scan.Degraded {
+			report.Degraded = true
+			report.DegradedReasons = append(report.DegradedReasons, fmt.Sprintf("%s: %s", scanner.Name(), scan.DegradedReason))
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return severityRank(report.Findings[i].Severity) < severityRank(report.Findings[j].Severity)
+	})
+	report.RiskScore = RiskScore(report.Findings)
+	report.Recommendations = securityRecommendations(report)
+	return report, nil
+}
+
+// RiskScore is the chance, from 0 to 1, that at least one finding makes the
+// code exploitable, taking each finding to do so independently with the
+// weight of its severity: 1 - Π(1 - weight). One critical finding scores
+// 0.5, two 0.75; ten low findings score 0.26.
+func RiskScore(findings []SecurityFinding) float64 {
+	safe := 1.0
+	for _, finding := range findings {
+		weight, ok := securitySeverityWeights[finding.Severity]
+		if !ok {
+			weight = securitySeverityWeights[SecuritySeverityUnknown]
+		}
+		safe *= 1 - weight
+	}
+	return math.Round((1-safe)*100) / 100
+}
+
+// severityRank orders severities from the most severe
+func severityRank(severity string) int {
+	switch severity {
+	case SecuritySeverityCritical:
+		return 0
+	case SecuritySeverityHigh:
+		return 1
+	case SecuritySeverityMedium:
+		return 2
+	case SecuritySeverityLow:
+		return 3
+	}
+	return 4
+}
+
+// securityRecommendations suggests how to address the findings of a report:
+// upgrading each vulnerable package to the highest version fixing its
+// vulnerabilities, replacing packages with unfixed ones, and removing and
+// rotating secrets
+func securityRecommendations(report *SecurityReport) []string {
+	type upgrade struct {
+		fixed   string
+		ids     []string
+		unfixed []string
+	}
+	upgrades := make(map[string]*upgrade)
+	var packages []string
+	recommendations := []string{}
+	for _, finding := range report.Findings {
+		switch finding.Type {
+		case SecurityFindingVulnerability:
+			key := finding.Package + "@" + finding.Version
+			u, ok := upgrades[key]
+			if !ok {
+				u = &upgrade{}
+				upgrades[key] = u
+				packages = append(packages, key)
+			}
+			id := finding.ID
+			if len(finding.Aliases) > 0 {
+				id += " (" + strings.Join(finding.Aliases, ", ") + ")"
+			}
+			if finding.FixedVersion == "" {
+				u.unfixed = append(u.unfixed, id)
+				continue
+			}
+			u.ids = append(u.ids, id)
+			if compareVersions(finding.FixedVersion, u.fixed) > 0 {
+				u.fixed = finding.FixedVersion
+			}
+		case SecurityFindingSecret:
+			recommendations = append(recommendations, fmt.Sprintf(
+				"Remove the %s in %s:%d from the repository and rotate it", strings.ReplaceAll(finding.Rule, "_", " "), finding.File, finding.Line))
+		}
+	}
+
+	for _, key := range packages {
+		u := upgrades[key]
+		if u.fixed != "" {
+			recommendations = append(recommendations, fmt.Sprintf("Upgrade %s to %s to fix %s", key, u.fixed, strings.Join(u.ids, ", ")))
+		}
+		if len(u.unfixed) > 0 {
+			recommendations = append(recommendations, fmt.Sprintf(
+				"No release of %s fixes %s; consider replacing it", key, strings.Join(u.unfixed, ", ")))
+		}
+	}
+	if report.Degraded {
+		recommendations = append(recommendations, "Re-run the analysis: some checks could not complete")
+	}
+	return recommendations
+}
+
+// compareVersions compares dotted versions numerically, ignoring a "v"
+// prefix and pre-release suffixes; the empty version is the lowest
+func compareVersions(a, b string) int {
+	if a == "" || b == "" {
+		return strings.Compare(a, b)
+	}
+	parse := func(version string) []int {
+		version = strings.TrimPrefix(version, "v")
+		if i := strings.IndexAny(version, "-+"); i >= 0 {
+			version = version[:i]
+		}
+		var parts []int
+		for _, part := range strings.Split(version, ".") {
+			n := 0
+			fmt.Sscanf(part, "%d", &n)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+	pa, pb := parse(a), parse(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// fakeSecurityScanner returns canned findings or an error
+type fakeSecurityScanner struct {
+	name string
+	scan *SecurityScan
+	err  error
+}
+
+func (s *fakeSecurityScanner) Name() string {
+	return s.name
+}
+
+func (s *fakeSecurityScanner) Scan(ctx context.Context, content map[string]string) (*SecurityScan, error) {
+	return s.scan, s.err
+}
+
+func TestRiskScore(t *testing.T) {
+	finding := func(severity string) SecurityFinding {
+		return SecurityFinding{Severity: severity}
+	}
+	lows := make([]SecurityFinding, 10)
+	for i := range lows {
+		lows[i] = finding(SecuritySeverityLow)
+	}
+
+	assert.Equal(t, 0.0, RiskScore(nil))
+	assert.Equal(t, 0.5, RiskScore([]SecurityFinding{finding(SecuritySeverityCritical)}))
+	assert.Equal(t, 0.75, RiskScore([]SecurityFinding{finding(SecuritySeverityCritical), finding(SecuritySeverityCritical)}))
+	assert.Equal(t, 0.26, RiskScore(lows))
+	// 1 - 0.75 * 0.9 * 0.9
+	assert.Equal(t, 0.39, RiskScore([]SecurityFinding{
+		finding(SecuritySeverityHigh), finding(SecuritySeverityMedium), finding(SecuritySeverityUnknown),
+	}))
+}
+
+func TestSecurityAnalyzer_Analyze(t *testing.T) {
+	analyzer := &SecurityAnalyzer{
+		scanners: []SecurityScanner{
+			&fakeSecurityScanner{name: "osv", scan: &SecurityScan{Findings: []SecurityFinding{
+				{Type: SecurityFindingVulnerability, Severity: SecuritySeverityMedium, ID: "GHSA-1",
+					Aliases: []string{"CVE-2024-1"}, Package: "lodash", Version: "4.17.20", FixedVersion: "4.17.21"},
+				{Type: SecurityFindingVulnerability, Severity: SecuritySeverityHigh, ID: "GHSA-2",
+					Package: "lodash", Version: "4.17.20", FixedVersion: "4.17.23"},
+				{Type: SecurityFindingVulnerability, Severity: SecuritySeverityLow, ID: "GO-3",
+					Package: "example.com/lib", Version: "1.0.0"},
+			}}},
+			&fakeSecurityScanner{name: "secrets", scan: &SecurityScan{Findings: []SecurityFinding{
+				{Type: SecurityFindingSecret, Severity: SecuritySeverityCritical, File: "deploy/id_rsa", Line: 1, Rule: "private_key"},
+			}}},
+			&fakeSecurityScanner{name: "broken", err: errors.New("scanner crashed")},
+		},
+		logger: zap.NewNop(),
+	}
+
+	report, err := analyzer.Analyze(context.Background(), map[string]string{})
+	require.NoError(t, err)
+
+	// Most severe first
+	var ids []string
+	for _, finding := range report.Findings {
+		ids = append(ids, finding.ID+finding.Rule)
+	}
+	assert.Equal(t, []string{"private_key", "GHSA-2", "GHSA-1", "GO-3"}, ids)
+	// 1 - 0.5 * 0.75 * 0.9 * 0.97
+	assert.Equal(t, 0.67, report.RiskScore)
+
+	assert.True(t, report.Degraded)
+	assert.Equal(t, []string{"broken: scanner crashed"}, report.DegradedReasons)
+	assert.Equal(t, []string{
+		"Remove the private key in deploy/id_rsa:1 from the repository and rotate it",
+		"Upgrade lodash@4.17.20 to 4.17.23 to fix GHSA-2, GHSA-1 (CVE-2024-1)",
+		"No release of example.com/lib@1.0.0 fixes GO-3; consider replacing it",
+		"Re-run the analysis: some checks could not complete",
+	}, report.Recommendations)
+}
+
+func TestSecurityAnalyzer_Offline(t *testing.T) {
+	// OSV is unreachable and nothing is cached
+	analyzer := NewSecurityAnalyzer(&config.SecurityAnalysisConfig{
+		OSVURL:              "http://127.0.0.1:1",
+		OSVTimeout:          1,
+		BatchSize:           100,
+		CacheDir:            t.TempDir(),
+		CacheTTL:            3600,
+		SecretAllowlistFile: ".secrets-allowlist",
+		MinSecretEntropy:    3.5,
+	}, zap.NewNop())
+
+	content := loadSecurityFixture(t)
+	content["deploy/id_rsa"] = secretFixture["deploy/id_rsa"]
+	report, err := analyzer.Analyze(context.Background(), content)
+	require.NoError(t, err)
+
+	// Secrets are still found
+	assert.True(t, report.Degraded)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, "private_key", report.Findings[0].Rule)
+	assert.Equal(t, 0.5, report.RiskScore)
+	assert.Equal(t, []string{
+		"osv: 5 dependencies or vulnerabilities could not be checked against OSV",
+	}, report.DegradedReasons)
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 1, compareVersions("1.10.0", "1.9.1"))
+	assert.Equal(t, -1, compareVersions("v0.17.0", "0.17.1"))
+	assert.Equal(t, 0, compareVersions("1.2", "1.2.0"))
+	assert.Equal(t, 0, compareVersions("1.2.0-rc.1", "1.2.0"))
+	assert.Equal(t, 1, compareVersions("0.0.1", ""))
+}
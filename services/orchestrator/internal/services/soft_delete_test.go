@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+)
+
+// setupSoftDeleteProjects stores project-1, whose member bob and staging
+// environment were deleted, and project-2, deleted itself
+func setupSoftDeleteProjects(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, name TEXT, status TEXT, owner_id TEXT,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE project_members (id TEXT PRIMARY KEY, project_id TEXT, user_id TEXT, role TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE environments (id TEXT PRIMARY KEY, project_id TEXT, name TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE resources (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE integrations (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, name, status, owner_id, created_at, deleted_at) VALUES
+			('project-1', 'Platform', 'active', 'carol', '2026-10-01 12:00:00', NULL),
+			('project-2', 'Payments', 'active', 'carol', '2026-10-02 12:00:00', '2026-10-10 12:00:00')`,
+		`INSERT INTO project_members (id, project_id, user_id, role, deleted_at) VALUES
+			('member-1', 'project-1', 'alice', 'developer', NULL),
+			('member-2', 'project-1', 'bob', 'owner', '2026-10-05 12:00:00')`,
+		`INSERT INTO environments (id, project_id, name, deleted_at) VALUES
+			('env-1', 'project-1', 'production', NULL),
+			('env-2', 'project-1', 'staging', '2026-10-05 12:00:00')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	return db
+}
+
+func TestProjectService_GetProjectExcludesDeletedRows(t *testing.T) {
+	service := NewProjectService(setupSoftDeleteProjects(t), zap.NewNop())
+
+	project, err := service.GetProject(context.Background(), "project-1")
+	require.NoError(t, err)
+	require.Len(t, project.Members, 1)
+	assert.Equal(t, "alice", project.Members[0].UserID)
+	require.Len(t, project.Environments, 1)
+	assert.Equal(t, "production", project.Environments[0].Name)
+
+	// A deleted member has no role, whatever it had
+	assert.Equal(t, "developer", project.GetMemberRole("alice"))
+	assert.Empty(t, project.GetMemberRole("bob"))
+	assert.False(t, project.HasPermission("bob", "workflows:read"))
+
+	_, err = service.GetProject(context.Background(), "project-2")
+	assert.Error(t, err)
+}
+
+func TestProject_IgnoresMembersDeletedAfterLoading(t *testing.T) {
+	project := &models.Project{Members: []models.ProjectMember{
+		{UserID: "alice", Role: "developer", Permissions: []string{"workflows:read"}},
+		{UserID: "bob", Role: "owner", DeletedAt: gorm.DeletedAt{Valid: true}},
+		{UserID: "bob", Role: "viewer"},
+	}}
+
+	assert.True(t, project.HasPermission("alice", "workflows:read"))
+	assert.False(t, project.HasPermission("alice", "workflows:write"))
+	assert.Equal(t, "viewer", project.GetMemberRole("bob"))
+	assert.False(t, project.HasPermission("bob", "workflows:read"), "the deleted owner membership does not count")
+}
+
+func TestProjectService_ListProjectsIncludeDeleted(t *testing.T) {
+	ctx := context.Background()
+	service := NewProjectService(setupSoftDeleteProjects(t), zap.NewNop())
+
+	projects, total, err := service.ListProjects(ctx, &ProjectFilters{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, projects, 1)
+	assert.Equal(t, "project-1", projects[0].ID)
+
+	projects, total, err = service.ListProjects(ctx, &ProjectFilters{IncludeDeleted: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, projects, 2)
+	assert.Equal(t, "project-2", projects[0].ID)
+	assert.True(t, projects[0].DeletedAt.Valid)
+	assert.False(t, projects[1].DeletedAt.Valid)
+}
+
+func TestWorkflowEngine_DeletedWorkflowsHidden(t *testing.T) {
+	ctx := context.Background()
+	db := setupWorkflowTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT, status TEXT)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, resource_usage BLOB, deleted_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	// Unreachable Redis: cache misses fall through to the database
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	t.Cleanup(func() { redisClient.Close() })
+	engine := NewWorkflowEngine(db, redisClient, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	kept := &models.Workflow{Name: "kept", Type: models.WorkflowTypeCustom, Status: models.WorkflowStatusCompleted, ProjectID: "project-1"}
+	deleted := &models.Workflow{Name: "deleted", Type: models.WorkflowTypeCustom, Status: models.WorkflowStatusCompleted, ProjectID: "project-1"}
+	require.NoError(t, db.Create(kept).Error)
+	require.NoError(t, db.Create(deleted).Error)
+	for _, stmt := range []string{
+		`INSERT INTO workflow_steps VALUES ('s1', '` + kept.ID + `', 'build', 'completed')`,
+		`INSERT INTO workflow_steps VALUES ('s2', '` + deleted.ID + `', 'build', 'completed')`,
+		`INSERT INTO executions VALUES ('e1', '` + kept.ID + `', NULL, NULL)`,
+		`INSERT INTO executions VALUES ('e2', '` + kept.ID + `', NULL, '2026-10-05 12:00:00')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	require.NoError(t, db.Delete(deleted).Error)
+
+	// Neither a deleted workflow nor its steps are visible
+	_, err := engine.GetWorkflowMetrics(ctx, deleted.ID)
+	assert.Error(t, err)
+	metrics, err := engine.GetWorkflowMetrics(ctx, kept.ID)
+	require.NoError(t, err)
+	assert.Len(t, metrics.StepMetrics, 1)
+	assert.Equal(t, 1, metrics.ResourceUsage["execution_count"], "deleted executions are not counted")
+
+	summaries, total, err := engine.ListWorkflowSummaries(ctx, &WorkflowFilters{ProjectID: "project-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, kept.ID, summaries[0].ID)
+	assert.Nil(t, summaries[0].DeletedAt)
+
+	// Admin listings include them, marked deleted
+	summaries, total, err = engine.ListWorkflowSummaries(ctx, &WorkflowFilters{ProjectID: "project-1", IncludeDeleted: true, SortBy: "name"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, summaries, 2)
+	assert.Equal(t, deleted.ID, summaries[0].ID)
+	assert.NotNil(t, summaries[0].DeletedAt)
+}
@@ -0,0 +1,609 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// Analyzers available out of the box
+const (
+	AnalyzerGoVet       = "go_vet"
+	AnalyzerStaticcheck = "staticcheck"
+	AnalyzerESLint      = "eslint"
+)
+
+// staticAnalysisRevision is bumped when a change to the analyzers changes
+// the reports they produce, invalidating cached reports
+const staticAnalysisRevision = 2
+
+// Severities of static analysis issues
+const (
+	IssueSeverityError   = "error"
+	IssueSeverityWarning = "warning"
+)
+
+// ErrAnalyzerUnavailable is returned by analyzers whose binary is not
+// installed
+var ErrAnalyzerUnavailable = errors.New("analyzer is not installed")
+
+// StaticIssue is a finding of an analyzer. Issues about the analysis itself,
+// e.g. an analyzer that is not installed, have no file.
+type StaticIssue struct {
+	Analyzer string `json:"analyzer"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Analyzer checks the files of a workspace with an external tool
+type Analyzer interface {
+	// Name is the analyzer's name in config and issues
+	Name() string
+	// Handles reports whether the analyzer checks a file
+	Handles(file string) bool
+	// Analyze checks the files of dir it handles, given relative to dir.
+	// It returns ErrAnalyzerUnavailable when its binary is not installed.
+	Analyze(ctx context.Context, dir string, files []string) ([]StaticIssue, error)
+}
+
+// analyzerRunner runs a command in dir and returns its stdout and stderr.
+// A non-zero exit is returned as an *exec.ExitError along with the output.
+type analyzerRunner func(ctx context.Context, dir string, env []string, name string, args ...string) ([]byte, []byte, error)
+
+// runAnalyzer runs an analyzer with only the environment it needs, so that
+// the code analyzed cannot reach the worker's secrets through it, and with a
+// HOME of its own that is removed afterwards
+func runAnalyzer(ctx context.Context, dir string, env []string, name string, args ...string) ([]byte, []byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrAnalyzerUnavailable, name)
+	}
+	home, err := os.MkdirTemp("", "analyzer-home-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create analyzer home: %w", err)
+	}
+	defer os.RemoveAll(home)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(analyzerEnv(home), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// analyzerEnv is the environment analyzers run with: the worker's PATH, home
+// as HOME and TMPDIR, and the worker's Go build and module caches, which the
+// go tool would otherwise look for under home
+func analyzerEnv(home string) []string {
+	env := []string{"PATH=" + os.Getenv("PATH"), "HOME=" + home, "TMPDIR=" + home}
+	if cache := os.Getenv("GOCACHE"); cache != "" {
+		env = append(env, "GOCACHE="+cache)
+	} else if dir, err := os.UserCacheDir(); err == nil {
+		env = append(env, "GOCACHE="+filepath.Join(dir, "go-build"))
+	}
+	if modCache := os.Getenv("GOMODCACHE"); modCache != "" {
+		env = append(env, "GOMODCACHE="+modCache)
+	} else if gopath := os.Getenv("GOPATH"); gopath != "" {
+		env = append(env, "GOMODCACHE="+filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod"))
+	} else if dir, err := os.UserHomeDir(); err == nil {
+		env = append(env, "GOMODCACHE="+filepath.Join(dir, "go", "pkg", "mod"))
+	}
+	return env
+}
+
+// goEnv keeps the go tool from downloading toolchains and modules and from
+// changing the workspace's go.mod: requirements resolve from the workspace's
+// vendor directory when it has one and the module cache otherwise, and a
+// go.work in the workspace is ignored. cgo is disabled so the workspace's
+// #cgo directives never reach a C compiler.
+func goEnv(dir string) []string {
+	mode := "-mod=readonly"
+	if _, err := os.Stat(filepath.Join(dir, "vendor", "modules.txt")); err == nil {
+		mode = "-mod=vendor"
+	}
+	return []string{"GOTOOLCHAIN=local", "GOPROXY=off", "GOWORK=off", "GOFLAGS=" + mode, "CGO_ENABLED=0"}
+}
+
+// goVetAnalyzer runs go vet over the Go packages of the workspace
+type goVetAnalyzer struct {
+	run   analyzerRunner
+	flags []string
+}
+
+// goVetLine is a finding of go vet: file:line:col: message. Findings of
+// packages that do not type-check are prefixed with "vet: ".
+var goVetLine = regexp.MustCompile(`^(vet: )?(.+?\.go):(\d+):(\d+): (.+)$`)
+
+func (a *goVetAnalyzer) Name() string {
+	return AnalyzerGoVet
+}
+
+func (a *goVetAnalyzer) Handles(file string) bool {
+	return path.Ext(file) == ".go"
+}
+
+func (a *goVetAnalyzer) Analyze(ctx context.Context, dir string, files []string) ([]StaticIssue, error) {
+	args := append([]string{"vet"}, a.flags...)
+	_, stderr, err := a.run(ctx, dir, goEnv(dir), "go", append(args, "./...")...)
+	issues := parseGoVet(dir, stderr)
+	if err != nil && len(issues) == 0 {
+		return nil, commandError("go vet", err, stderr)
+	}
+	return issues, nil
+}
+
+// parseGoVet parses the findings go vet writes to stderr; findings of
+// packages that do not type-check are reported under the typecheck rule
+func parseGoVet(dir string, output []byte) []StaticIssue {
+	issues := []StaticIssue{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		match := goVetLine.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(match[3])
+		column, _ := strconv.Atoi(match[4])
+		rule := "vet"
+		if match[1] != "" {
+			rule = "typecheck"
+		}
+		issues = append(issues, StaticIssue{
+			Analyzer: AnalyzerGoVet,
+			File:     workspacePath(dir, match[2]),
+			Line:     line,
+			Column:   column,
+			Severity: IssueSeverityError,
+			Rule:     rule,
+			Message:  match[5],
+		})
+	}
+	return issues
+}
+
+// staticcheckAnalyzer runs staticcheck over the Go packages of the workspace
+type staticcheckAnalyzer struct {
+	run   analyzerRunner
+	flags []string
+}
+
+func (a *staticcheckAnalyzer) Name() string {
+	return AnalyzerStaticcheck
+}
+
+func (a *staticcheckAnalyzer) Handles(file string) bool {
+	return path.Ext(file) == ".go"
+}
+
+func (a *staticcheckAnalyzer) Analyze(ctx context.Context, dir string, files []string) ([]StaticIssue, error) {
+	args := append([]string{"-f", "json"}, a.flags...)
+	stdout, stderr, err := a.run(ctx, dir, goEnv(dir), "staticcheck", append(args, "./...")...)
+	issues, parseErr := parseStaticcheck(dir, stdout)
+	if parseErr != nil || (err != nil && len(issues) == 0) {
+		return nil, commandError("staticcheck", err, stderr)
+	}
+	return issues, nil
+}
+
+// parseStaticcheck parses the JSON lines staticcheck writes with -f json
+func parseStaticcheck(dir string, output []byte) ([]StaticIssue, error) {
+	issues := []StaticIssue{}
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	for decoder.More() {
+		var finding struct {
+			Code     string `json:"code"`
+			Severity string `json:"severity"`
+			Location struct {
+				File   string `json:"file"`
+				Line   int    `json:"line"`
+				Column int    `json:"column"`
+			} `json:"location"`
+			Message string `json:"message"`
+		}
+		if err := decoder.Decode(&finding); err != nil {
+			return nil, fmt.Errorf("invalid staticcheck output: %w", err)
+		}
+		severity := IssueSeverityWarning
+		if finding.Severity == IssueSeverityError {
+			severity = IssueSeverityError
+		}
+		issues = append(issues, StaticIssue{
+			Analyzer: AnalyzerStaticcheck,
+			File:     workspacePath(dir, finding.Location.File),
+			Line:     finding.Location.Line,
+			Column:   finding.Location.Column,
+			Severity: severity,
+			Rule:     finding.Code,
+			Message:  finding.Message,
+		})
+	}
+	return issues, nil
+}
+
+// eslintAnalyzer runs eslint over the JavaScript and TypeScript files of the
+// workspace. Config files in the workspace are ignored, so the code analyzed
+// cannot load plugins of its own; the rules come from the server's config.
+type eslintAnalyzer struct {
+	run    analyzerRunner
+	flags  []string
+	config string // Path of the eslint config; eslint's defaults when empty
+}
+
+// eslintExtensions are the extensions of the files eslint checks
+var eslintExtensions = map[string]bool{
+	".js": true, ".jsx": true, ".mjs": true, ".cjs": true, ".ts": true, ".tsx": true,
+}
+
+func (a *eslintAnalyzer) Name() string {
+	return AnalyzerESLint
+}
+
+func (a *eslintAnalyzer) Handles(file string) bool {
+	return eslintExtensions[path.Ext(file)]
+}
+
+func (a *eslintAnalyzer) Analyze(ctx context.Context, dir string, files []string) ([]StaticIssue, error) {
+	args := []string{"--format", "json", "--no-config-lookup"}
+	if a.config != "" {
+		args = append(args, "--config", a.config)
+	}
+	args = append(args, a.flags...)
+	// Files are the workspace's own names; after -- none is read as an option
+	args = append(args, "--")
+	stdout, stderr, err := a.run(ctx, dir, nil, "eslint", append(args, files...)...)
+	// eslint exits 1 when it finds errors and 2 when it cannot run
+	if len(bytes.TrimSpace(stdout)) == 0 {
+		return nil, commandError("eslint", err, stderr)
+	}
+	issues, parseErr := parseESLint(dir, stdout)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	return issues, nil
+}
+
+// parseESLint parses the results eslint writes with --format json
+func parseESLint(dir string, output []byte) ([]StaticIssue, error) {
+	var results []struct {
+		FilePath string `json:"filePath"`
+		Messages []struct {
+			RuleID   *string `json:"ruleId"`
+			Severity int     `json:"severity"`
+			Message  string  `json:"message"`
+			Line     int     `json:"line"`
+			Column   int     `json:"column"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(output, &results); err != nil {
+		return nil, fmt.Errorf("invalid eslint output: %w", err)
+	}
+
+	issues := []StaticIssue{}
+	for _, result := range results {
+		for _, message := range result.Messages {
+			severity := IssueSeverityWarning
+			if message.Severity == 2 {
+				severity = IssueSeverityError
+			}
+			// Messages without a rule are parse errors
+			rule := "parse"
+			if message.RuleID != nil {
+				rule = *message.RuleID
+			}
+			issues = append(issues, StaticIssue{
+				Analyzer: AnalyzerESLint,
+				File:     workspacePath(dir, result.FilePath),
+				Line:     message.Line,
+				Column:   message.Column,
+				Severity: severity,
+				Rule:     rule,
+				Message:  message.Message,
+			})
+		}
+	}
+	return issues, nil
+}
+
+// workspacePath returns the path of a file reported by an analyzer relative
+// to the workspace, as given in the code analyzed
+func workspacePath(dir, file string) string {
+	if filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(dir, file); err == nil {
+			file = rel
+		} else if resolved, dirErr := filepath.EvalSymlinks(dir); dirErr == nil {
+			// The temp directory may be reached through a symlink
+			if rel, err := filepath.Rel(resolved, file); err == nil {
+				file = rel
+			}
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(file))
+}
+
+// commandError describes a failed analyzer run by its error output
+func commandError(name string, err error, stderr []byte) error {
+	if err == nil {
+		return fmt.Errorf("%s produced no output", name)
+	}
+	if errors.Is(err, ErrAnalyzerUnavailable) {
+		return err
+	}
+	if message := strings.TrimSpace(string(stderr)); message != "" {
+		const maxMessage = 500
+		if len(message) > maxMessage {
+			message = message[:maxMessage] + "..."
+		}
+		return fmt.Errorf("%s failed: %w: %s", name, err, message)
+	}
+	return fmt.Errorf("%s failed: %w", name, err)
+}
+
+// CodeMetrics are estimates from a pass over the source lines of the code
+// analyzed
+type CodeMetrics struct {
+	Files           int     `json:"files"`
+	Lines           int     `json:"lines"` // Non-blank lines
+	Functions       int     `json:"functions"`
+	Complexity      float64 `json:"complexity"` // Mean cyclomatic complexity per function
+	Maintainability float64 `json:"maintainability"`
+}
+
+// StaticAnalysisReport is the outcome of the static analysis of some code
+type StaticAnalysisReport struct {
+	Issues    []StaticIssue `json:"issues"`
+	Metrics   CodeMetrics   `json:"metrics"`
+	Analyzers []string      `json:"analyzers"` // Analyzers that checked files, whether or not they ran
+}
+
+// StaticAnalyzer runs the enabled analyzers over code written to a temporary
+// workspace. Analyzers that are not installed, time out or fail are reported
+// as warning issues rather than failing the analysis.
+type StaticAnalyzer struct {
+	analyzers []Analyzer
+	version   string
+	timeout   time.Duration
+	workDir   string
+	logger    *zap.Logger
+}
+
+// NewStaticAnalyzer creates a static analyzer running the analyzers enabled
+// in cfg with their configured flags
+func NewStaticAnalyzer(cfg *config.StaticAnalysisConfig, logger *zap.Logger) (*StaticAnalyzer, error) {
+	analyzers := make([]Analyzer, 0, len(cfg.Analyzers))
+	version := []string{fmt.Sprintf("static/%d", staticAnalysisRevision)}
+	for _, name := range cfg.Analyzers {
+		flags := cfg.Flags[name]
+		version = append(version, strings.TrimSpace(name+" "+strings.Join(flags, " ")))
+		switch name {
+		case AnalyzerGoVet:
+			analyzers = append(analyzers, &goVetAnalyzer{run: runAnalyzer, flags: flags})
+		case AnalyzerStaticcheck:
+			analyzers = append(analyzers, &staticcheckAnalyzer{run: runAnalyzer, flags: flags})
+		case AnalyzerESLint:
+			if cfg.ESLintConfig != "" {
+				version = append(version, "eslint config "+cfg.ESLintConfig)
+			}
+			analyzers = append(analyzers, &eslintAnalyzer{run: runAnalyzer, flags: flags, config: cfg.ESLintConfig})
+		default:
+			return nil, fmt.Errorf("unknown analyzer: %s", name)
+		}
+	}
+	return &StaticAnalyzer{
+		analyzers: analyzers,
+		version:   strings.Join(version, ", "),
+		timeout:   time.Duration(cfg.Timeout) * time.Second,
+		workDir:   cfg.WorkDir,
+		logger:    logger,
+	}, nil
+}
+
+// Version identifies the analyzers and their flags, so that reports cached
+// before they changed are not reused
+func (s *StaticAnalyzer) Version() string {
+	return s.version
+}
+
+// Analyze runs the analyzers handling the files of content, keyed by path,
+// and estimates the code's metrics. started is called before each analyzer
+// runs.
+func (s *StaticAnalyzer) Analyze(ctx context.Context, content map[string]string, started func(analyzer string)) (*StaticAnalysisReport, error) {
+	dir, files, err := writeWorkspace(s.workDir, content)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	report := &StaticAnalysisReport{
+		Issues:    []StaticIssue{},
+		Metrics:   measureCode(content),
+		Analyzers: []string{},
+	}
+	for _, analyzer := range s.analyzers {
+		var handled []string
+		for _, file := range files {
+			if analyzer.Handles(file) {
+				handled = append(handled, file)
+			}
+		}
+		if len(handled) == 0 {
+			continue
+		}
+		if started != nil {
+			started(analyzer.Name())
+		}
+		report.Analyzers = append(report.Analyzers, analyzer.Name())
+
+		issues, err := s.run(ctx, analyzer, dir, handled)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			s.logger.Warn("Analyzer did not run", zap.String("analyzer", analyzer.Name()), zap.Error(err))
+			issues = []StaticIssue{{
+				Analyzer: analyzer.Name(),
+				Severity: IssueSeverityWarning,
+				Rule:     "analyzer",
+				Message:  fmt.Sprintf("%s did not run on %s files: %v", analyzer.Name(), strings.Join(extensionsOf(handled), ", "), err),
+			}}
+		}
+		report.Issues = append(report.Issues, issues...)
+	}
+
+	sort.SliceStable(report.Issues, func(i, j int) bool {
+		if report.Issues[i].File != report.Issues[j].File {
+			return report.Issues[i].File < report.Issues[j].File
+		}
+		return report.Issues[i].Line < report.Issues[j].Line
+	})
+	return report, nil
+}
+
+// extensionsOf returns the sorted extensions of files
+func extensionsOf(files []string) []string {
+	seen := make(map[string]bool)
+	var extensions []string
+	for _, file := range files {
+		if ext := path.Ext(file); !seen[ext] {
+			seen[ext] = true
+			extensions = append(extensions, ext)
+		}
+	}
+	sort.Strings(extensions)
+	return extensions
+}
+
+// run runs an analyzer with the configured timeout
+func (s *StaticAnalyzer) run(ctx context.Context, analyzer Analyzer, dir string, files []string) ([]StaticIssue, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	issues, err := analyzer.Analyze(ctx, dir, files)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("timed out after %s", s.timeout)
+	}
+	return issues, err
+}
+
+// writeWorkspace writes content to a new temporary directory under workDir
+// and returns it with the sorted paths written. Paths leaving the workspace
+// are skipped. Go code without a go.mod gets one, so packages can be loaded.
+func writeWorkspace(workDir string, content map[string]string) (string, []string, error) {
+	if workDir != "" {
+		if err := os.MkdirAll(workDir, 0o755); err != nil {
+			return "", nil, fmt.Errorf("failed to create analysis workspace: %w", err)
+		}
+	}
+	dir, err := os.MkdirTemp(workDir, "analysis-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create analysis workspace: %w", err)
+	}
+
+	files := make([]string, 0, len(content))
+	hasGo, hasModule := false, false
+	for name, data := range content {
+		clean := path.Clean("/" + filepath.ToSlash(name))[1:]
+		if clean == "" || clean != filepath.ToSlash(name) {
+			continue
+		}
+		file := filepath.Join(dir, filepath.FromSlash(clean))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, fmt.Errorf("failed to write analysis workspace: %w", err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, fmt.Errorf("failed to write analysis workspace: %w", err)
+		}
+		files = append(files, clean)
+		hasGo = hasGo || path.Ext(clean) == ".go"
+		hasModule = hasModule || clean == "go.mod"
+	}
+	if hasGo && !hasModule {
+		if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module analysis\n\ngo 1.21\n"), 0o644); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, fmt.Errorf("failed to write analysis workspace: %w", err)
+		}
+	}
+	sort.Strings(files)
+	return dir, files, nil
+}
+
+var (
+	// goFunction and jsFunction match the lines declaring functions
+	goFunction = regexp.MustCompile(`^\s*func\b`)
+	jsFunction = regexp.MustCompile(`\bfunction\b|=>`)
+	// decisionPattern matches the branches adding to cyclomatic complexity
+	decisionPattern = regexp.MustCompile(`\b(if|for|while|case|catch)\b|&&|\|\|`)
+)
+
+// measureCode estimates the metrics of the Go, JavaScript and TypeScript
+// files of content. Complexity is the mean of 1 plus the decision points per
+// function; maintainability starts at 100 and loses 5 per point of mean
+// complexity above 1 and 1 per 2 lines of the mean function length above 30.
+func measureCode(content map[string]string) CodeMetrics {
+	var metrics CodeMetrics
+	decisions := 0
+	for name, data := range content {
+		var function *regexp.Regexp
+		switch ext := path.Ext(name); {
+		case ext == ".go":
+			function = goFunction
+		case eslintExtensions[ext]:
+			function = jsFunction
+		default:
+			continue
+		}
+		metrics.Files++
+		for _, line := range strings.Split(data, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "//") {
+				continue
+			}
+			metrics.Lines++
+			if function.MatchString(line) {
+				metrics.Functions++
+			}
+			decisions += len(decisionPattern.FindAllString(line, -1))
+		}
+	}
+	if metrics.Files == 0 {
+		metrics.Maintainability = 100
+		return metrics
+	}
+
+	functions := math.Max(float64(metrics.Functions), 1)
+	metrics.Complexity = round1(1 + float64(decisions)/functions)
+	linesPerFunction := float64(metrics.Lines) / functions
+	maintainability := 100 - 5*(metrics.Complexity-1) - math.Max(linesPerFunction-30, 0)/2
+	metrics.Maintainability = round1(math.Min(math.Max(maintainability, 0), 100))
+	return metrics
+}
+
+func round1(value float64) float64 {
+	return math.Round(value*10) / 10
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// goFixture is a Go codebase with a format string bug in main.go and a
+// package that does not type-check
+var goFixture = map[string]string{
+	"main.go": `package main
+
+import "fmt"
+
+func main() {
+	name := "world"
+	fmt.Printf("hello %d\n", name)
+}
+`,
+	"util/util.go": `package util
+
+func Double(n int) int {
+	return n * missing
+}
+`,
+	"README.md": "# Fixture\n",
+}
+
+// jsFixture is a JavaScript codebase with an unused variable and a syntax
+// error
+var jsFixture = map[string]string{
+	"src/app.js": `function app(a, b) {
+  const unused = 1;
+  if (a && b) {
+    return a;
+  }
+  return b;
+}
+`,
+	"src/broken.ts": "const x = ;\n",
+}
+
+// fakeRunner returns canned output for a command, with {dir} replaced by
+// the workspace, recording the arguments it was run with
+type fakeRunner struct {
+	stdout, stderr string
+	err            error
+	delay          time.Duration
+	args           []string
+}
+
+func (r *fakeRunner) run(ctx context.Context, dir string, env []string, name string, args ...string) ([]byte, []byte, error) {
+	r.args = append([]string{name}, args...)
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	return []byte(strings.ReplaceAll(r.stdout, "{dir}", dir)), []byte(r.stderr), r.err
+}
+
+func TestStaticAnalyzer_GoVet(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+	analyzer, err := NewStaticAnalyzer(&config.StaticAnalysisConfig{
+		Analyzers: []string{AnalyzerGoVet, AnalyzerESLint},
+		Timeout:   120,
+		WorkDir:   t.TempDir(),
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	var started []string
+	report, err := analyzer.Analyze(context.Background(), goFixture, func(name string) {
+		started = append(started, name)
+	})
+	require.NoError(t, err)
+
+	// eslint has no files to check
+	assert.Equal(t, []string{AnalyzerGoVet}, started)
+	assert.Equal(t, []string{AnalyzerGoVet}, report.Analyzers)
+	require.Len(t, report.Issues, 2)
+	assert.Equal(t, StaticIssue{
+		Analyzer: AnalyzerGoVet,
+		File:     "main.go",
+		Line:     7,
+		Column:   20,
+		Severity: IssueSeverityError,
+		Rule:     "vet",
+		Message:  report.Issues[0].Message,
+	}, report.Issues[0])
+	assert.Contains(t, report.Issues[0].Message, "format %d has arg name of wrong type string")
+	assert.Equal(t, "util/util.go", report.Issues[1].File)
+	assert.Equal(t, 4, report.Issues[1].Line)
+	assert.Equal(t, "typecheck", report.Issues[1].Rule)
+	assert.Contains(t, report.Issues[1].Message, "undefined: missing")
+
+	assert.Equal(t, CodeMetrics{Files: 2, Lines: 10, Functions: 2, Complexity: 1, Maintainability: 100}, report.Metrics)
+}
+
+func TestStaticAnalyzer_ParsesFindings(t *testing.T) {
+	staticcheck := &fakeRunner{
+		stdout: `{"code":"SA4006","severity":"error","location":{"file":"{dir}/main.go","line":6,"column":2},"message":"this value of name is never used"}
+{"code":"ST1003","severity":"warning","location":{"file":"{dir}/util/util.go","line":3,"column":6},"message":"should not use underscores"}
+`,
+		err: errors.New("exit status 1"),
+	}
+	eslint := &fakeRunner{
+		stdout: `[{"filePath":"{dir}/src/app.js","messages":[
+			{"ruleId":"no-unused-vars","severity":2,"message":"'unused' is assigned a value but never used.","line":2,"column":9}]},
+			{"filePath":"{dir}/src/broken.ts","messages":[
+			{"ruleId":null,"fatal":true,"severity":2,"message":"Parsing error: Expression expected.","line":1,"column":11}]}]`,
+		err: errors.New("exit status 1"),
+	}
+	analyzer := &StaticAnalyzer{
+		analyzers: []Analyzer{
+			&staticcheckAnalyzer{run: staticcheck.run, flags: []string{"-checks", "all"}},
+			&eslintAnalyzer{run: eslint.run, config: "/etc/uos/eslint.config.js"},
+		},
+		timeout: time.Minute,
+		workDir: t.TempDir(),
+		logger:  zap.NewNop(),
+	}
+
+	code := map[string]string{}
+	for name, content := range goFixture {
+		code[name] = content
+	}
+	for name, content := range jsFixture {
+		code[name] = content
+	}
+	report, err := analyzer.Analyze(context.Background(), code, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"staticcheck", "-f", "json", "-checks", "all", "./..."}, staticcheck.args)
+	assert.Equal(t, []string{"eslint", "--format", "json", "--no-config-lookup", "--config", "/etc/uos/eslint.config.js",
+		"--", "src/app.js", "src/broken.ts"}, eslint.args)
+	assert.Equal(t, []StaticIssue{
+		{Analyzer: AnalyzerStaticcheck, File: "main.go", Line: 6, Column: 2, Severity: IssueSeverityError,
+			Rule: "SA4006", Message: "this value of name is never used"},
+		{Analyzer: AnalyzerESLint, File: "src/app.js", Line: 2, Column: 9, Severity: IssueSeverityError,
+			Rule: "no-unused-vars", Message: "'unused' is assigned a value but never used."},
+		{Analyzer: AnalyzerESLint, File: "src/broken.ts", Line: 1, Column: 11, Severity: IssueSeverityError,
+			Rule: "parse", Message: "Parsing error: Expression expected."},
+		{Analyzer: AnalyzerStaticcheck, File: "util/util.go", Line: 3, Column: 6, Severity: IssueSeverityWarning,
+			Rule: "ST1003", Message: "should not use underscores"},
+	}, report.Issues)
+	assert.Equal(t, []string{AnalyzerStaticcheck, AnalyzerESLint}, report.Analyzers)
+
+	// The function of app.js has 2 decision points
+	assert.Equal(t, 4, report.Metrics.Files)
+	assert.Equal(t, 3, report.Metrics.Functions)
+	assert.Equal(t, 1.7, report.Metrics.Complexity)
+}
+
+func TestStaticAnalyzer_Degrades(t *testing.T) {
+	missing := &fakeRunner{err: ErrAnalyzerUnavailable}
+	slow := &fakeRunner{delay: time.Minute}
+	broken := &fakeRunner{stderr: "Oops! Something went wrong: no eslint config", err: errors.New("exit status 2")}
+	analyzer := &StaticAnalyzer{
+		analyzers: []Analyzer{
+			&staticcheckAnalyzer{run: missing.run},
+			&goVetAnalyzer{run: slow.run},
+			&eslintAnalyzer{run: broken.run},
+		},
+		timeout: 50 * time.Millisecond,
+		workDir: t.TempDir(),
+		logger:  zap.NewNop(),
+	}
+
+	code := map[string]string{"main.go": goFixture["main.go"], "src/app.js": jsFixture["src/app.js"]}
+	report, err := analyzer.Analyze(context.Background(), code, nil)
+	require.NoError(t, err)
+
+	require.Len(t, report.Issues, 3)
+	for _, issue := range report.Issues {
+		assert.Equal(t, IssueSeverityWarning, issue.Severity)
+		assert.Equal(t, "analyzer", issue.Rule)
+		assert.Empty(t, issue.File)
+	}
+	assert.Equal(t, AnalyzerStaticcheck, report.Issues[0].Analyzer)
+	assert.Contains(t, report.Issues[0].Message, "staticcheck did not run on .go files: analyzer is not installed")
+	assert.Equal(t, AnalyzerGoVet, report.Issues[1].Analyzer)
+	assert.Contains(t, report.Issues[1].Message, "timed out after 50ms")
+	assert.Equal(t, AnalyzerESLint, report.Issues[2].Analyzer)
+	assert.Contains(t, report.Issues[2].Message, "no eslint config")
+
+	// A cancelled analysis fails
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = analyzer.Analyze(ctx, code, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestStaticAnalyzer_UnknownAnalyzer(t *testing.T) {
+	_, err := NewStaticAnalyzer(&config.StaticAnalysisConfig{Analyzers: []string{"pylint"}}, zap.NewNop())
+	assert.EqualError(t, err, "unknown analyzer: pylint")
+}
+
+func TestRunAnalyzer_Environment(t *testing.T) {
+	if _, err := exec.LookPath("env"); err != nil {
+		t.Skip("env is not installed")
+	}
+	t.Setenv("DATABASE_PASSWORD", "hunter2")
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	stdout, _, err := runAnalyzer(context.Background(), t.TempDir(), goEnv(t.TempDir()), "env")
+	require.NoError(t, err)
+	env := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+		if name, value, ok := strings.Cut(line, "="); ok {
+			env[name] = value
+		}
+	}
+
+	assert.NotContains(t, env, "DATABASE_PASSWORD")
+	assert.Equal(t, os.Getenv("PATH"), env["PATH"])
+	assert.NotEqual(t, os.Getenv("HOME"), env["HOME"])
+	assert.NoDirExists(t, env["HOME"], "the analyzer's home is removed")
+	assert.NotEmpty(t, env["GOCACHE"])
+	assert.Equal(t, "-mod=readonly", env["GOFLAGS"])
+	assert.Equal(t, "off", env["GOPROXY"])
+	assert.Equal(t, "0", env["CGO_ENABLED"])
+}
+
+func TestESLintAnalyzer_FilesAreNotOptions(t *testing.T) {
+	eslint := &fakeRunner{stdout: `[]`}
+	analyzer := &eslintAnalyzer{run: eslint.run}
+
+	_, err := analyzer.Analyze(context.Background(), t.TempDir(), []string{"--config=evil.js", "--rulesdir=rules.js"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eslint", "--format", "json", "--no-config-lookup",
+		"--", "--config=evil.js", "--rulesdir=rules.js"}, eslint.args)
+}
+
+func TestGoEnv_Vendor(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "vendor"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vendor", "modules.txt"), nil, 0o644))
+	assert.Contains(t, goEnv(dir), "GOFLAGS=-mod=vendor")
+}
+
+func TestWriteWorkspace_SkipsEscapingPaths(t *testing.T) {
+	dir, files, err := writeWorkspace(t.TempDir(), map[string]string{
+		"main.go":       "package main\n",
+		"../outside.go": "package outside\n",
+		"/etc/passwd":   "root\n",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main.go"}, files)
+	assert.FileExists(t, dir+"/go.mod")
+}
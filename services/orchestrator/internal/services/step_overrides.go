@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+// StepOverrideSignal is the signal carrying an operator's override of a step
+// to the running workflow
+const StepOverrideSignal = "step-override"
+
+// PermissionOverrideSteps is the project permission to skip or complete the
+// steps of running workflows by hand
+const PermissionOverrideSteps = "workflows:override_steps"
+
+var (
+	// ErrStepFinished is returned when overriding a step the workflow already
+	// finished
+	ErrStepFinished = errors.New("step already finished")
+
+	// ErrOverrideForbidden is returned when the operator lacks the project
+	// permission to override steps
+	ErrOverrideForbidden = errors.New("overriding steps requires the " + PermissionOverrideSteps + " permission")
+
+	// ErrWorkflowNotOverridable is returned when overriding a step of a
+	// workflow that is not running
+	ErrWorkflowNotOverridable = errors.New("workflow is not running")
+)
+
+// StepOverrideAction is what an operator does in place of a step
+type StepOverrideAction string
+
+const (
+	// StepOverrideSkip skips the step; its dependents run without its output
+	StepOverrideSkip StepOverrideAction = "skip"
+	// StepOverrideComplete completes the step by hand with the output the
+	// operator supplied, which its dependents receive
+	StepOverrideComplete StepOverrideAction = "complete"
+)
+
+// StepOverride is an operator's override of a step, sent to the workflow on
+// StepOverrideSignal
+type StepOverride struct {
+	StepID string                 `json:"step_id"`
+	Action StepOverrideAction     `json:"action"`
+	Output map[string]interface{} `json:"output,omitempty"`
+	Actor  string                 `json:"actor"`
+	Reason string                 `json:"reason,omitempty"`
+}
+
+// finishedStepStatuses are the statuses of steps the workflow is done with
+var finishedStepStatuses = []models.WorkflowStatus{
+	models.WorkflowStatusCompleted,
+	models.WorkflowStatusFailed,
+	models.WorkflowStatusSkipped,
+	models.WorkflowStatusCompletedManually,
+}
+
+// OverrideStep sends an operator's override of a step to the running
+// workflow. The workflow applies it before or while executing the step and
+// ignores it once the step finished.
+func (e *WorkflowEngine) OverrideStep(ctx context.Context, workflowID string, override StepOverride) error {
+	var workflow models.Workflow
+	if err := e.db.WithContext(ctx).Select("id", "project_id", "status", "temporal_id").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+
+	allowed, err := e.canOverrideSteps(ctx, workflow.ProjectID, override.Actor)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrOverrideForbidden
+	}
+	if workflow.IsTerminal() || workflow.TemporalID == "" {
+		return ErrWorkflowNotOverridable
+	}
+
+	var finished int64
+	if err := e.db.WithContext(ctx).Model(&models.WorkflowStep{}).
+		Where("workflow_id = ? AND step_id = ? AND status IN ?", workflowID, override.StepID, finishedStepStatuses).
+		Count(&finished).Error; err != nil {
+		return fmt.Errorf("failed to get workflow step: %w", err)
+	}
+	if finished > 0 {
+		return ErrStepFinished
+	}
+
+	if err := e.temporalClient.SignalWorkflow(ctx, workflow.TemporalID, "", StepOverrideSignal, override); err != nil {
+		return fmt.Errorf("failed to signal step override: %w", err)
+	}
+
+	details, err := json.Marshal(override)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	entry := &models.AuditLog{
+		EntityType: "workflow",
+		EntityID:   workflow.ID,
+		Action:     "workflow.step." + string(override.Action),
+		Actor:      override.Actor,
+		Details:    details,
+	}
+	if err := e.db.WithContext(ctx).Create(entry).Error; err != nil {
+		e.logger.Error("failed to write audit log", zap.String("workflow_id", workflowID), zap.Error(err))
+	}
+	e.logger.Info("Step override sent",
+		zap.String("workflow_id", workflowID),
+		zap.String("step_id", override.StepID),
+		zap.String("action", string(override.Action)),
+		zap.String("actor", override.Actor))
+	return nil
+}
+
+// canOverrideSteps reports whether a user owns a project or has the
+// permission to override the steps of its workflows
+func (e *WorkflowEngine) canOverrideSteps(ctx context.Context, projectID, userID string) (bool, error) {
+	if projectID == "" || userID == "" {
+		return false, nil
+	}
+	var owners int64
+	if err := e.db.WithContext(ctx).Model(&models.Project{}).
+		Where("id = ? AND owner_id = ?", projectID, userID).Count(&owners).Error; err != nil {
+		return false, fmt.Errorf("failed to get project owner: %w", err)
+	}
+	if owners > 0 {
+		return true, nil
+	}
+
+	// Permissions are read as a StringArray; the model's plain []string
+	// does not scan
+	var members []struct {
+		Role        string
+		Permissions models.StringArray `gorm:"type:text[]"`
+	}
+	if err := e.db.WithContext(ctx).Model(&models.ProjectMember{}).Select("role", "permissions").
+		Where("project_id = ? AND user_id = ?", projectID, userID).Scan(&members).Error; err != nil {
+		return false, fmt.Errorf("failed to get project member: %w", err)
+	}
+	project := models.Project{ID: projectID}
+	for _, member := range members {
+		project.Members = append(project.Members, models.ProjectMember{
+			UserID:      userID,
+			Role:        member.Role,
+			Permissions: member.Permissions,
+		})
+	}
+	return project.HasPermission(userID, PermissionOverrideSteps), nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// setupStepOverrideTest stores a running workflow of project-1, which is
+// owned by owner-1 and has operator-1 allowed to override steps and
+// viewer-1 not
+func setupStepOverrideTest(t *testing.T) (*WorkflowEngine, *mocks.Client, *gorm.DB, *models.Workflow) {
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, owner_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE project_members (id TEXT PRIMARY KEY, project_id TEXT, user_id TEXT, role TEXT,
+			permissions TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, step_id TEXT, name TEXT, status TEXT)`,
+		`CREATE TABLE audit_logs (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), entity_type TEXT,
+			entity_id TEXT, action TEXT, actor TEXT, details BLOB, created_at DATETIME)`,
+		`INSERT INTO projects (id, owner_id) VALUES ('project-1', 'owner-1')`,
+		`INSERT INTO project_members (id, project_id, user_id, role, permissions) VALUES
+			('member-1', 'project-1', 'operator-1', 'developer', '{"workflows:read","workflows:override_steps"}'),
+			('member-2', 'project-1', 'viewer-1', 'viewer', '{"workflows:read"}')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	workflow := &models.Workflow{
+		Name:       "provision registry",
+		Type:       models.WorkflowTypeIntent,
+		ProjectID:  "project-1",
+		Status:     models.WorkflowStatusRunning,
+		TemporalID: "temporal-1",
+	}
+	require.NoError(t, db.Create(workflow).Error)
+	return engine, temporalClient, db, workflow
+}
+
+func TestWorkflowEngine_OverrideStep(t *testing.T) {
+	ctx := context.Background()
+	engine, temporalClient, db, workflow := setupStepOverrideTest(t)
+	override := StepOverride{
+		StepID: "provision",
+		Action: StepOverrideComplete,
+		Output: map[string]interface{}{"registry_url": "https://registry.internal"},
+		Actor:  "operator-1",
+		Reason: "the agent cannot reach the internal network",
+	}
+	temporalClient.On("SignalWorkflow", mock.Anything, "temporal-1", "", StepOverrideSignal, override).Return(nil).Once()
+
+	require.NoError(t, engine.OverrideStep(ctx, workflow.ID, override))
+	temporalClient.AssertExpectations(t)
+
+	var entry models.AuditLog
+	require.NoError(t, db.First(&entry, "entity_id = ?", workflow.ID).Error)
+	assert.Equal(t, "workflow", entry.EntityType)
+	assert.Equal(t, "workflow.step.complete", entry.Action)
+	assert.Equal(t, "operator-1", entry.Actor)
+	var details StepOverride
+	require.NoError(t, json.Unmarshal(entry.Details, &details))
+	assert.Equal(t, override, details)
+
+	// Owners need no permission
+	skip := StepOverride{StepID: "configure", Action: StepOverrideSkip, Actor: "owner-1"}
+	temporalClient.On("SignalWorkflow", mock.Anything, "temporal-1", "", StepOverrideSignal, skip).Return(nil).Once()
+	require.NoError(t, engine.OverrideStep(ctx, workflow.ID, skip))
+	temporalClient.AssertExpectations(t)
+}
+
+func TestWorkflowEngine_OverrideStepRejected(t *testing.T) {
+	ctx := context.Background()
+	engine, temporalClient, db, workflow := setupStepOverrideTest(t)
+	skip := func(actor string) StepOverride {
+		return StepOverride{StepID: "fetch", Action: StepOverrideSkip, Actor: actor}
+	}
+
+	err := engine.OverrideStep(ctx, workflow.ID, skip("viewer-1"))
+	assert.ErrorIs(t, err, ErrOverrideForbidden)
+	err = engine.OverrideStep(ctx, workflow.ID, skip("stranger"))
+	assert.ErrorIs(t, err, ErrOverrideForbidden)
+
+	require.NoError(t, db.Exec(`INSERT INTO workflow_steps (id, workflow_id, step_id, name, status) VALUES (?, ?, ?, ?, ?)`,
+		"step-1", workflow.ID, "fetch", "Fetch credentials", models.WorkflowStatusCompleted).Error)
+	err = engine.OverrideStep(ctx, workflow.ID, skip("operator-1"))
+	assert.ErrorIs(t, err, ErrStepFinished)
+
+	require.NoError(t, db.Model(workflow).Update("status", models.WorkflowStatusCompleted).Error)
+	err = engine.OverrideStep(ctx, workflow.ID, StepOverride{StepID: "provision", Action: StepOverrideSkip, Actor: "operator-1"})
+	assert.ErrorIs(t, err, ErrWorkflowNotOverridable)
+
+	err = engine.OverrideStep(ctx, "00000000-0000-0000-0000-000000000099", skip("operator-1"))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Nothing was signaled or audited
+	temporalClient.AssertNotCalled(t, "SignalWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	var audited int64
+	require.NoError(t, db.Model(&models.AuditLog{}).Count(&audited).Error)
+	assert.Zero(t, audited)
+}
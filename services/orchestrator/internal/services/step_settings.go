@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Step activity limits applied when none are configured
+const (
+	defaultMaxStepTimeout   = 24 * 3600
+	defaultMaxStepHeartbeat = 3600
+	defaultMaxStepAttempts  = 25
+)
+
+// StepActivitySettings override, for one step of an execution plan or
+// custom workflow, the activity settings of its workflow type. Unset
+// settings are those of the workflow type.
+type StepActivitySettings struct {
+	TimeoutSeconds         int      `json:"timeout_seconds,omitempty"`           // Start-to-close timeout of each attempt
+	HeartbeatSeconds       int      `json:"heartbeat_seconds,omitempty"`         // Attempts not heartbeating for this long are retried
+	ScheduleToCloseSeconds int      `json:"schedule_to_close_seconds,omitempty"` // Bounds all attempts of the step together
+	MaxAttempts            int      `json:"max_attempts,omitempty"`
+	NonRetryableErrorTypes []string `json:"non_retryable_error_types,omitempty"` // Errors of these types are not retried
+}
+
+// StepActivityLimits are the largest settings a step may ask for; zero
+// limits nothing
+type StepActivityLimits struct {
+	MaxTimeoutSeconds   int // Of both timeouts
+	MaxHeartbeatSeconds int
+	MaxAttempts         int
+}
+
+// Validate checks that the settings are consistent, heartbeats being
+// shorter than the timeouts a step sets, and within the limits
+func (s StepActivitySettings) Validate(limits StepActivityLimits) error {
+	if s.TimeoutSeconds < 0 || s.HeartbeatSeconds < 0 || s.ScheduleToCloseSeconds < 0 || s.MaxAttempts < 0 {
+		return errors.New("timeouts and attempts cannot be negative")
+	}
+	if s.HeartbeatSeconds > 0 && s.TimeoutSeconds > 0 && s.HeartbeatSeconds >= s.TimeoutSeconds {
+		return errors.New("heartbeat_seconds must be less than timeout_seconds")
+	}
+	if s.HeartbeatSeconds > 0 && s.ScheduleToCloseSeconds > 0 && s.HeartbeatSeconds >= s.ScheduleToCloseSeconds {
+		return errors.New("heartbeat_seconds must be less than schedule_to_close_seconds")
+	}
+	if s.TimeoutSeconds > 0 && s.ScheduleToCloseSeconds > 0 && s.ScheduleToCloseSeconds < s.TimeoutSeconds {
+		return errors.New("schedule_to_close_seconds cannot be less than timeout_seconds")
+	}
+	if limit := limits.MaxTimeoutSeconds; limit > 0 && (s.TimeoutSeconds > limit || s.ScheduleToCloseSeconds > limit) {
+		return fmt.Errorf("timeouts cannot exceed %d seconds", limit)
+	}
+	if limit := limits.MaxHeartbeatSeconds; limit > 0 && s.HeartbeatSeconds > limit {
+		return fmt.Errorf("heartbeat_seconds cannot exceed %d", limit)
+	}
+	if limit := limits.MaxAttempts; limit > 0 && s.MaxAttempts > limit {
+		return fmt.Errorf("max_attempts cannot exceed %d", limit)
+	}
+	for _, errorType := range s.NonRetryableErrorTypes {
+		if strings.TrimSpace(errorType) == "" {
+			return errors.New("non_retryable_error_types cannot have empty types")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,656 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrStorageQuotaExceeded is returned when storing artifacts would take a
+// project over its storage quota
+var ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+// StorageQuotaExceededEvent is the event published on a project's event
+// channel when artifacts are rejected by its storage quota
+const StorageQuotaExceededEvent = "storage_quota_exceeded"
+
+// artifactExpiryBatchSize is the number of expired artifacts removed at once
+const artifactExpiryBatchSize = 500
+
+var (
+	storageQuotaRejectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_storage_quota_rejections_total",
+		Help: "Artifact stores rejected because they would exceed the project storage quota",
+	})
+	storageReconcileCorrectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_storage_reconcile_corrections_total",
+		Help: "Project storage counters corrected by reconciliation with the artifacts table",
+	})
+)
+
+// ArtifactTypeUsage is the storage used by a project's artifacts of one type
+type ArtifactTypeUsage struct {
+	Type      string `json:"type"`
+	Bytes     int64  `json:"bytes"`
+	Artifacts int64  `json:"artifacts"`
+}
+
+// StorageUsage is the artifact storage used by a project
+type StorageUsage struct {
+	ProjectID    string              `json:"project_id"`
+	UsedBytes    int64               `json:"used_bytes"`
+	QuotaBytes   int64               `json:"quota_bytes,omitempty"` // Zero is unlimited
+	Artifacts    int64               `json:"artifacts"`
+	ByType       []ArtifactTypeUsage `json:"by_type"`
+	ReconciledAt *time.Time          `json:"reconciled_at,omitempty"`
+}
+
+// StorageUsageMirror is the Redis side of storage accounting: a cache of
+// usage for cheap reads and the project event channel
+type StorageUsageMirror interface {
+	// Get returns the usage cached for a project, nil when there is none
+	Get(ctx context.Context, projectID string) (*StorageUsage, error)
+	Set(ctx context.Context, usage *StorageUsage, ttl time.Duration) error
+	Delete(ctx context.Context, projectIDs ...string) error
+	Publish(ctx context.Context, projectID string, event []byte) error
+}
+
+const storageUsageKeyPrefix = "project_storage:"
+
+// redisStorageUsageMirror caches usage in Redis and publishes events on the
+// channel workflow events go to. While Redis is unreachable usage is read
+// from the database and events are buffered.
+type redisStorageUsageMirror struct {
+	client *redis.Client
+}
+
+// NewRedisStorageUsageMirror mirrors storage usage in Redis
+func NewRedisStorageUsageMirror(client *redis.Client) StorageUsageMirror {
+	return &redisStorageUsageMirror{client: client}
+}
+
+func (r *redisStorageUsageMirror) Get(ctx context.Context, projectID string) (*StorageUsage, error) {
+	data, ok := RedisGuardFor(r.client, nil).Get(ctx, storageUsageKeyPrefix+projectID)
+	if !ok {
+		return nil, nil
+	}
+	var usage StorageUsage
+	if err := json.Unmarshal([]byte(data), &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+func (r *redisStorageUsageMirror) Set(ctx context.Context, usage *StorageUsage, ttl time.Duration) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	RedisGuardFor(r.client, nil).Set(ctx, storageUsageKeyPrefix+usage.ProjectID, data, ttl)
+	return nil
+}
+
+func (r *redisStorageUsageMirror) Delete(ctx context.Context, projectIDs ...string) error {
+	if len(projectIDs) == 0 {
+		return nil
+	}
+	keys := make([]string, len(projectIDs))
+	for i, projectID := range projectIDs {
+		keys[i] = storageUsageKeyPrefix + projectID
+	}
+	RedisGuardFor(r.client, nil).Invalidate(ctx, keys...)
+	return nil
+}
+
+func (r *redisStorageUsageMirror) Publish(ctx context.Context, projectID string, event []byte) error {
+	return RedisGuardFor(r.client, nil).Publish(ctx, fmt.Sprintf("workflow:events:%s", projectID), event)
+}
+
+// StorageAccountant keeps the per-project artifact storage counters of the
+// project_storage_usage table in step with the artifacts it stores and
+// removes, and enforces the storage quotas of projects. Counters are updated
+// in the transaction writing the artifacts, under a lock on the project row,
+// so concurrent stores cannot both pass a quota. A background job removes
+// expired artifacts and recomputes the counters from the artifacts table to
+// correct drift.
+type StorageAccountant struct {
+	db       *gorm.DB
+	mirror   StorageUsageMirror
+	logger   *zap.Logger
+	cacheTTL time.Duration
+	interval time.Duration
+	now      func() time.Time
+	usage    *UsageMeter
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewStorageAccountant creates a storage accountant. The mirror may be nil,
+// in which case usage is always read from the database and no events are
+// published.
+func NewStorageAccountant(db *gorm.DB, mirror StorageUsageMirror, cfg *config.StorageConfig, logger *zap.Logger) *StorageAccountant {
+	interval := time.Duration(cfg.ReconcileInterval) * time.Second
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	return &StorageAccountant{
+		db:       db,
+		mirror:   mirror,
+		logger:   logger,
+		cacheTTL: time.Duration(cfg.UsageCacheTTL) * time.Second,
+		interval: interval,
+		now:      time.Now,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start starts the background expiry and reconciliation job
+func (a *StorageAccountant) Start() {
+	a.wg.Add(1)
+	go a.run()
+	a.logger.Info("Storage accountant started", zap.Duration("reconcile_interval", a.interval))
+}
+
+// Stop stops the background job
+func (a *StorageAccountant) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stopChan)
+		a.wg.Wait()
+		a.logger.Info("Storage accountant stopped")
+	})
+}
+
+func (a *StorageAccountant) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.stopChan:
+			return
+		}
+
+		ctx := context.Background()
+		expired, err := a.ExpireArtifacts(ctx)
+		if err != nil {
+			a.logger.Error("Failed to remove expired artifacts", zap.Error(err))
+		}
+		corrected, err := a.Reconcile(ctx)
+		if err != nil {
+			a.logger.Error("Failed to reconcile project storage usage", zap.Error(err))
+			continue
+		}
+		a.logger.Info("Project storage usage reconciled",
+			zap.Int("expired_artifacts", expired),
+			zap.Int("corrected_projects", corrected))
+	}
+}
+
+// StoreArtifacts records artifacts of a project and adds them to its storage
+// usage. Artifacts already recorded are skipped, so a retried store counts
+// each artifact once. Artifacts of a workflow are versioned by logical key;
+// those with the content of their key's latest version are skipped too. When the new artifacts would take the project over its
+// quota nothing is stored, a storage_quota_exceeded event is published and an
+// error wrapping ErrStorageQuotaExceeded is returned.
+func (a *StorageAccountant) StoreArtifacts(ctx context.Context, projectID string, artifacts []models.Artifact) error {
+	if len(artifacts) == 0 {
+		return nil
+	}
+	ids := make([]string, len(artifacts))
+	for i := range artifacts {
+		if artifacts[i].Size < 0 {
+			return fmt.Errorf("artifact %q has a negative size", artifacts[i].Name)
+		}
+		if artifacts[i].ID == "" {
+			artifacts[i].ID = uuid.NewString()
+		}
+		ids[i] = artifacts[i].ID
+	}
+
+	var rejection *storageQuotaRejection
+	var storedBytes int64
+	err := a.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		project, err := lockProject(tx, projectID)
+		if err != nil {
+			return err
+		}
+
+		var existing []string
+		if err := tx.Unscoped().Model(&models.Artifact{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+			return fmt.Errorf("failed to list stored artifacts: %w", err)
+		}
+		stored := make(map[string]bool, len(existing))
+		for _, id := range existing {
+			stored[id] = true
+		}
+		var pending []*models.Artifact
+		for i := range artifacts {
+			if !stored[artifacts[i].ID] {
+				stored[artifacts[i].ID] = true
+				pending = append(pending, &artifacts[i])
+			}
+		}
+		// Writing to the logical key of an artifact of the same workflow adds
+		// a version; the project lock serializes the writes of its workflows
+		pending, err = versionArtifacts(tx, pending)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		var requested int64
+		for _, artifact := range pending {
+			requested += artifact.Size
+		}
+
+		if quota := a.quota(project); quota > 0 {
+			used, err := usedBytes(tx, projectID)
+			if err != nil {
+				return err
+			}
+			if used+requested > quota {
+				rejection = &storageQuotaRejection{used: used, requested: requested, quota: quota}
+				return fmt.Errorf("%w: project %s uses %d of %d bytes and cannot store %d more",
+					ErrStorageQuotaExceeded, projectID, used, quota, requested)
+			}
+		}
+
+		deltas := make(map[string]*ArtifactTypeUsage)
+		for _, artifact := range pending {
+			if err := tx.Create(artifact).Error; err != nil {
+				return fmt.Errorf("failed to store artifact: %w", err)
+			}
+			addUsage(deltas, artifact.Type, artifact.Size, 1)
+		}
+		storedBytes = requested
+		return applyUsageDeltas(tx, projectID, deltas)
+	})
+	if rejection != nil {
+		storageQuotaRejectionsTotal.Inc()
+		a.publishQuotaExceeded(ctx, projectID, rejection)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.invalidate(ctx, projectID)
+	a.usage.Record(ctx, projectID, UsageMetricArtifactBytes, storedBytes)
+	return nil
+}
+
+// DeleteArtifacts deletes artifacts of a project and releases their storage.
+// It returns the number of artifacts deleted.
+func (a *StorageAccountant) DeleteArtifacts(ctx context.Context, projectID string, artifactIDs []string) (int, error) {
+	if len(artifactIDs) == 0 {
+		return 0, nil
+	}
+	var artifacts []storedArtifact
+	if err := storedArtifacts(a.db.WithContext(ctx)).
+		Where("executions.project_id = ? AND artifacts.id IN ?", projectID, artifactIDs).
+		Scan(&artifacts).Error; err != nil {
+		return 0, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+	return a.release(ctx, artifacts)
+}
+
+// ExpireArtifacts deletes the artifacts past their expiry and releases their
+// storage. It returns the number of artifacts deleted.
+func (a *StorageAccountant) ExpireArtifacts(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		var artifacts []storedArtifact
+		if err := storedArtifacts(a.db.WithContext(ctx)).
+			Where("artifacts.expires_at IS NOT NULL AND artifacts.expires_at <= ?", a.now()).
+			Order("artifacts.expires_at").
+			Limit(artifactExpiryBatchSize).
+			Scan(&artifacts).Error; err != nil {
+			return total, fmt.Errorf("failed to list expired artifacts: %w", err)
+		}
+		released, err := a.release(ctx, artifacts)
+		total += released
+		if err != nil || len(artifacts) < artifactExpiryBatchSize || released == 0 {
+			return total, err
+		}
+	}
+}
+
+// GetUsage returns the storage used by a project and its quota, from the
+// mirror when it holds them
+func (a *StorageAccountant) GetUsage(ctx context.Context, projectID string) (*StorageUsage, error) {
+	if a.mirror != nil {
+		usage, err := a.mirror.Get(ctx, projectID)
+		if err != nil {
+			a.logger.Warn("Failed to read cached storage usage", zap.String("project_id", projectID), zap.Error(err))
+		} else if usage != nil {
+			return usage, nil
+		}
+	}
+
+	var project models.Project
+	if err := a.db.WithContext(ctx).Select("id", "resource_limits").First(&project, "id = ?", projectID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, projectID)
+		}
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+
+	var rows []models.ProjectStorageUsage
+	if err := a.db.WithContext(ctx).Where("project_id = ?", projectID).Order("artifact_type").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get storage usage: %w", err)
+	}
+
+	usage := &StorageUsage{
+		ProjectID:  projectID,
+		QuotaBytes: a.quota(&project),
+		ByType:     make([]ArtifactTypeUsage, 0, len(rows)),
+	}
+	for _, row := range rows {
+		usage.UsedBytes += row.Bytes
+		usage.Artifacts += row.Artifacts
+		usage.ByType = append(usage.ByType, ArtifactTypeUsage{Type: row.ArtifactType, Bytes: row.Bytes, Artifacts: row.Artifacts})
+		if row.ReconciledAt != nil && (usage.ReconciledAt == nil || row.ReconciledAt.After(*usage.ReconciledAt)) {
+			usage.ReconciledAt = row.ReconciledAt
+		}
+	}
+
+	if a.mirror != nil && a.cacheTTL > 0 {
+		if err := a.mirror.Set(ctx, usage, a.cacheTTL); err != nil {
+			a.logger.Warn("Failed to cache storage usage", zap.String("project_id", projectID), zap.Error(err))
+		}
+	}
+	return usage, nil
+}
+
+// Reconcile recomputes the storage counters of every project from the
+// artifacts table. It returns the number of projects whose counters had
+// drifted.
+func (a *StorageAccountant) Reconcile(ctx context.Context) (int, error) {
+	var projectIDs []string
+	if err := a.db.WithContext(ctx).Raw(`SELECT DISTINCT executions.project_id FROM artifacts
+		JOIN executions ON executions.id = artifacts.execution_id
+		WHERE artifacts.deleted_at IS NULL
+		UNION SELECT project_id FROM project_storage_usage`).
+		Scan(&projectIDs).Error; err != nil {
+		return 0, fmt.Errorf("failed to list projects storing artifacts: %w", err)
+	}
+
+	corrected := 0
+	for _, projectID := range projectIDs {
+		drifted, err := a.reconcileProject(ctx, projectID)
+		if err != nil {
+			return corrected, err
+		}
+		if drifted {
+			corrected++
+		}
+	}
+	storageReconcileCorrectionsTotal.Add(float64(corrected))
+	return corrected, nil
+}
+
+// reconcileProject recomputes the storage counters of a project, holding the
+// lock stores take so none is lost between the count and the write
+func (a *StorageAccountant) reconcileProject(ctx context.Context, projectID string) (bool, error) {
+	drifted := false
+	err := a.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := lockProject(tx.Unscoped(), projectID); err != nil && !errors.Is(err, ErrProjectNotFound) {
+			return err
+		}
+
+		var actual []ArtifactTypeUsage
+		if err := tx.Table("artifacts").
+			Select("artifacts.type AS type, COALESCE(SUM(artifacts.size), 0) AS bytes, COUNT(*) AS artifacts").
+			Joins("JOIN executions ON executions.id = artifacts.execution_id").
+			Where("executions.project_id = ? AND artifacts.deleted_at IS NULL", projectID).
+			Group("artifacts.type").
+			Scan(&actual).Error; err != nil {
+			return fmt.Errorf("failed to count artifacts: %w", err)
+		}
+
+		var rows []models.ProjectStorageUsage
+		if err := tx.Where("project_id = ?", projectID).Find(&rows).Error; err != nil {
+			return fmt.Errorf("failed to get storage usage: %w", err)
+		}
+		recorded := make(map[string]models.ProjectStorageUsage, len(rows))
+		for _, row := range rows {
+			recorded[row.ArtifactType] = row
+		}
+
+		now := a.now()
+		for _, usage := range actual {
+			row, ok := recorded[usage.Type]
+			delete(recorded, usage.Type)
+			if !ok || row.Bytes != usage.Bytes || row.Artifacts != usage.Artifacts {
+				drifted = true
+				a.logger.Warn("Correcting drifted project storage usage",
+					zap.String("project_id", projectID),
+					zap.String("artifact_type", usage.Type),
+					zap.Int64("recorded_bytes", row.Bytes),
+					zap.Int64("actual_bytes", usage.Bytes))
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "project_id"}, {Name: "artifact_type"}},
+				UpdateAll: true,
+			}).Create(&models.ProjectStorageUsage{
+				ProjectID:    projectID,
+				ArtifactType: usage.Type,
+				Bytes:        usage.Bytes,
+				Artifacts:    usage.Artifacts,
+				ReconciledAt: &now,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to save storage usage: %w", err)
+			}
+		}
+
+		// Counters of types the project no longer stores
+		for artifactType, row := range recorded {
+			if row.Bytes != 0 || row.Artifacts != 0 {
+				drifted = true
+			}
+			if err := tx.Where("project_id = ? AND artifact_type = ?", projectID, artifactType).
+				Delete(&models.ProjectStorageUsage{}).Error; err != nil {
+				return fmt.Errorf("failed to delete storage usage: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	a.invalidate(ctx, projectID)
+	return drifted, nil
+}
+
+// storedArtifact is an artifact with the project it belongs to
+type storedArtifact struct {
+	ID        string
+	ProjectID string
+	Type      string
+	Size      int64
+}
+
+// storedArtifacts selects the artifacts not yet deleted, with their project
+func storedArtifacts(db *gorm.DB) *gorm.DB {
+	return db.Table("artifacts").
+		Select("artifacts.id AS id, executions.project_id AS project_id, artifacts.type AS type, artifacts.size AS size").
+		Joins("JOIN executions ON executions.id = artifacts.execution_id").
+		Where("artifacts.deleted_at IS NULL")
+}
+
+// release deletes artifacts and subtracts them from the usage of their
+// projects. Artifacts deleted concurrently are only subtracted once.
+func (a *StorageAccountant) release(ctx context.Context, artifacts []storedArtifact) (int, error) {
+	byProject := make(map[string][]storedArtifact)
+	for _, artifact := range artifacts {
+		byProject[artifact.ProjectID] = append(byProject[artifact.ProjectID], artifact)
+	}
+	projectIDs := make([]string, 0, len(byProject))
+	for projectID := range byProject {
+		projectIDs = append(projectIDs, projectID)
+	}
+	sort.Strings(projectIDs)
+
+	released := 0
+	for _, projectID := range projectIDs {
+		err := a.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if _, err := lockProject(tx.Unscoped(), projectID); err != nil && !errors.Is(err, ErrProjectNotFound) {
+				return err
+			}
+			deltas := make(map[string]*ArtifactTypeUsage)
+			for _, artifact := range byProject[projectID] {
+				result := tx.Delete(&models.Artifact{}, "id = ?", artifact.ID)
+				if result.Error != nil {
+					return fmt.Errorf("failed to delete artifact: %w", result.Error)
+				}
+				if result.RowsAffected > 0 {
+					released++
+					addUsage(deltas, artifact.Type, -artifact.Size, -1)
+				}
+			}
+			return applyUsageDeltas(tx, projectID, deltas)
+		})
+		if err != nil {
+			return released, err
+		}
+		a.invalidate(ctx, projectID)
+	}
+	return released, nil
+}
+
+// quota returns a project's storage quota in bytes, zero when it has none.
+// Unreadable limits are logged and leave the project unlimited.
+func (a *StorageAccountant) quota(project *models.Project) int64 {
+	limits, err := models.ParseProjectResourceLimits(project.ResourceLimits)
+	if err != nil {
+		a.logger.Warn("Ignoring invalid project resource limits", zap.String("project_id", project.ID), zap.Error(err))
+		return 0
+	}
+	return limits.MaxStorageBytes
+}
+
+// invalidate drops the usage cached for a project after it changed
+func (a *StorageAccountant) invalidate(ctx context.Context, projectID string) {
+	if a.mirror == nil {
+		return
+	}
+	if err := a.mirror.Delete(ctx, projectID); err != nil {
+		a.logger.Warn("Failed to invalidate cached storage usage", zap.String("project_id", projectID), zap.Error(err))
+	}
+}
+
+// storageQuotaRejection describes artifacts rejected by a storage quota
+type storageQuotaRejection struct {
+	used, requested, quota int64
+}
+
+// publishQuotaExceeded publishes a storage_quota_exceeded event
+func (a *StorageAccountant) publishQuotaExceeded(ctx context.Context, projectID string, rejection *storageQuotaRejection) {
+	a.logger.Warn("Artifacts rejected by storage quota",
+		zap.String("project_id", projectID),
+		zap.Int64("used_bytes", rejection.used),
+		zap.Int64("requested_bytes", rejection.requested),
+		zap.Int64("quota_bytes", rejection.quota))
+	if a.mirror == nil {
+		return
+	}
+
+	event, err := json.Marshal(map[string]interface{}{
+		"project_id": projectID,
+		"event_type": StorageQuotaExceededEvent,
+		"timestamp":  a.now(),
+		"data": map[string]interface{}{
+			"used_bytes":      rejection.used,
+			"requested_bytes": rejection.requested,
+			"quota_bytes":     rejection.quota,
+		},
+	})
+	if err != nil {
+		a.logger.Error("failed to marshal storage event", zap.Error(err))
+		return
+	}
+	if err := a.mirror.Publish(ctx, projectID, event); err != nil {
+		a.logger.Error("failed to publish storage event", zap.Error(err))
+	}
+}
+
+// lockProject loads a project's ID and resource limits, locking its row for
+// the rest of the transaction
+func lockProject(tx *gorm.DB, projectID string) (*models.Project, error) {
+	var project models.Project
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "resource_limits").
+		First(&project, "id = ?", projectID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, projectID)
+		}
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+	return &project, nil
+}
+
+// usedBytes sums a project's storage counters
+func usedBytes(tx *gorm.DB, projectID string) (int64, error) {
+	var used int64
+	if err := tx.Model(&models.ProjectStorageUsage{}).
+		Where("project_id = ?", projectID).
+		Select("COALESCE(SUM(bytes), 0)").
+		Scan(&used).Error; err != nil {
+		return 0, fmt.Errorf("failed to get storage usage: %w", err)
+	}
+	return used, nil
+}
+
+// addUsage adds bytes and artifacts to the delta of an artifact type
+func addUsage(deltas map[string]*ArtifactTypeUsage, artifactType string, bytes, artifacts int64) {
+	delta, ok := deltas[artifactType]
+	if !ok {
+		delta = &ArtifactTypeUsage{Type: artifactType}
+		deltas[artifactType] = delta
+	}
+	delta.Bytes += bytes
+	delta.Artifacts += artifacts
+}
+
+// applyUsageDeltas adds deltas to a project's storage counters
+func applyUsageDeltas(tx *gorm.DB, projectID string, deltas map[string]*ArtifactTypeUsage) error {
+	for _, delta := range deltas {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "project_id"}, {Name: "artifact_type"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"bytes":      gorm.Expr("project_storage_usage.bytes + ?", delta.Bytes),
+				"artifacts":  gorm.Expr("project_storage_usage.artifacts + ?", delta.Artifacts),
+				"updated_at": gorm.Expr("excluded.updated_at"),
+			}),
+		}).Create(&models.ProjectStorageUsage{
+			ProjectID:    projectID,
+			ArtifactType: delta.Type,
+			Bytes:        delta.Bytes,
+			Artifacts:    delta.Artifacts,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update storage usage: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// memoryStorageUsageMirror is an in-memory StorageUsageMirror
+type memoryStorageUsageMirror struct {
+	mu     sync.Mutex
+	usage  map[string]StorageUsage
+	events []map[string]interface{}
+}
+
+func (m *memoryStorageUsageMirror) Get(ctx context.Context, projectID string) (*StorageUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage, ok := m.usage[projectID]
+	if !ok {
+		return nil, nil
+	}
+	return &usage, nil
+}
+
+func (m *memoryStorageUsageMirror) Set(ctx context.Context, usage *StorageUsage, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage[usage.ProjectID] = *usage
+	return nil
+}
+
+func (m *memoryStorageUsageMirror) Delete(ctx context.Context, projectIDs ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, projectID := range projectIDs {
+		delete(m.usage, projectID)
+	}
+	return nil
+}
+
+func (m *memoryStorageUsageMirror) Publish(ctx context.Context, projectID string, event []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(event, &decoded); err != nil {
+		return err
+	}
+	m.events = append(m.events, decoded)
+	return nil
+}
+
+func setupStorageTest(t *testing.T) (*gorm.DB, *StorageAccountant, *memoryStorageUsageMirror) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, resource_limits BLOB, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, workflow_id TEXT, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT,
+		path TEXT, url TEXT, size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+		workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`).Error)
+	require.NoError(t, db.AutoMigrate(&models.ProjectStorageUsage{}))
+
+	for _, project := range []struct{ id, limits string }{
+		{"project-1", ""},
+		{"project-2", `{"max_storage_bytes": 100}`},
+	} {
+		require.NoError(t, db.Exec(`INSERT INTO projects (id, resource_limits) VALUES (?, ?)`, project.id, []byte(project.limits)).Error)
+		require.NoError(t, db.Exec(`INSERT INTO executions (id, project_id) VALUES (?, ?)`, "exec-"+project.id, project.id).Error)
+	}
+
+	mirror := &memoryStorageUsageMirror{usage: make(map[string]StorageUsage)}
+	accountant := NewStorageAccountant(db, mirror, &config.StorageConfig{ReconcileInterval: 3600, UsageCacheTTL: 300}, zap.NewNop())
+	return db, accountant, mirror
+}
+
+func artifact(projectID, id, artifactType string, size int64) models.Artifact {
+	return models.Artifact{ID: id, ExecutionID: "exec-" + projectID, Name: id, Type: artifactType, Size: size}
+}
+
+func TestStorageAccountant_StoreAndRelease(t *testing.T) {
+	db, accountant, mirror := setupStorageTest(t)
+	ctx := context.Background()
+
+	stored := []models.Artifact{
+		artifact("project-1", "a-1", "code", 100),
+		artifact("project-1", "a-2", "code", 50),
+		artifact("project-1", "a-3", "docs", 25),
+	}
+	require.NoError(t, accountant.StoreArtifacts(ctx, "project-1", stored))
+	// A retried store counts each artifact once
+	require.NoError(t, accountant.StoreArtifacts(ctx, "project-1", stored[:2]))
+
+	usage, err := accountant.GetUsage(ctx, "project-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(175), usage.UsedBytes)
+	assert.Equal(t, int64(3), usage.Artifacts)
+	assert.Zero(t, usage.QuotaBytes)
+	assert.Equal(t, []ArtifactTypeUsage{
+		{Type: "code", Bytes: 150, Artifacts: 2},
+		{Type: "docs", Bytes: 25, Artifacts: 1},
+	}, usage.ByType)
+	assert.Contains(t, mirror.usage, "project-1", "reads are cached")
+
+	// Deletion releases storage and invalidates the cache
+	deleted, err := accountant.DeleteArtifacts(ctx, "project-1", []string{"a-2", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.NotContains(t, mirror.usage, "project-1")
+	deleted, err = accountant.DeleteArtifacts(ctx, "project-1", []string{"a-2"})
+	require.NoError(t, err)
+	assert.Zero(t, deleted, "deleted artifacts are released once")
+
+	// So does expiry
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	accountant.now = func() time.Time { return now }
+	require.NoError(t, db.Model(&models.Artifact{}).Where("id = ?", "a-3").Update("expires_at", now.Add(-time.Minute)).Error)
+	require.NoError(t, db.Model(&models.Artifact{}).Where("id = ?", "a-1").Update("expires_at", now.Add(time.Hour)).Error)
+	expired, err := accountant.ExpireArtifacts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+
+	usage, err = accountant.GetUsage(ctx, "project-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), usage.UsedBytes)
+	assert.Equal(t, int64(1), usage.Artifacts)
+	assert.Equal(t, []ArtifactTypeUsage{
+		{Type: "code", Bytes: 100, Artifacts: 1},
+		{Type: "docs", Bytes: 0, Artifacts: 0},
+	}, usage.ByType)
+
+	// Project stats report the same usage
+	service := NewProjectService(db, zap.NewNop())
+	service.SetStorageAccountant(accountant)
+	storage, err := service.GetProjectStorage(ctx, "project-1")
+	require.NoError(t, err)
+	assert.Equal(t, usage, storage)
+
+	_, err = accountant.GetUsage(ctx, "missing")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+	assert.ErrorIs(t, accountant.StoreArtifacts(ctx, "missing", []models.Artifact{artifact("missing", "a-4", "code", 1)}), ErrProjectNotFound)
+}
+
+func TestStorageAccountant_RejectsArtifactsOverQuota(t *testing.T) {
+	db, accountant, mirror := setupStorageTest(t)
+	ctx := context.Background()
+
+	require.NoError(t, accountant.StoreArtifacts(ctx, "project-2", []models.Artifact{artifact("project-2", "a-1", "code", 60)}))
+
+	err := accountant.StoreArtifacts(ctx, "project-2", []models.Artifact{
+		artifact("project-2", "a-2", "code", 30),
+		artifact("project-2", "a-3", "docs", 20),
+	})
+	require.ErrorIs(t, err, ErrStorageQuotaExceeded)
+	assert.Contains(t, err.Error(), "uses 60 of 100 bytes and cannot store 50 more")
+
+	// Nothing of a rejected store is kept
+	var count int64
+	require.NoError(t, db.Model(&models.Artifact{}).Where("id IN ?", []string{"a-2", "a-3"}).Count(&count).Error)
+	assert.Zero(t, count)
+
+	require.Len(t, mirror.events, 1)
+	event := mirror.events[0]
+	assert.Equal(t, StorageQuotaExceededEvent, event["event_type"])
+	assert.Equal(t, "project-2", event["project_id"])
+	assert.Equal(t, map[string]interface{}{"used_bytes": 60.0, "requested_bytes": 50.0, "quota_bytes": 100.0}, event["data"])
+
+	// Filling the quota exactly is allowed, and the stored artifact does not
+	// count against its retry
+	last := []models.Artifact{artifact("project-2", "a-2", "code", 40)}
+	require.NoError(t, accountant.StoreArtifacts(ctx, "project-2", last))
+	require.NoError(t, accountant.StoreArtifacts(ctx, "project-2", last))
+
+	usage, err := accountant.GetUsage(ctx, "project-2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), usage.UsedBytes)
+	assert.Equal(t, int64(100), usage.QuotaBytes)
+	assert.Len(t, mirror.events, 1)
+}
+
+func TestStorageAccountant_ReconcileFixesDrift(t *testing.T) {
+	db, accountant, mirror := setupStorageTest(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+	accountant.now = func() time.Time { return now }
+
+	require.NoError(t, accountant.StoreArtifacts(ctx, "project-1", []models.Artifact{
+		artifact("project-1", "a-1", "code", 100),
+		artifact("project-1", "a-2", "docs", 25),
+	}))
+	require.NoError(t, accountant.StoreArtifacts(ctx, "project-2", []models.Artifact{artifact("project-2", "a-3", "code", 10)}))
+
+	// Skew project-1: a lost decrement, a counter for a type it no longer
+	// stores and an artifact removed behind the accountant's back
+	require.NoError(t, db.Model(&models.ProjectStorageUsage{}).
+		Where("project_id = ? AND artifact_type = ?", "project-1", "code").
+		Updates(map[string]interface{}{"bytes": 9999, "artifacts": 7}).Error)
+	require.NoError(t, db.Create(&models.ProjectStorageUsage{ProjectID: "project-1", ArtifactType: "logs", Bytes: 5, Artifacts: 1}).Error)
+	require.NoError(t, db.Delete(&models.Artifact{}, "id = ?", "a-2").Error)
+	_, err := accountant.GetUsage(ctx, "project-1")
+	require.NoError(t, err)
+
+	corrected, err := accountant.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, corrected)
+	assert.Empty(t, mirror.usage, "reconciled usage is read afresh")
+
+	usage, err := accountant.GetUsage(ctx, "project-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), usage.UsedBytes)
+	assert.Equal(t, int64(1), usage.Artifacts)
+	assert.Equal(t, []ArtifactTypeUsage{{Type: "code", Bytes: 100, Artifacts: 1}}, usage.ByType)
+	require.NotNil(t, usage.ReconciledAt)
+	assert.True(t, now.Equal(*usage.ReconciledAt))
+
+	usage, err = accountant.GetUsage(ctx, "project-2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), usage.UsedBytes)
+
+	// Accurate counters are left as they are
+	corrected, err = accountant.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, corrected)
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidSimulationConfig is returned when the simulation settings in a
+// workflow config cannot be parsed
+var ErrInvalidSimulationConfig = errors.New("invalid simulation config")
+
+// SimulationConfig holds the simulation settings of a workflow as activities
+// take them. Workflow configs set them as
+//
+//	{"simulation": {"enabled": true, "fixtures": {"backend": {"artifacts": [...]}}}}
+type SimulationConfig struct {
+	Enabled  bool         `json:"simulation"`
+	Fixtures StubFixtures `json:"simulation_fixtures,omitempty"`
+}
+
+// StubFixtures maps a task type, or an agent task type such as
+// execute_code, to the canned response returned for it. The "default" entry
+// is used when nothing more specific matches.
+type StubFixtures map[string]StubResponse
+
+// StubResponse is a canned agent response. Output is merged over the built-in
+// response for the task type; a non-empty Error makes the task fail.
+type StubResponse struct {
+	Status    string                 `json:"status,omitempty"`
+	Output    map[string]interface{} `json:"output,omitempty"`
+	Artifacts []StubArtifact         `json:"artifacts,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// StubArtifact is an artifact returned by a stub agent
+type StubArtifact struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Content     string `json:"content"`
+	Path        string `json:"path,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// ParseSimulationConfig reads the simulation settings from a workflow config
+// of any schema version. It returns nil when simulation is not enabled.
+// Configs that are not JSON objects carry no simulation settings.
+func ParseSimulationConfig(raw json.RawMessage) (*SimulationConfig, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, nil
+	}
+	if _, ok := fields["simulation"]; !ok {
+		return nil, nil
+	}
+	if err := migrateWorkflowConfigV1(fields); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSimulationConfig, err)
+	}
+
+	var settings SimulationSettings
+	if err := json.Unmarshal(fields["simulation"], &settings); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSimulationConfig, err)
+	}
+	if !settings.Enabled {
+		return nil, nil
+	}
+	return &SimulationConfig{Enabled: true, Fixtures: settings.Fixtures}, nil
+}
+
+const (
+	stubMetaAgentID     = "stub-meta-agent"
+	stubCodeExecutorID  = "stub-code-executor"
+	stubDesignIDPrefix  = "stub-design-"
+	stubSpawnedIDPrefix = "stub-agent-"
+)
+
+// StubAgentClient is an in-process AgentExecutor for simulated workflows. It
+// emulates the meta-prompt agent and a code executor and answers tasks with
+// deterministic canned responses taken from fixtures.
+type StubAgentClient struct {
+	fixtures      StubFixtures
+	mu            sync.Mutex
+	agents        map[string]Agent
+	designs       map[string][]string
+	conversations *localConversations
+}
+
+var _ AgentExecutor = (*StubAgentClient)(nil)
+
+// NewStubAgentClient creates a stub client answering from the given fixtures
+func NewStubAgentClient(fixtures StubFixtures) *StubAgentClient {
+	c := &StubAgentClient{
+		fixtures: fixtures,
+		agents:   make(map[string]Agent),
+		designs:  make(map[string][]string),
+	}
+	c.conversations = newLocalConversations("stub-conversation", c.ExecuteTask)
+	c.agents[stubMetaAgentID] = Agent{
+		ID:           stubMetaAgentID,
+		Name:         "Simulated meta-prompt agent",
+		Type:         "meta-prompt",
+		Status:       "available",
+		Capabilities: stubCapabilities("agent-design", "agent-spawn"),
+		Tags:         []string{"simulated"},
+	}
+	c.agents[stubCodeExecutorID] = Agent{
+		ID:           stubCodeExecutorID,
+		Name:         "Simulated code executor",
+		Type:         "code_executor",
+		Status:       "active",
+		Capabilities: []Capability{{Name: "multi-language", Version: "*"}}, // Every runtime
+		Tags:         []string{"simulated"},
+	}
+	return c
+}
+
+// CreateAgent implements AgentExecutor
+func (c *StubAgentClient) CreateAgent(ctx context.Context, req *CreateAgentRequest) (*Agent, error) {
+	name := req.Name
+	if name == "" {
+		name = req.Type
+	}
+	agent := Agent{
+		ID:           stubSpawnedIDPrefix + stubSlug(name),
+		Name:         name,
+		Type:         req.Type,
+		Status:       "available",
+		ProjectID:    req.ProjectID,
+		Config:       req.Config,
+		Capabilities: stubCapabilities(req.Capabilities...),
+		Tags:         append([]string{"simulated"}, req.Tags...),
+	}
+
+	c.mu.Lock()
+	c.agents[agent.ID] = agent
+	c.mu.Unlock()
+	return &agent, nil
+}
+
+// GetAgent implements AgentExecutor
+func (c *StubAgentClient) GetAgent(ctx context.Context, agentID string) (*Agent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	agent, ok := c.agents[agentID]
+	if !ok {
+		return nil, fmt.Errorf("%w: stub agent %s", ErrAgentNotFound, agentID)
+	}
+	return &agent, nil
+}
+
+// ListAgents implements AgentExecutor. Agents are returned in ID order.
+func (c *StubAgentClient) ListAgents(ctx context.Context, filters *AgentFilters) (*AgentList, error) {
+	if filters == nil {
+		filters = &AgentFilters{}
+	}
+
+	c.mu.Lock()
+	agents := make([]Agent, 0, len(c.agents))
+	for _, agent := range c.agents {
+		if filters.Type != "" && agent.Type != filters.Type {
+			continue
+		}
+		if filters.Status != "" && agent.Status != filters.Status {
+			continue
+		}
+		agents = append(agents, agent)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+	if filters.needsClientSideFiltering() {
+		agents = filterAgents(agents, filters, time.Now())
+	}
+
+	return NewAgentListPage(agents, filters), nil
+}
+
+// ExecuteTask implements AgentExecutor. Meta-agent design and spawn requests
+// register a stub agent with the requested capabilities; every other task is
+// answered from the fixtures. A stub client is created per activity, so any
+// agent ID is accepted rather than only those this instance spawned.
+func (c *StubAgentClient) ExecuteTask(ctx context.Context, agentID string, req *ExecuteTaskRequest) (*TaskExecution, error) {
+	var output map[string]interface{}
+	status := "completed"
+
+	switch req.Type {
+	case "design-agent":
+		taskType := stubString(stubMap(req.Input["context"])["task_type"])
+		designID := stubDesignIDPrefix + stubSlug(taskType)
+		c.mu.Lock()
+		c.designs[designID] = stubStrings(stubMap(req.Input["requirements"])["capabilities"])
+		c.mu.Unlock()
+		output = map[string]interface{}{"designId": designID}
+
+	case "spawn-agent":
+		designID := stubString(req.Input["designId"])
+		c.mu.Lock()
+		capabilities, ok := c.designs[designID]
+		if !ok {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("stub design %s not found", designID)
+		}
+		agent := Agent{
+			ID:           stubSpawnedIDPrefix + strings.TrimPrefix(designID, stubDesignIDPrefix),
+			Name:         "Simulated " + strings.TrimPrefix(designID, stubDesignIDPrefix) + " agent",
+			Type:         DynamicAgentType,
+			Status:       "available",
+			Capabilities: stubCapabilities(capabilities...),
+			Tags:         []string{"simulated"},
+		}
+		c.agents[agent.ID] = agent
+		c.mu.Unlock()
+		output = map[string]interface{}{"agentId": agent.ID}
+
+	default:
+		output = stubDefaultOutput(req)
+		if fixture, ok := c.fixtureFor(req); ok {
+			if fixture.Error != "" {
+				return nil, fmt.Errorf("simulated agent error: %s", fixture.Error)
+			}
+			if fixture.Status != "" {
+				status = fixture.Status
+			}
+			for key, value := range fixture.Output {
+				output[key] = value
+			}
+			if len(fixture.Artifacts) > 0 {
+				output["artifacts"] = fixture.Artifacts
+			}
+		}
+	}
+
+	// Round-trip through JSON so activities see the same types as for a
+	// response decoded from the agent manager
+	normalized, err := normalizeStubOutput(output)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &TaskExecution{
+		ID:          fmt.Sprintf("stub-%s-%s", agentID, req.Type),
+		AgentID:     agentID,
+		Type:        req.Type,
+		Status:      status,
+		Input:       req.Input,
+		Output:      normalized,
+		StartedAt:   now,
+		CompletedAt: &now,
+	}, nil
+}
+
+// CreateConversation implements ConversationalAgent
+func (c *StubAgentClient) CreateConversation(ctx context.Context, agentID string, req *CreateConversationRequest) (*Conversation, error) {
+	return c.conversations.Create(agentID, req), nil
+}
+
+// SendTurn implements ConversationalAgent. Each turn is answered like a task
+// of the conversation's type whose input is the conversation context
+// overlaid with the turn input.
+func (c *StubAgentClient) SendTurn(ctx context.Context, agentID, conversationID string, req *SendTurnRequest) (*ConversationTurn, error) {
+	return c.conversations.SendTurn(ctx, agentID, conversationID, req)
+}
+
+// GetTurns implements ConversationalAgent
+func (c *StubAgentClient) GetTurns(ctx context.Context, agentID, conversationID string) ([]ConversationTurn, error) {
+	return c.conversations.GetTurns(conversationID)
+}
+
+// CloseConversation implements ConversationalAgent
+func (c *StubAgentClient) CloseConversation(ctx context.Context, agentID, conversationID string) error {
+	return c.conversations.Close(conversationID)
+}
+
+// fixtureFor looks up the fixture for a request by task type, then agent task
+// type, then "default"
+func (c *StubAgentClient) fixtureFor(req *ExecuteTaskRequest) (StubResponse, bool) {
+	keys := []string{stubString(stubMap(req.Input["task"])["type"]), req.Type, "default"}
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if fixture, ok := c.fixtures[key]; ok {
+			return fixture, true
+		}
+	}
+	return StubResponse{}, false
+}
+
+// stubDefaultOutput returns the built-in response for an agent task type
+func stubDefaultOutput(req *ExecuteTaskRequest) map[string]interface{} {
+	switch req.Type {
+	case "prepare_environment":
+		language := stubString(req.Input["language"])
+		return map[string]interface{}{
+			"environment_id": "stub-env-" + stubSlug(language),
+			"language":       language,
+		}
+	case "execute_code":
+		return map[string]interface{}{
+			"output":    fmt.Sprintf("simulated %s execution", stubString(req.Input["language"])),
+			"exit_code": 0,
+			"metrics":   map[string]interface{}{"duration_ms": 0},
+		}
+	}
+
+	task := stubMap(req.Input["task"])
+	name := stubSlug(stubString(task["id"]))
+	if name == "" {
+		name = stubSlug(req.Type)
+	}
+	return map[string]interface{}{
+		"summary": fmt.Sprintf("Simulated %s for %q", req.Type, stubString(task["title"])),
+		"artifacts": []StubArtifact{{
+			Name:        name + ".md",
+			Type:        "documentation",
+			Content:     fmt.Sprintf("# %s\n\nSimulated output.\n", stubString(task["title"])),
+			Path:        "simulated/" + name + ".md",
+			ContentType: "text/markdown",
+		}},
+	}
+}
+
+func normalizeStubOutput(output map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode stub output: %w", err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, fmt.Errorf("failed to decode stub output: %w", err)
+	}
+	return normalized, nil
+}
+
+func stubCapabilities(names ...string) []Capability {
+	capabilities := make([]Capability, len(names))
+	for i, name := range names {
+		capabilities[i] = Capability{Name: name, Version: "stub"}
+	}
+	return capabilities
+}
+
+func stubSlug(s string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		}
+		return '-'
+	}, s), "-")
+}
+
+func stubMap(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
+}
+
+func stubString(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func stubStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
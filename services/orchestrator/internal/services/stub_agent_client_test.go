@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func TestParseSimulationConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		enabled bool
+		wantErr bool
+	}{
+		{"empty", ``, false, false},
+		{"no simulation key", `{"steps": []}`, false, false},
+		{"not an object", `["simulation"]`, false, false},
+		{"disabled", `{"simulation": false, "simulation_fixtures": {"default": {}}}`, false, false},
+		{"enabled", `{"simulation": true}`, true, false},
+		{"invalid flag", `{"simulation": "yes"}`, false, true},
+		{"invalid fixtures", `{"simulation": true, "simulation_fixtures": {"backend": []}}`, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, err := ParseSimulationConfig(json.RawMessage(tt.config))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSimulationConfig)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.enabled, sim != nil)
+		})
+	}
+}
+
+func TestStubAgentClient_FixturesAreDeterministic(t *testing.T) {
+	ctx := context.Background()
+	fixtures := StubFixtures{
+		"backend":      {Artifacts: []StubArtifact{{Name: "api.go", Type: "code", Content: "package api"}}},
+		"execute_code": {Output: map[string]interface{}{"exit_code": 2}},
+	}
+	req := &ExecuteTaskRequest{
+		Type:  "generate_backend_code",
+		Input: map[string]interface{}{"task": map[string]interface{}{"id": "t1", "type": "backend"}},
+	}
+
+	first, err := NewStubAgentClient(fixtures).ExecuteTask(ctx, "stub-agent-backend", req)
+	require.NoError(t, err)
+	second, err := NewStubAgentClient(fixtures).ExecuteTask(ctx, "stub-agent-backend", req)
+	require.NoError(t, err)
+	assert.Equal(t, first.Output, second.Output)
+	assert.Equal(t, "api.go", first.Output["artifacts"].([]interface{})[0].(map[string]interface{})["name"])
+
+	// Fixture output is merged over the built-in response with JSON types
+	result, err := NewStubAgentClient(fixtures).ExecuteTask(ctx, stubCodeExecutorID, &ExecuteTaskRequest{
+		Type:  "execute_code",
+		Input: map[string]interface{}{"language": "go"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), result.Output["exit_code"])
+	assert.Equal(t, "simulated go execution", result.Output["output"])
+	assert.IsType(t, map[string]interface{}{}, result.Output["metrics"])
+
+	// The code executor is found the same way as a real one
+	agents, err := NewStubAgentClient(nil).ListAgents(ctx, &AgentFilters{Type: "code_executor", Status: "active"})
+	require.NoError(t, err)
+	require.Len(t, agents.Agents, 1)
+	assert.Equal(t, stubCodeExecutorID, agents.Agents[0].ID)
+}
+
+func TestStubAgentClient_Conversations(t *testing.T) {
+	ctx := context.Background()
+	client := NewStubAgentClient(StubFixtures{
+		"backend": {Artifacts: []StubArtifact{{Name: "orders.go", Type: "code", Content: "package orders"}}},
+	})
+
+	conversation, err := client.CreateConversation(ctx, "agent-1", &CreateConversationRequest{
+		Type:    "generate_backend_code",
+		Context: map[string]interface{}{"task": map[string]interface{}{"id": "task-api", "type": "backend"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "open", conversation.Status)
+
+	// Turns are answered from the fixtures of the conversation's task
+	for i := 0; i < 2; i++ {
+		turn, err := client.SendTurn(ctx, "agent-1", conversation.ID, &SendTurnRequest{Input: map[string]interface{}{"round": i + 1}})
+		require.NoError(t, err)
+		assert.Equal(t, i, turn.Index)
+		assert.Equal(t, "completed", turn.Status)
+		assert.Len(t, turn.Output["artifacts"], 1)
+	}
+
+	turns, err := client.GetTurns(ctx, "agent-1", conversation.ID)
+	require.NoError(t, err)
+	require.Len(t, turns, 2)
+	assert.Equal(t, 2, turns[1].Input["round"])
+
+	require.NoError(t, client.CloseConversation(ctx, "agent-1", conversation.ID))
+	_, err = client.SendTurn(ctx, "agent-1", conversation.ID, &SendTurnRequest{})
+	assert.Error(t, err, "closed conversations take no more turns")
+	_, err = client.SendTurn(ctx, "agent-1", "missing", &SendTurnRequest{})
+	assert.Error(t, err)
+}
+
+func TestWorkflowEngine_SimulatedWorkflowsExcludedFromProjectStats(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, status TEXT,
+		simulated BOOLEAN DEFAULT false, deleted_at DATETIME)`).Error)
+
+	mockTemporalClient := new(mocks.Client)
+	mockWorkflowRun := new(mocks.WorkflowRun)
+	mockWorkflowRun.On("GetID").Return("temporal-id")
+	mockWorkflowRun.On("GetRunID").Return("run-id")
+	mockTemporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(mockWorkflowRun, nil)
+
+	// Unreachable Redis: cache writes and event publishing only log errors
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	engine := NewWorkflowEngine(db, redisClient, mockTemporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+
+	start := func(config string) (*StartWorkflowResponse, error) {
+		return engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+			Name:      "Workflow",
+			Type:      string(models.WorkflowTypeTaskExecution),
+			ProjectID: "project-1",
+			UserID:    "user-1",
+			Config:    json.RawMessage(config),
+		})
+	}
+
+	real, err := start(`{"max_parallel": 2}`)
+	require.NoError(t, err)
+	simulated, err := start(`{"simulation": true}`)
+	require.NoError(t, err)
+	_, err = start(`{"simulation": "yes"}`)
+	assert.ErrorIs(t, err, ErrInvalidSimulationConfig)
+
+	var storedSimulated, storedReal models.Workflow
+	require.NoError(t, db.First(&storedSimulated, "id = ?", simulated.WorkflowID).Error)
+	assert.True(t, storedSimulated.Simulated)
+	require.NoError(t, db.First(&storedReal, "id = ?", real.WorkflowID).Error)
+	assert.False(t, storedReal.Simulated)
+
+	for id, row := range map[string][]interface{}{
+		"exec-1": {"succeeded", false},
+		"exec-2": {"succeeded", true},
+		"exec-3": {"failed", true},
+	} {
+		require.NoError(t, db.Exec(`INSERT INTO executions (id, project_id, status, simulated) VALUES (?, ?, ?, ?)`,
+			id, "project-1", row[0], row[1]).Error)
+	}
+
+	stats, err := NewProjectService(db, zap.NewNop()).GetProjectStats(context.Background(), "project-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.TotalWorkflows)
+	assert.Equal(t, int64(1), stats.TotalExecutions)
+	assert.Equal(t, int64(1), stats.SuccessfulExecutions)
+	assert.Equal(t, int64(0), stats.FailedExecutions)
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// IntentProcessor is the part of the Intent Processor client that workflow
+// activities call
+type IntentProcessor interface {
+	AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error)
+	ProcessIntent(ctx context.Context, req *ProcessIntentRequest) (*ProcessIntentResponse, error)
+}
+
+var (
+	_ IntentProcessor = (*IntentClient)(nil)
+	_ IntentProcessor = (*StubIntentClient)(nil)
+)
+
+const (
+	// StubAnalyzeIntentFixture is the fixture key answering intent analyses
+	StubAnalyzeIntentFixture = "analyze_intent"
+	// StubProcessIntentFixture is the fixture key answering processed intents
+	StubProcessIntentFixture = "process_intent"
+
+	stubIntentAction = "simulated_action"
+)
+
+// StubIntentClient is an in-process IntentProcessor for simulated workflows.
+// Analyses plan a single action unless the analyze_intent fixture says
+// otherwise, and processed intents complete with a canned result. Fixture
+// output is merged over the analysis, or over the result of a processed
+// intent; a non-empty Error makes the call fail.
+type StubIntentClient struct {
+	fixtures StubFixtures
+}
+
+// NewStubIntentClient creates a stub client answering from the given fixtures
+func NewStubIntentClient(fixtures StubFixtures) *StubIntentClient {
+	return &StubIntentClient{fixtures: fixtures}
+}
+
+// AnalyzeIntent implements IntentProcessor
+func (c *StubIntentClient) AnalyzeIntent(ctx context.Context, req *AnalyzeIntentRequest) (*AnalyzeIntentResponse, error) {
+	intentType := req.Context["intent_type"]
+	if intentType == "" {
+		intentType = "simulated"
+	}
+	response := map[string]interface{}{
+		"intent_type":     intentType,
+		"confidence":      1,
+		"required_params": []string{stubIntentAction},
+		"optional_params": []string{},
+	}
+
+	fixture, ok := c.fixtures[StubAnalyzeIntentFixture]
+	if ok {
+		if fixture.Error != "" {
+			return nil, fmt.Errorf("simulated intent processor error: %s", fixture.Error)
+		}
+		for key, value := range fixture.Output {
+			response[key] = value
+		}
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode stub analysis: %w", err)
+	}
+	var analysis AnalyzeIntentResponse
+	if err := json.Unmarshal(data, &analysis); err != nil {
+		return nil, fmt.Errorf("failed to decode stub analysis: %w", err)
+	}
+	return &analysis, nil
+}
+
+// ProcessIntent implements IntentProcessor
+func (c *StubIntentClient) ProcessIntent(ctx context.Context, req *ProcessIntentRequest) (*ProcessIntentResponse, error) {
+	status := "completed"
+	result := map[string]interface{}{
+		"summary": fmt.Sprintf("Simulated %s %q", req.Type, req.Content),
+	}
+
+	fixture, ok := c.fixtures[StubProcessIntentFixture]
+	if ok {
+		if fixture.Error != "" {
+			return nil, fmt.Errorf("simulated intent processor error: %s", fixture.Error)
+		}
+		if fixture.Status != "" {
+			status = fixture.Status
+		}
+		for key, value := range fixture.Output {
+			result[key] = value
+		}
+	}
+
+	// Round-trip through JSON so activities see the same types as for a
+	// response decoded from the Intent Processor
+	normalized, err := normalizeStubOutput(result)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessIntentResponse{
+		IntentID:   "stub-intent-" + stubSlug(req.Type),
+		Status:     status,
+		Message:    stubString(normalized["summary"]),
+		Result:     normalized,
+		Confidence: 1,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStubIntentClient(t *testing.T) {
+	ctx := context.Background()
+
+	// Without fixtures analyses plan a single action that completes
+	stub := NewStubIntentClient(nil)
+	analysis, err := stub.AnalyzeIntent(ctx, &AnalyzeIntentRequest{Content: "ship it", Context: map[string]string{"intent_type": "deployment"}})
+	require.NoError(t, err)
+	assert.Equal(t, "deployment", analysis.IntentType)
+	assert.Equal(t, float32(1), analysis.Confidence)
+	assert.Equal(t, []string{stubIntentAction}, analysis.RequiredParams)
+
+	processed, err := stub.ProcessIntent(ctx, &ProcessIntentRequest{Type: "action", Content: stubIntentAction})
+	require.NoError(t, err)
+	assert.Equal(t, "completed", processed.Status)
+	assert.Equal(t, `Simulated action "simulated_action"`, processed.Result["summary"])
+
+	// Fixtures are merged over the canned responses
+	stub = NewStubIntentClient(StubFixtures{
+		StubAnalyzeIntentFixture: {Output: map[string]interface{}{"risks": []string{"drops tables"}, "estimated_time": 600}},
+		StubProcessIntentFixture: {Status: "failed", Output: map[string]interface{}{"rows": 3}},
+	})
+	analysis, err = stub.AnalyzeIntent(ctx, &AnalyzeIntentRequest{Content: "migrate"})
+	require.NoError(t, err)
+	assert.Equal(t, "simulated", analysis.IntentType)
+	assert.Equal(t, []string{"drops tables"}, analysis.Risks)
+	assert.Equal(t, 600, analysis.EstimatedTime)
+
+	processed, err = stub.ProcessIntent(ctx, &ProcessIntentRequest{Type: "action", Content: "migrate"})
+	require.NoError(t, err)
+	assert.Equal(t, "failed", processed.Status)
+	assert.Equal(t, float64(3), processed.Result["rows"])
+
+	stub = NewStubIntentClient(StubFixtures{StubAnalyzeIntentFixture: {Error: "unavailable"}})
+	_, err = stub.AnalyzeIntent(ctx, &AnalyzeIntentRequest{Content: "migrate"})
+	assert.ErrorContains(t, err, "unavailable")
+}
@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// Sources of a TaskEstimate
+const (
+	EstimateSourceHeuristic = "heuristic"
+	EstimateSourceBlended   = "blended"
+)
+
+// TaskEstimate is the estimate a task is executed with
+type TaskEstimate struct {
+	Hours        float64 `json:"hours"`         // Expected duration
+	TimeoutHours float64 `json:"timeout_hours"` // Duration most runs finish within
+	Samples      int64   `json:"samples"`       // Recorded durations the estimate is based on
+	Source       string  `json:"source"`
+}
+
+// HeuristicEstimate returns a task's static estimate unchanged
+func HeuristicEstimate(hours float64) TaskEstimate {
+	return TaskEstimate{Hours: hours, TimeoutHours: hours, Source: EstimateSourceHeuristic}
+}
+
+// TaskDuration is the actual duration of one executed task
+type TaskDuration struct {
+	TaskID         string
+	TaskType       string
+	Complexity     string
+	EstimatedHours float64
+	Duration       time.Duration
+}
+
+// EstimateRefiner learns how long tasks actually take and blends that into
+// the static estimates they are created with
+type EstimateRefiner struct {
+	db         *gorm.DB
+	logger     *zap.Logger
+	minSamples int
+	window     int
+}
+
+// NewEstimateRefiner creates an estimate refiner
+func NewEstimateRefiner(db *gorm.DB, cfg *config.EstimationConfig, logger *zap.Logger) *EstimateRefiner {
+	return &EstimateRefiner{
+		db:         db,
+		logger:     logger,
+		minSamples: cfg.MinSamples,
+		window:     cfg.Window,
+	}
+}
+
+// durationStatKey identifies the durations summarized by one stat
+type durationStatKey struct{ taskType, complexity string }
+
+// RecordDurations stores the durations of a workflow's tasks and refreshes
+// the stats of their type and complexity. Tasks already recorded for the
+// workflow are skipped, so a retried activity counts each task once.
+func (r *EstimateRefiner) RecordDurations(ctx context.Context, workflowID, projectID string, durations []TaskDuration) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		changed := make(map[durationStatKey]bool)
+		for _, duration := range durations {
+			sample := &models.TaskDurationSample{
+				WorkflowID:     workflowID,
+				TaskID:         duration.TaskID,
+				ProjectID:      projectID,
+				TaskType:       duration.TaskType,
+				Complexity:     duration.Complexity,
+				EstimatedHours: duration.EstimatedHours,
+				Duration:       duration.Duration.Milliseconds(),
+			}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(sample)
+			if result.Error != nil {
+				return fmt.Errorf("failed to record task duration: %w", result.Error)
+			}
+			if result.RowsAffected > 0 {
+				changed[durationStatKey{duration.TaskType, duration.Complexity}] = true
+			}
+		}
+
+		for key := range changed {
+			if err := r.refreshStat(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// refreshStat recomputes one stat over the most recent samples
+func (r *EstimateRefiner) refreshStat(tx *gorm.DB, key durationStatKey) error {
+	var durations []int64
+	if err := tx.Model(&models.TaskDurationSample{}).
+		Where("task_type = ? AND complexity = ?", key.taskType, key.complexity).
+		Order("created_at DESC").Limit(r.window).
+		Pluck("duration", &durations).Error; err != nil {
+		return fmt.Errorf("failed to list task durations: %w", err)
+	}
+	if len(durations) == 0 {
+		return nil
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var sum int64
+	for _, duration := range durations {
+		sum += duration
+	}
+	stat := &models.TaskDurationStat{
+		TaskType:    key.taskType,
+		Complexity:  key.complexity,
+		SampleCount: int64(len(durations)),
+		Mean:        sum / int64(len(durations)),
+		P50:         percentile(durations, 0.50),
+		P95:         percentile(durations, 0.95),
+	}
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_type"}, {Name: "complexity"}},
+		UpdateAll: true,
+	}).Create(stat).Error; err != nil {
+		return fmt.Errorf("failed to save task duration stat: %w", err)
+	}
+	return nil
+}
+
+// Refine blends a task's static estimate with the recorded durations of its
+// type and complexity: the median for the estimate and the 95th percentile
+// for the timeout. History is weighted n/(n+min_samples), so it counts as
+// much as the heuristic once min_samples durations are recorded and
+// dominates as more accumulate. Without enough history, or when it cannot
+// be read, the heuristic is returned unchanged.
+func (r *EstimateRefiner) Refine(ctx context.Context, taskType, complexity string, heuristicHours float64) TaskEstimate {
+	estimate := HeuristicEstimate(heuristicHours)
+	if r.minSamples == 0 {
+		return estimate
+	}
+
+	var stat models.TaskDurationStat
+	err := r.db.WithContext(ctx).Where("task_type = ? AND complexity = ?", taskType, complexity).
+		Limit(1).Find(&stat).Error
+	if err != nil {
+		r.logger.Warn("Failed to read task duration stats, using the static estimate",
+			zap.String("task_type", taskType),
+			zap.String("complexity", complexity),
+			zap.Error(err))
+		return estimate
+	}
+	if stat.SampleCount < int64(r.minSamples) {
+		return estimate
+	}
+
+	weight := float64(stat.SampleCount) / float64(stat.SampleCount+int64(r.minSamples))
+	if heuristicHours <= 0 {
+		weight = 1 // Nothing to blend with
+	}
+	blend := func(historicalMs int64) float64 {
+		return weight*msToHours(historicalMs) + (1-weight)*heuristicHours
+	}
+
+	estimate.Hours = blend(stat.P50)
+	estimate.TimeoutHours = math.Max(blend(stat.P95), estimate.Hours)
+	estimate.Samples = stat.SampleCount
+	estimate.Source = EstimateSourceBlended
+	return estimate
+}
+
+func msToHours(ms int64) float64 {
+	return float64(ms) / float64(time.Hour/time.Millisecond)
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile[T int64 | float64](sorted []T, p float64) T {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// estimateErrorBuckets are the upper bounds, exclusive, of the estimate
+// error distribution
+var estimateErrorBuckets = []struct {
+	label string
+	upper float64
+}{
+	{"<-50%", -0.5},
+	{"-50%..-20%", -0.2},
+	{"-20%..+20%", 0.2},
+	{"+20%..+100%", 1},
+	{">+100%", math.Inf(1)},
+}
+
+// ErrorBucket counts the tasks whose estimate error fell in a range
+type ErrorBucket struct {
+	Range string `json:"range"`
+	Count int64  `json:"count"`
+}
+
+// TaskTypeAccuracy describes how far the estimates of one task type were
+// from the actual durations. Errors are relative, (actual - estimate) /
+// estimate, so 0.5 is a task that took 50% longer than estimated.
+type TaskTypeAccuracy struct {
+	TaskType           string        `json:"task_type"`
+	Samples            int64         `json:"samples"`
+	Unestimated        int64         `json:"unestimated"` // Samples without an estimate, left out of the errors
+	MeanEstimatedHours float64       `json:"mean_estimated_hours"`
+	MeanActualHours    float64       `json:"mean_actual_hours"`
+	MeanError          float64       `json:"mean_error"` // Positive when tasks run over their estimate
+	MeanAbsoluteError  float64       `json:"mean_absolute_error"`
+	MedianError        float64       `json:"median_error"`
+	Distribution       []ErrorBucket `json:"distribution"`
+}
+
+// EstimationAccuracy reports a project's estimate errors over a time window
+type EstimationAccuracy struct {
+	ProjectID    string             `json:"project_id"`
+	Since        time.Time          `json:"since"`
+	Until        time.Time          `json:"until"`
+	TotalSamples int64              `json:"total_samples"`
+	TaskTypes    []TaskTypeAccuracy `json:"task_types"`
+}
+
+// GetEstimationAccuracy compares the estimates of a project's tasks recorded
+// in [since, until) with their actual durations, per task type
+func (s *ProjectService) GetEstimationAccuracy(ctx context.Context, projectID string, since, until time.Time) (*EstimationAccuracy, error) {
+	var samples []models.TaskDurationSample
+	if err := s.db.WithContext(ctx).
+		Where("project_id = ? AND created_at >= ? AND created_at < ?", projectID, since, until).
+		Find(&samples).Error; err != nil {
+		return nil, fmt.Errorf("failed to list task durations: %w", err)
+	}
+
+	report := &EstimationAccuracy{
+		ProjectID:    projectID,
+		Since:        since,
+		Until:        until,
+		TotalSamples: int64(len(samples)),
+		TaskTypes:    []TaskTypeAccuracy{},
+	}
+
+	byType := make(map[string][]models.TaskDurationSample)
+	for _, sample := range samples {
+		byType[sample.TaskType] = append(byType[sample.TaskType], sample)
+	}
+	for taskType, typeSamples := range byType {
+		report.TaskTypes = append(report.TaskTypes, taskTypeAccuracy(taskType, typeSamples))
+	}
+	sort.Slice(report.TaskTypes, func(i, j int) bool {
+		if report.TaskTypes[i].Samples != report.TaskTypes[j].Samples {
+			return report.TaskTypes[i].Samples > report.TaskTypes[j].Samples
+		}
+		return report.TaskTypes[i].TaskType < report.TaskTypes[j].TaskType
+	})
+
+	return report, nil
+}
+
+func taskTypeAccuracy(taskType string, samples []models.TaskDurationSample) TaskTypeAccuracy {
+	accuracy := TaskTypeAccuracy{
+		TaskType:     taskType,
+		Samples:      int64(len(samples)),
+		Distribution: make([]ErrorBucket, len(estimateErrorBuckets)),
+	}
+	for i, bucket := range estimateErrorBuckets {
+		accuracy.Distribution[i].Range = bucket.label
+	}
+
+	var errs []float64
+	var estimatedSum, actualSum float64
+	for _, sample := range samples {
+		actualSum += sample.ActualHours()
+		if sample.EstimatedHours <= 0 {
+			accuracy.Unestimated++
+			continue
+		}
+		estimatedSum += sample.EstimatedHours
+
+		relative := (sample.ActualHours() - sample.EstimatedHours) / sample.EstimatedHours
+		errs = append(errs, relative)
+		accuracy.MeanError += relative
+		accuracy.MeanAbsoluteError += math.Abs(relative)
+		for i, bucket := range estimateErrorBuckets {
+			if relative < bucket.upper {
+				accuracy.Distribution[i].Count++
+				break
+			}
+		}
+	}
+
+	accuracy.MeanActualHours = actualSum / float64(len(samples))
+	if len(errs) == 0 {
+		return accuracy
+	}
+	accuracy.MeanEstimatedHours = estimatedSum / float64(len(errs))
+	accuracy.MeanError /= float64(len(errs))
+	accuracy.MeanAbsoluteError /= float64(len(errs))
+	sort.Float64s(errs)
+	accuracy.MedianError = percentile(errs, 0.50)
+	return accuracy
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+func setupEstimatesTestDB(t *testing.T) *gorm.DB {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.TaskDurationSample{}, &models.TaskDurationStat{}))
+	return db
+}
+
+func TestEstimateRefiner_RecordDurationsIsIdempotent(t *testing.T) {
+	db := setupEstimatesTestDB(t)
+	refiner := NewEstimateRefiner(db, &config.EstimationConfig{MinSamples: 4, Window: 200}, zap.NewNop())
+
+	durations := []TaskDuration{
+		{TaskID: "task-1", TaskType: "backend", Complexity: "medium", EstimatedHours: 2, Duration: time.Hour},
+		{TaskID: "task-2", TaskType: "backend", Complexity: "medium", EstimatedHours: 2, Duration: 2 * time.Hour},
+		{TaskID: "task-3", TaskType: "backend", Complexity: "medium", EstimatedHours: 2, Duration: 3 * time.Hour},
+		{TaskID: "task-4", TaskType: "backend", Complexity: "medium", EstimatedHours: 2, Duration: 4 * time.Hour},
+	}
+	// A retried activity records the same workflow's tasks again
+	for i := 0; i < 2; i++ {
+		require.NoError(t, refiner.RecordDurations(context.Background(), "workflow-1", "project-1", durations))
+	}
+
+	var samples int64
+	require.NoError(t, db.Model(&models.TaskDurationSample{}).Count(&samples).Error)
+	assert.Equal(t, int64(4), samples)
+
+	var stat models.TaskDurationStat
+	require.NoError(t, db.First(&stat, "task_type = ? AND complexity = ?", "backend", "medium").Error)
+	assert.Equal(t, int64(4), stat.SampleCount)
+	assert.Equal(t, (150 * time.Minute).Milliseconds(), stat.Mean)
+	assert.Equal(t, (2 * time.Hour).Milliseconds(), stat.P50)
+	assert.Equal(t, (4 * time.Hour).Milliseconds(), stat.P95)
+}
+
+func TestEstimateRefiner_Refine(t *testing.T) {
+	db := setupEstimatesTestDB(t)
+	require.NoError(t, db.Create(&[]models.TaskDurationStat{
+		{TaskType: "backend", Complexity: "medium", SampleCount: 10, P50: (2 * time.Hour).Milliseconds(), P95: (4 * time.Hour).Milliseconds()},
+		{TaskType: "frontend", Complexity: "low", SampleCount: 9, P50: (5 * time.Hour).Milliseconds(), P95: (9 * time.Hour).Milliseconds()},
+	}).Error)
+	refiner := NewEstimateRefiner(db, &config.EstimationConfig{MinSamples: 10, Window: 200}, zap.NewNop())
+	ctx := context.Background()
+
+	// Enough samples: history and heuristic weigh 10/20 each
+	estimate := refiner.Refine(ctx, "backend", "medium", 1)
+	assert.Equal(t, EstimateSourceBlended, estimate.Source)
+	assert.InDelta(t, 1.5, estimate.Hours, 0.0001)
+	assert.InDelta(t, 2.5, estimate.TimeoutHours, 0.0001)
+	assert.Equal(t, int64(10), estimate.Samples)
+
+	// Without a static estimate the history is used as is
+	estimate = refiner.Refine(ctx, "backend", "medium", 0)
+	assert.InDelta(t, 2, estimate.Hours, 0.0001)
+	assert.InDelta(t, 4, estimate.TimeoutHours, 0.0001)
+
+	// Too few samples, or none at all, keep the heuristic
+	assert.Equal(t, HeuristicEstimate(1), refiner.Refine(ctx, "frontend", "low", 1))
+	assert.Equal(t, HeuristicEstimate(3), refiner.Refine(ctx, "database", "high", 3))
+
+	// So does a database without stats
+	empty := NewEstimateRefiner(setupWorkflowTestDB(t), &config.EstimationConfig{MinSamples: 10, Window: 200}, zap.NewNop())
+	assert.Equal(t, HeuristicEstimate(3), empty.Refine(ctx, "backend", "medium", 3))
+}
+
+func TestProjectService_GetEstimationAccuracy(t *testing.T) {
+	db := setupEstimatesTestDB(t)
+
+	now := time.Now()
+	var seq int
+	sample := func(projectID, taskType string, estimatedHours float64, actual time.Duration, createdAt time.Time) {
+		seq++
+		require.NoError(t, db.Create(&models.TaskDurationSample{
+			WorkflowID:     "workflow-1",
+			TaskID:         fmt.Sprintf("task-%d", seq),
+			ProjectID:      projectID,
+			TaskType:       taskType,
+			Complexity:     "medium",
+			EstimatedHours: estimatedHours,
+			Duration:       actual.Milliseconds(),
+			CreatedAt:      createdAt,
+		}).Error)
+	}
+	sample("project-1", "backend", 2, time.Hour, now.Add(-time.Hour))       // -50%
+	sample("project-1", "backend", 2, 2*time.Hour, now.Add(-time.Hour))     // 0%
+	sample("project-1", "backend", 2, 5*time.Hour, now.Add(-time.Hour))     // +150%
+	sample("project-1", "backend", 0, time.Hour, now.Add(-time.Hour))       // No estimate
+	sample("project-1", "frontend", 1, 66*time.Minute, now.Add(-time.Hour)) // +10%
+	// Outside the window or the project
+	sample("project-1", "backend", 1, 10*time.Hour, now.Add(-60*24*time.Hour))
+	sample("project-2", "backend", 1, 10*time.Hour, now.Add(-time.Hour))
+
+	service := NewProjectService(db, zap.NewNop())
+	report, err := service.GetEstimationAccuracy(context.Background(), "project-1", now.Add(-30*24*time.Hour), now)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(5), report.TotalSamples)
+	require.Len(t, report.TaskTypes, 2)
+
+	backend := report.TaskTypes[0]
+	assert.Equal(t, "backend", backend.TaskType)
+	assert.Equal(t, int64(4), backend.Samples)
+	assert.Equal(t, int64(1), backend.Unestimated)
+	assert.InDelta(t, 2, backend.MeanEstimatedHours, 0.0001)
+	assert.InDelta(t, 2.25, backend.MeanActualHours, 0.0001)
+	assert.InDelta(t, 1.0/3, backend.MeanError, 0.0001)
+	assert.InDelta(t, 2.0/3, backend.MeanAbsoluteError, 0.0001)
+	assert.InDelta(t, 0, backend.MedianError, 0.0001)
+	assert.Equal(t, []ErrorBucket{
+		{Range: "<-50%", Count: 0},
+		{Range: "-50%..-20%", Count: 1},
+		{Range: "-20%..+20%", Count: 1},
+		{Range: "+20%..+100%", Count: 0},
+		{Range: ">+100%", Count: 1},
+	}, backend.Distribution)
+
+	frontend := report.TaskTypes[1]
+	assert.Equal(t, "frontend", frontend.TaskType)
+	assert.InDelta(t, 0.1, frontend.MeanError, 0.0001)
+	assert.Equal(t, int64(1), frontend.Distribution[2].Count)
+
+	// No history reports no task types rather than dividing by zero
+	empty, err := service.GetEstimationAccuracy(context.Background(), "project-3", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	assert.Zero(t, empty.TotalSamples)
+	assert.Empty(t, empty.TaskTypes)
+}
@@ -0,0 +1,416 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/events"
+)
+
+// TaskLogMessageType is the type of the messages agents stream task logs in
+const TaskLogMessageType = "task_log"
+
+// taskLogConsumerName is the name the log relay's writer is registered under
+// as an event consumer
+const taskLogConsumerName = "task_log_relay"
+
+// Reasons a log line is dropped, recorded as the task_log_lines_dropped_total
+// reason label
+const (
+	logDropBufferFull  = "buffer_full"
+	logDropWriteFailed = "write_failed"
+)
+
+var taskLogLinesDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_task_log_lines_dropped_total",
+	Help: "Task log lines from agents that did not reach the live log stream, by reason",
+}, []string{"reason"})
+
+// TaskLogLine is one line of an execution's output, the payload of a
+// task_log message
+type TaskLogLine struct {
+	ID          string    `json:"id,omitempty"` // Position in the log stream, set when read
+	ExecutionID string    `json:"execution_id"`
+	Level       string    `json:"level"`
+	Message     string    `json:"message"`
+	Timestamp   time.Time `json:"timestamp"`
+	Dropped     int64     `json:"dropped,omitempty"` // Set on markers for lines that were dropped
+}
+
+// LogStream keeps the recent log lines of running executions
+type LogStream interface {
+	// Append adds a line to the end of an execution's stream
+	Append(ctx context.Context, line TaskLogLine) error
+	// Read returns the lines after the position after, or from the start
+	// when it is empty, waiting up to block for new lines
+	Read(ctx context.Context, executionID, after string, block time.Duration) ([]TaskLogLine, error)
+}
+
+// taskLogStreamTTL is how long an execution's stream is kept after its last line
+const taskLogStreamTTL = 24 * time.Hour
+
+// taskLogReadCount bounds the lines returned by one stream read
+const taskLogReadCount = 500
+
+// redisLogStream keeps log lines in a Redis stream per execution
+type redisLogStream struct {
+	client *redis.Client
+	maxLen int64
+}
+
+// NewRedisLogStream keeps the last maxLen lines of each execution in Redis
+func NewRedisLogStream(client *redis.Client, maxLen int64) LogStream {
+	return &redisLogStream{client: client, maxLen: maxLen}
+}
+
+func taskLogStreamKey(executionID string) string {
+	return fmt.Sprintf("task_logs:%s", executionID)
+}
+
+func (s *redisLogStream) Append(ctx context.Context, line TaskLogLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log line: %w", err)
+	}
+	key := taskLogStreamKey(line.ExecutionID)
+	pipe := s.client.TxPipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"line": data},
+	})
+	pipe.Expire(ctx, key, taskLogStreamTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisLogStream) Read(ctx context.Context, executionID, after string, block time.Duration) ([]TaskLogLine, error) {
+	if after == "" {
+		after = "0"
+	}
+	streams, err := s.client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{taskLogStreamKey(executionID), after},
+		Count:   taskLogReadCount,
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []TaskLogLine
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			data, _ := message.Values["line"].(string)
+			var line TaskLogLine
+			if err := json.Unmarshal([]byte(data), &line); err != nil {
+				return nil, fmt.Errorf("failed to decode log line %s: %w", message.ID, err)
+			}
+			line.ID = message.ID
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// memoryLogStream keeps log lines in memory
+type memoryLogStream struct {
+	mu      sync.Mutex
+	maxLen  int
+	seq     int64
+	lines   map[string][]TaskLogLine
+	updated chan struct{} // Closed and replaced when a line is appended
+}
+
+// NewMemoryLogStream keeps the last maxLen lines of each execution in memory.
+// It only serves the followers of one orchestrator, e.g. in tests and local
+// runs.
+func NewMemoryLogStream(maxLen int64) LogStream {
+	return &memoryLogStream{
+		maxLen:  int(maxLen),
+		lines:   make(map[string][]TaskLogLine),
+		updated: make(chan struct{}),
+	}
+}
+
+func (m *memoryLogStream) Append(ctx context.Context, line TaskLogLine) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.seq++
+	line.ID = strconv.FormatInt(m.seq, 10)
+	lines := append(m.lines[line.ExecutionID], line)
+	if m.maxLen > 0 && len(lines) > m.maxLen {
+		lines = lines[len(lines)-m.maxLen:]
+	}
+	m.lines[line.ExecutionID] = lines
+
+	close(m.updated)
+	m.updated = make(chan struct{})
+	return nil
+}
+
+func (m *memoryLogStream) Read(ctx context.Context, executionID, after string, block time.Duration) ([]TaskLogLine, error) {
+	var afterSeq int64
+	if after != "" {
+		seq, err := strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log stream position %q: %w", after, err)
+		}
+		afterSeq = seq
+	}
+
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	for {
+		m.mu.Lock()
+		var lines []TaskLogLine
+		for _, line := range m.lines[executionID] {
+			if seq, _ := strconv.ParseInt(line.ID, 10, 64); seq > afterSeq {
+				lines = append(lines, line)
+			}
+			if len(lines) == taskLogReadCount {
+				break
+			}
+		}
+		updated := m.updated
+		m.mu.Unlock()
+
+		if len(lines) > 0 || block <= 0 {
+			return lines, nil
+		}
+		select {
+		case <-updated:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// LogRelayConfig configures a LogRelay
+type LogRelayConfig struct {
+	// BufferSize bounds the lines waiting to be written to the stream; lines
+	// arriving while it is full are dropped
+	BufferSize int
+	// FollowInterval is how long a follow read waits for new lines before
+	// the follower checks whether the execution has finished
+	FollowInterval time.Duration
+}
+
+// queuedLogLine is a line waiting to be written, with the number of lines of
+// its execution dropped just before it
+type queuedLogLine struct {
+	line          TaskLogLine
+	droppedBefore int64
+}
+
+// LogRelay writes the task logs agents push over their WebSocket connections
+// to a LogStream, where followers of running executions read them. Lines are
+// written in arrival order by one background writer. Lines that cannot be
+// buffered or written are counted and replaced by a marker line, so a
+// follower can tell the log has a gap.
+type LogRelay struct {
+	stream LogStream
+	logger *zap.Logger
+	config LogRelayConfig
+
+	lines chan queuedLogLine
+
+	mu      sync.Mutex
+	dropped map[string]int64 // Lines dropped per execution while the buffer was full, not yet queued
+
+	failed map[string]int64 // Lines per execution that could not be written, not yet marked; writer only
+
+	consumer *events.Consumer
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewLogRelay creates a log relay writing to stream
+func NewLogRelay(stream LogStream, cfg LogRelayConfig, logger *zap.Logger) *LogRelay {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	if cfg.FollowInterval <= 0 {
+		cfg.FollowInterval = 2 * time.Second
+	}
+	return &LogRelay{
+		stream:   stream,
+		logger:   logger,
+		config:   cfg,
+		lines:    make(chan queuedLogLine, cfg.BufferSize),
+		dropped:  make(map[string]int64),
+		failed:   make(map[string]int64),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start starts the background writer
+func (r *LogRelay) Start() {
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop stops the background writer after it has written the buffered lines
+func (r *LogRelay) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+		r.wg.Wait()
+	})
+}
+
+// SetEventRegistry registers the relay's writer as an event consumer, its
+// lag being the lines waiting in the buffer. It must be called before Start.
+func (r *LogRelay) SetEventRegistry(registry *events.Registry) {
+	r.consumer = registry.Register(taskLogConsumerName, events.ConsumerOptions{
+		Lag: func(ctx context.Context) (int64, error) { return int64(len(r.lines)), nil },
+	})
+}
+
+// HandleMessage relays a task_log message. It never blocks: lines arriving
+// while the buffer is full are dropped.
+func (r *LogRelay) HandleMessage(agentID string, msg AgentMessage) {
+	var line TaskLogLine
+	if err := json.Unmarshal(msg.Payload, &line); err != nil || line.ExecutionID == "" {
+		r.logger.Warn("Ignoring malformed task log message",
+			zap.String("agentID", agentID),
+			zap.String("messageID", msg.ID),
+			zap.Error(err))
+		return
+	}
+	line.ID = ""
+	line.Dropped = 0
+	if line.Timestamp.IsZero() {
+		line.Timestamp = msg.Timestamp
+	}
+	if line.Timestamp.IsZero() {
+		line.Timestamp = time.Now()
+	}
+	line.Timestamp = line.Timestamp.UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case r.lines <- queuedLogLine{line: line, droppedBefore: r.dropped[line.ExecutionID]}:
+		delete(r.dropped, line.ExecutionID)
+	default:
+		r.dropped[line.ExecutionID]++
+		taskLogLinesDroppedTotal.WithLabelValues(logDropBufferFull).Inc()
+	}
+}
+
+// Read returns the lines of an execution after the position after, waiting
+// up to the follow interval for new ones
+func (r *LogRelay) Read(ctx context.Context, executionID, after string) ([]TaskLogLine, error) {
+	lines, err := r.stream.Read(ctx, executionID, after, r.config.FollowInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log stream: %w", err)
+	}
+	return lines, nil
+}
+
+func (r *LogRelay) run() {
+	defer r.wg.Done()
+
+	ctx := context.Background()
+	write := events.Wrap(r.consumer, r.write)
+	for {
+		select {
+		case queued := <-r.lines:
+			write(ctx, queued)
+		case <-r.stopChan:
+			for {
+				select {
+				case queued := <-r.lines:
+					write(ctx, queued)
+				default:
+					r.markDropped()
+					return
+				}
+			}
+		}
+	}
+}
+
+// write appends a queued line, preceded by a marker for the lines dropped
+// before it. Once the buffer is drained, drops that no later line has
+// marked yet are marked too. It returns why the line could not be written.
+func (r *LogRelay) write(ctx context.Context, queued queuedLogLine) error {
+	line := queued.line
+	if dropped := queued.droppedBefore + r.failed[line.ExecutionID]; dropped > 0 {
+		delete(r.failed, line.ExecutionID)
+		r.writeMarker(line.ExecutionID, dropped, line.Timestamp)
+	}
+
+	err := r.stream.Append(ctx, line)
+	if err != nil {
+		// Redis outages are logged once per interval by its guard
+		if !IsRedisOutage(err) {
+			r.logger.Warn("Failed to write task log line",
+				zap.String("executionID", line.ExecutionID),
+				zap.Error(err))
+		}
+		taskLogLinesDroppedTotal.WithLabelValues(logDropWriteFailed).Inc()
+		r.failed[line.ExecutionID]++
+	}
+
+	if len(r.lines) == 0 {
+		r.markDropped()
+	}
+	return err
+}
+
+// markDropped writes a marker for each execution with unmarked drops
+func (r *LogRelay) markDropped() {
+	r.mu.Lock()
+	dropped := r.dropped
+	r.dropped = make(map[string]int64)
+	r.mu.Unlock()
+
+	for executionID, count := range r.failed {
+		dropped[executionID] += count
+	}
+	r.failed = make(map[string]int64)
+
+	for executionID, count := range dropped {
+		r.writeMarker(executionID, count, time.Now().UTC())
+	}
+}
+
+// writeMarker appends a marker for count dropped lines. A marker that cannot
+// be written is retried before the execution's next line.
+func (r *LogRelay) writeMarker(executionID string, count int64, timestamp time.Time) {
+	marker := TaskLogLine{
+		ExecutionID: executionID,
+		Level:       "warn",
+		Message:     fmt.Sprintf("[%d log lines dropped]", count),
+		Timestamp:   timestamp,
+		Dropped:     count,
+	}
+	if err := r.stream.Append(context.Background(), marker); err != nil {
+		if !IsRedisOutage(err) {
+			r.logger.Warn("Failed to write dropped log lines marker",
+				zap.String("executionID", executionID),
+				zap.Int64("dropped", count),
+				zap.Error(err))
+		}
+		r.failed[executionID] += count
+	}
+}
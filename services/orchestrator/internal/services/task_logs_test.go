@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/events"
+)
+
+// faultyLogStream holds appends while release is open and fails the lines
+// fail reports
+type faultyLogStream struct {
+	LogStream
+	appending chan struct{} // Signalled when an append starts
+	release   chan struct{}
+	fail      func(line TaskLogLine) bool
+}
+
+func (s *faultyLogStream) Append(ctx context.Context, line TaskLogLine) error {
+	select {
+	case s.appending <- struct{}{}:
+	default:
+	}
+	if s.release != nil {
+		<-s.release
+	}
+	if s.fail != nil && s.fail(line) {
+		return errors.New("stream unavailable")
+	}
+	return s.LogStream.Append(ctx, line)
+}
+
+func taskLogMessage(t *testing.T, executionID, message string) AgentMessage {
+	payload, err := json.Marshal(TaskLogLine{ExecutionID: executionID, Level: "info", Message: message})
+	require.NoError(t, err)
+	return AgentMessage{Type: TaskLogMessageType, Payload: payload, Timestamp: time.Now()}
+}
+
+// readLogMessages reads an execution's stream until it holds count lines
+func readLogMessages(t *testing.T, stream LogStream, executionID string, count int) []string {
+	var messages []string
+	after := ""
+	deadline := time.Now().Add(5 * time.Second)
+	for len(messages) < count && time.Now().Before(deadline) {
+		lines, err := stream.Read(context.Background(), executionID, after, 100*time.Millisecond)
+		require.NoError(t, err)
+		for _, line := range lines {
+			messages = append(messages, line.Message)
+			after = line.ID
+		}
+	}
+	return messages
+}
+
+func TestLogRelay_MarksLinesDroppedWhileBufferIsFull(t *testing.T) {
+	stream := &faultyLogStream{
+		LogStream: NewMemoryLogStream(100),
+		appending: make(chan struct{}, 1),
+		release:   make(chan struct{}),
+	}
+	relay := NewLogRelay(stream, LogRelayConfig{BufferSize: 2}, zap.NewNop())
+	relay.Start()
+	defer relay.Stop()
+	dropped := testutil.ToFloat64(taskLogLinesDroppedTotal.WithLabelValues(logDropBufferFull))
+
+	// The writer holds line 1, lines 2 and 3 fill the buffer and the rest
+	// are dropped
+	relay.HandleMessage("agent-1", taskLogMessage(t, "exec-1", "line 1"))
+	<-stream.appending
+	for _, message := range []string{"line 2", "line 3", "line 4", "line 5", "line 6"} {
+		relay.HandleMessage("agent-1", taskLogMessage(t, "exec-1", message))
+	}
+	close(stream.release)
+
+	assert.Equal(t, []string{"line 1", "line 2", "line 3", "[3 log lines dropped]"},
+		readLogMessages(t, stream, "exec-1", 4))
+	assert.Equal(t, float64(3), testutil.ToFloat64(taskLogLinesDroppedTotal.WithLabelValues(logDropBufferFull))-dropped)
+
+	// Once there is room again lines flow normally
+	relay.HandleMessage("agent-1", taskLogMessage(t, "exec-1", "line 7"))
+	messages := readLogMessages(t, stream, "exec-1", 5)
+	require.Len(t, messages, 5)
+	assert.Equal(t, "line 7", messages[4])
+}
+
+func TestLogRelay_MarksLinesThatFailToWrite(t *testing.T) {
+	stream := &faultyLogStream{
+		LogStream: NewMemoryLogStream(100),
+		appending: make(chan struct{}, 1),
+		fail:      func(line TaskLogLine) bool { return line.Message == "line 2" },
+	}
+	relay := NewLogRelay(stream, LogRelayConfig{BufferSize: 10}, zap.NewNop())
+	failed := testutil.ToFloat64(taskLogLinesDroppedTotal.WithLabelValues(logDropWriteFailed))
+
+	for _, message := range []string{"line 1", "line 2", "line 3"} {
+		relay.HandleMessage("agent-1", taskLogMessage(t, "exec-1", message))
+	}
+	// Malformed messages are ignored
+	relay.HandleMessage("agent-1", AgentMessage{Type: TaskLogMessageType, Payload: json.RawMessage(`{"message":"no execution"}`)})
+	relay.Start()
+	relay.Stop()
+
+	// The marker takes the place of the lost line
+	assert.Equal(t, []string{"line 1", "[1 log lines dropped]", "line 3"},
+		readLogMessages(t, stream, "exec-1", 3))
+	assert.Equal(t, float64(1), testutil.ToFloat64(taskLogLinesDroppedTotal.WithLabelValues(logDropWriteFailed))-failed)
+}
+
+func TestLogRelay_ReportsToEventRegistry(t *testing.T) {
+	stream := &faultyLogStream{
+		LogStream: NewMemoryLogStream(100),
+		fail:      func(line TaskLogLine) bool { return line.Message == "line 2" },
+	}
+	relay := NewLogRelay(stream, LogRelayConfig{BufferSize: 10}, zap.NewNop())
+	registry := events.NewRegistry(&config.EventsConfig{}, zap.NewNop())
+	relay.SetEventRegistry(registry)
+
+	// Lines waiting in the buffer are the relay's lag
+	for _, message := range []string{"line 1", "line 2", "line 3"} {
+		relay.HandleMessage("agent-1", taskLogMessage(t, "exec-1", message))
+	}
+	registry.Check(context.Background())
+	consumers := registry.Consumers()
+	require.Len(t, consumers, 1)
+	assert.Equal(t, taskLogConsumerName, consumers[0].Name)
+	assert.Equal(t, int64(3), consumers[0].Lag)
+
+	relay.Start()
+	relay.Stop()
+	registry.Check(context.Background())
+	consumers = registry.Consumers()
+	assert.Equal(t, int64(2), consumers[0].Processed)
+	assert.Equal(t, int64(1), consumers[0].Errors)
+	assert.Equal(t, "stream unavailable", consumers[0].LastError)
+	assert.Zero(t, consumers[0].Lag)
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/models"
+)
+
+// TaskQualityTracker keeps the quality scores of the tasks agents run and
+// averages them per agent
+type TaskQualityTracker struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+// NewTaskQualityTracker creates a task quality tracker
+func NewTaskQualityTracker(db *gorm.DB) *TaskQualityTracker {
+	return &TaskQualityTracker{db: db, now: time.Now}
+}
+
+// RecordScores stores the quality scores of a workflow's tasks. Tasks
+// already scored for the workflow are skipped, so a retried activity counts
+// each task once.
+func (t *TaskQualityTracker) RecordScores(ctx context.Context, scores []models.TaskQualityScore) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	for i := range scores {
+		if scores[i].CreatedAt.IsZero() {
+			scores[i].CreatedAt = t.now()
+		}
+	}
+	if err := t.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(scores, 100).Error; err != nil {
+		return fmt.Errorf("failed to record task quality scores: %w", err)
+	}
+	return nil
+}
+
+// AgentQualityStats summarizes the quality scores of the tasks an agent ran
+type AgentQualityStats struct {
+	AgentID        string    `json:"agent_id"`
+	ScoredTasks    int64     `json:"scored_tasks"`
+	AverageQuality float64   `json:"average_quality"`
+	MinQuality     float64   `json:"min_quality"`
+	LastScoredAt   time.Time `json:"last_scored_at"`
+}
+
+// AgentStats averages the quality scores of the tasks each agent ran in
+// [since, until), for one agent when agentID is set, best agents first
+func (t *TaskQualityTracker) AgentStats(ctx context.Context, agentID string, since, until time.Time) ([]AgentQualityStats, error) {
+	query := t.db.WithContext(ctx).Model(&models.TaskQualityScore{}).
+		Select("agent_id", "score", "created_at").
+		Where("created_at >= ? AND created_at < ?", since, until)
+	if agentID != "" {
+		query = query.Where("agent_id = ?", agentID)
+	}
+	var scores []models.TaskQualityScore
+	if err := query.Find(&scores).Error; err != nil {
+		return nil, fmt.Errorf("failed to list task quality scores: %w", err)
+	}
+
+	byAgent := make(map[string]*AgentQualityStats)
+	var stats []*AgentQualityStats
+	for _, score := range scores {
+		stat, ok := byAgent[score.AgentID]
+		if !ok {
+			stat = &AgentQualityStats{AgentID: score.AgentID, MinQuality: score.Score}
+			byAgent[score.AgentID] = stat
+			stats = append(stats, stat)
+		}
+		stat.ScoredTasks++
+		// Running mean, so AverageQuality is complete after the loop
+		stat.AverageQuality += (score.Score - stat.AverageQuality) / float64(stat.ScoredTasks)
+		stat.MinQuality = min(stat.MinQuality, score.Score)
+		if score.CreatedAt.After(stat.LastScoredAt) {
+			stat.LastScoredAt = score.CreatedAt
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].AverageQuality != stats[j].AverageQuality {
+			return stats[i].AverageQuality > stats[j].AverageQuality
+		}
+		return stats[i].AgentID < stats[j].AgentID
+	})
+
+	result := make([]AgentQualityStats, len(stats))
+	for i, stat := range stats {
+		result[i] = *stat
+	}
+	return result, nil
+}
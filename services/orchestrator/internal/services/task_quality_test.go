@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+)
+
+func TestTaskQualityTracker_AgentStats(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Migrator().CreateTable(&models.TaskQualityScore{}))
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tracker := NewTaskQualityTracker(db)
+	tracker.now = func() time.Time { return now }
+
+	scores := func() []models.TaskQualityScore {
+		return []models.TaskQualityScore{
+			{WorkflowID: "wf-1", TaskID: "task-1", AgentID: "agent-1", Score: 0.4, Scorer: "heuristic"},
+			{WorkflowID: "wf-1", TaskID: "task-2", AgentID: "agent-1", Score: 0.8, Scorer: "heuristic"},
+			{WorkflowID: "wf-1", TaskID: "task-3", AgentID: "agent-2", Score: 0.6, Scorer: "reviewer"},
+		}
+	}
+	// Recording a workflow's scores again adds nothing
+	require.NoError(t, tracker.RecordScores(ctx, scores()))
+	require.NoError(t, tracker.RecordScores(ctx, scores()))
+
+	stats, err := tracker.AgentStats(ctx, "", now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+	assert.Equal(t, "agent-1", stats[0].AgentID)
+	assert.Equal(t, int64(2), stats[0].ScoredTasks)
+	assert.InDelta(t, 0.6, stats[0].AverageQuality, 1e-9)
+	assert.InDelta(t, 0.4, stats[0].MinQuality, 1e-9)
+	assert.True(t, stats[0].LastScoredAt.Equal(now))
+	// Ties go by agent
+	assert.Equal(t, "agent-2", stats[1].AgentID)
+
+	stats, err = tracker.AgentStats(ctx, "agent-2", now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, "agent-2", stats[0].AgentID)
+
+	// The window ends before until
+	stats, err = tracker.AgentStats(ctx, "", now.Add(-time.Hour), now)
+	require.NoError(t, err)
+	assert.Empty(t, stats)
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	taskqueuepb "go.temporal.io/api/taskqueue/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// Task queue types, used as metric labels
+const (
+	TaskQueueTypeWorkflow = "workflow"
+	TaskQueueTypeActivity = "activity"
+)
+
+// Limitations of task queue stats, reported when the Temporal server cannot
+// tell the backlog
+const (
+	// taskQueueLimitationNoStatus is reported when the server does not
+	// report the task queue status, so the backlog is unknown
+	taskQueueLimitationNoStatus = "the Temporal server does not report task queue status: backlog is unknown, only pollers are reported"
+	// taskQueueLimitationNoBacklogAge is always reported: the Temporal API
+	// in use does not tell the age of the oldest backlog task
+	taskQueueLimitationNoBacklogAge = "the Temporal API does not report the age of the oldest backlog task: see lag_seconds for the oldest workflow not started"
+)
+
+var (
+	taskQueuePollers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_task_queue_pollers",
+		Help: "Workers polling a task queue, by task queue and type",
+	}, []string{"task_queue", "type"})
+	taskQueueBacklog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_task_queue_backlog",
+		Help: "Approximate tasks waiting in a task queue, by task queue and type; unset when the Temporal server does not report it",
+	}, []string{"task_queue", "type"})
+	taskQueuePendingWorkflows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_task_queue_pending_workflows",
+		Help: "Pending workflows of a task queue not started yet",
+	}, []string{"task_queue"})
+	taskQueueLagSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_task_queue_lag_seconds",
+		Help: "Time the oldest pending workflow of a task queue has been waiting to start",
+	}, []string{"task_queue"})
+)
+
+// TaskQueueTypeStats are the pollers and backlog of the workflow or activity
+// tasks of a task queue
+type TaskQueueTypeStats struct {
+	Pollers int `json:"pollers"`
+	// LastPollAt is the last time one of the pollers polled
+	LastPollAt *time.Time `json:"last_poll_at,omitempty"`
+	// Backlog is the approximate number of tasks waiting, nil when the
+	// server does not report it
+	Backlog *int64 `json:"backlog,omitempty"`
+	// TasksPerSecond is the rate tasks are dispatched at, when reported
+	TasksPerSecond *float64 `json:"tasks_per_second,omitempty"`
+}
+
+// TaskQueueStats are the Temporal view of a task queue, and the workflows
+// waiting to start on it
+type TaskQueueStats struct {
+	Name     string             `json:"name"`
+	Workflow TaskQueueTypeStats `json:"workflow"`
+	Activity TaskQueueTypeStats `json:"activity"`
+	// PendingWorkflows is the number of pending workflows of the queue's
+	// types not started yet, held by the scheduler or waiting to be
+	// submitted; LagSeconds is how long the oldest has been waiting
+	PendingWorkflows int64   `json:"pending_workflows"`
+	LagSeconds       float64 `json:"lag_seconds"`
+	// Limitations lists what the stats leave out on this Temporal server
+	Limitations []string `json:"limitations,omitempty"`
+	// Error is why the queue could not be described; the database-derived
+	// fields are still set
+	Error string `json:"error,omitempty"`
+}
+
+// TaskQueueReport are the stats of the task queues the orchestrator starts
+// workflows on
+type TaskQueueReport struct {
+	Namespace   string           `json:"namespace"`
+	CollectedAt time.Time        `json:"collected_at"`
+	Queues      []TaskQueueStats `json:"queues"`
+}
+
+// TaskQueueMonitor reports the pollers and backlog of the task queues the
+// orchestrator starts workflows on, from Temporal, with the lag of the
+// workflows waiting to start, from the database. Stats are collected on
+// request and periodically, for the Prometheus gauges.
+type TaskQueueMonitor struct {
+	db             *gorm.DB
+	temporalClient client.Client
+	workflowTypes  *WorkflowTypeRegistry
+	logger         *zap.Logger
+	namespace      string
+	defaultQueue   string
+	interval       time.Duration
+	now            func() time.Time
+
+	// statusUnsupported is set once the server rejects status requests,
+	// so they are no longer sent
+	statusUnsupported atomic.Bool
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewTaskQueueMonitor creates a task queue monitor for the default task
+// queue and those of the registered workflow types
+func NewTaskQueueMonitor(db *gorm.DB, temporalClient client.Client, workflowTypes *WorkflowTypeRegistry, cfg *config.TemporalConfig, logger *zap.Logger) *TaskQueueMonitor {
+	return &TaskQueueMonitor{
+		db:             db,
+		temporalClient: temporalClient,
+		workflowTypes:  workflowTypes,
+		logger:         logger,
+		namespace:      cfg.Namespace,
+		defaultQueue:   cfg.TaskQueue,
+		interval:       time.Duration(cfg.TaskQueueStatsInterval) * time.Second,
+		now:            time.Now,
+		stopChan:       make(chan struct{}),
+	}
+}
+
+// Start starts collecting the stats periodically
+func (m *TaskQueueMonitor) Start() {
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("Task queue monitor started", zap.Duration("interval", m.interval))
+}
+
+// Stop stops the periodic collection
+func (m *TaskQueueMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+		m.wg.Wait()
+		m.logger.Info("Task queue monitor stopped")
+	})
+}
+
+func (m *TaskQueueMonitor) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+		report, err := m.Collect(ctx)
+		cancel()
+		if err != nil {
+			m.logger.Error("Failed to collect task queue stats", zap.Error(err))
+			continue
+		}
+		for _, queue := range report.Queues {
+			if queue.Error != "" {
+				m.logger.Warn("Failed to describe task queue",
+					zap.String("task_queue", queue.Name), zap.String("error", queue.Error))
+			}
+		}
+	}
+}
+
+// queues maps the task queues workflows are started on to their workflow
+// types
+func (m *TaskQueueMonitor) queues() map[string][]models.WorkflowType {
+	queues := map[string][]models.WorkflowType{m.defaultQueue: nil}
+	for _, workflowType := range m.workflowTypes.Types() {
+		spec, _ := m.workflowTypes.Lookup(workflowType)
+		queue := spec.TaskQueue
+		if queue == "" {
+			queue = m.defaultQueue
+		}
+		queues[queue] = append(queues[queue], workflowType)
+	}
+	return queues
+}
+
+// Collect describes the task queues and updates the gauges. A queue Temporal
+// fails to describe is reported with its error; only database errors fail
+// the collection.
+func (m *TaskQueueMonitor) Collect(ctx context.Context) (*TaskQueueReport, error) {
+	queues := m.queues()
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := &TaskQueueReport{Namespace: m.namespace, CollectedAt: m.now(), Queues: make([]TaskQueueStats, 0, len(names))}
+	for _, name := range names {
+		stats := TaskQueueStats{Name: name}
+		if err := m.pendingLag(ctx, queues[name], &stats, report.CollectedAt); err != nil {
+			return nil, err
+		}
+		m.describe(ctx, &stats)
+		stats.Limitations = append(stats.Limitations, taskQueueLimitationNoBacklogAge)
+		report.Queues = append(report.Queues, stats)
+		exportTaskQueueStats(&stats)
+	}
+	return report, nil
+}
+
+// pendingLag counts the pending workflows of the queue's types that have not
+// started, and how long the oldest has been waiting
+func (m *TaskQueueMonitor) pendingLag(ctx context.Context, types []models.WorkflowType, stats *TaskQueueStats, now time.Time) error {
+	if len(types) == 0 {
+		return nil
+	}
+	pending := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("type IN ? AND status = ? AND started_at IS NULL", types, models.WorkflowStatusPending)
+	if err := pending.Session(&gorm.Session{}).Count(&stats.PendingWorkflows).Error; err != nil {
+		return fmt.Errorf("failed to count pending workflows: %w", err)
+	}
+	if stats.PendingWorkflows == 0 {
+		return nil
+	}
+	var oldest models.Workflow
+	err := pending.Session(&gorm.Session{}).Select("id", "queued_at", "created_at").
+		Order("COALESCE(queued_at, created_at)").First(&oldest).Error
+	if err != nil {
+		return fmt.Errorf("failed to get the oldest pending workflow: %w", err)
+	}
+	since := oldest.CreatedAt
+	if oldest.QueuedAt != nil {
+		since = *oldest.QueuedAt
+	}
+	stats.LagSeconds = max(now.Sub(since).Seconds(), 0)
+	return nil
+}
+
+// describe fills in the pollers of the queue and, when the server reports
+// it, its backlog
+func (m *TaskQueueMonitor) describe(ctx context.Context, stats *TaskQueueStats) {
+	withStatus := !m.statusUnsupported.Load()
+	for _, queueType := range []struct {
+		kind  enumspb.TaskQueueType
+		stats *TaskQueueTypeStats
+	}{
+		{enumspb.TASK_QUEUE_TYPE_WORKFLOW, &stats.Workflow},
+		{enumspb.TASK_QUEUE_TYPE_ACTIVITY, &stats.Activity},
+	} {
+		resp, err := m.describeQueue(ctx, stats.Name, queueType.kind, withStatus)
+		if withStatus && isUnsupported(err) {
+			// Servers predating task queue status: pollers only from now on
+			m.statusUnsupported.Store(true)
+			withStatus = false
+			resp, err = m.describeQueue(ctx, stats.Name, queueType.kind, false)
+		}
+		if err != nil {
+			stats.Error = err.Error()
+			return
+		}
+		fillTaskQueueTypeStats(queueType.stats, resp)
+		if resp.GetTaskQueueStatus() == nil {
+			withStatus = false
+		}
+	}
+	if !withStatus {
+		stats.Limitations = append(stats.Limitations, taskQueueLimitationNoStatus)
+	}
+}
+
+func (m *TaskQueueMonitor) describeQueue(ctx context.Context, name string, kind enumspb.TaskQueueType, withStatus bool) (*workflowservice.DescribeTaskQueueResponse, error) {
+	return m.temporalClient.WorkflowService().DescribeTaskQueue(ctx, &workflowservice.DescribeTaskQueueRequest{
+		Namespace:              m.namespace,
+		TaskQueue:              &taskqueuepb.TaskQueue{Name: name, Kind: enumspb.TASK_QUEUE_KIND_NORMAL},
+		TaskQueueType:          kind,
+		IncludeTaskQueueStatus: withStatus,
+	})
+}
+
+// isUnsupported reports whether the server rejected a request it does not
+// support
+func isUnsupported(err error) bool {
+	var unimplemented *serviceerror.Unimplemented
+	var invalid *serviceerror.InvalidArgument
+	return errors.As(err, &unimplemented) || errors.As(err, &invalid)
+}
+
+func fillTaskQueueTypeStats(stats *TaskQueueTypeStats, resp *workflowservice.DescribeTaskQueueResponse) {
+	stats.Pollers = len(resp.GetPollers())
+	for _, poller := range resp.GetPollers() {
+		if at := poller.GetLastAccessTime(); at != nil && (stats.LastPollAt == nil || at.After(*stats.LastPollAt)) {
+			stats.LastPollAt = at
+		}
+	}
+	if status := resp.GetTaskQueueStatus(); status != nil {
+		backlog := status.GetBacklogCountHint()
+		rate := status.GetRatePerSecond()
+		stats.Backlog = &backlog
+		stats.TasksPerSecond = &rate
+	}
+}
+
+func exportTaskQueueStats(stats *TaskQueueStats) {
+	for queueType, typeStats := range map[string]TaskQueueTypeStats{
+		TaskQueueTypeWorkflow: stats.Workflow,
+		TaskQueueTypeActivity: stats.Activity,
+	} {
+		if stats.Error != "" {
+			// Stale values are dropped rather than exported as current
+			taskQueuePollers.DeleteLabelValues(stats.Name, queueType)
+			taskQueueBacklog.DeleteLabelValues(stats.Name, queueType)
+			continue
+		}
+		taskQueuePollers.WithLabelValues(stats.Name, queueType).Set(float64(typeStats.Pollers))
+		if typeStats.Backlog != nil {
+			taskQueueBacklog.WithLabelValues(stats.Name, queueType).Set(float64(*typeStats.Backlog))
+		} else {
+			taskQueueBacklog.DeleteLabelValues(stats.Name, queueType)
+		}
+	}
+	taskQueuePendingWorkflows.WithLabelValues(stats.Name).Set(float64(stats.PendingWorkflows))
+	taskQueueLagSeconds.WithLabelValues(stats.Name).Set(stats.LagSeconds)
+}
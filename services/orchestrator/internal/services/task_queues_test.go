@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	taskqueuepb "go.temporal.io/api/taskqueue/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// fakeTaskQueueService answers DescribeTaskQueue, by task queue and type,
+// like a Temporal server with or without task queue status support
+type fakeTaskQueueService struct {
+	workflowservice.WorkflowServiceClient
+	statusSupport bool // Report the status when asked
+	rejectStatus  bool // Reject requests asking for the status, as servers predating it do
+	failQueue     string
+	pollers       map[string]int
+	backlog       map[string]int64
+	requests      []*workflowservice.DescribeTaskQueueRequest
+}
+
+func (f *fakeTaskQueueService) DescribeTaskQueue(ctx context.Context, req *workflowservice.DescribeTaskQueueRequest, opts ...grpc.CallOption) (*workflowservice.DescribeTaskQueueResponse, error) {
+	f.requests = append(f.requests, req)
+	if req.TaskQueue.Name == f.failQueue {
+		return nil, serviceerror.NewUnavailable("frontend unavailable")
+	}
+	if req.IncludeTaskQueueStatus && f.rejectStatus {
+		return nil, serviceerror.NewInvalidArgument("unknown field include_task_queue_status")
+	}
+	key := req.TaskQueue.Name + "/" + req.TaskQueueType.String()
+	lastPoll := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	resp := &workflowservice.DescribeTaskQueueResponse{}
+	for i := 0; i < f.pollers[key]; i++ {
+		at := lastPoll.Add(time.Duration(i) * time.Second)
+		resp.Pollers = append(resp.Pollers, &taskqueuepb.PollerInfo{Identity: "worker", LastAccessTime: &at})
+	}
+	if req.IncludeTaskQueueStatus && f.statusSupport {
+		resp.TaskQueueStatus = &taskqueuepb.TaskQueueStatus{BacklogCountHint: f.backlog[key], RatePerSecond: 2.5}
+	}
+	return resp, nil
+}
+
+func newTestTaskQueueMonitor(t *testing.T, service *fakeTaskQueueService) *TaskQueueMonitor {
+	db := setupWorkflowTestDB(t)
+	registry := NewWorkflowTypeRegistry()
+	require.NoError(t, registry.Register(WorkflowTypeSpec{Type: models.WorkflowTypeExecution, Workflow: "CodeExecutionWorkflow"}))
+	require.NoError(t, registry.Register(WorkflowTypeSpec{Type: models.WorkflowTypeAnalysis, Workflow: "CodeAnalysisWorkflow", TaskQueue: "analysis-queue"}))
+
+	temporalClient := new(mocks.Client)
+	temporalClient.On("WorkflowService").Return(service)
+	monitor := NewTaskQueueMonitor(db, temporalClient, registry, &config.TemporalConfig{
+		Namespace: "default", TaskQueue: "orchestrator-queue", TaskQueueStatsInterval: 30,
+	}, zap.NewNop())
+	now := time.Date(2026, 1, 1, 12, 10, 0, 0, time.UTC)
+	monitor.now = func() time.Time { return now }
+
+	// Two executions waiting to start, one running, an analysis done
+	queued := now.Add(-5 * time.Minute)
+	started := now.Add(-time.Minute)
+	for _, workflow := range []*models.Workflow{
+		{Name: "held", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusPending, QueuedAt: &queued},
+		{Name: "new", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusPending, CreatedAt: now.Add(-time.Minute)},
+		{Name: "running", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusRunning, StartedAt: &started},
+		{Name: "done", Type: models.WorkflowTypeAnalysis, Status: models.WorkflowStatusCompleted},
+	} {
+		require.NoError(t, db.Create(workflow).Error)
+	}
+	return monitor
+}
+
+func TestTaskQueueMonitor_WithTaskQueueStatus(t *testing.T) {
+	service := &fakeTaskQueueService{
+		statusSupport: true,
+		pollers:       map[string]int{"orchestrator-queue/Workflow": 2, "orchestrator-queue/Activity": 3, "analysis-queue/Activity": 1},
+		backlog:       map[string]int64{"orchestrator-queue/Workflow": 7, "orchestrator-queue/Activity": 40},
+	}
+	monitor := newTestTaskQueueMonitor(t, service)
+
+	report, err := monitor.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "default", report.Namespace)
+	require.Len(t, report.Queues, 2)
+
+	analysis, orchestrator := report.Queues[0], report.Queues[1]
+	assert.Equal(t, "analysis-queue", analysis.Name)
+	assert.Equal(t, "orchestrator-queue", orchestrator.Name)
+
+	assert.Equal(t, 2, orchestrator.Workflow.Pollers)
+	assert.Equal(t, 3, orchestrator.Activity.Pollers)
+	require.NotNil(t, orchestrator.Workflow.LastPollAt)
+	assert.Equal(t, time.Date(2026, 1, 1, 12, 0, 1, 0, time.UTC), *orchestrator.Workflow.LastPollAt, "the latest poll")
+	require.NotNil(t, orchestrator.Activity.Backlog)
+	assert.Equal(t, int64(40), *orchestrator.Activity.Backlog)
+	assert.Equal(t, 2.5, *orchestrator.Activity.TasksPerSecond)
+	assert.Equal(t, int64(2), orchestrator.PendingWorkflows)
+	assert.Equal(t, 300.0, orchestrator.LagSeconds, "the oldest workflow was queued 5 minutes ago")
+	assert.Equal(t, []string{taskQueueLimitationNoBacklogAge}, orchestrator.Limitations)
+
+	assert.Equal(t, int64(0), analysis.PendingWorkflows)
+	assert.Zero(t, analysis.LagSeconds)
+	require.NotNil(t, analysis.Workflow.Backlog)
+	assert.Zero(t, *analysis.Workflow.Backlog)
+
+	for _, req := range service.requests {
+		assert.Equal(t, "default", req.Namespace)
+		assert.True(t, req.IncludeTaskQueueStatus)
+	}
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(taskQueuePollers.WithLabelValues("orchestrator-queue", TaskQueueTypeActivity)))
+	assert.Equal(t, 7.0, testutil.ToFloat64(taskQueueBacklog.WithLabelValues("orchestrator-queue", TaskQueueTypeWorkflow)))
+	assert.Equal(t, 40.0, testutil.ToFloat64(taskQueueBacklog.WithLabelValues("orchestrator-queue", TaskQueueTypeActivity)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(taskQueuePendingWorkflows.WithLabelValues("orchestrator-queue")))
+	assert.Equal(t, 300.0, testutil.ToFloat64(taskQueueLagSeconds.WithLabelValues("orchestrator-queue")))
+}
+
+func TestTaskQueueMonitor_PollersOnly(t *testing.T) {
+	t.Run("status rejected", func(t *testing.T) {
+		service := &fakeTaskQueueService{rejectStatus: true, pollers: map[string]int{"orchestrator-queue/Workflow": 1}}
+		monitor := newTestTaskQueueMonitor(t, service)
+
+		report, err := monitor.Collect(context.Background())
+		require.NoError(t, err)
+		orchestrator := report.Queues[1]
+		assert.Empty(t, orchestrator.Error)
+		assert.Equal(t, 1, orchestrator.Workflow.Pollers)
+		assert.Nil(t, orchestrator.Workflow.Backlog)
+		assert.Nil(t, orchestrator.Activity.Backlog)
+		assert.Contains(t, orchestrator.Limitations, taskQueueLimitationNoStatus)
+		assert.Equal(t, int64(2), orchestrator.PendingWorkflows, "the derived lag does not need Temporal")
+
+		// The status is no longer asked for once rejected
+		service.requests = nil
+		_, err = monitor.Collect(context.Background())
+		require.NoError(t, err)
+		for _, req := range service.requests {
+			assert.False(t, req.IncludeTaskQueueStatus)
+		}
+
+		assert.Equal(t, 1.0, testutil.ToFloat64(taskQueuePollers.WithLabelValues("orchestrator-queue", TaskQueueTypeWorkflow)))
+		assert.Zero(t, testutil.CollectAndCount(taskQueueBacklog), "no backlog is exported")
+	})
+
+	t.Run("status ignored", func(t *testing.T) {
+		// Servers may ignore the flag instead of rejecting it
+		service := &fakeTaskQueueService{pollers: map[string]int{"analysis-queue/Activity": 2}}
+		monitor := newTestTaskQueueMonitor(t, service)
+
+		report, err := monitor.Collect(context.Background())
+		require.NoError(t, err)
+		analysis := report.Queues[0]
+		assert.Equal(t, 2, analysis.Activity.Pollers)
+		assert.Nil(t, analysis.Activity.Backlog)
+		assert.Contains(t, analysis.Limitations, taskQueueLimitationNoStatus)
+	})
+}
+
+func TestTaskQueueMonitor_DescribeFailure(t *testing.T) {
+	service := &fakeTaskQueueService{statusSupport: true, failQueue: "analysis-queue"}
+	monitor := newTestTaskQueueMonitor(t, service)
+
+	report, err := monitor.Collect(context.Background())
+	require.NoError(t, err)
+	analysis := report.Queues[0]
+	assert.Contains(t, analysis.Error, "frontend unavailable")
+	assert.Equal(t, 0, analysis.Workflow.Pollers)
+	assert.Empty(t, report.Queues[1].Error, "other queues are still described")
+	assert.Equal(t, enumspb.TASK_QUEUE_KIND_NORMAL, service.requests[0].TaskQueue.Kind)
+}
@@ -0,0 +1,12 @@
+module example.com/shop
+
+go 1.21
+
+require github.com/gin-gonic/gin v1.9.0
+
+require (
+	golang.org/x/net v0.17.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace gopkg.in/yaml.v3 => gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,603 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.temporal.io/sdk/activity"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidUsageQuery is returned for usage queries that cannot be answered
+var ErrInvalidUsageQuery = errors.New("invalid usage query")
+
+// Usage metrics. Requests are counted per route class, as
+// UsageMetricRequests followed by the class, e.g. requests.workflows.
+const (
+	UsageMetricRequests         = "requests"
+	UsageMetricWorkflowsStarted = "workflows_started"
+	UsageMetricAgentTasks       = "agent_tasks"
+	UsageMetricArtifactBytes    = "artifact_bytes"
+	// Tokens used by LLM requests, such as AI reviews
+	UsageMetricLLMPromptTokens     = "llm_prompt_tokens"
+	UsageMetricLLMCompletionTokens = "llm_completion_tokens"
+)
+
+// Usage report granularities
+const (
+	UsageGranularityHour = "hour"
+	UsageGranularityDay  = "day"
+)
+
+const (
+	// maxUsagePoints bounds the series of a usage report
+	maxUsagePoints = 2000
+	// maxUsageOwners bounds the workflows whose project and creator are
+	// cached for activities
+	maxUsageOwners = 10000
+)
+
+var (
+	usageRecordErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "orchestrator_usage_record_errors_total",
+		Help: "Usage increments lost because the counter store could not be reached",
+	})
+	usageRollupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_usage_rollups_total",
+		Help: "Batches of usage counters rolled up into the usage table, by result",
+	}, []string{"result"})
+)
+
+// UsageKey identifies a usage counter
+type UsageKey struct {
+	Hour      time.Time // Start of the hour, UTC
+	ProjectID string
+	Subject   string
+	Metric    string
+}
+
+// usageFieldSeparator separates the parts of a counter's field; it cannot
+// appear in project IDs, subjects or metric names
+const usageFieldSeparator = "\x1f"
+
+func (k UsageKey) field() string {
+	return strings.Join([]string{strconv.FormatInt(k.Hour.Unix(), 10), k.ProjectID, k.Subject, k.Metric}, usageFieldSeparator)
+}
+
+func parseUsageField(field string) (UsageKey, error) {
+	parts := strings.Split(field, usageFieldSeparator)
+	if len(parts) != 4 {
+		return UsageKey{}, fmt.Errorf("invalid usage counter %q", field)
+	}
+	hour, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return UsageKey{}, fmt.Errorf("invalid usage counter %q: %w", field, err)
+	}
+	return UsageKey{Hour: time.Unix(hour, 0).UTC(), ProjectID: parts[1], Subject: parts[2], Metric: parts[3]}, nil
+}
+
+// UsageCounterStore keeps the usage counters of every replica until they are
+// rolled up. Pending counters are sealed into batches, which are read, added
+// to the usage table and then deleted.
+type UsageCounterStore interface {
+	// Increment adds counts to the pending counters, in one round trip
+	Increment(ctx context.Context, counts map[UsageKey]int64) error
+	// Seal moves the pending counters into a new batch and returns the IDs
+	// of every batch not rolled up yet, older ones included
+	Seal(ctx context.Context) ([]string, error)
+	Batch(ctx context.Context, id string) (map[UsageKey]int64, error)
+	DeleteBatch(ctx context.Context, id string) error
+}
+
+const (
+	usagePendingKey     = "usage:pending"
+	usageBatchKeyPrefix = "usage:batch:"
+)
+
+// redisUsageCounterStore keeps the counters in Redis hashes shared by every
+// replica. Counters not rolled up yet are lost when Redis loses its data.
+type redisUsageCounterStore struct {
+	client *redis.Client
+}
+
+// NewRedisUsageCounterStore keeps usage counters in Redis
+func NewRedisUsageCounterStore(client *redis.Client) UsageCounterStore {
+	return &redisUsageCounterStore{client: client}
+}
+
+func (s *redisUsageCounterStore) Increment(ctx context.Context, counts map[UsageKey]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	pipe := s.client.Pipeline()
+	for key, n := range counts {
+		pipe.HIncrBy(ctx, usagePendingKey, key.field(), n)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisUsageCounterStore) Seal(ctx context.Context) ([]string, error) {
+	// Renaming is atomic: increments after it start a new pending hash
+	err := s.client.Rename(ctx, usagePendingKey, usageBatchKeyPrefix+uuid.NewString()).Err()
+	if err != nil && !strings.Contains(err.Error(), "no such key") {
+		return nil, err
+	}
+
+	var ids []string
+	iter := s.client.Scan(ctx, 0, usageBatchKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		ids = append(ids, strings.TrimPrefix(iter.Val(), usageBatchKeyPrefix))
+	}
+	return ids, iter.Err()
+}
+
+func (s *redisUsageCounterStore) Batch(ctx context.Context, id string) (map[UsageKey]int64, error) {
+	fields, err := s.client.HGetAll(ctx, usageBatchKeyPrefix+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[UsageKey]int64, len(fields))
+	for field, value := range fields {
+		key, err := parseUsageField(field)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid usage count for %q: %w", field, err)
+		}
+		counts[key] += n
+	}
+	return counts, nil
+}
+
+func (s *redisUsageCounterStore) DeleteBatch(ctx context.Context, id string) error {
+	return s.client.Del(ctx, usageBatchKeyPrefix+id).Err()
+}
+
+// memoryUsageCounterStore keeps the counters in memory, for a single replica
+type memoryUsageCounterStore struct {
+	mu      sync.Mutex
+	pending map[UsageKey]int64
+	batches map[string]map[UsageKey]int64
+}
+
+// NewMemoryUsageCounterStore keeps usage counters in memory
+func NewMemoryUsageCounterStore() UsageCounterStore {
+	return &memoryUsageCounterStore{
+		pending: make(map[UsageKey]int64),
+		batches: make(map[string]map[UsageKey]int64),
+	}
+}
+
+func (s *memoryUsageCounterStore) Increment(ctx context.Context, counts map[UsageKey]int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, n := range counts {
+		s.pending[key] += n
+	}
+	return nil
+}
+
+func (s *memoryUsageCounterStore) Seal(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) > 0 {
+		s.batches[uuid.NewString()] = s.pending
+		s.pending = make(map[UsageKey]int64)
+	}
+	ids := make([]string, 0, len(s.batches))
+	for id := range s.batches {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *memoryUsageCounterStore) Batch(ctx context.Context, id string) (map[UsageKey]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[UsageKey]int64, len(s.batches[id]))
+	for key, n := range s.batches[id] {
+		counts[key] = n
+	}
+	return counts, nil
+}
+
+func (s *memoryUsageCounterStore) DeleteBatch(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.batches, id)
+	return nil
+}
+
+// usageSubjectKey carries the subject usage is billed to in a context
+type usageSubjectKey struct{}
+
+// usageOwner is who the usage of a workflow's activities is billed to
+type usageOwner struct {
+	projectID string
+	subject   string
+}
+
+// UsageMeter counts API usage per project and subject, an API key or a user,
+// by the hour: requests by route class, workflows started, agent tasks
+// executed and bytes of artifacts stored. Increments only touch the counter
+// store; a background job rolls the counters up into the usage_buckets
+// table, which usage reports are read from. A nil meter counts nothing.
+type UsageMeter struct {
+	db       *gorm.DB
+	store    UsageCounterStore
+	logger   *zap.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	ownersMu sync.Mutex
+	owners   map[string]usageOwner // By workflow ID
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewUsageMeter creates a usage meter counting into store
+func NewUsageMeter(db *gorm.DB, store UsageCounterStore, cfg *config.UsageConfig, logger *zap.Logger) *UsageMeter {
+	return &UsageMeter{
+		db:       db,
+		store:    store,
+		logger:   logger,
+		interval: time.Duration(cfg.RollupInterval) * time.Second,
+		now:      time.Now,
+		owners:   make(map[string]usageOwner),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// SetUsageMeter makes the engine count the workflows it starts
+func (e *WorkflowEngine) SetUsageMeter(usage *UsageMeter) {
+	e.usage = usage
+}
+
+// SetUsageMeter makes the client count the agent tasks it executes
+func (c *AgentClient) SetUsageMeter(usage *UsageMeter) {
+	c.usage = usage
+}
+
+// SetUsageMeter makes the accountant count the bytes of artifacts stored
+func (a *StorageAccountant) SetUsageMeter(usage *UsageMeter) {
+	a.usage = usage
+}
+
+// Start starts the background roll-up job
+func (m *UsageMeter) Start() {
+	m.wg.Add(1)
+	go m.run()
+	m.logger.Info("Usage meter started", zap.Duration("rollup_interval", m.interval))
+}
+
+// Stop stops the background job after a last roll-up
+func (m *UsageMeter) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+		m.wg.Wait()
+		m.logger.Info("Usage meter stopped")
+	})
+}
+
+func (m *UsageMeter) run() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		stopping := false
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			stopping = true
+		}
+
+		if _, err := m.Rollup(context.Background()); err != nil {
+			m.logger.Error("Failed to roll up usage counters", zap.Error(err))
+		}
+		if stopping {
+			return
+		}
+	}
+}
+
+// WithSubject returns ctx carrying who the usage recorded with it is billed
+// to, such as api_key:<fingerprint> or user:<id>
+func (m *UsageMeter) WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, usageSubjectKey{}, subject)
+}
+
+// RecordRequest counts an API request of a route class against a project,
+// "" when the request names none
+func (m *UsageMeter) RecordRequest(ctx context.Context, projectID, routeClass string) {
+	m.Record(ctx, projectID, UsageMetricRequests+"."+routeClass, 1)
+}
+
+// RecordWorkflowStart counts a started workflow. Workflows started on behalf
+// of no request, such as queued ones, are billed to their creator.
+func (m *UsageMeter) RecordWorkflowStart(ctx context.Context, workflow *models.Workflow) {
+	if m == nil {
+		return
+	}
+	subject := usageSubject(ctx)
+	if subject == "" && workflow.CreatedBy != "" {
+		subject = "user:" + workflow.CreatedBy
+	}
+	m.increment(ctx, UsageKey{ProjectID: workflow.ProjectID, Subject: subject, Metric: UsageMetricWorkflowsStarted}, 1)
+}
+
+// Record adds n to a metric of a project. In workflow activities, usage is
+// billed to the creator of the workflow and, when projectID is empty, to
+// its project.
+func (m *UsageMeter) Record(ctx context.Context, projectID, metric string, n int64) {
+	if m == nil || n == 0 {
+		return
+	}
+	subject := usageSubject(ctx)
+	if subject == "" && activity.IsActivity(ctx) {
+		owner := m.workflowOwner(ctx, activity.GetInfo(ctx).WorkflowExecution.ID)
+		subject = owner.subject
+		if projectID == "" {
+			projectID = owner.projectID
+		}
+	}
+	m.increment(ctx, UsageKey{ProjectID: projectID, Subject: subject, Metric: metric}, n)
+}
+
+// increment adds to a counter of the current hour. Metering never fails the
+// work it counts: increments the store rejects are logged and dropped.
+func (m *UsageMeter) increment(ctx context.Context, key UsageKey, n int64) {
+	key.Hour = m.now().UTC().Truncate(time.Hour)
+	if err := m.store.Increment(context.WithoutCancel(ctx), map[UsageKey]int64{key: n}); err != nil {
+		usageRecordErrorsTotal.Inc()
+		// Redis outages are logged once per interval by its guard
+		if !IsRedisOutage(err) {
+			m.logger.Warn("Failed to record usage",
+				zap.String("project_id", key.ProjectID),
+				zap.String("metric", key.Metric),
+				zap.Error(err))
+		}
+	}
+}
+
+func usageSubject(ctx context.Context) string {
+	subject, _ := ctx.Value(usageSubjectKey{}).(string)
+	return subject
+}
+
+// workflowOwner returns the project and creator of a workflow, cached so
+// activities of a workflow look it up once
+func (m *UsageMeter) workflowOwner(ctx context.Context, workflowID string) usageOwner {
+	m.ownersMu.Lock()
+	owner, ok := m.owners[workflowID]
+	m.ownersMu.Unlock()
+	if ok {
+		return owner
+	}
+
+	var workflow models.Workflow
+	err := m.db.WithContext(ctx).Select("project_id", "created_by").Take(&workflow, "id = ?", workflowID).Error
+	if err != nil {
+		// Child workflows have no row of their own; their usage is
+		// unattributed rather than lost
+		m.logger.Debug("Usage of workflow billed to no project", zap.String("workflow_id", workflowID), zap.Error(err))
+		return usageOwner{}
+	}
+	owner = usageOwner{projectID: workflow.ProjectID}
+	if workflow.CreatedBy != "" {
+		owner.subject = "user:" + workflow.CreatedBy
+	}
+
+	m.ownersMu.Lock()
+	if len(m.owners) >= maxUsageOwners {
+		m.owners = make(map[string]usageOwner)
+	}
+	m.owners[workflowID] = owner
+	m.ownersMu.Unlock()
+	return owner
+}
+
+// Rollup seals the pending counters and adds every batch not rolled up yet
+// to the usage table. Each batch is claimed in the transaction adding it, so
+// a batch rolled up again, after a crash or by another replica, is not
+// counted twice. It returns the number of batches added.
+func (m *UsageMeter) Rollup(ctx context.Context) (int, error) {
+	ids, err := m.store.Seal(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seal usage counters: %w", err)
+	}
+
+	applied := 0
+	for _, id := range ids {
+		counts, err := m.store.Batch(ctx, id)
+		if err != nil {
+			return applied, fmt.Errorf("failed to read usage batch %s: %w", id, err)
+		}
+		added, err := m.applyBatch(ctx, id, counts)
+		if err != nil {
+			usageRollupsTotal.WithLabelValues("error").Inc()
+			return applied, err
+		}
+		if added {
+			applied++
+			usageRollupsTotal.WithLabelValues("applied").Inc()
+		} else {
+			usageRollupsTotal.WithLabelValues("duplicate").Inc()
+		}
+		if err := m.store.DeleteBatch(ctx, id); err != nil {
+			return applied, fmt.Errorf("failed to delete usage batch %s: %w", id, err)
+		}
+	}
+	if applied > 0 {
+		m.logger.Info("Usage counters rolled up", zap.Int("batches", applied))
+	}
+	return applied, nil
+}
+
+// applyBatch adds a batch to the usage buckets unless it was added already
+func (m *UsageMeter) applyBatch(ctx context.Context, id string, counts map[UsageKey]int64) (bool, error) {
+	added := false
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		claim := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.UsageRollup{ID: id, AppliedAt: m.now().UTC()})
+		if claim.Error != nil {
+			return fmt.Errorf("failed to claim usage batch: %w", claim.Error)
+		}
+		if claim.RowsAffected == 0 {
+			return nil // Already rolled up
+		}
+		added = true
+
+		buckets := make([]models.UsageBucket, 0, len(counts))
+		for key, n := range counts {
+			buckets = append(buckets, models.UsageBucket{
+				ProjectID: key.ProjectID,
+				Subject:   key.Subject,
+				Metric:    key.Metric,
+				Hour:      key.Hour,
+				Count:     n,
+				UpdatedAt: m.now().UTC(),
+			})
+		}
+		if len(buckets) == 0 {
+			return nil
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "project_id"}, {Name: "subject"}, {Name: "metric"}, {Name: "hour"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"count":      gorm.Expr("usage_buckets.count + excluded.count"),
+				"updated_at": gorm.Expr("excluded.updated_at"),
+			}),
+		}).CreateInBatches(buckets, 500).Error
+		if err != nil {
+			return fmt.Errorf("failed to add usage buckets: %w", err)
+		}
+		return nil
+	})
+	return added, err
+}
+
+// UsageQuery selects the usage of a report
+type UsageQuery struct {
+	ProjectID   string // Every project when empty
+	From        time.Time
+	To          time.Time
+	Granularity string // hour or day
+}
+
+// UsagePoint is the usage of one period of a report
+type UsagePoint struct {
+	Start   time.Time        `json:"start"`
+	Metrics map[string]int64 `json:"metrics"`
+}
+
+// UsageReport is the usage of a project, or of every project, over a period
+type UsageReport struct {
+	ProjectID   string                      `json:"project_id,omitempty"`
+	From        time.Time                   `json:"from"`
+	To          time.Time                   `json:"to"`
+	Granularity string                      `json:"granularity"`
+	Series      []UsagePoint                `json:"series"`
+	Totals      map[string]int64            `json:"totals"`
+	BySubject   map[string]map[string]int64 `json:"by_subject"` // Totals by API key or user
+}
+
+// Usage reports the rolled-up usage of a query. Usage of the last roll-up
+// interval is not reported until it is rolled up. From is rounded down, and
+// To up, to the granularity; periods without usage are reported with no
+// metrics.
+func (m *UsageMeter) Usage(ctx context.Context, query UsageQuery) (*UsageReport, error) {
+	step := time.Hour
+	switch query.Granularity {
+	case UsageGranularityHour:
+	case UsageGranularityDay:
+		step = 24 * time.Hour
+	default:
+		return nil, fmt.Errorf("%w: granularity must be hour or day", ErrInvalidUsageQuery)
+	}
+	from := query.From.UTC().Truncate(step)
+	to := query.To.UTC()
+	if truncated := to.Truncate(step); !truncated.Equal(to) {
+		to = truncated.Add(step)
+	}
+	if !from.Before(to) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidUsageQuery)
+	}
+	points := int(to.Sub(from) / step)
+	if points > maxUsagePoints {
+		return nil, fmt.Errorf("%w: the range spans %d periods, at most %d are allowed", ErrInvalidUsageQuery, points, maxUsagePoints)
+	}
+
+	db := m.db.WithContext(ctx).Where("hour >= ? AND hour < ?", from, to)
+	if query.ProjectID != "" {
+		db = db.Where("project_id = ?", query.ProjectID)
+	}
+	var buckets []models.UsageBucket
+	if err := db.Find(&buckets).Error; err != nil {
+		return nil, fmt.Errorf("failed to list usage buckets: %w", err)
+	}
+
+	report := &UsageReport{
+		ProjectID:   query.ProjectID,
+		From:        from,
+		To:          to,
+		Granularity: query.Granularity,
+		Series:      make([]UsagePoint, points),
+		Totals:      make(map[string]int64),
+		BySubject:   make(map[string]map[string]int64),
+	}
+	for i := range report.Series {
+		report.Series[i] = UsagePoint{Start: from.Add(time.Duration(i) * step), Metrics: make(map[string]int64)}
+	}
+	for _, bucket := range buckets {
+		i := int(bucket.Hour.UTC().Sub(from) / step)
+		if i < 0 || i >= points {
+			continue
+		}
+		report.Series[i].Metrics[bucket.Metric] += bucket.Count
+		report.Totals[bucket.Metric] += bucket.Count
+		if report.BySubject[bucket.Subject] == nil {
+			report.BySubject[bucket.Subject] = make(map[string]int64)
+		}
+		report.BySubject[bucket.Subject][bucket.Metric] += bucket.Count
+	}
+	return report, nil
+}
+
+// UsageCSV renders the series of a report as CSV rows of period start,
+// metric and count, metrics in name order
+func UsageCSV(report *UsageReport) [][]string {
+	rows := [][]string{{"period_start", "metric", "count"}}
+	for _, point := range report.Series {
+		metrics := make([]string, 0, len(point.Metrics))
+		for metric := range point.Metrics {
+			metrics = append(metrics, metric)
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics {
+			rows = append(rows, []string{point.Start.Format(time.RFC3339), metric, strconv.FormatInt(point.Metrics[metric], 10)})
+		}
+	}
+	return rows
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// setupUsageMeter returns a usage meter counting in memory at a fixed time
+func setupUsageMeter(t *testing.T, store UsageCounterStore) (*UsageMeter, *gorm.DB, *time.Time) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.UsageBucket{}, &models.UsageRollup{}))
+
+	now := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	meter := NewUsageMeter(db, store, &config.UsageConfig{RollupInterval: 60}, zap.NewNop())
+	meter.now = func() time.Time { return now }
+	return meter, db, &now
+}
+
+func usageCounts(t *testing.T, db *gorm.DB) map[string]int64 {
+	var buckets []models.UsageBucket
+	require.NoError(t, db.Find(&buckets).Error)
+	counts := make(map[string]int64)
+	for _, bucket := range buckets {
+		counts[bucket.ProjectID+"/"+bucket.Subject+"/"+bucket.Metric+"@"+bucket.Hour.UTC().Format("15")] += bucket.Count
+	}
+	return counts
+}
+
+func TestUsageMeter_CountsConcurrentIncrements(t *testing.T) {
+	ctx := context.Background()
+	meter, db, _ := setupUsageMeter(t, NewMemoryUsageCounterStore())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			subject := "user:alice"
+			if i%2 == 1 {
+				subject = "api_key:0123456789ab"
+			}
+			ctx := meter.WithSubject(ctx, subject)
+			for j := 0; j < 20; j++ {
+				meter.RecordRequest(ctx, "project-1", "workflows")
+			}
+			meter.Record(ctx, "project-1", UsageMetricArtifactBytes, 512)
+		}(i)
+	}
+	wg.Wait()
+
+	applied, err := meter.Rollup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, map[string]int64{
+		"project-1/user:alice/requests.workflows@09":           500,
+		"project-1/api_key:0123456789ab/requests.workflows@09": 500,
+		"project-1/user:alice/artifact_bytes@09":               25 * 512,
+		"project-1/api_key:0123456789ab/artifact_bytes@09":     25 * 512,
+	}, usageCounts(t, db))
+
+	// Workflows started on behalf of no request are billed to their creator
+	meter.RecordWorkflowStart(ctx, &models.Workflow{ProjectID: "project-2", CreatedBy: "bob"})
+	_, err = meter.Rollup(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, usageCounts(t, db)["project-2/user:bob/workflows_started@09"])
+
+	// A nil meter counts nothing
+	(*UsageMeter)(nil).RecordRequest(ctx, "project-1", "workflows")
+}
+
+// flakyUsageStore fails to delete batches while failDeletes is set, as if
+// the roll-up crashed after adding a batch
+type flakyUsageStore struct {
+	UsageCounterStore
+	failDeletes bool
+}
+
+func (s *flakyUsageStore) DeleteBatch(ctx context.Context, id string) error {
+	if s.failDeletes {
+		return errors.New("connection reset")
+	}
+	return s.UsageCounterStore.DeleteBatch(ctx, id)
+}
+
+func TestUsageMeter_RollupIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	store := &flakyUsageStore{UsageCounterStore: NewMemoryUsageCounterStore(), failDeletes: true}
+	meter, db, now := setupUsageMeter(t, store)
+	ctx = meter.WithSubject(ctx, "user:alice")
+
+	meter.Record(ctx, "project-1", UsageMetricAgentTasks, 3)
+	_, err := meter.Rollup(ctx)
+	require.Error(t, err, "the batch was added but not deleted")
+	assert.EqualValues(t, 3, usageCounts(t, db)["project-1/user:alice/agent_tasks@09"])
+
+	// The next roll-up finds the batch again and does not add it twice;
+	// counters of the next hour are added to their own bucket
+	store.failDeletes = false
+	*now = now.Add(time.Hour)
+	meter.Record(ctx, "project-1", UsageMetricAgentTasks, 2)
+	applied, err := meter.Rollup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.Equal(t, map[string]int64{
+		"project-1/user:alice/agent_tasks@09": 3,
+		"project-1/user:alice/agent_tasks@10": 2,
+	}, usageCounts(t, db))
+
+	// Batches of the same hour add up
+	meter.Record(ctx, "project-1", UsageMetricAgentTasks, 4)
+	_, err = meter.Rollup(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 6, usageCounts(t, db)["project-1/user:alice/agent_tasks@10"])
+
+	applied, err = meter.Rollup(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, applied, "nothing left to roll up")
+	var rollups int64
+	require.NoError(t, db.Model(&models.UsageRollup{}).Count(&rollups).Error)
+	assert.EqualValues(t, 3, rollups)
+}
+
+func TestUsageMeter_ReportsByGranularity(t *testing.T) {
+	ctx := context.Background()
+	meter, db, _ := setupUsageMeter(t, NewMemoryUsageCounterStore())
+	day := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.Create([]models.UsageBucket{
+		{ProjectID: "project-1", Subject: "user:alice", Metric: "requests.workflows", Hour: day.Add(9 * time.Hour), Count: 10},
+		{ProjectID: "project-1", Subject: "api_key:abc", Metric: "requests.workflows", Hour: day.Add(9 * time.Hour), Count: 5},
+		{ProjectID: "project-1", Subject: "user:alice", Metric: "workflows_started", Hour: day.Add(11 * time.Hour), Count: 2},
+		{ProjectID: "project-1", Subject: "user:alice", Metric: "requests.workflows", Hour: day.Add(30 * time.Hour), Count: 7},
+		{ProjectID: "project-2", Subject: "user:bob", Metric: "requests.agents", Hour: day.Add(9 * time.Hour), Count: 100},
+	}).Error)
+
+	hourly, err := meter.Usage(ctx, UsageQuery{
+		ProjectID: "project-1", From: day.Add(9*time.Hour + 15*time.Minute), To: day.Add(12 * time.Hour), Granularity: UsageGranularityHour,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, day.Add(9*time.Hour), hourly.From, "from is rounded down to the hour")
+	require.Len(t, hourly.Series, 3)
+	assert.Equal(t, map[string]int64{"requests.workflows": 15}, hourly.Series[0].Metrics)
+	assert.Empty(t, hourly.Series[1].Metrics)
+	assert.Equal(t, map[string]int64{"workflows_started": 2}, hourly.Series[2].Metrics)
+	assert.Equal(t, map[string]int64{"requests.workflows": 15, "workflows_started": 2}, hourly.Totals)
+	assert.Equal(t, map[string]int64{"requests.workflows": 5}, hourly.BySubject["api_key:abc"])
+
+	daily, err := meter.Usage(ctx, UsageQuery{
+		ProjectID: "project-1", From: day, To: day.Add(36 * time.Hour), Granularity: UsageGranularityDay,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, day.Add(48*time.Hour), daily.To, "to is rounded up to the day")
+	require.Len(t, daily.Series, 2)
+	assert.Equal(t, map[string]int64{"requests.workflows": 15, "workflows_started": 2}, daily.Series[0].Metrics)
+	assert.Equal(t, map[string]int64{"requests.workflows": 7}, daily.Series[1].Metrics)
+	assert.Equal(t, map[string]int64{"requests.workflows": 22, "workflows_started": 2}, daily.Totals)
+
+	// Without a project, every project is reported
+	all, err := meter.Usage(ctx, UsageQuery{From: day, To: day.Add(24 * time.Hour), Granularity: UsageGranularityDay})
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, all.Totals["requests.agents"])
+
+	assert.Equal(t, [][]string{
+		{"period_start", "metric", "count"},
+		{"2026-10-17T00:00:00Z", "requests.workflows", "15"},
+		{"2026-10-17T00:00:00Z", "workflows_started", "2"},
+		{"2026-10-18T00:00:00Z", "requests.workflows", "7"},
+	}, UsageCSV(daily))
+
+	for _, query := range []UsageQuery{
+		{From: day, To: day.Add(time.Hour), Granularity: "week"},
+		{From: day, To: day, Granularity: UsageGranularityHour},
+		{From: day, To: day.Add(365 * 24 * time.Hour), Granularity: UsageGranularityHour},
+	} {
+		_, err := meter.Usage(ctx, query)
+		assert.ErrorIs(t, err, ErrInvalidUsageQuery)
+	}
+}
@@ -0,0 +1,628 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/encryption"
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrUnresolvedReference is returned when an ${env.NAME} or
+	// ${secret.NAME} reference has no value
+	ErrUnresolvedReference = errors.New("unresolved variable reference")
+	// ErrSecretNotFound is returned by secret providers for unknown names
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrInvalidVariableName is returned for names that cannot be referenced
+	ErrInvalidVariableName = errors.New("invalid variable name")
+	// ErrUnresolvedPlaceholder is returned when a {{secret:NAME}} or
+	// {{var:NAME}} placeholder has no value
+	ErrUnresolvedPlaceholder = errors.New("unresolved placeholder")
+)
+
+// RedactedValue replaces secret values in execution output
+const RedactedValue = "[REDACTED]"
+
+var (
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	referencePattern    = regexp.MustCompile(`\$\{(env|secret)\.([A-Za-z_][A-Za-z0-9_]*)\}`)
+	// placeholderPattern matches the placeholders of workflow input and
+	// config, which stay in the stored workflow and are only resolved while
+	// it runs
+	placeholderPattern = regexp.MustCompile(`\{\{\s*(secret|var):([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// ValidateVariableName reports whether name can be used as a variable or
+// secret name
+func ValidateVariableName(name string) error {
+	if !variableNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidVariableName, name)
+	}
+	return nil
+}
+
+// SecretProvider stores project secrets. Values are write-only through the
+// API; only the resolver reads them back.
+type SecretProvider interface {
+	GetSecret(ctx context.Context, projectID, name string) (string, error)
+	SetSecret(ctx context.Context, projectID, name, value string) error
+	DeleteSecret(ctx context.Context, projectID, name string) error
+	ListSecrets(ctx context.Context, projectID string) ([]string, error)
+}
+
+// DBSecretProvider keeps secrets in the project's secrets column, each value
+// sealed in its own encryption envelope
+type DBSecretProvider struct {
+	db        *gorm.DB
+	encryptor *encryption.Encryptor
+}
+
+var _ SecretProvider = (*DBSecretProvider)(nil)
+
+// NewDBSecretProvider creates a provider that encrypts values with encryptor
+func NewDBSecretProvider(db *gorm.DB, encryptor *encryption.Encryptor) *DBSecretProvider {
+	return &DBSecretProvider{db: db, encryptor: encryptor}
+}
+
+// GetSecret decrypts the named secret of a project
+func (p *DBSecretProvider) GetSecret(ctx context.Context, projectID, name string) (string, error) {
+	secrets, err := p.load(p.db.WithContext(ctx), projectID)
+	if err != nil {
+		return "", err
+	}
+	sealed, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	plaintext, err := p.encryptor.Decrypt(ctx, sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+	}
+	return string(plaintext), nil
+}
+
+// SetSecret creates or replaces a secret
+func (p *DBSecretProvider) SetSecret(ctx context.Context, projectID, name, value string) error {
+	if err := ValidateVariableName(name); err != nil {
+		return err
+	}
+	sealed, err := p.encryptor.Encrypt(ctx, []byte(value))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret %s: %w", name, err)
+	}
+	return p.update(ctx, projectID, func(secrets map[string]json.RawMessage) error {
+		secrets[name] = sealed
+		return nil
+	})
+}
+
+// DeleteSecret removes a secret
+func (p *DBSecretProvider) DeleteSecret(ctx context.Context, projectID, name string) error {
+	return p.update(ctx, projectID, func(secrets map[string]json.RawMessage) error {
+		if _, ok := secrets[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+		}
+		delete(secrets, name)
+		return nil
+	})
+}
+
+// ListSecrets returns the names of a project's secrets, never their values
+func (p *DBSecretProvider) ListSecrets(ctx context.Context, projectID string) ([]string, error) {
+	secrets, err := p.load(p.db.WithContext(ctx), projectID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RewrapSecrets re-wraps the data keys of every project secret under the
+// current master key. Secrets are sealed one by one inside the secrets map
+// rather than in an encrypted column, so encryption.Rewrap does not see
+// them. Soft-deleted projects are included.
+func (p *DBSecretProvider) RewrapSecrets(ctx context.Context) (encryption.RewrapResult, error) {
+	var result encryption.RewrapResult
+	var projectIDs []string
+	if err := p.db.WithContext(ctx).Unscoped().Model(&models.Project{}).
+		Where("secrets IS NOT NULL").Order("id").Pluck("id", &projectIDs).Error; err != nil {
+		return result, fmt.Errorf("failed to list projects with secrets: %w", err)
+	}
+
+	for _, projectID := range projectIDs {
+		result.Scanned++
+		rewrapped := false
+		err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			secrets, err := p.load(tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}), projectID)
+			if err != nil {
+				return err
+			}
+			for name, sealed := range secrets {
+				data, changed, err := p.encryptor.Rewrap(ctx, sealed)
+				if err != nil {
+					return fmt.Errorf("failed to rewrap secret %s: %w", name, err)
+				}
+				if changed {
+					secrets[name] = data
+					rewrapped = true
+				}
+			}
+			if !rewrapped {
+				return nil
+			}
+			return p.save(tx.Unscoped(), projectID, secrets)
+		})
+		if err != nil {
+			return result, fmt.Errorf("failed to rewrap secrets of project %s: %w", projectID, err)
+		}
+		if rewrapped {
+			result.Rewrapped++
+		}
+	}
+	return result, nil
+}
+
+func (p *DBSecretProvider) load(db *gorm.DB, projectID string) (map[string]json.RawMessage, error) {
+	var project models.Project
+	if err := db.Select("id", "secrets").First(&project, "id = ?", projectID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+	secrets := make(map[string]json.RawMessage)
+	if len(project.Secrets) > 0 {
+		if err := json.Unmarshal(project.Secrets, &secrets); err != nil {
+			return nil, fmt.Errorf("failed to decode project secrets: %w", err)
+		}
+	}
+	return secrets, nil
+}
+
+func (p *DBSecretProvider) update(ctx context.Context, projectID string, fn func(map[string]json.RawMessage) error) error {
+	return p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		secrets, err := p.load(tx, projectID)
+		if err != nil {
+			return err
+		}
+		if err := fn(secrets); err != nil {
+			return err
+		}
+		return p.save(tx, projectID, secrets)
+	})
+}
+
+func (p *DBSecretProvider) save(db *gorm.DB, projectID string, secrets map[string]json.RawMessage) error {
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to encode project secrets: %w", err)
+	}
+	if err := db.Model(&models.Project{}).Where("id = ?", projectID).
+		UpdateColumn("secrets", json.RawMessage(data)).Error; err != nil {
+		return fmt.Errorf("failed to update project secrets: %w", err)
+	}
+	return nil
+}
+
+// VariableService manages project environment variables and resolves the
+// variables of an execution
+type VariableService struct {
+	db      *gorm.DB
+	secrets SecretProvider
+}
+
+// NewVariableService creates a variable service. secrets may be nil, in
+// which case ${secret.NAME} references fail to resolve.
+func NewVariableService(db *gorm.DB, secrets SecretProvider) *VariableService {
+	return &VariableService{db: db, secrets: secrets}
+}
+
+// Secrets returns the secret provider, or nil when secrets are not available
+func (s *VariableService) Secrets() SecretProvider {
+	return s.secrets
+}
+
+// GetProjectVariables returns a project's environment variables
+func (s *VariableService) GetProjectVariables(ctx context.Context, projectID string) (map[string]string, error) {
+	var project models.Project
+	if err := s.db.WithContext(ctx).Select("id", "environment_vars").First(&project, "id = ?", projectID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+	return decodeVariables(project.EnvironmentVars)
+}
+
+// SetProjectVariables replaces a project's environment variables
+func (s *VariableService) SetProjectVariables(ctx context.Context, projectID string, vars map[string]string) error {
+	for name := range vars {
+		if err := ValidateVariableName(name); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(vars)
+	if err != nil {
+		return fmt.Errorf("failed to encode environment variables: %w", err)
+	}
+
+	result := s.db.WithContext(ctx).Model(&models.Project{}).Where("id = ?", projectID).
+		UpdateColumn("environment_vars", json.RawMessage(data))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update environment variables: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("failed to get project: %w", gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// VariableScope selects the variables an execution sees
+type VariableScope struct {
+	ProjectID   string
+	Environment string            // Name of a project environment, optional
+	Overrides   map[string]string // Request-level values, highest precedence
+}
+
+// Resolve merges the project's variables, the selected environment's
+// variables and the overrides, later layers winning, then expands the
+// references in the merged values
+func (s *VariableService) Resolve(ctx context.Context, scope VariableScope) (*ResolvedVariables, error) {
+	merged := make(map[string]string)
+
+	if scope.ProjectID != "" {
+		projectVars, err := s.GetProjectVariables(ctx, scope.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range projectVars {
+			merged[name] = value
+		}
+	}
+
+	if scope.Environment != "" {
+		var environment models.Environment
+		if err := s.db.WithContext(ctx).Select("id", "variables").
+			First(&environment, "project_id = ? AND name = ?", scope.ProjectID, scope.Environment).Error; err != nil {
+			return nil, fmt.Errorf("failed to get environment %s: %w", scope.Environment, err)
+		}
+		envVars, err := decodeVariables(environment.Variables)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range envVars {
+			merged[name] = value
+		}
+	}
+
+	for name, value := range scope.Overrides {
+		merged[name] = value
+	}
+
+	resolved := &ResolvedVariables{
+		projectID: scope.ProjectID,
+		provider:  s.secrets,
+		raw:       merged,
+		env:       make(map[string]string, len(merged)),
+		secrets:   make(map[string]string),
+	}
+	for name, value := range merged {
+		expanded, err := resolved.Expand(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve variable %s: %w", name, err)
+		}
+		resolved.env[name] = expanded
+	}
+	return resolved, nil
+}
+
+// ResolveForWorkflow resolves the variables of the project a workflow
+// belongs to
+func (s *VariableService) ResolveForWorkflow(ctx context.Context, workflowID, environment string, overrides map[string]string) (*ResolvedVariables, error) {
+	var workflow models.Workflow
+	if err := s.db.WithContext(ctx).Select("id", "project_id").First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	return s.Resolve(ctx, VariableScope{
+		ProjectID:   workflow.ProjectID,
+		Environment: environment,
+		Overrides:   overrides,
+	})
+}
+
+// decodeVariables reads a JSON object of variables; non-string values are
+// formatted as text
+func decodeVariables(data json.RawMessage) (map[string]string, error) {
+	vars := make(map[string]string)
+	if len(data) == 0 {
+		return vars, nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode environment variables: %w", err)
+	}
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			vars[name] = v
+		case nil:
+			vars[name] = ""
+		default:
+			vars[name] = fmt.Sprint(v)
+		}
+	}
+	return vars, nil
+}
+
+// ResolvedVariables are the variables of one execution. They also remember
+// every secret value handed out so it can be redacted from what the
+// execution produces. A nil *ResolvedVariables expands and redacts nothing.
+// They may be shared by the concurrent activities of a workflow.
+type ResolvedVariables struct {
+	projectID string
+	provider  SecretProvider
+	raw       map[string]string
+	env       map[string]string
+
+	mu      sync.Mutex // guards secrets
+	secrets map[string]string
+}
+
+// Env returns the merged, expanded environment
+func (r *ResolvedVariables) Env() map[string]string {
+	if r == nil {
+		return nil
+	}
+	env := make(map[string]string, len(r.env))
+	for name, value := range r.env {
+		env[name] = value
+	}
+	return env
+}
+
+// Expand replaces ${env.NAME} and ${secret.NAME} references in s. Values
+// substituted for ${env.NAME} are not expanded again.
+func (r *ResolvedVariables) Expand(ctx context.Context, s string) (string, error) {
+	if r == nil || !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var firstErr error
+	expanded := referencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		match := referencePattern.FindStringSubmatch(ref)
+		kind, name := match[1], match[2]
+		if kind == "env" {
+			if value, ok := r.raw[name]; ok {
+				return value
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%w: %s", ErrUnresolvedReference, ref)
+			}
+			return ref
+		}
+
+		value, err := r.secret(ctx, name)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%w: %s: %v", ErrUnresolvedReference, ref, err)
+		}
+		return value
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return expanded, nil
+}
+
+// ExpandValue expands references in every string of a decoded JSON value,
+// returning a copy
+func (r *ResolvedVariables) ExpandValue(ctx context.Context, value interface{}) (interface{}, error) {
+	return expandStrings(ctx, value, r.Expand)
+}
+
+// Placeholders returns the distinct placeholders of JSON documents, such as
+// a workflow's input and config, as "secret:NAME" or "var:NAME" in order
+func Placeholders(documents ...[]byte) []string {
+	seen := make(map[string]bool)
+	var placeholders []string
+	for _, document := range documents {
+		for _, match := range placeholderPattern.FindAllSubmatch(document, -1) {
+			placeholder := string(match[1]) + ":" + string(match[2])
+			if !seen[placeholder] {
+				seen[placeholder] = true
+				placeholders = append(placeholders, placeholder)
+			}
+		}
+	}
+	sort.Strings(placeholders)
+	return placeholders
+}
+
+// ExpandPlaceholders replaces {{var:NAME}} placeholders in s with the
+// expanded variable and {{secret:NAME}} placeholders with the secret, which
+// is then redacted like the secrets of ${secret.NAME}
+func (r *ResolvedVariables) ExpandPlaceholders(ctx context.Context, s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	var firstErr error
+	expanded := placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		if firstErr != nil {
+			return placeholder
+		}
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		kind, name := match[1], match[2]
+		if r == nil {
+			firstErr = fmt.Errorf("%w: {{%s:%s}}: variables are not configured", ErrUnresolvedPlaceholder, kind, name)
+			return placeholder
+		}
+		if kind == "var" {
+			if value, ok := r.env[name]; ok {
+				return value
+			}
+			firstErr = fmt.Errorf("%w: {{var:%s}}", ErrUnresolvedPlaceholder, name)
+			return placeholder
+		}
+
+		value, err := r.secret(ctx, name)
+		if err != nil {
+			firstErr = fmt.Errorf("%w: {{secret:%s}}: %v", ErrUnresolvedPlaceholder, name, err)
+			return placeholder
+		}
+		return value
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return expanded, nil
+}
+
+// ExpandPlaceholderValue expands placeholders in every string of a decoded
+// JSON value, returning a copy
+func (r *ResolvedVariables) ExpandPlaceholderValue(ctx context.Context, value interface{}) (interface{}, error) {
+	return expandStrings(ctx, value, r.ExpandPlaceholders)
+}
+
+// expandStrings applies expand to every string of a decoded JSON value,
+// returning a copy
+func expandStrings(ctx context.Context, value interface{}, expand func(ctx context.Context, s string) (string, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return expand(ctx, v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			expanded, err := expandStrings(ctx, item, expand)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = expanded
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			expanded, err := expandStrings(ctx, item, expand)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = expanded
+		}
+		return out, nil
+	case map[string]string:
+		// Environment variables of code execution requests
+		out := make(map[string]string, len(v))
+		for key, item := range v {
+			expanded, err := expand(ctx, item)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = expanded
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+func (r *ResolvedVariables) secret(ctx context.Context, name string) (string, error) {
+	r.mu.Lock()
+	value, ok := r.secrets[name]
+	r.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	if r.provider == nil {
+		return "", errors.New("secrets are not enabled")
+	}
+	value, err := r.provider.GetSecret(ctx, r.projectID, name)
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.secrets[name] = value
+	r.mu.Unlock()
+	return value, nil
+}
+
+// Redact replaces the secret values handed out by Expand with RedactedValue
+func (r *ResolvedVariables) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, value := range r.secretValues() {
+		s = strings.ReplaceAll(s, value, RedactedValue)
+		// Values echoed back inside JSON are escaped
+		if escaped, err := json.Marshal(value); err == nil {
+			if quoted := string(escaped[1 : len(escaped)-1]); quoted != value {
+				s = strings.ReplaceAll(s, quoted, RedactedValue)
+			}
+		}
+	}
+	return s
+}
+
+// RedactValue redacts every string of a decoded JSON value, returning a copy
+func (r *ResolvedVariables) RedactValue(value interface{}) interface{} {
+	if r == nil || len(r.secretValues()) == 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case string:
+		return r.Redact(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = r.RedactValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.RedactValue(item)
+		}
+		return out
+	}
+	return value
+}
+
+// RedactMap redacts the values of an output map
+func (r *ResolvedVariables) RedactMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	return r.RedactValue(m).(map[string]interface{})
+}
+
+// RedactError returns err with secret values removed from its message
+func (r *ResolvedVariables) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if redacted := r.Redact(err.Error()); redacted != err.Error() {
+		return errors.New(redacted)
+	}
+	return err
+}
+
+// secretValues returns the non-empty secret values, longest first so a
+// value containing another is replaced whole
+func (r *ResolvedVariables) secretValues() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	values := make([]string, 0, len(r.secrets))
+	for _, value := range r.secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
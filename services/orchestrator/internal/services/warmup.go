@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// warmupID is the ID the warmup looks rows up by; no row has it
+const warmupID = "00000000-0000-0000-0000-000000000000"
+
+var (
+	warmupDurationSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_warmup_duration_seconds",
+		Help: "Time each startup warmup step took",
+	}, []string{"step"})
+	warmupFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_warmup_failures_total",
+		Help: "Startup warmup steps that failed or timed out, by step",
+	}, []string{"step"})
+)
+
+// WarmupModels are the tables the workflow start and read paths touch
+var WarmupModels = []interface{}{
+	&models.Workflow{},
+	&models.WorkflowStep{},
+	&models.WorkflowExecution{},
+	&models.WorkflowTemplate{},
+	&models.Project{},
+	&models.Execution{},
+	&models.AuditLog{},
+}
+
+// WarmupStep is a part of the startup warmup
+type WarmupStep struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// WarmupResult is the outcome of a warmup step
+type WarmupResult struct {
+	Step     string
+	Duration time.Duration
+	Err      error
+}
+
+// RunWarmup runs the steps concurrently, each bounded by timeout, so the
+// first requests after a deploy do not pay for opening connections and
+// filling caches. Failures are logged and returned, never fatal: a step
+// that fails leaves its path to warm up on first use.
+func RunWarmup(ctx context.Context, steps []WarmupStep, timeout time.Duration, logger *zap.Logger) []WarmupResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	type finished struct {
+		index  int
+		result WarmupResult
+	}
+	done := make(chan finished, len(steps))
+	for i, step := range steps {
+		go func(i int, step WarmupStep) {
+			start := time.Now()
+			err := step.Run(ctx)
+			if err == nil && ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			done <- finished{i, WarmupResult{Step: step.Name, Duration: time.Since(start), Err: err}}
+		}(i, step)
+	}
+
+	// Steps still running at the deadline are reported as timed out and
+	// left behind rather than waited for
+	results := make([]WarmupResult, len(steps))
+	for i, step := range steps {
+		results[i] = WarmupResult{Step: step.Name, Duration: timeout, Err: context.DeadlineExceeded}
+	}
+collect:
+	for pending := len(steps); pending > 0; pending-- {
+		select {
+		case f := <-done:
+			results[f.index] = f.result
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	failed := 0
+	for _, result := range results {
+		warmupDurationSeconds.WithLabelValues(result.Step).Set(result.Duration.Seconds())
+		if result.Err != nil {
+			failed++
+			warmupFailuresTotal.WithLabelValues(result.Step).Inc()
+			logger.Warn("Warmup step failed",
+				zap.String("step", result.Step),
+				zap.Duration("duration", result.Duration),
+				zap.Error(result.Err))
+		}
+	}
+	logger.Info("Warmup completed",
+		zap.Int("steps", len(steps)),
+		zap.Int("failed", failed),
+		zap.Duration("duration", time.Since(started)))
+	return results
+}
+
+// DatabaseWarmupStep opens up to connections database connections and runs
+// a lookup and a no-op query of each model on every one of them, parsing
+// the models' schemas and preparing the statements
+func DatabaseWarmupStep(db *gorm.DB, connections int, warmupModels ...interface{}) WarmupStep {
+	return WarmupStep{Name: "database", Run: func(ctx context.Context) error {
+		// Each transaction holds its connection throughout, so the queries
+		// warm distinct connections
+		return concurrently(connections, func() error {
+			return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				for _, model := range warmupModels {
+					if err := warmModel(tx, model); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	}}
+}
+
+// concurrently runs fn n times at once and returns the first error
+func concurrently(n int, fn func() error) error {
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errs <- fn() }()
+	}
+	var first error
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// warmModel looks up a row of a model by an ID no row has, and lists none
+func warmModel(tx *gorm.DB, model interface{}) error {
+	row := reflect.New(reflect.TypeOf(model).Elem()).Interface()
+	err := tx.First(row, "id = ?", warmupID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%T: %w", model, err)
+	}
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(model).Elem())).Interface()
+	if err := tx.Where("1 = 0").Limit(1).Find(rows).Error; err != nil {
+		return fmt.Errorf("%T: %w", model, err)
+	}
+	return nil
+}
+
+// RedisWarmupStep grows the Redis pool to its minimum idle connections by
+// holding that many pings at once
+func RedisWarmupStep(redisClient *redis.Client) WarmupStep {
+	return WarmupStep{Name: "redis", Run: func(ctx context.Context) error {
+		conns := redisClient.Options().MinIdleConns
+		if conns < 1 {
+			conns = 1
+		}
+		return concurrently(conns, func() error {
+			return redisClient.Ping(ctx).Err()
+		})
+	}}
+}
+
+// TemporalWarmupStep establishes the Temporal client's connection to the
+// frontend with a GetSystemInfo call
+func TemporalWarmupStep(temporalClient client.Client) WarmupStep {
+	return WarmupStep{Name: "temporal", Run: func(ctx context.Context) error {
+		_, err := temporalClient.WorkflowService().GetSystemInfo(ctx, &workflowservice.GetSystemInfoRequest{})
+		return err
+	}}
+}
+
+// WorkflowConfigWarmupStep compiles the field tables unknown config fields
+// are reported from, and decodes an empty config of every typed workflow
+// type, filling the JSON codecs of the config types
+func WorkflowConfigWarmupStep() WarmupStep {
+	return WarmupStep{Name: "workflow_configs", Run: func(ctx context.Context) error {
+		for workflowType, newConfig := range workflowConfigTypes {
+			compileConfigFields(reflect.TypeOf(newConfig()).Elem())
+			if _, _, err := NormalizeWorkflowConfig(workflowType, []byte(`{}`)); err != nil {
+				return fmt.Errorf("%s: %w", workflowType, err)
+			}
+		}
+		return nil
+	}}
+}
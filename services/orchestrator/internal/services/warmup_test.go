@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"orchestrator/internal/models"
+)
+
+func TestRunWarmup_FailuresDoNotBlock(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	stuck := make(chan struct{})
+	t.Cleanup(func() { close(stuck) })
+
+	steps := []WarmupStep{
+		{Name: "ok", Run: func(ctx context.Context) error { return nil }},
+		{Name: "failing", Run: func(ctx context.Context) error { return errors.New("connection refused") }},
+		// Ignores its context
+		{Name: "stuck", Run: func(ctx context.Context) error { <-stuck; return nil }},
+	}
+	started := time.Now()
+	results := RunWarmup(context.Background(), steps, 100*time.Millisecond, zap.New(core))
+	assert.Less(t, time.Since(started), time.Second, "warmup ends at its timeout")
+
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.EqualError(t, results[1].Err, "connection refused")
+	assert.ErrorIs(t, results[2].Err, context.DeadlineExceeded)
+	assert.Equal(t, 2, logs.FilterMessage("Warmup step failed").Len())
+	assert.Equal(t, 1, logs.FilterMessage("Warmup completed").Len())
+}
+
+func TestDatabaseWarmupStep(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.Create(&models.Workflow{Name: "existing", Type: models.WorkflowTypeExecution, ProjectID: "project-1"}).Error)
+
+	results := RunWarmup(context.Background(), []WarmupStep{
+		DatabaseWarmupStep(db, 1, &models.Workflow{}),
+		// Tables that do not exist fail the step, not the warmup
+		DatabaseWarmupStep(db, 1, &models.Project{}),
+	}, time.Second, zap.NewNop())
+	assert.NoError(t, results[0].Err)
+	require.Error(t, results[1].Err)
+	assert.Contains(t, results[1].Err.Error(), "*models.Project")
+
+	var count int64
+	require.NoError(t, db.Model(&models.Workflow{}).Count(&count).Error)
+	assert.EqualValues(t, 1, count, "warmup only reads")
+}
+
+func TestRedisWarmupStep_Unreachable(t *testing.T) {
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1, MinIdleConns: 2})
+	t.Cleanup(func() { redisClient.Close() })
+
+	results := RunWarmup(context.Background(), []WarmupStep{RedisWarmupStep(redisClient)}, time.Second, zap.NewNop())
+	assert.Error(t, results[0].Err)
+}
+
+func TestWorkflowConfigWarmupStep(t *testing.T) {
+	results := RunWarmup(context.Background(), []WarmupStep{WorkflowConfigWarmupStep()}, time.Second, zap.NewNop())
+	require.NoError(t, results[0].Err)
+
+	// The field tables of nested settings are compiled too
+	for _, settings := range []interface{}{SimulationSettings{}, ActivitySettings{}, RetryBudgetSettings{}} {
+		_, ok := configFields.Load(reflect.TypeOf(settings))
+		assert.True(t, ok, "%T", settings)
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/converter"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+// ActivityProgress is the structured heartbeat payload recorded by long
+// running activities
+type ActivityProgress struct {
+	Percent int    `json:"percent,omitempty"`
+	Step    string `json:"step"`
+	Message string `json:"message,omitempty"`
+}
+
+// PendingActivity describes an activity of a workflow that has not finished,
+// for diagnosing workflows that appear hung
+type PendingActivity struct {
+	ActivityID         string            `json:"activity_id"`
+	ActivityType       string            `json:"activity_type"`
+	State              string            `json:"state"`
+	Attempt            int32             `json:"attempt"`
+	MaximumAttempts    int32             `json:"maximum_attempts,omitempty"`
+	ScheduledTime      *time.Time        `json:"scheduled_time,omitempty"`
+	LastStartedTime    *time.Time        `json:"last_started_time,omitempty"`
+	QueueWaitMs        int64             `json:"queue_wait_ms"` // Scheduled until started, or until now while waiting for a worker
+	LastHeartbeatTime  *time.Time        `json:"last_heartbeat_time,omitempty"`
+	HeartbeatDetails   interface{}       `json:"heartbeat_details,omitempty"`
+	Progress           *ActivityProgress `json:"progress,omitempty"`
+	HeartbeatTimeout   float64           `json:"heartbeat_timeout_seconds,omitempty"`
+	LastFailure        string            `json:"last_failure,omitempty"`
+	LastWorkerIdentity string            `json:"last_worker_identity,omitempty"`
+	LikelyStuck        bool              `json:"likely_stuck"`
+}
+
+// GetPendingActivities reports the pending activities of a workflow's current
+// run with their heartbeat state. Activities that have not heartbeated within
+// their heartbeat timeout are flagged as likely stuck.
+func (e *WorkflowEngine) GetPendingActivities(ctx context.Context, workflowID string) ([]PendingActivity, error) {
+	var workflow models.Workflow
+	if err := e.db.WithContext(ctx).Select("id", "temporal_id", "temporal_run_id").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	if workflow.TemporalID == "" {
+		return []PendingActivity{}, nil
+	}
+
+	resp, err := e.temporalClient.DescribeWorkflowExecution(ctx, workflow.TemporalID, workflow.TemporalRunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe workflow execution: %w", err)
+	}
+	if len(resp.GetPendingActivities()) == 0 {
+		return []PendingActivity{}, nil
+	}
+
+	timeouts, err := e.activityHeartbeatTimeouts(ctx, workflow.TemporalID, workflow.TemporalRunID)
+	if err != nil {
+		// Still report what Describe returned, without stuck detection
+		e.logger.Warn("failed to read activity heartbeat timeouts",
+			zap.String("workflow_id", workflowID),
+			zap.Error(err))
+	}
+
+	now := time.Now()
+	activities := make([]PendingActivity, 0, len(resp.GetPendingActivities()))
+	for _, info := range resp.GetPendingActivities() {
+		activities = append(activities, newPendingActivity(info, timeouts[info.GetActivityId()], now))
+	}
+	return activities, nil
+}
+
+// activityHeartbeatTimeouts reads the heartbeat timeout each activity was
+// scheduled with from the workflow history, keyed by activity ID
+func (e *WorkflowEngine) activityHeartbeatTimeouts(ctx context.Context, temporalID, runID string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	iter := e.temporalClient.GetWorkflowHistory(ctx, temporalID, runID, false, enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			return timeouts, fmt.Errorf("failed to read workflow history: %w", err)
+		}
+		if attrs := event.GetActivityTaskScheduledEventAttributes(); attrs != nil && attrs.GetHeartbeatTimeout() != nil {
+			timeouts[attrs.GetActivityId()] = *attrs.GetHeartbeatTimeout()
+		}
+	}
+	return timeouts, nil
+}
+
+// newPendingActivity converts Temporal's pending activity info
+func newPendingActivity(info *workflowpb.PendingActivityInfo, heartbeatTimeout time.Duration, now time.Time) PendingActivity {
+	activity := PendingActivity{
+		ActivityID:         info.GetActivityId(),
+		ActivityType:       info.GetActivityType().GetName(),
+		State:              info.GetState().String(),
+		Attempt:            info.GetAttempt(),
+		MaximumAttempts:    info.GetMaximumAttempts(),
+		ScheduledTime:      info.GetScheduledTime(),
+		LastStartedTime:    info.GetLastStartedTime(),
+		LastHeartbeatTime:  info.GetLastHeartbeatTime(),
+		HeartbeatTimeout:   heartbeatTimeout.Seconds(),
+		LastFailure:        info.GetLastFailure().GetMessage(),
+		LastWorkerIdentity: info.GetLastWorkerIdentity(),
+	}
+	activity.HeartbeatDetails, activity.Progress = decodeHeartbeatDetails(info.GetHeartbeatDetails())
+	activity.QueueWaitMs = activityQueueWait(info, now)
+
+	// A started activity is likely stuck when it has been silent for longer
+	// than its heartbeat timeout
+	if heartbeatTimeout > 0 && info.GetState() == enumspb.PENDING_ACTIVITY_STATE_STARTED {
+		lastSign := activity.LastHeartbeatTime
+		if lastSign == nil {
+			lastSign = activity.LastStartedTime
+		}
+		activity.LikelyStuck = lastSign != nil && now.Sub(*lastSign) > heartbeatTimeout
+	}
+	return activity
+}
+
+// decodeHeartbeatDetails decodes heartbeat payloads. Structured progress is
+// returned separately; free-text heartbeats are returned as strings.
+func decodeHeartbeatDetails(payloads *commonpb.Payloads) (interface{}, *ActivityProgress) {
+	values := make([]interface{}, 0, len(payloads.GetPayloads()))
+	for _, payload := range payloads.GetPayloads() {
+		var value interface{}
+		if err := converter.GetDefaultDataConverter().FromPayload(payload, &value); err != nil {
+			value = fmt.Sprintf("<undecodable %s payload>", payload.GetMetadata()[converter.MetadataEncoding])
+		}
+		values = append(values, value)
+	}
+
+	var progress *ActivityProgress
+	if len(values) > 0 {
+		if fields, ok := values[len(values)-1].(map[string]interface{}); ok {
+			if data, err := json.Marshal(fields); err == nil {
+				var decoded ActivityProgress
+				if json.Unmarshal(data, &decoded) == nil && (decoded.Step != "" || decoded.Percent > 0) {
+					progress = &decoded
+				}
+			}
+		}
+	}
+
+	switch len(values) {
+	case 0:
+		return nil, progress
+	case 1:
+		return values[0], progress
+	default:
+		return values, progress
+	}
+}
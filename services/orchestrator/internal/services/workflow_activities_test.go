@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/failure/v1"
+	"go.temporal.io/api/history/v1"
+	"go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func heartbeatPayloads(t *testing.T, values ...interface{}) *common.Payloads {
+	payloads, err := converter.GetDefaultDataConverter().ToPayloads(values...)
+	require.NoError(t, err)
+	return payloads
+}
+
+func scheduledEvent(activityID string, heartbeatTimeout time.Duration) *history.HistoryEvent {
+	return &history.HistoryEvent{
+		EventType: enums.EVENT_TYPE_ACTIVITY_TASK_SCHEDULED,
+		Attributes: &history.HistoryEvent_ActivityTaskScheduledEventAttributes{
+			ActivityTaskScheduledEventAttributes: &history.ActivityTaskScheduledEventAttributes{
+				ActivityId:       activityID,
+				HeartbeatTimeout: &heartbeatTimeout,
+			},
+		},
+	}
+}
+
+func TestWorkflowEngine_GetPendingActivities(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+
+	tracked := &models.Workflow{
+		Name:          "Long code execution",
+		Type:          models.WorkflowTypeExecution,
+		Status:        models.WorkflowStatusRunning,
+		ProjectID:     "project-1",
+		TemporalID:    "temporal-1",
+		TemporalRunID: "run-1",
+	}
+	require.NoError(t, db.Create(tracked).Error)
+
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+
+	describe := describeResponse("temporal-1", "run-1", enums.WORKFLOW_EXECUTION_STATUS_RUNNING)
+	describe.PendingActivities = []*workflow.PendingActivityInfo{
+		{
+			// Silent for longer than its heartbeat timeout
+			ActivityId:        "5",
+			ActivityType:      &common.ActivityType{Name: "ExecuteCodeActivity"},
+			State:             enums.PENDING_ACTIVITY_STATE_STARTED,
+			Attempt:           2,
+			MaximumAttempts:   3,
+			ScheduledTime:     ago(10 * time.Minute),
+			LastStartedTime:   ago(9 * time.Minute),
+			LastHeartbeatTime: ago(8 * time.Minute),
+			HeartbeatDetails:  heartbeatPayloads(t, ActivityProgress{Percent: 10, Step: "executing_code", Message: "Running python code"}),
+			LastFailure:       &failure.Failure{Message: "agent connection reset"},
+		},
+		{
+			// Heartbeating within its timeout with a free-text payload
+			ActivityId:        "7",
+			ActivityType:      &common.ActivityType{Name: "ExecuteTaskWithAgentActivity"},
+			State:             enums.PENDING_ACTIVITY_STATE_STARTED,
+			Attempt:           1,
+			LastStartedTime:   ago(3 * time.Minute),
+			LastHeartbeatTime: ago(30 * time.Second),
+			HeartbeatDetails:  heartbeatPayloads(t, "Task 1 sent to agent"),
+		},
+		{
+			// Waiting for a retry is not stuck, however long ago it started
+			ActivityId:      "9",
+			ActivityType:    &common.ActivityType{Name: "PrepareEnvironmentActivity"},
+			State:           enums.PENDING_ACTIVITY_STATE_SCHEDULED,
+			Attempt:         3,
+			LastStartedTime: ago(time.Hour),
+		},
+	}
+
+	iter := new(mocks.HistoryEventIterator)
+	events := []*history.HistoryEvent{
+		scheduledEvent("5", time.Minute),
+		scheduledEvent("7", 2*time.Minute),
+		scheduledEvent("9", 30*time.Second),
+	}
+	for _, event := range events {
+		iter.On("HasNext").Return(true).Once()
+		iter.On("Next").Return(event, nil).Once()
+	}
+	iter.On("HasNext").Return(false)
+
+	temporalClient := new(mocks.Client)
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "temporal-1", "run-1").Return(describe, nil)
+	temporalClient.On("GetWorkflowHistory", mock.Anything, "temporal-1", "run-1", false,
+		enums.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT).Return(iter)
+
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	engine := NewWorkflowEngine(db, redisClient, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	activities, err := engine.GetPendingActivities(context.Background(), tracked.ID)
+	require.NoError(t, err)
+	require.Len(t, activities, 3)
+
+	stuck := activities[0]
+	assert.Equal(t, "ExecuteCodeActivity", stuck.ActivityType)
+	assert.Equal(t, "Started", stuck.State)
+	assert.Equal(t, int32(2), stuck.Attempt)
+	assert.Equal(t, float64(60), stuck.HeartbeatTimeout)
+	assert.Equal(t, "agent connection reset", stuck.LastFailure)
+	assert.True(t, stuck.LikelyStuck)
+	assert.Equal(t, int64(60000), stuck.QueueWaitMs)
+	require.NotNil(t, stuck.Progress)
+	assert.Equal(t, ActivityProgress{Percent: 10, Step: "executing_code", Message: "Running python code"}, *stuck.Progress)
+	assert.Equal(t, map[string]interface{}{"percent": float64(10), "step": "executing_code", "message": "Running python code"},
+		stuck.HeartbeatDetails)
+
+	healthy := activities[1]
+	assert.False(t, healthy.LikelyStuck)
+	assert.Equal(t, "Task 1 sent to agent", healthy.HeartbeatDetails)
+	assert.Nil(t, healthy.Progress)
+
+	retrying := activities[2]
+	assert.False(t, retrying.LikelyStuck)
+	assert.Zero(t, retrying.QueueWaitMs)
+	assert.Nil(t, retrying.HeartbeatDetails)
+
+	temporalClient.AssertExpectations(t)
+}
+
+func TestWorkflowEngine_GetPendingActivitiesWithoutExecution(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+
+	pending := &models.Workflow{
+		Name:      "Not yet submitted",
+		Type:      models.WorkflowTypeExecution,
+		Status:    models.WorkflowStatusPending,
+		ProjectID: "project-1",
+	}
+	require.NoError(t, db.Create(pending).Error)
+
+	temporalClient := new(mocks.Client)
+	engine := NewWorkflowEngine(db, nil, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	activities, err := engine.GetPendingActivities(context.Background(), pending.ID)
+	require.NoError(t, err)
+	assert.Empty(t, activities)
+
+	_, err = engine.GetPendingActivities(context.Background(), "missing")
+	assert.Error(t, err)
+	temporalClient.AssertNotCalled(t, "DescribeWorkflowExecution", mock.Anything, mock.Anything, mock.Anything)
+}
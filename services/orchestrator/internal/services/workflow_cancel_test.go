@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func TestWorkflowEngine_CancelRecordsReasonAndActor(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newTimingTestEngine(t, temporalClient)
+	temporalClient.On("CancelWorkflow", mock.Anything, "temporal-1", "run-1").Return(nil).Once()
+
+	workflow := &models.Workflow{Name: "build", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusRunning,
+		ProjectID: "project-1", TemporalID: "temporal-1", TemporalRunID: "run-1", CreatedBy: "alice"}
+	require.NoError(t, db.Create(workflow).Error)
+
+	require.NoError(t, engine.CancelWorkflow(ctx, workflow.ID, "superseded by a newer build", "bob"))
+	temporalClient.AssertExpectations(t)
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.Equal(t, models.WorkflowStatusCancelled, stored.Status)
+	assert.Equal(t, "superseded by a newer build", stored.CancelledReason)
+	assert.Equal(t, "bob", stored.CancelledBy)
+	assert.Equal(t, "bob", stored.UpdatedBy)
+	require.NotNil(t, stored.CancelledAt)
+	assert.Equal(t, stored.CompletedAt.UnixNano(), stored.CancelledAt.UnixNano())
+	assert.Empty(t, stored.Error, "a cancellation is not a failure")
+	assert.Empty(t, stored.ErrorClass)
+
+	summaries, _, err := engine.ListWorkflowSummaries(ctx, &WorkflowFilters{ProjectID: "project-1", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "superseded by a newer build", summaries[0].CancelledReason)
+	assert.Equal(t, "bob", summaries[0].CancelledBy)
+	assert.NotNil(t, summaries[0].CancelledAt)
+}
+
+func TestWorkflowMonitor_PreservesCancellation(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	epoch := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	byUser := &models.Workflow{Name: "cancelled through the API", Type: models.WorkflowTypeCustom,
+		Status: models.WorkflowStatusRunning, ProjectID: "test-project-id", TemporalID: "api-1", TemporalRunID: "run-1", StartedAt: &epoch}
+	external := &models.Workflow{Name: "cancelled from the Temporal UI", Type: models.WorkflowTypeCustom,
+		Status: models.WorkflowStatusRunning, ProjectID: "test-project-id", TemporalID: "ui-1", TemporalRunID: "run-1", StartedAt: &epoch}
+	for _, workflow := range []*models.Workflow{byUser, external} {
+		require.NoError(t, db.Create(workflow).Error)
+	}
+
+	cancelled := func(workflowID string) *workflowservice.DescribeWorkflowExecutionResponse {
+		resp := describeResponse(workflowID, "run-1", enums.WORKFLOW_EXECUTION_STATUS_CANCELED)
+		closed := epoch.Add(time.Minute)
+		resp.WorkflowExecutionInfo.CloseTime = &closed
+		return resp
+	}
+	cancelledAt := epoch.Add(50 * time.Second)
+	temporalClient := &mocks.Client{}
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "api-1", "run-1").
+		Run(func(mock.Arguments) {
+			// CancelWorkflow records the cancellation after the monitor
+			// loaded the workflow as running
+			require.NoError(t, db.Model(&models.Workflow{}).Where("id = ?", byUser.ID).Updates(map[string]interface{}{
+				"status": models.WorkflowStatusCancelled, "cancelled_reason": "no longer needed",
+				"cancelled_by": "alice", "cancelled_at": cancelledAt,
+			}).Error)
+		}).
+		Return(cancelled("api-1"), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "ui-1", "run-1").
+		Return(cancelled("ui-1"), nil).Once()
+
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+	monitor.checkWorkflows()
+	temporalClient.AssertExpectations(t)
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", byUser.ID).Error)
+	assert.Equal(t, models.WorkflowStatusCancelled, stored.Status)
+	assert.Equal(t, "no longer needed", stored.CancelledReason)
+	assert.Equal(t, "alice", stored.CancelledBy)
+	require.NotNil(t, stored.CancelledAt)
+	assert.True(t, stored.CancelledAt.Equal(cancelledAt))
+	assert.Empty(t, stored.Error)
+
+	var outside models.Workflow
+	require.NoError(t, db.First(&outside, "id = ?", external.ID).Error)
+	assert.Equal(t, models.WorkflowStatusCancelled, outside.Status)
+	assert.Equal(t, externalCancelReason, outside.CancelledReason)
+	assert.Empty(t, outside.CancelledBy, "the actor is unknown")
+	require.NotNil(t, outside.CancelledAt)
+	assert.True(t, outside.CancelledAt.Equal(epoch.Add(time.Minute)), "cancelled when Temporal closed the run")
+	assert.Empty(t, outside.Error, "a cancellation is not a failure")
+}
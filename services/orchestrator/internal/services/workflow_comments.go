@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrInvalidComment is returned for comments with an empty or oversized
+	// body
+	ErrInvalidComment = errors.New("invalid comment")
+	// ErrCommentForbidden is returned when someone other than the author or
+	// a project admin changes a comment
+	ErrCommentForbidden = errors.New("only the author or a project admin may change the comment")
+)
+
+// MaxCommentBodyLength is the longest comment body, in characters
+const MaxCommentBodyLength = 10000
+
+// Comment pages hold 50 comments unless the request asks for fewer, and at
+// most 200
+const (
+	defaultCommentPageSize = 50
+	maxCommentPageSize     = 200
+)
+
+// projectAdminRoles are the project member roles that may change any
+// comment on the project's workflows
+var projectAdminRoles = []string{"owner", "admin"}
+
+// workflowMentionPattern matches workflow mentions, wf:<uuid>
+var workflowMentionPattern = regexp.MustCompile(`\bwf:([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})\b`)
+
+// ParseWorkflowMentions returns the workflows a comment body mentions as
+// wf:<uuid>, in order of first mention
+func ParseWorkflowMentions(body string) []models.WorkflowMention {
+	var mentions []models.WorkflowMention
+	seen := make(map[string]bool)
+	for _, match := range workflowMentionPattern.FindAllStringSubmatch(body, -1) {
+		workflowID := strings.ToLower(match[1])
+		if seen[workflowID] {
+			continue
+		}
+		seen[workflowID] = true
+		mentions = append(mentions, models.WorkflowMention{
+			WorkflowID: workflowID,
+			Link:       "/api/v1/workflows/" + workflowID,
+		})
+	}
+	return mentions
+}
+
+// CreateCommentRequest is a new comment on a workflow
+type CreateCommentRequest struct {
+	Body   string `json:"body" binding:"required"`
+	Pinned bool   `json:"pinned"`
+}
+
+// UpdateCommentRequest changes the body or the pinned flag of a comment;
+// fields left out are kept
+type UpdateCommentRequest struct {
+	Body   *string `json:"body"`
+	Pinned *bool   `json:"pinned"`
+}
+
+// CommentList is a page of the comments on a workflow
+type CommentList struct {
+	Comments []*models.WorkflowComment `json:"comments"`
+	Total    int64                     `json:"total"`
+	Limit    int                       `json:"limit"`
+	Offset   int                       `json:"offset"`
+}
+
+// WorkflowCommentService stores operator notes on workflows
+type WorkflowCommentService struct {
+	db     *gorm.DB
+	logger *zap.Logger
+}
+
+// NewWorkflowCommentService creates a comment service
+func NewWorkflowCommentService(db *gorm.DB, logger *zap.Logger) *WorkflowCommentService {
+	return &WorkflowCommentService{db: db, logger: logger}
+}
+
+// CreateComment adds a comment by author to a workflow. It returns
+// gorm.ErrRecordNotFound for unknown workflows.
+func (s *WorkflowCommentService) CreateComment(ctx context.Context, workflowID, author string, req *CreateCommentRequest) (*models.WorkflowComment, error) {
+	if err := validateCommentBody(req.Body); err != nil {
+		return nil, err
+	}
+	if _, err := s.getWorkflow(ctx, workflowID); err != nil {
+		return nil, err
+	}
+
+	comment := &models.WorkflowComment{
+		WorkflowID: workflowID,
+		Author:     author,
+		Body:       req.Body,
+		Pinned:     req.Pinned,
+	}
+	if err := s.db.WithContext(ctx).Create(comment).Error; err != nil {
+		return nil, fmt.Errorf("failed to create comment: %w", err)
+	}
+	comment.Mentions = ParseWorkflowMentions(comment.Body)
+
+	s.logger.Info("Workflow comment added",
+		zap.String("workflow_id", workflowID),
+		zap.String("comment_id", comment.ID),
+		zap.String("author", author))
+	return comment, nil
+}
+
+// ListComments returns a page of the comments on a workflow, pinned
+// comments first, then newest first
+func (s *WorkflowCommentService) ListComments(ctx context.Context, workflowID string, limit, offset int) (*CommentList, error) {
+	if _, err := s.getWorkflow(ctx, workflowID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultCommentPageSize
+	}
+	if limit > maxCommentPageSize {
+		limit = maxCommentPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	query := s.db.WithContext(ctx).Model(&models.WorkflowComment{}).Where("workflow_id = ?", workflowID)
+	list := &CommentList{Comments: []*models.WorkflowComment{}, Limit: limit, Offset: offset}
+	if err := query.Count(&list.Total).Error; err != nil {
+		return nil, fmt.Errorf("failed to count comments: %w", err)
+	}
+	if err := query.Order("pinned DESC, created_at DESC, id").Limit(limit).Offset(offset).
+		Find(&list.Comments).Error; err != nil {
+		return nil, fmt.Errorf("failed to list comments: %w", err)
+	}
+	for _, comment := range list.Comments {
+		comment.Mentions = ParseWorkflowMentions(comment.Body)
+	}
+	return list, nil
+}
+
+// CountComments returns the number of comments on a workflow
+func (s *WorkflowCommentService) CountComments(ctx context.Context, workflowID string) (int64, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&models.WorkflowComment{}).
+		Where("workflow_id = ?", workflowID).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count comments: %w", err)
+	}
+	return count, nil
+}
+
+// UpdateComment changes a comment on behalf of actor, who must be its
+// author or an admin of the workflow's project
+func (s *WorkflowCommentService) UpdateComment(ctx context.Context, workflowID, commentID, actor string, req *UpdateCommentRequest) (*models.WorkflowComment, error) {
+	comment, err := s.authorize(ctx, workflowID, commentID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil {
+		if err := validateCommentBody(*req.Body); err != nil {
+			return nil, err
+		}
+		comment.Body = *req.Body
+	}
+	if req.Pinned != nil {
+		comment.Pinned = *req.Pinned
+	}
+	if err := s.db.WithContext(ctx).Model(comment).Select("body", "pinned", "updated_at").
+		Updates(comment).Error; err != nil {
+		return nil, fmt.Errorf("failed to update comment: %w", err)
+	}
+	comment.Mentions = ParseWorkflowMentions(comment.Body)
+	return comment, nil
+}
+
+// DeleteComment deletes a comment on behalf of actor, who must be its
+// author or an admin of the workflow's project
+func (s *WorkflowCommentService) DeleteComment(ctx context.Context, workflowID, commentID, actor string) error {
+	comment, err := s.authorize(ctx, workflowID, commentID, actor)
+	if err != nil {
+		return err
+	}
+	if err := s.db.WithContext(ctx).Delete(comment).Error; err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+
+	s.logger.Info("Workflow comment deleted",
+		zap.String("workflow_id", workflowID),
+		zap.String("comment_id", commentID),
+		zap.String("actor", actor))
+	return nil
+}
+
+// authorize returns a comment of a workflow that actor may change
+func (s *WorkflowCommentService) authorize(ctx context.Context, workflowID, commentID, actor string) (*models.WorkflowComment, error) {
+	var comment models.WorkflowComment
+	if err := s.db.WithContext(ctx).Where("id = ? AND workflow_id = ?", commentID, workflowID).
+		First(&comment).Error; err != nil {
+		return nil, fmt.Errorf("comment not found: %w", err)
+	}
+	if comment.Author == actor {
+		return &comment, nil
+	}
+
+	workflow, err := s.getWorkflow(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	admin, err := s.isProjectAdmin(ctx, workflow.ProjectID, actor)
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, ErrCommentForbidden
+	}
+	return &comment, nil
+}
+
+// isProjectAdmin reports whether a user owns a project or is one of its
+// admins
+func (s *WorkflowCommentService) isProjectAdmin(ctx context.Context, projectID, userID string) (bool, error) {
+	if projectID == "" {
+		return false, nil
+	}
+	var owners int64
+	if err := s.db.WithContext(ctx).Model(&models.Project{}).
+		Where("id = ? AND owner_id = ?", projectID, userID).Count(&owners).Error; err != nil {
+		return false, fmt.Errorf("failed to get project owner: %w", err)
+	}
+	if owners > 0 {
+		return true, nil
+	}
+	var admins int64
+	if err := s.db.WithContext(ctx).Model(&models.ProjectMember{}).
+		Where("project_id = ? AND user_id = ? AND role IN ?", projectID, userID, projectAdminRoles).
+		Count(&admins).Error; err != nil {
+		return false, fmt.Errorf("failed to get project member: %w", err)
+	}
+	return admins > 0, nil
+}
+
+func (s *WorkflowCommentService) getWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error) {
+	var workflow models.Workflow
+	if err := s.db.WithContext(ctx).Select("id", "project_id").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	return &workflow, nil
+}
+
+func validateCommentBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return fmt.Errorf("%w: body must not be empty", ErrInvalidComment)
+	}
+	if utf8.RuneCountInString(body) > MaxCommentBodyLength {
+		return fmt.Errorf("%w: body exceeds %d characters", ErrInvalidComment, MaxCommentBodyLength)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// setupCommentTest stores a workflow of project-1, which is owned by
+// owner-1 and has admin-1 as an admin and viewer-1 as a viewer
+func setupCommentTest(t *testing.T) (*WorkflowCommentService, *gorm.DB, *models.Workflow) {
+	db := setupWorkflowTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_comments (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), workflow_id TEXT,
+			author TEXT, body TEXT, pinned BOOLEAN DEFAULT false, created_at DATETIME, updated_at DATETIME)`,
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, owner_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE project_members (id TEXT PRIMARY KEY, project_id TEXT, user_id TEXT, role TEXT, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, owner_id) VALUES ('project-1', 'owner-1')`,
+		`INSERT INTO project_members (id, project_id, user_id, role) VALUES
+			('member-1', 'project-1', 'admin-1', 'admin'), ('member-2', 'project-1', 'viewer-1', 'viewer')`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	workflow := &models.Workflow{Name: "deploy", Type: models.WorkflowTypeExecution, ProjectID: "project-1"}
+	require.NoError(t, db.Create(workflow).Error)
+	return NewWorkflowCommentService(db, zap.NewNop()), db, workflow
+}
+
+func TestWorkflowCommentService_CreateComment(t *testing.T) {
+	comments, _, workflow := setupCommentTest(t)
+	ctx := context.Background()
+
+	comment, err := comments.CreateComment(ctx, workflow.ID, "alice", &CreateCommentRequest{
+		Body: "Same failure as wf:3F2B8C1E-1A2B-4C3D-8E9F-0A1B2C3D4E5F, rerunning",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, comment.ID)
+	assert.Equal(t, "alice", comment.Author)
+	assert.Equal(t, []models.WorkflowMention{{
+		WorkflowID: "3f2b8c1e-1a2b-4c3d-8e9f-0a1b2c3d4e5f",
+		Link:       "/api/v1/workflows/3f2b8c1e-1a2b-4c3d-8e9f-0a1b2c3d4e5f",
+	}}, comment.Mentions)
+
+	_, err = comments.CreateComment(ctx, workflow.ID, "alice", &CreateCommentRequest{Body: "  \n"})
+	assert.ErrorIs(t, err, ErrInvalidComment)
+	_, err = comments.CreateComment(ctx, workflow.ID, "alice", &CreateCommentRequest{Body: strings.Repeat("é", MaxCommentBodyLength+1)})
+	assert.ErrorIs(t, err, ErrInvalidComment)
+	_, err = comments.CreateComment(ctx, "00000000-0000-0000-0000-000000000099", "alice", &CreateCommentRequest{Body: "hello"})
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	count, err := comments.CountComments(ctx, workflow.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestWorkflowCommentService_ListComments(t *testing.T) {
+	comments, db, workflow := setupCommentTest(t)
+	ctx := context.Background()
+
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, comment := range []models.WorkflowComment{
+		{ID: "comment-1", Body: "first"},
+		{ID: "comment-2", Body: "pinned early", Pinned: true},
+		{ID: "comment-3", Body: "second"},
+		{ID: "comment-4", Body: "pinned late", Pinned: true},
+		{ID: "comment-5", Body: "third"},
+	} {
+		comment.WorkflowID = workflow.ID
+		comment.Author = "alice"
+		comment.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, db.Create(&comment).Error)
+	}
+
+	ids := func(list *CommentList) []string {
+		var ids []string
+		for _, comment := range list.Comments {
+			ids = append(ids, comment.ID)
+		}
+		return ids
+	}
+
+	list, err := comments.ListComments(ctx, workflow.ID, 3, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), list.Total)
+	assert.Equal(t, []string{"comment-4", "comment-2", "comment-5"}, ids(list))
+
+	list, err = comments.ListComments(ctx, workflow.ID, 3, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"comment-3", "comment-1"}, ids(list))
+
+	list, err = comments.ListComments(ctx, workflow.ID, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, defaultCommentPageSize, list.Limit)
+	assert.Len(t, list.Comments, 5)
+}
+
+func TestWorkflowCommentService_Permissions(t *testing.T) {
+	tests := []struct {
+		actor   string
+		allowed bool
+	}{
+		{"alice", true},     // Author
+		{"owner-1", true},   // Project owner
+		{"admin-1", true},   // Project admin
+		{"viewer-1", false}, // Project viewer
+		{"mallory", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.actor, func(t *testing.T) {
+			comments, db, workflow := setupCommentTest(t)
+			ctx := context.Background()
+			comment, err := comments.CreateComment(ctx, workflow.ID, "alice", &CreateCommentRequest{Body: "draft"})
+			require.NoError(t, err)
+
+			body, pinned := "edited", true
+			updated, err := comments.UpdateComment(ctx, workflow.ID, comment.ID, tt.actor, &UpdateCommentRequest{Body: &body, Pinned: &pinned})
+			if !tt.allowed {
+				assert.ErrorIs(t, err, ErrCommentForbidden)
+				assert.ErrorIs(t, comments.DeleteComment(ctx, workflow.ID, comment.ID, tt.actor), ErrCommentForbidden)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "edited", updated.Body)
+			assert.True(t, updated.Pinned)
+			assert.Equal(t, "alice", updated.Author)
+
+			var stored models.WorkflowComment
+			require.NoError(t, db.First(&stored, "id = ?", comment.ID).Error)
+			assert.Equal(t, "edited", stored.Body)
+			assert.True(t, stored.Pinned)
+
+			require.NoError(t, comments.DeleteComment(ctx, workflow.ID, comment.ID, tt.actor))
+			assert.ErrorIs(t, db.First(&stored, "id = ?", comment.ID).Error, gorm.ErrRecordNotFound)
+		})
+	}
+}
+
+func TestWorkflowCommentService_UpdateComment(t *testing.T) {
+	comments, _, workflow := setupCommentTest(t)
+	ctx := context.Background()
+	comment, err := comments.CreateComment(ctx, workflow.ID, "alice", &CreateCommentRequest{Body: "draft"})
+	require.NoError(t, err)
+
+	empty := ""
+	_, err = comments.UpdateComment(ctx, workflow.ID, comment.ID, "alice", &UpdateCommentRequest{Body: &empty})
+	assert.ErrorIs(t, err, ErrInvalidComment)
+
+	// A comment is only reachable through its own workflow
+	_, err = comments.UpdateComment(ctx, "00000000-0000-0000-0000-000000000099", comment.ID, "alice", &UpdateCommentRequest{})
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestParseWorkflowMentions(t *testing.T) {
+	const id = "3f2b8c1e-1a2b-4c3d-8e9f-0a1b2c3d4e5f"
+	const other = "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+
+	assert.Empty(t, ParseWorkflowMentions("no mentions, wf:not-a-uuid, xwf:"+id+"x"))
+	assert.Equal(t, []models.WorkflowMention{
+		{WorkflowID: id, Link: "/api/v1/workflows/" + id},
+		{WorkflowID: other, Link: "/api/v1/workflows/" + other},
+	}, ParseWorkflowMentions("see wf:"+id+" and (wf:"+other+"), again wf:"+id))
+}
@@ -0,0 +1,502 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// ErrWorkflowsNotComparable is returned when comparing workflows of
+// different projects or types
+var ErrWorkflowsNotComparable = errors.New("workflows are not comparable")
+
+// Kinds of differences between two workflow runs
+const (
+	ChangeAdded     = "added"
+	ChangeRemoved   = "removed"
+	ChangeChanged   = "changed"
+	ChangeUnchanged = "unchanged"
+)
+
+// stepSlowdownTolerance is the fraction a step's duration may grow or shrink
+// by before it counts as slower or faster
+const stepSlowdownTolerance = 0.1
+
+// ComparisonLimits caps the entries of each comparison section, like
+// ReportLimits does for reports
+type ComparisonLimits struct {
+	InputChanges int
+	Steps        int
+	Artifacts    int
+	Errors       int
+	ValueLen     int // Runes of each compared value or error message
+}
+
+// DefaultComparisonLimits are the section limits of workflow comparisons
+var DefaultComparisonLimits = ComparisonLimits{InputChanges: 100, Steps: 200, Artifacts: 100, Errors: 50, ValueLen: 200}
+
+// WorkflowComparison is the difference between a workflow run and another
+// run of the same project and type
+type WorkflowComparison struct {
+	Base      ComparedWorkflow              `json:"base"`
+	Other     ComparedWorkflow              `json:"other"`
+	Summary   ComparisonSummary             `json:"summary"`
+	Input     ReportSection[ValueChange]    `json:"input"`
+	Steps     ReportSection[StepComparison] `json:"steps"`
+	Artifacts ReportSection[ArtifactChange] `json:"artifacts"`
+	Errors    ReportSection[ErrorChange]    `json:"errors"`
+}
+
+// ComparedWorkflow identifies one side of a comparison
+type ComparedWorkflow struct {
+	ID        string                `json:"id"`
+	Name      string                `json:"name"`
+	Status    models.WorkflowStatus `json:"status"`
+	CreatedAt time.Time             `json:"created_at"`
+	Duration  int64                 `json:"duration_ms"`
+}
+
+// ComparisonSummary counts the differences between two runs. Runs are
+// identical when their input, step outcomes, artifacts and errors match;
+// durations may differ.
+type ComparisonSummary struct {
+	Identical        bool  `json:"identical"`
+	DurationDelta    int64 `json:"duration_delta_ms"`
+	InputChanges     int   `json:"input_changes"`
+	StepsSlower      int   `json:"steps_slower"`
+	StepsFaster      int   `json:"steps_faster"`
+	StepsAdded       int   `json:"steps_added"`
+	StepsRemoved     int   `json:"steps_removed"`
+	StatusChanges    int   `json:"status_changes"`
+	NewFailures      int   `json:"new_failures"`
+	ResolvedFailures int   `json:"resolved_failures"`
+	ArtifactsAdded   int   `json:"artifacts_added"`
+	ArtifactsRemoved int   `json:"artifacts_removed"`
+	ArtifactsChanged int   `json:"artifacts_changed"`
+	ErrorChanges     int   `json:"error_changes"`
+}
+
+// ValueChange is a difference between the inputs of two runs. Values are
+// compact JSON.
+type ValueChange struct {
+	Path   string `json:"path"` // e.g. $.files[2].name
+	Change string `json:"change"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// StepComparison compares the runs of a step, aligned by step name
+type StepComparison struct {
+	Name           string                `json:"name"`
+	Change         string                `json:"change"`
+	BeforeStatus   models.WorkflowStatus `json:"before_status,omitempty"`
+	AfterStatus    models.WorkflowStatus `json:"after_status,omitempty"`
+	BeforeDuration int64                 `json:"before_duration_ms"`
+	AfterDuration  int64                 `json:"after_duration_ms"`
+	DurationDelta  int64                 `json:"duration_delta_ms"`
+}
+
+// ArtifactChange is an artifact produced by only one run, or by both with
+// different content
+type ArtifactChange struct {
+	Name           string `json:"name"`
+	Change         string `json:"change"`
+	BeforeChecksum string `json:"before_checksum,omitempty"`
+	AfterChecksum  string `json:"after_checksum,omitempty"`
+	BeforeSize     int64  `json:"before_size"`
+	AfterSize      int64  `json:"after_size"`
+}
+
+// ErrorChange is an error of the workflow or a step that differs between
+// the runs
+type ErrorChange struct {
+	Source   string `json:"source"` // workflow or step
+	Activity string `json:"activity"`
+	Change   string `json:"change"`
+	Before   string `json:"before,omitempty"`
+	After    string `json:"after,omitempty"`
+}
+
+// WorkflowComparer compares stored workflow runs
+type WorkflowComparer struct {
+	db     *gorm.DB
+	limits ComparisonLimits
+}
+
+// NewWorkflowComparer creates a comparer with the default limits
+func NewWorkflowComparer(db *gorm.DB) *WorkflowComparer {
+	return &WorkflowComparer{db: db, limits: DefaultComparisonLimits}
+}
+
+// comparedRun is a workflow with the steps and artifacts of its run
+type comparedRun struct {
+	workflow  models.Workflow
+	steps     []models.WorkflowStep
+	artifacts []models.Artifact
+}
+
+// Compare diffs the run of workflowID against the run of otherID. It returns
+// gorm.ErrRecordNotFound for unknown workflows and ErrWorkflowsNotComparable
+// when they belong to different projects or are of different types.
+func (c *WorkflowComparer) Compare(ctx context.Context, workflowID, otherID string) (*WorkflowComparison, error) {
+	base, err := c.load(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	other, err := c.load(ctx, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if base.workflow.ProjectID != other.workflow.ProjectID {
+		return nil, fmt.Errorf("%w: they belong to different projects", ErrWorkflowsNotComparable)
+	}
+	if base.workflow.Type != other.workflow.Type {
+		return nil, fmt.Errorf("%w: %s and %s workflows", ErrWorkflowsNotComparable, base.workflow.Type, other.workflow.Type)
+	}
+
+	comparison := &WorkflowComparison{
+		Base:  comparedWorkflow(base.workflow),
+		Other: comparedWorkflow(other.workflow),
+	}
+	summary := &comparison.Summary
+	summary.DurationDelta = other.workflow.Duration - base.workflow.Duration
+
+	var inputChanges []ValueChange
+	diffJSON("$", decodeJSON(base.workflow.Input), decodeJSON(other.workflow.Input), &inputChanges)
+	for i := range inputChanges {
+		inputChanges[i].Before = truncateRunes(inputChanges[i].Before, c.limits.ValueLen)
+		inputChanges[i].After = truncateRunes(inputChanges[i].After, c.limits.ValueLen)
+	}
+	summary.InputChanges = len(inputChanges)
+
+	steps := compareSteps(base.steps, other.steps, summary)
+	artifacts := compareArtifacts(runArtifacts(base), runArtifacts(other), summary)
+
+	errorChanges := compareErrors(base, other)
+	for i := range errorChanges {
+		errorChanges[i].Before = truncateRunes(errorChanges[i].Before, c.limits.ValueLen)
+		errorChanges[i].After = truncateRunes(errorChanges[i].After, c.limits.ValueLen)
+	}
+	summary.ErrorChanges = len(errorChanges)
+
+	summary.Identical = summary.InputChanges == 0 && summary.StepsAdded == 0 && summary.StepsRemoved == 0 &&
+		summary.StatusChanges == 0 && summary.ArtifactsAdded == 0 && summary.ArtifactsRemoved == 0 &&
+		summary.ArtifactsChanged == 0 && summary.ErrorChanges == 0 &&
+		base.workflow.Status == other.workflow.Status
+
+	comparison.Input = newReportSection(inputChanges, c.limits.InputChanges)
+	comparison.Steps = newReportSection(steps, c.limits.Steps)
+	comparison.Artifacts = newReportSection(artifacts, c.limits.Artifacts)
+	comparison.Errors = newReportSection(errorChanges, c.limits.Errors)
+	return comparison, nil
+}
+
+func (c *WorkflowComparer) load(ctx context.Context, workflowID string) (*comparedRun, error) {
+	db := c.db.WithContext(ctx)
+	run := &comparedRun{}
+	if err := db.Where("id = ?", workflowID).First(&run.workflow).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	if err := db.Where("workflow_id = ?", workflowID).Order(`"order"`).Find(&run.steps).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow steps: %w", err)
+	}
+
+	var executionIDs []string
+	if err := db.Model(&models.Execution{}).Where("workflow_id = ?", workflowID).
+		Pluck("id", &executionIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get executions: %w", err)
+	}
+	if len(executionIDs) > 0 {
+		if err := db.Where("execution_id IN ?", executionIDs).Order("created_at").Find(&run.artifacts).Error; err != nil {
+			return nil, fmt.Errorf("failed to get artifacts: %w", err)
+		}
+	}
+	return run, nil
+}
+
+func comparedWorkflow(workflow models.Workflow) ComparedWorkflow {
+	return ComparedWorkflow{
+		ID:        workflow.ID,
+		Name:      workflow.Name,
+		Status:    workflow.Status,
+		CreatedAt: workflow.CreatedAt,
+		Duration:  workflow.Duration,
+	}
+}
+
+// stepKeys aligns steps by name; repeated names are told apart by occurrence
+func stepKeys(steps []models.WorkflowStep) []string {
+	seen := make(map[string]int, len(steps))
+	keys := make([]string, len(steps))
+	for i, step := range steps {
+		seen[step.Name]++
+		keys[i] = step.Name
+		if n := seen[step.Name]; n > 1 {
+			keys[i] = fmt.Sprintf("%s#%d", step.Name, n)
+		}
+	}
+	return keys
+}
+
+// compareSteps aligns the steps of both runs by name, in the base run's
+// order followed by the steps only the other run has
+func compareSteps(base, other []models.WorkflowStep, summary *ComparisonSummary) []StepComparison {
+	baseKeys, otherKeys := stepKeys(base), stepKeys(other)
+	otherByKey := make(map[string]*models.WorkflowStep, len(other))
+	for i := range other {
+		otherByKey[otherKeys[i]] = &other[i]
+	}
+
+	comparisons := make([]StepComparison, 0, len(base))
+	matched := make(map[string]bool, len(base))
+	for i, before := range base {
+		after, ok := otherByKey[baseKeys[i]]
+		if !ok {
+			summary.StepsRemoved++
+			if before.Status == models.WorkflowStatusFailed {
+				summary.ResolvedFailures++
+			}
+			comparisons = append(comparisons, StepComparison{
+				Name:           before.Name,
+				Change:         ChangeRemoved,
+				BeforeStatus:   before.Status,
+				BeforeDuration: before.Duration,
+			})
+			continue
+		}
+		matched[baseKeys[i]] = true
+
+		comparison := StepComparison{
+			Name:           before.Name,
+			Change:         ChangeUnchanged,
+			BeforeStatus:   before.Status,
+			AfterStatus:    after.Status,
+			BeforeDuration: before.Duration,
+			AfterDuration:  after.Duration,
+			DurationDelta:  after.Duration - before.Duration,
+		}
+		if before.Status != after.Status || before.Error != after.Error {
+			comparison.Change = ChangeChanged
+		}
+		if before.Status != after.Status {
+			summary.StatusChanges++
+			switch {
+			case after.Status == models.WorkflowStatusFailed:
+				summary.NewFailures++
+			case before.Status == models.WorkflowStatusFailed:
+				summary.ResolvedFailures++
+			}
+		}
+		tolerance := int64(float64(before.Duration) * stepSlowdownTolerance)
+		switch {
+		case comparison.DurationDelta > tolerance:
+			summary.StepsSlower++
+		case comparison.DurationDelta < -tolerance:
+			summary.StepsFaster++
+		}
+		comparisons = append(comparisons, comparison)
+	}
+
+	for i, after := range other {
+		if matched[otherKeys[i]] {
+			continue
+		}
+		summary.StepsAdded++
+		if after.Status == models.WorkflowStatusFailed {
+			summary.NewFailures++
+		}
+		comparisons = append(comparisons, StepComparison{
+			Name:          after.Name,
+			Change:        ChangeAdded,
+			AfterStatus:   after.Status,
+			AfterDuration: after.Duration,
+		})
+	}
+	return comparisons
+}
+
+// runArtifacts returns a run's artifacts by name. Task execution workflows
+// keep theirs in the workflow output, without checksums.
+func runArtifacts(run *comparedRun) map[string]models.Artifact {
+	artifacts := make(map[string]models.Artifact, len(run.artifacts))
+	for _, artifact := range run.artifacts {
+		artifacts[artifact.Name] = artifact
+	}
+	if len(run.artifacts) == 0 {
+		for _, artifact := range outputArtifacts(run.workflow.Output) {
+			artifacts[artifact.Name] = models.Artifact{Name: artifact.Name, Type: artifact.Type, Size: artifact.Size}
+		}
+	}
+	return artifacts
+}
+
+// compareArtifacts lists the artifacts added, removed or changed, by name.
+// Artifacts with checksums are compared by checksum, others by size.
+func compareArtifacts(base, other map[string]models.Artifact, summary *ComparisonSummary) []ArtifactChange {
+	names := make([]string, 0, len(base)+len(other))
+	for name := range base {
+		names = append(names, name)
+	}
+	for name := range other {
+		if _, ok := base[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []ArtifactChange
+	for _, name := range names {
+		before, inBase := base[name]
+		after, inOther := other[name]
+		change := ArtifactChange{
+			Name:           name,
+			BeforeChecksum: before.Checksum,
+			AfterChecksum:  after.Checksum,
+			BeforeSize:     before.Size,
+			AfterSize:      after.Size,
+		}
+		switch {
+		case !inOther:
+			change.Change = ChangeRemoved
+			summary.ArtifactsRemoved++
+		case !inBase:
+			change.Change = ChangeAdded
+			summary.ArtifactsAdded++
+		case before.Checksum != "" && after.Checksum != "" && before.Checksum != after.Checksum,
+			(before.Checksum == "" || after.Checksum == "") && before.Size != after.Size:
+			change.Change = ChangeChanged
+			summary.ArtifactsChanged++
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// compareErrors lists the workflow and step errors that differ between runs
+func compareErrors(base, other *comparedRun) []ErrorChange {
+	var changes []ErrorChange
+	add := func(source, activity, before, after string) {
+		if before == after {
+			return
+		}
+		change := ErrorChange{Source: source, Activity: activity, Change: ChangeChanged, Before: before, After: after}
+		switch {
+		case before == "":
+			change.Change = ChangeAdded
+		case after == "":
+			change.Change = ChangeRemoved
+		}
+		changes = append(changes, change)
+	}
+
+	add("workflow", base.workflow.Name, base.workflow.Error, other.workflow.Error)
+
+	baseKeys, otherKeys := stepKeys(base.steps), stepKeys(other.steps)
+	otherErrors := make(map[string]string, len(other.steps))
+	for i, step := range other.steps {
+		otherErrors[otherKeys[i]] = step.Error
+	}
+	seen := make(map[string]bool, len(base.steps))
+	for i, step := range base.steps {
+		seen[baseKeys[i]] = true
+		add("step", step.Name, step.Error, otherErrors[baseKeys[i]])
+	}
+	for i, step := range other.steps {
+		if !seen[otherKeys[i]] {
+			add("step", step.Name, "", step.Error)
+		}
+	}
+	return changes
+}
+
+// decodeJSON decodes a JSON document; documents that are not valid JSON
+// compare as strings
+func decodeJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw)
+	}
+	return value
+}
+
+var jsonIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// diffJSON appends the differences between two decoded JSON values, with
+// object keys in sorted order and arrays compared by index
+func diffJSON(path string, before, after interface{}, changes *[]ValueChange) {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		if a, ok := after.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(b)+len(a))
+			for key := range b {
+				keys = append(keys, key)
+			}
+			for key := range a {
+				if _, ok := b[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				keyPath := path + "[" + strconv.Quote(key) + "]"
+				if jsonIdentifier.MatchString(key) {
+					keyPath = path + "." + key
+				}
+				beforeValue, inBefore := b[key]
+				afterValue, inAfter := a[key]
+				switch {
+				case !inAfter:
+					*changes = append(*changes, ValueChange{Path: keyPath, Change: ChangeRemoved, Before: compactJSON(beforeValue)})
+				case !inBefore:
+					*changes = append(*changes, ValueChange{Path: keyPath, Change: ChangeAdded, After: compactJSON(afterValue)})
+				default:
+					diffJSON(keyPath, beforeValue, afterValue, changes)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if a, ok := after.([]interface{}); ok {
+			for i := 0; i < max(len(b), len(a)); i++ {
+				indexPath := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(a):
+					*changes = append(*changes, ValueChange{Path: indexPath, Change: ChangeRemoved, Before: compactJSON(b[i])})
+				case i >= len(b):
+					*changes = append(*changes, ValueChange{Path: indexPath, Change: ChangeAdded, After: compactJSON(a[i])})
+				default:
+					diffJSON(indexPath, b[i], a[i], changes)
+				}
+			}
+			return
+		}
+	}
+
+	beforeJSON, afterJSON := compactJSON(before), compactJSON(after)
+	if beforeJSON == afterJSON {
+		return
+	}
+	*changes = append(*changes, ValueChange{Path: path, Change: ChangeChanged, Before: beforeJSON, After: afterJSON})
+}
+
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+type comparedStep struct {
+	name     string
+	status   models.WorkflowStatus
+	duration int64
+	error    string
+}
+
+type comparedArtifact struct {
+	name, checksum string
+}
+
+// seedComparedRun stores a workflow run with one execution per step and its
+// artifacts on the first execution
+func seedComparedRun(t *testing.T, db *gorm.DB, input string, steps []comparedStep, artifacts []comparedArtifact) string {
+	workflow := &models.Workflow{
+		Name:      "Orders service",
+		Type:      models.WorkflowTypeIntent,
+		Status:    models.WorkflowStatusCompleted,
+		ProjectID: "project-1",
+		Input:     json.RawMessage(input),
+		CreatedAt: reportEpoch,
+	}
+	for _, step := range steps {
+		workflow.Duration += step.duration
+		if step.status == models.WorkflowStatusFailed {
+			workflow.Status = models.WorkflowStatusFailed
+		}
+	}
+	require.NoError(t, db.Create(workflow).Error)
+
+	for i, step := range steps {
+		require.NoError(t, db.Create(&models.WorkflowStep{
+			ID:         fmt.Sprintf("%s-step-%d", workflow.ID, i),
+			WorkflowID: workflow.ID,
+			Name:       step.name,
+			Type:       "task",
+			Order:      i + 1,
+			Status:     step.status,
+			Error:      step.error,
+			Duration:   step.duration,
+		}).Error)
+		require.NoError(t, db.Create(&models.Execution{
+			ID:         fmt.Sprintf("%s-execution-%d", workflow.ID, i),
+			ProjectID:  "project-1",
+			WorkflowID: workflow.ID,
+			Name:       step.name,
+			Type:       models.ExecutionTypeCustom,
+			Status:     models.ExecutionStatusSucceeded,
+		}).Error)
+	}
+	for i, artifact := range artifacts {
+		require.NoError(t, db.Create(&models.Artifact{
+			ID:          fmt.Sprintf("%s-artifact-%d", workflow.ID, i),
+			ExecutionID: workflow.ID + "-execution-0",
+			Name:        artifact.name,
+			Type:        "code",
+			Checksum:    artifact.checksum,
+			Size:        1024,
+		}).Error)
+	}
+	return workflow.ID
+}
+
+var baseComparedSteps = []comparedStep{
+	{name: "design", status: models.WorkflowStatusCompleted, duration: 30000},
+	{name: "api", status: models.WorkflowStatusCompleted, duration: 60000},
+	{name: "tests", status: models.WorkflowStatusCompleted, duration: 20000},
+}
+
+var baseComparedArtifacts = []comparedArtifact{{"design.md", "sha256:aaa"}, {"orders.go", "sha256:bbb"}}
+
+const baseComparedInput = `{"prompt": "Build an orders service", "language": "go", "options": {"tests": true}}`
+
+func TestWorkflowComparer_IdenticalRuns(t *testing.T) {
+	db := setupReportTest(t)
+	base := seedComparedRun(t, db, baseComparedInput, baseComparedSteps, baseComparedArtifacts)
+	// Same run, keys in another order and a little slower overall
+	slower := append([]comparedStep(nil), baseComparedSteps...)
+	slower[1].duration = 62000
+	other := seedComparedRun(t, db, `{"options": {"tests": true}, "language": "go", "prompt": "Build an orders service"}`,
+		slower, baseComparedArtifacts)
+
+	comparison, err := NewWorkflowComparer(db).Compare(context.Background(), base, other)
+	require.NoError(t, err)
+
+	assert.Equal(t, ComparisonSummary{Identical: true, DurationDelta: 2000}, comparison.Summary)
+	assert.Empty(t, comparison.Input.Items)
+	assert.Empty(t, comparison.Artifacts.Items)
+	assert.Empty(t, comparison.Errors.Items)
+	require.Len(t, comparison.Steps.Items, 3)
+	for _, step := range comparison.Steps.Items {
+		assert.Equal(t, ChangeUnchanged, step.Change)
+	}
+	assert.Equal(t, int64(2000), comparison.Steps.Items[1].DurationDelta)
+}
+
+func TestWorkflowComparer_ExtraFailedStep(t *testing.T) {
+	db := setupReportTest(t)
+	base := seedComparedRun(t, db, baseComparedInput, baseComparedSteps, baseComparedArtifacts)
+	steps := []comparedStep{
+		{name: "design", status: models.WorkflowStatusCompleted, duration: 30000},
+		{name: "api", status: models.WorkflowStatusCompleted, duration: 90000},
+		{name: "tests", status: models.WorkflowStatusCompleted, duration: 20000},
+		{name: "deploy", status: models.WorkflowStatusFailed, duration: 5000, error: "image push denied"},
+	}
+	other := seedComparedRun(t, db, baseComparedInput, steps,
+		[]comparedArtifact{{"design.md", "sha256:aaa"}, {"orders.go", "sha256:ccc"}, {"Dockerfile", "sha256:ddd"}})
+
+	comparison, err := NewWorkflowComparer(db).Compare(context.Background(), base, other)
+	require.NoError(t, err)
+
+	assert.Equal(t, ComparisonSummary{
+		DurationDelta:    35000,
+		StepsSlower:      1,
+		StepsAdded:       1,
+		NewFailures:      1,
+		ArtifactsAdded:   1,
+		ArtifactsChanged: 1,
+		ErrorChanges:     1,
+	}, comparison.Summary)
+	assert.Equal(t, models.WorkflowStatusFailed, comparison.Other.Status)
+
+	require.Len(t, comparison.Steps.Items, 4)
+	assert.Equal(t, StepComparison{
+		Name:          "deploy",
+		Change:        ChangeAdded,
+		AfterStatus:   models.WorkflowStatusFailed,
+		AfterDuration: 5000,
+	}, comparison.Steps.Items[3])
+	assert.Equal(t, int64(30000), comparison.Steps.Items[1].DurationDelta)
+
+	assert.Equal(t, []ArtifactChange{
+		{Name: "Dockerfile", Change: ChangeAdded, AfterChecksum: "sha256:ddd", AfterSize: 1024},
+		{Name: "orders.go", Change: ChangeChanged, BeforeChecksum: "sha256:bbb", AfterChecksum: "sha256:ccc", BeforeSize: 1024, AfterSize: 1024},
+	}, comparison.Artifacts.Items)
+	assert.Equal(t, []ErrorChange{
+		{Source: "step", Activity: "deploy", Change: ChangeAdded, After: "image push denied"},
+	}, comparison.Errors.Items)
+
+	// Compared the other way round, the failure is resolved
+	reverse, err := NewWorkflowComparer(db).Compare(context.Background(), other, base)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reverse.Summary.StepsRemoved)
+	assert.Equal(t, 1, reverse.Summary.ResolvedFailures)
+	assert.Equal(t, 1, reverse.Summary.StepsFaster)
+	assert.Equal(t, 0, reverse.Summary.NewFailures)
+}
+
+func TestWorkflowComparer_DivergentInputs(t *testing.T) {
+	db := setupReportTest(t)
+	base := seedComparedRun(t, db,
+		`{"prompt": "Build an orders service", "files": ["main.go", "orders.go"], "options": {"tests": true, "lint": true}, "retries": 3}`,
+		baseComparedSteps, baseComparedArtifacts)
+	other := seedComparedRun(t, db,
+		`{"prompt": "Build an orders API", "files": ["main.go"], "options": {"tests": false, "coverage target": 80}, "retries": 3}`,
+		baseComparedSteps, baseComparedArtifacts)
+
+	comparison, err := NewWorkflowComparer(db).Compare(context.Background(), base, other)
+	require.NoError(t, err)
+
+	assert.Equal(t, []ValueChange{
+		{Path: "$.files[1]", Change: ChangeRemoved, Before: `"orders.go"`},
+		{Path: `$.options["coverage target"]`, Change: ChangeAdded, After: "80"},
+		{Path: "$.options.lint", Change: ChangeRemoved, Before: "true"},
+		{Path: "$.options.tests", Change: ChangeChanged, Before: "true", After: "false"},
+		{Path: "$.prompt", Change: ChangeChanged, Before: `"Build an orders service"`, After: `"Build an orders API"`},
+	}, comparison.Input.Items)
+	assert.Equal(t, 5, comparison.Summary.InputChanges)
+	assert.False(t, comparison.Summary.Identical)
+	assert.Zero(t, comparison.Summary.StatusChanges)
+
+	// Sections are bounded, with the rest counted as omitted
+	comparer := NewWorkflowComparer(db)
+	comparer.limits.InputChanges = 2
+	comparer.limits.ValueLen = 10
+	bounded, err := comparer.Compare(context.Background(), base, other)
+	require.NoError(t, err)
+	assert.Len(t, bounded.Input.Items, 2)
+	assert.Equal(t, 5, bounded.Input.Total)
+	assert.Equal(t, 3, bounded.Input.Omitted)
+	assert.Equal(t, `"orders.go…`, bounded.Input.Items[0].Before)
+}
+
+func TestWorkflowComparer_RequiresSameProjectAndType(t *testing.T) {
+	db := setupReportTest(t)
+	base := seedComparedRun(t, db, baseComparedInput, baseComparedSteps, nil)
+	other := seedComparedRun(t, db, baseComparedInput, baseComparedSteps, nil)
+	comparer := NewWorkflowComparer(db)
+
+	require.NoError(t, db.Model(&models.Workflow{}).Where("id = ?", other).Update("type", models.WorkflowTypeReview).Error)
+	_, err := comparer.Compare(context.Background(), base, other)
+	assert.ErrorIs(t, err, ErrWorkflowsNotComparable)
+
+	require.NoError(t, db.Model(&models.Workflow{}).Where("id = ?", other).
+		Updates(map[string]interface{}{"type": models.WorkflowTypeIntent, "project_id": "project-2"}).Error)
+	_, err = comparer.Compare(context.Background(), base, other)
+	assert.ErrorIs(t, err, ErrWorkflowsNotComparable)
+
+	_, err = comparer.Compare(context.Background(), base, "missing")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
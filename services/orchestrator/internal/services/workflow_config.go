@@ -0,0 +1,372 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidWorkflowConfig is returned for workflow configs that cannot be
+// decoded or fail validation
+var ErrInvalidWorkflowConfig = errors.New("invalid workflow config")
+
+// WorkflowConfigVersion is the schema version of workflow configs. Configs
+// without a schema_version are version 1, the untyped configs stored before
+// configs were versioned.
+const WorkflowConfigVersion = 2
+
+// ConfigWarningsMetadataKey is the key of the unknown config fields in a
+// workflow's metadata
+const ConfigWarningsMetadataKey = "config_warnings"
+
+// TypedWorkflowConfig is the typed config of a workflow type
+type TypedWorkflowConfig interface {
+	// Common returns the settings every workflow type has
+	Common() *WorkflowConfigBase
+
+	applyDefaults()
+	validate() error
+}
+
+// WorkflowConfigBase holds the settings every workflow type has
+type WorkflowConfigBase struct {
+	SchemaVersion int                 `json:"schema_version"`
+	Simulation    *SimulationSettings `json:"simulation,omitempty"`
+	Environment   string              `json:"environment,omitempty"` // Project environment placeholders resolve against
+	Activities    ActivitySettings    `json:"activities"`
+	RetryBudget   RetryBudgetSettings `json:"retry_budget"`
+}
+
+// SimulationSettings run a workflow against the stub agent
+type SimulationSettings struct {
+	Enabled  bool         `json:"enabled"`
+	Fixtures StubFixtures `json:"fixtures,omitempty"`
+}
+
+// ActivitySettings are the timeouts and retries of a workflow's activities
+type ActivitySettings struct {
+	TimeoutSeconds          int `json:"timeout_seconds"`           // Start-to-close timeout of each attempt
+	HeartbeatTimeoutSeconds int `json:"heartbeat_timeout_seconds"` // Attempts not heartbeating for this long are retried
+	MaxAttempts             int `json:"max_attempts"`
+}
+
+// RetryBudgetSettings bound the retries a workflow makes across all of its
+// tasks, by the workflow itself and by Temporal alike
+type RetryBudgetSettings struct {
+	MaxRetries    int `json:"max_retries"`
+	WindowSeconds int `json:"window_seconds"` // Retries must start within this long of the workflow's start
+}
+
+// Common returns the config itself
+func (c *WorkflowConfigBase) Common() *WorkflowConfigBase { return c }
+
+// SimulationConfig returns the simulation settings in the form activities
+// take them, or nil when simulation is not enabled
+func (c *WorkflowConfigBase) SimulationConfig() *SimulationConfig {
+	if c.Simulation == nil || !c.Simulation.Enabled {
+		return nil
+	}
+	return &SimulationConfig{Enabled: true, Fixtures: c.Simulation.Fixtures}
+}
+
+func (c *WorkflowConfigBase) applyDefaults() {}
+
+func (c *WorkflowConfigBase) defaultActivities(timeout, heartbeat int) {
+	if c.Activities.TimeoutSeconds == 0 {
+		c.Activities.TimeoutSeconds = timeout
+	}
+	if c.Activities.HeartbeatTimeoutSeconds == 0 {
+		c.Activities.HeartbeatTimeoutSeconds = heartbeat
+	}
+	if c.Activities.MaxAttempts == 0 {
+		c.Activities.MaxAttempts = 3
+	}
+	if c.RetryBudget.MaxRetries == 0 {
+		c.RetryBudget.MaxRetries = 10
+	}
+	if c.RetryBudget.WindowSeconds == 0 {
+		c.RetryBudget.WindowSeconds = 3600
+	}
+}
+
+func (c *WorkflowConfigBase) validate() error {
+	activities := c.Activities
+	if activities.TimeoutSeconds < 0 || activities.HeartbeatTimeoutSeconds < 0 || activities.MaxAttempts < 0 {
+		return fmt.Errorf("%w: activity timeouts and attempts cannot be negative", ErrInvalidWorkflowConfig)
+	}
+	if activities.HeartbeatTimeoutSeconds > activities.TimeoutSeconds && activities.TimeoutSeconds > 0 {
+		return fmt.Errorf("%w: activities.heartbeat_timeout_seconds cannot exceed activities.timeout_seconds", ErrInvalidWorkflowConfig)
+	}
+	if c.RetryBudget.MaxRetries < 0 || c.RetryBudget.WindowSeconds < 0 {
+		return fmt.Errorf("%w: retry_budget.max_retries and retry_budget.window_seconds cannot be negative", ErrInvalidWorkflowConfig)
+	}
+	return nil
+}
+
+// IntentConfig is the config of intent processing workflows
+type IntentConfig struct {
+	WorkflowConfigBase
+}
+
+func (c *IntentConfig) applyDefaults() { c.defaultActivities(300, 30) }
+
+// CodeExecutionConfig is the config of code execution workflows
+type CodeExecutionConfig struct {
+	WorkflowConfigBase
+	MatrixParallelism int `json:"matrix_parallelism"` // Matrix combinations run at once when the request sets no max_parallel
+}
+
+func (c *CodeExecutionConfig) applyDefaults() {
+	c.defaultActivities(600, 60)
+	if c.MatrixParallelism == 0 {
+		c.MatrixParallelism = 4
+	}
+}
+
+func (c *CodeExecutionConfig) validate() error {
+	if c.MatrixParallelism < 1 || c.MatrixParallelism > 10 {
+		return fmt.Errorf("%w: matrix_parallelism must be between 1 and 10", ErrInvalidWorkflowConfig)
+	}
+	return c.WorkflowConfigBase.validate()
+}
+
+// DeploymentConfig is the config of deployment workflows
+type DeploymentConfig struct {
+	WorkflowConfigBase
+	ApprovalTimeoutMinutes  int `json:"approval_timeout_minutes"`  // When the request sets none
+	ApprovalReminderMinutes int `json:"approval_reminder_minutes"` // How often the wait for approval is logged
+}
+
+func (c *DeploymentConfig) applyDefaults() {
+	c.defaultActivities(1800, 300)
+	if c.ApprovalTimeoutMinutes == 0 {
+		c.ApprovalTimeoutMinutes = 72 * 60
+	}
+	if c.ApprovalReminderMinutes == 0 {
+		c.ApprovalReminderMinutes = 60
+	}
+}
+
+func (c *DeploymentConfig) validate() error {
+	if c.ApprovalTimeoutMinutes < 0 || c.ApprovalReminderMinutes < 0 {
+		return fmt.Errorf("%w: approval timeouts cannot be negative", ErrInvalidWorkflowConfig)
+	}
+	return c.WorkflowConfigBase.validate()
+}
+
+// TaskExecutionConfig is the config of task execution workflows
+type TaskExecutionConfig struct {
+	WorkflowConfigBase
+}
+
+func (c *TaskExecutionConfig) applyDefaults() { c.defaultActivities(1800, 120) }
+
+// workflowConfigTypes creates the typed config of each workflow type. Other
+// types, such as custom workflows whose config holds their definition, only
+// have the common settings.
+var workflowConfigTypes = map[models.WorkflowType]func() TypedWorkflowConfig{
+	models.WorkflowTypeIntent:        func() TypedWorkflowConfig { return &IntentConfig{} },
+	models.WorkflowTypeExecution:     func() TypedWorkflowConfig { return &CodeExecutionConfig{} },
+	models.WorkflowTypeDeployment:    func() TypedWorkflowConfig { return &DeploymentConfig{} },
+	models.WorkflowTypeTaskExecution: func() TypedWorkflowConfig { return &TaskExecutionConfig{} },
+}
+
+// workflowConfigMigrations upgrade the fields of a config from the version
+// they are indexed by to the next
+var workflowConfigMigrations = map[int]func(fields map[string]json.RawMessage) error{
+	1: migrateWorkflowConfigV1,
+}
+
+// migrateWorkflowConfigV1 nests the flat simulation flag and fixtures of
+// version 1 under simulation
+func migrateWorkflowConfigV1(fields map[string]json.RawMessage) error {
+	flag, ok := fields["simulation"]
+	if !ok {
+		return nil
+	}
+	var enabled bool
+	if err := json.Unmarshal(flag, &enabled); err != nil {
+		// Already nested, or invalid, which decoding reports
+		return nil
+	}
+	settings := map[string]json.RawMessage{"enabled": flag}
+	if fixtures, ok := fields["simulation_fixtures"]; ok {
+		settings["fixtures"] = fixtures
+		delete(fields, "simulation_fixtures")
+	}
+	nested, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	fields["simulation"] = nested
+	return nil
+}
+
+// NormalizeWorkflowConfig validates the config of a workflow about to be
+// stored. It returns the config upgraded to the current schema version,
+// unknown fields included, and a warning per unknown field.
+func NormalizeWorkflowConfig(workflowType models.WorkflowType, raw json.RawMessage) (json.RawMessage, []string, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return raw, nil, nil
+	}
+	fields, err := migrateWorkflowConfig(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, warnings, err := decodeWorkflowConfig(workflowType, fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	upgraded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal workflow config: %w", err)
+	}
+	return upgraded, warnings, nil
+}
+
+// DecodeWorkflowConfig decodes a stored workflow config into the typed config
+// of its workflow type, upgrading older schema versions and applying
+// defaults. Unknown fields are ignored.
+func DecodeWorkflowConfig(workflowType models.WorkflowType, raw json.RawMessage) (TypedWorkflowConfig, error) {
+	fields, err := migrateWorkflowConfig(raw)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := decodeWorkflowConfig(workflowType, fields)
+	return config, err
+}
+
+// migrateWorkflowConfig returns the fields of a config upgraded to the
+// current schema version
+func migrateWorkflowConfig(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null")) {
+		if err := json.Unmarshal(trimmed, &fields); err != nil {
+			return nil, fmt.Errorf("%w: config must be a JSON object", ErrInvalidWorkflowConfig)
+		}
+	}
+
+	version := 1
+	if value, ok := fields["schema_version"]; ok {
+		if err := json.Unmarshal(value, &version); err != nil {
+			return nil, fmt.Errorf("%w: schema_version must be an integer", ErrInvalidWorkflowConfig)
+		}
+	}
+	if version < 1 || version > WorkflowConfigVersion {
+		return nil, fmt.Errorf("%w: schema_version %d is not supported, the latest is %d",
+			ErrInvalidWorkflowConfig, version, WorkflowConfigVersion)
+	}
+	for ; version < WorkflowConfigVersion; version++ {
+		if err := workflowConfigMigrations[version](fields); err != nil {
+			return nil, fmt.Errorf("%w: failed to upgrade from schema_version %d: %v", ErrInvalidWorkflowConfig, version, err)
+		}
+	}
+	fields["schema_version"] = json.RawMessage(fmt.Sprint(WorkflowConfigVersion))
+	return fields, nil
+}
+
+// decodeWorkflowConfig decodes upgraded config fields into the typed config
+// of the workflow type, returning a warning per unknown field
+func decodeWorkflowConfig(workflowType models.WorkflowType, fields map[string]json.RawMessage) (TypedWorkflowConfig, []string, error) {
+	newConfig, typed := workflowConfigTypes[workflowType]
+	if !typed {
+		newConfig = func() TypedWorkflowConfig { return &WorkflowConfigBase{} }
+	}
+	config := newConfig()
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal workflow config: %w", err)
+	}
+	// Invalid simulation settings are reported as such
+	if _, err := ParseSimulationConfig(data); err != nil {
+		return nil, nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidWorkflowConfig, err)
+	}
+	config.applyDefaults()
+	if err := config.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	var warnings []string
+	if typed {
+		warnings = unknownConfigFields(fields, reflect.TypeOf(config).Elem(), "")
+	}
+	return config, warnings, nil
+}
+
+// unknownConfigFields returns a warning per field of a config, or of the
+// objects nested in it, that its typed config does not have
+func unknownConfigFields(fields map[string]json.RawMessage, t reflect.Type, prefix string) []string {
+	known := configFieldTypes(t)
+	var warnings []string
+	for name, value := range fields {
+		fieldType, ok := known[name]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("unknown config field %q is ignored", prefix+name))
+			continue
+		}
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() != reflect.Struct {
+			continue
+		}
+		var nested map[string]json.RawMessage
+		if err := json.Unmarshal(value, &nested); err == nil {
+			warnings = append(warnings, unknownConfigFields(nested, fieldType, prefix+name+".")...)
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// configFields caches the field types of config structs, by struct type
+var configFields sync.Map
+
+// configFieldTypes returns the type of each JSON field of a struct, the
+// fields of embedded structs included
+func configFieldTypes(t reflect.Type) map[string]reflect.Type {
+	if cached, ok := configFields.Load(t); ok {
+		return cached.(map[string]reflect.Type)
+	}
+	types := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			for name, fieldType := range configFieldTypes(field.Type) {
+				types[name] = fieldType
+			}
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		types[name] = field.Type
+	}
+	configFields.Store(t, types)
+	return types
+}
+
+// compileConfigFields caches the field types of a config struct and of the
+// structs nested in it
+func compileConfigFields(t reflect.Type) {
+	for _, fieldType := range configFieldTypes(t) {
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct {
+			compileConfigFields(fieldType)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"orchestrator/internal/models"
+)
+
+func TestDecodeWorkflowConfig_DefaultsAndValidation(t *testing.T) {
+	tests := []struct {
+		name         string
+		workflowType models.WorkflowType
+		config       string
+		check        func(t *testing.T, config TypedWorkflowConfig)
+		wantErr      error
+	}{
+		{
+			name: "intent defaults", workflowType: models.WorkflowTypeIntent, config: ``,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				intent := config.(*IntentConfig)
+				assert.Equal(t, WorkflowConfigVersion, intent.SchemaVersion)
+				assert.Equal(t, ActivitySettings{TimeoutSeconds: 300, HeartbeatTimeoutSeconds: 30, MaxAttempts: 3}, intent.Activities)
+				assert.Nil(t, intent.SimulationConfig())
+			},
+		},
+		{
+			name: "intent activities", workflowType: models.WorkflowTypeIntent,
+			config: `{"activities": {"timeout_seconds": 60, "max_attempts": 1}}`,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				assert.Equal(t, ActivitySettings{TimeoutSeconds: 60, HeartbeatTimeoutSeconds: 30, MaxAttempts: 1}, config.Common().Activities)
+			},
+		},
+		{
+			name: "intent heartbeat above timeout", workflowType: models.WorkflowTypeIntent,
+			config:  `{"activities": {"timeout_seconds": 10, "heartbeat_timeout_seconds": 20}}`,
+			wantErr: ErrInvalidWorkflowConfig,
+		},
+		{
+			name: "code execution defaults", workflowType: models.WorkflowTypeExecution, config: `{}`,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				execution := config.(*CodeExecutionConfig)
+				assert.Equal(t, 4, execution.MatrixParallelism)
+				assert.Equal(t, 600, execution.Activities.TimeoutSeconds)
+			},
+		},
+		{
+			name: "code execution parallelism", workflowType: models.WorkflowTypeExecution,
+			config: `{"matrix_parallelism": 2}`,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				assert.Equal(t, 2, config.(*CodeExecutionConfig).MatrixParallelism)
+			},
+		},
+		{
+			name: "code execution parallelism out of range", workflowType: models.WorkflowTypeExecution,
+			config: `{"matrix_parallelism": 11}`, wantErr: ErrInvalidWorkflowConfig,
+		},
+		{
+			name: "code execution wrong field type", workflowType: models.WorkflowTypeExecution,
+			config: `{"matrix_parallelism": "two"}`, wantErr: ErrInvalidWorkflowConfig,
+		},
+		{
+			name: "deployment defaults", workflowType: models.WorkflowTypeDeployment, config: `null`,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				deployment := config.(*DeploymentConfig)
+				assert.Equal(t, 72*60, deployment.ApprovalTimeoutMinutes)
+				assert.Equal(t, 60, deployment.ApprovalReminderMinutes)
+				assert.Equal(t, 1800, deployment.Activities.TimeoutSeconds)
+			},
+		},
+		{
+			name: "deployment negative approval timeout", workflowType: models.WorkflowTypeDeployment,
+			config: `{"approval_timeout_minutes": -5}`, wantErr: ErrInvalidWorkflowConfig,
+		},
+		{
+			name: "task execution simulation", workflowType: models.WorkflowTypeTaskExecution,
+			config: `{"schema_version": 2, "simulation": {"enabled": true, "fixtures": {"default": {"output": {"ok": true}}}}}`,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				sim := config.Common().SimulationConfig()
+				require.NotNil(t, sim)
+				assert.Contains(t, sim.Fixtures, "default")
+				assert.Equal(t, 120, config.Common().Activities.HeartbeatTimeoutSeconds)
+			},
+		},
+		{
+			name: "task execution retry budget", workflowType: models.WorkflowTypeTaskExecution,
+			config: `{"retry_budget": {"max_retries": 2}}`,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				assert.Equal(t, RetryBudgetSettings{MaxRetries: 2, WindowSeconds: 3600}, config.Common().RetryBudget)
+			},
+		},
+		{
+			name: "task execution negative retry budget", workflowType: models.WorkflowTypeTaskExecution,
+			config: `{"retry_budget": {"window_seconds": -1}}`, wantErr: ErrInvalidWorkflowConfig,
+		},
+		{
+			name: "task execution invalid simulation", workflowType: models.WorkflowTypeTaskExecution,
+			config: `{"simulation": {"enabled": "yes"}}`, wantErr: ErrInvalidSimulationConfig,
+		},
+		{
+			name: "untyped workflow type", workflowType: models.WorkflowTypeCustom,
+			config: `{"steps": [{"name": "build"}]}`,
+			check: func(t *testing.T, config TypedWorkflowConfig) {
+				assert.IsType(t, &WorkflowConfigBase{}, config)
+			},
+		},
+		{
+			name: "not an object", workflowType: models.WorkflowTypeIntent,
+			config: `[1, 2]`, wantErr: ErrInvalidWorkflowConfig,
+		},
+		{
+			name: "unsupported schema version", workflowType: models.WorkflowTypeIntent,
+			config: `{"schema_version": 3}`, wantErr: ErrInvalidWorkflowConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := DecodeWorkflowConfig(tt.workflowType, json.RawMessage(tt.config))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestNormalizeWorkflowConfig_MigratesV1(t *testing.T) {
+	v1 := json.RawMessage(`{"simulation": true, "simulation_fixtures": {"default": {"output": {"ok": true}}},
+		"environment": "staging"}`)
+
+	upgraded, warnings, err := NormalizeWorkflowConfig(models.WorkflowTypeIntent, v1)
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.JSONEq(t, `{"schema_version": 2, "environment": "staging",
+		"simulation": {"enabled": true, "fixtures": {"default": {"output": {"ok": true}}}}}`, string(upgraded))
+
+	// Stored v1 configs decode the same as their upgraded form
+	fromV1, err := DecodeWorkflowConfig(models.WorkflowTypeIntent, v1)
+	require.NoError(t, err)
+	fromV2, err := DecodeWorkflowConfig(models.WorkflowTypeIntent, upgraded)
+	require.NoError(t, err)
+	assert.Equal(t, fromV2, fromV1)
+	assert.Equal(t, "staging", fromV1.Common().Environment)
+	require.NotNil(t, fromV1.Common().SimulationConfig())
+
+	// Older configs keep working with the simulation parser
+	sim, err := ParseSimulationConfig(v1)
+	require.NoError(t, err)
+	require.NotNil(t, sim)
+	assert.Contains(t, sim.Fixtures, "default")
+}
+
+func TestNormalizeWorkflowConfig_WarnsAboutUnknownFields(t *testing.T) {
+	config, warnings, err := NormalizeWorkflowConfig(models.WorkflowTypeExecution,
+		json.RawMessage(`{"matrix_parallelism": 2, "parallelism": 8, "activities": {"timeout": 30}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`unknown config field "activities.timeout" is ignored`,
+		`unknown config field "parallelism" is ignored`,
+	}, warnings)
+	assert.Contains(t, string(config), `"parallelism":8`, "unknown fields are kept")
+
+	// Requests carry the warnings in the workflow's metadata
+	workflow, err := newWorkflowRecord(context.Background(), &StartWorkflowRequest{
+		Name:   "matrix build",
+		Type:   string(models.WorkflowTypeExecution),
+		Config: json.RawMessage(`{"parallelism": 8}`),
+	}, models.WorkflowStatusPending)
+	require.NoError(t, err)
+	var metadata map[string][]string
+	require.NoError(t, json.Unmarshal(workflow.Metadata, &metadata))
+	assert.Equal(t, []string{`unknown config field "parallelism" is ignored`}, metadata[ConfigWarningsMetadataKey])
+
+	_, err = newWorkflowRecord(context.Background(), &StartWorkflowRequest{
+		Name:   "matrix build",
+		Type:   string(models.WorkflowTypeExecution),
+		Config: json.RawMessage(`{"matrix_parallelism": 0.5}`),
+	}, models.WorkflowStatusPending)
+	assert.ErrorIs(t, err, ErrInvalidWorkflowConfig)
+
+	// Custom workflows keep their definition without warnings
+	_, warnings, err = NormalizeWorkflowConfig(models.WorkflowTypeCustom, json.RawMessage(`{"steps": []}`))
+	require.NoError(t, err)
+	assert.Empty(t, warnings)
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// What starting a workflow identical to one in flight does
+const (
+	// DedupeWarn starts the workflow and warns about the duplicate; the
+	// default
+	DedupeWarn = "warn"
+	// DedupeReuse returns the workflow in flight instead of starting one
+	DedupeReuse = "reuse"
+	// DedupeStrict rejects the workflow with ErrDuplicateWorkflow
+	DedupeStrict = "strict"
+)
+
+var (
+	// ErrDuplicateWorkflow is returned when starting a workflow, with
+	// dedupe=strict, while one with the same fingerprint is in flight
+	ErrDuplicateWorkflow = errors.New("duplicate workflow in flight")
+	// ErrInvalidDedupeMode is returned for dedupe modes other than warn,
+	// reuse and strict
+	ErrInvalidDedupeMode = errors.New("invalid dedupe mode")
+)
+
+// inFlightStatuses are the statuses of workflows duplicates are detected
+// against. Terminal workflows and drafts never block new submissions.
+var inFlightStatuses = []models.WorkflowStatus{
+	models.WorkflowStatusPending,
+	models.WorkflowStatusRunning,
+	models.WorkflowStatusPaused,
+}
+
+var workflowDuplicatesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_workflow_duplicates_total",
+	Help: "Workflow starts matching a workflow in flight, by dedupe mode",
+}, []string{"mode"})
+
+// validateDedupeMode checks a dedupe mode; empty means DedupeWarn
+func validateDedupeMode(mode string) error {
+	switch mode {
+	case "", DedupeWarn, DedupeReuse, DedupeStrict:
+		return nil
+	}
+	return fmt.Errorf("%w: %q, expected warn, reuse or strict", ErrInvalidDedupeMode, mode)
+}
+
+// CanonicalJSON re-encodes a JSON document with object keys sorted and
+// insignificant whitespace removed. Numbers keep their text, so 1 and 1.0
+// differ. An empty document is null.
+func CanonicalJSON(raw []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return []byte("null"), nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	// Maps are encoded in key order
+	return json.Marshal(value)
+}
+
+// WorkflowFingerprint is the SHA-256, hex encoded, of a workflow's project,
+// type and canonical input: workflows with the same fingerprint do the same
+// work
+func WorkflowFingerprint(projectID string, workflowType models.WorkflowType, input json.RawMessage) (string, error) {
+	canonical, err := CanonicalJSON(input)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidWorkflowInput, err)
+	}
+	hash := sha256.New()
+	hash.Write([]byte(projectID))
+	hash.Write([]byte{0})
+	hash.Write([]byte(workflowType))
+	hash.Write([]byte{0})
+	hash.Write(canonical)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findInFlightDuplicate returns the oldest workflow in flight with the
+// workflow's fingerprint, nil when there is none. The lookup is served by
+// the fingerprint index.
+func (e *WorkflowEngine) findInFlightDuplicate(ctx context.Context, workflow *models.Workflow) (*models.Workflow, error) {
+	if workflow.Fingerprint == "" {
+		return nil, nil
+	}
+	var duplicate models.Workflow
+	err := e.db.WithContext(ctx).
+		Select("id", "temporal_id", "temporal_run_id", "status", "scheduling_reason", "created_at").
+		Where("fingerprint = ? AND status IN ?", workflow.Fingerprint, inFlightStatuses).
+		Order("created_at").
+		First(&duplicate).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up duplicate workflows: %w", err)
+	}
+	return &duplicate, nil
+}
+
+// dedupe applies the dedupe mode to a workflow about to start. It returns
+// the response of the workflow in flight to reuse, or the warning to
+// attach to the new workflow's response.
+func (e *WorkflowEngine) dedupe(ctx context.Context, workflow *models.Workflow, mode string) (*StartWorkflowResponse, *models.Workflow, error) {
+	duplicate, err := e.findInFlightDuplicate(ctx, workflow)
+	if err != nil || duplicate == nil {
+		return nil, nil, err
+	}
+	if mode == "" {
+		mode = DedupeWarn
+	}
+	workflowDuplicatesTotal.WithLabelValues(mode).Inc()
+
+	switch mode {
+	case DedupeStrict:
+		return nil, nil, fmt.Errorf("%w: workflow %s has the same type and input and is %s",
+			ErrDuplicateWorkflow, duplicate.ID, duplicate.Status)
+	case DedupeReuse:
+		return &StartWorkflowResponse{
+			WorkflowID:       duplicate.ID,
+			TemporalID:       duplicate.TemporalID,
+			TemporalRunID:    duplicate.TemporalRunID,
+			Status:           string(duplicate.Status),
+			SchedulingReason: duplicate.SchedulingReason,
+			Reused:           true,
+		}, nil, nil
+	}
+	return nil, duplicate, nil
+}
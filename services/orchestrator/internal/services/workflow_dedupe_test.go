@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+
+	"orchestrator/internal/models"
+)
+
+func TestWorkflowFingerprint_Canonicalization(t *testing.T) {
+	fingerprint := func(projectID string, workflowType models.WorkflowType, input string) string {
+		t.Helper()
+		value, err := WorkflowFingerprint(projectID, workflowType, json.RawMessage(input))
+		require.NoError(t, err)
+		return value
+	}
+	base := fingerprint("project-1", models.WorkflowTypeExecution, `{"code": "print(1)", "env": {"a": 1, "b": [1, 2]}}`)
+	assert.Len(t, base, 64)
+
+	// Key order and whitespace do not matter
+	assert.Equal(t, base, fingerprint("project-1", models.WorkflowTypeExecution, `{"env":{"b":[1,2],"a":1},"code":"print(1)"}`))
+	assert.Equal(t, base, fingerprint("project-1", models.WorkflowTypeExecution, "{\n\t\"env\": {\"a\": 1,\n\"b\": [ 1, 2 ]},\n\t\"code\": \"print(1)\"\n}\n"))
+
+	// Values, array order, the project and the type do
+	for name, other := range map[string]string{
+		"value":       fingerprint("project-1", models.WorkflowTypeExecution, `{"code": "print(2)", "env": {"a": 1, "b": [1, 2]}}`),
+		"array order": fingerprint("project-1", models.WorkflowTypeExecution, `{"code": "print(1)", "env": {"a": 1, "b": [2, 1]}}`),
+		"number text": fingerprint("project-1", models.WorkflowTypeExecution, `{"code": "print(1)", "env": {"a": 1.0, "b": [1, 2]}}`),
+		"project":     fingerprint("project-2", models.WorkflowTypeExecution, `{"code": "print(1)", "env": {"a": 1, "b": [1, 2]}}`),
+		"type":        fingerprint("project-1", models.WorkflowTypeAnalysis, `{"code": "print(1)", "env": {"a": 1, "b": [1, 2]}}`),
+	} {
+		assert.NotEqual(t, base, other, name)
+	}
+
+	// Missing input is null input
+	assert.Equal(t, fingerprint("project-1", models.WorkflowTypeExecution, ""), fingerprint("project-1", models.WorkflowTypeExecution, " null "))
+
+	_, err := WorkflowFingerprint("project-1", models.WorkflowTypeExecution, json.RawMessage(`{"code":`))
+	assert.ErrorIs(t, err, ErrInvalidWorkflowInput)
+}
+
+func TestWorkflowEngine_StartWorkflowDedupe(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-1")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, "CodeExecutionWorkflow", mock.Anything).Return(run, nil)
+
+	request := func(input, dedupe string) *StartWorkflowRequest {
+		return &StartWorkflowRequest{
+			Name:           "build",
+			Type:           string(models.WorkflowTypeExecution),
+			Priority:       string(models.WorkflowPriorityMedium),
+			ProjectID:      "project-1",
+			Input:          json.RawMessage(input),
+			TimeoutSeconds: 3600,
+			Dedupe:         dedupe,
+		}
+	}
+	first, err := engine.StartWorkflow(ctx, request(`{"code": "print(1)", "args": ["a"]}`, ""))
+	require.NoError(t, err)
+	assert.Empty(t, first.DuplicateOf)
+	assert.Empty(t, first.Warning)
+
+	// The same input, however formatted
+	duplicate := `{ "args": [ "a" ],  "code": "print(1)" }`
+
+	t.Run("warn starts the workflow with a warning", func(t *testing.T) {
+		resp, err := engine.StartWorkflow(ctx, request(duplicate, DedupeWarn))
+		require.NoError(t, err)
+		assert.NotEqual(t, first.WorkflowID, resp.WorkflowID)
+		assert.Equal(t, first.WorkflowID, resp.DuplicateOf)
+		assert.Contains(t, resp.Warning, first.WorkflowID)
+		assert.False(t, resp.Reused)
+	})
+
+	t.Run("reuse returns the workflow in flight", func(t *testing.T) {
+		var before int64
+		require.NoError(t, db.Model(&models.Workflow{}).Count(&before).Error)
+
+		resp, err := engine.StartWorkflow(ctx, request(duplicate, DedupeReuse))
+		require.NoError(t, err)
+		assert.True(t, resp.Reused)
+		assert.Equal(t, first.WorkflowID, resp.WorkflowID, "the oldest workflow in flight")
+		assert.Equal(t, first.TemporalRunID, resp.TemporalRunID)
+		assert.Equal(t, string(models.WorkflowStatusRunning), resp.Status)
+
+		var after int64
+		require.NoError(t, db.Model(&models.Workflow{}).Count(&after).Error)
+		assert.Equal(t, before, after, "no workflow is created")
+	})
+
+	t.Run("strict rejects the workflow", func(t *testing.T) {
+		_, err := engine.StartWorkflow(ctx, request(duplicate, DedupeStrict))
+		require.ErrorIs(t, err, ErrDuplicateWorkflow)
+		assert.Contains(t, err.Error(), first.WorkflowID)
+
+		// Other input is not a duplicate
+		resp, err := engine.StartWorkflow(ctx, request(`{"code": "print(2)", "args": ["a"]}`, DedupeStrict))
+		require.NoError(t, err)
+		assert.Empty(t, resp.DuplicateOf)
+	})
+
+	t.Run("terminal workflows never block", func(t *testing.T) {
+		require.NoError(t, db.Model(&models.Workflow{}).
+			Where("status IN ?", inFlightStatuses).
+			Update("status", models.WorkflowStatusCompleted).Error)
+
+		resp, err := engine.StartWorkflow(ctx, request(duplicate, DedupeStrict))
+		require.NoError(t, err)
+		assert.Empty(t, resp.DuplicateOf)
+		assert.False(t, resp.Reused)
+	})
+
+	t.Run("invalid modes are rejected", func(t *testing.T) {
+		_, err := engine.StartWorkflow(ctx, request(duplicate, "ignore"))
+		assert.ErrorIs(t, err, ErrInvalidDedupeMode)
+	})
+}
+
+func TestWorkflowEngine_FindInFlightDuplicateUsesIndex(t *testing.T) {
+	engine, db := newDraftTestEngine(t, new(mocks.Client))
+	fingerprint, err := WorkflowFingerprint("project-1", models.WorkflowTypeExecution, json.RawMessage(`{"code": "print(1)"}`))
+	require.NoError(t, err)
+
+	var plan []struct {
+		Detail string
+	}
+	require.NoError(t, db.Raw(`EXPLAIN QUERY PLAN SELECT id FROM workflows
+		WHERE fingerprint = ? AND status IN (?) AND deleted_at IS NULL ORDER BY created_at LIMIT 1`,
+		fingerprint, inFlightStatuses).Scan(&plan).Error)
+	var details []string
+	for _, step := range plan {
+		details = append(details, step.Detail)
+	}
+	assert.Contains(t, strings.Join(details, "\n"), "idx_workflows_fingerprint")
+
+	found, err := engine.findInFlightDuplicate(context.Background(), &models.Workflow{Fingerprint: fingerprint})
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
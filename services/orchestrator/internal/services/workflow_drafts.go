@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/requestid"
+)
+
+var (
+	// ErrNotDraft is returned when a draft operation targets a workflow that
+	// is not, or no longer, a draft
+	ErrNotDraft = errors.New("workflow is not a draft")
+	// ErrIncompleteDraft is returned when a draft is submitted before it
+	// describes a workflow that can run
+	ErrIncompleteDraft = errors.New("workflow draft is incomplete")
+)
+
+// CreateWorkflowDraft saves a workflow without starting it. The request is
+// validated as for StartWorkflow, but may leave out what is only required
+// to run the workflow.
+func (e *WorkflowEngine) CreateWorkflowDraft(ctx context.Context, req *StartWorkflowRequest) (*models.Workflow, error) {
+	workflow, err := newWorkflowRecord(ctx, req, models.WorkflowStatusDraft)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.db.WithContext(ctx).Create(workflow).Error; err != nil {
+		if isForeignKeyViolation(e.db, err) {
+			return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, workflow.ProjectID)
+		}
+		return nil, fmt.Errorf("failed to create workflow draft: %w", err)
+	}
+	return workflow, nil
+}
+
+// UpdateWorkflowDraft replaces the settings of a draft with those of the
+// request
+func (e *WorkflowEngine) UpdateWorkflowDraft(ctx context.Context, workflowID string, req *StartWorkflowRequest) (*models.Workflow, error) {
+	draft, err := e.getDraft(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := newWorkflowRecord(ctx, req, models.WorkflowStatusDraft)
+	if err != nil {
+		return nil, err
+	}
+
+	// The draft keeps its identity and author
+	updated.ID = draft.ID
+	updated.RequestID = draft.RequestID
+	updated.CreatedBy = draft.CreatedBy
+	updated.CreatedAt = draft.CreatedAt
+	updated.UpdatedAt = time.Now()
+	if updated.UpdatedBy == "" {
+		updated.UpdatedBy = draft.UpdatedBy
+	}
+
+	// A draft submitted meanwhile is not updated
+	result := e.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND status = ?", workflowID, models.WorkflowStatusDraft).
+		Select("name", "description", "type", "priority", "effective_priority", "project_id", "input", "config", "metadata", "tags", "labels",
+			"simulated", "max_retries", "timeout_seconds", "sla_seconds", "sla_threshold", "budget", "template_id", "fingerprint", "updated_by", "updated_at").
+		Updates(updated)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update workflow draft: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotDraft
+	}
+	e.dropCachedWorkflow(ctx, workflowID)
+	return updated, nil
+}
+
+// SubmitWorkflowDraft starts a draft: it is validated as a workflow about to
+// run, moved to pending and submitted to Temporal as StartWorkflow does
+func (e *WorkflowEngine) SubmitWorkflowDraft(ctx context.Context, workflowID, userID string) (*StartWorkflowResponse, error) {
+	if e.maintenance.Active(ctx) {
+		return nil, ErrMaintenanceMode
+	}
+	draft, err := e.getDraft(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDraftSubmission(draft, e.workflowTypes); err != nil {
+		return nil, err
+	}
+	if err := e.applySandboxPolicy(ctx, draft); err != nil {
+		return nil, err
+	}
+	if err := e.applyRegion(ctx, draft); err != nil {
+		return nil, err
+	}
+	if err := e.validateTemplatePlan(ctx, draft.TemplateID); err != nil {
+		return nil, err
+	}
+	if err := e.checkAgentCompatibility(string(draft.Type), draft.Simulated); err != nil {
+		return nil, err
+	}
+
+	// Only one of concurrent submissions moves the draft to pending. The
+	// reaper measures how long it is pending from its submission.
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":       models.WorkflowStatusPending,
+		"submitted_at": now,
+		"queued_at":    now,
+		"request_id":   requestid.FromContext(ctx),
+		"metadata":     draft.Metadata,
+	}
+	if userID != "" {
+		updates["updated_by"] = userID
+	}
+	result := e.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND status = ?", workflowID, models.WorkflowStatusDraft).
+		Updates(updates)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to submit workflow draft: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotDraft
+	}
+	draft.Status = models.WorkflowStatusPending
+	draft.SubmittedAt = &now
+	draft.QueuedAt = &now
+	draft.RequestID = requestid.FromContext(ctx)
+	if userID != "" {
+		draft.UpdatedBy = userID
+	}
+
+	e.logger.Info("Submitting workflow draft", zap.String("workflow_id", workflowID), zap.String("user_id", userID))
+	return e.launchWorkflow(ctx, draft)
+}
+
+// DeleteWorkflowDraft discards a draft
+func (e *WorkflowEngine) DeleteWorkflowDraft(ctx context.Context, workflowID string) error {
+	if _, err := e.getDraft(ctx, workflowID); err != nil {
+		return err
+	}
+	result := e.db.WithContext(ctx).
+		Where("id = ? AND status = ?", workflowID, models.WorkflowStatusDraft).
+		Delete(&models.Workflow{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete workflow draft: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotDraft
+	}
+	e.dropCachedWorkflow(ctx, workflowID)
+	return nil
+}
+
+// getDraft loads a draft from the database, bypassing the cache
+func (e *WorkflowEngine) getDraft(ctx context.Context, workflowID string) (*models.Workflow, error) {
+	var workflow models.Workflow
+	if err := e.db.WithContext(ctx).First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	if workflow.Status != models.WorkflowStatusDraft {
+		return nil, fmt.Errorf("%w: it is %s", ErrNotDraft, workflow.Status)
+	}
+	return &workflow, nil
+}
+
+// dropCachedWorkflow removes the cached copy of a workflow so readers see
+// its stored state
+func (e *WorkflowEngine) dropCachedWorkflow(ctx context.Context, workflowID string) {
+	e.cache().Invalidate(ctx, fmt.Sprintf("workflow:%s", workflowID))
+}
+
+// validateDraftSubmission checks that a draft describes a workflow that can
+// run, listing everything it still lacks
+func validateDraftSubmission(draft *models.Workflow, workflowTypes *WorkflowTypeRegistry) error {
+	var problems []string
+	if strings.TrimSpace(draft.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if draft.ProjectID == "" {
+		problems = append(problems, "project_id is required")
+	}
+	spec, known := workflowTypes.Lookup(draft.Type)
+	if !known {
+		problems = append(problems, fmt.Sprintf("unknown workflow type %q", draft.Type))
+	}
+	if len(draft.Input) == 0 || string(draft.Input) == "null" {
+		problems = append(problems, "input is required")
+	} else if !json.Valid(draft.Input) {
+		problems = append(problems, "input is not valid JSON")
+	} else if err := spec.ValidateInput(draft.Input); known && err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrIncompleteDraft, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// EnableDraftRetention makes the monitor purge drafts not edited for maxAge
+func (m *WorkflowMonitor) EnableDraftRetention(maxAge time.Duration) {
+	m.draftRetention = maxAge
+}
+
+// draftPurgeInterval is how often the monitor purges expired drafts
+const draftPurgeInterval = time.Hour
+
+// purgeDrafts deletes the drafts not edited within the retention, at most
+// once per draftPurgeInterval
+func (m *WorkflowMonitor) purgeDrafts(ctx context.Context) {
+	now := m.now()
+	if m.draftRetention <= 0 || (!m.lastDraftPurge.IsZero() && now.Sub(m.lastDraftPurge) < draftPurgeInterval) {
+		return
+	}
+	m.lastDraftPurge = now
+
+	result := m.db.WithContext(ctx).Unscoped().
+		Where("status = ? AND updated_at < ?", models.WorkflowStatusDraft, now.Add(-m.draftRetention)).
+		Delete(&models.Workflow{})
+	if result.Error != nil {
+		m.logger.Error("Failed to purge expired workflow drafts", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		m.logger.Info("Purged expired workflow drafts",
+			zap.Int64("count", result.RowsAffected),
+			zap.Duration("retention", m.draftRetention))
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+func newDraftTestEngine(t *testing.T, temporalClient *mocks.Client) (*WorkflowEngine, *gorm.DB) {
+	db := setupWorkflowTestDB(t)
+	// Unreachable Redis: cache writes and event publishing only log errors
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	t.Cleanup(func() { redisClient.Close() })
+	engine := NewWorkflowEngine(db, redisClient, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+	return engine, db
+}
+
+func TestWorkflowEngine_DraftSubmitStartsWorkflow(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+
+	// A draft needs neither a project nor input yet
+	draft, err := engine.CreateWorkflowDraft(ctx, &StartWorkflowRequest{
+		Name:     "nightly build",
+		Type:     string(models.WorkflowTypeExecution),
+		Priority: string(models.WorkflowPriorityMedium),
+		UserID:   "alice",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, models.WorkflowStatusDraft, draft.Status)
+
+	_, err = engine.SubmitWorkflowDraft(ctx, draft.ID, "alice")
+	require.ErrorIs(t, err, ErrIncompleteDraft)
+	assert.Contains(t, err.Error(), "project_id is required")
+	assert.Contains(t, err.Error(), "input is required")
+
+	updated, err := engine.UpdateWorkflowDraft(ctx, draft.ID, &StartWorkflowRequest{
+		Name:           "nightly build",
+		Type:           string(models.WorkflowTypeExecution),
+		Priority:       string(models.WorkflowPriorityHigh),
+		ProjectID:      "project-1",
+		UserID:         "bob",
+		Input:          json.RawMessage(`{"code": "print(1)"}`),
+		TimeoutSeconds: 3600,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, draft.ID, updated.ID)
+	assert.Equal(t, "alice", updated.CreatedBy)
+
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return(draft.ID)
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, "CodeExecutionWorkflow", mock.Anything).
+		Return(run, nil).Once()
+
+	resp, err := engine.SubmitWorkflowDraft(ctx, draft.ID, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, draft.ID, resp.WorkflowID)
+	assert.Equal(t, "run-1", resp.TemporalRunID)
+	assert.Equal(t, string(models.WorkflowStatusRunning), resp.Status)
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", draft.ID).Error)
+	assert.Equal(t, models.WorkflowStatusRunning, stored.Status)
+	assert.Equal(t, models.WorkflowPriorityHigh, stored.Priority)
+	assert.Equal(t, "project-1", stored.ProjectID)
+	assert.Equal(t, "bob", stored.UpdatedBy)
+	assert.NotNil(t, stored.SubmittedAt)
+	assert.NotNil(t, stored.StartedAt)
+	require.NotNil(t, stored.QueuedAt)
+	assert.Equal(t, stored.SubmittedAt.UnixNano(), stored.QueuedAt.UnixNano())
+
+	// A submitted workflow is no longer a draft
+	_, err = engine.SubmitWorkflowDraft(ctx, draft.ID, "bob")
+	assert.ErrorIs(t, err, ErrNotDraft)
+	_, err = engine.UpdateWorkflowDraft(ctx, draft.ID, &StartWorkflowRequest{Name: "renamed", Type: string(models.WorkflowTypeExecution)})
+	assert.ErrorIs(t, err, ErrNotDraft)
+	assert.ErrorIs(t, engine.DeleteWorkflowDraft(ctx, draft.ID), ErrNotDraft)
+	temporalClient.AssertExpectations(t)
+}
+
+func TestWorkflowEngine_DraftValidation(t *testing.T) {
+	ctx := context.Background()
+	engine, _ := newDraftTestEngine(t, new(mocks.Client))
+
+	// Drafts are validated as started workflows are
+	_, err := engine.CreateWorkflowDraft(ctx, &StartWorkflowRequest{Name: "draft", Tags: []string{"Not A Tag!"}})
+	assert.ErrorIs(t, err, ErrInvalidTags)
+
+	draft, err := engine.CreateWorkflowDraft(ctx, &StartWorkflowRequest{
+		Name:      "draft",
+		Type:      "legacy_type",
+		ProjectID: "project-1",
+		Input:     json.RawMessage(`{"code": "print(1)"}`),
+	})
+	require.NoError(t, err)
+
+	_, err = engine.SubmitWorkflowDraft(ctx, draft.ID, "")
+	require.ErrorIs(t, err, ErrIncompleteDraft)
+	assert.Contains(t, err.Error(), `unknown workflow type "legacy_type"`)
+
+	require.NoError(t, engine.DeleteWorkflowDraft(ctx, draft.ID))
+	_, err = engine.SubmitWorkflowDraft(ctx, draft.ID, "")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestWorkflowEngine_ListWorkflowsExcludesDrafts(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newDraftTestEngine(t, new(mocks.Client))
+
+	require.NoError(t, db.Create(&models.Workflow{Name: "running", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusRunning}).Error)
+	_, err := engine.CreateWorkflowDraft(ctx, &StartWorkflowRequest{Name: "draft", Type: string(models.WorkflowTypeExecution)})
+	require.NoError(t, err)
+
+	summaries, total, err := engine.ListWorkflowSummaries(ctx, &WorkflowFilters{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "running", summaries[0].Name)
+
+	_, total, err = engine.ListWorkflowSummaries(ctx, &WorkflowFilters{IncludeDrafts: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+
+	summaries, _, err = engine.ListWorkflowSummaries(ctx, &WorkflowFilters{Status: string(models.WorkflowStatusDraft)})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "draft", summaries[0].Name)
+}
+
+func TestWorkflowMonitor_PurgesExpiredDrafts(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	now := time.Now()
+	newWorkflow := func(name string, status models.WorkflowStatus, updatedAt time.Time) *models.Workflow {
+		workflow := &models.Workflow{Name: name, Type: models.WorkflowTypeExecution, Status: status}
+		require.NoError(t, db.Create(workflow).Error)
+		require.NoError(t, db.Model(workflow).UpdateColumn("updated_at", updatedAt).Error)
+		return workflow
+	}
+	expired := newWorkflow("expired draft", models.WorkflowStatusDraft, now.Add(-48*time.Hour))
+	recent := newWorkflow("recent draft", models.WorkflowStatusDraft, now.Add(-time.Hour))
+	completed := newWorkflow("old workflow", models.WorkflowStatusCompleted, now.Add(-48*time.Hour))
+
+	monitor := NewWorkflowMonitor(db, new(mocks.Client), zap.NewNop(), nil, time.Minute)
+	monitor.now = func() time.Time { return now }
+	monitor.EnableDraftRetention(24 * time.Hour)
+	monitor.checkWorkflows()
+
+	exists := func(workflow *models.Workflow) bool {
+		var count int64
+		require.NoError(t, db.Unscoped().Model(&models.Workflow{}).Where("id = ?", workflow.ID).Count(&count).Error)
+		return count > 0
+	}
+	assert.False(t, exists(expired))
+	assert.True(t, exists(recent))
+	assert.True(t, exists(completed))
+
+	// Drafts expiring later wait for the next purge
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, db.Model(recent).UpdateColumn("updated_at", now.Add(-25*time.Hour)).Error)
+	monitor.checkWorkflows()
+	assert.True(t, exists(recent))
+
+	now = now.Add(draftPurgeInterval)
+	monitor.checkWorkflows()
+	assert.False(t, exists(recent))
+}
@@ -3,10 +3,14 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"orchestrator/internal/deadline"
 	"orchestrator/internal/models"
+	"orchestrator/internal/requestid"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/temporal"
@@ -23,6 +27,17 @@ type WorkflowEngine struct {
 	intentClient   *IntentClient
 	agentClient    *AgentClient
 	config         *WorkflowConfig
+	activity       *ProjectActivityTracker
+	compatibility  AgentCompatibilityChecker
+	scheduler      *FairShareScheduler
+	maintenance    *MaintenanceMode
+	usage          *UsageMeter
+	plans          *PlanValidator
+	redactors      *ProjectRedactors
+	workflowTypes  *WorkflowTypeRegistry
+	sandboxPolicies *SandboxPolicies
+	events         *WorkflowEventQueue
+	residency      *DataResidency
 }
 
 // WorkflowConfig holds workflow engine configuration
@@ -35,6 +50,12 @@ type WorkflowConfig struct {
 	RetryPolicy             *temporal.RetryPolicy
 	EnableMetrics           bool
 	EnableTracing           bool
+	// EnableSearchAttributes sets custom search attributes (e.g. Tags) on started
+	// workflows. They must already be registered on the Temporal namespace.
+	EnableSearchAttributes bool
+	// StateQueryTimeout bounds how long a running workflow has to answer
+	// the state query; defaults to 2 seconds
+	StateQueryTimeout time.Duration
 }
 
 // NewWorkflowEngine creates a new workflow engine
@@ -58,51 +79,268 @@ func NewWorkflowEngine(
 	}
 }
 
+// SetActivityTracker makes workflow starts record project activity through
+// the tracker
+func (e *WorkflowEngine) SetActivityTracker(tracker *ProjectActivityTracker) {
+	e.activity = tracker
+}
+
 // StartWorkflow starts a new workflow execution
 func (e *WorkflowEngine) StartWorkflow(ctx context.Context, req *StartWorkflowRequest) (*StartWorkflowResponse, error) {
+	if e.maintenance.Active(ctx) {
+		return nil, ErrMaintenanceMode
+	}
+	if _, err := e.resolveRequest(req); err != nil {
+		return nil, err
+	}
+	if err := validateDedupeMode(req.Dedupe); err != nil {
+		return nil, err
+	}
+	workflow, err := newWorkflowRecord(ctx, req, models.WorkflowStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.applySandboxPolicy(ctx, workflow); err != nil {
+		return nil, err
+	}
+	if err := e.applyRegion(ctx, workflow); err != nil {
+		return nil, err
+	}
+	if err := e.validateTemplatePlan(ctx, workflow.TemplateID); err != nil {
+		return nil, err
+	}
+	if err := e.validateCustomSteps(workflow); err != nil {
+		return nil, err
+	}
+	if err := e.checkAgentCompatibility(req.Type, workflow.Simulated); err != nil {
+		return nil, err
+	}
+	reused, duplicate, err := e.dedupe(ctx, workflow, req.Dedupe)
+	if err != nil {
+		return nil, err
+	}
+	if reused != nil {
+		return reused, nil
+	}
+
+	// The request's deadline bounds the enqueue, not the workflow
+	if err := deadline.Check(ctx, "starting the workflow"); err != nil {
+		return nil, err
+	}
+
 	// Create workflow record in database
+	if err := e.db.Create(workflow).Error; err != nil {
+		if isForeignKeyViolation(e.db, err) {
+			return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, workflow.ProjectID)
+		}
+		return nil, fmt.Errorf("failed to create workflow record: %w", err)
+	}
+	response, err := e.launchWorkflow(ctx, workflow)
+	if err != nil {
+		return nil, err
+	}
+	if duplicate != nil {
+		response.DuplicateOf = duplicate.ID
+		response.Warning = fmt.Sprintf("workflow %s with the same type and input is %s", duplicate.ID, duplicate.Status)
+	}
+	return response, nil
+}
+
+// isForeignKeyViolation reports whether err is a foreign key violation,
+// SQLSTATE 23503 on Postgres, as translated by the database's dialector
+func isForeignKeyViolation(db *gorm.DB, err error) bool {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrForeignKeyViolated)
+}
+
+// newWorkflowRecord validates a request and builds the workflow row it
+// describes, in the given status
+func newWorkflowRecord(ctx context.Context, req *StartWorkflowRequest, status models.WorkflowStatus) (*models.Workflow, error) {
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		return nil, err
+	}
+	config, configWarnings, err := NormalizeWorkflowConfig(models.WorkflowType(req.Type), req.Config)
+	if err != nil {
+		return nil, err
+	}
+	simulation, err := ParseSimulationConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	slaThreshold, err := normalizeSLA(req.SLASeconds, req.SLAWarningThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateBudget(req.Budget); err != nil {
+		return nil, err
+	}
+	budget := req.Budget
+	if budget.IsZero() {
+		budget = nil
+	}
+	fingerprint, err := WorkflowFingerprint(req.ProjectID, models.WorkflowType(req.Type), req.Input)
+	if err != nil {
+		return nil, err
+	}
+	source, err := NormalizeWorkflowSource(req.Source)
+	if err != nil {
+		return nil, err
+	}
+	var templateID *string
+	if req.TemplateID != "" {
+		if _, err := uuid.Parse(req.TemplateID); err != nil {
+			return nil, fmt.Errorf("%w: template_id must be a UUID", ErrInvalidTemplate)
+		}
+		templateID = &req.TemplateID
+	}
+
 	workflow := &models.Workflow{
 		Name:           req.Name,
 		Description:    req.Description,
 		Type:           models.WorkflowType(req.Type),
 		Priority:       models.WorkflowPriority(req.Priority),
+		// Escalated while the workflow is held, see FairShareScheduler
+		EffectivePriority: models.WorkflowPriority(req.Priority),
 		ProjectID:      req.ProjectID,
-		Status:         models.WorkflowStatusPending,
+		Status:         status,
 		Input:          req.Input,
-		Config:         req.Config,
+		Config:         config,
+		Tags:           tags,
+		Labels:         req.Labels,
+		Simulated:      simulation != nil,
+		RequestID:      requestid.FromContext(ctx),
+		Fingerprint:    fingerprint,
 		MaxRetries:     req.MaxRetries,
 		TimeoutSeconds: req.TimeoutSeconds,
+		SLASeconds:     req.SLASeconds,
+		SLAThreshold:   slaThreshold,
+		Budget:         budget,
+		TemplateID:     templateID,
+		Source:         source,
 		CreatedBy:      req.UserID,
 		UpdatedBy:      req.UserID,
 	}
+	metadata := map[string]interface{}{}
+	// Unknown config fields are ignored, but kept in sight of the operator
+	if len(configWarnings) > 0 {
+		metadata[ConfigWarningsMetadataKey] = configWarnings
+	}
+	if req.BypassCache {
+		metadata[BypassCacheMetadataKey] = true
+	}
+	if source != nil {
+		metadata[models.WorkflowSourceMetadataKey] = source
+	}
+	if len(metadata) > 0 {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal workflow metadata: %w", err)
+		}
+		workflow.Metadata = data
+	}
+	// Drafts are queued once submitted
+	if status != models.WorkflowStatusDraft {
+		now := time.Now()
+		workflow.QueuedAt = &now
+	}
+	return workflow, nil
+}
 
-	if err := e.db.Create(workflow).Error; err != nil {
-		return nil, fmt.Errorf("failed to create workflow record: %w", err)
+// launchWorkflow submits a stored pending workflow to Temporal, failing the
+// workflow when it cannot be submitted
+func (e *WorkflowEngine) launchWorkflow(ctx context.Context, workflow *models.Workflow) (*StartWorkflowResponse, error) {
+	// Workflows of projects over their fair share wait in the pending queue
+	if reason := e.scheduler.admit(ctx, workflow); reason != "" {
+		return &StartWorkflowResponse{
+			WorkflowID:       workflow.ID,
+			Status:           string(workflow.Status),
+			SchedulingReason: reason,
+		}, nil
+	}
+	return e.dispatchWorkflow(ctx, workflow)
+}
+
+// dispatchWorkflow starts a stored pending workflow without admission
+func (e *WorkflowEngine) dispatchWorkflow(ctx context.Context, workflow *models.Workflow) (*StartWorkflowResponse, error) {
+	if workflow.SLASeconds > 0 {
+		workflow.SLAStatus = models.SLAStatusOnTrack
+		slaTrackedTotal.WithLabelValues(workflow.ProjectID).Inc()
+	}
+	e.activity.Touch(workflow.ProjectID)
+
+	if err := e.submitWorkflow(ctx, workflow); err != nil {
+		// Temporal may have started the workflow after the request's deadline
+		// passed; it stays pending for the reaper to re-submit, which attaches
+		// to a started run
+		if ctx.Err() != nil || deadline.Exhausted(err) {
+			e.logger.Warn("Request deadline passed while starting workflow; left pending",
+				zap.String("workflow_id", workflow.ID), zap.Error(err))
+			return nil, fmt.Errorf("%w: workflow %s is pending: %v", deadline.ErrExhausted, workflow.ID, err)
+		}
+		// Update workflow status to failed
+		workflow.Status = models.WorkflowStatusFailed
+		workflow.Error = err.Error()
+		e.db.Save(workflow)
+		return nil, err
+	}
+
+	return &StartWorkflowResponse{
+		WorkflowID:    workflow.ID,
+		TemporalID:    workflow.TemporalID,
+		TemporalRunID: workflow.TemporalRunID,
+		Status:        string(workflow.Status),
+		Budget:        workflow.Budget,
+		EstimatedTime: workflow.EstimatedTime,
+		EstimatedCost: workflow.EstimatedCost,
+	}, nil
+}
+
+// submitWorkflow starts the Temporal execution for a stored workflow and
+// records its Temporal IDs. The workflow ID doubles as the Temporal workflow
+// ID, so submitting a workflow whose execution is already running attaches
+// to that run instead of starting another.
+func (e *WorkflowEngine) submitWorkflow(ctx context.Context, workflow *models.Workflow) error {
+	spec, err := e.workflowTypes.Resolve(workflow.Type)
+	if err != nil {
+		return err
+	}
+	taskQueue := spec.TaskQueue
+	if taskQueue == "" {
+		taskQueue = e.config.TaskQueue
 	}
 
 	// Prepare workflow options
 	workflowOptions := client.StartWorkflowOptions{
 		ID:                       workflow.ID,
-		TaskQueue:                e.config.TaskQueue,
+		TaskQueue:                taskQueue,
 		WorkflowExecutionTimeout: time.Duration(workflow.TimeoutSeconds) * time.Second,
 		WorkflowTaskTimeout:      10 * time.Minute,
 		RetryPolicy:              e.config.RetryPolicy,
+		Memo:                     workflowMemo(workflow),
+	}
+	if e.config.EnableSearchAttributes {
+		workflowOptions.SearchAttributes = workflowSearchAttributes(workflow)
 	}
 
 	// Start Temporal workflow
 	workflowRun, err := e.temporalClient.ExecuteWorkflow(
 		ctx,
 		workflowOptions,
-		e.getWorkflowFunction(workflow.Type),
+		spec.Workflow,
 		workflow,
 	)
 	if err != nil {
-		// Update workflow status to failed
-		workflow.Status = models.WorkflowStatusFailed
-		workflow.Error = err.Error()
-		e.db.Save(workflow)
-		return nil, fmt.Errorf("failed to start temporal workflow: %w", err)
+		return fmt.Errorf("failed to start temporal workflow: %w", err)
 	}
+	// The workflow is enqueued; recording it does not depend on the caller
+	// still waiting
+	ctx = context.WithoutCancel(ctx)
 
 	// Update workflow with Temporal IDs
 	workflow.TemporalID = workflowRun.GetID()
@@ -110,10 +348,13 @@ func (e *WorkflowEngine) StartWorkflow(ctx context.Context, req *StartWorkflowRe
 	workflow.Status = models.WorkflowStatusRunning
 	now := time.Now()
 	workflow.StartedAt = &now
+	observeQueueWait(workflow)
 
 	if err := e.db.Save(workflow).Error; err != nil {
 		e.logger.Error("failed to update workflow with temporal IDs", zap.Error(err))
 	}
+	e.recordFirstRun(ctx, workflow)
+	e.usage.RecordWorkflowStart(ctx, workflow)
 
 	// Store workflow state in Redis for quick access
 	e.cacheWorkflowState(ctx, workflow)
@@ -121,12 +362,7 @@ func (e *WorkflowEngine) StartWorkflow(ctx context.Context, req *StartWorkflowRe
 	// Emit workflow started event
 	e.emitWorkflowEvent(ctx, workflow, "started", nil)
 
-	return &StartWorkflowResponse{
-		WorkflowID:    workflow.ID,
-		TemporalID:    workflow.TemporalID,
-		TemporalRunID: workflow.TemporalRunID,
-		Status:        string(workflow.Status),
-	}, nil
+	return nil
 }
 
 // GetWorkflow retrieves workflow details
@@ -150,7 +386,7 @@ func (e *WorkflowEngine) GetWorkflow(ctx context.Context, workflowID string) (*m
 }
 
 // CancelWorkflow cancels a running workflow
-func (e *WorkflowEngine) CancelWorkflow(ctx context.Context, workflowID string, reason string) error {
+func (e *WorkflowEngine) CancelWorkflow(ctx context.Context, workflowID string, reason string, userID string) error {
 	workflow, err := e.GetWorkflow(ctx, workflowID)
 	if err != nil {
 		return err
@@ -165,35 +401,105 @@ func (e *WorkflowEngine) CancelWorkflow(ctx context.Context, workflowID string,
 		return fmt.Errorf("failed to cancel temporal workflow: %w", err)
 	}
 
-	// Update workflow status
+	// Update workflow status; the reason is kept apart from Error, as a
+	// cancellation is not a failure
 	workflow.Status = models.WorkflowStatusCancelled
-	workflow.Error = reason
+	workflow.CancelledReason = reason
+	workflow.CancelledBy = userID
 	now := time.Now()
+	workflow.CancelledAt = &now
 	workflow.CompletedAt = &now
+	if userID != "" {
+		workflow.UpdatedBy = userID
+	}
 
 	if err := e.db.Save(workflow).Error; err != nil {
 		return fmt.Errorf("failed to update workflow status: %w", err)
 	}
+	observeExecution(workflow)
+	if err := finalizeRun(ctx, e.db, workflow, workflow.TemporalRunID, runEnd{
+		status:      models.WorkflowStatusCancelled,
+		err:         reason,
+		completedAt: now.UTC(),
+	}); err != nil {
+		e.logger.Warn("failed to finalize workflow run",
+			zap.String("workflow_id", workflow.ID),
+			zap.Error(err))
+	}
 
 	// Update cache
 	e.cacheWorkflowState(ctx, workflow)
 
 	// Emit workflow cancelled event
-	e.emitWorkflowEvent(ctx, workflow, "cancelled", map[string]interface{}{"reason": reason})
+	e.emitWorkflowEvent(ctx, workflow, "cancelled", map[string]interface{}{
+		"reason":       reason,
+		"cancelled_by": userID,
+		"cancelled_at": now.UTC(),
+	})
 
 	return nil
 }
 
 // ListWorkflows lists workflows with filters
 func (e *WorkflowEngine) ListWorkflows(ctx context.Context, filters *WorkflowFilters) ([]*models.Workflow, int64, error) {
-	query := e.db.Model(&models.Workflow{})
+	query := applyWorkflowFilters(e.db.Model(&models.Workflow{}), filters)
 
-	// Apply filters
+	// Count total
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count workflows: %w", err)
+	}
+
+	query = applyWorkflowPagination(query, filters)
+
+	// Fetch workflows
+	var workflows []*models.Workflow
+	if err := query.Preload("Project").Find(&workflows).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list workflows: %w", err)
+	}
+
+	return workflows, total, nil
+}
+
+// ListWorkflowSummaries lists workflow summaries with filters.
+// Only the columns needed by list views are selected, so the jsonb payload
+// columns are never read and no associations are preloaded.
+func (e *WorkflowEngine) ListWorkflowSummaries(ctx context.Context, filters *WorkflowFilters) ([]*models.WorkflowSummary, int64, error) {
+	query := applyWorkflowFilters(e.db.WithContext(ctx).Model(&models.Workflow{}), filters)
+
+	// Count total on its own session so the select below starts from a clean statement
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count workflows: %w", err)
+	}
+
+	query = applyWorkflowPagination(query, filters)
+
+	// Fetch summaries
+	summaries := make([]*models.WorkflowSummary, 0)
+	if err := query.Select(workflowSummaryColumns + ", " + workflowSourceColumn(e.db)).Find(&summaries).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to list workflow summaries: %w", err)
+	}
+
+	return summaries, total, nil
+}
+
+// workflowSummaryColumns lists the columns selected for models.WorkflowSummary
+const workflowSummaryColumns = "id, name, type, status, priority, effective_priority, project_id, tags, labels, simulated, sla_status, created_at, started_at, " +
+	"completed_at, duration, SUBSTR(error, 1, 200) AS error, error_class, cancelled_reason, cancelled_by, cancelled_at, created_by, deleted_at"
+
+// applyWorkflowFilters applies workflow list filters to a query
+func applyWorkflowFilters(query *gorm.DB, filters *WorkflowFilters) *gorm.DB {
+	if filters.IncludeDeleted {
+		query = query.Unscoped()
+	}
 	if filters.ProjectID != "" {
 		query = query.Where("project_id = ?", filters.ProjectID)
 	}
 	if filters.Status != "" {
 		query = query.Where("status = ?", filters.Status)
+	} else if !filters.IncludeDrafts {
+		query = query.Where("status <> ?", models.WorkflowStatusDraft)
 	}
 	if filters.Type != "" {
 		query = query.Where("type = ?", filters.Type)
@@ -201,19 +507,21 @@ func (e *WorkflowEngine) ListWorkflows(ctx context.Context, filters *WorkflowFil
 	if filters.CreatedBy != "" {
 		query = query.Where("created_by = ?", filters.CreatedBy)
 	}
+	if filters.SLAStatus != "" {
+		query = query.Where("sla_status = ?", filters.SLAStatus)
+	}
 	if !filters.StartDate.IsZero() {
 		query = query.Where("created_at >= ?", filters.StartDate)
 	}
 	if !filters.EndDate.IsZero() {
 		query = query.Where("created_at <= ?", filters.EndDate)
 	}
+	query = applySourceFilters(query, filters.Commit, filters.Repository)
+	return applyTagFilters(query, filters.Tags, filters.Labels)
+}
 
-	// Count total
-	var total int64
-	if err := query.Count(&total).Error; err != nil {
-		return nil, 0, fmt.Errorf("failed to count workflows: %w", err)
-	}
-
+// applyWorkflowPagination applies sorting and pagination to a workflow query
+func applyWorkflowPagination(query *gorm.DB, filters *WorkflowFilters) *gorm.DB {
 	// Apply sorting
 	if filters.SortBy != "" {
 		order := "ASC"
@@ -232,14 +540,7 @@ func (e *WorkflowEngine) ListWorkflows(ctx context.Context, filters *WorkflowFil
 	if filters.Offset > 0 {
 		query = query.Offset(filters.Offset)
 	}
-
-	// Fetch workflows
-	var workflows []*models.Workflow
-	if err := query.Preload("Project").Find(&workflows).Error; err != nil {
-		return nil, 0, fmt.Errorf("failed to list workflows: %w", err)
-	}
-
-	return workflows, total, nil
+	return query
 }
 
 // GetWorkflowMetrics retrieves workflow metrics
@@ -255,6 +556,7 @@ func (e *WorkflowEngine) GetWorkflowMetrics(ctx context.Context, workflowID stri
 		StartedAt:      workflow.StartedAt,
 		CompletedAt:    workflow.CompletedAt,
 		Duration:       workflow.Duration,
+		DurationText:   models.HumanDuration(workflow.Duration),
 		RetryCount:     workflow.RetryCount,
 		StepMetrics:    make([]*StepMetric, 0),
 		ResourceUsage:  make(map[string]interface{}),
@@ -263,13 +565,14 @@ func (e *WorkflowEngine) GetWorkflowMetrics(ctx context.Context, workflowID stri
 	// Get step metrics
 	for _, step := range workflow.Steps {
 		stepMetric := &StepMetric{
-			StepID:      step.ID,
-			Name:        step.Name,
-			Status:      string(step.Status),
-			StartedAt:   step.StartedAt,
-			CompletedAt: step.CompletedAt,
-			Duration:    step.Duration,
-			RetryCount:  step.RetryCount,
+			StepID:       step.ID,
+			Name:         step.Name,
+			Status:       string(step.Status),
+			StartedAt:    step.StartedAt,
+			CompletedAt:  step.CompletedAt,
+			Duration:     step.Duration,
+			DurationText: models.HumanDuration(step.Duration),
+			RetryCount:   step.RetryCount,
 		}
 		metrics.StepMetrics = append(metrics.StepMetrics, stepMetric)
 	}
@@ -292,29 +595,19 @@ func (e *WorkflowEngine) GetWorkflowMetrics(ctx context.Context, workflowID stri
 		metrics.ResourceUsage["execution_count"] = len(executions)
 	}
 
+	// Time spent waiting in queues apart from time spent running
+	metrics.Timing = e.workflowTiming(ctx, workflow, executions)
+
+	// Task execution workflows record which related tasks reused an agent
+	metrics.Affinity = affinityFromMetadata(workflow.Metadata)
+
 	return metrics, nil
 }
 
-// getWorkflowFunction returns the appropriate workflow function name based on type
-func (e *WorkflowEngine) getWorkflowFunction(workflowType models.WorkflowType) interface{} {
-	// Return workflow function names as strings
-	// The actual workflow functions will be registered separately with the Temporal worker
-	switch workflowType {
-	case models.WorkflowTypeIntent:
-		return "IntentProcessingWorkflow"
-	case models.WorkflowTypeExecution:
-		return "CodeExecutionWorkflow"
-	case models.WorkflowTypeAnalysis:
-		return "CodeAnalysisWorkflow"
-	case models.WorkflowTypeReview:
-		return "CodeReviewWorkflow"
-	case models.WorkflowTypeDeployment:
-		return "DeploymentWorkflow"
-	case models.WorkflowTypeTaskExecution:
-		return "TaskExecutionWorkflow"
-	default:
-		return "CustomWorkflow"
-	}
+// cache returns the guard of the Redis client, which falls back while Redis
+// is unreachable
+func (e *WorkflowEngine) cache() *RedisGuard {
+	return RedisGuardFor(e.redis, e.logger)
 }
 
 // cacheWorkflowState caches workflow state in Redis
@@ -326,17 +619,16 @@ func (e *WorkflowEngine) cacheWorkflowState(ctx context.Context, workflow *model
 		return
 	}
 
-	if err := e.redis.Set(ctx, key, data, 5*time.Minute).Err(); err != nil {
-		e.logger.Error("failed to cache workflow state", zap.Error(err))
-	}
+	e.cache().Set(ctx, key, data, 5*time.Minute)
 }
 
-// getCachedWorkflow retrieves workflow from cache
+// getCachedWorkflow retrieves workflow from cache. While Redis is
+// unreachable every read is a miss.
 func (e *WorkflowEngine) getCachedWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error) {
 	key := fmt.Sprintf("workflow:%s", workflowID)
-	data, err := e.redis.Get(ctx, key).Result()
-	if err != nil {
-		return nil, err
+	data, ok := e.cache().Get(ctx, key)
+	if !ok {
+		return nil, redis.Nil
 	}
 
 	var workflow models.Workflow
@@ -358,32 +650,72 @@ func (e *WorkflowEngine) emitWorkflowEvent(ctx context.Context, workflow *models
 		"timestamp":   time.Now(),
 		"data":        data,
 	}
+	if requestID := requestid.FromContext(ctx); requestID != "" {
+		event[requestid.Field] = requestID
+	} else if workflow.RequestID != "" {
+		event[requestid.Field] = workflow.RequestID
+	}
 
 	eventData, err := json.Marshal(event)
 	if err != nil {
 		e.logger.Error("failed to marshal workflow event", zap.Error(err))
 		return
 	}
+	eventData = e.redactors.For(ctx, workflow.ProjectID).JSON(eventData)
 
-	// Publish event to Redis
+	// Publish event to Redis, buffered while it is unreachable; with an
+	// event queue the event is queued instead of published on this path
 	channel := fmt.Sprintf("workflow:events:%s", workflow.ProjectID)
-	if err := e.redis.Publish(ctx, channel, eventData).Err(); err != nil {
+	if e.events != nil {
+		e.events.Enqueue(channel, eventData, WorkflowEventPriority(eventType, workflow))
+		return
+	}
+	if err := e.cache().Publish(ctx, channel, eventData); err != nil {
 		e.logger.Error("failed to publish workflow event", zap.Error(err))
 	}
 }
 
 // StartWorkflowRequest represents a request to start a workflow
 type StartWorkflowRequest struct {
-	Name           string          `json:"name"`
-	Description    string          `json:"description"`
-	Type           string          `json:"type"`
-	Priority       string          `json:"priority"`
-	ProjectID      string          `json:"project_id"`
-	UserID         string          `json:"user_id"`
-	Input          json.RawMessage `json:"input"`
-	Config         json.RawMessage `json:"config"`
-	MaxRetries     int             `json:"max_retries"`
-	TimeoutSeconds int             `json:"timeout_seconds"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	Type           string            `json:"type"`
+	Priority       string            `json:"priority"`
+	ProjectID      string            `json:"project_id"`
+	UserID         string            `json:"user_id"`
+	Input          json.RawMessage   `json:"input"`
+	Config         json.RawMessage   `json:"config"`
+	Tags           []string          `json:"tags"`
+	Labels         map[string]string `json:"labels"`
+	MaxRetries     int               `json:"max_retries"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+
+	// SLASeconds is the expected completion time; the workflow is at risk
+	// once SLAWarningThreshold (a fraction, default 0.8) of it has elapsed
+	SLASeconds          int     `json:"sla_seconds"`
+	SLAWarningThreshold float64 `json:"sla_warning_threshold"`
+
+	// Budget limits the estimated cost and time of an intent; intent
+	// workflows without one use their project's default budget
+	Budget *models.WorkflowBudget `json:"budget,omitempty"`
+
+	// BypassCache makes code analysis ignore cached reports, see
+	// BypassCacheMetadataKey
+	BypassCache bool `json:"bypass_cache,omitempty"`
+
+	// TemplateID is the workflow template the workflow is started from,
+	// counted in the template's usage stats
+	TemplateID string `json:"template_id,omitempty"`
+
+	// Dedupe is what happens when a workflow with the same type and input
+	// is in flight in the project: DedupeWarn (default), DedupeReuse or
+	// DedupeStrict
+	Dedupe string `json:"dedupe,omitempty"`
+
+	// Source is the git context of a workflow started by CI, kept in the
+	// workflow's metadata; code analysis and review default their
+	// repository, branch and commit to it
+	Source *models.WorkflowSource `json:"source,omitempty"`
 }
 
 // StartWorkflowResponse represents a response from starting a workflow
@@ -392,6 +724,19 @@ type StartWorkflowResponse struct {
 	TemporalID    string `json:"temporal_id"`
 	TemporalRunID string `json:"temporal_run_id"`
 	Status        string `json:"status"`
+	// SchedulingReason is why the fair-share scheduler holds the workflow
+	// in the pending queue
+	SchedulingReason string `json:"scheduling_reason,omitempty"`
+	// Budget and the intent's estimates, once intent analysis made them
+	Budget        *models.WorkflowBudget `json:"budget,omitempty"`
+	EstimatedTime *int                   `json:"estimated_time,omitempty"`
+	EstimatedCost *float64               `json:"estimated_cost,omitempty"`
+	// DuplicateOf is the workflow in flight with the same type and input,
+	// which Warning describes; Reused is set when the response is that
+	// workflow's, see StartWorkflowRequest.Dedupe
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	Warning     string `json:"warning,omitempty"`
+	Reused      bool   `json:"reused,omitempty"`
 }
 
 // WorkflowFilters represents filters for listing workflows
@@ -402,10 +747,23 @@ type WorkflowFilters struct {
 	CreatedBy string
 	StartDate time.Time
 	EndDate   time.Time
+	Tags      []string          // Workflows must carry all of these tags
+	Labels    map[string]string // Workflows must carry all of these key=value labels
+	SLAStatus string
+	Commit     string // Workflows started for this commit SHA, see WorkflowSource
+	Repository string // Workflows started for this repository
 	SortBy    string
 	SortDesc  bool
 	Limit     int
 	Offset    int
+
+	// IncludeDrafts lists drafts along with other workflows; without a
+	// Status filter they are left out
+	IncludeDrafts bool
+
+	// IncludeDeleted lists soft-deleted workflows too; only admin endpoints
+	// set it
+	IncludeDeleted bool
 }
 
 // WorkflowMetrics represents workflow metrics
@@ -414,19 +772,24 @@ type WorkflowMetrics struct {
 	Status        string                 `json:"status"`
 	StartedAt     *time.Time             `json:"started_at"`
 	CompletedAt   *time.Time             `json:"completed_at"`
-	Duration      int64                  `json:"duration"`
+	Duration      int64                  `json:"duration_ms"`
+	DurationText  string                 `json:"duration_text,omitempty"`
 	RetryCount    int                    `json:"retry_count"`
 	StepMetrics   []*StepMetric          `json:"step_metrics"`
 	ResourceUsage map[string]interface{} `json:"resource_usage"`
+	Affinity      *AffinityMetrics       `json:"affinity,omitempty"` // Task group agent reuse, for task execution workflows
+	Timing        *WorkflowTiming        `json:"timing"`             // Queue wait apart from execution time
 }
 
 // StepMetric represents metrics for a workflow step
+
 type StepMetric struct {
-	StepID      string     `json:"step_id"`
-	Name        string     `json:"name"`
-	Status      string     `json:"status"`
-	StartedAt   *time.Time `json:"started_at"`
-	CompletedAt *time.Time `json:"completed_at"`
-	Duration    int64      `json:"duration"`
-	RetryCount  int        `json:"retry_count"`
+	StepID       string     `json:"step_id"`
+	Name         string     `json:"name"`
+	Status       string     `json:"status"`
+	StartedAt    *time.Time `json:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	Duration     int64      `json:"duration_ms"`
+	DurationText string     `json:"duration_text,omitempty"`
+	RetryCount   int        `json:"retry_count"`
 }
\ No newline at end of file
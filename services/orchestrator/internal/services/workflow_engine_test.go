@@ -3,6 +3,9 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,8 +16,10 @@ import (
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/mocks"
 	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 // Mock clients
@@ -31,37 +36,63 @@ type MockAgentClient struct {
 	mock.Mock
 }
 
-func setupTestDB(t *testing.T) *gorm.DB {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+// setupWorkflowTestDB creates an sqlite database holding only the workflows table.
+// The uuid column default is Postgres-only, so it is stripped from the parsed
+// schema and IDs are assigned by a create callback instead.
+func setupWorkflowTestDB(t testing.TB) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
 	assert.NoError(t, err)
 
-	// Auto-migrate test models
-	err = db.AutoMigrate(&models.Workflow{}, &models.WorkflowStep{}, &models.Execution{})
+	stmt := &gorm.Statement{DB: db}
+	assert.NoError(t, stmt.Parse(&models.Workflow{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+
+	var seq int
+	err = db.Callback().Create().Before("gorm:create").Register("test:assign_id", func(tx *gorm.DB) {
+		if workflow, ok := tx.Statement.Dest.(*models.Workflow); ok && workflow.ID == "" {
+			seq++
+			workflow.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", seq)
+		}
+	})
 	assert.NoError(t, err)
+	assert.NoError(t, db.Migrator().CreateTable(&models.Workflow{}))
 
 	return db
 }
 
+// setupWorkflowChildTables adds the workflow_steps and executions tables that
+// GetWorkflow preloads, with the columns the engine tests read
+func setupWorkflowChildTables(t testing.TB, db *gorm.DB) {
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT, type TEXT, status TEXT,
+			started_at DATETIME, completed_at DATETIME, duration INTEGER, retry_count INTEGER)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, resource_usage BLOB, created_at DATETIME,
+			started_at DATETIME, completed_at DATETIME, deleted_at DATETIME)`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+}
+
+// setupTestRedis returns a client for an unreachable Redis: cache writes and
+// event publishing only log errors, so the engine runs without a server
 func setupTestRedis() *redis.Client {
-	// Use redis mock or miniredis for testing
-	return redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   15, // Use a separate DB for testing
-	})
+	return redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
 }
 
 func TestWorkflowEngine_StartWorkflow(t *testing.T) {
 	// Setup
-	db := setupTestDB(t)
+	db := setupWorkflowTestDB(t)
 	redisClient := setupTestRedis()
 	logger := zap.NewNop()
 	
 	mockTemporalClient := new(mocks.Client)
 	mockWorkflowRun := new(mocks.WorkflowRun)
 	
-	mockIntentClient := &MockIntentClient{}
-	mockAgentClient := &MockAgentClient{}
-	
 	config := &WorkflowConfig{
 		TaskQueue:               "test-queue",
 		MaxConcurrentWorkflows:  10,
@@ -75,10 +106,11 @@ func TestWorkflowEngine_StartWorkflow(t *testing.T) {
 		redisClient,
 		mockTemporalClient,
 		logger,
-		mockIntentClient,
-		mockAgentClient,
+		nil,
+		nil,
 		config,
 	)
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
 
 	// Test data
 	req := &StartWorkflowRequest{
@@ -124,9 +156,50 @@ func TestWorkflowEngine_StartWorkflow(t *testing.T) {
 	assert.Equal(t, models.WorkflowStatusRunning, workflow.Status)
 }
 
+func TestWorkflowEngine_StartWorkflowUnknownProject(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+
+	// Recreate the workflows table with the project foreign key the migrations add
+	var ddl string
+	assert.NoError(t, db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'workflows'").Scan(&ddl).Error)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`,
+		`INSERT INTO projects (id) VALUES ('project-1')`,
+		`DROP TABLE workflows`,
+		strings.TrimSuffix(ddl, ")") + `,CONSTRAINT fk_workflows_project_id FOREIGN KEY (project_id) REFERENCES projects(id))`,
+		`PRAGMA foreign_keys = ON`,
+	} {
+		assert.NoError(t, db.Exec(stmt).Error)
+	}
+
+	mockTemporalClient := new(mocks.Client)
+	mockWorkflowRun := new(mocks.WorkflowRun)
+	mockWorkflowRun.On("GetID").Return("temporal-workflow-id")
+	mockWorkflowRun.On("GetRunID").Return("temporal-run-id")
+	mockTemporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockWorkflowRun, nil)
+	engine := NewWorkflowEngine(db, setupTestRedis(), mockTemporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+
+	start := func(projectID string) error {
+		_, err := engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+			Name: "Test Workflow", Type: "intent_processing", ProjectID: projectID, UserID: "test-user-id",
+			Input: json.RawMessage(`{"test": "data"}`),
+		})
+		return err
+	}
+
+	// The violated foreign key is reported as the unknown project, not a database failure
+	err := start("missing-project")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+	assert.Contains(t, err.Error(), "missing-project")
+	assert.NoError(t, start("project-1"))
+	mockTemporalClient.AssertNumberOfCalls(t, "ExecuteWorkflow", 1)
+}
+
 func TestWorkflowEngine_GetWorkflow(t *testing.T) {
 	// Setup
-	db := setupTestDB(t)
+	db := setupWorkflowTestDB(t)
+	setupWorkflowChildTables(t, db)
 	redisClient := setupTestRedis()
 	logger := zap.NewNop()
 	
@@ -161,7 +234,8 @@ func TestWorkflowEngine_GetWorkflow(t *testing.T) {
 
 func TestWorkflowEngine_CancelWorkflow(t *testing.T) {
 	// Setup
-	db := setupTestDB(t)
+	db := setupWorkflowTestDB(t)
+	setupWorkflowChildTables(t, db)
 	redisClient := setupTestRedis()
 	logger := zap.NewNop()
 	
@@ -197,7 +271,7 @@ func TestWorkflowEngine_CancelWorkflow(t *testing.T) {
 	).Return(nil)
 
 	// Execute
-	err = engine.CancelWorkflow(context.Background(), workflow.ID, "Test cancellation")
+	err = engine.CancelWorkflow(context.Background(), workflow.ID, "Test cancellation", "test-user")
 
 	// Assert
 	assert.NoError(t, err)
@@ -207,13 +281,19 @@ func TestWorkflowEngine_CancelWorkflow(t *testing.T) {
 	err = db.First(&updatedWorkflow, "id = ?", workflow.ID).Error
 	assert.NoError(t, err)
 	assert.Equal(t, models.WorkflowStatusCancelled, updatedWorkflow.Status)
-	assert.Equal(t, "Test cancellation", updatedWorkflow.Error)
+	assert.Empty(t, updatedWorkflow.Error)
+	assert.Equal(t, "Test cancellation", updatedWorkflow.CancelledReason)
+	assert.Equal(t, "test-user", updatedWorkflow.CancelledBy)
+	assert.NotNil(t, updatedWorkflow.CancelledAt)
 	assert.NotNil(t, updatedWorkflow.CompletedAt)
 }
 
 func TestWorkflowEngine_ListWorkflows(t *testing.T) {
 	// Setup
-	db := setupTestDB(t)
+	db := setupWorkflowTestDB(t)
+	// Listed workflows preload their project
+	err := db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, name TEXT, deleted_at DATETIME)`).Error
+	assert.NoError(t, err)
 	redisClient := setupTestRedis()
 	logger := zap.NewNop()
 	
@@ -324,7 +404,8 @@ func TestWorkflowEngine_ListWorkflows(t *testing.T) {
 
 func TestWorkflowEngine_GetWorkflowMetrics(t *testing.T) {
 	// Setup
-	db := setupTestDB(t)
+	db := setupWorkflowTestDB(t)
+	setupWorkflowChildTables(t, db)
 	redisClient := setupTestRedis()
 	logger := zap.NewNop()
 	
@@ -346,7 +427,7 @@ func TestWorkflowEngine_GetWorkflowMetrics(t *testing.T) {
 		ProjectID:   "test-project-id",
 		StartedAt:   &startTime,
 		CompletedAt: &endTime,
-		Duration:    3600, // 1 hour in seconds
+		Duration:    3600000, // 1 hour in milliseconds
 		RetryCount:  1,
 		CreatedBy:   "test-user",
 		UpdatedBy:   "test-user",
@@ -354,30 +435,13 @@ func TestWorkflowEngine_GetWorkflowMetrics(t *testing.T) {
 	err := db.Create(workflow).Error
 	assert.NoError(t, err)
 
-	// Create workflow steps
-	steps := []models.WorkflowStep{
-		{
-			WorkflowID:  workflow.ID,
-			Name:        "Step 1",
-			Type:        "action",
-			Status:      models.WorkflowStatusCompleted,
-			StartedAt:   &startTime,
-			CompletedAt: &endTime,
-			Duration:    1800000, // 30 minutes in milliseconds
-		},
-		{
-			WorkflowID:  workflow.ID,
-			Name:        "Step 2",
-			Type:        "action",
-			Status:      models.WorkflowStatusCompleted,
-			StartedAt:   &startTime,
-			CompletedAt: &endTime,
-			Duration:    1800000, // 30 minutes in milliseconds
-		},
-	}
-	
-	for _, step := range steps {
-		err := db.Create(&step).Error
+	// Create workflow steps; depends_on is a Postgres array, so the rows are inserted directly
+	for i, name := range []string{"Step 1", "Step 2"} {
+		err := db.Exec(`INSERT INTO workflow_steps (id, workflow_id, name, type, status, started_at, completed_at, duration)
+			VALUES (?, ?, ?, 'action', ?, ?, ?, ?)`,
+			fmt.Sprintf("step-%d", i+1), workflow.ID, name, models.WorkflowStatusCompleted,
+			startTime, endTime, 1800000, // 30 minutes in milliseconds
+		).Error
 		assert.NoError(t, err)
 	}
 
@@ -389,6 +453,347 @@ func TestWorkflowEngine_GetWorkflowMetrics(t *testing.T) {
 	assert.NotNil(t, metrics)
 	assert.Equal(t, workflow.ID, metrics.WorkflowID)
 	assert.Equal(t, "completed", metrics.Status)
-	assert.Equal(t, int64(3600), metrics.Duration)
+	assert.Equal(t, int64(3600000), metrics.Duration)
 	assert.Len(t, metrics.StepMetrics, 2)
-}
\ No newline at end of file
+}
+
+func TestWorkflowEngine_ListWorkflowSummaries(t *testing.T) {
+	// Setup
+	db := setupWorkflowTestDB(t)
+	redisClient := setupTestRedis()
+	logger := zap.NewNop()
+
+	engine := &WorkflowEngine{
+		db:     db,
+		redis:  redisClient,
+		logger: logger,
+	}
+
+	longError := strings.Repeat("x", 500)
+	workflow := &models.Workflow{
+		Name:      "Summary Workflow",
+		Type:      models.WorkflowTypeIntent,
+		Status:    models.WorkflowStatusFailed,
+		ProjectID: "test-project-id",
+		Input:     json.RawMessage(`{"payload": "large"}`),
+		Output:    json.RawMessage(`{"result": "large"}`),
+		Error:     longError,
+		CreatedBy: "user1",
+		UpdatedBy: "user1",
+	}
+	err := db.Create(workflow).Error
+	assert.NoError(t, err)
+
+	// Execute
+	summaries, total, err := engine.ListWorkflowSummaries(context.Background(), &WorkflowFilters{
+		ProjectID: "test-project-id",
+	})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, workflow.Name, summaries[0].Name)
+	assert.Equal(t, models.WorkflowStatusFailed, summaries[0].Status)
+	assert.Len(t, summaries[0].Error, 200)
+}
+
+func TestWorkflowEngine_ListWorkflowSummariesSkipsPayloadColumns(t *testing.T) {
+	// Setup a dry-run database that only records the generated SQL
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{DryRun: true, Logger: recorder})
+	assert.NoError(t, err)
+
+	engine := &WorkflowEngine{
+		db:     db,
+		logger: zap.NewNop(),
+	}
+
+	// Execute
+	_, _, err = engine.ListWorkflowSummaries(context.Background(), &WorkflowFilters{
+		ProjectID: "test-project-id",
+		Limit:     10,
+	})
+	assert.NoError(t, err)
+
+	// Assert the list query never touches the jsonb columns or associations
+	assert.Len(t, recorder.statements, 2) // count + select
+	selectSQL := recorder.statements[len(recorder.statements)-1]
+	for _, column := range []string{"input", "output", "config", "projects"} {
+		assert.NotContains(t, selectSQL, column)
+	}
+	// Only the source is extracted from the metadata
+	assert.NotRegexp(t, `[ ,]metadata[ ,]`, selectSQL)
+	assert.Contains(t, selectSQL, "json_extract(metadata, '$.source')")
+	assert.Contains(t, selectSQL, "SUBSTR(error, 1, 200)")
+}
+
+func BenchmarkWorkflowEngine_ListWorkflows(b *testing.B) {
+	engine := setupBenchmarkEngine(b)
+	filters := &WorkflowFilters{ProjectID: "bench-project-id", Limit: 50}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := engine.ListWorkflows(context.Background(), filters); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWorkflowEngine_ListWorkflowSummaries(b *testing.B) {
+	engine := setupBenchmarkEngine(b)
+	filters := &WorkflowFilters{ProjectID: "bench-project-id", Limit: 50}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := engine.ListWorkflowSummaries(context.Background(), filters); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// setupBenchmarkEngine seeds workflows with large payloads for list benchmarks
+func setupBenchmarkEngine(b *testing.B) *WorkflowEngine {
+	db := setupWorkflowTestDB(b)
+	payload := json.RawMessage(fmt.Sprintf(`{"data": %q}`, strings.Repeat("x", 64*1024)))
+
+	for i := 0; i < 200; i++ {
+		workflow := &models.Workflow{
+			Name:      fmt.Sprintf("Bench Workflow %d", i),
+			Type:      models.WorkflowTypeExecution,
+			Status:    models.WorkflowStatusCompleted,
+			ProjectID: "bench-project-id",
+			Input:     payload,
+			Output:    payload,
+			CreatedBy: "bench-user",
+			UpdatedBy: "bench-user",
+		}
+		if err := db.Create(workflow).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return &WorkflowEngine{
+		db:     db,
+		redis:  setupTestRedis(),
+		logger: zap.NewNop(),
+	}
+}
+
+// sqlRecorder is a GORM logger that records every traced SQL statement
+type sqlRecorder struct {
+	logger.Interface
+	statements []string
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+func TestWorkflowEngine_ListWorkflowSummariesFiltersByTagsAndLabels(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	engine := NewWorkflowEngine(db, nil, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	seed := []*models.Workflow{
+		{Name: "checkout-api", Tags: models.StringArray{"team:payments", "JIRA-1"}, Labels: models.Labels{"env": "prod", "tier": "1"}},
+		{Name: "checkout-ui", Tags: models.StringArray{"team:payments"}, Labels: models.Labels{"env": "staging"}},
+		{Name: "search", Tags: models.StringArray{"team:search", "JIRA-1"}, Labels: models.Labels{"env": "prod"}},
+		{Name: "underscore", Tags: models.StringArray{"team_payments"}},
+		{Name: "untagged"},
+	}
+	for _, workflow := range seed {
+		workflow.Type = models.WorkflowTypeCustom
+		workflow.ProjectID = "test-project-id"
+		assert.NoError(t, db.Create(workflow).Error)
+	}
+
+	names := func(filters *WorkflowFilters) []string {
+		summaries, total, err := engine.ListWorkflowSummaries(context.Background(), filters)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(summaries)), total)
+		result := make([]string, 0, len(summaries))
+		for _, summary := range summaries {
+			result = append(result, summary.Name)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		filters  *WorkflowFilters
+		expected []string
+	}{
+		{"single tag", &WorkflowFilters{Tags: []string{"team:payments"}}, []string{"checkout-api", "checkout-ui"}},
+		{"tags are ANDed", &WorkflowFilters{Tags: []string{"team:payments", "JIRA-1"}}, []string{"checkout-api"}},
+		{"no partial tag match", &WorkflowFilters{Tags: []string{"team"}}, []string{}},
+		{"underscore is literal", &WorkflowFilters{Tags: []string{"team_payments"}}, []string{"underscore"}},
+		{"label", &WorkflowFilters{Labels: map[string]string{"env": "prod"}}, []string{"checkout-api", "search"}},
+		{"labels are ANDed", &WorkflowFilters{Labels: map[string]string{"env": "prod", "tier": "1"}}, []string{"checkout-api"}},
+		{"tag and label", &WorkflowFilters{Tags: []string{"JIRA-1"}, Labels: map[string]string{"env": "prod"}}, []string{"checkout-api", "search"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.SortBy = "name"
+			assert.Equal(t, tt.expected, names(tt.filters))
+		})
+	}
+}
+
+func TestApplyTagFilters_PostgresUsesContainment(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	assert.NoError(t, err)
+
+	var workflows []*models.Workflow
+	stmt := applyTagFilters(db.Model(&models.Workflow{}), []string{"a", "b"}, map[string]string{"env": "prod"}).
+		Find(&workflows).Statement
+
+	assert.Contains(t, stmt.SQL.String(), "tags @> $1::text[]")
+	assert.Contains(t, stmt.SQL.String(), "labels @> $2::jsonb")
+	assert.Equal(t, models.StringArray{"a", "b"}, stmt.Vars[0])
+	assert.Equal(t, `{"env":"prod"}`, stmt.Vars[1])
+}
+
+func TestWorkflowEngine_UpdateWorkflowTags(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	engine := NewWorkflowEngine(db, nil, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	workflow := &models.Workflow{
+		Name:      "tagged",
+		Type:      models.WorkflowTypeCustom,
+		ProjectID: "test-project-id",
+		Tags:      models.StringArray{"a", "b"},
+	}
+	assert.NoError(t, db.Create(workflow).Error)
+
+	updated, err := engine.UpdateWorkflowTags(context.Background(), workflow.ID, nil, []string{"c", "a"}, []string{"b"})
+	assert.NoError(t, err)
+	assert.Equal(t, models.StringArray{"a", "c"}, updated.Tags)
+
+	replace := []string{"x"}
+	updated, err = engine.UpdateWorkflowTags(context.Background(), workflow.ID, &replace, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, models.StringArray{"x"}, updated.Tags)
+
+	var stored models.Workflow
+	assert.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.Equal(t, models.StringArray{"x"}, stored.Tags)
+
+	_, err = engine.UpdateWorkflowTags(context.Background(), workflow.ID, nil, []string{"bad tag"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidTags)
+
+	_, err = engine.UpdateWorkflowTags(context.Background(), "missing", nil, []string{"a"}, nil)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestWorkflowEngine_UpdateWorkflowTagsConcurrently(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	// One connection to the in-memory database, shared like a pool would be
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	engine := NewWorkflowEngine(db, nil, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	workflow := &models.Workflow{Name: "tagged", Type: models.WorkflowTypeCustom, ProjectID: "test-project-id"}
+	assert.NoError(t, db.Create(workflow).Error)
+
+	// Widen the window between reading the tags and writing them back
+	assert.NoError(t, db.Callback().Query().After("gorm:query").Register("test:slow_read", func(tx *gorm.DB) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+
+	// No update is lost to another's read-modify-write
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := engine.UpdateWorkflowTags(context.Background(), workflow.ID, nil, []string{fmt.Sprintf("tag-%d", i)}, nil)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	var stored models.Workflow
+	assert.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.ElementsMatch(t, models.StringArray{"tag-0", "tag-1", "tag-2", "tag-3"}, stored.Tags)
+}
+
+func TestWorkflowEngine_StartWorkflowPropagatesTagsToTemporal(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	// Unreachable Redis: cache writes and event publishing only log errors
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+
+	tests := []struct {
+		name                   string
+		enableSearchAttributes bool
+	}{
+		{"memo only", false},
+		{"memo and search attributes", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTemporalClient := new(mocks.Client)
+			mockWorkflowRun := new(mocks.WorkflowRun)
+			engine := NewWorkflowEngine(db, redisClient, mockTemporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{
+				TaskQueue:              "test-queue",
+				EnableSearchAttributes: tt.enableSearchAttributes,
+			})
+			engine.SetWorkflowTypes(testWorkflowTypes(t))
+
+			mockWorkflowRun.On("GetID").Return("temporal-id")
+			mockWorkflowRun.On("GetRunID").Return("run-id")
+			mockTemporalClient.On("ExecuteWorkflow",
+				mock.Anything,
+				mock.MatchedBy(func(opts client.StartWorkflowOptions) bool {
+					if !assert.ObjectsAreEqual([]string{"team:payments"}, opts.Memo["tags"]) ||
+						!assert.ObjectsAreEqual(map[string]string{"env": "prod"}, opts.Memo["labels"]) {
+						return false
+					}
+					if tt.enableSearchAttributes {
+						return assert.ObjectsAreEqual([]string{"team:payments"}, opts.SearchAttributes["Tags"])
+					}
+					return opts.SearchAttributes == nil
+				}),
+				mock.Anything,
+				mock.Anything,
+			).Return(mockWorkflowRun, nil)
+
+			resp, err := engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+				Name:      "Tagged Workflow",
+				Type:      string(models.WorkflowTypeCustom),
+				ProjectID: "test-project-id",
+				UserID:    "test-user-id",
+				Tags:      []string{" team:payments", "team:payments"},
+				Labels:    map[string]string{"env": "prod"},
+			})
+			assert.NoError(t, err)
+			mockTemporalClient.AssertExpectations(t)
+
+			var stored models.Workflow
+			assert.NoError(t, db.First(&stored, "id = ?", resp.WorkflowID).Error)
+			assert.Equal(t, models.StringArray{"team:payments"}, stored.Tags)
+			assert.Equal(t, models.Labels{"env": "prod"}, stored.Labels)
+		})
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{"b", " a ", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, tags)
+
+	for _, invalid := range []string{"", "has space", `quote"`, "percent%", strings.Repeat("x", 64)} {
+		_, err := normalizeTags([]string{invalid})
+		assert.ErrorIs(t, err, ErrInvalidTags, invalid)
+	}
+}
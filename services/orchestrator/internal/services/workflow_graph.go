@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// Graph layouts
+const (
+	GraphLayoutDAG    = "dag"
+	GraphLayoutLinear = "linear"
+)
+
+// Node states, the statuses of steps and executions collapsed for coloring
+const (
+	NodeStatePending   = "pending"
+	NodeStateRunning   = "running"
+	NodeStateCompleted = "completed"
+	NodeStateFailed    = "failed"
+	NodeStateCancelled = "cancelled"
+	NodeStateSkipped   = "skipped"
+)
+
+// WorkflowGraph is the step graph of a workflow for rendering as a DAG
+type WorkflowGraph struct {
+	WorkflowID string                `json:"workflow_id"`
+	Status     models.WorkflowStatus `json:"status"`
+	Source     string                `json:"source"` // steps, plan, tasks or executions
+	Layout     string                `json:"layout"`
+	Nodes      []GraphNode           `json:"nodes"`
+	Edges      []GraphEdge           `json:"edges"`
+	Ranks      int                   `json:"ranks"`
+	Cycles     [][]string            `json:"cycles,omitempty"`
+	Warnings   []string              `json:"warnings,omitempty"`
+}
+
+// GraphNode is a step, planned step, task or execution of a workflow
+type GraphNode struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Kind        string     `json:"kind"`
+	Type        string     `json:"type,omitempty"`
+	Status      string     `json:"status"`
+	State       string     `json:"state"`
+	Rank        int        `json:"rank"`
+	Duration    int64      `json:"duration_ms"`
+	Artifacts   int        `json:"artifacts"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// GraphEdge is a dependency; From must finish before To starts
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// WorkflowGraphBuilder assembles workflow graphs from stored data
+type WorkflowGraphBuilder struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+// NewWorkflowGraphBuilder creates a graph builder
+func NewWorkflowGraphBuilder(db *gorm.DB) *WorkflowGraphBuilder {
+	return &WorkflowGraphBuilder{db: db, now: time.Now}
+}
+
+// graphPlan is the part of a workflow input that describes planned work:
+// an execution plan for intent workflows, tasks for task workflows
+type graphPlan struct {
+	ExecutionPlan *struct {
+		Steps []struct {
+			ID        string   `json:"id"`
+			Name      string   `json:"name"`
+			Type      string   `json:"type"`
+			DependsOn []string `json:"depends_on"`
+		} `json:"steps"`
+	} `json:"execution_plan"`
+	Tasks []struct {
+		ID           string   `json:"id"`
+		Title        string   `json:"title"`
+		Type         string   `json:"type"`
+		Dependencies []string `json:"dependencies"`
+	} `json:"tasks"`
+}
+
+// Build assembles the graph of a workflow. Nodes come from the workflow's
+// steps, else the plan or tasks in its input, else its executions. It
+// returns gorm.ErrRecordNotFound for unknown workflows.
+func (b *WorkflowGraphBuilder) Build(ctx context.Context, workflowID string) (*WorkflowGraph, error) {
+	db := b.db.WithContext(ctx)
+
+	var workflow models.Workflow
+	if err := db.Select("id", "status", "input", "project_id").Where("id = ?", workflowID).First(&workflow).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+
+	var steps []models.WorkflowStep
+	if err := db.Where("workflow_id = ?", workflowID).Order(`"order"`).Find(&steps).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow steps: %w", err)
+	}
+
+	var executions []models.Execution
+	if err := db.Select("id", "workflow_step_id", "name", "type", "status", "started_at", "completed_at", "duration", "created_at").
+		Where("workflow_id = ?", workflowID).Order("created_at").Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get executions: %w", err)
+	}
+
+	artifacts := make(map[string]int)
+	if len(executions) > 0 {
+		ids := make([]string, len(executions))
+		for i, execution := range executions {
+			ids[i] = execution.ID
+		}
+		var counts []struct {
+			ExecutionID string
+			Count       int
+		}
+		if err := db.Model(&models.Artifact{}).Select("execution_id, COUNT(*) AS count").
+			Where("execution_id IN ?", ids).Group("execution_id").Scan(&counts).Error; err != nil {
+			return nil, fmt.Errorf("failed to count artifacts: %w", err)
+		}
+		for _, count := range counts {
+			artifacts[count.ExecutionID] = count.Count
+		}
+	}
+
+	graph := &WorkflowGraph{WorkflowID: workflow.ID, Status: workflow.Status}
+	var nodes []GraphNode
+	deps := make(map[string][]string)
+
+	var plan graphPlan
+	if len(steps) == 0 && len(workflow.Input) > 0 {
+		// Inputs of other workflow types simply have no plan
+		_ = json.Unmarshal(workflow.Input, &plan)
+	}
+
+	switch {
+	case len(steps) > 0:
+		graph.Source = "steps"
+		for _, step := range steps {
+			node := GraphNode{
+				ID:          step.ID,
+				Name:        step.Name,
+				Kind:        "step",
+				Type:        step.Type,
+				Status:      string(step.Status),
+				Duration:    step.Duration,
+				StartedAt:   step.StartedAt,
+				CompletedAt: step.CompletedAt,
+			}
+			for _, execution := range executions {
+				if execution.WorkflowStepID == step.ID {
+					node.Artifacts += artifacts[execution.ID]
+				}
+			}
+			nodes = append(nodes, node)
+			deps[step.ID] = step.DependsOn
+		}
+
+	case plan.ExecutionPlan != nil && len(plan.ExecutionPlan.Steps) > 0:
+		graph.Source = "plan"
+		for _, step := range plan.ExecutionPlan.Steps {
+			node := GraphNode{ID: step.ID, Name: step.Name, Kind: "planned_step", Type: step.Type}
+			applyExecution(&node, executions, artifacts)
+			nodes = append(nodes, node)
+			deps[step.ID] = step.DependsOn
+		}
+
+	case len(plan.Tasks) > 0:
+		graph.Source = "tasks"
+		for _, task := range plan.Tasks {
+			node := GraphNode{ID: task.ID, Name: task.Title, Kind: "task", Type: task.Type}
+			applyExecution(&node, executions, artifacts)
+			nodes = append(nodes, node)
+			deps[task.ID] = task.Dependencies
+		}
+
+	default:
+		graph.Source = "executions"
+		for _, execution := range executions {
+			nodes = append(nodes, GraphNode{
+				ID:          execution.ID,
+				Name:        execution.Name,
+				Kind:        "execution",
+				Type:        string(execution.Type),
+				Status:      string(execution.Status),
+				Duration:    execution.Duration,
+				Artifacts:   artifacts[execution.ID],
+				StartedAt:   execution.StartedAt,
+				CompletedAt: execution.CompletedAt,
+			})
+		}
+		sortByStart(nodes)
+	}
+
+	now := b.now()
+	for i := range nodes {
+		node := &nodes[i]
+		if node.Status == "" {
+			node.Status = NodeStatePending
+		}
+		node.State = graphNodeState(node.Status, workflow.IsTerminal())
+		// Running nodes show their time so far
+		if node.State == NodeStateRunning && node.StartedAt != nil && node.CompletedAt == nil {
+			node.Duration = now.Sub(*node.StartedAt).Milliseconds()
+		}
+	}
+
+	layoutGraph(graph, nodes, deps)
+	return graph, nil
+}
+
+// applyExecution takes the live status of a planned node from the latest
+// execution recorded under its name
+func applyExecution(node *GraphNode, executions []models.Execution, artifacts map[string]int) {
+	for _, execution := range executions {
+		if execution.Name != node.Name && execution.Name != node.ID {
+			continue
+		}
+		node.Status = string(execution.Status)
+		node.Duration = execution.Duration
+		node.StartedAt = execution.StartedAt
+		node.CompletedAt = execution.CompletedAt
+		node.Artifacts += artifacts[execution.ID]
+	}
+}
+
+// graphNodeState collapses step and execution statuses into node states.
+// Nodes still pending once the workflow has finished never ran.
+func graphNodeState(status string, workflowDone bool) string {
+	switch status {
+	case "running", "retrying":
+		return NodeStateRunning
+	case "completed", "succeeded":
+		return NodeStateCompleted
+	case "failed", "timed_out", "terminated", "below_threshold":
+		return NodeStateFailed
+	case "cancelled":
+		return NodeStateCancelled
+	case "skipped":
+		return NodeStateSkipped
+	}
+	if workflowDone {
+		return NodeStateSkipped
+	}
+	return NodeStatePending
+}
+
+// layoutGraph sets the edges and node ranks of a graph. Dependencies may
+// name a node by ID or by name; unknown ones are reported as warnings.
+// Without any dependency the nodes are chained in their given order, which
+// is step order or execution start time.
+func layoutGraph(graph *WorkflowGraph, nodes []GraphNode, deps map[string][]string) {
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node.ID] = i
+	}
+	byName := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		if _, ok := byName[node.Name]; !ok && node.Name != "" {
+			byName[node.Name] = i
+		}
+	}
+
+	edges := []GraphEdge{}
+	seen := make(map[GraphEdge]bool)
+	for _, node := range nodes {
+		for _, dep := range deps[node.ID] {
+			from, ok := index[dep]
+			if !ok {
+				from, ok = byName[dep]
+			}
+			if !ok {
+				graph.Warnings = append(graph.Warnings, fmt.Sprintf("%s depends on unknown step %s", node.ID, dep))
+				continue
+			}
+			edge := GraphEdge{From: nodes[from].ID, To: node.ID}
+			if !seen[edge] {
+				seen[edge] = true
+				edges = append(edges, edge)
+			}
+		}
+	}
+
+	graph.Layout = GraphLayoutDAG
+	if len(edges) == 0 && len(nodes) > 1 {
+		graph.Layout = GraphLayoutLinear
+		for i := 1; i < len(nodes); i++ {
+			edges = append(edges, GraphEdge{From: nodes[i-1].ID, To: nodes[i].ID})
+		}
+	}
+
+	graph.Cycles = assignRanks(nodes, edges, index)
+	if graph.Nodes = nodes; graph.Nodes == nil {
+		graph.Nodes = []GraphNode{}
+	}
+	graph.Edges = edges
+	for _, node := range nodes {
+		graph.Ranks = max(graph.Ranks, node.Rank+1)
+	}
+}
+
+// sortByStart orders nodes by start time; nodes that have not started keep
+// their given order after the started ones
+func sortByStart(nodes []GraphNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i].StartedAt, nodes[j].StartedAt
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.Before(*b)
+	})
+}
+
+// assignRanks sets each node's rank to the length of the longest dependency
+// path leading to it. Nodes on a cycle share a rank and the cycles are
+// returned rather than failing the layout.
+func assignRanks(nodes []GraphNode, edges []GraphEdge, index map[string]int) [][]string {
+	outgoing := make([][]int, len(nodes))
+	for _, edge := range edges {
+		outgoing[index[edge.From]] = append(outgoing[index[edge.From]], index[edge.To])
+	}
+
+	components := stronglyConnected(len(nodes), outgoing)
+	component := make([]int, len(nodes))
+	for c, members := range components {
+		for _, v := range members {
+			component[v] = c
+		}
+	}
+
+	// Components come out of Tarjan's algorithm in reverse topological
+	// order, so walking them backwards visits dependencies first
+	ranks := make([]int, len(components))
+	var cycles [][]string
+	for c := len(components) - 1; c >= 0; c-- {
+		members := components[c]
+		cyclic := len(members) > 1
+		for _, v := range members {
+			nodes[v].Rank = ranks[c]
+			for _, w := range outgoing[v] {
+				if component[w] == c {
+					cyclic = true
+					continue
+				}
+				ranks[component[w]] = max(ranks[component[w]], ranks[c]+1)
+			}
+		}
+		if cyclic {
+			ids := make([]string, len(members))
+			for i, v := range members {
+				ids[i] = nodes[v].ID
+			}
+			sort.Strings(ids)
+			cycles = append(cycles, ids)
+		}
+	}
+	return cycles
+}
+
+// stronglyConnected returns the strongly connected components of a graph in
+// reverse topological order, using Tarjan's algorithm
+func stronglyConnected(n int, outgoing [][]int) [][]int {
+	var (
+		counter    int
+		stack      []int
+		onStack    = make([]bool, n)
+		order      = make([]int, n)
+		lowlink    = make([]int, n)
+		visited    = make([]bool, n)
+		components [][]int
+		visit      func(v int)
+	)
+	visit = func(v int) {
+		visited[v] = true
+		order[v], lowlink[v] = counter, counter
+		counter++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range outgoing[v] {
+			if !visited[w] {
+				visit(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], order[w])
+			}
+		}
+
+		if lowlink[v] != order[v] {
+			return
+		}
+		var members []int
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			members = append(members, w)
+			if w == v {
+				break
+			}
+		}
+		components = append(components, members)
+	}
+	for v := 0; v < n; v++ {
+		if !visited[v] {
+			visit(v)
+		}
+	}
+	return components
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+func graphNodes(ids ...string) []GraphNode {
+	nodes := make([]GraphNode, len(ids))
+	for i, id := range ids {
+		nodes[i] = GraphNode{ID: id, Name: "name-" + id}
+	}
+	return nodes
+}
+
+func ranksOf(graph *WorkflowGraph) map[string]int {
+	ranks := make(map[string]int, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		ranks[node.ID] = node.Rank
+	}
+	return ranks
+}
+
+func TestLayoutGraph_DAG(t *testing.T) {
+	// a -> b -> d, a -> c -> d, c -> e, plus a shortcut a -> d
+	graph := &WorkflowGraph{}
+	layoutGraph(graph, graphNodes("a", "b", "c", "d", "e"), map[string][]string{
+		"b": {"a"},
+		"c": {"name-a"}, // by name
+		"d": {"b", "c", "a", "b"},
+		"e": {"c", "ghost"},
+	})
+
+	assert.Equal(t, GraphLayoutDAG, graph.Layout)
+	assert.Equal(t, map[string]int{"a": 0, "b": 1, "c": 1, "d": 2, "e": 2}, ranksOf(graph))
+	assert.Equal(t, 3, graph.Ranks)
+	assert.ElementsMatch(t, []GraphEdge{
+		{From: "a", To: "b"}, {From: "a", To: "c"}, {From: "b", To: "d"},
+		{From: "c", To: "d"}, {From: "a", To: "d"}, {From: "c", To: "e"},
+	}, graph.Edges, "duplicate dependencies collapse into one edge")
+	assert.Empty(t, graph.Cycles)
+	assert.Equal(t, []string{"e depends on unknown step ghost"}, graph.Warnings)
+}
+
+func TestLayoutGraph_ToleratesCycles(t *testing.T) {
+	// root -> a -> b -> c -> a, c -> tail, plus a self loop on solo
+	graph := &WorkflowGraph{}
+	assert.NotPanics(t, func() {
+		layoutGraph(graph, graphNodes("root", "a", "b", "c", "tail", "solo"), map[string][]string{
+			"a":    {"root", "c"},
+			"b":    {"a"},
+			"c":    {"b"},
+			"tail": {"c"},
+			"solo": {"solo"},
+		})
+	})
+
+	assert.ElementsMatch(t, [][]string{{"a", "b", "c"}, {"solo"}}, graph.Cycles)
+	ranks := ranksOf(graph)
+	assert.Equal(t, 0, ranks["root"])
+	assert.Equal(t, 1, ranks["a"], "nodes of a cycle share a rank")
+	assert.Equal(t, 1, ranks["b"])
+	assert.Equal(t, 1, ranks["c"])
+	assert.Equal(t, 2, ranks["tail"], "nodes behind a cycle are ranked after it")
+	assert.Equal(t, 0, ranks["solo"])
+	assert.Len(t, graph.Nodes, 6)
+}
+
+func TestLayoutGraph_LinearFallback(t *testing.T) {
+	graph := &WorkflowGraph{}
+	layoutGraph(graph, graphNodes("first", "second", "third"), map[string][]string{})
+
+	assert.Equal(t, GraphLayoutLinear, graph.Layout)
+	assert.Equal(t, []GraphEdge{{From: "first", To: "second"}, {From: "second", To: "third"}}, graph.Edges)
+	assert.Equal(t, map[string]int{"first": 0, "second": 1, "third": 2}, ranksOf(graph))
+
+	graph = &WorkflowGraph{}
+	layoutGraph(graph, nil, nil)
+	assert.Empty(t, graph.Nodes)
+	assert.NotNil(t, graph.Nodes)
+	assert.NotNil(t, graph.Edges)
+	assert.Zero(t, graph.Ranks)
+}
+
+func TestWorkflowGraphBuilder_Build(t *testing.T) {
+	db := setupReportTest(t)
+	builder := NewWorkflowGraphBuilder(db)
+	builder.now = func() time.Time { return reportEpoch.Add(10 * time.Minute) }
+	ctx := context.Background()
+
+	t.Run("steps in order", func(t *testing.T) {
+		workflow := seedReportWorkflow(t, db)
+		graph, err := builder.Build(ctx, workflow.ID)
+		require.NoError(t, err)
+
+		assert.Equal(t, "steps", graph.Source)
+		assert.Equal(t, GraphLayoutLinear, graph.Layout)
+		require.Len(t, graph.Nodes, 5)
+		names := make([]string, len(graph.Nodes))
+		for i, node := range graph.Nodes {
+			names[i] = node.Name
+			assert.Equal(t, i, node.Rank)
+		}
+		assert.Equal(t, []string{"design", "api", "database", "frontend", "tests"}, names)
+		assert.Equal(t, NodeStateFailed, graph.Nodes[2].State)
+		assert.Equal(t, NodeStateCompleted, graph.Nodes[0].State)
+		assert.EqualValues(t, 45000, graph.Nodes[0].Duration)
+
+		var artifacts int
+		for _, node := range graph.Nodes {
+			artifacts += node.Artifacts
+		}
+		var stored int64
+		require.NoError(t, db.Model(&models.Artifact{}).Count(&stored).Error)
+		assert.EqualValues(t, stored, artifacts)
+	})
+
+	t.Run("plan with live status", func(t *testing.T) {
+		input, err := json.Marshal(map[string]interface{}{
+			"content": "build an orders service",
+			"execution_plan": map[string]interface{}{"steps": []map[string]interface{}{
+				{"id": "plan-1", "name": "schema", "type": "code"},
+				{"id": "plan-2", "name": "api", "type": "code", "depends_on": []string{"plan-1"}},
+				{"id": "plan-3", "name": "docs", "type": "action", "depends_on": []string{"plan-1"}},
+			}},
+		})
+		require.NoError(t, err)
+		workflow := &models.Workflow{Name: "planned", Type: models.WorkflowTypeIntent, Status: models.WorkflowStatusRunning,
+			ProjectID: "project-1", Input: input}
+		require.NoError(t, db.Create(workflow).Error)
+
+		started := reportEpoch.Add(9 * time.Minute)
+		for _, execution := range []models.Execution{
+			{ID: "plan-exec-1", Name: "schema", Status: models.ExecutionStatusSucceeded, Duration: 1200},
+			{ID: "plan-exec-2", Name: "api", Status: models.ExecutionStatusRunning, StartedAt: &started},
+		} {
+			execution.ProjectID, execution.WorkflowID, execution.Type = "project-1", workflow.ID, models.ExecutionTypeCode
+			require.NoError(t, db.Create(&execution).Error)
+		}
+		require.NoError(t, db.Create(&models.Artifact{ID: "plan-artifact", ExecutionID: "plan-exec-1", Name: "schema.sql", Type: "code"}).Error)
+
+		graph, err := builder.Build(ctx, workflow.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "plan", graph.Source)
+		assert.Equal(t, GraphLayoutDAG, graph.Layout)
+		assert.Equal(t, 2, graph.Ranks)
+
+		states := make(map[string]GraphNode)
+		for _, node := range graph.Nodes {
+			states[node.ID] = node
+		}
+		assert.Equal(t, NodeStateCompleted, states["plan-1"].State)
+		assert.Equal(t, 1, states["plan-1"].Artifacts)
+		assert.Equal(t, NodeStateRunning, states["plan-2"].State)
+		assert.EqualValues(t, time.Minute.Milliseconds(), states["plan-2"].Duration, "running nodes report elapsed time")
+		assert.Equal(t, NodeStatePending, states["plan-3"].State)
+		assert.Equal(t, 1, states["plan-3"].Rank)
+	})
+
+	t.Run("executions only", func(t *testing.T) {
+		workflow := &models.Workflow{Name: "bare", Type: models.WorkflowTypeCustom, Status: models.WorkflowStatusCompleted, ProjectID: "project-1"}
+		require.NoError(t, db.Create(workflow).Error)
+		late, early := reportEpoch.Add(time.Minute), reportEpoch
+		for _, execution := range []models.Execution{
+			{ID: "bare-late", Name: "late", Status: models.ExecutionStatusSucceeded, StartedAt: &late},
+			{ID: "bare-early", Name: "early", Status: models.ExecutionStatusSucceeded, StartedAt: &early},
+			{ID: "bare-never", Name: "never", Status: models.ExecutionStatusPending},
+		} {
+			execution.ProjectID, execution.WorkflowID, execution.Type = "project-1", workflow.ID, models.ExecutionTypeCode
+			require.NoError(t, db.Create(&execution).Error)
+		}
+
+		graph, err := builder.Build(ctx, workflow.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "executions", graph.Source)
+		assert.Equal(t, []GraphEdge{{From: "bare-early", To: "bare-late"}, {From: "bare-late", To: "bare-never"}}, graph.Edges)
+		assert.Equal(t, NodeStateSkipped, graph.Nodes[2].State, "pending nodes of a finished workflow never ran")
+	})
+
+	_, err := builder.Build(ctx, "missing")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
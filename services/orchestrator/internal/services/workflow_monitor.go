@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.temporal.io/api/enums/v1"
 	"go.temporal.io/api/workflowservice/v1"
 	"go.temporal.io/sdk/client"
 	"go.uber.org/zap"
@@ -25,6 +26,30 @@ type WorkflowMonitor struct {
 	interval       time.Duration
 	stopChan       chan struct{}
 	wg             sync.WaitGroup
+
+	// Orphaned workflow handling, see EnableReaper
+	engine          *WorkflowEngine
+	reaper          *ReaperConfig
+	missedDescribes map[string]int
+
+	// Draft purging, see EnableDraftRetention
+	draftRetention time.Duration
+	lastDraftPurge time.Time
+
+	// Checkpoint purging, see EnableCheckpointRetention
+	checkpointRetention time.Duration
+	lastCheckpointPurge time.Time
+
+	// Agent cleanup, see EnableAgentCleanup
+	agentGC *AgentGarbageCollector
+
+	// SLA tracking, see EnableSLATracking
+	slaEvents func(ctx context.Context, workflow *models.Workflow, eventType string, data map[string]interface{})
+	now       func() time.Time
+
+	// Stuck workflow task detection, see EnableStuckDetection
+	stuckEvents       func(ctx context.Context, workflow *models.Workflow, eventType string, data map[string]interface{})
+	stuckTaskFailures int
 }
 
 // NewWorkflowMonitor creates a new workflow monitor
@@ -36,6 +61,7 @@ func NewWorkflowMonitor(db *gorm.DB, temporalClient client.Client, logger *zap.L
 		redis:          redisClient,
 		interval:       interval,
 		stopChan:       make(chan struct{}),
+		now:            time.Now,
 	}
 }
 
@@ -76,6 +102,11 @@ func (m *WorkflowMonitor) monitorWorkflows() {
 // checkWorkflows checks and updates workflow statuses
 func (m *WorkflowMonitor) checkWorkflows() {
 	ctx := context.Background()
+	m.purgeDrafts(ctx)
+	m.purgeCheckpoints(ctx)
+	if m.agentGC != nil {
+		m.agentGC.ReleaseFinishedWorkflows(ctx)
+	}
 
 	// Get all running workflows from database
 	var workflows []models.Workflow
@@ -88,9 +119,17 @@ func (m *WorkflowMonitor) checkWorkflows() {
 	}
 
 	m.logger.Debug("Checking workflow statuses", zap.Int("count", len(workflows)))
+	m.countStuck(workflows)
 
 	// Check each workflow status in Temporal
 	for _, workflow := range workflows {
+		m.checkSLA(ctx, &workflow)
+
+		// Workflows held by the fair-share scheduler are pending by design
+		if workflow.TemporalID == "" && workflow.Status == models.WorkflowStatusPending && workflow.SchedulingReason == "" && m.reaper != nil {
+			m.reapPending(ctx, &workflow)
+			continue
+		}
 		if workflow.TemporalID == "" || workflow.TemporalRunID == "" {
 			continue
 		}
@@ -98,23 +137,57 @@ func (m *WorkflowMonitor) checkWorkflows() {
 		// Describe workflow execution
 		resp, err := m.temporalClient.DescribeWorkflowExecution(ctx, workflow.TemporalID, workflow.TemporalRunID)
 		if err != nil {
+			if isExecutionNotFound(err) && workflow.Status == models.WorkflowStatusRunning && m.reaper != nil {
+				m.reapMissing(ctx, &workflow)
+				continue
+			}
 			m.logger.Error("Failed to describe workflow execution",
 				zap.String("workflowID", workflow.ID),
 				zap.String("temporalID", workflow.TemporalID),
 				zap.Error(err))
 			continue
 		}
+		if m.reaper != nil {
+			delete(m.missedDescribes, workflow.ID)
+		}
+
+		// A continued run keeps the workflow ID but gets a new run ID, as
+		// does a failed run Temporal retries; follow it so later checks
+		// describe the live run
+		if isContinuedAsNew(resp) || mayRetry(resp) {
+			reason := models.RunReasonRetry
+			if isContinuedAsNew(resp) {
+				reason = models.RunReasonContinueAsNew
+			}
+			resp, err = m.followNextRun(ctx, &workflow, resp, reason)
+			if err != nil {
+				m.logger.Error("Failed to follow next workflow run",
+					zap.String("workflowID", workflow.ID),
+					zap.String("temporalID", workflow.TemporalID),
+					zap.Error(err))
+				continue
+			}
+		}
+
+		m.checkWorkflowTask(ctx, &workflow, resp)
 
 		// Update workflow status based on Temporal status
 		m.updateWorkflowStatus(&workflow, resp)
 	}
 }
 
-// updateWorkflowStatus updates workflow status based on Temporal workflow info
-func (m *WorkflowMonitor) updateWorkflowStatus(workflow *models.Workflow, info *workflowservice.DescribeWorkflowExecutionResponse) {
+// isContinuedAsNew reports whether the described run ended by continuing as new
+func isContinuedAsNew(info *workflowservice.DescribeWorkflowExecutionResponse) bool {
+	return info.WorkflowExecutionInfo != nil &&
+		info.WorkflowExecutionInfo.Status == enums.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW
+}
+
+// updateWorkflowStatus updates workflow status based on Temporal workflow
+// info, and reports whether it changed
+func (m *WorkflowMonitor) updateWorkflowStatus(workflow *models.Workflow, info *workflowservice.DescribeWorkflowExecutionResponse) bool {
 	executionInfo := info.WorkflowExecutionInfo
 	if executionInfo == nil {
-		return
+		return false
 	}
 
 	// Map Temporal status to our workflow status
@@ -130,8 +203,8 @@ func (m *WorkflowMonitor) updateWorkflowStatus(workflow *models.Workflow, info *
 		newStatus = models.WorkflowStatusFailed
 		errorMsg = "Workflow execution failed"
 	case 4: // WORKFLOW_EXECUTION_STATUS_CANCELED
+		// Not a failure: the reason goes to CancelledReason, not Error
 		newStatus = models.WorkflowStatusCancelled
-		errorMsg = "Workflow execution cancelled"
 	case 5: // WORKFLOW_EXECUTION_STATUS_TERMINATED
 		newStatus = models.WorkflowStatusTerminated
 		errorMsg = "Workflow execution terminated"
@@ -141,12 +214,12 @@ func (m *WorkflowMonitor) updateWorkflowStatus(workflow *models.Workflow, info *
 		newStatus = models.WorkflowStatusTimedOut
 		errorMsg = "Workflow execution timed out"
 	default:
-		return // Unknown status
+		return false // Unknown status
 	}
 
 	// Check if status changed
 	if workflow.Status == newStatus {
-		return
+		return false
 	}
 
 	// Update workflow status
@@ -155,10 +228,27 @@ func (m *WorkflowMonitor) updateWorkflowStatus(workflow *models.Workflow, info *
 		workflow.Error = errorMsg
 	}
 
-	// Set completion time if workflow is in terminal state
-	if workflow.IsTerminal() && workflow.CompletedAt == nil {
-		now := time.Now()
-		workflow.CompletedAt = &now
+	// Set completion time and duration if workflow is in terminal state.
+	// Runs cancelled or terminated outside the engine may never have been
+	// marked started, so Temporal's start and close times fill the gaps.
+	if workflow.IsTerminal() {
+		if workflow.StartedAt == nil && executionInfo.StartTime != nil {
+			startedAt := executionInfo.StartTime.UTC()
+			workflow.StartedAt = &startedAt
+		}
+		if workflow.CompletedAt == nil {
+			completedAt := m.now().UTC()
+			if executionInfo.CloseTime != nil {
+				completedAt = executionInfo.CloseTime.UTC()
+			}
+			workflow.CompletedAt = &completedAt
+		}
+		if workflow.StartedAt != nil {
+			workflow.Duration = workflow.CompletedAt.Sub(*workflow.StartedAt).Milliseconds()
+		}
+	}
+	if newStatus == models.WorkflowStatusCancelled {
+		m.preserveCancellation(workflow)
 	}
 
 	// Extract workflow result if completed
@@ -176,22 +266,65 @@ func (m *WorkflowMonitor) updateWorkflowStatus(workflow *models.Workflow, info *
 			zap.String("workflowID", workflow.ID),
 			zap.String("newStatus", string(newStatus)),
 			zap.Error(err))
-		return
+		return false
 	}
 
 	// Clear cache for this workflow so the API gets fresh data
-	ctx := context.Background()
-	cacheKey := fmt.Sprintf("workflow:%s", workflow.ID)
-	if err := m.redis.Del(ctx, cacheKey).Err(); err != nil {
-		m.logger.Error("Failed to clear workflow cache",
-			zap.String("workflowID", workflow.ID),
-			zap.Error(err))
+	m.clearWorkflowCache(context.Background(), workflow.ID)
+
+	// Catch SLAs missed since the last check
+	if workflow.IsTerminal() {
+		observeExecution(workflow)
+		m.checkSLA(context.Background(), workflow)
+		runErr := errorMsg
+		if newStatus == models.WorkflowStatusCancelled {
+			runErr = workflow.CancelledReason
+		}
+		m.finalizeRun(context.Background(), workflow, workflow.TemporalRunID, runEnd{
+			status:      newStatus,
+			output:      workflow.Output,
+			err:         runErr,
+			completedAt: *workflow.CompletedAt,
+		})
 	}
 
 	m.logger.Info("Updated workflow status",
 		zap.String("workflowID", workflow.ID),
 		zap.String("oldStatus", string(workflow.Status)),
 		zap.String("newStatus", string(newStatus)))
+	return true
+}
+
+// externalCancelReason is the reason of workflows cancelled outside the
+// orchestrator, e.g. from the Temporal UI
+const externalCancelReason = "Workflow execution cancelled"
+
+// preserveCancellation keeps the reason, actor and time of a cancellation
+// recorded since the workflow was loaded, as CancelWorkflow records them
+// before Temporal reports the run cancelled. Workflows cancelled outside
+// the orchestrator get a generic reason and no actor.
+func (m *WorkflowMonitor) preserveCancellation(workflow *models.Workflow) {
+	var stored models.Workflow
+	err := m.db.Select("cancelled_reason", "cancelled_by", "cancelled_at").
+		First(&stored, "id = ?", workflow.ID).Error
+	if err == nil && stored.CancelledAt != nil {
+		workflow.CancelledReason = stored.CancelledReason
+		workflow.CancelledBy = stored.CancelledBy
+		workflow.CancelledAt = stored.CancelledAt
+		return
+	}
+	if workflow.CancelledAt == nil {
+		workflow.CancelledAt = workflow.CompletedAt
+	}
+	if workflow.CancelledReason == "" {
+		workflow.CancelledReason = externalCancelReason
+	}
+}
+
+// clearWorkflowCache drops the cached workflow state so the API reads fresh data
+func (m *WorkflowMonitor) clearWorkflowCache(ctx context.Context, workflowID string) {
+	cacheKey := fmt.Sprintf("workflow:%s", workflowID)
+	RedisGuardFor(m.redis, m.logger).Invalidate(ctx, cacheKey)
 }
 
 // getWorkflowResult retrieves the result of a completed workflow
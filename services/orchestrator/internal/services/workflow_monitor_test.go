@@ -0,0 +1,116 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func describeResponse(workflowID, runID string, status enums.WorkflowExecutionStatus) *workflowservice.DescribeWorkflowExecutionResponse {
+	return &workflowservice.DescribeWorkflowExecutionResponse{
+		WorkflowExecutionInfo: &workflow.WorkflowExecutionInfo{
+			Execution: &common.WorkflowExecution{WorkflowId: workflowID, RunId: runID},
+			Status:    status,
+		},
+	}
+}
+
+func TestWorkflowMonitor_FollowsContinuedAsNewRun(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+
+	tracked := &models.Workflow{
+		Name:          "Deployment awaiting approval",
+		Type:          models.WorkflowTypeDeployment,
+		Status:        models.WorkflowStatusPending,
+		ProjectID:     "test-project-id",
+		TemporalID:    "deploy-1",
+		TemporalRunID: "run-1",
+	}
+	assert.NoError(t, db.Create(tracked).Error)
+
+	temporalClient := &mocks.Client{}
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "deploy-1", "run-1").
+		Return(describeResponse("deploy-1", "run-1", enums.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "deploy-1", "").
+		Return(describeResponse("deploy-1", "run-2", enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "deploy-1", "run-2").
+		Return(describeResponse("deploy-1", "run-2", enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil).Once()
+
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+
+	// First check detects the transition and switches to the new run
+	monitor.checkWorkflows()
+
+	var stored models.Workflow
+	assert.NoError(t, db.First(&stored, "id = ?", tracked.ID).Error)
+	assert.Equal(t, "run-2", stored.TemporalRunID)
+	assert.Equal(t, models.WorkflowStatusRunning, stored.Status)
+
+	// Subsequent checks describe the new run directly
+	monitor.checkWorkflows()
+
+	temporalClient.AssertExpectations(t)
+}
+
+func TestWorkflowMonitor_RecordsDurationOfStoppedWorkflows(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	epoch := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cancelled := &models.Workflow{Name: "cancelled before the engine marked it started", Type: models.WorkflowTypeCustom,
+		Status: models.WorkflowStatusPending, ProjectID: "test-project-id", TemporalID: "cancel-1", TemporalRunID: "run-1"}
+	terminated := &models.Workflow{Name: "terminated from the Temporal UI", Type: models.WorkflowTypeCustom,
+		Status: models.WorkflowStatusRunning, ProjectID: "test-project-id", TemporalID: "terminate-1", TemporalRunID: "run-1",
+		StartedAt: &epoch}
+	for _, workflow := range []*models.Workflow{cancelled, terminated} {
+		require.NoError(t, db.Create(workflow).Error)
+	}
+
+	withTimes := func(resp *workflowservice.DescribeWorkflowExecutionResponse, start, close time.Time) *workflowservice.DescribeWorkflowExecutionResponse {
+		resp.WorkflowExecutionInfo.StartTime = &start
+		resp.WorkflowExecutionInfo.CloseTime = &close
+		return resp
+	}
+	temporalClient := &mocks.Client{}
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "cancel-1", "run-1").
+		Return(withTimes(describeResponse("cancel-1", "run-1", enums.WORKFLOW_EXECUTION_STATUS_CANCELED),
+			epoch.Add(time.Second), epoch.Add(91*time.Second)), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "terminate-1", "run-1").
+		Return(withTimes(describeResponse("terminate-1", "run-1", enums.WORKFLOW_EXECUTION_STATUS_TERMINATED),
+			epoch.Add(-time.Second), epoch.Add(2*time.Minute+500*time.Millisecond)), nil).Once()
+
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+	monitor.checkWorkflows()
+	temporalClient.AssertExpectations(t)
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", cancelled.ID).Error)
+	assert.Equal(t, models.WorkflowStatusCancelled, stored.Status)
+	assert.True(t, stored.StartedAt.Equal(epoch.Add(time.Second)), "start time comes from Temporal")
+	assert.True(t, stored.CompletedAt.Equal(epoch.Add(91*time.Second)), "completion time is the close time")
+	assert.EqualValues(t, 90000, stored.Duration)
+	assert.Equal(t, "1m30s", stored.DurationText)
+
+	var stopped models.Workflow
+	require.NoError(t, db.First(&stopped, "id = ?", terminated.ID).Error)
+	assert.Equal(t, models.WorkflowStatusTerminated, stopped.Status)
+	assert.True(t, stopped.StartedAt.Equal(epoch), "a recorded start time is kept")
+	assert.EqualValues(t, 120500, stopped.Duration)
+	assert.Equal(t, "2m0.5s", stopped.DurationText)
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.temporal.io/api/serviceerror"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/requestid"
+)
+
+// Reaper actions, used as audit actions and metric labels
+const (
+	ReaperActionResubmitted        = "resubmitted"
+	ReaperActionFailedNeverStarted = "failed_never_started"
+	ReaperActionExecutionMissing   = "execution_missing"
+	ReaperActionTerminatedMissing  = "terminated_missing"
+)
+
+// reaperActor is the audit actor for changes made by the reaper
+const reaperActor = "system:workflow-reaper"
+
+var reaperActionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_workflow_reaper_actions_total",
+	Help: "Actions taken by the workflow reaper on orphaned workflows",
+}, []string{"action"})
+
+// ReaperConfig configures how the workflow monitor handles orphaned workflows
+type ReaperConfig struct {
+	// PendingThreshold is how long a workflow may stay pending without a
+	// Temporal execution before it is re-submitted or failed
+	PendingThreshold time.Duration
+	// MaxMissedDescribes is the number of consecutive checks a running
+	// workflow's execution may be missing from Temporal before it is marked
+	// terminated
+	MaxMissedDescribes int
+}
+
+// EnableReaper makes the monitor re-submit or fail workflows that never
+// reached Temporal and terminate running workflows whose execution is gone.
+// Re-submission and events go through the engine.
+func (m *WorkflowMonitor) EnableReaper(engine *WorkflowEngine, config ReaperConfig) {
+	if config.MaxMissedDescribes <= 0 {
+		config.MaxMissedDescribes = 1
+	}
+	m.engine = engine
+	m.reaper = &config
+	m.missedDescribes = make(map[string]int)
+}
+
+// reapPending handles a pending workflow without a Temporal execution. Once it
+// is older than the threshold it is re-submitted if it can be rebuilt from
+// its row, and failed otherwise.
+func (m *WorkflowMonitor) reapPending(ctx context.Context, workflow *models.Workflow) {
+	if time.Since(pendingSince(workflow)) < m.reaper.PendingThreshold {
+		return
+	}
+
+	if reason := unrecoverableReason(workflow, m.engine.workflowTypes); reason != "" {
+		m.failNeverStarted(ctx, workflow, reason)
+		return
+	}
+
+	// Re-submit with the ID of the request that created the workflow
+	if err := m.engine.submitWorkflow(requestid.NewContext(ctx, workflow.RequestID), workflow); err != nil {
+		m.logger.Warn("Failed to re-submit stale pending workflow",
+			zap.String("workflowID", workflow.ID),
+			zap.Error(err))
+		m.failNeverStarted(ctx, workflow, err.Error())
+		return
+	}
+	m.recordReaperAction(ctx, workflow, ReaperActionResubmitted, map[string]interface{}{
+		"temporal_run_id": workflow.TemporalRunID,
+	})
+}
+
+// failNeverStarted marks a pending workflow failed because it never started
+func (m *WorkflowMonitor) failNeverStarted(ctx context.Context, workflow *models.Workflow, reason string) {
+	now := time.Now()
+	result := m.db.Model(&models.Workflow{}).
+		Where("id = ? AND status = ? AND (temporal_id = '' OR temporal_id IS NULL)", workflow.ID, models.WorkflowStatusPending).
+		Updates(map[string]interface{}{
+			"status":       models.WorkflowStatusFailed,
+			"error":        "Workflow never started: " + reason,
+			"completed_at": now,
+		})
+	if result.Error != nil {
+		m.logger.Error("Failed to mark stale pending workflow failed",
+			zap.String("workflowID", workflow.ID),
+			zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		// Started or changed by someone else since it was loaded
+		return
+	}
+
+	workflow.Status = models.WorkflowStatusFailed
+	workflow.Error = "Workflow never started: " + reason
+	workflow.CompletedAt = &now
+	m.clearWorkflowCache(ctx, workflow.ID)
+	m.engine.emitWorkflowEvent(ctx, workflow, "failed", map[string]interface{}{"reason": "never_started", "details": reason})
+	m.recordReaperAction(ctx, workflow, ReaperActionFailedNeverStarted, map[string]interface{}{"reason": reason})
+}
+
+// reapMissing records that a running workflow's execution was not found in
+// Temporal and marks it terminated after enough consecutive misses
+func (m *WorkflowMonitor) reapMissing(ctx context.Context, workflow *models.Workflow) {
+	m.missedDescribes[workflow.ID]++
+	misses := m.missedDescribes[workflow.ID]
+	if misses < m.reaper.MaxMissedDescribes {
+		m.recordReaperAction(ctx, workflow, ReaperActionExecutionMissing, map[string]interface{}{"misses": misses})
+		return
+	}
+
+	now := time.Now()
+	reason := fmt.Sprintf("Temporal execution not found in %d consecutive checks", misses)
+	result := m.db.Model(&models.Workflow{}).
+		Where("id = ? AND status = ?", workflow.ID, models.WorkflowStatusRunning).
+		Updates(map[string]interface{}{
+			"status":       models.WorkflowStatusTerminated,
+			"error":        reason,
+			"completed_at": now,
+		})
+	if result.Error != nil {
+		m.logger.Error("Failed to mark missing workflow terminated",
+			zap.String("workflowID", workflow.ID),
+			zap.Error(result.Error))
+		return
+	}
+	delete(m.missedDescribes, workflow.ID)
+	if result.RowsAffected == 0 {
+		return
+	}
+
+	workflow.Status = models.WorkflowStatusTerminated
+	workflow.Error = reason
+	workflow.CompletedAt = &now
+	observeExecution(workflow)
+	m.clearWorkflowCache(ctx, workflow.ID)
+	m.finalizeRun(ctx, workflow, workflow.TemporalRunID, runEnd{
+		status:      models.WorkflowStatusTerminated,
+		err:         reason,
+		completedAt: now,
+	})
+	m.engine.emitWorkflowEvent(ctx, workflow, "terminated", map[string]interface{}{"reason": "execution_not_found"})
+	m.recordReaperAction(ctx, workflow, ReaperActionTerminatedMissing, map[string]interface{}{"misses": misses})
+}
+
+// recordReaperAction logs, audits and counts a reaper action
+func (m *WorkflowMonitor) recordReaperAction(ctx context.Context, workflow *models.Workflow, action string, details map[string]interface{}) {
+	reaperActionsTotal.WithLabelValues(action).Inc()
+	m.logger.Warn("Workflow reaper action",
+		zap.String("action", action),
+		zap.String("workflowID", workflow.ID),
+		zap.String("status", string(workflow.Status)),
+		zap.Any("details", details))
+
+	data, err := json.Marshal(details)
+	if err != nil {
+		m.logger.Error("Failed to marshal audit details", zap.Error(err))
+		return
+	}
+	entry := &models.AuditLog{
+		EntityType: "workflow",
+		EntityID:   workflow.ID,
+		Action:     "reaper." + action,
+		Actor:      reaperActor,
+		Details:    data,
+	}
+	if err := m.db.WithContext(ctx).Create(entry).Error; err != nil {
+		m.logger.Error("Failed to write audit log",
+			zap.String("workflowID", workflow.ID),
+			zap.String("action", action),
+			zap.Error(err))
+	}
+}
+
+// unrecoverableReason explains why a pending workflow cannot be re-submitted
+// from its row, or returns an empty string if it can
+func unrecoverableReason(workflow *models.Workflow, workflowTypes *WorkflowTypeRegistry) string {
+	if _, known := workflowTypes.Lookup(workflow.Type); !known {
+		return fmt.Sprintf("unknown workflow type %q", workflow.Type)
+	}
+	if len(workflow.Input) > 0 && !json.Valid(workflow.Input) {
+		return "stored input is not valid JSON"
+	}
+	if _, err := DecodeWorkflowConfig(workflow.Type, workflow.Config); err != nil {
+		return err.Error()
+	}
+	if workflow.TimeoutSeconds > 0 &&
+		time.Since(pendingSince(workflow)) > time.Duration(workflow.TimeoutSeconds)*time.Second {
+		return "workflow timeout elapsed before it started"
+	}
+	return ""
+}
+
+// pendingSince returns when a workflow became pending: when it was created,
+// or submitted for a workflow saved as a draft first
+func pendingSince(workflow *models.Workflow) time.Time {
+	if workflow.SubmittedAt != nil {
+		return *workflow.SubmittedAt
+	}
+	return workflow.CreatedAt
+}
+
+// isExecutionNotFound reports whether Temporal has no execution for a describe
+func isExecutionNotFound(err error) bool {
+	var notFound *serviceerror.NotFound
+	return errors.As(err, &notFound)
+}
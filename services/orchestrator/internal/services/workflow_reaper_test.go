@@ -0,0 +1,161 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+func reaperActions(t *testing.T, db *gorm.DB, workflowID string) []string {
+	var actions []string
+	require.NoError(t, db.Model(&models.AuditLog{}).Where("entity_id = ?", workflowID).
+		Order("rowid").Pluck("action", &actions).Error)
+	return actions
+}
+
+func TestWorkflowMonitor_ReapsOrphanedWorkflows(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE audit_logs (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		entity_type TEXT, entity_id TEXT, action TEXT, actor TEXT, details TEXT, created_at DATETIME)`).Error)
+
+	stale := time.Now().Add(-time.Hour)
+	newWorkflow := func(name string, workflowType models.WorkflowType, status models.WorkflowStatus, createdAt time.Time, temporalID string) *models.Workflow {
+		workflow := &models.Workflow{
+			Name:           name,
+			Type:           workflowType,
+			Status:         status,
+			ProjectID:      "project-1",
+			Input:          []byte(`{"code": "print(1)"}`),
+			TimeoutSeconds: 7200,
+			TemporalID:     temporalID,
+			CreatedAt:      createdAt,
+		}
+		if temporalID != "" {
+			workflow.TemporalRunID = "run-" + temporalID
+		}
+		require.NoError(t, db.Create(workflow).Error)
+		return workflow
+	}
+
+	recoverable := newWorkflow("recoverable", models.WorkflowTypeExecution, models.WorkflowStatusPending, stale, "")
+	unrecoverable := newWorkflow("unknown type", "legacy_type", models.WorkflowStatusPending, stale, "")
+	fresh := newWorkflow("fresh", models.WorkflowTypeExecution, models.WorkflowStatusPending, time.Now(), "")
+	missing := newWorkflow("missing execution", models.WorkflowTypeExecution, models.WorkflowStatusRunning, stale, "lost")
+
+	mockWorkflowRun := new(mocks.WorkflowRun)
+	mockWorkflowRun.On("GetID").Return(recoverable.ID)
+	mockWorkflowRun.On("GetRunID").Return("run-resubmitted")
+	temporalClient := new(mocks.Client)
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, "CodeExecutionWorkflow", mock.Anything).
+		Return(mockWorkflowRun, nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, recoverable.ID, "run-resubmitted").
+		Return(describeResponse(recoverable.ID, "run-resubmitted", enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil)
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "lost", "run-lost").
+		Return(nil, serviceerror.NewNotFound("workflow execution not found"))
+
+	// Unreachable Redis: cache writes and event publishing only log errors
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	engine := NewWorkflowEngine(db, redisClient, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+	monitor.EnableReaper(engine, ReaperConfig{PendingThreshold: 10 * time.Minute, MaxMissedDescribes: 2})
+
+	resubmitted := testutil.ToFloat64(reaperActionsTotal.WithLabelValues(ReaperActionResubmitted))
+	failed := testutil.ToFloat64(reaperActionsTotal.WithLabelValues(ReaperActionFailedNeverStarted))
+	terminated := testutil.ToFloat64(reaperActionsTotal.WithLabelValues(ReaperActionTerminatedMissing))
+
+	status := func(workflow *models.Workflow) models.Workflow {
+		var stored models.Workflow
+		require.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+		return stored
+	}
+
+	// First cycle: stale pending rows are resolved, the missing execution is
+	// only counted
+	monitor.checkWorkflows()
+
+	stored := status(recoverable)
+	assert.Equal(t, models.WorkflowStatusRunning, stored.Status)
+	assert.Equal(t, "run-resubmitted", stored.TemporalRunID)
+
+	stored = status(unrecoverable)
+	assert.Equal(t, models.WorkflowStatusFailed, stored.Status)
+	assert.Contains(t, stored.Error, "never started")
+	assert.NotNil(t, stored.CompletedAt)
+
+	assert.Equal(t, models.WorkflowStatusPending, status(fresh).Status)
+	assert.Equal(t, models.WorkflowStatusRunning, status(missing).Status)
+
+	// Second cycle: the missing execution reaches the threshold
+	monitor.checkWorkflows()
+
+	stored = status(missing)
+	assert.Equal(t, models.WorkflowStatusTerminated, stored.Status)
+	assert.Contains(t, stored.Error, "not found in 2 consecutive checks")
+
+	// Further cycles change nothing
+	monitor.checkWorkflows()
+	monitor.checkWorkflows()
+
+	assert.Equal(t, []string{"reaper." + ReaperActionResubmitted}, reaperActions(t, db, recoverable.ID))
+	assert.Equal(t, []string{"reaper." + ReaperActionFailedNeverStarted}, reaperActions(t, db, unrecoverable.ID))
+	assert.Empty(t, reaperActions(t, db, fresh.ID))
+	assert.Equal(t, []string{"reaper." + ReaperActionExecutionMissing, "reaper." + ReaperActionTerminatedMissing},
+		reaperActions(t, db, missing.ID))
+
+	assert.Equal(t, resubmitted+1, testutil.ToFloat64(reaperActionsTotal.WithLabelValues(ReaperActionResubmitted)))
+	assert.Equal(t, failed+1, testutil.ToFloat64(reaperActionsTotal.WithLabelValues(ReaperActionFailedNeverStarted)))
+	assert.Equal(t, terminated+1, testutil.ToFloat64(reaperActionsTotal.WithLabelValues(ReaperActionTerminatedMissing)))
+	temporalClient.AssertExpectations(t)
+}
+
+func TestWorkflowMonitor_ReaperFailsWorkflowWhenResubmitFails(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE audit_logs (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+		entity_type TEXT, entity_id TEXT, action TEXT, actor TEXT, details TEXT, created_at DATETIME)`).Error)
+
+	orphan := &models.Workflow{
+		Name:      "orphan",
+		Type:      models.WorkflowTypeCustom,
+		Status:    models.WorkflowStatusPending,
+		ProjectID: "project-1",
+		CreatedAt: time.Now().Add(-10 * time.Minute),
+	}
+	require.NoError(t, db.Create(orphan).Error)
+
+	temporalClient := new(mocks.Client)
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, serviceerror.NewUnavailable("temporal down")).Once()
+
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	engine := NewWorkflowEngine(db, redisClient, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+	monitor.EnableReaper(engine, ReaperConfig{PendingThreshold: time.Minute})
+
+	monitor.checkWorkflows()
+	monitor.checkWorkflows()
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", orphan.ID).Error)
+	assert.Equal(t, models.WorkflowStatusFailed, stored.Status)
+	assert.Contains(t, stored.Error, "temporal down")
+	assert.Equal(t, []string{"reaper." + ReaperActionFailedNeverStarted}, reaperActions(t, db, orphan.ID))
+	temporalClient.AssertExpectations(t)
+}
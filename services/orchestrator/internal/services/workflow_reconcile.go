@@ -0,0 +1,513 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrReconcileInProgress is returned when a reconciliation pass is
+	// requested while another runs
+	ErrReconcileInProgress = errors.New("workflow reconciliation already in progress")
+	// ErrInvalidReconcileRequest is returned for reconciliation options that
+	// cannot be applied
+	ErrInvalidReconcileRequest = errors.New("invalid reconcile request")
+)
+
+// Orphan policies of a reconciliation pass
+const (
+	OrphanPolicyLog       = "log"
+	OrphanPolicyTerminate = "terminate"
+)
+
+// Outcomes of a reconciliation pass, used as metric labels
+const (
+	ReconcileOutcomeCorrected  = "corrected"
+	ReconcileOutcomeNotFound   = "not_found"
+	ReconcileOutcomeUnchanged  = "unchanged"
+	ReconcileOutcomeSkipped    = "skipped"
+	ReconcileOutcomeFailed     = "failed"
+	ReconcileOutcomeOrphan     = "orphan"
+	ReconcileOutcomeTerminated = "orphan_terminated"
+)
+
+// maxReconcileReportItems bounds the workflows listed by outcome in a report;
+// the counts cover every workflow
+const maxReconcileReportItems = 100
+
+// Phases of a reconciliation pass
+const (
+	reconcilePhaseWorkflows = "workflows"
+	reconcilePhaseOrphans   = "orphans"
+)
+
+// reconcileStatuses are the workflow statuses a pass checks against Temporal
+var reconcileStatuses = []models.WorkflowStatus{
+	models.WorkflowStatusPending,
+	models.WorkflowStatusRunning,
+}
+
+var reconcileOutcomesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "orchestrator_workflow_reconcile_total",
+	Help: "Workflows and Temporal executions checked by reconciliation passes, by outcome",
+}, []string{"outcome"})
+
+// ReconcileCorrection is a workflow whose status a pass corrected
+type ReconcileCorrection struct {
+	WorkflowID string                `json:"workflow_id"`
+	From       models.WorkflowStatus `json:"from"`
+	To         models.WorkflowStatus `json:"to"`
+}
+
+// ReconcileOrphan is a running Temporal execution without a workflow row
+type ReconcileOrphan struct {
+	WorkflowID   string `json:"workflow_id"`
+	RunID        string `json:"run_id"`
+	WorkflowType string `json:"workflow_type"`
+	Terminated   bool   `json:"terminated"`
+}
+
+// ReconcileReport summarizes a reconciliation pass
+type ReconcileReport struct {
+	StartedAt    time.Time  `json:"started_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	Resumed      bool       `json:"resumed"`
+	OrphanPolicy string     `json:"orphan_policy"`
+	// Checked is the number of unfinished workflows checked
+	Checked   int `json:"checked"`
+	Corrected int `json:"corrected"`
+	// NotFound workflows have no execution in Temporal; the reaper marks
+	// them terminated
+	NotFound  int `json:"not_found"`
+	Unchanged int `json:"unchanged"`
+	// Skipped workflows never reached Temporal
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// Orphans is the number of running executions without a workflow row
+	Orphans           int `json:"orphans"`
+	OrphansTerminated int `json:"orphans_terminated"`
+
+	Corrections       []ReconcileCorrection `json:"corrections,omitempty"`
+	NotFoundWorkflows []string              `json:"not_found_workflows,omitempty"`
+	OrphanExecutions  []ReconcileOrphan     `json:"orphan_executions,omitempty"`
+}
+
+// ReconcileCheckpoint is the progress of an unfinished reconciliation pass
+type ReconcileCheckpoint struct {
+	Phase string `json:"phase"`
+	// After is the ID of the last workflow checked
+	After string `json:"after,omitempty"`
+	// PageToken is the Temporal visibility page of executions to check next
+	PageToken []byte          `json:"page_token,omitempty"`
+	Report    ReconcileReport `json:"report"`
+}
+
+// ReconcileCheckpointStore keeps the checkpoint of an unfinished pass, so a
+// pass interrupted by a crash or a cancelled request resumes where it stopped
+type ReconcileCheckpointStore interface {
+	Load(ctx context.Context) (*ReconcileCheckpoint, error) // Nil without an unfinished pass
+	Save(ctx context.Context, checkpoint *ReconcileCheckpoint) error
+	Clear(ctx context.Context) error
+}
+
+const (
+	reconcileCheckpointKey = "reconcile:workflows:checkpoint"
+	// reconcileCheckpointTTL is how long an interrupted pass can be resumed;
+	// later passes start over
+	reconcileCheckpointTTL = 24 * time.Hour
+)
+
+// redisReconcileCheckpointStore keeps the checkpoint in a key shared by
+// every replica
+type redisReconcileCheckpointStore struct {
+	client *redis.Client
+}
+
+// NewRedisReconcileCheckpointStore stores reconciliation checkpoints in Redis
+func NewRedisReconcileCheckpointStore(client *redis.Client) ReconcileCheckpointStore {
+	return &redisReconcileCheckpointStore{client: client}
+}
+
+func (s *redisReconcileCheckpointStore) Load(ctx context.Context) (*ReconcileCheckpoint, error) {
+	data, err := s.client.Get(ctx, reconcileCheckpointKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint ReconcileCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to decode reconcile checkpoint: %w", err)
+	}
+	return &checkpoint, nil
+}
+
+func (s *redisReconcileCheckpointStore) Save(ctx context.Context, checkpoint *ReconcileCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, reconcileCheckpointKey, data, reconcileCheckpointTTL).Err()
+}
+
+func (s *redisReconcileCheckpointStore) Clear(ctx context.Context) error {
+	return s.client.Del(ctx, reconcileCheckpointKey).Err()
+}
+
+// memoryReconcileCheckpointStore keeps the checkpoint in process, for a
+// single replica and tests
+type memoryReconcileCheckpointStore struct {
+	mu         sync.Mutex
+	checkpoint *ReconcileCheckpoint
+}
+
+// NewMemoryReconcileCheckpointStore stores reconciliation checkpoints in memory
+func NewMemoryReconcileCheckpointStore() ReconcileCheckpointStore {
+	return &memoryReconcileCheckpointStore{}
+}
+
+func (s *memoryReconcileCheckpointStore) Load(ctx context.Context) (*ReconcileCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoint == nil {
+		return nil, nil
+	}
+	checkpoint := *s.checkpoint
+	return &checkpoint, nil
+}
+
+func (s *memoryReconcileCheckpointStore) Save(ctx context.Context, checkpoint *ReconcileCheckpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := *checkpoint
+	s.checkpoint = &saved
+	return nil
+}
+
+func (s *memoryReconcileCheckpointStore) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = nil
+	return nil
+}
+
+// ReconcileOptions adjusts a reconciliation pass
+type ReconcileOptions struct {
+	// OrphanPolicy overrides the configured policy when set
+	OrphanPolicy string
+}
+
+// WorkflowReconciler reconciles the state of unfinished workflows with
+// Temporal, for when the monitor missed their executions closing, e.g.
+// across a crash. Statuses are corrected through the monitor's update path.
+type WorkflowReconciler struct {
+	monitor   *WorkflowMonitor
+	events    func(ctx context.Context, workflow *models.Workflow, eventType string, data map[string]interface{})
+	taskQueue string
+	store     ReconcileCheckpointStore
+	config    config.ReconcileConfig
+	limiter   *rate.Limiter
+
+	mu      sync.Mutex
+	running bool
+}
+
+// NewWorkflowReconciler creates a reconciler correcting workflows through
+// monitor and publishing their events through engine
+func NewWorkflowReconciler(monitor *WorkflowMonitor, engine *WorkflowEngine, store ReconcileCheckpointStore, cfg *config.ReconcileConfig) *WorkflowReconciler {
+	return &WorkflowReconciler{
+		monitor:   monitor,
+		events:    engine.emitWorkflowEvent,
+		taskQueue: engine.config.TaskQueue,
+		store:     store,
+		config:    *cfg,
+		limiter:   rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), 1),
+	}
+}
+
+// Reconcile runs a reconciliation pass, resuming an unfinished one. It pages
+// through pending and running workflows and applies the status Temporal
+// reports for each, then looks for running executions on the task queue
+// without a workflow row, logging them or terminating them by the orphan
+// policy. Calls to Temporal are rate-limited. The checkpoint is saved after
+// every page, so an interrupted pass returns its error and the report so far.
+func (r *WorkflowReconciler) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	policy := opts.OrphanPolicy
+	if policy == "" {
+		policy = r.config.OrphanPolicy
+	}
+	if policy != OrphanPolicyLog && policy != OrphanPolicyTerminate {
+		return nil, fmt.Errorf("%w: unsupported orphan policy %q", ErrInvalidReconcileRequest, policy)
+	}
+
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return nil, ErrReconcileInProgress
+	}
+	r.running = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+	}()
+
+	checkpoint, err := r.store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reconcile checkpoint: %w", err)
+	}
+	if checkpoint == nil {
+		checkpoint = &ReconcileCheckpoint{
+			Phase:  reconcilePhaseWorkflows,
+			Report: ReconcileReport{StartedAt: r.monitor.now().UTC()},
+		}
+	} else {
+		checkpoint.Report.Resumed = true
+		r.monitor.logger.Info("Resuming workflow reconciliation",
+			zap.String("phase", checkpoint.Phase),
+			zap.String("after", checkpoint.After))
+	}
+	report := &checkpoint.Report
+	report.OrphanPolicy = policy
+
+	for checkpoint.Phase == reconcilePhaseWorkflows {
+		var page []models.Workflow
+		query := r.monitor.db.WithContext(ctx).
+			Where("status IN ?", reconcileStatuses).
+			Order("id").
+			Limit(r.config.PageSize)
+		if checkpoint.After != "" {
+			query = query.Where("id > ?", checkpoint.After)
+		}
+		if err := query.Find(&page).Error; err != nil {
+			return report, fmt.Errorf("failed to list unfinished workflows: %w", err)
+		}
+		for i := range page {
+			if err := r.reconcileWorkflow(ctx, &page[i], report); err != nil {
+				return report, err
+			}
+			checkpoint.After = page[i].ID
+		}
+		if len(page) < r.config.PageSize {
+			checkpoint.Phase = reconcilePhaseOrphans
+		}
+		r.saveCheckpoint(ctx, checkpoint)
+	}
+
+	running, err := scopeVisibilityQuery("ExecutionStatus = 'Running'", r.taskQueue)
+	if err != nil {
+		return report, err
+	}
+	for checkpoint.Phase == reconcilePhaseOrphans {
+		if err := r.limiter.Wait(ctx); err != nil {
+			return report, err
+		}
+		resp, err := r.monitor.temporalClient.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			PageSize:      int32(r.config.PageSize),
+			Query:         running,
+			NextPageToken: checkpoint.PageToken,
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to list running executions: %w", err)
+		}
+		if err := r.reconcileExecutions(ctx, resp, policy, report); err != nil {
+			return report, err
+		}
+		checkpoint.PageToken = resp.GetNextPageToken()
+		if len(checkpoint.PageToken) == 0 {
+			break
+		}
+		r.saveCheckpoint(ctx, checkpoint)
+	}
+
+	completedAt := r.monitor.now().UTC()
+	report.CompletedAt = &completedAt
+	if err := r.store.Clear(ctx); err != nil {
+		r.monitor.logger.Warn("Failed to clear reconcile checkpoint", zap.Error(err))
+	}
+	r.monitor.logger.Info("Workflow reconciliation completed",
+		zap.Bool("resumed", report.Resumed),
+		zap.Int("checked", report.Checked),
+		zap.Int("corrected", report.Corrected),
+		zap.Int("not_found", report.NotFound),
+		zap.Int("unchanged", report.Unchanged),
+		zap.Int("skipped", report.Skipped),
+		zap.Int("failed", report.Failed),
+		zap.Int("orphans", report.Orphans),
+		zap.Int("orphans_terminated", report.OrphansTerminated))
+	return report, nil
+}
+
+// saveCheckpoint records the progress of a pass; a pass whose checkpoint is
+// lost starts over
+func (r *WorkflowReconciler) saveCheckpoint(ctx context.Context, checkpoint *ReconcileCheckpoint) {
+	if err := r.store.Save(ctx, checkpoint); err != nil {
+		r.monitor.logger.Warn("Failed to save reconcile checkpoint", zap.Error(err))
+	}
+}
+
+// reconcileWorkflow applies the status Temporal reports for a workflow. Only
+// a cancelled or expired ctx fails the pass.
+func (r *WorkflowReconciler) reconcileWorkflow(ctx context.Context, workflow *models.Workflow, report *ReconcileReport) error {
+	report.Checked++
+	// Workflows never submitted, or held by the scheduler, are the reaper's
+	if workflow.TemporalID == "" {
+		r.count(report, ReconcileOutcomeSkipped)
+		return nil
+	}
+
+	if err := r.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	resp, err := r.monitor.temporalClient.DescribeWorkflowExecution(ctx, workflow.TemporalID, workflow.TemporalRunID)
+	if err == nil && (isContinuedAsNew(resp) || mayRetry(resp)) {
+		reason := models.RunReasonRetry
+		if isContinuedAsNew(resp) {
+			reason = models.RunReasonContinueAsNew
+		}
+		if err = r.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		resp, err = r.monitor.followNextRun(ctx, workflow, resp, reason)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if isExecutionNotFound(err) {
+			r.count(report, ReconcileOutcomeNotFound)
+			if len(report.NotFoundWorkflows) < maxReconcileReportItems {
+				report.NotFoundWorkflows = append(report.NotFoundWorkflows, workflow.ID)
+			}
+			r.monitor.logger.Warn("Workflow execution not found in Temporal",
+				zap.String("workflowID", workflow.ID),
+				zap.String("temporalID", workflow.TemporalID))
+			return nil
+		}
+		r.count(report, ReconcileOutcomeFailed)
+		r.monitor.logger.Error("Failed to describe workflow execution",
+			zap.String("workflowID", workflow.ID),
+			zap.String("temporalID", workflow.TemporalID),
+			zap.Error(err))
+		return nil
+	}
+
+	from := workflow.Status
+	if !r.monitor.updateWorkflowStatus(workflow, resp) {
+		r.count(report, ReconcileOutcomeUnchanged)
+		return nil
+	}
+	r.count(report, ReconcileOutcomeCorrected)
+	if len(report.Corrections) < maxReconcileReportItems {
+		report.Corrections = append(report.Corrections, ReconcileCorrection{WorkflowID: workflow.ID, From: from, To: workflow.Status})
+	}
+	r.events(ctx, workflow, string(workflow.Status), map[string]interface{}{
+		"reason":          "reconciled",
+		"previous_status": from,
+	})
+	return nil
+}
+
+// reconcileExecutions finds the running executions of a page without a
+// workflow row. Child workflows have no row of their own and are left alone.
+func (r *WorkflowReconciler) reconcileExecutions(ctx context.Context, resp *workflowservice.ListWorkflowExecutionsResponse, policy string, report *ReconcileReport) error {
+	var ids []string
+	for _, info := range resp.GetExecutions() {
+		if info.GetParentExecution() == nil {
+			ids = append(ids, info.GetExecution().GetWorkflowId())
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Deleted workflows keep their row
+	var rows []models.Workflow
+	if err := r.monitor.db.WithContext(ctx).Unscoped().Select("id", "temporal_id").
+		Where("id IN ? OR temporal_id IN ?", ids, ids).Find(&rows).Error; err != nil {
+		return fmt.Errorf("failed to look up workflows of running executions: %w", err)
+	}
+	known := make(map[string]bool, len(rows)*2)
+	for _, row := range rows {
+		known[row.ID] = true
+		known[row.TemporalID] = true
+	}
+
+	for _, info := range resp.GetExecutions() {
+		if info.GetParentExecution() != nil || known[info.GetExecution().GetWorkflowId()] {
+			continue
+		}
+		orphan := ReconcileOrphan{
+			WorkflowID:   info.GetExecution().GetWorkflowId(),
+			RunID:        info.GetExecution().GetRunId(),
+			WorkflowType: info.GetType().GetName(),
+		}
+		r.count(report, ReconcileOutcomeOrphan)
+		r.monitor.logger.Warn("Temporal execution has no workflow",
+			zap.String("temporalID", orphan.WorkflowID),
+			zap.String("runID", orphan.RunID),
+			zap.String("workflowType", orphan.WorkflowType),
+			zap.String("orphanPolicy", policy))
+
+		if policy == OrphanPolicyTerminate {
+			if err := r.limiter.Wait(ctx); err != nil {
+				return err
+			}
+			err := r.monitor.temporalClient.TerminateWorkflow(ctx, orphan.WorkflowID, orphan.RunID,
+				"No orchestrator workflow for this execution")
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				r.count(report, ReconcileOutcomeFailed)
+				r.monitor.logger.Error("Failed to terminate orphaned execution",
+					zap.String("temporalID", orphan.WorkflowID),
+					zap.Error(err))
+			} else {
+				orphan.Terminated = true
+				r.count(report, ReconcileOutcomeTerminated)
+			}
+		}
+		if len(report.OrphanExecutions) < maxReconcileReportItems {
+			report.OrphanExecutions = append(report.OrphanExecutions, orphan)
+		}
+	}
+	return nil
+}
+
+// count adds a workflow or execution to the report and metrics
+func (r *WorkflowReconciler) count(report *ReconcileReport, outcome string) {
+	reconcileOutcomesTotal.WithLabelValues(outcome).Inc()
+	switch outcome {
+	case ReconcileOutcomeCorrected:
+		report.Corrected++
+	case ReconcileOutcomeNotFound:
+		report.NotFound++
+	case ReconcileOutcomeUnchanged:
+		report.Unchanged++
+	case ReconcileOutcomeSkipped:
+		report.Skipped++
+	case ReconcileOutcomeFailed:
+		report.Failed++
+	case ReconcileOutcomeOrphan:
+		report.Orphans++
+	case ReconcileOutcomeTerminated:
+		report.OrphansTerminated++
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// setupReconciler returns a reconciler over db with an in-memory checkpoint
+func setupReconciler(t *testing.T, db *gorm.DB, temporalClient *mocks.Client, cfg config.ReconcileConfig) (*WorkflowReconciler, ReconcileCheckpointStore) {
+	// Unreachable Redis: cache invalidation and events only log errors
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	t.Cleanup(func() { redisClient.Close() })
+	engine := NewWorkflowEngine(db, redisClient, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+	store := NewMemoryReconcileCheckpointStore()
+	return NewWorkflowReconciler(monitor, engine, store, &cfg), store
+}
+
+func createReconcileWorkflow(t *testing.T, db *gorm.DB, name string, status models.WorkflowStatus, temporalID string) *models.Workflow {
+	workflow := &models.Workflow{
+		Name:       name,
+		Type:       models.WorkflowTypeExecution,
+		Status:     status,
+		ProjectID:  "project-1",
+		TemporalID: temporalID,
+	}
+	if temporalID != "" {
+		workflow.TemporalRunID = "run-" + temporalID
+	}
+	require.NoError(t, db.Create(workflow).Error)
+	return workflow
+}
+
+func executionInfo(workflowID string, parent bool) *workflow.WorkflowExecutionInfo {
+	info := &workflow.WorkflowExecutionInfo{
+		Execution: &common.WorkflowExecution{WorkflowId: workflowID, RunId: "run-" + workflowID},
+		Type:      &common.WorkflowType{Name: "CodeExecutionWorkflow"},
+		Status:    enums.WORKFLOW_EXECUTION_STATUS_RUNNING,
+	}
+	if parent {
+		info.ParentExecution = &common.WorkflowExecution{WorkflowId: "parent", RunId: "run-parent"}
+	}
+	return info
+}
+
+func pageToken(token string) interface{} {
+	return mock.MatchedBy(func(req *workflowservice.ListWorkflowExecutionsRequest) bool {
+		return string(req.NextPageToken) == token
+	})
+}
+
+func TestWorkflowReconciler_ReconcilesMismatches(t *testing.T) {
+	ctx := context.Background()
+	db := setupWorkflowTestDB(t)
+	done := createReconcileWorkflow(t, db, "done", models.WorkflowStatusRunning, "t-done")
+	live := createReconcileWorkflow(t, db, "live", models.WorkflowStatusRunning, "t-live")
+	started := createReconcileWorkflow(t, db, "started", models.WorkflowStatusPending, "t-started")
+	lost := createReconcileWorkflow(t, db, "lost", models.WorkflowStatusRunning, "t-lost")
+	held := createReconcileWorkflow(t, db, "held", models.WorkflowStatusPending, "")
+	broken := createReconcileWorkflow(t, db, "broken", models.WorkflowStatusRunning, "t-broken")
+	finished := createReconcileWorkflow(t, db, "finished", models.WorkflowStatusCompleted, "t-finished")
+	deleted := createReconcileWorkflow(t, db, "deleted", models.WorkflowStatusCompleted, "t-deleted")
+	require.NoError(t, db.Delete(deleted).Error)
+
+	temporalClient := new(mocks.Client)
+	describe := func(workflow *models.Workflow) *mock.Call {
+		return temporalClient.On("DescribeWorkflowExecution", mock.Anything, workflow.TemporalID, workflow.TemporalRunID)
+	}
+	describe(done).Return(describeResponse("t-done", "run-t-done", enums.WORKFLOW_EXECUTION_STATUS_COMPLETED), nil).Once()
+	describe(live).Return(describeResponse("t-live", "run-t-live", enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil).Once()
+	describe(started).Return(describeResponse("t-started", "run-t-started", enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil).Once()
+	describe(lost).Return(nil, serviceerror.NewNotFound("workflow execution not found")).Once()
+	describe(broken).Return(nil, serviceerror.NewUnavailable("temporal unavailable")).Once()
+
+	// Running executions span two pages: one of a workflow, a child
+	// workflow, one of a deleted workflow and an orphan
+	temporalClient.On("ListWorkflow", mock.Anything, pageToken("")).Return(&workflowservice.ListWorkflowExecutionsResponse{
+		Executions:    []*workflow.WorkflowExecutionInfo{executionInfo("t-live", false), executionInfo("child-1", true)},
+		NextPageToken: []byte("page-2"),
+	}, nil).Once()
+	temporalClient.On("ListWorkflow", mock.Anything, pageToken("page-2")).Return(&workflowservice.ListWorkflowExecutionsResponse{
+		Executions: []*workflow.WorkflowExecutionInfo{executionInfo(deleted.ID, false), executionInfo("orphan-1", false)},
+	}, nil).Once()
+	temporalClient.On("TerminateWorkflow", mock.Anything, "orphan-1", "run-orphan-1", mock.Anything).Return(nil).Once()
+
+	reconciler, store := setupReconciler(t, db, temporalClient, config.ReconcileConfig{
+		RequestsPerSecond: 1000, PageSize: 4, OrphanPolicy: OrphanPolicyLog,
+	})
+
+	_, err := reconciler.Reconcile(ctx, ReconcileOptions{OrphanPolicy: "ignore"})
+	assert.ErrorIs(t, err, ErrInvalidReconcileRequest)
+
+	report, err := reconciler.Reconcile(ctx, ReconcileOptions{OrphanPolicy: OrphanPolicyTerminate})
+	require.NoError(t, err)
+	temporalClient.AssertExpectations(t)
+
+	assert.False(t, report.Resumed)
+	assert.NotNil(t, report.CompletedAt)
+	assert.Equal(t, OrphanPolicyTerminate, report.OrphanPolicy)
+	assert.Equal(t, 6, report.Checked)
+	assert.Equal(t, 2, report.Corrected)
+	assert.Equal(t, 1, report.NotFound)
+	assert.Equal(t, 1, report.Unchanged)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 1, report.Orphans)
+	assert.Equal(t, 1, report.OrphansTerminated)
+	assert.ElementsMatch(t, []ReconcileCorrection{
+		{WorkflowID: done.ID, From: models.WorkflowStatusRunning, To: models.WorkflowStatusCompleted},
+		{WorkflowID: started.ID, From: models.WorkflowStatusPending, To: models.WorkflowStatusRunning},
+	}, report.Corrections)
+	assert.Equal(t, []string{lost.ID}, report.NotFoundWorkflows)
+	assert.Equal(t, []ReconcileOrphan{{
+		WorkflowID: "orphan-1", RunID: "run-orphan-1", WorkflowType: "CodeExecutionWorkflow", Terminated: true,
+	}}, report.OrphanExecutions)
+
+	status := func(workflow *models.Workflow) models.WorkflowStatus {
+		var stored models.Workflow
+		require.NoError(t, db.Unscoped().First(&stored, "id = ?", workflow.ID).Error)
+		return stored.Status
+	}
+	assert.Equal(t, models.WorkflowStatusCompleted, status(done))
+	assert.Equal(t, models.WorkflowStatusRunning, status(started))
+	assert.Equal(t, models.WorkflowStatusRunning, status(lost), "missing executions are left to the reaper")
+	assert.Equal(t, models.WorkflowStatusPending, status(held))
+	assert.Equal(t, models.WorkflowStatusCompleted, status(finished))
+
+	checkpoint, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint, "a finished pass leaves no checkpoint")
+}
+
+func TestWorkflowReconciler_ResumesInterruptedPass(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	first := createReconcileWorkflow(t, db, "first", models.WorkflowStatusRunning, "t-first")
+	second := createReconcileWorkflow(t, db, "second", models.WorkflowStatusRunning, "t-second")
+	third := createReconcileWorkflow(t, db, "third", models.WorkflowStatusRunning, "t-third")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	temporalClient := new(mocks.Client)
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "t-first", "run-t-first").
+		Return(describeResponse("t-first", "run-t-first", enums.WORKFLOW_EXECUTION_STATUS_COMPLETED), nil).Once()
+	// The pass is interrupted while describing the second workflow
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "t-second", "run-t-second").
+		Run(func(mock.Arguments) { cancel() }).Return(nil, context.Canceled).Once()
+
+	reconciler, store := setupReconciler(t, db, temporalClient, config.ReconcileConfig{
+		RequestsPerSecond: 1000, PageSize: 1, OrphanPolicy: OrphanPolicyLog,
+	})
+	report, err := reconciler.Reconcile(ctx, ReconcileOptions{})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, report.Corrected)
+	assert.Nil(t, report.CompletedAt)
+
+	checkpoint, err := store.Load(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, first.ID, checkpoint.After)
+
+	// The next pass starts after the last finished page
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "t-second", "run-t-second").
+		Return(describeResponse("t-second", "run-t-second", enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil).Once()
+	// Timed out runs may have been retried, so the latest run is described
+	timedOut := describeResponse("t-third", "run-t-third", enums.WORKFLOW_EXECUTION_STATUS_TIMED_OUT)
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "t-third", "run-t-third").Return(timedOut, nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "t-third", "").Return(timedOut, nil).Once()
+	temporalClient.On("ListWorkflow", mock.Anything, pageToken("")).
+		Return(&workflowservice.ListWorkflowExecutionsResponse{}, nil).Once()
+
+	report, err = reconciler.Reconcile(context.Background(), ReconcileOptions{})
+	require.NoError(t, err)
+	temporalClient.AssertExpectations(t)
+	assert.True(t, report.Resumed)
+	assert.Equal(t, 3, report.Checked)
+	assert.Equal(t, 2, report.Corrected)
+	assert.Equal(t, 1, report.Unchanged)
+	assert.Equal(t, []ReconcileCorrection{
+		{WorkflowID: first.ID, From: models.WorkflowStatusRunning, To: models.WorkflowStatusCompleted},
+		{WorkflowID: third.ID, From: models.WorkflowStatusRunning, To: models.WorkflowStatusTimedOut},
+	}, report.Corrections)
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", second.ID).Error)
+	assert.Equal(t, models.WorkflowStatusRunning, stored.Status)
+}
+
+func TestWorkflowReconciler_RateLimitsTemporalCalls(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	for _, name := range []string{"a", "b", "c"} {
+		createReconcileWorkflow(t, db, name, models.WorkflowStatusRunning, "t-"+name)
+	}
+	temporalClient := new(mocks.Client)
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, mock.Anything, mock.Anything).
+		Return(describeResponse("t", "run-t", enums.WORKFLOW_EXECUTION_STATUS_RUNNING), nil)
+	temporalClient.On("ListWorkflow", mock.Anything, mock.Anything).
+		Return(&workflowservice.ListWorkflowExecutionsResponse{}, nil)
+
+	reconciler, _ := setupReconciler(t, db, temporalClient, config.ReconcileConfig{
+		RequestsPerSecond: 20, PageSize: 10, OrphanPolicy: OrphanPolicyLog,
+	})
+	start := time.Now()
+	_, err := reconciler.Reconcile(context.Background(), ReconcileOptions{})
+	require.NoError(t, err)
+
+	// Four calls at 20 per second wait at least three intervals
+	assert.GreaterOrEqual(t, time.Since(start), 140*time.Millisecond)
+}
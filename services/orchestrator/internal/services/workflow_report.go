@@ -0,0 +1,430 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// ReportLimits caps the entries of each report section. Entries past a limit
+// are dropped and counted as omitted.
+type ReportLimits struct {
+	Timeline        int
+	Artifacts       int
+	Errors          int
+	Comments        int
+	ErrorMessageLen int // Runes of each error message
+}
+
+// DefaultReportLimits are the section limits of workflow reports
+var DefaultReportLimits = ReportLimits{Timeline: 200, Artifacts: 100, Errors: 50, Comments: 100, ErrorMessageLen: 500}
+
+// ReportSection is a possibly truncated list of report entries
+type ReportSection[T any] struct {
+	Items   []T `json:"items"`
+	Total   int `json:"total"`
+	Omitted int `json:"omitted,omitempty"`
+}
+
+func newReportSection[T any](items []T, limit int) ReportSection[T] {
+	section := ReportSection[T]{Items: items, Total: len(items)}
+	if section.Items == nil {
+		section.Items = []T{}
+	}
+	if limit > 0 && len(items) > limit {
+		section.Items = items[:limit]
+		section.Omitted = len(items) - limit
+	}
+	return section
+}
+
+// WorkflowReport is the execution trace of a workflow assembled from its
+// steps, executions, artifacts and logs
+type WorkflowReport struct {
+	GeneratedAt time.Time                     `json:"generated_at"`
+	Workflow    ReportWorkflow                `json:"workflow"`
+	Intent      *models.IntentAnalysis        `json:"intent,omitempty"`
+	Timeline    ReportSection[TimelineEntry]  `json:"timeline"`
+	Agents      []ReportAgent                 `json:"agents"`
+	Artifacts   ReportSection[ReportArtifact] `json:"artifacts"`
+	Errors      ReportSection[ReportError]    `json:"errors"`
+	Comments    ReportSection[ReportComment]  `json:"comments"`
+	Totals      ReportTotals                  `json:"totals"`
+}
+
+// ReportWorkflow is the workflow metadata of a report
+type ReportWorkflow struct {
+	ID          string                  `json:"id"`
+	Name        string                  `json:"name"`
+	Type        models.WorkflowType     `json:"type"`
+	Priority    models.WorkflowPriority `json:"priority"`
+	Status      models.WorkflowStatus   `json:"status"`
+	ProjectID   string                  `json:"project_id"`
+	Tags        []string                `json:"tags,omitempty"`
+	Source      *models.WorkflowSource  `json:"source,omitempty"` // Git context the workflow was started for
+	CreatedBy   string                  `json:"created_by,omitempty"`
+	CreatedAt   time.Time               `json:"created_at"`
+	StartedAt   *time.Time              `json:"started_at,omitempty"`
+	CompletedAt *time.Time              `json:"completed_at,omitempty"`
+	Duration    int64                   `json:"duration_ms"`
+}
+
+// TimelineEntry is a step or execution of the workflow
+type TimelineEntry struct {
+	Kind        string     `json:"kind"` // step or execution
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	AgentID     string     `json:"agent_id,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Duration    int64      `json:"duration_ms"`
+	Events      int        `json:"events,omitempty"`
+}
+
+// ReportAgent summarizes the executions of one agent
+type ReportAgent struct {
+	ID         string  `json:"id"`
+	Executions int     `json:"executions"`
+	Failed     int     `json:"failed"`
+	Duration   int64   `json:"duration_ms"`
+	Cost       float64 `json:"cost"`
+}
+
+// ReportArtifact is an artifact produced by the workflow
+type ReportArtifact struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+	Link        string `json:"link,omitempty"`
+	ExecutionID string `json:"execution_id,omitempty"`
+}
+
+// ReportError is an error of the workflow, a step, an execution or an
+// error-level execution log
+type ReportError struct {
+	Source   string     `json:"source"`
+	Activity string     `json:"activity"`
+	Message  string     `json:"message"`
+	At       *time.Time `json:"at,omitempty"`
+}
+
+// ReportComment is an operator comment on the workflow
+type ReportComment struct {
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	Pinned    bool      `json:"pinned,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReportTotals are the resource usage and cost totals of a workflow
+type ReportTotals struct {
+	Steps            int     `json:"steps"`
+	StepsFailed      int     `json:"steps_failed"`
+	Executions       int     `json:"executions"`
+	ExecutionsFailed int     `json:"executions_failed"`
+	ExecutionTime    int64   `json:"execution_time_ms"`
+	Cost             float64 `json:"cost"`
+	ArtifactBytes    int64   `json:"artifact_bytes"`
+	PeakCPUUsage     float64 `json:"peak_cpu_usage"`
+	PeakMemoryBytes  int64   `json:"peak_memory_bytes"`
+	NetworkRxBytes   int64   `json:"network_rx_bytes"`
+	NetworkTxBytes   int64   `json:"network_tx_bytes"`
+	DiskReadBytes    int64   `json:"disk_read_bytes"`
+	DiskWriteBytes   int64   `json:"disk_write_bytes"`
+}
+
+// WorkflowReportBuilder assembles workflow reports from stored data
+type WorkflowReportBuilder struct {
+	db        *gorm.DB
+	limits    ReportLimits
+	now       func() time.Time
+	redactors *ProjectRedactors
+}
+
+// NewWorkflowReportBuilder creates a report builder with the default limits
+func NewWorkflowReportBuilder(db *gorm.DB) *WorkflowReportBuilder {
+	return &WorkflowReportBuilder{db: db, limits: DefaultReportLimits, now: time.Now}
+}
+
+// Build assembles the report of a workflow. It returns gorm.ErrRecordNotFound
+// for unknown workflows.
+func (b *WorkflowReportBuilder) Build(ctx context.Context, workflowID string) (*WorkflowReport, error) {
+	db := b.db.WithContext(ctx)
+
+	var workflow models.Workflow
+	if err := db.Where("id = ?", workflowID).First(&workflow).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+
+	var steps []models.WorkflowStep
+	if err := db.Where("workflow_id = ?", workflowID).Order(`"order"`).Find(&steps).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow steps: %w", err)
+	}
+
+	var executions []models.Execution
+	if err := db.Where("workflow_id = ?", workflowID).Order("created_at").Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get executions: %w", err)
+	}
+	executionIDs := make([]string, len(executions))
+	for i, execution := range executions {
+		executionIDs[i] = execution.ID
+	}
+
+	var artifacts []models.Artifact
+	var logs []models.ExecutionLog
+	events := make(map[string]int)
+	if len(executionIDs) > 0 {
+		if err := db.Where("execution_id IN ?", executionIDs).Order("created_at").Find(&artifacts).Error; err != nil {
+			return nil, fmt.Errorf("failed to get artifacts: %w", err)
+		}
+		if err := db.Where("execution_id IN ? AND level = ?", executionIDs, "error").
+			Order("timestamp").Find(&logs).Error; err != nil {
+			return nil, fmt.Errorf("failed to get execution logs: %w", err)
+		}
+		var counts []struct {
+			ExecutionID string
+			Count       int
+		}
+		if err := db.Model(&models.ExecutionEvent{}).Select("execution_id, COUNT(*) AS count").
+			Where("execution_id IN ?", executionIDs).Group("execution_id").Scan(&counts).Error; err != nil {
+			return nil, fmt.Errorf("failed to count execution events: %w", err)
+		}
+		for _, count := range counts {
+			events[count.ExecutionID] = count.Count
+		}
+	}
+
+	// Pinned comments lead; the rest read in the order they were written
+	var comments []models.WorkflowComment
+	if err := db.Where("workflow_id = ?", workflowID).Order("pinned DESC, created_at, id").Find(&comments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get comments: %w", err)
+	}
+
+	var intent *models.IntentAnalysis
+	var analysis models.IntentAnalysis
+	err := db.Where("workflow_id = ?", workflowID).Order("created_at DESC").Limit(1).Find(&analysis).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get intent analysis: %w", err)
+	}
+	if analysis.ID != "" {
+		intent = &analysis
+	}
+
+	report := &WorkflowReport{
+		GeneratedAt: b.now().UTC(),
+		Workflow: ReportWorkflow{
+			ID:          workflow.ID,
+			Name:        workflow.Name,
+			Type:        workflow.Type,
+			Priority:    workflow.Priority,
+			Status:      workflow.Status,
+			ProjectID:   workflow.ProjectID,
+			Tags:        workflow.Tags,
+			Source:      workflow.Source,
+			CreatedBy:   workflow.CreatedBy,
+			CreatedAt:   workflow.CreatedAt,
+			StartedAt:   workflow.StartedAt,
+			CompletedAt: workflow.CompletedAt,
+			Duration:    workflow.Duration,
+		},
+		Intent: intent,
+	}
+
+	var timeline []TimelineEntry
+	var reportErrors []ReportError
+	if workflow.Error != "" {
+		reportErrors = append(reportErrors, ReportError{Source: "workflow", Activity: workflow.Name, Message: workflow.Error, At: workflow.CompletedAt})
+	}
+
+	totals := &report.Totals
+	totals.Steps = len(steps)
+	for _, step := range steps {
+		timeline = append(timeline, TimelineEntry{
+			Kind:        "step",
+			Name:        step.Name,
+			Status:      string(step.Status),
+			StartedAt:   step.StartedAt,
+			CompletedAt: step.CompletedAt,
+			Duration:    step.Duration,
+		})
+		if step.Status == models.WorkflowStatusFailed {
+			totals.StepsFailed++
+		}
+		if step.Error != "" {
+			reportErrors = append(reportErrors, ReportError{Source: "step", Activity: step.Name, Message: step.Error, At: step.CompletedAt})
+		}
+	}
+
+	agents := make(map[string]*ReportAgent)
+	var agentOrder []string
+	executionNames := make(map[string]string, len(executions))
+	totals.Executions = len(executions)
+	for _, execution := range executions {
+		executionNames[execution.ID] = execution.Name
+		failed := execution.Status == models.ExecutionStatusFailed || execution.Status == models.ExecutionStatusTimedOut
+		cost := executionCost(execution)
+
+		timeline = append(timeline, TimelineEntry{
+			Kind:        "execution",
+			Name:        execution.Name,
+			Status:      string(execution.Status),
+			AgentID:     execution.AgentID,
+			StartedAt:   execution.StartedAt,
+			CompletedAt: execution.CompletedAt,
+			Duration:    execution.Duration,
+			Events:      events[execution.ID],
+		})
+		if execution.Error != "" {
+			reportErrors = append(reportErrors, ReportError{Source: "execution", Activity: execution.Name, Message: execution.Error, At: execution.CompletedAt})
+		}
+
+		if failed {
+			totals.ExecutionsFailed++
+		}
+		totals.ExecutionTime += execution.Duration
+		totals.Cost += cost
+		if usage, err := execution.GetResourceUsage(); err == nil && usage != nil {
+			totals.PeakCPUUsage = max(totals.PeakCPUUsage, usage.CPUUsage)
+			totals.PeakMemoryBytes = max(totals.PeakMemoryBytes, usage.MemoryUsage)
+			totals.NetworkRxBytes += usage.NetworkRxBytes
+			totals.NetworkTxBytes += usage.NetworkTxBytes
+			totals.DiskReadBytes += usage.DiskReadBytes
+			totals.DiskWriteBytes += usage.DiskWriteBytes
+		}
+
+		if execution.AgentID == "" {
+			continue
+		}
+		agent, ok := agents[execution.AgentID]
+		if !ok {
+			agent = &ReportAgent{ID: execution.AgentID}
+			agents[execution.AgentID] = agent
+			agentOrder = append(agentOrder, execution.AgentID)
+		}
+		agent.Executions++
+		if failed {
+			agent.Failed++
+		}
+		agent.Duration += execution.Duration
+		agent.Cost += cost
+	}
+	report.Agents = make([]ReportAgent, len(agentOrder))
+	for i, id := range agentOrder {
+		report.Agents[i] = *agents[id]
+	}
+
+	for _, log := range logs {
+		activity := log.Source
+		if activity == "" {
+			activity = executionNames[log.ExecutionID]
+		}
+		at := log.Timestamp
+		reportErrors = append(reportErrors, ReportError{Source: "log", Activity: activity, Message: log.Message, At: &at})
+	}
+	for i := range reportErrors {
+		reportErrors[i].Message = truncateRunes(reportErrors[i].Message, b.limits.ErrorMessageLen)
+	}
+
+	reportArtifacts := make([]ReportArtifact, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		link := artifact.URL
+		if link == "" {
+			link = artifact.Path
+		}
+		reportArtifacts = append(reportArtifacts, ReportArtifact{
+			Name:        artifact.Name,
+			Type:        artifact.Type,
+			Size:        artifact.Size,
+			Link:        link,
+			ExecutionID: artifact.ExecutionID,
+		})
+	}
+	// Task execution workflows keep their artifacts in the workflow output
+	if len(reportArtifacts) == 0 {
+		reportArtifacts = outputArtifacts(workflow.Output)
+	}
+	for _, artifact := range reportArtifacts {
+		totals.ArtifactBytes += artifact.Size
+	}
+
+	// Steps and executions interleave by start time; entries that never
+	// started keep their relative order at the end
+	sort.SliceStable(timeline, func(i, j int) bool {
+		a, b := timeline[i].StartedAt, timeline[j].StartedAt
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.Before(*b)
+	})
+
+	report.Timeline = newReportSection(timeline, b.limits.Timeline)
+	report.Artifacts = newReportSection(reportArtifacts, b.limits.Artifacts)
+	report.Errors = newReportSection(reportErrors, b.limits.Errors)
+
+	reportComments := make([]ReportComment, len(comments))
+	for i, comment := range comments {
+		reportComments[i] = ReportComment{
+			Author:    comment.Author,
+			Body:      comment.Body,
+			Pinned:    comment.Pinned,
+			CreatedAt: comment.CreatedAt,
+			UpdatedAt: comment.UpdatedAt,
+		}
+	}
+	report.Comments = newReportSection(reportComments, b.limits.Comments)
+	redactReport(report, b.redactors.For(ctx, workflow.ProjectID))
+	return report, nil
+}
+
+// executionCost returns the cost recorded in an execution's metadata
+func executionCost(execution models.Execution) float64 {
+	if len(execution.Metadata) == 0 {
+		return 0
+	}
+	var metadata struct {
+		Cost float64 `json:"cost"`
+	}
+	if err := json.Unmarshal(execution.Metadata, &metadata); err != nil {
+		return 0
+	}
+	return metadata.Cost
+}
+
+// outputArtifacts reads the artifacts listed in a workflow's output
+func outputArtifacts(output json.RawMessage) []ReportArtifact {
+	if len(output) == 0 {
+		return nil
+	}
+	var result struct {
+		Artifacts []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+			Path string `json:"path"`
+			Size int64  `json:"size"`
+		} `json:"artifacts"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil
+	}
+	artifacts := make([]ReportArtifact, len(result.Artifacts))
+	for i, artifact := range result.Artifacts {
+		artifacts[i] = ReportArtifact{Name: artifact.Name, Type: artifact.Type, Size: artifact.Size, Link: artifact.Path}
+	}
+	return artifacts
+}
+
+func truncateRunes(s string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:limit]) + "…"
+}
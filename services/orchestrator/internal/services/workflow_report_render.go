@@ -0,0 +1,270 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// ErrUnsupportedReportFormat is returned for report formats without a renderer
+var ErrUnsupportedReportFormat = errors.New("unsupported report format")
+
+// ReportRenderer renders workflow reports in one format
+type ReportRenderer interface {
+	ContentType() string
+	FileExtension() string
+	Render(w io.Writer, report *WorkflowReport) error
+}
+
+var (
+	reportRenderersMu sync.RWMutex
+	reportRenderers   = map[string]ReportRenderer{
+		"json":     JSONReportRenderer{},
+		"markdown": MarkdownReportRenderer{},
+	}
+)
+
+// RegisterReportRenderer adds or replaces the renderer of a report format
+func RegisterReportRenderer(format string, renderer ReportRenderer) {
+	reportRenderersMu.Lock()
+	defer reportRenderersMu.Unlock()
+	reportRenderers[format] = renderer
+}
+
+// ReportRendererFor returns the renderer of a report format
+func ReportRendererFor(format string) (ReportRenderer, error) {
+	reportRenderersMu.RLock()
+	defer reportRenderersMu.RUnlock()
+	renderer, ok := reportRenderers[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedReportFormat, format)
+	}
+	return renderer, nil
+}
+
+// JSONReportRenderer renders reports as indented JSON
+type JSONReportRenderer struct{}
+
+func (JSONReportRenderer) ContentType() string   { return "application/json; charset=utf-8" }
+func (JSONReportRenderer) FileExtension() string { return "json" }
+
+func (JSONReportRenderer) Render(w io.Writer, report *WorkflowReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// MarkdownReportRenderer renders reports as a Markdown document
+type MarkdownReportRenderer struct{}
+
+func (MarkdownReportRenderer) ContentType() string   { return "text/markdown; charset=utf-8" }
+func (MarkdownReportRenderer) FileExtension() string { return "md" }
+
+func (MarkdownReportRenderer) Render(w io.Writer, report *WorkflowReport) error {
+	return markdownReportTemplate.Execute(w, report)
+}
+
+var markdownReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"cell":    markdownCell,
+	"time":    formatReportTime,
+	"ms":      formatMillis,
+	"seconds": formatSeconds,
+	"bytes":   formatBytes,
+	"join":    strings.Join,
+	"percent": func(f float64) string { return fmt.Sprintf("%.0f%%", f*100) },
+	"money":   func(f float64) string { return fmt.Sprintf("$%.2f", f) },
+	"quote":   markdownQuote,
+}).Parse(`# Workflow report: {{cell .Workflow.Name}}
+
+Generated {{time .GeneratedAt}}
+
+| Field | Value |
+| --- | --- |
+| ID | {{.Workflow.ID}} |
+| Type | {{.Workflow.Type}} |
+| Priority | {{.Workflow.Priority}} |
+| Status | {{.Workflow.Status}} |
+| Project | {{.Workflow.ProjectID}} |
+{{- if .Workflow.Tags}}
+| Tags | {{cell (join .Workflow.Tags ", ")}} |
+{{- end}}
+{{- with .Workflow.Source}}
+| Repository | {{cell .Repository}} |
+{{- if .Branch}}
+| Branch | {{cell .Branch}} |
+{{- end}}
+{{- if .CommitSHA}}
+| Commit | {{.CommitSHA}} |
+{{- end}}
+{{- if .PRNumber}}
+| Pull request | #{{.PRNumber}} |
+{{- end}}
+{{- if .CIRunURL}}
+| CI run | [link]({{.CIRunURL}}) |
+{{- end}}
+{{- end}}
+{{- if .Workflow.CreatedBy}}
+| Created by | {{cell .Workflow.CreatedBy}} |
+{{- end}}
+| Created | {{time .Workflow.CreatedAt}} |
+| Started | {{time .Workflow.StartedAt}} |
+| Completed | {{time .Workflow.CompletedAt}} |
+| Duration | {{ms .Workflow.Duration}} |
+
+## Intent
+{{with .Intent}}
+- Type: {{.IntentType}}
+- Confidence: {{percent .Confidence}}{{if .LowConfidence}} (low){{end}}
+- Estimated time: {{seconds .EstimatedTime}}
+- Estimated cost: {{money .EstimatedCost}}
+{{- if .Risks}}
+- Risks: {{join .Risks "; "}}
+{{- end}}
+{{- else}}
+No intent analysis recorded.
+{{- end}}
+
+## Timeline
+{{if .Timeline.Items}}
+| Kind | Name | Status | Agent | Started | Duration | Events |
+| --- | --- | --- | --- | --- | --- | --- |
+{{- range .Timeline.Items}}
+| {{.Kind}} | {{cell .Name}} | {{.Status}} | {{or .AgentID "-"}} | {{time .StartedAt}} | {{ms .Duration}} | {{if eq .Kind "step"}}-{{else}}{{.Events}}{{end}} |
+{{- end}}
+{{- else}}
+No steps or executions recorded.
+{{- end}}
+{{- if .Timeline.Omitted}}
+
+_{{.Timeline.Omitted}} of {{.Timeline.Total}} entries omitted._
+{{- end}}
+
+## Agents
+{{if .Agents}}
+| Agent | Executions | Failed | Duration | Cost |
+| --- | --- | --- | --- | --- |
+{{- range .Agents}}
+| {{.ID}} | {{.Executions}} | {{.Failed}} | {{ms .Duration}} | {{money .Cost}} |
+{{- end}}
+{{- else}}
+No agents involved.
+{{- end}}
+
+## Artifacts
+{{if .Artifacts.Items}}
+| Name | Type | Size | Link |
+| --- | --- | --- | --- |
+{{- range .Artifacts.Items}}
+| {{cell .Name}} | {{.Type}} | {{bytes .Size}} | {{with .Link}}[link]({{.}}){{else}}-{{end}} |
+{{- end}}
+{{- else}}
+No artifacts produced.
+{{- end}}
+{{- if .Artifacts.Omitted}}
+
+_{{.Artifacts.Omitted}} of {{.Artifacts.Total}} artifacts omitted._
+{{- end}}
+
+## Errors
+{{if .Errors.Items}}
+| Source | Activity | Message | At |
+| --- | --- | --- | --- |
+{{- range .Errors.Items}}
+| {{.Source}} | {{cell .Activity}} | {{cell .Message}} | {{time .At}} |
+{{- end}}
+{{- else}}
+No errors.
+{{- end}}
+{{- if .Errors.Omitted}}
+
+_{{.Errors.Omitted}} of {{.Errors.Total}} errors omitted._
+{{- end}}
+
+## Comments
+{{- range .Comments.Items}}
+
+**{{cell .Author}}**, {{time .CreatedAt}}{{if .Pinned}} (pinned){{end}}
+
+{{quote .Body}}
+{{- else}}
+
+No comments.
+{{- end}}
+{{- if .Comments.Omitted}}
+
+_{{.Comments.Omitted}} of {{.Comments.Total}} comments omitted._
+{{- end}}
+
+## Totals
+
+| Metric | Value |
+| --- | --- |
+| Steps | {{.Totals.Steps}} ({{.Totals.StepsFailed}} failed) |
+| Executions | {{.Totals.Executions}} ({{.Totals.ExecutionsFailed}} failed) |
+| Execution time | {{ms .Totals.ExecutionTime}} |
+| Cost | {{money .Totals.Cost}} |
+| Artifacts | {{bytes .Totals.ArtifactBytes}} |
+| Peak CPU | {{printf "%.1f" .Totals.PeakCPUUsage}}% |
+| Peak memory | {{bytes .Totals.PeakMemoryBytes}} |
+| Network rx/tx | {{bytes .Totals.NetworkRxBytes}} / {{bytes .Totals.NetworkTxBytes}} |
+| Disk read/write | {{bytes .Totals.DiskReadBytes}} / {{bytes .Totals.DiskWriteBytes}} |
+`))
+
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// markdownQuote renders a Markdown comment body as a blockquote
+func markdownQuote(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatReportTime(value interface{}) string {
+	switch t := value.(type) {
+	case time.Time:
+		return t.UTC().Format(time.RFC3339)
+	case *time.Time:
+		if t == nil {
+			return "-"
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprint(value)
+}
+
+func formatMillis(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
+
+func formatSeconds(value interface{}) string {
+	switch s := value.(type) {
+	case int:
+		return (time.Duration(s) * time.Second).String()
+	case int64:
+		return (time.Duration(s) * time.Second).String()
+	}
+	return fmt.Sprint(value)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// reportEpoch is the fixed start of the seeded report workflow
+var reportEpoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// reportSourceMetadata is the git context the seeded report workflow was
+// started for
+var reportSourceMetadata = json.RawMessage(`{"source": {"repository": "github.com/acme/orders", "branch": "main",
+	"commit_sha": "9fceb02d0ae598e95dc970b74767f19372d61af8", "ci_run_url": "https://ci.example.com/runs/42", "pr_number": 17}}`)
+
+func setupReportTest(t *testing.T) *gorm.DB {
+	db := setupWorkflowTestDB(t)
+	createIntentAnalysesTable(t, db)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT, type TEXT, "order" INTEGER,
+			status TEXT, input TEXT, output TEXT, config TEXT, error TEXT, started_at DATETIME, completed_at DATETIME,
+			duration INTEGER, retry_count INTEGER, max_retries INTEGER, timeout_seconds INTEGER, depends_on TEXT,
+			step_id TEXT, overridden_by TEXT, created_at DATETIME, updated_at DATETIME)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, project_id TEXT, workflow_id TEXT, workflow_step_id TEXT,
+			agent_id TEXT, name TEXT, type TEXT, status TEXT, language TEXT, runtime TEXT, code TEXT, script TEXT,
+			command TEXT, arguments TEXT, environment TEXT, input TEXT, output TEXT, logs TEXT, error TEXT, error_signature TEXT, error_class TEXT,
+			exit_code INTEGER, started_at DATETIME, completed_at DATETIME, duration INTEGER, timeout_seconds INTEGER,
+			retry_count INTEGER, max_retries INTEGER, retry_delay INTEGER, resource_usage TEXT, metadata TEXT,
+			tags TEXT, priority INTEGER, simulated BOOLEAN, queued_at DATETIME, scheduled_at DATETIME,
+			created_by TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT, path TEXT, url TEXT,
+			size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME, created_at DATETIME,
+			updated_at DATETIME, deleted_at DATETIME,
+			workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`,
+		`CREATE TABLE execution_logs (id TEXT PRIMARY KEY, execution_id TEXT, level TEXT, message TEXT, source TEXT,
+			line_number INTEGER, metadata TEXT, timestamp DATETIME, created_at DATETIME)`,
+		`CREATE TABLE execution_events (id TEXT PRIMARY KEY, execution_id TEXT, type TEXT, name TEXT, data TEXT,
+			timestamp DATETIME, created_at DATETIME)`,
+		`CREATE TABLE workflow_comments (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), workflow_id TEXT,
+			author TEXT, body TEXT, pinned BOOLEAN DEFAULT false, created_at DATETIME, updated_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	return db
+}
+
+// seedReportWorkflow stores a failed workflow with three successful and two
+// failed steps, each run by one execution
+func seedReportWorkflow(t *testing.T, db *gorm.DB) *models.Workflow {
+	at := func(seconds int) *time.Time {
+		ts := reportEpoch.Add(time.Duration(seconds) * time.Second)
+		return &ts
+	}
+
+	workflow := &models.Workflow{
+		Name:        "Orders service",
+		Type:        models.WorkflowTypeIntent,
+		Priority:    models.WorkflowPriorityHigh,
+		Status:      models.WorkflowStatusFailed,
+		ProjectID:   "project-1",
+		Tags:        models.StringArray{"orders", "backend"},
+		Metadata:    reportSourceMetadata,
+		Error:       "2 of 5 tasks failed",
+		CreatedBy:   "alice",
+		CreatedAt:   reportEpoch.Add(-time.Minute),
+		StartedAt:   at(0),
+		CompletedAt: at(300),
+		Duration:    300000,
+	}
+	require.NoError(t, db.Create(workflow).Error)
+
+	require.NoError(t, db.Create(&models.IntentAnalysis{
+		ID:            "intent-1",
+		WorkflowID:    workflow.ID,
+		ProjectID:     "project-1",
+		IntentType:    "code_generation",
+		Confidence:    0.92,
+		Risks:         models.StringArray{"schema migration"},
+		EstimatedTime: 600,
+		EstimatedCost: 1.5,
+		CreatedAt:     reportEpoch.Add(-time.Minute),
+	}).Error)
+
+	steps := []struct {
+		name, agent string
+		failed      bool
+		error       string
+	}{
+		{name: "design", agent: "agent-architect"},
+		{name: "api", agent: "agent-backend"},
+		{name: "database", agent: "agent-backend", failed: true, error: "migration failed: column \"total\" | already exists"},
+		{name: "frontend", agent: "agent-frontend"},
+		{name: "tests", agent: "agent-qa", failed: true, error: "activity timed out"},
+	}
+	for i, s := range steps {
+		stepStatus, executionStatus := models.WorkflowStatusCompleted, models.ExecutionStatusSucceeded
+		if s.failed {
+			stepStatus, executionStatus = models.WorkflowStatusFailed, models.ExecutionStatusFailed
+		}
+		started, completed := at(i*60), at(i*60+45)
+
+		require.NoError(t, db.Create(&models.WorkflowStep{
+			ID:          fmt.Sprintf("step-%d", i+1),
+			WorkflowID:  workflow.ID,
+			Name:        s.name,
+			Type:        "task",
+			Order:       i + 1,
+			Status:      stepStatus,
+			Error:       s.error,
+			StartedAt:   started,
+			CompletedAt: completed,
+			Duration:    45000,
+		}).Error)
+
+		usage, err := json.Marshal(models.ResourceUsage{
+			CPUUsage:       float64(20 + 10*i),
+			MemoryUsage:    int64(i+1) * 64 << 20,
+			NetworkRxBytes: 2048,
+			NetworkTxBytes: 1024,
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&models.Execution{
+			ID:             fmt.Sprintf("execution-%d", i+1),
+			ProjectID:      "project-1",
+			WorkflowID:     workflow.ID,
+			WorkflowStepID: fmt.Sprintf("step-%d", i+1),
+			AgentID:        s.agent,
+			Name:           s.name + "-task",
+			Type:           models.ExecutionTypeCustom,
+			Status:         executionStatus,
+			Error:          s.error,
+			StartedAt:      at(i*60 + 1),
+			CompletedAt:    completed,
+			Duration:       44000,
+			ResourceUsage:  usage,
+			Metadata:       json.RawMessage(`{"cost":0.25}`),
+			CreatedAt:      *started,
+		}).Error)
+		require.NoError(t, db.Create(&models.ExecutionEvent{
+			ID:          fmt.Sprintf("event-%d", i+1),
+			ExecutionID: fmt.Sprintf("execution-%d", i+1),
+			Type:        "conversation_turn",
+			Name:        "round_1",
+			Timestamp:   *started,
+		}).Error)
+	}
+
+	for i, artifact := range []models.Artifact{
+		{ID: "artifact-1", ExecutionID: "execution-1", Name: "design.md", Type: "document", Path: "designs/design.md", Size: 1536},
+		{ID: "artifact-2", ExecutionID: "execution-2", Name: "orders.go", Type: "code", URL: "https://artifacts.example.com/orders.go", Size: 4096},
+	} {
+		artifact.CreatedAt = *at(i*60 + 30)
+		require.NoError(t, db.Create(&artifact).Error)
+	}
+	require.NoError(t, db.Create(&models.ExecutionLog{
+		ID:          "log-1",
+		ExecutionID: "execution-3",
+		Level:       "error",
+		Message:     "psql: relation orders locked",
+		Timestamp:   *at(150),
+	}).Error)
+	require.NoError(t, db.Create(&models.ExecutionLog{
+		ID:          "log-2",
+		ExecutionID: "execution-3",
+		Level:       "info",
+		Message:     "running migration",
+		Timestamp:   *at(130),
+	}).Error)
+
+	for _, comment := range []models.WorkflowComment{
+		{Author: "bob", Body: "Retrying after the lock on `orders` clears.", CreatedAt: *at(320)},
+		{Author: "alice", Body: "Known flaky migration, see wf:3f2b8c1e-1a2b-4c3d-8e9f-0a1b2c3d4e5f.\n\n- rerun once\n- then page the DBA", Pinned: true, CreatedAt: *at(400)},
+	} {
+		comment.WorkflowID = workflow.ID
+		comment.UpdatedAt = comment.CreatedAt
+		require.NoError(t, db.Create(&comment).Error)
+	}
+
+	return workflow
+}
+
+func assertGolden(t *testing.T, name string, got []byte) {
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, got, 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "run go test -update to create the golden file")
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestWorkflowReportBuilder_Snapshots(t *testing.T) {
+	db := setupReportTest(t)
+	workflow := seedReportWorkflow(t, db)
+
+	builder := NewWorkflowReportBuilder(db)
+	builder.now = func() time.Time { return reportEpoch.Add(time.Hour) }
+	report, err := builder.Build(context.Background(), workflow.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, ReportTotals{
+		Steps:            5,
+		StepsFailed:      2,
+		Executions:       5,
+		ExecutionsFailed: 2,
+		ExecutionTime:    220000,
+		Cost:             1.25,
+		ArtifactBytes:    5632,
+		PeakCPUUsage:     60,
+		PeakMemoryBytes:  320 << 20,
+		NetworkRxBytes:   10240,
+		NetworkTxBytes:   5120,
+	}, report.Totals)
+	require.Len(t, report.Agents, 4)
+	assert.Equal(t, ReportAgent{ID: "agent-backend", Executions: 2, Failed: 1, Duration: 88000, Cost: 0.5}, report.Agents[1])
+	assert.Equal(t, 6, report.Errors.Total, "workflow, two steps, two executions and one error log")
+
+	for format, golden := range map[string]string{"json": "workflow_report.golden.json", "markdown": "workflow_report.golden.md"} {
+		t.Run(format, func(t *testing.T) {
+			renderer, err := ReportRendererFor(format)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			require.NoError(t, renderer.Render(&buf, report))
+			assertGolden(t, golden, buf.Bytes())
+		})
+	}
+}
+
+func TestWorkflowReportBuilder_TruncatesSections(t *testing.T) {
+	db := setupReportTest(t)
+	workflow := seedReportWorkflow(t, db)
+
+	builder := NewWorkflowReportBuilder(db)
+	builder.limits = ReportLimits{Timeline: 4, Artifacts: 1, Errors: 2, ErrorMessageLen: 10}
+	report, err := builder.Build(context.Background(), workflow.ID)
+	require.NoError(t, err)
+
+	assert.Len(t, report.Timeline.Items, 4)
+	assert.Equal(t, 10, report.Timeline.Total)
+	assert.Equal(t, 6, report.Timeline.Omitted)
+	assert.Len(t, report.Artifacts.Items, 1)
+	assert.Equal(t, 1, report.Artifacts.Omitted)
+	assert.Equal(t, 4, report.Errors.Omitted)
+	assert.Equal(t, "2 of 5 tas…", report.Errors.Items[0].Message)
+
+	var buf bytes.Buffer
+	require.NoError(t, MarkdownReportRenderer{}.Render(&buf, report))
+	assert.Contains(t, buf.String(), "_6 of 10 entries omitted._")
+	assert.Contains(t, buf.String(), "_4 of 6 errors omitted._")
+}
+
+func TestWorkflowReportBuilder_UnknownWorkflow(t *testing.T) {
+	db := setupReportTest(t)
+
+	_, err := NewWorkflowReportBuilder(db).Build(context.Background(), "00000000-0000-0000-0000-999999999999")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestReportRendererFor(t *testing.T) {
+	_, err := ReportRendererFor("pdf")
+	assert.ErrorIs(t, err, ErrUnsupportedReportFormat)
+
+	renderer, err := ReportRendererFor("markdown")
+	require.NoError(t, err)
+	assert.Equal(t, "md", renderer.FileExtension())
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrInvalidRerunPhase is returned for re-runs from a phase other than
+	// start, analysis or plan, or from a checkpoint of a workflow type that
+	// keeps none
+	ErrInvalidRerunPhase = errors.New("invalid re-run phase")
+	// ErrWorkflowNotRerunnable is returned when re-running a workflow that has
+	// not finished
+	ErrWorkflowNotRerunnable = errors.New("workflow cannot be re-run")
+	// ErrCheckpointNotFound is returned when a re-run resumes from a phase
+	// the workflow kept no checkpoint of, or whose checkpoint has expired
+	ErrCheckpointNotFound = errors.New("workflow checkpoint not found")
+	// ErrCheckpointSchemaMismatch is returned when a re-run resumes from a
+	// checkpoint written in a format the workflow no longer reads
+	ErrCheckpointSchemaMismatch = errors.New("workflow checkpoint schema version mismatch")
+)
+
+// RerunFromStart re-runs a workflow from scratch, reusing no checkpoint
+const RerunFromStart = "start"
+
+// Versions of the formats of checkpointed phase outputs. A version is bumped
+// when its format changes incompatibly; re-runs cannot resume from
+// checkpoints of another version.
+const (
+	IntentAnalysisCheckpointVersion = 1
+	ExecutionPlanCheckpointVersion  = 1
+)
+
+// CheckpointSchemaVersion is the current format version of a phase's
+// checkpoints, 0 for phases without checkpoints
+func CheckpointSchemaVersion(phase models.CheckpointPhase) int {
+	switch phase {
+	case models.CheckpointPhaseAnalysis:
+		return IntentAnalysisCheckpointVersion
+	case models.CheckpointPhasePlan:
+		return ExecutionPlanCheckpointVersion
+	}
+	return 0
+}
+
+// ValidateCheckpoint checks that a checkpoint can be resumed from
+func ValidateCheckpoint(checkpoint *models.WorkflowCheckpoint) error {
+	if want := CheckpointSchemaVersion(checkpoint.Phase); checkpoint.SchemaVersion != want {
+		return fmt.Errorf("%w: %s checkpoint of workflow %s is version %d, expected %d",
+			ErrCheckpointSchemaMismatch, checkpoint.Phase, checkpoint.WorkflowID, checkpoint.SchemaVersion, want)
+	}
+	return nil
+}
+
+// RerunWorkflow starts a new workflow with the settings and input of a
+// finished one. Intent workflows can resume from a phase: from "analysis"
+// the new workflow reuses the intent analysis, from "plan" the analysis and
+// the execution plan, of the earlier workflow; "start" or "" repeats every
+// phase.
+func (e *WorkflowEngine) RerunWorkflow(ctx context.Context, workflowID, from, userID string) (*StartWorkflowResponse, error) {
+	if e.maintenance.Active(ctx) {
+		return nil, ErrMaintenanceMode
+	}
+	var source models.Workflow
+	if err := e.db.WithContext(ctx).First(&source, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	if !source.IsTerminal() {
+		return nil, fmt.Errorf("%w: it is %s", ErrWorkflowNotRerunnable, source.Status)
+	}
+
+	var ref *models.CheckpointRef
+	switch from {
+	case "", RerunFromStart:
+	case string(models.CheckpointPhaseAnalysis), string(models.CheckpointPhasePlan):
+		if source.Type != models.WorkflowTypeIntent {
+			return nil, fmt.Errorf("%w: only intent workflows resume from checkpoints", ErrInvalidRerunPhase)
+		}
+		ref = &models.CheckpointRef{WorkflowID: source.ID, From: models.CheckpointPhase(from)}
+		if err := e.checkRerunCheckpoint(ctx, ref); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q, expected start, analysis or plan", ErrInvalidRerunPhase, from)
+	}
+
+	input, err := rerunInput(source.Input, ref)
+	if err != nil {
+		return nil, err
+	}
+	if userID == "" {
+		userID = source.CreatedBy
+	}
+	workflow, err := newWorkflowRecord(ctx, &StartWorkflowRequest{
+		Name:                source.Name,
+		Description:         source.Description,
+		Type:                string(source.Type),
+		Priority:            string(source.Priority),
+		ProjectID:           source.ProjectID,
+		UserID:              userID,
+		Input:               input,
+		Config:              source.Config,
+		Tags:                source.Tags,
+		Labels:              source.Labels,
+		MaxRetries:          source.MaxRetries,
+		TimeoutSeconds:      source.TimeoutSeconds,
+		SLASeconds:          source.SLASeconds,
+		SLAWarningThreshold: source.SLAThreshold,
+		Budget:              source.Budget,
+	}, models.WorkflowStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	workflow.ParentWorkflowID = &source.ID
+	workflow.TemplateID = source.TemplateID
+	// Re-runs from a checkpoint reuse the analysis, and so its estimates
+	if ref != nil {
+		workflow.EstimatedTime = source.EstimatedTime
+		workflow.EstimatedCost = source.EstimatedCost
+	}
+	if err := e.applySandboxPolicy(ctx, workflow); err != nil {
+		return nil, err
+	}
+	if err := e.applyRegion(ctx, workflow); err != nil {
+		return nil, err
+	}
+	if err := e.checkAgentCompatibility(string(workflow.Type), workflow.Simulated); err != nil {
+		return nil, err
+	}
+
+	if err := e.db.WithContext(ctx).Create(workflow).Error; err != nil {
+		return nil, fmt.Errorf("failed to create workflow record: %w", err)
+	}
+	e.logger.Info("Re-running workflow",
+		zap.String("workflow_id", workflow.ID),
+		zap.String("source_workflow_id", source.ID),
+		zap.String("from", from))
+	return e.launchWorkflow(ctx, workflow)
+}
+
+// checkRerunCheckpoint checks that the checkpoint a re-run resumes from
+// exists and is of the current format
+func (e *WorkflowEngine) checkRerunCheckpoint(ctx context.Context, ref *models.CheckpointRef) error {
+	var checkpoint models.WorkflowCheckpoint
+	err := e.db.WithContext(ctx).
+		Where("workflow_id = ? AND phase = ?", ref.WorkflowID, ref.From).
+		Take(&checkpoint).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: workflow %s kept no %s checkpoint", ErrCheckpointNotFound, ref.WorkflowID, ref.From)
+		}
+		return fmt.Errorf("failed to get workflow checkpoint: %w", err)
+	}
+	return ValidateCheckpoint(&checkpoint)
+}
+
+// rerunInput is the input of an earlier workflow, referencing the
+// checkpoint a re-run resumes from instead of any it referenced itself
+func rerunInput(input json.RawMessage, ref *models.CheckpointRef) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if len(input) == 0 || json.Unmarshal(input, &fields) != nil || fields == nil {
+		if ref == nil {
+			return input, nil
+		}
+		fields = map[string]json.RawMessage{}
+	}
+
+	delete(fields, "checkpoint")
+	if ref != nil {
+		encoded, err := json.Marshal(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal checkpoint reference: %w", err)
+		}
+		fields["checkpoint"] = encoded
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal workflow input: %w", err)
+	}
+	return encoded, nil
+}
+
+// EnableCheckpointRetention makes the monitor purge workflow checkpoints
+// older than maxAge
+func (m *WorkflowMonitor) EnableCheckpointRetention(maxAge time.Duration) {
+	m.checkpointRetention = maxAge
+}
+
+// purgeCheckpoints deletes the checkpoints older than the retention, at most
+// once per draftPurgeInterval
+func (m *WorkflowMonitor) purgeCheckpoints(ctx context.Context) {
+	now := m.now()
+	if m.checkpointRetention <= 0 || (!m.lastCheckpointPurge.IsZero() && now.Sub(m.lastCheckpointPurge) < draftPurgeInterval) {
+		return
+	}
+	m.lastCheckpointPurge = now
+
+	result := m.db.WithContext(ctx).
+		Where("created_at < ?", now.Add(-m.checkpointRetention)).
+		Delete(&models.WorkflowCheckpoint{})
+	if result.Error != nil {
+		m.logger.Error("Failed to purge expired workflow checkpoints", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		m.logger.Info("Purged expired workflow checkpoints",
+			zap.Int64("count", result.RowsAffected),
+			zap.Duration("retention", m.checkpointRetention))
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+func createCheckpointsTable(t *testing.T, db *gorm.DB) {
+	require.NoError(t, db.Exec(`CREATE TABLE workflow_checkpoints (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), workflow_id TEXT, phase TEXT,
+		schema_version INTEGER, data BLOB, created_at DATETIME, UNIQUE (workflow_id, phase))`).Error)
+}
+
+// setupRerunTest stores a failed intent workflow with an analysis and a plan
+// checkpoint
+func setupRerunTest(t *testing.T) (*WorkflowEngine, *gorm.DB, *mocks.Client, *models.Workflow) {
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	createCheckpointsTable(t, db)
+
+	source := &models.Workflow{
+		Name:           "deploy api",
+		Type:           models.WorkflowTypeIntent,
+		Priority:       models.WorkflowPriorityHigh,
+		ProjectID:      "project-1",
+		Status:         models.WorkflowStatusFailed,
+		Input:          json.RawMessage(`{"content": "deploy the api", "checkpoint": {"workflow_id": "older", "from": "plan"}}`),
+		Tags:           models.StringArray{"release"},
+		TimeoutSeconds: 600,
+		CreatedBy:      "alice",
+	}
+	require.NoError(t, db.Create(source).Error)
+	for _, checkpoint := range []*models.WorkflowCheckpoint{
+		{WorkflowID: source.ID, Phase: models.CheckpointPhaseAnalysis, SchemaVersion: IntentAnalysisCheckpointVersion, Data: []byte(`{}`)},
+		{WorkflowID: source.ID, Phase: models.CheckpointPhasePlan, SchemaVersion: ExecutionPlanCheckpointVersion, Data: []byte(`{}`)},
+	} {
+		require.NoError(t, db.Create(checkpoint).Error)
+	}
+	return engine, db, temporalClient, source
+}
+
+func TestWorkflowEngine_RerunWorkflow(t *testing.T) {
+	for _, from := range []string{"", RerunFromStart, "analysis", "plan"} {
+		t.Run("from "+from, func(t *testing.T) {
+			ctx := context.Background()
+			engine, db, temporalClient, source := setupRerunTest(t)
+
+			var started *models.Workflow
+			run := new(mocks.WorkflowRun)
+			run.On("GetID").Return("rerun")
+			run.On("GetRunID").Return("run-1")
+			temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, "IntentProcessingWorkflow", mock.Anything).
+				Run(func(args mock.Arguments) { started = args.Get(3).(*models.Workflow) }).
+				Return(run, nil).Once()
+
+			resp, err := engine.RerunWorkflow(ctx, source.ID, from, "bob")
+			require.NoError(t, err)
+			assert.NotEqual(t, source.ID, resp.WorkflowID)
+			assert.Equal(t, string(models.WorkflowStatusRunning), resp.Status)
+
+			var rerun models.Workflow
+			require.NoError(t, db.First(&rerun, "id = ?", resp.WorkflowID).Error)
+			assert.Equal(t, source.Name, rerun.Name)
+			assert.Equal(t, models.WorkflowPriorityHigh, rerun.Priority)
+			assert.Equal(t, "project-1", rerun.ProjectID)
+			assert.Equal(t, models.StringArray{"release"}, rerun.Tags)
+			assert.Equal(t, 600, rerun.TimeoutSeconds)
+			assert.Equal(t, "bob", rerun.CreatedBy)
+			require.NotNil(t, rerun.ParentWorkflowID)
+			assert.Equal(t, source.ID, *rerun.ParentWorkflowID)
+
+			// The checkpoint reference of the earlier run is replaced
+			var input map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(started.Input, &input))
+			assert.JSONEq(t, `"deploy the api"`, string(input["content"]))
+			if from == "" || from == RerunFromStart {
+				assert.NotContains(t, input, "checkpoint")
+			} else {
+				assert.JSONEq(t, fmt.Sprintf(`{"workflow_id": %q, "from": %q}`, source.ID, from), string(input["checkpoint"]))
+			}
+			temporalClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWorkflowEngine_RerunWorkflowRejections(t *testing.T) {
+	ctx := context.Background()
+	engine, db, temporalClient, source := setupRerunTest(t)
+
+	// A plan written before its format changed cannot be resumed from;
+	// the analysis still can
+	require.NoError(t, db.Model(&models.WorkflowCheckpoint{}).
+		Where("workflow_id = ? AND phase = ?", source.ID, models.CheckpointPhasePlan).
+		Update("schema_version", ExecutionPlanCheckpointVersion-1).Error)
+	_, err := engine.RerunWorkflow(ctx, source.ID, "plan", "bob")
+	assert.ErrorIs(t, err, ErrCheckpointSchemaMismatch)
+
+	_, err = engine.RerunWorkflow(ctx, source.ID, "execute", "bob")
+	assert.ErrorIs(t, err, ErrInvalidRerunPhase)
+
+	// Expired or never written checkpoints
+	require.NoError(t, db.Where("workflow_id = ? AND phase = ?", source.ID, models.CheckpointPhaseAnalysis).
+		Delete(&models.WorkflowCheckpoint{}).Error)
+	_, err = engine.RerunWorkflow(ctx, source.ID, "analysis", "bob")
+	assert.ErrorIs(t, err, ErrCheckpointNotFound)
+
+	// Only finished workflows are re-run, and only intent workflows from
+	// checkpoints
+	running := &models.Workflow{Name: "running", Type: models.WorkflowTypeIntent, Status: models.WorkflowStatusRunning}
+	require.NoError(t, db.Create(running).Error)
+	_, err = engine.RerunWorkflow(ctx, running.ID, "", "bob")
+	assert.ErrorIs(t, err, ErrWorkflowNotRerunnable)
+	code := &models.Workflow{Name: "code", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusFailed}
+	require.NoError(t, db.Create(code).Error)
+	_, err = engine.RerunWorkflow(ctx, code.ID, "analysis", "bob")
+	assert.ErrorIs(t, err, ErrInvalidRerunPhase)
+
+	_, err = engine.RerunWorkflow(ctx, "00000000-0000-0000-0000-000000000099", "", "bob")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Nothing was started
+	temporalClient.AssertNotCalled(t, "ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestWorkflowMonitor_PurgesExpiredCheckpoints(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	createCheckpointsTable(t, db)
+	now := time.Now()
+	for phase, createdAt := range map[models.CheckpointPhase]time.Time{
+		models.CheckpointPhaseAnalysis: now.Add(-8 * 24 * time.Hour),
+		models.CheckpointPhasePlan:     now.Add(-time.Hour),
+	} {
+		require.NoError(t, db.Create(&models.WorkflowCheckpoint{
+			WorkflowID: "wf-1", Phase: phase, SchemaVersion: 1, CreatedAt: createdAt,
+		}).Error)
+	}
+
+	monitor := NewWorkflowMonitor(db, new(mocks.Client), zap.NewNop(), nil, time.Minute)
+	monitor.now = func() time.Time { return now }
+	monitor.EnableCheckpointRetention(7 * 24 * time.Hour)
+	monitor.checkWorkflows()
+
+	var phases []models.CheckpointPhase
+	require.NoError(t, db.Model(&models.WorkflowCheckpoint{}).Pluck("phase", &phases).Error)
+	assert.Equal(t, []models.CheckpointPhase{models.CheckpointPhasePlan}, phases)
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/models"
+)
+
+// ListWorkflowRuns returns the Temporal runs of a workflow, oldest first. It
+// returns gorm.ErrRecordNotFound for unknown workflows.
+func (e *WorkflowEngine) ListWorkflowRuns(ctx context.Context, workflowID string) ([]models.WorkflowExecution, error) {
+	var count int64
+	if err := e.db.WithContext(ctx).Model(&models.Workflow{}).Where("id = ?", workflowID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to get workflow: %w", err)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("workflow not found: %w", gorm.ErrRecordNotFound)
+	}
+
+	runs := []models.WorkflowExecution{}
+	if err := e.db.WithContext(ctx).Where("workflow_id = ?", workflowID).
+		Order("started_at, created_at, id").Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workflow runs: %w", err)
+	}
+	return runs, nil
+}
+
+// CountWorkflowRuns returns the number of Temporal runs of a workflow
+func (e *WorkflowEngine) CountWorkflowRuns(ctx context.Context, workflowID string) (int64, error) {
+	var count int64
+	if err := e.db.WithContext(ctx).Model(&models.WorkflowExecution{}).
+		Where("workflow_id = ?", workflowID).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count workflow runs: %w", err)
+	}
+	return count, nil
+}
+
+// recordFirstRun records the run a workflow was just submitted with. The
+// first run of a re-run follows the latest run of the workflow it re-runs.
+// Run history is best effort; failures are logged.
+func (e *WorkflowEngine) recordFirstRun(ctx context.Context, workflow *models.Workflow) {
+	reason, previousRunID := models.RunReasonStart, ""
+	if workflow.ParentWorkflowID != nil {
+		reason = models.RunReasonRerun
+		var runIDs []string
+		if err := e.db.WithContext(ctx).Model(&models.Workflow{}).Where("id = ?", *workflow.ParentWorkflowID).
+			Pluck("temporal_run_id", &runIDs).Error; err != nil {
+			e.logger.Warn("failed to get run of re-run workflow",
+				zap.String("workflow_id", workflow.ID),
+				zap.String("source_workflow_id", *workflow.ParentWorkflowID),
+				zap.Error(err))
+		}
+		if len(runIDs) > 0 {
+			previousRunID = runIDs[0]
+		}
+	}
+
+	run := newWorkflowRun(workflow, reason, previousRunID)
+	if err := recordRun(ctx, e.db, run); err != nil {
+		e.logger.Warn("failed to record workflow run",
+			zap.String("workflow_id", workflow.ID),
+			zap.String("run_id", workflow.TemporalRunID),
+			zap.Error(err))
+	}
+}
+
+// newWorkflowRun is the run row of a workflow's current Temporal run
+func newWorkflowRun(workflow *models.Workflow, reason models.RunReason, previousRunID string) *models.WorkflowExecution {
+	startedAt := time.Now().UTC()
+	if workflow.StartedAt != nil {
+		startedAt = *workflow.StartedAt
+	}
+	return &models.WorkflowExecution{
+		WorkflowID:    workflow.ID,
+		ProjectID:     workflow.ProjectID,
+		ExecutionID:   workflow.TemporalRunID,
+		Reason:        reason,
+		PreviousRunID: previousRunID,
+		Status:        models.WorkflowStatusRunning,
+		Input:         workflow.Input,
+		StartedAt:     startedAt,
+		RetryCount:    workflow.RetryCount,
+	}
+}
+
+// recordRun stores a run row. A run that is already recorded, as when a
+// workflow is re-submitted and attaches to its running execution, is kept.
+func recordRun(ctx context.Context, db *gorm.DB, run *models.WorkflowExecution) error {
+	if run.ExecutionID == "" {
+		return nil
+	}
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "execution_id"}},
+		DoNothing: true,
+	}).Create(run).Error; err != nil {
+		return fmt.Errorf("failed to record workflow run: %w", err)
+	}
+	return nil
+}
+
+// runStatus maps the status of a closed Temporal run to the status of its
+// run row. A run that continued as new finished its part of the workflow.
+func runStatus(status enums.WorkflowExecutionStatus) models.WorkflowStatus {
+	switch status {
+	case enums.WORKFLOW_EXECUTION_STATUS_COMPLETED, enums.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW:
+		return models.WorkflowStatusCompleted
+	case enums.WORKFLOW_EXECUTION_STATUS_FAILED:
+		return models.WorkflowStatusFailed
+	case enums.WORKFLOW_EXECUTION_STATUS_CANCELED:
+		return models.WorkflowStatusCancelled
+	case enums.WORKFLOW_EXECUTION_STATUS_TERMINATED:
+		return models.WorkflowStatusTerminated
+	case enums.WORKFLOW_EXECUTION_STATUS_TIMED_OUT:
+		return models.WorkflowStatusTimedOut
+	default:
+		return models.WorkflowStatusRunning
+	}
+}
+
+// mayRetry reports whether Temporal may have started another run after the
+// described one, under the workflow's retry policy
+func mayRetry(info *workflowservice.DescribeWorkflowExecutionResponse) bool {
+	if info.WorkflowExecutionInfo == nil {
+		return false
+	}
+	status := info.WorkflowExecutionInfo.Status
+	return status == enums.WORKFLOW_EXECUTION_STATUS_FAILED || status == enums.WORKFLOW_EXECUTION_STATUS_TIMED_OUT
+}
+
+// runEnd is how a run ended
+type runEnd struct {
+	status      models.WorkflowStatus
+	output      json.RawMessage
+	err         string
+	completedAt time.Time
+}
+
+// closedRunEnd is how a described Temporal run ended
+func closedRunEnd(info *workflowpb.WorkflowExecutionInfo, now time.Time) runEnd {
+	end := runEnd{status: runStatus(info.Status), completedAt: now}
+	if info.CloseTime != nil {
+		end.completedAt = info.CloseTime.UTC()
+	}
+	switch end.status {
+	case models.WorkflowStatusFailed:
+		end.err = "Workflow execution failed"
+	case models.WorkflowStatusTimedOut:
+		end.err = "Workflow execution timed out"
+	}
+	return end
+}
+
+// finalizeRun records how a workflow's run ended, with the resource usage
+// of the executions during the run. Runs without a row, such as those of
+// workflows whose start could not be recorded, get one.
+func finalizeRun(ctx context.Context, db *gorm.DB, workflow *models.Workflow, runID string, end runEnd) error {
+	if runID == "" {
+		return nil
+	}
+
+	var run models.WorkflowExecution
+	err := db.WithContext(ctx).Where("execution_id = ?", runID).First(&run).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		run = *newWorkflowRun(workflow, models.RunReasonStart, "")
+		run.ExecutionID = runID
+		if err := recordRun(ctx, db, &run); err != nil {
+			return err
+		}
+		err = db.WithContext(ctx).Where("execution_id = ?", runID).First(&run).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get workflow run: %w", err)
+	}
+	if run.CompletedAt != nil {
+		return nil // Already finalized
+	}
+
+	usage, err := runResourceUsage(ctx, db, workflow.ID, run.StartedAt, end.completedAt)
+	if err != nil {
+		return err
+	}
+	run.Status = end.status
+	run.Error = end.err
+	if len(end.output) > 0 {
+		run.Output = end.output
+	}
+	run.CompletedAt = &end.completedAt
+	run.Duration = end.completedAt.Sub(run.StartedAt).Milliseconds()
+	run.ResourceUsage = usage
+	if err := db.WithContext(ctx).Select("status", "error", "output", "completed_at", "duration", "resource_usage", "updated_at").
+		Save(&run).Error; err != nil {
+		return fmt.Errorf("failed to update workflow run: %w", err)
+	}
+	return nil
+}
+
+// finalizeRun records how a workflow's run ended. Run history is best
+// effort; failures are logged.
+func (m *WorkflowMonitor) finalizeRun(ctx context.Context, workflow *models.Workflow, runID string, end runEnd) {
+	if err := finalizeRun(ctx, m.db, workflow, runID, end); err != nil {
+		m.logger.Warn("Failed to finalize workflow run",
+			zap.String("workflowID", workflow.ID),
+			zap.String("runID", runID),
+			zap.Error(err))
+	}
+}
+
+// followNextRun describes the latest run of a workflow whose described run
+// closed, continuing as new or failing under a retry policy. A newer run is
+// recorded with reason, after the closed one is finalized, and its run ID
+// stored. Runs that started and closed between two checks are not seen.
+func (m *WorkflowMonitor) followNextRun(ctx context.Context, workflow *models.Workflow, closed *workflowservice.DescribeWorkflowExecutionResponse, reason models.RunReason) (*workflowservice.DescribeWorkflowExecutionResponse, error) {
+	// An empty run ID describes the latest run in the chain
+	resp, err := m.temporalClient.DescribeWorkflowExecution(ctx, workflow.TemporalID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe latest run: %w", err)
+	}
+
+	executionInfo := resp.WorkflowExecutionInfo
+	if executionInfo == nil || executionInfo.Execution == nil {
+		return resp, nil
+	}
+
+	newRunID := executionInfo.Execution.RunId
+	if newRunID == "" || newRunID == workflow.TemporalRunID {
+		return resp, nil
+	}
+
+	// Updates writes the new values into workflow
+	oldRunID := workflow.TemporalRunID
+	updates := map[string]interface{}{"temporal_run_id": newRunID}
+	retryCount := workflow.RetryCount
+	if reason == models.RunReasonRetry {
+		retryCount++
+		updates["retry_count"] = retryCount
+	}
+	if err := m.db.Model(workflow).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update temporal run ID: %w", err)
+	}
+
+	m.logger.Info("Workflow started a new run",
+		zap.String("workflowID", workflow.ID),
+		zap.String("reason", string(reason)),
+		zap.String("oldRunID", oldRunID),
+		zap.String("newRunID", newRunID))
+
+	m.finalizeRun(ctx, workflow, oldRunID, closedRunEnd(closed.WorkflowExecutionInfo, m.now().UTC()))
+	workflow.TemporalRunID = newRunID
+	workflow.RetryCount = retryCount
+
+	run := newWorkflowRun(workflow, reason, oldRunID)
+	if executionInfo.StartTime != nil {
+		run.StartedAt = executionInfo.StartTime.UTC()
+	} else {
+		run.StartedAt = m.now().UTC()
+	}
+	if err := recordRun(ctx, m.db, run); err != nil {
+		m.logger.Warn("Failed to record workflow run",
+			zap.String("workflowID", workflow.ID),
+			zap.String("runID", newRunID),
+			zap.Error(err))
+	}
+
+	return resp, nil
+}
+
+// runResourceUsage sums the resource usage of the executions a workflow
+// created between from and to: peak CPU and memory, and total network and
+// disk traffic. It returns nil when none reported usage.
+func runResourceUsage(ctx context.Context, db *gorm.DB, workflowID string, from, to time.Time) (json.RawMessage, error) {
+	var executions []models.Execution
+	if err := db.WithContext(ctx).Select("id", "resource_usage").
+		Where("workflow_id = ? AND created_at >= ? AND created_at <= ?", workflowID, from, to).
+		Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get run executions: %w", err)
+	}
+
+	var total *models.ResourceUsage
+	for _, execution := range executions {
+		usage, err := execution.GetResourceUsage()
+		if err != nil || usage == nil {
+			continue
+		}
+		if total == nil {
+			total = &models.ResourceUsage{}
+		}
+		total.CPUUsage = max(total.CPUUsage, usage.CPUUsage)
+		total.MemoryUsage = max(total.MemoryUsage, usage.MemoryUsage)
+		total.NetworkRxBytes += usage.NetworkRxBytes
+		total.NetworkTxBytes += usage.NetworkTxBytes
+		total.DiskReadBytes += usage.DiskReadBytes
+		total.DiskWriteBytes += usage.DiskWriteBytes
+	}
+	if total == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal run resource usage: %w", err)
+	}
+	return data, nil
+}
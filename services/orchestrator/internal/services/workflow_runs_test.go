@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// createWorkflowRunTables creates the run and execution tables next to the
+// workflows table
+func createWorkflowRunTables(t *testing.T, db *gorm.DB) {
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_executions (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), workflow_id TEXT,
+			project_id TEXT, execution_id TEXT UNIQUE, reason TEXT, previous_run_id TEXT, status TEXT, input BLOB, output BLOB,
+			events BLOB, metrics BLOB, error TEXT, started_at DATETIME, completed_at DATETIME, duration INTEGER,
+			retry_count INTEGER, resource_usage BLOB, created_at DATETIME, updated_at DATETIME)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, resource_usage BLOB, created_at DATETIME,
+			deleted_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+}
+
+func TestWorkflowRuns_RetriesAndContinuations(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	createWorkflowRunTables(t, db)
+
+	workflow := &models.Workflow{
+		Name:      "Deploy orders",
+		Type:      models.WorkflowTypeDeployment,
+		Status:    models.WorkflowStatusPending,
+		ProjectID: "project-1",
+		Input:     json.RawMessage(`{"environment":"staging"}`),
+	}
+	require.NoError(t, db.Create(workflow).Error)
+
+	started := new(mocks.WorkflowRun)
+	started.On("GetID").Return(workflow.ID)
+	started.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(started, nil).Once()
+	require.NoError(t, engine.submitWorkflow(ctx, workflow))
+	epoch := *workflow.StartedAt
+
+	// The first run used 40% CPU and read 1 KiB
+	usage, err := json.Marshal(models.ResourceUsage{CPUUsage: 40, DiskReadBytes: 1024})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`INSERT INTO executions (id, workflow_id, resource_usage, created_at) VALUES ('execution-1', ?, ?, ?)`,
+		workflow.ID, usage, epoch.Add(10*time.Second)).Error)
+
+	at := func(seconds int) *time.Time {
+		ts := epoch.Add(time.Duration(seconds) * time.Second)
+		return &ts
+	}
+	describe := func(runID string, status enums.WorkflowExecutionStatus, start, close *time.Time) *workflowservice.DescribeWorkflowExecutionResponse {
+		resp := describeResponse(workflow.ID, runID, status)
+		resp.WorkflowExecutionInfo.StartTime = start
+		resp.WorkflowExecutionInfo.CloseTime = close
+		return resp
+	}
+	// run-1 fails and is retried as run-2, which continues as new as run-3,
+	// which completes
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, workflow.ID, "run-1").
+		Return(describe("run-1", enums.WORKFLOW_EXECUTION_STATUS_FAILED, at(0), at(60)), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, workflow.ID, "").
+		Return(describe("run-2", enums.WORKFLOW_EXECUTION_STATUS_RUNNING, at(65), nil), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, workflow.ID, "run-2").
+		Return(describe("run-2", enums.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW, at(65), at(120)), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, workflow.ID, "").
+		Return(describe("run-3", enums.WORKFLOW_EXECUTION_STATUS_RUNNING, at(120), nil), nil).Once()
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, workflow.ID, "run-3").
+		Return(describe("run-3", enums.WORKFLOW_EXECUTION_STATUS_COMPLETED, at(120), at(200)), nil).Once()
+	result := new(mocks.WorkflowRun)
+	result.On("Get", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		*args.Get(1).(*interface{}) = map[string]interface{}{"deployed": true}
+	}).Return(nil)
+	temporalClient.On("GetWorkflow", mock.Anything, workflow.ID, "run-3").Return(result)
+
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	t.Cleanup(func() { redisClient.Close() })
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+	for i := 0; i < 3; i++ {
+		monitor.checkWorkflows()
+	}
+	temporalClient.AssertExpectations(t)
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", workflow.ID).Error)
+	assert.Equal(t, models.WorkflowStatusCompleted, stored.Status)
+	assert.Equal(t, "run-3", stored.TemporalRunID)
+	assert.Equal(t, 1, stored.RetryCount)
+
+	runs, err := engine.ListWorkflowRuns(ctx, workflow.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 3)
+
+	type runSummary struct {
+		runID, previousRunID string
+		reason               models.RunReason
+		status               models.WorkflowStatus
+		retryCount           int
+		duration             int64
+	}
+	summaries := make([]runSummary, len(runs))
+	for i, run := range runs {
+		summaries[i] = runSummary{run.ExecutionID, run.PreviousRunID, run.Reason, run.Status, run.RetryCount, run.Duration}
+		assert.JSONEq(t, `{"environment":"staging"}`, string(run.Input), "every run keeps the input it started with")
+		require.NotNil(t, run.CompletedAt, run.ExecutionID)
+	}
+	assert.Equal(t, []runSummary{
+		{"run-1", "", models.RunReasonStart, models.WorkflowStatusFailed, 0, 60000},
+		{"run-2", "run-1", models.RunReasonRetry, models.WorkflowStatusCompleted, 1, 55000},
+		{"run-3", "run-2", models.RunReasonContinueAsNew, models.WorkflowStatusCompleted, 1, 80000},
+	}, summaries)
+
+	assert.Equal(t, "Workflow execution failed", runs[0].Error)
+	var runUsage models.ResourceUsage
+	require.NoError(t, json.Unmarshal(runs[0].ResourceUsage, &runUsage))
+	assert.Equal(t, models.ResourceUsage{CPUUsage: 40, DiskReadBytes: 1024}, runUsage)
+	assert.Nil(t, runs[1].ResourceUsage, "no executions ran during the retry")
+	assert.JSONEq(t, `{"deployed":true}`, string(runs[2].Output))
+
+	count, err := engine.CountWorkflowRuns(ctx, workflow.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+
+	_, err = engine.ListWorkflowRuns(ctx, "00000000-0000-0000-0000-000000000099")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestWorkflowRuns_RerunFollowsSourceRun(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newDraftTestEngine(t, new(mocks.Client))
+	createWorkflowRunTables(t, db)
+
+	source := &models.Workflow{Name: "build", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusFailed,
+		ProjectID: "project-1", TemporalRunID: "source-run"}
+	require.NoError(t, db.Create(source).Error)
+	now := time.Now().UTC()
+	rerun := &models.Workflow{Name: "build", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusRunning,
+		ProjectID: "project-1", TemporalRunID: "rerun-run", ParentWorkflowID: &source.ID, StartedAt: &now}
+	require.NoError(t, db.Create(rerun).Error)
+
+	engine.recordFirstRun(ctx, rerun)
+	// Re-submitting a workflow that attached to its running execution
+	// keeps the recorded run
+	engine.recordFirstRun(ctx, rerun)
+
+	runs, err := engine.ListWorkflowRuns(ctx, rerun.ID)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, "rerun-run", runs[0].ExecutionID)
+	assert.Equal(t, models.RunReasonRerun, runs[0].Reason)
+	assert.Equal(t, "source-run", runs[0].PreviousRunID)
+	assert.Equal(t, models.WorkflowStatusRunning, runs[0].Status)
+	assert.Nil(t, runs[0].CompletedAt)
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrSharingDisabled is returned when no share secret is configured
+	ErrSharingDisabled = errors.New("workflow sharing is not configured")
+	// ErrInvalidShareTTL is returned for share links outside the allowed validity
+	ErrInvalidShareTTL = errors.New("invalid share link validity")
+	// ErrShareTokenInvalid is returned for malformed or forged share tokens
+	ErrShareTokenInvalid = errors.New("invalid share token")
+	// ErrShareTokenExpired is returned for share tokens past their expiry
+	ErrShareTokenExpired = errors.New("share token expired")
+	// ErrShareTokenRevoked is returned for share tokens that were revoked
+	ErrShareTokenRevoked = errors.New("share token revoked")
+	// ErrShareTokenNotFound is returned when revoking a token that was not
+	// issued for the workflow, or has expired
+	ErrShareTokenNotFound = errors.New("share token not found")
+)
+
+// PublicWorkflowPath is the route prefix of shared workflow views
+const PublicWorkflowPath = "/api/v1/public/workflows/"
+
+// ShareToken is a signed, expiring grant of read-only access to a workflow
+type ShareToken struct {
+	ID         string    `json:"token_id"`
+	WorkflowID string    `json:"workflow_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Token      string    `json:"token"`
+	URL        string    `json:"url"`
+}
+
+// shareClaims is the signed part of a share token
+type shareClaims struct {
+	ID         string `json:"jti"`
+	WorkflowID string `json:"wid"`
+	ExpiresAt  int64  `json:"exp"`
+}
+
+// PublicWorkflowView is the redacted view of a shared workflow. It carries no
+// input, output, configuration or error details.
+type PublicWorkflowView struct {
+	Name           string                `json:"name"`
+	Type           models.WorkflowType   `json:"type"`
+	Status         models.WorkflowStatus `json:"status"`
+	Progress       int                   `json:"progress"` // Percent of steps completed
+	Steps          []PublicStepView      `json:"steps"`
+	CreatedAt      time.Time             `json:"created_at"`
+	StartedAt      *time.Time            `json:"started_at,omitempty"`
+	CompletedAt    *time.Time            `json:"completed_at,omitempty"`
+	ShareExpiresAt time.Time             `json:"share_expires_at"`
+}
+
+// PublicStepView is the redacted view of a workflow step
+type PublicStepView struct {
+	Name        string                `json:"name"`
+	Status      models.WorkflowStatus `json:"status"`
+	StartedAt   *time.Time            `json:"started_at,omitempty"`
+	CompletedAt *time.Time            `json:"completed_at,omitempty"`
+}
+
+// ShareTokenStore records the workflow of issued share tokens and revoked
+// token IDs, each until the token expires
+type ShareTokenStore interface {
+	Issue(ctx context.Context, tokenID, workflowID string, ttl time.Duration) error
+	// Issued returns the workflow of an unexpired token and its remaining
+	// validity, or an empty workflow ID for unknown tokens
+	Issued(ctx context.Context, tokenID string) (workflowID string, remaining time.Duration, err error)
+	Revoke(ctx context.Context, tokenID string, ttl time.Duration) error
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// redisShareTokenStore keeps issued and revoked token IDs in Redis until the
+// tokens would have expired anyway
+type redisShareTokenStore struct {
+	client *redis.Client
+}
+
+// NewRedisShareTokenStore stores share tokens and their revocations in Redis
+func NewRedisShareTokenStore(client *redis.Client) ShareTokenStore {
+	return &redisShareTokenStore{client: client}
+}
+
+func (r *redisShareTokenStore) Issue(ctx context.Context, tokenID, workflowID string, ttl time.Duration) error {
+	return r.client.Set(ctx, shareTokenKey(tokenID), workflowID, ttl).Err()
+}
+
+func (r *redisShareTokenStore) Issued(ctx context.Context, tokenID string) (string, time.Duration, error) {
+	pipe := r.client.Pipeline()
+	get := pipe.Get(ctx, shareTokenKey(tokenID))
+	ttl := pipe.PTTL(ctx, shareTokenKey(tokenID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return "", 0, err
+	}
+	workflowID, err := get.Result()
+	if errors.Is(err, redis.Nil) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return workflowID, ttl.Val(), nil
+}
+
+func (r *redisShareTokenStore) Revoke(ctx context.Context, tokenID string, ttl time.Duration) error {
+	return r.client.Set(ctx, shareRevocationKey(tokenID), "1", ttl).Err()
+}
+
+func (r *redisShareTokenStore) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	n, err := r.client.Exists(ctx, shareRevocationKey(tokenID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func shareTokenKey(tokenID string) string {
+	return fmt.Sprintf("share:token:%s", tokenID)
+}
+
+func shareRevocationKey(tokenID string) string {
+	return fmt.Sprintf("share:revoked:%s", tokenID)
+}
+
+// WorkflowShareService issues and validates public share links of workflows
+type WorkflowShareService struct {
+	db         *gorm.DB
+	tokens     ShareTokenStore
+	logger     *zap.Logger
+	secret     []byte
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+	baseURL    string
+	now        func() time.Time
+}
+
+// NewWorkflowShareService creates a share service. Tokens are signed with the
+// configured secret; without one every operation returns ErrSharingDisabled.
+func NewWorkflowShareService(db *gorm.DB, tokens ShareTokenStore, cfg *config.ShareConfig, logger *zap.Logger) *WorkflowShareService {
+	return &WorkflowShareService{
+		db:         db,
+		tokens:     tokens,
+		logger:     logger,
+		secret:     []byte(cfg.Secret),
+		defaultTTL: time.Duration(cfg.DefaultTTL) * time.Second,
+		maxTTL:     time.Duration(cfg.MaxTTL) * time.Second,
+		baseURL:    strings.TrimSuffix(cfg.PublicBaseURL, "/"),
+		now:        time.Now,
+	}
+}
+
+// CreateShareToken issues a share link for a workflow, valid for ttl or the
+// default validity when ttl is zero
+func (s *WorkflowShareService) CreateShareToken(ctx context.Context, workflowID string, ttl time.Duration) (*ShareToken, error) {
+	if len(s.secret) == 0 {
+		return nil, ErrSharingDisabled
+	}
+	if ttl == 0 {
+		ttl = s.defaultTTL
+	}
+	if ttl <= 0 || (s.maxTTL > 0 && ttl > s.maxTTL) {
+		return nil, fmt.Errorf("%w: must be between 1s and %s", ErrInvalidShareTTL, s.maxTTL)
+	}
+
+	var workflow models.Workflow
+	if err := s.db.WithContext(ctx).Select("id").First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate share token ID: %w", err)
+	}
+	claims := shareClaims{
+		ID:         hex.EncodeToString(id),
+		WorkflowID: workflow.ID,
+		ExpiresAt:  s.now().Add(ttl).Unix(),
+	}
+	token, err := s.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.tokens.Issue(ctx, claims.ID, claims.WorkflowID, ttl); err != nil {
+		return nil, fmt.Errorf("failed to record share token: %w", err)
+	}
+
+	return &ShareToken{
+		ID:         claims.ID,
+		WorkflowID: claims.WorkflowID,
+		ExpiresAt:  time.Unix(claims.ExpiresAt, 0).UTC(),
+		Token:      token,
+		URL:        s.baseURL + PublicWorkflowPath + token,
+	}, nil
+}
+
+// ValidateShareToken checks a token's signature, expiry and revocation
+func (s *WorkflowShareService) ValidateShareToken(ctx context.Context, token string) (*ShareToken, error) {
+	if len(s.secret) == 0 {
+		return nil, ErrSharingDisabled
+	}
+
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrShareTokenInvalid
+	}
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, s.mac(payload)) {
+		return nil, ErrShareTokenInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrShareTokenInvalid
+	}
+	var claims shareClaims
+	if err := json.Unmarshal(data, &claims); err != nil || claims.ID == "" || claims.WorkflowID == "" {
+		return nil, ErrShareTokenInvalid
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0).UTC()
+	if !s.now().Before(expiresAt) {
+		return nil, ErrShareTokenExpired
+	}
+
+	revoked, err := s.tokens.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check share token revocation: %w", err)
+	}
+	if revoked {
+		return nil, ErrShareTokenRevoked
+	}
+
+	return &ShareToken{
+		ID:         claims.ID,
+		WorkflowID: claims.WorkflowID,
+		ExpiresAt:  expiresAt,
+		Token:      token,
+		URL:        s.baseURL + PublicWorkflowPath + token,
+	}, nil
+}
+
+// RevokeShareToken revokes a share token of a workflow by ID. The revocation
+// is kept for the rest of the token's validity.
+func (s *WorkflowShareService) RevokeShareToken(ctx context.Context, workflowID, tokenID string) error {
+	if len(s.secret) == 0 {
+		return ErrSharingDisabled
+	}
+	issuedFor, remaining, err := s.tokens.Issued(ctx, tokenID)
+	if err != nil {
+		return fmt.Errorf("failed to look up share token: %w", err)
+	}
+	if issuedFor == "" || issuedFor != workflowID || remaining <= 0 {
+		return ErrShareTokenNotFound
+	}
+	if err := s.tokens.Revoke(ctx, tokenID, remaining); err != nil {
+		return fmt.Errorf("failed to revoke share token: %w", err)
+	}
+	s.logger.Info("share token revoked",
+		zap.String("workflow_id", workflowID),
+		zap.String("token_id", tokenID))
+	return nil
+}
+
+// GetPublicWorkflowView returns the redacted view of the workflow a share
+// token grants access to
+func (s *WorkflowShareService) GetPublicWorkflowView(ctx context.Context, token string) (*PublicWorkflowView, error) {
+	share, err := s.ValidateShareToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the columns of the view are read, so sensitive columns never
+	// leave the database
+	var workflow models.Workflow
+	if err := s.db.WithContext(ctx).
+		Select("id", "name", "type", "status", "created_at", "started_at", "completed_at").
+		Preload("Steps", func(tx *gorm.DB) *gorm.DB {
+			return tx.Select("id", "workflow_id", "name", "status", "started_at", "completed_at", "order").
+				Order(clause.OrderByColumn{Column: clause.Column{Name: "order"}})
+		}).
+		First(&workflow, "id = ?", share.WorkflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+
+	view := &PublicWorkflowView{
+		Name:           workflow.Name,
+		Type:           workflow.Type,
+		Status:         workflow.Status,
+		Steps:          make([]PublicStepView, 0, len(workflow.Steps)),
+		CreatedAt:      workflow.CreatedAt,
+		StartedAt:      workflow.StartedAt,
+		CompletedAt:    workflow.CompletedAt,
+		ShareExpiresAt: share.ExpiresAt,
+	}
+	completed := 0
+	for _, step := range workflow.Steps {
+		view.Steps = append(view.Steps, PublicStepView{
+			Name:        step.Name,
+			Status:      step.Status,
+			StartedAt:   step.StartedAt,
+			CompletedAt: step.CompletedAt,
+		})
+		if step.Status == models.WorkflowStatusCompleted {
+			completed++
+		}
+	}
+	switch {
+	case workflow.Status == models.WorkflowStatusCompleted:
+		view.Progress = 100
+	case len(workflow.Steps) > 0:
+		view.Progress = completed * 100 / len(workflow.Steps)
+	}
+
+	return view, nil
+}
+
+func (s *WorkflowShareService) sign(claims shareClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal share token: %w", err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload)), nil
+}
+
+func (s *WorkflowShareService) mac(payload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
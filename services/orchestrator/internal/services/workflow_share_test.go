@@ -0,0 +1,234 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+)
+
+// memoryShareTokens is an in-memory ShareTokenStore. Issued tokens keep
+// their full validity as the remaining one.
+type memoryShareTokens struct {
+	mu      sync.Mutex
+	issued  map[string]memoryShareToken
+	revoked map[string]time.Duration
+	err     error
+}
+
+type memoryShareToken struct {
+	workflowID string
+	ttl        time.Duration
+}
+
+func (m *memoryShareTokens) Issue(ctx context.Context, tokenID, workflowID string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.issued[tokenID] = memoryShareToken{workflowID: workflowID, ttl: ttl}
+	return nil
+}
+
+func (m *memoryShareTokens) Issued(ctx context.Context, tokenID string) (string, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := m.issued[tokenID]
+	return token.workflowID, token.ttl, nil
+}
+
+func (m *memoryShareTokens) Revoke(ctx context.Context, tokenID string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[tokenID] = ttl
+	return nil
+}
+
+func (m *memoryShareTokens) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return false, m.err
+	}
+	_, ok := m.revoked[tokenID]
+	return ok, nil
+}
+
+func setupShareTest(t *testing.T) (*gorm.DB, *WorkflowShareService, *memoryShareTokens) {
+	db := setupWorkflowTestDB(t)
+	require.NoError(t, db.Exec(`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT,
+		type TEXT, "order" INTEGER, status TEXT, input TEXT, output TEXT, config TEXT, error TEXT,
+		started_at DATETIME, completed_at DATETIME)`).Error)
+
+	tokens := &memoryShareTokens{issued: make(map[string]memoryShareToken), revoked: make(map[string]time.Duration)}
+	shares := NewWorkflowShareService(db, tokens, &config.ShareConfig{
+		Secret:        strings.Repeat("s", 32),
+		DefaultTTL:    3600,
+		MaxTTL:        86400,
+		PublicBaseURL: "https://status.example.com/",
+	}, zap.NewNop())
+	return db, shares, tokens
+}
+
+func TestWorkflowShareService_TokenLifecycle(t *testing.T) {
+	db, shares, tokens := setupShareTest(t)
+	ctx := context.Background()
+
+	workflow := &models.Workflow{Name: "shared", Type: models.WorkflowTypeCustom, ProjectID: "project-1"}
+	require.NoError(t, db.Create(workflow).Error)
+
+	share, err := shares.CreateShareToken(ctx, workflow.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, workflow.ID, share.WorkflowID)
+	assert.Equal(t, "https://status.example.com"+PublicWorkflowPath+share.Token, share.URL)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), share.ExpiresAt, 5*time.Second)
+
+	validated, err := shares.ValidateShareToken(ctx, share.Token)
+	require.NoError(t, err)
+	assert.Equal(t, share.ID, validated.ID)
+	assert.Equal(t, workflow.ID, validated.WorkflowID)
+
+	t.Run("tampered tokens are rejected", func(t *testing.T) {
+		payload, signature, _ := strings.Cut(share.Token, ".")
+		for _, token := range []string{
+			"",
+			"not-a-token",
+			payload + ".",
+			payload + "." + strings.Repeat("A", len(signature)),
+			strings.ToUpper(payload[:1]) + payload[1:] + "x." + signature,
+		} {
+			_, err := shares.ValidateShareToken(ctx, token)
+			assert.ErrorIs(t, err, ErrShareTokenInvalid, token)
+		}
+
+		// A token signed with another secret
+		other := NewWorkflowShareService(db, tokens, &config.ShareConfig{Secret: strings.Repeat("o", 32), DefaultTTL: 60}, zap.NewNop())
+		forged, err := other.CreateShareToken(ctx, workflow.ID, 0)
+		require.NoError(t, err)
+		_, err = shares.ValidateShareToken(ctx, forged.Token)
+		assert.ErrorIs(t, err, ErrShareTokenInvalid)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		shares.now = func() time.Time { return share.ExpiresAt.Add(-time.Second) }
+		_, err := shares.ValidateShareToken(ctx, share.Token)
+		assert.NoError(t, err)
+
+		shares.now = func() time.Time { return share.ExpiresAt }
+		_, err = shares.ValidateShareToken(ctx, share.Token)
+		assert.ErrorIs(t, err, ErrShareTokenExpired)
+		shares.now = time.Now
+	})
+
+	t.Run("validity bounds", func(t *testing.T) {
+		_, err := shares.CreateShareToken(ctx, workflow.ID, 48*time.Hour)
+		assert.ErrorIs(t, err, ErrInvalidShareTTL)
+		_, err = shares.CreateShareToken(ctx, workflow.ID, -time.Second)
+		assert.ErrorIs(t, err, ErrInvalidShareTTL)
+		_, err = shares.CreateShareToken(ctx, "missing", 0)
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("revocation", func(t *testing.T) {
+		other, err := shares.CreateShareToken(ctx, workflow.ID, time.Minute)
+		require.NoError(t, err)
+
+		// Tokens are only revoked through the workflow they were issued for
+		assert.ErrorIs(t, shares.RevokeShareToken(ctx, "other-workflow", share.ID), ErrShareTokenNotFound)
+		assert.ErrorIs(t, shares.RevokeShareToken(ctx, workflow.ID, "unknown"), ErrShareTokenNotFound)
+		assert.Empty(t, tokens.revoked)
+
+		// The revocation is kept for the rest of the token's validity
+		require.NoError(t, shares.RevokeShareToken(ctx, workflow.ID, share.ID))
+		assert.Equal(t, time.Hour, tokens.revoked[share.ID])
+
+		_, err = shares.ValidateShareToken(ctx, share.Token)
+		assert.ErrorIs(t, err, ErrShareTokenRevoked)
+		_, err = shares.ValidateShareToken(ctx, other.Token)
+		assert.NoError(t, err, "only the revoked token is affected")
+
+		// Revocations that cannot be checked fail closed
+		tokens.err = errors.New("redis down")
+		_, err = shares.ValidateShareToken(ctx, other.Token)
+		assert.Error(t, err)
+		tokens.err = nil
+	})
+
+	t.Run("disabled without a secret", func(t *testing.T) {
+		disabled := NewWorkflowShareService(db, tokens, &config.ShareConfig{}, zap.NewNop())
+		_, err := disabled.CreateShareToken(ctx, workflow.ID, 0)
+		assert.ErrorIs(t, err, ErrSharingDisabled)
+		_, err = disabled.ValidateShareToken(ctx, share.Token)
+		assert.ErrorIs(t, err, ErrSharingDisabled)
+	})
+}
+
+func TestWorkflowShareService_PublicViewIsRedacted(t *testing.T) {
+	db, shares, _ := setupShareTest(t)
+	ctx := context.Background()
+
+	started := time.Now().Add(-time.Minute)
+	workflow := &models.Workflow{
+		Name:      "deploy service",
+		Type:      models.WorkflowTypeDeployment,
+		Status:    models.WorkflowStatusRunning,
+		ProjectID: "project-1",
+		Input:     json.RawMessage(`{"api_key": "secret-input"}`),
+		Output:    json.RawMessage(`{"token": "secret-output"}`),
+		Config:    json.RawMessage(`{"password": "secret-config"}`),
+		Error:     "secret-error",
+		StartedAt: &started,
+	}
+	require.NoError(t, db.Create(workflow).Error)
+	for i, step := range []struct {
+		name   string
+		status models.WorkflowStatus
+	}{{"deploy", models.WorkflowStatusPending}, {"build", models.WorkflowStatusCompleted}, {"test", models.WorkflowStatusRunning}} {
+		require.NoError(t, db.Exec(`INSERT INTO workflow_steps (id, workflow_id, name, type, "order", status, input, output, error)
+			VALUES (?, ?, ?, 'task', ?, ?, '{"step_secret": "secret-step-input"}', '{"x": "secret-step-output"}', 'secret-step-error')`,
+			step.name, workflow.ID, step.name, []int{3, 1, 2}[i], step.status).Error)
+	}
+
+	share, err := shares.CreateShareToken(ctx, workflow.ID, 0)
+	require.NoError(t, err)
+
+	view, err := shares.GetPublicWorkflowView(ctx, share.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "deploy service", view.Name)
+	assert.Equal(t, models.WorkflowTypeDeployment, view.Type)
+	assert.Equal(t, models.WorkflowStatusRunning, view.Status)
+	assert.Equal(t, 33, view.Progress)
+	assert.NotNil(t, view.StartedAt)
+	assert.Equal(t, share.ExpiresAt, view.ShareExpiresAt)
+
+	// Steps are in execution order
+	require.Len(t, view.Steps, 3)
+	assert.Equal(t, PublicStepView{Name: "build", Status: models.WorkflowStatusCompleted}, view.Steps[0])
+	assert.Equal(t, "test", view.Steps[1].Name)
+	assert.Equal(t, "deploy", view.Steps[2].Name)
+
+	data, err := json.Marshal(view)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	for _, key := range []string{"id", "project_id", "input", "output", "config", "error", "metadata", "labels", "created_by"} {
+		assert.NotContains(t, fields, key)
+	}
+
+	// Completed workflows report full progress
+	require.NoError(t, db.Model(&models.Workflow{}).Where("id = ?", workflow.ID).
+		Update("status", models.WorkflowStatusCompleted).Error)
+	view, err = shares.GetPublicWorkflowView(ctx, share.Token)
+	require.NoError(t, err)
+	assert.Equal(t, 100, view.Progress)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidSLA is returned when a workflow's SLA settings fail validation
+var ErrInvalidSLA = errors.New("invalid workflow SLA")
+
+// defaultSLAWarningThreshold is the fraction of the SLA after which a
+// workflow is at risk when the request does not set one
+const defaultSLAWarningThreshold = 0.8
+
+// SLA events published on the workflow event channel
+const (
+	SLAEventWarning  = "sla_warning"
+	SLAEventBreached = "sla_breached"
+)
+
+// Breach rate per project is breaches over tracked workflows
+var (
+	slaTrackedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_workflow_sla_tracked_total",
+		Help: "Workflows started with an SLA",
+	}, []string{"project_id"})
+	slaWarningsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_workflow_sla_warnings_total",
+		Help: "Workflows that crossed their SLA warning threshold",
+	}, []string{"project_id"})
+	slaBreachesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_workflow_sla_breaches_total",
+		Help: "Workflows that ran past their SLA",
+	}, []string{"project_id"})
+)
+
+// normalizeSLA validates the SLA settings of a start request and returns the
+// warning threshold to store
+func normalizeSLA(seconds int, threshold float64) (float64, error) {
+	if seconds < 0 {
+		return 0, fmt.Errorf("%w: sla_seconds must not be negative", ErrInvalidSLA)
+	}
+	if threshold < 0 || threshold >= 1 {
+		return 0, fmt.Errorf("%w: sla_warning_threshold must be between 0 and 1", ErrInvalidSLA)
+	}
+	if seconds == 0 {
+		if threshold != 0 {
+			return 0, fmt.Errorf("%w: sla_warning_threshold requires sla_seconds", ErrInvalidSLA)
+		}
+		return 0, nil
+	}
+	if threshold == 0 {
+		threshold = defaultSLAWarningThreshold
+	}
+	return threshold, nil
+}
+
+// IsValidSLAStatus reports whether status is a known SLA status
+func IsValidSLAStatus(status string) bool {
+	switch models.SLAStatus(status) {
+	case models.SLAStatusOnTrack, models.SLAStatusAtRisk, models.SLAStatusBreached:
+		return true
+	}
+	return false
+}
+
+// slaRank orders SLA statuses; a workflow's status only ever moves forward
+func slaRank(status models.SLAStatus) int {
+	switch status {
+	case models.SLAStatusAtRisk:
+		return 1
+	case models.SLAStatusBreached:
+		return 2
+	}
+	return 0
+}
+
+// slaStatusAfter returns the SLA status of a workflow that has run for elapsed
+func slaStatusAfter(workflow *models.Workflow, elapsed time.Duration) models.SLAStatus {
+	sla := time.Duration(workflow.SLASeconds) * time.Second
+	switch {
+	case elapsed > sla:
+		return models.SLAStatusBreached
+	case elapsed >= time.Duration(float64(sla)*workflow.SLAThreshold):
+		return models.SLAStatusAtRisk
+	}
+	return models.SLAStatusOnTrack
+}
+
+// EnableSLATracking makes the monitor check running workflows against their
+// SLA and publish warning and breach events through the engine. Workflows are
+// never stopped for missing their SLA.
+func (m *WorkflowMonitor) EnableSLATracking(engine *WorkflowEngine) {
+	m.slaEvents = engine.emitWorkflowEvent
+}
+
+// checkSLA moves a workflow's SLA status forward based on the time it has
+// been running, or ran for once completed
+func (m *WorkflowMonitor) checkSLA(ctx context.Context, workflow *models.Workflow) {
+	if m.slaEvents == nil || workflow.SLASeconds <= 0 {
+		return
+	}
+
+	start := workflow.CreatedAt
+	if workflow.StartedAt != nil {
+		start = *workflow.StartedAt
+	}
+	end := m.now()
+	if workflow.CompletedAt != nil {
+		end = *workflow.CompletedAt
+	}
+	elapsed := end.Sub(start)
+
+	status := slaStatusAfter(workflow, elapsed)
+	if slaRank(status) <= slaRank(workflow.SLAStatus) {
+		return
+	}
+
+	// Conditional on the loaded status so each transition is reported once,
+	// even with several monitors running
+	result := m.db.Model(&models.Workflow{}).
+		Where("id = ? AND sla_status = ?", workflow.ID, workflow.SLAStatus).
+		Update("sla_status", status)
+	if result.Error != nil {
+		m.logger.Error("Failed to update workflow SLA status",
+			zap.String("workflowID", workflow.ID),
+			zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	workflow.SLAStatus = status
+	m.clearWorkflowCache(ctx, workflow.ID)
+
+	data := map[string]interface{}{
+		"sla_seconds":           workflow.SLASeconds,
+		"sla_warning_threshold": workflow.SLAThreshold,
+		"elapsed_seconds":       int64(elapsed / time.Second),
+	}
+	if status == models.SLAStatusBreached {
+		slaBreachesTotal.WithLabelValues(workflow.ProjectID).Inc()
+		m.slaEvents(ctx, workflow, SLAEventBreached, data)
+	} else {
+		slaWarningsTotal.WithLabelValues(workflow.ProjectID).Inc()
+		m.slaEvents(ctx, workflow, SLAEventWarning, data)
+	}
+
+	m.logger.Warn("Workflow SLA status changed",
+		zap.String("workflowID", workflow.ID),
+		zap.String("slaStatus", string(status)),
+		zap.Duration("elapsed", elapsed))
+}
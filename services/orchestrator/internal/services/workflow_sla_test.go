@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+type slaEvent struct {
+	workflowID string
+	eventType  string
+}
+
+func TestWorkflowMonitor_TracksSLA(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	epoch := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	newWorkflow := func(name string, slaSeconds int) *models.Workflow {
+		workflow := &models.Workflow{
+			Name:         name,
+			Type:         models.WorkflowTypeCustom,
+			Status:       models.WorkflowStatusRunning,
+			ProjectID:    "project-sla",
+			SLASeconds:   slaSeconds,
+			SLAThreshold: 0.8,
+			CreatedAt:    epoch,
+			StartedAt:    &epoch,
+		}
+		if slaSeconds > 0 {
+			workflow.SLAStatus = models.SLAStatusOnTrack
+		}
+		require.NoError(t, db.Create(workflow).Error)
+		return workflow
+	}
+	tracked := newWorkflow("tracked", 100)
+	untracked := newWorkflow("untracked", 0)
+
+	engine := NewWorkflowEngine(db, redisClient, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	monitor := NewWorkflowMonitor(db, nil, zap.NewNop(), redisClient, time.Minute)
+	monitor.EnableSLATracking(engine)
+
+	var events []slaEvent
+	monitor.slaEvents = func(ctx context.Context, workflow *models.Workflow, eventType string, data map[string]interface{}) {
+		events = append(events, slaEvent{workflow.ID, eventType})
+	}
+	now := epoch
+	monitor.now = func() time.Time { return now }
+
+	warnings := testutil.ToFloat64(slaWarningsTotal.WithLabelValues("project-sla"))
+	breaches := testutil.ToFloat64(slaBreachesTotal.WithLabelValues("project-sla"))
+
+	steps := []struct {
+		elapsed time.Duration
+		status  models.SLAStatus
+		events  []slaEvent
+	}{
+		{10 * time.Second, models.SLAStatusOnTrack, nil},
+		{79 * time.Second, models.SLAStatusOnTrack, nil},
+		{80 * time.Second, models.SLAStatusAtRisk, []slaEvent{{tracked.ID, SLAEventWarning}}},
+		{95 * time.Second, models.SLAStatusAtRisk, []slaEvent{{tracked.ID, SLAEventWarning}}},
+		{101 * time.Second, models.SLAStatusBreached, []slaEvent{{tracked.ID, SLAEventWarning}, {tracked.ID, SLAEventBreached}}},
+		{time.Hour, models.SLAStatusBreached, []slaEvent{{tracked.ID, SLAEventWarning}, {tracked.ID, SLAEventBreached}}},
+	}
+	for _, step := range steps {
+		now = epoch.Add(step.elapsed)
+		monitor.checkWorkflows()
+
+		var stored models.Workflow
+		require.NoError(t, db.First(&stored, "id = ?", tracked.ID).Error)
+		assert.Equal(t, step.status, stored.SLAStatus, "after %s", step.elapsed)
+		assert.Equal(t, models.WorkflowStatusRunning, stored.Status, "missing the SLA does not stop the workflow")
+		assert.Equal(t, step.events, events, "after %s", step.elapsed)
+	}
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", untracked.ID).Error)
+	assert.Empty(t, stored.SLAStatus)
+
+	assert.Equal(t, warnings+1, testutil.ToFloat64(slaWarningsTotal.WithLabelValues("project-sla")))
+	assert.Equal(t, breaches+1, testutil.ToFloat64(slaBreachesTotal.WithLabelValues("project-sla")))
+
+	// A workflow found past its SLA on the first check skips the warning
+	late := newWorkflow("late", 30)
+	events = nil
+	monitor.checkWorkflows()
+	assert.Equal(t, []slaEvent{{late.ID, SLAEventBreached}}, events)
+
+	// Filtering by SLA status
+	summaries, total, err := engine.ListWorkflowSummaries(context.Background(), &WorkflowFilters{SLAStatus: string(models.SLAStatusBreached)})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	for _, summary := range summaries {
+		assert.Equal(t, models.SLAStatusBreached, summary.SLAStatus)
+	}
+}
+
+func TestNormalizeSLA(t *testing.T) {
+	tests := []struct {
+		name      string
+		seconds   int
+		threshold float64
+		expected  float64
+		wantErr   bool
+	}{
+		{"no SLA", 0, 0, 0, false},
+		{"default threshold", 600, 0, defaultSLAWarningThreshold, false},
+		{"custom threshold", 600, 0.5, 0.5, false},
+		{"negative SLA", -1, 0, 0, true},
+		{"threshold of one", 600, 1, 0, true},
+		{"threshold without SLA", 0, 0.5, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			threshold, err := normalizeSLA(tt.seconds, tt.threshold)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSLA)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, threshold)
+		})
+	}
+}
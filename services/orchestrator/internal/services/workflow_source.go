@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// ErrInvalidWorkflowSource is returned for a source block that does not
+// describe a git commit
+var ErrInvalidWorkflowSource = errors.New("invalid workflow source")
+
+// commitSHAPattern matches abbreviated and full SHA-1 and SHA-256 commit IDs
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+
+// NormalizeWorkflowSource validates the git context a workflow is started
+// for and returns it as it is stored: trimmed, with a lower-case commit SHA.
+// An empty source is no source.
+func NormalizeWorkflowSource(source *models.WorkflowSource) (*models.WorkflowSource, error) {
+	if source == nil {
+		return nil, nil
+	}
+	normalized := models.WorkflowSource{
+		Repository: strings.TrimSpace(source.Repository),
+		Branch:     strings.TrimSpace(source.Branch),
+		CommitSHA:  NormalizeCommitSHA(source.CommitSHA),
+		CIRunURL:   strings.TrimSpace(source.CIRunURL),
+		PRNumber:   source.PRNumber,
+	}
+	if normalized == (models.WorkflowSource{}) {
+		return nil, nil
+	}
+
+	if normalized.Repository == "" {
+		return nil, fmt.Errorf("%w: repository is required", ErrInvalidWorkflowSource)
+	}
+	if len(normalized.Repository) > 512 || strings.ContainsAny(normalized.Repository, " \t\r\n") {
+		return nil, fmt.Errorf("%w: repository %q is not a repository URL or name", ErrInvalidWorkflowSource, normalized.Repository)
+	}
+	if len(normalized.Branch) > 255 || strings.ContainsAny(normalized.Branch, " \t\r\n~^:?*[\\") ||
+		strings.HasPrefix(normalized.Branch, "-") || strings.Contains(normalized.Branch, "..") {
+		return nil, fmt.Errorf("%w: branch %q is not a branch name", ErrInvalidWorkflowSource, normalized.Branch)
+	}
+	if normalized.CommitSHA != "" && !commitSHAPattern.MatchString(normalized.CommitSHA) {
+		return nil, fmt.Errorf("%w: commit_sha %q is not 7 to 64 hexadecimal characters", ErrInvalidWorkflowSource, source.CommitSHA)
+	}
+	if normalized.CIRunURL != "" {
+		runURL, err := url.Parse(normalized.CIRunURL)
+		if err != nil || (runURL.Scheme != "http" && runURL.Scheme != "https") || runURL.Host == "" {
+			return nil, fmt.Errorf("%w: ci_run_url %q is not an http(s) URL", ErrInvalidWorkflowSource, normalized.CIRunURL)
+		}
+	}
+	if normalized.PRNumber < 0 {
+		return nil, fmt.Errorf("%w: pr_number must not be negative", ErrInvalidWorkflowSource)
+	}
+	return &normalized, nil
+}
+
+// NormalizeCommitSHA returns a commit SHA as it is stored and filtered on
+func NormalizeCommitSHA(sha string) string {
+	return strings.ToLower(strings.TrimSpace(sha))
+}
+
+// applySourceFilters keeps the workflows started for a commit or repository.
+// Postgres matches by jsonb containment, backed by the GIN index on
+// metadata; other databases match the stored JSON text.
+func applySourceFilters(query *gorm.DB, commit, repository string) *gorm.DB {
+	filter := make(map[string]string)
+	if repository = strings.TrimSpace(repository); repository != "" {
+		filter["repository"] = repository
+	}
+	if commit = NormalizeCommitSHA(commit); commit != "" {
+		filter["commit_sha"] = commit
+	}
+	if len(filter) == 0 {
+		return query
+	}
+
+	if query.Dialector.Name() == "postgres" {
+		data, _ := json.Marshal(map[string]map[string]string{models.WorkflowSourceMetadataKey: filter})
+		return query.Where("metadata @> ?::jsonb", string(data))
+	}
+
+	// Iterate in a stable order so generated SQL is deterministic
+	for _, key := range []string{"repository", "commit_sha"} {
+		if value, ok := filter[key]; ok {
+			v, _ := json.Marshal(value)
+			query = query.Where(`metadata LIKE ? ESCAPE '!'`, "%"+escapeLike(`"`+key+`":`+string(v))+"%")
+		}
+	}
+	return query
+}
+
+// workflowSourceColumn selects the source in a workflow's metadata for
+// models.WorkflowSummary without reading the rest of the metadata
+func workflowSourceColumn(db *gorm.DB) string {
+	if db.Dialector.Name() == "postgres" {
+		return "jsonb_build_object('source', metadata->'source') AS source_metadata"
+	}
+	return "json_object('source', json(json_extract(metadata, '$.source'))) AS source_metadata"
+}
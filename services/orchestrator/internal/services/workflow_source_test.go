@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+
+	"orchestrator/internal/models"
+)
+
+func TestNormalizeWorkflowSource(t *testing.T) {
+	source, err := NormalizeWorkflowSource(&models.WorkflowSource{
+		Repository: " github.com/acme/orders ", Branch: "feature/checkout", CommitSHA: "9FCEB02D",
+		CIRunURL: "https://ci.example.com/runs/42", PRNumber: 17,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &models.WorkflowSource{
+		Repository: "github.com/acme/orders", Branch: "feature/checkout", CommitSHA: "9fceb02d",
+		CIRunURL: "https://ci.example.com/runs/42", PRNumber: 17,
+	}, source)
+
+	source, err = NormalizeWorkflowSource(&models.WorkflowSource{Repository: " "})
+	require.NoError(t, err)
+	assert.Nil(t, source, "an empty source is no source")
+
+	for name, invalid := range map[string]models.WorkflowSource{
+		"no repository":   {CommitSHA: "9fceb02d"},
+		"spaced repo":     {Repository: "acme orders"},
+		"short commit":    {Repository: "acme/orders", CommitSHA: "9fce"},
+		"non-hex commit":  {Repository: "acme/orders", CommitSHA: "main-branch"},
+		"branch range":    {Repository: "acme/orders", Branch: "main..dev"},
+		"branch option":   {Repository: "acme/orders", Branch: "-f"},
+		"relative run":    {Repository: "acme/orders", CIRunURL: "/runs/42"},
+		"non-http run":    {Repository: "acme/orders", CIRunURL: "ftp://ci.example.com/runs/42"},
+		"negative number": {Repository: "acme/orders", PRNumber: -1},
+	} {
+		_, err := NormalizeWorkflowSource(&invalid)
+		assert.ErrorIs(t, err, ErrInvalidWorkflowSource, name)
+	}
+}
+
+func TestWorkflowEngine_WorkflowSource(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(run, nil)
+
+	start := func(source *models.WorkflowSource) (string, error) {
+		response, err := engine.StartWorkflow(ctx, &StartWorkflowRequest{
+			Name: "analysis", Type: string(models.WorkflowTypeExecution), ProjectID: "project-1", UserID: "ci",
+			Source: source,
+		})
+		if err != nil {
+			return "", err
+		}
+		return response.WorkflowID, nil
+	}
+	orders, err := start(&models.WorkflowSource{
+		Repository: "github.com/acme/orders", Branch: "main", CommitSHA: "9FCEB02D0AE598E9", PRNumber: 17,
+	})
+	require.NoError(t, err)
+	billing, err := start(&models.WorkflowSource{Repository: "github.com/acme/billing", CommitSHA: "1a2b3c4d"})
+	require.NoError(t, err)
+	manual, err := start(nil)
+	require.NoError(t, err)
+	_, err = start(&models.WorkflowSource{Repository: "github.com/acme/orders", CommitSHA: "HEAD"})
+	assert.ErrorIs(t, err, ErrInvalidWorkflowSource)
+
+	// The source is kept in the metadata under its key
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", orders).Error)
+	assert.JSONEq(t, `{"source": {"repository": "github.com/acme/orders", "branch": "main",
+		"commit_sha": "9fceb02d0ae598e9", "pr_number": 17}}`, string(stored.Metadata))
+	require.NotNil(t, stored.Source)
+	assert.Equal(t, "9fceb02d0ae598e9", stored.Source.CommitSHA)
+
+	list := func(filters *WorkflowFilters) []*models.WorkflowSummary {
+		summaries, total, err := engine.ListWorkflowSummaries(ctx, filters)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(summaries)), total)
+		return summaries
+	}
+	ids := func(summaries []*models.WorkflowSummary) []string {
+		var ids []string
+		for _, summary := range summaries {
+			ids = append(ids, summary.ID)
+		}
+		return ids
+	}
+
+	summaries := list(&WorkflowFilters{SortBy: "id"})
+	require.Len(t, summaries, 3)
+	assert.Equal(t, &models.WorkflowSource{Repository: "github.com/acme/orders", Branch: "main",
+		CommitSHA: "9fceb02d0ae598e9", PRNumber: 17}, summaries[0].Source, "listings show the source")
+	assert.Nil(t, summaries[2].Source)
+
+	assert.Equal(t, []string{orders}, ids(list(&WorkflowFilters{Commit: "9FCEB02D0AE598E9"})), "commits compare case-insensitively")
+	assert.Equal(t, []string{billing}, ids(list(&WorkflowFilters{Repository: "github.com/acme/billing"})))
+	assert.Empty(t, list(&WorkflowFilters{Repository: "github.com/acme/orders", Commit: "1a2b3c4d"}),
+		"both filters must match")
+	assert.Empty(t, list(&WorkflowFilters{Commit: "9fceb02d"}), "abbreviated commits match only as started")
+	assert.Len(t, list(&WorkflowFilters{}), 3)
+	assert.NotContains(t, ids(list(&WorkflowFilters{Repository: "github.com/acme/orders"})), manual)
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/retry"
+)
+
+// WorkflowStateQuery is the Temporal query workflows answer with their
+// WorkflowState
+const WorkflowStateQuery = "state"
+
+// Sources of a WorkflowState
+const (
+	WorkflowStateSourceQuery    = "query"
+	WorkflowStateSourceDatabase = "database"
+)
+
+// defaultStateQueryTimeout bounds how long a running workflow has to answer
+// the state query before its state is read from the database
+const defaultStateQueryTimeout = 2 * time.Second
+
+// WorkflowArtifactRef refers to an artifact a workflow produced
+type WorkflowArtifactRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+}
+
+// WorkflowState is what a workflow has done so far: the phase it is in, its
+// completed and pending steps, the artifacts it produced and its last error.
+// Running workflows report it through the state query; the state of other
+// workflows is read from the database.
+type WorkflowState struct {
+	WorkflowID     string                `json:"workflow_id"`
+	Status         models.WorkflowStatus `json:"status,omitempty"`
+	Phase          string                `json:"phase"`
+	CompletedSteps []string              `json:"completed_steps"`
+	PendingSteps   []string              `json:"pending_steps"`
+	Artifacts      []WorkflowArtifactRef `json:"artifacts"`
+	LastError      string                `json:"last_error,omitempty"`
+	RetryBudget    *retry.Budget         `json:"retry_budget,omitempty"` // Retries the workflow has used and may still make
+	Source         string                `json:"source"`
+	QueryError     string                `json:"query_error,omitempty"` // Why a running workflow's state was read from the database
+}
+
+// GetWorkflowState asks a running workflow for its state, falling back to
+// the database view for workflows that are terminal, not started in
+// Temporal, or do not answer within the state query timeout
+func (e *WorkflowEngine) GetWorkflowState(ctx context.Context, workflowID string) (*WorkflowState, error) {
+	var workflow models.Workflow
+	if err := e.db.WithContext(ctx).
+		Select("id", "status", "temporal_id", "error").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+
+	if workflow.IsTerminal() || workflow.TemporalID == "" {
+		return e.databaseWorkflowState(ctx, &workflow, "")
+	}
+
+	state, err := e.queryWorkflowState(ctx, workflow.TemporalID)
+	if err != nil {
+		e.logger.Warn("Workflow state query failed, reading state from the database",
+			zap.String("workflow_id", workflowID),
+			zap.Error(err))
+		return e.databaseWorkflowState(ctx, &workflow, err.Error())
+	}
+	state.WorkflowID = workflow.ID
+	state.Status = workflow.Status
+	state.Source = WorkflowStateSourceQuery
+	return state, nil
+}
+
+// queryWorkflowState runs the state query against the latest run of a
+// workflow, which is the one answering after a continue-as-new
+func (e *WorkflowEngine) queryWorkflowState(ctx context.Context, temporalID string) (*WorkflowState, error) {
+	timeout := e.config.StateQueryTimeout
+	if timeout <= 0 {
+		timeout = defaultStateQueryTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	value, err := e.temporalClient.QueryWorkflow(ctx, temporalID, "", WorkflowStateQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workflow state: %w", err)
+	}
+	var state WorkflowState
+	if err := value.Get(&state); err != nil {
+		return nil, fmt.Errorf("failed to decode workflow state: %w", err)
+	}
+	return &state, nil
+}
+
+// databaseWorkflowState builds a workflow's state from its stored steps and
+// the artifacts of its executions
+func (e *WorkflowEngine) databaseWorkflowState(ctx context.Context, workflow *models.Workflow, queryErr string) (*WorkflowState, error) {
+	state := &WorkflowState{
+		WorkflowID:     workflow.ID,
+		Status:         workflow.Status,
+		Phase:          string(workflow.Status),
+		CompletedSteps: []string{},
+		PendingSteps:   []string{},
+		Artifacts:      []WorkflowArtifactRef{},
+		LastError:      workflow.Error,
+		Source:         WorkflowStateSourceDatabase,
+		QueryError:     queryErr,
+	}
+
+	var steps []models.WorkflowStep
+	if err := e.db.WithContext(ctx).Select("name", "status", "error").
+		Where("workflow_id = ?", workflow.ID).Order(`"order"`).Find(&steps).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workflow steps: %w", err)
+	}
+	for _, step := range steps {
+		switch step.Status {
+		case models.WorkflowStatusCompleted, models.WorkflowStatusSkipped, models.WorkflowStatusCompletedManually:
+			state.CompletedSteps = append(state.CompletedSteps, step.Name)
+		case models.WorkflowStatusPending, models.WorkflowStatusRunning, models.WorkflowStatusPaused:
+			state.PendingSteps = append(state.PendingSteps, step.Name)
+			if step.Status == models.WorkflowStatusRunning && !workflow.IsTerminal() {
+				state.Phase = step.Name
+			}
+		}
+		if step.Error != "" && workflow.Error == "" {
+			state.LastError = step.Error
+		}
+	}
+
+	if err := e.db.WithContext(ctx).Model(&models.Artifact{}).
+		Select("artifacts.id", "artifacts.name", "artifacts.type").
+		Joins("JOIN executions ON executions.id = artifacts.execution_id").
+		Where("executions.workflow_id = ? AND executions.deleted_at IS NULL", workflow.ID).
+		Where("NOT " + supersededArtifact).
+		Order("artifacts.created_at, artifacts.id").
+		Scan(&state.Artifacts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workflow artifacts: %w", err)
+	}
+	if state.Artifacts == nil {
+		state.Artifacts = []WorkflowArtifactRef{}
+	}
+	return state, nil
+}
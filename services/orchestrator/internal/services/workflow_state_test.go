@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// setupWorkflowStateTest stores a running deployment whose first two steps
+// completed, producing one artifact, and whose third step is running. An
+// execution of it was deleted along with its artifact.
+func setupWorkflowStateTest(t *testing.T) (*gorm.DB, *models.Workflow) {
+	db := setupWorkflowTestDB(t)
+	for _, stmt := range []string{
+		`CREATE TABLE workflow_steps (id TEXT PRIMARY KEY, workflow_id TEXT, name TEXT, "order" INTEGER,
+			status TEXT, error TEXT)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY, workflow_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT,
+			created_at DATETIME, deleted_at DATETIME, parent_artifact_id TEXT)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	wf := &models.Workflow{
+		Name:       "Deploy",
+		Type:       models.WorkflowTypeDeployment,
+		Status:     models.WorkflowStatusRunning,
+		ProjectID:  "project-1",
+		TemporalID: "temporal-1",
+	}
+	require.NoError(t, db.Create(wf).Error)
+
+	for _, stmt := range []string{
+		`INSERT INTO workflow_steps VALUES ('s3', '` + wf.ID + `', 'test', 3, 'running', '')`,
+		`INSERT INTO workflow_steps VALUES ('s1', '` + wf.ID + `', 'validate', 1, 'completed', '')`,
+		`INSERT INTO workflow_steps VALUES ('s2', '` + wf.ID + `', 'build', 2, 'completed', 'cache miss')`,
+		`INSERT INTO workflow_steps VALUES ('s4', '` + wf.ID + `', 'deploy_production', 4, 'pending', '')`,
+		`INSERT INTO executions VALUES ('e1', '` + wf.ID + `', NULL)`,
+		`INSERT INTO artifacts VALUES ('a1', 'e1', 'app.tar.gz', 'build', '2026-10-18 12:00:00', NULL, NULL)`,
+		// The artifacts of deleted executions are not listed
+		`INSERT INTO executions VALUES ('e2', '` + wf.ID + `', '2026-10-18 12:30:00')`,
+		`INSERT INTO artifacts VALUES ('a2', 'e2', 'old.tar.gz', 'build', '2026-10-18 11:00:00', NULL, NULL)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	return db, wf
+}
+
+func TestWorkflowEngine_GetWorkflowStateFromQuery(t *testing.T) {
+	db, wf := setupWorkflowStateTest(t)
+
+	value := new(mocks.Value)
+	value.On("Get", mock.AnythingOfType("*services.WorkflowState")).Run(func(args mock.Arguments) {
+		*args.Get(0).(*WorkflowState) = WorkflowState{
+			Phase:          "deploy_production",
+			CompletedSteps: []string{"validate", "build", "test"},
+			PendingSteps:   []string{"deploy_production"},
+			Artifacts:      []WorkflowArtifactRef{{ID: "artifact-123", Type: "build"}},
+		}
+	}).Return(nil)
+	temporalClient := new(mocks.Client)
+	temporalClient.On("QueryWorkflow", mock.Anything, "temporal-1", "", WorkflowStateQuery).Return(value, nil)
+
+	engine := NewWorkflowEngine(db, nil, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	state, err := engine.GetWorkflowState(context.Background(), wf.ID)
+	require.NoError(t, err)
+
+	// The workflow is ahead of the database
+	assert.Equal(t, WorkflowStateSourceQuery, state.Source)
+	assert.Equal(t, wf.ID, state.WorkflowID)
+	assert.Equal(t, models.WorkflowStatusRunning, state.Status)
+	assert.Equal(t, "deploy_production", state.Phase)
+	assert.Equal(t, []string{"validate", "build", "test"}, state.CompletedSteps)
+	assert.Empty(t, state.QueryError)
+	temporalClient.AssertExpectations(t)
+}
+
+func TestWorkflowEngine_GetWorkflowStateFallsBackWhenQueryTimesOut(t *testing.T) {
+	db, wf := setupWorkflowStateTest(t)
+
+	temporalClient := new(mocks.Client)
+	temporalClient.On("QueryWorkflow", mock.Anything, "temporal-1", "", WorkflowStateQuery).
+		Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Return(nil, context.DeadlineExceeded)
+
+	engine := NewWorkflowEngine(db, nil, temporalClient, zap.NewNop(), nil, nil,
+		&WorkflowConfig{TaskQueue: "test-queue", StateQueryTimeout: 50 * time.Millisecond})
+	started := time.Now()
+	state, err := engine.GetWorkflowState(context.Background(), wf.ID)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(started), time.Second)
+
+	assert.Equal(t, WorkflowStateSourceDatabase, state.Source)
+	assert.Contains(t, state.QueryError, context.DeadlineExceeded.Error())
+	assert.Equal(t, "test", state.Phase)
+	assert.Equal(t, []string{"validate", "build"}, state.CompletedSteps)
+	assert.Equal(t, []string{"test", "deploy_production"}, state.PendingSteps)
+	assert.Equal(t, []WorkflowArtifactRef{{ID: "a1", Name: "app.tar.gz", Type: "build"}}, state.Artifacts)
+	assert.Equal(t, "cache miss", state.LastError)
+	temporalClient.AssertExpectations(t)
+}
+
+func TestWorkflowEngine_GetWorkflowStateOfTerminalWorkflow(t *testing.T) {
+	db, wf := setupWorkflowStateTest(t)
+	require.NoError(t, db.Model(wf).Updates(map[string]interface{}{
+		"status": models.WorkflowStatusFailed,
+		"error":  "health check failed",
+	}).Error)
+
+	// Terminal workflows are not queried
+	temporalClient := new(mocks.Client)
+	engine := NewWorkflowEngine(db, nil, temporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	state, err := engine.GetWorkflowState(context.Background(), wf.ID)
+	require.NoError(t, err)
+	assert.Equal(t, WorkflowStateSourceDatabase, state.Source)
+	assert.Equal(t, string(models.WorkflowStatusFailed), state.Phase)
+	assert.Equal(t, "health check failed", state.LastError)
+	assert.Empty(t, state.QueryError)
+	temporalClient.AssertNotCalled(t, "QueryWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	_, err = engine.GetWorkflowState(context.Background(), "00000000-0000-0000-0000-000000000099")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/history/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+// ErrWorkflowNotStuck is returned when resetting a workflow that is not stuck
+var ErrWorkflowNotStuck = errors.New("workflow is not stuck")
+
+// WorkflowEventStuck is published on the workflow event channel when a
+// workflow is marked stuck
+const WorkflowEventStuck = "workflow_stuck"
+
+// maxStuckErrorLength bounds the failure message kept on the workflow row
+const maxStuckErrorLength = 1024
+
+var (
+	workflowsStuck = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orchestrator_workflows_stuck",
+		Help: "Running workflows marked stuck on a repeatedly failing workflow task",
+	})
+	workflowStuckTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_workflow_stuck_total",
+		Help: "Workflows marked stuck on a repeatedly failing workflow task",
+	}, []string{"project_id"})
+)
+
+// EnableStuckDetection makes the monitor mark running workflows stuck once
+// their workflow task has failed more than failures consecutive times, as it
+// does on panics and nondeterminism, which Temporal retries indefinitely.
+// Events go through the engine.
+func (m *WorkflowMonitor) EnableStuckDetection(engine *WorkflowEngine, failures int) {
+	if failures <= 0 {
+		return
+	}
+	m.stuckEvents = engine.emitWorkflowEvent
+	m.stuckTaskFailures = failures
+}
+
+// checkWorkflowTask marks a workflow stuck when the pending workflow task of
+// the described run has failed too often in a row. Workflows stay stuck
+// until reset, so each one is reported once.
+func (m *WorkflowMonitor) checkWorkflowTask(ctx context.Context, workflow *models.Workflow, info *workflowservice.DescribeWorkflowExecutionResponse) {
+	if m.stuckEvents == nil || workflow.Stuck || info.PendingWorkflowTask == nil {
+		return
+	}
+	// The first attempt is not a retry
+	failures := int(info.PendingWorkflowTask.Attempt) - 1
+	if failures <= m.stuckTaskFailures {
+		return
+	}
+
+	summary := fmt.Sprintf("workflow task failed %d consecutive times", failures)
+	if cause, message := m.lastWorkflowTaskFailure(ctx, workflow); cause != "" {
+		summary = fmt.Sprintf("%s: %s: %s", summary, cause, message)
+	}
+	if len(summary) > maxStuckErrorLength {
+		summary = strings.ToValidUTF8(summary[:maxStuckErrorLength], "")
+	}
+
+	// Conditional on the flag so each workflow is reported once, even with
+	// several monitors running
+	now := m.now().UTC()
+	result := m.db.WithContext(ctx).Model(&models.Workflow{}).
+		Where("id = ? AND stuck = ?", workflow.ID, false).
+		Updates(map[string]interface{}{
+			"stuck":       true,
+			"stuck_error": summary,
+			"stuck_at":    now,
+		})
+	if result.Error != nil {
+		m.logger.Error("Failed to mark workflow stuck",
+			zap.String("workflowID", workflow.ID),
+			zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected == 0 {
+		return
+	}
+	workflow.Stuck, workflow.StuckError, workflow.StuckAt = true, summary, &now
+	m.clearWorkflowCache(ctx, workflow.ID)
+
+	workflowStuckTotal.WithLabelValues(workflow.ProjectID).Inc()
+	m.stuckEvents(ctx, workflow, WorkflowEventStuck, map[string]interface{}{
+		"failures":        failures,
+		"error":           summary,
+		"temporal_run_id": workflow.TemporalRunID,
+	})
+	m.logger.Warn("Workflow task keeps failing, marked workflow stuck",
+		zap.String("workflowID", workflow.ID),
+		zap.String("temporalID", workflow.TemporalID),
+		zap.Int("failures", failures),
+		zap.String("error", summary))
+}
+
+// lastWorkflowTaskFailure returns the cause and message of the last workflow
+// task failure in the run's history. Temporal records only the first of
+// consecutive failures, so this is the failure the task keeps hitting.
+func (m *WorkflowMonitor) lastWorkflowTaskFailure(ctx context.Context, workflow *models.Workflow) (string, string) {
+	var failed *history.WorkflowTaskFailedEventAttributes
+	events := m.temporalClient.GetWorkflowHistory(ctx, workflow.TemporalID, workflow.TemporalRunID, false,
+		enums.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	for events.HasNext() {
+		event, err := events.Next()
+		if err != nil {
+			m.logger.Warn("Failed to read workflow history",
+				zap.String("workflowID", workflow.ID),
+				zap.Error(err))
+			break
+		}
+		if attributes := event.GetWorkflowTaskFailedEventAttributes(); attributes != nil {
+			failed = attributes
+		}
+	}
+	if failed == nil {
+		return "", ""
+	}
+	return failed.Cause.String(), failed.GetFailure().GetMessage()
+}
+
+// countStuck exports the number of running workflows marked stuck
+func (m *WorkflowMonitor) countStuck(workflows []models.Workflow) {
+	if m.stuckEvents == nil {
+		return
+	}
+	stuck := 0
+	for _, workflow := range workflows {
+		if workflow.Stuck {
+			stuck++
+		}
+	}
+	workflowsStuck.Set(float64(stuck))
+}
+
+// ResetStuck clears the stuck flag of a workflow, once a fix for its failing
+// workflow task is deployed. A workflow whose task still fails is marked
+// stuck again by the next monitor check.
+func (e *WorkflowEngine) ResetStuck(ctx context.Context, workflowID, actor string) (*models.Workflow, error) {
+	var workflow models.Workflow
+	if err := e.db.WithContext(ctx).First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	if !workflow.Stuck {
+		return nil, ErrWorkflowNotStuck
+	}
+
+	if err := e.db.WithContext(ctx).Model(&workflow).Updates(map[string]interface{}{
+		"stuck":       false,
+		"stuck_error": "",
+		"stuck_at":    nil,
+	}).Error; err != nil {
+		return nil, fmt.Errorf("failed to reset stuck workflow: %w", err)
+	}
+	previous := workflow.StuckError
+	workflow.Stuck, workflow.StuckError, workflow.StuckAt = false, "", nil
+
+	e.dropCachedWorkflow(ctx, workflowID)
+
+	details, err := json.Marshal(map[string]interface{}{"stuck_error": previous})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	entry := &models.AuditLog{
+		EntityType: "workflow",
+		EntityID:   workflow.ID,
+		Action:     "workflow.reset_stuck",
+		Actor:      actor,
+		Details:    details,
+	}
+	if err := e.db.WithContext(ctx).Create(entry).Error; err != nil {
+		e.logger.Error("failed to write audit log", zap.String("workflow_id", workflowID), zap.Error(err))
+	}
+	return &workflow, nil
+}
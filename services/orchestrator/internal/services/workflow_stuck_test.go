@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prometheustestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/failure/v1"
+	"go.temporal.io/api/history/v1"
+	"go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// workflowTaskFailedHistory returns a history with a nondeterministic
+// workflow task failure
+func workflowTaskFailedHistory(message string) *mocks.HistoryEventIterator {
+	events := []*history.HistoryEvent{
+		{EventType: enums.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED},
+		{
+			EventType: enums.EVENT_TYPE_WORKFLOW_TASK_FAILED,
+			Attributes: &history.HistoryEvent_WorkflowTaskFailedEventAttributes{
+				WorkflowTaskFailedEventAttributes: &history.WorkflowTaskFailedEventAttributes{
+					Cause:   enums.WORKFLOW_TASK_FAILED_CAUSE_NON_DETERMINISTIC_ERROR,
+					Failure: &failure.Failure{Message: message},
+				},
+			},
+		},
+	}
+	iterator := &mocks.HistoryEventIterator{}
+	for _, event := range events {
+		iterator.On("HasNext").Return(true).Once()
+		iterator.On("Next").Return(event, nil).Once()
+	}
+	iterator.On("HasNext").Return(false)
+	return iterator
+}
+
+func TestWorkflowMonitor_MarksWorkflowsWithFailingTasksStuck(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+	defer redisClient.Close()
+
+	stuck := &models.Workflow{Name: "panicking", Type: models.WorkflowTypeCustom, Status: models.WorkflowStatusRunning,
+		ProjectID: "project-stuck", TemporalID: "panic-1", TemporalRunID: "run-1"}
+	retrying := &models.Workflow{Name: "retrying", Type: models.WorkflowTypeCustom, Status: models.WorkflowStatusRunning,
+		ProjectID: "project-stuck", TemporalID: "retry-1", TemporalRunID: "run-1"}
+	for _, workflow := range []*models.Workflow{stuck, retrying} {
+		require.NoError(t, db.Create(workflow).Error)
+	}
+
+	// The workflow task of panic-1 has failed 6 times in a row, that of
+	// retry-1 twice
+	temporalClient := &mocks.Client{}
+	for temporalID, attempt := range map[string]int32{"panic-1": 7, "retry-1": 3} {
+		resp := describeResponse(temporalID, "run-1", enums.WORKFLOW_EXECUTION_STATUS_RUNNING)
+		resp.PendingWorkflowTask = &workflow.PendingWorkflowTaskInfo{
+			State:   enums.PENDING_WORKFLOW_TASK_STATE_SCHEDULED,
+			Attempt: attempt,
+		}
+		temporalClient.On("DescribeWorkflowExecution", mock.Anything, temporalID, "run-1").Return(resp, nil)
+	}
+	for i := 0; i < 2; i++ {
+		temporalClient.On("GetWorkflowHistory", mock.Anything, "panic-1", "run-1", false, enums.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT).
+			Return(workflowTaskFailedHistory("unknown command CommandType: ScheduleActivityTask")).Once()
+	}
+
+	engine := NewWorkflowEngine(db, redisClient, nil, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+	monitor := NewWorkflowMonitor(db, temporalClient, zap.NewNop(), redisClient, time.Minute)
+	monitor.EnableStuckDetection(engine, 5)
+	var events []map[string]interface{}
+	monitor.stuckEvents = func(ctx context.Context, workflow *models.Workflow, eventType string, data map[string]interface{}) {
+		assert.Equal(t, WorkflowEventStuck, eventType)
+		assert.Equal(t, stuck.ID, workflow.ID)
+		events = append(events, data)
+	}
+	marked := prometheustestutil.ToFloat64(workflowStuckTotal.WithLabelValues("project-stuck"))
+
+	monitor.checkWorkflows()
+
+	var stored models.Workflow
+	require.NoError(t, db.First(&stored, "id = ?", stuck.ID).Error)
+	assert.True(t, stored.Stuck)
+	assert.NotNil(t, stored.StuckAt)
+	assert.Contains(t, stored.StuckError, "workflow task failed 6 consecutive times")
+	assert.Contains(t, stored.StuckError, "unknown command CommandType: ScheduleActivityTask")
+	assert.Equal(t, models.WorkflowStatusRunning, stored.Status)
+	require.Len(t, events, 1)
+	assert.Equal(t, 6, events[0]["failures"])
+	assert.Equal(t, stored.StuckError, events[0]["error"])
+	assert.Equal(t, marked+1, prometheustestutil.ToFloat64(workflowStuckTotal.WithLabelValues("project-stuck")))
+
+	var other models.Workflow
+	require.NoError(t, db.First(&other, "id = ?", retrying.ID).Error)
+	assert.False(t, other.Stuck, "failures up to the threshold are retried")
+
+	// Stuck workflows are reported once
+	monitor.checkWorkflows()
+	assert.Len(t, events, 1)
+	assert.Equal(t, float64(1), prometheustestutil.ToFloat64(workflowsStuck))
+
+	// Resetting clears the flag; a task that still fails is detected again
+	reset, err := engine.ResetStuck(context.Background(), stuck.ID, "admin-1")
+	require.NoError(t, err)
+	assert.False(t, reset.Stuck)
+	stored = models.Workflow{}
+	require.NoError(t, db.First(&stored, "id = ?", stuck.ID).Error)
+	assert.False(t, stored.Stuck)
+	assert.Empty(t, stored.StuckError)
+	assert.Nil(t, stored.StuckAt)
+
+	_, err = engine.ResetStuck(context.Background(), stuck.ID, "admin-1")
+	assert.ErrorIs(t, err, ErrWorkflowNotStuck)
+	_, err = engine.ResetStuck(context.Background(), "missing", "admin-1")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	monitor.checkWorkflows()
+	assert.Len(t, events, 2)
+	temporalClient.AssertExpectations(t)
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/requestid"
+)
+
+const (
+	maxWorkflowTags   = 32
+	maxWorkflowLabels = 32
+	maxLabelLength    = 256
+)
+
+// ErrInvalidTags is returned when workflow tags or labels fail validation
+var ErrInvalidTags = errors.New("invalid workflow tags")
+
+// tagPattern restricts tags to a conservative charset so they are safe in
+// array literals, LIKE patterns and Temporal keyword lists.
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]{0,62}$`)
+
+// normalizeTags trims, validates and de-duplicates tags, preserving order
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("%w: tag %q must be 1-63 characters of letters, digits or _.:/-", ErrInvalidTags, tag)
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	if len(result) > maxWorkflowTags {
+		return nil, fmt.Errorf("%w: too many tags: %d (max %d)", ErrInvalidTags, len(result), maxWorkflowTags)
+	}
+	return result, nil
+}
+
+// validateLabels checks label keys and values for size limits
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxWorkflowLabels {
+		return fmt.Errorf("%w: too many labels: %d (max %d)", ErrInvalidTags, len(labels), maxWorkflowLabels)
+	}
+	for key, value := range labels {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("%w: label keys must not be empty", ErrInvalidTags)
+		}
+		if len(key) > maxLabelLength || len(value) > maxLabelLength {
+			return fmt.Errorf("%w: label %q exceeds %d characters", ErrInvalidTags, key, maxLabelLength)
+		}
+	}
+	return nil
+}
+
+// applyTagFilters restricts a workflow query to workflows carrying all of the
+// given tags and labels. Postgres uses array/jsonb containment (GIN indexed);
+// other dialects fall back to LIKE on the serialized columns.
+func applyTagFilters(query *gorm.DB, tags []string, labels map[string]string) *gorm.DB {
+	if len(tags) == 0 && len(labels) == 0 {
+		return query
+	}
+
+	if query.Dialector.Name() == "postgres" {
+		if len(tags) > 0 {
+			query = query.Where("tags @> ?::text[]", models.StringArray(tags))
+		}
+		if len(labels) > 0 {
+			data, _ := json.Marshal(labels)
+			query = query.Where("labels @> ?::jsonb", string(data))
+		}
+		return query
+	}
+
+	for _, tag := range tags {
+		query = query.Where(`tags LIKE ? ESCAPE '!'`, "%"+escapeLike(`"`+tag+`"`)+"%")
+	}
+
+	// Iterate in a stable order so generated SQL is deterministic
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(labels[key])
+		query = query.Where(`labels LIKE ? ESCAPE '!'`, "%"+escapeLike(string(k)+":"+string(v))+"%")
+	}
+	return query
+}
+
+// escapeLike escapes LIKE wildcards using '!' as the escape character
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// UpdateWorkflowTags adds and removes tags on an existing workflow. When
+// replace is non-nil it replaces the tag set before add/remove are applied.
+// The workflow row is locked for the update, so concurrent updates apply in
+// turn. Temporal's Tags search attribute and memo keep the tags the workflow
+// was started with.
+func (e *WorkflowEngine) UpdateWorkflowTags(ctx context.Context, workflowID string, replace *[]string, add, remove []string) (*models.Workflow, error) {
+	removed := make(map[string]bool, len(remove))
+	for _, tag := range remove {
+		removed[strings.TrimSpace(tag)] = true
+	}
+
+	var workflow models.Workflow
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&workflow, "id = ?", workflowID).Error; err != nil {
+			return fmt.Errorf("workflow not found: %w", err)
+		}
+
+		tags := []string(workflow.Tags)
+		if replace != nil {
+			tags = *replace
+		}
+
+		updated := make([]string, 0, len(tags)+len(add))
+		for _, tag := range append(tags, add...) {
+			if !removed[strings.TrimSpace(tag)] {
+				updated = append(updated, tag)
+			}
+		}
+
+		normalized, err := normalizeTags(updated)
+		if err != nil {
+			return err
+		}
+		if normalized == nil {
+			normalized = []string{}
+		}
+
+		if err := tx.Model(&workflow).Update("tags", models.StringArray(normalized)).Error; err != nil {
+			return fmt.Errorf("failed to update workflow tags: %w", err)
+		}
+		workflow.Tags = normalized
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the cached copy so readers see the new tags
+	e.dropCachedWorkflow(ctx, workflowID)
+
+	return &workflow, nil
+}
+
+// workflowMemo builds the Temporal memo for a workflow. Memo fields are not
+// indexed but are shown in the Temporal UI.
+func workflowMemo(workflow *models.Workflow) map[string]interface{} {
+	memo := make(map[string]interface{})
+	if len(workflow.Tags) > 0 {
+		memo["tags"] = []string(workflow.Tags)
+	}
+	if len(workflow.Labels) > 0 {
+		memo["labels"] = map[string]string(workflow.Labels)
+	}
+	if workflow.RequestID != "" {
+		memo[requestid.Field] = workflow.RequestID
+	}
+	if len(memo) == 0 {
+		return nil
+	}
+	return memo
+}
+
+// workflowSearchAttributes builds the Temporal search attributes for a workflow.
+// The attributes must be registered on the namespace before they can be set;
+// see SearchAttributeTypes.
+func workflowSearchAttributes(workflow *models.Workflow) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	for name, value := range map[string]string{
+		SearchAttributeProjectID:    workflow.ProjectID,
+		SearchAttributeWorkflowType: string(workflow.Type),
+		SearchAttributePriority:     string(workflow.Priority),
+		SearchAttributeCreatedBy:    workflow.CreatedBy,
+	} {
+		if value != "" {
+			attributes[name] = value
+		}
+	}
+	if len(workflow.Tags) > 0 {
+		attributes[SearchAttributeTags] = []string(workflow.Tags)
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+	return attributes
+}
@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/models"
+)
+
+var (
+	// ErrInvalidTemplate is returned for workflows started from a template ID
+	// that is not a UUID
+	ErrInvalidTemplate = errors.New("invalid workflow template")
+
+	// ErrTemplateNotFound is returned when cloning a template that does not
+	// exist, is inactive, or is private to another project
+	ErrTemplateNotFound = errors.New("workflow template not found")
+
+	// ErrTemplateNameTaken is returned when a clone would reuse the name of
+	// another template; template names are unique
+	ErrTemplateNameTaken = errors.New("workflow template name already taken")
+
+	// ErrInvalidCatalogQuery is returned for catalog queries with an unknown
+	// sort order or invalid paging
+	ErrInvalidCatalogQuery = errors.New("invalid template catalog query")
+)
+
+// Orders of the template catalog
+const (
+	CatalogSortPopularity  = "popularity"   // Most workflows started first
+	CatalogSortSuccessRate = "success_rate" // Highest success rate first
+	CatalogSortDuration    = "duration"     // Fastest first; templates never completed last
+	CatalogSortNewest      = "newest"
+	CatalogSortName        = "name"
+)
+
+// catalogOrders are the ORDER BY clauses of the catalog sort orders, ties
+// broken by name so pages are stable
+var catalogOrders = map[string]string{
+	CatalogSortPopularity:  "COALESCE(template_stats.workflow_count, 0) DESC, workflow_templates.name",
+	CatalogSortSuccessRate: "COALESCE(template_stats.success_rate, 0) DESC, COALESCE(template_stats.workflow_count, 0) DESC, workflow_templates.name",
+	CatalogSortDuration:    "CASE WHEN COALESCE(template_stats.avg_duration_ms, 0) = 0 THEN 1 ELSE 0 END, template_stats.avg_duration_ms, workflow_templates.name",
+	CatalogSortNewest:      "workflow_templates.created_at DESC, workflow_templates.name",
+	CatalogSortName:        "workflow_templates.name",
+}
+
+// Catalog page sizes
+const (
+	defaultCatalogLimit = 20
+	maxCatalogLimit     = 100
+)
+
+// catalogColumns are the template columns listed in the catalog; schemas,
+// configs and steps are left to the template itself
+const catalogColumns = "workflow_templates.id, workflow_templates.name, workflow_templates.description, workflow_templates.type, " +
+	"workflow_templates.version, workflow_templates.tags, workflow_templates.project_id, workflow_templates.source_template_id, " +
+	"workflow_templates.source_version, workflow_templates.created_by, workflow_templates.created_at, workflow_templates.updated_at"
+
+// finishedWorkflowStatuses are the statuses of workflows that ran to an end
+var finishedWorkflowStatuses = []string{
+	string(models.WorkflowStatusCompleted),
+	string(models.WorkflowStatusFailed),
+	string(models.WorkflowStatusCancelled),
+	string(models.WorkflowStatusTerminated),
+	string(models.WorkflowStatusTimedOut),
+}
+
+// TemplateCatalogFilter selects and orders the templates of the catalog
+type TemplateCatalogFilter struct {
+	Type   string
+	Tags   []string // Templates must carry all of these tags
+	Sort   string   // One of the CatalogSort orders, popularity by default
+	Limit  int
+	Offset int
+}
+
+// TemplateCatalogEntry is a public template with its usage
+type TemplateCatalogEntry struct {
+	*models.WorkflowTemplate
+	Usage models.TemplateStat `json:"usage"`
+}
+
+// TemplateCatalogPage is a page of the template catalog
+type TemplateCatalogPage struct {
+	Templates  []TemplateCatalogEntry `json:"templates"`
+	TotalCount int64                  `json:"total_count"`
+	Limit      int                    `json:"limit"`
+	Offset     int                    `json:"offset"`
+}
+
+// TemplateCatalog lists the public workflow templates of every project with
+// the usage of each, and clones them into projects. Usage is recomputed
+// every interval from the workflows started from each template rather than
+// on every listing.
+type TemplateCatalog struct {
+	db       *gorm.DB
+	logger   *zap.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewTemplateCatalog creates a template catalog recomputing usage every
+// interval
+func NewTemplateCatalog(db *gorm.DB, logger *zap.Logger, interval time.Duration) *TemplateCatalog {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &TemplateCatalog{
+		db:       db,
+		logger:   logger,
+		interval: interval,
+		now:      time.Now,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start recomputes usage now and then every interval
+func (c *TemplateCatalog) Start() {
+	c.wg.Add(1)
+	go c.run()
+	c.logger.Info("Template stats aggregator started", zap.Duration("interval", c.interval))
+}
+
+// Stop stops recomputing usage
+func (c *TemplateCatalog) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopChan)
+		c.wg.Wait()
+		c.logger.Info("Template stats aggregator stopped")
+	})
+}
+
+func (c *TemplateCatalog) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.RefreshStats(context.Background()); err != nil {
+			c.logger.Error("Failed to refresh template stats", zap.Error(err))
+		}
+		select {
+		case <-ticker.C:
+		case <-c.stopChan:
+			return
+		}
+	}
+}
+
+// templateUsage is the usage of a template as counted from its workflows
+type templateUsage struct {
+	TemplateID        string
+	WorkflowCount     int64
+	FinishedCount     int64
+	CompletedCount    int64
+	CancelledCount    int64
+	CompletedDuration int64
+}
+
+// RefreshStats recomputes the usage of every template from the workflows
+// started from it, returning the number of templates used
+func (c *TemplateCatalog) RefreshStats(ctx context.Context) (int, error) {
+	var usage []templateUsage
+	err := c.db.WithContext(ctx).Model(&models.Workflow{}).
+		Select("template_id, COUNT(*) AS workflow_count, "+
+			"SUM(CASE WHEN status IN ? THEN 1 ELSE 0 END) AS finished_count, "+
+			"SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS completed_count, "+
+			"SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS cancelled_count, "+
+			"COALESCE(SUM(CASE WHEN status = ? THEN duration ELSE 0 END), 0) AS completed_duration",
+			finishedWorkflowStatuses, models.WorkflowStatusCompleted, models.WorkflowStatusCancelled, models.WorkflowStatusCompleted).
+		Where("template_id IS NOT NULL AND simulated = ? AND status <> ?", false, models.WorkflowStatusDraft).
+		Group("template_id").
+		Scan(&usage).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count template usage: %w", err)
+	}
+
+	now := c.now()
+	stats := make([]models.TemplateStat, len(usage))
+	ids := make([]string, len(usage))
+	for i, u := range usage {
+		stat := models.TemplateStat{
+			TemplateID:     u.TemplateID,
+			WorkflowCount:  u.WorkflowCount,
+			FinishedCount:  u.FinishedCount,
+			CompletedCount: u.CompletedCount,
+			CancelledCount: u.CancelledCount,
+			UpdatedAt:      now,
+		}
+		// Cancellations are neither successes nor failures
+		if decided := u.FinishedCount - u.CancelledCount; decided > 0 {
+			stat.SuccessRate = float64(u.CompletedCount) / float64(decided)
+		}
+		if u.CompletedCount > 0 {
+			stat.AvgDurationMs = u.CompletedDuration / u.CompletedCount
+		}
+		stats[i] = stat
+		ids[i] = u.TemplateID
+	}
+
+	err = c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Templates whose workflows are all gone have no usage left
+		stale := tx.Where("1 = 1")
+		if len(ids) > 0 {
+			stale = tx.Where("template_id NOT IN ?", ids)
+		}
+		if err := stale.Delete(&models.TemplateStat{}).Error; err != nil {
+			return err
+		}
+		if len(stats) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(stats, 100).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to store template stats: %w", err)
+	}
+	return len(stats), nil
+}
+
+// Catalog lists the active public templates with their usage
+func (c *TemplateCatalog) Catalog(ctx context.Context, filter TemplateCatalogFilter) (*TemplateCatalogPage, error) {
+	if filter.Sort == "" {
+		filter.Sort = CatalogSortPopularity
+	}
+	order, ok := catalogOrders[filter.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidCatalogQuery, filter.Sort)
+	}
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, fmt.Errorf("%w: limit and offset must not be negative", ErrInvalidCatalogQuery)
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultCatalogLimit
+	}
+	filter.Limit = min(filter.Limit, maxCatalogLimit)
+
+	query := c.db.WithContext(ctx).Model(&models.WorkflowTemplate{}).
+		Where("workflow_templates.is_public = ? AND workflow_templates.is_active = ?", true, true)
+	if filter.Type != "" {
+		query = query.Where("workflow_templates.type = ?", filter.Type)
+	}
+	query = applyTagFilters(query, filter.Tags, nil)
+
+	page := &TemplateCatalogPage{Templates: []TemplateCatalogEntry{}, Limit: filter.Limit, Offset: filter.Offset}
+	// Count on its own session so the select below starts from a clean statement
+	if err := query.Session(&gorm.Session{}).Count(&page.TotalCount).Error; err != nil {
+		return nil, fmt.Errorf("failed to count catalog templates: %w", err)
+	}
+
+	var templates []*models.WorkflowTemplate
+	err := query.Select(catalogColumns).
+		Joins("LEFT JOIN template_stats ON template_stats.template_id = workflow_templates.id").
+		Order(order).Limit(filter.Limit).Offset(filter.Offset).
+		Find(&templates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalog templates: %w", err)
+	}
+	if len(templates) == 0 {
+		return page, nil
+	}
+
+	ids := make([]string, len(templates))
+	for i, template := range templates {
+		ids[i] = template.ID
+	}
+	var stats []models.TemplateStat
+	if err := c.db.WithContext(ctx).Where("template_id IN ?", ids).Find(&stats).Error; err != nil {
+		return nil, fmt.Errorf("failed to load template stats: %w", err)
+	}
+	byTemplate := make(map[string]models.TemplateStat, len(stats))
+	for _, stat := range stats {
+		byTemplate[stat.TemplateID] = stat
+	}
+
+	for _, template := range templates {
+		// Templates never used have zero usage
+		usage, ok := byTemplate[template.ID]
+		if !ok {
+			usage.TemplateID = template.ID
+		}
+		page.Templates = append(page.Templates, TemplateCatalogEntry{WorkflowTemplate: template, Usage: usage})
+	}
+	return page, nil
+}
+
+// CloneTemplate copies a template into a project as a new, private template
+// recording its source and the source's version. Public templates can be
+// cloned into any project, others only within their own. The clone is named
+// after the source and the project unless name is given.
+func (c *TemplateCatalog) CloneTemplate(ctx context.Context, templateID, projectID, name, userID string) (*models.WorkflowTemplate, error) {
+	db := c.db.WithContext(ctx)
+
+	var source models.WorkflowTemplate
+	if err := db.First(&source, "id = ? AND is_active = ?", templateID, true).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, fmt.Errorf("failed to get workflow template: %w", err)
+	}
+	if !source.IsPublic && (source.ProjectID == nil || *source.ProjectID != projectID) {
+		return nil, ErrTemplateNotFound
+	}
+
+	var project models.Project
+	if err := db.Select("id", "name").First(&project, "id = ?", projectID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrProjectNotFound, projectID)
+		}
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = fmt.Sprintf("%s (%s)", source.Name, project.Name)
+	}
+	// Names stay taken by deleted templates too
+	var taken int64
+	if err := db.Unscoped().Model(&models.WorkflowTemplate{}).Where("name = ?", name).Count(&taken).Error; err != nil {
+		return nil, fmt.Errorf("failed to check workflow template name: %w", err)
+	}
+	if taken > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNameTaken, name)
+	}
+
+	clone := &models.WorkflowTemplate{
+		ID:               uuid.NewString(),
+		Name:             name,
+		Description:      source.Description,
+		Type:             source.Type,
+		Version:          source.Version,
+		Schema:           source.Schema,
+		Config:           source.Config,
+		Steps:            source.Steps,
+		Variables:        source.Variables,
+		Tags:             source.Tags,
+		IsActive:         true,
+		IsPublic:         false,
+		ProjectID:        &project.ID,
+		SourceTemplateID: &source.ID,
+		SourceVersion:    source.Version,
+		CreatedBy:        userID,
+		UpdatedBy:        userID,
+	}
+	if err := db.Create(clone).Error; err != nil {
+		return nil, fmt.Errorf("failed to clone workflow template: %w", err)
+	}
+
+	c.logger.Info("Workflow template cloned",
+		zap.String("templateID", source.ID),
+		zap.String("cloneID", clone.ID),
+		zap.String("projectID", project.ID),
+		zap.String("userID", userID))
+	return clone, nil
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// setupTemplateCatalogTest creates a catalog over the workflows, templates
+// and template stats tables, with the projects project-1 (Platform) and
+// project-2 (Payments)
+func setupTemplateCatalogTest(t *testing.T) (*TemplateCatalog, *gorm.DB) {
+	db := setupWorkflowTestDB(t)
+
+	stmt := &gorm.Statement{DB: db}
+	require.NoError(t, stmt.Parse(&models.WorkflowTemplate{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+	require.NoError(t, db.Migrator().CreateTable(&models.WorkflowTemplate{}, &models.TemplateStat{}))
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, name TEXT, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO projects (id, name) VALUES ('project-1', 'Platform'), ('project-2', 'Payments')`).Error)
+
+	catalog := NewTemplateCatalog(db, zap.NewNop(), time.Minute)
+	catalog.now = func() time.Time { return time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC) }
+	return catalog, db
+}
+
+func createTemplate(t *testing.T, db *gorm.DB, template *models.WorkflowTemplate) {
+	if template.Version == "" {
+		template.Version = "1.0.0"
+	}
+	active := template.IsActive
+	require.NoError(t, db.Create(template).Error)
+	// Inactive templates are created active by the column default
+	if !active {
+		require.NoError(t, db.Model(template).Update("is_active", false).Error)
+	}
+}
+
+func createTemplateWorkflow(t *testing.T, db *gorm.DB, templateID string, status models.WorkflowStatus, duration time.Duration, simulated bool) {
+	require.NoError(t, db.Create(&models.Workflow{
+		Name:       "From template",
+		Type:       models.WorkflowTypeExecution,
+		ProjectID:  "project-1",
+		Status:     status,
+		Duration:   duration.Milliseconds(),
+		Simulated:  simulated,
+		TemplateID: &templateID,
+	}).Error)
+}
+
+func TestTemplateCatalog_RefreshStats(t *testing.T) {
+	ctx := context.Background()
+	catalog, db := setupTemplateCatalogTest(t)
+
+	createTemplateWorkflow(t, db, "tpl-a", models.WorkflowStatusCompleted, time.Second, false)
+	createTemplateWorkflow(t, db, "tpl-a", models.WorkflowStatusCompleted, 3*time.Second, false)
+	createTemplateWorkflow(t, db, "tpl-a", models.WorkflowStatusFailed, time.Minute, false)
+	createTemplateWorkflow(t, db, "tpl-a", models.WorkflowStatusRunning, 0, false)
+	// Drafts and simulated workflows are not usage
+	createTemplateWorkflow(t, db, "tpl-a", models.WorkflowStatusDraft, 0, false)
+	createTemplateWorkflow(t, db, "tpl-a", models.WorkflowStatusCompleted, time.Hour, true)
+	createTemplateWorkflow(t, db, "tpl-b", models.WorkflowStatusCancelled, time.Second, false)
+	// Workflows started without a template are not counted
+	require.NoError(t, db.Create(&models.Workflow{Name: "Ad hoc", Type: models.WorkflowTypeExecution, ProjectID: "project-1", Status: models.WorkflowStatusCompleted}).Error)
+	// The workflows of tpl-c are gone
+	require.NoError(t, db.Create(&models.TemplateStat{TemplateID: "tpl-c", WorkflowCount: 5}).Error)
+
+	used, err := catalog.RefreshStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, used)
+
+	var stats []models.TemplateStat
+	require.NoError(t, db.Order("template_id").Find(&stats).Error)
+	require.Len(t, stats, 2)
+	assert.Equal(t, "tpl-a", stats[0].TemplateID)
+	assert.Equal(t, int64(4), stats[0].WorkflowCount)
+	assert.Equal(t, int64(3), stats[0].FinishedCount)
+	assert.Equal(t, int64(2), stats[0].CompletedCount)
+	assert.InDelta(t, 2.0/3, stats[0].SuccessRate, 1e-9)
+	assert.Equal(t, int64(2000), stats[0].AvgDurationMs)
+	assert.Equal(t, int64(1), stats[1].WorkflowCount)
+	assert.Zero(t, stats[1].SuccessRate)
+	assert.Zero(t, stats[1].AvgDurationMs)
+
+	// Refreshing again updates the stats in place
+	createTemplateWorkflow(t, db, "tpl-b", models.WorkflowStatusCompleted, time.Second, false)
+	_, err = catalog.RefreshStats(ctx)
+	require.NoError(t, err)
+	var stat models.TemplateStat
+	require.NoError(t, db.First(&stat, "template_id = ?", "tpl-b").Error)
+	assert.Equal(t, int64(2), stat.WorkflowCount)
+	assert.Equal(t, int64(1), stat.CancelledCount)
+	assert.InDelta(t, 1.0, stat.SuccessRate, 1e-9, "cancellations are not failures")
+}
+
+func TestTemplateCatalog_Catalog(t *testing.T) {
+	ctx := context.Background()
+	catalog, db := setupTemplateCatalogTest(t)
+	projectID := "project-1"
+
+	createTemplate(t, db, &models.WorkflowTemplate{ID: "tpl-build", Name: "Build", Type: models.WorkflowTypeExecution,
+		Tags: models.StringArray{"ci", "go"}, IsPublic: true, IsActive: true, ProjectID: &projectID})
+	createTemplate(t, db, &models.WorkflowTemplate{ID: "tpl-deploy", Name: "Deploy", Type: models.WorkflowTypeDeployment,
+		Tags: models.StringArray{"ci"}, IsPublic: true, IsActive: true})
+	createTemplate(t, db, &models.WorkflowTemplate{ID: "tpl-review", Name: "Review", Type: models.WorkflowTypeReview,
+		IsPublic: true, IsActive: true})
+	// Private and inactive templates are not listed
+	createTemplate(t, db, &models.WorkflowTemplate{ID: "tpl-private", Name: "Private", Type: models.WorkflowTypeExecution,
+		IsActive: true, ProjectID: &projectID})
+	createTemplate(t, db, &models.WorkflowTemplate{ID: "tpl-retired", Name: "Retired", Type: models.WorkflowTypeExecution,
+		IsPublic: true})
+
+	createTemplateWorkflow(t, db, "tpl-deploy", models.WorkflowStatusCompleted, 10*time.Minute, false)
+	createTemplateWorkflow(t, db, "tpl-deploy", models.WorkflowStatusCompleted, 10*time.Minute, false)
+	createTemplateWorkflow(t, db, "tpl-deploy", models.WorkflowStatusFailed, time.Minute, false)
+	createTemplateWorkflow(t, db, "tpl-build", models.WorkflowStatusCompleted, time.Minute, false)
+	createTemplateWorkflow(t, db, "tpl-private", models.WorkflowStatusCompleted, time.Minute, false)
+	_, err := catalog.RefreshStats(ctx)
+	require.NoError(t, err)
+
+	names := func(filter TemplateCatalogFilter) []string {
+		page, err := catalog.Catalog(ctx, filter)
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range page.Templates {
+			names = append(names, entry.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"Deploy", "Build", "Review"}, names(TemplateCatalogFilter{}))
+	assert.Equal(t, []string{"Build", "Deploy", "Review"}, names(TemplateCatalogFilter{Sort: CatalogSortSuccessRate}))
+	// Never completed templates come last
+	assert.Equal(t, []string{"Build", "Deploy", "Review"}, names(TemplateCatalogFilter{Sort: CatalogSortDuration}))
+	assert.Equal(t, []string{"Build", "Deploy", "Review"}, names(TemplateCatalogFilter{Sort: CatalogSortName}))
+	assert.Equal(t, []string{"Deploy"}, names(TemplateCatalogFilter{Type: string(models.WorkflowTypeDeployment)}))
+	assert.Equal(t, []string{"Deploy", "Build"}, names(TemplateCatalogFilter{Tags: []string{"ci"}}))
+	assert.Equal(t, []string{"Build"}, names(TemplateCatalogFilter{Tags: []string{"ci", "go"}}))
+
+	page, err := catalog.Catalog(ctx, TemplateCatalogFilter{Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), page.TotalCount)
+	require.Len(t, page.Templates, 1)
+	entry := page.Templates[0]
+	assert.Equal(t, "Build", entry.Name)
+	assert.Equal(t, int64(1), entry.Usage.WorkflowCount)
+	assert.Equal(t, int64(60000), entry.Usage.AvgDurationMs)
+
+	page, err = catalog.Catalog(ctx, TemplateCatalogFilter{Type: string(models.WorkflowTypeReview)})
+	require.NoError(t, err)
+	require.Len(t, page.Templates, 1)
+	assert.Equal(t, "tpl-review", page.Templates[0].Usage.TemplateID)
+	assert.Zero(t, page.Templates[0].Usage.WorkflowCount)
+
+	_, err = catalog.Catalog(ctx, TemplateCatalogFilter{Sort: "random"})
+	assert.ErrorIs(t, err, ErrInvalidCatalogQuery)
+	_, err = catalog.Catalog(ctx, TemplateCatalogFilter{Offset: -1})
+	assert.ErrorIs(t, err, ErrInvalidCatalogQuery)
+}
+
+func TestTemplateCatalog_CloneTemplate(t *testing.T) {
+	ctx := context.Background()
+	catalog, db := setupTemplateCatalogTest(t)
+	projectID := "project-1"
+
+	createTemplate(t, db, &models.WorkflowTemplate{ID: "tpl-build", Name: "Build", Type: models.WorkflowTypeExecution, Version: "2.1.0",
+		Steps: []byte(`[{"name": "compile"}]`), Tags: models.StringArray{"ci"}, IsPublic: true, IsActive: true, ProjectID: &projectID})
+	createTemplate(t, db, &models.WorkflowTemplate{ID: "tpl-private", Name: "Private", Type: models.WorkflowTypeExecution,
+		IsActive: true, ProjectID: &projectID})
+
+	clone, err := catalog.CloneTemplate(ctx, "tpl-build", "project-2", "", "user-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, "tpl-build", clone.ID)
+	assert.Equal(t, "Build (Payments)", clone.Name)
+
+	var stored models.WorkflowTemplate
+	require.NoError(t, db.First(&stored, "id = ?", clone.ID).Error)
+	require.NotNil(t, stored.ProjectID)
+	assert.Equal(t, "project-2", *stored.ProjectID)
+	require.NotNil(t, stored.SourceTemplateID)
+	assert.Equal(t, "tpl-build", *stored.SourceTemplateID)
+	assert.Equal(t, "2.1.0", stored.SourceVersion)
+	assert.JSONEq(t, `[{"name": "compile"}]`, string(stored.Steps))
+	assert.Equal(t, models.StringArray{"ci"}, stored.Tags)
+	assert.False(t, stored.IsPublic)
+	assert.True(t, stored.IsActive)
+	assert.Equal(t, "user-1", stored.CreatedBy)
+
+	// Template names are unique
+	_, err = catalog.CloneTemplate(ctx, "tpl-build", "project-2", "", "user-1")
+	assert.ErrorIs(t, err, ErrTemplateNameTaken)
+
+	// Private templates are only cloned within their project
+	_, err = catalog.CloneTemplate(ctx, "tpl-private", "project-2", "", "user-1")
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	clone, err = catalog.CloneTemplate(ctx, "tpl-private", "project-1", "Private v2", "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "Private v2", clone.Name)
+
+	_, err = catalog.CloneTemplate(ctx, "tpl-missing", "project-2", "", "user-1")
+	assert.ErrorIs(t, err, ErrTemplateNotFound)
+	_, err = catalog.CloneTemplate(ctx, "tpl-build", "project-missing", "", "user-1")
+	assert.ErrorIs(t, err, ErrProjectNotFound)
+}
+
+func TestNewWorkflowRecord_TemplateID(t *testing.T) {
+	req := &StartWorkflowRequest{Name: "Build", Type: string(models.WorkflowTypeExecution), ProjectID: "project-1",
+		TemplateID: "6f1c2a9e-0d4b-4e8a-9c3f-1b2d3e4f5a6b"}
+	workflow, err := newWorkflowRecord(context.Background(), req, models.WorkflowStatusPending)
+	require.NoError(t, err)
+	require.NotNil(t, workflow.TemplateID)
+	assert.Equal(t, req.TemplateID, *workflow.TemplateID)
+
+	req.TemplateID = "build"
+	_, err = newWorkflowRecord(context.Background(), req, models.WorkflowStatusPending)
+	assert.ErrorIs(t, err, ErrInvalidTemplate)
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	enumspb "go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+// timingBucketsMs are the upper bounds of the duration histograms, from
+// sub-second waits to workflows running for hours
+var timingBucketsMs = []int64{100, 1000, 5000, 30000, 60000, 300000, 900000, 3600000, 14400000}
+
+// Where the time of workflows goes: waiting to start, and running
+var (
+	workflowQueueWaitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orchestrator_workflow_queue_wait_seconds",
+		Help:    "Time workflows waited between being queued and starting",
+		Buckets: timingBucketsSeconds(),
+	}, []string{"workflow_type", "priority"})
+	workflowExecutionSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orchestrator_workflow_execution_seconds",
+		Help:    "Time workflows ran between starting and finishing",
+		Buckets: timingBucketsSeconds(),
+	}, []string{"workflow_type", "priority"})
+)
+
+func timingBucketsSeconds() []float64 {
+	buckets := make([]float64, len(timingBucketsMs))
+	for i, bound := range timingBucketsMs {
+		buckets[i] = float64(bound) / 1000
+	}
+	return buckets
+}
+
+// DurationHistogram counts durations by bucket. Counts has one entry per
+// bound, counting the durations up to it and above the previous one, and a
+// last entry counting those above the last bound.
+type DurationHistogram struct {
+	Count    int     `json:"count"`
+	TotalMs  int64   `json:"total_ms"`
+	MeanMs   int64   `json:"mean_ms"`
+	MaxMs    int64   `json:"max_ms"`
+	BoundsMs []int64 `json:"bounds_ms"`
+	Counts   []int   `json:"counts"`
+}
+
+func newDurationHistogram() DurationHistogram {
+	return DurationHistogram{BoundsMs: timingBucketsMs, Counts: make([]int, len(timingBucketsMs)+1)}
+}
+
+// observe counts a duration, in milliseconds
+func (h *DurationHistogram) observe(ms int64) {
+	h.Count++
+	h.TotalMs += ms
+	h.MeanMs = h.TotalMs / int64(h.Count)
+	h.MaxMs = max(h.MaxMs, ms)
+	bucket := len(h.BoundsMs)
+	for i, bound := range h.BoundsMs {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.Counts[bucket]++
+}
+
+// TimingBreakdown splits the time of workflows or executions into waiting in
+// queues and running
+type TimingBreakdown struct {
+	QueueWait DurationHistogram `json:"queue_wait"`
+	Execution DurationHistogram `json:"execution"`
+	// QueueShare is the fraction of the measured time spent waiting
+	QueueShare float64 `json:"queue_share"`
+}
+
+func newTimingBreakdown() TimingBreakdown {
+	return TimingBreakdown{QueueWait: newDurationHistogram(), Execution: newDurationHistogram()}
+}
+
+// observe counts the waits and run times of a workflow or execution that
+// are known: waits once it started, run times once it finished
+func (b *TimingBreakdown) observe(queuedAt, startedAt, completedAt *time.Time) {
+	if wait, ok := timeBetween(queuedAt, startedAt); ok {
+		b.QueueWait.observe(wait)
+	}
+	if run, ok := timeBetween(startedAt, completedAt); ok {
+		b.Execution.observe(run)
+	}
+	if total := b.QueueWait.TotalMs + b.Execution.TotalMs; total > 0 {
+		b.QueueShare = float64(b.QueueWait.TotalMs) / float64(total)
+	}
+}
+
+// timeBetween returns the milliseconds from one time to another when both
+// are set; clock skew between hosts never makes it negative
+func timeBetween(from, to *time.Time) (int64, bool) {
+	if from == nil || to == nil {
+		return 0, false
+	}
+	return max(to.Sub(*from).Milliseconds(), 0), true
+}
+
+// WorkflowTiming splits the time of a workflow into waiting to start and
+// running, with the same split for its executions and the activities it is
+// waiting on
+type WorkflowTiming struct {
+	QueuedAt    *time.Time `json:"queued_at,omitempty"`
+	QueueWaitMs *int64     `json:"queue_wait_ms,omitempty"` // Once started
+	ExecutionMs *int64     `json:"execution_ms,omitempty"`  // Once finished
+
+	Executions        TimingBreakdown         `json:"executions"`
+	PendingActivities []PendingActivityTiming `json:"pending_activities,omitempty"` // Of running workflows
+}
+
+// PendingActivityTiming is how long an activity of a running workflow waited
+// for a worker
+type PendingActivityTiming struct {
+	ActivityType    string     `json:"activity_type"`
+	State           string     `json:"state"`
+	Attempt         int32      `json:"attempt"`
+	ScheduledTime   *time.Time `json:"scheduled_time,omitempty"`
+	LastStartedTime *time.Time `json:"last_started_time,omitempty"`
+	QueueWaitMs     int64      `json:"queue_wait_ms"`
+}
+
+// TimingOverview splits the time of the workflows and executions started in
+// a window into waiting in queues and running
+type TimingOverview struct {
+	ProjectID  string          `json:"project_id,omitempty"`
+	Since      time.Time       `json:"since"`
+	Until      time.Time       `json:"until"`
+	Workflows  TimingBreakdown `json:"workflows"`
+	Executions TimingBreakdown `json:"executions"`
+	// ByWorkflowType splits the workflows by type
+	ByWorkflowType map[string]TimingBreakdown `json:"by_workflow_type"`
+}
+
+// GetTimingOverview splits the time of the workflows and executions, of a
+// project or all projects, started in [since, until) into waiting in queues
+// and running. Simulated runs are left out.
+func (e *WorkflowEngine) GetTimingOverview(ctx context.Context, projectID string, since, until time.Time) (*TimingOverview, error) {
+	workflowQuery := e.db.WithContext(ctx).Model(&models.Workflow{}).
+		Select("type", "queued_at", "started_at", "completed_at").
+		Where("started_at >= ? AND started_at < ? AND simulated = ?", since, until, false)
+	executionQuery := e.db.WithContext(ctx).Model(&models.Execution{}).
+		Select("queued_at", "started_at", "completed_at").
+		Where("started_at >= ? AND started_at < ? AND simulated = ?", since, until, false)
+	if projectID != "" {
+		workflowQuery = workflowQuery.Where("project_id = ?", projectID)
+		executionQuery = executionQuery.Where("project_id = ?", projectID)
+	}
+
+	var workflows []models.Workflow
+	if err := workflowQuery.Find(&workflows).Error; err != nil {
+		return nil, fmt.Errorf("failed to list workflow timings: %w", err)
+	}
+	var executions []models.Execution
+	if err := executionQuery.Find(&executions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list execution timings: %w", err)
+	}
+
+	overview := &TimingOverview{
+		ProjectID:      projectID,
+		Since:          since,
+		Until:          until,
+		Workflows:      newTimingBreakdown(),
+		Executions:     newTimingBreakdown(),
+		ByWorkflowType: make(map[string]TimingBreakdown),
+	}
+	for _, workflow := range workflows {
+		overview.Workflows.observe(workflow.QueuedAt, workflow.StartedAt, workflow.CompletedAt)
+		byType, ok := overview.ByWorkflowType[string(workflow.Type)]
+		if !ok {
+			byType = newTimingBreakdown()
+		}
+		byType.observe(workflow.QueuedAt, workflow.StartedAt, workflow.CompletedAt)
+		overview.ByWorkflowType[string(workflow.Type)] = byType
+	}
+	for _, execution := range executions {
+		overview.Executions.observe(execution.QueuedAt, execution.StartedAt, execution.CompletedAt)
+	}
+	return overview, nil
+}
+
+// workflowTiming splits the time of a workflow and of its executions into
+// waiting and running, adding the activities a running workflow waits on
+func (e *WorkflowEngine) workflowTiming(ctx context.Context, workflow *models.Workflow, executions []models.Execution) *WorkflowTiming {
+	timing := &WorkflowTiming{QueuedAt: workflow.QueuedAt, Executions: newTimingBreakdown()}
+	if wait, ok := timeBetween(workflow.QueuedAt, workflow.StartedAt); ok {
+		timing.QueueWaitMs = &wait
+	}
+	if run, ok := timeBetween(workflow.StartedAt, workflow.CompletedAt); ok {
+		timing.ExecutionMs = &run
+	}
+	for _, execution := range executions {
+		timing.Executions.observe(execution.QueuedAt, execution.StartedAt, execution.CompletedAt)
+	}
+	if workflow.Status == models.WorkflowStatusRunning && workflow.TemporalID != "" {
+		timing.PendingActivities = e.pendingActivityTimings(ctx, workflow)
+	}
+	return timing
+}
+
+// pendingActivityTimings reports how long the pending activities of a
+// running workflow waited for a worker. Failing to describe the workflow
+// only leaves them out.
+func (e *WorkflowEngine) pendingActivityTimings(ctx context.Context, workflow *models.Workflow) []PendingActivityTiming {
+	description, err := e.temporalClient.DescribeWorkflowExecution(ctx, workflow.TemporalID, workflow.TemporalRunID)
+	if err != nil {
+		e.logger.Warn("failed to describe workflow for activity timings",
+			zap.String("workflow_id", workflow.ID),
+			zap.Error(err))
+		return nil
+	}
+	now := time.Now()
+	var timings []PendingActivityTiming
+	for _, info := range description.GetPendingActivities() {
+		timings = append(timings, PendingActivityTiming{
+			ActivityType:    info.GetActivityType().GetName(),
+			State:           info.GetState().String(),
+			Attempt:         info.GetAttempt(),
+			ScheduledTime:   info.GetScheduledTime(),
+			LastStartedTime: info.GetLastStartedTime(),
+			QueueWaitMs:     activityQueueWait(info, now),
+		})
+	}
+	return timings
+}
+
+// activityQueueWait returns the milliseconds between when the current
+// attempt of an activity was scheduled and when it started, or now when no
+// worker has picked it up yet
+func activityQueueWait(info *workflowpb.PendingActivityInfo, now time.Time) int64 {
+	waitedUntil := &now
+	if info.GetState() != enumspb.PENDING_ACTIVITY_STATE_SCHEDULED && info.GetLastStartedTime() != nil {
+		waitedUntil = info.GetLastStartedTime()
+	}
+	wait, _ := timeBetween(info.GetScheduledTime(), waitedUntil)
+	return wait
+}
+
+// observeQueueWait records how long a workflow waited to start
+func observeQueueWait(workflow *models.Workflow) {
+	if wait, ok := timeBetween(workflow.QueuedAt, workflow.StartedAt); ok {
+		workflowQueueWaitSeconds.WithLabelValues(string(workflow.Type), string(workflow.Priority)).
+			Observe(float64(wait) / 1000)
+	}
+}
+
+// observeExecution records how long a finished workflow ran
+func observeExecution(workflow *models.Workflow) {
+	if run, ok := timeBetween(workflow.StartedAt, workflow.CompletedAt); ok {
+		workflowExecutionSeconds.WithLabelValues(string(workflow.Type), string(workflow.Priority)).
+			Observe(float64(run) / 1000)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/common/v1"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/mocks"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+)
+
+// newTimingTestEngine creates an engine over the workflows, steps and
+// executions tables
+func newTimingTestEngine(t *testing.T, temporalClient *mocks.Client) (*WorkflowEngine, *gorm.DB) {
+	engine, db := newDraftTestEngine(t, temporalClient)
+	for _, model := range []interface{}{&models.WorkflowStep{}, &models.Execution{}} {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DefaultValue == "gen_random_uuid()" {
+				field.HasDefaultValue = false
+				field.DefaultValue = ""
+			}
+		}
+		require.NoError(t, db.Migrator().CreateTable(model))
+	}
+	return engine, db
+}
+
+func TestTimingBreakdown_Observe(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := base.Add(d)
+		return &t
+	}
+
+	breakdown := newTimingBreakdown()
+	// Waited 2s, ran 8s
+	breakdown.observe(at(0), at(2*time.Second), at(10*time.Second))
+	// Waited 40s, still running
+	breakdown.observe(at(0), at(40*time.Second), nil)
+	// Still queued
+	breakdown.observe(at(0), nil, nil)
+	// Started before it was queued by a skewed clock: no wait
+	breakdown.observe(at(time.Second), at(0), at(2*time.Hour))
+
+	assert.Equal(t, 3, breakdown.QueueWait.Count)
+	assert.Equal(t, int64(42000), breakdown.QueueWait.TotalMs)
+	assert.Equal(t, int64(14000), breakdown.QueueWait.MeanMs)
+	assert.Equal(t, int64(40000), breakdown.QueueWait.MaxMs)
+	assert.Equal(t, []int{1, 0, 1, 0, 1, 0, 0, 0, 0, 0}, breakdown.QueueWait.Counts)
+
+	assert.Equal(t, 2, breakdown.Execution.Count)
+	assert.Equal(t, int64(7208000), breakdown.Execution.TotalMs)
+	assert.Equal(t, int64(7200000), breakdown.Execution.MaxMs)
+	assert.Equal(t, []int{0, 0, 0, 1, 0, 0, 0, 0, 1, 0}, breakdown.Execution.Counts)
+	assert.InDelta(t, 42000.0/7250000.0, breakdown.QueueShare, 1e-9)
+}
+
+func TestWorkflowEngine_StartPathsRecordQueueTimes(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-1")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(run, nil)
+
+	resp, err := engine.StartWorkflow(ctx, &StartWorkflowRequest{
+		Name:      "build",
+		Type:      string(models.WorkflowTypeExecution),
+		Priority:  string(models.WorkflowPriorityHigh),
+		ProjectID: "project-1",
+		Input:     []byte(`{"code": "print(1)"}`),
+	})
+	require.NoError(t, err)
+	var started models.Workflow
+	require.NoError(t, db.First(&started, "id = ?", resp.WorkflowID).Error)
+	require.NotNil(t, started.QueuedAt)
+	require.NotNil(t, started.StartedAt)
+	assert.False(t, started.StartedAt.Before(*started.QueuedAt))
+
+	// Drafts are not queued until submitted
+	draft, err := engine.CreateWorkflowDraft(ctx, &StartWorkflowRequest{Name: "nightly", Type: string(models.WorkflowTypeExecution)})
+	require.NoError(t, err)
+	assert.Nil(t, draft.QueuedAt)
+}
+
+func TestWorkflowEngine_GetWorkflowMetricsTiming(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newTimingTestEngine(t, temporalClient)
+
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+	wf := &models.Workflow{
+		ID:            "wf-timing",
+		Name:          "Build",
+		Type:          models.WorkflowTypeExecution,
+		Status:        models.WorkflowStatusRunning,
+		ProjectID:     "project-1",
+		TemporalID:    "temporal-timing",
+		TemporalRunID: "run-1",
+		QueuedAt:      ago(10 * time.Minute),
+		StartedAt:     ago(9 * time.Minute),
+	}
+	require.NoError(t, db.Create(wf).Error)
+	for _, execution := range []*models.Execution{
+		{ID: "exec-1", WorkflowID: wf.ID, ProjectID: "project-1", Name: "compile", Status: models.ExecutionStatusSucceeded,
+			QueuedAt: ago(9 * time.Minute), StartedAt: ago(8 * time.Minute), CompletedAt: ago(5 * time.Minute)},
+		{ID: "exec-2", WorkflowID: wf.ID, ProjectID: "project-1", Name: "test", Status: models.ExecutionStatusRunning,
+			QueuedAt: ago(5 * time.Minute), StartedAt: ago(4 * time.Minute)},
+	} {
+		require.NoError(t, db.Create(execution).Error)
+	}
+
+	describe := describeResponse("temporal-timing", "run-1", enums.WORKFLOW_EXECUTION_STATUS_RUNNING)
+	describe.PendingActivities = []*workflow.PendingActivityInfo{
+		{
+			ActivityType:    &common.ActivityType{Name: "ExecuteCodeActivity"},
+			State:           enums.PENDING_ACTIVITY_STATE_STARTED,
+			Attempt:         1,
+			ScheduledTime:   ago(4 * time.Minute),
+			LastStartedTime: ago(3*time.Minute + 30*time.Second),
+		},
+		{
+			// No worker has picked it up yet
+			ActivityType:  &common.ActivityType{Name: "StoreArtifactsActivity"},
+			State:         enums.PENDING_ACTIVITY_STATE_SCHEDULED,
+			Attempt:       1,
+			ScheduledTime: ago(time.Minute),
+		},
+	}
+	temporalClient.On("DescribeWorkflowExecution", mock.Anything, "temporal-timing", "run-1").Return(describe, nil)
+
+	metrics, err := engine.GetWorkflowMetrics(ctx, wf.ID)
+	require.NoError(t, err)
+	require.NotNil(t, metrics.Timing)
+	timing := metrics.Timing
+	require.NotNil(t, timing.QueueWaitMs)
+	assert.Equal(t, int64(60000), *timing.QueueWaitMs)
+	assert.Nil(t, timing.ExecutionMs)
+
+	assert.Equal(t, 2, timing.Executions.QueueWait.Count)
+	assert.Equal(t, int64(120000), timing.Executions.QueueWait.TotalMs)
+	assert.Equal(t, 1, timing.Executions.Execution.Count)
+	assert.Equal(t, int64(180000), timing.Executions.Execution.TotalMs)
+	assert.InDelta(t, 0.4, timing.Executions.QueueShare, 1e-9)
+
+	require.Len(t, timing.PendingActivities, 2)
+	assert.Equal(t, int64(30000), timing.PendingActivities[0].QueueWaitMs)
+	assert.GreaterOrEqual(t, timing.PendingActivities[1].QueueWaitMs, int64(60000))
+}
+
+func TestWorkflowEngine_GetTimingOverview(t *testing.T) {
+	ctx := context.Background()
+	engine, db := newTimingTestEngine(t, new(mocks.Client))
+
+	until := time.Now()
+	since := until.Add(-time.Hour)
+	ago := func(d time.Duration) *time.Time {
+		at := until.Add(-d)
+		return &at
+	}
+	for _, wf := range []*models.Workflow{
+		{ID: "wf-1", Name: "a", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusCompleted, ProjectID: "project-1",
+			QueuedAt: ago(50 * time.Minute), StartedAt: ago(49 * time.Minute), CompletedAt: ago(46 * time.Minute)},
+		{ID: "wf-2", Name: "b", Type: models.WorkflowTypeDeployment, Status: models.WorkflowStatusRunning, ProjectID: "project-1",
+			QueuedAt: ago(30 * time.Minute), StartedAt: ago(20 * time.Minute)},
+		// Simulated, another project and outside the window
+		{ID: "wf-3", Name: "c", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusCompleted, ProjectID: "project-1",
+			Simulated: true, QueuedAt: ago(10 * time.Minute), StartedAt: ago(5 * time.Minute), CompletedAt: ago(time.Minute)},
+		{ID: "wf-4", Name: "d", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusCompleted, ProjectID: "project-2",
+			QueuedAt: ago(10 * time.Minute), StartedAt: ago(9 * time.Minute), CompletedAt: ago(time.Minute)},
+		{ID: "wf-5", Name: "e", Type: models.WorkflowTypeExecution, Status: models.WorkflowStatusCompleted, ProjectID: "project-1",
+			QueuedAt: ago(3 * time.Hour), StartedAt: ago(2 * time.Hour), CompletedAt: ago(90 * time.Minute)},
+	} {
+		require.NoError(t, db.Create(wf).Error)
+	}
+	require.NoError(t, db.Create(&models.Execution{ID: "exec-1", WorkflowID: "wf-1", ProjectID: "project-1", Name: "run",
+		Status: models.ExecutionStatusSucceeded, QueuedAt: ago(49 * time.Minute), StartedAt: ago(48 * time.Minute),
+		CompletedAt: ago(47 * time.Minute)}).Error)
+
+	overview, err := engine.GetTimingOverview(ctx, "project-1", since, until)
+	require.NoError(t, err)
+	assert.Equal(t, 2, overview.Workflows.QueueWait.Count)
+	assert.Equal(t, int64(660000), overview.Workflows.QueueWait.TotalMs)
+	assert.Equal(t, 1, overview.Workflows.Execution.Count)
+	assert.Equal(t, int64(180000), overview.Workflows.Execution.TotalMs)
+	assert.InDelta(t, 660.0/840.0, overview.Workflows.QueueShare, 1e-9)
+	assert.Equal(t, int64(600000), overview.ByWorkflowType[string(models.WorkflowTypeDeployment)].QueueWait.TotalMs)
+	assert.Equal(t, 1, overview.ByWorkflowType[string(models.WorkflowTypeExecution)].Execution.Count)
+	assert.Equal(t, 1, overview.Executions.QueueWait.Count)
+	assert.InDelta(t, 0.5, overview.Executions.QueueShare, 1e-9)
+
+	// Every project
+	overview, err = engine.GetTimingOverview(ctx, "", since, until)
+	require.NoError(t, err)
+	assert.Equal(t, 3, overview.Workflows.QueueWait.Count)
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"orchestrator/internal/models"
+)
+
+// ErrUnknownWorkflowType is returned for workflow types no workflow is
+// registered for
+var ErrUnknownWorkflowType = errors.New("unknown workflow type")
+
+// ErrInvalidWorkflowInput is returned for workflow inputs that do not decode
+// into the input of their workflow type
+var ErrInvalidWorkflowInput = errors.New("invalid workflow input")
+
+// WorkflowTypeSpec is what the engine needs to start workflows of a type.
+// The temporal package registers a spec per workflow type along with the
+// workflow function that runs it.
+type WorkflowTypeSpec struct {
+	Type     models.WorkflowType
+	Workflow string // Name the workflow is registered under with the worker
+
+	// Input returns a value of the type the workflow decodes its input
+	// into; inputs that do not decode into it are rejected. Nil accepts any
+	// JSON input.
+	Input func() interface{}
+
+	// DefaultConfig is the config of workflows whose request sets none
+	DefaultConfig json.RawMessage
+
+	// TaskQueue is the queue the workflows are started on, the engine's
+	// task queue when empty. A worker must poll it.
+	TaskQueue string
+
+	// AgentDependent workflows run tasks on agents, so they are not started
+	// while the agent manager's API version is incompatible
+	AgentDependent bool
+}
+
+// WorkflowTypeRegistry is the workflow types the engine can start
+type WorkflowTypeRegistry struct {
+	mu    sync.RWMutex
+	specs map[models.WorkflowType]WorkflowTypeSpec
+}
+
+// NewWorkflowTypeRegistry creates an empty registry
+func NewWorkflowTypeRegistry() *WorkflowTypeRegistry {
+	return &WorkflowTypeRegistry{specs: make(map[models.WorkflowType]WorkflowTypeSpec)}
+}
+
+// Register adds a workflow type. Each type is registered once.
+func (r *WorkflowTypeRegistry) Register(spec WorkflowTypeSpec) error {
+	if spec.Type == "" || spec.Workflow == "" {
+		return errors.New("workflow types need a type and a workflow name")
+	}
+	if len(spec.DefaultConfig) > 0 && !json.Valid(spec.DefaultConfig) {
+		return fmt.Errorf("default config of workflow type %q is not valid JSON", spec.Type)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.specs[spec.Type]; exists {
+		return fmt.Errorf("workflow type %q is already registered", spec.Type)
+	}
+	r.specs[spec.Type] = spec
+	return nil
+}
+
+// Lookup returns the spec of a workflow type. A nil registry has no types.
+func (r *WorkflowTypeRegistry) Lookup(workflowType models.WorkflowType) (WorkflowTypeSpec, bool) {
+	if r == nil {
+		return WorkflowTypeSpec{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	spec, ok := r.specs[workflowType]
+	return spec, ok
+}
+
+// Types returns the registered workflow types in order
+func (r *WorkflowTypeRegistry) Types() []models.WorkflowType {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]models.WorkflowType, 0, len(r.specs))
+	for workflowType := range r.specs {
+		types = append(types, workflowType)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Resolve returns the spec of a workflow type, or ErrUnknownWorkflowType
+// listing the registered types
+func (r *WorkflowTypeRegistry) Resolve(workflowType models.WorkflowType) (WorkflowTypeSpec, error) {
+	if spec, ok := r.Lookup(workflowType); ok {
+		return spec, nil
+	}
+	names := make([]string, 0)
+	for _, registered := range r.Types() {
+		names = append(names, string(registered))
+	}
+	return WorkflowTypeSpec{}, fmt.Errorf("%w %q, registered types: %s",
+		ErrUnknownWorkflowType, workflowType, strings.Join(names, ", "))
+}
+
+// ValidateInput checks that an input decodes into the input of the workflow
+// type. Empty inputs are left to the callers, as drafts may not have one yet.
+func (s WorkflowTypeSpec) ValidateInput(input json.RawMessage) error {
+	if s.Input == nil || len(bytes.TrimSpace(input)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(input, s.Input()); err != nil {
+		return fmt.Errorf("%w for workflow type %q: %v", ErrInvalidWorkflowInput, s.Type, err)
+	}
+	return nil
+}
+
+// SetWorkflowTypes sets the workflow types the engine can start
+func (e *WorkflowEngine) SetWorkflowTypes(registry *WorkflowTypeRegistry) {
+	e.workflowTypes = registry
+}
+
+// resolveRequest resolves the workflow type of a request and validates its
+// input, filling in the type's default config when the request sets none
+func (e *WorkflowEngine) resolveRequest(req *StartWorkflowRequest) (WorkflowTypeSpec, error) {
+	spec, err := e.workflowTypes.Resolve(models.WorkflowType(req.Type))
+	if err != nil {
+		return WorkflowTypeSpec{}, err
+	}
+	if err := spec.ValidateInput(req.Input); err != nil {
+		return WorkflowTypeSpec{}, err
+	}
+	if len(bytes.TrimSpace(req.Config)) == 0 && len(spec.DefaultConfig) > 0 {
+		req.Config = append(json.RawMessage(nil), spec.DefaultConfig...)
+	}
+	return spec, nil
+}
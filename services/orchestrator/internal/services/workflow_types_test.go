@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/mocks"
+
+	"orchestrator/internal/models"
+)
+
+// testWorkflowTypes registers the built-in workflow types as the temporal
+// package does, without their inputs, which live in that package
+func testWorkflowTypes(t *testing.T) *WorkflowTypeRegistry {
+	registry := NewWorkflowTypeRegistry()
+	for _, spec := range []WorkflowTypeSpec{
+		{Type: models.WorkflowTypeIntent, Workflow: "IntentProcessingWorkflow", AgentDependent: true},
+		{Type: models.WorkflowTypeExecution, Workflow: "CodeExecutionWorkflow", AgentDependent: true},
+		{Type: models.WorkflowTypeAnalysis, Workflow: "CodeAnalysisWorkflow"},
+		{Type: models.WorkflowTypeReview, Workflow: "CodeReviewWorkflow"},
+		{Type: models.WorkflowTypeDeployment, Workflow: "DeploymentWorkflow"},
+		{Type: models.WorkflowTypeTaskExecution, Workflow: "TaskExecutionWorkflow", AgentDependent: true},
+		{Type: models.WorkflowTypeCustom, Workflow: "CustomWorkflow"},
+		{Type: models.WorkflowTypePromotion, Workflow: "PromoteEnvironmentWorkflow", Input: func() interface{} { return &PromotionInput{} }},
+	} {
+		require.NoError(t, registry.Register(spec))
+	}
+	return registry
+}
+
+func TestWorkflowTypeRegistry_Register(t *testing.T) {
+	registry := NewWorkflowTypeRegistry()
+	require.NoError(t, registry.Register(WorkflowTypeSpec{Type: "noop", Workflow: "NoopWorkflow"}))
+
+	assert.Error(t, registry.Register(WorkflowTypeSpec{Type: "noop", Workflow: "OtherWorkflow"}), "types are registered once")
+	assert.Error(t, registry.Register(WorkflowTypeSpec{Type: "unnamed"}))
+	assert.Error(t, registry.Register(WorkflowTypeSpec{Workflow: "UntypedWorkflow"}))
+	assert.Error(t, registry.Register(WorkflowTypeSpec{Type: "broken", Workflow: "BrokenWorkflow", DefaultConfig: json.RawMessage(`{`)}))
+	assert.Equal(t, []models.WorkflowType{"noop"}, registry.Types())
+
+	_, ok := (*WorkflowTypeRegistry)(nil).Lookup("noop")
+	assert.False(t, ok)
+}
+
+func TestWorkflowEngine_StartWorkflowRejectsUnregisteredTypes(t *testing.T) {
+	temporalClient := new(mocks.Client)
+	engine, _ := newDraftTestEngine(t, temporalClient)
+
+	_, err := engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+		Name: "build", Type: "nightly_build", ProjectID: "project-1", UserID: "alice",
+	})
+	assert.ErrorIs(t, err, ErrUnknownWorkflowType)
+	assert.EqualError(t, err, `unknown workflow type "nightly_build", registered types: `+
+		"code_analysis, code_execution, code_review, custom, deployment, environment_promotion, intent_processing, task_execution")
+
+	_, err = engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+		Name: "promote", Type: string(models.WorkflowTypePromotion), ProjectID: "project-1", UserID: "alice",
+		Input: json.RawMessage(`{"source": 42}`),
+	})
+	assert.ErrorIs(t, err, ErrInvalidWorkflowInput)
+
+	engine.SetWorkflowTypes(nil)
+	_, err = engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+		Name: "build", Type: "intent_processing", ProjectID: "project-1", UserID: "alice",
+	})
+	assert.ErrorIs(t, err, ErrUnknownWorkflowType, "engines without a registry start nothing")
+	temporalClient.AssertNotCalled(t, "ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestWorkflowEngine_StartWorkflowOfRegisteredType(t *testing.T) {
+	ctx := context.Background()
+	temporalClient := new(mocks.Client)
+	engine, db := newDraftTestEngine(t, temporalClient)
+
+	// A type the engine knows nothing about, registered like any other
+	require.NoError(t, engine.workflowTypes.Register(WorkflowTypeSpec{
+		Type:          "noop",
+		Workflow:      "NoopWorkflow",
+		DefaultConfig: json.RawMessage(`{"max_parallel": 1}`),
+		TaskQueue:     "noop-queue",
+	}))
+
+	run := new(mocks.WorkflowRun)
+	run.On("GetID").Return("temporal-id")
+	run.On("GetRunID").Return("run-1")
+	temporalClient.On("ExecuteWorkflow", mock.Anything,
+		mock.MatchedBy(func(opts client.StartWorkflowOptions) bool { return opts.TaskQueue == "noop-queue" }),
+		"NoopWorkflow", mock.Anything).Return(run, nil).Once()
+
+	response, err := engine.StartWorkflow(ctx, &StartWorkflowRequest{
+		Name: "noop", Type: "noop", ProjectID: "project-1", UserID: "alice",
+	})
+	require.NoError(t, err)
+	temporalClient.AssertExpectations(t)
+
+	var workflow models.Workflow
+	require.NoError(t, db.First(&workflow, "id = ?", response.WorkflowID).Error)
+	assert.Equal(t, models.WorkflowType("noop"), workflow.Type)
+	assert.JSONEq(t, `{"max_parallel": 1, "schema_version": 2}`, string(workflow.Config), "the type's default config applies")
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/converter"
+	"go.uber.org/zap"
+)
+
+// Custom search attributes set on started workflows. Temporal reserves
+// WorkflowType for the workflow function name, so our workflow type is
+// indexed as OrchestratorWorkflowType.
+const (
+	SearchAttributeProjectID    = "ProjectId"
+	SearchAttributeWorkflowType = "OrchestratorWorkflowType"
+	SearchAttributePriority     = "Priority"
+	SearchAttributeCreatedBy    = "CreatedBy"
+	SearchAttributeTags         = "Tags"
+)
+
+// SearchAttributeTypes lists the custom search attributes and their types, as
+// registered on the Temporal namespace
+var SearchAttributeTypes = map[string]enumspb.IndexedValueType{
+	SearchAttributeProjectID:    enumspb.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeWorkflowType: enumspb.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributePriority:     enumspb.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeCreatedBy:    enumspb.INDEXED_VALUE_TYPE_KEYWORD,
+	SearchAttributeTags:         enumspb.INDEXED_VALUE_TYPE_KEYWORD_LIST,
+}
+
+const (
+	maxVisibilityQueryLength = 1000
+	visibilityPageSize       = 100
+)
+
+// ErrInvalidVisibilityQuery is returned when a Temporal visibility query is
+// rejected by validation
+var ErrInvalidVisibilityQuery = errors.New("invalid visibility query")
+
+// orderByPattern matches ORDER BY clauses, which cannot be nested inside the
+// task queue scope added to every query
+var orderByPattern = regexp.MustCompile(`(?i)\border\s+by\b`)
+
+// TemporalWorkflowExecution is a workflow execution as reported by Temporal
+// visibility
+type TemporalWorkflowExecution struct {
+	WorkflowID       string                 `json:"workflow_id"`
+	RunID            string                 `json:"run_id"`
+	WorkflowType     string                 `json:"workflow_type"`
+	Status           string                 `json:"status"`
+	StartTime        *time.Time             `json:"start_time,omitempty"`
+	CloseTime        *time.Time             `json:"close_time,omitempty"`
+	SearchAttributes map[string]interface{} `json:"search_attributes,omitempty"`
+}
+
+// ListWorkflowsFromTemporal runs a Temporal visibility query, e.g.
+// `ProjectId = 'p1' AND ExecutionStatus = 'Running'`, and returns up to one
+// page of matching executions. The query is validated and scoped to the
+// engine's task queue so only orchestrator workflows are visible.
+func (e *WorkflowEngine) ListWorkflowsFromTemporal(ctx context.Context, query string) ([]TemporalWorkflowExecution, error) {
+	scoped, err := scopeVisibilityQuery(query, e.config.TaskQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.temporalClient.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+		PageSize: visibilityPageSize,
+		Query:    scoped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workflows from Temporal: %w", err)
+	}
+
+	executions := make([]TemporalWorkflowExecution, 0, len(resp.GetExecutions()))
+	for _, info := range resp.GetExecutions() {
+		execution := TemporalWorkflowExecution{
+			WorkflowID:   info.GetExecution().GetWorkflowId(),
+			RunID:        info.GetExecution().GetRunId(),
+			WorkflowType: info.GetType().GetName(),
+			Status:       info.GetStatus().String(),
+			StartTime:    info.GetStartTime(),
+			CloseTime:    info.GetCloseTime(),
+		}
+
+		fields := info.GetSearchAttributes().GetIndexedFields()
+		if len(fields) > 0 {
+			execution.SearchAttributes = make(map[string]interface{}, len(fields))
+			for name, payload := range fields {
+				var value interface{}
+				if err := converter.GetDefaultDataConverter().FromPayload(payload, &value); err != nil {
+					e.logger.Warn("failed to decode search attribute",
+						zap.String("workflow_id", execution.WorkflowID),
+						zap.String("attribute", name),
+						zap.Error(err),
+					)
+					continue
+				}
+				execution.SearchAttributes[name] = value
+			}
+		}
+		executions = append(executions, execution)
+	}
+
+	return executions, nil
+}
+
+// scopeVisibilityQuery validates a visibility query and restricts it to the
+// given task queue. Statement separators, comments, control characters,
+// unbalanced quotes or parentheses, backslashes outside of quoted values and
+// ORDER BY clauses are rejected.
+func scopeVisibilityQuery(query, taskQueue string) (string, error) {
+	query = strings.TrimSpace(query)
+	if len(query) > maxVisibilityQueryLength {
+		return "", fmt.Errorf("%w: query is longer than %d characters", ErrInvalidVisibilityQuery, maxVisibilityQueryLength)
+	}
+	for _, r := range query {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("%w: query contains control characters", ErrInvalidVisibilityQuery)
+		}
+	}
+	for _, token := range []string{";", "--", "/*", "*/"} {
+		if strings.Contains(query, token) {
+			return "", fmt.Errorf("%w: query must not contain %q", ErrInvalidVisibilityQuery, token)
+		}
+	}
+	if err := checkVisibilityQueryBalanced(query); err != nil {
+		return "", err
+	}
+	if orderByPattern.MatchString(query) {
+		return "", fmt.Errorf("%w: ORDER BY is not supported", ErrInvalidVisibilityQuery)
+	}
+
+	scope := fmt.Sprintf("TaskQueue = '%s'", strings.NewReplacer("'", "", `\`, "").Replace(taskQueue))
+	if query == "" {
+		return scope, nil
+	}
+	return fmt.Sprintf("%s AND (%s)", scope, query), nil
+}
+
+// checkVisibilityQueryBalanced rejects queries whose quotes or parentheses are
+// unbalanced, which could otherwise escape the task queue scope. Quoted values
+// are scanned as the visibility parser reads them: a backslash escapes the
+// next character, so \' does not close a value.
+func checkVisibilityQueryBalanced(query string) error {
+	var quote rune
+	escaped := false
+	depth := 0
+	for _, r := range query {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == '\\' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '\\':
+			return fmt.Errorf("%w: backslashes are only allowed in quoted values", ErrInvalidVisibilityQuery)
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("%w: query has unbalanced parentheses", ErrInvalidVisibilityQuery)
+			}
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("%w: query has unbalanced quotes", ErrInvalidVisibilityQuery)
+	}
+	if depth != 0 {
+		return fmt.Errorf("%w: query has unbalanced parentheses", ErrInvalidVisibilityQuery)
+	}
+	return nil
+}
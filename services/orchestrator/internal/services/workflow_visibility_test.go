@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/mocks"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func TestWorkflowEngine_StartWorkflowSetsSearchAttributes(t *testing.T) {
+	db := setupWorkflowTestDB(t)
+	// Unreachable Redis: cache writes and event publishing only log errors
+	redisClient := redis.NewClient(&redis.Options{Addr: "localhost:0", MaxRetries: -1})
+
+	var options client.StartWorkflowOptions
+	mockWorkflowRun := new(mocks.WorkflowRun)
+	mockWorkflowRun.On("GetID").Return("temporal-id")
+	mockWorkflowRun.On("GetRunID").Return("run-id")
+	mockTemporalClient := new(mocks.Client)
+	mockTemporalClient.On("ExecuteWorkflow", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { options = args.Get(1).(client.StartWorkflowOptions) }).
+		Return(mockWorkflowRun, nil)
+
+	engine := NewWorkflowEngine(db, redisClient, mockTemporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{
+		TaskQueue:              "test-queue",
+		EnableSearchAttributes: true,
+	})
+	engine.SetWorkflowTypes(testWorkflowTypes(t))
+
+	_, err := engine.StartWorkflow(context.Background(), &StartWorkflowRequest{
+		Name:      "Searchable Workflow",
+		Type:      string(models.WorkflowTypeTaskExecution),
+		Priority:  string(models.WorkflowPriorityHigh),
+		ProjectID: "project-1",
+		UserID:    "user-1",
+		Tags:      []string{"team:payments"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		SearchAttributeProjectID:    "project-1",
+		SearchAttributeWorkflowType: string(models.WorkflowTypeTaskExecution),
+		SearchAttributePriority:     string(models.WorkflowPriorityHigh),
+		SearchAttributeCreatedBy:    "user-1",
+		SearchAttributeTags:         []string{"team:payments"},
+	}, options.SearchAttributes)
+	for name := range options.SearchAttributes {
+		assert.Contains(t, SearchAttributeTypes, name)
+	}
+}
+
+func TestScopeVisibilityQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{"empty", "  ", "TaskQueue = 'q'", false},
+		{"simple", "ProjectId = 'p1'", "TaskQueue = 'q' AND (ProjectId = 'p1')", false},
+		{"quoted parenthesis", "CreatedBy = 'a)b'", "TaskQueue = 'q' AND (CreatedBy = 'a)b')", false},
+		{"escape scope", "ProjectId = 'p1') OR (TaskQueue = 'other'", "", true},
+		{"unbalanced quote", "ProjectId = 'p1", "", true},
+		{"escaped quote", `CreatedBy = 'o\'brien'`, `TaskQueue = 'q' AND (CreatedBy = 'o\'brien')`, false},
+		{"escaped backslash", `CreatedBy = 'a\\'`, `TaskQueue = 'q' AND (CreatedBy = 'a\\')`, false},
+		{"escape scope with escaped quotes", `CreatedBy = 'a\' AND ProjectId = ') OR (TaskQueue = 'other' OR CreatedBy = 'b\'c'`, "", true},
+		{"escape scope with trailing backslash", `ProjectId = 'p1' OR ProjectId = 'p2\'`, "", true},
+		{"backslash outside quotes", `ProjectId = \'p1'`, "", true},
+		{"statement separator", "ProjectId = 'p1'; DROP", "", true},
+		{"comment", "ProjectId = 'p1' -- x", "", true},
+		{"control character", "ProjectId = 'p1'\nOR 1=1", "", true},
+		{"order by", "ProjectId = 'p1' ORDER BY StartTime", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scopeVisibilityQuery(tt.query, "q")
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidVisibilityQuery)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWorkflowEngine_ListWorkflowsFromTemporal(t *testing.T) {
+	projectID, err := converter.GetDefaultDataConverter().ToPayload("project-1")
+	require.NoError(t, err)
+	startTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	mockTemporalClient := new(mocks.Client)
+	mockTemporalClient.On("ListWorkflow", mock.Anything, mock.MatchedBy(func(req *workflowservice.ListWorkflowExecutionsRequest) bool {
+		return req.Query == "TaskQueue = 'test-queue' AND (ProjectId = 'project-1')"
+	})).Return(&workflowservice.ListWorkflowExecutionsResponse{
+		Executions: []*workflowpb.WorkflowExecutionInfo{{
+			Execution: &commonpb.WorkflowExecution{WorkflowId: "wf-1", RunId: "run-1"},
+			Type:      &commonpb.WorkflowType{Name: "TaskExecutionWorkflow"},
+			Status:    enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING,
+			StartTime: &startTime,
+			SearchAttributes: &commonpb.SearchAttributes{
+				IndexedFields: map[string]*commonpb.Payload{SearchAttributeProjectID: projectID},
+			},
+		}},
+	}, nil)
+
+	engine := NewWorkflowEngine(nil, nil, mockTemporalClient, zap.NewNop(), nil, nil, &WorkflowConfig{TaskQueue: "test-queue"})
+
+	executions, err := engine.ListWorkflowsFromTemporal(context.Background(), " ProjectId = 'project-1' ")
+	require.NoError(t, err)
+	require.Len(t, executions, 1)
+	assert.Equal(t, "wf-1", executions[0].WorkflowID)
+	assert.Equal(t, "TaskExecutionWorkflow", executions[0].WorkflowType)
+	assert.Equal(t, startTime, *executions[0].StartTime)
+	assert.Equal(t, "project-1", executions[0].SearchAttributes[SearchAttributeProjectID])
+
+	// Rejected queries never reach Temporal
+	_, err = engine.ListWorkflowsFromTemporal(context.Background(), "ProjectId = 'x') OR (1 = 1")
+	assert.ErrorIs(t, err, ErrInvalidVisibilityQuery)
+	mockTemporalClient.AssertNumberOfCalls(t, "ListWorkflow", 1)
+}
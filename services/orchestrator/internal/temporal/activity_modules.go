@@ -0,0 +1,164 @@
+package temporal
+
+import (
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/worker"
+)
+
+// registeredActivity is an activity function and the name it is registered
+// and executed under
+type registeredActivity struct {
+	name string
+	fn   interface{}
+}
+
+// activityModule is the activities of a feature, registered together
+type activityModule struct {
+	name       string
+	activities []registeredActivity
+}
+
+// Register registers the activities of the module under their names
+func (m *activityModule) Register(w worker.ActivityRegistry) {
+	for _, a := range m.activities {
+		w.RegisterActivityWithOptions(a.fn, activity.RegisterOptions{Name: a.name})
+	}
+}
+
+// Names returns the names of the activities of the module
+func (m *activityModule) Names() []string {
+	names := make([]string, len(m.activities))
+	for i, a := range m.activities {
+		names[i] = a.name
+	}
+	return names
+}
+
+// intentModule is intent processing, including the placeholder resolution
+// that starts workflows with placeholders and the checkpoints intents resume
+// from
+func intentModule(a *Activities) *activityModule {
+	return &activityModule{name: "intent", activities: []registeredActivity{
+		{ActivityResolvePlaceholders, a.ResolvePlaceholdersActivity},
+		{ActivityAnalyzeIntent, a.AnalyzeIntentActivity},
+		{ActivityRecordRiskConfirmation, a.RecordRiskConfirmationActivity},
+		{ActivityCreateExecutionPlan, a.CreateExecutionPlanActivity},
+		{ActivityLoadCheckpoint, a.LoadCheckpointActivity},
+		{ActivityExecuteStep, a.ExecuteStepActivity},
+		{ActivityRecordWorkflowStep, a.RecordWorkflowStepActivity},
+		{ActivityAggregateResults, a.AggregateResultsActivity},
+	}}
+}
+
+// codeExecutionModule is code execution, including matrix runs
+func codeExecutionModule(a *Activities) *activityModule {
+	return &activityModule{name: "code-execution", activities: []registeredActivity{
+		{ActivitySelectAgent, a.SelectAgentActivity},
+		{ActivityPrepareEnvironment, a.PrepareEnvironmentActivity},
+		{ActivityExecuteCode, a.ExecuteCodeActivity},
+		{ActivityProcessResults, a.ProcessResultsActivity},
+		{ActivityCleanupEnvironment, a.CleanupEnvironmentActivity},
+		{ActivityRecordMatrixRun, a.RecordMatrixRunActivity},
+		{ActivitySummarizeCodeMatrix, a.SummarizeCodeMatrixActivity},
+	}}
+}
+
+// analysisModule is code analysis and its report cache
+func analysisModule(a *Activities) *activityModule {
+	return &activityModule{name: "analysis", activities: []registeredActivity{
+		{ActivityFetchCode, a.FetchCodeActivity},
+		{ActivityLookupAnalysisCache, a.LookupAnalysisCacheActivity},
+		{ActivityStoreAnalysisCache, a.StoreAnalysisCacheActivity},
+		{ActivityRunStaticAnalysis, a.RunStaticAnalysisActivity},
+		{ActivityRunSecurityAnalysis, a.RunSecurityAnalysisActivity},
+		{ActivityRunPerformanceAnalysis, a.RunPerformanceAnalysisActivity},
+		{ActivityGenerateAnalysisReport, a.GenerateAnalysisReportActivity},
+	}}
+}
+
+// reviewModule is code review of pull requests
+func reviewModule(a *Activities) *activityModule {
+	return &activityModule{name: "review", activities: []registeredActivity{
+		{ActivityFetchCodeChanges, a.FetchCodeChangesActivity},
+		{ActivityRunAutomatedChecks, RunAutomatedChecksActivity},
+		{ActivityRunAIReview, a.RunAIReviewActivity},
+		{ActivityGenerateReviewSummary, GenerateReviewSummaryActivity},
+		{ActivityPostReviewComments, a.PostReviewCommentsActivity},
+	}}
+}
+
+// deploymentModule is deployments and the custom workflow steps
+func deploymentModule() *activityModule {
+	return &activityModule{name: "deployment", activities: []registeredActivity{
+		{ActivityValidateDeployment, ValidateDeploymentActivity},
+		{ActivityBuildArtifacts, BuildArtifactsActivity},
+		{ActivityRunDeploymentTests, RunDeploymentTestsActivity},
+		{ActivityDeployToStaging, DeployToStagingActivity},
+		{ActivityRunSmokeTests, RunSmokeTestsActivity},
+		{ActivityDeployToProduction, DeployToProductionActivity},
+		{ActivityRunHealthCheck, RunHealthCheckActivity},
+		{ActivityRollbackDeployment, RollbackDeploymentActivity},
+		{ActivityUpdateDeploymentStatus, UpdateDeploymentStatusActivity},
+		{ActivityExecuteCustomStep, ExecuteCustomStepActivity},
+	}}
+}
+
+// promotionModule is the promotion of environments and the deployments
+// promotions chain
+func promotionModule(a *Activities) *activityModule {
+	return &activityModule{name: "promotion", activities: []registeredActivity{
+		{ActivityApplyPromotion, a.ApplyPromotionActivity},
+		{ActivityRecordPromotionSnapshot, a.RecordPromotionSnapshotActivity},
+		{ActivityCreatePromotionDeployment, a.CreatePromotionDeploymentActivity},
+		{ActivityMarkPromotionDeploymentStarted, a.MarkPromotionDeploymentStartedActivity},
+	}}
+}
+
+// taskExecutionModule is task execution without the meta-agent, batches of
+// tasks, iterative refinement and the storage of task artifacts
+func taskExecutionModule(a *Activities) *activityModule {
+	return &activityModule{name: "task-execution", activities: []registeredActivity{
+		{ActivityFindOrCreateAgentForTask, a.FindOrCreateAgentForTaskActivity},
+		{ActivityExecuteTaskWithAgent, a.ExecuteTaskWithAgentActivity},
+		{ActivityAggregateTaskResults, a.AggregateTaskResultsActivity},
+		{ActivityStoreArtifacts, a.StoreArtifactsActivity},
+		{ActivityExecuteBatch, a.ExecuteBatchActivity},
+		{ActivityIterativeTask, a.IterativeTaskActivity},
+	}}
+}
+
+// metaAgentModule is task execution by the meta-agent
+func metaAgentModule(a *MetaAgentActivities) *activityModule {
+	return &activityModule{name: "meta-agent", activities: []registeredActivity{
+		{ActivityMetaAgentFindOrCreateAgentForTask, a.FindOrCreateAgentForTaskActivity},
+		{ActivityMetaAgentExecuteTaskWithAgent, a.ExecuteTaskWithAgentActivity},
+		{ActivityMetaAgentOptimizeAgentPerformance, a.OptimizeAgentPerformanceActivity},
+	}}
+}
+
+// resourceModule is the provisioning and termination of resources
+func resourceModule(a *ResourceActivities) *activityModule {
+	return &activityModule{name: "resources", activities: []registeredActivity{
+		{ActivityProvisionResource, a.ProvisionResourceActivity},
+		{ActivityCheckResource, a.CheckResourceActivity},
+		{ActivityMarkResourceReady, a.MarkResourceReadyActivity},
+		{ActivityTerminateResource, a.TerminateResourceActivity},
+		{ActivityMarkResourceTerminated, a.MarkResourceTerminatedActivity},
+		{ActivityMarkResourceFailed, a.MarkResourceFailedActivity},
+	}}
+}
+
+// activityModules returns the modules of every activity of the worker
+func activityModules(activities *Activities, metaAgentActivities *MetaAgentActivities, resourceActivities *ResourceActivities) []*activityModule {
+	return []*activityModule{
+		intentModule(activities),
+		codeExecutionModule(activities),
+		analysisModule(activities),
+		reviewModule(activities),
+		deploymentModule(),
+		promotionModule(activities),
+		taskExecutionModule(activities),
+		metaAgentModule(metaAgentActivities),
+		resourceModule(resourceActivities),
+	}
+}
@@ -0,0 +1,202 @@
+package temporal
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+)
+
+// recordingRegistry records the names activities are registered under
+type recordingRegistry struct {
+	names []string
+}
+
+func (r *recordingRegistry) RegisterActivity(a interface{}) {
+	panic("activities are registered under their name constant")
+}
+
+func (r *recordingRegistry) RegisterActivityWithOptions(a interface{}, options activity.RegisterOptions) {
+	r.names = append(r.names, options.Name)
+}
+
+func registeredActivityNames(t *testing.T) map[string]string {
+	registered := make(map[string]string)
+	for _, module := range activityModules(&Activities{}, &MetaAgentActivities{}, &ResourceActivities{}) {
+		registry := &recordingRegistry{}
+		module.Register(registry)
+		assert.Equal(t, module.Names(), registry.names, module.name)
+		for _, name := range module.Names() {
+			if other, ok := registered[name]; ok {
+				t.Errorf("activity %s is registered by both the %s and %s modules", name, other, module.name)
+			}
+			registered[name] = module.name
+		}
+	}
+	return registered
+}
+
+// parsePackage parses the non-test files of the package
+func parsePackage(t *testing.T) (*token.FileSet, []*ast.File) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+	pkg, ok := packages["temporal"]
+	require.True(t, ok)
+	var files []*ast.File
+	for _, file := range pkg.Files {
+		files = append(files, file)
+	}
+	return fset, files
+}
+
+// stringConstants returns the values of the string constants of the files
+func stringConstants(files []*ast.File) map[string]string {
+	constants := make(map[string]string)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				value := spec.(*ast.ValueSpec)
+				for i, name := range value.Names {
+					if i >= len(value.Values) {
+						continue
+					}
+					if lit, ok := value.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+						constants[name.Name], _ = strconv.Unquote(lit.Value)
+					}
+				}
+			}
+		}
+	}
+	return constants
+}
+
+// assignedValues returns the expressions assigned to a variable in a function
+func assignedValues(fn *ast.FuncDecl, variable string) []ast.Expr {
+	var values []ast.Expr
+	ast.Inspect(fn, func(node ast.Node) bool {
+		assign, ok := node.(*ast.AssignStmt)
+		if !ok || len(assign.Lhs) != len(assign.Rhs) {
+			return true
+		}
+		for i, lhs := range assign.Lhs {
+			if ident, ok := lhs.(*ast.Ident); ok && ident.Name == variable {
+				values = append(values, assign.Rhs[i])
+			}
+		}
+		return true
+	})
+	return values
+}
+
+// executedActivities returns the names of the activities each call of
+// workflow.ExecuteActivity of the files can execute, by the position of the
+// call. Calls that do not name activities with constants, directly or
+// through variables only assigned constants, fail the test.
+func executedActivities(t *testing.T, fset *token.FileSet, files []*ast.File) map[string][]string {
+	constants := stringConstants(files)
+	constant := func(expr ast.Expr) (string, bool) {
+		ident, ok := expr.(*ast.Ident)
+		if !ok {
+			return "", false
+		}
+		value, ok := constants[ident.Name]
+		return value, ok
+	}
+
+	executed := make(map[string][]string)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			ast.Inspect(fn, func(node ast.Node) bool {
+				call, ok := node.(*ast.CallExpr)
+				if !ok || len(call.Args) < 2 {
+					return true
+				}
+				selector, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok || selector.Sel.Name != "ExecuteActivity" {
+					return true
+				}
+				if pkg, ok := selector.X.(*ast.Ident); !ok || pkg.Name != "workflow" {
+					return true
+				}
+
+				position := fset.Position(call.Pos()).String()
+				if name, ok := constant(call.Args[1]); ok {
+					executed[position] = append(executed[position], name)
+					return true
+				}
+				variable, ok := call.Args[1].(*ast.Ident)
+				if !ok {
+					t.Errorf("%s: activity is not executed by name constant", position)
+					return true
+				}
+				values := assignedValues(fn, variable.Name)
+				if len(values) == 0 {
+					t.Errorf("%s: activity name %s is not assigned a name constant", position, variable.Name)
+				}
+				for _, value := range values {
+					name, ok := constant(value)
+					if !ok {
+						t.Errorf("%s: activity name %s is assigned something else than a name constant", position, variable.Name)
+						continue
+					}
+					executed[position] = append(executed[position], name)
+				}
+				return true
+			})
+		}
+	}
+	return executed
+}
+
+func TestActivityModules_RegisterEveryExecutedActivity(t *testing.T) {
+	registered := registeredActivityNames(t)
+	fset, files := parsePackage(t)
+	executed := executedActivities(t, fset, files)
+	require.NotEmpty(t, executed)
+
+	for position, names := range executed {
+		for _, name := range names {
+			_, ok := registered[name]
+			assert.True(t, ok, "%s: activity %s is executed but not registered", position, name)
+		}
+	}
+}
+
+func TestActivityModules_NamesAreConstants(t *testing.T) {
+	registered := registeredActivityNames(t)
+	_, files := parsePackage(t)
+	constants := stringConstants(files)
+
+	// Every registered name has its constant, and the other way around
+	names := make(map[string]bool)
+	for name, value := range constants {
+		if strings.HasPrefix(name, "Activity") {
+			names[value] = true
+		}
+	}
+	for name := range registered {
+		assert.True(t, names[name], "activity %s has no name constant", name)
+	}
+	for name := range names {
+		_, ok := registered[name]
+		assert.True(t, ok, "activity %s has a name constant but is not registered", name)
+	}
+}
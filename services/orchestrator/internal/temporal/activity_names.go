@@ -0,0 +1,85 @@
+package temporal
+
+// Names of the activities, used both to register them and to execute them
+// from workflows, so that an activity executed by name is one that is
+// registered. Executing an activity by anything but one of these constants
+// fails TestActivityModules_RegisterEveryExecutedActivity.
+const (
+	// Placeholder resolution
+	ActivityResolvePlaceholders = "ResolvePlaceholdersActivity"
+
+	// Intent processing
+	ActivityAnalyzeIntent          = "AnalyzeIntentActivity"
+	ActivityRecordRiskConfirmation = "RecordRiskConfirmationActivity"
+	ActivityCreateExecutionPlan    = "CreateExecutionPlanActivity"
+	ActivityLoadCheckpoint         = "LoadCheckpointActivity"
+	ActivityExecuteStep            = "ExecuteStepActivity"
+	ActivityRecordWorkflowStep     = "RecordWorkflowStepActivity"
+	ActivityAggregateResults       = "AggregateResultsActivity"
+
+	// Code execution
+	ActivitySelectAgent         = "SelectAgentActivity"
+	ActivityPrepareEnvironment  = "PrepareEnvironmentActivity"
+	ActivityExecuteCode         = "ExecuteCodeActivity"
+	ActivityProcessResults      = "ProcessResultsActivity"
+	ActivityCleanupEnvironment  = "CleanupEnvironmentActivity"
+	ActivityRecordMatrixRun     = "RecordMatrixRunActivity"
+	ActivitySummarizeCodeMatrix = "SummarizeCodeMatrixActivity"
+
+	// Task execution
+	ActivityFindOrCreateAgentForTask = "FindOrCreateAgentForTaskActivity"
+	ActivityExecuteTaskWithAgent     = "ExecuteTaskWithAgentActivity"
+	ActivityAggregateTaskResults     = "AggregateTaskResultsActivity"
+	ActivityStoreArtifacts           = "StoreArtifactsActivity"
+	ActivityExecuteBatch             = "ExecuteBatchActivity"
+	ActivityIterativeTask            = "IterativeTaskActivity"
+
+	// Meta-agent task execution
+	ActivityMetaAgentFindOrCreateAgentForTask = "MetaAgentFindOrCreateAgentForTaskActivity"
+	ActivityMetaAgentExecuteTaskWithAgent     = "MetaAgentExecuteTaskWithAgentActivity"
+	ActivityMetaAgentOptimizeAgentPerformance = "MetaAgentOptimizeAgentPerformanceActivity"
+
+	// Code analysis
+	ActivityFetchCode              = "FetchCodeActivity"
+	ActivityLookupAnalysisCache    = "LookupAnalysisCacheActivity"
+	ActivityStoreAnalysisCache     = "StoreAnalysisCacheActivity"
+	ActivityRunStaticAnalysis      = "RunStaticAnalysisActivity"
+	ActivityRunSecurityAnalysis    = "RunSecurityAnalysisActivity"
+	ActivityRunPerformanceAnalysis = "RunPerformanceAnalysisActivity"
+	ActivityGenerateAnalysisReport = "GenerateAnalysisReportActivity"
+
+	// Code review
+	ActivityFetchCodeChanges      = "FetchCodeChangesActivity"
+	ActivityRunAutomatedChecks    = "RunAutomatedChecksActivity"
+	ActivityRunAIReview           = "RunAIReviewActivity"
+	ActivityGenerateReviewSummary = "GenerateReviewSummaryActivity"
+	ActivityPostReviewComments    = "PostReviewCommentsActivity"
+
+	// Deployment
+	ActivityValidateDeployment     = "ValidateDeploymentActivity"
+	ActivityBuildArtifacts         = "BuildArtifactsActivity"
+	ActivityRunDeploymentTests     = "RunDeploymentTestsActivity"
+	ActivityDeployToStaging        = "DeployToStagingActivity"
+	ActivityRunSmokeTests          = "RunSmokeTestsActivity"
+	ActivityDeployToProduction     = "DeployToProductionActivity"
+	ActivityRunHealthCheck         = "RunHealthCheckActivity"
+	ActivityRollbackDeployment     = "RollbackDeploymentActivity"
+	ActivityUpdateDeploymentStatus = "UpdateDeploymentStatusActivity"
+
+	// Environment promotion
+	ActivityApplyPromotion                 = "ApplyPromotionActivity"
+	ActivityRecordPromotionSnapshot        = "RecordPromotionSnapshotActivity"
+	ActivityCreatePromotionDeployment      = "CreatePromotionDeploymentActivity"
+	ActivityMarkPromotionDeploymentStarted = "MarkPromotionDeploymentStartedActivity"
+
+	// Custom workflows
+	ActivityExecuteCustomStep = "ExecuteCustomStepActivity"
+
+	// Resource provisioning
+	ActivityProvisionResource      = "ProvisionResourceActivity"
+	ActivityCheckResource          = "CheckResourceActivity"
+	ActivityMarkResourceReady      = "MarkResourceReadyActivity"
+	ActivityTerminateResource      = "TerminateResourceActivity"
+	ActivityMarkResourceTerminated = "MarkResourceTerminatedActivity"
+	ActivityMarkResourceFailed     = "MarkResourceFailedActivity"
+)
@@ -0,0 +1,355 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/services"
+)
+
+// fakeFleet is an agent manager with a fixed fleet. Created and spawned
+// agents join the fleet as idle dynamic agents.
+type fakeFleet struct {
+	mu      sync.Mutex
+	agents  []services.Agent
+	created int
+}
+
+func (f *fakeFleet) ListAgents(ctx context.Context, filters *services.AgentFilters) (*services.AgentList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var agents []services.Agent
+	for _, agent := range f.agents {
+		if (filters.Type == "" || agent.Type == filters.Type) && (filters.Status == "" || agent.Status == filters.Status) {
+			agents = append(agents, agent)
+		}
+	}
+	return &services.AgentList{Agents: agents, TotalCount: int64(len(agents))}, nil
+}
+
+func (f *fakeFleet) GetAgent(ctx context.Context, agentID string) (*services.Agent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, agent := range f.agents {
+		if agent.ID == agentID {
+			return &agent, nil
+		}
+	}
+	return nil, fmt.Errorf("agent %s not found", agentID)
+}
+
+func (f *fakeFleet) CreateAgent(ctx context.Context, req *services.CreateAgentRequest) (*services.Agent, error) {
+	return f.add(req.Type, req.Capabilities), nil
+}
+
+func (f *fakeFleet) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	switch req.Type {
+	case "design-agent":
+		return &services.TaskExecution{Output: map[string]interface{}{"designId": "design-1"}}, nil
+	case "spawn-agent":
+		agent := f.add(req.Input["agentType"].(string), backendCapabilities)
+		return &services.TaskExecution{Output: map[string]interface{}{"agentId": agent.ID}}, nil
+	}
+	return nil, fmt.Errorf("unexpected task %s", req.Type)
+}
+
+func (f *fakeFleet) add(agentType string, capabilities []string) *services.Agent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created++
+	agent := services.Agent{
+		ID:     fmt.Sprintf("dynamic-%d", f.created),
+		Type:   agentType,
+		Status: "available",
+	}
+	for _, name := range capabilities {
+		agent.Capabilities = append(agent.Capabilities, services.Capability{Name: name})
+	}
+	f.agents = append(f.agents, agent)
+	return &agent
+}
+
+// backendFleet has two backend agents with the given loads and a meta-prompt
+// agent
+func backendFleet(loads ...float64) *fakeFleet {
+	fleet := &fakeFleet{agents: []services.Agent{{ID: "meta", Type: "meta-prompt", Status: "available"}}}
+	for i, load := range loads {
+		fleet.agents = append(fleet.agents, services.Agent{
+			ID:     fmt.Sprintf("backend-%d", i+1),
+			Type:   "backend",
+			Status: "available",
+			Load:   load,
+		})
+		for _, name := range backendCapabilities {
+			fleet.agents[i+1].Capabilities = append(fleet.agents[i+1].Capabilities, services.Capability{Name: name})
+		}
+	}
+	return fleet
+}
+
+var backendCapabilities = []string{"backend", "api", "server", "database", "rest", "graphql"}
+
+var testSelectionPolicy = services.AgentSelectionPolicy{UtilizationThreshold: 0.8, MaxDynamicAgentsPerProject: 1}
+
+func TestFindOrCreateAgentForTask_SaturationSpillover(t *testing.T) {
+	task := Task{ID: "task-0001", Type: "backend", Title: "Orders API"}
+
+	variants := map[string]func(fleet *fakeFleet) (*AgentInfo, error){
+		"meta-agent": func(fleet *fakeFleet) (*AgentInfo, error) {
+			activities := NewMetaAgentActivities(fleet, zap.NewNop())
+			activities.selection = testSelectionPolicy
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(activities.FindOrCreateAgentForTaskActivity,
+				activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+			val, err := env.ExecuteActivity("MetaAgentFindOrCreateAgentForTaskActivity", task, (*services.SimulationConfig)(nil))
+			if err != nil {
+				return nil, err
+			}
+			var agent AgentInfo
+			return &agent, val.Get(&agent)
+		},
+		"original": func(fleet *fakeFleet) (*AgentInfo, error) {
+			activities := NewActivities(nil, zap.NewNop(), nil, fleet)
+			activities.selection = testSelectionPolicy
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestActivityEnvironment()
+			env.RegisterActivity(activities.FindOrCreateAgentForTaskActivity)
+			val, err := env.ExecuteActivity(activities.FindOrCreateAgentForTaskActivity, task)
+			if err != nil {
+				return nil, err
+			}
+			var agent AgentInfo
+			return &agent, val.Get(&agent)
+		},
+	}
+
+	for name, findOrCreate := range variants {
+		t.Run(name, func(t *testing.T) {
+			// An idle agent is used
+			agent, err := findOrCreate(backendFleet(0.9, 0.2))
+			require.NoError(t, err)
+			assert.Equal(t, "backend-2", agent.ID)
+			assert.Equal(t, services.SelectionMatchedExisting, agent.Selection)
+
+			// A saturated fleet spills over to a new dynamic agent
+			fleet := backendFleet(0.9, 0.85)
+			agent, err = findOrCreate(fleet)
+			require.NoError(t, err)
+			assert.Equal(t, "dynamic-1", agent.ID)
+			assert.Equal(t, services.DynamicAgentType, agent.Type)
+			assert.Equal(t, services.SelectionSpawnedSaturated, agent.Selection)
+			assert.Contains(t, agent.SelectionWhy, "all 2 qualified agents")
+
+			// At the dynamic agent cap the task queues on the least loaded agent
+			fleet = backendFleet(0.9, 0.85)
+			fleet.add(services.DynamicAgentType, nil)
+			agent, err = findOrCreate(fleet)
+			require.NoError(t, err)
+			assert.Equal(t, "backend-2", agent.ID)
+			assert.Equal(t, services.SelectionQueuedSaturated, agent.Selection)
+			assert.Contains(t, agent.SelectionWhy, "1 of 1 dynamic agents")
+			assert.Equal(t, 1, fleet.created, "no agent is created past the cap")
+		})
+	}
+}
+
+// poolFleet is a fakeFleet that warm pools can scale down
+type poolFleet struct {
+	*fakeFleet
+}
+
+func (f poolFleet) DeleteAgent(ctx context.Context, agentID string) error {
+	return fmt.Errorf("unexpected delete of agent %s", agentID)
+}
+
+func TestFindOrCreateAgentForTask_PrefersWarmPool(t *testing.T) {
+	fleet := backendFleet(0.2)
+	fleet.agents = append(fleet.agents, services.Agent{
+		ID:     "pool-1",
+		Type:   "backend_pool",
+		Status: "available",
+		Tags:   []string{services.WarmPoolTag},
+	})
+	pools := services.NewPoolManager(poolFleet{fleet}, &config.AgentManagerConfig{
+		WarmPools: []config.WarmPoolConfig{{
+			AgentType: "backend_pool",
+			TaskTypes: []string{"backend"},
+			MaxSize:   1,
+		}},
+		PoolCheckInterval: 30,
+	}, zap.NewNop())
+	pools.Reconcile(context.Background())
+
+	activities := NewMetaAgentActivities(fleet, zap.NewNop())
+	activities.selection = testSelectionPolicy
+	activities.pools = pools
+	find := func(task Task) *AgentInfo {
+		var suite testsuite.WorkflowTestSuite
+		env := suite.NewTestActivityEnvironment()
+		env.RegisterActivityWithOptions(activities.FindOrCreateAgentForTaskActivity,
+			activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+		val, err := env.ExecuteActivity("MetaAgentFindOrCreateAgentForTaskActivity", task, (*services.SimulationConfig)(nil))
+		require.NoError(t, err)
+		var agent AgentInfo
+		require.NoError(t, val.Get(&agent))
+		return &agent
+	}
+
+	// The idle pool member takes the task ahead of the matching agent
+	agent := find(Task{ID: "task-0001", Type: "backend", Title: "Orders API"})
+	assert.Equal(t, "pool-1", agent.ID)
+	assert.Equal(t, services.SelectionWarmPool, agent.Selection)
+
+	// The member is claimed, so the next task is matched as usual
+	agent = find(Task{ID: "task-0002", Type: "backend", Title: "Payments API"})
+	assert.Equal(t, "backend-1", agent.ID)
+	assert.Equal(t, services.SelectionMatchedExisting, agent.Selection)
+	assert.Equal(t, 0, fleet.created)
+	assert.Equal(t, 1, pools.Status()[0].Busy)
+}
+
+// slowSpawnFleet is a fakeFleet whose meta-agent takes a while to spawn
+type slowSpawnFleet struct {
+	*fakeFleet
+	designs, spawns atomic.Int32
+}
+
+func (f *slowSpawnFleet) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	switch req.Type {
+	case "design-agent":
+		f.designs.Add(1)
+	case "spawn-agent":
+		f.spawns.Add(1)
+		time.Sleep(50 * time.Millisecond)
+	}
+	return f.fakeFleet.ExecuteTask(ctx, agentID, req)
+}
+
+func TestFindOrCreateAgentForTask_ConcurrentRequestsShareOneSpawn(t *testing.T) {
+	fleet := &slowSpawnFleet{fakeFleet: backendFleet()}
+	activities := NewMetaAgentActivities(fleet, zap.NewNop())
+	activities.spawns = services.NewSpawnCoordinator(services.NewMemorySpawnStore(), &config.AgentManagerConfig{
+		SpawnRateLimit:   5,
+		SpawnWaitTimeout: 10,
+		SpawnReuseTTL:    3600,
+	}, zap.NewNop())
+
+	const tasks = 10
+	var wg sync.WaitGroup
+	agents := make([]AgentInfo, tasks)
+	for i := 0; i < tasks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(activities.FindOrCreateAgentForTaskActivity,
+				activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+			task := Task{ID: fmt.Sprintf("task-%d", i), Type: "backend", Title: "Orders API"}
+			val, err := env.ExecuteActivity("MetaAgentFindOrCreateAgentForTaskActivity", task, (*services.SimulationConfig)(nil))
+			if assert.NoError(t, err) {
+				assert.NoError(t, val.Get(&agents[i]))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.EqualValues(t, 1, fleet.designs.Load(), "one design for all tasks")
+	assert.EqualValues(t, 1, fleet.spawns.Load(), "one spawn for all tasks")
+	for _, agent := range agents {
+		assert.Equal(t, "dynamic-1", agent.ID)
+		assert.Equal(t, services.SelectionSpawnedNoMatch, agent.Selection)
+	}
+}
+
+func TestFindOrCreateAgentForTask_AgentPreferences(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`,
+		`INSERT INTO projects (id, settings) VALUES
+			('preferring', CAST('{"agent_preferences": {"preferred_agent_ids": ["backend-3"], "excluded_agent_ids": ["backend-1"]}}' AS BLOB)),
+			('excluding', CAST('{"agent_preferences": {"excluded_agent_ids": ["backend-1"]}}' AS BLOB)),
+			('indifferent', NULL)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	task := Task{ID: "task-0001", Type: "backend", Title: "Orders API"}
+
+	variants := map[string]func(fleet *fakeFleet, projectID string) *AgentInfo{
+		"meta-agent": func(fleet *fakeFleet, projectID string) *AgentInfo {
+			activities := NewMetaAgentActivities(fleet, zap.NewNop())
+			activities.selection = testSelectionPolicy
+			activities.preferences = services.NewAgentPreferenceService(db, fleet, &config.AgentManagerConfig{PreferenceBoost: 0.2})
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestActivityEnvironment()
+			env.RegisterActivityWithOptions(activities.FindOrCreateAgentForTaskActivity,
+				activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+			val, err := env.ExecuteActivity("MetaAgentFindOrCreateAgentForTaskActivity", task, (*services.SimulationConfig)(nil),
+				AgentSelectionHints{ProjectID: projectID})
+			require.NoError(t, err)
+			var agent AgentInfo
+			require.NoError(t, val.Get(&agent))
+			return &agent
+		},
+		"original": func(fleet *fakeFleet, projectID string) *AgentInfo {
+			activities := NewActivities(nil, zap.NewNop(), nil, fleet)
+			activities.selection = testSelectionPolicy
+			activities.preferences = services.NewAgentPreferenceService(db, fleet, &config.AgentManagerConfig{PreferenceBoost: 0.2})
+			var suite testsuite.WorkflowTestSuite
+			env := suite.NewTestActivityEnvironment()
+			env.SetWorkerOptions(worker.Options{
+				BackgroundActivityContext: context.WithValue(context.Background(), "project_id", projectID),
+			})
+			env.RegisterActivity(activities.FindOrCreateAgentForTaskActivity)
+			val, err := env.ExecuteActivity(activities.FindOrCreateAgentForTaskActivity, task)
+			require.NoError(t, err)
+			var agent AgentInfo
+			require.NoError(t, val.Get(&agent))
+			return &agent
+		},
+	}
+
+	for name, find := range variants {
+		t.Run(name, func(t *testing.T) {
+			// The least loaded of the equally matching agents is used
+			agent := find(backendFleet(0.1, 0.5, 0.3), "indifferent")
+			assert.Equal(t, "backend-1", agent.ID)
+			assert.Empty(t, agent.Preference)
+
+			// Excluded agents are left out
+			agent = find(backendFleet(0.1, 0.3, 0.5), "excluding")
+			assert.Equal(t, "backend-2", agent.ID)
+			assert.Equal(t, services.PreferenceExcluded, agent.Preference)
+			assert.Contains(t, agent.PreferenceWhy, "backend-1")
+
+			// The preferred agent wins over an equally matching, less loaded one
+			agent = find(backendFleet(0.1, 0.2, 0.3), "preferring")
+			assert.Equal(t, "backend-3", agent.ID)
+			assert.Equal(t, services.SelectionMatchedExisting, agent.Selection)
+			assert.Equal(t, services.PreferenceBoosted, agent.Preference)
+			assert.Contains(t, agent.PreferenceWhy, "over backend-2")
+
+			// A saturated preferred agent is passed over
+			agent = find(backendFleet(0.1, 0.2, 0.9), "preferring")
+			assert.Equal(t, "backend-2", agent.ID)
+			assert.Equal(t, services.PreferenceExcluded, agent.Preference)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+const (
+	// defaultWatchdogInterval is how often a running agent task is polled
+	// and the activity heartbeats
+	defaultWatchdogInterval = 10 * time.Second
+
+	// agentTaskHeartbeatTimeout bounds how long an agent task may go without
+	// a heartbeat before Temporal times the attempt out
+	agentTaskHeartbeatTimeout = 45 * time.Second
+
+	// agentUnresponsiveError is the error type of task attempts abandoned
+	// because their agent stopped responding. It is retryable; the workflow
+	// retries on another agent.
+	agentUnresponsiveError = "AgentUnresponsive"
+
+	// cancelTaskTimeout bounds the request cancelling an abandoned task
+	cancelTaskTimeout = 10 * time.Second
+)
+
+// AgentWatchdogConfig controls how tasks running on agents are watched
+type AgentWatchdogConfig struct {
+	// Interval between status polls, each followed by a heartbeat when the
+	// agent answered
+	Interval time.Duration
+	// StallTimeout is how long the agent may fail to answer before the task
+	// is cancelled. It defaults to the activity's heartbeat timeout.
+	StallTimeout time.Duration
+}
+
+// agentTaskID names the task of an activity attempt on an agent, so it can be
+// polled and cancelled before the agent answers the execute request. The
+// activity ID tells apart the attempts the workflow retries itself.
+func agentTaskID(ctx context.Context, task Task) string {
+	info := activity.GetInfo(ctx)
+	return fmt.Sprintf("%s-%s-%s-%d", info.WorkflowExecution.ID, task.ID, info.ActivityID, info.Attempt)
+}
+
+// executeWatched runs a task on an agent while polling its status. Each
+// successful poll heartbeats with the task's progress; when the agent stops
+// answering for the stall timeout the task is cancelled on the agent and a
+// retryable agentUnresponsiveError is returned. Cancelling the activity also
+// cancels the task on the agent.
+func executeWatched(ctx context.Context, executor services.AgentExecutor, cfg AgentWatchdogConfig, logger *zap.Logger, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	monitor, ok := executor.(services.TaskMonitor)
+	if !ok || req.TaskID == "" {
+		return executor.ExecuteTask(ctx, agentID, req)
+	}
+
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultWatchdogInterval
+	}
+	stallTimeout := cfg.StallTimeout
+	if stallTimeout <= 0 {
+		stallTimeout = activity.GetInfo(ctx).HeartbeatTimeout
+	}
+
+	type outcome struct {
+		execution *services.TaskExecution
+		err       error
+	}
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan outcome, 1)
+	go func() {
+		execution, err := executor.ExecuteTask(taskCtx, agentID, req)
+		done <- outcome{execution, err}
+	}()
+
+	// cancelOnAgent stops the task on the agent. The activity context may
+	// already be done, so the request gets its own.
+	cancelOnAgent := func(reason string) {
+		cancel()
+		cancelCtx, cancelTimeout := context.WithTimeout(context.Background(), cancelTaskTimeout)
+		defer cancelTimeout()
+		if err := monitor.CancelTask(cancelCtx, agentID, req.TaskID); err != nil {
+			logger.Warn("failed to cancel agent task",
+				zap.String("agent_id", agentID),
+				zap.String("task_id", req.TaskID),
+				zap.String("reason", reason),
+				zap.Error(err))
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastSeen := time.Now()
+	for {
+		select {
+		case result := <-done:
+			return result.execution, result.err
+
+		case <-ctx.Done():
+			cancelOnAgent("activity cancelled")
+			return nil, ctx.Err()
+
+		case <-ticker.C:
+			pollCtx, pollCancel := context.WithTimeout(ctx, interval)
+			status, err := monitor.GetTaskStatus(pollCtx, agentID, req.TaskID)
+			pollCancel()
+			if err == nil {
+				lastSeen = time.Now()
+				recordProgress(ctx, taskProgress(status), "executing_task",
+					fmt.Sprintf("Task %s is %s on agent %s", req.TaskID, status.Status, agentID))
+				continue
+			}
+
+			// No heartbeat without an answer, so Temporal also times the
+			// attempt out should this worker stall
+			silent := time.Since(lastSeen)
+			logger.Warn("agent task status unavailable",
+				zap.String("agent_id", agentID),
+				zap.String("task_id", req.TaskID),
+				zap.Duration("silent_for", silent),
+				zap.Error(err))
+			if stallTimeout > 0 && silent >= stallTimeout {
+				cancelOnAgent("agent unresponsive")
+				return nil, temporal.NewApplicationError(
+					fmt.Sprintf("agent %s stopped responding for %s", agentID, silent.Round(time.Millisecond)),
+					agentUnresponsiveError, agentID)
+			}
+		}
+	}
+}
+
+// taskProgress reads the progress percentage an agent reports for a task,
+// keeping it between the dispatch and completion heartbeats
+func taskProgress(status *services.TaskExecution) int {
+	progress, _ := status.Output["progress"].(float64)
+	return min(max(int(progress), 10), 99)
+}
+
+// agentUnresponsive reports whether a task attempt failed because its agent
+// stopped responding, as detected by the watchdog or by a Temporal heartbeat
+// timeout
+func agentUnresponsive(err error) bool {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() == agentUnresponsiveError {
+		return true
+	}
+	var timeoutErr *temporal.TimeoutError
+	return errors.As(err, &timeoutErr) && timeoutErr.TimeoutType() == enumspb.TIMEOUT_TYPE_HEARTBEAT
+}
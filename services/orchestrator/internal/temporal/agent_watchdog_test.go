@@ -0,0 +1,164 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// stallingFleet is a backend fleet where some agents stop responding once
+// they are given a task: the task never completes and its status cannot be
+// read, as when the agent process dies mid-task
+type stallingFleet struct {
+	*fakeFleet
+	dead map[string]bool
+
+	mu        sync.Mutex
+	executed  []string // Agents given a task, in order
+	taskIDs   []string
+	cancelled map[string]string // Task ID by agent
+	polls     map[string]int
+}
+
+func newStallingFleet(dead ...string) *stallingFleet {
+	fleet := &stallingFleet{
+		fakeFleet: backendFleet(0.1, 0.2),
+		dead:      map[string]bool{},
+		cancelled: map[string]string{},
+		polls:     map[string]int{},
+	}
+	for _, agentID := range dead {
+		fleet.dead[agentID] = true
+	}
+	return fleet
+}
+
+func (f *stallingFleet) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	f.mu.Lock()
+	f.executed = append(f.executed, agentID)
+	f.taskIDs = append(f.taskIDs, req.TaskID)
+	f.mu.Unlock()
+
+	if f.dead[agentID] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	// Live agents take a few status polls to finish
+	select {
+	case <-time.After(100 * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &services.TaskExecution{ID: req.TaskID, AgentID: agentID, Status: "completed", Output: map[string]interface{}{"summary": "done"}}, nil
+}
+
+func (f *stallingFleet) GetTaskStatus(ctx context.Context, agentID, taskID string) (*services.TaskExecution, error) {
+	f.mu.Lock()
+	f.polls[agentID]++
+	f.mu.Unlock()
+	if f.dead[agentID] {
+		return nil, errors.New("connection refused")
+	}
+	return &services.TaskExecution{ID: taskID, Status: "running", Output: map[string]interface{}{"progress": float64(40)}}, nil
+}
+
+func (f *stallingFleet) CancelTask(ctx context.Context, agentID, taskID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cancelled[agentID] = taskID
+	return nil
+}
+
+func TestTaskExecutionWorkflow_RetriesOnAnotherAgentWhenAgentStopsResponding(t *testing.T) {
+	fleet := newStallingFleet("backend-1")
+	metaActivities := NewMetaAgentActivities(fleet, zap.NewNop())
+	metaActivities.watchdog = AgentWatchdogConfig{Interval: 20 * time.Millisecond, StallTimeout: 150 * time.Millisecond}
+	activities := NewActivities(nil, zap.NewNop(), nil, fleet)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	env.RegisterWorkflow(engine.TaskExecutionWorkflow)
+	env.RegisterActivityWithOptions(metaActivities.FindOrCreateAgentForTaskActivity,
+		activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+	env.RegisterActivityWithOptions(metaActivities.ExecuteTaskWithAgentActivity,
+		activity.RegisterOptions{Name: "MetaAgentExecuteTaskWithAgentActivity"})
+	env.RegisterActivity(activities.AggregateTaskResultsActivity)
+	env.RegisterActivity(activities.StoreArtifactsActivity)
+
+	var attempts []error
+	var aggregated AggregatedTaskResult
+	env.SetOnActivityCompletedListener(func(info *activity.Info, result converter.EncodedValue, err error) {
+		switch info.ActivityType.Name {
+		case "MetaAgentExecuteTaskWithAgentActivity":
+			attempts = append(attempts, err)
+		case "AggregateTaskResultsActivity":
+			require.NoError(t, result.Get(&aggregated))
+		}
+	})
+
+	input, err := json.Marshal(TaskExecutionInput{
+		ProjectID: "project-1",
+		Tasks:     []Task{{ID: "task-0001", Type: "backend", Title: "Orders API"}},
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	env.ExecuteWorkflow(engine.TaskExecutionWorkflow, &models.Workflow{ID: testWorkflowID, Input: input})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// The dead agent is given up on after the stall timeout rather than the
+	// task's full timeout
+	assert.Less(t, time.Since(start), 5*time.Second)
+	require.Len(t, attempts, 2)
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, attempts[0], &appErr)
+	assert.Equal(t, agentUnresponsiveError, appErr.Type())
+	assert.False(t, appErr.NonRetryable())
+	assert.NoError(t, attempts[1])
+
+	// The task is cancelled on the dead agent and the retry selects the
+	// other agent
+	assert.Equal(t, []string{"backend-1", "backend-2"}, fleet.executed)
+	require.Len(t, fleet.taskIDs, 2)
+	assert.NotEqual(t, fleet.taskIDs[0], fleet.taskIDs[1])
+	assert.Equal(t, map[string]string{"backend-1": fleet.taskIDs[0]}, fleet.cancelled)
+
+	// The live agent answered the status polls made while it ran
+	assert.Positive(t, fleet.polls["backend-2"])
+
+	require.Len(t, aggregated.TaskResults, 1)
+	assert.Equal(t, models.ExecutionStatusSucceeded, aggregated.TaskResults[0].Status)
+	assert.Equal(t, "backend-2", aggregated.TaskResults[0].AgentID)
+}
+
+func TestExecuteWatched_UnwatchedExecutorRunsDirectly(t *testing.T) {
+	fleet := backendFleet(0.1)
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivityWithOptions(func(ctx context.Context) error {
+		// fakeFleet cannot be polled, so its error comes straight back
+		_, err := executeWatched(ctx, fleet, AgentWatchdogConfig{StallTimeout: time.Millisecond}, zap.NewNop(), "backend-1",
+			&services.ExecuteTaskRequest{TaskID: "task-1", Type: "lint"})
+		return err
+	}, activity.RegisterOptions{Name: "Watched"})
+
+	_, err := env.ExecuteActivity("Watched")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected task lint")
+}
@@ -0,0 +1,116 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// analysisReportRevision is bumped when a change to report generation or
+// performance analysis changes the reports produced, invalidating cached
+// reports
+const analysisReportRevision = 1
+
+// analysisCacheChange gates the cache lookup and store of code analysis, so
+// executions started before they were added replay without them
+const analysisCacheChange = "analysis-cache"
+
+// AnalysisCacheLookup is the result of looking up the cached report of an
+// analysis
+type AnalysisCacheLookup struct {
+	Result  string          `json:"result"`           // See services.AnalysisCacheHit
+	Version string          `json:"version"`          // Analyzer version the report is stored under
+	Report  *AnalysisReport `json:"report,omitempty"` // Set on hits
+}
+
+// AnalysisCacheEntry is the report of an analysis to cache
+type AnalysisCacheEntry struct {
+	Request    CodeAnalysisRequest `json:"request"`
+	Commit     string              `json:"commit"`
+	Version    string              `json:"version"`
+	WorkflowID string              `json:"workflow_id"`
+	Report     AnalysisReport      `json:"report"`
+}
+
+// analysisCacheKey identifies what a request analyzes at a commit
+func analysisCacheKey(req CodeAnalysisRequest, commit string) *services.AnalysisCacheKey {
+	return &services.AnalysisCacheKey{
+		Repository: req.Repository,
+		Commit:     commit,
+		Path:       req.Path,
+		Include:    req.Include,
+		Types:      req.Types,
+	}
+}
+
+// analyzerVersion identifies the analyzers of the worker and their settings
+func (a *Activities) analyzerVersion() string {
+	static, security := "static/none", "security/none"
+	if a.analyzers != nil {
+		static = a.analyzers.Version()
+	}
+	if a.scanners != nil {
+		security = a.scanners.Version()
+	}
+	return fmt.Sprintf("report/%d; %s; %s", analysisReportRevision, static, security)
+}
+
+// LookupAnalysisCacheActivity returns the cached report of an analysis of
+// the commit by the worker's analyzers, if any. Requests bypassing the cache
+// and analyses of unknown commits always miss.
+func (a *Activities) LookupAnalysisCacheActivity(ctx context.Context, req CodeAnalysisRequest, commit string) (*AnalysisCacheLookup, error) {
+	lookup := &AnalysisCacheLookup{Result: services.AnalysisCacheMiss, Version: a.analyzerVersion()}
+	if a.analysisCache == nil || req.BypassCache || commit == "" {
+		return lookup, nil
+	}
+
+	data, result, err := a.analysisCache.Lookup(ctx, analysisCacheKey(req, commit), lookup.Version)
+	if err != nil {
+		return nil, err
+	}
+	lookup.Result = result
+	if data != nil {
+		var report AnalysisReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			// A report that no longer decodes is analyzed again
+			activity.GetLogger(ctx).Warn("Ignoring undecodable cached analysis report", zap.Error(err))
+			lookup.Result = services.AnalysisCacheMiss
+			return lookup, nil
+		}
+		lookup.Report = &report
+	}
+	return lookup, nil
+}
+
+// StoreAnalysisCacheActivity caches the report of an analysis of a commit
+func (a *Activities) StoreAnalysisCacheActivity(ctx context.Context, entry AnalysisCacheEntry) error {
+	if a.analysisCache == nil || entry.Commit == "" {
+		return nil
+	}
+	data, err := json.Marshal(entry.Report)
+	if err != nil {
+		return err
+	}
+	return a.analysisCache.Store(ctx, analysisCacheKey(entry.Request, entry.Commit), entry.Version, data,
+		entry.Request.ProjectID, entry.WorkflowID)
+}
+
+// cacheBypassed reports whether the workflow was started to ignore cached
+// reports
+func cacheBypassed(wf *models.Workflow) bool {
+	if len(wf.Metadata) == 0 {
+		return false
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(wf.Metadata, &metadata); err != nil {
+		return false
+	}
+	bypass, _ := metadata[services.BypassCacheMetadataKey].(bool)
+	return bypass
+}
@@ -0,0 +1,157 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func analysisWorkflow(t *testing.T, req CodeAnalysisRequest) *models.Workflow {
+	input, err := json.Marshal(req)
+	require.NoError(t, err)
+	return &models.Workflow{ID: "wf-1", ProjectID: "project-1", Type: models.WorkflowTypeAnalysis, Input: input}
+}
+
+// analysisTestEnvironment runs CodeAnalysisWorkflow with the code of commit
+// abc123 fetched, recording the activities started
+func analysisTestEnvironment(t *testing.T, activities *Activities) (*testsuite.TestWorkflowEnvironment, *[]string) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(NewWorkflowEngine(zap.NewNop()).CodeAnalysisWorkflow)
+	env.RegisterActivity(activities)
+	env.OnActivity(activities.FetchCodeActivity, mock.Anything, mock.Anything).
+		Return(&CodeData{Files: []string{"main.go"}, Metadata: map[string]interface{}{"commit": "abc123"}}, nil)
+
+	var started []string
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		started = append(started, info.ActivityType.Name)
+	})
+	return env, &started
+}
+
+func TestCodeAnalysisWorkflow_CacheHit(t *testing.T) {
+	activities := &Activities{}
+	env, started := analysisTestEnvironment(t, activities)
+	env.OnActivity(activities.LookupAnalysisCacheActivity, mock.Anything, mock.Anything, "abc123").
+		Return(&AnalysisCacheLookup{
+			Result:  services.AnalysisCacheHit,
+			Version: "report/1",
+			Report:  &AnalysisReport{Summary: "cached", Score: 0.9},
+		}, nil)
+
+	env.ExecuteWorkflow("CodeAnalysisWorkflow", analysisWorkflow(t, CodeAnalysisRequest{Repository: "https://github.com/acme/api"}))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// The analyses are skipped
+	assert.Equal(t, []string{"FetchCodeActivity", "LookupAnalysisCacheActivity"}, *started)
+}
+
+func TestCodeAnalysisWorkflow_CacheMissPopulates(t *testing.T) {
+	activities := &Activities{}
+	env, started := analysisTestEnvironment(t, activities)
+	env.OnActivity(activities.LookupAnalysisCacheActivity, mock.Anything, mock.Anything, "abc123").
+		Return(&AnalysisCacheLookup{Result: services.AnalysisCacheMiss, Version: "report/1"}, nil)
+	env.OnActivity(activities.RunStaticAnalysisActivity, mock.Anything, mock.Anything).Return(&StaticAnalysisResult{}, nil)
+	env.OnActivity(activities.RunSecurityAnalysisActivity, mock.Anything, mock.Anything).Return(&SecurityAnalysisResult{}, nil)
+	env.OnActivity(activities.RunPerformanceAnalysisActivity, mock.Anything, mock.Anything).Return(&PerformanceAnalysisResult{}, nil)
+	env.OnActivity(activities.GenerateAnalysisReportActivity, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&AnalysisReport{Summary: "fresh", Score: 0.8}, nil)
+	var stored AnalysisCacheEntry
+	env.OnActivity(activities.StoreAnalysisCacheActivity, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { stored = args.Get(1).(AnalysisCacheEntry) }).
+		Return(nil)
+
+	env.ExecuteWorkflow("CodeAnalysisWorkflow", analysisWorkflow(t, CodeAnalysisRequest{Repository: "https://github.com/acme/api"}))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	assert.Contains(t, *started, "RunStaticAnalysisActivity")
+	assert.Equal(t, "StoreAnalysisCacheActivity", (*started)[len(*started)-1])
+	assert.Equal(t, "abc123", stored.Commit)
+	assert.Equal(t, "report/1", stored.Version)
+	assert.Equal(t, "wf-1", stored.WorkflowID)
+	assert.Equal(t, "project-1", stored.Request.ProjectID)
+	assert.Equal(t, "fresh", stored.Report.Summary)
+	assert.False(t, stored.Report.CacheHit)
+}
+
+func TestCodeAnalysisWorkflow_ForceBypassesCache(t *testing.T) {
+	activities := &Activities{}
+	env, _ := analysisTestEnvironment(t, activities)
+	var lookedUp CodeAnalysisRequest
+	env.OnActivity(activities.LookupAnalysisCacheActivity, mock.Anything, mock.Anything, "abc123").
+		Run(func(args mock.Arguments) { lookedUp = args.Get(1).(CodeAnalysisRequest) }).
+		Return(&AnalysisCacheLookup{Result: services.AnalysisCacheMiss, Version: "report/1"}, nil)
+	env.OnActivity(activities.RunStaticAnalysisActivity, mock.Anything, mock.Anything).Return(&StaticAnalysisResult{}, nil)
+	env.OnActivity(activities.RunSecurityAnalysisActivity, mock.Anything, mock.Anything).Return(&SecurityAnalysisResult{}, nil)
+	env.OnActivity(activities.RunPerformanceAnalysisActivity, mock.Anything, mock.Anything).Return(&PerformanceAnalysisResult{}, nil)
+	env.OnActivity(activities.GenerateAnalysisReportActivity, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&AnalysisReport{}, nil)
+	env.OnActivity(activities.StoreAnalysisCacheActivity, mock.Anything, mock.Anything).Return(nil)
+
+	// Workflows started with force=true carry the flag in their metadata
+	wf := analysisWorkflow(t, CodeAnalysisRequest{Repository: "https://github.com/acme/api"})
+	wf.Metadata = json.RawMessage(`{"bypass_cache": true}`)
+	env.ExecuteWorkflow("CodeAnalysisWorkflow", wf)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	assert.True(t, lookedUp.BypassCache)
+}
+
+func TestLookupAnalysisCacheActivity(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.AnalysisResult{}))
+	activities := &Activities{
+		analysisCache: services.NewAnalysisCache(db, &config.AnalysisCacheConfig{Enabled: true, Retention: 3600}, zap.NewNop()),
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+	lookup := func(req CodeAnalysisRequest) *AnalysisCacheLookup {
+		value, err := env.ExecuteActivity(activities.LookupAnalysisCacheActivity, req, "abc123")
+		require.NoError(t, err)
+		var result AnalysisCacheLookup
+		require.NoError(t, value.Get(&result))
+		return &result
+	}
+	req := CodeAnalysisRequest{ProjectID: "project-1", Repository: "https://github.com/acme/api"}
+
+	// Miss, then populate
+	missed := lookup(req)
+	assert.Equal(t, services.AnalysisCacheMiss, missed.Result)
+	assert.Equal(t, "report/1; static/none; security/none", missed.Version)
+	_, err = env.ExecuteActivity(activities.StoreAnalysisCacheActivity, AnalysisCacheEntry{
+		Request: req, Commit: "abc123", Version: missed.Version, WorkflowID: "wf-1",
+		Report: AnalysisReport{Summary: "cached", Score: 0.9},
+	})
+	require.NoError(t, err)
+
+	hit := lookup(req)
+	assert.Equal(t, services.AnalysisCacheHit, hit.Result)
+	require.NotNil(t, hit.Report)
+	assert.Equal(t, "cached", hit.Report.Summary)
+
+	// Forced analyses ignore the cached report
+	req.BypassCache = true
+	bypassed := lookup(req)
+	assert.Equal(t, services.AnalysisCacheMiss, bypassed.Result)
+	assert.Nil(t, bypassed.Report)
+}
@@ -0,0 +1,143 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// storageQuotaExceededError is the type of the non-retryable error returned
+// when artifacts would take a project over its storage quota
+const storageQuotaExceededError = "StorageQuotaExceeded"
+
+// recordArtifacts records the artifacts of the running workflow under an
+// execution of their own and accounts them against the project's storage.
+// A retried activity records each artifact once. Artifacts of simulated
+// workflows are not recorded.
+func (a *Activities) recordArtifacts(ctx context.Context, projectID string, artifacts []Artifact) error {
+	if a.storage == nil || len(artifacts) == 0 {
+		return nil
+	}
+
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "simulated", "metadata").
+		First(&workflow, "id = ?", activity.GetInfo(ctx).WorkflowExecution.ID).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+	if workflow.Simulated {
+		return nil
+	}
+
+	now := time.Now()
+	execution := &models.Execution{
+		ID:          artifactRecordID(workflow.ID, "store-artifacts"),
+		ProjectID:   projectID,
+		WorkflowID:  workflow.ID,
+		Name:        "Store artifacts",
+		Type:        models.ExecutionTypeCustom,
+		Status:      models.ExecutionStatusSucceeded,
+		QueuedAt:    timePtr(activityScheduledAt(ctx)),
+		StartedAt:   &now,
+		CompletedAt: &now,
+	}
+	if err := a.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(execution).Error; err != nil {
+		return fmt.Errorf("failed to create execution: %w", err)
+	}
+
+	// Contents of workflows pinned to a region stay in its bucket
+	records, err := a.artifactRecords(services.WithRegion(ctx, services.WorkflowRegion(workflow.Metadata)),
+		workflow.ID, execution.ID, artifacts)
+	if err != nil {
+		return err
+	}
+
+	err = a.storage.StoreArtifacts(ctx, projectID, records)
+	if errors.Is(err, services.ErrStorageQuotaExceeded) {
+		if updateErr := a.db.WithContext(ctx).Model(&models.Execution{}).Where("id = ?", execution.ID).
+			Updates(map[string]interface{}{"status": models.ExecutionStatusFailed, "error": err.Error()}).Error; updateErr != nil {
+			a.logger.Warn("Failed to mark artifact store as failed", zap.String("execution_id", execution.ID), zap.Error(updateErr))
+		}
+		return temporal.NewNonRetryableApplicationError(err.Error(), storageQuotaExceededError, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store artifacts: %w", err)
+	}
+	return nil
+}
+
+// recordRoundArtifacts records the artifacts of a refinement round under
+// the iterative execution. Rounds regenerating a file add versions of it;
+// failures only cost the round's lineage, so they are logged.
+func (a *Activities) recordRoundArtifacts(ctx context.Context, execution *models.Execution, artifacts []Artifact) {
+	if a.storage == nil || len(artifacts) == 0 || execution.Simulated {
+		return
+	}
+	regionCtx, err := a.regionContext(ctx, execution.WorkflowID)
+	var records []models.Artifact
+	if err == nil {
+		records, err = a.artifactRecords(regionCtx, execution.WorkflowID, execution.ID, artifacts)
+	}
+	if err == nil {
+		err = a.storage.StoreArtifacts(ctx, execution.ProjectID, records)
+	}
+	if err != nil {
+		a.logger.Warn("Failed to record refinement round artifacts",
+			zap.String("execution_id", execution.ID),
+			zap.Error(err))
+	}
+}
+
+// artifactRecords keeps the contents of artifacts in artifact storage and
+// returns their records. Record IDs derive from the execution, the artifact
+// ID and the content, so retries record an artifact once while regenerated
+// contents become new versions of the artifact's logical key.
+func (a *Activities) artifactRecords(ctx context.Context, workflowID, executionID string, artifacts []Artifact) ([]models.Artifact, error) {
+	records := make([]models.Artifact, len(artifacts))
+	for i, artifact := range artifacts {
+		content, artifactPath := artifact.Content, artifact.Path
+		// Extracted artifacts format missing fields as <nil>
+		if content == "<nil>" {
+			content = ""
+		}
+		if artifactPath == "<nil>" {
+			artifactPath = ""
+		}
+		checksum, err := a.artifacts.PutContent(ctx, []byte(content))
+		if err != nil {
+			return nil, err
+		}
+		records[i] = models.Artifact{
+			ID:          artifactRecordID(workflowID, executionID+"/"+artifact.ID+"@"+checksum),
+			ExecutionID: executionID,
+			WorkflowID:  workflowID,
+			LogicalKey:  services.ArtifactKey(artifactPath, artifact.Name),
+			Name:        artifact.Name,
+			Type:        artifact.Type,
+			Path:        artifactPath,
+			Size:        artifact.Size,
+			Checksum:    checksum,
+			ContentType: artifact.ContentType,
+		}
+		// Previews are served from the head kept with the record
+		if err := services.WithContentHead(&records[i], []byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// artifactRecordID derives the ID of a database record from the workflow
+// and the ID of what it records
+func artifactRecordID(workflowID, id string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("artifact:"+workflowID+"/"+id)).String()
+}
@@ -0,0 +1,174 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func setupArtifactsTest(t *testing.T, simulated bool) (*gorm.DB, *Activities, *testsuite.TestActivityEnvironment) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, simulated BOOLEAN, metadata BLOB, deleted_at DATETIME)`,
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, resource_limits BLOB, deleted_at DATETIME)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY,
+			project_id TEXT, workflow_id TEXT, workflow_step_id TEXT, agent_id TEXT, name TEXT, type TEXT,
+			status TEXT, language TEXT, runtime TEXT, code TEXT, script TEXT, command TEXT, arguments TEXT,
+			environment TEXT, input TEXT, output TEXT, logs TEXT, error TEXT, error_signature TEXT, error_class TEXT, exit_code INTEGER,
+			started_at DATETIME, completed_at DATETIME, duration INTEGER, timeout_seconds INTEGER,
+			retry_count INTEGER, max_retries INTEGER, retry_delay INTEGER, resource_usage TEXT,
+			metadata TEXT, tags TEXT, priority INTEGER, simulated BOOLEAN, queued_at DATETIME,
+			scheduled_at DATETIME, created_by TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT, path TEXT, url TEXT,
+			size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+			created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+			workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	require.NoError(t, db.AutoMigrate(&models.ProjectStorageUsage{}))
+	require.NoError(t, db.Exec(`INSERT INTO workflows (id, project_id, simulated) VALUES (?, ?, ?)`,
+		testWorkflowID, "project-1", simulated).Error)
+	require.NoError(t, db.Exec(`INSERT INTO projects (id, resource_limits) VALUES (?, ?)`,
+		"project-1", []byte(`{"max_storage_bytes": 10}`)).Error)
+
+	activities := NewActivities(db, zap.NewNop(), nil, nil)
+	activities.storage = services.NewStorageAccountant(db, nil, &config.StorageConfig{}, zap.NewNop())
+	store, err := services.NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	activities.artifacts = services.NewArtifactService(db, store, &config.ArtifactsConfig{MaxDiffBytes: 1024}, zap.NewNop())
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+	return db, activities, env
+}
+
+func TestStoreArtifactsActivity_RecordsArtifactsAgainstQuota(t *testing.T) {
+	db, _, env := setupArtifactsTest(t, false)
+
+	artifacts := []Artifact{
+		{ID: "task-1-main.go", Name: "main.go", Type: "code", Size: 6},
+		{ID: "task-1-README.md", Name: "README.md", Type: "docs", Size: 4},
+	}
+	// Retries of the activity record each artifact once
+	for i := 0; i < 2; i++ {
+		_, err := env.ExecuteActivity("StoreArtifactsActivity", "project-1", artifacts)
+		require.NoError(t, err)
+	}
+
+	var stored []models.Artifact
+	require.NoError(t, db.Order("name").Find(&stored).Error)
+	require.Len(t, stored, 2)
+	assert.Equal(t, artifactRecordID(testWorkflowID, "store-artifacts"), stored[0].ExecutionID)
+	var usage []models.ProjectStorageUsage
+	require.NoError(t, db.Order("artifact_type").Find(&usage).Error)
+	require.Len(t, usage, 2)
+	assert.Equal(t, int64(6), usage[0].Bytes)
+	assert.Equal(t, int64(4), usage[1].Bytes)
+
+	// The project is at its quota
+	_, err := env.ExecuteActivity("StoreArtifactsActivity", "project-1", []Artifact{{ID: "task-2-main.go", Type: "code", Size: 1}})
+	require.Error(t, err)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(err, &appErr))
+	assert.True(t, appErr.NonRetryable())
+	assert.Equal(t, storageQuotaExceededError, appErr.Type())
+
+	var execution models.Execution
+	require.NoError(t, db.First(&execution, "id = ?", artifactRecordID(testWorkflowID, "store-artifacts")).Error)
+	assert.Equal(t, models.ExecutionStatusFailed, execution.Status)
+	assert.Contains(t, execution.Error, "storage quota exceeded")
+}
+
+func TestStoreArtifactsActivity_SkipsSimulatedWorkflows(t *testing.T) {
+	db, _, env := setupArtifactsTest(t, true)
+
+	_, err := env.ExecuteActivity("StoreArtifactsActivity", "project-1", []Artifact{{ID: "a", Type: "code", Size: 100}})
+	require.NoError(t, err)
+
+	var count int64
+	require.NoError(t, db.Model(&models.Artifact{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestStoreArtifactsActivity_VersionsRegeneratedArtifacts(t *testing.T) {
+	db, activities, env := setupArtifactsTest(t, false)
+	ctx := context.Background()
+
+	// Refinement rounds record their artifacts under the iterative execution
+	execution := &models.Execution{ID: "exec-iterative", ProjectID: "project-1", WorkflowID: testWorkflowID}
+	activities.recordRoundArtifacts(ctx, execution, []Artifact{
+		{ID: "task-1-main.go", Name: "main.go", Path: "src/main.go", Type: "code", Content: "v1\n", Size: 3},
+	})
+	activities.recordRoundArtifacts(ctx, execution, []Artifact{
+		{ID: "task-1-main.go", Name: "main.go", Path: "src/main.go", Type: "code", Content: "v2\n", Size: 3},
+	})
+	// The final artifacts of the task are those of its last round
+	_, err := env.ExecuteActivity("StoreArtifactsActivity", "project-1", []Artifact{
+		{ID: "task-1-main.go", Name: "main.go", Path: "src/main.go", Type: "code", Content: "v2\n", Size: 3},
+	})
+	require.NoError(t, err)
+
+	var versions []models.Artifact
+	require.NoError(t, db.Order("version").Find(&versions).Error)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "src/main.go", versions[1].LogicalKey)
+	assert.Equal(t, testWorkflowID, versions[1].WorkflowID)
+	assert.Equal(t, 2, versions[1].Version)
+	require.NotNil(t, versions[1].ParentArtifactID)
+	assert.Equal(t, versions[0].ID, *versions[1].ParentArtifactID)
+
+	diff, err := activities.artifacts.Diff(ctx, versions[1].ID, versions[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, services.ArtifactDiffText, diff.Mode)
+	assert.Contains(t, diff.Diff, "-v1\n+v2\n")
+}
+
+func TestStoreArtifactsActivity_KeepsContentsInWorkflowRegion(t *testing.T) {
+	db, activities, env := setupArtifactsTest(t, false)
+	ctx := context.Background()
+	newStore := func() services.OutputStore {
+		store, err := services.NewFileOutputStore(t.TempDir())
+		require.NoError(t, err)
+		return store
+	}
+	eu, fallback := newStore(), newStore()
+	activities.artifacts = services.NewArtifactService(db,
+		services.NewRegionalOutputStore(map[string]services.OutputStore{"eu-west-1": eu}, fallback),
+		&config.ArtifactsConfig{MaxDiffBytes: 1024}, zap.NewNop())
+	require.NoError(t, db.Exec(`UPDATE workflows SET metadata = ? WHERE id = ?`, []byte(`{"region": "eu-west-1"}`), testWorkflowID).Error)
+
+	_, err := env.ExecuteActivity("StoreArtifactsActivity", "project-1", []Artifact{
+		{ID: "task-1-main.go", Name: "main.go", Type: "code", Content: "eu\n", Size: 3},
+	})
+	require.NoError(t, err)
+
+	var artifact models.Artifact
+	require.NoError(t, db.First(&artifact).Error)
+	data, err := eu.Get(ctx, artifact.Checksum)
+	require.NoError(t, err)
+	assert.Equal(t, "eu\n", string(data))
+	_, err = fallback.Get(ctx, artifact.Checksum)
+	assert.ErrorIs(t, err, services.ErrOutputNotFound, "contents of pinned workflows stay in their region's bucket")
+
+	// Regions without a bucket fail the store rather than leave the region
+	require.NoError(t, db.Exec(`UPDATE workflows SET metadata = ? WHERE id = ?`, []byte(`{"region": "ap-south-1"}`), testWorkflowID).Error)
+	_, err = env.ExecuteActivity("StoreArtifactsActivity", "project-1", []Artifact{
+		{ID: "task-2-main.go", Name: "main.go", Type: "code", Content: "ap\n", Size: 3},
+	})
+	assert.ErrorContains(t, err, "no artifact bucket for region ap-south-1")
+}
@@ -0,0 +1,40 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+// ExecuteBatchActivity runs the same or different tasks on many agents in one
+// activity, heartbeating the number of completed tasks. Individual task
+// failures are reported in the result; the activity only fails for invalid
+// batches and aborted fail-fast batches.
+func (a *Activities) ExecuteBatchActivity(ctx context.Context, requests []services.AgentTaskRequest, opts services.BatchOptions, sim *services.SimulationConfig) (*services.BatchResult, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Executing task batch",
+		zap.Int("tasks", len(requests)),
+		zap.Int("maxParallel", opts.MaxParallel),
+		zap.Bool("failFast", opts.FailFast))
+
+	total := len(requests)
+	recordProgress(ctx, 0, "executing_batch", fmt.Sprintf("0/%d tasks completed", total))
+	opts.OnProgress = func(completed, total int) {
+		recordProgress(ctx, 100*completed/total, "executing_batch", fmt.Sprintf("%d/%d tasks completed", completed, total))
+	}
+
+	result, err := services.ExecuteTaskBatch(ctx, agentExecutorFor(a.agentClient, sim), requests, opts)
+	if err != nil {
+		return result, fmt.Errorf("failed to execute task batch: %w", err)
+	}
+
+	logger.Info("Task batch completed",
+		zap.Int("succeeded", result.Succeeded),
+		zap.Int("failed", result.Failed),
+		zap.Int("skipped", result.Skipped))
+	return result, nil
+}
@@ -0,0 +1,109 @@
+package temporal
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+// heartbeatRecorder captures every heartbeat an activity records, which the
+// test environment's throttled heartbeat listener does not
+type heartbeatRecorder struct {
+	interceptor.WorkerInterceptorBase
+	mu       sync.Mutex
+	progress []services.ActivityProgress
+}
+
+func (r *heartbeatRecorder) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	return &heartbeatInbound{ActivityInboundInterceptorBase: interceptor.ActivityInboundInterceptorBase{Next: next}, recorder: r}
+}
+
+type heartbeatInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+	recorder *heartbeatRecorder
+}
+
+func (i *heartbeatInbound) Init(outbound interceptor.ActivityOutboundInterceptor) error {
+	return i.Next.Init(&heartbeatOutbound{ActivityOutboundInterceptorBase: interceptor.ActivityOutboundInterceptorBase{Next: outbound}, recorder: i.recorder})
+}
+
+type heartbeatOutbound struct {
+	interceptor.ActivityOutboundInterceptorBase
+	recorder *heartbeatRecorder
+}
+
+func (o *heartbeatOutbound) RecordHeartbeat(ctx context.Context, details ...interface{}) {
+	o.recorder.mu.Lock()
+	for _, detail := range details {
+		if progress, ok := detail.(services.ActivityProgress); ok {
+			o.recorder.progress = append(o.recorder.progress, progress)
+		}
+	}
+	o.recorder.mu.Unlock()
+	o.Next.RecordHeartbeat(ctx, details...)
+}
+
+func TestExecuteBatchActivity_HeartbeatsProgress(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	recorder := &heartbeatRecorder{}
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{recorder}})
+
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+	env.RegisterActivity(activities.ExecuteBatchActivity)
+
+	sim := &services.SimulationConfig{Enabled: true, Fixtures: services.StubFixtures{
+		"broken_check": {Error: "environment unreachable"},
+	}}
+	requests := []services.AgentTaskRequest{
+		{AgentID: "env-dev", Task: services.ExecuteTaskRequest{Type: "smoke_test"}},
+		{AgentID: "env-staging", Task: services.ExecuteTaskRequest{Type: "broken_check"}},
+		{AgentID: "env-prod", Task: services.ExecuteTaskRequest{Type: "smoke_test"}},
+		{AgentID: "env-perf", Task: services.ExecuteTaskRequest{Type: "smoke_test"}},
+	}
+
+	value, err := env.ExecuteActivity(activities.ExecuteBatchActivity, requests, services.BatchOptions{MaxParallel: 2}, sim)
+	require.NoError(t, err)
+
+	var result services.BatchResult
+	require.NoError(t, value.Get(&result))
+	assert.Equal(t, 3, result.Succeeded)
+	assert.Equal(t, 1, result.Failed)
+	assert.Contains(t, result.Results[1].Error, "environment unreachable")
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	require.Len(t, recorder.progress, 5)
+	assert.Equal(t, services.ActivityProgress{Percent: 0, Step: "executing_batch", Message: "0/4 tasks completed"}, recorder.progress[0])
+	assert.Equal(t, services.ActivityProgress{Percent: 50, Step: "executing_batch", Message: "2/4 tasks completed"}, recorder.progress[2])
+	assert.Equal(t, services.ActivityProgress{Percent: 100, Step: "executing_batch", Message: "4/4 tasks completed"}, recorder.progress[4])
+}
+
+func TestExecuteBatchActivity_FailFastFailsActivity(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+	env.RegisterActivity(activities.ExecuteBatchActivity)
+
+	sim := &services.SimulationConfig{Enabled: true, Fixtures: services.StubFixtures{
+		"broken_check": {Error: "environment unreachable"},
+	}}
+	requests := []services.AgentTaskRequest{
+		{AgentID: "env-staging", Task: services.ExecuteTaskRequest{Type: "broken_check"}},
+		{AgentID: "env-prod", Task: services.ExecuteTaskRequest{Type: "smoke_test"}},
+	}
+
+	_, err := env.ExecuteActivity(activities.ExecuteBatchActivity, requests,
+		services.BatchOptions{MaxParallel: 1, FailFast: true}, sim)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "task batch aborted")
+}
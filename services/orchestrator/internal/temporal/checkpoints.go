@@ -0,0 +1,120 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// checkpointUnusableError is the error type of re-runs whose checkpoint is
+// gone or of another schema version; retrying does not help
+const checkpointUnusableError = "CheckpointUnusable"
+
+// IntentCheckpoint holds the phase outputs an intent workflow re-run resumes
+// with. Plan is only set for re-runs from the plan.
+type IntentCheckpoint struct {
+	Analysis *IntentAnalysisResult `json:"analysis,omitempty"`
+	Plan     *ExecutionPlan        `json:"plan,omitempty"`
+}
+
+// saveCheckpoint keeps the output of a phase of the activity's workflow,
+// replacing the one kept by an earlier attempt
+func (a *Activities) saveCheckpoint(ctx context.Context, phase models.CheckpointPhase, output interface{}) error {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s checkpoint: %w", phase, err)
+	}
+	return a.storeCheckpoint(ctx, &models.WorkflowCheckpoint{
+		WorkflowID:    activity.GetInfo(ctx).WorkflowExecution.ID,
+		Phase:         phase,
+		SchemaVersion: services.CheckpointSchemaVersion(phase),
+		Data:          data,
+	})
+}
+
+// storeCheckpoint upserts a checkpoint by workflow and phase
+func (a *Activities) storeCheckpoint(ctx context.Context, checkpoint *models.WorkflowCheckpoint) error {
+	err := a.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "workflow_id"}, {Name: "phase"}},
+		DoUpdates: clause.AssignmentColumns([]string{"schema_version", "data", "created_at"}),
+	}).Create(checkpoint).Error
+	if err != nil {
+		return fmt.Errorf("failed to save %s checkpoint: %w", checkpoint.Phase, err)
+	}
+	return nil
+}
+
+// LoadCheckpointActivity loads the checkpoints a re-run resumes from and
+// keeps copies for the re-run's own workflow, so it can be re-run from them
+// in turn. The risk decision of a reused analysis is recorded for the
+// re-run, which must pass the risk gate again.
+func (a *Activities) LoadCheckpointActivity(ctx context.Context, ref models.CheckpointRef) (*IntentCheckpoint, error) {
+	phases := []models.CheckpointPhase{models.CheckpointPhaseAnalysis}
+	if ref.From == models.CheckpointPhasePlan {
+		phases = append(phases, models.CheckpointPhasePlan)
+	}
+
+	result := &IntentCheckpoint{}
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+	for _, phase := range phases {
+		var checkpoint models.WorkflowCheckpoint
+		err := a.db.WithContext(ctx).
+			Where("workflow_id = ? AND phase = ?", ref.WorkflowID, phase).
+			Take(&checkpoint).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("workflow %s kept no %s checkpoint", ref.WorkflowID, phase), checkpointUnusableError, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s checkpoint: %w", phase, err)
+		}
+		// The format may have changed since the re-run was requested
+		if err := services.ValidateCheckpoint(&checkpoint); err != nil {
+			return nil, temporal.NewNonRetryableApplicationError(err.Error(), checkpointUnusableError, err)
+		}
+
+		switch phase {
+		case models.CheckpointPhaseAnalysis:
+			err = json.Unmarshal(checkpoint.Data, &result.Analysis)
+		case models.CheckpointPhasePlan:
+			err = json.Unmarshal(checkpoint.Data, &result.Plan)
+		}
+		if err != nil {
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("failed to decode %s checkpoint: %v", phase, err), checkpointUnusableError, err)
+		}
+
+		if err := a.storeCheckpoint(ctx, &models.WorkflowCheckpoint{
+			WorkflowID:    workflowID,
+			Phase:         phase,
+			SchemaVersion: checkpoint.SchemaVersion,
+			Data:          checkpoint.Data,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if ref.From == models.CheckpointPhaseAnalysis && a.risks != nil &&
+		result.Analysis != nil && result.Analysis.RiskDecision != nil {
+		var workflow models.Workflow
+		if err := a.db.WithContext(ctx).Select("id", "project_id").
+			First(&workflow, "id = ?", workflowID).Error; err != nil {
+			return nil, fmt.Errorf("workflow not found: %w", err)
+		}
+		if err := a.risks.RecordDecision(ctx, &workflow, result.Analysis.RiskDecision); err != nil {
+			return nil, err
+		}
+	}
+
+	activity.RecordHeartbeat(ctx, "Checkpoint loaded")
+	return result, nil
+}
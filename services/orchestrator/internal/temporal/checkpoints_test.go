@@ -0,0 +1,249 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func createCheckpointsTable(t *testing.T, db *gorm.DB) {
+	require.NoError(t, db.Exec(`CREATE TABLE workflow_checkpoints (
+		id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), workflow_id TEXT, phase TEXT,
+		schema_version INTEGER, data BLOB, created_at DATETIME, UNIQUE (workflow_id, phase))`).Error)
+}
+
+func loadCheckpoint(t *testing.T, db *gorm.DB, workflowID string, phase models.CheckpointPhase) *models.WorkflowCheckpoint {
+	var checkpoint models.WorkflowCheckpoint
+	require.NoError(t, db.Where("workflow_id = ? AND phase = ?", workflowID, phase).Take(&checkpoint).Error)
+	return &checkpoint
+}
+
+func TestIntentActivities_SaveCheckpoints(t *testing.T) {
+	db, env, _ := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{
+		IntentType:     "deployment",
+		Confidence:     0.9,
+		RequiredParams: []string{"build", "deploy"},
+	})
+	createCheckpointsTable(t, db)
+
+	val, err := env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Content: "deploy the api"})
+	require.NoError(t, err)
+	var analysis IntentAnalysisResult
+	require.NoError(t, val.Get(&analysis))
+	// A retried activity replaces its checkpoint
+	_, err = env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Content: "deploy the api"})
+	require.NoError(t, err)
+
+	checkpoint := loadCheckpoint(t, db, testWorkflowID, models.CheckpointPhaseAnalysis)
+	assert.Equal(t, services.IntentAnalysisCheckpointVersion, checkpoint.SchemaVersion)
+	var saved IntentAnalysisResult
+	require.NoError(t, json.Unmarshal(checkpoint.Data, &saved))
+	assert.Equal(t, "deployment", saved.IntentType)
+	assert.Equal(t, []string{"build", "deploy"}, saved.Actions)
+
+	val, err = env.ExecuteActivity("CreateExecutionPlanActivity", analysis)
+	require.NoError(t, err)
+	var plan ExecutionPlan
+	require.NoError(t, val.Get(&plan))
+
+	checkpoint = loadCheckpoint(t, db, testWorkflowID, models.CheckpointPhasePlan)
+	assert.Equal(t, services.ExecutionPlanCheckpointVersion, checkpoint.SchemaVersion)
+	var savedPlan ExecutionPlan
+	require.NoError(t, json.Unmarshal(checkpoint.Data, &savedPlan))
+	assert.Equal(t, plan, savedPlan)
+}
+
+func TestLoadCheckpointActivity(t *testing.T) {
+	db, env, activities := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{})
+	createCheckpointsTable(t, db)
+	require.NoError(t, db.AutoMigrate(&models.IntentRiskReview{}))
+	require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`).Error)
+	activities.risks = services.NewRiskGate(db, &config.RiskPolicyConfig{DefaultAction: "allow"}, &riskEvents{}, zap.NewNop())
+
+	analysis := IntentAnalysisResult{
+		IntentType: "deployment",
+		Actions:    []string{"deploy"},
+		RiskDecision: &services.RiskDecision{
+			Action:         models.RiskActionRequireConfirmation,
+			TriggeredRisks: []string{"restarts production pods"},
+		},
+	}
+	plan := ExecutionPlan{Steps: []ExecutionStep{{ID: "step-1", Name: "deploy", Type: "action"}}}
+	for phase, output := range map[models.CheckpointPhase]interface{}{
+		models.CheckpointPhaseAnalysis: analysis,
+		models.CheckpointPhasePlan:     plan,
+	} {
+		data, err := json.Marshal(output)
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&models.WorkflowCheckpoint{
+			WorkflowID:    "source",
+			Phase:         phase,
+			SchemaVersion: services.CheckpointSchemaVersion(phase),
+			Data:          data,
+		}).Error)
+	}
+
+	t.Run("from plan", func(t *testing.T) {
+		val, err := env.ExecuteActivity("LoadCheckpointActivity", models.CheckpointRef{WorkflowID: "source", From: models.CheckpointPhasePlan})
+		require.NoError(t, err)
+		var loaded IntentCheckpoint
+		require.NoError(t, val.Get(&loaded))
+		assert.Equal(t, &analysis, loaded.Analysis)
+		assert.Equal(t, &plan, loaded.Plan)
+
+		// The re-run keeps its own copies
+		assert.JSONEq(t, string(loadCheckpoint(t, db, "source", models.CheckpointPhasePlan).Data),
+			string(loadCheckpoint(t, db, testWorkflowID, models.CheckpointPhasePlan).Data))
+		loadCheckpoint(t, db, testWorkflowID, models.CheckpointPhaseAnalysis)
+
+		// The plan was past the risk gate already
+		var reviews int64
+		require.NoError(t, db.Model(&models.IntentRiskReview{}).Count(&reviews).Error)
+		assert.Zero(t, reviews)
+	})
+
+	t.Run("from analysis", func(t *testing.T) {
+		val, err := env.ExecuteActivity("LoadCheckpointActivity", models.CheckpointRef{WorkflowID: "source", From: models.CheckpointPhaseAnalysis})
+		require.NoError(t, err)
+		var loaded IntentCheckpoint
+		require.NoError(t, val.Get(&loaded))
+		assert.Equal(t, &analysis, loaded.Analysis)
+		assert.Nil(t, loaded.Plan)
+
+		// The reused risk decision must be confirmed for the re-run
+		var review models.IntentRiskReview
+		require.NoError(t, db.First(&review, "workflow_id = ?", testWorkflowID).Error)
+		assert.Equal(t, models.RiskReviewPending, review.Status)
+	})
+
+	t.Run("unusable checkpoints", func(t *testing.T) {
+		require.NoError(t, db.Model(&models.WorkflowCheckpoint{}).
+			Where("workflow_id = ? AND phase = ?", "source", models.CheckpointPhasePlan).
+			Update("schema_version", services.ExecutionPlanCheckpointVersion+1).Error)
+
+		for _, ref := range []models.CheckpointRef{
+			{WorkflowID: "source", From: models.CheckpointPhasePlan},
+			{WorkflowID: "missing", From: models.CheckpointPhaseAnalysis},
+		} {
+			_, err := env.ExecuteActivity("LoadCheckpointActivity", ref)
+			var appErr *temporal.ApplicationError
+			require.True(t, errors.As(err, &appErr), ref.WorkflowID)
+			assert.Equal(t, checkpointUnusableError, appErr.Type())
+			assert.True(t, appErr.NonRetryable())
+		}
+	})
+}
+
+// rerunIntentEnv runs an intent processing workflow with stub activities,
+// counting the calls to each and keeping the names of the executed steps
+func rerunIntentEnv(t *testing.T) (*testsuite.TestWorkflowEnvironment, *WorkflowEngine, map[string]int, *[]string) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	env.RegisterWorkflow(engine.IntentProcessingWorkflow)
+
+	calls := make(map[string]int)
+	var executed []string
+	register := func(name string, fn interface{}) {
+		env.RegisterActivityWithOptions(fn, activity.RegisterOptions{Name: name})
+	}
+	register("LoadCheckpointActivity", func(ctx context.Context, ref models.CheckpointRef) (*IntentCheckpoint, error) {
+		calls["LoadCheckpointActivity"]++
+		checkpoint := &IntentCheckpoint{Analysis: &IntentAnalysisResult{IntentType: "deployment", Actions: []string{"reused"}}}
+		if ref.From == models.CheckpointPhasePlan {
+			checkpoint.Plan = &ExecutionPlan{Steps: []ExecutionStep{{ID: "step-1", Name: "reused", Type: "action"}}}
+		}
+		return checkpoint, nil
+	})
+	register("AnalyzeIntentActivity", func(ctx context.Context, intent IntentData) (*IntentAnalysisResult, error) {
+		calls["AnalyzeIntentActivity"]++
+		return &IntentAnalysisResult{IntentType: "deployment", Actions: []string{"analyzed"}}, nil
+	})
+	register("CreateExecutionPlanActivity", func(ctx context.Context, analysis IntentAnalysisResult) (*ExecutionPlan, error) {
+		calls["CreateExecutionPlanActivity"]++
+		return &ExecutionPlan{Steps: []ExecutionStep{{ID: "step-1", Name: "planned from " + analysis.Actions[0], Type: "action"}}}, nil
+	})
+	register("ExecuteStepActivity", func(ctx context.Context, step ExecutionStep, sim *services.SimulationConfig) (*StepResult, error) {
+		calls["ExecuteStepActivity"]++
+		executed = append(executed, step.Name)
+		return &StepResult{StepID: step.ID, Status: models.ExecutionStatusSucceeded}, nil
+	})
+	register("AggregateResultsActivity", func(ctx context.Context, results []StepResult) (*WorkflowResult, error) {
+		calls["AggregateResultsActivity"]++
+		return &WorkflowResult{Status: "completed"}, nil
+	})
+	return env, engine, calls, &executed
+}
+
+func TestIntentProcessingWorkflow_Rerun(t *testing.T) {
+	tests := []struct {
+		name     string
+		ref      *models.CheckpointRef
+		executed string
+		calls    map[string]int
+	}{
+		{"from start", nil, "planned from analyzed", map[string]int{
+			"AnalyzeIntentActivity": 1, "CreateExecutionPlanActivity": 1, "ExecuteStepActivity": 1, "AggregateResultsActivity": 1,
+		}},
+		{"from analysis", &models.CheckpointRef{WorkflowID: "source", From: models.CheckpointPhaseAnalysis}, "planned from reused", map[string]int{
+			"LoadCheckpointActivity": 1, "CreateExecutionPlanActivity": 1, "ExecuteStepActivity": 1, "AggregateResultsActivity": 1,
+		}},
+		{"from plan", &models.CheckpointRef{WorkflowID: "source", From: models.CheckpointPhasePlan}, "reused", map[string]int{
+			"LoadCheckpointActivity": 1, "ExecuteStepActivity": 1, "AggregateResultsActivity": 1,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, engine, calls, executed := rerunIntentEnv(t)
+			input, err := json.Marshal(IntentData{Content: "deploy the api", Checkpoint: tt.ref})
+			require.NoError(t, err)
+			wf := &models.Workflow{ID: "wf-1", Type: models.WorkflowTypeIntent, Input: input}
+
+			env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+			assert.Equal(t, tt.calls, calls)
+			assert.Equal(t, []string{tt.executed}, *executed)
+
+			state := queryState(t, env)
+			assert.Equal(t, []string{intentStepAnalyze, intentStepPlan, intentStepExecute, intentStepAggregate}, state.CompletedSteps)
+			assert.Empty(t, state.PendingSteps)
+		})
+	}
+}
+
+func TestIntentProcessingWorkflow_RerunFailsOnUnusableCheckpoint(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	env.RegisterWorkflow(engine.IntentProcessingWorkflow)
+	env.RegisterActivityWithOptions(func(ctx context.Context, ref models.CheckpointRef) (*IntentCheckpoint, error) {
+		return nil, temporal.NewNonRetryableApplicationError("plan checkpoint is version 0, expected 1", checkpointUnusableError, nil)
+	}, activity.RegisterOptions{Name: "LoadCheckpointActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, intent IntentData) (*IntentAnalysisResult, error) {
+		return &IntentAnalysisResult{}, nil
+	}, activity.RegisterOptions{Name: "AnalyzeIntentActivity"})
+	env.OnActivity("AnalyzeIntentActivity", mock.Anything, mock.Anything).Never()
+
+	input, err := json.Marshal(IntentData{Checkpoint: &models.CheckpointRef{WorkflowID: "source", From: models.CheckpointPhasePlan}})
+	require.NoError(t, err)
+	env.ExecuteWorkflow(engine.IntentProcessingWorkflow, &models.Workflow{ID: "wf-1", Type: models.WorkflowTypeIntent, Input: input})
+
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), "failed to load checkpoint")
+	assert.ErrorContains(t, env.GetWorkflowError(), "plan checkpoint is version 0, expected 1")
+}
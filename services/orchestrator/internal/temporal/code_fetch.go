@@ -0,0 +1,66 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+// repositoryUnavailableError is the type of the non-retryable error returned
+// when a repository cannot be fetched with the project's credentials, or the
+// branch or path does not exist
+const repositoryUnavailableError = "RepositoryUnavailable"
+
+// FetchCodeActivity fetches the files of the requested branch and path of a
+// repository for analysis, with the commit they were read at
+func (a *Activities) FetchCodeActivity(ctx context.Context, req CodeAnalysisRequest) (*CodeData, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Fetching code", zap.String("repository", req.Repository))
+
+	if a.repositories == nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			"code cannot be fetched: repositories are not configured", repositoryUnavailableError, nil)
+	}
+
+	fetched, err := a.repositories.Fetch(ctx, &services.RepositoryFetchRequest{
+		ProjectID:   req.ProjectID,
+		URL:         req.Repository,
+		Branch:      req.Branch,
+		Path:        req.Path,
+		Include:     req.Include,
+		Integration: req.Integration,
+	})
+	if errors.Is(err, services.ErrRepositoryAuth) || errors.Is(err, services.ErrRepositoryNotFound) ||
+		errors.Is(err, services.ErrRepositoryURLNotAllowed) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), repositoryUnavailableError, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	codeData := &CodeData{
+		Files:   fetched.Files,
+		Content: fetched.Content,
+		Metadata: map[string]interface{}{
+			"repository":   req.Repository,
+			"branch":       fetched.Branch,
+			"path":         req.Path,
+			"commit":       fetched.Commit,
+			"author":       fetched.Author,
+			"message":      fetched.Message,
+			"committed_at": fetched.CommittedAt.Format(time.RFC3339),
+			"bytes":        fetched.Bytes,
+			"skipped":      fetched.Skipped,
+			"truncated":    fetched.Truncated,
+		},
+	}
+
+	activity.RecordHeartbeat(ctx, "Code fetched")
+	return codeData, nil
+}
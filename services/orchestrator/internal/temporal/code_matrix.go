@@ -0,0 +1,435 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+const (
+	maxMatrixCombinations = 20
+	maxMatrixParallelism  = 10
+
+	// Outputs are diffed on their first lines only, and diffs are capped
+	maxMatrixDiffInputLines = 500
+	maxMatrixDiffLines      = 50
+)
+
+// MatrixCombination is one language, runtime and environment a code
+// execution request runs on
+type MatrixCombination struct {
+	Language    string            `json:"language"`
+	Runtime     string            `json:"runtime,omitempty"`     // Runtime version, e.g. 3.11 or 1.22
+	Environment map[string]string `json:"environment,omitempty"` // Merged over the request's environment
+}
+
+// Key identifies the combination, e.g. python@3.11 {DEBUG=1}
+func (c MatrixCombination) Key() string {
+	key := c.Language
+	if c.Runtime != "" {
+		key += "@" + c.Runtime
+	}
+	if len(c.Environment) > 0 {
+		names := make([]string, 0, len(c.Environment))
+		for name := range c.Environment {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		pairs := make([]string, len(names))
+		for i, name := range names {
+			pairs[i] = name + "=" + c.Environment[name]
+		}
+		key += " {" + strings.Join(pairs, ",") + "}"
+	}
+	return key
+}
+
+// forCombination returns the single execution request of a combination
+func (r CodeExecutionRequest) forCombination(c MatrixCombination) CodeExecutionRequest {
+	environment := make(map[string]string, len(r.Environment)+len(c.Environment))
+	for name, value := range r.Environment {
+		environment[name] = value
+	}
+	for name, value := range c.Environment {
+		environment[name] = value
+	}
+	r.Language = c.Language
+	r.Runtime = c.Runtime
+	r.Environment = environment
+	r.Matrix = nil
+	r.MaxParallel = 0
+	return r
+}
+
+// validateMatrix checks the matrix of a request and returns how many
+// combinations may run at once, defaultParallel unless the request says
+func validateMatrix(req CodeExecutionRequest, defaultParallel int) (int, error) {
+	if len(req.Matrix) > maxMatrixCombinations {
+		return 0, fmt.Errorf("matrix has %d combinations, at most %d are allowed", len(req.Matrix), maxMatrixCombinations)
+	}
+	if req.MaxParallel < 0 || req.MaxParallel > maxMatrixParallelism {
+		return 0, fmt.Errorf("max_parallel must be between 1 and %d", maxMatrixParallelism)
+	}
+	seen := make(map[string]bool, len(req.Matrix))
+	for i, combination := range req.Matrix {
+		if combination.Language == "" {
+			return 0, fmt.Errorf("matrix combination %d has no language", i)
+		}
+		key := combination.Key()
+		if seen[key] {
+			return 0, fmt.Errorf("matrix combination %s is listed twice", key)
+		}
+		seen[key] = true
+	}
+
+	parallel := req.MaxParallel
+	if parallel == 0 {
+		parallel = defaultParallel
+	}
+	if parallel > len(req.Matrix) {
+		parallel = len(req.Matrix)
+	}
+	return parallel, nil
+}
+
+// MatrixRunResult is the execution of the request on one combination
+type MatrixRunResult struct {
+	Key         string            `json:"key"`
+	Combination MatrixCombination `json:"combination"`
+	ExecutionID string            `json:"execution_id,omitempty"`
+	AgentID     string            `json:"agent_id,omitempty"`
+	Success     bool              `json:"success"`
+	ExitCode    *int              `json:"exit_code,omitempty"` // Unset when the code never ran
+	Output      string            `json:"output,omitempty"`    // Preview when the output was offloaded
+	Error       string            `json:"error,omitempty"`
+	Duration    int64             `json:"duration_ms"`
+
+	OffloadedOutput *models.OffloadedOutput `json:"offloaded_output,omitempty"`
+}
+
+// CodeMatrixResult is the output of a code execution workflow with a matrix
+type CodeMatrixResult struct {
+	Runs    []MatrixRunResult `json:"runs"`
+	Summary CodeMatrixSummary `json:"summary"`
+}
+
+// CodeMatrixSummary compares the runs of a matrix with its first
+// combination, the baseline
+type CodeMatrixSummary struct {
+	Total        int                `json:"total"`
+	Passed       int                `json:"passed"`
+	Failed       int                `json:"failed"`
+	Consistent   bool               `json:"consistent"` // Every combination passed with the baseline's output
+	Baseline     string             `json:"baseline"`
+	Fastest      string             `json:"fastest,omitempty"`
+	Slowest      string             `json:"slowest,omitempty"`
+	Combinations []MatrixComparison `json:"combinations"`
+}
+
+// MatrixComparison compares a combination's run with the baseline's
+type MatrixComparison struct {
+	Key           string   `json:"key"`
+	Passed        bool     `json:"passed"`
+	ExitCode      *int     `json:"exit_code,omitempty"`
+	Duration      int64    `json:"duration_ms"`
+	DurationDelta int64    `json:"duration_delta_ms"`
+	OutputMatches bool     `json:"output_matches"`
+	OutputDiff    []string `json:"output_diff,omitempty"` // Baseline lines missing (-) and extra lines (+)
+	Error         string   `json:"error,omitempty"`
+}
+
+// runCodeMatrix runs the request once per matrix combination, at most
+// parallel at a time. A failing combination does not stop the others.
+func (w *WorkflowEngine) runCodeMatrix(ctx workflow.Context, req CodeExecutionRequest, parallel int, sim *services.SimulationConfig) (*CodeMatrixResult, error) {
+	runs := make([]MatrixRunResult, len(req.Matrix))
+	slots := workflow.NewBufferedChannel(ctx, parallel)
+	wg := workflow.NewWaitGroup(ctx)
+	for i, combination := range req.Matrix {
+		i, combination := i, combination
+		slots.Send(ctx, true)
+		wg.Add(1)
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			defer wg.Done()
+			defer slots.Receive(ctx, nil)
+			runs[i] = w.runMatrixCombination(ctx, req.forCombination(combination), combination, sim)
+		})
+	}
+	wg.Wait(ctx)
+
+	var summary CodeMatrixSummary
+	if err := workflow.ExecuteActivity(ctx, ActivitySummarizeCodeMatrix, runs).Get(ctx, &summary); err != nil {
+		return nil, fmt.Errorf("failed to summarize matrix: %w", err)
+	}
+	return &CodeMatrixResult{Runs: runs, Summary: summary}, nil
+}
+
+// runMatrixCombination executes the request of one combination and records
+// the run as an execution
+func (w *WorkflowEngine) runMatrixCombination(ctx workflow.Context, req CodeExecutionRequest, combination MatrixCombination, sim *services.SimulationConfig) MatrixRunResult {
+	logger := workflow.GetLogger(ctx)
+	run := MatrixRunResult{Key: combination.Key(), Combination: combination}
+
+	started := workflow.Now(ctx)
+	if err := w.executeMatrixCombination(ctx, req, sim, &run); err != nil {
+		logger.Error("Matrix combination failed", "combination", run.Key, "error", err)
+		run.Error = err.Error()
+	}
+	// Agents report how long the code ran; otherwise the whole run counts
+	if run.Duration == 0 {
+		run.Duration = workflow.Now(ctx).Sub(started).Milliseconds()
+	}
+
+	if err := workflow.ExecuteActivity(ctx, ActivityRecordMatrixRun, run, sim).Get(ctx, &run.ExecutionID); err != nil {
+		logger.Error("Failed to record matrix run", "combination", run.Key, "error", err)
+	}
+	return run
+}
+
+func (w *WorkflowEngine) executeMatrixCombination(ctx workflow.Context, req CodeExecutionRequest, sim *services.SimulationConfig, run *MatrixRunResult) error {
+	var agent AgentInfo
+	if err := workflow.ExecuteActivity(ctx, ActivitySelectAgent, req, sim).Get(ctx, &agent); err != nil {
+		return fmt.Errorf("failed to select agent: %w", err)
+	}
+	run.AgentID = agent.ID
+
+	var envInfo EnvironmentInfo
+	if err := workflow.ExecuteActivity(ctx, ActivityPrepareEnvironment, agent, req, sim).Get(ctx, &envInfo); err != nil {
+		return fmt.Errorf("failed to prepare environment: %w", err)
+	}
+	defer func() {
+		if err := workflow.ExecuteActivity(ctx, ActivityCleanupEnvironment, envInfo).Get(ctx, nil); err != nil {
+			workflow.GetLogger(ctx).Error("Failed to cleanup environment", "combination", run.Key, "error", err)
+		}
+	}()
+
+	var result ExecutionResult
+	if err := workflow.ExecuteActivity(ctx, ActivityExecuteCode, agent, envInfo, req, sim).Get(ctx, &result); err != nil {
+		return fmt.Errorf("code execution failed: %w", err)
+	}
+	exitCode := result.ExitCode
+	run.ExitCode = &exitCode
+	run.Success = exitCode == 0
+	run.Output = result.Output
+	run.OffloadedOutput = result.OffloadedOutput
+	if duration, ok := result.Metrics["duration_ms"].(float64); ok {
+		run.Duration = int64(duration)
+	}
+	return nil
+}
+
+// RecordMatrixRunActivity stores the run of a matrix combination as an
+// execution of the workflow, with the combination in its metadata
+func (a *Activities) RecordMatrixRunActivity(ctx context.Context, run MatrixRunResult, sim *services.SimulationConfig) (string, error) {
+	wf, err := a.currentWorkflow(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var output []byte
+	if run.OffloadedOutput != nil {
+		output, err = json.Marshal(run.OffloadedOutput)
+	} else {
+		output, err = json.Marshal(map[string]interface{}{"output": run.Output})
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal output: %w", err)
+	}
+	metadata, err := json.Marshal(map[string]interface{}{"matrix_key": run.Key, "matrix": run.Combination})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	completedAt := time.Now()
+	startedAt := completedAt.Add(-time.Duration(run.Duration) * time.Millisecond)
+	execution := &models.Execution{
+		ProjectID:   wf.ProjectID,
+		WorkflowID:  wf.ID,
+		AgentID:     run.AgentID,
+		Name:        "Run " + run.Key,
+		Type:        models.ExecutionTypeCode,
+		Status:      models.ExecutionStatusSucceeded,
+		Language:    run.Combination.Language,
+		Runtime:     run.Combination.Runtime,
+		Output:      output,
+		Error:       run.Error,
+		ExitCode:    run.ExitCode,
+		StartedAt:   &startedAt,
+		CompletedAt: &completedAt,
+		Duration:    run.Duration,
+		Metadata:    metadata,
+		Simulated:   sim != nil,
+	}
+	if !run.Success {
+		execution.Status = models.ExecutionStatusFailed
+		if execution.Error == "" && run.ExitCode != nil {
+			execution.Error = fmt.Sprintf("exit code %d", *run.ExitCode)
+		}
+	}
+	if err := a.db.WithContext(ctx).Create(execution).Error; err != nil {
+		return "", fmt.Errorf("failed to create execution: %w", err)
+	}
+	a.recordExecutionMetrics(execution, nil)
+	// The full output becomes the output artifact of the execution
+	if offloaded := run.OffloadedOutput; offloaded != nil {
+		if err := a.outputs.RecordArtifact(ctx, wf.ProjectID, execution.ID, offloaded, "text/plain"); err != nil {
+			return "", err
+		}
+		output, err := json.Marshal(offloaded)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal output: %w", err)
+		}
+		// Written from the model, not a map, for it to be encrypted at rest
+		execution.Output = output
+		if err := a.db.WithContext(ctx).Model(execution).Select("output").Updates(execution).Error; err != nil {
+			return "", fmt.Errorf("failed to update execution output: %w", err)
+		}
+	}
+
+	activity.GetLogger(ctx).Info("Matrix run recorded",
+		zap.String("combination", run.Key),
+		zap.String("executionID", execution.ID))
+	return execution.ID, nil
+}
+
+// SummarizeCodeMatrixActivity compares the runs of a matrix
+func (a *Activities) SummarizeCodeMatrixActivity(ctx context.Context, runs []MatrixRunResult) (*CodeMatrixSummary, error) {
+	summary := summarizeCodeMatrix(runs)
+	activity.GetLogger(ctx).Info("Matrix summarized",
+		zap.Int("passed", summary.Passed),
+		zap.Int("failed", summary.Failed))
+	return &summary, nil
+}
+
+func summarizeCodeMatrix(runs []MatrixRunResult) CodeMatrixSummary {
+	summary := CodeMatrixSummary{Total: len(runs), Combinations: make([]MatrixComparison, len(runs))}
+	if len(runs) == 0 {
+		return summary
+	}
+
+	baseline := runs[0]
+	summary.Baseline = baseline.Key
+	summary.Consistent = true
+	var fastest, slowest *MatrixRunResult
+	for i := range runs {
+		run := &runs[i]
+		if run.Success {
+			summary.Passed++
+		} else {
+			summary.Failed++
+		}
+
+		comparison := MatrixComparison{
+			Key:           run.Key,
+			Passed:        run.Success,
+			ExitCode:      run.ExitCode,
+			Duration:      run.Duration,
+			DurationDelta: run.Duration - baseline.Duration,
+			OutputMatches: sameOutput(baseline, *run),
+			Error:         run.Error,
+		}
+		if !comparison.OutputMatches {
+			comparison.OutputDiff = diffLines(baseline.Output, run.Output)
+		}
+		summary.Combinations[i] = comparison
+		summary.Consistent = summary.Consistent && run.Success && comparison.OutputMatches
+
+		// Only runs that executed the code are compared by speed
+		if run.ExitCode == nil {
+			continue
+		}
+		if fastest == nil || run.Duration < fastest.Duration {
+			fastest = run
+		}
+		if slowest == nil || run.Duration > slowest.Duration {
+			slowest = run
+		}
+	}
+	if fastest != nil {
+		summary.Fastest = fastest.Key
+		summary.Slowest = slowest.Key
+	}
+	return summary
+}
+
+// diffLines returns the lines of before missing from after, prefixed with
+// "- ", and the lines of after not in before, prefixed with "+ ", in order
+func diffLines(before, after string) []string {
+	a := firstLines(before, maxMatrixDiffInputLines)
+	b := firstLines(after, maxMatrixDiffInputLines)
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for (i < len(a) || j < len(b)) && len(diff) < maxMatrixDiffLines {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	return diff
+}
+
+func firstLines(s string, n int) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return lines
+}
+
+// capabilityRuns reports whether an agent capability runs language at
+// runtime. Versions list the runtimes supported, comma separated; a runtime
+// matches a listed version or its patch releases (3.11 matches 3.11.4), and
+// "*" supports any runtime.
+func capabilityRuns(capability services.Capability, language, runtime string) bool {
+	if capability.Name != language && capability.Name != "multi-language" {
+		return false
+	}
+	if runtime == "" {
+		return true
+	}
+	for _, version := range strings.Split(capability.Version, ",") {
+		version = strings.TrimSpace(version)
+		if version == "*" || version == runtime || strings.HasPrefix(version, runtime+".") {
+			return true
+		}
+	}
+	return false
+}
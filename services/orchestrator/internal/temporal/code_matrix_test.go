@@ -0,0 +1,201 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// runtimeAgent runs code with a result per runtime, as the agents of a
+// fleet where each supports some Python versions
+type runtimeAgent struct {
+	fakeFleet
+	results map[string]map[string]interface{}
+
+	mu          sync.Mutex
+	running     int
+	maxRunning  int
+	executedOn  map[string]string // Agent by runtime
+	environment map[string]string
+}
+
+func (a *runtimeAgent) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	runtime, _ := req.Input["runtime"].(string)
+	if req.Type == "prepare_environment" {
+		a.mu.Lock()
+		if runtime == "3.12" {
+			a.environment = req.Input["environment"].(map[string]string)
+		}
+		a.mu.Unlock()
+		return &services.TaskExecution{ID: "env-" + runtime, Output: map[string]interface{}{}}, nil
+	}
+
+	a.mu.Lock()
+	a.running++
+	if a.running > a.maxRunning {
+		a.maxRunning = a.running
+	}
+	a.executedOn[runtime] = agentID
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.running--
+		a.mu.Unlock()
+	}()
+	return &services.TaskExecution{Output: a.results[runtime]}, nil
+}
+
+func newRuntimeAgent() *runtimeAgent {
+	agent := &runtimeAgent{
+		results: map[string]map[string]interface{}{
+			"3.10": {"output": "sum=6\nok\n", "exit_code": float64(0), "metrics": map[string]interface{}{"duration_ms": float64(1200)}},
+			"3.11": {"output": "sum=6\nok\n", "exit_code": float64(0), "metrics": map[string]interface{}{"duration_ms": float64(900)}},
+			"3.12": {"output": "sum=6\nDeprecationWarning: utcnow\nfailed\n", "exit_code": float64(1), "metrics": map[string]interface{}{"duration_ms": float64(1000)}},
+		},
+		executedOn: map[string]string{},
+	}
+	agent.agents = []services.Agent{
+		{ID: "py-legacy", Type: "code_executor", Status: "active", Capabilities: []services.Capability{{Name: "python", Version: "3.10.14, 3.11.9"}}},
+		{ID: "py-latest", Type: "code_executor", Status: "active", Capabilities: []services.Capability{{Name: "python", Version: "3.12"}}},
+		{ID: "go", Type: "code_executor", Status: "active", Capabilities: []services.Capability{{Name: "go", Version: "1.22"}}},
+	}
+	return agent
+}
+
+// runCodeMatrixWorkflow runs a code execution workflow with a matrix,
+// returning its summary and the status of the execution recorded for each
+// runtime
+func runCodeMatrixWorkflow(t *testing.T, agent *runtimeAgent, req CodeExecutionRequest) (CodeMatrixSummary, map[string]models.ExecutionStatus, error) {
+	db := setupIterativeTest(t)
+	activities := NewActivities(db, zap.NewNop(), nil, agent)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	env.RegisterWorkflow(engine.CodeExecutionWorkflow)
+	for _, fn := range []interface{}{
+		activities.SelectAgentActivity, activities.PrepareEnvironmentActivity, activities.ExecuteCodeActivity,
+		activities.CleanupEnvironmentActivity, activities.RecordMatrixRunActivity, activities.SummarizeCodeMatrixActivity,
+	} {
+		env.RegisterActivity(fn)
+	}
+
+	var summary CodeMatrixSummary
+	env.SetOnActivityCompletedListener(func(info *activity.Info, result converter.EncodedValue, err error) {
+		if info.ActivityType.Name == "SummarizeCodeMatrixActivity" {
+			require.NoError(t, result.Get(&summary))
+		}
+	})
+
+	input, err := json.Marshal(req)
+	require.NoError(t, err)
+	env.ExecuteWorkflow(engine.CodeExecutionWorkflow, &models.Workflow{ID: testWorkflowID, Input: input})
+	require.True(t, env.IsWorkflowCompleted())
+	if err := env.GetWorkflowError(); err != nil {
+		return summary, nil, err
+	}
+
+	var executions []models.Execution
+	require.NoError(t, db.Select("workflow_id", "status", "language", "runtime").Find(&executions).Error)
+	statuses := make(map[string]models.ExecutionStatus, len(executions))
+	for _, execution := range executions {
+		assert.Equal(t, testWorkflowID, execution.WorkflowID)
+		statuses[execution.Language+"@"+execution.Runtime] = execution.Status
+	}
+	return summary, statuses, nil
+}
+
+func TestCodeExecutionWorkflow_MatrixComparesRuntimes(t *testing.T) {
+	agent := newRuntimeAgent()
+	summary, statuses, err := runCodeMatrixWorkflow(t, agent, CodeExecutionRequest{
+		Language:    "python",
+		Code:        "print(f'sum={sum([1, 2, 3])}')",
+		Environment: map[string]string{"STRICT": "1"},
+		Matrix: []MatrixCombination{
+			{Language: "python", Runtime: "3.10"},
+			{Language: "python", Runtime: "3.11"},
+			{Language: "python", Runtime: "3.12", Environment: map[string]string{"PYTHONWARNINGS": "default"}},
+			{Language: "python", Runtime: "3.9"},
+		},
+		MaxParallel: 2,
+	})
+	require.NoError(t, err)
+
+	// Runtimes go to the agents declaring them
+	assert.Equal(t, map[string]string{"3.10": "py-legacy", "3.11": "py-legacy", "3.12": "py-latest"}, agent.executedOn)
+	assert.LessOrEqual(t, agent.maxRunning, 2)
+	assert.Equal(t, map[string]string{"STRICT": "1", "PYTHONWARNINGS": "default"}, agent.environment)
+
+	assert.Equal(t, 4, summary.Total)
+	assert.Equal(t, 2, summary.Passed)
+	assert.Equal(t, 2, summary.Failed)
+	assert.False(t, summary.Consistent)
+	assert.Equal(t, "python@3.10", summary.Baseline)
+	assert.Equal(t, "python@3.11", summary.Fastest)
+	assert.Equal(t, "python@3.10", summary.Slowest)
+
+	require.Len(t, summary.Combinations, 4)
+	exitCode := func(code int) *int { return &code }
+	assert.Equal(t, MatrixComparison{
+		Key: "python@3.11", Passed: true, ExitCode: exitCode(0), Duration: 900, DurationDelta: -300, OutputMatches: true,
+	}, summary.Combinations[1])
+	assert.Equal(t, MatrixComparison{
+		Key:           "python@3.12 {PYTHONWARNINGS=default}",
+		ExitCode:      exitCode(1),
+		Duration:      1000,
+		DurationDelta: -200,
+		OutputDiff:    []string{"- ok", "+ DeprecationWarning: utcnow", "+ failed"},
+	}, summary.Combinations[2])
+
+	// No agent runs 3.9, so the code never ran there
+	unsupported := summary.Combinations[3]
+	assert.Equal(t, "python@3.9", unsupported.Key)
+	assert.False(t, unsupported.Passed)
+	assert.Nil(t, unsupported.ExitCode)
+	assert.Contains(t, unsupported.Error, "no suitable agent found for language: python runtime: 3.9")
+
+	// Each combination is recorded as an execution of the workflow
+	assert.Equal(t, map[string]models.ExecutionStatus{
+		"python@3.10": models.ExecutionStatusSucceeded,
+		"python@3.11": models.ExecutionStatusSucceeded,
+		"python@3.12": models.ExecutionStatusFailed,
+		"python@3.9":  models.ExecutionStatusFailed,
+	}, statuses)
+}
+
+func TestCodeExecutionWorkflow_MatrixValidation(t *testing.T) {
+	for name, req := range map[string]CodeExecutionRequest{
+		"duplicate combination": {Matrix: []MatrixCombination{{Language: "go", Runtime: "1.22"}, {Language: "go", Runtime: "1.22"}}},
+		"missing language":      {Matrix: []MatrixCombination{{Runtime: "1.22"}}},
+		"parallelism too high":  {Matrix: []MatrixCombination{{Language: "go"}}, MaxParallel: 50},
+	} {
+		_, _, err := runCodeMatrixWorkflow(t, newRuntimeAgent(), req)
+		require.Error(t, err, name)
+		assert.Contains(t, err.Error(), "invalid matrix", name)
+	}
+}
+
+func TestCapabilityRuns(t *testing.T) {
+	python := services.Capability{Name: "python", Version: "3.10.14, 3.11"}
+	assert.True(t, capabilityRuns(python, "python", ""))
+	assert.True(t, capabilityRuns(python, "python", "3.10"))
+	assert.True(t, capabilityRuns(python, "python", "3.10.14"))
+	assert.True(t, capabilityRuns(python, "python", "3.11"))
+	assert.False(t, capabilityRuns(python, "python", "3.1"))
+	assert.False(t, capabilityRuns(python, "python", "3.12"))
+	assert.False(t, capabilityRuns(python, "go", ""))
+
+	assert.True(t, capabilityRuns(services.Capability{Name: "multi-language", Version: "*"}, "go", "1.22"))
+	assert.False(t, capabilityRuns(services.Capability{Name: "multi-language"}, "go", "1.22"))
+}
@@ -0,0 +1,217 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+// pullRequestUnavailableError is the type of the non-retryable error returned
+// when a pull request cannot be read or reviewed with the project's
+// credentials, or does not exist
+const pullRequestUnavailableError = "PullRequestUnavailable"
+
+// scmProvider returns the provider of a review request, or a non-retryable
+// error when it is not configured
+func (a *Activities) scmProvider(req CodeReviewRequest) (services.SCMProvider, error) {
+	name := req.Provider
+	if name == "" {
+		name = services.SCMProviderGitHub
+	}
+	provider, ok := a.scm[name]
+	if !ok {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("SCM provider %q is not configured", name), pullRequestUnavailableError, nil)
+	}
+	if req.PullRequest <= 0 {
+		return nil, temporal.NewNonRetryableApplicationError(
+			"code review needs a pull request number", pullRequestUnavailableError, nil)
+	}
+	return provider, nil
+}
+
+// pullRequestRef identifies the pull request of a review request
+func pullRequestRef(req CodeReviewRequest) *services.PullRequestRef {
+	return &services.PullRequestRef{
+		ProjectID:   req.ProjectID,
+		Repository:  req.Repository,
+		Number:      req.PullRequest,
+		Integration: req.Integration,
+	}
+}
+
+// scmError makes authentication and not found errors non-retryable; rate
+// limits and other failures are retried
+func scmError(err error) error {
+	if errors.Is(err, services.ErrSCMAuth) || errors.Is(err, services.ErrSCMNotFound) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), pullRequestUnavailableError, err)
+	}
+	return err
+}
+
+// FetchCodeChangesActivity fetches the metadata and the diff of the pull
+// request to review, with the patch and line counts of each file
+func (a *Activities) FetchCodeChangesActivity(ctx context.Context, req CodeReviewRequest) (*CodeChanges, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Fetching code changes",
+		zap.String("repository", req.Repository),
+		zap.Int("pull_request", req.PullRequest))
+
+	provider, err := a.scmProvider(req)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := provider.FetchPullRequest(ctx, pullRequestRef(req))
+	if err != nil {
+		return nil, scmError(err)
+	}
+
+	changes := &CodeChanges{
+		ProjectID:   req.ProjectID,
+		Files:       make([]string, 0, len(pr.Files)),
+		Additions:   pr.Additions,
+		Deletions:   pr.Deletions,
+		Diff:        pr.Diff,
+		FileChanges: pr.Files,
+		Title:       pr.Title,
+		Author:      pr.Author,
+		URL:         pr.URL,
+		BaseCommit:  pr.BaseCommit,
+		HeadCommit:  pr.HeadCommit,
+	}
+	for _, file := range pr.Files {
+		changes.Files = append(changes.Files, file.Path)
+	}
+	return changes, nil
+}
+
+// PostReviewCommentsActivity posts the comments of a review summary on the
+// lines of the pull request they were made on, and the summary as a comment.
+// The review is keyed by workflow and head commit, so retries of the
+// activity or the workflow do not post it twice.
+func (a *Activities) PostReviewCommentsActivity(ctx context.Context, req CodeReviewRequest, changes CodeChanges, summary ReviewSummary) error {
+	logger := activity.GetLogger(ctx)
+
+	provider, err := a.scmProvider(req)
+	if err != nil {
+		return err
+	}
+	info := activity.GetInfo(ctx)
+	posted, err := provider.PostReview(ctx, pullRequestRef(req), &services.PullRequestReview{
+		Key:      info.WorkflowExecution.ID + ":" + changes.HeadCommit,
+		Commit:   changes.HeadCommit,
+		Files:    changes.FileChanges,
+		Comments: summary.Comments,
+		Summary:  reviewSummaryText(summary),
+	})
+	if err != nil {
+		return scmError(err)
+	}
+
+	logger.Info("Posted review comments",
+		zap.Int("pull_request", req.PullRequest),
+		zap.Int("inline", posted.Inline),
+		zap.Int("unplaced", posted.Unplaced),
+		zap.Bool("already_posted", posted.AlreadyPosted))
+	return nil
+}
+
+// reviewSummaryText is the summary comment of a review
+func reviewSummaryText(summary ReviewSummary) string {
+	verdict := "changes suggested"
+	if summary.Approved {
+		verdict = "approved"
+	}
+	return fmt.Sprintf("**Automated review: %s** (score %.2f, %d comments)", verdict, summary.Score, len(summary.Comments))
+}
+
+// RunAutomatedChecksActivity runs the automated checks of the changes (placeholder)
+func RunAutomatedChecksActivity(ctx context.Context, changes CodeChanges) (*AutomatedCheckResults, error) {
+	return &AutomatedCheckResults{
+		Passed:   true,
+		Checks:   []interface{}{},
+		Coverage: 0.85,
+	}, nil
+}
+
+// aiReviewResultMargin is the part of the activity's timeout kept to return
+// the findings of the chunks reviewed when the others did not finish
+const aiReviewResultMargin = 30 * time.Second
+
+// RunAIReviewActivity reviews the diff of the changes with the AI reviewer,
+// given the automated checks as context. Chunks not reviewed before the
+// activity's deadline, less a margin, leave a partial review; the activity
+// only fails when no chunk was reviewed.
+func (a *Activities) RunAIReviewActivity(ctx context.Context, changes CodeChanges, checks AutomatedCheckResults) (*AIReviewResult, error) {
+	logger := activity.GetLogger(ctx)
+	if a.reviewer == nil {
+		logger.Info("No AI reviewer configured, skipping AI review")
+		return &AIReviewResult{Issues: []services.AIReviewFinding{}, CodeQuality: 1, Skipped: true}, nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) > 2*aiReviewResultMargin {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-aiReviewResultMargin))
+		defer cancel()
+	}
+
+	review, err := a.reviewer.Review(ctx, &services.AIReviewRequest{
+		ProjectID: changes.ProjectID,
+		Title:     changes.Title,
+		Diff:      changes.Diff,
+		Files:     changes.FileChanges,
+		Checks:    checksSummary(checks),
+		Progress: func(done, total int) {
+			activity.RecordHeartbeat(ctx, fmt.Sprintf("Reviewed %d of %d chunks", done, total))
+		},
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrAIReviewAuth) {
+			return nil, temporal.NewNonRetryableApplicationError(err.Error(), "AIReviewUnauthorized", err)
+		}
+		return nil, fmt.Errorf("failed to review changes: %w", err)
+	}
+
+	logger.Info("AI review completed",
+		zap.Int("findings", len(review.Findings)),
+		zap.Float64("code_quality", review.CodeQuality),
+		zap.Int("chunks", review.Chunks),
+		zap.Int("failed_chunks", review.FailedChunks),
+		zap.Int("prompt_tokens", review.PromptTokens),
+		zap.Int("completion_tokens", review.CompletionTokens))
+	return &AIReviewResult{
+		Issues:           review.Findings,
+		CodeQuality:      review.CodeQuality,
+		Model:            review.Model,
+		Chunks:           review.Chunks,
+		FailedChunks:     review.FailedChunks,
+		Partial:          review.Partial,
+		PromptTokens:     review.PromptTokens,
+		CompletionTokens: review.CompletionTokens,
+	}, nil
+}
+
+// checksSummary describes the automated checks for the reviewer
+func checksSummary(checks AutomatedCheckResults) string {
+	status := "passed"
+	if !checks.Passed {
+		status = "failed"
+	}
+	return fmt.Sprintf("%s, %d checks, coverage %.0f%%", status, len(checks.Checks), checks.Coverage*100)
+}
+
+// GenerateReviewSummaryActivity summarizes the checks and the AI review (placeholder)
+func GenerateReviewSummaryActivity(ctx context.Context, checks AutomatedCheckResults, ai AIReviewResult) (*ReviewSummary, error) {
+	return &ReviewSummary{
+		Approved: true,
+		Comments: []services.ReviewComment{},
+		Score:    0.88,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+
+	"orchestrator/internal/services"
+)
+
+// fakeReviewer returns a fixed review, recording the request
+type fakeReviewer struct {
+	review  *services.AIReview
+	err     error
+	request *services.AIReviewRequest
+}
+
+func (r *fakeReviewer) Review(ctx context.Context, req *services.AIReviewRequest) (*services.AIReview, error) {
+	r.request = req
+	return r.review, r.err
+}
+
+func runAIReview(t *testing.T, activities *Activities, changes CodeChanges) (*AIReviewResult, error) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+	value, err := env.ExecuteActivity(ActivityRunAIReview, changes, AutomatedCheckResults{Passed: false, Checks: []interface{}{"lint"}, Coverage: 0.72})
+	if err != nil {
+		return nil, err
+	}
+	var result AIReviewResult
+	require.NoError(t, value.Get(&result))
+	return &result, nil
+}
+
+func TestRunAIReviewActivity(t *testing.T) {
+	changes := CodeChanges{ProjectID: "project-1", Title: "Add endpoint", Diff: "diff", FileChanges: []services.PullRequestFile{{Path: "main.go"}}}
+
+	// Reviews are skipped without a reviewer
+	result, err := runAIReview(t, &Activities{}, changes)
+	require.NoError(t, err)
+	assert.True(t, result.Skipped)
+	assert.Empty(t, result.Issues)
+
+	reviewer := &fakeReviewer{review: &services.AIReview{
+		Findings:     []services.AIReviewFinding{{File: "main.go", StartLine: 3, EndLine: 3, Severity: services.AIReviewSeverityHigh, Category: "bug", Message: "nil dereference"}},
+		CodeQuality:  0.8,
+		Model:        "review-model",
+		Chunks:       2,
+		FailedChunks: 1,
+		Partial:      true,
+		PromptTokens: 150,
+	}}
+	result, err = runAIReview(t, &Activities{reviewer: reviewer}, changes)
+	require.NoError(t, err)
+	assert.Equal(t, "project-1", reviewer.request.ProjectID)
+	assert.Equal(t, changes.FileChanges, reviewer.request.Files)
+	assert.Equal(t, "failed, 1 checks, coverage 72%", reviewer.request.Checks)
+	assert.Equal(t, &AIReviewResult{
+		Issues:       reviewer.review.Findings,
+		CodeQuality:  0.8,
+		Model:        "review-model",
+		Chunks:       2,
+		FailedChunks: 1,
+		Partial:      true,
+		PromptTokens: 150,
+	}, result)
+
+	// Missing or rejected credentials are not retried
+	_, err = runAIReview(t, &Activities{reviewer: &fakeReviewer{err: fmt.Errorf("%w: no API key configured", services.ErrAIReviewAuth)}}, changes)
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, err, &appErr)
+	assert.True(t, appErr.NonRetryable())
+	_, err = runAIReview(t, &Activities{reviewer: &fakeReviewer{err: services.ErrAIReviewFailed}}, changes)
+	require.ErrorAs(t, err, &appErr)
+	assert.False(t, appErr.NonRetryable())
+}
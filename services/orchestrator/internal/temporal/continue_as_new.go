@@ -0,0 +1,45 @@
+package temporal
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// DefaultMaxHistoryLength is the history length at which long-running
+// workflows start a fresh run. Temporal warns at 10k events and hard-fails at
+// 50k, so this leaves plenty of headroom for the final iteration.
+const DefaultMaxHistoryLength = 5000
+
+// ContinueAsNewPolicy decides when a long-running workflow should carry its
+// state over into a new run instead of growing its history further.
+type ContinueAsNewPolicy struct {
+	MaxHistoryLength int
+}
+
+// DefaultContinueAsNewPolicy returns the policy used by the worker
+func DefaultContinueAsNewPolicy() ContinueAsNewPolicy {
+	return ContinueAsNewPolicy{MaxHistoryLength: DefaultMaxHistoryLength}
+}
+
+// ShouldContinueAsNew reports whether the current run has crossed the history
+// threshold or the server has suggested continuing as new.
+func (p ContinueAsNewPolicy) ShouldContinueAsNew(ctx workflow.Context) bool {
+	info := workflow.GetInfo(ctx)
+	if info.GetContinueAsNewSuggested() {
+		return true
+	}
+
+	threshold := p.MaxHistoryLength
+	if threshold <= 0 {
+		threshold = DefaultMaxHistoryLength
+	}
+	return info.GetCurrentHistoryLength() >= threshold
+}
+
+// ContinuationInfo is embedded in the state carried across runs so every
+// loop-style workflow records the same bookkeeping.
+type ContinuationInfo struct {
+	Runs          int       `json:"runs"`            // Number of times the workflow has continued as new
+	WaitStartedAt time.Time `json:"wait_started_at"` // When the first run started waiting
+}
@@ -0,0 +1,75 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// noAgentInRegionError is the type of the non-retryable error an agent
+// selection fails with when no agent runs in the region of its workflow
+const noAgentInRegionError = "NoAgentInRegion"
+
+// agentsInRegion keeps the agents running in a region; an empty region
+// keeps every agent. Without any, the error lists the regions agents are
+// available in.
+func agentsInRegion(agents []services.Agent, region string) ([]services.Agent, error) {
+	regional, err := services.FilterAgentsByRegion(agents, region)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), noAgentInRegionError, err)
+	}
+	return regional, nil
+}
+
+// workflowRegion returns the region a workflow is pinned to, "" when it is
+// not
+func (a *Activities) workflowRegion(ctx context.Context, workflowID string) (string, error) {
+	if a.db == nil {
+		return "", nil
+	}
+	var wf models.Workflow
+	err := a.db.WithContext(ctx).Select("id", "metadata").First(&wf, "id = ?", workflowID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get workflow region: %w", err)
+	}
+	return services.WorkflowRegion(wf.Metadata), nil
+}
+
+// regionalAgents keeps the agents running in the region of the running
+// workflow
+func (a *Activities) regionalAgents(ctx context.Context, agents []services.Agent) ([]services.Agent, error) {
+	region, err := a.workflowRegion(ctx, activity.GetInfo(ctx).WorkflowExecution.ID)
+	if err != nil {
+		return nil, err
+	}
+	return agentsInRegion(agents, region)
+}
+
+// regionContext returns a context storing artifact contents in the bucket
+// of a workflow's region
+func (a *Activities) regionContext(ctx context.Context, workflowID string) (context.Context, error) {
+	region, err := a.workflowRegion(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	return services.WithRegion(ctx, region), nil
+}
+
+// outputContext returns the context outputs of the running workflow are
+// offloaded with, storing them in the bucket of its region
+func (a *Activities) outputContext(ctx context.Context) (context.Context, error) {
+	if a.outputs == nil {
+		return ctx, nil
+	}
+	return a.regionContext(ctx, activity.GetInfo(ctx).WorkflowExecution.ID)
+}
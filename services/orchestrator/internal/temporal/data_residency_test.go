@@ -0,0 +1,65 @@
+package temporal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+// regionalFleet has a meta-prompt agent and an idle backend agent in
+// us-east-1, and a busier backend agent in eu-west-1
+func regionalFleet() *fakeFleet {
+	fleet := backendFleet(0, 0.5)
+	fleet.agents[0].Region = "eu-west-1"
+	fleet.agents[1].Region = "us-east-1"
+	fleet.agents[2].Region = "eu-west-1"
+	return fleet
+}
+
+func findAgentInRegion(t *testing.T, fleet *fakeFleet, region string) (*AgentInfo, error) {
+	activities := NewMetaAgentActivities(fleet, zap.NewNop())
+	activities.selection = testSelectionPolicy
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivityWithOptions(activities.FindOrCreateAgentForTaskActivity,
+		activity.RegisterOptions{Name: ActivityMetaAgentFindOrCreateAgentForTask})
+	val, err := env.ExecuteActivity(ActivityMetaAgentFindOrCreateAgentForTask,
+		Task{ID: "task-0001", Type: "backend", Title: "Orders API"}, (*services.SimulationConfig)(nil),
+		AgentSelectionHints{ProjectID: "project-1", Region: region})
+	if err != nil {
+		return nil, err
+	}
+	var agent AgentInfo
+	require.NoError(t, val.Get(&agent))
+	return &agent, nil
+}
+
+func TestFindOrCreateAgentForTask_PinnedRegion(t *testing.T) {
+	agent, err := findAgentInRegion(t, regionalFleet(), "")
+	require.NoError(t, err)
+	assert.Equal(t, "backend-1", agent.ID, "without a region the idle agent wins")
+
+	agent, err = findAgentInRegion(t, regionalFleet(), "eu-west-1")
+	require.NoError(t, err)
+	assert.Equal(t, "backend-2", agent.ID, "only agents in the region are candidates")
+}
+
+func TestFindOrCreateAgentForTask_NoAgentInRegion(t *testing.T) {
+	fleet := regionalFleet()
+	_, err := findAgentInRegion(t, fleet, "ap-south-1")
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "no agent in region ap-south-1: agents are available in eu-west-1, us-east-1")
+
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, noAgentInRegionError, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+	assert.Zero(t, fleet.created, "no agent is spawned outside the region")
+}
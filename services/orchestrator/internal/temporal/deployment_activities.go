@@ -0,0 +1,87 @@
+package temporal
+
+import (
+	"context"
+	"time"
+)
+
+// ValidateDeploymentActivity validates a deployment request (placeholder)
+func ValidateDeploymentActivity(ctx context.Context, req DeploymentRequest) (*DeploymentValidation, error) {
+	return &DeploymentValidation{
+		IsValid: true,
+		Errors:  []string{},
+	}, nil
+}
+
+// BuildArtifactsActivity builds the artifacts to deploy (placeholder)
+func BuildArtifactsActivity(ctx context.Context, req DeploymentRequest) (*BuildResult, error) {
+	return &BuildResult{
+		ArtifactID: "artifact-123",
+		Version:    req.Version,
+		Size:       1024 * 1024 * 50, // 50MB
+	}, nil
+}
+
+// RunDeploymentTestsActivity tests the built artifacts (placeholder)
+func RunDeploymentTestsActivity(ctx context.Context, build BuildResult) (*TestResult, error) {
+	return &TestResult{
+		Passed:   true,
+		Tests:    100,
+		Failures: 0,
+		Coverage: 0.85,
+	}, nil
+}
+
+// DeployToStagingActivity deploys the artifacts to staging (placeholder)
+func DeployToStagingActivity(ctx context.Context, build BuildResult) (*DeploymentResult, error) {
+	return &DeploymentResult{
+		DeploymentID: "deploy-staging-123",
+		Environment:  "staging",
+		Version:      build.Version,
+		URL:          "https://staging.example.com",
+		Timestamp:    time.Now(),
+	}, nil
+}
+
+// RunSmokeTestsActivity smoke tests a deployment (placeholder)
+func RunSmokeTestsActivity(ctx context.Context, deployment DeploymentResult) (*TestResult, error) {
+	return &TestResult{
+		Passed:   true,
+		Tests:    20,
+		Failures: 0,
+		Coverage: 0.0,
+	}, nil
+}
+
+// DeployToProductionActivity deploys the artifacts to production (placeholder)
+func DeployToProductionActivity(ctx context.Context, build BuildResult) (*DeploymentResult, error) {
+	return &DeploymentResult{
+		DeploymentID: "deploy-prod-123",
+		Environment:  "production",
+		Version:      build.Version,
+		URL:          "https://api.example.com",
+		Timestamp:    time.Now(),
+	}, nil
+}
+
+// RunHealthCheckActivity checks the health of a deployment (placeholder)
+func RunHealthCheckActivity(ctx context.Context, deployment DeploymentResult) (*HealthCheckResult, error) {
+	return &HealthCheckResult{
+		IsHealthy: true,
+		Checks: map[string]bool{
+			"api":      true,
+			"database": true,
+			"cache":    true,
+		},
+	}, nil
+}
+
+// RollbackDeploymentActivity rolls a deployment back (placeholder)
+func RollbackDeploymentActivity(ctx context.Context, deployment DeploymentResult) error {
+	return nil
+}
+
+// UpdateDeploymentStatusActivity records the status of a deployment (placeholder)
+func UpdateDeploymentStatusActivity(ctx context.Context, deployment DeploymentResult) error {
+	return nil
+}
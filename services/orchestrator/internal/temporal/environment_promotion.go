@@ -0,0 +1,192 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// promotionFailedError is the type of the non-retryable error returned when
+// a promotion cannot be applied: its environments do not exist or are
+// invalid, or the target conflicts
+const promotionFailedError = "PromotionFailed"
+
+// promotionSnapshotArtifactID identifies the before/after snapshot of the
+// target among the artifacts of a promotion
+const promotionSnapshotArtifactID = "promotion-snapshot"
+
+// promotionActivityOptions apply to every promotion activity
+var promotionActivityOptions = workflow.ActivityOptions{
+	StartToCloseTimeout: time.Minute,
+	RetryPolicy: &temporal.RetryPolicy{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    30 * time.Second,
+		MaximumAttempts:    5,
+	},
+}
+
+// PromoteEnvironmentWorkflow copies the config and variables of an
+// environment into another, records the target before and after as an
+// artifact and, when asked to, deploys the target in a child deployment
+// workflow
+func (w *WorkflowEngine) PromoteEnvironmentWorkflow(ctx workflow.Context, wf *models.Workflow) (err error) {
+	progress := trackProgress(ctx, wf)
+	defer func() { progress.finish(err) }()
+
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Starting environment promotion workflow", "workflowID", wf.ID)
+	ctx = workflow.WithActivityOptions(ctx, promotionActivityOptions)
+
+	var input services.PromotionInput
+	if err := json.Unmarshal(wf.Input, &input); err != nil {
+		return temporal.NewNonRetryableApplicationError("failed to parse promotion input", promotionFailedError, err)
+	}
+	deploy := len(input.Deploy) > 0
+	progress.plan(promotionSteps(deploy)...)
+
+	progress.start(promotionStepApply)
+	var result services.PromotionResult
+	if err := workflow.ExecuteActivity(ctx, ActivityApplyPromotion, wf.ProjectID, input).Get(ctx, &result); err != nil {
+		return fmt.Errorf("promotion failed: %w", err)
+	}
+	progress.complete(promotionStepApply)
+
+	// Recorded apart from applying, so a retried record does not take the
+	// promoted target for its state before the promotion
+	progress.start(promotionStepSnapshot)
+	if err := workflow.ExecuteActivity(ctx, ActivityRecordPromotionSnapshot, wf.ProjectID, result).Get(ctx, nil); err != nil {
+		return fmt.Errorf("failed to record promotion snapshot: %w", err)
+	}
+	progress.complete(promotionStepSnapshot)
+	progress.addArtifacts(services.WorkflowArtifactRef{
+		ID:   promotionSnapshotArtifactID,
+		Name: promotionSnapshotName(result),
+		Type: "snapshot",
+	})
+	logger.Info("Promoted environment", "source", result.Source, "target", result.Target, "changes", len(result.Changes))
+
+	if !deploy {
+		return nil
+	}
+	progress.start(promotionStepDeploy)
+	if err := deployPromotedEnvironment(ctx, wf, input); err != nil {
+		return err
+	}
+	progress.complete(promotionStepDeploy)
+	return nil
+}
+
+// deployPromotedEnvironment runs the deployment of a promoted target as a
+// child workflow, recorded as a workflow of its own under the promotion
+func deployPromotedEnvironment(ctx workflow.Context, wf *models.Workflow, input services.PromotionInput) error {
+	var deployment models.Workflow
+	if err := workflow.ExecuteActivity(ctx, ActivityCreatePromotionDeployment, wf.ID, input).Get(ctx, &deployment); err != nil {
+		return fmt.Errorf("failed to create deployment of %s: %w", input.Target, err)
+	}
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{WorkflowID: deployment.ID})
+	child := workflow.ExecuteChildWorkflow(childCtx, "DeploymentWorkflow", &deployment, (*DeploymentContinuation)(nil))
+	var execution workflow.Execution
+	if err := child.GetChildWorkflowExecution().Get(ctx, &execution); err != nil {
+		return fmt.Errorf("failed to start deployment of %s: %w", input.Target, err)
+	}
+	err := workflow.ExecuteActivity(ctx, ActivityMarkPromotionDeploymentStarted, deployment.ID, execution.RunID).Get(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record deployment of %s: %w", input.Target, err)
+	}
+	if err := child.Get(ctx, nil); err != nil {
+		return fmt.Errorf("deployment of %s failed: %w", input.Target, err)
+	}
+	return nil
+}
+
+// promotionSnapshotName names the snapshot artifact of a promotion
+func promotionSnapshotName(result services.PromotionResult) string {
+	return fmt.Sprintf("promotion-%s-to-%s.json", result.Source, result.Target)
+}
+
+// promoter returns the environment promoter, or a non-retryable error when
+// promotions are not configured
+func (a *Activities) promoter() (*services.EnvironmentPromoter, error) {
+	if a.promotions == nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			"environment promotion is not configured", promotionFailedError, nil)
+	}
+	return a.promotions, nil
+}
+
+// promotionError makes missing or invalid environments and conflicts
+// non-retryable; database failures are retried
+func promotionError(err error) error {
+	if errors.Is(err, services.ErrPromotionConflict) || errors.Is(err, services.ErrInvalidPromotion) ||
+		errors.Is(err, gorm.ErrRecordNotFound) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), promotionFailedError, err)
+	}
+	return err
+}
+
+// ApplyPromotionActivity copies the config and variables of the source
+// environment into the target
+func (a *Activities) ApplyPromotionActivity(ctx context.Context, projectID string, input services.PromotionInput) (*services.PromotionResult, error) {
+	promoter, err := a.promoter()
+	if err != nil {
+		return nil, err
+	}
+	result, err := promoter.Apply(ctx, projectID, &input)
+	if err != nil {
+		return nil, promotionError(err)
+	}
+	return result, nil
+}
+
+// RecordPromotionSnapshotActivity records the changes of a promotion and the
+// target before and after it as an artifact of the promotion workflow
+func (a *Activities) RecordPromotionSnapshotActivity(ctx context.Context, projectID string, result services.PromotionResult) error {
+	snapshot, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal promotion snapshot: %w", err)
+	}
+	return a.recordArtifacts(ctx, projectID, []Artifact{{
+		ID:          promotionSnapshotArtifactID,
+		Name:        promotionSnapshotName(result),
+		Type:        "snapshot",
+		Content:     string(snapshot),
+		Size:        int64(len(snapshot)),
+		ContentType: "application/json",
+		CreatedAt:   result.PromotedAt,
+	}})
+}
+
+// CreatePromotionDeploymentActivity records the deployment chained by a
+// promotion workflow
+func (a *Activities) CreatePromotionDeploymentActivity(ctx context.Context, promotionID string, input services.PromotionInput) (*models.Workflow, error) {
+	promoter, err := a.promoter()
+	if err != nil {
+		return nil, err
+	}
+	deployment, err := promoter.CreateDeployment(ctx, promotionID, &input)
+	if err != nil {
+		return nil, promotionError(err)
+	}
+	return deployment, nil
+}
+
+// MarkPromotionDeploymentStartedActivity records the run of the deployment
+// chained by a promotion workflow
+func (a *Activities) MarkPromotionDeploymentStartedActivity(ctx context.Context, deploymentID, runID string) error {
+	promoter, err := a.promoter()
+	if err != nil {
+		return err
+	}
+	return promoter.MarkDeploymentStarted(ctx, deploymentID, runID)
+}
@@ -0,0 +1,178 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// setupPromotionActivities stores a development and a staging environment
+// and a promotion workflow of the first into the second
+func setupPromotionActivities(t *testing.T, deploy string) (*gorm.DB, *Activities, *models.Workflow) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, model := range []interface{}{&models.Workflow{}, &models.Environment{}} {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DefaultValue == "gen_random_uuid()" {
+				field.HasDefaultValue = false
+				field.DefaultValue = ""
+			}
+		}
+		require.NoError(t, db.Migrator().CreateTable(model))
+	}
+	for _, environment := range []*models.Environment{
+		{ID: "env-dev", ProjectID: "project-1", Name: "development", Variables: json.RawMessage(`{"LOG_LEVEL": "debug"}`)},
+		{ID: "env-staging", ProjectID: "project-1", Name: "staging", Variables: json.RawMessage(`{"LOG_LEVEL": "info"}`)},
+	} {
+		require.NoError(t, db.Create(environment).Error)
+	}
+
+	input, err := json.Marshal(services.PromotionInput{Source: "development", Target: "staging", Deploy: json.RawMessage(deploy)})
+	require.NoError(t, err)
+	promotion := &models.Workflow{
+		ID:        testWorkflowID,
+		Name:      "Promote development to staging",
+		Type:      models.WorkflowTypePromotion,
+		Priority:  models.WorkflowPriorityMedium,
+		ProjectID: "project-1",
+		Status:    models.WorkflowStatusRunning,
+		Input:     input,
+		CreatedBy: "alice",
+	}
+	require.NoError(t, db.Create(promotion).Error)
+
+	activities := NewActivities(db, zap.NewNop(), nil, nil)
+	activities.promotions = services.NewEnvironmentPromoter(db, nil, zap.NewNop())
+	return db, activities, promotion
+}
+
+func newPromotionTestEnv(activities *Activities) *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	env.RegisterWorkflow(engine.PromoteEnvironmentWorkflow)
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	promotionModule(activities).Register(env)
+	return env
+}
+
+// countDeployments mocks the deployment workflow, counting its runs
+func countDeployments(env *testsuite.TestWorkflowEnvironment) *int {
+	count := 0
+	env.OnWorkflow("DeploymentWorkflow", mock.Anything, mock.Anything, mock.Anything).Return(
+		func(ctx workflow.Context, wf *models.Workflow, state *DeploymentContinuation) error {
+			count++
+			return nil
+		})
+	return &count
+}
+
+func stagingVariables(t *testing.T, db *gorm.DB) string {
+	var environment models.Environment
+	require.NoError(t, db.First(&environment, "id = ?", "env-staging").Error)
+	return string(environment.Variables)
+}
+
+func TestPromoteEnvironmentWorkflow_ChainsDeployment(t *testing.T) {
+	db, activities, promotion := setupPromotionActivities(t, `{"version": "2.0.0"}`)
+	env := newPromotionTestEnv(activities)
+
+	var deployed []*models.Workflow
+	env.OnWorkflow("DeploymentWorkflow", mock.Anything, mock.Anything, mock.Anything).Return(
+		func(ctx workflow.Context, wf *models.Workflow, state *DeploymentContinuation) error {
+			deployed = append(deployed, wf)
+			return nil
+		})
+	var snapshot services.PromotionResult
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		if info.ActivityType.Name == ActivityRecordPromotionSnapshot {
+			var projectID string
+			require.NoError(t, args.Get(&projectID, &snapshot))
+		}
+	})
+
+	env.ExecuteWorkflow("PromoteEnvironmentWorkflow", promotion)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// The promotion was applied before the deployment, and snapshotted
+	assert.JSONEq(t, `{"LOG_LEVEL": "debug"}`, stagingVariables(t, db))
+	assert.JSONEq(t, `{"LOG_LEVEL": "info"}`, string(snapshot.Before.Variables))
+	assert.JSONEq(t, `{"LOG_LEVEL": "debug"}`, string(snapshot.After.Variables))
+	require.Len(t, snapshot.Changes, 1)
+	assert.Equal(t, "LOG_LEVEL", snapshot.Changes[0].Key)
+
+	// The deployment ran as a child recorded under the promotion
+	require.Len(t, deployed, 1)
+	assert.JSONEq(t, `{"version": "2.0.0", "environment": "staging"}`, string(deployed[0].Input))
+	var deployment models.Workflow
+	require.NoError(t, db.First(&deployment, "id = ?", deployed[0].ID).Error)
+	assert.Equal(t, models.WorkflowTypeDeployment, deployment.Type)
+	require.NotNil(t, deployment.ParentWorkflowID)
+	assert.Equal(t, promotion.ID, *deployment.ParentWorkflowID)
+	assert.Equal(t, deployment.ID, deployment.TemporalID)
+	assert.NotEmpty(t, deployment.TemporalRunID, "the workflow monitor follows the deployment's run")
+
+	state := queryState(t, env)
+	assert.Equal(t, []string{promotionStepApply, promotionStepSnapshot, promotionStepDeploy}, state.CompletedSteps)
+	require.Len(t, state.Artifacts, 1)
+	assert.Equal(t, "promotion-development-to-staging.json", state.Artifacts[0].Name)
+}
+
+func TestPromoteEnvironmentWorkflow_WithoutDeployment(t *testing.T) {
+	db, activities, promotion := setupPromotionActivities(t, "")
+	env := newPromotionTestEnv(activities)
+	deployments := countDeployments(env)
+
+	env.ExecuteWorkflow("PromoteEnvironmentWorkflow", promotion)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	assert.JSONEq(t, `{"LOG_LEVEL": "debug"}`, stagingVariables(t, db))
+	assert.Zero(t, *deployments)
+	var count int64
+	require.NoError(t, db.Model(&models.Workflow{}).Where("type = ?", models.WorkflowTypeDeployment).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestPromoteEnvironmentWorkflow_FailsOnConflict(t *testing.T) {
+	db, activities, promotion := setupPromotionActivities(t, `{"version": "2.0.0"}`)
+	// Staging was promoted into, then changed by hand
+	require.NoError(t, db.Model(&models.Environment{}).Where("id = ?", "env-staging").
+		Update("promotion_checksum", "checksum-of-the-last-promotion").Error)
+	env := newPromotionTestEnv(activities)
+	deployments := countDeployments(env)
+	attempts := 0
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		if info.ActivityType.Name == ActivityApplyPromotion {
+			attempts++
+		}
+	})
+
+	env.ExecuteWorkflow("PromoteEnvironmentWorkflow", promotion)
+	require.True(t, env.IsWorkflowCompleted())
+	err := env.GetWorkflowError()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "modified since its last promotion")
+	assert.Equal(t, 1, attempts, "conflicts are not retried")
+
+	assert.JSONEq(t, `{"LOG_LEVEL": "info"}`, stagingVariables(t, db))
+	assert.Zero(t, *deployments)
+}
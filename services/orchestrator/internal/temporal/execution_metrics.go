@@ -0,0 +1,50 @@
+package temporal
+
+import (
+	"time"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// recordExecutionMetrics records the duration of a finished execution and
+// the numeric metrics its output reports under "metrics", as samples taken
+// at its completion
+func (a *Activities) recordExecutionMetrics(execution *models.Execution, output map[string]interface{}) {
+	if a.metrics == nil || execution.CompletedAt == nil {
+		return
+	}
+	values := outputMetrics(output)
+	if execution.StartedAt != nil {
+		values[services.ExecutionMetricDuration] = float64(execution.CompletedAt.Sub(*execution.StartedAt).Milliseconds())
+	}
+	a.metrics.RecordValues(execution.ID, *execution.CompletedAt, values, nil)
+}
+
+// recordRoundMetrics records the duration, score and cost of a refinement
+// round, tagged with the round
+func (a *Activities) recordRoundMetrics(executionID string, round refinementRound) {
+	a.metrics.RecordValues(executionID, time.Now(), map[string]float64{
+		services.ExecutionMetricRoundDuration: float64(round.DurationMs),
+		services.ExecutionMetricRoundScore:    round.Score,
+		services.ExecutionMetricRoundCost:     round.Cost,
+	}, map[string]interface{}{"round": round.Round})
+}
+
+// outputMetrics returns the numeric values of the metrics an agent reported
+// in an output
+func outputMetrics(output map[string]interface{}) map[string]float64 {
+	values := make(map[string]float64)
+	metrics, _ := output["metrics"].(map[string]interface{})
+	for name, value := range metrics {
+		switch number := value.(type) {
+		case float64:
+			values[name] = number
+		case int:
+			values[name] = float64(number)
+		case int64:
+			values[name] = float64(number)
+		}
+	}
+	return values
+}
@@ -0,0 +1,57 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestActivities_RecordExecutionMetrics(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE metrics (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), execution_id TEXT NOT NULL,
+		name TEXT NOT NULL, value REAL NOT NULL, unit TEXT, type TEXT, tags BLOB, timestamp DATETIME NOT NULL, created_at DATETIME)`).Error)
+	recorder := services.NewMetricsRecorder(db, &config.ExecutionMetricsConfig{FlushInterval: 5, FlushTimeout: 5, BatchSize: 100, MaxBuffered: 100}, zap.NewNop())
+	activities := &Activities{metrics: recorder}
+
+	started := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	completed := started.Add(1500 * time.Millisecond)
+	activities.recordExecutionMetrics(&models.Execution{ID: "execution-1", StartedAt: &started, CompletedAt: &completed},
+		map[string]interface{}{"metrics": map[string]interface{}{"tests_passed": float64(12), "runtime": "go1.22"}})
+	activities.recordRoundMetrics("execution-2", refinementRound{Round: 2, Score: 0.75, DurationMs: 200, Cost: 0.25})
+	// Executions still running have nothing to record
+	activities.recordExecutionMetrics(&models.Execution{ID: "execution-3", StartedAt: &started}, nil)
+	_, err = recorder.Flush(context.Background())
+	require.NoError(t, err)
+
+	var samples []models.Metric
+	require.NoError(t, db.Order("execution_id, name").Find(&samples).Error)
+	require.Len(t, samples, 5)
+	assert.Equal(t, "duration_ms", samples[0].Name)
+	assert.Equal(t, 1500.0, samples[0].Value)
+	assert.True(t, samples[0].Timestamp.Equal(completed))
+	assert.Equal(t, "tests_passed", samples[1].Name)
+	assert.Equal(t, 12.0, samples[1].Value)
+
+	assert.Equal(t, []string{"round_cost", "round_duration_ms", "round_score"},
+		[]string{samples[2].Name, samples[3].Name, samples[4].Name})
+	assert.Equal(t, 0.75, samples[4].Value)
+	var tags map[string]interface{}
+	require.NoError(t, json.Unmarshal(samples[4].Tags, &tags))
+	assert.EqualValues(t, 2, tags["round"])
+
+	// Without a recorder nothing is recorded
+	(&Activities{}).recordRoundMetrics("execution-2", refinementRound{Round: 1})
+}
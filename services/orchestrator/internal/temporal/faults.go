@@ -0,0 +1,53 @@
+package temporal
+
+import (
+	"context"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+)
+
+// ActivityFaultInjector decides the faults injected into activities
+type ActivityFaultInjector interface {
+	// InjectActivityFault returns the delay and the error, nil for none, for
+	// an attempt of an activity
+	InjectActivityFault(ctx context.Context, activityType string) (time.Duration, error)
+}
+
+// faultInterceptor delays or fails activity attempts before they run, as the
+// injector's rules say
+type faultInterceptor struct {
+	interceptor.WorkerInterceptorBase
+	injector ActivityFaultInjector
+}
+
+// NewFaultInterceptor creates a worker interceptor injecting faults into
+// activities
+func NewFaultInterceptor(injector ActivityFaultInjector) interceptor.WorkerInterceptor {
+	return &faultInterceptor{injector: injector}
+}
+
+func (f *faultInterceptor) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	return &faultActivityInbound{ActivityInboundInterceptorBase: interceptor.ActivityInboundInterceptorBase{Next: next}, injector: f.injector}
+}
+
+type faultActivityInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+	injector ActivityFaultInjector
+}
+
+func (i *faultActivityInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	delay, err := i.injector.InjectActivityFault(ctx, activity.GetInfo(ctx).ActivityType.Name)
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i.Next.ExecuteActivity(ctx, in)
+}
@@ -0,0 +1,101 @@
+package temporal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+type injectedAttempt struct {
+	attempt int32
+	failed  bool
+}
+
+// attemptRecorder records the attempts of the task executions and whether a
+// fault failed them
+type attemptRecorder struct {
+	ActivityFaultInjector
+	mu       sync.Mutex
+	attempts []injectedAttempt
+}
+
+func (r *attemptRecorder) InjectActivityFault(ctx context.Context, activityType string) (time.Duration, error) {
+	delay, err := r.ActivityFaultInjector.InjectActivityFault(ctx, activityType)
+	if activityType == "MetaAgentExecuteTaskWithAgentActivity" {
+		r.mu.Lock()
+		r.attempts = append(r.attempts, injectedAttempt{attempt: activity.GetInfo(ctx).Attempt, failed: err != nil})
+		r.mu.Unlock()
+	}
+	return delay, err
+}
+
+func faultyTaskExecution(t *testing.T, probability float64) *attemptRecorder {
+	injector := services.NewFaultInjector(services.NewMemoryFaultRuleStore(), &config.FaultInjectionConfig{
+		Enabled: true, DefaultTTL: 300, MaxTTL: 3600, MaxDelay: 60, RefreshInterval: 1,
+	}, zap.NewNop())
+	_, err := injector.CreateRule(context.Background(), &services.FaultRule{
+		Target:      services.FaultTargetActivity,
+		Pattern:     "*ExecuteTaskWithAgentActivity",
+		Probability: probability,
+		Error:       "agent manager unavailable",
+	}, "test")
+	require.NoError(t, err)
+	return &attemptRecorder{ActivityFaultInjector: injector}
+}
+
+func TestFaultInterceptor_ActivityRetriesAbsorbInjectedFailures(t *testing.T) {
+	recorder := faultyTaskExecution(t, 0.5)
+	result := runSimulatedTaskWorkflow(t, simulatedTaskWorkflow(t), NewFaultInterceptor(recorder))
+
+	// Each task is retried after an injected failure, up to three attempts,
+	// and fails only when every attempt was faulted. The workflow retries
+	// with a new activity, so each one is Temporal's first attempt.
+	require.Len(t, result.TaskResults, 2)
+	attempts := recorder.attempts
+	for _, task := range result.TaskResults {
+		require.NotEmpty(t, attempts)
+		n := 0
+		for n < len(attempts) && n < 3 {
+			assert.Equal(t, int32(1), attempts[n].attempt)
+			n++
+			if !attempts[n-1].failed {
+				break
+			}
+		}
+		taskAttempts := attempts[:n]
+		attempts = attempts[n:]
+
+		if taskAttempts[n-1].failed {
+			assert.Len(t, taskAttempts, 3, "task %s", task.TaskID)
+			assert.Equal(t, models.ExecutionStatusFailed, task.Status)
+			assert.Contains(t, task.Error, "agent manager unavailable")
+		} else {
+			assert.LessOrEqual(t, len(taskAttempts), 3, "task %s", task.TaskID)
+			assert.Equal(t, models.ExecutionStatusSucceeded, task.Status)
+		}
+	}
+	assert.Empty(t, attempts)
+}
+
+func TestFaultInterceptor_TaskFailsAfterRetriesAreExhausted(t *testing.T) {
+	recorder := faultyTaskExecution(t, 1)
+	result := runSimulatedTaskWorkflow(t, simulatedTaskWorkflow(t), NewFaultInterceptor(recorder))
+
+	// The workflow still completes, recording both tasks as failed
+	assert.Len(t, recorder.attempts, 6)
+	assert.Equal(t, 0, result.SuccessfulTasks)
+	require.Len(t, result.TaskResults, 2)
+	for _, task := range result.TaskResults {
+		assert.Equal(t, models.ExecutionStatusFailed, task.Status)
+	}
+}
@@ -0,0 +1,89 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+const (
+	// intentAnalysisActor is the audit actor for warnings raised by intent
+	// analysis
+	intentAnalysisActor = "system:intent-analysis"
+	// warningLowIntentConfidence is the warning code for analyses below the
+	// confidence threshold
+	warningLowIntentConfidence = "low_intent_confidence"
+)
+
+// recordIntentAnalysis persists the Intent Processor's analysis against the
+// running workflow. Analyses below the confidence threshold flag the workflow
+// with a warning event in its audit trail.
+func (a *Activities) recordIntentAnalysis(ctx context.Context, resp *services.AnalyzeIntentResponse) error {
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "project_id").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+
+	entities, err := json.Marshal(resp.Entities)
+	if err != nil {
+		return fmt.Errorf("failed to marshal intent entities: %w", err)
+	}
+
+	threshold := a.intentClient.LowConfidenceThreshold()
+	analysis := &models.IntentAnalysis{
+		WorkflowID:     workflow.ID,
+		ProjectID:      workflow.ProjectID,
+		IntentType:     resp.IntentType,
+		Confidence:     float64(resp.Confidence),
+		Entities:       entities,
+		RequiredParams: resp.RequiredParams,
+		OptionalParams: resp.OptionalParams,
+		Risks:          resp.Risks,
+		EstimatedTime:  resp.EstimatedTime,
+		EstimatedCost:  float64(resp.EstimatedCost),
+		LowConfidence:  float64(resp.Confidence) < threshold,
+	}
+	if err := a.db.WithContext(ctx).Create(analysis).Error; err != nil {
+		return fmt.Errorf("failed to save intent analysis: %w", err)
+	}
+
+	if !analysis.LowConfidence {
+		return nil
+	}
+
+	details, err := json.Marshal(map[string]interface{}{
+		"warning":     warningLowIntentConfidence,
+		"intent_type": analysis.IntentType,
+		"confidence":  analysis.Confidence,
+		"threshold":   threshold,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal warning details: %w", err)
+	}
+	warning := &models.AuditLog{
+		EntityType: "workflow",
+		EntityID:   workflow.ID,
+		Action:     "workflow.warning",
+		Actor:      intentAnalysisActor,
+		Details:    details,
+	}
+	if err := a.db.WithContext(ctx).Create(warning).Error; err != nil {
+		return fmt.Errorf("failed to record low confidence warning: %w", err)
+	}
+
+	a.logger.Warn("Intent analysis below confidence threshold",
+		zap.String("workflow_id", workflow.ID),
+		zap.String("intent_type", analysis.IntentType),
+		zap.Float64("confidence", analysis.Confidence),
+		zap.Float64("threshold", threshold))
+	return nil
+}
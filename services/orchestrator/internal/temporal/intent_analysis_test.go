@@ -0,0 +1,159 @@
+package temporal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// The test activity environment runs activities under this workflow ID
+const testWorkflowID = "default-test-workflow-id"
+
+func setupIntentAnalysisTest(t *testing.T, resp services.AnalyzeIntentResponse) (*gorm.DB, *testsuite.TestActivityEnvironment, *Activities) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE intent_analyses (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			workflow_id TEXT, project_id TEXT, intent_type TEXT, confidence REAL, entities TEXT,
+			required_params TEXT, optional_params TEXT, risks TEXT, estimated_time INTEGER,
+			estimated_cost REAL, low_confidence BOOLEAN, created_at DATETIME)`,
+		`CREATE TABLE audit_logs (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			entity_type TEXT, entity_id TEXT, action TEXT, actor TEXT, details TEXT, created_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	require.NoError(t, db.Exec(`INSERT INTO workflows (id, project_id) VALUES (?, ?)`, testWorkflowID, "project-1").Error)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/intents/analyze", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	intentClient, err := services.NewIntentClient(&config.IntentAPIConfig{
+		Transport:              "http",
+		HTTPBaseURL:            server.URL,
+		Timeout:                5,
+		LowConfidenceThreshold: 0.6,
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	activities := NewActivities(db, zap.NewNop(), intentClient, nil)
+	env.RegisterActivity(activities)
+	return db, env, activities
+}
+
+func TestAnalyzeIntentActivity_PersistsAnalysis(t *testing.T) {
+	db, env, _ := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{
+		IntentType: "code_generation",
+		Confidence: 0.9,
+		Entities: []services.Entity{
+			{Type: "language", Value: "go", Confidence: 0.95, Start: 10, End: 12},
+			{Type: "framework", Value: "gin", Confidence: 0.8, Start: 18, End: 21},
+		},
+		RequiredParams: []string{"language"},
+		OptionalParams: []string{"framework"},
+		Risks:          []string{"writes files"},
+		EstimatedTime:  120,
+		EstimatedCost:  0.5,
+	})
+
+	val, err := env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Type: "code", Content: "write a go gin server"})
+	require.NoError(t, err)
+	var result IntentAnalysisResult
+	require.NoError(t, val.Get(&result))
+	assert.Equal(t, "code_generation", result.IntentType)
+
+	var analysis models.IntentAnalysis
+	require.NoError(t, db.First(&analysis, "workflow_id = ?", testWorkflowID).Error)
+	assert.Equal(t, "project-1", analysis.ProjectID)
+	assert.Equal(t, "code_generation", analysis.IntentType)
+	assert.InDelta(t, 0.9, analysis.Confidence, 0.0001)
+	assert.Equal(t, models.StringArray{"language"}, analysis.RequiredParams)
+	assert.Equal(t, models.StringArray{"framework"}, analysis.OptionalParams)
+	assert.Equal(t, models.StringArray{"writes files"}, analysis.Risks)
+	assert.Equal(t, 120, analysis.EstimatedTime)
+	assert.InDelta(t, 0.5, analysis.EstimatedCost, 0.0001)
+	assert.False(t, analysis.LowConfidence)
+
+	var entities []services.Entity
+	require.NoError(t, json.Unmarshal(analysis.Entities, &entities))
+	require.Len(t, entities, 2)
+	assert.Equal(t, services.Entity{Type: "language", Value: "go", Confidence: 0.95, Start: 10, End: 12}, entities[0])
+
+	var warnings int64
+	require.NoError(t, db.Model(&models.AuditLog{}).Where("entity_id = ?", testWorkflowID).Count(&warnings).Error)
+	assert.Zero(t, warnings)
+}
+
+func TestAnalyzeIntentActivity_FlagsLowConfidence(t *testing.T) {
+	db, env, _ := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{
+		IntentType: "deployment",
+		Confidence: 0.4,
+	})
+
+	_, err := env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Type: "deploy", Content: "ship it"})
+	require.NoError(t, err)
+
+	var analysis models.IntentAnalysis
+	require.NoError(t, db.First(&analysis, "workflow_id = ?", testWorkflowID).Error)
+	assert.True(t, analysis.LowConfidence)
+
+	var warning models.AuditLog
+	require.NoError(t, db.First(&warning, "entity_id = ?", testWorkflowID).Error)
+	assert.Equal(t, "workflow", warning.EntityType)
+	assert.Equal(t, "workflow.warning", warning.Action)
+	assert.Equal(t, intentAnalysisActor, warning.Actor)
+
+	var details map[string]interface{}
+	require.NoError(t, json.Unmarshal(warning.Details, &details))
+	assert.Equal(t, warningLowIntentConfidence, details["warning"])
+	assert.Equal(t, "deployment", details["intent_type"])
+	assert.InDelta(t, 0.4, details["confidence"], 0.0001)
+	assert.InDelta(t, 0.6, details["threshold"], 0.0001)
+}
+
+func TestAnalyzeIntentActivity_SimulatedUsesStub(t *testing.T) {
+	db, env, _ := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{
+		IntentType: "code_generation",
+		Confidence: 0.9,
+	})
+	sim := &services.SimulationConfig{Enabled: true, Fixtures: services.StubFixtures{
+		services.StubAnalyzeIntentFixture: {Output: map[string]interface{}{
+			"intent_type":     "deployment",
+			"confidence":      0.3,
+			"required_params": []string{"deploy"},
+		}},
+	}}
+
+	val, err := env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Type: "deploy", Content: "ship it"}, sim)
+	require.NoError(t, err)
+	var result IntentAnalysisResult
+	require.NoError(t, val.Get(&result))
+	assert.Equal(t, "deployment", result.IntentType)
+	assert.Equal(t, []string{"deploy"}, result.Actions)
+
+	// Simulated runs stay out of the project's intent analytics
+	var analyses, warnings int64
+	require.NoError(t, db.Model(&models.IntentAnalysis{}).Count(&analyses).Error)
+	require.NoError(t, db.Model(&models.AuditLog{}).Count(&warnings).Error)
+	assert.Zero(t, analyses)
+	assert.Zero(t, warnings)
+}
@@ -0,0 +1,49 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// intentBudgetExceededError is the error type of intent processing
+// workflows failed because their estimates exceed their budget
+const intentBudgetExceededError = "BudgetExceeded"
+
+// checkIntentBudget records the estimates of the workflow's intent and
+// checks them against its budget. Intents analyzed without a budget
+// enforcer, or of workflows without a budget, get no decision.
+func (a *Activities) checkIntentBudget(ctx context.Context, estimatedTime int, estimatedCost float64) (*services.BudgetDecision, error) {
+	if a.budgets == nil {
+		return nil, nil
+	}
+	decision, err := a.budgets.Check(ctx, activity.GetInfo(ctx).WorkflowExecution.ID, estimatedTime, estimatedCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check intent budget: %w", err)
+	}
+	return decision, nil
+}
+
+// enforceIntentBudget acts on the budget decision on an intent. Intents over
+// budget fail the workflow under the fail policy and only log a warning
+// under the warn policy; the enforcer published the event of either.
+func (w *WorkflowEngine) enforceIntentBudget(ctx workflow.Context, wf *models.Workflow, decision *services.BudgetDecision) error {
+	if decision == nil || len(decision.Exceeded) == 0 {
+		return nil
+	}
+	exceeded := strings.Join(decision.Exceeded, "; ")
+	if decision.Policy == models.BudgetPolicyWarn {
+		workflow.GetLogger(ctx).Warn("Intent estimates exceed budget", "workflowID", wf.ID, "exceeded", exceeded)
+		return nil
+	}
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("intent exceeds budget: %s", exceeded),
+		intentBudgetExceededError, nil, decision.Exceeded)
+}
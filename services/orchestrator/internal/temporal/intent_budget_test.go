@@ -0,0 +1,183 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestAnalyzeIntentActivity_ChecksBudget(t *testing.T) {
+	tests := []struct {
+		name            string
+		budget          string // Budget of the workflow, JSON
+		projectSettings string
+		exceeded        int
+		policy          models.BudgetPolicy
+		events          []string
+		recordedBudget  models.WorkflowBudget
+	}{
+		{
+			name:           "under budget",
+			budget:         `{"max_cost": 5, "max_duration_seconds": 900}`,
+			policy:         models.BudgetPolicyFail,
+			recordedBudget: models.WorkflowBudget{MaxCost: 5, MaxDurationSeconds: 900},
+		},
+		{
+			name:           "over budget with warn policy",
+			budget:         `{"max_cost": 1, "policy": "warn"}`,
+			exceeded:       1,
+			policy:         models.BudgetPolicyWarn,
+			events:         []string{services.IntentBudgetWarnedEvent},
+			recordedBudget: models.WorkflowBudget{MaxCost: 1, Policy: models.BudgetPolicyWarn},
+		},
+		{
+			name:           "over budget",
+			budget:         `{"max_cost": 1, "max_duration_seconds": 300}`,
+			exceeded:       2,
+			policy:         models.BudgetPolicyFail,
+			events:         []string{services.IntentBudgetExceededEvent},
+			recordedBudget: models.WorkflowBudget{MaxCost: 1, MaxDurationSeconds: 300},
+		},
+		{
+			name:            "project default budget",
+			projectSettings: `{"default_budget": {"max_duration_seconds": 300}}`,
+			exceeded:        1,
+			policy:          models.BudgetPolicyFail,
+			events:          []string{services.IntentBudgetExceededEvent},
+			recordedBudget:  models.WorkflowBudget{MaxDurationSeconds: 300},
+		},
+		{
+			name:            "workflow budget ahead of the project default",
+			budget:          `{"max_duration_seconds": 900}`,
+			projectSettings: `{"default_budget": {"max_duration_seconds": 300}}`,
+			policy:          models.BudgetPolicyFail,
+			recordedBudget:  models.WorkflowBudget{MaxDurationSeconds: 900},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, env, activities := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{
+				IntentType:    "code_generation",
+				Confidence:    0.9,
+				EstimatedTime: 600,
+				EstimatedCost: 2.5,
+			})
+			for _, stmt := range []string{
+				`ALTER TABLE workflows ADD COLUMN budget TEXT`,
+				`ALTER TABLE workflows ADD COLUMN estimated_time INTEGER`,
+				`ALTER TABLE workflows ADD COLUMN estimated_cost REAL`,
+				`ALTER TABLE workflows ADD COLUMN updated_at DATETIME`,
+				`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`,
+			} {
+				require.NoError(t, db.Exec(stmt).Error)
+			}
+			if tt.budget != "" {
+				require.NoError(t, db.Exec(`UPDATE workflows SET budget = ?`, tt.budget).Error)
+			}
+			if tt.projectSettings != "" {
+				require.NoError(t, db.Exec(`INSERT INTO projects (id, settings) VALUES ('project-1', ?)`,
+					[]byte(tt.projectSettings)).Error)
+			}
+
+			events := &riskEvents{}
+			activities.budgets = services.NewBudgetEnforcer(db, &config.BudgetConfig{Policy: "fail"}, events, zap.NewNop())
+
+			val, err := env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Type: "generate", Content: "add an endpoint"})
+			require.NoError(t, err)
+			var result IntentAnalysisResult
+			require.NoError(t, val.Get(&result))
+			require.NotNil(t, result.BudgetDecision)
+			assert.Len(t, result.BudgetDecision.Exceeded, tt.exceeded)
+			assert.Equal(t, tt.policy, result.BudgetDecision.Policy)
+			assert.Equal(t, tt.events, events.types)
+
+			var workflow models.Workflow
+			require.NoError(t, db.Select("id", "budget", "estimated_time", "estimated_cost").
+				First(&workflow, "id = ?", testWorkflowID).Error)
+			require.NotNil(t, workflow.EstimatedTime)
+			require.NotNil(t, workflow.EstimatedCost)
+			assert.Equal(t, 600, *workflow.EstimatedTime)
+			assert.Equal(t, 2.5, *workflow.EstimatedCost)
+			require.NotNil(t, workflow.Budget)
+			assert.Equal(t, tt.recordedBudget, *workflow.Budget)
+		})
+	}
+}
+
+// budgetedIntentEnv runs an intent processing workflow whose analysis
+// carries the budget decision
+func budgetedIntentEnv(t *testing.T, decision *services.BudgetDecision) (*testsuite.TestWorkflowEnvironment, *WorkflowEngine, *models.Workflow) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	input, err := json.Marshal(IntentData{Content: "add an endpoint"})
+	require.NoError(t, err)
+	wf := &models.Workflow{ID: "wf-1", Type: models.WorkflowTypeIntent, Input: input}
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.IntentProcessingWorkflow)
+	env.RegisterActivityWithOptions(func(ctx context.Context, intent IntentData) (*IntentAnalysisResult, error) {
+		return &IntentAnalysisResult{IntentType: "code_generation", BudgetDecision: decision}, nil
+	}, activity.RegisterOptions{Name: "AnalyzeIntentActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, analysis IntentAnalysisResult) (*ExecutionPlan, error) {
+		return &ExecutionPlan{}, nil
+	}, activity.RegisterOptions{Name: "CreateExecutionPlanActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, results []StepResult) (*WorkflowResult, error) {
+		return &WorkflowResult{Status: "completed"}, nil
+	}, activity.RegisterOptions{Name: "AggregateResultsActivity"})
+	return env, engine, wf
+}
+
+func TestIntentProcessingWorkflow_Budget(t *testing.T) {
+	budget := models.WorkflowBudget{MaxCost: 5}
+	tests := []struct {
+		name     string
+		decision *services.BudgetDecision
+	}{
+		{"no budget", nil},
+		{"under budget", &services.BudgetDecision{Budget: budget, EstimatedCost: 2, Policy: models.BudgetPolicyFail}},
+		{"over budget with warn policy", &services.BudgetDecision{
+			Budget: budget, EstimatedCost: 8, Policy: models.BudgetPolicyWarn,
+			Exceeded: []string{"estimated cost 8.00 exceeds max_cost 5.00"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, engine, wf := budgetedIntentEnv(t, tt.decision)
+			env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+			require.True(t, env.IsWorkflowCompleted())
+			require.NoError(t, env.GetWorkflowError())
+		})
+	}
+}
+
+func TestIntentProcessingWorkflow_BudgetExceeded(t *testing.T) {
+	env, engine, wf := budgetedIntentEnv(t, &services.BudgetDecision{
+		Budget:        models.WorkflowBudget{MaxCost: 5},
+		EstimatedCost: 8,
+		Policy:        models.BudgetPolicyFail,
+		Exceeded:      []string{"estimated cost 8.00 exceeds max_cost 5.00"},
+	})
+	env.OnActivity("CreateExecutionPlanActivity", mock.Anything, mock.Anything).Never()
+
+	env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(env.GetWorkflowError(), &appErr))
+	assert.Equal(t, intentBudgetExceededError, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+	assert.Contains(t, appErr.Error(), "intent exceeds budget: estimated cost 8.00 exceeds max_cost 5.00")
+}
@@ -0,0 +1,118 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// Error types of intent processing workflows stopped by the risk gate
+const (
+	intentRiskBlockedError = "IntentRiskBlocked"
+	intentRiskDeniedError  = "IntentRiskDenied"
+)
+
+// defaultRiskConfirmationTimeout bounds the wait for a confirmation when the
+// decision carries no timeout
+const defaultRiskConfirmationTimeout = 72 * time.Hour
+
+// evaluateIntentRisks applies the risk policy of the workflow's project to
+// the risks of its intent and records the decision. Intents without risks,
+// or analyzed without a risk gate, get no decision.
+func (a *Activities) evaluateIntentRisks(ctx context.Context, risks []string) (*services.RiskDecision, error) {
+	if a.risks == nil || len(risks) == 0 {
+		return nil, nil
+	}
+
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "project_id").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+
+	decision, err := a.risks.Evaluate(ctx, workflow.ProjectID, risks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate risk policy: %w", err)
+	}
+	if err := a.risks.RecordDecision(ctx, &workflow, decision); err != nil {
+		return nil, err
+	}
+	return decision, nil
+}
+
+// RecordRiskConfirmationActivity records how the pending risk confirmation
+// of the workflow ended: confirmed, denied or, for a nil confirmation, timed
+// out
+func (a *Activities) RecordRiskConfirmationActivity(ctx context.Context, confirmation *services.RiskConfirmation) error {
+	if a.risks == nil {
+		return nil
+	}
+	return a.risks.RecordConfirmation(ctx, activity.GetInfo(ctx).WorkflowExecution.ID, confirmation)
+}
+
+// gateIntentRisks acts on the risk policy's decision on an intent. Blocked
+// intents fail the workflow; intents requiring confirmation hold it until the
+// confirmation signal arrives, failing it when their risks are denied or the
+// confirmation times out.
+func (w *WorkflowEngine) gateIntentRisks(ctx workflow.Context, wf *models.Workflow, progress *workflowProgress, decision *services.RiskDecision) error {
+	if decision == nil {
+		return nil
+	}
+	logger := workflow.GetLogger(ctx)
+
+	switch decision.Action {
+	case models.RiskActionBlock:
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("intent blocked by risk policy: %s", strings.Join(decision.TriggeredRisks, "; ")),
+			intentRiskBlockedError, nil, decision.TriggeredRisks)
+	case models.RiskActionRequireConfirmation:
+	default:
+		return nil
+	}
+
+	progress.start(intentStepRiskConfirmation)
+	timeout := decision.ConfirmationTimeout
+	if timeout <= 0 {
+		timeout = defaultRiskConfirmationTimeout
+	}
+	logger.Info("Waiting for intent risks to be confirmed", "workflowID", wf.ID, "risks", decision.TriggeredRisks)
+
+	var confirmation *services.RiskConfirmation
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, services.IntentRiskConfirmationSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, &confirmation)
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, timeout), func(f workflow.Future) {})
+	selector.Select(ctx)
+	cancelTimer()
+
+	// The outcome is recorded for the API; failing to record it does not
+	// change it
+	if err := workflow.ExecuteActivity(ctx, ActivityRecordRiskConfirmation, confirmation).Get(ctx, nil); err != nil {
+		logger.Error("Failed to record risk confirmation", "workflowID", wf.ID, "error", err)
+	}
+
+	switch {
+	case confirmation == nil:
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("intent risk confirmation timed out after %s", timeout),
+			intentRiskDeniedError, nil, decision.TriggeredRisks)
+	case !confirmation.Confirmed:
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("intent risks denied by %s: %s", confirmation.Actor, confirmation.Comment),
+			intentRiskDeniedError, nil, decision.TriggeredRisks)
+	}
+	logger.Info("Intent risks confirmed", "workflowID", wf.ID, "actor", confirmation.Actor)
+	progress.complete(intentStepRiskConfirmation)
+	return nil
+}
@@ -0,0 +1,221 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// riskEvents is an in-memory services.WorkflowEventPublisher keeping the
+// event types it published
+type riskEvents struct {
+	mu    sync.Mutex
+	types []string
+}
+
+func (r *riskEvents) Publish(ctx context.Context, projectID string, event []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var decoded struct {
+		EventType string `json:"event_type"`
+	}
+	if err := json.Unmarshal(event, &decoded); err != nil {
+		return err
+	}
+	r.types = append(r.types, decoded.EventType)
+	return nil
+}
+
+func TestAnalyzeIntentActivity_AppliesRiskPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		risks  []string
+		action models.RiskAction
+		event  string
+	}{
+		{"allow", []string{"style: long functions"}, models.RiskActionAllow, services.IntentRiskAllowedEvent},
+		{"warn", []string{"raises cloud cost"}, models.RiskActionWarn, services.IntentRiskWarnedEvent},
+		{"require confirmation", []string{"restarts production pods"}, models.RiskActionRequireConfirmation, services.IntentRiskConfirmationRequiredEvent},
+		{"block", []string{"raises cloud cost", "data_loss: drops the users table"}, models.RiskActionBlock, services.IntentRiskBlockedEvent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, env, activities := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{
+				IntentType: "deployment",
+				Confidence: 0.9,
+				Risks:      tt.risks,
+			})
+			require.NoError(t, db.AutoMigrate(&models.IntentRiskReview{}))
+			require.NoError(t, db.Exec(`CREATE TABLE projects (id TEXT PRIMARY KEY, settings BLOB, deleted_at DATETIME)`).Error)
+			require.NoError(t, db.Exec(`INSERT INTO projects (id, settings) VALUES ('project-1', ?)`,
+				[]byte(`{"risk_policy": {"rules": [{"keywords": ["production"], "action": "require_confirmation"}]}}`)).Error)
+
+			events := &riskEvents{}
+			activities.risks = services.NewRiskGate(db, &config.RiskPolicyConfig{
+				DefaultAction:       "allow",
+				ConfirmationTimeout: 600,
+				Rules: []config.RiskRuleConfig{
+					{Category: "data_loss", Action: "block"},
+					{Keywords: []string{"cost"}, Action: "warn"},
+				},
+			}, events, zap.NewNop())
+
+			val, err := env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Type: "deploy", Content: "ship it"})
+			require.NoError(t, err)
+			var result IntentAnalysisResult
+			require.NoError(t, val.Get(&result))
+			require.NotNil(t, result.RiskDecision)
+			assert.Equal(t, tt.action, result.RiskDecision.Action)
+			assert.Equal(t, []string{tt.event}, events.types)
+
+			var review models.IntentRiskReview
+			require.NoError(t, db.First(&review, "workflow_id = ?", testWorkflowID).Error)
+			assert.Equal(t, "project-1", review.ProjectID)
+			assert.Equal(t, tt.action, review.Action)
+			if tt.action == models.RiskActionRequireConfirmation {
+				assert.Equal(t, models.RiskReviewPending, review.Status)
+				assert.Equal(t, 10*time.Minute, result.RiskDecision.ConfirmationTimeout)
+			} else {
+				assert.Empty(t, review.Status)
+			}
+			if tt.action == models.RiskActionBlock {
+				assert.Equal(t, models.StringArray{"data_loss: drops the users table"}, review.TriggeredRisks)
+			}
+		})
+	}
+}
+
+func TestAnalyzeIntentActivity_NoRisksNoDecision(t *testing.T) {
+	db, env, activities := setupIntentAnalysisTest(t, services.AnalyzeIntentResponse{IntentType: "deployment", Confidence: 0.9})
+	events := &riskEvents{}
+	activities.risks = services.NewRiskGate(db, &config.RiskPolicyConfig{DefaultAction: "block"}, events, zap.NewNop())
+
+	val, err := env.ExecuteActivity("AnalyzeIntentActivity", IntentData{Type: "deploy", Content: "ship it"})
+	require.NoError(t, err)
+	var result IntentAnalysisResult
+	require.NoError(t, val.Get(&result))
+	assert.Nil(t, result.RiskDecision)
+	assert.Empty(t, events.types)
+}
+
+// riskGatedIntentEnv runs an intent processing workflow whose analysis
+// carries the decision, recording the confirmations the workflow records
+func riskGatedIntentEnv(t *testing.T, decision *services.RiskDecision) (*testsuite.TestWorkflowEnvironment, *WorkflowEngine, *models.Workflow, *[]*services.RiskConfirmation) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	input, err := json.Marshal(IntentData{Content: "deploy the release"})
+	require.NoError(t, err)
+	wf := &models.Workflow{ID: "wf-1", Type: models.WorkflowTypeIntent, Input: input}
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.IntentProcessingWorkflow)
+	env.RegisterActivityWithOptions(func(ctx context.Context, intent IntentData) (*IntentAnalysisResult, error) {
+		return &IntentAnalysisResult{IntentType: "deployment", RiskDecision: decision}, nil
+	}, activity.RegisterOptions{Name: "AnalyzeIntentActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, analysis IntentAnalysisResult) (*ExecutionPlan, error) {
+		return &ExecutionPlan{}, nil
+	}, activity.RegisterOptions{Name: "CreateExecutionPlanActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, results []StepResult) (*WorkflowResult, error) {
+		return &WorkflowResult{Status: "completed"}, nil
+	}, activity.RegisterOptions{Name: "AggregateResultsActivity"})
+
+	var recorded []*services.RiskConfirmation
+	env.RegisterActivityWithOptions(func(ctx context.Context, confirmation *services.RiskConfirmation) error {
+		recorded = append(recorded, confirmation)
+		return nil
+	}, activity.RegisterOptions{Name: "RecordRiskConfirmationActivity"})
+	return env, engine, wf, &recorded
+}
+
+func TestIntentProcessingWorkflow_RiskBlocked(t *testing.T) {
+	env, engine, wf, recorded := riskGatedIntentEnv(t, &services.RiskDecision{
+		Action:         models.RiskActionBlock,
+		TriggeredRisks: []string{"data_loss: drops the users table", "data_loss: deletes backups"},
+	})
+	env.OnActivity("CreateExecutionPlanActivity", mock.Anything, mock.Anything).Never()
+
+	env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(env.GetWorkflowError(), &appErr))
+	assert.Equal(t, intentRiskBlockedError, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+	assert.Contains(t, appErr.Error(), "data_loss: drops the users table; data_loss: deletes backups")
+	assert.Empty(t, *recorded)
+}
+
+func TestIntentProcessingWorkflow_RiskConfirmed(t *testing.T) {
+	env, engine, wf, recorded := riskGatedIntentEnv(t, &services.RiskDecision{
+		Action:              models.RiskActionRequireConfirmation,
+		TriggeredRisks:      []string{"restarts production pods"},
+		ConfirmationTimeout: 24 * time.Hour,
+	})
+
+	var waiting services.WorkflowState
+	env.RegisterDelayedCallback(func() {
+		waiting = queryState(t, env)
+		env.SignalWorkflow(services.IntentRiskConfirmationSignal, services.RiskConfirmation{Confirmed: true, Actor: "alice"})
+	}, time.Hour)
+
+	env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, intentStepRiskConfirmation, waiting.Phase)
+	assert.Equal(t, []string{intentStepAnalyze}, waiting.CompletedSteps)
+	require.Len(t, *recorded, 1)
+	assert.Equal(t, &services.RiskConfirmation{Confirmed: true, Actor: "alice"}, (*recorded)[0])
+}
+
+func TestIntentProcessingWorkflow_RiskDenied(t *testing.T) {
+	env, engine, wf, recorded := riskGatedIntentEnv(t, &services.RiskDecision{
+		Action:              models.RiskActionRequireConfirmation,
+		TriggeredRisks:      []string{"restarts production pods"},
+		ConfirmationTimeout: 24 * time.Hour,
+	})
+	env.OnActivity("CreateExecutionPlanActivity", mock.Anything, mock.Anything).Never()
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(services.IntentRiskConfirmationSignal,
+			services.RiskConfirmation{Actor: "alice", Comment: "not during the freeze"})
+	}, time.Hour)
+
+	env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(env.GetWorkflowError(), &appErr))
+	assert.Equal(t, intentRiskDeniedError, appErr.Type())
+	assert.Contains(t, appErr.Error(), "intent risks denied by alice: not during the freeze")
+	require.Len(t, *recorded, 1)
+	assert.False(t, (*recorded)[0].Confirmed)
+}
+
+func TestIntentProcessingWorkflow_RiskConfirmationTimesOut(t *testing.T) {
+	env, engine, wf, recorded := riskGatedIntentEnv(t, &services.RiskDecision{
+		Action:              models.RiskActionRequireConfirmation,
+		TriggeredRisks:      []string{"restarts production pods"},
+		ConfirmationTimeout: time.Hour,
+	})
+
+	env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), "confirmation timed out after 1h0m0s")
+	require.Len(t, *recorded, 1)
+	assert.Nil(t, (*recorded)[0])
+}
@@ -0,0 +1,466 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+const (
+	// defaultAcceptanceThreshold is the quality score accepting a round when
+	// the task config sets none
+	defaultAcceptanceThreshold = 0.8
+	// maxRefinementRounds bounds the rounds a task config may ask for
+	maxRefinementRounds = 10
+
+	reviewerHeuristic = "heuristic"
+	reviewerAgent     = "agent"
+
+	// conversationTurnEvent is the execution event type recorded per round
+	conversationTurnEvent = "conversation_turn"
+)
+
+// ErrInvalidRefinementConfig is returned for task configs whose refinement
+// settings cannot be used
+var ErrInvalidRefinementConfig = errors.New("invalid refinement config")
+
+// RefinementConfig holds the refinement settings of a task config, e.g.
+//
+//	{"max_rounds": 3, "acceptance_threshold": 0.9, "reviewer": "agent", "reviewer_agent_id": "..."}
+type RefinementConfig struct {
+	MaxRounds           int     `json:"max_rounds"`
+	AcceptanceThreshold float64 `json:"acceptance_threshold"`        // Quality score between 0 and 1
+	Reviewer            string  `json:"reviewer,omitempty"`          // "heuristic" (default) or "agent"
+	ReviewerAgentID     string  `json:"reviewer_agent_id,omitempty"` // Required for the agent reviewer
+}
+
+// taskRefinementConfig reads the refinement settings from a task config. It
+// returns nil for tasks that run in a single round.
+func taskRefinementConfig(task Task) (*RefinementConfig, error) {
+	if _, ok := task.Config["max_rounds"]; !ok {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(task.Config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefinementConfig, err)
+	}
+	var cfg RefinementConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefinementConfig, err)
+	}
+	if cfg.MaxRounds <= 0 {
+		return nil, nil
+	}
+
+	if cfg.MaxRounds > maxRefinementRounds {
+		return nil, fmt.Errorf("%w: max_rounds must be at most %d", ErrInvalidRefinementConfig, maxRefinementRounds)
+	}
+	if cfg.AcceptanceThreshold == 0 {
+		cfg.AcceptanceThreshold = defaultAcceptanceThreshold
+	}
+	if cfg.AcceptanceThreshold < 0 || cfg.AcceptanceThreshold > 1 {
+		return nil, fmt.Errorf("%w: acceptance_threshold must be between 0 and 1", ErrInvalidRefinementConfig)
+	}
+	switch cfg.Reviewer {
+	case "":
+		cfg.Reviewer = reviewerHeuristic
+	case reviewerHeuristic:
+	case reviewerAgent:
+		if cfg.ReviewerAgentID == "" {
+			return nil, fmt.Errorf("%w: the agent reviewer requires reviewer_agent_id", ErrInvalidRefinementConfig)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown reviewer %q", ErrInvalidRefinementConfig, cfg.Reviewer)
+	}
+	return &cfg, nil
+}
+
+// QualityReview is the verdict of a quality check on one round's output
+type QualityReview struct {
+	Score    float64  `json:"score"` // Between 0 and 1
+	Feedback []string `json:"feedback,omitempty"`
+}
+
+// qualityCheck scores the output of a refinement round
+type qualityCheck func(ctx context.Context, task Task, output map[string]interface{}, artifacts []Artifact) (*QualityReview, error)
+
+// heuristicQualityCheck scores artifacts locally: something must be produced,
+// no artifact may be empty or contain placeholders, and tasks that call for
+// tests must include them. The score is the share of checks passed.
+func heuristicQualityCheck(ctx context.Context, task Task, output map[string]interface{}, artifacts []Artifact) (*QualityReview, error) {
+	if len(artifacts) == 0 {
+		return &QualityReview{Feedback: []string{"no artifacts were produced"}}, nil
+	}
+
+	checks, passed := 0, 0
+	var feedback []string
+	check := func(ok bool, problem string) {
+		checks++
+		if ok {
+			passed++
+		} else {
+			feedback = append(feedback, problem)
+		}
+	}
+
+	hasTests := false
+	for _, artifact := range artifacts {
+		content := strings.TrimSpace(artifact.Content)
+		check(content != "", fmt.Sprintf("%s is empty", artifact.Name))
+		check(!strings.Contains(content, "TODO") && !strings.Contains(content, "FIXME"),
+			fmt.Sprintf("%s contains TODO or FIXME placeholders", artifact.Name))
+		if isTestArtifact(artifact) {
+			hasTests = true
+		}
+	}
+	if shouldGenerateTests(task.Type) {
+		check(hasTests, "no tests were produced")
+	}
+
+	return &QualityReview{Score: float64(passed) / float64(checks), Feedback: feedback}, nil
+}
+
+// agentQualityCheck has a second agent review each round. The reviewer
+// answers a review_output task with a score and feedback.
+func agentQualityCheck(executor services.AgentExecutor, reviewerID string) qualityCheck {
+	return func(ctx context.Context, task Task, output map[string]interface{}, artifacts []Artifact) (*QualityReview, error) {
+		resp, err := executor.ExecuteTask(ctx, reviewerID, &services.ExecuteTaskRequest{
+			Type: "review_output",
+			Input: map[string]interface{}{
+				"task_id":             task.ID,
+				"title":               task.Title,
+				"description":         task.Description,
+				"acceptance_criteria": task.AcceptanceCriteria,
+				"output":              output,
+				"artifacts":           artifacts,
+			},
+			Priority: task.Priority,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review output: %w", err)
+		}
+
+		review := &QualityReview{}
+		if score, ok := resp.Output["score"].(float64); ok {
+			review.Score = score
+		}
+		switch feedback := resp.Output["feedback"].(type) {
+		case string:
+			if feedback != "" {
+				review.Feedback = []string{feedback}
+			}
+		case []interface{}:
+			for _, item := range feedback {
+				if s, ok := item.(string); ok {
+					review.Feedback = append(review.Feedback, s)
+				}
+			}
+		}
+		return review, nil
+	}
+}
+
+// refinementRound is the record of one round, stored as an execution event
+type refinementRound struct {
+	Round         int      `json:"round"`
+	TurnID        string   `json:"turn_id"`
+	Status        string   `json:"status"`
+	Score         float64  `json:"score"`
+	Feedback      []string `json:"feedback,omitempty"`
+	Accepted      bool     `json:"accepted"`
+	ArtifactCount int      `json:"artifact_count"`
+	DurationMs    int64    `json:"duration_ms"`
+	Cost          float64  `json:"cost"`
+}
+
+// IterativeTaskActivity executes a task over an agent conversation in up to
+// max_rounds refinement rounds. After each round a quality check scores the
+// output; its feedback is the next turn until a round reaches the acceptance
+// threshold. Every round is recorded as an event of one execution, whose
+// duration and cost are the totals over the rounds. Tasks that never reach
+// the threshold end with status below_threshold and their best round.
+func (a *Activities) IterativeTaskActivity(ctx context.Context, task Task, agent AgentInfo, sim *services.SimulationConfig) (*TaskExecutionResult, error) {
+	logger := activity.GetLogger(ctx)
+	startTime := time.Now()
+
+	refinement, err := taskRefinementConfig(task)
+	if err != nil {
+		return nil, err
+	}
+	if refinement == nil {
+		return nil, fmt.Errorf("%w: task %s has no refinement rounds", ErrInvalidRefinementConfig, task.ID)
+	}
+
+	executor := agentExecutorFor(a.agentClient, sim)
+	conversations, ok := executor.(services.ConversationalAgent)
+	if !ok {
+		return nil, fmt.Errorf("agent client does not support conversations")
+	}
+	check := heuristicQualityCheck
+	if refinement.Reviewer == reviewerAgent {
+		check = agentQualityCheck(executor, refinement.ReviewerAgentID)
+	}
+
+	logger.Info("Executing task in refinement rounds",
+		zap.String("taskID", task.ID),
+		zap.String("agentID", agent.ID),
+		zap.Int("maxRounds", refinement.MaxRounds),
+		zap.Float64("acceptanceThreshold", refinement.AcceptanceThreshold),
+		zap.String("reviewer", refinement.Reviewer))
+
+	execution, err := a.createIterativeExecution(ctx, task, agent, sim != nil, startTime)
+	if err != nil {
+		return nil, err
+	}
+
+	conversation, err := conversations.CreateConversation(ctx, agent.ID, &services.CreateConversationRequest{
+		Type: task.Type,
+		Context: map[string]interface{}{
+			"task_id":                task.ID,
+			"title":                  task.Title,
+			"description":            task.Description,
+			"acceptance_criteria":    task.AcceptanceCriteria,
+			"technical_requirements": task.TechnicalRequirements,
+		},
+		Config: map[string]interface{}{
+			"generate_tests":  shouldGenerateTests(task.Type),
+			"generate_docs":   shouldGenerateDocs(task.Type),
+			"target_language": getTargetLanguage(task),
+		},
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to create conversation: %w", err)
+		a.finishIterativeExecution(ctx, execution, nil, err)
+		return nil, err
+	}
+	defer func() {
+		if err := conversations.CloseConversation(ctx, agent.ID, conversation.ID); err != nil {
+			logger.Warn("Failed to close conversation", zap.String("conversationID", conversation.ID), zap.Error(err))
+		}
+	}()
+
+	var (
+		rounds     []refinementRound
+		best       *TaskExecutionResult
+		bestScore  = -1.0
+		accepted   bool
+		feedback   []string
+		turnOutput map[string]interface{}
+	)
+	for round := 1; round <= refinement.MaxRounds && !accepted; round++ {
+		recordProgress(ctx, 100*(round-1)/refinement.MaxRounds, "refining_task",
+			fmt.Sprintf("Round %d/%d of task %s", round, refinement.MaxRounds, task.ID))
+
+		input := map[string]interface{}{"round": round}
+		if round == 1 {
+			input["instruction"] = task.Description
+		} else {
+			input["instruction"] = "Revise your previous answer to address the review feedback"
+			input["feedback"] = feedback
+		}
+
+		roundStart := time.Now()
+		turn, err := conversations.SendTurn(ctx, agent.ID, conversation.ID, &services.SendTurnRequest{Input: input})
+		if err == nil && turn.Status == "failed" {
+			err = errors.New(turn.Error)
+		}
+		if err != nil {
+			err = fmt.Errorf("refinement round %d failed: %w", round, err)
+			a.finishIterativeExecution(ctx, execution, rounds, err)
+			return nil, err
+		}
+		turnOutput = turn.Output
+
+		var artifacts []Artifact
+		if artifactsData, ok := turnOutput["artifacts"]; ok {
+			artifacts = extractArtifacts(artifactsData, task.ID)
+		}
+		review, err := check(ctx, task, turnOutput, artifacts)
+		if err != nil {
+			err = fmt.Errorf("refinement round %d failed: %w", round, err)
+			a.finishIterativeExecution(ctx, execution, rounds, err)
+			return nil, err
+		}
+		accepted = review.Score >= refinement.AcceptanceThreshold
+		feedback = review.Feedback
+
+		// Agents that do not report a duration are charged the round's
+		// wall-clock time
+		durationMs := turn.Duration
+		if durationMs == 0 {
+			durationMs = time.Since(roundStart).Milliseconds()
+		}
+		rounds = append(rounds, refinementRound{
+			Round:         round,
+			TurnID:        turn.ID,
+			Status:        turn.Status,
+			Score:         review.Score,
+			Feedback:      review.Feedback,
+			Accepted:      accepted,
+			ArtifactCount: len(artifacts),
+			DurationMs:    durationMs,
+			Cost:          turn.Cost,
+		})
+		if err := a.recordRefinementRound(ctx, execution.ID, rounds[len(rounds)-1]); err != nil {
+			logger.Warn("Failed to record refinement round", zap.Int("round", round), zap.Error(err))
+		}
+		a.recordRoundMetrics(execution.ID, rounds[len(rounds)-1])
+		a.recordRoundArtifacts(ctx, execution, artifacts)
+
+		logger.Info("Refinement round completed",
+			zap.String("taskID", task.ID),
+			zap.Int("round", round),
+			zap.Float64("score", review.Score),
+			zap.Bool("accepted", accepted))
+
+		if review.Score > bestScore {
+			bestScore = review.Score
+			best = &TaskExecutionResult{Output: turnOutput, Artifacts: artifacts}
+		}
+	}
+
+	a.finishIterativeExecution(ctx, execution, rounds, nil)
+
+	status := models.ExecutionStatusSucceeded
+	if !accepted {
+		status = models.ExecutionStatusBelowThreshold
+	}
+	result := &TaskExecutionResult{
+		TaskID:    task.ID,
+		AgentID:   agent.ID,
+		Status:    status,
+		Output:    best.Output,
+		Artifacts: best.Artifacts,
+		StartTime: startTime,
+		EndTime:   time.Now(),
+		Duration:  time.Since(startTime),
+	}
+	if result.Output == nil {
+		result.Output = make(map[string]interface{})
+	}
+	result.Output["refinement"] = map[string]interface{}{
+		"conversation_id": conversation.ID,
+		"execution_id":    execution.ID,
+		"rounds":          len(rounds),
+		"accepted":        accepted,
+		"score":           bestScore,
+		"threshold":       refinement.AcceptanceThreshold,
+		"feedback":        feedback,
+	}
+
+	recordProgress(ctx, 100, "task_completed", fmt.Sprintf("Task %s %s after %d rounds", task.ID, status, len(rounds)))
+	return result, nil
+}
+
+// createIterativeExecution records the execution that refinement rounds are
+// attached to
+func (a *Activities) createIterativeExecution(ctx context.Context, task Task, agent AgentInfo, simulated bool, startTime time.Time) (*models.Execution, error) {
+	workflow, err := a.currentWorkflow(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := json.Marshal(task)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal task: %w", err)
+	}
+	execution := &models.Execution{
+		ProjectID:  workflow.ProjectID,
+		WorkflowID: workflow.ID,
+		AgentID:    agent.ID,
+		Name:       fmt.Sprintf("Refine %s", task.Title),
+		Type:       models.ExecutionTypeCustom,
+		Status:     models.ExecutionStatusRunning,
+		Input:      input,
+		QueuedAt:   timePtr(activityScheduledAt(ctx)),
+		StartedAt:  &startTime,
+		Simulated:  simulated,
+	}
+	if err := a.db.WithContext(ctx).Create(execution).Error; err != nil {
+		return nil, fmt.Errorf("failed to create execution: %w", err)
+	}
+	return execution, nil
+}
+
+// currentWorkflow loads the ID and project of the workflow running the
+// activity
+func (a *Activities) currentWorkflow(ctx context.Context) (*models.Workflow, error) {
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "project_id").
+		First(&workflow, "id = ?", activity.GetInfo(ctx).WorkflowExecution.ID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	return &workflow, nil
+}
+
+// recordRefinementRound stores a round as an event of the execution
+func (a *Activities) recordRefinementRound(ctx context.Context, executionID string, round refinementRound) error {
+	data, err := json.Marshal(round)
+	if err != nil {
+		return fmt.Errorf("failed to marshal refinement round: %w", err)
+	}
+	event := &models.ExecutionEvent{
+		ExecutionID: executionID,
+		Type:        conversationTurnEvent,
+		Name:        fmt.Sprintf("round_%d", round.Round),
+		Data:        data,
+		Timestamp:   time.Now(),
+	}
+	if err := a.db.WithContext(ctx).Create(event).Error; err != nil {
+		return fmt.Errorf("failed to save execution event: %w", err)
+	}
+	return nil
+}
+
+// finishIterativeExecution completes the execution with the totals over its
+// rounds. Failures are logged; they do not fail the task.
+func (a *Activities) finishIterativeExecution(ctx context.Context, execution *models.Execution, rounds []refinementRound, runErr error) {
+	var durationMs int64
+	var cost, score float64
+	accepted := false
+	for _, round := range rounds {
+		durationMs += round.DurationMs
+		cost += round.Cost
+		if round.Score > score {
+			score = round.Score
+		}
+		accepted = accepted || round.Accepted
+	}
+	metadata, err := json.Marshal(map[string]interface{}{
+		"rounds":     len(rounds),
+		"cost":       cost,
+		"accepted":   accepted,
+		"best_score": score,
+	})
+	if err != nil {
+		a.logger.Warn("Failed to marshal execution metadata", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":       models.ExecutionStatusSucceeded,
+		"duration":     durationMs,
+		"metadata":     metadata,
+		"completed_at": now,
+	}
+	if runErr != nil {
+		updates["status"] = models.ExecutionStatusFailed
+		updates["error"] = runErr.Error()
+	}
+	if err := a.db.WithContext(ctx).Model(&models.Execution{}).Where("id = ?", execution.ID).Updates(updates).Error; err != nil {
+		a.logger.Warn("Failed to complete execution",
+			zap.String("executionID", execution.ID),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,268 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// scriptedAgent is a conversational agent whose answers improve turn by turn
+type scriptedAgent struct {
+	services.AgentExecutor
+	script [][]services.StubArtifact
+
+	mu     sync.Mutex
+	turns  []services.SendTurnRequest
+	closed bool
+}
+
+func (s *scriptedAgent) CreateConversation(ctx context.Context, agentID string, req *services.CreateConversationRequest) (*services.Conversation, error) {
+	return &services.Conversation{ID: "conversation-1", AgentID: agentID, Type: req.Type, Status: "open"}, nil
+}
+
+func (s *scriptedAgent) SendTurn(ctx context.Context, agentID, conversationID string, req *services.SendTurnRequest) (*services.ConversationTurn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns = append(s.turns, *req)
+	artifacts := s.script[min(len(s.turns), len(s.script))-1]
+
+	output, err := normalizeOutput(map[string]interface{}{"artifacts": artifacts})
+	if err != nil {
+		return nil, err
+	}
+	return &services.ConversationTurn{
+		ID:             fmt.Sprintf("turn-%d", len(s.turns)),
+		ConversationID: conversationID,
+		Index:          len(s.turns) - 1,
+		Status:         "completed",
+		Input:          req.Input,
+		Output:         output,
+		Duration:       100,
+		Cost:           0.25,
+	}, nil
+}
+
+func (s *scriptedAgent) GetTurns(ctx context.Context, agentID, conversationID string) ([]services.ConversationTurn, error) {
+	return nil, nil
+}
+
+func (s *scriptedAgent) CloseConversation(ctx context.Context, agentID, conversationID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func normalizeOutput(output map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	var normalized map[string]interface{}
+	return normalized, json.Unmarshal(data, &normalized)
+}
+
+// improvingScript scores 1/3, 2/3 and 1 with the heuristic quality check
+func improvingScript() [][]services.StubArtifact {
+	return [][]services.StubArtifact{
+		{{Name: "orders.go", Type: "code", Content: "package orders // TODO"}},
+		{{Name: "orders.go", Type: "code", Content: "package orders"}},
+		{
+			{Name: "orders.go", Type: "code", Content: "package orders"},
+			{Name: "orders_test.go", Type: "test", Content: "package orders"},
+		},
+	}
+}
+
+func setupIterativeTest(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, metadata TEXT, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE executions (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			project_id TEXT, workflow_id TEXT, workflow_step_id TEXT, agent_id TEXT, name TEXT, type TEXT,
+			status TEXT, language TEXT, runtime TEXT, code TEXT, script TEXT, command TEXT, arguments TEXT,
+			environment TEXT, input TEXT, output TEXT, logs TEXT, error TEXT, error_signature TEXT, error_class TEXT, exit_code INTEGER,
+			started_at DATETIME, completed_at DATETIME, duration INTEGER, timeout_seconds INTEGER,
+			retry_count INTEGER, max_retries INTEGER, retry_delay INTEGER, resource_usage TEXT,
+			metadata TEXT, tags TEXT, priority INTEGER, simulated BOOLEAN, queued_at DATETIME,
+			scheduled_at DATETIME, created_by TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE execution_events (id TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			execution_id TEXT, type TEXT, name TEXT, data TEXT, timestamp DATETIME, created_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	require.NoError(t, db.Exec(`INSERT INTO workflows (id, project_id) VALUES (?, ?)`, testWorkflowID, "project-1").Error)
+	return db
+}
+
+// runIterativeTaskWorkflow runs the task execution workflow for one task with
+// the given refinement config, returning the task's result
+func runIterativeTaskWorkflow(t *testing.T, db *gorm.DB, agent *scriptedAgent, config map[string]interface{}) TaskExecutionResult {
+	input, err := json.Marshal(TaskExecutionInput{
+		ProjectID: "project-1",
+		Tasks:     []Task{{ID: "task-api", Title: "Orders API", Type: "backend", Config: config}},
+	})
+	require.NoError(t, err)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	activities := NewActivities(db, zap.NewNop(), nil, agent)
+
+	env.RegisterWorkflow(engine.TaskExecutionWorkflow)
+	env.RegisterActivity(activities.IterativeTaskActivity)
+	env.RegisterActivity(activities.AggregateTaskResultsActivity)
+	env.RegisterActivity(activities.StoreArtifactsActivity)
+	env.RegisterActivityWithOptions(func(ctx context.Context, task Task, sim *services.SimulationConfig) (*AgentInfo, error) {
+		return &AgentInfo{ID: "agent-1", Type: "backend"}, nil
+	}, activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+
+	var result TaskExecutionResult
+	env.SetOnActivityCompletedListener(func(info *activity.Info, value converter.EncodedValue, err error) {
+		if info.ActivityType.Name == "IterativeTaskActivity" {
+			require.NoError(t, err)
+			require.NoError(t, value.Get(&result))
+		}
+	})
+
+	env.ExecuteWorkflow(engine.TaskExecutionWorkflow, &models.Workflow{ID: testWorkflowID, Input: input})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	return result
+}
+
+func roundEvents(t *testing.T, db *gorm.DB) []refinementRound {
+	var events []models.ExecutionEvent
+	require.NoError(t, db.Order("name").Find(&events).Error)
+	rounds := make([]refinementRound, len(events))
+	for i, event := range events {
+		assert.Equal(t, conversationTurnEvent, event.Type)
+		require.NoError(t, json.Unmarshal(event.Data, &rounds[i]))
+	}
+	return rounds
+}
+
+func TestTaskExecutionWorkflow_IterativeTaskStopsOnAcceptance(t *testing.T) {
+	db := setupIterativeTest(t)
+	agent := &scriptedAgent{script: improvingScript()}
+
+	result := runIterativeTaskWorkflow(t, db, agent, map[string]interface{}{
+		"max_rounds":           5,
+		"acceptance_threshold": 0.9,
+	})
+
+	assert.Equal(t, models.ExecutionStatusSucceeded, result.Status)
+	assert.Equal(t, "agent-1", result.AgentID)
+	require.Len(t, result.Artifacts, 2)
+	assert.Equal(t, "orders_test.go", result.Artifacts[1].Name)
+	refinement, ok := result.Output["refinement"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, true, refinement["accepted"])
+	assert.EqualValues(t, 3, refinement["rounds"])
+
+	// Rounds stop at the first accepted answer, and each later turn carries
+	// the review feedback within the same conversation
+	require.Len(t, agent.turns, 3)
+	assert.True(t, agent.closed)
+	assert.NotContains(t, agent.turns[0].Input, "feedback")
+	assert.Equal(t, []string{"orders.go contains TODO or FIXME placeholders", "no tests were produced"},
+		agent.turns[1].Input["feedback"])
+	assert.Equal(t, []string{"no tests were produced"}, agent.turns[2].Input["feedback"])
+
+	rounds := roundEvents(t, db)
+	require.Len(t, rounds, 3)
+	assert.InDelta(t, 1.0/3, rounds[0].Score, 0.0001)
+	assert.InDelta(t, 2.0/3, rounds[1].Score, 0.0001)
+	assert.InDelta(t, 1.0, rounds[2].Score, 0.0001)
+	assert.False(t, rounds[1].Accepted)
+	assert.True(t, rounds[2].Accepted)
+
+	// The execution accounts for every round
+	var execution models.Execution
+	require.NoError(t, db.First(&execution).Error)
+	assert.Equal(t, "project-1", execution.ProjectID)
+	assert.Equal(t, testWorkflowID, execution.WorkflowID)
+	assert.Equal(t, models.ExecutionStatusSucceeded, execution.Status)
+	assert.Equal(t, int64(300), execution.Duration)
+	var metadata map[string]interface{}
+	require.NoError(t, json.Unmarshal(execution.Metadata, &metadata))
+	assert.InDelta(t, 0.75, metadata["cost"], 0.0001)
+	assert.EqualValues(t, 3, metadata["rounds"])
+}
+
+func TestTaskExecutionWorkflow_IterativeTaskStopsAtRoundCap(t *testing.T) {
+	db := setupIterativeTest(t)
+	agent := &scriptedAgent{script: improvingScript()}
+
+	result := runIterativeTaskWorkflow(t, db, agent, map[string]interface{}{
+		"max_rounds":           2,
+		"acceptance_threshold": 0.9,
+	})
+
+	assert.Equal(t, models.ExecutionStatusBelowThreshold, result.Status)
+	require.Len(t, agent.turns, 2)
+	assert.True(t, agent.closed)
+
+	// The best round is kept
+	require.Len(t, result.Artifacts, 1)
+	assert.Equal(t, "package orders", result.Artifacts[0].Content)
+	refinement := result.Output["refinement"].(map[string]interface{})
+	assert.Equal(t, false, refinement["accepted"])
+	assert.InDelta(t, 2.0/3, refinement["score"], 0.0001)
+
+	rounds := roundEvents(t, db)
+	require.Len(t, rounds, 2)
+	assert.False(t, rounds[1].Accepted)
+
+	var execution models.Execution
+	require.NoError(t, db.First(&execution).Error)
+	assert.Equal(t, int64(200), execution.Duration)
+}
+
+func TestTaskRefinementConfig(t *testing.T) {
+	cfg, err := taskRefinementConfig(Task{})
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = taskRefinementConfig(Task{Config: map[string]interface{}{"max_rounds": 3}})
+	require.NoError(t, err)
+	assert.Equal(t, &RefinementConfig{MaxRounds: 3, AcceptanceThreshold: defaultAcceptanceThreshold, Reviewer: reviewerHeuristic}, cfg)
+
+	for _, config := range []map[string]interface{}{
+		{"max_rounds": maxRefinementRounds + 1},
+		{"max_rounds": 2, "acceptance_threshold": 1.5},
+		{"max_rounds": 2, "reviewer": "agent"},
+		{"max_rounds": 2, "reviewer": "oracle"},
+		{"max_rounds": "two"},
+	} {
+		_, err := taskRefinementConfig(Task{Config: config})
+		assert.ErrorIs(t, err, ErrInvalidRefinementConfig, config)
+	}
+}
+
+func TestAgentQualityCheck(t *testing.T) {
+	reviewer := services.NewStubAgentClient(services.StubFixtures{
+		"review_output": {Output: map[string]interface{}{"score": 0.5, "feedback": []string{"handle empty orders"}}},
+	})
+
+	review, err := agentQualityCheck(reviewer, "reviewer-1")(context.Background(), Task{ID: "task-api", Type: "backend"},
+		map[string]interface{}{}, []Artifact{{Name: "orders.go", Content: "package orders"}})
+	require.NoError(t, err)
+	assert.Equal(t, &QualityReview{Score: 0.5, Feedback: []string{"handle empty orders"}}, review)
+}
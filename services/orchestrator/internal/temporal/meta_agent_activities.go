@@ -2,25 +2,40 @@ package temporal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"go.temporal.io/sdk/activity"
 	"go.uber.org/zap"
 
+	"orchestrator/internal/models"
 	"orchestrator/internal/services"
 )
 
 // MetaAgentActivities handles meta-agent specific workflow activities
 type MetaAgentActivities struct {
-	agentClient *services.AgentClient
+	agentClient services.AgentExecutor
 	logger      *zap.Logger
+	selection   services.AgentSelectionPolicy
+	preferences *services.AgentPreferenceService
+	spawns      *services.SpawnCoordinator
+	variables   *services.VariableService
+	estimates   *services.EstimateRefiner
+	agentGC     *services.AgentGarbageCollector
+	pools       *services.PoolManager
+	watchdog    AgentWatchdogConfig
 }
 
+// dynamicAgentTTL is the lifetime dynamic agents are spawned with
+const dynamicAgentTTL = time.Hour
+
 // NewMetaAgentActivities creates new meta-agent activities instance
 func NewMetaAgentActivities(
-	agentClient *services.AgentClient,
+	agentClient services.AgentExecutor,
 	logger *zap.Logger,
 ) *MetaAgentActivities {
 	return &MetaAgentActivities{
@@ -29,182 +44,352 @@ func NewMetaAgentActivities(
 	}
 }
 
-// FindOrCreateAgentForTaskActivity finds a suitable agent or creates one using meta-agent
-func (a *MetaAgentActivities) FindOrCreateAgentForTaskActivity(ctx context.Context, task Task) (*AgentInfo, error) {
+// newAgentInfo describes a selected agent along with how it was selected
+func newAgentInfo(agent *services.Agent, selection, reason string) *AgentInfo {
+	capNames := make([]string, len(agent.Capabilities))
+	for i, cap := range agent.Capabilities {
+		capNames[i] = cap.Name
+	}
+	return &AgentInfo{
+		ID:           agent.ID,
+		Type:         agent.Type,
+		Capabilities: capNames,
+		Status:       agent.Status,
+		Selection:    selection,
+		SelectionWhy: reason,
+	}
+}
+
+// AgentSelectionHints steer the selection of an agent for a task
+type AgentSelectionHints struct {
+	// Exclude lists agents not to select, such as one that stopped
+	// responding during an earlier attempt of the task
+	Exclude []string `json:"exclude,omitempty"`
+	// PreferredAgent ran an earlier task of the task's group and is reused
+	// while it is available and below its utilization threshold
+	PreferredAgent string `json:"preferred_agent,omitempty"`
+	GroupKey       string `json:"group_key,omitempty"`
+	// ProjectID is the project of the task, whose agent preferences apply
+	ProjectID string `json:"project_id,omitempty"`
+	// Region is the data residency region of the task's workflow; only
+	// agents running in it take the task
+	Region string `json:"region,omitempty"`
+}
+
+// projectID returns the project of the task; hints of workflows started
+// before they carried it fall back to the project of the context
+func (h AgentSelectionHints) projectID(ctx context.Context) string {
+	if h.ProjectID != "" {
+		return h.ProjectID
+	}
+	return getProjectIDFromContext(ctx)
+}
+
+// FindOrCreateAgentForTaskActivity finds a suitable agent or creates one using
+// meta-agent. The preferred agent of the hints is used when it can take the
+// task; otherwise the agent is selected as usual, leaving out excluded agents,
+// and the broken affinity is recorded on the result.
+func (a *MetaAgentActivities) FindOrCreateAgentForTaskActivity(ctx context.Context, task Task, sim *services.SimulationConfig, hints AgentSelectionHints) (*AgentInfo, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Finding or creating agent for task", 
 		zap.String("taskID", task.ID),
-		zap.String("taskType", task.Type))
+		zap.String("taskType", task.Type),
+		zap.String("preferredAgent", hints.PreferredAgent))
+
+	agentClient := agentExecutorFor(a.agentClient, sim)
+	if hints.PreferredAgent == "" {
+		return a.findOrCreateAgent(ctx, agentClient, task, hints)
+	}
+
+	preferred, reason := a.preferredAgent(ctx, agentClient, hints)
+	if preferred != nil {
+		logger.Info("Reusing agent of task group",
+			zap.String("agentID", preferred.ID),
+			zap.String("groupKey", hints.GroupKey))
+		info := newAgentInfo(preferred, services.SelectionAffinity,
+			fmt.Sprintf("agent ran an earlier task of group %s", hints.GroupKey))
+		info.Affinity = services.AffinityHonored
+		return info, nil
+	}
+
+	logger.Info("Task group affinity broken",
+		zap.String("preferredAgent", hints.PreferredAgent),
+		zap.String("groupKey", hints.GroupKey),
+		zap.String("reason", reason))
+	info, err := a.findOrCreateAgent(ctx, agentClient, task, hints)
+	if err != nil {
+		return nil, err
+	}
+	info.Affinity, info.AffinityWhy = services.AffinityBroken, reason
+	return info, nil
+}
+
+// preferredAgent returns the preferred agent of the hints when it can take
+// the task, else why it cannot
+func (a *MetaAgentActivities) preferredAgent(ctx context.Context, agentClient services.AgentExecutor, hints AgentSelectionHints) (*services.Agent, string) {
+	if slices.Contains(hints.Exclude, hints.PreferredAgent) {
+		return nil, fmt.Sprintf("agent %s stopped responding", hints.PreferredAgent)
+	}
+	agent, err := agentClient.GetAgent(ctx, hints.PreferredAgent)
+	if err != nil {
+		return nil, fmt.Sprintf("failed to get agent %s: %v", hints.PreferredAgent, err)
+	}
+	if ok, reason := a.selection.Reusable(*agent); !ok {
+		return nil, reason
+	}
+	return agent, ""
+}
+
+// findOrCreateAgent selects an agent for a task by capability, load and the
+// agent preferences of its project, leaving out the agents the hints
+// exclude, and creates one through the meta-agent when none fits
+func (a *MetaAgentActivities) findOrCreateAgent(ctx context.Context, agentClient services.AgentExecutor, task Task, hints AgentSelectionHints) (*AgentInfo, error) {
+	logger := activity.GetLogger(ctx)
+	projectID := hints.projectID(ctx)
+	exclude := hints.Exclude
+	prefs := projectAgentPreferences(ctx, a.preferences, projectID)
+
+	// Idle members of the warm pool serving the task type take the task
+	// without a spawn, unless the project excludes them. Simulated
+	// workflows use stub agents only. Pools are not kept per region, so
+	// workflows pinned to one skip them.
+	if agentClient == a.agentClient && hints.Region == "" {
+		poolExclude := exclude
+		if prefs != nil {
+			poolExclude = append(slices.Clone(exclude), prefs.ExcludedAgentIDs...)
+		}
+		if agent := a.pools.Claim(task.Type, poolExclude); agent != nil {
+			logger.Info("Using warm pool agent",
+				zap.String("agentID", agent.ID),
+				zap.String("agentType", agent.Type))
+			return newAgentInfo(agent, services.SelectionWarmPool,
+				fmt.Sprintf("idle member of the %s warm pool", agent.Type)), nil
+		}
+	}
 
 	// Step 1: Calculate required capabilities for the task
-	requiredCapabilities := a.getRequiredCapabilities(task)
+	requiredCapabilities := taskCapabilities(task)
 	logger.Info("Required capabilities determined", 
 		zap.Strings("capabilities", requiredCapabilities))
 
 	// Step 2: Search for existing suitable agents
-	agents, err := a.agentClient.ListAgents(ctx, &services.AgentFilters{
-		ProjectID: getProjectIDFromContext(ctx),
+	agents, err := agentClient.ListAgents(ctx, &services.AgentFilters{
+		ProjectID: projectID,
 		Status:    "available",
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list agents: %w", err)
 	}
-
-	// Step 3: Find best matching existing agent
-	bestAgent := a.findBestMatchingAgent(agents.Agents, requiredCapabilities, a.logger)
-	
-	// If we found a suitable agent (matching at least 60% of capabilities), use it
-	if bestAgent != nil {
+	agents.Agents = excludeAgents(agents.Agents, exclude)
+	// Workflows pinned to a region only run on agents in it, the meta-agent
+	// designing new ones included
+	if agents.Agents, err = agentsInRegion(agents.Agents, hints.Region); err != nil {
+		return nil, err
+	}
+	// Agents the project excludes are left out before scoring
+	candidates, excluded := prefs.Filter(agents.Agents)
+
+	// Step 3: Use the best matching agent (at least 60% of capabilities)
+	// with spare capacity, preferred agents boosted. When every matching
+	// agent is saturated the task spills over to a new agent, within the
+	// project's dynamic agent cap.
+	ranked, scores := a.rankMatchingAgents(candidates, requiredCapabilities, a.logger)
+	ranked = prefs.Rank(ranked, scores, 1)
+	selection := a.selection.SelectAgent(ctx, agentClient, projectID, ranked)
+	if !selection.Spawn() {
+		info := newAgentInfo(selection.Agent, selection.Decision, selection.Reason)
+		info.Preference, info.PreferenceWhy = prefs.Influence(selection.Agent, ranked, scores, excluded)
 		logger.Info("Found suitable existing agent", 
-			zap.String("agentID", bestAgent.ID),
-			zap.String("agentType", bestAgent.Type))
-
-		capNames := make([]string, len(bestAgent.Capabilities))
-		for i, cap := range bestAgent.Capabilities {
-			capNames[i] = cap.Name
-		}
-		
-		return &AgentInfo{
-			ID:           bestAgent.ID,
-			Type:         bestAgent.Type,
-			Capabilities: capNames,
-			Status:       bestAgent.Status,
-		}, nil
+			zap.String("agentID", selection.Agent.ID),
+			zap.String("agentType", selection.Agent.Type),
+			zap.String("selection", selection.Decision),
+			zap.String("reason", selection.Reason),
+			zap.String("preference", info.Preference))
+		return info, nil
 	}
 
-	// Step 4: No suitable agent found - use meta-agent to create one
-	logger.Info("No suitable agent found, using meta-agent for dynamic creation")
+	// Step 4: No suitable agent available - use meta-agent to create one
+	logger.Info("Using meta-agent for dynamic creation",
+		zap.String("selection", selection.Decision),
+		zap.String("reason", selection.Reason))
 
 	// Find the meta-prompt agent
 	metaAgent := a.findMetaPromptAgent(agents.Agents)
+
+	// fallback is used when no agent can be created: a saturated agent
+	// that matches the task, else the meta-agent itself
+	fallback := func(cause string) *AgentInfo {
+		if selection.Fallback != nil {
+			return newAgentInfo(selection.Fallback, services.SelectionQueuedSaturated, selection.Reason+"; "+cause)
+		}
+		return newAgentInfo(metaAgent, services.SelectionFellBackToMeta, cause)
+	}
+
 	if metaAgent == nil {
+		if selection.Fallback != nil {
+			return fallback("meta-prompt agent not available"), nil
+		}
 		return nil, fmt.Errorf("meta-prompt agent not available")
 	}
 
 	logger.Info("Found meta-prompt agent", zap.String("metaAgentID", metaAgent.ID))
 
-	// Step 5: Request meta-agent to design a new specialized agent
-	designTask := a.createAgentDesignTask(task, requiredCapabilities)
-	
-	designResp, err := a.agentClient.ExecuteTask(ctx, metaAgent.ID, &services.ExecuteTaskRequest{
-		Type: "design-agent",
-		Input: map[string]interface{}{
-			"taskDescription": designTask.Description,
-			"requirements": map[string]interface{}{
-				"capabilities":         requiredCapabilities,
-				"complexity":          task.Complexity,
-				"estimated_hours":     task.EstimatedHours,
-				"technical_requirements": task.TechnicalRequirements,
+	// Steps 5-9 design, spawn and wait for a new agent. Concurrent requests
+	// for the same capabilities share one spawn and a recently spawned agent
+	// is reused; failure is set when the meta-agent could not create one.
+	var failure string
+	var spawnedAgent *services.Agent
+	spawn := func(ctx context.Context) (string, error) {
+		// Step 5: Request meta-agent to design a new specialized agent
+		designTask := a.createAgentDesignTask(task, requiredCapabilities)
+
+		designResp, err := agentClient.ExecuteTask(ctx, metaAgent.ID, &services.ExecuteTaskRequest{
+			Type: "design-agent",
+			Input: map[string]interface{}{
+				"taskDescription": designTask.Description,
+				"requirements": map[string]interface{}{
+					"capabilities":         requiredCapabilities,
+					"complexity":          task.Complexity,
+					"estimated_hours":     task.EstimatedHours,
+					"technical_requirements": task.TechnicalRequirements,
+				},
+				"context": map[string]interface{}{
+					"project_id":   projectID,
+					"task_type":    task.Type,
+					"priority":     task.Priority,
+				},
 			},
-			"context": map[string]interface{}{
-				"project_id":   getProjectIDFromContext(ctx),
-				"task_type":    task.Type,
-				"priority":     task.Priority,
+			Config: map[string]interface{}{
+				"timeout_minutes": 5,
+				"response_format": "agent_specification",
 			},
-		},
-		Config: map[string]interface{}{
-			"timeout_minutes": 5,
-			"response_format": "agent_specification",
-		},
-		Priority:   "high",
-		Timeout:    300, // 5 minutes
-		MaxRetries: 2,
-	})
-	if err != nil {
-		// Fallback: Use meta-agent directly if design fails
-		logger.Warn("Agent design failed, using meta-agent directly", zap.Error(err))
-		metaCapNames := make([]string, len(metaAgent.Capabilities))
-		for i, cap := range metaAgent.Capabilities {
-			metaCapNames[i] = cap.Name
+			Priority:   "high",
+			Timeout:    300, // 5 minutes
+			MaxRetries: 2,
+		})
+		if err != nil {
+			failure = fmt.Sprintf("agent design failed: %v", err)
+			return "", err
 		}
-		
-		return &AgentInfo{
-			ID:           metaAgent.ID,
-			Type:         metaAgent.Type,
-			Capabilities: metaCapNames,
-			Status:       metaAgent.Status,
-		}, nil
-	}
 
-	// Step 6: Extract design ID from response
-	designID, ok := designResp.Output["designId"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid design response: missing designId")
-	}
+		// Step 6: Extract design ID from response
+		designID, ok := designResp.Output["designId"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid design response: missing designId")
+		}
 
-	logger.Info("Agent design completed", zap.String("designID", designID))
+		logger.Info("Agent design completed", zap.String("designID", designID))
 
-	// Step 7: Spawn the designed agent
-	spawnResp, err := a.agentClient.ExecuteTask(ctx, metaAgent.ID, &services.ExecuteTaskRequest{
-		Type: "spawn-agent",
-		Input: map[string]interface{}{
-			"designId": designID,
+		// Step 7: Spawn the designed agent. Spawned agents are registered as
+		// dynamic agents, which count toward the project's cap, in the region
+		// of the workflow when it is pinned to one.
+		spawnInput := map[string]interface{}{
+			"designId":  designID,
+			"agentType": services.DynamicAgentType,
 			"taskContext": map[string]interface{}{
 				"task_id":       task.ID,
-				"project_id":    getProjectIDFromContext(ctx),
+				"project_id":    projectID,
 				"priority":      task.Priority,
 				"estimated_duration": task.EstimatedHours * 3600, // Convert to seconds
 			},
-			"ttl": 3600000, // 1 hour TTL for dynamic agents
-		},
-		Config: map[string]interface{}{
-			"timeout_minutes": 5,
-			"auto_register":   true,
+			"ttl": dynamicAgentTTL.Milliseconds(),
+		}
+		if hints.Region != "" {
+			spawnInput["region"] = hints.Region
+		}
+		spawnResp, err := agentClient.ExecuteTask(ctx, metaAgent.ID, &services.ExecuteTaskRequest{
+			Type:  "spawn-agent",
+			Input: spawnInput,
+			Config: map[string]interface{}{
+				"timeout_minutes": 5,
+				"auto_register":   true,
+			},
+			Priority:   "high",
+			Timeout:    300,
+			MaxRetries: 2,
+		})
+		if err != nil {
+			failure = fmt.Sprintf("agent spawn failed: %v", err)
+			return "", err
+		}
+
+		// Step 8: Extract spawned agent info
+		spawnedAgentID, ok := spawnResp.Output["agentId"].(string)
+		if !ok {
+			return "", fmt.Errorf("invalid spawn response: missing agentId")
+		}
+
+		logger.Info("Dynamic agent spawned successfully", 
+			zap.String("spawnedAgentID", spawnedAgentID),
+			zap.String("designID", designID))
+
+		// Step 9: Wait for agent to be ready and get its info
+		spawnedAgent, err = a.waitForAgentReady(ctx, agentClient, spawnedAgentID, 30*time.Second)
+		if err != nil {
+			return "", fmt.Errorf("spawned agent not ready: %w", err)
+		}
+		return spawnedAgentID, nil
+	}
+	usable := func(ctx context.Context, agentID string) bool {
+		agent, err := agentClient.GetAgent(ctx, agentID)
+		if err != nil || (agent.Status != "available" && agent.Status != "active") {
+			return false
+		}
+		spawnedAgent = agent
+		return true
+	}
+
+	agentID, outcome, err := a.spawns.Spawn(ctx, services.SpawnRequest{
+		ProjectID:   projectID,
+		Fingerprint: services.CapabilityFingerprint(requiredCapabilities),
+		Spawn:       spawn,
+		Usable:      usable,
+		OnWait: func() {
+			activity.RecordHeartbeat(ctx, fmt.Sprintf("Waiting to spawn an agent for task %s", task.ID))
 		},
-		Priority:   "high",
-		Timeout:    300,
-		MaxRetries: 2,
 	})
 	if err != nil {
-		// Fallback: Use meta-agent directly if spawn fails
-		logger.Warn("Agent spawn failed, using meta-agent directly", zap.Error(err))
-		metaCapNames := make([]string, len(metaAgent.Capabilities))
-		for i, cap := range metaAgent.Capabilities {
-			metaCapNames[i] = cap.Name
+		if failure != "" || errors.Is(err, services.ErrSpawnWaitTimeout) {
+			if failure == "" {
+				failure = err.Error()
+			}
+			// Fallback: Use a saturated agent or the meta-agent directly
+			logger.Warn("Agent creation failed, using fallback agent", zap.String("reason", failure))
+			return fallback(failure), nil
+		}
+		return nil, err
+	}
+	// Simulated workflows spawn stub agents, which need no cleanup
+	if a.agentGC != nil && agentClient == a.agentClient {
+		workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+		if err := a.agentGC.TrackAgent(ctx, workflowID, agentID, outcome == services.SpawnCreated, dynamicAgentTTL); err != nil {
+			logger.Warn("Failed to track dynamic agent for cleanup",
+				zap.String("agentID", agentID),
+				zap.Error(err))
 		}
-		
-		return &AgentInfo{
-			ID:           metaAgent.ID,
-			Type:         metaAgent.Type,
-			Capabilities: metaCapNames,
-			Status:       metaAgent.Status,
-		}, nil
 	}
 
-	// Step 8: Extract spawned agent info
-	spawnedAgentID, ok := spawnResp.Output["agentId"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid spawn response: missing agentId")
+	reason := selection.Reason
+	if outcome != services.SpawnCreated {
+		logger.Info("Reusing dynamic agent spawned for the same capabilities",
+			zap.String("agentID", agentID),
+			zap.String("outcome", outcome))
+		reason += fmt.Sprintf("; %s agent spawned for the same capabilities", outcome)
 	}
-
-	logger.Info("Dynamic agent spawned successfully", 
-		zap.String("spawnedAgentID", spawnedAgentID),
-		zap.String("designID", designID))
-
-	// Step 9: Wait for agent to be ready and get its info
-	spawnedAgent, err := a.waitForAgentReady(ctx, spawnedAgentID, 30*time.Second)
-	if err != nil {
-		return nil, fmt.Errorf("spawned agent not ready: %w", err)
+	if spawnedAgent == nil {
+		if spawnedAgent, err = agentClient.GetAgent(ctx, agentID); err != nil {
+			return nil, fmt.Errorf("failed to get spawned agent: %w", err)
+		}
 	}
 
 	// Record heartbeat with success
-	activity.RecordHeartbeat(ctx, fmt.Sprintf("Dynamic agent %s ready for task %s", spawnedAgentID, task.ID))
+	activity.RecordHeartbeat(ctx, fmt.Sprintf("Dynamic agent %s ready for task %s", agentID, task.ID))
 
-	spawnedCapNames := make([]string, len(spawnedAgent.Capabilities))
-	for i, cap := range spawnedAgent.Capabilities {
-		spawnedCapNames[i] = cap.Name
-	}
-	
-	return &AgentInfo{
-		ID:           spawnedAgent.ID,
-		Type:         spawnedAgent.Type,
-		Capabilities: spawnedCapNames,
-		Status:       spawnedAgent.Status,
-	}, nil
+	return newAgentInfo(spawnedAgent, selection.Decision, reason), nil
 }
 
 // ExecuteTaskWithAgentActivity executes a task using the selected/created agent
-func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context, task Task, agent AgentInfo) (*TaskExecutionResult, error) {
+func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context, task Task, agent AgentInfo, sim *services.SimulationConfig) (*TaskExecutionResult, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("Executing task with agent", 
 		zap.String("taskID", task.ID),
@@ -213,7 +398,25 @@ func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context,
 
 	startTime := time.Now()
 
+	// Warm pool members running a task are never taken for idle
+	a.pools.Acquire(agent.ID)
+	defer a.pools.Release(agent.ID)
+
+	vars, config, err := resolveTaskConfig(ctx, a.variables, task)
+	if err != nil {
+		return &TaskExecutionResult{
+			TaskID:    task.ID,
+			AgentID:   agent.ID,
+			Status:    models.ExecutionStatusFailed,
+			Error:     err.Error(),
+			StartTime: startTime,
+			EndTime:   time.Now(),
+			Duration:  time.Since(startTime),
+		}, err
+	}
+
 	// Prepare comprehensive task execution request
+	estimate := taskEstimate(ctx, a.estimates, task)
 	execReq := &services.ExecuteTaskRequest{
 		Type: a.mapTaskTypeToAgentAction(task.Type),
 		Input: map[string]interface{}{
@@ -224,11 +427,12 @@ func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context,
 				"type":                  task.Type,
 				"priority":              task.Priority,
 				"complexity":            task.Complexity,
-				"estimated_hours":       task.EstimatedHours,
+				"estimated_hours":       estimate.Hours,
 				"acceptance_criteria":   task.AcceptanceCriteria,
 				"technical_requirements": task.TechnicalRequirements,
 				"dependencies":          task.Dependencies,
 				"tags":                  task.Tags,
+				"config":                config,
 			},
 			"context": map[string]interface{}{
 				"project_id":        getProjectIDFromContext(ctx),
@@ -244,7 +448,7 @@ func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context,
 			},
 		},
 		Config: map[string]interface{}{
-			"timeout_minutes":       int(task.EstimatedHours * 60),
+			"timeout_minutes":       int(estimate.TimeoutHours * 60),
 			"max_response_tokens":   4000,
 			"enable_streaming":      false,
 			"quality_threshold":     0.8,
@@ -253,25 +457,35 @@ func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context,
 			"target_framework":     getTargetFramework(task),
 			"environment":          getEnvironment(task),
 		},
+		TaskID:     agentTaskID(ctx, task),
 		Priority:   task.Priority,
-		Timeout:    int(task.EstimatedHours * 3600), // Convert hours to seconds
+		Timeout:    int(estimate.TimeoutHours * 3600), // Convert hours to seconds
 		MaxRetries: 2,
 	}
 
-	// Execute the task
+	// Execute the task, heartbeating while the agent reports progress
 	logger.Info("Sending task execution request to agent")
-	execResp, err := a.agentClient.ExecuteTask(ctx, agent.ID, execReq)
+	recordProgress(ctx, 10, "executing_task", fmt.Sprintf("Task %s sent to agent %s", task.ID, agent.ID))
+	execResp, err := executeWatched(ctx, agentExecutorFor(a.agentClient, sim), a.watchdog, a.logger, agent.ID, execReq)
 	if err != nil {
+		unresponsive := agentUnresponsive(err)
+		if !unresponsive {
+			err = vars.RedactError(err)
+		}
 		// Create failed result
 		result := &TaskExecutionResult{
 			TaskID:    task.ID,
 			AgentID:   agent.ID,
-			Status:    "failed",
+			Status:    models.ExecutionStatusFailed,
 			Error:     fmt.Sprintf("Task execution failed: %v", err),
 			StartTime: startTime,
 			EndTime:   time.Now(),
 			Duration:  time.Since(startTime),
 		}
+		if unresponsive {
+			// Returned as is so the workflow retries on another agent
+			return result, err
+		}
 		return result, fmt.Errorf("task execution failed: %w", err)
 	}
 
@@ -286,16 +500,16 @@ func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context,
 	result := &TaskExecutionResult{
 		TaskID:    task.ID,
 		AgentID:   agent.ID,
-		Status:    execResp.Status,
-		Output:    execResp.Output,
+		Status:    agentTaskStatus(ctx, execResp.Status),
+		Output:    vars.RedactMap(execResp.Output),
 		StartTime: startTime,
 		EndTime:   endTime,
 		Duration:  duration,
 	}
 
 	// Extract and process artifacts
-	if artifactsData, ok := execResp.Output["artifacts"]; ok {
-		artifacts := a.extractArtifacts(artifactsData, task.ID)
+	if artifactsData, ok := result.Output["artifacts"]; ok {
+		artifacts := extractArtifacts(artifactsData, task.ID)
 		result.Artifacts = artifacts
 		
 		logger.Info("Extracted artifacts from task execution", 
@@ -314,7 +528,7 @@ func (a *MetaAgentActivities) ExecuteTaskWithAgentActivity(ctx context.Context,
 	}
 
 	// Record success heartbeat
-	activity.RecordHeartbeat(ctx, fmt.Sprintf("Task %s completed by agent %s in %.2f seconds", 
+	recordProgress(ctx, 100, "task_completed", fmt.Sprintf("Task %s completed by agent %s in %.2f seconds",
 		task.ID, agent.ID, duration.Seconds()))
 
 	return result, nil
@@ -378,7 +592,8 @@ func (a *MetaAgentActivities) OptimizeAgentPerformanceActivity(ctx context.Conte
 
 // Helper functions
 
-func (a *MetaAgentActivities) getRequiredCapabilities(task Task) []string {
+// taskCapabilities returns the capabilities an agent needs to run a task
+func taskCapabilities(task Task) []string {
 	capabilities := []string{}
 
 	// Base capabilities from task type
@@ -426,13 +641,16 @@ func (a *MetaAgentActivities) getRequiredCapabilities(task Task) []string {
 	}
 
 	// Remove duplicates and return
-	return a.removeDuplicates(capabilities)
+	return removeDuplicates(capabilities)
 }
 
-func (a *MetaAgentActivities) findBestMatchingAgent(agents []services.Agent, requiredCapabilities []string, logger *zap.Logger) *services.Agent {
-	var bestAgent *services.Agent
-	bestScore := 0.0
+// rankMatchingAgents returns the agents matching at least 60% of the
+// required capabilities, best match first and less loaded first among
+// equals, with their scores by agent ID
+func (a *MetaAgentActivities) rankMatchingAgents(agents []services.Agent, requiredCapabilities []string, logger *zap.Logger) ([]services.Agent, map[string]float64) {
 	minThreshold := 0.6 // Agent must match at least 60% of capabilities
+	scores := make(map[string]float64, len(agents))
+	var matching []services.Agent
 
 	for _, agent := range agents {
 		score := a.calculateAgentMatchScore(agent, requiredCapabilities)
@@ -440,41 +658,59 @@ func (a *MetaAgentActivities) findBestMatchingAgent(agents []services.Agent, req
 			zap.String("agentID", agent.ID),
 			zap.String("agentType", agent.Type),
 			zap.Float64("score", score),
+			zap.Float64("load", agent.Load),
 			zap.Any("agentCapabilities", agent.Capabilities))
 
-		if score >= minThreshold && score > bestScore {
-			bestScore = score
-			bestAgent = &agent
+		if score >= minThreshold {
+			scores[agent.ID] = score
+			matching = append(matching, agent)
 		}
 	}
 
-	if bestAgent != nil {
-		logger.Info("Best matching agent found", 
-			zap.String("agentID", bestAgent.ID),
-			zap.Float64("match_score", bestScore))
-	}
-
-	return bestAgent
+	sort.SliceStable(matching, func(i, j int) bool {
+		if scores[matching[i].ID] != scores[matching[j].ID] {
+			return scores[matching[i].ID] > scores[matching[j].ID]
+		}
+		return matching[i].Load < matching[j].Load
+	})
+	return matching, scores
 }
 
 func (a *MetaAgentActivities) calculateAgentMatchScore(agent services.Agent, requiredCapabilities []string) float64 {
-	if len(requiredCapabilities) == 0 {
-		return 1.0
-	}
+	return services.CapabilityMatchScore(agent, requiredCapabilities)
+}
 
-	agentCaps := make(map[string]bool)
-	for _, cap := range agent.Capabilities {
-		agentCaps[strings.ToLower(cap.Name)] = true
+// projectAgentPreferences returns the agent preferences of a project, nil
+// when it has none or they cannot be read; selection then goes on without
+// them
+func projectAgentPreferences(ctx context.Context, prefs *services.AgentPreferenceService, projectID string) *services.EffectiveAgentPreferences {
+	if prefs == nil || projectID == "" {
+		return nil
 	}
-
-	matches := 0
-	for _, required := range requiredCapabilities {
-		if agentCaps[strings.ToLower(required)] {
-			matches++
+	effective, err := prefs.Preferences(ctx, projectID)
+	if err != nil {
+		if !errors.Is(err, services.ErrProjectNotFound) {
+			activity.GetLogger(ctx).Warn("Failed to get agent preferences",
+				zap.String("projectID", projectID),
+				zap.Error(err))
 		}
+		return nil
 	}
+	return effective
+}
 
-	return float64(matches) / float64(len(requiredCapabilities))
+// excludeAgents drops the agents with the given IDs
+func excludeAgents(agents []services.Agent, exclude []string) []services.Agent {
+	if len(exclude) == 0 {
+		return agents
+	}
+	var kept []services.Agent
+	for _, agent := range agents {
+		if !slices.Contains(exclude, agent.ID) {
+			kept = append(kept, agent)
+		}
+	}
+	return kept
 }
 
 func (a *MetaAgentActivities) findMetaPromptAgent(agents []services.Agent) *services.Agent {
@@ -523,20 +759,37 @@ Please design an agent specification that can effectively handle this type of wo
 	}
 }
 
-func (a *MetaAgentActivities) waitForAgentReady(ctx context.Context, agentID string, timeout time.Duration) (*services.Agent, error) {
+func (a *MetaAgentActivities) waitForAgentReady(ctx context.Context, agentClient services.AgentExecutor, agentID string, timeout time.Duration) (*services.Agent, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	ready := func() *services.Agent {
+		agent, err := agentClient.GetAgent(ctx, agentID)
+		if err == nil && (agent.Status == "available" || agent.Status == "active") {
+			return agent
+		}
+		return nil
+	}
+
+	// Agents that are ready immediately don't wait for the first tick
+	if agent := ready(); agent != nil {
+		return agent, nil
+	}
+
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
+	started := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
 			return nil, fmt.Errorf("timeout waiting for agent %s to be ready", agentID)
 		case <-ticker.C:
-			agent, err := a.agentClient.GetAgent(ctx, agentID)
-			if err == nil && (agent.Status == "available" || agent.Status == "active") {
+			// Heartbeat each poll so a slow spawn is not mistaken for a hang
+			waited := time.Since(started)
+			recordProgress(ctx, int(100*waited/timeout), "waiting_for_agent",
+				fmt.Sprintf("Waiting for agent %s to be ready (%s elapsed)", agentID, waited.Round(time.Second)))
+			if agent := ready(); agent != nil {
 				return agent, nil
 			}
 		}
@@ -566,7 +819,8 @@ func (a *MetaAgentActivities) mapTaskTypeToAgentAction(taskType string) string {
 	}
 }
 
-func (a *MetaAgentActivities) extractArtifacts(artifactsData interface{}, taskID string) []Artifact {
+// extractArtifacts reads the artifacts of an agent response
+func extractArtifacts(artifactsData interface{}, taskID string) []Artifact {
 	artifacts := []Artifact{}
 
 	switch data := artifactsData.(type) {
@@ -624,9 +878,15 @@ func (a *MetaAgentActivities) calculatePerformanceMetrics(results []TaskExecutio
 	for _, result := range results {
 		totalDuration += result.Duration
 		
-		if result.Status == "completed" || result.Status == "succeeded" {
+		if result.Status.IsSuccess() {
 			successCount++
-			totalScore += 1.0 // Could be more sophisticated scoring
+			// Results are scored when aggregated; those that were not are
+			// scored heuristically here
+			if result.QualityScore != nil {
+				totalScore += *result.QualityScore
+			} else {
+				totalScore += scoreHeuristically(result).Score
+			}
 		}
 	}
 
@@ -639,7 +899,7 @@ func (a *MetaAgentActivities) calculatePerformanceMetrics(results []TaskExecutio
 	}
 }
 
-func (a *MetaAgentActivities) removeDuplicates(slice []string) []string {
+func removeDuplicates(slice []string) []string {
 	keys := make(map[string]bool)
 	var result []string
 	
@@ -0,0 +1,37 @@
+package temporal
+
+import (
+	"orchestrator/internal/models"
+)
+
+// offloadedOutputMap returns an offloaded output as the output map of a step
+// result
+func offloadedOutputMap(offloaded *models.OffloadedOutput) map[string]interface{} {
+	return map[string]interface{}{
+		"truncated":   true,
+		"output_ref":  offloaded.OutputRef,
+		"artifact_id": offloaded.ArtifactID,
+		"size":        offloaded.Size,
+		"checksum":    offloaded.Checksum,
+		"preview":     offloaded.Preview,
+	}
+}
+
+// isOffloadedOutput reports whether a step result's output is the offloaded
+// form of an output
+func isOffloadedOutput(output map[string]interface{}) bool {
+	truncated, _ := output["truncated"].(bool)
+	ref, _ := output["output_ref"].(string)
+	return truncated && ref != ""
+}
+
+// sameOutput reports whether two matrix runs printed the same output.
+// Offloaded outputs are compared by checksum, as their previews may match
+// while the outputs differ.
+func sameOutput(a, b MatrixRunResult) bool {
+	if a.OffloadedOutput != nil || b.OffloadedOutput != nil {
+		return a.OffloadedOutput != nil && b.OffloadedOutput != nil &&
+			a.OffloadedOutput.Checksum == b.OffloadedOutput.Checksum
+	}
+	return a.Output == b.Output
+}
@@ -0,0 +1,157 @@
+package temporal
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/encryption"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// setupOutputsTest creates activities offloading outputs over 64 bytes
+func setupOutputsTest(t *testing.T, db *gorm.DB) (*Activities, *services.OutputPolicy) {
+	require.NoError(t, db.Exec(`CREATE TABLE artifacts (id TEXT PRIMARY KEY, execution_id TEXT, name TEXT, type TEXT,
+		path TEXT, url TEXT, size INTEGER, checksum TEXT, content_type TEXT, metadata TEXT, expires_at DATETIME,
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME,
+		workflow_id TEXT, logical_key TEXT, version INTEGER DEFAULT 1, parent_artifact_id TEXT, content_head BLOB)`).Error)
+	store, err := services.NewFileOutputStore(t.TempDir())
+	require.NoError(t, err)
+	outputs := services.NewOutputPolicy(db, store, &config.OutputsConfig{MaxInlineBytes: 64, PreviewBytes: 8}, zap.NewNop())
+	activities := NewActivities(db, zap.NewNop(), nil, nil)
+	activities.outputs = outputs
+	return activities, outputs
+}
+
+func TestRecordMatrixRunActivity_OffloadsLargeOutputs(t *testing.T) {
+	ctx := context.Background()
+	db := setupIterativeTest(t)
+	activities, outputs := setupOutputsTest(t, db)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	full := "sum=6\n" + strings.Repeat("progress\n", 20) + "ok\n"
+	preview, offloaded, err := outputs.LimitText(ctx, full)
+	require.NoError(t, err)
+	require.NotNil(t, offloaded)
+
+	// Results of the code execution workflow reference the full output
+	value, err := env.ExecuteActivity(activities.ProcessResultsActivity, ExecutionResult{Output: preview, OffloadedOutput: offloaded})
+	require.NoError(t, err)
+	var processed ProcessedResult
+	require.NoError(t, value.Get(&processed))
+	assert.Equal(t, preview, processed.Data["output"])
+	assert.Equal(t, true, processed.Data["output_truncated"])
+	assert.Equal(t, offloaded.OutputRef, processed.Data["output_ref"])
+
+	exitCode := 0
+	value, err = env.ExecuteActivity(activities.RecordMatrixRunActivity, MatrixRunResult{
+		Key: "python@3.12", Combination: MatrixCombination{Language: "python", Runtime: "3.12"},
+		Success: true, ExitCode: &exitCode, Output: preview, OffloadedOutput: offloaded,
+	}, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+	var executionID string
+	require.NoError(t, value.Get(&executionID))
+
+	var execution models.Execution
+	require.NoError(t, db.Select("id", "output").First(&execution, "id = ?", executionID).Error)
+	stored, ok := models.ParseOffloadedOutput(execution.Output)
+	require.True(t, ok)
+	assert.Equal(t, preview, stored.Preview)
+	assert.NotEmpty(t, stored.ArtifactID)
+	rehydrated, err := outputs.FullOutput(ctx, execution.Output)
+	require.NoError(t, err)
+	assert.Equal(t, full, string(rehydrated))
+
+	var artifact models.Artifact
+	require.NoError(t, db.First(&artifact, "id = ?", stored.ArtifactID).Error)
+	assert.Equal(t, executionID, artifact.ExecutionID)
+	assert.Equal(t, models.OutputArtifactType, artifact.Type)
+
+	// Outputs with the same preview differ when their checksums do
+	_, other, err := outputs.LimitText(ctx, "sum=6\n"+strings.Repeat("progress\n", 21)+"ok\n")
+	require.NoError(t, err)
+	require.Equal(t, offloaded.Preview[:8], other.Preview[:8])
+	summary := summarizeCodeMatrix([]MatrixRunResult{
+		{Key: "python@3.11", Success: true, Output: preview, OffloadedOutput: offloaded},
+		{Key: "python@3.12", Success: true, Output: preview, OffloadedOutput: offloaded},
+		{Key: "python@3.13", Success: true, Output: preview, OffloadedOutput: other},
+	})
+	assert.True(t, summary.Combinations[1].OutputMatches)
+	assert.False(t, summary.Combinations[2].OutputMatches)
+	assert.False(t, summary.Consistent)
+}
+
+// encryptedProjects enables encryption at rest for the projects listed
+type encryptedProjects map[string]bool
+
+func (p encryptedProjects) EncryptionEnabled(ctx context.Context, db *gorm.DB, projectID string) (bool, error) {
+	return p[projectID], nil
+}
+
+func TestRecordMatrixRunActivity_EncryptsOffloadedOutputs(t *testing.T) {
+	ctx := context.Background()
+	db := setupIterativeTest(t)
+	keys, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+	require.NoError(t, encryption.Register(db, encryption.NewEncryptor(keys), encryptedProjects{"project-1": true}))
+	activities, outputs := setupOutputsTest(t, db)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	preview, offloaded, err := outputs.LimitText(ctx, "sum=6\n"+strings.Repeat("progress\n", 20)+"ok\n")
+	require.NoError(t, err)
+	require.NotNil(t, offloaded)
+	value, err := env.ExecuteActivity(activities.RecordMatrixRunActivity, MatrixRunResult{
+		Key: "python@3.12", Combination: MatrixCombination{Language: "python", Runtime: "3.12"},
+		Success: true, Output: preview, OffloadedOutput: offloaded,
+	}, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+	var executionID string
+	require.NoError(t, value.Get(&executionID))
+
+	// The output referencing the artifact is stored as ciphertext
+	var raw string
+	require.NoError(t, db.Raw(`SELECT output FROM executions WHERE id = ?`, executionID).Row().Scan(&raw))
+	assert.True(t, encryption.IsEncrypted([]byte(raw)))
+	assert.NotContains(t, raw, "output_ref")
+
+	var execution models.Execution
+	require.NoError(t, db.Select("id", "project_id", "output").First(&execution, "id = ?", executionID).Error)
+	stored, ok := models.ParseOffloadedOutput(execution.Output)
+	require.True(t, ok)
+	assert.NotEmpty(t, stored.ArtifactID)
+}
+
+func TestAggregateResultsActivity_KeepsOffloadedOutputs(t *testing.T) {
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	offloaded := offloadedOutputMap(&models.OffloadedOutput{Truncated: true, OutputRef: "abc123", Size: 1 << 20, Preview: "head…tail"})
+	value, err := env.ExecuteActivity(activities.AggregateResultsActivity, []StepResult{
+		{StepID: "step-1", Status: models.ExecutionStatusSucceeded, Output: map[string]interface{}{"result": "ok"}},
+		{StepID: "step-2", Status: models.ExecutionStatusSucceeded, Output: offloaded},
+	})
+	require.NoError(t, err)
+	var result WorkflowResult
+	require.NoError(t, value.Get(&result))
+	assert.Equal(t, "completed", result.Status)
+	assert.Equal(t, []string{"step-2"}, result.OffloadedOutputs)
+	assert.Equal(t, map[string]interface{}{"result": "ok"}, result.Results["step-1"])
+	assert.Equal(t, "abc123", result.Results["step-2"].(map[string]interface{})["output_ref"])
+}
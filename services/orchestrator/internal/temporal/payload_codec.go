@@ -0,0 +1,334 @@
+package temporal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+
+	"orchestrator/internal/config"
+)
+
+// Encodings of the payloads produced by the payload codecs
+const (
+	payloadEncodingGzip       = "binary/gzip"
+	payloadEncodingClaimCheck = "claim-check/sha256"
+)
+
+// Payload codec operations, recorded as the operation label
+const (
+	payloadOpCompressed = "compressed"
+	payloadOpOffloaded  = "offloaded"
+)
+
+// payloadStoreTimeout bounds one payload store read or write
+const payloadStoreTimeout = 30 * time.Second
+
+var (
+	payloadCodecTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orchestrator_temporal_payloads_encoded_total",
+		Help: "Temporal payloads compressed or offloaded to the payload store",
+	}, []string{"operation"})
+	payloadCodecBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "orchestrator_temporal_payload_size_bytes",
+		Help:    "Size of compressed or offloaded Temporal payloads before and after encoding",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 9), // 1KiB to 64MiB
+	}, []string{"operation", "size"})
+)
+
+// ErrPayloadNotFound is returned by payload stores for unknown keys
+var ErrPayloadNotFound = errors.New("payload not found")
+
+// PayloadStore keeps the payloads offloaded by the claim-check codec. Every
+// worker and client of a namespace must reach the same store, and payloads
+// must be kept as long as the workflow histories referencing them.
+type PayloadStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// NewPayloadDataConverter returns the data converter of the Temporal client:
+// JSON payloads, gzip-compressed above the compression threshold and, when
+// still above the offload threshold, moved to the payload store and passed by
+// reference. Both encodings are derived from the payload content alone, so
+// encoding is deterministic and replays decode the same values. Payloads in
+// either encoding are always decoded, also once a threshold is disabled.
+func NewPayloadDataConverter(cfg config.PayloadCodecConfig) (converter.DataConverter, error) {
+	var store PayloadStore
+	if cfg.StoreDir != "" {
+		fileStore, err := NewFilePayloadStore(cfg.StoreDir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	if cfg.OffloadThreshold > 0 && store == nil {
+		return nil, fmt.Errorf("payload offloading needs a store directory")
+	}
+	return newPayloadDataConverter(cfg.CompressionThreshold, cfg.OffloadThreshold, store), nil
+}
+
+func newPayloadDataConverter(compressionThreshold, offloadThreshold int, store PayloadStore) converter.DataConverter {
+	// Codecs encode last to first: compression runs before offloading
+	return converter.NewCodecDataConverter(
+		converter.GetDefaultDataConverter(),
+		&claimCheckCodec{threshold: offloadThreshold, store: store},
+		&compressionCodec{threshold: compressionThreshold},
+	)
+}
+
+// marshalPayload encodes a payload with its metadata in key order. Proto map
+// fields otherwise marshal in random order, and equal payloads must compress
+// and hash the same.
+func marshalPayload(payload *commonpb.Payload) ([]byte, error) {
+	keys := make([]string, 0, len(payload.Metadata))
+	for key := range payload.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Concatenated messages decode as one message with all the fields
+	var buf bytes.Buffer
+	buf.Grow(payload.Size())
+	for _, key := range keys {
+		entry, err := (&commonpb.Payload{Metadata: map[string][]byte{key: payload.Metadata[key]}}).Marshal()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(entry)
+	}
+	data, err := (&commonpb.Payload{Data: payload.Data}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
+
+func unmarshalPayload(data []byte) (*commonpb.Payload, error) {
+	payload := &commonpb.Payload{}
+	if err := payload.Unmarshal(data); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return payload, nil
+}
+
+func payloadEncoding(payload *commonpb.Payload) string {
+	return string(payload.GetMetadata()[converter.MetadataEncoding])
+}
+
+// compressionCodec gzips payloads above a size threshold
+type compressionCodec struct {
+	threshold int // 0 disables compression
+}
+
+func (c *compressionCodec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, payload := range payloads {
+		result[i] = payload
+		size := payload.Size()
+		if c.threshold <= 0 || size <= c.threshold {
+			continue
+		}
+
+		data, err := marshalPayload(payload)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress payload: %w", err)
+		}
+
+		compressed := &commonpb.Payload{
+			Metadata: map[string][]byte{converter.MetadataEncoding: []byte(payloadEncodingGzip)},
+			Data:     buf.Bytes(),
+		}
+		if compressed.Size() >= size {
+			continue // Not worth it
+		}
+		result[i] = compressed
+		observePayload(payloadOpCompressed, size, compressed.Size())
+	}
+	return result, nil
+}
+
+func (c *compressionCodec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, payload := range payloads {
+		result[i] = payload
+		if payloadEncoding(payload) != payloadEncodingGzip {
+			continue
+		}
+
+		reader, err := gzip.NewReader(bytes.NewReader(payload.Data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress payload: %w", err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress payload: %w", err)
+		}
+		if result[i], err = unmarshalPayload(data); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// claimCheckCodec moves payloads above a size threshold to a payload store,
+// keyed by the hash of their content, and passes the key instead
+type claimCheckCodec struct {
+	threshold int // 0 disables offloading
+	store     PayloadStore
+}
+
+func (c *claimCheckCodec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, payload := range payloads {
+		result[i] = payload
+		size := payload.Size()
+		if c.threshold <= 0 || size <= c.threshold {
+			continue
+		}
+
+		data, err := marshalPayload(payload)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		key := hex.EncodeToString(sum[:])
+
+		ctx, cancel := context.WithTimeout(context.Background(), payloadStoreTimeout)
+		err = c.store.Put(ctx, key, data)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to offload payload: %w", err)
+		}
+
+		result[i] = &commonpb.Payload{
+			Metadata: map[string][]byte{
+				converter.MetadataEncoding: []byte(payloadEncodingClaimCheck),
+				"size":                     []byte(strconv.Itoa(len(data))),
+			},
+			Data: []byte(key),
+		}
+		observePayload(payloadOpOffloaded, size, result[i].Size())
+	}
+	return result, nil
+}
+
+func (c *claimCheckCodec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, payload := range payloads {
+		result[i] = payload
+		if payloadEncoding(payload) != payloadEncodingClaimCheck {
+			continue
+		}
+		if c.store == nil {
+			return nil, fmt.Errorf("payload %s was offloaded but no payload store is configured", payload.Data)
+		}
+
+		key := string(payload.Data)
+		ctx, cancel := context.WithTimeout(context.Background(), payloadStoreTimeout)
+		data, err := c.store.Get(ctx, key)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load offloaded payload %s: %w", key, err)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != key {
+			return nil, fmt.Errorf("offloaded payload %s is corrupt", key)
+		}
+		if result[i], err = unmarshalPayload(data); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func observePayload(operation string, original, encoded int) {
+	payloadCodecTotal.WithLabelValues(operation).Inc()
+	payloadCodecBytes.WithLabelValues(operation, "original").Observe(float64(original))
+	payloadCodecBytes.WithLabelValues(operation, "encoded").Observe(float64(encoded))
+}
+
+// filePayloadStore keeps offloaded payloads as files in a directory, e.g. a
+// volume shared by all workers
+type filePayloadStore struct {
+	dir string
+}
+
+// NewFilePayloadStore stores offloaded payloads in dir, creating it if needed
+func NewFilePayloadStore(dir string) (PayloadStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create payload store directory: %w", err)
+	}
+	return &filePayloadStore{dir: dir}, nil
+}
+
+// path spreads payloads over subdirectories by key prefix
+func (s *filePayloadStore) path(key string) (string, error) {
+	if len(key) < 3 || filepath.Base(key) != key {
+		return "", fmt.Errorf("invalid payload key %q", key)
+	}
+	return filepath.Join(s.dir, key[:2], key), nil
+}
+
+func (s *filePayloadStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	// Keys are content hashes: an existing file already holds the payload
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	// Write then rename, so readers never see a partial payload
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *filePayloadStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrPayloadNotFound, key)
+	}
+	return data, err
+}
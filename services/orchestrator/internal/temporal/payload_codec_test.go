@@ -0,0 +1,291 @@
+package temporal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/config"
+)
+
+const (
+	testCompressionThreshold = 1024
+	testOffloadThreshold     = 16 * 1024
+)
+
+// memoryPayloadStore keeps offloaded payloads in memory
+type memoryPayloadStore struct {
+	mu       sync.Mutex
+	payloads map[string][]byte
+}
+
+func newMemoryPayloadStore() *memoryPayloadStore {
+	return &memoryPayloadStore{payloads: make(map[string][]byte)}
+}
+
+func (s *memoryPayloadStore) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloads[key] = data
+	return nil
+}
+
+func (s *memoryPayloadStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.payloads[key]
+	if !ok {
+		return nil, ErrPayloadNotFound
+	}
+	return data, nil
+}
+
+func (s *memoryPayloadStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.payloads)
+}
+
+// randomText returns text of the given length that barely compresses
+func randomText(seed int64, length int) string {
+	data := make([]byte, length)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return base64.StdEncoding.EncodeToString(data)[:length]
+}
+
+func TestPayloadDataConverter_RoundTrip(t *testing.T) {
+	store := newMemoryPayloadStore()
+	dc := newPayloadDataConverter(testCompressionThreshold, testOffloadThreshold, store)
+
+	repeated := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		repeated[fmt.Sprintf("src/handler_%d.go", i)] = strings.Repeat("func handle() error { return nil }\n", 20)
+	}
+	random := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		random[fmt.Sprintf("assets/blob_%d.bin", i)] = randomText(int64(i), 8*1024)
+	}
+
+	tests := []struct {
+		name         string
+		value        interface{}
+		wantEncoding string
+	}{
+		{
+			name:         "small code changes stay plain",
+			value:        CodeChanges{Files: []string{"main.go"}, Additions: 3, Deletions: 1, Diff: "+fmt.Println()"},
+			wantEncoding: converter.MetadataEncodingJSON,
+		},
+		{
+			name:         "repetitive generated code is compressed",
+			value:        CodeData{Files: []string{"src"}, Content: repeated, Metadata: map[string]interface{}{"language": "go"}},
+			wantEncoding: payloadEncodingGzip,
+		},
+		{
+			name: "large artifacts are offloaded",
+			value: []Artifact{
+				{ID: "artifact-1", Name: "bundle", Type: "binary", Content: randomText(10, 24*1024), Size: 24 * 1024},
+				{ID: "artifact-2", Name: "report", Type: "text", Content: "done", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			},
+			wantEncoding: payloadEncodingClaimCheck,
+		},
+		{
+			name:         "compressed data still above the offload threshold is offloaded",
+			value:        CodeData{Content: random},
+			wantEncoding: payloadEncodingClaimCheck,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads, err := dc.ToPayloads(tt.value)
+			require.NoError(t, err)
+			require.Len(t, payloads.Payloads, 1)
+			assert.Equal(t, tt.wantEncoding, payloadEncoding(payloads.Payloads[0]))
+
+			decoded := newValueOfType(tt.value)
+			require.NoError(t, dc.FromPayloads(payloads, decoded))
+			assert.Equal(t, tt.value, derefValue(decoded))
+		})
+	}
+}
+
+func newValueOfType(value interface{}) interface{} {
+	switch value.(type) {
+	case CodeChanges:
+		return &CodeChanges{}
+	case CodeData:
+		return &CodeData{}
+	case []Artifact:
+		return &[]Artifact{}
+	}
+	panic(fmt.Sprintf("unexpected type %T", value))
+}
+
+func derefValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *CodeChanges:
+		return *v
+	case *CodeData:
+		return *v
+	case *[]Artifact:
+		return *v
+	}
+	panic(fmt.Sprintf("unexpected type %T", value))
+}
+
+func TestClaimCheckCodec_OffloadsAboveThreshold(t *testing.T) {
+	store := newMemoryPayloadStore()
+	codec := &claimCheckCodec{threshold: 100, store: store}
+
+	payloadOfSize := func(size int) *commonpb.Payload {
+		payload := &commonpb.Payload{Metadata: map[string][]byte{converter.MetadataEncoding: []byte("binary/plain")}}
+		payload.Data = make([]byte, size-payload.Size()-2) // Tag and length of the data field
+		require.Equal(t, size, payload.Size())
+		return payload
+	}
+
+	encoded, err := codec.Encode([]*commonpb.Payload{payloadOfSize(100), payloadOfSize(101)})
+	require.NoError(t, err)
+	assert.Equal(t, "binary/plain", payloadEncoding(encoded[0]))
+	assert.Equal(t, payloadEncodingClaimCheck, payloadEncoding(encoded[1]))
+	assert.Equal(t, 1, store.len())
+
+	decoded, err := codec.Decode(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, payloadOfSize(101).Data, decoded[1].Data)
+
+	// Offloaded payloads cannot be read without their store
+	_, err = (&claimCheckCodec{}).Decode(encoded)
+	assert.ErrorContains(t, err, "no payload store is configured")
+
+	// Nor when the stored payload does not match its key
+	for key := range store.payloads {
+		store.payloads[key] = []byte("tampered")
+	}
+	_, err = codec.Decode(encoded)
+	assert.ErrorContains(t, err, "is corrupt")
+}
+
+func TestPayloadDataConverter_IsDeterministic(t *testing.T) {
+	// Metadata entries are written in key order, so a payload encodes the
+	// same every time, as a replayed workflow needs
+	payload := func() []*commonpb.Payload {
+		return []*commonpb.Payload{{
+			Metadata: map[string][]byte{"encoding": []byte("json/plain"), "a": []byte("1"), "b": []byte("2"), "c": []byte("3")},
+			Data:     []byte(randomText(3, 2048)),
+		}}
+	}
+	store := newMemoryPayloadStore()
+	compression := &compressionCodec{threshold: 10}
+	claimCheck := &claimCheckCodec{threshold: 10, store: store}
+
+	compressed, err := compression.Encode(payload())
+	require.NoError(t, err)
+	offloaded, err := claimCheck.Encode(payload())
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		again, err := compression.Encode(payload())
+		require.NoError(t, err)
+		assert.Equal(t, compressed[0].Data, again[0].Data)
+
+		again, err = claimCheck.Encode(payload())
+		require.NoError(t, err)
+		assert.Equal(t, offloaded[0].Data, again[0].Data)
+	}
+	assert.Equal(t, 1, store.len())
+
+	decoded, err := claimCheck.Decode(offloaded)
+	require.NoError(t, err)
+	assert.Equal(t, payload()[0].Metadata, decoded[0].Metadata)
+}
+
+func TestFilePayloadStore(t *testing.T) {
+	dir := t.TempDir()
+	dc, err := NewPayloadDataConverter(config.PayloadCodecConfig{
+		CompressionThreshold: testCompressionThreshold,
+		OffloadThreshold:     testOffloadThreshold,
+		StoreDir:             dir,
+	})
+	require.NoError(t, err)
+
+	value := CodeData{Content: map[string]string{"blob": randomText(2, 64*1024)}}
+	payloads, err := dc.ToPayloads(value)
+	require.NoError(t, err)
+	require.Equal(t, payloadEncodingClaimCheck, payloadEncoding(payloads.Payloads[0]))
+
+	// Another converter on the same directory, as on another worker, reads it
+	other, err := NewPayloadDataConverter(config.PayloadCodecConfig{StoreDir: dir})
+	require.NoError(t, err)
+	var decoded CodeData
+	require.NoError(t, other.FromPayloads(payloads, &decoded))
+	assert.Equal(t, value, decoded)
+
+	store, err := NewFilePayloadStore(t.TempDir())
+	require.NoError(t, err)
+	_, err = store.Get(context.Background(), string(payloads.Payloads[0].Data))
+	assert.ErrorIs(t, err, ErrPayloadNotFound)
+	assert.Error(t, store.Put(context.Background(), "../escape", []byte("data")))
+
+	_, err = NewPayloadDataConverter(config.PayloadCodecConfig{OffloadThreshold: 1})
+	assert.Error(t, err)
+}
+
+// payloadTestActivities receives a large input and returns a summary of it
+type payloadTestActivities struct{}
+
+func (a *payloadTestActivities) SummarizeArtifactsActivity(ctx context.Context, artifacts []Artifact) (CodeChanges, error) {
+	var changes CodeChanges
+	for _, artifact := range artifacts {
+		changes.Files = append(changes.Files, artifact.Path)
+		changes.Additions += len(artifact.Content)
+	}
+	return changes, nil
+}
+
+func payloadTestWorkflow(ctx workflow.Context, artifacts []Artifact) (CodeChanges, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{StartToCloseTimeout: time.Minute})
+	var changes CodeChanges
+	err := workflow.ExecuteActivity(ctx, "SummarizeArtifactsActivity", artifacts).Get(ctx, &changes)
+	return changes, err
+}
+
+func TestPayloadDataConverter_WorkflowWithOversizedInput(t *testing.T) {
+	store := newMemoryPayloadStore()
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetDataConverter(newPayloadDataConverter(testCompressionThreshold, testOffloadThreshold, store))
+	env.RegisterWorkflow(payloadTestWorkflow)
+	env.RegisterActivity(&payloadTestActivities{})
+
+	// Far above the offload threshold, as a large generated project would be
+	var artifacts []Artifact
+	for i := 0; i < 8; i++ {
+		artifacts = append(artifacts, Artifact{
+			ID:      fmt.Sprintf("artifact-%d", i),
+			Path:    fmt.Sprintf("dist/chunk_%d.js", i),
+			Content: randomText(int64(i), 32*1024),
+		})
+	}
+	env.ExecuteWorkflow(payloadTestWorkflow, artifacts)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	var changes CodeChanges
+	require.NoError(t, env.GetWorkflowResult(&changes))
+	assert.Len(t, changes.Files, 8)
+	assert.Equal(t, 8*32*1024, changes.Additions)
+	// The workflow and activity inputs are the same payload, stored once
+	assert.Equal(t, 1, store.len())
+}
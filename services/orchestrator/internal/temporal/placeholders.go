@@ -0,0 +1,372 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+const (
+	// unresolvedPlaceholderError is the type of the non-retryable error
+	// failing workflows with a placeholder that has no value
+	unresolvedPlaceholderError = "UnresolvedPlaceholder"
+	// placeholderTTL is how long a worker keeps the resolved placeholders of
+	// a workflow after their last use
+	placeholderTTL = time.Hour
+)
+
+// placeholderResolutionChange versions resolving placeholders as the first
+// step of workflows. Runs started before it resolve them in the activities
+// that send them to agents, as they did then.
+const placeholderResolutionChange = "placeholder-resolution"
+
+// PlaceholderResolution names the placeholders resolved for a workflow. The
+// values stay in the worker's memory and never enter workflow history.
+type PlaceholderResolution struct {
+	Placeholders []string `json:"placeholders"`
+	Environment  string   `json:"environment,omitempty"`
+}
+
+// resolvePlaceholders runs ResolvePlaceholdersActivity as the first step of
+// workflows whose input or config holds {{secret:NAME}} or {{var:NAME}}
+// placeholders, failing them when one cannot be resolved. Workflows call it
+// before doing any work, so a missing value fails them before any side effect.
+func resolvePlaceholders(ctx workflow.Context, wf *models.Workflow) error {
+	if workflow.GetVersion(ctx, placeholderResolutionChange, workflow.DefaultVersion, 1) < 1 {
+		return nil
+	}
+	if len(services.Placeholders(wf.Input, wf.Config)) == 0 {
+		return nil
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    time.Second,
+			BackoffCoefficient: 2.0,
+			MaximumInterval:    30 * time.Second,
+			MaximumAttempts:    3,
+		},
+	})
+	var resolution PlaceholderResolution
+	if err := workflow.ExecuteActivity(ctx, ActivityResolvePlaceholders).Get(ctx, &resolution); err != nil {
+		return fmt.Errorf("failed to resolve placeholders: %w", err)
+	}
+	workflow.GetLogger(ctx).Info("Resolved placeholders",
+		"placeholders", resolution.Placeholders,
+		"environment", resolution.Environment)
+	return nil
+}
+
+// ResolvePlaceholdersActivity resolves the placeholders of the running
+// workflow's input and config: {{secret:NAME}} from the project's secrets
+// and {{var:NAME}} from its variables and the environment selected by
+// config.environment. The values are kept in the worker's memory and
+// substituted into the requests later activities send to agents; the stored
+// workflow keeps its placeholders. A placeholder without a value fails the
+// workflow.
+func (a *Activities) ResolvePlaceholdersActivity(ctx context.Context) (*PlaceholderResolution, error) {
+	if a.placeholders == nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			"placeholders cannot be resolved: variables are not configured", unresolvedPlaceholderError, nil)
+	}
+
+	run, err := a.placeholders.resolve(ctx, activity.GetInfo(ctx).WorkflowExecution.ID)
+	if errors.Is(err, services.ErrUnresolvedPlaceholder) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), unresolvedPlaceholderError, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PlaceholderResolution{Placeholders: run.placeholders, Environment: run.environment}, nil
+}
+
+// placeholderResolver keeps the resolved placeholders of the workflows a
+// worker runs. Activities of a workflow that reach another worker, or run
+// after a restart, resolve them again.
+type placeholderResolver struct {
+	db        *gorm.DB
+	variables *services.VariableService
+	now       func() time.Time
+
+	mu   sync.Mutex
+	runs map[string]*placeholderRun // By workflow ID
+}
+
+// placeholderRun holds the resolved placeholders of one workflow
+type placeholderRun struct {
+	vars         *services.ResolvedVariables
+	placeholders []string
+	environment  string
+	lastUsed     time.Time
+}
+
+func newPlaceholderResolver(db *gorm.DB, variables *services.VariableService) *placeholderResolver {
+	return &placeholderResolver{
+		db:        db,
+		variables: variables,
+		now:       time.Now,
+		runs:      make(map[string]*placeholderRun),
+	}
+}
+
+// resolve returns the resolved placeholders of a workflow, resolving every
+// placeholder of its input and config when the worker has none yet
+func (r *placeholderResolver) resolve(ctx context.Context, workflowID string) (*placeholderRun, error) {
+	if run := r.cached(workflowID); run != nil {
+		return run, nil
+	}
+
+	var wf models.Workflow
+	if err := r.db.WithContext(ctx).Select("id", "project_id", "input", "config").
+		First(&wf, "id = ?", workflowID).Error; err != nil {
+		return nil, fmt.Errorf("workflow not found: %w", err)
+	}
+	var config struct {
+		Environment string `json:"environment"`
+	}
+	if len(wf.Config) > 0 {
+		if err := json.Unmarshal(wf.Config, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse workflow config: %w", err)
+		}
+	}
+	vars, err := r.variables.Resolve(ctx, services.VariableScope{ProjectID: wf.ProjectID, Environment: config.Environment})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve variables: %w", err)
+	}
+
+	// Every placeholder is resolved now, so that a missing one fails the
+	// workflow before it does any work
+	placeholders := services.Placeholders(wf.Input, wf.Config)
+	for _, placeholder := range placeholders {
+		if _, err := vars.ExpandPlaceholders(ctx, "{{"+placeholder+"}}"); err != nil {
+			return nil, err
+		}
+	}
+
+	run := &placeholderRun{vars: vars, placeholders: placeholders, environment: config.Environment}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run.lastUsed = r.now()
+	r.runs[workflowID] = run
+	return run, nil
+}
+
+// cached returns the resolved placeholders of a workflow held by the worker,
+// dropping those unused for placeholderTTL
+func (r *placeholderResolver) cached(workflowID string) *placeholderRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for id, run := range r.runs {
+		if now.Sub(run.lastUsed) > placeholderTTL {
+			delete(r.runs, id)
+		}
+	}
+	run := r.runs[workflowID]
+	if run != nil {
+		run.lastUsed = now
+	}
+	return run
+}
+
+// variablesFor returns the variables placeholders in values are resolved
+// against, nil outside of activities and for workflows without placeholders
+func (r *placeholderResolver) variablesFor(ctx context.Context, values ...interface{}) (*services.ResolvedVariables, error) {
+	if !activity.IsActivity(ctx) {
+		return nil, nil
+	}
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+	if run := r.cached(workflowID); run != nil {
+		return run.vars, nil
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode agent request: %w", err)
+	}
+	if len(services.Placeholders(data)) == 0 {
+		return nil, nil
+	}
+	run, err := r.resolve(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	return run.vars, nil
+}
+
+// placeholderAgents substitutes the resolved placeholders of the running
+// workflow into the requests sent to agents and redacts the secrets from
+// what agents answer. Substituting at the last moment keeps the values out
+// of workflow history and of the executions activities record.
+type placeholderAgents struct {
+	services.AgentExecutor
+	resolver *placeholderResolver
+}
+
+// withPlaceholders wraps an executor to substitute placeholders, keeping the
+// task monitoring and conversations it supports
+func withPlaceholders(executor services.AgentExecutor, resolver *placeholderResolver) services.AgentExecutor {
+	if executor == nil {
+		return nil
+	}
+	agents := &placeholderAgents{AgentExecutor: executor, resolver: resolver}
+	monitor, monitored := executor.(services.TaskMonitor)
+	conversational, ok := executor.(services.ConversationalAgent)
+	var conversations *placeholderConversations
+	if ok {
+		conversations = &placeholderConversations{next: conversational, resolver: resolver}
+	}
+	switch {
+	case monitored && ok:
+		return struct {
+			*placeholderAgents
+			services.TaskMonitor
+			*placeholderConversations
+		}{agents, monitor, conversations}
+	case monitored:
+		return struct {
+			*placeholderAgents
+			services.TaskMonitor
+		}{agents, monitor}
+	case ok:
+		return struct {
+			*placeholderAgents
+			*placeholderConversations
+		}{agents, conversations}
+	}
+	return agents
+}
+
+// ExecuteTask substitutes placeholders in the task's input and config
+func (a *placeholderAgents) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	vars, err := a.resolver.variablesFor(ctx, req.Input, req.Config)
+	if err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		return a.AgentExecutor.ExecuteTask(ctx, agentID, req)
+	}
+
+	// The caller's request may be recorded, so it keeps its placeholders
+	expanded := *req
+	if expanded.Input, err = expandPlaceholderMap(ctx, vars, req.Input); err != nil {
+		return nil, err
+	}
+	if expanded.Config, err = expandPlaceholderMap(ctx, vars, req.Config); err != nil {
+		return nil, err
+	}
+	execution, err := a.AgentExecutor.ExecuteTask(ctx, agentID, &expanded)
+	if err != nil {
+		return nil, vars.RedactError(err)
+	}
+	redacted := *execution
+	redacted.Input = req.Input
+	redacted.Output = vars.RedactMap(execution.Output)
+	redacted.Error = vars.Redact(execution.Error)
+	return &redacted, nil
+}
+
+// placeholderConversations substitutes placeholders in the conversations
+// held with agents
+type placeholderConversations struct {
+	next     services.ConversationalAgent
+	resolver *placeholderResolver
+}
+
+func (c *placeholderConversations) CreateConversation(ctx context.Context, agentID string, req *services.CreateConversationRequest) (*services.Conversation, error) {
+	vars, err := c.resolver.variablesFor(ctx, req.Context, req.Config)
+	if err != nil || vars == nil {
+		if err != nil {
+			return nil, err
+		}
+		return c.next.CreateConversation(ctx, agentID, req)
+	}
+
+	expanded := *req
+	if expanded.Context, err = expandPlaceholderMap(ctx, vars, req.Context); err != nil {
+		return nil, err
+	}
+	if expanded.Config, err = expandPlaceholderMap(ctx, vars, req.Config); err != nil {
+		return nil, err
+	}
+	conversation, err := c.next.CreateConversation(ctx, agentID, &expanded)
+	if err != nil {
+		return nil, vars.RedactError(err)
+	}
+	redacted := *conversation
+	redacted.Context = req.Context
+	return &redacted, nil
+}
+
+func (c *placeholderConversations) SendTurn(ctx context.Context, agentID, conversationID string, req *services.SendTurnRequest) (*services.ConversationTurn, error) {
+	vars, err := c.resolver.variablesFor(ctx, req.Input)
+	if err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		return c.next.SendTurn(ctx, agentID, conversationID, req)
+	}
+
+	input, err := expandPlaceholderMap(ctx, vars, req.Input)
+	if err != nil {
+		return nil, err
+	}
+	turn, err := c.next.SendTurn(ctx, agentID, conversationID, &services.SendTurnRequest{Input: input})
+	if err != nil {
+		return nil, vars.RedactError(err)
+	}
+	redacted := redactTurn(vars, *turn)
+	redacted.Input = req.Input
+	return &redacted, nil
+}
+
+func (c *placeholderConversations) GetTurns(ctx context.Context, agentID, conversationID string) ([]services.ConversationTurn, error) {
+	turns, err := c.next.GetTurns(ctx, agentID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	vars, err := c.resolver.variablesFor(ctx)
+	if err != nil || vars == nil {
+		return turns, err
+	}
+	for i := range turns {
+		turns[i] = redactTurn(vars, turns[i])
+	}
+	return turns, nil
+}
+
+func (c *placeholderConversations) CloseConversation(ctx context.Context, agentID, conversationID string) error {
+	return c.next.CloseConversation(ctx, agentID, conversationID)
+}
+
+// redactTurn removes secrets from a conversation turn
+func redactTurn(vars *services.ResolvedVariables, turn services.ConversationTurn) services.ConversationTurn {
+	turn.Input = vars.RedactMap(turn.Input)
+	turn.Output = vars.RedactMap(turn.Output)
+	turn.Error = vars.Redact(turn.Error)
+	return turn
+}
+
+// expandPlaceholderMap substitutes placeholders in a request map, returning
+// a copy
+func expandPlaceholderMap(ctx context.Context, vars *services.ResolvedVariables, m map[string]interface{}) (map[string]interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+	expanded, err := vars.ExpandPlaceholderValue(ctx, m)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), unresolvedPlaceholderError, err)
+	}
+	return expanded.(map[string]interface{}), nil
+}
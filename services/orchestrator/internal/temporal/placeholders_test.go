@@ -0,0 +1,135 @@
+package temporal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// setupPlaceholderActivities stores a workflow with placeholders in its
+// input and config
+func setupPlaceholderActivities(t *testing.T, input string) (*gorm.DB, *placeholderResolver) {
+	db, variables := setupVariableActivities(t)
+	for _, stmt := range []string{
+		`ALTER TABLE workflows ADD COLUMN input BLOB`,
+		`ALTER TABLE workflows ADD COLUMN config BLOB`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	require.NoError(t, db.Exec(`UPDATE workflows SET input = CAST(? AS BLOB), config = CAST(? AS BLOB) WHERE id = ?`,
+		input, `{"environment": "prod"}`, testWorkflowID).Error)
+	return db, newPlaceholderResolver(db, variables)
+}
+
+const placeholderInput = `{"tasks": [{"id": "task-1", "config": {"token": "{{secret:TOKEN}}", "stage": "{{ var:STAGE }}"}}]}`
+
+func TestPlaceholders_ResolvedInActivities(t *testing.T) {
+	db, resolver := setupPlaceholderActivities(t, placeholderInput)
+	agent := &echoAgent{}
+	activities := NewActivities(db, zap.NewNop(), nil, agent)
+	activities.placeholders = resolver
+	metaActivities := NewMetaAgentActivities(withPlaceholders(agent, resolver), zap.NewNop())
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities.ResolvePlaceholdersActivity)
+	env.RegisterActivity(metaActivities.ExecuteTaskWithAgentActivity)
+
+	value, err := env.ExecuteActivity(activities.ResolvePlaceholdersActivity)
+	require.NoError(t, err)
+	var resolution PlaceholderResolution
+	require.NoError(t, value.Get(&resolution))
+	assert.Equal(t, PlaceholderResolution{Placeholders: []string{"secret:TOKEN", "var:STAGE"}, Environment: "prod"}, resolution,
+		"only the names of the placeholders enter workflow history")
+
+	// Tasks carry the placeholders; the agent receives the values
+	task := Task{ID: "task-1", Type: "backend", EstimatedHours: 1, Config: map[string]interface{}{
+		"token": "{{secret:TOKEN}}",
+		"stage": "{{ var:STAGE }}",
+	}}
+	value, err = env.ExecuteActivity(metaActivities.ExecuteTaskWithAgentActivity, task, AgentInfo{ID: "agent-1"}, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+	var result TaskExecutionResult
+	require.NoError(t, value.Get(&result))
+
+	require.Len(t, agent.requests, 1)
+	sent := agent.requests[0].Input["task"].(map[string]interface{})["config"]
+	assert.Equal(t, map[string]interface{}{"token": "tok-secret-1", "stage": "prod"}, sent)
+	assert.Equal(t, models.ExecutionStatusSucceeded, result.Status)
+	assert.Equal(t, "using [REDACTED]", result.Output["log"], "secrets are redacted from what agents answer")
+	assert.Equal(t, "{{secret:TOKEN}}", task.Config["token"])
+
+	// The stored workflow keeps its placeholders
+	var stored models.Workflow
+	require.NoError(t, db.Select("input").First(&stored, "id = ?", testWorkflowID).Error)
+	assert.JSONEq(t, placeholderInput, string(stored.Input))
+}
+
+func TestPlaceholders_SubstitutedIntoCodeExecution(t *testing.T) {
+	const input = `{"language": "python", "code": "login('{{secret:TOKEN}}')", "environment": {"API_TOKEN": "{{secret:TOKEN}}"}}`
+	db, resolver := setupPlaceholderActivities(t, input)
+	agent := &echoAgent{}
+	activities := NewActivities(db, zap.NewNop(), nil, withPlaceholders(agent, resolver))
+	activities.placeholders = resolver
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities.PrepareEnvironmentActivity)
+	env.RegisterActivity(activities.ExecuteCodeActivity)
+
+	req := CodeExecutionRequest{
+		Language:    "python",
+		Code:        "login('{{secret:TOKEN}}')",
+		Environment: map[string]string{"API_TOKEN": "{{secret:TOKEN}}"},
+	}
+	agentInfo := AgentInfo{ID: "agent-1"}
+	_, err := env.ExecuteActivity(activities.PrepareEnvironmentActivity, agentInfo, req, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+	value, err := env.ExecuteActivity(activities.ExecuteCodeActivity, agentInfo, EnvironmentInfo{ID: "env-1"}, req, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+
+	// The environment and the code the agent runs carry the values
+	require.Len(t, agent.requests, 2)
+	assert.Equal(t, map[string]string{"API_TOKEN": "tok-secret-1"}, agent.requests[0].Input["environment"])
+	assert.Equal(t, "login('tok-secret-1')", agent.requests[1].Input["code"])
+	var result ExecutionResult
+	require.NoError(t, value.Get(&result))
+	assert.Equal(t, "token is [REDACTED]", result.Output)
+	assert.Equal(t, "{{secret:TOKEN}}", req.Environment["API_TOKEN"], "the request keeps its placeholders")
+}
+
+func TestPlaceholders_UnresolvedFailsWorkflow(t *testing.T) {
+	db, resolver := setupPlaceholderActivities(t, `{"version": "1.2.0", "registry_token": "{{secret:NOPE}}"}`)
+	activities := NewActivities(db, zap.NewNop(), nil, &echoAgent{})
+	activities.placeholders = resolver
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	env.RegisterActivity(activities.ResolvePlaceholdersActivity)
+
+	var stored models.Workflow
+	require.NoError(t, db.Select("id", "input", "config").First(&stored, "id = ?", testWorkflowID).Error)
+	env.ExecuteWorkflow(engine.DeploymentWorkflow, &stored, (*DeploymentContinuation)(nil))
+	require.True(t, env.IsWorkflowCompleted())
+
+	err := env.GetWorkflowError()
+	require.Error(t, err, "the workflow fails before validating the deployment")
+	var activityErr *temporal.ActivityError
+	require.ErrorAs(t, err, &activityErr)
+	var appErr *temporal.ApplicationError
+	require.True(t, errors.As(activityErr, &appErr))
+	assert.Equal(t, unresolvedPlaceholderError, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+	assert.Contains(t, err.Error(), "{{secret:NOPE}}")
+}
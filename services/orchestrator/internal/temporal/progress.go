@@ -0,0 +1,19 @@
+package temporal
+
+import (
+	"context"
+
+	"go.temporal.io/sdk/activity"
+
+	"orchestrator/internal/services"
+)
+
+// recordProgress heartbeats with structured progress, which the pending
+// activities endpoint decodes for stuck-workflow diagnosis
+func recordProgress(ctx context.Context, percent int, step, message string) {
+	activity.RecordHeartbeat(ctx, services.ActivityProgress{
+		Percent: percent,
+		Step:    step,
+		Message: message,
+	})
+}
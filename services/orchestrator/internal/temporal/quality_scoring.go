@@ -0,0 +1,262 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// Scorers of a QualityScore
+const (
+	QualityScorerHeuristic = "heuristic"
+	QualityScorerReviewer  = "reviewer"
+)
+
+// Signals a heuristic quality score is computed from, each between 0 and 1
+const (
+	qualitySignalReported  = "reported"  // The quality the agent reported
+	qualitySignalArtifacts = "artifacts" // Artifacts produced against those expected
+	qualitySignalTests     = "tests"     // Tests produced when requested
+	qualitySignalDocs      = "docs"      // Documentation produced when requested
+	qualitySignalDuration  = "duration"  // Duration against the estimate
+	qualitySignalReviewer  = "reviewer"  // The reviewer agent's score
+)
+
+// QualityScore is how well an agent ran a task, between 0 and 1
+type QualityScore struct {
+	Score   float64            `json:"score"`
+	Scorer  string             `json:"scorer"`
+	Signals map[string]float64 `json:"signals,omitempty"`
+}
+
+// QualityScorer scores the result of a successful task
+type QualityScorer interface {
+	ScoreResult(ctx context.Context, result TaskExecutionResult) (*QualityScore, error)
+}
+
+// HeuristicQualityScorer scores results from what they carry: the quality
+// the agent reported, the artifacts produced against those expected, tests
+// and documentation when the task requested them, and the duration against
+// the estimate. The score is the mean of the signals available.
+type HeuristicQualityScorer struct{}
+
+func (HeuristicQualityScorer) ScoreResult(ctx context.Context, result TaskExecutionResult) (*QualityScore, error) {
+	return scoreHeuristically(result), nil
+}
+
+func scoreHeuristically(result TaskExecutionResult) *QualityScore {
+	signals := make(map[string]float64)
+
+	if reported, ok := reportedQuality(result.Output); ok {
+		signals[qualitySignalReported] = reported
+	}
+
+	produced := len(result.Artifacts)
+	switch {
+	case result.ExpectedArtifacts > 0:
+		signals[qualitySignalArtifacts] = min(float64(produced)/float64(result.ExpectedArtifacts), 1)
+	case produced > 0:
+		signals[qualitySignalArtifacts] = 1
+	default:
+		signals[qualitySignalArtifacts] = 0
+	}
+
+	hasTests, hasDocs := false, false
+	for _, artifact := range result.Artifacts {
+		hasTests = hasTests || isTestArtifact(artifact)
+		hasDocs = hasDocs || isDocArtifact(artifact)
+	}
+	if result.TestsRequested {
+		signals[qualitySignalTests] = boolSignal(hasTests)
+	}
+	if result.DocsRequested {
+		signals[qualitySignalDocs] = boolSignal(hasDocs)
+	}
+
+	// On time or early scores 1, falling to 0 at three times the estimate
+	if result.EstimatedHours > 0 && result.Duration > 0 {
+		ratio := result.Duration.Hours() / result.EstimatedHours
+		signals[qualitySignalDuration] = clampQuality(1 - (ratio-1)/2)
+	}
+
+	total := 0.0
+	for _, signal := range signals {
+		total += signal
+	}
+	return &QualityScore{
+		Score:   total / float64(len(signals)),
+		Scorer:  QualityScorerHeuristic,
+		Signals: signals,
+	}
+}
+
+// reportedQuality reads the quality an agent reported in its output, as a
+// fraction or a percentage
+func reportedQuality(output map[string]interface{}) (float64, bool) {
+	for _, key := range []string{"quality", "quality_score"} {
+		value, ok := output[key].(float64)
+		if !ok {
+			continue
+		}
+		if value > 1 && value <= 100 {
+			value /= 100
+		}
+		return clampQuality(value), true
+	}
+	return 0, false
+}
+
+func isTestArtifact(artifact Artifact) bool {
+	return artifact.Type == "test" || strings.Contains(strings.ToLower(artifact.Name), "test")
+}
+
+func isDocArtifact(artifact Artifact) bool {
+	name := strings.ToLower(artifact.Name)
+	return artifact.Type == "documentation" || artifact.Type == "docs" ||
+		strings.HasSuffix(name, ".md") || strings.Contains(name, "readme")
+}
+
+func boolSignal(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+func clampQuality(score float64) float64 {
+	return max(0, min(score, 1))
+}
+
+// reviewerQualityScorer has a reviewer agent score results, keeping the
+// heuristic signals next to its verdict. Results are scored heuristically
+// when the reviewer fails.
+type reviewerQualityScorer struct {
+	executor   services.AgentExecutor
+	reviewerID string
+}
+
+// NewReviewerQualityScorer scores results with the reviewer agent
+// reviewerID, which answers review_output tasks with a score and feedback
+func NewReviewerQualityScorer(executor services.AgentExecutor, reviewerID string) QualityScorer {
+	return &reviewerQualityScorer{executor: executor, reviewerID: reviewerID}
+}
+
+func (r *reviewerQualityScorer) ScoreResult(ctx context.Context, result TaskExecutionResult) (*QualityScore, error) {
+	score := scoreHeuristically(result)
+	task := Task{ID: result.TaskID, Type: result.TaskType, Complexity: result.Complexity}
+	review, err := agentQualityCheck(r.executor, r.reviewerID)(ctx, task, result.Output, result.Artifacts)
+	if err != nil {
+		activity.GetLogger(ctx).Warn("Reviewer failed to score task, scoring heuristically",
+			zap.String("taskID", result.TaskID),
+			zap.String("reviewerID", r.reviewerID),
+			zap.Error(err))
+		return score, nil
+	}
+	score.Score = clampQuality(review.Score)
+	score.Scorer = QualityScorerReviewer
+	score.Signals[qualitySignalReviewer] = score.Score
+	return score, nil
+}
+
+// qualityExpectations carries onto a task's result what the task asked of
+// its agent. Tests are requested as they are from the agent, unless the
+// technical requirements set generate_tests; every agent is asked for
+// documentation, so it only counts for documentation tasks or when the
+// requirements set generate_docs.
+func qualityExpectations(result *TaskExecutionResult, task Task) {
+	result.TestsRequested = shouldGenerateTests(task.Type)
+	result.DocsRequested = task.Type == "documentation"
+	if task.TechnicalRequirements == nil {
+		return
+	}
+	if requested, ok := task.TechnicalRequirements["generate_tests"].(bool); ok {
+		result.TestsRequested = requested
+	}
+	if requested, ok := task.TechnicalRequirements["generate_docs"].(bool); ok {
+		result.DocsRequested = requested
+	}
+	if expected, ok := task.TechnicalRequirements["expected_artifacts"].(float64); ok && expected > 0 {
+		result.ExpectedArtifacts = int(expected)
+	}
+}
+
+// scoreResults sets the quality score of each result: failed tasks score 0,
+// successful ones are scored by the configured scorer, heuristically by
+// default. It returns the mean score.
+func (a *Activities) scoreResults(ctx context.Context, results []TaskExecutionResult) float64 {
+	scorer := a.quality
+	if scorer == nil {
+		scorer = HeuristicQualityScorer{}
+	}
+
+	total := 0.0
+	for i := range results {
+		result := &results[i]
+		score := &QualityScore{Scorer: QualityScorerHeuristic}
+		if result.Status.IsSuccess() {
+			scored, err := scorer.ScoreResult(ctx, *result)
+			if err != nil {
+				activity.GetLogger(ctx).Warn("Failed to score task, scoring heuristically",
+					zap.String("taskID", result.TaskID),
+					zap.Error(err))
+				scored = scoreHeuristically(*result)
+			}
+			score = scored
+		}
+		result.QualityScore = &score.Score
+		result.QualityScorer = score.Scorer
+		result.QualitySignals = score.Signals
+		total += score.Score
+	}
+	if len(results) == 0 {
+		return 0
+	}
+	return total / float64(len(results))
+}
+
+// recordTaskQuality stores the quality scores of the running workflow's
+// tasks, from which the average quality of each agent is reported
+func (a *Activities) recordTaskQuality(ctx context.Context, results []TaskExecutionResult) error {
+	if a.qualityScores == nil {
+		return nil
+	}
+
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "project_id", "simulated").
+		First(&workflow, "id = ?", activity.GetInfo(ctx).WorkflowExecution.ID).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+	// Stub agents of simulated workflows say nothing about real ones
+	if workflow.Simulated {
+		return nil
+	}
+
+	var scores []models.TaskQualityScore
+	for _, result := range results {
+		if result.AgentID == "" || result.QualityScore == nil {
+			continue
+		}
+		signals, err := json.Marshal(result.QualitySignals)
+		if err != nil {
+			return fmt.Errorf("failed to marshal quality signals: %w", err)
+		}
+		scores = append(scores, models.TaskQualityScore{
+			WorkflowID: workflow.ID,
+			TaskID:     result.TaskID,
+			ProjectID:  workflow.ProjectID,
+			AgentID:    result.AgentID,
+			TaskType:   result.TaskType,
+			Score:      *result.QualityScore,
+			Scorer:     result.QualityScorer,
+			Signals:    signals,
+		})
+	}
+	return a.qualityScores.RecordScores(ctx, scores)
+}
@@ -0,0 +1,256 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestScoreHeuristically(t *testing.T) {
+	code := Artifact{Name: "main.go", Type: "code"}
+	test := Artifact{Name: "main_test.go", Type: "code"}
+	doc := Artifact{Name: "README.md", Type: "documentation"}
+
+	tests := []struct {
+		name    string
+		result  TaskExecutionResult
+		score   float64
+		signals map[string]float64
+	}{
+		{
+			name:    "nothing produced",
+			result:  TaskExecutionResult{},
+			score:   0,
+			signals: map[string]float64{qualitySignalArtifacts: 0},
+		},
+		{
+			name:    "artifacts without expectation",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code}},
+			score:   1,
+			signals: map[string]float64{qualitySignalArtifacts: 1},
+		},
+		{
+			name:    "reported fraction",
+			result:  TaskExecutionResult{Output: map[string]interface{}{"quality": 0.6}, Artifacts: []Artifact{code}},
+			score:   0.8,
+			signals: map[string]float64{qualitySignalReported: 0.6, qualitySignalArtifacts: 1},
+		},
+		{
+			name:    "reported percentage",
+			result:  TaskExecutionResult{Output: map[string]interface{}{"quality_score": 80.0}, Artifacts: []Artifact{code}},
+			score:   0.9,
+			signals: map[string]float64{qualitySignalReported: 0.8, qualitySignalArtifacts: 1},
+		},
+		{
+			name:    "fewer artifacts than expected",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code}, ExpectedArtifacts: 4},
+			score:   0.25,
+			signals: map[string]float64{qualitySignalArtifacts: 0.25},
+		},
+		{
+			name:    "more artifacts than expected",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code, test}, ExpectedArtifacts: 1},
+			score:   1,
+			signals: map[string]float64{qualitySignalArtifacts: 1},
+		},
+		{
+			name:    "requested tests produced",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code, test}, TestsRequested: true},
+			score:   1,
+			signals: map[string]float64{qualitySignalArtifacts: 1, qualitySignalTests: 1},
+		},
+		{
+			name:    "requested tests missing",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code}, TestsRequested: true},
+			score:   0.5,
+			signals: map[string]float64{qualitySignalArtifacts: 1, qualitySignalTests: 0},
+		},
+		{
+			name:    "requested docs produced",
+			result:  TaskExecutionResult{Artifacts: []Artifact{doc}, DocsRequested: true},
+			score:   1,
+			signals: map[string]float64{qualitySignalArtifacts: 1, qualitySignalDocs: 1},
+		},
+		{
+			name:    "requested docs missing",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code, test}, TestsRequested: true, DocsRequested: true},
+			score:   2.0 / 3,
+			signals: map[string]float64{qualitySignalArtifacts: 1, qualitySignalTests: 1, qualitySignalDocs: 0},
+		},
+		{
+			name:    "early",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code}, EstimatedHours: 2, Duration: time.Hour},
+			score:   1,
+			signals: map[string]float64{qualitySignalArtifacts: 1, qualitySignalDuration: 1},
+		},
+		{
+			name:    "twice the estimate",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code}, EstimatedHours: 1, Duration: 2 * time.Hour},
+			score:   0.75,
+			signals: map[string]float64{qualitySignalArtifacts: 1, qualitySignalDuration: 0.5},
+		},
+		{
+			name:    "far over the estimate",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code}, EstimatedHours: 1, Duration: 5 * time.Hour},
+			score:   0.5,
+			signals: map[string]float64{qualitySignalArtifacts: 1, qualitySignalDuration: 0},
+		},
+		{
+			name:    "no estimate",
+			result:  TaskExecutionResult{Artifacts: []Artifact{code}, Duration: 5 * time.Hour},
+			score:   1,
+			signals: map[string]float64{qualitySignalArtifacts: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := scoreHeuristically(tt.result)
+			assert.Equal(t, QualityScorerHeuristic, score.Scorer)
+			assert.InDelta(t, tt.score, score.Score, 1e-9)
+			require.Len(t, score.Signals, len(tt.signals))
+			for signal, value := range tt.signals {
+				assert.InDelta(t, value, score.Signals[signal], 1e-9, signal)
+			}
+		})
+	}
+}
+
+func TestQualityExpectations(t *testing.T) {
+	var result TaskExecutionResult
+	qualityExpectations(&result, Task{Type: "backend"})
+	assert.True(t, result.TestsRequested)
+	assert.False(t, result.DocsRequested)
+
+	result = TaskExecutionResult{}
+	qualityExpectations(&result, Task{Type: "documentation"})
+	assert.False(t, result.TestsRequested)
+	assert.True(t, result.DocsRequested)
+
+	result = TaskExecutionResult{}
+	qualityExpectations(&result, Task{Type: "backend", TechnicalRequirements: map[string]interface{}{
+		"generate_tests":     false,
+		"generate_docs":      true,
+		"expected_artifacts": 3.0,
+	}})
+	assert.False(t, result.TestsRequested)
+	assert.True(t, result.DocsRequested)
+	assert.Equal(t, 3, result.ExpectedArtifacts)
+}
+
+// scoringReviewer answers review_output tasks with a fixed score
+type scoringReviewer struct {
+	services.AgentExecutor
+	score float64
+	err   error
+}
+
+func (r *scoringReviewer) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &services.TaskExecution{Output: map[string]interface{}{"score": r.score}}, nil
+}
+
+func TestReviewerQualityScorer(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	result := TaskExecutionResult{TaskID: "task-1", Status: models.ExecutionStatusSucceeded, Artifacts: []Artifact{{Name: "main.go"}}}
+
+	reviewer := &scoringReviewer{score: 0.4}
+	var score *QualityScore
+	env.RegisterActivityWithOptions(func(ctx context.Context) error {
+		var err error
+		score, err = NewReviewerQualityScorer(reviewer, "reviewer-1").ScoreResult(ctx, result)
+		return err
+	}, activity.RegisterOptions{Name: "ScoreWithReviewer"})
+
+	_, err := env.ExecuteActivity("ScoreWithReviewer")
+	require.NoError(t, err)
+	assert.Equal(t, QualityScorerReviewer, score.Scorer)
+	assert.InDelta(t, 0.4, score.Score, 1e-9)
+	assert.InDelta(t, 0.4, score.Signals[qualitySignalReviewer], 1e-9)
+	assert.InDelta(t, 1, score.Signals[qualitySignalArtifacts], 1e-9)
+
+	// A failing reviewer leaves the heuristic score
+	reviewer.err = errors.New("reviewer unavailable")
+	_, err = env.ExecuteActivity("ScoreWithReviewer")
+	require.NoError(t, err)
+	assert.Equal(t, QualityScorerHeuristic, score.Scorer)
+	assert.InDelta(t, 1, score.Score, 1e-9)
+}
+
+func TestAggregateTaskResultsActivity_RecordsQualityScores(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, simulated BOOLEAN, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO workflows (id, project_id, simulated) VALUES (?, ?, ?)`, testWorkflowID, "project-1", false).Error)
+	require.NoError(t, db.AutoMigrate(&models.TaskQualityScore{}))
+
+	activities := NewActivities(db, zap.NewNop(), nil, nil)
+	activities.qualityScores = services.NewTaskQualityTracker(db)
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	results := []TaskExecutionResult{
+		{TaskID: "task-1", AgentID: "agent-1", Status: models.ExecutionStatusSucceeded, Artifacts: []Artifact{{Name: "main.go"}}},
+		{TaskID: "task-2", AgentID: "agent-1", Status: models.ExecutionStatusSucceeded, Artifacts: []Artifact{{Name: "main.go"}}, TestsRequested: true},
+		{TaskID: "task-3", AgentID: "agent-2", Status: models.ExecutionStatusFailed},
+	}
+
+	// Retries of the activity record each task once
+	var aggregated AggregatedTaskResult
+	for i := 0; i < 2; i++ {
+		value, err := env.ExecuteActivity("AggregateTaskResultsActivity", results)
+		require.NoError(t, err)
+		require.NoError(t, value.Get(&aggregated))
+	}
+
+	assert.InDelta(t, 0.5, aggregated.Metadata["average_quality"], 1e-9)
+	require.Len(t, aggregated.TaskResults, 3)
+	require.NotNil(t, aggregated.TaskResults[1].QualityScore)
+	assert.InDelta(t, 0.5, *aggregated.TaskResults[1].QualityScore, 1e-9)
+	assert.InDelta(t, 0, *aggregated.TaskResults[2].QualityScore, 1e-9)
+
+	var scores []models.TaskQualityScore
+	require.NoError(t, db.Order("task_id").Find(&scores).Error)
+	require.Len(t, scores, 3)
+	assert.Equal(t, "project-1", scores[0].ProjectID)
+	assert.Equal(t, QualityScorerHeuristic, scores[0].Scorer)
+	assert.JSONEq(t, `{"artifacts": 1, "tests": 0}`, string(scores[1].Signals))
+
+	// The scores carry into the meta agent's performance metrics
+	metrics := (&MetaAgentActivities{}).calculatePerformanceMetrics(aggregated.TaskResults)
+	assert.InDelta(t, 0.5, metrics.AverageScore, 1e-9)
+	assert.Equal(t, 2, metrics.SuccessfulExecutions)
+	assert.InDelta(t, 1.0/3, metrics.FailureRate, 1e-9)
+}
+
+func TestCalculatePerformanceMetrics_UsesQualityScores(t *testing.T) {
+	reviewed := 0.2
+	results := []TaskExecutionResult{
+		// Scored by the reviewer, which overrides the heuristic
+		{Status: models.ExecutionStatusSucceeded, Artifacts: []Artifact{{Name: "main.go"}}, QualityScore: &reviewed, Duration: time.Minute},
+		// Not scored yet: scored heuristically
+		{Status: models.ExecutionStatusSucceeded, Artifacts: []Artifact{{Name: "main.go"}}, Duration: 3 * time.Minute},
+	}
+
+	metrics := (&MetaAgentActivities{}).calculatePerformanceMetrics(results)
+	assert.InDelta(t, 0.6, metrics.AverageScore, 1e-9)
+	assert.Equal(t, 2*time.Minute, metrics.AverageDuration)
+	assert.Zero(t, metrics.FailureRate)
+}
@@ -0,0 +1,238 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// ErrNondeterministic is returned when a history diverges from the current
+// workflow code
+var ErrNondeterministic = errors.New("workflow code is nondeterministic for this history")
+
+// ReplayDivergence is where a history diverged from the current workflow code
+type ReplayDivergence struct {
+	EventID     int64  `json:"event_id"`
+	EventType   string `json:"event_type"`
+	Explanation string `json:"explanation"`
+	Detail      string `json:"detail"` // The SDK's message
+}
+
+// Replayer replays workflow histories against the workflows this worker
+// registers, to debug nondeterminism errors and to check that workflow
+// changes keep running executions replayable
+type Replayer struct {
+	replayer worker.WorkflowReplayer
+	logger   *zap.Logger
+}
+
+// NewReplayer creates a replayer decoding payloads as the worker configured
+// with cfg does
+func NewReplayer(cfg config.PayloadCodecConfig, logger *zap.Logger) (*Replayer, error) {
+	dataConverter, err := NewPayloadDataConverter(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payload data converter: %w", err)
+	}
+	replayer, err := worker.NewWorkflowReplayerWithOptions(worker.WorkflowReplayerOptions{
+		DataConverter:      dataConverter,
+		ContextPropagators: []workflow.ContextPropagator{NewRequestIDPropagator()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workflow replayer: %w", err)
+	}
+	registerWorkflows(replayer, NewWorkflowEngine(logger), DefaultWorkflowTypes())
+	return &Replayer{replayer: replayer, logger: logger}, nil
+}
+
+// Replay replays a history. When the current workflow code diverges from it,
+// the divergence is returned with an error wrapping ErrNondeterministic.
+// Other failures, such as corrupt histories, are returned without one.
+func (r *Replayer) Replay(history *historypb.History) (*ReplayDivergence, error) {
+	err := r.replay(history.Events)
+	if err == nil {
+		return nil, nil
+	}
+	if !isNondeterminism(err) {
+		return nil, fmt.Errorf("failed to replay workflow history: %w", err)
+	}
+
+	divergence := explainDivergence(err)
+	if event := r.divergentEvent(history.Events, divergence.Detail); event != nil {
+		divergence.EventID = event.GetEventId()
+		divergence.EventType = event.GetEventType().String()
+	}
+	return divergence, fmt.Errorf("%w: %s", ErrNondeterministic, divergence.Detail)
+}
+
+func (r *Replayer) replay(events []*historypb.HistoryEvent) error {
+	return r.replayer.ReplayWorkflowHistory(NewTemporalLogger(r.logger), &historypb.History{Events: events})
+}
+
+// divergentEvent finds the event at which a history diverged. A history
+// only replays cleanly when cut between workflow tasks, so the workflow task
+// that diverged is found by replaying the history up to each workflow task;
+// the SDK's message then tells the event within it.
+func (r *Replayer) divergentEvent(events []*historypb.HistoryEvent, detail string) *historypb.HistoryEvent {
+	const minEvents = 3 // The least the replayer accepts
+	var cuts []int
+	for i, event := range events {
+		if i >= minEvents && event.GetEventType() == enumspb.EVENT_TYPE_WORKFLOW_TASK_SCHEDULED {
+			cuts = append(cuts, i)
+		}
+	}
+	cuts = append(cuts, len(events))
+	diverged := sort.Search(len(cuts), func(i int) bool {
+		return isNondeterminism(r.replay(events[:cuts[i]]))
+	})
+	if diverged == len(cuts) {
+		return nil
+	}
+	start := 0
+	if diverged > 0 {
+		start = cuts[diverged-1]
+	}
+	task := events[start:cuts[diverged]]
+
+	// The history event the SDK names, or else the completion of the
+	// workflow task whose commands the history does not record
+	if match := historyEventPattern.FindStringSubmatch(detail); match != nil {
+		id := ""
+		if idMatch := eventIDPattern.FindStringSubmatch(detail); idMatch != nil {
+			id = idMatch[1]
+		}
+		for _, event := range task {
+			if event.GetEventType().String() == match[1] && (id == "" || commandID(event) == id) {
+				return event
+			}
+		}
+	}
+	for i := len(task) - 1; i >= 0; i-- {
+		if task[i].GetEventType() == enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED {
+			return task[i]
+		}
+	}
+	return task[len(task)-1]
+}
+
+// commandID returns the activity or timer ID of an event, which the SDK
+// prints in its messages
+func commandID(event *historypb.HistoryEvent) string {
+	if attrs := event.GetActivityTaskScheduledEventAttributes(); attrs != nil {
+		return attrs.GetActivityId()
+	}
+	if attrs := event.GetTimerStartedEventAttributes(); attrs != nil {
+		return attrs.GetTimerId()
+	}
+	return ""
+}
+
+// isNondeterminism reports whether a replay failed because the workflow code
+// issued other commands than the history records. The SDK does not export
+// its error type.
+func isNondeterminism(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "nondeterministic")
+}
+
+var (
+	missingCommandPattern    = regexp.MustCompile(`missing replay command for (\w+)`)
+	extraCommandPattern      = regexp.MustCompile(`extra replay command for (\w+)`)
+	mismatchedCommandPattern = regexp.MustCompile(`history event is (\w+): .*, replay command is (\w+)`)
+	activityTypePattern      = regexp.MustCompile(`ActivityType:\(?Name:(\w+)`)
+	historyEventPattern      = regexp.MustCompile(`(?:history event is|missing replay command for) (\w+)`)
+	eventIDPattern           = regexp.MustCompile(`(?:ActivityId|TimerId):([\w-]+)`)
+)
+
+// explainDivergence turns the SDK's nondeterminism message into an
+// explanation of what changed in the workflow code
+func explainDivergence(err error) *ReplayDivergence {
+	detail := err.Error()
+	divergence := &ReplayDivergence{Detail: detail}
+	activities := activityTypePattern.FindAllStringSubmatch(detail, -1)
+	activity := func(i int) string {
+		if i < len(activities) {
+			return " " + activities[i][1]
+		}
+		return ""
+	}
+
+	switch {
+	case mismatchedCommandPattern.MatchString(detail):
+		match := mismatchedCommandPattern.FindStringSubmatch(detail)
+		divergence.Explanation = fmt.Sprintf(
+			"the history records %s%s where the workflow code now issues %s%s; steps were reordered, renamed or replaced",
+			match[1], activity(0), match[2], activity(1))
+	case missingCommandPattern.MatchString(detail):
+		match := missingCommandPattern.FindStringSubmatch(detail)
+		divergence.Explanation = fmt.Sprintf(
+			"the history records %s%s but the workflow code no longer issues it; a step was removed or made conditional",
+			match[1], activity(0))
+	case extraCommandPattern.MatchString(detail):
+		match := extraCommandPattern.FindStringSubmatch(detail)
+		divergence.Explanation = fmt.Sprintf(
+			"the workflow code issues %s%s which the history does not record; a step was added before existing ones",
+			match[1], activity(0))
+	default:
+		divergence.Explanation = "the workflow code no longer produces the commands the history records"
+	}
+	return divergence
+}
+
+// LoadHistory reads a history saved as JSON, by `temporal workflow show
+// --output json` or by SaveHistory
+func LoadHistory(path string) (*historypb.History, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workflow history: %w", err)
+	}
+	defer file.Close()
+
+	history, err := client.HistoryFromJSON(file, client.HistoryJSONOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workflow history %s: %w", path, err)
+	}
+	return history, nil
+}
+
+// SaveHistory writes a history as JSON, for instance to record a golden
+// history replayed by the tests
+func SaveHistory(path string, history *historypb.History) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create workflow history file: %w", err)
+	}
+	marshaler := jsonpb.Marshaler{Indent: "  "}
+	if err := marshaler.Marshal(file, history); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write workflow history: %w", err)
+	}
+	return file.Close()
+}
+
+// FetchHistory fetches the history of a workflow run from Temporal. An
+// empty runID fetches the latest run.
+func FetchHistory(ctx context.Context, c client.Client, workflowID, runID string) (*historypb.History, error) {
+	history := &historypb.History{}
+	iter := c.GetWorkflowHistory(ctx, workflowID, runID, false, 0)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch workflow history: %w", err)
+		}
+		history.Events = append(history.Events, event)
+	}
+	return history, nil
+}
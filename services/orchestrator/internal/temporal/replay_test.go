@@ -0,0 +1,66 @@
+package temporal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	historypb "go.temporal.io/api/history/v1"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// The golden histories in testdata are completed runs of the workflows as
+// deployed; `replayer -workflow-id ID -save FILE` records more. A workflow
+// change that fails this test would break the executions running when it is
+// deployed; gate it with workflow.GetVersion instead.
+func TestReplayer_GoldenHistories(t *testing.T) {
+	replayer, err := NewReplayer(config.PayloadCodecConfig{}, zap.NewNop())
+	require.NoError(t, err)
+
+	files, err := filepath.Glob("testdata/*_history.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			history, err := LoadHistory(file)
+			require.NoError(t, err)
+
+			divergence, err := replayer.Replay(history)
+			require.NoError(t, err)
+			assert.Nil(t, divergence)
+		})
+	}
+}
+
+func TestReplayer_ReportsDivergence(t *testing.T) {
+	replayer, err := NewReplayer(config.PayloadCodecConfig{}, zap.NewNop())
+	require.NoError(t, err)
+	history, err := LoadHistory("testdata/intent_processing_history.json")
+	require.NoError(t, err)
+
+	// Pretend the recorded run planned with an activity the code no longer calls
+	scheduled := scheduledActivity(t, history, "CreateExecutionPlanActivity")
+	scheduled.GetActivityTaskScheduledEventAttributes().ActivityType.Name = "PlanStepsActivity"
+
+	divergence, err := replayer.Replay(history)
+	require.ErrorIs(t, err, ErrNondeterministic)
+	require.NotNil(t, divergence)
+	assert.Equal(t, scheduled.GetEventId(), divergence.EventID)
+	assert.Equal(t, "ActivityTaskScheduled", divergence.EventType)
+	assert.Contains(t, divergence.Explanation, "PlanStepsActivity")
+	assert.Contains(t, divergence.Explanation, "CreateExecutionPlanActivity")
+	assert.NotEmpty(t, divergence.Detail)
+}
+
+func scheduledActivity(t *testing.T, history *historypb.History, activityType string) *historypb.HistoryEvent {
+	for _, event := range history.Events {
+		if attrs := event.GetActivityTaskScheduledEventAttributes(); attrs != nil && attrs.ActivityType.GetName() == activityType {
+			return event
+		}
+	}
+	t.Fatalf("no %s scheduled in the history", activityType)
+	return nil
+}
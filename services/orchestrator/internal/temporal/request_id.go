@@ -0,0 +1,80 @@
+package temporal
+
+import (
+	"context"
+
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/requestid"
+)
+
+// requestIDHeader is the Temporal header key carrying the request ID
+const requestIDHeader = "request-id"
+
+type requestIDWorkflowKey struct{}
+
+// requestIDPropagator carries the ID of the API request that started a
+// workflow through to its activities and child workflows, so agent and intent
+// calls made by activities send the same X-Request-ID.
+type requestIDPropagator struct{}
+
+// NewRequestIDPropagator creates the context propagator for request IDs
+func NewRequestIDPropagator() workflow.ContextPropagator {
+	return &requestIDPropagator{}
+}
+
+// Inject writes the request ID from a Go context into the Temporal header
+func (p *requestIDPropagator) Inject(ctx context.Context, writer workflow.HeaderWriter) error {
+	return writeRequestID(requestid.FromContext(ctx), writer)
+}
+
+// InjectFromWorkflow writes the request ID from a workflow context into the
+// Temporal header
+func (p *requestIDPropagator) InjectFromWorkflow(ctx workflow.Context, writer workflow.HeaderWriter) error {
+	id, _ := ctx.Value(requestIDWorkflowKey{}).(string)
+	return writeRequestID(id, writer)
+}
+
+// Extract reads the request ID from the Temporal header into a Go context
+func (p *requestIDPropagator) Extract(ctx context.Context, reader workflow.HeaderReader) (context.Context, error) {
+	id, err := readRequestID(reader)
+	if err != nil || id == "" {
+		return ctx, err
+	}
+	return requestid.NewContext(ctx, id), nil
+}
+
+// ExtractToWorkflow reads the request ID from the Temporal header into a
+// workflow context
+func (p *requestIDPropagator) ExtractToWorkflow(ctx workflow.Context, reader workflow.HeaderReader) (workflow.Context, error) {
+	id, err := readRequestID(reader)
+	if err != nil || id == "" {
+		return ctx, err
+	}
+	return workflow.WithValue(ctx, requestIDWorkflowKey{}, id), nil
+}
+
+func writeRequestID(id string, writer workflow.HeaderWriter) error {
+	if id == "" {
+		return nil
+	}
+	payload, err := converter.GetDefaultDataConverter().ToPayload(id)
+	if err != nil {
+		return err
+	}
+	writer.Set(requestIDHeader, payload)
+	return nil
+}
+
+func readRequestID(reader workflow.HeaderReader) (string, error) {
+	payload, ok := reader.Get(requestIDHeader)
+	if !ok {
+		return "", nil
+	}
+	var id string
+	if err := converter.GetDefaultDataConverter().FromPayload(payload, &id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
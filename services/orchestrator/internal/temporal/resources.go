@@ -0,0 +1,214 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// resourceActivityOptions apply to every resource activity. Provider calls
+// are keyed by the resource ID, so retrying them is safe.
+var resourceActivityOptions = workflow.ActivityOptions{
+	StartToCloseTimeout: 2 * time.Minute,
+	RetryPolicy: &temporal.RetryPolicy{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    time.Minute,
+		MaximumAttempts:    5,
+	},
+}
+
+// ResourceProvisioningWorkflow asks the resource's provider to create it,
+// polls until the provider reports it ready and records how to connect to
+// it. A resource that fails or does not become ready in time is marked
+// failed with the reason.
+func (w *WorkflowEngine) ResourceProvisioningWorkflow(ctx workflow.Context, input services.ResourceWorkflowInput) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Starting resource provisioning workflow", "resourceID", input.ResourceID)
+	ctx = workflow.WithActivityOptions(ctx, resourceActivityOptions)
+
+	if err := w.provisionResource(ctx, input); err != nil {
+		markResourceFailed(ctx, input.ResourceID, err)
+		return err
+	}
+	return nil
+}
+
+func (w *WorkflowEngine) provisionResource(ctx workflow.Context, input services.ResourceWorkflowInput) error {
+	if err := workflow.ExecuteActivity(ctx, ActivityProvisionResource, input.ResourceID).Get(ctx, nil); err != nil {
+		return err
+	}
+
+	deadline := workflow.Now(ctx).Add(input.Timeout)
+	for {
+		var state services.ResourceState
+		if err := workflow.ExecuteActivity(ctx, ActivityCheckResource, input.ResourceID).Get(ctx, &state); err != nil {
+			return err
+		}
+		if state.Failed {
+			return fmt.Errorf("resource failed: %s", state.Error)
+		}
+		if state.Ready {
+			return workflow.ExecuteActivity(ctx, ActivityMarkResourceReady, input.ResourceID, state.Connection).Get(ctx, nil)
+		}
+		if !workflow.Now(ctx).Before(deadline) {
+			return fmt.Errorf("resource not ready after %s", input.Timeout)
+		}
+		if err := workflow.Sleep(ctx, input.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// ResourceTerminationWorkflow asks the resource's provider to remove it and
+// records when it was terminated
+func (w *WorkflowEngine) ResourceTerminationWorkflow(ctx workflow.Context, input services.ResourceWorkflowInput) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Info("Starting resource termination workflow", "resourceID", input.ResourceID)
+	ctx = workflow.WithActivityOptions(ctx, resourceActivityOptions)
+
+	err := workflow.ExecuteActivity(ctx, ActivityTerminateResource, input.ResourceID).Get(ctx, nil)
+	if err == nil {
+		err = workflow.ExecuteActivity(ctx, ActivityMarkResourceTerminated, input.ResourceID).Get(ctx, nil)
+	}
+	if err != nil {
+		markResourceFailed(ctx, input.ResourceID, err)
+		return err
+	}
+	return nil
+}
+
+// markResourceFailed records why a resource workflow failed, also when the
+// workflow was cancelled
+func markResourceFailed(ctx workflow.Context, resourceID string, cause error) {
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	if err := workflow.ExecuteActivity(ctx, ActivityMarkResourceFailed, resourceID, resourceFailureMessage(cause)).Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Error("Failed to mark resource failed", "resourceID", resourceID, "error", err)
+	}
+}
+
+// resourceFailureMessage is the reason recorded on a failed resource: the
+// provider's own message when an activity failed
+func resourceFailureMessage(err error) string {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		return appErr.Message()
+	}
+	return err.Error()
+}
+
+// ResourceActivities implements the resource workflow activities
+type ResourceActivities struct {
+	db           *gorm.DB
+	logger       *zap.Logger
+	provisioners map[string]services.ResourceProvisioner
+}
+
+// NewResourceActivities creates the resource activities. Resources fail to
+// provision until provisioners are set.
+func NewResourceActivities(db *gorm.DB, logger *zap.Logger) *ResourceActivities {
+	return &ResourceActivities{db: db, logger: logger}
+}
+
+// load returns a resource and the provisioner of its provider
+func (a *ResourceActivities) load(ctx context.Context, resourceID string) (*models.Resource, services.ResourceProvisioner, error) {
+	var resource models.Resource
+	if err := a.db.WithContext(ctx).First(&resource, "id = ?", resourceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, temporal.NewNonRetryableApplicationError("resource not found", "ResourceNotFound", err)
+		}
+		return nil, nil, fmt.Errorf("failed to load resource: %w", err)
+	}
+
+	provisioner, ok := a.provisioners[resource.Provider]
+	if !ok {
+		return nil, nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("%s: %s", services.ErrUnknownResourceProvider, resource.Provider), "UnknownResourceProvider", nil)
+	}
+	return &resource, provisioner, nil
+}
+
+// ProvisionResourceActivity asks the resource's provider to create it
+func (a *ResourceActivities) ProvisionResourceActivity(ctx context.Context, resourceID string) error {
+	resource, provisioner, err := a.load(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	return provisioner.Provision(ctx, resource)
+}
+
+// CheckResourceActivity asks the resource's provider whether it is ready
+func (a *ResourceActivities) CheckResourceActivity(ctx context.Context, resourceID string) (*services.ResourceState, error) {
+	resource, provisioner, err := a.load(ctx, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	return provisioner.State(ctx, resource)
+}
+
+// MarkResourceReadyActivity records that a provisioning resource is ready
+// and how to connect to it
+func (a *ResourceActivities) MarkResourceReadyActivity(ctx context.Context, resourceID string, connection map[string]interface{}) error {
+	metadata, err := json.Marshal(connection)
+	if err != nil {
+		return fmt.Errorf("failed to marshal connection metadata: %w", err)
+	}
+	return a.transition(ctx, resourceID, []models.ResourceStatus{models.ResourceStatusProvisioning}, map[string]interface{}{
+		"status":         models.ResourceStatusReady,
+		"metadata":       metadata,
+		"provisioned_at": time.Now().UTC(),
+		"error":          "",
+	})
+}
+
+// TerminateResourceActivity asks the resource's provider to remove it
+func (a *ResourceActivities) TerminateResourceActivity(ctx context.Context, resourceID string) error {
+	resource, provisioner, err := a.load(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	return provisioner.Terminate(ctx, resource)
+}
+
+// MarkResourceTerminatedActivity records that a terminating resource is gone
+func (a *ResourceActivities) MarkResourceTerminatedActivity(ctx context.Context, resourceID string) error {
+	return a.transition(ctx, resourceID, []models.ResourceStatus{models.ResourceStatusTerminating}, map[string]interface{}{
+		"status":        models.ResourceStatusTerminated,
+		"terminated_at": time.Now().UTC(),
+	})
+}
+
+// MarkResourceFailedActivity records why provisioning or terminating a
+// resource failed
+func (a *ResourceActivities) MarkResourceFailedActivity(ctx context.Context, resourceID, message string) error {
+	return a.transition(ctx, resourceID,
+		[]models.ResourceStatus{models.ResourceStatusProvisioning, models.ResourceStatusTerminating},
+		map[string]interface{}{"status": models.ResourceStatusFailed, "error": message})
+}
+
+// transition updates a resource still in one of the from statuses. A retried
+// activity finds the resource already moved on and changes nothing.
+func (a *ResourceActivities) transition(ctx context.Context, resourceID string, from []models.ResourceStatus, updates map[string]interface{}) error {
+	result := a.db.WithContext(ctx).Model(&models.Resource{}).
+		Where("id = ? AND status IN ?", resourceID, from).
+		Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update resource: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		a.logger.Info("Resource already moved on, skipping status update",
+			zap.String("resource_id", resourceID),
+			zap.Any("status", updates["status"]))
+	}
+	return nil
+}
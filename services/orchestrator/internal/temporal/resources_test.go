@@ -0,0 +1,207 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// fakeProvisioner creates each resource once, however often it is asked,
+// and reports the states queued for it in turn
+type fakeProvisioner struct {
+	mu           sync.Mutex
+	created      map[string]int
+	terminated   map[string]int
+	states       []services.ResourceState
+	provisionErr []error // Returned by the first calls to Provision
+}
+
+func newFakeProvisioner(states ...services.ResourceState) *fakeProvisioner {
+	return &fakeProvisioner{created: make(map[string]int), terminated: make(map[string]int), states: states}
+}
+
+func (p *fakeProvisioner) Provision(ctx context.Context, resource *models.Resource) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.provisionErr) > 0 {
+		err := p.provisionErr[0]
+		p.provisionErr = p.provisionErr[1:]
+		return err
+	}
+	if p.created[resource.ID] == 0 {
+		p.created[resource.ID]++
+	}
+	return nil
+}
+
+func (p *fakeProvisioner) State(ctx context.Context, resource *models.Resource) (*services.ResourceState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	state := p.states[0]
+	if len(p.states) > 1 {
+		p.states = p.states[1:]
+	}
+	return &state, nil
+}
+
+func (p *fakeProvisioner) Terminate(ctx context.Context, resource *models.Resource) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.terminated[resource.ID]++
+	return nil
+}
+
+// setupResourceTestDB creates the resources table without its Postgres-only
+// uuid default
+func setupResourceTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+
+	stmt := &gorm.Statement{DB: db}
+	require.NoError(t, stmt.Parse(&models.Resource{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+	require.NoError(t, db.Migrator().CreateTable(&models.Resource{}))
+	return db
+}
+
+func createTestResource(t *testing.T, db *gorm.DB, provider string, status models.ResourceStatus) *models.Resource {
+	resource := &models.Resource{
+		ID:        "resource-1",
+		ProjectID: "project-1",
+		Name:      "db",
+		Type:      "database",
+		Provider:  provider,
+		Status:    status,
+	}
+	require.NoError(t, db.Create(resource).Error)
+	return resource
+}
+
+func runResourceWorkflow(t *testing.T, db *gorm.DB, provisioner services.ResourceProvisioner, workflowFn interface{}) error {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	activities := NewResourceActivities(db, zap.NewNop())
+	activities.provisioners = map[string]services.ResourceProvisioner{"fake": provisioner}
+	env.RegisterActivity(activities)
+
+	env.ExecuteWorkflow(workflowFn, services.ResourceWorkflowInput{
+		ResourceID:   "resource-1",
+		PollInterval: 10 * time.Second,
+		Timeout:      time.Minute,
+	})
+	require.True(t, env.IsWorkflowCompleted())
+	return env.GetWorkflowError()
+}
+
+func loadTestResource(t *testing.T, db *gorm.DB) models.Resource {
+	var resource models.Resource
+	require.NoError(t, db.First(&resource, "id = ?", "resource-1").Error)
+	return resource
+}
+
+func TestResourceProvisioningWorkflow_Succeeds(t *testing.T) {
+	db := setupResourceTestDB(t)
+	createTestResource(t, db, "fake", models.ResourceStatusProvisioning)
+	provisioner := newFakeProvisioner(
+		services.ResourceState{},
+		services.ResourceState{Ready: true, Connection: map[string]interface{}{"host": "db.local", "port": float64(5432)}},
+	)
+	// A transient provider error is retried without creating the resource twice
+	provisioner.provisionErr = []error{errors.New("provider unavailable")}
+
+	engine := NewWorkflowEngine(zap.NewNop())
+	require.NoError(t, runResourceWorkflow(t, db, provisioner, engine.ResourceProvisioningWorkflow))
+
+	resource := loadTestResource(t, db)
+	assert.Equal(t, models.ResourceStatusReady, resource.Status)
+	require.NotNil(t, resource.ProvisionedAt)
+	assert.Empty(t, resource.Error)
+	var connection map[string]interface{}
+	require.NoError(t, json.Unmarshal(resource.Metadata, &connection))
+	assert.Equal(t, map[string]interface{}{"host": "db.local", "port": float64(5432)}, connection)
+	assert.Equal(t, 1, provisioner.created["resource-1"])
+
+	// Marking it ready again, as a retried activity would, changes nothing
+	activities := NewResourceActivities(db, zap.NewNop())
+	require.NoError(t, activities.MarkResourceReadyActivity(context.Background(), "resource-1", map[string]interface{}{"host": "other"}))
+	again := loadTestResource(t, db)
+	assert.Equal(t, resource.ProvisionedAt.UTC(), again.ProvisionedAt.UTC())
+	assert.JSONEq(t, string(resource.Metadata), string(again.Metadata))
+}
+
+func TestResourceProvisioningWorkflow_Fails(t *testing.T) {
+	engine := NewWorkflowEngine(zap.NewNop())
+
+	tests := []struct {
+		name        string
+		provider    string
+		provisioner *fakeProvisioner
+		wantError   string
+	}{
+		{
+			name:        "provider reports failure",
+			provider:    "fake",
+			provisioner: newFakeProvisioner(services.ResourceState{Failed: true, Error: "image pull failed"}),
+			wantError:   "resource failed: image pull failed",
+		},
+		{
+			name:        "never ready",
+			provider:    "fake",
+			provisioner: newFakeProvisioner(services.ResourceState{}),
+			wantError:   "resource not ready after 1m0s",
+		},
+		{
+			name:        "unknown provider",
+			provider:    "aws",
+			provisioner: newFakeProvisioner(services.ResourceState{Ready: true}),
+			wantError:   "unknown resource provider: aws",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := setupResourceTestDB(t)
+			createTestResource(t, db, tt.provider, models.ResourceStatusProvisioning)
+
+			err := runResourceWorkflow(t, db, tt.provisioner, engine.ResourceProvisioningWorkflow)
+			require.Error(t, err)
+
+			resource := loadTestResource(t, db)
+			assert.Equal(t, models.ResourceStatusFailed, resource.Status)
+			assert.Equal(t, tt.wantError, resource.Error)
+			assert.Nil(t, resource.ProvisionedAt)
+		})
+	}
+}
+
+func TestResourceTerminationWorkflow(t *testing.T) {
+	db := setupResourceTestDB(t)
+	createTestResource(t, db, "fake", models.ResourceStatusTerminating)
+	provisioner := newFakeProvisioner(services.ResourceState{Ready: true})
+
+	engine := NewWorkflowEngine(zap.NewNop())
+	require.NoError(t, runResourceWorkflow(t, db, provisioner, engine.ResourceTerminationWorkflow))
+
+	resource := loadTestResource(t, db)
+	assert.Equal(t, models.ResourceStatusTerminated, resource.Status)
+	require.NotNil(t, resource.TerminatedAt)
+	assert.Equal(t, 1, provisioner.terminated["resource-1"])
+}
@@ -0,0 +1,93 @@
+package temporal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func aggregateSteps(t *testing.T, results []StepResult) *WorkflowResult {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+	env.RegisterActivity(activities.AggregateResultsActivity)
+
+	value, err := env.ExecuteActivity(activities.AggregateResultsActivity, results)
+	require.NoError(t, err)
+	var result WorkflowResult
+	require.NoError(t, value.Get(&result))
+	return &result
+}
+
+func TestAggregateResultsActivity_CompletedStepsSucceed(t *testing.T) {
+	// Step results recorded as "completed" used to make every intent
+	// workflow report completed_with_errors
+	var results []StepResult
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"step_id": "step-1", "status": "completed", "output": {"ok": true}},
+		{"step_id": "step-2", "status": "succeeded", "output": {"ok": true}}
+	]`), &results))
+
+	result := aggregateSteps(t, results)
+	assert.Equal(t, "completed", result.Status)
+	assert.Len(t, result.Results, 2)
+
+	results = append(results, StepResult{StepID: "step-3", Status: models.ExecutionStatusFailed, Error: "boom"})
+	assert.Equal(t, "completed_with_errors", aggregateSteps(t, results).Status)
+}
+
+func TestTaskExecutionWorkflow_NormalizesAgentStatuses(t *testing.T) {
+	wf := simulatedTaskWorkflow(t)
+	wf.Config = json.RawMessage(`{"simulation": true, "simulation_fixtures": {
+		"backend": {"status": "Success"},
+		"frontend": {"status": "done"}
+	}}`)
+
+	result := runSimulatedTaskWorkflow(t, wf)
+	assert.Equal(t, "completed", result.Status)
+	assert.Equal(t, 2, result.SuccessfulTasks)
+	for _, task := range result.TaskResults {
+		assert.Equal(t, models.ExecutionStatusSucceeded, task.Status)
+	}
+
+	// Statuses nobody recognizes count as failures
+	wf.Config = json.RawMessage(`{"simulation": true, "simulation_fixtures": {"frontend": {"status": "exploded"}}}`)
+	result = runSimulatedTaskWorkflow(t, wf)
+	assert.Equal(t, "partial_success", result.Status)
+	assert.Equal(t, models.ExecutionStatusFailed, result.TaskResults[1].Status)
+}
+
+func TestTaskExecutionResult_DecodesLegacyStatuses(t *testing.T) {
+	tests := []struct {
+		stored   string
+		expected models.ExecutionStatus
+		success  bool
+	}{
+		{"completed", models.ExecutionStatusSucceeded, true},
+		{"succeeded", models.ExecutionStatusSucceeded, true},
+		{"SUCCESS", models.ExecutionStatusSucceeded, true},
+		{"failed", models.ExecutionStatusFailed, false},
+		{"canceled", models.ExecutionStatusCancelled, false},
+		{"below_threshold", models.ExecutionStatusBelowThreshold, false},
+		{"mystery", "mystery", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stored, func(t *testing.T) {
+			var result TaskExecutionResult
+			require.NoError(t, json.Unmarshal([]byte(`{"task_id": "task-1", "status": "`+tt.stored+`"}`), &result))
+			assert.Equal(t, tt.expected, result.Status)
+			assert.Equal(t, tt.success, result.Status.IsSuccess())
+
+			// Re-encoding writes the canonical status
+			encoded, err := json.Marshal(result)
+			require.NoError(t, err)
+			assert.Contains(t, string(encoded), `"status":"`+string(tt.expected)+`"`)
+		})
+	}
+}
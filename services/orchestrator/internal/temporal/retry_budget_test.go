@@ -0,0 +1,113 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// failingDependency counts the calls made to an agent layer that keeps
+// failing
+type failingDependency struct {
+	mu              sync.Mutex
+	findCalls       int
+	executeCalls    int
+	selectionFailed bool // Agent selection fails as well as execution
+}
+
+func (f *failingDependency) find(ctx context.Context, task Task, sim *services.SimulationConfig, hints AgentSelectionHints) (*AgentInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.findCalls++
+	if f.selectionFailed {
+		return nil, errors.New("agent manager unavailable")
+	}
+	return &AgentInfo{ID: "backend-1", Type: "backend"}, nil
+}
+
+func (f *failingDependency) execute(ctx context.Context, task Task, agent AgentInfo, sim *services.SimulationConfig) (*TaskExecutionResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.executeCalls++
+	return nil, errors.New("agent unavailable")
+}
+
+// runRetryBudgetWorkflow runs a task execution workflow of the given tasks
+// and config against the failing dependency
+func runRetryBudgetWorkflow(t *testing.T, dependency *failingDependency, taskCount int, config string) (AggregatedTaskResult, services.WorkflowState) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+	env.RegisterWorkflow(engine.TaskExecutionWorkflow)
+	env.RegisterActivityWithOptions(dependency.find, activity.RegisterOptions{Name: ActivityMetaAgentFindOrCreateAgentForTask})
+	env.RegisterActivityWithOptions(dependency.execute, activity.RegisterOptions{Name: ActivityMetaAgentExecuteTaskWithAgent})
+	env.RegisterActivity(activities.AggregateTaskResultsActivity)
+	env.RegisterActivity(activities.StoreArtifactsActivity)
+
+	var aggregated AggregatedTaskResult
+	env.SetOnActivityCompletedListener(func(info *activity.Info, result converter.EncodedValue, err error) {
+		if info.ActivityType.Name == "AggregateTaskResultsActivity" {
+			require.NoError(t, result.Get(&aggregated))
+		}
+	})
+
+	tasks := make([]Task, taskCount)
+	for i := range tasks {
+		tasks[i] = Task{ID: fmt.Sprintf("task-%04d", i+1), Type: "backend", Title: "Orders API"}
+	}
+	input, err := json.Marshal(TaskExecutionInput{ProjectID: "project-1", Tasks: tasks})
+	require.NoError(t, err)
+
+	env.ExecuteWorkflow(engine.TaskExecutionWorkflow, &models.Workflow{ID: testWorkflowID, Input: input, Config: json.RawMessage(config)})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	return aggregated, queryState(t, env)
+}
+
+func TestTaskExecutionWorkflow_RetryBudgetBoundsTaskRetries(t *testing.T) {
+	dependency := &failingDependency{}
+	aggregated, state := runRetryBudgetWorkflow(t, dependency, 2, `{"retry_budget": {"max_retries": 1}}`)
+
+	// The first task is retried once; the second one is not retried at all.
+	// Each fails once its next retry finds the budget spent.
+	assert.Equal(t, 3, dependency.executeCalls)
+	require.Len(t, aggregated.TaskResults, 2)
+	for _, result := range aggregated.TaskResults {
+		assert.Equal(t, models.ExecutionStatusFailed, result.Status)
+		assert.Contains(t, result.Error, "retry budget exhausted: 1 of 1 retries used")
+		assert.Contains(t, result.Error, "retryable: false")
+		assert.Contains(t, result.Error, "agent unavailable")
+	}
+
+	require.NotNil(t, state.RetryBudget)
+	assert.Equal(t, 1, state.RetryBudget.Limit)
+	assert.Equal(t, 1, state.RetryBudget.Used)
+}
+
+func TestTaskExecutionWorkflow_RetryBudgetCoversTemporalRetries(t *testing.T) {
+	dependency := &failingDependency{selectionFailed: true}
+	aggregated, state := runRetryBudgetWorkflow(t, dependency, 3,
+		`{"activities": {"max_attempts": 5}, "retry_budget": {"max_retries": 2}}`)
+
+	// Temporal's retries of agent selection are capped at the budget, and
+	// once spent no task's selection is retried: one attempt per task plus
+	// the two retries of the budget
+	assert.Equal(t, 3+2, dependency.findCalls)
+	assert.Zero(t, dependency.executeCalls)
+	require.Len(t, aggregated.TaskResults, 3)
+	assert.Equal(t, 2, state.RetryBudget.Used)
+}
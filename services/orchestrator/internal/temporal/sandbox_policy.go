@@ -0,0 +1,49 @@
+package temporal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// sandboxPolicyViolationError is the type of the error a task fails with
+// when the sandbox policy of its workflow does not allow its type
+const sandboxPolicyViolationError = "SandboxPolicyViolation"
+
+// sandboxPolicy returns the sandbox policy a task execution workflow was
+// started under, nil when it was started without one
+func sandboxPolicy(wf *models.Workflow) *models.SandboxPolicy {
+	if len(wf.Metadata) == 0 {
+		return nil
+	}
+	var metadata map[string]json.RawMessage
+	if err := json.Unmarshal(wf.Metadata, &metadata); err != nil {
+		return nil
+	}
+	raw, ok := metadata[services.SandboxPolicyMetadataKey]
+	if !ok {
+		return nil
+	}
+	var policy models.SandboxPolicy
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return nil
+	}
+	return &policy
+}
+
+// sandboxRejection is the result of a task the sandbox policy does not
+// allow, failed without running
+func sandboxRejection(task Task, policy *models.SandboxPolicy) TaskExecutionResult {
+	message := fmt.Sprintf("task type %q is not allowed by the sandbox policy, allowed types: %s",
+		task.Type, strings.Join(policy.AllowedTaskTypes, ", "))
+	return TaskExecutionResult{
+		TaskID: task.ID,
+		Status: models.ExecutionStatusFailed,
+		Error:  temporal.NewNonRetryableApplicationError(message, sandboxPolicyViolationError, nil).Error(),
+	}
+}
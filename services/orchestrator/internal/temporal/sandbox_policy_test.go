@@ -0,0 +1,45 @@
+package temporal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"orchestrator/internal/models"
+)
+
+func TestTaskExecutionWorkflow_RejectsTasksOutsideSandboxPolicy(t *testing.T) {
+	wf := simulatedTaskWorkflow(t)
+	wf.Metadata = json.RawMessage(`{"sandbox_policy": {"allowed_task_types": ["backend"]}}`)
+
+	result := runSimulatedTaskWorkflow(t, wf)
+	require.Len(t, result.TaskResults, 2)
+	assert.Equal(t, 1, result.SuccessfulTasks)
+	assert.Equal(t, 1, result.FailedTasks)
+	assert.Equal(t, "stub-agent-backend", result.TaskResults[0].AgentID)
+
+	// The frontend task fails with a policy error, without an agent ever
+	// being selected for it
+	rejected := result.TaskResults[1]
+	assert.Equal(t, "task-ui", rejected.TaskID)
+	assert.Equal(t, models.ExecutionStatusFailed, rejected.Status)
+	assert.Empty(t, rejected.AgentID)
+	assert.Empty(t, rejected.Artifacts)
+	assert.Contains(t, rejected.Error, `task type "frontend" is not allowed by the sandbox policy, allowed types: backend`)
+	assert.Contains(t, rejected.Error, sandboxPolicyViolationError)
+}
+
+func TestSandboxPolicy_FromMetadata(t *testing.T) {
+	assert.Nil(t, sandboxPolicy(&models.Workflow{}))
+	assert.Nil(t, sandboxPolicy(&models.Workflow{Metadata: json.RawMessage(`{"bypass_cache": true}`)}))
+
+	// Workflows started without a policy run every task
+	assert.True(t, sandboxPolicy(&models.Workflow{}).AllowsTaskType("anything"))
+
+	policy := sandboxPolicy(&models.Workflow{Metadata: json.RawMessage(`{"sandbox_policy": {"allowed_task_types": ["testing"]}}`)})
+	require.NotNil(t, policy)
+	assert.True(t, policy.AllowsTaskType("testing"))
+	assert.False(t, policy.AllowsTaskType("deployment"))
+}
@@ -0,0 +1,75 @@
+package temporal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/operatorservice/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+// registerSearchAttributes adds the orchestrator's custom search attributes
+// to the namespace. Attributes that already exist are left alone. Clusters
+// that forbid registration, e.g. Temporal Cloud, are logged and skipped; the
+// attributes then have to be created by an operator.
+func registerSearchAttributes(ctx context.Context, operator operatorservice.OperatorServiceClient, namespace string, logger *zap.Logger) error {
+	existing, err := operator.ListSearchAttributes(ctx, &operatorservice.ListSearchAttributesRequest{
+		Namespace: namespace,
+	})
+	if err != nil {
+		if isPermissionDenied(err) {
+			logger.Warn("Not allowed to list search attributes, skipping registration", zap.Error(err))
+			return nil
+		}
+		return fmt.Errorf("failed to list search attributes: %w", err)
+	}
+
+	missing := make(map[string]enumspb.IndexedValueType)
+	for name, valueType := range services.SearchAttributeTypes {
+		if _, ok := existing.GetCustomAttributes()[name]; ok {
+			continue
+		}
+		missing[name] = valueType
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	_, err = operator.AddSearchAttributes(ctx, &operatorservice.AddSearchAttributesRequest{
+		Namespace:        namespace,
+		SearchAttributes: missing,
+	})
+	var alreadyExists *serviceerror.AlreadyExists
+	switch {
+	case err == nil:
+	case isPermissionDenied(err):
+		logger.Warn("Not allowed to register search attributes, skipping registration", zap.Error(err))
+		return nil
+	case errors.As(err, &alreadyExists):
+		// Registered concurrently by another instance
+	default:
+		return fmt.Errorf("failed to add search attributes: %w", err)
+	}
+
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	logger.Info("Registered search attributes",
+		zap.String("namespace", namespace),
+		zap.Strings("attributes", names),
+	)
+	return nil
+}
+
+func isPermissionDenied(err error) bool {
+	var denied *serviceerror.PermissionDenied
+	return errors.As(err, &denied)
+}
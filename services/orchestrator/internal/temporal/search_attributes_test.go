@@ -0,0 +1,57 @@
+package temporal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/operatorservice/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"orchestrator/internal/services"
+)
+
+// fakeOperatorService records search attribute registrations
+type fakeOperatorService struct {
+	operatorservice.OperatorServiceClient
+	existing map[string]enumspb.IndexedValueType
+	addErr   error
+	added    map[string]enumspb.IndexedValueType
+}
+
+func (f *fakeOperatorService) ListSearchAttributes(ctx context.Context, req *operatorservice.ListSearchAttributesRequest, opts ...grpc.CallOption) (*operatorservice.ListSearchAttributesResponse, error) {
+	return &operatorservice.ListSearchAttributesResponse{CustomAttributes: f.existing}, nil
+}
+
+func (f *fakeOperatorService) AddSearchAttributes(ctx context.Context, req *operatorservice.AddSearchAttributesRequest, opts ...grpc.CallOption) (*operatorservice.AddSearchAttributesResponse, error) {
+	if f.addErr != nil {
+		return nil, f.addErr
+	}
+	f.added = req.SearchAttributes
+	return &operatorservice.AddSearchAttributesResponse{}, nil
+}
+
+func TestRegisterSearchAttributes(t *testing.T) {
+	ctx := context.Background()
+
+	// Only missing attributes are added
+	operator := &fakeOperatorService{existing: map[string]enumspb.IndexedValueType{
+		services.SearchAttributeTags: enumspb.INDEXED_VALUE_TYPE_KEYWORD_LIST,
+	}}
+	require.NoError(t, registerSearchAttributes(ctx, operator, "default", zap.NewNop()))
+	assert.Len(t, operator.added, len(services.SearchAttributeTypes)-1)
+	assert.NotContains(t, operator.added, services.SearchAttributeTags)
+	assert.Equal(t, enumspb.INDEXED_VALUE_TYPE_KEYWORD, operator.added[services.SearchAttributeProjectID])
+
+	// Forbidden registration is skipped
+	operator = &fakeOperatorService{addErr: serviceerror.NewPermissionDenied("forbidden", "")}
+	assert.NoError(t, registerSearchAttributes(ctx, operator, "default", zap.NewNop()))
+
+	// Other failures are reported
+	operator = &fakeOperatorService{addErr: serviceerror.NewUnavailable("down")}
+	assert.Error(t, registerSearchAttributes(ctx, operator, "default", zap.NewNop()))
+}
@@ -0,0 +1,51 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+)
+
+// securityAnalysisUnavailableError is the type of the non-retryable error
+// returned when no security scanner is configured
+const securityAnalysisUnavailableError = "SecurityAnalysisUnavailable"
+
+// RunSecurityAnalysisActivity checks the dependencies of the fetched code
+// against OSV and its content for secrets, and scores the findings. Checks
+// that cannot complete, e.g. while OSV is unreachable, mark the result
+// degraded rather than failing the workflow.
+func (a *Activities) RunSecurityAnalysisActivity(ctx context.Context, code CodeData) (*SecurityAnalysisResult, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Running security analysis", zap.Int("files", len(code.Files)))
+
+	if a.scanners == nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			"security analysis cannot run: scanners are not configured", securityAnalysisUnavailableError, nil)
+	}
+
+	report, err := a.scanners.Analyze(ctx, code.Content)
+	if err != nil {
+		return nil, err
+	}
+	if report.Degraded {
+		logger.Warn("Security analysis degraded", zap.Strings("reasons", report.DegradedReasons))
+	}
+
+	vulnerabilities := make([]interface{}, len(report.Findings))
+	for i, finding := range report.Findings {
+		vulnerabilities[i] = finding
+	}
+	result := &SecurityAnalysisResult{
+		Vulnerabilities: vulnerabilities,
+		RiskScore:       report.RiskScore,
+		Recommendations: report.Recommendations,
+		Degraded:        report.Degraded,
+		DegradedReasons: report.DegradedReasons,
+	}
+
+	activity.RecordHeartbeat(ctx, fmt.Sprintf("Security analysis found %d issues", len(report.Findings)))
+	return result, nil
+}
@@ -0,0 +1,24 @@
+package temporal
+
+import (
+	"orchestrator/internal/services"
+)
+
+// agentExecutorFor returns the executor an agent-facing activity should use:
+// a stub client answering from the workflow's fixtures when it runs in
+// simulation mode, the real client otherwise
+func agentExecutorFor(client services.AgentExecutor, sim *services.SimulationConfig) services.AgentExecutor {
+	if sim != nil {
+		return services.NewStubAgentClient(sim.Fixtures)
+	}
+	return client
+}
+
+// intentProcessorFor returns the Intent Processor client an intent activity
+// should use, picked like agentExecutorFor
+func intentProcessorFor(client *services.IntentClient, sim *services.SimulationConfig) services.IntentProcessor {
+	if sim != nil {
+		return services.NewStubIntentClient(sim.Fixtures)
+	}
+	return client
+}
@@ -0,0 +1,165 @@
+package temporal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func simulatedTaskWorkflow(t *testing.T) *models.Workflow {
+	input, err := json.Marshal(TaskExecutionInput{
+		ProjectID: "project-1",
+		Tasks: []Task{
+			{ID: "task-api", Title: "Orders API", Type: "backend", EstimatedHours: 1},
+			{ID: "task-ui", Title: "Orders page", Type: "frontend", EstimatedHours: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	config, err := json.Marshal(map[string]interface{}{
+		"simulation": true,
+		"simulation_fixtures": services.StubFixtures{
+			"backend": {
+				Output: map[string]interface{}{"summary": "orders service"},
+				Artifacts: []services.StubArtifact{
+					{Name: "orders.go", Type: "code", Content: "package orders", Path: "internal/orders/orders.go"},
+					{Name: "orders_test.go", Type: "test", Content: "package orders", Path: "internal/orders/orders_test.go"},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	return &models.Workflow{ID: "wf-sim", Type: models.WorkflowTypeTaskExecution, Input: input, Config: config, Simulated: true}
+}
+
+// runSimulatedTaskWorkflow runs the task execution workflow with real
+// activities and no agent client, returning the aggregated result
+func runSimulatedTaskWorkflow(t *testing.T, wf *models.Workflow, interceptors ...interceptor.WorkerInterceptor) AggregatedTaskResult {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: interceptors})
+	engine := NewWorkflowEngine(zap.NewNop())
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+	metaAgentActivities := NewMetaAgentActivities(nil, zap.NewNop())
+
+	env.RegisterWorkflow(engine.TaskExecutionWorkflow)
+	env.RegisterActivity(activities.AggregateTaskResultsActivity)
+	env.RegisterActivity(activities.StoreArtifactsActivity)
+	env.RegisterActivityWithOptions(metaAgentActivities.FindOrCreateAgentForTaskActivity,
+		activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+	env.RegisterActivityWithOptions(metaAgentActivities.ExecuteTaskWithAgentActivity,
+		activity.RegisterOptions{Name: "MetaAgentExecuteTaskWithAgentActivity"})
+
+	var aggregated AggregatedTaskResult
+	env.SetOnActivityCompletedListener(func(info *activity.Info, result converter.EncodedValue, err error) {
+		if info.ActivityType.Name == "AggregateTaskResultsActivity" {
+			require.NoError(t, result.Get(&aggregated))
+		}
+	})
+
+	env.ExecuteWorkflow(engine.TaskExecutionWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	return aggregated
+}
+
+func TestTaskExecutionWorkflow_SimulationUsesStubAgents(t *testing.T) {
+	wf := simulatedTaskWorkflow(t)
+
+	first := runSimulatedTaskWorkflow(t, wf)
+	assert.Equal(t, 2, first.TotalTasks)
+	assert.Equal(t, 2, first.SuccessfulTasks)
+	require.Len(t, first.TaskResults, 2)
+
+	// Each task type gets its own stub agent spawned through the meta-agent
+	backend, frontend := first.TaskResults[0], first.TaskResults[1]
+	assert.Equal(t, "stub-agent-backend", backend.AgentID)
+	assert.Equal(t, "stub-agent-frontend", frontend.AgentID)
+
+	// The backend fixture replaces the canned artifacts; frontend falls back
+	// to the built-in response
+	assert.Equal(t, "orders service", backend.Output["summary"])
+	require.Len(t, backend.Artifacts, 2)
+	assert.Equal(t, "orders.go", backend.Artifacts[0].Name)
+	assert.Equal(t, "internal/orders/orders_test.go", backend.Artifacts[1].Path)
+	require.Len(t, frontend.Artifacts, 1)
+	assert.Equal(t, "task-ui.md", frontend.Artifacts[0].Name)
+
+	// A second run produces the same outputs
+	second := runSimulatedTaskWorkflow(t, wf)
+	require.Len(t, second.TaskResults, len(first.TaskResults))
+	for i := range first.TaskResults {
+		assert.Equal(t, first.TaskResults[i].AgentID, second.TaskResults[i].AgentID)
+		assert.Equal(t, first.TaskResults[i].Status, second.TaskResults[i].Status)
+		assert.Equal(t, stripArtifactTimes(first.TaskResults[i].Artifacts), stripArtifactTimes(second.TaskResults[i].Artifacts))
+	}
+}
+
+func TestTaskExecutionWorkflow_SimulationFixtureError(t *testing.T) {
+	wf := simulatedTaskWorkflow(t)
+	wf.Config = json.RawMessage(`{"simulation": true, "simulation_fixtures": {"frontend": {"error": "renderer crashed"}}}`)
+
+	result := runSimulatedTaskWorkflow(t, wf)
+	assert.Equal(t, 1, result.SuccessfulTasks)
+	assert.Equal(t, 1, result.FailedTasks)
+	assert.Contains(t, result.TaskResults[1].Error, "renderer crashed")
+}
+
+func TestCodeExecutionWorkflow_Simulation(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+
+	env.RegisterWorkflow(engine.CodeExecutionWorkflow)
+	for _, fn := range []interface{}{
+		activities.SelectAgentActivity, activities.PrepareEnvironmentActivity, activities.ExecuteCodeActivity,
+		activities.ProcessResultsActivity, activities.CleanupEnvironmentActivity,
+	} {
+		env.RegisterActivity(fn)
+	}
+
+	var processed ProcessedResult
+	env.SetOnActivityCompletedListener(func(info *activity.Info, result converter.EncodedValue, err error) {
+		if info.ActivityType.Name == "ProcessResultsActivity" {
+			require.NoError(t, result.Get(&processed))
+		}
+	})
+
+	input, err := json.Marshal(CodeExecutionRequest{Language: "python", Code: "print('hi')"})
+	require.NoError(t, err)
+	wf := &models.Workflow{
+		ID:     "wf-code-sim",
+		Type:   models.WorkflowTypeExecution,
+		Input:  input,
+		Config: json.RawMessage(`{"simulation": true, "simulation_fixtures": {"execute_code": {"output": {"output": "hi"}}}}`),
+	}
+
+	env.ExecuteWorkflow(engine.CodeExecutionWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	assert.True(t, processed.Success)
+	assert.Equal(t, "hi", processed.Data["output"])
+}
+
+func stripArtifactTimes(artifacts []Artifact) []Artifact {
+	stripped := make([]Artifact, len(artifacts))
+	for i, artifact := range artifacts {
+		artifact.CreatedAt = time.Time{}
+		stripped[i] = artifact
+	}
+	return stripped
+}
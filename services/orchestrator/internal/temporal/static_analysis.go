@@ -0,0 +1,64 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/services"
+)
+
+// staticAnalysisUnavailableError is the type of the non-retryable error
+// returned when no static analyzer is configured
+const staticAnalysisUnavailableError = "StaticAnalysisUnavailable"
+
+// RunStaticAnalysisActivity checks the fetched code with the analyzers
+// handling its files and estimates its complexity and maintainability.
+// Analyzers that are not installed or fail are reported as warning issues.
+func (a *Activities) RunStaticAnalysisActivity(ctx context.Context, code CodeData) (*StaticAnalysisResult, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Info("Running static analysis", zap.Int("files", len(code.Files)))
+
+	if a.analyzers == nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			"static analysis cannot run: analyzers are not configured", staticAnalysisUnavailableError, nil)
+	}
+
+	report, err := a.analyzers.Analyze(ctx, code.Content, func(analyzer string) {
+		recordProgress(ctx, 0, "static_analysis", fmt.Sprintf("Running %s", analyzer))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	issues := make([]interface{}, len(report.Issues))
+	errorCount, warningCount := 0, 0
+	for i, issue := range report.Issues {
+		issues[i] = issue
+		if issue.Severity == services.IssueSeverityError {
+			errorCount++
+		} else {
+			warningCount++
+		}
+	}
+	result := &StaticAnalysisResult{
+		Issues: issues,
+		Metrics: map[string]interface{}{
+			"files":           report.Metrics.Files,
+			"lines":           report.Metrics.Lines,
+			"functions":       report.Metrics.Functions,
+			"complexity":      report.Metrics.Complexity,
+			"maintainability": report.Metrics.Maintainability,
+			"errors":          errorCount,
+			"warnings":        warningCount,
+			"analyzers":       report.Analyzers,
+		},
+		Summary: fmt.Sprintf("Static analysis found %d errors and %d warnings", errorCount, warningCount),
+	}
+
+	activity.RecordHeartbeat(ctx, "Static analysis completed")
+	return result, nil
+}
@@ -0,0 +1,328 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// stepDispatchChange gates executing the steps of an execution plan in
+// dependency order with operator overrides; runs started before executed
+// only the steps without dependencies
+const stepDispatchChange = "step-dispatch"
+
+// invalidPlanError is the type of the non-retryable error returned for plans
+// whose dependencies cannot be satisfied
+const invalidPlanError = "InvalidExecutionPlan"
+
+// StepRecord is how a step of an execution plan ended, recorded as a
+// workflow step
+type StepRecord struct {
+	StepID       string                            `json:"step_id"`
+	Name         string                            `json:"name"`
+	Type         string                            `json:"type"`
+	Order        int                               `json:"order"`
+	Status       models.WorkflowStatus             `json:"status"`
+	Inputs       map[string]map[string]interface{} `json:"inputs,omitempty"`
+	Output       map[string]interface{}            `json:"output,omitempty"`
+	Error        string                            `json:"error,omitempty"`
+	OverriddenBy string                            `json:"overridden_by,omitempty"`
+	CompletedAt  time.Time                         `json:"completed_at"`
+}
+
+// stepDispatcher executes the steps of an execution plan, applying the
+// overrides operators send on services.StepOverrideSignal. Overrides of steps
+// not reached yet are held until the step is; overrides of finished steps
+// are ignored.
+type stepDispatcher struct {
+	ctx     workflow.Context
+	sim     *services.SimulationConfig
+	signals workflow.ReceiveChannel
+	planned map[string]bool
+	pending map[string]services.StepOverride
+	// outputs of the finished steps, by step ID
+	outputs map[string]map[string]interface{}
+}
+
+// dispatchSteps executes the steps of a plan once the steps they depend on
+// finished, one at a time, giving each the outputs of its dependencies
+func dispatchSteps(ctx workflow.Context, progress *workflowProgress, steps []ExecutionStep, sim *services.SimulationConfig) ([]StepResult, error) {
+	ordered, err := dispatchOrder(steps)
+	if err != nil {
+		return nil, err
+	}
+	d := &stepDispatcher{
+		ctx:     ctx,
+		sim:     sim,
+		signals: workflow.GetSignalChannel(ctx, services.StepOverrideSignal),
+		planned: make(map[string]bool, len(steps)),
+		pending: make(map[string]services.StepOverride),
+		outputs: make(map[string]map[string]interface{}, len(steps)),
+	}
+	for _, step := range steps {
+		d.planned[step.ID] = true
+	}
+
+	results := make([]StepResult, 0, len(ordered))
+	for i, step := range ordered {
+		result, err := d.dispatch(step, i+1)
+		if err != nil {
+			return nil, err
+		}
+		if result.Error != "" {
+			progress.recordError(result.Error)
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// planSteps returns what plan validation looks at of the steps of a plan
+func planSteps(steps []ExecutionStep) []services.PlanStep {
+	planned := make([]services.PlanStep, len(steps))
+	for i, step := range steps {
+		planned[i] = services.PlanStep{ID: step.ID, DependsOn: step.DependsOn, StepActivitySettings: step.StepActivitySettings}
+	}
+	return planned
+}
+
+// dispatchOrder orders the steps of a plan after the steps they depend on,
+// keeping the order of the plan otherwise. Steps with inconsistent activity
+// settings make the plan invalid.
+func dispatchOrder(steps []ExecutionStep) ([]ExecutionStep, error) {
+	planned := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		if planned[step.ID] {
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("execution plan has two steps %s", step.ID), invalidPlanError, nil)
+		}
+		planned[step.ID] = true
+		if err := step.StepActivitySettings.Validate(services.StepActivityLimits{}); err != nil {
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("step %s: %v", step.ID, err), invalidPlanError, nil)
+		}
+	}
+	for _, step := range steps {
+		for _, dependency := range step.DependsOn {
+			if !planned[dependency] {
+				return nil, temporal.NewNonRetryableApplicationError(
+					fmt.Sprintf("step %s depends on unknown step %s", step.ID, dependency), invalidPlanError, nil)
+			}
+		}
+	}
+
+	ordered := make([]ExecutionStep, 0, len(steps))
+	placed := make(map[string]bool, len(steps))
+	for len(ordered) < len(steps) {
+		progressed := false
+		for _, step := range steps {
+			if placed[step.ID] || !allPlaced(step.DependsOn, placed) {
+				continue
+			}
+			ordered = append(ordered, step)
+			placed[step.ID] = true
+			progressed = true
+		}
+		if !progressed {
+			var cycle []string
+			for _, step := range steps {
+				if !placed[step.ID] {
+					cycle = append(cycle, step.ID)
+				}
+			}
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("steps %s depend on each other", strings.Join(cycle, ", ")), invalidPlanError, nil)
+		}
+	}
+	return ordered, nil
+}
+
+func allPlaced(ids []string, placed map[string]bool) bool {
+	for _, id := range ids {
+		if !placed[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch executes a step unless an override of it arrived, abandoning the
+// execution when one arrives while it runs
+func (d *stepDispatcher) dispatch(step ExecutionStep, order int) (*StepResult, error) {
+	step.Inputs = d.inputs(step)
+	d.drain()
+	if override, ok := d.pending[step.ID]; ok {
+		delete(d.pending, step.ID)
+		return d.override(step, order, override), nil
+	}
+
+	options, err := stepActivityOptions(workflow.GetActivityOptions(d.ctx), step.StepActivitySettings)
+	if err != nil {
+		return nil, err
+	}
+	activityCtx, cancel := workflow.WithCancel(workflow.WithActivityOptions(d.ctx, options))
+	defer cancel()
+	future := workflow.ExecuteActivity(activityCtx, ActivityExecuteStep, step, d.sim)
+
+	var result StepResult
+	var stepErr error
+	var override *services.StepOverride
+	done := false
+	for !done && override == nil {
+		selector := workflow.NewSelector(d.ctx)
+		selector.AddFuture(future, func(f workflow.Future) {
+			stepErr = f.Get(d.ctx, &result)
+			done = true
+		})
+		selector.AddReceive(d.signals, func(c workflow.ReceiveChannel, more bool) {
+			var received services.StepOverride
+			c.Receive(d.ctx, &received)
+			override = d.receive(received, step.ID)
+		})
+		selector.Select(d.ctx)
+	}
+	if override != nil {
+		cancel()
+		return d.override(step, order, *override), nil
+	}
+	if stepErr != nil {
+		return nil, fmt.Errorf("step execution failed: %w", stepErr)
+	}
+
+	status := models.WorkflowStatusCompleted
+	if result.Error != "" {
+		status = models.WorkflowStatusFailed
+	}
+	d.finish(step, order, StepRecord{Status: status, Output: result.Output, Error: result.Error})
+	return &result, nil
+}
+
+// drain takes the overrides received since the last step
+func (d *stepDispatcher) drain() {
+	for {
+		var received services.StepOverride
+		if !d.signals.ReceiveAsync(&received) {
+			return
+		}
+		d.receive(received, "")
+	}
+}
+
+// receive returns an override of the current step, holds overrides of steps
+// not reached yet and ignores the rest
+func (d *stepDispatcher) receive(override services.StepOverride, current string) *services.StepOverride {
+	logger := workflow.GetLogger(d.ctx)
+	_, finished := d.outputs[override.StepID]
+	switch {
+	case override.Action != services.StepOverrideSkip && override.Action != services.StepOverrideComplete:
+		logger.Warn("Ignoring step override with unknown action", "stepID", override.StepID, "action", override.Action)
+	case !d.planned[override.StepID]:
+		logger.Warn("Ignoring override of a step not in the plan", "stepID", override.StepID, "actor", override.Actor)
+	case finished:
+		logger.Warn("Ignoring override of a finished step", "stepID", override.StepID, "actor", override.Actor)
+	case override.StepID == current:
+		return &override
+	default:
+		d.pending[override.StepID] = override
+	}
+	return nil
+}
+
+// override finishes a step as the operator asked: skipped, or completed with
+// the output they supplied
+func (d *stepDispatcher) override(step ExecutionStep, order int, override services.StepOverride) *StepResult {
+	result := &StepResult{StepID: step.ID, Status: models.ExecutionStatusSucceeded, Output: override.Output}
+	status := models.WorkflowStatusCompletedManually
+	if override.Action == services.StepOverrideSkip {
+		result.Status = models.ExecutionStatusSkipped
+		result.Output = nil
+		status = models.WorkflowStatusSkipped
+	}
+	workflow.GetLogger(d.ctx).Info("Step overridden by operator",
+		"stepID", step.ID, "action", override.Action, "actor", override.Actor)
+	d.finish(step, order, StepRecord{Status: status, Output: result.Output, OverriddenBy: override.Actor})
+	return result
+}
+
+// finish records how a step ended and keeps its output for its dependents.
+// The record is for the API; failing to record it does not fail the step.
+func (d *stepDispatcher) finish(step ExecutionStep, order int, record StepRecord) {
+	d.outputs[step.ID] = record.Output
+	record.StepID = step.ID
+	record.Name = step.Name
+	record.Type = step.Type
+	record.Order = order
+	record.Inputs = step.Inputs
+	record.CompletedAt = workflow.Now(d.ctx)
+	if err := workflow.ExecuteActivity(d.ctx, ActivityRecordWorkflowStep, record).Get(d.ctx, nil); err != nil {
+		workflow.GetLogger(d.ctx).Error("Failed to record workflow step", "stepID", step.ID, "error", err)
+	}
+}
+
+// inputs returns the outputs of the steps a step depends on, by step ID
+func (d *stepDispatcher) inputs(step ExecutionStep) map[string]map[string]interface{} {
+	var inputs map[string]map[string]interface{}
+	for _, dependency := range step.DependsOn {
+		output := d.outputs[dependency]
+		if output == nil {
+			continue
+		}
+		if inputs == nil {
+			inputs = make(map[string]map[string]interface{}, len(step.DependsOn))
+		}
+		inputs[dependency] = output
+	}
+	return inputs
+}
+
+// RecordWorkflowStepActivity records how a step of the workflow's execution
+// plan ended. Records of a step are replaced, so retries are harmless.
+func (a *Activities) RecordWorkflowStepActivity(ctx context.Context, record StepRecord) error {
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+	step := models.WorkflowStep{
+		ID:           workflowStepID(workflowID, record.StepID),
+		WorkflowID:   workflowID,
+		StepID:       record.StepID,
+		Name:         record.Name,
+		Type:         record.Type,
+		Order:        record.Order,
+		Status:       record.Status,
+		Error:        record.Error,
+		OverriddenBy: record.OverriddenBy,
+		CompletedAt:  &record.CompletedAt,
+	}
+	if record.Inputs != nil {
+		input, err := json.Marshal(record.Inputs)
+		if err != nil {
+			return fmt.Errorf("failed to marshal step inputs: %w", err)
+		}
+		step.Input = input
+	}
+	if record.Output != nil {
+		output, err := json.Marshal(record.Output)
+		if err != nil {
+			return fmt.Errorf("failed to marshal step output: %w", err)
+		}
+		step.Output = output
+	}
+	if err := a.db.WithContext(ctx).Save(&step).Error; err != nil {
+		return fmt.Errorf("failed to record workflow step: %w", err)
+	}
+	return nil
+}
+
+// workflowStepID derives the ID of the record of a step from the workflow
+// and the ID of the step in its plan
+func workflowStepID(workflowID, stepID string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("step:"+workflowID+"/"+stepID)).String()
+}
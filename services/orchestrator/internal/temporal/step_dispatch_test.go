@@ -0,0 +1,263 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// overrideTestPlan has a step fetching credentials, a step provisioning an
+// internal system with them and a step configuring what was provisioned
+var overrideTestPlan = ExecutionPlan{Steps: []ExecutionStep{
+	{ID: "configure", Name: "Configure", Type: "action", DependsOn: []string{"provision"}},
+	{ID: "fetch", Name: "Fetch credentials", Type: "query"},
+	{ID: "provision", Name: "Provision", Type: "action", DependsOn: []string{"fetch"}},
+}}
+
+// dispatchRun is what a run of the intent processing workflow did with its
+// plan
+type dispatchRun struct {
+	executed   []ExecutionStep // Steps started
+	aggregated []StepResult
+	result     *WorkflowResult
+}
+
+// stepDispatchEnv runs an intent processing workflow of overrideTestPlan
+// whose provision step takes an hour, recording its steps in a database
+func stepDispatchEnv(t *testing.T) (*testsuite.TestWorkflowEnvironment, *gorm.DB, *dispatchRun) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	stmt := &gorm.Statement{DB: db}
+	require.NoError(t, stmt.Parse(&models.WorkflowStep{}))
+	for _, field := range stmt.Schema.Fields {
+		if field.DefaultValue == "gen_random_uuid()" {
+			field.HasDefaultValue = false
+			field.DefaultValue = ""
+		}
+	}
+	require.NoError(t, db.Migrator().CreateTable(&models.WorkflowStep{}))
+	activities := NewActivities(db, zap.NewNop(), nil, nil)
+
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.IntentProcessingWorkflow)
+	env.RegisterActivityWithOptions(func(ctx context.Context, intent IntentData) (*IntentAnalysisResult, error) {
+		return &IntentAnalysisResult{IntentType: "provisioning"}, nil
+	}, activity.RegisterOptions{Name: ActivityAnalyzeIntent})
+	env.RegisterActivityWithOptions(func(ctx context.Context, analysis IntentAnalysisResult) (*ExecutionPlan, error) {
+		return &overrideTestPlan, nil
+	}, activity.RegisterOptions{Name: ActivityCreateExecutionPlan})
+	env.RegisterActivityWithOptions(activities.RecordWorkflowStepActivity, activity.RegisterOptions{Name: ActivityRecordWorkflowStep})
+
+	run := &dispatchRun{}
+	env.RegisterActivityWithOptions(func(ctx context.Context, results []StepResult) (*WorkflowResult, error) {
+		run.aggregated = results
+		result, err := activities.AggregateResultsActivity(ctx, results)
+		run.result = result
+		return result, err
+	}, activity.RegisterOptions{Name: ActivityAggregateResults})
+
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		if info.ActivityType.Name == ActivityExecuteStep {
+			var step ExecutionStep
+			require.NoError(t, args.Get(&step))
+			run.executed = append(run.executed, step)
+		}
+	})
+	execute := func(ctx context.Context, step ExecutionStep, sim *services.SimulationConfig) (*StepResult, error) {
+		return &StepResult{
+			StepID: step.ID,
+			Status: models.ExecutionStatusSucceeded,
+			Output: map[string]interface{}{"by": step.ID},
+		}, nil
+	}
+	env.RegisterActivityWithOptions(execute, activity.RegisterOptions{Name: ActivityExecuteStep})
+	isProvision := mock.MatchedBy(func(step ExecutionStep) bool { return step.ID == "provision" })
+	env.OnActivity(ActivityExecuteStep, mock.Anything, isProvision, mock.Anything).Return(execute).After(time.Hour)
+	env.OnActivity(ActivityExecuteStep, mock.Anything, mock.Anything, mock.Anything).Return(execute)
+	return env, db, run
+}
+
+func intentWorkflow(t *testing.T) *models.Workflow {
+	input, err := json.Marshal(IntentData{Content: "provision the internal registry"})
+	require.NoError(t, err)
+	return &models.Workflow{ID: testWorkflowID, Type: models.WorkflowTypeIntent, Input: input}
+}
+
+func recordedSteps(t *testing.T, db *gorm.DB) map[string]models.WorkflowStep {
+	var steps []models.WorkflowStep
+	require.NoError(t, db.Where("workflow_id = ?", testWorkflowID).Find(&steps).Error)
+	byID := make(map[string]models.WorkflowStep, len(steps))
+	for _, step := range steps {
+		byID[step.StepID] = step
+	}
+	return byID
+}
+
+func executedIDs(steps []ExecutionStep) []string {
+	ids := make([]string, len(steps))
+	for i, step := range steps {
+		ids[i] = step.ID
+	}
+	return ids
+}
+
+func TestIntentProcessingWorkflow_DispatchesInDependencyOrder(t *testing.T) {
+	env, db, run := stepDispatchEnv(t)
+
+	env.ExecuteWorkflow("IntentProcessingWorkflow", intentWorkflow(t))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	require.Equal(t, []string{"fetch", "provision", "configure"}, executedIDs(run.executed))
+	assert.Nil(t, run.executed[0].Inputs)
+	assert.Equal(t, map[string]map[string]interface{}{"fetch": {"by": "fetch"}}, run.executed[1].Inputs)
+	assert.Equal(t, map[string]map[string]interface{}{"provision": {"by": "provision"}}, run.executed[2].Inputs)
+	assert.Len(t, run.aggregated, 3)
+
+	steps := recordedSteps(t, db)
+	require.Len(t, steps, 3)
+	for id, order := range map[string]int{"fetch": 1, "provision": 2, "configure": 3} {
+		assert.Equal(t, models.WorkflowStatusCompleted, steps[id].Status, id)
+		assert.Equal(t, order, steps[id].Order, id)
+		assert.Empty(t, steps[id].OverriddenBy, id)
+	}
+}
+
+func TestIntentProcessingWorkflow_SkipsStep(t *testing.T) {
+	env, db, run := stepDispatchEnv(t)
+	// Sent while the workflow is still planning, held until the step is reached
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(services.StepOverrideSignal, services.StepOverride{
+			StepID: "provision",
+			Action: services.StepOverrideSkip,
+			Actor:  "alice",
+		})
+	}, 0)
+
+	env.ExecuteWorkflow("IntentProcessingWorkflow", intentWorkflow(t))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// The skipped step never ran; its dependent ran without its output
+	require.Equal(t, []string{"fetch", "configure"}, executedIDs(run.executed))
+	assert.Nil(t, run.executed[1].Inputs)
+
+	require.Len(t, run.aggregated, 3)
+	assert.Equal(t, models.ExecutionStatusSkipped, run.aggregated[1].Status)
+	assert.Equal(t, "completed", run.result.Status, "skipped steps are not errors")
+
+	steps := recordedSteps(t, db)
+	assert.Equal(t, models.WorkflowStatusSkipped, steps["provision"].Status)
+	assert.Equal(t, "alice", steps["provision"].OverriddenBy)
+	assert.Equal(t, models.WorkflowStatusCompleted, steps["configure"].Status)
+}
+
+func TestIntentProcessingWorkflow_CompletesStepManually(t *testing.T) {
+	env, db, run := stepDispatchEnv(t)
+	// Sent while the provision step runs, which is abandoned
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(services.StepOverrideSignal, services.StepOverride{
+			StepID: "provision",
+			Action: services.StepOverrideComplete,
+			Output: map[string]interface{}{"registry_url": "https://registry.internal"},
+			Actor:  "alice",
+			Reason: "the agent cannot reach the internal network",
+		})
+	}, 10*time.Minute)
+
+	env.ExecuteWorkflow("IntentProcessingWorkflow", intentWorkflow(t))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// The manual output is piped to the dependent
+	require.Equal(t, []string{"fetch", "provision", "configure"}, executedIDs(run.executed))
+	assert.Equal(t, map[string]map[string]interface{}{
+		"provision": {"registry_url": "https://registry.internal"},
+	}, run.executed[2].Inputs)
+	require.Len(t, run.aggregated, 3)
+	assert.Equal(t, models.ExecutionStatusSucceeded, run.aggregated[1].Status)
+	assert.Equal(t, map[string]interface{}{"registry_url": "https://registry.internal"}, run.aggregated[1].Output)
+
+	steps := recordedSteps(t, db)
+	assert.Equal(t, models.WorkflowStatusCompletedManually, steps["provision"].Status)
+	assert.Equal(t, "alice", steps["provision"].OverriddenBy)
+	assert.JSONEq(t, `{"registry_url": "https://registry.internal"}`, string(steps["provision"].Output))
+	assert.JSONEq(t, `{"provision": {"registry_url": "https://registry.internal"}}`, string(steps["configure"].Input))
+}
+
+func TestIntentProcessingWorkflow_IgnoresOverrideOfFinishedStep(t *testing.T) {
+	env, db, run := stepDispatchEnv(t)
+	// Sent while the provision step runs, after the fetch step finished
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(services.StepOverrideSignal, services.StepOverride{
+			StepID: "fetch",
+			Action: services.StepOverrideComplete,
+			Output: map[string]interface{}{"token": "by-hand"},
+			Actor:  "alice",
+		})
+	}, 10*time.Minute)
+
+	env.ExecuteWorkflow("IntentProcessingWorkflow", intentWorkflow(t))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// Every step ran with the output it produced
+	require.Equal(t, []string{"fetch", "provision", "configure"}, executedIDs(run.executed))
+	assert.Equal(t, map[string]map[string]interface{}{"fetch": {"by": "fetch"}}, run.executed[1].Inputs)
+	assert.Equal(t, map[string]interface{}{"by": "fetch"}, run.aggregated[0].Output)
+
+	steps := recordedSteps(t, db)
+	assert.Equal(t, models.WorkflowStatusCompleted, steps["fetch"].Status)
+	assert.Empty(t, steps["fetch"].OverriddenBy)
+	assert.JSONEq(t, `{"by": "fetch"}`, string(steps["fetch"].Output))
+}
+
+func TestDispatchOrder_RejectsInvalidPlans(t *testing.T) {
+	_, err := dispatchOrder([]ExecutionStep{{ID: "a", DependsOn: []string{"missing"}}})
+	assert.ErrorContains(t, err, "step a depends on unknown step missing")
+
+	_, err = dispatchOrder([]ExecutionStep{
+		{ID: "a"},
+		{ID: "b", DependsOn: []string{"c"}},
+		{ID: "c", DependsOn: []string{"b"}},
+	})
+	assert.ErrorContains(t, err, "steps b, c depend on each other")
+
+	_, err = dispatchOrder([]ExecutionStep{{ID: "a"}, {ID: "a"}})
+	assert.ErrorContains(t, err, "two steps a")
+}
+
+func TestCreateExecutionPlanActivity_RejectsInvalidPlans(t *testing.T) {
+	activities := NewActivities(nil, zap.NewNop(), nil, nil)
+	activities.plans = services.NewPlanValidator(&config.PlansConfig{MaxSteps: 2, MaxDepth: 2})
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	_, err := env.ExecuteActivity("CreateExecutionPlanActivity", IntentAnalysisResult{Actions: []string{"build", "test", "deploy"}})
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "plan has 3 steps, more than the limit of 2")
+	var appErr *temporal.ApplicationError
+	require.ErrorAs(t, err, &appErr)
+	assert.Equal(t, invalidPlanError, appErr.Type())
+	assert.True(t, appErr.NonRetryable())
+}
@@ -0,0 +1,200 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// observedStep is what a step's activity saw of its options
+type observedStep struct {
+	timeout   time.Duration // Start-to-close timeout of the attempt
+	heartbeat time.Duration
+	attempts  int
+}
+
+// stepSettingsEnv runs an intent processing workflow of plan, failing the
+// attempts of steps fail returns an error for, and records the options of
+// the steps by step ID
+func stepSettingsEnv(t *testing.T, plan ExecutionPlan, fail func(step ExecutionStep, attempt int32) error) (*testsuite.TestWorkflowEnvironment, map[string]*observedStep) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.IntentProcessingWorkflow)
+	env.RegisterActivityWithOptions(func(ctx context.Context, intent IntentData) (*IntentAnalysisResult, error) {
+		return &IntentAnalysisResult{IntentType: "migration"}, nil
+	}, activity.RegisterOptions{Name: ActivityAnalyzeIntent})
+	env.RegisterActivityWithOptions(func(ctx context.Context, analysis IntentAnalysisResult) (*ExecutionPlan, error) {
+		return &plan, nil
+	}, activity.RegisterOptions{Name: ActivityCreateExecutionPlan})
+	env.RegisterActivityWithOptions(func(ctx context.Context, record StepRecord) error {
+		return nil
+	}, activity.RegisterOptions{Name: ActivityRecordWorkflowStep})
+	env.RegisterActivityWithOptions(func(ctx context.Context, results []StepResult) (*WorkflowResult, error) {
+		return &WorkflowResult{Status: "completed"}, nil
+	}, activity.RegisterOptions{Name: ActivityAggregateResults})
+
+	observed := make(map[string]*observedStep)
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		if info.ActivityType.Name != ActivityExecuteStep {
+			return
+		}
+		var step ExecutionStep
+		require.NoError(t, args.Get(&step))
+		if observed[step.ID] == nil {
+			observed[step.ID] = &observedStep{timeout: info.Deadline.Sub(info.StartedTime), heartbeat: info.HeartbeatTimeout}
+		}
+		observed[step.ID].attempts++
+	})
+	env.RegisterActivityWithOptions(func(ctx context.Context, step ExecutionStep, sim *services.SimulationConfig) (*StepResult, error) {
+		if fail != nil {
+			if err := fail(step, activity.GetInfo(ctx).Attempt); err != nil {
+				return nil, err
+			}
+		}
+		return &StepResult{StepID: step.ID, Status: models.ExecutionStatusSucceeded}, nil
+	}, activity.RegisterOptions{Name: ActivityExecuteStep})
+	return env, observed
+}
+
+// migrationPlan has a lint step bounded to 5 minutes, a migration step
+// running for up to 2 hours heartbeating every 10 seconds, a quick step
+// shorter than the intent workflows' heartbeat and a step with the defaults
+var migrationPlan = ExecutionPlan{Steps: []ExecutionStep{
+	{ID: "lint", Type: "action", StepActivitySettings: services.StepActivitySettings{TimeoutSeconds: 300}},
+	{ID: "migrate", Type: "action", DependsOn: []string{"lint"}, StepActivitySettings: services.StepActivitySettings{
+		TimeoutSeconds: 7200, HeartbeatSeconds: 10, MaxAttempts: 2, NonRetryableErrorTypes: []string{"MigrationConflict"}}},
+	{ID: "notify", Type: "action", DependsOn: []string{"migrate"}, StepActivitySettings: services.StepActivitySettings{TimeoutSeconds: 20}},
+	{ID: "report", Type: "action", DependsOn: []string{"migrate"}},
+}}
+
+func TestIntentProcessingWorkflow_AppliesStepSettings(t *testing.T) {
+	env, observed := stepSettingsEnv(t, migrationPlan, func(step ExecutionStep, attempt int32) error {
+		if step.ID == "migrate" && attempt == 1 {
+			return errors.New("lock timeout")
+		}
+		return nil
+	})
+
+	env.ExecuteWorkflow("IntentProcessingWorkflow", intentWorkflow(t))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	require.Len(t, observed, 4)
+	assert.Equal(t, &observedStep{timeout: 5 * time.Minute, heartbeat: 30 * time.Second, attempts: 1}, observed["lint"],
+		"the heartbeat of intent workflows")
+	assert.Equal(t, &observedStep{timeout: 2 * time.Hour, heartbeat: 10 * time.Second, attempts: 2}, observed["migrate"])
+	assert.Equal(t, &observedStep{timeout: 20 * time.Second, attempts: 1}, observed["notify"],
+		"an inherited heartbeat as long as the step's timeout is dropped")
+	assert.Equal(t, &observedStep{timeout: 5 * time.Minute, heartbeat: 30 * time.Second, attempts: 1}, observed["report"],
+		"steps without settings have those of intent workflows")
+}
+
+func TestIntentProcessingWorkflow_AppliesStepRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{name: "max attempts over the workflow's", err: errors.New("lock timeout"), attempts: 2},
+		{name: "non-retryable error type", err: temporal.NewApplicationError("conflicting migration", "MigrationConflict"), attempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, observed := stepSettingsEnv(t, migrationPlan, func(step ExecutionStep, attempt int32) error {
+				if step.ID == "migrate" {
+					return tt.err
+				}
+				return nil
+			})
+
+			env.ExecuteWorkflow("IntentProcessingWorkflow", intentWorkflow(t))
+			require.True(t, env.IsWorkflowCompleted())
+			require.Error(t, env.GetWorkflowError())
+			assert.Equal(t, tt.attempts, observed["migrate"].attempts)
+			assert.NotContains(t, observed, "report")
+		})
+	}
+}
+
+func TestIntentProcessingWorkflow_RejectsInvalidStepSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings services.StepActivitySettings
+		message  string
+	}{
+		{name: "heartbeat as long as the timeout", settings: services.StepActivitySettings{TimeoutSeconds: 60, HeartbeatSeconds: 60},
+			message: "step migrate: heartbeat_seconds must be less than timeout_seconds"},
+		{name: "heartbeat as long as the inherited timeout", settings: services.StepActivitySettings{HeartbeatSeconds: 300},
+			message: "heartbeat_seconds 300 must be less than the step's timeout of 5m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := ExecutionPlan{Steps: []ExecutionStep{
+				{ID: "lint", Type: "action"},
+				{ID: "migrate", Type: "action", DependsOn: []string{"lint"}, StepActivitySettings: tt.settings},
+			}}
+			env, observed := stepSettingsEnv(t, plan, nil)
+
+			env.ExecuteWorkflow("IntentProcessingWorkflow", intentWorkflow(t))
+			require.True(t, env.IsWorkflowCompleted())
+			err := env.GetWorkflowError()
+			require.Error(t, err)
+			assert.ErrorContains(t, err, tt.message)
+			var appErr *temporal.ApplicationError
+			require.ErrorAs(t, err, &appErr)
+			assert.Equal(t, invalidPlanError, appErr.Type())
+			assert.NotContains(t, observed, "migrate")
+		})
+	}
+}
+
+func TestCustomWorkflow_AppliesStepSettings(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.CustomWorkflow)
+
+	observed := make(map[string]*observedStep)
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		var step CustomStep
+		require.NoError(t, args.Get(&step))
+		if observed[step.Name] == nil {
+			observed[step.Name] = &observedStep{timeout: info.Deadline.Sub(info.StartedTime), heartbeat: info.HeartbeatTimeout}
+		}
+		observed[step.Name].attempts++
+	})
+	env.RegisterActivityWithOptions(func(ctx context.Context, step CustomStep) (interface{}, error) {
+		if step.Name == "migrate" {
+			return nil, errors.New("lock timeout")
+		}
+		return map[string]interface{}{"step": step.Name}, nil
+	}, activity.RegisterOptions{Name: ActivityExecuteCustomStep})
+
+	definition, err := json.Marshal(map[string]interface{}{"steps": []map[string]interface{}{
+		{"name": "migrate", "timeout_seconds": 7200, "heartbeat_seconds": 10, "max_retries": 2, "continue_on_error": true},
+		{"name": "lint", "timeout_seconds": 10, "max_attempts": 4, "max_retries": 1},
+	}})
+	require.NoError(t, err)
+	env.ExecuteWorkflow(engine.CustomWorkflow, &models.Workflow{ID: testWorkflowID, Type: models.WorkflowTypeCustom, Config: definition})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	assert.Equal(t, &observedStep{timeout: 2 * time.Hour, heartbeat: 10 * time.Second, attempts: 2}, observed["migrate"],
+		"max_retries stands in for max_attempts")
+	assert.Equal(t, &observedStep{timeout: 10 * time.Second, attempts: 1}, observed["lint"],
+		"the heartbeat of custom workflows is dropped for shorter steps")
+}
@@ -0,0 +1,88 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// taskGroupKeys are the tag prefixes and technical requirements that group
+// related tasks, in order of precedence
+var taskGroupKeys = []string{"component", "module"}
+
+// taskGroupKey names the group of related tasks a task belongs to, from a
+// "component:" or "module:" tag or else the component or module of its
+// technical requirements. Tasks of a group prefer the agent that ran the
+// group's last task. Tasks without a group have an empty key.
+func taskGroupKey(task Task) string {
+	for _, key := range taskGroupKeys {
+		for _, tag := range task.Tags {
+			if value, ok := strings.CutPrefix(tag, key+":"); ok && strings.TrimSpace(value) != "" {
+				return key + ":" + strings.ToLower(strings.TrimSpace(value))
+			}
+		}
+	}
+	for _, key := range taskGroupKeys {
+		if value, ok := task.TechnicalRequirements[key].(string); ok && strings.TrimSpace(value) != "" {
+			return key + ":" + strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	return ""
+}
+
+// affinityDecisions lists the results of tasks that preferred an agent
+func affinityDecisions(results []TaskExecutionResult) []services.AffinityDecision {
+	var decisions []services.AffinityDecision
+	for _, result := range results {
+		if result.Affinity == "" {
+			continue
+		}
+		decisions = append(decisions, services.AffinityDecision{
+			TaskID:         result.TaskID,
+			GroupKey:       result.GroupKey,
+			PreferredAgent: result.PreferredAgent,
+			AgentID:        result.AgentID,
+			Outcome:        result.Affinity,
+			Reason:         result.AffinityReason,
+		})
+	}
+	return decisions
+}
+
+// recordAffinity stores the affinity decisions in the workflow's metadata,
+// where the workflow metrics read them
+func (a *Activities) recordAffinity(ctx context.Context, affinity *services.AffinityMetrics) error {
+	if a.db == nil {
+		return nil
+	}
+
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "metadata").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+
+	metadata := map[string]interface{}{}
+	if len(workflow.Metadata) > 0 {
+		if err := json.Unmarshal(workflow.Metadata, &metadata); err != nil {
+			return fmt.Errorf("failed to parse workflow metadata: %w", err)
+		}
+	}
+	metadata[services.AffinityMetadataKey] = affinity
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal workflow metadata: %w", err)
+	}
+	if err := a.db.WithContext(ctx).Model(&models.Workflow{}).Where("id = ?", workflowID).
+		Update("metadata", json.RawMessage(data)).Error; err != nil {
+		return fmt.Errorf("failed to save affinity decisions: %w", err)
+	}
+	return nil
+}
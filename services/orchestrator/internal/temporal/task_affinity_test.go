@@ -0,0 +1,190 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// busyFleet is a backend fleet whose agents get busier with each task they
+// run. An agent can be made to crash, going offline, on its nth task.
+type busyFleet struct {
+	*fakeFleet
+	step    float64        // Load each task adds to its agent
+	crashOn map[string]int // Task of each agent that crashes it, counting from 1
+
+	mu       sync.Mutex
+	executed []string // Agents given a task, in order
+	tasks    map[string]int
+}
+
+func (f *busyFleet) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	if req.Type == "design-agent" || req.Type == "spawn-agent" {
+		return f.fakeFleet.ExecuteTask(ctx, agentID, req)
+	}
+	f.mu.Lock()
+	f.executed = append(f.executed, agentID)
+	if f.tasks == nil {
+		f.tasks = map[string]int{}
+	}
+	f.tasks[agentID]++
+	crashed := f.tasks[agentID] == f.crashOn[agentID]
+	f.mu.Unlock()
+
+	f.fakeFleet.mu.Lock()
+	defer f.fakeFleet.mu.Unlock()
+	for i := range f.agents {
+		agent := &f.agents[i]
+		if agent.ID != agentID {
+			continue
+		}
+		if crashed {
+			agent.Status = "offline"
+			return nil, fmt.Errorf("agent %s crashed", agentID)
+		}
+		agent.Load += f.step
+	}
+	return &services.TaskExecution{ID: req.TaskID, AgentID: agentID, Status: "completed", Output: map[string]interface{}{"summary": "done"}}, nil
+}
+
+// runAffinityWorkflow runs the task execution workflow for the given tasks,
+// returning the aggregated result
+func runAffinityWorkflow(t *testing.T, db *gorm.DB, fleet *busyFleet, tasks []Task) AggregatedTaskResult {
+	metaActivities := NewMetaAgentActivities(fleet, zap.NewNop())
+	metaActivities.selection = testSelectionPolicy
+	activities := NewActivities(db, zap.NewNop(), nil, fleet)
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	engine := NewWorkflowEngine(zap.NewNop())
+	env.RegisterWorkflow(engine.TaskExecutionWorkflow)
+	env.RegisterActivityWithOptions(metaActivities.FindOrCreateAgentForTaskActivity,
+		activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"})
+	env.RegisterActivityWithOptions(metaActivities.ExecuteTaskWithAgentActivity,
+		activity.RegisterOptions{Name: "MetaAgentExecuteTaskWithAgentActivity"})
+	env.RegisterActivityWithOptions(metaActivities.OptimizeAgentPerformanceActivity,
+		activity.RegisterOptions{Name: "MetaAgentOptimizeAgentPerformanceActivity"})
+	env.RegisterActivity(activities.AggregateTaskResultsActivity)
+	env.RegisterActivity(activities.StoreArtifactsActivity)
+
+	var aggregated AggregatedTaskResult
+	env.SetOnActivityCompletedListener(func(info *activity.Info, result converter.EncodedValue, err error) {
+		if info.ActivityType.Name == "AggregateTaskResultsActivity" {
+			require.NoError(t, result.Get(&aggregated))
+		}
+	})
+
+	input, err := json.Marshal(TaskExecutionInput{ProjectID: "project-1", Tasks: tasks})
+	require.NoError(t, err)
+	env.ExecuteWorkflow(engine.TaskExecutionWorkflow, &models.Workflow{ID: testWorkflowID, Input: input})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	require.Len(t, aggregated.TaskResults, len(tasks))
+	return aggregated
+}
+
+func TestTaskExecutionWorkflow_ReusesAgentForRelatedTasks(t *testing.T) {
+	db := setupIterativeTest(t)
+	fleet := &busyFleet{fakeFleet: backendFleet(0.1, 0.2), step: 0.3}
+	tasks := []Task{
+		{ID: "task-1", Type: "backend", Title: "Orders model", Tags: []string{"component:orders"}},
+		{ID: "task-2", Type: "backend", Title: "Orders API", Tags: []string{"api", "component:Orders"}},
+		{ID: "task-3", Type: "backend", Title: "Orders events", TechnicalRequirements: map[string]interface{}{"component": "orders"}},
+		{ID: "task-4", Type: "backend", Title: "Orders search", Tags: []string{"component:orders"}},
+		{ID: "task-5", Type: "backend", Title: "Health check"},
+	}
+
+	aggregated := runAffinityWorkflow(t, db, fleet, tasks)
+
+	// The group stays on backend-1, although backend-2 becomes less loaded,
+	// until backend-1 is saturated
+	assert.Equal(t, []string{"backend-1", "backend-1", "backend-1", "backend-2", "backend-2"}, fleet.executed)
+	var outcomes []string
+	for _, result := range aggregated.TaskResults {
+		assert.Equal(t, models.ExecutionStatusSucceeded, result.Status)
+		outcomes = append(outcomes, result.Affinity)
+	}
+	assert.Equal(t, []string{"", services.AffinityHonored, services.AffinityHonored, services.AffinityBroken, ""}, outcomes)
+
+	broken := aggregated.TaskResults[3]
+	assert.Equal(t, "component:orders", broken.GroupKey)
+	assert.Equal(t, "backend-1", broken.PreferredAgent)
+	assert.Contains(t, broken.AffinityReason, "at or above threshold")
+	assert.Equal(t, float64(2), aggregated.Metadata["affinity_honored"])
+	assert.Equal(t, float64(1), aggregated.Metadata["affinity_broken"])
+
+	// The decisions are kept in the workflow's metadata for its metrics
+	var workflow models.Workflow
+	require.NoError(t, db.First(&workflow, "id = ?", testWorkflowID).Error)
+	var metadata map[string]services.AffinityMetrics
+	require.NoError(t, json.Unmarshal(workflow.Metadata, &metadata))
+	affinity := metadata[services.AffinityMetadataKey]
+	assert.Equal(t, 2, affinity.Honored)
+	assert.Equal(t, 1, affinity.Broken)
+	require.Len(t, affinity.Decisions, 3)
+	assert.Equal(t, services.AffinityDecision{
+		TaskID:         "task-4",
+		GroupKey:       "component:orders",
+		PreferredAgent: "backend-1",
+		AgentID:        "backend-2",
+		Outcome:        services.AffinityBroken,
+		Reason:         broken.AffinityReason,
+	}, affinity.Decisions[2])
+}
+
+func TestTaskExecutionWorkflow_FailedAgentBreaksAffinity(t *testing.T) {
+	// backend-1 goes offline on its second task
+	fleet := &busyFleet{fakeFleet: backendFleet(0.1, 0.2), crashOn: map[string]int{"backend-1": 2}}
+	tasks := []Task{
+		{ID: "task-1", Type: "backend", Title: "Orders model", Tags: []string{"module:orders"}},
+		{ID: "task-2", Type: "backend", Title: "Orders API", Tags: []string{"module:orders"}},
+		{ID: "task-3", Type: "backend", Title: "Orders events", Tags: []string{"module:orders"}},
+	}
+
+	aggregated := runAffinityWorkflow(t, nil, fleet, tasks)
+
+	// The failed attempt is retried on backend-2, which the group then keeps
+	assert.Equal(t, []string{"backend-1", "backend-1", "backend-2", "backend-2"}, fleet.executed)
+	for _, result := range aggregated.TaskResults {
+		assert.Equal(t, models.ExecutionStatusSucceeded, result.Status)
+	}
+	retried := aggregated.TaskResults[1]
+	assert.Equal(t, "backend-2", retried.AgentID)
+	assert.Equal(t, services.AffinityBroken, retried.Affinity)
+	assert.Equal(t, "agent backend-1 is offline", retried.AffinityReason)
+	assert.Equal(t, services.AffinityHonored, aggregated.TaskResults[2].Affinity)
+	assert.Equal(t, "backend-2", aggregated.TaskResults[2].PreferredAgent)
+}
+
+func TestTaskGroupKey(t *testing.T) {
+	tests := map[string]struct {
+		task Task
+		want string
+	}{
+		"component tag":         {Task{Tags: []string{"api", "component: Orders "}}, "component:orders"},
+		"module tag":            {Task{Tags: []string{"module:billing"}}, "module:billing"},
+		"component over module": {Task{Tags: []string{"module:billing", "component:orders"}}, "component:orders"},
+		"technical requirement": {Task{TechnicalRequirements: map[string]interface{}{"module": "Billing"}}, "module:billing"},
+		"tag over requirement":  {Task{Tags: []string{"module:billing"}, TechnicalRequirements: map[string]interface{}{"component": "orders"}}, "module:billing"},
+		"empty tag":             {Task{Tags: []string{"component:"}}, ""},
+		"no group":              {Task{Tags: []string{"api"}, TechnicalRequirements: map[string]interface{}{"component": 3}}, ""},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, taskGroupKey(tt.task))
+		})
+	}
+}
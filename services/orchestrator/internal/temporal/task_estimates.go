@@ -0,0 +1,98 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// taskEstimate resolves the estimate and timeout a task is executed with:
+// its static estimate, blended with the recorded durations of its type and
+// complexity when a refiner is set
+func taskEstimate(ctx context.Context, refiner *services.EstimateRefiner, task Task) services.TaskEstimate {
+	if refiner == nil {
+		return services.HeuristicEstimate(task.EstimatedHours)
+	}
+	return refiner.Refine(ctx, task.Type, task.Complexity, task.EstimatedHours)
+}
+
+// recordTaskDurations records the actual durations of the running workflow's
+// successful tasks. Failed tasks say little about how long the work takes.
+func (a *Activities) recordTaskDurations(ctx context.Context, results []TaskExecutionResult) error {
+	if a.estimates == nil {
+		return nil
+	}
+
+	var durations []services.TaskDuration
+	for _, result := range results {
+		if !result.Status.IsSuccess() || result.TaskType == "" {
+			continue
+		}
+		duration := result.Duration
+		if duration <= 0 {
+			duration = result.EndTime.Sub(result.StartTime)
+		}
+		if duration <= 0 {
+			continue
+		}
+		durations = append(durations, services.TaskDuration{
+			TaskID:         result.TaskID,
+			TaskType:       result.TaskType,
+			Complexity:     result.Complexity,
+			EstimatedHours: result.EstimatedHours,
+			Duration:       duration,
+		})
+	}
+	if len(durations) == 0 {
+		return nil
+	}
+
+	workflowID := activity.GetInfo(ctx).WorkflowExecution.ID
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "project_id").
+		First(&workflow, "id = ?", workflowID).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+
+	return a.estimates.RecordDurations(ctx, workflow.ID, workflow.ProjectID, durations)
+}
+
+// recordTaskOutcomes records whether the agents of the running workflow's
+// tasks ran them successfully, for capability drift detection
+func (a *Activities) recordTaskOutcomes(ctx context.Context, results []TaskExecutionResult) error {
+	if a.drift == nil {
+		return nil
+	}
+
+	var outcomes []services.TaskOutcome
+	for _, result := range results {
+		if result.AgentID == "" || len(result.Capabilities) == 0 {
+			continue
+		}
+		outcomes = append(outcomes, services.TaskOutcome{
+			TaskID:       result.TaskID,
+			AgentID:      result.AgentID,
+			Capabilities: result.Capabilities,
+			Succeeded:    result.Status.IsSuccess(),
+		})
+	}
+	if len(outcomes) == 0 {
+		return nil
+	}
+
+	var workflow models.Workflow
+	if err := a.db.WithContext(ctx).Select("id", "simulated").
+		First(&workflow, "id = ?", activity.GetInfo(ctx).WorkflowExecution.ID).Error; err != nil {
+		return fmt.Errorf("workflow not found: %w", err)
+	}
+	// Stub agents of simulated workflows say nothing about real ones
+	if workflow.Simulated {
+		return nil
+	}
+
+	return a.drift.RecordOutcomes(ctx, workflow.ID, outcomes)
+}
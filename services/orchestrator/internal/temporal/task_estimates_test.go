@@ -0,0 +1,93 @@
+package temporal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestAggregateTaskResultsActivity_RecordsDurationsOnce(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO workflows (id, project_id) VALUES (?, ?)`, testWorkflowID, "project-1").Error)
+	require.NoError(t, db.AutoMigrate(&models.TaskDurationSample{}, &models.TaskDurationStat{}))
+
+	activities := NewActivities(db, zap.NewNop(), nil, nil)
+	activities.estimates = services.NewEstimateRefiner(db, &config.EstimationConfig{MinSamples: 10, Window: 200}, zap.NewNop())
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	results := []TaskExecutionResult{
+		{TaskID: "task-1", Status: models.ExecutionStatusSucceeded, TaskType: "backend", Complexity: "medium", EstimatedHours: 2, Duration: 90 * time.Minute},
+		// Timed by start and end only
+		{TaskID: "task-2", Status: models.ExecutionStatusSucceeded, TaskType: "backend", Complexity: "medium", EstimatedHours: 2, StartTime: start, EndTime: start.Add(30 * time.Minute)},
+		// Failed tasks and tasks of unknown type are not learned from
+		{TaskID: "task-3", Status: models.ExecutionStatusFailed, TaskType: "backend", Complexity: "medium", Duration: time.Minute},
+		{TaskID: "task-4", Status: models.ExecutionStatusSucceeded, Duration: time.Minute},
+	}
+
+	// Retries of the activity record each task once
+	for i := 0; i < 2; i++ {
+		_, err := env.ExecuteActivity("AggregateTaskResultsActivity", results)
+		require.NoError(t, err)
+	}
+
+	var samples []models.TaskDurationSample
+	require.NoError(t, db.Order("task_id").Find(&samples).Error)
+	require.Len(t, samples, 2)
+	assert.Equal(t, "project-1", samples[0].ProjectID)
+	assert.Equal(t, (90 * time.Minute).Milliseconds(), samples[0].Duration)
+	assert.Equal(t, (30 * time.Minute).Milliseconds(), samples[1].Duration)
+
+	var stat models.TaskDurationStat
+	require.NoError(t, db.First(&stat, "task_type = ? AND complexity = ?", "backend", "medium").Error)
+	assert.Equal(t, int64(2), stat.SampleCount)
+	assert.Equal(t, time.Hour.Milliseconds(), stat.Mean)
+}
+
+func TestAggregateTaskResultsActivity_RecordsTaskOutcomes(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, simulated BOOLEAN, deleted_at DATETIME)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO workflows (id, project_id, simulated) VALUES (?, ?, ?)`, testWorkflowID, "project-1", false).Error)
+	require.NoError(t, db.AutoMigrate(&models.AgentTaskOutcome{}))
+
+	activities := NewActivities(db, zap.NewNop(), nil, nil)
+	activities.drift = services.NewCapabilityDriftDetector(db, nil, nil, &config.CapabilityDriftConfig{}, zap.NewNop())
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	results := []TaskExecutionResult{
+		{TaskID: "task-1", AgentID: "agent-1", Status: models.ExecutionStatusSucceeded, Capabilities: []string{"backend", "Go"}},
+		{TaskID: "task-2", AgentID: "agent-1", Status: models.ExecutionStatusFailed, Capabilities: []string{"go"}},
+		// Tasks no agent was found for say nothing about agents
+		{TaskID: "task-3", Status: models.ExecutionStatusFailed, Capabilities: []string{"go"}},
+	}
+	for i := 0; i < 2; i++ {
+		_, err := env.ExecuteActivity("AggregateTaskResultsActivity", results)
+		require.NoError(t, err)
+	}
+
+	var outcomes []models.AgentTaskOutcome
+	require.NoError(t, db.Order("task_id, capability").Find(&outcomes).Error)
+	require.Len(t, outcomes, 3)
+	assert.Equal(t, []string{"backend", "go", "go"}, []string{outcomes[0].Capability, outcomes[1].Capability, outcomes[2].Capability})
+	assert.True(t, outcomes[1].Succeeded)
+	assert.False(t, outcomes[2].Succeeded)
+	assert.Equal(t, "agent-1", outcomes[2].AgentID)
+}
@@ -3,12 +3,14 @@ package temporal
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
 	"go.temporal.io/sdk/activity"
 	"go.uber.org/zap"
 
+	"orchestrator/internal/models"
 	"orchestrator/internal/services"
 )
 
@@ -30,97 +32,103 @@ func (a *Activities) FindOrCreateAgentForTaskActivity(ctx context.Context, task
 	if err != nil {
 		return nil, fmt.Errorf("failed to list agents: %w", err)
 	}
-
-	// Find best matching agent
-	var bestAgent *services.Agent
-	bestScore := 0
-
-	for _, agent := range agents.Agents {
+	// Workflows pinned to a region only run on agents in it
+	region, err := a.workflowRegion(ctx, activity.GetInfo(ctx).WorkflowExecution.ID)
+	if err != nil {
+		return nil, err
+	}
+	if agents.Agents, err = agentsInRegion(agents.Agents, region); err != nil {
+		return nil, err
+	}
+	// Agents the project excludes are left out before scoring
+	prefs := projectAgentPreferences(ctx, a.preferences, getProjectIDFromContext(ctx))
+	candidates, excluded := prefs.Filter(agents.Agents)
+
+	// Suitable agents match at least 50% of capabilities, best match first
+	// with preferred agents boosted
+	scores := make(map[string]float64, len(candidates))
+	var matching []services.Agent
+	for _, agent := range candidates {
 		score := a.calculateAgentScore(agent, requiredCapabilities)
 		logger.Debug("Agent capability score", 
 			zap.String("agentID", agent.ID),
-			zap.Int("score", score),
+			zap.Float64("score", score),
+			zap.Float64("load", agent.Load),
 			zap.Any("agentCapabilities", agent.Capabilities))
 
-		if score > bestScore {
-			bestScore = score
-			bestAgent = &agent
+		if score > 0 && score >= float64(len(requiredCapabilities)/2) {
+			scores[agent.ID] = score
+			matching = append(matching, agent)
 		}
 	}
-
-	// If we found a suitable agent (matching at least 50% of capabilities)
-	if bestAgent != nil && bestScore >= len(requiredCapabilities)/2 {
+	sort.SliceStable(matching, func(i, j int) bool {
+		return scores[matching[i].ID] > scores[matching[j].ID]
+	})
+	// Scores count matched capabilities, so the boost scales with them
+	matching = prefs.Rank(matching, scores, float64(len(requiredCapabilities)))
+
+	// Use the best suitable agent with spare capacity, spilling over to a
+	// new agent when every suitable agent is saturated
+	selection := a.selection.SelectAgent(ctx, a.agentClient, getProjectIDFromContext(ctx), matching)
+	if !selection.Spawn() {
+		info := newAgentInfo(selection.Agent, selection.Decision, selection.Reason)
+		info.Preference, info.PreferenceWhy = prefs.Influence(selection.Agent, matching, scores, excluded)
 		logger.Info("Found suitable existing agent", 
-			zap.String("agentID", bestAgent.ID),
-			zap.Int("score", bestScore))
-
-		bestCapNames := make([]string, len(bestAgent.Capabilities))
-		for i, cap := range bestAgent.Capabilities {
-			bestCapNames[i] = cap.Name
-		}
-		
-		return &AgentInfo{
-			ID:           bestAgent.ID,
-			Type:         bestAgent.Type,
-			Capabilities: bestCapNames,
-			Status:       bestAgent.Status,
-		}, nil
+			zap.String("agentID", selection.Agent.ID),
+			zap.Float64("score", scores[selection.Agent.ID]),
+			zap.String("selection", selection.Decision),
+			zap.String("reason", selection.Reason),
+			zap.String("preference", info.Preference))
+		return info, nil
 	}
 
-	// No suitable agent found - request dynamic agent creation
-	logger.Info("No suitable agent found, requesting dynamic agent creation")
+	// No suitable agent available - request dynamic agent creation
+	logger.Info("Requesting dynamic agent creation",
+		zap.String("selection", selection.Decision),
+		zap.String("reason", selection.Reason))
 
 	// Create agent specification based on task requirements
 	agentSpec := a.createAgentSpec(task, requiredCapabilities)
+	agentConfig := map[string]interface{}{
+		"task_type":        task.Type,
+		"task_description": task.Description,
+		"complexity":       task.Complexity,
+		"spec":             agentSpec,
+	}
+	// Agents created for a pinned workflow run in its region
+	if region != "" {
+		agentConfig["region"] = region
+	}
 
 	// Request meta-prompt agent to create a new specialized agent
 	createResp, err := a.agentClient.CreateAgent(ctx, &services.CreateAgentRequest{
 		Name:         fmt.Sprintf("%s-agent-%s", task.Type, task.ID[:8]),
-		Type:         "dynamic",
+		Type:         services.DynamicAgentType,
 		ProjectID:    getProjectIDFromContext(ctx),
 		Capabilities: requiredCapabilities,
-		Config: map[string]interface{}{
-			"task_type":        task.Type,
-			"task_description": task.Description,
-			"complexity":       task.Complexity,
-			"spec":             agentSpec,
-		},
+		Config:       agentConfig,
 	})
 	if err != nil {
-		// If dynamic creation fails, try to use meta-prompt agent directly
+		cause := fmt.Sprintf("dynamic agent creation failed: %v", err)
+
+		// Queue on a saturated suitable agent if there is one
+		if selection.Fallback != nil {
+			logger.Warn("Dynamic agent creation failed, queueing on saturated agent", zap.Error(err))
+			return newAgentInfo(selection.Fallback, services.SelectionQueuedSaturated, selection.Reason+"; "+cause), nil
+		}
+
+		// Otherwise try to use meta-prompt agent directly
 		logger.Warn("Dynamic agent creation failed, falling back to meta-prompt agent", zap.Error(err))
-		
-		// Find meta-prompt agent
-		for _, agent := range agents.Agents {
-			if agent.Type == "meta-prompt" {
-				agentCapNames := make([]string, len(agent.Capabilities))
-				for i, cap := range agent.Capabilities {
-					agentCapNames[i] = cap.Name
-				}
-				
-				return &AgentInfo{
-					ID:           agent.ID,
-					Type:         agent.Type,
-					Capabilities: agentCapNames,
-					Status:       agent.Status,
-				}, nil
+		for i := range agents.Agents {
+			if agents.Agents[i].Type == "meta-prompt" {
+				return newAgentInfo(&agents.Agents[i], services.SelectionFellBackToMeta, cause), nil
 			}
 		}
 
 		return nil, fmt.Errorf("failed to create agent and no fallback available: %w", err)
 	}
 
-	createCapNames := make([]string, len(createResp.Capabilities))
-	for i, cap := range createResp.Capabilities {
-		createCapNames[i] = cap.Name
-	}
-	
-	return &AgentInfo{
-		ID:           createResp.ID,
-		Type:         createResp.Type,
-		Capabilities: createCapNames,
-		Status:       createResp.Status,
-	}, nil
+	return newAgentInfo(createResp, selection.Decision, selection.Reason), nil
 }
 
 // ExecuteTaskWithAgentActivity executes a task using the selected agent
@@ -132,7 +140,21 @@ func (a *Activities) ExecuteTaskWithAgentActivity(ctx context.Context, task Task
 
 	startTime := time.Now()
 
+	vars, config, err := resolveTaskConfig(ctx, a.variables, task)
+	if err != nil {
+		return &TaskExecutionResult{
+			TaskID:    task.ID,
+			AgentID:   agent.ID,
+			Status:    models.ExecutionStatusFailed,
+			Error:     err.Error(),
+			StartTime: startTime,
+			EndTime:   time.Now(),
+			Duration:  time.Since(startTime),
+		}, err
+	}
+
 	// Prepare task execution request
+	estimate := taskEstimate(ctx, a.estimates, task)
 	execReq := &services.ExecuteTaskRequest{
 		Type: task.Type,
 		Input: map[string]interface{}{
@@ -141,6 +163,7 @@ func (a *Activities) ExecuteTaskWithAgentActivity(ctx context.Context, task Task
 			"description":           task.Description,
 			"acceptance_criteria":   task.AcceptanceCriteria,
 			"technical_requirements": task.TechnicalRequirements,
+			"config":                 config,
 			"context": map[string]interface{}{
 				"project_id": getProjectIDFromContext(ctx),
 				"priority":   task.Priority,
@@ -148,24 +171,26 @@ func (a *Activities) ExecuteTaskWithAgentActivity(ctx context.Context, task Task
 			},
 		},
 		Config: map[string]interface{}{
-			"timeout_minutes":  int(task.EstimatedHours * 60),
+			"timeout_minutes":  int(estimate.TimeoutHours * 60),
 			"generate_tests":   shouldGenerateTests(task.Type),
 			"generate_docs":    shouldGenerateDocs(task.Type),
 			"code_style":       "standard",
 			"target_language":  getTargetLanguage(task),
 		},
 		Priority:   task.Priority,
-		Timeout:    int(task.EstimatedHours * 3600), // Convert hours to seconds
+		Timeout:    int(estimate.TimeoutHours * 3600), // Convert hours to seconds
 		MaxRetries: 2,
 	}
 
 	// Execute task
+	recordProgress(ctx, 10, "executing_task", fmt.Sprintf("Task %s sent to agent %s", task.ID, agent.ID))
 	execResp, err := a.agentClient.ExecuteTask(ctx, agent.ID, execReq)
 	if err != nil {
+		err = vars.RedactError(err)
 		return &TaskExecutionResult{
 			TaskID:    task.ID,
 			AgentID:   agent.ID,
-			Status:    "failed",
+			Status:    models.ExecutionStatusFailed,
 			Error:     err.Error(),
 			StartTime: startTime,
 			EndTime:   time.Now(),
@@ -177,15 +202,15 @@ func (a *Activities) ExecuteTaskWithAgentActivity(ctx context.Context, task Task
 	result := &TaskExecutionResult{
 		TaskID:    task.ID,
 		AgentID:   agent.ID,
-		Status:    execResp.Status,
-		Output:    execResp.Output,
+		Status:    agentTaskStatus(ctx, execResp.Status),
+		Output:    vars.RedactMap(execResp.Output),
 		StartTime: startTime,
 		EndTime:   time.Now(),
 		Duration:  time.Since(startTime),
 	}
 
 	// Extract artifacts from output
-	if artifactsData, ok := execResp.Output["artifacts"]; ok {
+	if artifactsData, ok := result.Output["artifacts"]; ok {
 		if artifacts, ok := artifactsData.([]interface{}); ok {
 			for _, artifactData := range artifacts {
 				if artifactMap, ok := artifactData.(map[string]interface{}); ok {
@@ -211,11 +236,22 @@ func (a *Activities) ExecuteTaskWithAgentActivity(ctx context.Context, task Task
 	}
 
 	// Record heartbeat with progress
-	activity.RecordHeartbeat(ctx, fmt.Sprintf("Task %s completed by agent %s", task.ID, agent.ID))
+	recordProgress(ctx, 100, "task_completed", fmt.Sprintf("Task %s completed by agent %s", task.ID, agent.ID))
 
 	return result, nil
 }
 
+// agentTaskStatus normalizes the status an agent reported for a task.
+// Statuses it does not recognize count as failed.
+func agentTaskStatus(ctx context.Context, reported string) models.ExecutionStatus {
+	status, ok := models.ParseExecutionStatus(reported)
+	if !ok {
+		activity.GetLogger(ctx).Warn("Agent reported an unknown task status", "status", reported)
+		return models.ExecutionStatusFailed
+	}
+	return status
+}
+
 // AggregateTaskResultsActivity aggregates results from all task executions
 func (a *Activities) AggregateTaskResultsActivity(ctx context.Context, results []TaskExecutionResult) (*AggregatedTaskResult, error) {
 	logger := activity.GetLogger(ctx)
@@ -230,7 +266,7 @@ func (a *Activities) AggregateTaskResultsActivity(ctx context.Context, results [
 
 	// Count successes and failures
 	for _, result := range results {
-		if result.Status == "completed" || result.Status == "succeeded" {
+		if result.Status.IsSuccess() {
 			aggregated.SuccessfulTasks++
 		} else {
 			aggregated.FailedTasks++
@@ -255,8 +291,31 @@ func (a *Activities) AggregateTaskResultsActivity(ctx context.Context, results [
 
 	// Add metadata
 	aggregated.Metadata["total_artifacts"] = len(aggregated.Artifacts)
+	aggregated.Metadata["average_quality"] = a.scoreResults(ctx, aggregated.TaskResults)
 	aggregated.Metadata["completion_rate"] = float64(aggregated.SuccessfulTasks) / float64(aggregated.TotalTasks)
 
+	// Affinity decisions are kept for the workflow metrics
+	if decisions := affinityDecisions(results); len(decisions) > 0 {
+		affinity := services.NewAffinityMetrics(decisions)
+		aggregated.Metadata["affinity_honored"] = affinity.Honored
+		aggregated.Metadata["affinity_broken"] = affinity.Broken
+		if err := a.recordAffinity(ctx, affinity); err != nil {
+			logger.Warn("Failed to record affinity decisions", zap.Error(err))
+		}
+	}
+
+	// Estimates learn from the actual durations; results are still
+	// aggregated when they cannot be recorded
+	if err := a.recordTaskDurations(ctx, results); err != nil {
+		logger.Warn("Failed to record task durations", zap.Error(err))
+	}
+	if err := a.recordTaskOutcomes(ctx, results); err != nil {
+		logger.Warn("Failed to record task outcomes", zap.Error(err))
+	}
+	if err := a.recordTaskQuality(ctx, results); err != nil {
+		logger.Warn("Failed to record task quality scores", zap.Error(err))
+	}
+
 	return aggregated, nil
 }
 
@@ -278,10 +337,13 @@ func (a *Activities) StoreArtifactsActivity(ctx context.Context, projectID strin
 		
 		// TODO: Implement actual artifact storage
 		// - Store in object storage (S3, Azure Blob, etc.)
-		// - Save metadata in database
 		// - Generate download URLs
 	}
 
+	if err := a.recordArtifacts(ctx, projectID, artifacts); err != nil {
+		return err
+	}
+
 	activity.RecordHeartbeat(ctx, fmt.Sprintf("Stored %d artifacts", len(artifacts)))
 	return nil
 }
@@ -329,20 +391,13 @@ func (a *Activities) getRequiredCapabilities(task Task) []string {
 	return capabilities
 }
 
-func (a *Activities) calculateAgentScore(agent services.Agent, requiredCapabilities []string) int {
-	score := 0
-	agentCaps := make(map[string]bool)
-
-	// Create a map for faster lookup
-	for _, cap := range agent.Capabilities {
-		agentCaps[strings.ToLower(cap.Name)] = true
-	}
-
-	// Count matching capabilities
+// calculateAgentScore counts the required capabilities the agent provides,
+// capabilities flagged for drift counting by their flag's weight
+func (a *Activities) calculateAgentScore(agent services.Agent, requiredCapabilities []string) float64 {
+	score := 0.0
+	weights := services.CapabilityWeights(agent)
 	for _, required := range requiredCapabilities {
-		if agentCaps[strings.ToLower(required)] {
-			score++
-		}
+		score += weights[strings.ToLower(required)]
 	}
 
 	return score
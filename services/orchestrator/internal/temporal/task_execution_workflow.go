@@ -2,6 +2,7 @@ package temporal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,25 +11,33 @@ import (
 	"go.uber.org/zap"
 
 	"orchestrator/internal/models"
+	"orchestrator/internal/retry"
+	"orchestrator/internal/services"
 )
 
 // TaskExecutionWorkflow handles the execution of tasks generated by intent processing
-func (w *WorkflowEngine) TaskExecutionWorkflow(ctx workflow.Context, wf *models.Workflow) error {
+func (w *WorkflowEngine) TaskExecutionWorkflow(ctx workflow.Context, wf *models.Workflow) (err error) {
+	progress := trackProgress(ctx, wf)
+	defer func() { progress.finish(err) }()
+
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting task execution workflow with meta-agent integration", "workflowID", wf.ID)
 
-	// Set workflow options with appropriate timeouts
-	ao := workflow.ActivityOptions{
-		StartToCloseTimeout: 30 * time.Minute,
-		HeartbeatTimeout:    2 * time.Minute,
-		RetryPolicy: &temporal.RetryPolicy{
-			InitialInterval:    time.Second,
-			BackoffCoefficient: 2.0,
-			MaximumInterval:    5 * time.Minute,
-			MaximumAttempts:    3,
-		},
+	if err := resolvePlaceholders(ctx, wf); err != nil {
+		return err
+	}
+
+	// Set workflow options from the workflow's config
+	config, err := taskExecutionConfig(wf)
+	if err != nil {
+		return err
 	}
-	ctx = workflow.WithActivityOptions(ctx, ao)
+	ctx = workflow.WithActivityOptions(ctx, activityOptions(config.Activities, 5*time.Minute))
+
+	// Retries of every task, by the workflow and by Temporal, come out of
+	// one budget
+	budget := retryBudget(ctx, config.RetryBudget)
+	progress.trackRetryBudget(budget)
 
 	// Step 1: Parse workflow input to get tasks and intent result
 	var workflowInput TaskExecutionInput
@@ -36,84 +45,120 @@ func (w *WorkflowEngine) TaskExecutionWorkflow(ctx workflow.Context, wf *models.
 		return fmt.Errorf("failed to parse workflow input: %w", err)
 	}
 
+	// Simulated workflows route agent activities to a stub client
+	sim := config.SimulationConfig()
+
+	// Task types are checked against the policy the workflow started under
+	policy := sandboxPolicy(wf)
+
+	for _, task := range workflowInput.Tasks {
+		progress.plan(taskStep(task.ID))
+	}
+	progress.plan(taskStepAggregate, taskStepStore)
+
 	logger.Info("Processing tasks from intent result", 
 		"taskCount", len(workflowInput.Tasks),
-		"projectID", workflowInput.ProjectID)
+		"projectID", workflowInput.ProjectID,
+		"simulated", sim != nil)
 
-	// Step 2: Process each task using meta-agent system
+	// Step 2: Process each task using meta-agent system. Related tasks
+	// prefer the agent that ran the last task of their group, which already
+	// holds the context of the component they work on.
 	var taskResults []TaskExecutionResult
+	assignments := make(map[string]string) // Agent ID by task group key
 	for _, task := range workflowInput.Tasks {
 		logger.Info("Processing task", 
 			"taskID", task.ID,
 			"taskType", task.Type,
 			"title", task.Title)
 
-		// Find or create suitable agent for the task using meta-agent system
-		var agent AgentInfo
-		err := workflow.ExecuteActivity(ctx, "MetaAgentFindOrCreateAgentForTaskActivity", task).Get(ctx, &agent)
-		if err != nil {
-			logger.Error("Failed to find/create agent for task", 
-				zap.String("taskID", task.ID),
-				zap.Error(err))
-			
-			// Record failed task
-			taskResults = append(taskResults, TaskExecutionResult{
-				TaskID: task.ID,
-				Status: "failed",
-				Error:  fmt.Sprintf("Agent selection failed: %v", err),
-			})
+		progress.start(taskStep(task.ID))
+		if !policy.AllowsTaskType(task.Type) {
+			result := sandboxRejection(task, policy)
+			logger.Warn("Task rejected by sandbox policy", "taskID", task.ID, "taskType", task.Type)
+			taskResults = append(taskResults, result)
+			progress.recordError(result.Error)
+			progress.complete(taskStep(task.ID))
 			continue
 		}
-
-		logger.Info("Agent selected for task", 
-			"taskID", task.ID,
-			"agentID", agent.ID,
-			"agentType", agent.Type)
-
-		// Execute task with agent using enhanced meta-agent execution
-		var taskResult TaskExecutionResult
-		err = workflow.ExecuteActivity(ctx, "MetaAgentExecuteTaskWithAgentActivity", task, agent).Get(ctx, &taskResult)
-		if err != nil {
-			logger.Error("Task execution failed", 
-				zap.String("taskID", task.ID),
-				zap.String("agentID", agent.ID),
-				zap.Error(err))
-			
-			taskResult = TaskExecutionResult{
-				TaskID:  task.ID,
-				Status:  "failed",
-				Error:   fmt.Sprintf("Execution failed: %v", err),
-				AgentID: agent.ID,
+		groupKey := taskGroupKey(task)
+		result := w.executeTask(ctx, task, sim, budget, AgentSelectionHints{
+			ProjectID:      workflowInput.ProjectID,
+			Region:         services.WorkflowRegion(wf.Metadata),
+			GroupKey:       groupKey,
+			PreferredAgent: assignments[groupKey],
+		})
+		if groupKey != "" {
+			// A failed agent is not preferred by the rest of the group
+			if result.Status.IsSuccess() && result.AgentID != "" {
+				assignments[groupKey] = result.AgentID
+			} else {
+				delete(assignments, groupKey)
 			}
 		}
-
-		taskResults = append(taskResults, taskResult)
+		taskResults = append(taskResults, result)
 
 		// Update progress
 		// Note: RecordHeartbeat is for activities, not workflows
+		if result.Error != "" {
+			progress.recordError(result.Error)
+		}
+		for _, artifact := range result.Artifacts {
+			progress.addArtifacts(services.WorkflowArtifactRef{ID: artifact.ID, Name: artifact.Name, Type: artifact.Type})
+		}
+		progress.complete(taskStep(task.ID))
 	}
 
-	// Step 3: Aggregate results and artifacts
+	// Step 3: Aggregate results and artifacts, carrying each task's estimate
+	// so the actual durations refine future estimates, its capabilities so
+	// the outcome counts toward the agent's observed success rates, and what
+	// it asked for so the result's quality can be scored
+	tasksByID := make(map[string]Task, len(workflowInput.Tasks))
+	for _, task := range workflowInput.Tasks {
+		tasksByID[task.ID] = task
+	}
+	for i := range taskResults {
+		if task, ok := tasksByID[taskResults[i].TaskID]; ok {
+			taskResults[i].TaskType = task.Type
+			taskResults[i].Complexity = task.Complexity
+			taskResults[i].EstimatedHours = task.EstimatedHours
+			taskResults[i].Capabilities = taskCapabilities(task)
+			qualityExpectations(&taskResults[i], task)
+		}
+	}
+
+	progress.start(taskStepAggregate)
 	var aggregatedResult AggregatedTaskResult
-	err := workflow.ExecuteActivity(ctx, "AggregateTaskResultsActivity", taskResults).Get(ctx, &aggregatedResult)
+	err = workflow.ExecuteActivity(ctx, ActivityAggregateTaskResults, taskResults).Get(ctx, &aggregatedResult)
 	if err != nil {
 		logger.Error("Failed to aggregate results", zap.Error(err))
+		progress.recordError(err.Error())
 	}
+	progress.complete(taskStepAggregate)
 
 	// Step 4: Store artifacts if any
+	progress.start(taskStepStore)
 	if len(aggregatedResult.Artifacts) > 0 {
-		err = workflow.ExecuteActivity(ctx, "StoreArtifactsActivity", 
+		err = workflow.ExecuteActivity(ctx, ActivityStoreArtifacts, 
 			workflowInput.ProjectID, aggregatedResult.Artifacts).Get(ctx, nil)
 		if err != nil {
 			logger.Error("Failed to store artifacts", zap.Error(err))
+			progress.recordError(err.Error())
 		}
 	}
+	progress.complete(taskStepStore)
 
-	// Step 5: Performance optimization for agents (async)
-	if len(taskResults) > 0 {
-		// Group results by agent for performance analysis
+	// Step 5: Performance optimization for agents (async). Stub agents
+	// have nothing to optimize.
+	if len(taskResults) > 0 && sim == nil {
+		// Group results by agent for performance analysis, with the quality
+		// scores aggregation gave them
+		scoredResults := taskResults
+		if len(aggregatedResult.TaskResults) == len(taskResults) {
+			scoredResults = aggregatedResult.TaskResults
+		}
 		agentResults := make(map[string][]TaskExecutionResult)
-		for _, result := range taskResults {
+		for _, result := range scoredResults {
 			if result.AgentID != "" {
 				agentResults[result.AgentID] = append(agentResults[result.AgentID], result)
 			}
@@ -122,7 +167,7 @@ func (w *WorkflowEngine) TaskExecutionWorkflow(ctx workflow.Context, wf *models.
 		// Request performance optimization for each agent (non-blocking)
 		for agentID, results := range agentResults {
 			if len(results) >= 2 { // Only optimize if we have enough data
-				workflow.ExecuteActivity(ctx, "MetaAgentOptimizeAgentPerformanceActivity", agentID, results)
+				workflow.ExecuteActivity(ctx, ActivityMetaAgentOptimizeAgentPerformance, agentID, results)
 				// Don't wait for completion - this is for future optimization
 			}
 		}
@@ -154,6 +199,138 @@ func (w *WorkflowEngine) TaskExecutionWorkflow(ctx workflow.Context, wf *models.
 	return nil
 }
 
+// maxTaskAttempts bounds the attempts of a task run by TaskExecutionWorkflow
+const maxTaskAttempts = 3
+
+// retryBudgetExhaustedError is the type of the error a task fails with when
+// it is not retried because its workflow's retry budget is exhausted
+const retryBudgetExhaustedError = "RetryBudgetExhausted"
+
+// executeTask runs a task on an agent found or created by the meta-agent
+// system, preferring the agent of the hints. A failed attempt is retried by
+// the workflow rather than by Temporal so that each attempt selects its agent
+// again; agents that stopped responding are left out, which moves the task off
+// a dead agent. Each retry is spent from the workflow's retry budget, and the
+// retries Temporal makes of the activities are capped at what is left of it.
+func (w *WorkflowEngine) executeTask(ctx workflow.Context, task Task, sim *services.SimulationConfig, budget *retry.Budget, hints AgentSelectionHints) TaskExecutionResult {
+	logger := workflow.GetLogger(ctx)
+	ctx = workflow.WithActivityOptions(ctx, withRetryBudget(workflow.GetActivityOptions(ctx), budget))
+
+	// The watchdog heartbeats while the agent answers, so a dead agent is
+	// detected within the heartbeat timeout
+	attemptOptions := workflow.GetActivityOptions(ctx)
+	attemptOptions.HeartbeatTimeout = agentTaskHeartbeatTimeout
+	attemptOptions.RetryPolicy = &temporal.RetryPolicy{MaximumAttempts: 1}
+	attemptCtx := workflow.WithActivityOptions(ctx, attemptOptions)
+
+	for attempt := 1; ; attempt++ {
+		// Find or create suitable agent for the task using meta-agent system
+		var agent AgentInfo
+		err := workflow.ExecuteActivity(ctx, ActivityMetaAgentFindOrCreateAgentForTask, task, sim, hints).Get(ctx, &agent)
+		if err != nil {
+			chargeActivityRetries(ctx, budget, err)
+			logger.Error("Failed to find/create agent for task", 
+				zap.String("taskID", task.ID),
+				zap.Error(err))
+			return TaskExecutionResult{
+				TaskID: task.ID,
+				Status: models.ExecutionStatusFailed,
+				Error:  fmt.Sprintf("Agent selection failed: %v", err),
+			}
+		}
+
+		logger.Info("Agent selected for task", 
+			"taskID", task.ID,
+			"agentID", agent.ID,
+			"agentType", agent.Type,
+			"attempt", attempt)
+
+		// Tasks asking for refinement rounds run in a single activity whose
+		// rounds heartbeat, retried by Temporal on the same agent
+		refinement, err := taskRefinementConfig(task)
+		if err != nil {
+			return TaskExecutionResult{
+				TaskID:  task.ID,
+				Status:  models.ExecutionStatusFailed,
+				Error:   err.Error(),
+				AgentID: agent.ID,
+			}
+		}
+		executeActivity, executeCtx := ActivityMetaAgentExecuteTaskWithAgent, attemptCtx
+		if refinement != nil {
+			executeActivity, executeCtx = ActivityIterativeTask, ctx
+		}
+
+		var taskResult TaskExecutionResult
+		err = workflow.ExecuteActivity(executeCtx, executeActivity, task, agent, sim).Get(ctx, &taskResult)
+		if err == nil {
+			return withAffinity(taskResult, hints, agent)
+		}
+		chargeActivityRetries(executeCtx, budget, err)
+		logger.Error("Task execution failed", 
+			zap.String("taskID", task.ID),
+			zap.String("agentID", agent.ID),
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+
+		failed := TaskExecutionResult{
+			TaskID:  task.ID,
+			Status:  models.ExecutionStatusFailed,
+			Error:   fmt.Sprintf("Execution failed: %v", err),
+			AgentID: agent.ID,
+		}
+		if refinement != nil || attempt >= maxTaskAttempts || !retryableTaskError(err) {
+			return withAffinity(failed, hints, agent)
+		}
+		if spendErr := budget.Spend(workflow.Now(ctx)); spendErr != nil {
+			logger.Warn("Task not retried, the workflow's retry budget is exhausted",
+				"taskID", task.ID,
+				"error", spendErr)
+			failed.Error = fmt.Sprintf("Execution failed: %v",
+				temporal.NewNonRetryableApplicationError(spendErr.Error(), retryBudgetExhaustedError, err))
+			return withAffinity(failed, hints, agent)
+		}
+		if agentUnresponsive(err) {
+			hints.Exclude = append(hints.Exclude, agent.ID)
+		}
+		if err := workflow.Sleep(ctx, retry.DefaultBackoff.Delay(attempt, task.ID)); err != nil {
+			return withAffinity(failed, hints, agent)
+		}
+		ctx = workflow.WithActivityOptions(ctx, withRetryBudget(workflow.GetActivityOptions(ctx), budget))
+	}
+}
+
+// withAffinity records on a task's result whether the agent preferred for its
+// group ran it
+func withAffinity(result TaskExecutionResult, hints AgentSelectionHints, agent AgentInfo) TaskExecutionResult {
+	result.GroupKey = hints.GroupKey
+	if hints.PreferredAgent != "" {
+		result.PreferredAgent = hints.PreferredAgent
+		result.Affinity, result.AffinityReason = agent.Affinity, agent.AffinityWhy
+	}
+	return result
+}
+
+// chargeActivityRetries spends the retries Temporal made of a failed activity
+// from the retry budget: an activity failing with a retryable error used all
+// the attempts its retry policy allowed
+func chargeActivityRetries(ctx workflow.Context, budget *retry.Budget, err error) {
+	policy := workflow.GetActivityOptions(ctx).RetryPolicy
+	if policy == nil || !retryableTaskError(err) {
+		return
+	}
+	budget.Charge(int(policy.MaximumAttempts) - 1)
+}
+
+// retryableTaskError reports whether a failed task attempt may be retried
+func retryableTaskError(err error) bool {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.NonRetryable() {
+		return false
+	}
+	return !temporal.IsCanceledError(err)
+}
+
 // Types for task execution workflow
 
 type TaskExecutionInput struct {
@@ -175,18 +352,45 @@ type Task struct {
 	Tags                  []string               `json:"tags"`
 	AcceptanceCriteria    []string               `json:"acceptance_criteria"`
 	TechnicalRequirements map[string]interface{} `json:"technical_requirements"`
+	Config                map[string]interface{} `json:"config,omitempty"` // e.g. refinement settings, see RefinementConfig
 }
 
 type TaskExecutionResult struct {
 	TaskID    string                 `json:"task_id"`
 	AgentID   string                 `json:"agent_id"`
-	Status    string                 `json:"status"`
+	Status    models.ExecutionStatus `json:"status"`
 	Output    map[string]interface{} `json:"output"`
 	Artifacts []Artifact             `json:"artifacts"`
 	Error     string                 `json:"error,omitempty"`
 	StartTime time.Time              `json:"start_time"`
 	EndTime   time.Time              `json:"end_time"`
 	Duration  time.Duration          `json:"duration"`
+
+	// The task's estimate, which aggregation compares with the duration
+	TaskType       string  `json:"task_type,omitempty"`
+	Complexity     string  `json:"complexity,omitempty"`
+	EstimatedHours float64 `json:"estimated_hours,omitempty"`
+
+	// The capabilities the task required of its agent
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// The task's group and whether the agent preferred for it ran the task
+	GroupKey       string `json:"group_key,omitempty"`
+	PreferredAgent string `json:"preferred_agent,omitempty"`
+	Affinity       string `json:"affinity,omitempty"`
+	AffinityReason string `json:"affinity_reason,omitempty"`
+
+	// What the task asked of its agent, which quality scoring checks the
+	// result against
+	ExpectedArtifacts int  `json:"expected_artifacts,omitempty"`
+	TestsRequested    bool `json:"tests_requested,omitempty"`
+	DocsRequested     bool `json:"docs_requested,omitempty"`
+
+	// The result's quality between 0 and 1, scored when results are
+	// aggregated
+	QualityScore   *float64           `json:"quality_score,omitempty"`
+	QualityScorer  string             `json:"quality_scorer,omitempty"`
+	QualitySignals map[string]float64 `json:"quality_signals,omitempty"`
 }
 
 type Artifact struct {
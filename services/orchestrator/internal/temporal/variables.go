@@ -0,0 +1,53 @@
+package temporal
+
+import (
+	"context"
+	"fmt"
+
+	"go.temporal.io/sdk/activity"
+
+	"orchestrator/internal/services"
+)
+
+// resolveVariables resolves the variables of the running activity's
+// workflow. Resolution happens inside activities so secret values never
+// reach workflow history. Without a variable service it returns nil and
+// references are passed through unchanged.
+func resolveVariables(ctx context.Context, variables *services.VariableService, environment string, overrides map[string]string) (*services.ResolvedVariables, error) {
+	if variables == nil {
+		return nil, nil
+	}
+	resolved, err := variables.ResolveForWorkflow(ctx, activity.GetInfo(ctx).WorkflowExecution.ID, environment, overrides)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve variables: %w", err)
+	}
+	return resolved, nil
+}
+
+// resolveTaskConfig resolves the variables of a task and expands the
+// references in its config. The environment is picked by config.environment.
+func resolveTaskConfig(ctx context.Context, variables *services.VariableService, task Task) (*services.ResolvedVariables, map[string]interface{}, error) {
+	environment, _ := task.Config["environment"].(string)
+	vars, err := resolveVariables(ctx, variables, environment, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if vars == nil || task.Config == nil {
+		return vars, task.Config, nil
+	}
+
+	config, err := vars.ExpandValue(ctx, task.Config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to expand task config: %w", err)
+	}
+	return vars, config.(map[string]interface{}), nil
+}
+
+// environmentFor returns the environment sent to the agent for a code
+// execution request
+func environmentFor(vars *services.ResolvedVariables, req CodeExecutionRequest) map[string]string {
+	if vars == nil {
+		return req.Environment
+	}
+	return vars.Env()
+}
@@ -0,0 +1,132 @@
+package temporal
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"orchestrator/internal/encryption"
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// echoAgent records the requests it receives and prints the environment
+// and config back, the way a careless script would
+type echoAgent struct {
+	fakeFleet
+	requests []*services.ExecuteTaskRequest
+}
+
+func (a *echoAgent) ExecuteTask(ctx context.Context, agentID string, req *services.ExecuteTaskRequest) (*services.TaskExecution, error) {
+	a.requests = append(a.requests, req)
+	switch req.Type {
+	case "prepare_environment":
+		return &services.TaskExecution{ID: "env-1", Output: map[string]interface{}{}}, nil
+	case "execute_code":
+		return &services.TaskExecution{Output: map[string]interface{}{
+			"output":    "token is tok-secret-1",
+			"exit_code": float64(0),
+			"metrics":   map[string]interface{}{"argv": []interface{}{"--token=tok-secret-1"}},
+		}}, nil
+	}
+	task := req.Input["task"].(map[string]interface{})
+	return &services.TaskExecution{Status: "completed", Output: map[string]interface{}{
+		"log": "using " + task["config"].(map[string]interface{})["token"].(string),
+	}}, nil
+}
+
+func setupVariableActivities(t *testing.T) (*gorm.DB, *services.VariableService) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	for _, stmt := range []string{
+		`CREATE TABLE workflows (id TEXT PRIMARY KEY, project_id TEXT, deleted_at DATETIME)`,
+		`CREATE TABLE projects (id TEXT PRIMARY KEY, environment_vars BLOB, secrets BLOB, deleted_at DATETIME)`,
+		`CREATE TABLE environments (id TEXT PRIMARY KEY, project_id TEXT, name TEXT, variables BLOB, deleted_at DATETIME)`,
+		`INSERT INTO workflows (id, project_id) VALUES ('` + testWorkflowID + `', 'project-1')`,
+		`INSERT INTO projects (id, environment_vars) VALUES ('project-1', CAST('{"STAGE": "dev", "REGION": "eu"}' AS BLOB))`,
+		`INSERT INTO environments (id, project_id, name, variables) VALUES ('e-1', 'project-1', 'prod', CAST('{"STAGE": "prod"}' AS BLOB))`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	keys, err := encryption.NewLocalKeyProvider("key-1", map[string][]byte{"key-1": bytes.Repeat([]byte{7}, 32)})
+	require.NoError(t, err)
+	secrets := services.NewDBSecretProvider(db, encryption.NewEncryptor(keys))
+	require.NoError(t, secrets.SetSecret(context.Background(), "project-1", "TOKEN", "tok-secret-1"))
+	return db, services.NewVariableService(db, secrets)
+}
+
+func TestCodeExecution_ResolvesVariablesAndRedactsOutput(t *testing.T) {
+	db, variables := setupVariableActivities(t)
+	agent := &echoAgent{}
+	activities := NewActivities(db, zap.NewNop(), nil, agent)
+	activities.variables = variables
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities.PrepareEnvironmentActivity)
+	env.RegisterActivity(activities.ExecuteCodeActivity)
+
+	req := CodeExecutionRequest{
+		Language:        "python",
+		Code:            "print(os.environ['API_TOKEN'])",
+		EnvironmentName: "prod",
+		Environment:     map[string]string{"API_TOKEN": "${secret.TOKEN}", "REGION": "us"},
+	}
+	agentInfo := AgentInfo{ID: "agent-1"}
+
+	_, err := env.ExecuteActivity(activities.PrepareEnvironmentActivity, agentInfo, req, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+	require.Len(t, agent.requests, 1)
+	assert.Equal(t, map[string]string{
+		"STAGE":     "prod",
+		"REGION":    "us",
+		"API_TOKEN": "tok-secret-1",
+	}, agent.requests[0].Input["environment"])
+
+	value, err := env.ExecuteActivity(activities.ExecuteCodeActivity, agentInfo, EnvironmentInfo{ID: "env-1"}, req, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+	var result ExecutionResult
+	require.NoError(t, value.Get(&result))
+	assert.Equal(t, "token is [REDACTED]", result.Output)
+	assert.Equal(t, []interface{}{"--token=[REDACTED]"}, result.Metrics["argv"])
+
+	req.Environment["MISSING"] = "${env.NOPE}"
+	_, err = env.ExecuteActivity(activities.PrepareEnvironmentActivity, agentInfo, req, (*services.SimulationConfig)(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unresolved variable reference")
+	assert.Len(t, agent.requests, 2, "nothing is sent with unresolved references")
+}
+
+func TestMetaAgentExecuteTask_ExpandsConfigAndRedactsOutput(t *testing.T) {
+	_, variables := setupVariableActivities(t)
+	agent := &echoAgent{}
+	activities := NewMetaAgentActivities(agent, zap.NewNop())
+	activities.variables = variables
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities.ExecuteTaskWithAgentActivity)
+
+	task := Task{ID: "task-1", Type: "backend", EstimatedHours: 1, Config: map[string]interface{}{
+		"token": "${secret.TOKEN}",
+		"stage": "${env.STAGE}",
+	}}
+	value, err := env.ExecuteActivity(activities.ExecuteTaskWithAgentActivity, task, AgentInfo{ID: "agent-1"}, (*services.SimulationConfig)(nil))
+	require.NoError(t, err)
+	var result TaskExecutionResult
+	require.NoError(t, value.Get(&result))
+
+	sent := agent.requests[0].Input["task"].(map[string]interface{})["config"]
+	assert.Equal(t, map[string]interface{}{"token": "tok-secret-1", "stage": "dev"}, sent)
+	assert.Equal(t, models.ExecutionStatusSucceeded, result.Status)
+	assert.Equal(t, "using [REDACTED]", result.Output["log"])
+}
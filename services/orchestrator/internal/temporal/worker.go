@@ -2,12 +2,15 @@ package temporal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
-	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
 	"go.temporal.io/api/workflowservice/v1"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -16,15 +19,52 @@ import (
 	"orchestrator/internal/services"
 )
 
+// Worker lifecycle defaults
+const (
+	defaultWorkerPollThreshold   = 3 * time.Minute
+	defaultWorkerHealthInterval  = 15 * time.Second
+	defaultWorkerRestartCooldown = 30 * time.Second
+)
+
+var (
+	// ErrWorkerRestartInProgress is returned when a restart is requested
+	// while another one is running
+	ErrWorkerRestartInProgress = errors.New("worker restart already in progress")
+	// ErrWorkerRestartCooldown is returned when restarts are requested too
+	// often
+	ErrWorkerRestartCooldown = errors.New("worker was restarted recently")
+)
+
+// taskWorker is the part of the SDK worker whose lifecycle is managed here
+type taskWorker interface {
+	Start() error
+	Stop()
+}
+
 // Worker represents a Temporal worker
 type Worker struct {
 	client            client.Client
-	worker            worker.Worker
 	logger            *zap.Logger
 	config            *config.TemporalConfig
 	workflows         *WorkflowEngine
+	workflowTypes     *WorkflowTypeRegistry
 	activities        *Activities
 	metaAgentActivities *MetaAgentActivities
+	resourceActivities  *ResourceActivities
+	interceptors        []interceptor.WorkerInterceptor
+
+	health          *workerHealth
+	healthInterval  time.Duration
+	restartCooldown time.Duration
+	newTaskWorker   func() taskWorker
+	errs            chan error
+	monitorStop     chan struct{}
+	monitorOnce     sync.Once
+
+	mu          sync.Mutex // guards worker, restarting and lastRestart
+	worker      taskWorker
+	restarting  bool
+	lastRestart time.Time
 }
 
 // NewWorker creates a new Temporal worker
@@ -35,23 +75,270 @@ func NewWorker(
 	intentClient *services.IntentClient,
 	agentClient *services.AgentClient,
 ) (*Worker, error) {
+	// Successful polls reported through the client's metrics drive the
+	// worker health
+	pollThreshold := time.Duration(cfg.WorkerPollThreshold) * time.Second
+	if pollThreshold <= 0 {
+		pollThreshold = defaultWorkerPollThreshold
+	}
+	health := newWorkerHealth(pollThreshold)
+
 	// Create Temporal client
-	temporalClient, err := createTemporalClient(cfg, logger)
+	temporalClient, err := createTemporalClient(cfg, logger, newHealthMetricsHandler(health, workerSlotCapacity(cfg)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Temporal client: %w", err)
 	}
 
-	// Create workflow engine
-	workflowEngine := NewWorkflowEngine(logger)
+	healthInterval := time.Duration(cfg.WorkerHealthInterval) * time.Second
+	if healthInterval <= 0 {
+		healthInterval = defaultWorkerHealthInterval
+	}
+
+	w := &Worker{
+		client:              temporalClient,
+		logger:              logger,
+		config:              cfg,
+		workflows:           NewWorkflowEngine(logger),
+		workflowTypes:       DefaultWorkflowTypes(),
+		activities:          NewActivities(db, logger, intentClient, agentClient),
+		metaAgentActivities: NewMetaAgentActivities(agentClient, logger),
+		resourceActivities:  NewResourceActivities(db, logger),
+		health:              health,
+		healthInterval:      healthInterval,
+		restartCooldown:     defaultWorkerRestartCooldown,
+		errs:                make(chan error, 1),
+		monitorStop:         make(chan struct{}),
+	}
+	w.newTaskWorker = w.newSDKWorker
+	w.worker = w.newTaskWorker()
+	return w, nil
+}
+
+// SetAgentSelectionPolicy sets the soft concurrency caps applied when
+// activities select agents for tasks. Activity structs are registered
+// whole, so the policy is set here rather than through their methods.
+func (w *Worker) SetAgentSelectionPolicy(policy services.AgentSelectionPolicy) {
+	w.activities.selection = policy
+	w.metaAgentActivities.selection = policy
+}
+
+// SetAgentPreferences makes agent selection honor the agents projects
+// prefer and exclude
+func (w *Worker) SetAgentPreferences(prefs *services.AgentPreferenceService) {
+	if w.activities != nil {
+		w.activities.preferences = prefs
+	}
+	if w.metaAgentActivities != nil {
+		w.metaAgentActivities.preferences = prefs
+	}
+}
+
+// SetSpawnCoordinator deduplicates and rate limits the dynamic agents the
+// meta-agent activities create
+func (w *Worker) SetSpawnCoordinator(spawns *services.SpawnCoordinator) {
+	if w.metaAgentActivities != nil {
+		w.metaAgentActivities.spawns = spawns
+	}
+}
+
+// SetVariableService makes activities resolve ${env.X} and ${secret.X}
+// references in execution requests and redact secrets from their results.
+// The {{secret:NAME}} and {{var:NAME}} placeholders of workflow inputs are
+// substituted into the requests sent to agents.
+func (w *Worker) SetVariableService(variables *services.VariableService) {
+	if w.activities != nil {
+		placeholders := newPlaceholderResolver(w.activities.db, variables)
+		w.activities.variables = variables
+		w.activities.placeholders = placeholders
+		w.activities.agentClient = withPlaceholders(w.activities.agentClient, placeholders)
+		if w.metaAgentActivities != nil {
+			w.metaAgentActivities.agentClient = withPlaceholders(w.metaAgentActivities.agentClient, placeholders)
+		}
+	}
+	if w.metaAgentActivities != nil {
+		w.metaAgentActivities.variables = variables
+	}
+}
+
+// SetEstimateRefiner makes activities execute tasks with estimates refined by
+// the durations of earlier tasks, and record the durations of new ones
+func (w *Worker) SetEstimateRefiner(estimates *services.EstimateRefiner) {
+	if w.activities != nil {
+		w.activities.estimates = estimates
+	}
+	if w.metaAgentActivities != nil {
+		w.metaAgentActivities.estimates = estimates
+	}
+}
+
+// SetStorageAccountant makes activities record stored artifacts and account
+// them against their project's storage quota
+func (w *Worker) SetStorageAccountant(storage *services.StorageAccountant) {
+	if w.activities != nil {
+		w.activities.storage = storage
+	}
+}
+
+// SetAgentGarbageCollector makes activities track the dynamic agents
+// workflows spawn and reuse, so they are cleaned up when the workflows finish
+func (w *Worker) SetAgentGarbageCollector(agentGC *services.AgentGarbageCollector) {
+	if w.metaAgentActivities != nil {
+		w.metaAgentActivities.agentGC = agentGC
+	}
+}
+
+// SetAgentPools makes the meta-agent activities give tasks idle warm pool
+// members before spawning agents, and count the tasks running on members
+func (w *Worker) SetAgentPools(pools *services.PoolManager) {
+	if w.metaAgentActivities != nil {
+		w.metaAgentActivities.pools = pools
+	}
+}
+
+// SetCapabilityDriftDetector makes activities record the outcomes of the
+// tasks agents run, from which the detector observes capability success rates
+func (w *Worker) SetCapabilityDriftDetector(drift *services.CapabilityDriftDetector) {
+	if w.activities != nil {
+		w.activities.drift = drift
+	}
+}
+
+// SetQualityScoring makes aggregation score task results with scorer, or
+// heuristically when it is nil, and record the scores with tracker
+func (w *Worker) SetQualityScoring(scorer QualityScorer, tracker *services.TaskQualityTracker) {
+	if w.activities != nil {
+		w.activities.quality = scorer
+		w.activities.qualityScores = tracker
+	}
+}
+
+// SetPlanValidator sets the limits execution plans are validated against
+// when they are created
+func (w *Worker) SetPlanValidator(plans *services.PlanValidator) {
+	if w.activities != nil {
+		w.activities.plans = plans
+	}
+}
+
+// SetRiskGate makes intent analysis apply the risk policy to the risks it
+// reports
+func (w *Worker) SetRiskGate(risks *services.RiskGate) {
+	if w.activities != nil {
+		w.activities.risks = risks
+	}
+}
+
+// SetBudgetEnforcer makes intent analysis record its estimates and check
+// them against the workflow's budget
+func (w *Worker) SetBudgetEnforcer(budgets *services.BudgetEnforcer) {
+	if w.activities != nil {
+		w.activities.budgets = budgets
+	}
+}
+
+// SetOutputPolicy makes activities offload execution outputs too large to
+// store inline
+func (w *Worker) SetOutputPolicy(outputs *services.OutputPolicy) {
+	if w.activities != nil {
+		w.activities.outputs = outputs
+	}
+}
+
+// SetArtifactService makes activities keep artifact contents in artifact
+// storage, so artifact versions can be diffed
+func (w *Worker) SetArtifactService(artifacts *services.ArtifactService) {
+	if w.activities != nil {
+		w.activities.artifacts = artifacts
+	}
+}
+
+// SetRepositoryFetcher sets the fetcher code analysis reads repositories with
+func (w *Worker) SetRepositoryFetcher(repositories services.RepositoryFetcher) {
+	if w.activities != nil {
+		w.activities.repositories = repositories
+	}
+}
+
+// SetStaticAnalyzer sets the analyzers code analysis checks code with
+func (w *Worker) SetStaticAnalyzer(analyzers *services.StaticAnalyzer) {
+	if w.activities != nil {
+		w.activities.analyzers = analyzers
+	}
+}
+
+// SetSecurityAnalyzer sets the scanners code analysis checks dependencies
+// and secrets with
+func (w *Worker) SetSecurityAnalyzer(scanners *services.SecurityAnalyzer) {
+	if w.activities != nil {
+		w.activities.scanners = scanners
+	}
+}
+
+// SetAnalysisCache sets the cache code analysis reuses the reports of
+// commits already analyzed from
+func (w *Worker) SetAnalysisCache(cache *services.AnalysisCache) {
+	if w.activities != nil {
+		w.activities.analysisCache = cache
+	}
+}
+
+// SetSCMProviders sets the providers code review reads pull requests from
+// and posts reviews to, by provider name
+func (w *Worker) SetSCMProviders(providers map[string]services.SCMProvider) {
+	if w.activities != nil {
+		w.activities.scm = providers
+	}
+}
+
+// SetAIReviewer sets the reviewer code review reviews diffs with; reviews
+// are skipped without one
+func (w *Worker) SetAIReviewer(reviewer services.AIReviewer) {
+	if w.activities != nil {
+		w.activities.reviewer = reviewer
+	}
+}
+
+// SetMetricsRecorder makes activities record time series of the metrics of
+// their executions
+func (w *Worker) SetMetricsRecorder(metrics *services.MetricsRecorder) {
+	if w.activities != nil {
+		w.activities.metrics = metrics
+	}
+}
+
+// SetResourceProvisioners sets the providers resources are provisioned with,
+// by provider name
+func (w *Worker) SetResourceProvisioners(provisioners map[string]services.ResourceProvisioner) {
+	if w.resourceActivities != nil {
+		w.resourceActivities.provisioners = provisioners
+	}
+}
 
-	// Create activities
-	activities := NewActivities(db, logger, intentClient, agentClient)
+// SetEnvironmentPromoter sets the promoter environment promotion workflows
+// apply promotions and create chained deployments with
+func (w *Worker) SetEnvironmentPromoter(promotions *services.EnvironmentPromoter) {
+	if w.activities != nil {
+		w.activities.promotions = promotions
+	}
+}
 
-	// Create meta-agent activities
-	metaAgentActivities := NewMetaAgentActivities(agentClient, logger)
+// SetFaultInjector makes the worker delay or fail activity attempts as the
+// injector's fault rules say. It must be called before the worker starts.
+func (w *Worker) SetFaultInjector(injector ActivityFaultInjector) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.interceptors = append(w.interceptors, NewFaultInterceptor(injector))
+	// SDK workers take their interceptors when created
+	if w.newTaskWorker != nil {
+		w.worker = w.newTaskWorker()
+	}
+}
 
-	// Create worker
-	w := worker.New(temporalClient, cfg.TaskQueue, worker.Options{
+// newSDKWorker creates an SDK worker with every workflow and activity
+// registered. Restarts replace the worker with a fresh one.
+func (w *Worker) newSDKWorker() taskWorker {
+	cfg := w.config
+	sdkWorker := worker.New(w.client, cfg.TaskQueue, worker.Options{
 		MaxConcurrentActivityExecutionSize:      cfg.WorkerOptions.MaxConcurrentActivityExecutionSize,
 		MaxConcurrentWorkflowTaskExecutionSize:  cfg.WorkerOptions.MaxConcurrentWorkflowTaskExecutionSize,
 		MaxConcurrentLocalActivityExecutionSize: cfg.WorkerOptions.MaxConcurrentLocalActivityExecutionSize,
@@ -65,23 +352,17 @@ func NewWorker(
 		DeadlockDetectionTimeout:                0, // Use default
 		MaxHeartbeatThrottleInterval:            0, // Use default
 		DefaultHeartbeatThrottleInterval:        0, // Use default
+		OnFatalError:                            w.onFatalError,
+		Interceptors:                            w.interceptors,
 	})
 
 	// Register workflows
-	registerWorkflows(w, workflowEngine)
+	registerWorkflows(sdkWorker, w.workflows, w.workflowTypes)
 
 	// Register activities
-	registerActivities(w, activities, metaAgentActivities)
+	registerActivities(sdkWorker, w.activities, w.metaAgentActivities, w.resourceActivities)
 
-	return &Worker{
-		client:              temporalClient,
-		worker:              w,
-		logger:              logger,
-		config:              cfg,
-		workflows:           workflowEngine,
-		activities:          activities,
-		metaAgentActivities: metaAgentActivities,
-	}, nil
+	return sdkWorker
 }
 
 // Start starts the worker
@@ -91,11 +372,19 @@ func (w *Worker) Start() error {
 		zap.String("namespace", w.config.Namespace),
 	)
 
+	w.mu.Lock()
+	tw := w.worker
+	w.mu.Unlock()
+
 	// Start worker
-	err := w.worker.Start()
-	if err != nil {
+	w.health.starting()
+	if err := tw.Start(); err != nil {
+		w.health.failed(err)
 		return fmt.Errorf("failed to start worker: %w", err)
 	}
+	w.health.running(false)
+
+	w.monitorOnce.Do(func() { go w.monitorHealth() })
 
 	w.logger.Info("Temporal worker started successfully with meta-agent capabilities")
 	return nil
@@ -104,18 +393,159 @@ func (w *Worker) Start() error {
 // Stop stops the worker
 func (w *Worker) Stop() {
 	w.logger.Info("Stopping Temporal worker")
-	w.worker.Stop()
+	close(w.monitorStop)
+
+	w.mu.Lock()
+	tw := w.worker
+	w.mu.Unlock()
+	tw.Stop()
+	w.health.stopped()
+
 	w.client.Close()
 	w.logger.Info("Temporal worker stopped")
 }
 
+// Restart stops the worker and starts a fresh one on the same client.
+// Restarts are refused while one is running and within the cooldown of the
+// previous one.
+func (w *Worker) Restart() error {
+	w.mu.Lock()
+	if w.restarting {
+		w.mu.Unlock()
+		return ErrWorkerRestartInProgress
+	}
+	if since := time.Since(w.lastRestart); !w.lastRestart.IsZero() && since < w.restartCooldown {
+		w.mu.Unlock()
+		return fmt.Errorf("%w: retry in %s", ErrWorkerRestartCooldown, (w.restartCooldown - since).Round(time.Second))
+	}
+	w.restarting = true
+	old := w.worker
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.restarting = false
+		w.lastRestart = time.Now()
+		w.mu.Unlock()
+	}()
+
+	w.logger.Warn("Restarting Temporal worker", zap.String("state", string(w.health.snapshot().State)))
+	old.Stop()
+	w.health.stopped()
+
+	tw := w.newTaskWorker()
+	w.mu.Lock()
+	w.worker = tw
+	w.mu.Unlock()
+
+	w.health.starting()
+	if err := tw.Start(); err != nil {
+		w.health.failed(err)
+		w.logger.Error("Temporal worker failed to start after restart", zap.Error(err))
+		return fmt.Errorf("failed to start worker: %w", err)
+	}
+	w.health.running(true)
+	w.logger.Info("Temporal worker restarted")
+	return nil
+}
+
+// Health reports the worker's current health
+func (w *Worker) Health() WorkerHealth {
+	if w.health == nil {
+		return WorkerHealth{State: WorkerStateStopped, Reason: "worker is not managed by this process"}
+	}
+	return w.health.snapshot()
+}
+
+// Errors delivers fatal worker errors. The worker has stopped when one is
+// received.
+func (w *Worker) Errors() <-chan error {
+	return w.errs
+}
+
+// onFatalError is called by the SDK before it stops the worker
+func (w *Worker) onFatalError(err error) {
+	w.health.failed(err)
+	w.logger.Error("Temporal worker stopped on fatal error", zap.Error(err))
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
+
+// monitorHealth periodically exports the worker health and logs changes
+func (w *Worker) monitorHealth() {
+	ticker := time.NewTicker(w.healthInterval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-w.monitorStop:
+			return
+		case <-ticker.C:
+		}
+
+		health := w.health.snapshot()
+		if health.Healthy {
+			workerHealthyGauge.Set(1)
+		} else {
+			workerHealthyGauge.Set(0)
+		}
+
+		switch {
+		case healthy && !health.Healthy:
+			w.logger.Warn("Temporal worker unhealthy",
+				zap.String("state", string(health.State)),
+				zap.String("reason", health.Reason),
+				zap.Any("pollers", health.Pollers))
+		case !healthy && health.Healthy:
+			w.logger.Info("Temporal worker healthy again")
+		}
+		healthy = health.Healthy
+	}
+}
+
+// workerSlotCapacity returns the task slots per SDK worker type, with the
+// SDK's default where the config leaves it unset
+func workerSlotCapacity(cfg *config.TemporalConfig) map[string]int {
+	orDefault := func(size int) int {
+		if size <= 0 {
+			return 1000
+		}
+		return size
+	}
+	return map[string]int{
+		"WorkflowWorker":      orDefault(cfg.WorkerOptions.MaxConcurrentWorkflowTaskExecutionSize),
+		"ActivityWorker":      orDefault(cfg.WorkerOptions.MaxConcurrentActivityExecutionSize),
+		"LocalActivityWorker": orDefault(cfg.WorkerOptions.MaxConcurrentLocalActivityExecutionSize),
+	}
+}
+
 // GetClient returns the Temporal client
 func (w *Worker) GetClient() client.Client {
 	return w.client
 }
 
+// WorkflowTypes returns the workflow types the worker runs, which the
+// workflow engine starts
+func (w *Worker) WorkflowTypes() *WorkflowTypeRegistry {
+	return w.workflowTypes
+}
+
+// NewClient creates a Temporal client encoding payloads as the worker does,
+// for tools that inspect workflows without running a worker
+func NewClient(cfg *config.TemporalConfig, logger *zap.Logger) (client.Client, error) {
+	return createTemporalClient(cfg, logger, nil)
+}
+
 // createTemporalClient creates a new Temporal client
-func createTemporalClient(cfg *config.TemporalConfig, logger *zap.Logger) (client.Client, error) {
+func createTemporalClient(cfg *config.TemporalConfig, logger *zap.Logger, metricsHandler client.MetricsHandler) (client.Client, error) {
+	dataConverter, err := NewPayloadDataConverter(cfg.PayloadCodec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payload data converter: %w", err)
+	}
+
 	// Configure client options
 	clientOptions := client.Options{
 		HostPort:  cfg.HostPort,
@@ -128,6 +558,9 @@ func createTemporalClient(cfg *config.TemporalConfig, logger *zap.Logger) (clien
 			KeepAliveTimeout:             0, // Use default
 			KeepAlivePermitWithoutStream: cfg.ClientOptions.KeepAlivePermitWithoutStream,
 		},
+		ContextPropagators: []workflow.ContextPropagator{NewRequestIDPropagator()},
+		MetricsHandler:     metricsHandler,
+		DataConverter:      dataConverter,
 	}
 
 	// Add metrics if enabled
@@ -158,82 +591,30 @@ func createTemporalClient(cfg *config.TemporalConfig, logger *zap.Logger) (clien
 		zap.String("namespace", cfg.Namespace),
 	)
 
+	if cfg.RegisterSearchAttributes {
+		if err := registerSearchAttributes(ctx, c.OperatorService(), cfg.Namespace, logger); err != nil {
+			logger.Warn("Failed to register search attributes", zap.Error(err))
+		}
+	}
+
 	return c, nil
 }
 
-// registerWorkflows registers all workflows with the worker
-func registerWorkflows(w worker.Worker, engine *WorkflowEngine) {
-	w.RegisterWorkflow(engine.IntentProcessingWorkflow)
-	w.RegisterWorkflow(engine.CodeExecutionWorkflow)
-	w.RegisterWorkflow(engine.CodeAnalysisWorkflow)
-	w.RegisterWorkflow(engine.CodeReviewWorkflow)
-	w.RegisterWorkflow(engine.DeploymentWorkflow)
-	w.RegisterWorkflow(engine.TaskExecutionWorkflow)
-	w.RegisterWorkflow(engine.CustomWorkflow)
-}
-
-// registerActivities registers all activities with the worker
-func registerActivities(w worker.Worker, activities *Activities, metaAgentActivities *MetaAgentActivities) {
-	// Intent processing activities
-	w.RegisterActivity(activities.AnalyzeIntentActivity)
-	w.RegisterActivity(activities.CreateExecutionPlanActivity)
-	w.RegisterActivity(activities.ExecuteStepActivity)
-	w.RegisterActivity(activities.AggregateResultsActivity)
-
-	// Code execution activities
-	w.RegisterActivity(activities.SelectAgentActivity)
-	w.RegisterActivity(activities.PrepareEnvironmentActivity)
-	w.RegisterActivity(activities.ExecuteCodeActivity)
-	w.RegisterActivity(activities.ProcessResultsActivity)
-	w.RegisterActivity(activities.CleanupEnvironmentActivity)
-
-	// Original task execution activities (kept for compatibility)
-	w.RegisterActivity(activities.FindOrCreateAgentForTaskActivity)
-	w.RegisterActivity(activities.ExecuteTaskWithAgentActivity)
-	w.RegisterActivity(activities.AggregateTaskResultsActivity)
-	w.RegisterActivity(activities.StoreArtifactsActivity)
-
-	// 🚀 NEW: Meta-Agent Integration Activities
-	w.RegisterActivityWithOptions(
-		metaAgentActivities.FindOrCreateAgentForTaskActivity,
-		activity.RegisterOptions{Name: "MetaAgentFindOrCreateAgentForTaskActivity"},
-	)
-	w.RegisterActivityWithOptions(
-		metaAgentActivities.ExecuteTaskWithAgentActivity,
-		activity.RegisterOptions{Name: "MetaAgentExecuteTaskWithAgentActivity"},
-	)
-	w.RegisterActivityWithOptions(
-		metaAgentActivities.OptimizeAgentPerformanceActivity,
-		activity.RegisterOptions{Name: "MetaAgentOptimizeAgentPerformanceActivity"},
-	)
+// registerWorkflows registers all workflows with the worker: the workflow of
+// every workflow type, and the resource workflows the resource manager
+// starts by name
+func registerWorkflows(w worker.WorkflowRegistry, engine *WorkflowEngine, types *WorkflowTypeRegistry) {
+	types.registerWith(w, engine)
+	w.RegisterWorkflow(engine.ResourceProvisioningWorkflow)
+	w.RegisterWorkflow(engine.ResourceTerminationWorkflow)
+}
 
-	// Code analysis activities
-	w.RegisterActivity(activities.FetchCodeActivity)
-	w.RegisterActivity(activities.RunStaticAnalysisActivity)
-	w.RegisterActivity(activities.RunSecurityAnalysisActivity)
-	w.RegisterActivity(activities.RunPerformanceAnalysisActivity)
-	w.RegisterActivity(activities.GenerateAnalysisReportActivity)
-
-	// Code review activities
-	w.RegisterActivity(FetchCodeChangesActivity)
-	w.RegisterActivity(RunAutomatedChecksActivity)
-	w.RegisterActivity(RunAIReviewActivity)
-	w.RegisterActivity(GenerateReviewSummaryActivity)
-	w.RegisterActivity(PostReviewCommentsActivity)
-
-	// Deployment activities
-	w.RegisterActivity(ValidateDeploymentActivity)
-	w.RegisterActivity(BuildArtifactsActivity)
-	w.RegisterActivity(RunDeploymentTestsActivity)
-	w.RegisterActivity(DeployToStagingActivity)
-	w.RegisterActivity(RunSmokeTestsActivity)
-	w.RegisterActivity(DeployToProductionActivity)
-	w.RegisterActivity(RunHealthCheckActivity)
-	w.RegisterActivity(RollbackDeploymentActivity)
-	w.RegisterActivity(UpdateDeploymentStatusActivity)
-
-	// Custom workflow activities
-	w.RegisterActivity(ExecuteCustomStepActivity)
+// registerActivities registers all activities with the worker, module by
+// module
+func registerActivities(w worker.ActivityRegistry, activities *Activities, metaAgentActivities *MetaAgentActivities, resourceActivities *ResourceActivities) {
+	for _, module := range activityModules(activities, metaAgentActivities, resourceActivities) {
+		module.Register(w)
+	}
 }
 
 // TemporalLogger adapts zap.Logger to Temporal's logger interface
@@ -283,137 +664,30 @@ func (l *TemporalLogger) fieldsFromKeyvals(keyvals []interface{}) []zap.Field {
 	return fields
 }
 
-// Placeholder activity functions - these would normally be in separate files
-
-func FetchCodeChangesActivity(ctx context.Context, req CodeReviewRequest) (*CodeChanges, error) {
-	return &CodeChanges{
-		Files:     []string{"file1.go", "file2.go"},
-		Additions: 100,
-		Deletions: 50,
-		Diff:      "diff content",
-	}, nil
-}
-
-func RunAutomatedChecksActivity(ctx context.Context, changes CodeChanges) (*AutomatedCheckResults, error) {
-	return &AutomatedCheckResults{
-		Passed:   true,
-		Checks:   []interface{}{},
-		Coverage: 0.85,
-	}, nil
-}
-
-func RunAIReviewActivity(ctx context.Context, changes CodeChanges, checks AutomatedCheckResults) (*AIReviewResult, error) {
-	return &AIReviewResult{
-		Issues:      []interface{}{},
-		Suggestions: []interface{}{},
-		CodeQuality: 0.9,
-	}, nil
-}
-
-func GenerateReviewSummaryActivity(ctx context.Context, checks AutomatedCheckResults, ai AIReviewResult) (*ReviewSummary, error) {
-	return &ReviewSummary{
-		Approved: true,
-		Comments: []interface{}{},
-		Score:    0.88,
-	}, nil
-}
-
-func PostReviewCommentsActivity(ctx context.Context, summary ReviewSummary) error {
-	return nil
-}
-
-func ValidateDeploymentActivity(ctx context.Context, req DeploymentRequest) (*DeploymentValidation, error) {
-	return &DeploymentValidation{
-		IsValid: true,
-		Errors:  []string{},
-	}, nil
-}
-
-func BuildArtifactsActivity(ctx context.Context, req DeploymentRequest) (*BuildResult, error) {
-	return &BuildResult{
-		ArtifactID: "artifact-123",
-		Version:    req.Version,
-		Size:       1024 * 1024 * 50, // 50MB
-	}, nil
-}
-
-func RunDeploymentTestsActivity(ctx context.Context, build BuildResult) (*TestResult, error) {
-	return &TestResult{
-		Passed:   true,
-		Tests:    100,
-		Failures: 0,
-		Coverage: 0.85,
-	}, nil
-}
-
-func DeployToStagingActivity(ctx context.Context, build BuildResult) (*DeploymentResult, error) {
-	return &DeploymentResult{
-		DeploymentID: "deploy-staging-123",
-		Environment:  "staging",
-		Version:      build.Version,
-		URL:          "https://staging.example.com",
-		Timestamp:    time.Now(),
-	}, nil
-}
-
-func RunSmokeTestsActivity(ctx context.Context, deployment DeploymentResult) (*TestResult, error) {
-	return &TestResult{
-		Passed:   true,
-		Tests:    20,
-		Failures: 0,
-		Coverage: 0.0,
-	}, nil
-}
-
-func DeployToProductionActivity(ctx context.Context, build BuildResult) (*DeploymentResult, error) {
-	return &DeploymentResult{
-		DeploymentID: "deploy-prod-123",
-		Environment:  "production",
-		Version:      build.Version,
-		URL:          "https://api.example.com",
-		Timestamp:    time.Now(),
-	}, nil
-}
-
-func RunHealthCheckActivity(ctx context.Context, deployment DeploymentResult) (*HealthCheckResult, error) {
-	return &HealthCheckResult{
-		IsHealthy: true,
-		Checks: map[string]bool{
-			"api":      true,
-			"database": true,
-			"cache":    true,
-		},
-	}, nil
-}
-
-func RollbackDeploymentActivity(ctx context.Context, deployment DeploymentResult) error {
-	return nil
-}
-
-func UpdateDeploymentStatusActivity(ctx context.Context, deployment DeploymentResult) error {
-	return nil
-}
-
-func ExecuteCustomStepActivity(ctx context.Context, step CustomStep) (interface{}, error) {
-	return map[string]interface{}{
-		"step":   step.Name,
-		"status": "completed",
-	}, nil
-}
-
 // Type definitions for placeholder activities
 type CodeReviewRequest struct {
+	ProjectID     string `json:"project_id,omitempty"`
 	Repository    string `json:"repository"`
 	Branch        string `json:"branch"`
 	CommitHash    string `json:"commit_hash"`
+	PullRequest   int    `json:"pull_request"`
+	Provider      string `json:"provider,omitempty"`    // SCM provider of the repository; github when empty
+	Integration   string `json:"integration,omitempty"` // Integration holding the token; matched by provider when empty
 	PostComments  bool   `json:"post_comments"`
 }
 
 type CodeChanges struct {
-	Files     []string `json:"files"`
-	Additions int      `json:"additions"`
-	Deletions int      `json:"deletions"`
-	Diff      string   `json:"diff"`
+	ProjectID   string                     `json:"project_id,omitempty"`
+	Files       []string                   `json:"files"`
+	Additions   int                        `json:"additions"`
+	Deletions   int                        `json:"deletions"`
+	Diff        string                     `json:"diff"`
+	FileChanges []services.PullRequestFile `json:"file_changes,omitempty"`
+	Title       string                     `json:"title,omitempty"`
+	Author      string                     `json:"author,omitempty"`
+	URL         string                     `json:"url,omitempty"`
+	BaseCommit  string                     `json:"base_commit,omitempty"`
+	HeadCommit  string                     `json:"head_commit,omitempty"`
 }
 
 type AutomatedCheckResults struct {
@@ -422,23 +696,52 @@ type AutomatedCheckResults struct {
 	Coverage float64       `json:"coverage"`
 }
 
+// AIReviewResult is the findings of the AI review of a pull request. The
+// review is skipped without a reviewer, and partial when some chunks of the
+// diff could not be reviewed in time.
 type AIReviewResult struct {
-	Issues      []interface{} `json:"issues"`
-	Suggestions []interface{} `json:"suggestions"`
-	CodeQuality float64       `json:"code_quality"`
+	Issues           []services.AIReviewFinding `json:"issues"`
+	CodeQuality      float64                    `json:"code_quality"`
+	Model            string                     `json:"model,omitempty"`
+	Chunks           int                        `json:"chunks"`
+	FailedChunks     int                        `json:"failed_chunks,omitempty"`
+	Partial          bool                       `json:"partial,omitempty"`
+	Skipped          bool                       `json:"skipped,omitempty"`
+	PromptTokens     int                        `json:"prompt_tokens,omitempty"`
+	CompletionTokens int                        `json:"completion_tokens,omitempty"`
 }
 
 type ReviewSummary struct {
-	Approved bool          `json:"approved"`
-	Comments []interface{} `json:"comments"`
-	Score    float64       `json:"score"`
+	Approved bool                     `json:"approved"`
+	Comments []services.ReviewComment `json:"comments"`
+	Score    float64                  `json:"score"`
 }
 
 type DeploymentRequest struct {
-	Version         string `json:"version"`
-	Environment     string `json:"environment"`
-	Repository      string `json:"repository"`
-	DeployToStaging bool   `json:"deploy_to_staging"`
+	Version                string `json:"version"`
+	Environment            string `json:"environment"`
+	Repository             string `json:"repository"`
+	DeployToStaging        bool   `json:"deploy_to_staging"`
+	RequireApproval        bool   `json:"require_approval"`
+	ApprovalTimeoutMinutes int    `json:"approval_timeout_minutes,omitempty"`
+}
+
+// DeploymentApprovalSignal is the signal name used to approve or reject a
+// deployment waiting in the approval step
+const DeploymentApprovalSignal = "deployment_approval"
+
+// DeploymentApproval is the payload of the approval signal
+type DeploymentApproval struct {
+	Approved bool   `json:"approved"`
+	Approver string `json:"approver"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// DeploymentContinuation carries deployment progress across continue-as-new
+type DeploymentContinuation struct {
+	ContinuationInfo
+	Build   BuildResult       `json:"build"`
+	Staging *DeploymentResult `json:"staging,omitempty"`
 }
 
 type DeploymentValidation struct {
@@ -475,7 +778,18 @@ type HealthCheckResult struct {
 type CustomStep struct {
 	Name            string                 `json:"name"`
 	Config          map[string]interface{} `json:"config"`
-	TimeoutSeconds  int                    `json:"timeout_seconds"`
-	MaxRetries      int                    `json:"max_retries"`
+	MaxRetries      int                    `json:"max_retries"` // Max attempts of definitions from before max_attempts
 	ContinueOnError bool                   `json:"continue_on_error"`
+	// Timeouts and retries of the step, over those of custom workflows
+	services.StepActivitySettings
+}
+
+// activitySettings returns the activity settings of the step, max_retries
+// standing in for max_attempts when it is not set
+func (s CustomStep) activitySettings() services.StepActivitySettings {
+	settings := s.StepActivitySettings
+	if settings.MaxAttempts == 0 {
+		settings.MaxAttempts = s.MaxRetries
+	}
+	return settings
 }
@@ -0,0 +1,267 @@
+package temporal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.temporal.io/sdk/client"
+)
+
+// WorkerState is the lifecycle state of the Temporal worker
+type WorkerState string
+
+const (
+	WorkerStateStarting WorkerState = "starting"
+	WorkerStateRunning  WorkerState = "running"
+	WorkerStateStopped  WorkerState = "stopped"
+	WorkerStateFailed   WorkerState = "failed"
+)
+
+// WorkerHealth is a snapshot of the Temporal worker's health
+type WorkerHealth struct {
+	State          WorkerState    `json:"state"`
+	Healthy        bool           `json:"healthy"`
+	Reason         string         `json:"reason,omitempty"`
+	StartedAt      *time.Time     `json:"started_at,omitempty"`
+	LastPollAt     *time.Time     `json:"last_poll_at,omitempty"`
+	LastError      string         `json:"last_error,omitempty"`
+	Restarts       int            `json:"restarts"`
+	Pollers        map[string]int `json:"pollers,omitempty"`
+	SlotsAvailable map[string]int `json:"slots_available,omitempty"`
+}
+
+var (
+	workerHealthyGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orchestrator_temporal_worker_healthy",
+		Help: "1 when the Temporal worker is running and polled within the threshold",
+	})
+	workerLastPollGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "orchestrator_temporal_worker_last_poll_timestamp_seconds",
+		Help: "Unix time of the worker's last successful task queue poll",
+	})
+	workerSlotsAvailableGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_temporal_worker_task_slots_available",
+		Help: "Free task slots of the Temporal worker",
+	}, []string{"worker_type"})
+	workerSlotUtilizationGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_temporal_worker_task_slot_utilization",
+		Help: "Fraction of the Temporal worker's task slots in use, 0-1",
+	}, []string{"worker_type"})
+	workerPollersGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "orchestrator_temporal_worker_pollers",
+		Help: "Active task queue pollers of the Temporal worker",
+	}, []string{"poller_type"})
+)
+
+// workerHealth tracks the worker lifecycle and its last successful poll. A
+// running worker is healthy while it has polled within pollThreshold of now,
+// counting from its start until the first poll.
+type workerHealth struct {
+	mu             sync.Mutex
+	pollThreshold  time.Duration
+	now            func() time.Time
+	state          WorkerState
+	startedAt      time.Time
+	lastPoll       time.Time
+	lastErr        error
+	restarts       int
+	pollers        map[string]int
+	slotsAvailable map[string]int
+}
+
+func newWorkerHealth(pollThreshold time.Duration) *workerHealth {
+	return &workerHealth{
+		pollThreshold:  pollThreshold,
+		now:            time.Now,
+		state:          WorkerStateStopped,
+		pollers:        make(map[string]int),
+		slotsAvailable: make(map[string]int),
+	}
+}
+
+func (h *workerHealth) starting() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = WorkerStateStarting
+}
+
+func (h *workerHealth) running(restarted bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = WorkerStateRunning
+	h.startedAt = h.now()
+	h.lastErr = nil
+	if restarted {
+		h.restarts++
+	}
+}
+
+func (h *workerHealth) stopped() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = WorkerStateStopped
+	h.pollers = make(map[string]int)
+}
+
+func (h *workerHealth) failed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state = WorkerStateFailed
+	h.lastErr = err
+}
+
+// polled records a successful poll, with or without a task
+func (h *workerHealth) polled() {
+	h.mu.Lock()
+	now := h.now()
+	h.lastPoll = now
+	h.mu.Unlock()
+	workerLastPollGauge.Set(float64(now.Unix()))
+}
+
+func (h *workerHealth) setPollers(pollerType string, count int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pollers[pollerType] = count
+}
+
+func (h *workerHealth) setSlotsAvailable(workerType string, count int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.slotsAvailable[workerType] = count
+}
+
+// snapshot evaluates the worker's health
+func (h *workerHealth) snapshot() WorkerHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := WorkerHealth{
+		State:          h.state,
+		Restarts:       h.restarts,
+		Pollers:        make(map[string]int, len(h.pollers)),
+		SlotsAvailable: make(map[string]int, len(h.slotsAvailable)),
+	}
+	for pollerType, count := range h.pollers {
+		health.Pollers[pollerType] = count
+	}
+	for workerType, count := range h.slotsAvailable {
+		health.SlotsAvailable[workerType] = count
+	}
+	if !h.startedAt.IsZero() {
+		startedAt := h.startedAt
+		health.StartedAt = &startedAt
+	}
+	if !h.lastPoll.IsZero() {
+		lastPoll := h.lastPoll
+		health.LastPollAt = &lastPoll
+	}
+	if h.lastErr != nil {
+		health.LastError = h.lastErr.Error()
+	}
+
+	if h.state != WorkerStateRunning {
+		health.Reason = fmt.Sprintf("worker is %s", h.state)
+		return health
+	}
+
+	// Polls from before the latest start do not count
+	since := h.startedAt
+	if h.lastPoll.After(since) {
+		since = h.lastPoll
+	}
+	if silent := h.now().Sub(since); silent > h.pollThreshold {
+		health.Reason = fmt.Sprintf("no successful poll for %s", silent.Round(time.Second))
+		return health
+	}
+	health.Healthy = true
+	return health
+}
+
+// SDK metric names the health tracking listens to
+const (
+	sdkWorkflowPollSucceedCounter    = "temporal_workflow_task_queue_poll_succeed"
+	sdkWorkflowPollEmptyCounter      = "temporal_workflow_task_queue_poll_empty"
+	sdkActivityPollNoTaskCounter     = "temporal_activity_poll_no_task"
+	sdkActivityScheduleToStartTimer  = "temporal_activity_schedule_to_start_latency"
+	sdkWorkerTaskSlotsAvailableGauge = "temporal_worker_task_slots_available"
+	sdkNumPollersGauge               = "temporal_num_pollers"
+)
+
+// healthMetricsHandler receives the SDK's metrics. Poll results feed the
+// worker health; slot and poller gauges are exported to Prometheus. Other
+// metrics are dropped.
+type healthMetricsHandler struct {
+	health   *workerHealth
+	capacity map[string]int // task slots per worker type
+	tags     map[string]string
+}
+
+var _ client.MetricsHandler = (*healthMetricsHandler)(nil)
+
+func newHealthMetricsHandler(health *workerHealth, capacity map[string]int) *healthMetricsHandler {
+	return &healthMetricsHandler{health: health, capacity: capacity, tags: map[string]string{}}
+}
+
+func (m *healthMetricsHandler) WithTags(tags map[string]string) client.MetricsHandler {
+	merged := make(map[string]string, len(m.tags)+len(tags))
+	for key, value := range m.tags {
+		merged[key] = value
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+	return &healthMetricsHandler{health: m.health, capacity: m.capacity, tags: merged}
+}
+
+func (m *healthMetricsHandler) Counter(name string) client.MetricsCounter {
+	switch name {
+	case sdkWorkflowPollSucceedCounter, sdkWorkflowPollEmptyCounter, sdkActivityPollNoTaskCounter:
+		return metricsCounterFunc(func(int64) { m.health.polled() })
+	}
+	return client.MetricsNopHandler.Counter(name)
+}
+
+func (m *healthMetricsHandler) Gauge(name string) client.MetricsGauge {
+	switch name {
+	case sdkWorkerTaskSlotsAvailableGauge:
+		workerType := m.tags["worker_type"]
+		return metricsGaugeFunc(func(value float64) {
+			m.health.setSlotsAvailable(workerType, int(value))
+			workerSlotsAvailableGauge.WithLabelValues(workerType).Set(value)
+			if capacity := m.capacity[workerType]; capacity > 0 {
+				workerSlotUtilizationGauge.WithLabelValues(workerType).Set(1 - value/float64(capacity))
+			}
+		})
+	case sdkNumPollersGauge:
+		pollerType := m.tags["poller_type"]
+		return metricsGaugeFunc(func(value float64) {
+			m.health.setPollers(pollerType, int(value))
+			workerPollersGauge.WithLabelValues(pollerType).Set(value)
+		})
+	}
+	return client.MetricsNopHandler.Gauge(name)
+}
+
+func (m *healthMetricsHandler) Timer(name string) client.MetricsTimer {
+	if name == sdkActivityScheduleToStartTimer {
+		// Recorded when a poll returns an activity task
+		return metricsTimerFunc(func(time.Duration) { m.health.polled() })
+	}
+	return client.MetricsNopHandler.Timer(name)
+}
+
+type metricsCounterFunc func(int64)
+
+func (f metricsCounterFunc) Inc(delta int64) { f(delta) }
+
+type metricsGaugeFunc func(float64)
+
+func (f metricsGaugeFunc) Update(value float64) { f(value) }
+
+type metricsTimerFunc func(time.Duration)
+
+func (f metricsTimerFunc) Record(d time.Duration) { f(d) }
@@ -0,0 +1,228 @@
+package temporal
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/config"
+)
+
+// fakeClock is a settable clock for the health tracker
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestWorkerHealth_StateMachine(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	health := newWorkerHealth(time.Minute)
+	health.now = clock.Now
+
+	snapshot := health.snapshot()
+	assert.Equal(t, WorkerStateStopped, snapshot.State)
+	assert.False(t, snapshot.Healthy)
+
+	health.starting()
+	assert.False(t, health.snapshot().Healthy)
+
+	// Healthy from the start until the threshold passes without a poll
+	health.running(false)
+	assert.True(t, health.snapshot().Healthy)
+	clock.Advance(61 * time.Second)
+	snapshot = health.snapshot()
+	assert.False(t, snapshot.Healthy)
+	assert.Equal(t, "no successful poll for 1m1s", snapshot.Reason)
+
+	// A poll makes it healthy again
+	health.polled()
+	assert.True(t, health.snapshot().Healthy)
+	clock.Advance(30 * time.Second)
+	assert.True(t, health.snapshot().Healthy)
+	clock.Advance(31 * time.Second)
+	assert.False(t, health.snapshot().Healthy)
+
+	// A fatal error is unhealthy regardless of polls
+	health.polled()
+	health.failed(errors.New("namespace not found"))
+	snapshot = health.snapshot()
+	assert.Equal(t, WorkerStateFailed, snapshot.State)
+	assert.False(t, snapshot.Healthy)
+	assert.Equal(t, "namespace not found", snapshot.LastError)
+	assert.Equal(t, "worker is failed", snapshot.Reason)
+
+	// A restart clears the error and restarts the grace period; polls of
+	// the previous worker do not count
+	clock.Advance(2 * time.Minute)
+	health.running(true)
+	snapshot = health.snapshot()
+	assert.True(t, snapshot.Healthy)
+	assert.Empty(t, snapshot.LastError)
+	assert.Equal(t, 1, snapshot.Restarts)
+	clock.Advance(61 * time.Second)
+	assert.False(t, health.snapshot().Healthy)
+}
+
+func TestHealthMetricsHandler(t *testing.T) {
+	health := newWorkerHealth(time.Minute)
+	health.running(false)
+	handler := newHealthMetricsHandler(health, workerSlotCapacity(&config.TemporalConfig{
+		WorkerOptions: config.WorkerOptions{MaxConcurrentActivityExecutionSize: 100},
+	}))
+
+	// Unrelated metrics are accepted and dropped
+	handler.Counter("temporal_request").Inc(1)
+	handler.Timer("temporal_activity_execution_latency").Record(time.Second)
+	assert.Nil(t, health.snapshot().LastPollAt)
+
+	handler.WithTags(map[string]string{"poller_type": "activity_task"}).Counter(sdkActivityPollNoTaskCounter).Inc(1)
+	assert.NotNil(t, health.snapshot().LastPollAt)
+
+	activityWorker := handler.WithTags(map[string]string{"worker_type": "ActivityWorker"})
+	activityWorker.Gauge(sdkWorkerTaskSlotsAvailableGauge).Update(25)
+	handler.WithTags(map[string]string{"poller_type": "workflow_task"}).Gauge(sdkNumPollersGauge).Update(2)
+
+	snapshot := health.snapshot()
+	assert.Equal(t, map[string]int{"ActivityWorker": 25}, snapshot.SlotsAvailable)
+	assert.Equal(t, map[string]int{"workflow_task": 2}, snapshot.Pollers)
+	assert.Equal(t, float64(25), testutil.ToFloat64(workerSlotsAvailableGauge.WithLabelValues("ActivityWorker")))
+	assert.Equal(t, 0.75, testutil.ToFloat64(workerSlotUtilizationGauge.WithLabelValues("ActivityWorker")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(workerPollersGauge.WithLabelValues("workflow_task")))
+}
+
+// fakeTaskWorker records its lifecycle; Start blocks while block is open
+type fakeTaskWorker struct {
+	startErr error
+	block    chan struct{}
+	started  bool
+	stopped  bool
+}
+
+func (f *fakeTaskWorker) Start() error {
+	if f.block != nil {
+		<-f.block
+	}
+	f.started = true
+	return f.startErr
+}
+
+func (f *fakeTaskWorker) Stop() { f.stopped = true }
+
+// newTestWorker returns a worker whose task workers come from next
+func newTestWorker(next func() *fakeTaskWorker) *Worker {
+	w := &Worker{
+		logger:          zap.NewNop(),
+		config:          &config.TemporalConfig{TaskQueue: "test-queue"},
+		health:          newWorkerHealth(time.Minute),
+		healthInterval:  time.Hour,
+		restartCooldown: time.Minute,
+		errs:            make(chan error, 1),
+		monitorStop:     make(chan struct{}),
+	}
+	w.newTaskWorker = func() taskWorker { return next() }
+	w.worker = w.newTaskWorker()
+	return w
+}
+
+func TestWorker_Restart(t *testing.T) {
+	var created []*fakeTaskWorker
+	next := &fakeTaskWorker{}
+	w := newTestWorker(func() *fakeTaskWorker {
+		created = append(created, next)
+		return next
+	})
+	defer close(w.monitorStop)
+
+	require.NoError(t, w.Start())
+	assert.Equal(t, WorkerStateRunning, w.Health().State)
+
+	next = &fakeTaskWorker{}
+	require.NoError(t, w.Restart())
+	require.Len(t, created, 2)
+	assert.True(t, created[0].stopped)
+	assert.True(t, created[1].started)
+	assert.False(t, created[1].stopped)
+	health := w.Health()
+	assert.True(t, health.Healthy)
+	assert.Equal(t, 1, health.Restarts)
+
+	// Restarts within the cooldown are refused
+	assert.ErrorIs(t, w.Restart(), ErrWorkerRestartCooldown)
+	assert.Len(t, created, 2)
+
+	// A worker that fails to start leaves the health failed
+	w.restartCooldown = 0
+	next = &fakeTaskWorker{startErr: errors.New("connection refused")}
+	err := w.Restart()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection refused")
+	health = w.Health()
+	assert.Equal(t, WorkerStateFailed, health.State)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, "connection refused", health.LastError)
+
+	// The next restart recovers
+	next = &fakeTaskWorker{}
+	require.NoError(t, w.Restart())
+	assert.True(t, w.Health().Healthy)
+	assert.Equal(t, 2, w.Health().Restarts)
+}
+
+func TestWorker_RestartInProgress(t *testing.T) {
+	block := make(chan struct{})
+	first := true
+	w := newTestWorker(func() *fakeTaskWorker {
+		if first {
+			first = false
+			return &fakeTaskWorker{}
+		}
+		return &fakeTaskWorker{block: block}
+	})
+	defer close(w.monitorStop)
+	require.NoError(t, w.Start())
+
+	done := make(chan error)
+	go func() { done <- w.Restart() }()
+	require.Eventually(t, func() bool { return w.Health().State == WorkerStateStarting }, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, w.Restart(), ErrWorkerRestartInProgress)
+	close(block)
+	require.NoError(t, <-done)
+	assert.True(t, w.Health().Healthy)
+}
+
+func TestWorker_FatalErrorReachesErrors(t *testing.T) {
+	w := newTestWorker(func() *fakeTaskWorker { return &fakeTaskWorker{} })
+	defer close(w.monitorStop)
+	require.NoError(t, w.Start())
+
+	w.onFatalError(errors.New("namespace deleted"))
+
+	select {
+	case err := <-w.Errors():
+		assert.EqualError(t, err, "namespace deleted")
+	default:
+		t.Fatal("fatal error was not delivered")
+	}
+	health := w.Health()
+	assert.Equal(t, WorkerStateFailed, health.State)
+	assert.Equal(t, "namespace deleted", health.LastError)
+}
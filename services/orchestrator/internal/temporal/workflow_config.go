@@ -0,0 +1,147 @@
+package temporal
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/retry"
+	"orchestrator/internal/services"
+)
+
+// workflowConfig decodes the config of a workflow as the given workflow type.
+// Workflows pass their own type, so tests and children started without one
+// still get the defaults of that type. Decoding is pure, so it is safe in
+// workflow code.
+func workflowConfig(workflowType models.WorkflowType, wf *models.Workflow) (services.TypedWorkflowConfig, error) {
+	config, err := services.DecodeWorkflowConfig(workflowType, wf.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse workflow config: %w", err)
+	}
+	return config, nil
+}
+
+// intentConfig decodes the config of an intent processing workflow
+func intentConfig(wf *models.Workflow) (*services.IntentConfig, error) {
+	config, err := workflowConfig(models.WorkflowTypeIntent, wf)
+	if err != nil {
+		return nil, err
+	}
+	return config.(*services.IntentConfig), nil
+}
+
+// codeExecutionConfig decodes the config of a code execution workflow
+func codeExecutionConfig(wf *models.Workflow) (*services.CodeExecutionConfig, error) {
+	config, err := workflowConfig(models.WorkflowTypeExecution, wf)
+	if err != nil {
+		return nil, err
+	}
+	return config.(*services.CodeExecutionConfig), nil
+}
+
+// deploymentConfig decodes the config of a deployment workflow
+func deploymentConfig(wf *models.Workflow) (*services.DeploymentConfig, error) {
+	config, err := workflowConfig(models.WorkflowTypeDeployment, wf)
+	if err != nil {
+		return nil, err
+	}
+	return config.(*services.DeploymentConfig), nil
+}
+
+// taskExecutionConfig decodes the config of a task execution workflow
+func taskExecutionConfig(wf *models.Workflow) (*services.TaskExecutionConfig, error) {
+	config, err := workflowConfig(models.WorkflowTypeTaskExecution, wf)
+	if err != nil {
+		return nil, err
+	}
+	return config.(*services.TaskExecutionConfig), nil
+}
+
+// activityOptions returns the options of a workflow's activities as set by
+// its config, backing off retries up to maximumInterval
+func activityOptions(settings services.ActivitySettings, maximumInterval time.Duration) workflow.ActivityOptions {
+	return workflow.ActivityOptions{
+		StartToCloseTimeout: time.Duration(settings.TimeoutSeconds) * time.Second,
+		HeartbeatTimeout:    time.Duration(settings.HeartbeatTimeoutSeconds) * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    retry.DefaultBackoff.Initial,
+			BackoffCoefficient: retry.DefaultBackoff.Multiplier,
+			MaximumInterval:    maximumInterval,
+			MaximumAttempts:    int32(settings.MaxAttempts),
+		},
+	}
+}
+
+// customActivityOptions are the options of the steps of custom workflows,
+// which have no activity settings of their own
+var customActivityOptions = workflow.ActivityOptions{
+	HeartbeatTimeout: 30 * time.Second,
+	RetryPolicy: &temporal.RetryPolicy{
+		InitialInterval:    time.Second,
+		BackoffCoefficient: 2.0,
+		MaximumInterval:    time.Minute,
+	},
+}
+
+// stepActivityOptions returns the options of a step's activity: the
+// settings the step sets over options, those of its workflow. A heartbeat
+// timeout the step inherits is dropped when it is not shorter than the
+// step's timeouts; one the step sets must be, or the plan is invalid.
+func stepActivityOptions(options workflow.ActivityOptions, settings services.StepActivitySettings) (workflow.ActivityOptions, error) {
+	if settings.TimeoutSeconds > 0 {
+		options.StartToCloseTimeout = time.Duration(settings.TimeoutSeconds) * time.Second
+	}
+	if settings.ScheduleToCloseSeconds > 0 {
+		options.ScheduleToCloseTimeout = time.Duration(settings.ScheduleToCloseSeconds) * time.Second
+	}
+	shortest := options.StartToCloseTimeout
+	if shortest == 0 || (options.ScheduleToCloseTimeout > 0 && options.ScheduleToCloseTimeout < shortest) {
+		shortest = options.ScheduleToCloseTimeout
+	}
+	if settings.HeartbeatSeconds > 0 {
+		options.HeartbeatTimeout = time.Duration(settings.HeartbeatSeconds) * time.Second
+		if shortest > 0 && options.HeartbeatTimeout >= shortest {
+			return options, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("heartbeat_seconds %d must be less than the step's timeout of %s", settings.HeartbeatSeconds, shortest),
+				invalidPlanError, nil)
+		}
+	} else if shortest > 0 && options.HeartbeatTimeout >= shortest {
+		options.HeartbeatTimeout = 0
+	}
+
+	if settings.MaxAttempts > 0 || len(settings.NonRetryableErrorTypes) > 0 {
+		policy := temporal.RetryPolicy{}
+		if options.RetryPolicy != nil {
+			policy = *options.RetryPolicy
+		}
+		if settings.MaxAttempts > 0 {
+			policy.MaximumAttempts = int32(settings.MaxAttempts)
+		}
+		policy.NonRetryableErrorTypes = append(append([]string(nil), policy.NonRetryableErrorTypes...),
+			settings.NonRetryableErrorTypes...)
+		options.RetryPolicy = &policy
+	}
+	return options, nil
+}
+
+// retryBudget creates the retry budget of a workflow from its config, its
+// window starting now
+func retryBudget(ctx workflow.Context, settings services.RetryBudgetSettings) *retry.Budget {
+	return retry.NewBudget(settings.MaxRetries, time.Duration(settings.WindowSeconds)*time.Second, workflow.Now(ctx))
+}
+
+// withRetryBudget caps the attempts Temporal makes of an activity at the
+// retries left in a workflow's budget, so retries by Temporal and by the
+// workflow together stay within it
+func withRetryBudget(options workflow.ActivityOptions, budget *retry.Budget) workflow.ActivityOptions {
+	if options.RetryPolicy == nil || budget == nil {
+		return options
+	}
+	policy := *options.RetryPolicy
+	policy.MaximumAttempts = int32(budget.Attempts(int(policy.MaximumAttempts)))
+	options.RetryPolicy = &policy
+	return options
+}
@@ -0,0 +1,48 @@
+package temporal
+
+import "orchestrator/internal/models"
+
+// workflowSourceFor returns the git context a workflow was started for,
+// nil when it has none or its input names another repository
+func workflowSourceFor(wf *models.Workflow, repository string) *models.WorkflowSource {
+	source := models.ParseWorkflowSource(wf.Metadata)
+	if source == nil || (repository != "" && repository != source.Repository) {
+		return nil
+	}
+	return source
+}
+
+// defaultAnalysisSource defaults the repository and branch of a code
+// analysis to the git context the workflow was started for. Inputs set
+// explicitly are kept, and an analysis of another repository gets no
+// defaults.
+func defaultAnalysisSource(wf *models.Workflow, req *CodeAnalysisRequest) {
+	source := workflowSourceFor(wf, req.Repository)
+	if source == nil {
+		return
+	}
+	req.Repository = source.Repository
+	if req.Branch == "" {
+		req.Branch = source.Branch
+	}
+}
+
+// defaultReviewSource defaults the repository, branch, commit and pull
+// request of a code review to the git context the workflow was started for,
+// like defaultAnalysisSource.
+func defaultReviewSource(wf *models.Workflow, req *CodeReviewRequest) {
+	source := workflowSourceFor(wf, req.Repository)
+	if source == nil {
+		return
+	}
+	req.Repository = source.Repository
+	if req.Branch == "" {
+		req.Branch = source.Branch
+	}
+	if req.CommitHash == "" {
+		req.CommitHash = source.CommitSHA
+	}
+	if req.PullRequest == 0 {
+		req.PullRequest = source.PRNumber
+	}
+}
@@ -0,0 +1,68 @@
+package temporal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// sourceMetadata is the metadata of a workflow started by CI for a commit
+var sourceMetadata = json.RawMessage(`{"source": {"repository": "https://github.com/acme/orders", "branch": "release/2.4",
+	"commit_sha": "9fceb02d0ae598e9", "ci_run_url": "https://ci.example.com/runs/42", "pr_number": 17}}`)
+
+func TestCodeAnalysisWorkflow_DefaultsInputToSource(t *testing.T) {
+	analyze := func(req CodeAnalysisRequest) CodeAnalysisRequest {
+		activities := &Activities{}
+		env, _ := analysisTestEnvironment(t, activities)
+		var lookedUp CodeAnalysisRequest
+		env.OnActivity(activities.LookupAnalysisCacheActivity, mock.Anything, mock.Anything, "abc123").
+			Run(func(args mock.Arguments) { lookedUp = args.Get(1).(CodeAnalysisRequest) }).
+			Return(&AnalysisCacheLookup{Result: services.AnalysisCacheHit, Report: &AnalysisReport{}}, nil)
+
+		wf := analysisWorkflow(t, req)
+		wf.Metadata = sourceMetadata
+		env.ExecuteWorkflow("CodeAnalysisWorkflow", wf)
+		require.True(t, env.IsWorkflowCompleted())
+		require.NoError(t, env.GetWorkflowError())
+		return lookedUp
+	}
+
+	defaulted := analyze(CodeAnalysisRequest{Path: "services/orders"})
+	assert.Equal(t, "https://github.com/acme/orders", defaulted.Repository)
+	assert.Equal(t, "release/2.4", defaulted.Branch)
+	assert.Equal(t, "services/orders", defaulted.Path)
+
+	explicit := analyze(CodeAnalysisRequest{Repository: "https://github.com/acme/orders", Branch: "main"})
+	assert.Equal(t, "main", explicit.Branch, "explicit inputs win over the source")
+
+	other := analyze(CodeAnalysisRequest{Repository: "https://github.com/acme/billing"})
+	assert.Equal(t, "https://github.com/acme/billing", other.Repository)
+	assert.Empty(t, other.Branch, "the source's branch is not used for another repository")
+}
+
+func TestDefaultReviewSource(t *testing.T) {
+	req := CodeReviewRequest{}
+	defaultReviewSource(&models.Workflow{Metadata: sourceMetadata}, &req)
+	assert.Equal(t, CodeReviewRequest{Repository: "https://github.com/acme/orders", Branch: "release/2.4",
+		CommitHash: "9fceb02d0ae598e9", PullRequest: 17}, req)
+
+	req = CodeReviewRequest{PullRequest: 3}
+	defaultReviewSource(&models.Workflow{Metadata: sourceMetadata}, &req)
+	assert.Equal(t, 3, req.PullRequest, "explicit inputs win over the source")
+	assert.Equal(t, "9fceb02d0ae598e9", req.CommitHash)
+
+	req = CodeReviewRequest{Repository: "https://github.com/acme/billing", PullRequest: 3}
+	defaultReviewSource(&models.Workflow{Metadata: sourceMetadata}, &req)
+	assert.Equal(t, CodeReviewRequest{Repository: "https://github.com/acme/billing", PullRequest: 3}, req,
+		"reviews of another repository keep their input")
+
+	req = CodeReviewRequest{}
+	defaultReviewSource(&models.Workflow{Metadata: json.RawMessage(`{"bypass_cache": true}`)}, &req)
+	assert.Equal(t, CodeReviewRequest{}, req, "workflows without a source keep their input")
+}
@@ -0,0 +1,169 @@
+package temporal
+
+import (
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/retry"
+	"orchestrator/internal/services"
+)
+
+// Phases of a workflow besides the step it is running
+const (
+	workflowPhaseStarting       = "starting"
+	workflowPhaseCompleted      = "completed"
+	workflowPhaseFailed         = "failed"
+	workflowPhaseContinuedAsNew = "continued_as_new"
+)
+
+// Steps of the intent processing workflow
+const (
+	intentStepAnalyze          = "analyze_intent"
+	intentStepRiskConfirmation = "risk_confirmation" // Only when the risk policy requires confirmation
+	intentStepPlan             = "create_execution_plan"
+	intentStepExecute          = "execute_steps"
+	intentStepAggregate        = "aggregate_results"
+)
+
+// Steps of the task execution workflow besides its tasks
+const (
+	taskStepAggregate = "aggregate_results"
+	taskStepStore     = "store_artifacts"
+)
+
+// Steps of the deployment workflow
+const (
+	deploymentStepValidate     = "validate"
+	deploymentStepBuild        = "build"
+	deploymentStepTest         = "test"
+	deploymentStepStaging      = "deploy_staging"
+	deploymentStepApproval     = "approval"
+	deploymentStepProduction   = "deploy_production"
+	deploymentStepHealthCheck  = "health_check"
+	deploymentStepUpdateStatus = "update_status"
+)
+
+// Steps of the environment promotion workflow
+const (
+	promotionStepApply    = "apply"
+	promotionStepSnapshot = "record_snapshot"
+	promotionStepDeploy   = "deploy" // Only when the promotion chains a deployment
+)
+
+// promotionSteps lists the steps a promotion runs
+func promotionSteps(deploy bool) []string {
+	steps := []string{promotionStepApply, promotionStepSnapshot}
+	if deploy {
+		steps = append(steps, promotionStepDeploy)
+	}
+	return steps
+}
+
+// deploymentSteps lists the steps a deployment runs
+func deploymentSteps(req DeploymentRequest) []string {
+	steps := []string{deploymentStepValidate, deploymentStepBuild, deploymentStepTest}
+	if req.DeployToStaging {
+		steps = append(steps, deploymentStepStaging)
+	}
+	if req.RequireApproval {
+		steps = append(steps, deploymentStepApproval)
+	}
+	return append(steps, deploymentStepProduction, deploymentStepHealthCheck, deploymentStepUpdateStatus)
+}
+
+// taskStep names the step running a task
+func taskStep(taskID string) string {
+	return "task:" + taskID
+}
+
+// buildArtifactRef refers to the artifact a deployment built
+func buildArtifactRef(build BuildResult) services.WorkflowArtifactRef {
+	return services.WorkflowArtifactRef{ID: build.ArtifactID, Name: build.Version, Type: "build"}
+}
+
+// workflowProgress is what a workflow answers the state query with. The
+// workflow updates it as it runs; the query handler only copies it, so
+// queries stay cheap and never run activities.
+type workflowProgress struct {
+	state services.WorkflowState
+}
+
+// trackProgress registers the state query of a workflow. Workflows call it
+// first, before anything that blocks, so every run answers the query from
+// its start.
+func trackProgress(ctx workflow.Context, wf *models.Workflow) *workflowProgress {
+	progress := &workflowProgress{state: services.WorkflowState{
+		WorkflowID:     wf.ID,
+		Phase:          workflowPhaseStarting,
+		CompletedSteps: []string{},
+		PendingSteps:   []string{},
+		Artifacts:      []services.WorkflowArtifactRef{},
+	}}
+	if err := workflow.SetQueryHandler(ctx, services.WorkflowStateQuery, progress.snapshot); err != nil {
+		workflow.GetLogger(ctx).Warn("Failed to register the workflow state query", "error", err)
+	}
+	return progress
+}
+
+// snapshot answers the state query with a copy of the progress
+func (p *workflowProgress) snapshot() (services.WorkflowState, error) {
+	state := p.state
+	state.CompletedSteps = append([]string{}, p.state.CompletedSteps...)
+	state.PendingSteps = append([]string{}, p.state.PendingSteps...)
+	state.Artifacts = append([]services.WorkflowArtifactRef{}, p.state.Artifacts...)
+	if p.state.RetryBudget != nil {
+		budget := *p.state.RetryBudget
+		state.RetryBudget = &budget
+	}
+	return state, nil
+}
+
+// plan adds steps the workflow is going to run
+func (p *workflowProgress) plan(steps ...string) {
+	p.state.PendingSteps = append(p.state.PendingSteps, steps...)
+}
+
+// start makes a step the current phase
+func (p *workflowProgress) start(step string) {
+	p.state.Phase = step
+}
+
+// complete moves a step from the pending to the completed steps
+func (p *workflowProgress) complete(step string) {
+	for i, pending := range p.state.PendingSteps {
+		if pending == step {
+			p.state.PendingSteps = append(p.state.PendingSteps[:i:i], p.state.PendingSteps[i+1:]...)
+			break
+		}
+	}
+	p.state.CompletedSteps = append(p.state.CompletedSteps, step)
+}
+
+// addArtifacts records artifacts the workflow produced
+func (p *workflowProgress) addArtifacts(refs ...services.WorkflowArtifactRef) {
+	p.state.Artifacts = append(p.state.Artifacts, refs...)
+}
+
+// trackRetryBudget reports a workflow's retry budget in its state as the
+// workflow spends it
+func (p *workflowProgress) trackRetryBudget(budget *retry.Budget) {
+	p.state.RetryBudget = budget
+}
+
+// recordError keeps an error the workflow went on after
+func (p *workflowProgress) recordError(message string) {
+	p.state.LastError = message
+}
+
+// finish sets the final phase from the error the workflow returns
+func (p *workflowProgress) finish(err error) {
+	switch {
+	case err == nil:
+		p.state.Phase = workflowPhaseCompleted
+	case workflow.IsContinueAsNewError(err):
+		p.state.Phase = workflowPhaseContinuedAsNew
+	default:
+		p.state.Phase = workflowPhaseFailed
+		p.state.LastError = err.Error()
+	}
+}
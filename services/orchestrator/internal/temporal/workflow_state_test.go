@@ -0,0 +1,127 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// queryState runs the state query against the workflow of env
+func queryState(t *testing.T, env *testsuite.TestWorkflowEnvironment) services.WorkflowState {
+	value, err := env.QueryWorkflow(services.WorkflowStateQuery)
+	require.NoError(t, err)
+	var state services.WorkflowState
+	require.NoError(t, value.Get(&state))
+	return state
+}
+
+func TestDeploymentWorkflow_StateQuery(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	wf := deploymentWorkflow(t, DeploymentRequest{Version: "1.2.0", DeployToStaging: true, RequireApproval: true})
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	for _, activity := range []interface{}{
+		ValidateDeploymentActivity, RunDeploymentTestsActivity, DeployToStagingActivity, RunSmokeTestsActivity,
+		DeployToProductionActivity, RunHealthCheckActivity, UpdateDeploymentStatusActivity,
+	} {
+		env.RegisterActivity(activity)
+	}
+	env.OnActivity(BuildArtifactsActivity, mock.Anything, mock.Anything).
+		Return(&BuildResult{ArtifactID: "artifact-123", Version: "1.2.0"}, nil).After(10 * time.Minute)
+
+	var building, awaitingApproval services.WorkflowState
+	env.RegisterDelayedCallback(func() { building = queryState(t, env) }, 5*time.Minute)
+	env.RegisterDelayedCallback(func() { awaitingApproval = queryState(t, env) }, 30*time.Minute)
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(DeploymentApprovalSignal, DeploymentApproval{Approved: true, Approver: "release-manager"})
+	}, time.Hour)
+
+	env.ExecuteWorkflow(engine.DeploymentWorkflow, wf, (*DeploymentContinuation)(nil))
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+
+	// While building, nothing has been produced yet
+	assert.Equal(t, "wf-1", building.WorkflowID)
+	assert.Equal(t, deploymentStepBuild, building.Phase)
+	assert.Equal(t, []string{deploymentStepValidate}, building.CompletedSteps)
+	assert.Equal(t, []string{
+		deploymentStepBuild, deploymentStepTest, deploymentStepStaging, deploymentStepApproval,
+		deploymentStepProduction, deploymentStepHealthCheck, deploymentStepUpdateStatus,
+	}, building.PendingSteps)
+	assert.Empty(t, building.Artifacts)
+
+	// Waiting for approval, the build artifact is known
+	assert.Equal(t, deploymentStepApproval, awaitingApproval.Phase)
+	assert.Equal(t, []string{
+		deploymentStepValidate, deploymentStepBuild, deploymentStepTest, deploymentStepStaging,
+	}, awaitingApproval.CompletedSteps)
+	assert.Equal(t, []string{
+		deploymentStepApproval, deploymentStepProduction, deploymentStepHealthCheck, deploymentStepUpdateStatus,
+	}, awaitingApproval.PendingSteps)
+	assert.Equal(t, []services.WorkflowArtifactRef{{ID: "artifact-123", Name: "1.2.0", Type: "build"}}, awaitingApproval.Artifacts)
+
+	// The completed workflow still answers
+	final := queryState(t, env)
+	assert.Equal(t, workflowPhaseCompleted, final.Phase)
+	assert.Equal(t, deploymentSteps(DeploymentRequest{DeployToStaging: true, RequireApproval: true}), final.CompletedSteps)
+	assert.Empty(t, final.PendingSteps)
+	assert.Empty(t, final.LastError)
+}
+
+func TestIntentProcessingWorkflow_StateQueryReportsFailure(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	input, err := json.Marshal(IntentData{})
+	require.NoError(t, err)
+	wf := &models.Workflow{ID: "wf-1", Type: models.WorkflowTypeIntent, Input: input}
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.IntentProcessingWorkflow)
+	env.RegisterActivityWithOptions(func(ctx context.Context, intent IntentData) (*IntentAnalysisResult, error) {
+		return &IntentAnalysisResult{}, nil
+	}, activity.RegisterOptions{Name: "AnalyzeIntentActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, analysis IntentAnalysisResult) (*ExecutionPlan, error) {
+		return &ExecutionPlan{Steps: []ExecutionStep{{ID: "step-1"}}}, nil
+	}, activity.RegisterOptions{Name: "CreateExecutionPlanActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, step ExecutionStep, sim *services.SimulationConfig) (*StepResult, error) {
+		return nil, nil
+	}, activity.RegisterOptions{Name: "ExecuteStepActivity"})
+	env.RegisterActivityWithOptions(func(ctx context.Context, results []StepResult) (*WorkflowResult, error) {
+		return nil, temporal.NewNonRetryableApplicationError("results store unavailable", "Unavailable", nil)
+	}, activity.RegisterOptions{Name: "AggregateResultsActivity"})
+	env.OnActivity("ExecuteStepActivity", mock.Anything, mock.Anything, mock.Anything).
+		Return(&StepResult{StepID: "step-1", Status: models.ExecutionStatusSucceeded, Error: "lint warnings"}, nil).
+		After(10 * time.Minute)
+
+	var executing services.WorkflowState
+	env.RegisterDelayedCallback(func() { executing = queryState(t, env) }, 5*time.Minute)
+
+	env.ExecuteWorkflow(engine.IntentProcessingWorkflow, wf)
+	require.True(t, env.IsWorkflowCompleted())
+	require.Error(t, env.GetWorkflowError())
+
+	assert.Equal(t, intentStepExecute, executing.Phase)
+	assert.Equal(t, []string{intentStepAnalyze, intentStepPlan}, executing.CompletedSteps)
+	assert.Equal(t, []string{intentStepExecute, intentStepAggregate}, executing.PendingSteps)
+	assert.Empty(t, executing.LastError)
+
+	final := queryState(t, env)
+	assert.Equal(t, workflowPhaseFailed, final.Phase)
+	assert.Equal(t, []string{intentStepAnalyze, intentStepPlan, intentStepExecute}, final.CompletedSteps)
+	assert.Equal(t, []string{intentStepAggregate}, final.PendingSteps)
+	assert.Contains(t, final.LastError, "failed to aggregate results")
+}
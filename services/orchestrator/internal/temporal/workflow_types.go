@@ -0,0 +1,133 @@
+package temporal
+
+import (
+	"fmt"
+
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// WorkflowTypeRegistry is the workflow types of the orchestrator: the spec
+// the engine starts each type with and the workflow that runs it. The worker
+// registers the workflow of every type in its registry, so adding a type
+// only takes registering it.
+type WorkflowTypeRegistry struct {
+	specs     *services.WorkflowTypeRegistry
+	workflows []registeredWorkflow
+}
+
+// registeredWorkflow is a workflow and the name it is registered under. The
+// function is bound to the worker's engine when the worker registers it.
+type registeredWorkflow struct {
+	name string
+	fn   func(engine *WorkflowEngine) interface{}
+}
+
+// NewWorkflowTypeRegistry creates an empty registry
+func NewWorkflowTypeRegistry() *WorkflowTypeRegistry {
+	return &WorkflowTypeRegistry{specs: services.NewWorkflowTypeRegistry()}
+}
+
+// Register adds a workflow type and the workflow that runs it. Types may
+// share a workflow; it is registered with the worker once.
+func (r *WorkflowTypeRegistry) Register(spec services.WorkflowTypeSpec, fn func(engine *WorkflowEngine) interface{}) error {
+	if fn == nil {
+		return fmt.Errorf("workflow type %q has no workflow", spec.Type)
+	}
+	if err := r.specs.Register(spec); err != nil {
+		return err
+	}
+	for _, registered := range r.workflows {
+		if registered.name == spec.Workflow {
+			return nil
+		}
+	}
+	r.workflows = append(r.workflows, registeredWorkflow{name: spec.Workflow, fn: fn})
+	return nil
+}
+
+// Specs returns the specs the engine starts the workflow types with
+func (r *WorkflowTypeRegistry) Specs() *services.WorkflowTypeRegistry {
+	if r == nil {
+		return nil
+	}
+	return r.specs
+}
+
+// Workflows returns the names of the registered workflows
+func (r *WorkflowTypeRegistry) Workflows() []string {
+	names := make([]string, len(r.workflows))
+	for i, registered := range r.workflows {
+		names[i] = registered.name
+	}
+	return names
+}
+
+// registerWith registers the workflow of every type with a worker, bound to
+// the engine
+func (r *WorkflowTypeRegistry) registerWith(w worker.WorkflowRegistry, engine *WorkflowEngine) {
+	for _, registered := range r.workflows {
+		w.RegisterWorkflowWithOptions(registered.fn(engine), workflow.RegisterOptions{Name: registered.name})
+	}
+}
+
+// DefaultWorkflowTypes returns the registry of the orchestrator's workflow
+// types. New types are added here.
+func DefaultWorkflowTypes() *WorkflowTypeRegistry {
+	registry := NewWorkflowTypeRegistry()
+	for _, entry := range []struct {
+		spec services.WorkflowTypeSpec
+		fn   func(engine *WorkflowEngine) interface{}
+	}{
+		{services.WorkflowTypeSpec{
+			Type:           models.WorkflowTypeIntent,
+			Workflow:       "IntentProcessingWorkflow",
+			Input:          func() interface{} { return &IntentData{} },
+			AgentDependent: true,
+		}, func(e *WorkflowEngine) interface{} { return e.IntentProcessingWorkflow }},
+		{services.WorkflowTypeSpec{
+			Type:           models.WorkflowTypeExecution,
+			Workflow:       "CodeExecutionWorkflow",
+			Input:          func() interface{} { return &CodeExecutionRequest{} },
+			AgentDependent: true,
+		}, func(e *WorkflowEngine) interface{} { return e.CodeExecutionWorkflow }},
+		{services.WorkflowTypeSpec{
+			Type:     models.WorkflowTypeAnalysis,
+			Workflow: "CodeAnalysisWorkflow",
+			Input:    func() interface{} { return &CodeAnalysisRequest{} },
+		}, func(e *WorkflowEngine) interface{} { return e.CodeAnalysisWorkflow }},
+		{services.WorkflowTypeSpec{
+			Type:     models.WorkflowTypeReview,
+			Workflow: "CodeReviewWorkflow",
+			Input:    func() interface{} { return &CodeReviewRequest{} },
+		}, func(e *WorkflowEngine) interface{} { return e.CodeReviewWorkflow }},
+		{services.WorkflowTypeSpec{
+			Type:     models.WorkflowTypeDeployment,
+			Workflow: "DeploymentWorkflow",
+			Input:    func() interface{} { return &DeploymentRequest{} },
+		}, func(e *WorkflowEngine) interface{} { return e.DeploymentWorkflow }},
+		{services.WorkflowTypeSpec{
+			Type:           models.WorkflowTypeTaskExecution,
+			Workflow:       "TaskExecutionWorkflow",
+			Input:          func() interface{} { return &TaskExecutionInput{} },
+			AgentDependent: true,
+		}, func(e *WorkflowEngine) interface{} { return e.TaskExecutionWorkflow }},
+		{services.WorkflowTypeSpec{
+			Type:     models.WorkflowTypeCustom,
+			Workflow: "CustomWorkflow",
+		}, func(e *WorkflowEngine) interface{} { return e.CustomWorkflow }},
+		{services.WorkflowTypeSpec{
+			Type:     models.WorkflowTypePromotion,
+			Workflow: "PromoteEnvironmentWorkflow",
+			Input:    func() interface{} { return &services.PromotionInput{} },
+		}, func(e *WorkflowEngine) interface{} { return e.PromoteEnvironmentWorkflow }},
+	} {
+		if err := registry.Register(entry.spec, entry.fn); err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
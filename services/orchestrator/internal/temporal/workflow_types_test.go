@@ -0,0 +1,76 @@
+package temporal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+func TestDefaultWorkflowTypes_Complete(t *testing.T) {
+	registry := DefaultWorkflowTypes()
+	workflows := registry.Workflows()
+
+	// Every workflow type of the models is startable, by a workflow the
+	// worker registers
+	for _, workflowType := range []models.WorkflowType{
+		models.WorkflowTypeIntent,
+		models.WorkflowTypeExecution,
+		models.WorkflowTypeAnalysis,
+		models.WorkflowTypeReview,
+		models.WorkflowTypeDeployment,
+		models.WorkflowTypeTaskExecution,
+		models.WorkflowTypeCustom,
+		models.WorkflowTypePromotion,
+	} {
+		spec, ok := registry.Specs().Lookup(workflowType)
+		if assert.True(t, ok, "workflow type %q is not registered", workflowType) {
+			assert.Contains(t, workflows, spec.Workflow, workflowType)
+		}
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	assert.NotPanics(t, func() { registry.registerWith(env, NewWorkflowEngine(zap.NewNop())) })
+}
+
+func TestWorkflowTypeRegistry_Register(t *testing.T) {
+	registry := NewWorkflowTypeRegistry()
+	noop := func(e *WorkflowEngine) interface{} { return e.CustomWorkflow }
+
+	require.NoError(t, registry.Register(services.WorkflowTypeSpec{Type: "noop", Workflow: "CustomWorkflow"}, noop))
+	require.NoError(t, registry.Register(services.WorkflowTypeSpec{Type: "also_noop", Workflow: "CustomWorkflow"}, noop))
+	assert.Equal(t, []string{"CustomWorkflow"}, registry.Workflows(), "shared workflows are registered once")
+
+	assert.Error(t, registry.Register(services.WorkflowTypeSpec{Type: "noop", Workflow: "CustomWorkflow"}, noop))
+	assert.Error(t, registry.Register(services.WorkflowTypeSpec{Type: "unbound", Workflow: "UnboundWorkflow"}, nil))
+}
+
+func TestWorkflowTypeRegistry_RunsRegisteredType(t *testing.T) {
+	// A type registered without touching the engine or the worker
+	registry := DefaultWorkflowTypes()
+	require.NoError(t, registry.Register(services.WorkflowTypeSpec{Type: "noop", Workflow: "NoopWorkflow"},
+		func(*WorkflowEngine) interface{} {
+			return func(ctx workflow.Context, wf *models.Workflow) (string, error) {
+				return "noop " + wf.Name, nil
+			}
+		}))
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	registry.registerWith(env, NewWorkflowEngine(zap.NewNop()))
+
+	env.ExecuteWorkflow("NoopWorkflow", &models.Workflow{ID: testWorkflowID, Name: "smoke"})
+	require.True(t, env.IsWorkflowCompleted())
+	require.NoError(t, env.GetWorkflowError())
+	var result string
+	require.NoError(t, env.GetWorkflowResult(&result))
+	assert.Equal(t, "noop smoke", result)
+	assert.Contains(t, registry.Specs().Types(), models.WorkflowType("noop"))
+}
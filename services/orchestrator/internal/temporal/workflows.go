@@ -10,77 +10,131 @@ import (
 	"go.uber.org/zap"
 
 	"orchestrator/internal/models"
+	"orchestrator/internal/services"
 )
 
 // WorkflowEngine implements Temporal workflows
 type WorkflowEngine struct {
-	logger *zap.Logger
+	logger        *zap.Logger
+	continueAsNew ContinueAsNewPolicy
 }
 
 // NewWorkflowEngine creates a new workflow engine
 func NewWorkflowEngine(logger *zap.Logger) *WorkflowEngine {
 	return &WorkflowEngine{
-		logger: logger,
+		logger:        logger,
+		continueAsNew: DefaultContinueAsNewPolicy(),
 	}
 }
 
 // IntentProcessingWorkflow handles intent processing workflow
-func (w *WorkflowEngine) IntentProcessingWorkflow(ctx workflow.Context, wf *models.Workflow) error {
+func (w *WorkflowEngine) IntentProcessingWorkflow(ctx workflow.Context, wf *models.Workflow) (err error) {
+	progress := trackProgress(ctx, wf)
+	defer func() { progress.finish(err) }()
+	progress.plan(intentStepAnalyze, intentStepPlan, intentStepExecute, intentStepAggregate)
+
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting intent processing workflow", "workflowID", wf.ID)
 
-	// Set workflow options
-	ao := workflow.ActivityOptions{
-		StartToCloseTimeout: 5 * time.Minute,
-		HeartbeatTimeout:    30 * time.Second,
-		RetryPolicy: &temporal.RetryPolicy{
-			InitialInterval:    time.Second,
-			BackoffCoefficient: 2.0,
-			MaximumInterval:    time.Minute,
-			MaximumAttempts:    3,
-		},
+	if err := resolvePlaceholders(ctx, wf); err != nil {
+		return err
 	}
-	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	// Set workflow options from the workflow's config
+	config, err := intentConfig(wf)
+	if err != nil {
+		return err
+	}
+	ctx = workflow.WithActivityOptions(ctx, activityOptions(config.Activities, time.Minute))
 
 	// Step 1: Parse and validate intent
 	var intentData IntentData
 	if err := json.Unmarshal(wf.Input, &intentData); err != nil {
 		return fmt.Errorf("failed to parse intent data: %w", err)
 	}
+	sim := config.SimulationConfig()
+
+	// Re-runs resume with the analysis, and the plan, of an earlier run
+	var checkpoint IntentCheckpoint
+	if intentData.Checkpoint != nil {
+		err = workflow.ExecuteActivity(ctx, ActivityLoadCheckpoint, *intentData.Checkpoint).Get(ctx, &checkpoint)
+		if err != nil {
+			return fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+	}
 
 	var analysisResult IntentAnalysisResult
-	err := workflow.ExecuteActivity(ctx, "AnalyzeIntentActivity", intentData).Get(ctx, &analysisResult)
-	if err != nil {
-		return fmt.Errorf("intent analysis failed: %w", err)
+	if checkpoint.Analysis != nil {
+		analysisResult = *checkpoint.Analysis
+	} else {
+		progress.start(intentStepAnalyze)
+		err = workflow.ExecuteActivity(ctx, ActivityAnalyzeIntent, intentData, sim).Get(ctx, &analysisResult)
+		if err != nil {
+			return fmt.Errorf("intent analysis failed: %w", err)
+		}
 	}
+	progress.complete(intentStepAnalyze)
 
 	// Step 2: Create execution plan
 	var executionPlan ExecutionPlan
-	err = workflow.ExecuteActivity(ctx, "CreateExecutionPlanActivity", analysisResult).Get(ctx, &executionPlan)
-	if err != nil {
-		return fmt.Errorf("failed to create execution plan: %w", err)
+	if checkpoint.Plan != nil {
+		executionPlan = *checkpoint.Plan
+	} else {
+		// Intents estimated over their budget fail the workflow under the
+		// fail policy
+		if err := w.enforceIntentBudget(ctx, wf, analysisResult.BudgetDecision); err != nil {
+			return err
+		}
+
+		// Risks the policy blocks fail the workflow; risks requiring
+		// confirmation hold it until they are confirmed
+		if err := w.gateIntentRisks(ctx, wf, progress, analysisResult.RiskDecision); err != nil {
+			return err
+		}
+
+		progress.start(intentStepPlan)
+		err = workflow.ExecuteActivity(ctx, ActivityCreateExecutionPlan, analysisResult).Get(ctx, &executionPlan)
+		if err != nil {
+			return fmt.Errorf("failed to create execution plan: %w", err)
+		}
 	}
+	progress.complete(intentStepPlan)
 
-	// Step 3: Execute plan steps in parallel or sequence based on dependencies
+	// Step 3: Execute plan steps in dependency order, applying the overrides
+	// of operators. Runs started before the dispatcher executed only the
+	// steps without dependencies.
+	progress.start(intentStepExecute)
 	var results []StepResult
-	for _, step := range executionPlan.Steps {
-		if len(step.DependsOn) == 0 {
-			// Execute independent steps in parallel
-			var stepResult StepResult
-			err = workflow.ExecuteActivity(ctx, "ExecuteStepActivity", step).Get(ctx, &stepResult)
-			if err != nil {
-				return fmt.Errorf("step execution failed: %w", err)
+	if workflow.GetVersion(ctx, stepDispatchChange, workflow.DefaultVersion, 1) >= 1 {
+		results, err = dispatchSteps(ctx, progress, executionPlan.Steps, sim)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, step := range executionPlan.Steps {
+			if len(step.DependsOn) == 0 {
+				var stepResult StepResult
+				err = workflow.ExecuteActivity(ctx, ActivityExecuteStep, step, sim).Get(ctx, &stepResult)
+				if err != nil {
+					return fmt.Errorf("step execution failed: %w", err)
+				}
+				if stepResult.Error != "" {
+					progress.recordError(stepResult.Error)
+				}
+				results = append(results, stepResult)
 			}
-			results = append(results, stepResult)
 		}
 	}
+	progress.complete(intentStepExecute)
 
 	// Step 4: Aggregate results
+	progress.start(intentStepAggregate)
 	var finalResult WorkflowResult
-	err = workflow.ExecuteActivity(ctx, "AggregateResultsActivity", results).Get(ctx, &finalResult)
+	err = workflow.ExecuteActivity(ctx, ActivityAggregateResults, results).Get(ctx, &finalResult)
 	if err != nil {
 		return fmt.Errorf("failed to aggregate results: %w", err)
 	}
+	progress.complete(intentStepAggregate)
 
 	// Update workflow output
 	outputData, _ := json.Marshal(finalResult)
@@ -95,6 +149,10 @@ func (w *WorkflowEngine) CodeExecutionWorkflow(ctx workflow.Context, wf *models.
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting code execution workflow", "workflowID", wf.ID)
 
+	if err := resolvePlaceholders(ctx, wf); err != nil {
+		return err
+	}
+
 	// For testing purposes, check if this is a simple test workflow
 	var input map[string]interface{}
 	if err := json.Unmarshal(wf.Input, &input); err == nil {
@@ -118,54 +176,67 @@ func (w *WorkflowEngine) CodeExecutionWorkflow(ctx workflow.Context, wf *models.
 		}
 	}
 
-	ao := workflow.ActivityOptions{
-		StartToCloseTimeout: 10 * time.Minute,
-		HeartbeatTimeout:    1 * time.Minute,
-		RetryPolicy: &temporal.RetryPolicy{
-			InitialInterval:    time.Second,
-			BackoffCoefficient: 2.0,
-			MaximumInterval:    time.Minute,
-			MaximumAttempts:    3,
-		},
+	config, err := codeExecutionConfig(wf)
+	if err != nil {
+		return err
 	}
-	ctx = workflow.WithActivityOptions(ctx, ao)
+	ctx = workflow.WithActivityOptions(ctx, activityOptions(config.Activities, time.Minute))
 
 	// Step 1: Parse execution request
 	var execRequest CodeExecutionRequest
 	if err := json.Unmarshal(wf.Input, &execRequest); err != nil {
 		return fmt.Errorf("failed to parse execution request: %w", err)
 	}
+	sim := config.SimulationConfig()
+
+	// A matrix runs the steps below once per combination and compares the runs
+	if len(execRequest.Matrix) > 0 {
+		parallel, err := validateMatrix(execRequest, config.MatrixParallelism)
+		if err != nil {
+			return fmt.Errorf("invalid matrix: %w", err)
+		}
+		matrixResult, err := w.runCodeMatrix(ctx, execRequest, parallel, sim)
+		if err != nil {
+			return err
+		}
+		outputData, _ := json.Marshal(matrixResult)
+		wf.Output = outputData
+
+		logger.Info("Code execution matrix completed", "workflowID", wf.ID,
+			"passed", matrixResult.Summary.Passed, "failed", matrixResult.Summary.Failed)
+		return nil
+	}
 
 	// Step 2: Select appropriate agent
 	var agent AgentInfo
-	err := workflow.ExecuteActivity(ctx, "SelectAgentActivity", execRequest).Get(ctx, &agent)
+	err = workflow.ExecuteActivity(ctx, ActivitySelectAgent, execRequest, sim).Get(ctx, &agent)
 	if err != nil {
 		return fmt.Errorf("failed to select agent: %w", err)
 	}
 
 	// Step 3: Prepare execution environment
 	var envInfo EnvironmentInfo
-	err = workflow.ExecuteActivity(ctx, "PrepareEnvironmentActivity", agent, execRequest).Get(ctx, &envInfo)
+	err = workflow.ExecuteActivity(ctx, ActivityPrepareEnvironment, agent, execRequest, sim).Get(ctx, &envInfo)
 	if err != nil {
 		return fmt.Errorf("failed to prepare environment: %w", err)
 	}
 
 	// Step 4: Execute code
 	var execResult ExecutionResult
-	err = workflow.ExecuteActivity(ctx, "ExecuteCodeActivity", agent, envInfo, execRequest).Get(ctx, &execResult)
+	err = workflow.ExecuteActivity(ctx, ActivityExecuteCode, agent, envInfo, execRequest, sim).Get(ctx, &execResult)
 	if err != nil {
 		return fmt.Errorf("code execution failed: %w", err)
 	}
 
 	// Step 5: Process results
 	var processedResult ProcessedResult
-	err = workflow.ExecuteActivity(ctx, "ProcessResultsActivity", execResult).Get(ctx, &processedResult)
+	err = workflow.ExecuteActivity(ctx, ActivityProcessResults, execResult).Get(ctx, &processedResult)
 	if err != nil {
 		return fmt.Errorf("failed to process results: %w", err)
 	}
 
 	// Step 6: Cleanup environment
-	err = workflow.ExecuteActivity(ctx, "CleanupEnvironmentActivity", envInfo).Get(ctx, nil)
+	err = workflow.ExecuteActivity(ctx, ActivityCleanupEnvironment, envInfo).Get(ctx, nil)
 	if err != nil {
 		// Log but don't fail workflow for cleanup errors
 		logger.Error("Failed to cleanup environment", zap.Error(err))
@@ -184,6 +255,10 @@ func (w *WorkflowEngine) CodeAnalysisWorkflow(ctx workflow.Context, wf *models.W
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting code analysis workflow", "workflowID", wf.ID)
 
+	if err := resolvePlaceholders(ctx, wf); err != nil {
+		return err
+	}
+
 	ao := workflow.ActivityOptions{
 		StartToCloseTimeout: 15 * time.Minute,
 		HeartbeatTimeout:    2 * time.Minute,
@@ -201,33 +276,59 @@ func (w *WorkflowEngine) CodeAnalysisWorkflow(ctx workflow.Context, wf *models.W
 	if err := json.Unmarshal(wf.Input, &analysisRequest); err != nil {
 		return fmt.Errorf("failed to parse analysis request: %w", err)
 	}
+	analysisRequest.ProjectID = wf.ProjectID
+	defaultAnalysisSource(wf, &analysisRequest)
+	if cacheBypassed(wf) {
+		analysisRequest.BypassCache = true
+	}
 
 	// Step 2: Fetch code
 	var codeData CodeData
-	err := workflow.ExecuteActivity(ctx, "FetchCodeActivity", analysisRequest).Get(ctx, &codeData)
+	err := workflow.ExecuteActivity(ctx, ActivityFetchCode, analysisRequest).Get(ctx, &codeData)
 	if err != nil {
 		return fmt.Errorf("failed to fetch code: %w", err)
 	}
 
+	// Reuse the report of an earlier analysis of the commit. The cache is an
+	// optimization: failing to use it only means analyzing again.
+	commit, _ := codeData.Metadata["commit"].(string)
+	useCache := workflow.GetVersion(ctx, analysisCacheChange, workflow.DefaultVersion, 1) >= 1
+	var cacheLookup AnalysisCacheLookup
+	if useCache {
+		err = workflow.ExecuteActivity(ctx, ActivityLookupAnalysisCache, analysisRequest, commit).Get(ctx, &cacheLookup)
+		if err != nil {
+			logger.Warn("Failed to look up cached analysis", zap.Error(err))
+		}
+		if cacheLookup.Report != nil {
+			report := *cacheLookup.Report
+			report.CacheHit = true
+			outputData, _ := json.Marshal(report)
+			wf.Output = outputData
+
+			logger.Info("Code analysis workflow completed from cache", "workflowID", wf.ID, "commit", commit)
+			return nil
+		}
+	}
+
 	// Step 3: Run multiple analyses in parallel
 	selector := workflow.NewSelector(ctx)
 
 	// Static analysis
-	staticFuture := workflow.ExecuteActivity(ctx, "RunStaticAnalysisActivity", codeData)
+	staticFuture := workflow.ExecuteActivity(ctx, ActivityRunStaticAnalysis, codeData)
 	var staticResult StaticAnalysisResult
 	selector.AddFuture(staticFuture, func(f workflow.Future) {
 		f.Get(ctx, &staticResult)
 	})
 
 	// Security analysis
-	securityFuture := workflow.ExecuteActivity(ctx, "RunSecurityAnalysisActivity", codeData)
+	securityFuture := workflow.ExecuteActivity(ctx, ActivityRunSecurityAnalysis, codeData)
 	var securityResult SecurityAnalysisResult
 	selector.AddFuture(securityFuture, func(f workflow.Future) {
 		f.Get(ctx, &securityResult)
 	})
 
 	// Performance analysis
-	perfFuture := workflow.ExecuteActivity(ctx, "RunPerformanceAnalysisActivity", codeData)
+	perfFuture := workflow.ExecuteActivity(ctx, ActivityRunPerformanceAnalysis, codeData)
 	var perfResult PerformanceAnalysisResult
 	selector.AddFuture(perfFuture, func(f workflow.Future) {
 		f.Get(ctx, &perfResult)
@@ -240,12 +341,25 @@ func (w *WorkflowEngine) CodeAnalysisWorkflow(ctx workflow.Context, wf *models.W
 
 	// Step 4: Generate report
 	var report AnalysisReport
-	err = workflow.ExecuteActivity(ctx, "GenerateAnalysisReportActivity", 
+	err = workflow.ExecuteActivity(ctx, ActivityGenerateAnalysisReport, 
 		staticResult, securityResult, perfResult).Get(ctx, &report)
 	if err != nil {
 		return fmt.Errorf("failed to generate analysis report: %w", err)
 	}
 
+	if useCache && cacheLookup.Version != "" {
+		err = workflow.ExecuteActivity(ctx, ActivityStoreAnalysisCache, AnalysisCacheEntry{
+			Request:    analysisRequest,
+			Commit:     commit,
+			Version:    cacheLookup.Version,
+			WorkflowID: wf.ID,
+			Report:     report,
+		}).Get(ctx, nil)
+		if err != nil {
+			logger.Warn("Failed to cache analysis report", zap.Error(err))
+		}
+	}
+
 	// Update workflow output
 	outputData, _ := json.Marshal(report)
 	wf.Output = outputData
@@ -259,6 +373,10 @@ func (w *WorkflowEngine) CodeReviewWorkflow(ctx workflow.Context, wf *models.Wor
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting code review workflow", "workflowID", wf.ID)
 
+	if err := resolvePlaceholders(ctx, wf); err != nil {
+		return err
+	}
+
 	ao := workflow.ActivityOptions{
 		StartToCloseTimeout: 20 * time.Minute,
 		HeartbeatTimeout:    3 * time.Minute,
@@ -276,38 +394,40 @@ func (w *WorkflowEngine) CodeReviewWorkflow(ctx workflow.Context, wf *models.Wor
 	if err := json.Unmarshal(wf.Input, &reviewRequest); err != nil {
 		return fmt.Errorf("failed to parse review request: %w", err)
 	}
+	reviewRequest.ProjectID = wf.ProjectID
+	defaultReviewSource(wf, &reviewRequest)
 
 	// Step 2: Fetch code changes
 	var codeChanges CodeChanges
-	err := workflow.ExecuteActivity(ctx, "FetchCodeChangesActivity", reviewRequest).Get(ctx, &codeChanges)
+	err := workflow.ExecuteActivity(ctx, ActivityFetchCodeChanges, reviewRequest).Get(ctx, &codeChanges)
 	if err != nil {
 		return fmt.Errorf("failed to fetch code changes: %w", err)
 	}
 
 	// Step 3: Run automated checks
 	var automatedChecks AutomatedCheckResults
-	err = workflow.ExecuteActivity(ctx, "RunAutomatedChecksActivity", codeChanges).Get(ctx, &automatedChecks)
+	err = workflow.ExecuteActivity(ctx, ActivityRunAutomatedChecks, codeChanges).Get(ctx, &automatedChecks)
 	if err != nil {
 		return fmt.Errorf("failed to run automated checks: %w", err)
 	}
 
 	// Step 4: AI-powered review
 	var aiReview AIReviewResult
-	err = workflow.ExecuteActivity(ctx, "RunAIReviewActivity", codeChanges, automatedChecks).Get(ctx, &aiReview)
+	err = workflow.ExecuteActivity(ctx, ActivityRunAIReview, codeChanges, automatedChecks).Get(ctx, &aiReview)
 	if err != nil {
 		return fmt.Errorf("failed to run AI review: %w", err)
 	}
 
 	// Step 5: Generate review summary
 	var reviewSummary ReviewSummary
-	err = workflow.ExecuteActivity(ctx, "GenerateReviewSummaryActivity", automatedChecks, aiReview).Get(ctx, &reviewSummary)
+	err = workflow.ExecuteActivity(ctx, ActivityGenerateReviewSummary, automatedChecks, aiReview).Get(ctx, &reviewSummary)
 	if err != nil {
 		return fmt.Errorf("failed to generate review summary: %w", err)
 	}
 
 	// Step 6: Post review comments (if configured)
 	if reviewRequest.PostComments {
-		err = workflow.ExecuteActivity(ctx, "PostReviewCommentsActivity", reviewSummary).Get(ctx, nil)
+		err = workflow.ExecuteActivity(ctx, ActivityPostReviewComments, reviewRequest, codeChanges, reviewSummary).Get(ctx, nil)
 		if err != nil {
 			logger.Error("Failed to post review comments", zap.Error(err))
 		}
@@ -321,22 +441,27 @@ func (w *WorkflowEngine) CodeReviewWorkflow(ctx workflow.Context, wf *models.Wor
 	return nil
 }
 
-// DeploymentWorkflow handles deployment workflow
-func (w *WorkflowEngine) DeploymentWorkflow(ctx workflow.Context, wf *models.Workflow) error {
+// DeploymentWorkflow handles deployment workflow. state is nil on the first
+// run and carries build and approval progress after a continue-as-new.
+func (w *WorkflowEngine) DeploymentWorkflow(ctx workflow.Context, wf *models.Workflow, state *DeploymentContinuation) (err error) {
+	progress := trackProgress(ctx, wf)
+	defer func() { progress.finish(err) }()
+
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting deployment workflow", "workflowID", wf.ID)
 
-	ao := workflow.ActivityOptions{
-		StartToCloseTimeout: 30 * time.Minute,
-		HeartbeatTimeout:    5 * time.Minute,
-		RetryPolicy: &temporal.RetryPolicy{
-			InitialInterval:    time.Second,
-			BackoffCoefficient: 2.0,
-			MaximumInterval:    5 * time.Minute,
-			MaximumAttempts:    3,
-		},
+	// Runs continued as new resolved the placeholders already
+	if state == nil {
+		if err := resolvePlaceholders(ctx, wf); err != nil {
+			return err
+		}
 	}
-	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	config, err := deploymentConfig(wf)
+	if err != nil {
+		return err
+	}
+	ctx = workflow.WithActivityOptions(ctx, activityOptions(config.Activities, 5*time.Minute))
 
 	// Step 1: Parse deployment request
 	var deployRequest DeploymentRequest
@@ -344,70 +469,116 @@ func (w *WorkflowEngine) DeploymentWorkflow(ctx workflow.Context, wf *models.Wor
 		return fmt.Errorf("failed to parse deployment request: %w", err)
 	}
 
-	// Step 2: Validate deployment
-	var validation DeploymentValidation
-	err := workflow.ExecuteActivity(ctx, "ValidateDeploymentActivity", deployRequest).Get(ctx, &validation)
-	if err != nil {
-		return fmt.Errorf("deployment validation failed: %w", err)
-	}
+	progress.plan(deploymentSteps(deployRequest)...)
+	if state == nil {
+		state = &DeploymentContinuation{}
 
-	if !validation.IsValid {
-		return fmt.Errorf("deployment validation failed: %s", validation.Errors)
-	}
+		// Step 2: Validate deployment
+		progress.start(deploymentStepValidate)
+		var validation DeploymentValidation
+		err := workflow.ExecuteActivity(ctx, ActivityValidateDeployment, deployRequest).Get(ctx, &validation)
+		if err != nil {
+			return fmt.Errorf("deployment validation failed: %w", err)
+		}
 
-	// Step 3: Build artifacts
-	var buildResult BuildResult
-	err = workflow.ExecuteActivity(ctx, "BuildArtifactsActivity", deployRequest).Get(ctx, &buildResult)
-	if err != nil {
-		return fmt.Errorf("build failed: %w", err)
-	}
+		if !validation.IsValid {
+			return fmt.Errorf("deployment validation failed: %s", validation.Errors)
+		}
+		progress.complete(deploymentStepValidate)
 
-	// Step 4: Run tests
-	var testResult TestResult
-	err = workflow.ExecuteActivity(ctx, "RunDeploymentTestsActivity", buildResult).Get(ctx, &testResult)
-	if err != nil {
-		return fmt.Errorf("tests failed: %w", err)
-	}
+		// Step 3: Build artifacts
+		progress.start(deploymentStepBuild)
+		err = workflow.ExecuteActivity(ctx, ActivityBuildArtifacts, deployRequest).Get(ctx, &state.Build)
+		if err != nil {
+			return fmt.Errorf("build failed: %w", err)
+		}
+		progress.complete(deploymentStepBuild)
+		progress.addArtifacts(buildArtifactRef(state.Build))
 
-	// Step 5: Deploy to staging (if configured)
-	if deployRequest.DeployToStaging {
-		var stagingResult DeploymentResult
-		err = workflow.ExecuteActivity(ctx, "DeployToStagingActivity", buildResult).Get(ctx, &stagingResult)
+		// Step 4: Run tests
+		progress.start(deploymentStepTest)
+		var testResult TestResult
+		err = workflow.ExecuteActivity(ctx, ActivityRunDeploymentTests, state.Build).Get(ctx, &testResult)
 		if err != nil {
-			return fmt.Errorf("staging deployment failed: %w", err)
+			return fmt.Errorf("tests failed: %w", err)
+		}
+		progress.complete(deploymentStepTest)
+
+		// Step 5: Deploy to staging (if configured)
+		if deployRequest.DeployToStaging {
+			progress.start(deploymentStepStaging)
+			var stagingResult DeploymentResult
+			err = workflow.ExecuteActivity(ctx, ActivityDeployToStaging, state.Build).Get(ctx, &stagingResult)
+			if err != nil {
+				return fmt.Errorf("staging deployment failed: %w", err)
+			}
+
+			// Run smoke tests on staging
+			var smokeTestResult TestResult
+			err = workflow.ExecuteActivity(ctx, ActivityRunSmokeTests, stagingResult).Get(ctx, &smokeTestResult)
+			if err != nil {
+				// Rollback staging
+				workflow.ExecuteActivity(ctx, ActivityRollbackDeployment, stagingResult).Get(ctx, nil)
+				return fmt.Errorf("staging smoke tests failed: %w", err)
+			}
+			state.Staging = &stagingResult
+			progress.complete(deploymentStepStaging)
+		}
+	} else {
+		logger.Info("Resuming deployment workflow", "workflowID", wf.ID, "runs", state.Runs)
+
+		// Earlier runs did everything up to the approval
+		for _, step := range []string{deploymentStepValidate, deploymentStepBuild, deploymentStepTest} {
+			progress.complete(step)
 		}
+		progress.addArtifacts(buildArtifactRef(state.Build))
+		if state.Staging != nil {
+			progress.complete(deploymentStepStaging)
+		}
+	}
 
-		// Run smoke tests on staging
-		var smokeTestResult TestResult
-		err = workflow.ExecuteActivity(ctx, "RunSmokeTestsActivity", stagingResult).Get(ctx, &smokeTestResult)
+	// Step 6: Wait for approval (if required)
+	if deployRequest.RequireApproval {
+		progress.start(deploymentStepApproval)
+		approval, err := w.waitForDeploymentApproval(ctx, wf, config, deployRequest, state)
 		if err != nil {
-			// Rollback staging
-			workflow.ExecuteActivity(ctx, "RollbackDeploymentActivity", stagingResult).Get(ctx, nil)
-			return fmt.Errorf("staging smoke tests failed: %w", err)
+			return err
 		}
+		if !approval.Approved {
+			return fmt.Errorf("deployment rejected by %s: %s", approval.Approver, approval.Comment)
+		}
+		logger.Info("Deployment approved", "workflowID", wf.ID, "approver", approval.Approver)
+		progress.complete(deploymentStepApproval)
 	}
 
-	// Step 6: Deploy to production
+	// Step 7: Deploy to production
+	progress.start(deploymentStepProduction)
 	var prodResult DeploymentResult
-	err = workflow.ExecuteActivity(ctx, "DeployToProductionActivity", buildResult).Get(ctx, &prodResult)
+	err = workflow.ExecuteActivity(ctx, ActivityDeployToProduction, state.Build).Get(ctx, &prodResult)
 	if err != nil {
 		return fmt.Errorf("production deployment failed: %w", err)
 	}
+	progress.complete(deploymentStepProduction)
 
-	// Step 7: Health check
+	// Step 8: Health check
+	progress.start(deploymentStepHealthCheck)
 	var healthCheck HealthCheckResult
-	err = workflow.ExecuteActivity(ctx, "RunHealthCheckActivity", prodResult).Get(ctx, &healthCheck)
+	err = workflow.ExecuteActivity(ctx, ActivityRunHealthCheck, prodResult).Get(ctx, &healthCheck)
 	if err != nil || !healthCheck.IsHealthy {
 		// Rollback production
-		workflow.ExecuteActivity(ctx, "RollbackDeploymentActivity", prodResult).Get(ctx, nil)
+		workflow.ExecuteActivity(ctx, ActivityRollbackDeployment, prodResult).Get(ctx, nil)
 		return fmt.Errorf("health check failed: %w", err)
 	}
+	progress.complete(deploymentStepHealthCheck)
 
-	// Step 8: Update deployment status
-	err = workflow.ExecuteActivity(ctx, "UpdateDeploymentStatusActivity", prodResult).Get(ctx, nil)
+	// Step 9: Update deployment status
+	progress.start(deploymentStepUpdateStatus)
+	err = workflow.ExecuteActivity(ctx, ActivityUpdateDeploymentStatus, prodResult).Get(ctx, nil)
 	if err != nil {
 		logger.Error("Failed to update deployment status", zap.Error(err))
+		progress.recordError(err.Error())
 	}
+	progress.complete(deploymentStepUpdateStatus)
 
 	// Update workflow output
 	outputData, _ := json.Marshal(prodResult)
@@ -417,11 +588,76 @@ func (w *WorkflowEngine) DeploymentWorkflow(ctx workflow.Context, wf *models.Wor
 	return nil
 }
 
+// waitForDeploymentApproval blocks until an approval signal arrives or the
+// approval timeout expires. Each reminder interval adds timer events to the
+// history, so once the run grows past the continue-as-new threshold it hands
+// its state to a fresh run instead.
+func (w *WorkflowEngine) waitForDeploymentApproval(ctx workflow.Context, wf *models.Workflow, config *services.DeploymentConfig, req DeploymentRequest, state *DeploymentContinuation) (*DeploymentApproval, error) {
+	logger := workflow.GetLogger(ctx)
+
+	timeout := time.Duration(config.ApprovalTimeoutMinutes) * time.Minute
+	if req.ApprovalTimeoutMinutes > 0 {
+		timeout = time.Duration(req.ApprovalTimeoutMinutes) * time.Minute
+	}
+	if state.WaitStartedAt.IsZero() {
+		state.WaitStartedAt = workflow.Now(ctx)
+	}
+	deadline := state.WaitStartedAt.Add(timeout)
+
+	approvalCh := workflow.GetSignalChannel(ctx, DeploymentApprovalSignal)
+	for {
+		// Signals are not carried over to the next run, so take a buffered
+		// approval before deciding to continue as new. This happens in the
+		// workflow task that continues as new; a signal arriving while that
+		// task runs makes the server retry it, and the retry receives it here.
+		var approval DeploymentApproval
+		if approvalCh.ReceiveAsync(&approval) {
+			return &approval, nil
+		}
+
+		remaining := deadline.Sub(workflow.Now(ctx))
+		if remaining <= 0 {
+			return nil, fmt.Errorf("deployment approval timed out after %s", timeout)
+		}
+
+		if w.continueAsNew.ShouldContinueAsNew(ctx) {
+			state.Runs++
+			logger.Info("Continuing deployment approval wait as new", "workflowID", wf.ID, "runs", state.Runs)
+			return nil, workflow.NewContinueAsNewError(ctx, w.DeploymentWorkflow, wf, state)
+		}
+
+		wait := time.Duration(config.ApprovalReminderMinutes) * time.Minute
+		if remaining < wait {
+			wait = remaining
+		}
+
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		received := false
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(approvalCh, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, &approval)
+			received = true
+		})
+		selector.AddFuture(workflow.NewTimer(timerCtx, wait), func(f workflow.Future) {})
+		selector.Select(ctx)
+		cancelTimer()
+
+		if received {
+			return &approval, nil
+		}
+		logger.Info("Still waiting for deployment approval", "workflowID", wf.ID, "remaining", remaining-wait)
+	}
+}
+
 // CustomWorkflow handles custom workflow types
 func (w *WorkflowEngine) CustomWorkflow(ctx workflow.Context, wf *models.Workflow) (map[string]interface{}, error) {
 	logger := workflow.GetLogger(ctx)
 	logger.Info("Starting custom workflow", "workflowID", wf.ID)
 
+	if err := resolvePlaceholders(ctx, wf); err != nil {
+		return nil, err
+	}
+
 	// Check for test mode
 	var input map[string]interface{}
 	if err := json.Unmarshal(wf.Input, &input); err == nil {
@@ -453,22 +689,17 @@ func (w *WorkflowEngine) CustomWorkflow(ctx workflow.Context, wf *models.Workflo
 	// Collect results from all steps
 	results := make([]interface{}, 0)
 
-	// Execute custom steps based on definition
+	// Execute custom steps based on definition, each with its own timeouts
+	// and retries over those of custom workflows
 	for _, step := range customDef.Steps {
-		ao := workflow.ActivityOptions{
-			StartToCloseTimeout: time.Duration(step.TimeoutSeconds) * time.Second,
-			HeartbeatTimeout:    30 * time.Second,
-			RetryPolicy: &temporal.RetryPolicy{
-				InitialInterval:    time.Second,
-				BackoffCoefficient: 2.0,
-				MaximumInterval:    time.Minute,
-				MaximumAttempts:    int32(step.MaxRetries),
-			},
+		ao, err := stepActivityOptions(customActivityOptions, step.activitySettings())
+		if err != nil {
+			return nil, err
 		}
 		stepCtx := workflow.WithActivityOptions(ctx, ao)
 
 		var stepResult interface{}
-		err := workflow.ExecuteActivity(stepCtx, "ExecuteCustomStepActivity", step).Get(stepCtx, &stepResult)
+		err = workflow.ExecuteActivity(stepCtx, ActivityExecuteCustomStep, step).Get(stepCtx, &stepResult)
 		if err != nil {
 			if step.ContinueOnError {
 				logger.Warn("Custom step failed but continuing", 
@@ -505,13 +736,16 @@ type IntentData struct {
 	Content     string                 `json:"content"`
 	Context     map[string]interface{} `json:"context"`
 	Parameters  map[string]interface{} `json:"parameters"`
+	Checkpoint  *models.CheckpointRef  `json:"checkpoint,omitempty"` // Set for re-runs resuming from a checkpoint
 }
 
 type IntentAnalysisResult struct {
-	IntentType   string                 `json:"intent_type"`
-	Confidence   float64                `json:"confidence"`
-	Actions      []string               `json:"actions"`
-	Requirements map[string]interface{} `json:"requirements"`
+	IntentType     string                   `json:"intent_type"`
+	Confidence     float64                  `json:"confidence"`
+	Actions        []string                 `json:"actions"`
+	Requirements   map[string]interface{}   `json:"requirements"`
+	RiskDecision   *services.RiskDecision   `json:"risk_decision,omitempty"`   // Set when the analysis reported risks
+	BudgetDecision *services.BudgetDecision `json:"budget_decision,omitempty"` // Set when the workflow has a budget
 }
 
 type ExecutionPlan struct {
@@ -524,26 +758,38 @@ type ExecutionStep struct {
 	Type      string   `json:"type"`
 	DependsOn []string `json:"depends_on"`
 	Config    map[string]interface{} `json:"config"`
+	// Inputs are the outputs of the steps this step depends on, by step ID
+	Inputs    map[string]map[string]interface{} `json:"inputs,omitempty"`
+	// Timeouts and retries of the step, over those of the workflow type
+	services.StepActivitySettings
 }
 
 type StepResult struct {
 	StepID  string                 `json:"step_id"`
-	Status  string                 `json:"status"`
+	Status  models.ExecutionStatus `json:"status"`
 	Output  map[string]interface{} `json:"output"`
 	Error   string                 `json:"error,omitempty"`
 }
 
 type WorkflowResult struct {
-	Status  string                 `json:"status"`
-	Results map[string]interface{} `json:"results"`
-	Summary string                 `json:"summary"`
+	Status           string                 `json:"status"`
+	Results          map[string]interface{} `json:"results"`
+	Summary          string                 `json:"summary"`
+	OffloadedOutputs []string               `json:"offloaded_outputs,omitempty"` // Steps whose results are offloaded outputs
 }
 
 type CodeExecutionRequest struct {
-	Language    string                 `json:"language"`
-	Code        string                 `json:"code"`
-	Environment map[string]string      `json:"environment"`
-	Resources   map[string]interface{} `json:"resources"`
+	Language        string                 `json:"language"`
+	Runtime         string                 `json:"runtime,omitempty"` // Runtime version the agent must support, e.g. 3.11
+	Code            string                 `json:"code"`
+	Environment     map[string]string      `json:"environment"`                // Overrides; values may use ${env.X} and ${secret.X}
+	EnvironmentName string                 `json:"environment_name,omitempty"` // Project environment whose variables apply
+	Resources       map[string]interface{} `json:"resources"`
+
+	// Runs the code once per combination instead of on Language and Runtime,
+	// at most MaxParallel (default 4) at a time
+	Matrix      []MatrixCombination `json:"matrix,omitempty"`
+	MaxParallel int                 `json:"max_parallel,omitempty"`
 }
 
 type AgentInfo struct {
@@ -551,6 +797,12 @@ type AgentInfo struct {
 	Type         string   `json:"type"`
 	Capabilities []string `json:"capabilities"`
 	Status       string   `json:"status"`
+	Selection    string   `json:"selection,omitempty"`        // How the agent was chosen, e.g. spawned_due_to_saturation
+	SelectionWhy string   `json:"selection_reason,omitempty"` // Rationale of the selection
+	Affinity     string   `json:"affinity,omitempty"`         // Whether the task group's preferred agent was used, e.g. honored
+	AffinityWhy  string   `json:"affinity_reason,omitempty"`  // Why the preferred agent could not be used
+	Preference    string  `json:"preference,omitempty"`        // How the project's agent preferences influenced the selection, e.g. preferred
+	PreferenceWhy string  `json:"preference_reason,omitempty"` // Rationale of the preference outcome
 }
 
 type EnvironmentInfo struct {
@@ -560,9 +812,10 @@ type EnvironmentInfo struct {
 }
 
 type ExecutionResult struct {
-	Output   string                 `json:"output"`
-	ExitCode int                    `json:"exit_code"`
-	Metrics  map[string]interface{} `json:"metrics"`
+	Output          string                  `json:"output"` // Preview when the output was offloaded
+	ExitCode        int                     `json:"exit_code"`
+	Metrics         map[string]interface{}  `json:"metrics"`
+	OffloadedOutput *models.OffloadedOutput `json:"offloaded_output,omitempty"`
 }
 
 type ProcessedResult struct {
@@ -572,10 +825,14 @@ type ProcessedResult struct {
 }
 
 type CodeAnalysisRequest struct {
-	Repository string   `json:"repository"`
-	Branch     string   `json:"branch"`
-	Path       string   `json:"path"`
-	Types      []string `json:"types"`
+	ProjectID   string   `json:"project_id"`
+	Repository  string   `json:"repository"`
+	Branch      string   `json:"branch"`
+	Path        string   `json:"path"`
+	Include     []string `json:"include,omitempty"`     // File globs; every file when empty
+	Integration string   `json:"integration,omitempty"` // Git integration holding HTTPS credentials
+	Types       []string `json:"types"`
+	BypassCache bool     `json:"bypass_cache,omitempty"` // Analyze again even when a report of the commit is cached
 }
 
 type CodeData struct {
@@ -594,6 +851,8 @@ type SecurityAnalysisResult struct {
 	Vulnerabilities []interface{} `json:"vulnerabilities"`
 	RiskScore       float64       `json:"risk_score"`
 	Recommendations []string      `json:"recommendations"`
+	Degraded        bool          `json:"degraded,omitempty"` // Some checks could not complete
+	DegradedReasons []string      `json:"degraded_reasons,omitempty"`
 }
 
 type PerformanceAnalysisResult struct {
@@ -608,6 +867,7 @@ type AnalysisReport struct {
 	Security    SecurityAnalysisResult `json:"security"`
 	Performance PerformanceAnalysisResult `json:"performance"`
 	Score       float64                `json:"score"`
+	CacheHit    bool                   `json:"cache_hit"` // The report of an earlier analysis of the commit
 }
 
 // Types are already defined in worker.go, so we don't need to redefine them here
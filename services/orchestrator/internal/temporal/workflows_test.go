@@ -0,0 +1,156 @@
+package temporal
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+
+	"orchestrator/internal/models"
+)
+
+func deploymentWorkflow(t *testing.T, req DeploymentRequest) *models.Workflow {
+	input, err := json.Marshal(req)
+	require.NoError(t, err)
+	return &models.Workflow{ID: "wf-1", Type: models.WorkflowTypeDeployment, Input: input}
+}
+
+func TestDeploymentWorkflow_ApprovalWaitContinuesAsNew(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	wf := deploymentWorkflow(t, DeploymentRequest{Version: "1.2.0", DeployToStaging: true, RequireApproval: true})
+
+	// First run: builds, deploys to staging, then hits the history threshold
+	// while waiting for approval
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	for _, activity := range []interface{}{
+		ValidateDeploymentActivity, BuildArtifactsActivity, RunDeploymentTestsActivity,
+		DeployToStagingActivity, RunSmokeTestsActivity,
+	} {
+		env.RegisterActivity(activity)
+	}
+	env.SetCurrentHistoryLength(DefaultMaxHistoryLength)
+
+	env.ExecuteWorkflow(engine.DeploymentWorkflow, wf, (*DeploymentContinuation)(nil))
+	require.True(t, env.IsWorkflowCompleted())
+
+	err := env.GetWorkflowError()
+	var continueErr *workflow.ContinueAsNewError
+	require.ErrorAs(t, err, &continueErr)
+
+	var carriedWorkflow models.Workflow
+	var state DeploymentContinuation
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayloads(continueErr.Input, &carriedWorkflow, &state))
+	assert.Equal(t, wf.ID, carriedWorkflow.ID)
+	assert.Equal(t, 1, state.Runs)
+	assert.False(t, state.WaitStartedAt.IsZero())
+	assert.Equal(t, "artifact-123", state.Build.ArtifactID)
+	assert.Equal(t, "1.2.0", state.Build.Version)
+	require.NotNil(t, state.Staging)
+	assert.Equal(t, "staging", state.Staging.Environment)
+
+	// Second run: resumes from the carried state without rebuilding and
+	// deploys the original artifact once approved
+	env = suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	env.OnActivity(DeployToProductionActivity, mock.Anything, state.Build).
+		Return(&DeploymentResult{DeploymentID: "deploy-prod-1", Environment: "production"}, nil).Once()
+	env.RegisterActivity(RunHealthCheckActivity)
+	env.RegisterActivity(UpdateDeploymentStatusActivity)
+
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(DeploymentApprovalSignal, DeploymentApproval{Approved: true, Approver: "release-manager"})
+	}, 3*time.Hour)
+
+	env.ExecuteWorkflow(engine.DeploymentWorkflow, &carriedWorkflow, &state)
+	require.True(t, env.IsWorkflowCompleted())
+	assert.NoError(t, env.GetWorkflowError())
+	env.AssertExpectations(t)
+}
+
+func TestDeploymentWorkflow_ApprovalTimesOut(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	wf := deploymentWorkflow(t, DeploymentRequest{Version: "1.2.0", RequireApproval: true, ApprovalTimeoutMinutes: 90})
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	for _, activity := range []interface{}{
+		ValidateDeploymentActivity, BuildArtifactsActivity, RunDeploymentTestsActivity,
+	} {
+		env.RegisterActivity(activity)
+	}
+
+	env.ExecuteWorkflow(engine.DeploymentWorkflow, wf, (*DeploymentContinuation)(nil))
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), "approval timed out")
+}
+
+func TestDeploymentWorkflow_UsesTypedConfig(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	engine := NewWorkflowEngine(zap.NewNop())
+	wf := deploymentWorkflow(t, DeploymentRequest{Version: "1.2.0", RequireApproval: true})
+	wf.Config = json.RawMessage(`{"schema_version": 2, "approval_timeout_minutes": 30, "activities": {"heartbeat_timeout_seconds": 45}}`)
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	for _, activity := range []interface{}{
+		ValidateDeploymentActivity, BuildArtifactsActivity, RunDeploymentTestsActivity,
+	} {
+		env.RegisterActivity(activity)
+	}
+	var heartbeats []time.Duration
+	env.SetOnActivityStartedListener(func(info *activity.Info, ctx context.Context, args converter.EncodedValues) {
+		heartbeats = append(heartbeats, info.HeartbeatTimeout)
+	})
+
+	// The approval timeout and activity options come from the config,
+	// defaults filling in what it leaves out
+	env.ExecuteWorkflow(engine.DeploymentWorkflow, wf, (*DeploymentContinuation)(nil))
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), "approval timed out after 30m0s")
+	require.NotEmpty(t, heartbeats)
+	for _, heartbeat := range heartbeats {
+		assert.Equal(t, 45*time.Second, heartbeat)
+	}
+
+	// Invalid configs fail the workflow before it does any work
+	env = suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(engine.DeploymentWorkflow)
+	wf.Config = json.RawMessage(`{"approval_timeout_minutes": -1}`)
+	env.ExecuteWorkflow(engine.DeploymentWorkflow, wf, (*DeploymentContinuation)(nil))
+	require.True(t, env.IsWorkflowCompleted())
+	assert.ErrorContains(t, env.GetWorkflowError(), "invalid workflow config")
+}
+
+func TestContinueAsNewPolicy(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+
+	check := func(policy ContinueAsNewPolicy, historyLength int) bool {
+		env := suite.NewTestWorkflowEnvironment()
+		env.SetCurrentHistoryLength(historyLength)
+		env.ExecuteWorkflow(func(ctx workflow.Context) (bool, error) {
+			return policy.ShouldContinueAsNew(ctx), nil
+		})
+		require.NoError(t, env.GetWorkflowError())
+
+		var result bool
+		require.NoError(t, env.GetWorkflowResult(&result))
+		return result
+	}
+
+	assert.False(t, check(ContinueAsNewPolicy{MaxHistoryLength: 100}, 99))
+	assert.True(t, check(ContinueAsNewPolicy{MaxHistoryLength: 100}, 100))
+	assert.False(t, check(ContinueAsNewPolicy{}, DefaultMaxHistoryLength-1))
+	assert.True(t, check(ContinueAsNewPolicy{}, DefaultMaxHistoryLength))
+}
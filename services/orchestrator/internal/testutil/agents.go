@@ -0,0 +1,142 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"orchestrator/internal/services"
+)
+
+// FakeAgentGateway is an in-memory agent manager whose agents complete every
+// task they are given. When Err is set every method fails with it.
+type FakeAgentGateway struct {
+	mu sync.Mutex
+
+	Agents map[string]*services.Agent
+	Err    error
+}
+
+// NewFakeAgentGateway creates an agent gateway holding the given agents
+func NewFakeAgentGateway(agents ...services.Agent) *FakeAgentGateway {
+	f := &FakeAgentGateway{Agents: make(map[string]*services.Agent)}
+	for i := range agents {
+		f.Agents[agents[i].ID] = &agents[i]
+	}
+	return f
+}
+
+// ListAgents lists stored agents by ID, filtered by project, type and status
+func (f *FakeAgentGateway) ListAgents(ctx context.Context, filters *services.AgentFilters) (*services.AgentList, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	agents := []services.Agent{}
+	for _, agent := range f.Agents {
+		if (filters.ProjectID != "" && agent.ProjectID != filters.ProjectID) ||
+			(filters.Type != "" && agent.Type != filters.Type) ||
+			(filters.Status != "" && agent.Status != filters.Status) {
+			continue
+		}
+		agents = append(agents, *agent)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+
+	return services.NewAgentListPage(agents, filters), nil
+}
+
+// GetAgent returns a stored agent
+func (f *FakeAgentGateway) GetAgent(ctx context.Context, agentID string) (*services.Agent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.get(agentID)
+}
+
+// get returns a stored agent, failing as the agent manager does for unknown IDs
+func (f *FakeAgentGateway) get(agentID string) (*services.Agent, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	agent, ok := f.Agents[agentID]
+	if !ok {
+		return nil, fmt.Errorf("agent manager returned status 404: agent %s not found", agentID)
+	}
+	return agent, nil
+}
+
+// UpdateAgent applies the status and tags of the request to a stored agent
+func (f *FakeAgentGateway) UpdateAgent(ctx context.Context, agentID string, req *services.UpdateAgentRequest) (*services.Agent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	agent, err := f.get(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		agent.Name = req.Name
+	}
+	if req.Status != "" {
+		agent.Status = req.Status
+	}
+	if req.Tags != nil {
+		agent.Tags = req.Tags
+	}
+	return agent, nil
+}
+
+// ExecuteTaskBatch runs the tasks in order. Tasks for unknown agents fail;
+// with FailFast the tasks after the first failure are skipped.
+func (f *FakeAgentGateway) ExecuteTaskBatch(ctx context.Context, requests []services.AgentTaskRequest, opts services.BatchOptions) (*services.BatchResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("%w: no tasks", services.ErrInvalidBatch)
+	}
+	for i, request := range requests {
+		if request.AgentID == "" {
+			return nil, fmt.Errorf("%w: task %d has no agent_id", services.ErrInvalidBatch, i)
+		}
+		if request.Task.Type == "" {
+			return nil, fmt.Errorf("%w: task %d has no type", services.ErrInvalidBatch, i)
+		}
+	}
+
+	result := &services.BatchResult{Results: make([]services.AgentTaskResult, len(requests))}
+	var firstErr error
+	for i, request := range requests {
+		taskResult := &result.Results[i]
+		taskResult.AgentID = request.AgentID
+		if firstErr != nil && opts.FailFast {
+			taskResult.Skipped = true
+			result.Skipped++
+			continue
+		}
+
+		if _, ok := f.Agents[request.AgentID]; !ok {
+			taskResult.Error = fmt.Sprintf("agent %s not found", request.AgentID)
+			result.Failed++
+			if firstErr == nil && opts.FailFast {
+				firstErr = fmt.Errorf("%w: agent %s: %s", services.ErrBatchAborted, request.AgentID, taskResult.Error)
+				result.Aborted = true
+			}
+			continue
+		}
+		taskResult.Execution = &services.TaskExecution{
+			ID:      fmt.Sprintf("task-%d", i+1),
+			AgentID: request.AgentID,
+			Type:    request.Task.Type,
+			Status:  "completed",
+			Input:   request.Task.Input,
+		}
+		result.Succeeded++
+	}
+	return result, firstErr
+}
@@ -0,0 +1,249 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// ErrProjectNotFound is returned for unknown projects, as by ProjectService
+var ErrProjectNotFound = services.ErrProjectNotFound
+
+// FakeProjectManager is an in-memory project manager. When Err is set every
+// method fails with it.
+type FakeProjectManager struct {
+	mu sync.Mutex
+
+	Projects map[string]*models.Project
+	// IntentStats, EstimationAccuracy and FailureAnalyses hold the statistics
+	// reported per project; the requested window is filled in when they are
+	// returned
+	IntentStats        map[string]services.IntentStats
+	EstimationAccuracy map[string]services.EstimationAccuracy
+	FailureAnalyses    map[string]services.FailureAnalysis
+	// StorageUsage holds the storage reported per stored project
+	StorageUsage map[string]services.StorageUsage
+	// Stats holds the stats reported per project by batch reads
+	Stats map[string]models.ProjectStats
+	Err   error
+
+	seq int
+}
+
+// NewFakeProjectManager creates a project manager holding the given projects
+func NewFakeProjectManager(projects ...*models.Project) *FakeProjectManager {
+	f := &FakeProjectManager{
+		Projects:           make(map[string]*models.Project),
+		IntentStats:        make(map[string]services.IntentStats),
+		EstimationAccuracy: make(map[string]services.EstimationAccuracy),
+		FailureAnalyses:    make(map[string]services.FailureAnalysis),
+		StorageUsage:       make(map[string]services.StorageUsage),
+		Stats:              make(map[string]models.ProjectStats),
+	}
+	for _, project := range projects {
+		f.Projects[project.ID] = project
+	}
+	return f
+}
+
+// CreateProject stores an active project, rejecting duplicate names
+func (f *FakeProjectManager) CreateProject(ctx context.Context, req *services.CreateProjectRequest) (*models.Project, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	for _, project := range f.Projects {
+		if project.Name == req.Name {
+			return nil, fmt.Errorf("project with name '%s' already exists", req.Name)
+		}
+	}
+
+	f.seq++
+	now := time.Now()
+	project := &models.Project{
+		ID:          fmt.Sprintf("project-%d", f.seq),
+		Name:        req.Name,
+		Description: req.Description,
+		Type:        models.ProjectType(req.Type),
+		Status:      models.ProjectStatusActive,
+		OwnerID:     req.OwnerID,
+		Settings:    req.Settings,
+		Tags:        req.Tags,
+		CreatedBy:   req.OwnerID,
+		UpdatedBy:   req.OwnerID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	f.Projects[project.ID] = project
+	return project, nil
+}
+
+// GetProject returns a stored project
+func (f *FakeProjectManager) GetProject(ctx context.Context, projectID string) (*models.Project, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.get(projectID)
+}
+
+func (f *FakeProjectManager) get(projectID string) (*models.Project, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	project, ok := f.Projects[projectID]
+	if !ok {
+		return nil, ErrProjectNotFound
+	}
+	return project, nil
+}
+
+// ListProjects lists stored projects by ID, filtered by status, type and owner
+func (f *FakeProjectManager) ListProjects(ctx context.Context, filters *services.ProjectFilters) ([]*models.Project, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, 0, f.Err
+	}
+
+	var projects []*models.Project
+	for _, project := range f.Projects {
+		if (filters.Status != "" && string(project.Status) != filters.Status) ||
+			(filters.Type != "" && string(project.Type) != filters.Type) ||
+			(filters.OwnerID != "" && project.OwnerID != filters.OwnerID) {
+			continue
+		}
+		projects = append(projects, project)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].ID < projects[j].ID })
+
+	total := int64(len(projects))
+	return paginate(projects, filters.Offset, filters.Limit), total, nil
+}
+
+// UpdateProject applies the non-empty fields of the request to a stored project
+func (f *FakeProjectManager) UpdateProject(ctx context.Context, projectID string, req *services.UpdateProjectRequest) (*models.Project, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	project, err := f.get(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		project.Name = req.Name
+	}
+	if req.Description != "" {
+		project.Description = req.Description
+	}
+	if req.Status != "" {
+		project.Status = models.ProjectStatus(req.Status)
+	}
+	if req.Settings != nil {
+		project.Settings = req.Settings
+	}
+	if req.Tags != nil {
+		project.Tags = req.Tags
+	}
+	project.UpdatedBy = req.UpdatedBy
+	project.UpdatedAt = time.Now()
+	return project, nil
+}
+
+// DeleteProject removes a stored project
+func (f *FakeProjectManager) DeleteProject(ctx context.Context, projectID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(projectID); err != nil {
+		return err
+	}
+	delete(f.Projects, projectID)
+	return nil
+}
+
+// GetIntentStats returns the intent stats set for a project over the window
+func (f *FakeProjectManager) GetIntentStats(ctx context.Context, projectID string, since, until time.Time) (*services.IntentStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	stats := f.IntentStats[projectID]
+	stats.ProjectID, stats.Since, stats.Until = projectID, since, until
+	return &stats, nil
+}
+
+// GetEstimationAccuracy returns the estimation accuracy set for a project
+// over the window
+func (f *FakeProjectManager) GetEstimationAccuracy(ctx context.Context, projectID string, since, until time.Time) (*services.EstimationAccuracy, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	accuracy := f.EstimationAccuracy[projectID]
+	accuracy.ProjectID, accuracy.Since, accuracy.Until = projectID, since, until
+	return &accuracy, nil
+}
+
+// GetFailureAnalysis returns the failure analysis set for a project over the
+// window
+func (f *FakeProjectManager) GetFailureAnalysis(ctx context.Context, projectID string, since, until time.Time) (*services.FailureAnalysis, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	analysis := f.FailureAnalyses[projectID]
+	analysis.ProjectID, analysis.Since, analysis.Until = projectID, since, until
+	return &analysis, nil
+}
+
+// GetProjectStorage returns the storage usage set for a stored project
+func (f *FakeProjectManager) GetProjectStorage(ctx context.Context, projectID string) (*services.StorageUsage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(projectID); err != nil {
+		return nil, err
+	}
+
+	usage := f.StorageUsage[projectID]
+	usage.ProjectID = projectID
+	if usage.ByType == nil {
+		usage.ByType = []services.ArtifactTypeUsage{}
+	}
+	return &usage, nil
+}
+
+// GetProjectStatsBatch returns the stats set for the stored projects the
+// viewer owns or is a member of, in request order
+func (f *FakeProjectManager) GetProjectStatsBatch(ctx context.Context, projectIDs []string, viewer services.ProjectViewer) ([]services.ProjectStatsResult, error) {
+	ids, err := services.NormalizeProjectStatsBatch(projectIDs, services.DefaultProjectStatsBatchLimit)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	results := make([]services.ProjectStatsResult, 0, len(ids))
+	for _, projectID := range ids {
+		project, ok := f.Projects[projectID]
+		if !ok || !(viewer.All || project.OwnerID == viewer.UserID || project.GetMemberRole(viewer.UserID) != "") {
+			continue
+		}
+		stats := f.Stats[projectID]
+		stats.ProjectID = projectID
+		results = append(results, services.ProjectStatsResult{ProjectID: projectID, Stats: &stats})
+	}
+	return results, nil
+}
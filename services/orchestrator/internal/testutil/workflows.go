@@ -0,0 +1,528 @@
+// Package testutil provides in-memory implementations of the services the
+// HTTP handlers depend on, for handler tests that need neither a database
+// nor Temporal.
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"orchestrator/internal/models"
+	"orchestrator/internal/services"
+)
+
+// FakeWorkflowService is an in-memory workflow service. When Err is set
+// every method fails with it, as when Temporal or the database is down.
+type FakeWorkflowService struct {
+	mu sync.Mutex
+
+	Workflows         map[string]*models.Workflow
+	Analyses          map[string]*models.IntentAnalysis
+	PendingActivities map[string][]services.PendingActivity
+	RiskReviews       map[string]*models.IntentRiskReview
+	Checkpoints       map[string][]*models.WorkflowCheckpoint
+	Runs              map[string][]models.WorkflowExecution
+	Visibility        []services.TemporalWorkflowExecution
+	Err               error
+
+	// Started, Cancelled, RiskConfirmations and StepOverrides record the
+	// requests the service received
+	Started           []*services.StartWorkflowRequest
+	Cancelled         map[string]string // Reason by workflow ID
+	RiskConfirmations map[string]services.RiskConfirmation
+	StepOverrides     map[string][]services.StepOverride
+}
+
+// NewFakeWorkflowService creates a workflow service holding the given workflows
+func NewFakeWorkflowService(workflows ...*models.Workflow) *FakeWorkflowService {
+	f := &FakeWorkflowService{
+		Workflows:         make(map[string]*models.Workflow),
+		Analyses:          make(map[string]*models.IntentAnalysis),
+		PendingActivities: make(map[string][]services.PendingActivity),
+		RiskReviews:       make(map[string]*models.IntentRiskReview),
+		Checkpoints:       make(map[string][]*models.WorkflowCheckpoint),
+		Runs:              make(map[string][]models.WorkflowExecution),
+		Cancelled:         make(map[string]string),
+		RiskConfirmations: make(map[string]services.RiskConfirmation),
+		StepOverrides:     make(map[string][]services.StepOverride),
+	}
+	for _, workflow := range workflows {
+		f.Workflows[workflow.ID] = workflow
+	}
+	return f
+}
+
+// StartWorkflow stores a running workflow for the request
+func (f *FakeWorkflowService) StartWorkflow(ctx context.Context, req *services.StartWorkflowRequest) (*services.StartWorkflowResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	f.Started = append(f.Started, req)
+	now := time.Now()
+	workflow := &models.Workflow{
+		ID:                fmt.Sprintf("workflow-%d", len(f.Started)),
+		Name:              req.Name,
+		Type:              models.WorkflowType(req.Type),
+		Priority:          models.WorkflowPriority(req.Priority),
+		ProjectID:         req.ProjectID,
+		Status:            models.WorkflowStatusRunning,
+		EffectivePriority: models.WorkflowPriority(req.Priority),
+		Tags:              req.Tags,
+		Labels:            req.Labels,
+		Source:            req.Source,
+		MaxRetries:        req.MaxRetries,
+		CreatedBy:         req.UserID,
+		StartedAt:         &now,
+		CreatedAt:         now,
+	}
+	workflow.TemporalID = "temporal-" + workflow.ID
+	f.Workflows[workflow.ID] = workflow
+
+	return &services.StartWorkflowResponse{
+		WorkflowID: workflow.ID,
+		TemporalID: workflow.TemporalID,
+		Status:     string(workflow.Status),
+	}, nil
+}
+
+// GetWorkflow returns a stored workflow
+func (f *FakeWorkflowService) GetWorkflow(ctx context.Context, workflowID string) (*models.Workflow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.get(workflowID)
+}
+
+// get returns a stored workflow, failing as the engine does for unknown IDs
+func (f *FakeWorkflowService) get(workflowID string) (*models.Workflow, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	workflow, ok := f.Workflows[workflowID]
+	if !ok {
+		return nil, fmt.Errorf("workflow not found: %w", gorm.ErrRecordNotFound)
+	}
+	return workflow, nil
+}
+
+// ListWorkflowSummaries lists stored workflows by ID, filtered by project,
+// status and type; drafts only when asked for
+func (f *FakeWorkflowService) ListWorkflowSummaries(ctx context.Context, filters *services.WorkflowFilters) ([]*models.WorkflowSummary, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, 0, f.Err
+	}
+
+	var summaries []*models.WorkflowSummary
+	for _, workflow := range f.Workflows {
+		if (filters.ProjectID != "" && workflow.ProjectID != filters.ProjectID) ||
+			(filters.Status != "" && string(workflow.Status) != filters.Status) ||
+			(filters.Status == "" && !filters.IncludeDrafts && workflow.Status == models.WorkflowStatusDraft) ||
+			(filters.Type != "" && string(workflow.Type) != filters.Type) {
+			continue
+		}
+		summaries = append(summaries, &models.WorkflowSummary{
+			ID:                workflow.ID,
+			Name:              workflow.Name,
+			Type:              workflow.Type,
+			Status:            workflow.Status,
+			Priority:          workflow.Priority,
+			EffectivePriority: workflow.EffectivePriority,
+			ProjectID:         workflow.ProjectID,
+			Tags:              workflow.Tags,
+			Labels:            workflow.Labels,
+			Source:            workflow.Source,
+			CreatedAt:         workflow.CreatedAt,
+			StartedAt:         workflow.StartedAt,
+			CompletedAt:       workflow.CompletedAt,
+			CreatedBy:         workflow.CreatedBy,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].ID < summaries[j].ID })
+
+	total := int64(len(summaries))
+	return paginate(summaries, filters.Offset, filters.Limit), total, nil
+}
+
+// ListWorkflowsFromTemporal returns Visibility regardless of the query
+func (f *FakeWorkflowService) ListWorkflowsFromTemporal(ctx context.Context, query string) ([]services.TemporalWorkflowExecution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Visibility, nil
+}
+
+// UpdateWorkflowTags replaces, adds and removes the tags of a stored workflow
+func (f *FakeWorkflowService) UpdateWorkflowTags(ctx context.Context, workflowID string, replace *[]string, add, remove []string) (*models.Workflow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	workflow, err := f.get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string(workflow.Tags)
+	if replace != nil {
+		tags = *replace
+	}
+	removed := make(map[string]bool, len(remove))
+	for _, tag := range remove {
+		removed[tag] = true
+	}
+	var updated models.StringArray
+	seen := make(map[string]bool)
+	for _, tag := range append(append([]string{}, tags...), add...) {
+		if !removed[tag] && !seen[tag] {
+			seen[tag] = true
+			updated = append(updated, tag)
+		}
+	}
+	workflow.Tags = updated
+	return workflow, nil
+}
+
+// CancelWorkflow marks a stored workflow cancelled, recording the reason
+// and who cancelled it
+func (f *FakeWorkflowService) CancelWorkflow(ctx context.Context, workflowID string, reason string, userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	workflow, err := f.get(workflowID)
+	if err != nil {
+		return err
+	}
+	if workflow.IsTerminal() {
+		return fmt.Errorf("workflow is already in terminal state: %s", workflow.Status)
+	}
+
+	workflow.Status = models.WorkflowStatusCancelled
+	workflow.CancelledReason = reason
+	workflow.CancelledBy = userID
+	f.Cancelled[workflowID] = reason
+	return nil
+}
+
+// GetWorkflowMetrics reports the status and timing of a stored workflow
+func (f *FakeWorkflowService) GetWorkflowMetrics(ctx context.Context, workflowID string) (*services.WorkflowMetrics, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	workflow, err := f.get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	return &services.WorkflowMetrics{
+		WorkflowID:  workflow.ID,
+		Status:      string(workflow.Status),
+		StartedAt:   workflow.StartedAt,
+		CompletedAt: workflow.CompletedAt,
+		Duration:    workflow.Duration,
+		RetryCount:  workflow.RetryCount,
+	}, nil
+}
+
+// GetTimingOverview reports an empty timing breakdown over the window
+func (f *FakeWorkflowService) GetTimingOverview(ctx context.Context, projectID string, since, until time.Time) (*services.TimingOverview, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return &services.TimingOverview{ProjectID: projectID, Since: since, Until: until}, nil
+}
+
+// GetPendingActivities returns the pending activities of a stored workflow
+func (f *FakeWorkflowService) GetPendingActivities(ctx context.Context, workflowID string) ([]services.PendingActivity, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(workflowID); err != nil {
+		return nil, err
+	}
+	return f.PendingActivities[workflowID], nil
+}
+
+// GetWorkflowState reports the state of a stored workflow as read from the
+// database
+func (f *FakeWorkflowService) GetWorkflowState(ctx context.Context, workflowID string) (*services.WorkflowState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	workflow, err := f.get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	return &services.WorkflowState{
+		WorkflowID:     workflow.ID,
+		Status:         workflow.Status,
+		Phase:          string(workflow.Status),
+		CompletedSteps: []string{},
+		PendingSteps:   []string{},
+		Artifacts:      []services.WorkflowArtifactRef{},
+		LastError:      workflow.Error,
+		Source:         services.WorkflowStateSourceDatabase,
+	}, nil
+}
+
+// ListWorkflowRuns returns the runs of a stored workflow
+func (f *FakeWorkflowService) ListWorkflowRuns(ctx context.Context, workflowID string) ([]models.WorkflowExecution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(workflowID); err != nil {
+		return nil, err
+	}
+	runs := f.Runs[workflowID]
+	if runs == nil {
+		runs = []models.WorkflowExecution{}
+	}
+	return runs, nil
+}
+
+// CountWorkflowRuns returns the number of runs of a workflow
+func (f *FakeWorkflowService) CountWorkflowRuns(ctx context.Context, workflowID string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return 0, f.Err
+	}
+	return int64(len(f.Runs[workflowID])), nil
+}
+
+// GetIntentAnalysis returns the analysis of a workflow, or nil when it has none
+func (f *FakeWorkflowService) GetIntentAnalysis(ctx context.Context, workflowID string) (*models.IntentAnalysis, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Analyses[workflowID], nil
+}
+
+// GetIntentRiskReview returns the risk review of a workflow, or nil when it
+// has none
+func (f *FakeWorkflowService) GetIntentRiskReview(ctx context.Context, workflowID string) (*models.IntentRiskReview, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.RiskReviews[workflowID], nil
+}
+
+// ConfirmIntentRisks records the confirmation of a workflow's pending risks
+func (f *FakeWorkflowService) ConfirmIntentRisks(ctx context.Context, workflowID string, confirmation services.RiskConfirmation) (*models.IntentRiskReview, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.get(workflowID); err != nil {
+		return nil, err
+	}
+	review := f.RiskReviews[workflowID]
+	if review == nil || review.Status != models.RiskReviewPending {
+		return nil, services.ErrNoPendingRiskReview
+	}
+	f.RiskConfirmations[workflowID] = confirmation
+	return review, nil
+}
+
+// OverrideStep records the override of a step of a running workflow
+func (f *FakeWorkflowService) OverrideStep(ctx context.Context, workflowID string, override services.StepOverride) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	workflow, err := f.get(workflowID)
+	if err != nil {
+		return err
+	}
+	if workflow.IsTerminal() {
+		return services.ErrWorkflowNotOverridable
+	}
+	f.StepOverrides[workflowID] = append(f.StepOverrides[workflowID], override)
+	return nil
+}
+
+// RerunWorkflow stores a running copy of a finished workflow, checking the
+// checkpoint it resumes from as the engine does
+func (f *FakeWorkflowService) RerunWorkflow(ctx context.Context, workflowID, from, userID string) (*services.StartWorkflowResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	source, err := f.get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if !source.IsTerminal() {
+		return nil, services.ErrWorkflowNotRerunnable
+	}
+	switch from {
+	case "", services.RerunFromStart:
+	case string(models.CheckpointPhaseAnalysis), string(models.CheckpointPhasePlan):
+		var found *models.WorkflowCheckpoint
+		for _, checkpoint := range f.Checkpoints[workflowID] {
+			if checkpoint.Phase == models.CheckpointPhase(from) {
+				found = checkpoint
+			}
+		}
+		if found == nil {
+			return nil, services.ErrCheckpointNotFound
+		}
+		if err := services.ValidateCheckpoint(found); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, services.ErrInvalidRerunPhase
+	}
+
+	now := time.Now()
+	rerun := *source
+	rerun.ID = fmt.Sprintf("workflow-%d", len(f.Workflows)+1)
+	rerun.TemporalID = "temporal-" + rerun.ID
+	rerun.Status = models.WorkflowStatusRunning
+	rerun.ParentWorkflowID = &source.ID
+	rerun.CreatedBy = userID
+	rerun.StartedAt = &now
+	rerun.CompletedAt = nil
+	rerun.CreatedAt = now
+	f.Workflows[rerun.ID] = &rerun
+	return &services.StartWorkflowResponse{
+		WorkflowID: rerun.ID,
+		TemporalID: rerun.TemporalID,
+		Status:     string(rerun.Status),
+	}, nil
+}
+
+// ResetStuck clears the stuck flag of a stored workflow
+func (f *FakeWorkflowService) ResetStuck(ctx context.Context, workflowID, actor string) (*models.Workflow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	workflow, err := f.get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if !workflow.Stuck {
+		return nil, services.ErrWorkflowNotStuck
+	}
+	workflow.Stuck, workflow.StuckError, workflow.StuckAt = false, "", nil
+	copied := *workflow
+	return &copied, nil
+}
+
+// CreateWorkflowDraft stores a draft for the request
+func (f *FakeWorkflowService) CreateWorkflowDraft(ctx context.Context, req *services.StartWorkflowRequest) (*models.Workflow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	draft := draftFromRequest(req)
+	draft.ID = fmt.Sprintf("draft-%d", len(f.Workflows)+1)
+	draft.CreatedBy = req.UserID
+	draft.CreatedAt = time.Now()
+	f.Workflows[draft.ID] = draft
+	return draft, nil
+}
+
+// UpdateWorkflowDraft replaces the settings of a stored draft
+func (f *FakeWorkflowService) UpdateWorkflowDraft(ctx context.Context, workflowID string, req *services.StartWorkflowRequest) (*models.Workflow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	existing, err := f.draft(workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	draft := draftFromRequest(req)
+	draft.ID = existing.ID
+	draft.CreatedBy = existing.CreatedBy
+	draft.CreatedAt = existing.CreatedAt
+	f.Workflows[draft.ID] = draft
+	return draft, nil
+}
+
+// SubmitWorkflowDraft marks a stored draft running
+func (f *FakeWorkflowService) SubmitWorkflowDraft(ctx context.Context, workflowID, userID string) (*services.StartWorkflowResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	draft, err := f.draft(workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	draft.Status = models.WorkflowStatusRunning
+	draft.SubmittedAt = &now
+	draft.StartedAt = &now
+	draft.TemporalID = "temporal-" + draft.ID
+	return &services.StartWorkflowResponse{
+		WorkflowID: draft.ID,
+		TemporalID: draft.TemporalID,
+		Status:     string(draft.Status),
+	}, nil
+}
+
+// DeleteWorkflowDraft removes a stored draft
+func (f *FakeWorkflowService) DeleteWorkflowDraft(ctx context.Context, workflowID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.draft(workflowID); err != nil {
+		return err
+	}
+	delete(f.Workflows, workflowID)
+	return nil
+}
+
+// ValidateWorkflow validates the plan steps with the default limits
+func (f *FakeWorkflowService) ValidateWorkflow(ctx context.Context, req *services.StartWorkflowRequest, steps []services.PlanStep) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Err != nil {
+		return f.Err
+	}
+	return services.NewPlanValidator(nil).Validate(steps)
+}
+
+// draft returns a stored draft, failing as the engine does for other workflows
+func (f *FakeWorkflowService) draft(workflowID string) (*models.Workflow, error) {
+	workflow, err := f.get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.Status != models.WorkflowStatusDraft {
+		return nil, fmt.Errorf("%w: it is %s", services.ErrNotDraft, workflow.Status)
+	}
+	return workflow, nil
+}
+
+func draftFromRequest(req *services.StartWorkflowRequest) *models.Workflow {
+	return &models.Workflow{
+		Name:           req.Name,
+		Description:    req.Description,
+		Type:           models.WorkflowType(req.Type),
+		Priority:       models.WorkflowPriority(req.Priority),
+		ProjectID:      req.ProjectID,
+		Status:         models.WorkflowStatusDraft,
+		Input:          req.Input,
+		Config:         req.Config,
+		Tags:           req.Tags,
+		Labels:         req.Labels,
+		MaxRetries:     req.MaxRetries,
+		TimeoutSeconds: req.TimeoutSeconds,
+		UpdatedBy:      req.UserID,
+	}
+}
+
+// paginate applies an offset and a limit to a list; a zero limit keeps the rest
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,35 @@
+// Package agentconn is the handshake of the WebSocket connections the
+// orchestrator opens to agents through the Agent Manager, kept apart from the
+// agent client so that tests can dial the endpoint exactly as the
+// orchestrator does.
+package agentconn
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProjectHeader names the project a connection is opened for
+const ProjectHeader = "X-Project-ID"
+
+// URL returns the connection endpoint of an agent under baseURL, the
+// WebSocket URL of the Agent Manager
+func URL(baseURL, agentID string) string {
+	return fmt.Sprintf("%s/api/v1/agents/%s/connect", strings.TrimSuffix(baseURL, "/"), agentID)
+}
+
+// Dial opens a connection to an agent for a project. header carries the
+// caller's service credentials; a failed handshake returns the response of
+// the Agent Manager along with the error.
+func Dial(ctx context.Context, dialer *websocket.Dialer, baseURL, agentID, projectID string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(ProjectHeader, projectID)
+	return dialer.DialContext(ctx, URL(baseURL, agentID), header)
+}